@@ -22,6 +22,10 @@ import (
 	"github.com/janelia-flyem/dvid/storage/local"
 
 	// Declare the data types this DVID executable will support
+	_ "github.com/janelia-flyem/dvid/datatype/acqlog"
+	_ "github.com/janelia-flyem/dvid/datatype/annotation"
+	_ "github.com/janelia-flyem/dvid/datatype/counters"
+	_ "github.com/janelia-flyem/dvid/datatype/imagetile"
 	_ "github.com/janelia-flyem/dvid/datatype/keyvalue"
 	_ "github.com/janelia-flyem/dvid/datatype/labelgraph"
 	_ "github.com/janelia-flyem/dvid/datatype/labelmap"
@@ -256,7 +260,12 @@ func DoServe(cmd dvid.Command) error {
 	if err != nil {
 		return fmt.Errorf("Error loading configuration file %q: %s\n", *configfile, err.Error())
 	}
-	logConfig.SetLogger()
+	if err := logConfig.SetLogger(); err != nil {
+		return fmt.Errorf("Error in logging configuration: %s\n", err.Error())
+	}
+	if err := server.LoadPlugins(); err != nil {
+		return fmt.Errorf("Error loading datatype plugins: %s\n", err.Error())
+	}
 
 	// Load datastore metadata and initialize datastore
 	dbpath := cmd.Argument(1)
@@ -0,0 +1,33 @@
+/*
+	This file defines how data instances accept batches of operations sent through RPC
+	batch sessions, which let clients ingest many key-value pairs or blocks over one
+	connection, and how many voxel blocks are read at once, e.g., by gRPC clients.
+*/
+
+package datastore
+
+import "github.com/janelia-flyem/dvid/dvid"
+
+// BatchOp is one operation of a batch: a put of a value or a delete of a key.
+type BatchOp struct {
+	// Key is a datatype-specific key, e.g., a keyvalue key or a block coordinate like
+	// "10_20_30" for voxels.
+	Key string
+
+	Value  []byte
+	Delete bool
+}
+
+// BatchIngester is implemented by data instances that accept batches of operations.
+type BatchIngester interface {
+	// IngestBatch applies the operations in order at the context's version, committing
+	// them in storage batches.
+	IngestBatch(ctx *VersionedContext, ops []BatchOp) error
+}
+
+// BlockReader is implemented by data instances holding voxel blocks.
+type BlockReader interface {
+	// ReadBlocks returns the uncompressed blocks at the given block coordinates at the
+	// context's version, with a nil block for each block that isn't stored.
+	ReadBlocks(ctx *VersionedContext, coords []dvid.ChunkPoint3d) ([][]byte, error)
+}
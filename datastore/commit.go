@@ -0,0 +1,363 @@
+/*
+	This file supports atomic commits that group mutations of several data instances, e.g.,
+	an ROI update with a change of keyvalue state, so tools can maintain invariants across
+	instances.  All writes of a commit are applied in one batch, and each commit is recorded
+	with the prior values of the keys it wrote so it can be undone as a unit.
+
+	Commit records, including the full values of written keys before and after each
+	commit, are kept in the metadata store indefinitely since any commit may be undone
+	later.  To bound their size, commits writing more than MaxCommitBytes of values are
+	rejected, so large ingestions should use batch writes instead.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// The first byte of metadata indices for commit records.  The index with just this byte
+// holds the last commit ID.
+const commitKey byte = 0xA6
+
+// MaxCommitBytes is the maximum bytes of values before and after a commit that are
+// recorded for undo.  Larger commits are rejected.
+var MaxCommitBytes = 64 * dvid.Mega
+
+// Mutation is one change to a data instance within an atomic commit.  The action and its
+// arguments are specific to the datatype of the instance.
+type Mutation struct {
+	Instance dvid.DataString
+	Action   string
+	Args     json.RawMessage
+}
+
+// PreparedMutation gives the writes of a mutation that hasn't been applied.
+type PreparedMutation struct {
+	// Writes are full keys (see storage.Context) with their new values.  A nil value
+	// deletes the key.  Later writes to the same key take precedence.
+	Writes []storage.KeyValue
+
+	// Applied, if not nil, is called after the writes of the commit are stored, e.g., to
+	// update properties of the data instance.
+	Applied func() error
+}
+
+// Mutator is implemented by data instances whose mutations can be part of an atomic
+// commit.  Data instances must use the big data store or a small data store using the
+// same engine.
+type Mutator interface {
+	// PrepareMutation returns the writes of a mutation at the given version without
+	// applying them.
+	PrepareMutation(versionID dvid.VersionID, action string, args json.RawMessage) (*PreparedMutation, error)
+}
+
+// CommitRecord describes an atomic commit.
+type CommitRecord struct {
+	ID        uint64
+	UUID      dvid.UUID
+	Note      string
+	Created   time.Time
+	Mutations []MutationSummary
+	Keys      int
+	Undone    bool
+}
+
+// MutationSummary describes a mutation of a commit without its arguments.
+type MutationSummary struct {
+	Instance dvid.DataString
+	Action   string
+}
+
+// commitEntry is the stored record of a commit with the values of the keys it wrote
+// before and after the commit.
+type commitEntry struct {
+	Record CommitRecord
+	Before []keyState
+	After  []keyState
+}
+
+// keyState is the value of a key, which is recorded separately from its presence since
+// gob doesn't distinguish nil and empty values.
+type keyState struct {
+	K      []byte
+	V      []byte
+	Exists bool
+}
+
+// Commits and undos are serialized so they see each other's writes.
+var commitMu sync.Mutex
+
+func commitIndex(id uint64) []byte {
+	index := make([]byte, 9)
+	index[0] = commitKey
+	binary.BigEndian.PutUint64(index[1:], id)
+	return index
+}
+
+func getCommitEntry(id uint64) (*commitEntry, error) {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return nil, err
+	}
+	value, err := store.Get(storage.NewMetadataContext(), commitIndex(id))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("No commit with ID %d", id)
+	}
+	entry := new(commitEntry)
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(entry); err != nil {
+		return nil, fmt.Errorf("Could not decode commit %d: %s", id, err.Error())
+	}
+	return entry, nil
+}
+
+func putCommitEntry(entry *commitEntry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	return store.Put(storage.NewMetadataContext(), commitIndex(entry.Record.ID), buf.Bytes())
+}
+
+// nextCommitID reserves an ID for a new commit.
+func nextCommitID() (uint64, error) {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return 0, err
+	}
+	ctx := storage.NewMetadataContext()
+	value, err := store.Get(ctx, []byte{commitKey})
+	if err != nil {
+		return 0, err
+	}
+	var id uint64
+	if len(value) == 8 {
+		id = binary.BigEndian.Uint64(value)
+	}
+	id++
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, id)
+	return id, store.Put(ctx, []byte{commitKey}, buf)
+}
+
+// commitBatcher returns the store and batcher used to write commits.
+func commitBatcher() (storage.OrderedKeyValueDB, storage.KeyValueBatcher, error) {
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return nil, nil, fmt.Errorf("Atomic commits require a store that supports batch writes")
+	}
+	return db, batcher, nil
+}
+
+// Commit applies the mutations of data instances in the given unlocked version as one
+// batch and records the commit so it can be undone.
+func Commit(uuid dvid.UUID, note string, mutations []Mutation) (*CommitRecord, error) {
+	if len(mutations) == 0 {
+		return nil, fmt.Errorf("Commit requires at least one mutation")
+	}
+	repo, err := RepoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := repo.Locked(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, ErrModifyLockedNode
+	}
+	if err := CheckStorageQuota(repo); err != nil {
+		return nil, err
+	}
+	versionID, err := VersionFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	db, batcher, err := commitBatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	commitMu.Lock()
+	defer commitMu.Unlock()
+
+	// Prepare all mutations before writing anything.
+	record := CommitRecord{UUID: uuid, Note: note, Created: time.Now()}
+	var prepared []*PreparedMutation
+	for i, mutation := range mutations {
+		data, err := repo.GetDataByName(mutation.Instance)
+		if err != nil {
+			return nil, err
+		}
+		mutator, ok := data.(Mutator)
+		if !ok {
+			return nil, fmt.Errorf("Data %q of type %q does not support atomic commits",
+				data.DataName(), data.TypeName())
+		}
+		p, err := mutator.PrepareMutation(versionID, mutation.Action, mutation.Args)
+		if err != nil {
+			return nil, fmt.Errorf("Mutation %d (%s %q): %s", i, mutation.Action, mutation.Instance, err.Error())
+		}
+		prepared = append(prepared, p)
+		record.Mutations = append(record.Mutations, MutationSummary{mutation.Instance, mutation.Action})
+	}
+
+	// Get the final value of each written key and its value before the commit.
+	var keys []string
+	final := make(map[string][]byte)
+	for _, p := range prepared {
+		for _, kv := range p.Writes {
+			k := string(kv.K)
+			if _, found := final[k]; !found {
+				keys = append(keys, k)
+			}
+			final[k] = kv.V
+		}
+	}
+	entry := &commitEntry{
+		Before: make([]keyState, len(keys)),
+		After:  make([]keyState, len(keys)),
+	}
+	var numBytes int
+	for i, k := range keys {
+		before, err := db.Get(nil, []byte(k))
+		if err != nil {
+			return nil, err
+		}
+		entry.Before[i] = keyState{[]byte(k), before, before != nil}
+		entry.After[i] = keyState{[]byte(k), final[k], final[k] != nil}
+		numBytes += len(before) + len(final[k])
+	}
+	if numBytes > MaxCommitBytes {
+		return nil, fmt.Errorf("Commit would record %d bytes of values, more than the maximum %d bytes",
+			numBytes, MaxCommitBytes)
+	}
+
+	// Apply the commit and then record it.  If it can't be recorded, the prior values are
+	// restored so there are no applied commits that can't be undone, and failed commits
+	// are never recorded as applied.
+	if record.ID, err = nextCommitID(); err != nil {
+		return nil, err
+	}
+	record.Keys = len(keys)
+	entry.Record = record
+	if err := writeKeyStates(batcher, entry.After); err != nil {
+		return nil, err
+	}
+	if err := putCommitEntry(entry); err != nil {
+		if restoreErr := writeKeyStates(batcher, entry.Before); restoreErr != nil {
+			dvid.Criticalf("Unable to restore data after failing to record commit %d: %s\n",
+				record.ID, restoreErr.Error())
+		}
+		return nil, err
+	}
+	for _, p := range prepared {
+		if p.Applied != nil {
+			if err := p.Applied(); err != nil {
+				dvid.Errorf("Error after applying commit %d: %s\n", record.ID, err.Error())
+			}
+		}
+	}
+	dvid.Infof("Applied commit %d with %d mutations writing %d keys to node %s\n",
+		record.ID, len(mutations), len(keys), uuid)
+	return &record, nil
+}
+
+// writeKeyStates stores the given values of keys in one batch.
+func writeKeyStates(batcher storage.KeyValueBatcher, states []keyState) error {
+	batch := batcher.NewBatch(nil)
+	for _, state := range states {
+		if !state.Exists {
+			batch.Delete(state.K)
+		} else {
+			batch.Put(state.K, append([]byte{}, state.V...))
+		}
+	}
+	return batch.Commit()
+}
+
+// GetCommit returns the record of a commit.
+func GetCommit(id uint64) (*CommitRecord, error) {
+	entry, err := getCommitEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	return &entry.Record, nil
+}
+
+// UndoCommit restores the values of all keys written by a commit.  The undo is refused if
+// any of those keys has been changed since the commit.
+func UndoCommit(id uint64) error {
+	db, batcher, err := commitBatcher()
+	if err != nil {
+		return err
+	}
+
+	commitMu.Lock()
+	defer commitMu.Unlock()
+
+	entry, err := getCommitEntry(id)
+	if err != nil {
+		return err
+	}
+	if entry.Record.Undone {
+		return fmt.Errorf("Commit %d has already been undone", id)
+	}
+	repo, err := RepoFromUUID(entry.Record.UUID)
+	if err != nil {
+		return err
+	}
+	locked, err := repo.Locked(entry.Record.UUID)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return ErrModifyLockedNode
+	}
+
+	for _, after := range entry.After {
+		current, err := db.Get(nil, after.K)
+		if err != nil {
+			return err
+		}
+		if (current != nil) != after.Exists || !bytes.Equal(current, after.V) {
+			return fmt.Errorf("Cannot undo commit %d: data has changed since the commit", id)
+		}
+	}
+
+	// Restore the data and then mark the commit undone, reapplying the commit if it
+	// can't be marked so the record always matches the data.
+	if err := writeKeyStates(batcher, entry.Before); err != nil {
+		return err
+	}
+	entry.Record.Undone = true
+	if err := putCommitEntry(entry); err != nil {
+		if redoErr := writeKeyStates(batcher, entry.After); redoErr != nil {
+			dvid.Criticalf("Unable to reapply data after failing to mark commit %d undone: %s\n",
+				id, redoErr.Error())
+		}
+		return err
+	}
+	dvid.Infof("Undid commit %d to node %s\n", id, entry.Record.UUID)
+	return nil
+}
@@ -11,8 +11,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
 
 	"code.google.com/p/go.net/context"
 
@@ -32,6 +32,62 @@ func init() {
 type Request struct {
 	dvid.Command
 	Input []byte
+
+	// job is the background job running this request, if any.  It isn't sent via RPC.
+	job JobReporter
+}
+
+// JobReporter receives the progress of a command run as a background job and tells
+// the command whether the job has been canceled.
+type JobReporter interface {
+	SetProgress(percent float64)
+	Logf(format string, args ...interface{})
+	Canceled() bool
+	Done() <-chan struct{}
+}
+
+// SetJob makes the request report to a background job.
+func (r *Request) SetJob(job JobReporter) {
+	r.job = job
+}
+
+// InJob returns true if the request is run as a background job.
+func (r Request) InJob() bool {
+	return r.job != nil
+}
+
+// Progress records the percent of a request's work that is done if the request is run
+// as a background job.
+func (r Request) Progress(percent float64) {
+	if r.job != nil {
+		r.job.SetProgress(percent)
+	}
+}
+
+// JobLogf adds a message to the log of the background job running the request, or to
+// the server log if the request isn't run as a job.
+func (r Request) JobLogf(format string, args ...interface{}) {
+	if r.job != nil {
+		r.job.Logf(format, args...)
+	} else {
+		dvid.Infof(format, args...)
+	}
+}
+
+// Canceled returns true if the request is run as a background job that was canceled.
+// Long-running commands should check it periodically and stop early.
+func (r Request) Canceled() bool {
+	return r.job != nil && r.job.Canceled()
+}
+
+// Done returns a channel that is closed if the request is run as a background job that
+// was canceled, or nil if the request can't be canceled.  It can be given to a storage
+// context via SetDone() so range scans for the request stop when it is canceled.
+func (r Request) Done() <-chan struct{} {
+	if r.job == nil {
+		return nil
+	}
+	return r.job.Done()
 }
 
 // Response supports RPC responses from DVID.
@@ -78,6 +134,37 @@ func (ctx *VersionedContext) GetIterator() (storage.VersionIterator, error) {
 	return repo.GetIterator(ctx.VersionID())
 }
 
+// ancestries caches the ancestor path of each version.  A node's parents are fixed
+// when it is created, so cached paths never go stale.
+var (
+	ancestries   = make(map[dvid.VersionID][]dvid.VersionID)
+	ancestriesMu sync.RWMutex
+)
+
+// Ancestry returns the ancestor path of the context's version, starting with the version
+// itself, as traversed by its version iterator.
+func (ctx *VersionedContext) Ancestry() ([]dvid.VersionID, error) {
+	versionID := ctx.VersionID()
+	ancestriesMu.RLock()
+	ancestry, found := ancestries[versionID]
+	ancestriesMu.RUnlock()
+	if found {
+		return ancestry, nil
+	}
+
+	it, err := ctx.GetIterator()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get versioned data iterator: %s\n", err.Error())
+	}
+	for ; it.Valid(); it.Next() {
+		ancestry = append(ancestry, it.VersionID())
+	}
+	ancestriesMu.Lock()
+	ancestries[versionID] = ancestry
+	ancestriesMu.Unlock()
+	return ancestry, nil
+}
+
 // VersionedKeyValue returns the key-value pair corresponding to this key's version
 // given a list of key-value pairs across many versions.  If no suitable key-value
 // pair is found, nil is returned.
@@ -91,21 +178,16 @@ func (ctx *VersionedContext) VersionedKeyValue(values []*storage.KeyValue) (*sto
 		versionMap[vid] = kv
 	}
 
-	// Iterate from the current node up the ancestors in the version DAG, checking if
-	// current best is present.
-	it, err := ctx.GetIterator()
+	// Walk from the current node up the cached ancestor path in the version DAG,
+	// returning the closest version present.
+	ancestry, err := ctx.Ancestry()
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't get versioned data iterator: %s\n", err.Error())
+		return nil, err
 	}
-	for {
-		if it.Valid() {
-			if kv, found := versionMap[it.VersionID()]; found {
-				return kv, nil
-			}
-		} else {
-			break
+	for _, versionID := range ancestry {
+		if kv, found := versionMap[versionID]; found {
+			return kv, nil
 		}
-		it.Next()
 	}
 	return nil, nil
 }
@@ -194,6 +276,16 @@ type Data struct {
 
 	// If true (default), we allow changes along nodes.
 	versioned bool
+
+	// If true, values are encrypted at rest when storage encryption is enabled.
+	encrypted bool
+
+	// Durability of writes that don't request one, or the server default if
+	// storage.DurabilityDefault.
+	durability storage.Durability
+
+	// Names of data instances whose mutations are delivered to this data.
+	syncs []dvid.DataString
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
@@ -207,6 +299,9 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 		Checksum    string
 		Persistence string
 		Versioned   bool
+		Encrypted   bool
+		Durability  string
+		Syncs       []dvid.DataString
 	}{
 		TypeName:    d.typename,
 		TypeURL:     d.typeurl,
@@ -217,9 +312,18 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 		Checksum:    d.checksum.String(),
 		Persistence: d.persistence.String(),
 		Versioned:   d.versioned,
+		Encrypted:   d.encrypted,
+		Durability:  d.durability.String(),
+		Syncs:       d.syncs,
 	})
 }
 
+// LossyCompressor is implemented by datatypes whose data can be stored with lossy
+// compression, e.g., grayscale voxels using JPEG.
+type LossyCompressor interface {
+	SupportsLossyCompression() bool
+}
+
 // NewDataService returns a new Data instance that fulfills the DataService interface.
 // The UUID passed in corresponds to the root UUID of the repo that should hold the data.
 // This returned Data struct is usually embedded by datatype-specific data instances.
@@ -239,8 +343,15 @@ func NewDataService(t TypeService, uuid dvid.UUID, id dvid.InstanceID, name dvid
 		persistence: DataDefault,
 		versioned:   true,
 	}
-	err := data.ModifyConfig(c)
-	return data, err
+	if err := data.ModifyConfig(c); err != nil {
+		return nil, err
+	}
+	if data.compression.Format().Lossy() {
+		if lc, ok := t.(LossyCompressor); !ok || !lc.SupportsLossyCompression() {
+			return nil, fmt.Errorf("Data %q of type %q cannot use %s", name, dtype.Name, data.compression.Format())
+		}
+	}
+	return data, nil
 }
 
 // ---- dvid.Data implementation ----
@@ -294,6 +405,18 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.versioned)); err != nil {
 		return err
 	}
+	// Data stored before encryption at rest was added won't have the flag.
+	if err := dec.Decode(&(d.encrypted)); err != nil && err != io.EOF {
+		return err
+	}
+	// Likewise for data stored before write durability was added.
+	if err := dec.Decode(&(d.durability)); err != nil && err != io.EOF {
+		return err
+	}
+	// Likewise for data stored before syncs were added.
+	if err := dec.Decode(&(d.syncs)); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -330,11 +453,60 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.versioned); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.encrypted); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.durability); err != nil {
+		return nil, err
+	}
+	syncs := make([]dvid.DataString, len(d.syncs))
+	copy(syncs, d.syncs)
+	if err := enc.Encode(syncs); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
 // -----------
 
+// Encrypted returns true if values of this data are encrypted at rest.
+func (d *Data) Encrypted() bool {
+	return d.encrypted
+}
+
+// registerEncryption tells storage to encrypt values of data marked as encrypted.
+func registerEncryption(data dvid.Data) {
+	if e, ok := data.(interface {
+		Encrypted() bool
+	}); ok && e.Encrypted() {
+		storage.SetInstanceEncryption(data.InstanceID(), true)
+	}
+}
+
+// Durability returns the durability of writes to this data that don't request one.
+func (d *Data) Durability() storage.Durability {
+	return d.durability
+}
+
+// registerDurability tells storage the durability of writes to data that don't request one.
+func registerDurability(data dvid.Data) {
+	if w, ok := data.(interface {
+		Durability() storage.Durability
+	}); ok && w.Durability() != storage.DurabilityDefault {
+		storage.SetInstanceDurability(data.InstanceID(), w.Durability())
+	}
+}
+
+// registerChecksum tells storage which data have checksummed values that can be
+// verified and repaired.
+func registerChecksum(data dvid.Data) {
+	if c, ok := data.(interface {
+		Checksum() dvid.Checksum
+	}); ok && c.Checksum() != dvid.NoChecksum {
+		storage.SetInstanceChecksum(data.InstanceID(), true)
+	}
+}
+
 func (d *Data) Compression() dvid.Compression {
 	return d.compression
 }
@@ -370,29 +542,12 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 		return err
 	}
 	if found {
-		format := strings.ToLower(s)
-		switch format {
-		case "none":
-			d.compression, _ = dvid.NewCompression(dvid.Uncompressed, dvid.DefaultCompression)
-		case "snappy":
-			d.compression, _ = dvid.NewCompression(dvid.Snappy, dvid.DefaultCompression)
-		case "lz4":
-			d.compression, _ = dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
-		case "gzip":
-			d.compression, _ = dvid.NewCompression(dvid.Gzip, dvid.DefaultCompression)
-		default:
-			// Check for gzip + compression level
-			parts := strings.Split(format, ":")
-			if len(parts) == 2 && parts[0] == "gzip" {
-				level, err := strconv.Atoi(parts[1])
-				if err != nil {
-					return fmt.Errorf("Unable to parse gzip compression level ('%d').  Should be 'gzip:<level>'.", parts[1])
-				}
-				d.compression, _ = dvid.NewCompression(dvid.Gzip, dvid.CompressionLevel(level))
-			} else {
-				return fmt.Errorf("Illegal compression specified: %s", s)
-			}
+		// Levels can also be given as "gzip:<level>" or "jpeg:<quality>".
+		compression, err := dvid.ParseCompression(strings.Replace(s, ":", "-", 1))
+		if err != nil {
+			return fmt.Errorf("Illegal compression specified: %s", err.Error())
 		}
+		d.compression = compression
 	}
 
 	// Set checksum for this instance
@@ -401,15 +556,45 @@ func (d *Data) ModifyConfig(config dvid.Config) error {
 		return err
 	}
 	if found {
-		checksum := strings.ToLower(s)
-		switch checksum {
-		case "none":
-			d.checksum = dvid.NoChecksum
-		case "crc32":
-			d.checksum = dvid.CRC32
-		default:
-			return fmt.Errorf("Illegal checksum specified: %s", s)
+		checksum, err := dvid.ParseChecksum(s)
+		if err != nil {
+			return fmt.Errorf("Illegal checksum specified: %s", err.Error())
+		}
+		d.checksum = checksum
+		storage.SetInstanceChecksum(d.id, checksum != dvid.NoChecksum)
+	}
+
+	// Set encryption at rest for this instance
+	encrypted, found, err := config.GetBool("Encrypted")
+	if err != nil {
+		return err
+	}
+	if found {
+		d.encrypted = encrypted
+		storage.SetInstanceEncryption(d.id, encrypted)
+	}
+
+	// Set write durability for this instance
+	s, found, err = config.GetString("Durability")
+	if err != nil {
+		return err
+	}
+	if found {
+		durability, err := storage.ParseDurability(s)
+		if err != nil {
+			return err
 		}
+		d.durability = durability
+		storage.SetInstanceDurability(d.id, durability)
+	}
+
+	// Set the data instances synced with this instance
+	s, found, err = config.GetString("Sync")
+	if err != nil {
+		return err
+	}
+	if found {
+		d.syncs = parseSyncs(s)
 	}
 	return nil
 }
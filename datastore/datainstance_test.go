@@ -20,6 +20,7 @@ func TestDataGobEncoding(t *testing.T) {
 		checksum:    dvid.DefaultChecksum,
 		persistence: DataCritical,
 		versioned:   true,
+		syncs:       []dvid.DataString{"labels", "bodies"},
 	}
 
 	encoding, err := data.GobEncode()
@@ -34,3 +35,14 @@ func TestDataGobEncoding(t *testing.T) {
 		t.Errorf("Bad Gob roundtrip:\nOriginal: %v\nReturned: %v\n", data, data2)
 	}
 }
+
+func TestParseSyncs(t *testing.T) {
+	syncs := parseSyncs(" labels, bodies,,")
+	expected := []dvid.DataString{"labels", "bodies"}
+	if !reflect.DeepEqual(syncs, expected) {
+		t.Errorf("Expected syncs %v, got %v\n", expected, syncs)
+	}
+	if syncs := parseSyncs(""); len(syncs) != 0 {
+		t.Errorf("Expected no syncs, got %v\n", syncs)
+	}
+}
@@ -6,6 +6,7 @@ package datastore
 
 import (
 	"fmt"
+	"strings"
 
 	"code.google.com/p/go.net/context"
 
@@ -15,6 +16,9 @@ import (
 
 const (
 	Version = "0.9"
+
+	// DefaultBranch is the name of the branch starting at a repo's root node.
+	DefaultBranch = "master"
 )
 
 var (
@@ -60,7 +64,69 @@ func VersionFromUUID(uuid dvid.UUID) (dvid.VersionID, error) {
 	return Manager.VersionFromUUID(uuid)
 }
 
-// MatchingUUID returns version identifiers that uniquely matches a uuid string.
+// ResolveVersion returns version identifiers for either a uuid string or a branch
+// reference of the form "<uuid>:<branch>", where the uuid string matches any node in
+// the repo.  A branch reference resolves to the latest locked node on the branch, or
+// if open is true, the latest unlocked node.
+func ResolveVersion(str string, open bool) (dvid.UUID, dvid.VersionID, error) {
+	parts := strings.SplitN(str, ":", 2)
+	if len(parts) == 1 {
+		return MatchingUUID(str)
+	}
+	uuid, _, err := MatchingUUID(parts[0])
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	repo, err := RepoFromUUID(uuid)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	if repo == nil {
+		return dvid.NilUUID, 0, fmt.Errorf("No repo found for UUID %s", uuid)
+	}
+	head, err := repo.BranchHead(parts[1], open)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	versionID, err := VersionFromUUID(head)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	return head, versionID, nil
+}
+
+// MaxAliasLength is the maximum number of characters in a repo alias.
+const MaxAliasLength = 64
+
+// CheckAlias returns an error if a string can't be used as a repo alias.  Aliases can
+// be used in place of UUIDs in URLs, so they are limited to letters, digits, '-', '_',
+// and '.', and they can't be all hexadecimal digits, which could be mistaken for a UUID.
+// An empty alias is allowed and means the repo has no alias.
+func CheckAlias(alias string) error {
+	if alias == "" {
+		return nil
+	}
+	if len(alias) > MaxAliasLength {
+		return fmt.Errorf("Alias %q is longer than %d characters", alias, MaxAliasLength)
+	}
+	hex := true
+	for _, c := range alias {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+		case c >= 'g' && c <= 'z', c >= 'G' && c <= 'Z', c == '-', c == '_', c == '.':
+			hex = false
+		default:
+			return fmt.Errorf("Alias %q can only have letters, digits, '-', '_', and '.'", alias)
+		}
+	}
+	if hex {
+		return fmt.Errorf("Alias %q can't be all hexadecimal digits since it could match a UUID", alias)
+	}
+	return nil
+}
+
+// MatchingUUID returns version identifiers that uniquely matches a uuid string or,
+// if no UUID matches, the root of the repo with the string as alias.
 func MatchingUUID(uuidStr string) (dvid.UUID, dvid.VersionID, error) {
 	if Manager == nil {
 		return dvid.NilUUID, 0, fmt.Errorf("datastore not initialized")
@@ -135,7 +201,12 @@ func GetData(versionID dvid.VersionID, name dvid.DataString) (DataService, error
 // other packages.  See Context article at http://blog.golang.org/context
 type ctxkey int
 
-const repoCtxKey ctxkey = 0
+const (
+	repoCtxKey ctxkey = iota
+	clientCtxKey
+	requestIDCtxKey
+	durabilityCtxKey
+)
 
 type repoContext struct {
 	repo     Repo
@@ -148,6 +219,50 @@ func NewServerContext(ctx context.Context, repo Repo, versions ...dvid.VersionID
 	return context.WithValue(ctx, repoCtxKey, repoContext{repo, versions})
 }
 
+// WithClient returns a server Context that identifies the client making a request.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientCtxKey, client)
+}
+
+// ClientFromContext returns the client making a request, or an empty string if unknown.
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientCtxKey).(string)
+	return client
+}
+
+// WithRequestID returns a server Context that carries the ID of a request for logging.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey, id)
+}
+
+// RequestIDFromContext returns the ID of a request, or an empty string if unknown.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey).(string)
+	return id
+}
+
+// WithDurability returns a server Context that requests a durability for writes.
+func WithDurability(ctx context.Context, d storage.Durability) context.Context {
+	return context.WithValue(ctx, durabilityCtxKey, d)
+}
+
+// DurabilityFromContext returns the durability requested for writes, which is
+// storage.DurabilityDefault if none was requested.
+func DurabilityFromContext(ctx context.Context) storage.Durability {
+	d, _ := ctx.Value(durabilityCtxKey).(storage.Durability)
+	return d
+}
+
+// NewTimeLog returns a dvid.TimeLog whose messages include the ID of the request, if
+// any, so log messages for a request can be correlated.
+func NewTimeLog(ctx context.Context) dvid.TimeLog {
+	timedLog := dvid.NewTimeLog()
+	if id := RequestIDFromContext(ctx); id != "" {
+		return timedLog.With("request", id)
+	}
+	return timedLog
+}
+
 // FromContext returns Repo and optional versions within that Repo from a server Context.
 func FromContext(ctx context.Context) (Repo, []dvid.VersionID, error) {
 	repoCtxValue := ctx.Value(repoCtxKey)
@@ -45,11 +45,16 @@ var (
 	Compiled map[dvid.URLString]TypeService
 )
 
-// Register registers a datatype for DVID use.
+// Register registers a datatype for DVID use.  Datatypes registered while a plugin is
+// loaded are checked and registered by LoadTypePlugin().
 func Register(t TypeService) {
 	if Compiled == nil {
 		Compiled = make(map[dvid.URLString]TypeService)
 	}
+	if loadingPlugin != nil {
+		registerPluginType(t)
+		return
+	}
 	Compiled[t.GetType().URL] = t
 }
 
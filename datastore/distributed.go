@@ -301,6 +301,66 @@ func Push(repo Repo, target string, config dvid.Config) error {
 	return nil
 }
 
+// TransferFilterer is implemented by data instances that send only some of their stored
+// key-value pairs in a push, e.g., only blocks within an ROI.
+type TransferFilterer interface {
+	// TransferFilter returns a function that accepts the full keys that would be sent in
+	// a push of the given version delimited by the named ROI, if any.
+	TransferFilter(roiname string, uuid dvid.UUID) (func(key []byte) bool, error)
+}
+
+// InstanceEstimate gives the key-value pairs of a data instance that would be transferred.
+type InstanceEstimate struct {
+	Name     dvid.DataString
+	TypeName dvid.TypeString
+	*storage.TransferEstimate
+}
+
+// TransferEstimate gives the key-value pairs of each data instance that would be
+// transferred and their totals.
+type TransferEstimate struct {
+	Instances []InstanceEstimate
+	Total     storage.TransferEstimate
+}
+
+func (e *TransferEstimate) String() string {
+	var text string
+	for _, inst := range e.Instances {
+		text += fmt.Sprintf("%s (%s): %s\n", inst.Name, inst.TypeName, inst.TransferEstimate)
+	}
+	return text + fmt.Sprintf("Total: %s\n", &e.Total)
+}
+
+// EstimatePush scans the data that would be pushed from a repo with the given
+// configuration, i.e., the same "roi" and "data" settings as Push, and returns the
+// number of key-value pairs and bytes without transferring any data.
+func EstimatePush(repo Repo, config dvid.Config) (*TransferEstimate, error) {
+	roiname, err := getROI(config)
+	if err != nil {
+		return nil, err
+	}
+	data, err := getDataInstances(repo, config)
+	if err != nil {
+		return nil, err
+	}
+	estimate := new(TransferEstimate)
+	for _, instance := range data {
+		var filter func(key []byte) bool
+		if filterer, ok := instance.(TransferFilterer); ok {
+			if filter, err = filterer.TransferFilter(roiname, repo.RootUUID()); err != nil {
+				return nil, err
+			}
+		}
+		est, err := storage.EstimateInstance(instance.InstanceID(), filter)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to estimate data %q: %s", instance.DataName(), err.Error())
+		}
+		estimate.Instances = append(estimate.Instances, InstanceEstimate{instance.DataName(), instance.TypeName(), est})
+		estimate.Total.Add(est)
+	}
+	return estimate, nil
+}
+
 func Pull(repo Repo, target string, config dvid.Config) error {
 	// To Pull() we initiate a push from target.
 	// It's up to target whether it will push or not.
@@ -0,0 +1,140 @@
+/*
+	This file lets datatypes declare their HTTP endpoints with parameter, request, and
+	response schemas so the server can describe datatype APIs in machine-readable form,
+	e.g., as an OpenAPI document for client code generation.  Declarations only describe
+	the API; each data instance still serves requests via its ServeHTTP().
+*/
+
+package datastore
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Endpoint describes an HTTP endpoint of a datatype.  The path is relative to a data
+// instance, i.e., follows "/api/node/<UUID>/<data name>/", and gives path parameters
+// in braces, e.g., "key/{key}".
+type Endpoint struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string          `json:",omitempty"`
+	Params      []EndpointParam `json:",omitempty"`
+	Request     *EndpointBody   `json:",omitempty"`
+	Response    *EndpointBody   `json:",omitempty"`
+}
+
+// EndpointParam describes a path or query string parameter.  Type is a JSON schema
+// type, e.g., "string", "integer", or "boolean".
+type EndpointParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string `json:",omitempty"`
+	Required    bool
+}
+
+// EndpointBody describes a request or response body.  Schema is a JSON schema of the
+// body, which can be nil for binary or free-form bodies.
+type EndpointBody struct {
+	ContentType string
+	Description string                 `json:",omitempty"`
+	Schema      map[string]interface{} `json:",omitempty"`
+}
+
+// PathParams returns the names of the parameters in braces within the endpoint path.
+func (e Endpoint) PathParams() []string {
+	var names []string
+	for _, segment := range strings.Split(e.Path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, segment[1:len(segment)-1])
+		}
+	}
+	return names
+}
+
+// commonEndpoints are served by all data instances.
+var commonEndpoints = []Endpoint{
+	{
+		Method:   "GET",
+		Path:     "help",
+		Summary:  "Returns the datatype's help message.",
+		Response: &EndpointBody{ContentType: "text/plain"},
+	},
+	{
+		Method:  "GET",
+		Path:    "info",
+		Summary: "Returns the data instance's properties.",
+		Response: &EndpointBody{
+			ContentType: "application/json",
+			Schema:      map[string]interface{}{"type": "object"},
+		},
+	},
+}
+
+var (
+	endpointsMu sync.RWMutex
+	endpoints   = make(map[dvid.URLString][]Endpoint)
+)
+
+// RegisterEndpoints declares HTTP endpoints of a datatype beyond the "help" and "info"
+// endpoints common to all datatypes.  It's typically called from the datatype package's
+// init() after Register().  Path parameters without a declared EndpointParam are added
+// as required strings.
+func RegisterEndpoints(t TypeService, declared ...Endpoint) {
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+
+	url := t.GetType().URL
+	for _, e := range declared {
+		declaredParams := make(map[string]bool, len(e.Params))
+		for _, param := range e.Params {
+			if param.In == "path" {
+				declaredParams[param.Name] = true
+			}
+		}
+		params := e.Params
+		e.Params = nil
+		for _, name := range e.PathParams() {
+			if !declaredParams[name] {
+				e.Params = append(e.Params, EndpointParam{Name: name, In: "path", Type: "string", Required: true})
+			}
+		}
+		e.Params = append(e.Params, params...)
+		endpoints[url] = append(endpoints[url], e)
+	}
+}
+
+// TypeEndpoints returns the HTTP endpoints of a datatype, starting with those common to
+// all datatypes.
+func TypeEndpoints(t TypeService) []Endpoint {
+	endpointsMu.RLock()
+	defer endpointsMu.RUnlock()
+
+	declared := endpoints[t.GetType().URL]
+	all := make([]Endpoint, 0, len(commonEndpoints)+len(declared))
+	all = append(all, commonEndpoints...)
+	return append(all, declared...)
+}
+
+// WriteEndpointer is implemented by data services that declare which of their HTTP
+// endpoints modify data, e.g., so endpoints requested with POST only because they take
+// a query in the request body can still be used on committed nodes.
+type WriteEndpointer interface {
+	// WriteEndpoint returns true if a request with the given method to the given
+	// endpoint, the first path segment following the data name, modifies data.
+	WriteEndpoint(method, endpoint string) bool
+}
+
+// IsWriteRequest returns true if a request with the given method to the given endpoint
+// of a data service may modify data.  Unless the data service implements WriteEndpointer,
+// any request other than GET or HEAD is assumed to modify data.
+func IsWriteRequest(d DataService, method, endpoint string) bool {
+	if w, ok := d.(WriteEndpointer); ok {
+		return w.WriteEndpoint(method, endpoint)
+	}
+	return method != "GET" && method != "HEAD"
+}
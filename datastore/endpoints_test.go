@@ -0,0 +1,50 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type endpointTestType struct {
+	Type
+}
+
+func (t *endpointTestType) NewDataService(dvid.UUID, dvid.InstanceID, dvid.DataString, dvid.Config) (DataService, error) {
+	return nil, nil
+}
+
+func (t *endpointTestType) Help() string { return "" }
+
+func TestRegisterEndpoints(t *testing.T) {
+	dtype := &endpointTestType{Type{Name: "endpointtest", URL: "foo.bar.baz/endpointtest", Version: "1.0"}}
+	RegisterEndpoints(dtype, Endpoint{
+		Method: "GET",
+		Path:   "range/{begin}/{end}",
+		Params: []EndpointParam{
+			{Name: "end", In: "path", Type: "integer", Required: true},
+			{Name: "limit", In: "query", Type: "integer"},
+		},
+	})
+
+	endpoints := TypeEndpoints(dtype)
+	if len(endpoints) != len(commonEndpoints)+1 {
+		t.Fatalf("Expected %d endpoints, got %d\n", len(commonEndpoints)+1, len(endpoints))
+	}
+	if endpoints[0].Path != "help" || endpoints[1].Path != "info" {
+		t.Errorf("Expected common endpoints first, got %v\n", endpoints)
+	}
+	declared := endpoints[len(endpoints)-1]
+	if names := declared.PathParams(); !reflect.DeepEqual(names, []string{"begin", "end"}) {
+		t.Errorf("Bad path parameters: %v\n", names)
+	}
+	expected := []EndpointParam{
+		{Name: "begin", In: "path", Type: "string", Required: true},
+		{Name: "end", In: "path", Type: "integer", Required: true},
+		{Name: "limit", In: "query", Type: "integer"},
+	}
+	if !reflect.DeepEqual(declared.Params, expected) {
+		t.Errorf("Expected params %v, got %v\n", expected, declared.Params)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+	This file defines a portable JSON document describing a repo's metadata: its version
+	DAG, data instance definitions, and the datatype versions they require.  A repo can
+	be exported from one DVID server and imported into another, where the instances are
+	created empty with new local IDs, as the first step of migrating the repo's data.
+*/
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// ExportFormatVersion is the version of the repo export document format.
+const ExportFormatVersion = 1
+
+// RepoExport is a portable description of a repo and its data instances.  UUIDs are
+// kept across servers while local IDs are not exported.
+type RepoExport struct {
+	FormatVersion int
+	Exported      time.Time
+
+	Root        dvid.UUID
+	Alias       string
+	Description string
+	Log         []string
+	Properties  map[string]interface{}
+	Created     time.Time
+	Updated     time.Time
+
+	Note       string            `json:",omitempty"`
+	Tags       map[string]string `json:",omitempty"`
+	Provenance []Provenance      `json:",omitempty"`
+
+	// Nodes of the version DAG in order of creation.
+	Nodes []NodeExport
+
+	// Types gives the datatype versions required by the instances.
+	Types []TypeExport
+
+	Instances []InstanceExport
+}
+
+// NodeExport describes a version in a repo's DAG.  The first parent is the default
+// ancestor path.  An empty branch denotes the default branch.
+type NodeExport struct {
+	UUID    dvid.UUID
+	Parents []dvid.UUID
+	Branch  string `json:",omitempty"`
+	Note    string
+	Log     []string
+	Locked  bool
+	Data    map[dvid.DataString]DataAvail `json:",omitempty"`
+	Created time.Time
+	Updated time.Time
+
+	CommitMessage string    `json:",omitempty"`
+	Committer     string    `json:",omitempty"`
+	Committed     time.Time `json:",omitempty"`
+
+	Tags       map[string]string `json:",omitempty"`
+	Provenance []Provenance      `json:",omitempty"`
+}
+
+// TypeExport identifies a datatype version.
+type TypeExport struct {
+	Name    dvid.TypeString
+	URL     dvid.URLString
+	Version string
+}
+
+// InstanceExport defines a data instance.  Info is the instance's JSON description,
+// given for reference, while Definition is the encoded instance used to recreate it,
+// which requires the same datatype version.
+type InstanceExport struct {
+	Name        dvid.DataString
+	TypeURL     dvid.URLString
+	TypeVersion string
+	Info        json.RawMessage
+	Definition  []byte
+}
+
+// RepoExporter is implemented by repo managers that can export and import repo metadata.
+type RepoExporter interface {
+	// ExportRepo returns a portable description of a repo.
+	ExportRepo(Repo) (*RepoExport, error)
+
+	// ImportRepo adds a repo with the exported DAG and empty data instances, giving
+	// them local IDs.  Returns an error if any of the repo's UUIDs already exist.
+	ImportRepo(*RepoExport) (Repo, error)
+}
+
+// ExportRepo returns a portable description of a repo's DAG and data instances.
+func ExportRepo(repo Repo) (*RepoExport, error) {
+	if Manager == nil {
+		return nil, fmt.Errorf("datastore not initialized")
+	}
+	exporter, ok := Manager.(RepoExporter)
+	if !ok {
+		return nil, fmt.Errorf("Repo export is not supported by this datastore")
+	}
+	return exporter.ExportRepo(repo)
+}
+
+// ImportRepo adds a repo from an exported description, creating its data instances
+// without any data.
+func ImportRepo(doc *RepoExport) (Repo, error) {
+	if Manager == nil {
+		return nil, fmt.Errorf("datastore not initialized")
+	}
+	exporter, ok := Manager.(RepoExporter)
+	if !ok {
+		return nil, fmt.Errorf("Repo import is not supported by this datastore")
+	}
+	if doc.FormatVersion != ExportFormatVersion {
+		return nil, fmt.Errorf("Unsupported repo export format version %d, expected %d",
+			doc.FormatVersion, ExportFormatVersion)
+	}
+	return exporter.ImportRepo(doc)
+}
+
+// checkTypes returns an error if an exported datatype version isn't compiled into
+// this server.
+func (doc *RepoExport) checkTypes() error {
+	for _, t := range doc.Types {
+		typeservice, err := TypeServiceByURL(t.URL)
+		if err != nil {
+			return err
+		}
+		if version := typeservice.GetType().Version; version != t.Version {
+			return fmt.Errorf("Data type %q is version %q on this server, but export requires %q",
+				t.Name, version, t.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+// +build !clustered,!gcloud
+
+/*
+	This file exports and imports repo metadata for a single DVID process.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type nodesByCreation []*nodeT
+
+func (s nodesByCreation) Len() int      { return len(s) }
+func (s nodesByCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s nodesByCreation) Less(i, j int) bool {
+	if s[i].created.Equal(s[j].created) {
+		return s[i].versionID < s[j].versionID
+	}
+	return s[i].created.Before(s[j].created)
+}
+
+// ExportRepo returns a portable description of a repo's DAG and data instances.
+func (m *repoManager) ExportRepo(repo Repo) (*RepoExport, error) {
+	r, ok := repo.(*repoT)
+	if !ok {
+		return nil, fmt.Errorf("Repo passed to ExportRepo() is not *repoT!")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.export()
+}
+
+func (r *repoT) export() (*RepoExport, error) {
+	doc := &RepoExport{
+		FormatVersion: ExportFormatVersion,
+		Exported:      time.Now(),
+		Root:          r.rootID,
+		Alias:         r.alias,
+		Description:   r.description,
+		Log:           r.log,
+		Properties:    r.properties,
+		Created:       r.created,
+		Updated:       r.updated,
+		Note:          r.note,
+		Tags:          r.tags,
+		Provenance:    r.provenance,
+	}
+
+	nodes := make(nodesByCreation, 0, len(r.dag.nodes))
+	for _, node := range r.dag.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Sort(nodes)
+	for _, node := range nodes {
+		parents := make([]dvid.UUID, len(node.parents))
+		for i, versionID := range node.parents {
+			parent, found := r.dag.nodes[versionID]
+			if !found {
+				return nil, fmt.Errorf("Node %s has parent version %d not in repo %s", node.uuid, versionID, r.rootID)
+			}
+			parents[i] = parent.uuid
+		}
+		doc.Nodes = append(doc.Nodes, NodeExport{
+			UUID:    node.uuid,
+			Parents: parents,
+			Branch:  node.branch,
+			Note:    node.note,
+			Log:     node.log,
+			Locked:  node.locked,
+			Data:    node.avail,
+			Created: node.created,
+			Updated: node.updated,
+
+			CommitMessage: node.commitMessage,
+			Committer:     node.committer,
+			Committed:     node.committed,
+			Tags:          node.tags,
+			Provenance:    node.provenance,
+		})
+	}
+
+	names := make([]string, 0, len(r.data))
+	for name := range r.data {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	types := make(map[dvid.URLString]bool)
+	for _, name := range names {
+		dataservice := r.data[dvid.DataString(name)]
+		info, err := json.Marshal(dataservice)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&dataservice); err != nil {
+			return nil, fmt.Errorf("Unable to encode data %q: %s", name, err.Error())
+		}
+		doc.Instances = append(doc.Instances, InstanceExport{
+			Name:        dataservice.DataName(),
+			TypeURL:     dataservice.TypeURL(),
+			TypeVersion: dataservice.TypeVersion(),
+			Info:        info,
+			Definition:  buf.Bytes(),
+		})
+		if !types[dataservice.TypeURL()] {
+			types[dataservice.TypeURL()] = true
+			doc.Types = append(doc.Types, TypeExport{
+				Name:    dataservice.TypeName(),
+				URL:     dataservice.TypeURL(),
+				Version: dataservice.TypeVersion(),
+			})
+		}
+	}
+	return doc, nil
+}
+
+// repoFromExport returns a repo described by an export.  Its versions and instances
+// have placeholder local IDs that must be remapped before the repo is added.
+func repoFromExport(doc *RepoExport) (*repoT, error) {
+	r := &repoT{
+		rootID:      doc.Root,
+		alias:       doc.Alias,
+		description: doc.Description,
+		log:         doc.Log,
+		properties:  doc.Properties,
+		created:     doc.Created,
+		updated:     doc.Updated,
+		note:        doc.Note,
+		tags:        doc.Tags,
+		provenance:  doc.Provenance,
+		dag:         &dagT{root: doc.Root, nodes: make(map[dvid.VersionID]*nodeT, len(doc.Nodes))},
+		data:        make(map[dvid.DataString]DataService, len(doc.Instances)),
+	}
+	if r.log == nil {
+		r.log = []string{}
+	}
+	if r.properties == nil {
+		r.properties = make(map[string]interface{})
+	}
+
+	versions := make(map[dvid.UUID]dvid.VersionID, len(doc.Nodes))
+	for i, n := range doc.Nodes {
+		if _, found := versions[n.UUID]; found {
+			return nil, fmt.Errorf("Node %s is exported more than once", n.UUID)
+		}
+		versions[n.UUID] = dvid.VersionID(i + 1)
+	}
+	if _, found := versions[doc.Root]; !found {
+		return nil, fmt.Errorf("Root %s is not among the exported nodes", doc.Root)
+	}
+	for i, n := range doc.Nodes {
+		node := &nodeT{
+			note:      n.Note,
+			log:       n.Log,
+			avail:     n.Data,
+			uuid:      n.UUID,
+			versionID: dvid.VersionID(i + 1),
+			locked:    n.Locked,
+			parents:   []dvid.VersionID{},
+			children:  []dvid.VersionID{},
+			created:   n.Created,
+			updated:   n.Updated,
+			branch:    n.Branch,
+
+			commitMessage: n.CommitMessage,
+			committer:     n.Committer,
+			committed:     n.Committed,
+			tags:          n.Tags,
+			provenance:    n.Provenance,
+		}
+		if node.log == nil {
+			node.log = []string{}
+		}
+		if node.avail == nil {
+			node.avail = make(map[dvid.DataString]DataAvail)
+		}
+		for _, uuid := range n.Parents {
+			parent, found := versions[uuid]
+			if !found {
+				return nil, fmt.Errorf("Node %s has parent %s that is not among the exported nodes", n.UUID, uuid)
+			}
+			node.parents = append(node.parents, parent)
+		}
+		if (n.UUID == doc.Root) != (len(node.parents) == 0) {
+			return nil, fmt.Errorf("Node %s must have parents unless it is the root", n.UUID)
+		}
+		r.dag.nodes[node.versionID] = node
+	}
+	for _, n := range doc.Nodes {
+		child := versions[n.UUID]
+		for _, uuid := range n.Parents {
+			parent := r.dag.nodes[versions[uuid]]
+			parent.children = append(parent.children, child)
+		}
+	}
+
+	for _, inst := range doc.Instances {
+		typeservice, err := TypeServiceByURL(inst.TypeURL)
+		if err != nil {
+			return nil, err
+		}
+		if version := typeservice.GetType().Version; version != inst.TypeVersion {
+			return nil, fmt.Errorf("Data %q requires version %q of type %q, but this server has version %q",
+				inst.Name, inst.TypeVersion, inst.TypeURL, version)
+		}
+		var dataservice DataService
+		if err := gob.NewDecoder(bytes.NewBuffer(inst.Definition)).Decode(&dataservice); err != nil {
+			return nil, fmt.Errorf("Unable to decode data %q: %s", inst.Name, err.Error())
+		}
+		if dataservice.DataName() != inst.Name || dataservice.TypeURL() != inst.TypeURL {
+			return nil, fmt.Errorf("Definition of data %q is for data %q of type %q", inst.Name,
+				dataservice.DataName(), dataservice.TypeURL())
+		}
+		if _, found := r.data[inst.Name]; found {
+			return nil, fmt.Errorf("Data %q is exported more than once", inst.Name)
+		}
+		r.data[inst.Name] = dataservice
+	}
+	return r, nil
+}
+
+// ImportRepo adds a repo from an exported description, creating its data instances
+// without any data and with new local IDs.
+func (m *repoManager) ImportRepo(doc *RepoExport) (Repo, error) {
+	if err := doc.checkTypes(); err != nil {
+		return nil, err
+	}
+	r, err := repoFromExport(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range r.dag.nodes {
+		if _, err := m.VersionFromUUID(node.uuid); err == nil {
+			return nil, fmt.Errorf("Node %s already exists on this server", node.uuid)
+		}
+	}
+	if err := m.checkAliasAvailable(r.alias, r); err != nil {
+		return nil, err
+	}
+
+	if r.repoID, err = m.NewRepoID(); err != nil {
+		return nil, err
+	}
+	if _, _, err = r.remapLocalIDs(); err != nil {
+		return nil, err
+	}
+	r.manager = m
+	r.log = append(r.log, fmt.Sprintf("Imported repo with %d nodes and %d data instances", len(r.dag.nodes), len(r.data)))
+
+	m.Lock()
+	for _, node := range r.dag.nodes {
+		m.repos[node.uuid] = r
+	}
+	m.repoToUUID[r.repoID] = r.rootID
+	err = m.putCaches()
+	m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Save(); err != nil {
+		return nil, err
+	}
+
+	for _, dataservice := range r.data {
+		if err := onCreate(r, dataservice); err != nil {
+			dvid.Errorf("Imported repo %s: %s\n", r.rootID, err.Error())
+		}
+	}
+	dvid.Infof("Imported repo %s with %d nodes and %d data instances\n", r.rootID, len(r.dag.nodes), len(r.data))
+	return r, nil
+}
@@ -0,0 +1,88 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestRepoExport(t *testing.T) {
+	// Version 2 is the child of root 1 and branches into 3 and 4, which merge into 5.
+	repo := mockRepo()
+	repo.rootID = "uuid1"
+	repo.alias = "exported"
+	repo.log = []string{"created"}
+	repo.dag = &dagT{root: "uuid1", nodes: make(map[dvid.VersionID]*nodeT)}
+	parents := map[dvid.VersionID][]dvid.VersionID{2: {1}, 3: {2}, 4: {2}, 5: {4, 3}}
+	now := time.Now()
+	for v := dvid.VersionID(1); v <= 5; v++ {
+		repo.dag.nodes[v] = &nodeT{
+			uuid:      dvid.UUID(fmt.Sprintf("uuid%d", v)),
+			versionID: v,
+			locked:    v != 5,
+			log:       []string{},
+			avail:     map[dvid.DataString]DataAvail{},
+			parents:   append([]dvid.VersionID{}, parents[v]...),
+			created:   now.Add(time.Duration(v) * time.Second),
+		}
+	}
+	for v := dvid.VersionID(2); v <= 5; v++ {
+		for _, parent := range parents[v] {
+			repo.dag.nodes[parent].children = append(repo.dag.nodes[parent].children, v)
+		}
+	}
+	repo.dag.nodes[3].branch = "proofreading"
+
+	doc, err := repo.export()
+	if err != nil {
+		t.Fatalf("Could not export repo: %s\n", err.Error())
+	}
+	if len(doc.Nodes) != 5 || doc.Nodes[0].UUID != "uuid1" || doc.Nodes[4].UUID != "uuid5" {
+		t.Fatalf("Bad exported nodes: %v\n", doc.Nodes)
+	}
+	if !reflect.DeepEqual(doc.Nodes[4].Parents, []dvid.UUID{"uuid4", "uuid3"}) {
+		t.Errorf("Bad exported parents of merge: %v\n", doc.Nodes[4].Parents)
+	}
+
+	jsonBytes, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Could not encode export: %s\n", err.Error())
+	}
+	var received RepoExport
+	if err := json.Unmarshal(jsonBytes, &received); err != nil {
+		t.Fatalf("Could not decode export: %s\n", err.Error())
+	}
+	imported, err := repoFromExport(&received)
+	if err != nil {
+		t.Fatalf("Could not create repo from export: %s\n", err.Error())
+	}
+	if imported.rootID != "uuid1" || imported.alias != "exported" || len(imported.dag.nodes) != 5 {
+		t.Fatalf("Bad imported repo: %s\n", imported)
+	}
+	for v, node := range repo.dag.nodes {
+		got := imported.dag.nodes[v]
+		if got == nil || got.uuid != node.uuid || got.locked != node.locked || got.branch != node.branch {
+			t.Errorf("Bad imported node for version %d: %v\n", v, got)
+			continue
+		}
+		if !reflect.DeepEqual(got.parents, node.parents) || !reflect.DeepEqual(got.children, node.children) {
+			t.Errorf("Bad imported links for version %d: parents %v, children %v\n", v, got.parents, got.children)
+		}
+	}
+
+	// Exports must describe a single DAG rooted at the root.
+	received.Nodes[1].Parents = nil
+	if _, err := repoFromExport(&received); err == nil {
+		t.Errorf("Expected error importing non-root node without parents\n")
+	}
+	received.Nodes[1].Parents = []dvid.UUID{"missing"}
+	if _, err := repoFromExport(&received); err == nil {
+		t.Errorf("Expected error importing node with missing parent\n")
+	}
+}
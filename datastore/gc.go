@@ -0,0 +1,36 @@
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// GarbageCollector is implemented by repo managers that can delete stored data that is
+// no longer reachable from any repo.
+type GarbageCollector interface {
+	CollectGarbage(storage.GCOptions) (*storage.GCStats, error)
+}
+
+// StalePruner is implemented by data instances that store derived values, e.g.,
+// surfaces or tiles, that can become stale when their source data changes.
+type StalePruner interface {
+	// PruneStale finds stale derived values of the data instance and deletes them
+	// through a storage.StaleDeleter, which records them in the stats.
+	PruneStale(repo Repo, opts storage.GCOptions, stats *storage.GCStats) error
+}
+
+// CollectGarbage deletes stored data of data instances and versions that are no longer
+// in any repo, e.g., data left behind after an instance deletion was interrupted, and
+// stale derived values of data instances that are StalePruners.  A dry run only reports
+// the data that could be reclaimed.
+func CollectGarbage(opts storage.GCOptions) (*storage.GCStats, error) {
+	if Manager == nil {
+		return nil, fmt.Errorf("datastore not initialized")
+	}
+	gc, ok := Manager.(GarbageCollector)
+	if !ok {
+		return nil, fmt.Errorf("Garbage collection is not supported by this datastore")
+	}
+	return gc.CollectGarbage(opts)
+}
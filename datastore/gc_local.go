@@ -0,0 +1,102 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports garbage collection of data left in storage after data instances
+	are deleted or versions leave all repo DAGs, and of stale derived values.
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// liveIDs holds the data instances and versions reachable from any repo.  Any ID at
+// or above the next ID to be assigned is considered live, since it could have been
+// created after the IDs were gathered.
+type liveIDs struct {
+	instances    map[dvid.InstanceID]bool
+	versions     map[dvid.VersionID]bool
+	nextInstance dvid.InstanceID
+	nextVersion  dvid.VersionID
+}
+
+func (ids *liveIDs) live(instanceID dvid.InstanceID, versionID dvid.VersionID) bool {
+	if instanceID >= ids.nextInstance {
+		return true
+	}
+	if !ids.instances[instanceID] {
+		return false
+	}
+	// Reserved version IDs may be used for bounds or unversioned bookkeeping.
+	if versionID == 0 || versionID == dvid.MaxVersionID || versionID >= ids.nextVersion {
+		return true
+	}
+	return ids.versions[versionID]
+}
+
+// liveIDs returns the data instances and versions of all repos.
+func (m *repoManager) liveIDs() *liveIDs {
+	m.idMutex.Lock()
+	ids := &liveIDs{
+		instances:    make(map[dvid.InstanceID]bool),
+		versions:     make(map[dvid.VersionID]bool),
+		nextInstance: m.newInstanceID,
+		nextVersion:  m.newVersionID,
+	}
+	m.idMutex.Unlock()
+
+	for _, r := range m.repoList() {
+		r.mu.Lock()
+		for _, data := range r.data {
+			ids.instances[data.InstanceID()] = true
+		}
+		for versionID := range r.dag.nodes {
+			ids.versions[versionID] = true
+		}
+		r.mu.Unlock()
+	}
+	return ids
+}
+
+// CollectGarbage deletes stored data of data instances and versions that are no longer
+// in any repo.
+func (m *repoManager) CollectGarbage(opts storage.GCOptions) (*storage.GCStats, error) {
+	ids := m.liveIDs()
+	dvid.Infof("Starting garbage collection (dry run %t) with %d live instances, %d live versions\n",
+		opts.DryRun, len(ids.instances), len(ids.versions))
+	timedLog := dvid.NewTimeLog()
+	stats, err := storage.CollectGarbage(ids.live, opts)
+	if err != nil {
+		return stats, err
+	}
+	if err := m.pruneStale(opts, stats); err != nil {
+		return stats, err
+	}
+	timedLog.Infof("Garbage collection complete: %s", stats)
+	return stats, nil
+}
+
+// pruneStale has each data instance that is a StalePruner reclaim its stale derived values.
+func (m *repoManager) pruneStale(opts storage.GCOptions, stats *storage.GCStats) error {
+	for _, r := range m.repoList() {
+		r.mu.Lock()
+		var pruners []StalePruner
+		for _, data := range r.data {
+			if pruner, ok := data.(StalePruner); ok {
+				pruners = append(pruners, pruner)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, pruner := range pruners {
+			if err := pruner.PruneStale(r, opts, stats); err != nil {
+				return fmt.Errorf("Unable to prune stale values of %q: %s", pruner.(DataService).DataName(), err.Error())
+			}
+		}
+	}
+	return nil
+}
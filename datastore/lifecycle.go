@@ -0,0 +1,74 @@
+/*
+	This file defines lifecycle hooks that data instances can implement so derived data,
+	e.g., tiles, surfaces, or indexes kept outside the instance's keyspace or in memory,
+	can be initialized or cleaned up when the datastore creates or deletes an instance or
+	commits a version.  Hooks are called without any repo lock held, so they may use the
+	repo.
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Creator is implemented by data instances that initialize derived data when created.
+type Creator interface {
+	// OnCreate is called after the data instance is added to the repo.  If an error is
+	// returned, the instance is removed from the repo and creation fails.
+	OnCreate(repo Repo) error
+}
+
+// Deleter is implemented by data instances that clean up derived data when deleted.
+type Deleter interface {
+	// OnDelete is called before the data instance's key-value pairs are deleted.  If an
+	// error is returned, the instance is not deleted.
+	OnDelete(repo Repo) error
+}
+
+// VersionCommitter is implemented by data instances that act on committed versions,
+// e.g., to finalize derived data that is only computed for locked nodes.
+type VersionCommitter interface {
+	// OnVersionCommit is called after the node with the given UUID is locked and before
+	// the commit returns.  Errors are logged since the node remains locked.
+	OnVersionCommit(repo Repo, uuid dvid.UUID, versionID dvid.VersionID) error
+}
+
+// onCreate calls any creation hook of a new data instance.
+func onCreate(repo Repo, dataservice DataService) error {
+	creator, ok := dataservice.(Creator)
+	if !ok {
+		return nil
+	}
+	if err := creator.OnCreate(repo); err != nil {
+		return fmt.Errorf("Unable to initialize data %q: %s", dataservice.DataName(), err.Error())
+	}
+	return nil
+}
+
+// onDelete calls any deletion hook of a data instance.
+func onDelete(repo Repo, dataservice DataService) error {
+	deleter, ok := dataservice.(Deleter)
+	if !ok {
+		return nil
+	}
+	if err := deleter.OnDelete(repo); err != nil {
+		return fmt.Errorf("Unable to clean up data %q: %s", dataservice.DataName(), err.Error())
+	}
+	return nil
+}
+
+// onVersionCommit calls the commit hooks of all data instances for a locked node.
+func onVersionCommit(repo Repo, data []DataService, uuid dvid.UUID, versionID dvid.VersionID) {
+	for _, dataservice := range data {
+		committer, ok := dataservice.(VersionCommitter)
+		if !ok {
+			continue
+		}
+		if err := committer.OnVersionCommit(repo, uuid, versionID); err != nil {
+			dvid.Errorf("Error on commit of node %s for data %q: %s\n", uuid, dataservice.DataName(), err.Error())
+		}
+	}
+}
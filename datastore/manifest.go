@@ -0,0 +1,157 @@
+/*
+	This file generates integrity manifests for published versions.  A manifest lists each
+	data instance of a locked version with its datatype version and the number, size, and
+	checksum of its key-value pairs, so a third party can verify a downloaded or mirrored
+	copy of the version on another server.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// ManifestFormatVersion is the version of the integrity manifest format.
+const ManifestFormatVersion = 1
+
+// Manifest describes the content of each data instance in a locked version.
+type Manifest struct {
+	FormatVersion int
+	Generated     time.Time
+
+	Root  dvid.UUID
+	UUID  dvid.UUID
+	Alias string
+
+	// Instances in order of name.
+	Instances []InstanceManifest
+}
+
+// InstanceManifest gives the datatype version and content of a data instance.
+type InstanceManifest struct {
+	Name        dvid.DataString
+	TypeName    dvid.TypeString
+	TypeURL     dvid.URLString
+	TypeVersion string
+
+	Keys       uint64
+	IndexBytes uint64
+	ValueBytes uint64
+	Checksum   string
+}
+
+func (m *Manifest) String() string {
+	s := fmt.Sprintf("Manifest of node %s (repo %s) generated %s:\n", m.UUID, m.Root, m.Generated.Format(time.RFC3339))
+	for _, instance := range m.Instances {
+		s += fmt.Sprintf("  %s [%s %s]: %d keys, %d index bytes, %d value bytes, checksum %s\n",
+			instance.Name, instance.TypeName, instance.TypeVersion, instance.Keys,
+			instance.IndexBytes, instance.ValueBytes, instance.Checksum)
+	}
+	return s
+}
+
+// GenerateManifest scans all data instances of a locked version and returns its
+// manifest.  Locked versions can't change, so the manifest stays valid for the version.
+func GenerateManifest(repo Repo, uuid dvid.UUID, request Request) (*Manifest, error) {
+	locked, err := repo.Locked(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("Manifests can only be generated for locked nodes; node %s is unlocked", uuid)
+	}
+	versionID, err := VersionFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	data, err := repo.GetAllData()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range data {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	manifest := &Manifest{
+		FormatVersion: ManifestFormatVersion,
+		Generated:     time.Now(),
+		Root:          repo.RootUUID(),
+		UUID:          uuid,
+		Alias:         repo.GetAlias(),
+	}
+	for i, name := range names {
+		if request.Canceled() {
+			return nil, fmt.Errorf("Manifest generation for node %s canceled", uuid)
+		}
+		dataservice := data[dvid.DataString(name)]
+		ctx := NewVersionedContext(dataservice, versionID)
+		ctx.SetDone(request.Done())
+		summary, err := storage.SummarizeInstance(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to summarize data %q: %s", name, err.Error())
+		}
+		t := dataservice.GetType().GetType()
+		manifest.Instances = append(manifest.Instances, InstanceManifest{
+			Name:        dataservice.DataName(),
+			TypeName:    dataservice.TypeName(),
+			TypeURL:     t.URL,
+			TypeVersion: t.Version,
+			Keys:        summary.Keys,
+			IndexBytes:  summary.IndexBytes,
+			ValueBytes:  summary.ValueBytes,
+			Checksum:    summary.Checksum,
+		})
+		request.JobLogf("Data %q: %s\n", name, summary)
+		request.Progress(100 * float64(i+1) / float64(len(names)))
+	}
+	return manifest, nil
+}
+
+// Verify compares the manifest with an expected one, e.g., the manifest published with
+// a version, and returns a description of each difference.  The generation times and
+// aliases aren't compared.
+func (m *Manifest) Verify(expected *Manifest) []string {
+	var diffs []string
+	if expected.FormatVersion != m.FormatVersion {
+		return []string{fmt.Sprintf("manifest format version %d, expected %d", m.FormatVersion, expected.FormatVersion)}
+	}
+	if m.UUID != expected.UUID {
+		diffs = append(diffs, fmt.Sprintf("node %s, expected %s", m.UUID, expected.UUID))
+	}
+	instances := make(map[dvid.DataString]InstanceManifest, len(m.Instances))
+	for _, instance := range m.Instances {
+		instances[instance.Name] = instance
+	}
+	for _, e := range expected.Instances {
+		got, found := instances[e.Name]
+		if !found {
+			diffs = append(diffs, fmt.Sprintf("data %q is missing", e.Name))
+			continue
+		}
+		delete(instances, e.Name)
+		if got.TypeURL != e.TypeURL || got.TypeVersion != e.TypeVersion {
+			diffs = append(diffs, fmt.Sprintf("data %q has type %s version %s, expected %s version %s",
+				e.Name, got.TypeURL, got.TypeVersion, e.TypeURL, e.TypeVersion))
+		}
+		if got.Keys != e.Keys || got.IndexBytes != e.IndexBytes || got.ValueBytes != e.ValueBytes {
+			diffs = append(diffs, fmt.Sprintf("data %q has %d keys with %d index and %d value bytes, expected %d keys with %d index and %d value bytes",
+				e.Name, got.Keys, got.IndexBytes, got.ValueBytes, e.Keys, e.IndexBytes, e.ValueBytes))
+		}
+		if got.Checksum != e.Checksum {
+			diffs = append(diffs, fmt.Sprintf("data %q has checksum %s, expected %s", e.Name, got.Checksum, e.Checksum))
+		}
+	}
+	for _, instance := range m.Instances {
+		if _, extra := instances[instance.Name]; extra {
+			diffs = append(diffs, fmt.Sprintf("data %q isn't in expected manifest", instance.Name))
+		}
+	}
+	return diffs
+}
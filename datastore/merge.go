@@ -0,0 +1,74 @@
+/*
+	This file contains platform-independent code for merging divergent versions of a
+	repo into a node with multiple parents.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Merger is implemented by data instances that can merge versions that have diverged
+// since a common ancestor.  Data instances that don't implement Merger only expose the
+// data of the first parent in a merged node.
+type Merger interface {
+	// MergeConflicts returns descriptions of data, e.g., keys, that were changed in more
+	// than one parent since the common ancestor and can't be merged.
+	MergeConflicts(m *VersionMerge) ([]string, error)
+
+	// MergeVersions makes data changed in parents other than the first visible in the
+	// merged version.  It is only called if there are no conflicts.
+	MergeVersions(m *VersionMerge) error
+}
+
+// MergeConflictError is returned when a merge is rejected due to conflicting changes.
+type MergeConflictError struct {
+	// Conflicts gives the conflicting changes for each data instance.
+	Conflicts map[dvid.DataString][]string
+}
+
+func (e *MergeConflictError) Error() string {
+	names := make([]string, 0, len(e.Conflicts))
+	for name, conflicts := range e.Conflicts {
+		names = append(names, fmt.Sprintf("%s (%d)", name, len(conflicts)))
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("Merge has conflicting changes in data %s", strings.Join(names, ", "))
+}
+
+// VersionMerge describes the merge of divergent parent versions into a new version.
+type VersionMerge struct {
+	// Ancestor is the most recent common ancestor of all parents.
+	Ancestor dvid.VersionID
+
+	// Parents are the versions being merged where the first parent's data takes
+	// precedence for data instances that aren't Mergers.
+	Parents []dvid.VersionID
+
+	// Merged is the new version with all parents, which is 0 when only checking
+	// for conflicts.
+	Merged dvid.VersionID
+
+	// paths holds the ancestor path of each parent as traversed for versioned reads.
+	paths map[dvid.VersionID][]dvid.VersionID
+
+	// common holds the ancestor and all of its ancestors.
+	common map[dvid.VersionID]bool
+}
+
+// Seen returns the version seen by a parent given the versions stored for some data,
+// e.g., a key, and whether that version was stored after the common ancestor.
+// Returns false for found if none of the stored versions are on the parent's path.
+func (m *VersionMerge) Seen(parent dvid.VersionID, stored func(dvid.VersionID) bool) (v dvid.VersionID, found, changed bool) {
+	for _, v = range m.paths[parent] {
+		if stored(v) {
+			return v, true, !m.common[v]
+		}
+	}
+	return 0, false, false
+}
@@ -0,0 +1,80 @@
+/*
+	This file supports descriptive metadata of repos and nodes: a free-text note, key/value
+	tags, and an append-only provenance log of operations.  The metadata is stored with the
+	repo in the MetaData tier.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Provenance is an entry in the provenance log of a repo or node, recording who did what
+// and when, e.g., who loaded which files into a data instance.
+type Provenance struct {
+	Time      time.Time
+	User      string
+	Operation string
+	Detail    string `json:",omitempty"`
+}
+
+// Metadata is the descriptive metadata of a repo or node.
+type Metadata struct {
+	Note       string
+	Tags       map[string]string
+	Provenance []Provenance
+}
+
+// newMetadata returns copies of metadata so it can be read without holding a lock.
+func newMetadata(note string, tags map[string]string, provenance []Provenance) Metadata {
+	md := Metadata{
+		Note:       note,
+		Tags:       make(map[string]string, len(tags)),
+		Provenance: make([]Provenance, len(provenance)),
+	}
+	for key, value := range tags {
+		md.Tags[key] = value
+	}
+	copy(md.Provenance, provenance)
+	return md
+}
+
+// checkTags returns an error if any tag key is empty.
+func checkTags(changes map[string]*string) error {
+	for key := range changes {
+		if key == "" {
+			return fmt.Errorf("Tags must have non-empty keys")
+		}
+	}
+	return nil
+}
+
+// applyTags sets tags to changed values or deletes tags with nil values, returning the
+// possibly allocated tags.
+func applyTags(tags map[string]string, changes map[string]*string) map[string]string {
+	if tags == nil {
+		tags = make(map[string]string, len(changes))
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(tags, key)
+		} else {
+			tags[key] = *value
+		}
+	}
+	return tags
+}
+
+// checkProvenance returns an error if a provenance entry has no operation, and sets its
+// time if it has none.
+func checkProvenance(entry *Provenance) error {
+	if entry.Operation == "" {
+		return fmt.Errorf("Provenance entry requires an operation")
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// +build !clustered,!gcloud
+
+/*
+	This file implements repo and node metadata for a single DVID process.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (r *repoT) GetMetadata() Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return newMetadata(r.note, r.tags, r.provenance)
+}
+
+func (r *repoT) SetNote(note string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.note = note
+	r.updated = time.Now()
+	return r.save()
+}
+
+func (r *repoT) SetTags(changes map[string]*string) error {
+	if err := checkTags(changes); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags = applyTags(r.tags, changes)
+	r.updated = time.Now()
+	return r.save()
+}
+
+func (r *repoT) AddProvenance(entry Provenance) error {
+	if err := checkProvenance(&entry); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provenance = append(r.provenance, entry)
+	r.updated = time.Now()
+	return r.save()
+}
+
+func (r *repoT) GetStorageQuota() StorageQuota {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.quota
+}
+
+func (r *repoT) SetStorageQuota(quota StorageQuota) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quota = quota
+	r.updated = time.Now()
+	return r.save()
+}
+
+// getNode returns the node with given UUID.  Caller must hold the repo lock.
+func (r *repoT) getNode(uuid dvid.UUID) (*nodeT, error) {
+	versionID, found := r.manager.UUIDToVersion[uuid]
+	if !found {
+		return nil, fmt.Errorf("No version found with uuid %s", uuid)
+	}
+	node, found := r.dag.nodes[versionID]
+	if !found {
+		return nil, fmt.Errorf("No version found with id %d", versionID)
+	}
+	return node, nil
+}
+
+func (r *repoT) GetNodeMetadata(uuid dvid.UUID) (Metadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	node, err := r.getNode(uuid)
+	if err != nil {
+		return Metadata{}, err
+	}
+	node.Lock()
+	defer node.Unlock()
+	return newMetadata(node.note, node.tags, node.provenance), nil
+}
+
+// modifyNode changes a node's metadata, which can be changed even if the node is locked,
+// and then saves the repo.
+func (r *repoT) modifyNode(uuid dvid.UUID, modify func(node *nodeT)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	node, err := r.getNode(uuid)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	node.Lock()
+	modify(node)
+	node.updated = now
+	node.Unlock()
+	r.updated = now
+	return r.save()
+}
+
+func (r *repoT) SetNodeNote(uuid dvid.UUID, note string) error {
+	return r.modifyNode(uuid, func(node *nodeT) {
+		node.note = note
+	})
+}
+
+func (r *repoT) SetNodeTags(uuid dvid.UUID, changes map[string]*string) error {
+	if err := checkTags(changes); err != nil {
+		return err
+	}
+	return r.modifyNode(uuid, func(node *nodeT) {
+		node.tags = applyTags(node.tags, changes)
+	})
+}
+
+func (r *repoT) AddNodeProvenance(uuid dvid.UUID, entry Provenance) error {
+	if err := checkProvenance(&entry); err != nil {
+		return err
+	}
+	return r.modifyNode(uuid, func(node *nodeT) {
+		node.provenance = append(node.provenance, entry)
+	})
+}
@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyTags(t *testing.T) {
+	fly, optic := "fly", "optic lobe"
+	tags := applyTags(nil, map[string]*string{"species": &fly, "region": &optic})
+	expected := map[string]string{"species": "fly", "region": "optic lobe"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected tags %v, got %v\n", expected, tags)
+	}
+	tags = applyTags(tags, map[string]*string{"region": nil, "missing": nil})
+	expected = map[string]string{"species": "fly"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected tags %v after deletion, got %v\n", expected, tags)
+	}
+	if err := checkTags(map[string]*string{"": &fly}); err == nil {
+		t.Errorf("Expected error for empty tag key\n")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	entry := Provenance{User: "alice"}
+	if err := checkProvenance(&entry); err == nil {
+		t.Errorf("Expected error for provenance without operation\n")
+	}
+	entry.Operation = "load"
+	if err := checkProvenance(&entry); err != nil {
+		t.Fatalf("Unexpected error checking provenance: %s\n", err.Error())
+	}
+	if entry.Time.IsZero() {
+		t.Errorf("Expected provenance time to be set\n")
+	}
+
+	// Metadata is copied so later changes aren't seen.
+	provenance := []Provenance{entry}
+	md := newMetadata("note", map[string]string{"a": "b"}, provenance)
+	provenance[0].User = "bob"
+	if md.Provenance[0].User != "alice" {
+		t.Errorf("Expected copied provenance, got %v\n", md.Provenance)
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	var quota StorageQuota
+	if quota.Exceeded(1 << 40) {
+		t.Errorf("Expected zero quota to be unlimited\n")
+	}
+	quota.MaxBytes = 1000
+	if quota.Exceeded(999) || !quota.Exceeded(1000) {
+		t.Errorf("Expected quota of 1000 bytes to be reached at 1000 bytes\n")
+	}
+	quota.Override = true
+	if quota.Exceeded(2000) {
+		t.Errorf("Expected overridden quota to allow writes\n")
+	}
+}
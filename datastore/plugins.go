@@ -0,0 +1,116 @@
+/*
+	This file loads datatypes from Go plugins so datatypes can be added to a server without
+	recompiling it.  A plugin is a datatype package built with "go build -buildmode=plugin"
+	against the same DVID source as the server.  Like compiled-in datatypes, the package
+	registers its types via Register() in its init(), which runs when the plugin is opened.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"plugin"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// TypeSource describes an available datatype and whether it's compiled in or loaded
+// from a plugin.
+type TypeSource struct {
+	Name    dvid.TypeString
+	URL     dvid.URLString
+	Version string
+
+	// Plugin is the path of the plugin providing the type, or empty if compiled in.
+	Plugin string `json:",omitempty"`
+}
+
+var (
+	pluginMu sync.Mutex
+
+	// loadingPlugin collects the types registered while a plugin is opened.
+	loadingPlugin *typePlugin
+
+	// pluginTypes gives the plugin path of each type loaded from a plugin.
+	pluginTypes = make(map[dvid.URLString]string)
+)
+
+type typePlugin struct {
+	types    []TypeService
+	conflict error
+}
+
+// registerPluginType records a type registered by a plugin being opened or the first
+// conflict with an available type.
+func registerPluginType(t TypeService) {
+	if loadingPlugin.conflict != nil {
+		return
+	}
+	dtype := t.GetType()
+	if dtype.Name == "" || dtype.URL == "" || dtype.Version == "" {
+		loadingPlugin.conflict = fmt.Errorf("datatype %q (%s) must have a name, URL, and version",
+			dtype.Name, dtype.URL)
+		return
+	}
+	for url, typeservice := range Compiled {
+		if url == dtype.URL || typeservice.GetType().Name == dtype.Name {
+			loadingPlugin.conflict = fmt.Errorf("datatype %q (%s) conflicts with available datatype %q (%s)",
+				dtype.Name, dtype.URL, typeservice.GetType().Name, url)
+			return
+		}
+	}
+	for _, loaded := range loadingPlugin.types {
+		if loaded.GetType().URL == dtype.URL || loaded.GetType().Name == dtype.Name {
+			loadingPlugin.conflict = fmt.Errorf("datatype %q (%s) registered twice", dtype.Name, dtype.URL)
+			return
+		}
+	}
+	loadingPlugin.types = append(loadingPlugin.types, t)
+}
+
+// LoadTypePlugin opens a Go plugin and registers the datatypes it provides.  It must be
+// called before the datastore is initialized so repos using the types can be loaded.
+// An error is returned if the plugin registers no datatypes or a datatype with the same
+// name or URL as an available one, in which case none of its datatypes are registered.
+func LoadTypePlugin(path string) ([]TypeService, error) {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+
+	if Compiled == nil {
+		Compiled = make(map[dvid.URLString]TypeService)
+	}
+	loadingPlugin = new(typePlugin)
+	_, err := plugin.Open(path)
+	loaded := loadingPlugin
+	loadingPlugin = nil
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open datatype plugin %q: %s", path, err.Error())
+	}
+	if loaded.conflict != nil {
+		return nil, fmt.Errorf("Bad datatype plugin %q: %s", path, loaded.conflict.Error())
+	}
+	if len(loaded.types) == 0 {
+		return nil, fmt.Errorf("Datatype plugin %q registered no datatypes", path)
+	}
+	for _, t := range loaded.types {
+		Compiled[t.GetType().URL] = t
+		pluginTypes[t.GetType().URL] = path
+		dvid.Infof("Loaded datatype %q version %s (%s) from plugin %s\n",
+			t.GetType().Name, t.GetType().Version, t.GetType().URL, path)
+	}
+	return loaded.types, nil
+}
+
+// AvailableTypes returns the datatypes compiled into this server or loaded from plugins.
+func AvailableTypes() []TypeSource {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+
+	var types []TypeSource
+	for url, typeservice := range Compiled {
+		t := typeservice.GetType()
+		types = append(types, TypeSource{t.Name, url, t.Version, pluginTypes[url]})
+	}
+	return types
+}
@@ -0,0 +1,34 @@
+package datastore
+
+import "testing"
+
+func TestRegisterPluginTypes(t *testing.T) {
+	compiled := &endpointTestType{Type{Name: "plugintest", URL: "foo.bar.baz/plugintest", Version: "1.0"}}
+	Register(compiled)
+	defer delete(Compiled, compiled.URL)
+
+	loadingPlugin = new(typePlugin)
+	defer func() { loadingPlugin = nil }()
+	added := &endpointTestType{Type{Name: "pluginadded", URL: "foo.bar.baz/pluginadded", Version: "0.1"}}
+	Register(added)
+	if loadingPlugin.conflict != nil {
+		t.Fatalf("Unexpected conflict registering plugin type: %s\n", loadingPlugin.conflict.Error())
+	}
+	if len(loadingPlugin.types) != 1 || loadingPlugin.types[0] != added {
+		t.Errorf("Expected plugin type to be recorded, got %v\n", loadingPlugin.types)
+	}
+	if _, found := Compiled[added.URL]; found {
+		t.Errorf("Plugin type registered before plugin finished loading\n")
+	}
+
+	Register(&endpointTestType{Type{Name: "plugintest", URL: "other.url/plugintest", Version: "2.0"}})
+	if loadingPlugin.conflict == nil {
+		t.Errorf("Expected conflict registering plugin type with name of compiled type\n")
+	}
+
+	loadingPlugin = new(typePlugin)
+	Register(&endpointTestType{Type{Name: "noversion", URL: "foo.bar.baz/noversion"}})
+	if loadingPlugin.conflict == nil {
+		t.Errorf("Expected error registering plugin type without version\n")
+	}
+}
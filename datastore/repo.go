@@ -11,6 +11,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
@@ -20,6 +21,21 @@ var (
 	ErrModifyLockedNode = errors.New("can't modify locked node")
 )
 
+// NodeCommit describes the commit of a node, after which the node is locked and its
+// versioned data can't be modified.
+type NodeCommit struct {
+	UUID      dvid.UUID
+	Locked    bool
+	Message   string
+	Committer string
+
+	// Committed is the time of the commit, which is zero if the node isn't locked or was
+	// locked before commit times were recorded.
+	Committed time.Time
+
+	Log []string
+}
+
 // IDManager allows atomic ID incrementing across a DVID installation.  In the case
 // of a cluster of DVID servers using a common clustered DB, this requires
 // consensus between the DVID servers.
@@ -42,7 +58,8 @@ type IDManager interface {
 type RepoManager interface {
 	IDManager
 
-	// MatchingUUID returns version identifiers that uniquely matches a uuid string.
+	// MatchingUUID returns version identifiers that uniquely matches a uuid string or,
+	// if no UUID matches, the root of the repo with the string as alias.
 	MatchingUUID(uuidStr string) (dvid.UUID, dvid.VersionID, error)
 
 	// RepoFromUUID returns a Repo given a UUID.  Returns nil Repo if not found.
@@ -86,6 +103,22 @@ type Describer interface {
 
 	GetLog() ([]string, error)
 	AddToLog(hx string) error
+
+	// GetMetadata returns the note, tags, and provenance log.
+	GetMetadata() Metadata
+
+	SetNote(string) error
+
+	// SetTags sets tags to the given values or deletes tags given nil values.
+	SetTags(map[string]*string) error
+
+	// AddProvenance appends an entry to the provenance log, which can't be modified.
+	AddProvenance(Provenance) error
+
+	// GetStorageQuota returns the limit on storage used by the repo's data.
+	GetStorageQuota() StorageQuota
+
+	SetStorageQuota(StorageQuota) error
 }
 
 type Repo interface {
@@ -126,12 +159,48 @@ type Repo interface {
 	// an error if the parent node has not been locked.
 	NewVersion(dvid.UUID) (dvid.UUID, error)
 
+	// NewBranchVersion creates a new child node off a LOCKED parent node that starts
+	// a new named branch.  Children created with NewVersion stay on their parent's branch.
+	NewBranchVersion(parent dvid.UUID, branch string) (dvid.UUID, error)
+
+	// BranchHead returns the latest locked node on the named branch or, if open is true,
+	// the latest unlocked node.  The root's branch is DefaultBranch.
+	BranchHead(branch string, open bool) (dvid.UUID, error)
+
+	// Merge creates a new node whose parents are the given LOCKED nodes.  The new node
+	// is on the first parent's branch and, for data that are not Mergers, sees the first
+	// parent's data.  Returns a *MergeConflictError if data have conflicting changes.
+	Merge(parents []dvid.UUID, note string) (dvid.UUID, error)
+
 	// Save persists the repo to the MetaDataStore.
 	Save() error
 
 	// Lock "locks" the given node of the DAG to be read-only.
 	Lock(dvid.UUID) error
 
+	// CommitNode locks the given node of the DAG, recording a commit message and the
+	// committer in the node's commit metadata and log.  Returns ErrModifyLockedNode if
+	// the node is already locked.
+	CommitNode(uuid dvid.UUID, message, committer string) error
+
+	// GetNodeCommit returns the commit metadata of the given node of the DAG.
+	GetNodeCommit(dvid.UUID) (*NodeCommit, error)
+
+	// GetNodeMetadata returns the note, tags, and provenance log of the given node.
+	GetNodeMetadata(dvid.UUID) (Metadata, error)
+
+	SetNodeNote(uuid dvid.UUID, note string) error
+
+	// SetNodeTags sets tags of the given node to the given values or deletes tags given
+	// nil values.
+	SetNodeTags(uuid dvid.UUID, tags map[string]*string) error
+
+	// AddNodeProvenance appends an entry to the provenance log of the given node.
+	AddNodeProvenance(uuid dvid.UUID, entry Provenance) error
+
+	// Locked returns true if the given node of the DAG is read-only.
+	Locked(dvid.UUID) (bool, error)
+
 	gob.GobDecoder
 	gob.GobEncoder
 	json.Marshaler
@@ -16,6 +16,7 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 	"time"
@@ -77,6 +78,17 @@ func Create(path string, metadata bool, config dvid.Config) error {
 		return err
 	}
 
+	// Key compression changes the stored key format so must be set before any data is stored.
+	compressKeys, _, err := config.GetBool("CompressKeys")
+	if err != nil {
+		return err
+	}
+	if compressKeys {
+		if err = storage.SetKeyCompression(kvEngine); err != nil {
+			return err
+		}
+	}
+
 	// Put a blank RepoManager onto the key value store.
 	if err = InitMetadata(kvEngine); err != nil {
 		return err
@@ -144,6 +156,16 @@ func Initialize() error {
 	return nil
 }
 
+// ReloadMetadata replaces the repositories manager with one loaded from the MetaData
+// store, e.g., after another server sharing the store modified repos.
+func ReloadMetadata() error {
+	if err := Initialize(); err != nil {
+		return err
+	}
+	dvid.Infof("Reloaded repo metadata.\n")
+	return nil
+}
+
 // ---- RepoManager persistence to MetaData storage -----
 
 func (m *repoManager) loadData(t keyType, data interface{}) (found bool, err error) {
@@ -278,6 +300,11 @@ func (m *repoManager) loadMetadata() error {
 			return fmt.Errorf("Error gob decoding repo %d: %s", index.repoID, err.Error())
 		}
 		repo.manager = m
+		for _, dataservice := range repo.data {
+			registerEncryption(dataservice)
+			registerDurability(dataservice)
+			registerChecksum(dataservice)
+		}
 		// Cache all UUID from nodes into our high-level cache
 		for versionID, node := range repo.dag.nodes {
 			uuid, found := m.versionToUUID[versionID]
@@ -305,9 +332,22 @@ func (m *repoManager) loadMetadata() error {
 	return nil
 }
 
-// TODO: Verify that the datatypes used by the repo data have been compiled into this server.
+// verifyCompiledTypes checks that the datatypes used by the repo data are compiled into
+// this server or loaded from plugins.  Data created with another version of its datatype
+// is logged since the datatype may not read it correctly.
 func (m *repoManager) verifyCompiledTypes() error {
-	// Iterate over all data in all repo and check if present in Compiled
+	for _, repo := range m.repos {
+		for name, dataservice := range repo.data {
+			typeservice, err := TypeServiceByURL(dataservice.TypeURL())
+			if err != nil {
+				return fmt.Errorf("Data %q in repo %s: %s", name, repo.rootID, err.Error())
+			}
+			if version := typeservice.GetType().Version; version != dataservice.TypeVersion() {
+				dvid.Errorf("Data %q in repo %s was created with datatype %s version %s but version %s is available\n",
+					name, repo.rootID, dataservice.TypeURL(), dataservice.TypeVersion(), version)
+			}
+		}
+	}
 	return nil
 }
 
@@ -471,7 +511,8 @@ func (m *repoManager) MarshalJSON() ([]byte, error) {
 // string. Partial matches are accepted as long as they are unique for a datastore.  So if
 // a datastore has nodes with UUID strings 3FA22..., 7CD11..., and 836EE...,
 // we can still find a match even if given the minimum 3 letters.  (We don't
-// allow UUID strings of less than 3 letters just to prevent mistakes.)
+// allow UUID strings of less than 3 letters just to prevent mistakes.)  If no UUID
+// matches, the string can be a repo alias, which matches the root of the repo.
 func (m *repoManager) MatchingUUID(str string) (dvid.UUID, dvid.VersionID, error) {
 	var bestVersion dvid.VersionID
 	var bestUUID dvid.UUID
@@ -487,11 +528,62 @@ func (m *repoManager) MatchingUUID(str string) (dvid.UUID, dvid.VersionID, error
 	if numMatches > 1 {
 		err = fmt.Errorf("More than one UUID matches %s!", str)
 	} else if numMatches == 0 {
-		err = fmt.Errorf("Could not find UUID with partial match to %s!", str)
+		r, aliasErr := m.repoWithAlias(str)
+		if aliasErr != nil {
+			return dvid.NilUUID, 0, aliasErr
+		}
+		if r == nil {
+			return dvid.NilUUID, 0, fmt.Errorf("Could not find UUID with partial match to %s!", str)
+		}
+		versionID, found := m.UUIDToVersion[r.rootID]
+		if !found {
+			return dvid.NilUUID, 0, fmt.Errorf("Root %s of repo with alias %q has no version", r.rootID, str)
+		}
+		return r.rootID, versionID, nil
 	}
 	return bestUUID, bestVersion, err
 }
 
+// repoWithAlias returns the repo with the given alias or nil if there's none.  Aliases
+// set before they were required to be unique can match more than one repo, which is an
+// error.
+func (m *repoManager) repoWithAlias(alias string) (*repoT, error) {
+	if alias == "" {
+		return nil, nil
+	}
+	var match *repoT
+	for _, root := range m.repoToUUID {
+		r, found := m.repos[root]
+		if !found || r.GetAlias() != alias {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("More than one repo has alias %q", alias)
+		}
+		match = r
+	}
+	return match, nil
+}
+
+// aliasMu serializes alias changes so aliases stay unique.
+var aliasMu sync.Mutex
+
+// checkAliasAvailable returns an error if an alias is invalid or used by a repo other
+// than the given one.
+func (m *repoManager) checkAliasAvailable(alias string, r *repoT) error {
+	if err := CheckAlias(alias); err != nil {
+		return err
+	}
+	other, err := m.repoWithAlias(alias)
+	if err != nil {
+		return err
+	}
+	if other != nil && other != r {
+		return fmt.Errorf("Alias %q is already used by repo with root %s", alias, other.rootID)
+	}
+	return nil
+}
+
 // RepoFromUUID returns a repo given a UUID.  It will return nil if not found.
 func (m *repoManager) RepoFromUUID(uuid dvid.UUID) (Repo, error) {
 	repo, found := m.repos[uuid]
@@ -514,10 +606,28 @@ func (m *repoManager) RepoFromID(repoID dvid.RepoID) (Repo, error) {
 	return repo, nil
 }
 
+// repoList returns each repo once.
+func (m *repoManager) repoList() []*repoT {
+	m.Lock()
+	defer m.Unlock()
+	seen := make(map[*repoT]bool, len(m.repos))
+	repos := make([]*repoT, 0, len(m.repos))
+	for _, r := range m.repos {
+		if !seen[r] {
+			seen[r] = true
+			repos = append(repos, r)
+		}
+	}
+	return repos
+}
+
 // NewRepo creates a new Repo with a unique UUID
 func (m *repoManager) NewRepo(alias, description string) (Repo, error) {
 	m.Lock()
 	defer m.Unlock()
+	if err := m.checkAliasAvailable(alias, nil); err != nil {
+		return nil, err
+	}
 	repo, _, err := newRepo(m)
 	if err != nil {
 		return nil, err
@@ -546,6 +656,14 @@ func (m *repoManager) AddRepo(repo Repo) error {
 
 	r.manager = m
 
+	// A pushed repo keeps its alias unless a repo here already uses it.
+	aliasMu.Lock()
+	if err := m.checkAliasAvailable(r.alias, r); err != nil {
+		dvid.Errorf("Removing alias of added repo %s: %s\n", r.rootID, err.Error())
+		r.alias = ""
+	}
+	aliasMu.Unlock()
+
 	// Persist the changes
 	if err := m.putCaches(); err != nil {
 		return err
@@ -594,14 +712,23 @@ type repoT struct {
 	rootID dvid.UUID
 
 	// alias is an optional user-supplied string to identify this repo
-	// in a more friendly way than a UUID.  There are no guarantees that
-	// this string is unique across all repos.
+	// in a more friendly way than a UUID.  It can be used in place of the
+	// root UUID and is unique across repos, although aliases set by older
+	// servers may not be.
 	alias       string
 	description string
 	log         []string
 
 	properties map[string]interface{}
 
+	// Descriptive metadata, where the provenance log is append-only.
+	note       string
+	tags       map[string]string
+	provenance []Provenance
+
+	// Optional limit on storage used by the repo's data.
+	quota StorageQuota
+
 	created time.Time
 	updated time.Time
 
@@ -650,10 +777,29 @@ func (r *repoT) GetAlias() string {
 	return r.alias
 }
 
+// SetAlias sets or, given an empty string, removes the alias of the repo.  An error is
+// returned if the alias isn't valid or is used by another repo.
 func (r *repoT) SetAlias(alias string) error {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+	if r.manager != nil {
+		if err := r.manager.checkAliasAvailable(alias, r); err != nil {
+			return err
+		}
+	} else if err := CheckAlias(alias); err != nil {
+		return err
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if alias == r.alias {
+		return nil
+	}
+	prev := r.alias
 	r.alias = alias
+	if prev != "" {
+		return r.addToLog(fmt.Sprintf("Changed alias from %q to %q", prev, alias))
+	}
 	r.updated = time.Now()
 	return r.save()
 }
@@ -746,6 +892,19 @@ func (r *repoT) GobDecode(b []byte) error {
 	if err := dec.Decode(&(r.dag)); err != nil {
 		return err
 	}
+	// Repos stored before metadata was added won't have any.
+	if err := dec.Decode(&(r.note)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(r.tags)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(r.provenance)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(r.quota)); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -782,26 +941,46 @@ func (r *repoT) GobEncode() ([]byte, error) {
 	if err := enc.Encode(r.dag); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(r.note); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(r.tags); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(r.provenance); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(r.quota); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
 func (r *repoT) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Root        dvid.UUID
-		Alias       string
-		Description string
-		Log         []string
-		Properties  map[string]interface{}
-		Data        map[dvid.DataString]DataService `json:"DataInstances"`
-		DAG         *dagT
-		Created     time.Time
-		Updated     time.Time
+		Root         dvid.UUID
+		Alias        string
+		Description  string
+		Log          []string
+		Properties   map[string]interface{}
+		Note         string
+		Tags         map[string]string
+		Provenance   []Provenance
+		StorageQuota StorageQuota
+		Data         map[dvid.DataString]DataService `json:"DataInstances"`
+		DAG          *dagT
+		Created      time.Time
+		Updated      time.Time
 	}{
 		r.rootID,
 		r.alias,
 		r.description,
 		r.log,
 		r.properties,
+		r.note,
+		r.tags,
+		r.provenance,
+		r.quota,
 		r.data,
 		r.dag,
 		r.created,
@@ -844,6 +1023,30 @@ func (r *repoT) GetIterator(versionID dvid.VersionID) (storage.VersionIterator,
 }
 
 func (r *repoT) NewData(t TypeService, name dvid.DataString, c dvid.Config) (DataService, error) {
+	dataservice, err := r.newData(t, name, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := onCreate(r, dataservice); err != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if delErr := storage.DeleteDataInstance(dataservice.InstanceID()); delErr != nil {
+			dvid.Errorf("Unable to delete partial data %q: %s\n", name, delErr.Error())
+		}
+		delete(r.data, name)
+		actionMsg := fmt.Sprintf("Remove data instance %q after failed initialization", name)
+		if logErr := r.addToLog(actionMsg); logErr != nil {
+			return nil, logErr
+		}
+		if saveErr := r.save(); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, err
+	}
+	return dataservice, nil
+}
+
+func (r *repoT) newData(t TypeService, name dvid.DataString, c dvid.Config) (DataService, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	// Only allow unique data name per repo
@@ -881,13 +1084,20 @@ func (r *repoT) ModifyData(name dvid.DataString, config dvid.Config) error {
 // DeleteDataByName deletes all data associated with the data instance and removes
 // it from the Repo.
 func (r *repoT) DeleteDataByName(name dvid.DataString) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	dataservice, err := r.getDataByName(name)
+	dataservice, err := r.GetDataByName(name)
 	if err != nil {
 		return err
 	}
+	if err := onDelete(r, dataservice); err != nil {
+		return err
+	}
+	stopSyncs(dataservice.InstanceID())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data[dataservice.DataName()] != dataservice {
+		return fmt.Errorf("Data instance %q was deleted during cleanup", name)
+	}
 
 	// For all data tiers of storage, remove data key-value pairs that would be associated with this instance id.
 	if err = storage.DeleteDataInstance(dataservice.InstanceID()); err != nil {
@@ -907,6 +1117,175 @@ func (r *repoT) DeleteDataByName(name dvid.DataString) error {
 func (r *repoT) NewVersion(uuid dvid.UUID) (dvid.UUID, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.newVersion(uuid, "")
+}
+
+func (r *repoT) NewBranchVersion(uuid dvid.UUID, branch string) (dvid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if branch == "" {
+		return dvid.NilUUID, fmt.Errorf("Branch name cannot be empty")
+	}
+	if strings.Contains(branch, ":") || strings.Contains(branch, "/") {
+		return dvid.NilUUID, fmt.Errorf("Branch name %q cannot contain ':' or '/'", branch)
+	}
+	for _, node := range r.dag.nodes {
+		if node.branchName() == branch {
+			return dvid.NilUUID, fmt.Errorf("Branch %q already exists in repo (root %s)", branch, r.rootID)
+		}
+	}
+	return r.newVersion(uuid, branch)
+}
+
+func (r *repoT) BranchHead(branch string, open bool) (dvid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var head *nodeT
+	var branchFound bool
+	for _, node := range r.dag.nodes {
+		if node.branchName() != branch {
+			continue
+		}
+		branchFound = true
+		if node.locked == open {
+			continue
+		}
+		if head == nil || node.created.After(head.created) {
+			head = node
+		}
+	}
+	if !branchFound {
+		return dvid.NilUUID, fmt.Errorf("No branch %q in repo (root %s)", branch, r.rootID)
+	}
+	if head == nil {
+		if open {
+			return dvid.NilUUID, fmt.Errorf("No open node on branch %q", branch)
+		}
+		return dvid.NilUUID, fmt.Errorf("No locked node on branch %q", branch)
+	}
+	return head.uuid, nil
+}
+
+func (r *repoT) Merge(parents []dvid.UUID, note string) (dvid.UUID, error) {
+	r.mu.Lock()
+	merge, err := r.newVersionMerge(parents)
+	mergers := make(map[dvid.DataString]Merger)
+	for name, dataservice := range r.data {
+		if merger, ok := dataservice.(Merger); ok {
+			mergers[name] = merger
+		}
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+
+	// Check all data for conflicting changes before modifying the DAG.  The repo lock
+	// isn't held since data may need to traverse versions.
+	conflicts := make(map[dvid.DataString][]string)
+	for name, merger := range mergers {
+		dataConflicts, err := merger.MergeConflicts(merge)
+		if err != nil {
+			return dvid.NilUUID, fmt.Errorf("Error checking merge conflicts for data %q: %s", name, err.Error())
+		}
+		if len(dataConflicts) != 0 {
+			conflicts[name] = dataConflicts
+		}
+	}
+	if len(conflicts) != 0 {
+		return dvid.NilUUID, &MergeConflictError{conflicts}
+	}
+
+	r.mu.Lock()
+	childNode, err := r.addNode()
+	if err != nil {
+		r.mu.Unlock()
+		return dvid.NilUUID, err
+	}
+	childNode.parents = merge.Parents
+	childNode.branch = r.dag.nodes[merge.Parents[0]].branch
+	childNode.note = note
+	r.dag.nodes[childNode.versionID] = childNode
+	for _, parentID := range merge.Parents {
+		parentNode := r.dag.nodes[parentID]
+		parentNode.Lock()
+		parentNode.children = append(parentNode.children, childNode.versionID)
+		parentNode.updated = time.Now()
+		parentNode.Unlock()
+	}
+	merge.Merged = childNode.versionID
+	err = r.addToLog(fmt.Sprintf("Merged versions %v into %s", parents, childNode.uuid))
+	r.mu.Unlock()
+	if err != nil {
+		return dvid.NilUUID, err
+	}
+
+	for name, merger := range mergers {
+		if err := merger.MergeVersions(merge); err != nil {
+			return childNode.uuid, fmt.Errorf("Error merging data %q into %s: %s", name, childNode.uuid, err.Error())
+		}
+	}
+	return childNode.uuid, nil
+}
+
+// newVersionMerge checks the parents of a merge and finds their common ancestor.
+func (r *repoT) newVersionMerge(parents []dvid.UUID) (*VersionMerge, error) {
+	if len(parents) < 2 {
+		return nil, fmt.Errorf("Merge requires at least two parents, got %d", len(parents))
+	}
+	merge := &VersionMerge{
+		Parents: make([]dvid.VersionID, len(parents)),
+		paths:   make(map[dvid.VersionID][]dvid.VersionID, len(parents)),
+		common:  make(map[dvid.VersionID]bool),
+	}
+	var shared map[dvid.VersionID]bool
+	for i, uuid := range parents {
+		versionID, found := r.manager.UUIDToVersion[uuid]
+		if !found {
+			return nil, fmt.Errorf("No parent version found with uuid %s", uuid)
+		}
+		node, found := r.dag.nodes[versionID]
+		if !found {
+			return nil, fmt.Errorf("Parent %s is not in repo (root %s)", uuid, r.rootID)
+		}
+		if !node.locked {
+			return nil, fmt.Errorf("Cannot merge unlocked parent node %s", uuid)
+		}
+		if _, found := merge.paths[versionID]; found {
+			return nil, fmt.Errorf("Parent %s given more than once for merge", uuid)
+		}
+		merge.Parents[i] = versionID
+		merge.paths[versionID] = r.dag.firstParentPath(versionID)
+
+		ancestors := r.dag.ancestors(versionID)
+		if shared == nil {
+			shared = ancestors
+			continue
+		}
+		for v := range shared {
+			if !ancestors[v] {
+				delete(shared, v)
+			}
+		}
+	}
+
+	// The common ancestor is the most recently created version shared by all parents.
+	var ancestor *nodeT
+	for v := range shared {
+		node := r.dag.nodes[v]
+		if ancestor == nil || node.created.After(ancestor.created) {
+			ancestor = node
+		}
+	}
+	if ancestor == nil {
+		return nil, fmt.Errorf("Parents %v have no common ancestor", parents)
+	}
+	merge.Ancestor = ancestor.versionID
+	merge.common = r.dag.ancestors(ancestor.versionID)
+	return merge, nil
+}
+
+func (r *repoT) newVersion(uuid dvid.UUID, branch string) (dvid.UUID, error) {
 	// Make sure parent is available and locked.
 	parentVersionID, found := r.manager.UUIDToVersion[uuid]
 	if !found {
@@ -918,7 +1297,7 @@ func (r *repoT) NewVersion(uuid dvid.UUID) (dvid.UUID, error) {
 			parentVersionID)
 	}
 	if !parentNode.locked {
-		return dvid.NilUUID, fmt.Errorf("Cannot create child on unlocked parent node %s", uuid)
+		return dvid.NilUUID, fmt.Errorf("Cannot create child on unlocked parent node %s: commit it first", uuid)
 	}
 
 	// Add the child node.  Since it's new and unavailable, no need to lock it.
@@ -927,6 +1306,10 @@ func (r *repoT) NewVersion(uuid dvid.UUID) (dvid.UUID, error) {
 		return dvid.NilUUID, err
 	}
 	childNode.parents = []dvid.VersionID{parentVersionID}
+	childNode.branch = parentNode.branch
+	if branch != "" {
+		childNode.branch = branch
+	}
 	r.dag.nodes[childNode.versionID] = childNode
 
 	parentNode.Lock()
@@ -944,20 +1327,108 @@ func (r *repoT) Save() error {
 	return r.save()
 }
 
+// Lock commits the node with given UUID without a commit message.
 func (r *repoT) Lock(uuid dvid.UUID) error {
+	_, err := r.commitNode(uuid, "", "")
+	return err
+}
+
+// CommitNode commits the node with given UUID, which must not already be locked.
+func (r *repoT) CommitNode(uuid dvid.UUID, message, committer string) error {
+	wasLocked, err := r.commitNode(uuid, message, committer)
+	if err == nil && wasLocked {
+		return ErrModifyLockedNode
+	}
+	return err
+}
+
+// commitNode locks the node with given UUID, records its commit metadata, and then calls
+// the commit hooks of all data if the node wasn't already locked.  A locked node is left
+// unchanged.
+func (r *repoT) commitNode(uuid dvid.UUID, message, committer string) (wasLocked bool, err error) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	versionID, found := r.manager.UUIDToVersion[uuid]
 	if !found {
-		return fmt.Errorf("Could not LOCK missing version (uuid %s)", uuid)
+		r.mu.Unlock()
+		return false, fmt.Errorf("Could not LOCK missing version (uuid %s)", uuid)
 	}
 	node, found := r.dag.nodes[versionID]
 	if !found {
-		return fmt.Errorf("Could not LOCK missing version (id %d)", versionID)
+		r.mu.Unlock()
+		return false, fmt.Errorf("Could not LOCK missing version (id %d)", versionID)
 	}
+	if node.locked {
+		r.mu.Unlock()
+		return true, nil
+	}
+	now := time.Now()
+	entry := "Committed"
+	if committer != "" {
+		entry += " by " + committer
+	}
+	if message != "" {
+		entry += ": " + message
+	}
+	node.Lock()
 	node.locked = true
-	r.updated = time.Now()
-	return r.save()
+	node.commitMessage = message
+	node.committer = committer
+	node.committed = now
+	node.log = append(node.log, entry)
+	node.updated = now
+	node.Unlock()
+	r.updated = now
+	if err := r.save(); err != nil {
+		r.mu.Unlock()
+		return false, err
+	}
+	data := make([]DataService, 0, len(r.data))
+	for _, dataservice := range r.data {
+		data = append(data, dataservice)
+	}
+	r.mu.Unlock()
+
+	onVersionCommit(r, data, uuid, versionID)
+	return false, nil
+}
+
+func (r *repoT) GetNodeCommit(uuid dvid.UUID) (*NodeCommit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versionID, found := r.manager.UUIDToVersion[uuid]
+	if !found {
+		return nil, fmt.Errorf("No version found with uuid %s", uuid)
+	}
+	node, found := r.dag.nodes[versionID]
+	if !found {
+		return nil, fmt.Errorf("No version found with id %d", versionID)
+	}
+	node.Lock()
+	defer node.Unlock()
+	log := make([]string, len(node.log))
+	copy(log, node.log)
+	return &NodeCommit{
+		UUID:      uuid,
+		Locked:    node.locked,
+		Message:   node.commitMessage,
+		Committer: node.committer,
+		Committed: node.committed,
+		Log:       log,
+	}, nil
+}
+
+func (r *repoT) Locked(uuid dvid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versionID, found := r.manager.UUIDToVersion[uuid]
+	if !found {
+		return false, fmt.Errorf("No version found with uuid %s", uuid)
+	}
+	node, found := r.dag.nodes[versionID]
+	if !found {
+		return false, fmt.Errorf("No version found with id %d", versionID)
+	}
+	return node.locked, nil
 }
 
 func (r *repoT) Types() (map[dvid.URLString]TypeService, error) {
@@ -1055,6 +1526,9 @@ func (r *repoT) remapLocalIDs() (dvid.InstanceMap, dvid.VersionMap, error) {
 		}
 		instanceMap[dataservice.InstanceID()] = instanceID
 		r.data[dataname].SetInstanceID(instanceID)
+		registerEncryption(r.data[dataname])
+		registerDurability(r.data[dataname])
+		registerChecksum(r.data[dataname])
 	}
 
 	// Pass 1 on DAG: copy the nodes with new ids
@@ -1069,6 +1543,9 @@ func (r *repoT) remapLocalIDs() (dvid.InstanceMap, dvid.VersionMap, error) {
 		versionMap[oldVersionID] = newVersionID
 		newNodes[newVersionID] = nodePtr
 	}
+	for newVersionID, nodePtr := range newNodes {
+		nodePtr.versionID = newVersionID
+	}
 
 	// Pass 2 on DAG: now that we know the version mapping, modify all nodes.
 	for _, nodePtr := range r.dag.nodes {
@@ -1184,6 +1661,42 @@ func (dag *dagT) getIterator(versionID dvid.VersionID) (storage.VersionIterator,
 	return &versionIterator{dag, true, versionID, node}, nil
 }
 
+// ancestors returns the given version and all versions reachable through any parent.
+func (dag *dagT) ancestors(versionID dvid.VersionID) map[dvid.VersionID]bool {
+	ancestors := make(map[dvid.VersionID]bool)
+	queue := []dvid.VersionID{versionID}
+	for len(queue) != 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if ancestors[v] {
+			continue
+		}
+		node, found := dag.nodes[v]
+		if !found {
+			continue
+		}
+		ancestors[v] = true
+		queue = append(queue, node.parents...)
+	}
+	return ancestors
+}
+
+// firstParentPath returns the ancestor path traversed by a versionIterator.
+func (dag *dagT) firstParentPath(versionID dvid.VersionID) []dvid.VersionID {
+	var path []dvid.VersionID
+	for {
+		node, found := dag.nodes[versionID]
+		if !found {
+			return path
+		}
+		path = append(path, versionID)
+		if len(node.parents) == 0 {
+			return path
+		}
+		versionID = node.parents[0]
+	}
+}
+
 func (dag *dagT) deleteDataInstance(name dvid.DataString) {
 	for i, _ := range dag.nodes {
 		delete(dag.nodes[i].avail, name)
@@ -1215,6 +1728,26 @@ type nodeT struct {
 
 	created time.Time
 	updated time.Time
+
+	// branch is the name of the branch this node is on, where the empty string
+	// denotes DefaultBranch.
+	branch string
+
+	// Commit metadata recorded when the node was locked.
+	commitMessage string
+	committer     string
+	committed     time.Time
+
+	// Descriptive tags and append-only provenance log.
+	tags       map[string]string
+	provenance []Provenance
+}
+
+func (node *nodeT) branchName() string {
+	if node.branch == "" {
+		return DefaultBranch
+	}
+	return node.branch
 }
 
 func (node *nodeT) GobDecode(b []byte) error {
@@ -1256,6 +1789,26 @@ func (node *nodeT) GobDecode(b []byte) error {
 	if err := dec.Decode(&(node.updated)); err != nil {
 		return err
 	}
+	// Nodes stored before branch names were added won't have one.
+	if err := dec.Decode(&(node.branch)); err != nil && err != io.EOF {
+		return err
+	}
+	// Likewise for commit metadata.
+	if err := dec.Decode(&(node.commitMessage)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(node.committer)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(node.committed)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(node.tags)); err != nil && err != io.EOF {
+		return err
+	}
+	if err := dec.Decode(&(node.provenance)); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -1292,6 +1845,24 @@ func (node *nodeT) GobEncode() ([]byte, error) {
 	if err := enc.Encode(node.updated); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(node.branch); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(node.commitMessage); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(node.committer); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(node.committed); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(node.tags); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(node.provenance); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -1307,6 +1878,14 @@ func (node *nodeT) MarshalJSON() ([]byte, error) {
 		Children  []dvid.VersionID
 		Created   time.Time
 		Updated   time.Time
+		Branch    string
+
+		CommitMessage string
+		Committer     string
+		Committed     time.Time
+
+		Tags       map[string]string
+		Provenance []Provenance
 	}{
 		node.note,
 		node.log,
@@ -1318,6 +1897,12 @@ func (node *nodeT) MarshalJSON() ([]byte, error) {
 		node.children,
 		node.created,
 		node.updated,
+		node.branchName(),
+		node.commitMessage,
+		node.committer,
+		node.committed,
+		node.tags,
+		node.provenance,
 	})
 }
 
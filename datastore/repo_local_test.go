@@ -3,6 +3,7 @@
 package datastore
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
@@ -25,6 +26,7 @@ func TestRepoGobEncoding(t *testing.T) {
 			"bar": "some string",
 			"baz": []int{3, 9, 7},
 		},
+		quota:   StorageQuota{MaxBytes: 1 << 30},
 		dag:     &dagT{},
 		data:    make(map[dvid.DataString]DataService),
 		created: now,
@@ -63,6 +65,110 @@ func TestRepoGobEncoding(t *testing.T) {
 	}
 }
 
+func TestVersionMerge(t *testing.T) {
+	// Version 2 is the child of root 1 and branches into 3 and 4 -> 5.
+	repo := mockRepo()
+	repo.manager = &repoManager{UUIDToVersion: make(map[dvid.UUID]dvid.VersionID)}
+	repo.dag = &dagT{nodes: make(map[dvid.VersionID]*nodeT)}
+	parentOf := map[dvid.VersionID]dvid.VersionID{2: 1, 3: 2, 4: 2, 5: 4}
+	now := time.Now()
+	for v := dvid.VersionID(1); v <= 5; v++ {
+		uuid := dvid.UUID(fmt.Sprintf("uuid%d", v))
+		node := &nodeT{uuid: uuid, versionID: v, locked: true, created: now.Add(time.Duration(v) * time.Second)}
+		if parent, found := parentOf[v]; found {
+			node.parents = []dvid.VersionID{parent}
+		}
+		repo.dag.nodes[v] = node
+		repo.manager.UUIDToVersion[uuid] = v
+	}
+
+	merge, err := repo.newVersionMerge([]dvid.UUID{"uuid3", "uuid5"})
+	if err != nil {
+		t.Fatalf("Could not create version merge: %s\n", err.Error())
+	}
+	if merge.Ancestor != 2 {
+		t.Errorf("Expected common ancestor 2, got %d\n", merge.Ancestor)
+	}
+	if !reflect.DeepEqual(merge.paths[5], []dvid.VersionID{5, 4, 2, 1}) {
+		t.Errorf("Bad ancestor path for version 5: %v\n", merge.paths[5])
+	}
+
+	// Data stored at root and changed only on the second branch.
+	stored := func(v dvid.VersionID) bool { return v == 1 || v == 5 }
+	if v, found, changed := merge.Seen(3, stored); !found || changed || v != 1 {
+		t.Errorf("Expected version 3 to see unchanged root data, got %d, %t, %t\n", v, found, changed)
+	}
+	if v, found, changed := merge.Seen(5, stored); !found || !changed || v != 5 {
+		t.Errorf("Expected version 5 to see its changed data, got %d, %t, %t\n", v, found, changed)
+	}
+	if _, found, _ := merge.Seen(3, func(v dvid.VersionID) bool { return v == 4 }); found {
+		t.Errorf("Version 3 should not see data stored only on other branch\n")
+	}
+
+	if _, err := repo.newVersionMerge([]dvid.UUID{"uuid3"}); err == nil {
+		t.Errorf("Expected error merging a single parent\n")
+	}
+	if _, err := repo.newVersionMerge([]dvid.UUID{"uuid3", "uuid3"}); err == nil {
+		t.Errorf("Expected error merging duplicate parents\n")
+	}
+	repo.dag.nodes[5].locked = false
+	if _, err := repo.newVersionMerge([]dvid.UUID{"uuid3", "uuid5"}); err == nil {
+		t.Errorf("Expected error merging unlocked parent\n")
+	}
+}
+
+func TestRepoAlias(t *testing.T) {
+	for _, alias := range []string{"hemibrain", "fib-25.v2", "my_repo"} {
+		if err := CheckAlias(alias); err != nil {
+			t.Errorf("Expected alias %q to be valid: %s\n", alias, err.Error())
+		}
+	}
+	for _, alias := range []string{"3f8c", "1234", "has space", "a/b", "a:b"} {
+		if err := CheckAlias(alias); err == nil {
+			t.Errorf("Expected alias %q to be invalid\n", alias)
+		}
+	}
+
+	m := &repoManager{
+		repos:         make(map[dvid.UUID]*repoT),
+		repoToUUID:    make(map[dvid.RepoID]dvid.UUID),
+		UUIDToVersion: make(map[dvid.UUID]dvid.VersionID),
+	}
+	for i, alias := range []string{"hemibrain", "medulla"} {
+		r := mockRepo()
+		r.repoID = dvid.RepoID(i + 1)
+		r.rootID = dvid.UUID(fmt.Sprintf("%d0ab", i+1))
+		r.alias = alias
+		r.manager = m
+		m.repos[r.rootID] = r
+		m.repoToUUID[r.repoID] = r.rootID
+		m.UUIDToVersion[r.rootID] = dvid.VersionID(i + 1)
+	}
+
+	uuid, versionID, err := m.MatchingUUID("medulla")
+	if err != nil {
+		t.Fatalf("Could not match alias: %s\n", err.Error())
+	}
+	if uuid != "20ab" || versionID != 2 {
+		t.Errorf("Expected alias to match root 20ab, got %s (version %d)\n", uuid, versionID)
+	}
+	if _, _, err := m.MatchingUUID("optic"); err == nil {
+		t.Errorf("Expected error matching unknown alias\n")
+	}
+	if err := m.checkAliasAvailable("hemibrain", m.repos["20ab"]); err == nil {
+		t.Errorf("Expected error reusing alias of another repo\n")
+	}
+	if err := m.checkAliasAvailable("hemibrain", m.repos["10ab"]); err != nil {
+		t.Errorf("Expected repo's own alias to be available: %s\n", err.Error())
+	}
+
+	// Aliases set before uniqueness was enforced are ambiguous.
+	m.repos["20ab"].alias = "hemibrain"
+	if _, _, err := m.MatchingUUID("hemibrain"); err == nil {
+		t.Errorf("Expected error matching alias of more than one repo\n")
+	}
+}
+
 /*
 func TestNewDAG(t *testing.T) {
 	dag := NewVersionDAG()
@@ -125,3 +231,33 @@ func TestNewRepoDifferent(t *testing.T) {
 	c.Assert(root1, Not(Equals), root2)
 }
 */
+
+func TestNodeCommitGobEncoding(t *testing.T) {
+	node := &nodeT{
+		log:           []string{"Committed by alice: proofread region 3"},
+		avail:         map[dvid.DataString]DataAvail{},
+		uuid:          dvid.UUID("3f8c"),
+		versionID:     7,
+		locked:        true,
+		parents:       []dvid.VersionID{6},
+		children:      []dvid.VersionID{},
+		created:       time.Now().Add(-time.Hour),
+		updated:       time.Now(),
+		commitMessage: "proofread region 3",
+		committer:     "alice",
+		committed:     time.Now(),
+	}
+	encoding, err := node.GobEncode()
+	if err != nil {
+		t.Fatalf("Could not encode node: %s\n", err.Error())
+	}
+	received := &nodeT{}
+	if err = received.GobDecode(encoding); err != nil {
+		t.Fatalf("Could not decode node: %s\n", err.Error())
+	}
+	if received.commitMessage != node.commitMessage || received.committer != node.committer ||
+		!received.committed.Equal(node.committed) || !received.locked {
+		t.Errorf("Node Gob messed up commit: got %q by %q at %s\n", received.commitMessage,
+			received.committer, received.committed)
+	}
+}
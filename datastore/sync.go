@@ -0,0 +1,180 @@
+/*
+	This file supports syncing of data instances, where a data instance subscribes to the
+	mutations of other instances in its repo so it can update derived data, e.g., a graph
+	whose vertices are labels can merge vertices when labels are merged.  A data instance
+	that mutates calls NotifySubscribers, and each subscribed instance receives the
+	mutations in order through its own queue, handled in the background.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// SyncQueueSize is the number of mutations that can be queued for a subscribed data
+// instance before notification blocks.
+const SyncQueueSize = 1000
+
+// SyncMessage describes a mutation of a data instance for its subscribers.
+type SyncMessage struct {
+	// Event names the kind of mutation, e.g., "merge", as defined by the datatype of the
+	// mutated data.
+	Event string
+
+	// Version is the version that was mutated.
+	Version dvid.VersionID
+
+	// Delta is an event-specific description of the mutation.
+	Delta interface{}
+}
+
+// Syncer is implemented by data instances that can subscribe to mutations of other data
+// instances.  Data embedding *Data gets SyncedData.
+type Syncer interface {
+	// SyncedData returns the names of data instances this data is subscribed to.
+	SyncedData() []dvid.DataString
+
+	// CanSync returns true if this data can handle mutations of the given data.
+	CanSync(source DataService) bool
+
+	// HandleSync updates this data for a mutation of a subscribed data instance.
+	HandleSync(source DataService, msg SyncMessage) error
+}
+
+// SyncedData returns the names of data instances this data is subscribed to.
+func (d *Data) SyncedData() []dvid.DataString {
+	return d.syncs
+}
+
+func (d *Data) setSyncedData(names []dvid.DataString) {
+	d.syncs = names
+}
+
+// parseSyncs parses a comma-separated list of data names.
+func parseSyncs(s string) []dvid.DataString {
+	var names []dvid.DataString
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, dvid.DataString(name))
+		}
+	}
+	return names
+}
+
+// SetSyncs subscribes a data instance to the mutations of the given data instances in
+// its repo, replacing any previous subscriptions.  No data names removes all syncs.
+func SetSyncs(repo Repo, name dvid.DataString, sources []dvid.DataString) error {
+	dataservice, err := repo.GetDataByName(name)
+	if err != nil {
+		return err
+	}
+	syncer, ok := dataservice.(Syncer)
+	if !ok && len(sources) != 0 {
+		return fmt.Errorf("Data %q of type %q does not support syncs", name, dataservice.TypeName())
+	}
+	for _, sourceName := range sources {
+		if sourceName == name {
+			return fmt.Errorf("Data %q can't be synced with itself", name)
+		}
+		source, err := repo.GetDataByName(sourceName)
+		if err != nil {
+			return err
+		}
+		if !syncer.CanSync(source) {
+			return fmt.Errorf("Data %q can't sync with data %q of type %q", name, sourceName, source.TypeName())
+		}
+	}
+	setter, ok := dataservice.(interface {
+		setSyncedData([]dvid.DataString)
+	})
+	if !ok {
+		return fmt.Errorf("Data %q does not support syncs", name)
+	}
+	setter.setSyncedData(sources)
+	if err := repo.AddToLog(fmt.Sprintf("Set syncs of data %q to %v", name, sources)); err != nil {
+		return err
+	}
+	return repo.Save()
+}
+
+type syncDelivery struct {
+	source DataService
+	msg    SyncMessage
+}
+
+// syncQueues holds the queue of mutations for each subscribed data instance.
+var syncQueues = struct {
+	sync.Mutex
+	queues map[dvid.InstanceID]chan syncDelivery
+}{
+	queues: make(map[dvid.InstanceID]chan syncDelivery),
+}
+
+// queueSync queues a mutation for a subscribed data instance, starting delivery to the
+// instance if necessary.  The lock is held while queueing so a queue isn't closed while a
+// mutation is sent to it.
+func queueSync(subscriber DataService, syncer Syncer, delivery syncDelivery) {
+	syncQueues.Lock()
+	defer syncQueues.Unlock()
+	queue, found := syncQueues.queues[subscriber.InstanceID()]
+	if !found {
+		queue = make(chan syncDelivery, SyncQueueSize)
+		syncQueues.queues[subscriber.InstanceID()] = queue
+		go deliverSyncs(subscriber.DataName(), syncer, queue)
+	}
+	queue <- delivery
+}
+
+func deliverSyncs(name dvid.DataString, syncer Syncer, queue chan syncDelivery) {
+	for delivery := range queue {
+		if err := syncer.HandleSync(delivery.source, delivery.msg); err != nil {
+			dvid.Errorf("Data %q unable to sync %s of data %q: %s\n", name, delivery.msg.Event,
+				delivery.source.DataName(), err.Error())
+		}
+	}
+}
+
+// stopSyncs stops delivery of mutations to a data instance.
+func stopSyncs(id dvid.InstanceID) {
+	syncQueues.Lock()
+	defer syncQueues.Unlock()
+	if queue, found := syncQueues.queues[id]; found {
+		close(queue)
+		delete(syncQueues.queues, id)
+	}
+}
+
+// NotifySubscribers queues a mutation of a data instance for each data instance in its
+// repo subscribed to it.  It only blocks if a subscriber's queue is full.
+func NotifySubscribers(source DataService, msg SyncMessage) error {
+	uuid, err := UUIDFromVersion(msg.Version)
+	if err != nil {
+		return err
+	}
+	repo, err := RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	alldata, err := repo.GetAllData()
+	if err != nil {
+		return err
+	}
+	for _, dataservice := range alldata {
+		syncer, ok := dataservice.(Syncer)
+		if !ok {
+			continue
+		}
+		for _, name := range syncer.SyncedData() {
+			if name == source.DataName() {
+				queueSync(dataservice, syncer, syncDelivery{source, msg})
+				break
+			}
+		}
+	}
+	return nil
+}
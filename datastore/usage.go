@@ -0,0 +1,125 @@
+package datastore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// UsageReporter is implemented by repo managers that can report the storage used by
+// each data instance.
+type UsageReporter interface {
+	StorageUsage(scan bool) (*UsageReport, error)
+
+	// RepoStorageUsed returns the bytes stored by a repo's data instances.
+	RepoStorageUsed(Repo) (uint64, error)
+}
+
+// StorageQuota limits the storage used by the data instances of a repo.
+type StorageQuota struct {
+	// MaxBytes is the maximum bytes stored by the repo's data, or zero if unlimited.
+	MaxBytes uint64
+
+	// Override allows writes even if the quota is exceeded, e.g., so an admin can let
+	// an ingestion finish before cleaning up.
+	Override bool
+}
+
+// Exceeded returns true if writes should be rejected given the bytes used.
+func (q StorageQuota) Exceeded(used uint64) bool {
+	return q.MaxBytes != 0 && !q.Override && used >= q.MaxBytes
+}
+
+// QuotaExceededError is returned for writes rejected by a repo's storage quota.
+type QuotaExceededError struct {
+	Repo     dvid.UUID
+	Used     uint64
+	MaxBytes uint64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("Repo %s has used %d bytes of its %d byte storage quota", e.Repo, e.Used, e.MaxBytes)
+}
+
+// CheckStorageQuota returns a *QuotaExceededError if writes to the repo should be
+// rejected because its storage quota is reached.  All write paths, e.g., HTTP requests,
+// batch ingestion, and atomic commits, should call this before writing data of a repo.
+// Writes are allowed if the repo's usage can't be determined.
+func CheckStorageQuota(repo Repo) error {
+	quota := repo.GetStorageQuota()
+	if quota.MaxBytes == 0 || quota.Override {
+		return nil
+	}
+	used, err := RepoStorageUsed(repo)
+	if err != nil {
+		dvid.Errorf("Unable to check storage quota of repo %s: %s\n", repo.RootUUID(), err.Error())
+		return nil
+	}
+	if quota.Exceeded(used) {
+		return &QuotaExceededError{repo.RootUUID(), used, quota.MaxBytes}
+	}
+	return nil
+}
+
+// DataUsage is the storage used by a data instance.  Instances that are no longer in any
+// repo, e.g., after an interrupted deletion, have no repo or name.
+type DataUsage struct {
+	InstanceID dvid.InstanceID
+	Repo       dvid.UUID       `json:",omitempty"`
+	Name       dvid.DataString `json:",omitempty"`
+	TypeName   dvid.TypeString `json:",omitempty"`
+
+	Stored storage.UsageCount
+	Writes storage.WriteCount
+
+	// MinKey and MaxKey give the extent of the instance's keys in hexadecimal.
+	MinKey, MaxKey string
+
+	// Versions gives the usage of each version by UUID, or by version ID if the
+	// version is no longer in any repo.
+	Versions map[string]*storage.VersionUsage
+}
+
+// UsageReport gives the storage used by each data instance in order of decreasing
+// stored bytes.
+type UsageReport struct {
+	Scanned  time.Time
+	Updated  time.Time
+	Tracking bool
+	Data     []DataUsage
+}
+
+type dataUsageByBytes []DataUsage
+
+func (s dataUsageByBytes) Len() int           { return len(s) }
+func (s dataUsageByBytes) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s dataUsageByBytes) Less(i, j int) bool { return s[i].Stored.Bytes > s[j].Stored.Bytes }
+
+// StorageUsage returns the storage used by each data instance and version, scanning all
+// stored data first if requested.  Otherwise, the report cached from the last scan is
+// returned along with any writes since then if usage tracking is enabled.
+func StorageUsage(scan bool) (*UsageReport, error) {
+	if Manager == nil {
+		return nil, fmt.Errorf("datastore not initialized")
+	}
+	reporter, ok := Manager.(UsageReporter)
+	if !ok {
+		return nil, fmt.Errorf("Storage usage reports are not supported by this datastore")
+	}
+	return reporter.StorageUsage(scan)
+}
+
+// RepoStorageUsed returns the bytes stored by a repo's data instances as of the last
+// storage usage scan, plus bytes written since then if usage tracking is enabled.
+func RepoStorageUsed(repo Repo) (uint64, error) {
+	if Manager == nil {
+		return 0, fmt.Errorf("datastore not initialized")
+	}
+	reporter, ok := Manager.(UsageReporter)
+	if !ok {
+		return 0, fmt.Errorf("Storage usage reports are not supported by this datastore")
+	}
+	return reporter.RepoStorageUsed(repo)
+}
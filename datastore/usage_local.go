@@ -0,0 +1,78 @@
+// +build !clustered,!gcloud
+
+/*
+	This file names the data instances and versions of the storage usage report.
+*/
+
+package datastore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// StorageUsage returns the storage used by each named data instance and version.
+func (m *repoManager) StorageUsage(scan bool) (*UsageReport, error) {
+	stored, err := storage.StorageUsage(scan)
+	if err != nil {
+		return nil, err
+	}
+	instances := make(map[dvid.InstanceID]DataService)
+	roots := make(map[dvid.InstanceID]dvid.UUID)
+	for _, r := range m.repoList() {
+		r.mu.Lock()
+		for _, data := range r.data {
+			instances[data.InstanceID()] = data
+			roots[data.InstanceID()] = r.rootID
+		}
+		r.mu.Unlock()
+	}
+	report := &UsageReport{
+		Scanned:  stored.Scanned,
+		Updated:  stored.Updated,
+		Tracking: stored.Tracking,
+		Data:     make([]DataUsage, 0, len(stored.Instances)),
+	}
+	for instanceID, inst := range stored.Instances {
+		usage := DataUsage{
+			InstanceID: instanceID,
+			Stored:     inst.Stored,
+			Writes:     inst.Writes,
+			MinKey:     hex.EncodeToString(inst.MinKey),
+			MaxKey:     hex.EncodeToString(inst.MaxKey),
+			Versions:   make(map[string]*storage.VersionUsage, len(inst.Versions)),
+		}
+		if data, found := instances[instanceID]; found {
+			usage.Repo = roots[instanceID]
+			usage.Name = data.DataName()
+			usage.TypeName = data.TypeName()
+		}
+		for versionID, version := range inst.Versions {
+			if uuid, err := m.UUIDFromVersion(versionID); err == nil {
+				usage.Versions[string(uuid)] = version
+			} else {
+				usage.Versions[fmt.Sprintf("version %d", versionID)] = version
+			}
+		}
+		report.Data = append(report.Data, usage)
+	}
+	sort.Sort(dataUsageByBytes(report.Data))
+	return report, nil
+}
+
+// RepoStorageUsed returns the bytes stored by a repo's data instances.
+func (m *repoManager) RepoStorageUsed(repo Repo) (uint64, error) {
+	data, err := repo.GetAllData()
+	if err != nil {
+		return 0, err
+	}
+	instances := make([]dvid.InstanceID, 0, len(data))
+	for _, d := range data {
+		instances = append(instances, d.InstanceID())
+	}
+	return storage.UsageBytes(instances)
+}
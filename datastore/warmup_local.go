@@ -0,0 +1,83 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports warming storage caches after a restart so the first requests
+	after a deploy aren't dominated by cold-cache latency.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// dataByInstanceID returns the data instances of all repos by instance ID.
+func (m *repoManager) dataByInstanceID() map[dvid.InstanceID]DataService {
+	instances := make(map[dvid.InstanceID]DataService)
+	for _, r := range m.repoList() {
+		r.mu.Lock()
+		for _, data := range r.data {
+			instances[data.InstanceID()] = data
+		}
+		r.mu.Unlock()
+	}
+	return instances
+}
+
+// Warmup reads all metadata and then replays up to maxReads of the most frequent reads
+// before the last shutdown so their results are in the block cache.  Replay stops once
+// the timeout has elapsed if it is positive.  Reads of deleted data instances or
+// versions are skipped.
+func Warmup(maxReads int, timeout time.Duration) error {
+	m, ok := Manager.(*repoManager)
+	if !ok {
+		return fmt.Errorf("datastore not initialized")
+	}
+	timedLog := dvid.NewTimeLog()
+	numMetadata, err := storage.WarmMetadata()
+	if err != nil {
+		return err
+	}
+	reads, err := storage.LoadHotReads()
+	if err != nil {
+		return err
+	}
+	if len(reads) > maxReads {
+		reads = reads[:maxReads]
+	}
+
+	instances := m.dataByInstanceID()
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	var warmed, skipped int
+	for _, read := range reads {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			dvid.Infof("Cache warmup stopped after %s with %d reads left\n", timeout, len(reads)-warmed-skipped)
+			break
+		}
+		data, found := instances[read.InstanceID]
+		if !found {
+			skipped++
+			continue
+		}
+		if _, err := m.UUIDFromVersion(read.VersionID); err != nil {
+			skipped++
+			continue
+		}
+		if err := storage.WarmRead(NewVersionedContext(data, read.VersionID), read); err != nil {
+			dvid.Errorf("Error during cache warmup of %q: %s\n", data.DataName(), err.Error())
+			skipped++
+			continue
+		}
+		warmed++
+	}
+	timedLog.Infof("Cache warmup read %d metadata values and %d hot reads (%d skipped)",
+		numMetadata, warmed, skipped)
+	return nil
+}
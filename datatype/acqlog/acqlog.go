@@ -0,0 +1,536 @@
+/*
+	Package acqlog implements DVID support for logs of acquisition metadata, e.g., the stage
+	coordinates, focus scores, and timestamps recorded by a microscope for each section or
+	for each tile of a section.  Entries are JSON objects keyed by section Z and optionally
+	a tile column and row, and can be read by Z interval, so provenance of image data can be
+	kept alongside it in the same repo and version.
+*/
+package acqlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/message"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	Version  = "0.1"
+	RepoURL  = "github.com/janelia-flyem/dvid/datatype/acqlog"
+	TypeName = "acqlog"
+)
+
+const HelpMessage = `
+API for 'acqlog' datatype (github.com/janelia-flyem/dvid/datatype/acqlog)
+=========================================================================
+
+Command-line:
+
+$ dvid repo <UUID> new acqlog <data name> <settings...>
+
+	Adds newly named acquisition log data to repo with specified UUID.
+
+	Example:
+
+	$ dvid repo 3f8c new acqlog acquisition
+
+    Arguments:
+
+    UUID           Hexidecimal string with enough characters to uniquely identify a version node.
+    data name      Name of data to create, e.g., "acquisition"
+    settings       Configuration settings in "key=value" format separated by spaces.
+
+    Configuration Settings (case-insensitive keys)
+
+    Versioned      "true" or "false" (default)
+
+    ------------------
+
+HTTP API (Level 2 REST):
+
+Entries are JSON objects with any acquisition metadata, e.g., stage coordinates, focus
+scores, and timestamps.  Each entry describes a section at a Z or a tile of a section
+given by its column and row within the section.  Entries are returned with their keys:
+
+	{ "Z": 3012, "Tile": [4, 7], "Metadata": { "stage": [10213.5, 5532.0], "focus": 0.92 } }
+
+where "Tile" is omitted for section entries.
+
+GET  <api URL>/node/<UUID>/<data name>/help
+
+	Returns data-specific help message.
+
+GET  <api URL>/node/<UUID>/<data name>/info
+
+    Returns JSON with configuration settings.
+
+GET  <api URL>/node/<UUID>/<data name>/section/<z>
+POST <api URL>/node/<UUID>/<data name>/section/<z>
+DEL  <api URL>/node/<UUID>/<data name>/section/<z>
+
+	Returns, stores, or deletes the metadata of the section at Z.  A POST expects a
+	JSON object in the body, which replaces any stored metadata.  A GET returns the
+	entry as above or status 404 if there is none.
+
+GET  <api URL>/node/<UUID>/<data name>/tile/<z>/<col>_<row>
+POST <api URL>/node/<UUID>/<data name>/tile/<z>/<col>_<row>
+DEL  <api URL>/node/<UUID>/<data name>/tile/<z>/<col>_<row>
+
+	Returns, stores, or deletes the metadata of a tile of the section at Z as for
+	sections.
+
+POST <api URL>/node/<UUID>/<data name>/entries
+
+	Stores many entries at once.  Expects a JSON array of entries as above.
+
+GET  <api URL>/node/<UUID>/<data name>/zrange/<zmin>/<zmax>[?tiles=false]
+
+	Returns a JSON array of all entries with Z from zmin through zmax, ordered by Z with
+	each section's entry before those of its tiles, which are ordered by row and then
+	column.  If "tiles=false", only section entries are returned.
+
+	Example:
+
+	GET <api URL>/node/3f8c/acquisition/zrange/3000/3099?tiles=false
+`
+
+func init() {
+	datastore.Register(NewType())
+
+	// Need to register types that will be used to fulfill interfaces.
+	gob.Register(&Type{})
+	gob.Register(&Data{})
+}
+
+// Type embeds the datastore's Type to create a unique type for acqlog functions.
+type Type struct {
+	datastore.Type
+}
+
+// NewType returns a pointer to a new acqlog Type with default values set.
+func NewType() *Type {
+	dtype := new(Type)
+	dtype.Type = datastore.Type{
+		Name:    TypeName,
+		URL:     RepoURL,
+		Version: Version,
+		Requirements: &storage.Requirements{
+			Batcher: true,
+		},
+	}
+	return dtype
+}
+
+// --- TypeService interface ---
+
+// NewDataService returns a pointer to new acqlog data with default values.
+func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Config) (datastore.DataService, error) {
+	basedata, err := datastore.NewDataService(dtype, uuid, id, name, c)
+	if err != nil {
+		return nil, err
+	}
+	return &Data{Data: basedata}, nil
+}
+
+func (dtype *Type) Help() string {
+	return fmt.Sprintf(HelpMessage)
+}
+
+// Kinds of entries, stored after the Z of the index so a section's entry sorts before
+// its tiles.
+const (
+	sectionIndex byte = 1
+	tileIndex    byte = 2
+)
+
+// TileCoord is the column and row of a tile within a section.
+type TileCoord [2]int32
+
+// Entry is the acquisition metadata of a section or, if Tile is non-nil, a tile.
+type Entry struct {
+	Z        int32
+	Tile     *TileCoord `json:",omitempty"`
+	Metadata json.RawMessage
+}
+
+// encodeInt32 returns bytes of a signed integer that sort in the order of the integers.
+func encodeInt32(b []byte, i int32) {
+	binary.BigEndian.PutUint32(b, uint32(i)^0x80000000)
+}
+
+func decodeInt32(b []byte) int32 {
+	return int32(binary.BigEndian.Uint32(b) ^ 0x80000000)
+}
+
+func newIndex(z int32, tile *TileCoord) []byte {
+	if tile == nil {
+		index := make([]byte, 5)
+		encodeInt32(index[0:4], z)
+		index[4] = sectionIndex
+		return index
+	}
+	index := make([]byte, 13)
+	encodeInt32(index[0:4], z)
+	index[4] = tileIndex
+	encodeInt32(index[5:9], tile[1]) // row first so tiles are in raster order
+	encodeInt32(index[9:13], tile[0])
+	return index
+}
+
+func entryFromIndex(index []byte) (Entry, error) {
+	switch {
+	case len(index) == 5 && index[4] == sectionIndex:
+		return Entry{Z: decodeInt32(index[0:4])}, nil
+	case len(index) == 13 && index[4] == tileIndex:
+		tile := TileCoord{decodeInt32(index[9:13]), decodeInt32(index[5:9])}
+		return Entry{Z: decodeInt32(index[0:4]), Tile: &tile}, nil
+	default:
+		return Entry{}, fmt.Errorf("Bad acquisition log index: %x", index)
+	}
+}
+
+// checkMetadata returns an error if metadata isn't a JSON object.
+func checkMetadata(metadata []byte) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(metadata, &obj); err != nil || obj == nil {
+		return fmt.Errorf("Acquisition metadata must be a JSON object")
+	}
+	return nil
+}
+
+// Data embeds the datastore's Data.  Acquisition logs have no extended properties.
+type Data struct {
+	*datastore.Data
+}
+
+func (d *Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Base     *datastore.Data
+		Extended struct{}
+	}{
+		d.Data,
+		struct{}{},
+	})
+}
+
+func (d *Data) GobDecode(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	dec := gob.NewDecoder(buf)
+	return dec.Decode(&(d.Data))
+}
+
+func (d *Data) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(d.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GetEntry returns the entry of a section or, if tile is non-nil, a tile.  If there is
+// no entry, the returned entry is nil.
+func (d *Data) GetEntry(ctx storage.Context, z int32, tile *TileCoord) (*Entry, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	value, err := db.Get(ctx, newIndex(z, tile))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return &Entry{z, tile, value}, nil
+}
+
+// PutEntries stores entries, replacing any stored metadata with the same keys.
+func (d *Data) PutEntries(ctx storage.Context, entries []Entry) error {
+	for _, entry := range entries {
+		if err := checkMetadata(entry.Metadata); err != nil {
+			return fmt.Errorf("Entry at Z %d: %s", entry.Z, err.Error())
+		}
+	}
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 {
+		return db.Put(ctx, newIndex(entries[0].Z, entries[0].Tile), entries[0].Metadata)
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("Unable to store acquisition log entries: SmallDataStore is not a Batcher")
+	}
+	batch := batcher.NewBatch(ctx)
+	for _, entry := range entries {
+		batch.Put(newIndex(entry.Z, entry.Tile), entry.Metadata)
+	}
+	return batch.Commit()
+}
+
+// DeleteEntry deletes the entry of a section or tile.
+func (d *Data) DeleteEntry(ctx storage.Context, z int32, tile *TileCoord) error {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return err
+	}
+	return db.Delete(ctx, newIndex(z, tile))
+}
+
+// GetZRange returns the entries with Z from zmin through zmax, ordered by Z with each
+// section's entry before its tiles.  If withTiles is false, only section entries are
+// returned.
+func (d *Data) GetZRange(ctx storage.Context, zmin, zmax int32, withTiles bool) ([]Entry, error) {
+	if zmax < zmin {
+		return nil, fmt.Errorf("Bad Z range: %d is greater than %d", zmin, zmax)
+	}
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	begin := newIndex(zmin, nil)
+	end := newIndex(zmax, &TileCoord{0x7FFFFFFF, 0x7FFFFFFF})
+	kvs, err := db.GetRange(ctx, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, kv := range kvs {
+		index, err := ctx.IndexFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := entryFromIndex(index)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Tile != nil && !withTiles {
+			continue
+		}
+		entry.Metadata = kv.V
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// --- DataService interface ---
+
+func (d *Data) Help() string {
+	return fmt.Sprintf(HelpMessage)
+}
+
+// Send transfers all key-value pairs pertinent to this data type as well as
+// the storage.DataStoreType for them.
+func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
+	dvid.Criticalf("acqlog.Send() is not implemented yet, so push/pull will not work for this data type.\n")
+	return nil
+}
+
+// DoRPC acts as a switchboard for RPC commands.
+func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
+	return fmt.Errorf("Unknown command.  Data '%s' [%s] does not support '%s' command.",
+		d.DataName(), d.TypeName(), request.TypeCommand())
+}
+
+func parseZ(s string) (int32, error) {
+	z, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Bad Z %q: must be an integer", s)
+	}
+	return int32(z), nil
+}
+
+func parseTile(s string) (*TileCoord, error) {
+	coords := strings.Split(s, "_")
+	if len(coords) != 2 {
+		return nil, fmt.Errorf("Bad tile %q: must be <col>_<row>", s)
+	}
+	var tile TileCoord
+	for i, coord := range coords {
+		n, err := strconv.ParseInt(coord, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Bad tile %q: must be <col>_<row>", s)
+		}
+		tile[i] = int32(n)
+	}
+	return &tile, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+// ServeHTTP handles all incoming HTTP requests for this data.
+func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
+	timedLog := datastore.NewTimeLog(requestCtx)
+
+	// Get repo and version ID of this request
+	_, versions, err := datastore.FromContext(requestCtx)
+	if err != nil {
+		server.BadRequest(w, r, "Error: %q ServeHTTP has invalid context: %s\n", d.DataName(), err.Error())
+		return
+	}
+
+	// Construct storage.Context using a particular version of this Data
+	var versionID dvid.VersionID
+	if len(versions) > 0 {
+		versionID = versions[0]
+	}
+	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetClient(datastore.ClientFromContext(requestCtx))
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
+
+	// Break URL request into arguments
+	url := r.URL.Path[len(server.WebAPIPath):]
+	parts := strings.Split(url, "/")
+	if len(parts[len(parts)-1]) == 0 {
+		parts = parts[:len(parts)-1]
+	}
+
+	if len(parts) < 4 {
+		server.BadRequest(w, r, "incomplete API specification")
+		return
+	}
+
+	method := strings.ToLower(r.Method)
+	switch parts[3] {
+	case "help":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, d.Help())
+
+	case "info":
+		jsonBytes, err := d.MarshalJSON()
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+
+	case "section", "tile":
+		// GET, POST, or DELETE <api URL>/node/<UUID>/<data name>/section/<z>
+		// or <api URL>/node/<UUID>/<data name>/tile/<z>/<col>_<row>
+		numParts := 5
+		if parts[3] == "tile" {
+			numParts = 6
+		}
+		if len(parts) != numParts {
+			server.BadRequest(w, r, "Expected %s/<z> or tile/<z>/<col>_<row>", parts[3])
+			return
+		}
+		z, err := parseZ(parts[4])
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		var tile *TileCoord
+		if parts[3] == "tile" {
+			if tile, err = parseTile(parts[5]); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		switch method {
+		case "get":
+			entry, err := d.GetEntry(storeCtx, z, tile)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			if entry == nil {
+				http.Error(w, fmt.Sprintf("No acquisition metadata for %s", url), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, r, entry)
+		case "post":
+			metadata, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			if err := d.PutEntries(storeCtx, []Entry{{z, tile, metadata}}); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		case "delete":
+			if err := d.DeleteEntry(storeCtx, z, tile); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		default:
+			server.BadRequest(w, r, "Acquisition log entries only support GET, POST, and DELETE")
+			return
+		}
+		timedLog.Infof("HTTP %s %s of %q (%s)", r.Method, parts[3], d.DataName(), url)
+
+	case "entries":
+		if method != "post" {
+			server.BadRequest(w, r, "entries endpoint only supports POST")
+			return
+		}
+		var entries []Entry
+		if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+			server.BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+		if err := d.PutEntries(storeCtx, entries); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP POST %d entries of %q (%s)", len(entries), d.DataName(), url)
+
+	case "zrange":
+		if method != "get" {
+			server.BadRequest(w, r, "zrange endpoint only supports GET")
+			return
+		}
+		if len(parts) != 6 {
+			server.BadRequest(w, r, "Expected zrange/<zmin>/<zmax>")
+			return
+		}
+		zmin, err := parseZ(parts[4])
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		zmax, err := parseZ(parts[5])
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		withTiles := r.URL.Query().Get("tiles") != "false"
+		entries, err := d.GetZRange(storeCtx, zmin, zmax, withTiles)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, entries)
+		timedLog.Infof("HTTP GET %d entries of %q (%s)", len(entries), d.DataName(), url)
+
+	default:
+		server.BadRequest(w, r, "Unrecognized API call '%s' for acqlog data '%s'.  See API help.",
+			parts[3], d.DataName())
+	}
+}
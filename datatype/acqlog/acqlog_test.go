@@ -0,0 +1,106 @@
+package acqlog
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+var (
+	acqlogtype datastore.TypeService
+	testMu     sync.Mutex
+)
+
+// Sets package-level testRepo and TestVersionID
+func initTestRepo() (datastore.Repo, dvid.VersionID) {
+	testMu.Lock()
+	defer testMu.Unlock()
+	if acqlogtype == nil {
+		var err error
+		acqlogtype, err = datastore.TypeServiceByName(TypeName)
+		if err != nil {
+			log.Fatalf("Can't get acqlog type: %s\n", err)
+		}
+	}
+	return tests.NewRepo()
+}
+
+func TestIndexOrder(t *testing.T) {
+	indices := [][]byte{
+		newIndex(-3, nil),
+		newIndex(-3, &TileCoord{5, 0}),
+		newIndex(0, nil),
+		newIndex(0, &TileCoord{9, 0}),
+		newIndex(0, &TileCoord{-1, 1}),
+		newIndex(0, &TileCoord{0, 1}),
+		newIndex(12, nil),
+	}
+	for i := 1; i < len(indices); i++ {
+		if string(indices[i-1]) >= string(indices[i]) {
+			t.Errorf("Index %d (%x) doesn't sort before index %d (%x)\n", i-1, indices[i-1], i, indices[i])
+		}
+	}
+	entry, err := entryFromIndex(newIndex(-7, &TileCoord{3, -2}))
+	if err != nil {
+		t.Fatalf("Error decoding index: %s\n", err.Error())
+	}
+	if entry.Z != -7 || entry.Tile == nil || *entry.Tile != (TileCoord{3, -2}) {
+		t.Errorf("Bad entry decoded from index: %v\n", entry)
+	}
+}
+
+func TestZRange(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	dataservice, err := repo.NewData(acqlogtype, "acquisition", dvid.NewConfig())
+	if err != nil {
+		t.Fatalf("Error creating new acqlog instance: %s\n", err.Error())
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not acqlog.Data\n")
+	}
+	ctx := datastore.NewVersionedContext(data, versionID)
+
+	entries := []Entry{
+		{Z: 10, Metadata: json.RawMessage(`{"focus":0.9}`)},
+		{Z: 10, Tile: &TileCoord{1, 0}, Metadata: json.RawMessage(`{"stage":[1,2]}`)},
+		{Z: 11, Metadata: json.RawMessage(`{"focus":0.8}`)},
+		{Z: 12, Metadata: json.RawMessage(`{"focus":0.7}`)},
+	}
+	if err := data.PutEntries(ctx, entries); err != nil {
+		t.Fatalf("Error putting entries: %s\n", err.Error())
+	}
+	if err := data.PutEntries(ctx, []Entry{{Z: 1, Metadata: json.RawMessage(`[1,2]`)}}); err == nil {
+		t.Errorf("Expected error putting metadata that isn't a JSON object\n")
+	}
+
+	got, err := data.GetZRange(ctx, 10, 11, true)
+	if err != nil {
+		t.Fatalf("Error getting Z range: %s\n", err.Error())
+	}
+	if len(got) != 3 || got[0].Tile != nil || got[1].Tile == nil || got[2].Z != 11 {
+		t.Errorf("Bad entries for Z 10-11: %v\n", got)
+	}
+	if string(got[1].Metadata) != `{"stage":[1,2]}` {
+		t.Errorf("Bad tile metadata: %s\n", got[1].Metadata)
+	}
+	if got, err = data.GetZRange(ctx, 10, 12, false); err != nil || len(got) != 3 {
+		t.Errorf("Expected 3 section entries for Z 10-12, got %v (%v)\n", got, err)
+	}
+
+	if err := data.DeleteEntry(ctx, 11, nil); err != nil {
+		t.Fatalf("Error deleting entry: %s\n", err.Error())
+	}
+	entry, err := data.GetEntry(ctx, 11, nil)
+	if err != nil || entry != nil {
+		t.Errorf("Expected no entry after delete, got %v (%v)\n", entry, err)
+	}
+}
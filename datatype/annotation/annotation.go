@@ -0,0 +1,846 @@
+/*
+	Package annotation implements DVID support for point annotations, e.g., synapses or
+	bookmarks placed during proofreading.  Each element has a unique position, an optional
+	kind and body label, tags, and string properties.  Elements are indexed by position
+	for spatial queries and by label so the elements of a body can be listed.  If an
+	instance is given extents, elements outside them are rejected.
+*/
+package annotation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/message"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	Version  = "0.1"
+	RepoURL  = "github.com/janelia-flyem/dvid/datatype/annotation"
+	TypeName = "annotation"
+)
+
+const HelpMessage = `
+API for 'annotation' datatype (github.com/janelia-flyem/dvid/datatype/annotation)
+=================================================================================
+
+Command-line:
+
+$ dvid repo <UUID> new annotation <data name> <settings...>
+
+	Adds newly named annotation data to repo with specified UUID.
+
+	Example:
+
+	$ dvid repo 3f8c new annotation synapses MinPoint=0,0,0 MaxPoint=34431,39743,41407
+
+    Arguments:
+
+    UUID           Hexidecimal string with enough characters to uniquely identify a version node.
+    data name      Name of data to create, e.g., "synapses"
+    settings       Configuration settings in "key=value" format separated by spaces.
+
+    Configuration Settings (case-insensitive keys)
+
+    Versioned      "true" or "false" (default)
+    MinPoint       Minimum voxel coordinate of elements in "x,y,z" format.  Must be given
+                     with MaxPoint.  If not given, elements can be anywhere.
+    MaxPoint       Maximum voxel coordinate of elements in "x,y,z" format.
+
+    ------------------
+
+HTTP API (Level 2 REST):
+
+Elements are JSON objects with a position, and optionally a kind, body label, tags, and
+string properties:
+
+	{ "Pos": [3021, 1837, 401], "Kind": "PreSyn", "Label": 1827, "Tags": ["auto"],
+	  "Prop": { "conf": "0.93" } }
+
+Each position holds at most one element, so storing an element at a position replaces
+any element already there.
+
+GET  <api URL>/node/<UUID>/<data name>/help
+
+	Returns data-specific help message.
+
+GET  <api URL>/node/<UUID>/<data name>/info
+
+    Returns JSON with configuration settings.
+
+GET  <api URL>/node/<UUID>/<data name>/elements/<size>/<offset>
+POST <api URL>/node/<UUID>/<data name>/elements
+
+    A GET returns a JSON array of the elements within the subvolume of the given size
+    and offset, both in "x_y_z" format.  A POST stores a JSON array of elements.  If
+    any element is outside the instance's extents, none are stored.
+
+    Example:
+
+    GET <api URL>/node/3f8c/synapses/elements/100_100_10/3000_1800_400
+
+GET  <api URL>/node/<UUID>/<data name>/label/<label>
+
+    Returns a JSON array of the elements with the given body label.
+
+DEL  <api URL>/node/<UUID>/<data name>/element/<coord>
+
+    Deletes the element at the position given in "x_y_z" format.
+
+POST <api URL>/node/<UUID>/<data name>/move
+
+    Moves elements given a JSON array of moves, each from the position of an element to
+    a new position:
+
+	[ { "From": [3021, 1837, 401], "To": [3025, 1840, 401] }, ... ]
+
+    Moves are applied in order, so an element can be moved to a position vacated by an
+    earlier move.  If any element is missing, any destination is occupied or outside the
+    instance's extents, nothing is moved.  All moves are written in one atomic batch, so
+    they are logged together in the mutation log if it is enabled.  Returns JSON with the
+    number of moved elements: { "Moved": 12 }
+
+POST <api URL>/node/<UUID>/<data name>/relabel
+
+    Assigns new body labels to elements given a JSON array of positions and labels, e.g.,
+    to reassign the synapses of a body after a split:
+
+	[ { "Pos": [3021, 1837, 401], "Label": 1828 }, ... ]
+
+    If there is no element at any position, nothing is relabeled.  All changes are written
+    in one atomic batch, so they are logged together in the mutation log if it is enabled.
+    Returns JSON with the number of relabeled elements: { "Relabeled": 12 }
+
+POST <api URL>/node/<UUID>/<data name>/import?format=<csv|json>[&columns=<mapping>][&header=true]
+
+    Imports elements from a large CSV file or JSON array of objects.  The body is
+    streamed and elements are stored in batches of %d.  Rows that can't be parsed or
+    whose position is outside the instance's extents are rejected without stopping the
+    import.  Returns a JSON report of the number of rows read, elements imported, rows
+    rejected, and batches written, with the first %d rejections:
+
+	{ "Rows": 50002, "Imported": 50000, "Rejected": 2, "Batches": 50,
+	  "Errors": [ { "Row": 17, "Error": "position (-4,3,9) is outside extents ..." }, ... ] }
+
+    If the body itself is malformed, the import stops, elements from earlier batches stay
+    stored, and the report is returned with status 400 and an "Error" property.
+
+    Query-string Options:
+
+    format    "csv" or "json".
+    columns   Comma-separated mapping of element fields to CSV columns or JSON keys.
+                Fields are "x", "y", "z", "label", "kind", "tags", and "prop.<name>"
+                for a property.  Each entry is either a field, which for CSV maps to the
+                column at the entry's position, or "<field>=<source>", where source is a
+                1-based CSV column number, a CSV header name, or a JSON key.  For JSON, a
+                field without a source is read from the key of the same name, or <name>
+                for a property.  A CSV entry "-" skips a column.  Tags in CSV are
+                separated by semicolons.  Default: "x,y,z,label,kind".
+    header    If "true", the first CSV row is a header of column names.
+
+    Example:
+
+    POST <api URL>/node/3f8c/synapses/import?format=csv&header=true&columns=x=px,y=py,z=pz,label=body,prop.conf=confidence
+`
+
+func init() {
+	datastore.Register(NewType())
+
+	// Need to register types that will be used to fulfill interfaces.
+	gob.Register(&Type{})
+	gob.Register(&Data{})
+}
+
+// Type embeds the datastore's Type to create a unique type for annotation functions.
+type Type struct {
+	datastore.Type
+}
+
+// NewType returns a pointer to a new annotation Type with default values set.
+func NewType() *Type {
+	dtype := new(Type)
+	dtype.Type = datastore.Type{
+		Name:    TypeName,
+		URL:     RepoURL,
+		Version: Version,
+		Requirements: &storage.Requirements{
+			Batcher: true,
+		},
+	}
+	return dtype
+}
+
+// --- TypeService interface ---
+
+// NewDataService returns a pointer to new annotation data with default values.
+func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Config) (datastore.DataService, error) {
+	basedata, err := datastore.NewDataService(dtype, uuid, id, name, c)
+	if err != nil {
+		return nil, err
+	}
+	var props Properties
+	minStr, foundMin, err := c.GetString("MinPoint")
+	if err != nil {
+		return nil, err
+	}
+	maxStr, foundMax, err := c.GetString("MaxPoint")
+	if err != nil {
+		return nil, err
+	}
+	if foundMin != foundMax {
+		return nil, fmt.Errorf("Annotation extents require both MinPoint and MaxPoint settings")
+	}
+	if foundMin {
+		var extents dvid.Extents3d
+		if extents.MinPoint, err = parsePoint(minStr, ","); err != nil {
+			return nil, err
+		}
+		if extents.MaxPoint, err = parsePoint(maxStr, ","); err != nil {
+			return nil, err
+		}
+		for i := 0; i < 3; i++ {
+			if extents.MinPoint[i] > extents.MaxPoint[i] {
+				return nil, fmt.Errorf("MinPoint %s must not exceed MaxPoint %s", extents.MinPoint, extents.MaxPoint)
+			}
+		}
+		props.Extents = &extents
+	}
+	return &Data{Data: basedata, Properties: props}, nil
+}
+
+func (dtype *Type) Help() string {
+	return fmt.Sprintf(HelpMessage, ImportBatchSize, MaxImportErrors)
+}
+
+// parsePoint returns a 3d point from a string of coordinates with the given separator.
+func parsePoint(s, separator string) (dvid.Point3d, error) {
+	pt, err := dvid.StringToPoint(s, separator)
+	if err != nil {
+		return dvid.Point3d{}, err
+	}
+	pt3d, ok := pt.(dvid.Point3d)
+	if !ok {
+		return dvid.Point3d{}, fmt.Errorf("Expected 3d point, got %q", s)
+	}
+	return pt3d, nil
+}
+
+// Element is a point annotation.
+type Element struct {
+	Pos   dvid.Point3d
+	Kind  string            `json:",omitempty"`
+	Label uint64            `json:",omitempty"`
+	Tags  []string          `json:",omitempty"`
+	Prop  map[string]string `json:",omitempty"`
+}
+
+// The first byte of indices, which separates elements from the label index.
+const (
+	elementIndex byte = 1
+	labelIndex   byte = 2
+)
+
+// newElementIndex returns the index of an element, ordered by Z, then Y, then X.
+func newElementIndex(pos dvid.Point3d) []byte {
+	zyx := dvid.IndexZYX(pos)
+	return append([]byte{elementIndex}, zyx.Bytes()...)
+}
+
+// newLabelIndex returns the index of an element within the elements of a label.
+func newLabelIndex(label uint64, pos dvid.Point3d) []byte {
+	index := make([]byte, 9, 21)
+	index[0] = labelIndex
+	binary.BigEndian.PutUint64(index[1:9], label)
+	zyx := dvid.IndexZYX(pos)
+	return append(index, zyx.Bytes()...)
+}
+
+// Properties are additional properties for annotation data instances beyond those in
+// standard datastore.Data.  These will be persisted to metadata storage.
+type Properties struct {
+	// Extents bound the positions of elements if not nil.
+	Extents *dvid.Extents3d
+}
+
+// Data embeds the datastore's Data and extends it with annotation properties.
+type Data struct {
+	*datastore.Data
+	Properties
+
+	// Serializes modifications so the label index stays consistent with elements.
+	mu sync.Mutex
+}
+
+func (d *Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Base     *datastore.Data
+		Extended Properties
+	}{
+		d.Data,
+		d.Properties,
+	})
+}
+
+func (d *Data) GobDecode(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	dec := gob.NewDecoder(buf)
+	if err := dec.Decode(&(d.Data)); err != nil {
+		return err
+	}
+	return dec.Decode(&(d.Properties))
+}
+
+func (d *Data) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(d.Data); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.Properties); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkPosition returns an error if a position is outside the extents of the data.
+func (d *Data) checkPosition(pos dvid.Point3d) error {
+	ext := d.Extents
+	if ext == nil {
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		if pos[i] < ext.MinPoint[i] || pos[i] > ext.MaxPoint[i] {
+			return fmt.Errorf("position %s is outside extents %s to %s", pos, ext.MinPoint, ext.MaxPoint)
+		}
+	}
+	return nil
+}
+
+// getElement returns the element at a position or nil if there is none.
+func getElement(ctx storage.Context, db storage.OrderedKeyValueDB, pos dvid.Point3d) (*Element, error) {
+	value, err := db.Get(ctx, newElementIndex(pos))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var elem Element
+	if err := json.Unmarshal(value, &elem); err != nil {
+		return nil, fmt.Errorf("Unable to decode element at %s: %s", pos, err.Error())
+	}
+	return &elem, nil
+}
+
+// GetElement returns the element at a position or nil if there is none.
+func (d *Data) GetElement(ctx storage.Context, pos dvid.Point3d) (*Element, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	return getElement(ctx, db, pos)
+}
+
+// elementBatch accumulates modifications of elements and their label index for one
+// atomic batch write.  Elements modified earlier in the batch are tracked so the label
+// index is updated correctly if a position is modified more than once.
+type elementBatch struct {
+	ctx     storage.Context
+	db      storage.OrderedKeyValueDB
+	batch   storage.Batch
+	pending map[dvid.Point3d]*Element // nil if deleted in this batch
+}
+
+func newElementBatch(ctx storage.Context) (*elementBatch, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return nil, fmt.Errorf("Annotation data requires a store that supports batch writes")
+	}
+	return &elementBatch{
+		ctx:     ctx,
+		db:      db,
+		batch:   batcher.NewBatch(ctx),
+		pending: make(map[dvid.Point3d]*Element),
+	}, nil
+}
+
+// current returns the element at a position as modified by the batch so far.
+func (b *elementBatch) current(pos dvid.Point3d) (*Element, error) {
+	if elem, found := b.pending[pos]; found {
+		return elem, nil
+	}
+	return getElement(b.ctx, b.db, pos)
+}
+
+// put stores an element, replacing any element at its position.
+func (b *elementBatch) put(elem *Element) error {
+	old, err := b.current(elem.Pos)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(elem)
+	if err != nil {
+		return err
+	}
+	if old != nil && old.Label != elem.Label {
+		b.batch.Delete(newLabelIndex(old.Label, old.Pos))
+	}
+	b.batch.Put(newElementIndex(elem.Pos), value)
+	b.batch.Put(newLabelIndex(elem.Label, elem.Pos), []byte{})
+	b.pending[elem.Pos] = elem
+	return nil
+}
+
+// delete removes the element at a position and returns it, or nil if there is none.
+func (b *elementBatch) delete(pos dvid.Point3d) (*Element, error) {
+	old, err := b.current(pos)
+	if err != nil || old == nil {
+		return nil, err
+	}
+	b.batch.Delete(newElementIndex(pos))
+	b.batch.Delete(newLabelIndex(old.Label, pos))
+	b.pending[pos] = nil
+	return old, nil
+}
+
+func (b *elementBatch) size() int {
+	return len(b.pending)
+}
+
+func (b *elementBatch) commit() error {
+	return b.batch.Commit()
+}
+
+// PutElements stores elements in one batch.  If any element is outside the extents of
+// the data, no elements are stored.
+func (d *Data) PutElements(ctx storage.Context, elements []Element) error {
+	for _, elem := range elements {
+		if err := d.checkPosition(elem.Pos); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batch, err := newElementBatch(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range elements {
+		if err := batch.put(&elements[i]); err != nil {
+			return err
+		}
+	}
+	return batch.commit()
+}
+
+// DeleteElement deletes the element at a position.  It is not an error if there is
+// no element there.
+func (d *Data) DeleteElement(ctx storage.Context, pos dvid.Point3d) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batch, err := newElementBatch(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := batch.delete(pos); err != nil {
+		return err
+	}
+	return batch.commit()
+}
+
+// ElementMove moves the element at one position to another.
+type ElementMove struct {
+	From dvid.Point3d
+	To   dvid.Point3d
+}
+
+// MoveElements applies moves in order in one batch.  If any element is missing, or any
+// destination is occupied or outside the extents of the data, nothing is moved.
+func (d *Data) MoveElements(ctx storage.Context, moves []ElementMove) error {
+	for _, move := range moves {
+		if err := d.checkPosition(move.To); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batch, err := newElementBatch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, move := range moves {
+		if move.From.Equals(move.To) {
+			continue
+		}
+		target, err := batch.current(move.To)
+		if err != nil {
+			return err
+		}
+		if target != nil {
+			return fmt.Errorf("Unable to move element from %s to %s, which holds another element", move.From, move.To)
+		}
+		elem, err := batch.delete(move.From)
+		if err != nil {
+			return err
+		}
+		if elem == nil {
+			return fmt.Errorf("No element at %s to move", move.From)
+		}
+		moved := *elem
+		moved.Pos = move.To
+		if err := batch.put(&moved); err != nil {
+			return err
+		}
+	}
+	return batch.commit()
+}
+
+// ElementLabel gives a new label for the element at a position.
+type ElementLabel struct {
+	Pos   dvid.Point3d
+	Label uint64
+}
+
+// RelabelElements changes the labels of elements in one batch.  If there is no element
+// at any position, nothing is relabeled.
+func (d *Data) RelabelElements(ctx storage.Context, labels []ElementLabel) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batch, err := newElementBatch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, relabel := range labels {
+		elem, err := batch.current(relabel.Pos)
+		if err != nil {
+			return err
+		}
+		if elem == nil {
+			return fmt.Errorf("No element at %s to relabel", relabel.Pos)
+		}
+		relabeled := *elem
+		relabeled.Label = relabel.Label
+		if err := batch.put(&relabeled); err != nil {
+			return err
+		}
+	}
+	return batch.commit()
+}
+
+// GetElements returns the elements within the subvolume of the given size and offset.
+func (d *Data) GetElements(ctx storage.Context, size, offset dvid.Point3d) ([]Element, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	var end dvid.Point3d
+	for i := 0; i < 3; i++ {
+		if size[i] <= 0 {
+			return nil, fmt.Errorf("Bad subvolume size %s", size)
+		}
+		end[i] = offset[i] + size[i] - 1
+	}
+
+	// Elements are read a plane at a time and filtered by x and y.
+	elements := []Element{}
+	for z := offset[2]; z <= end[2]; z++ {
+		begIndex := newElementIndex(dvid.Point3d{offset[0], offset[1], z})
+		endIndex := newElementIndex(dvid.Point3d{end[0], end[1], z})
+		kvs, err := db.GetRange(ctx, begIndex, endIndex)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			var elem Element
+			if err := json.Unmarshal(kv.V, &elem); err != nil {
+				return nil, fmt.Errorf("Unable to decode element: %s", err.Error())
+			}
+			if elem.Pos[0] >= offset[0] && elem.Pos[0] <= end[0] {
+				elements = append(elements, elem)
+			}
+		}
+	}
+	return elements, nil
+}
+
+// GetLabelElements returns the elements with the given label.
+func (d *Data) GetLabelElements(ctx storage.Context, label uint64) ([]Element, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	begIndex := newLabelIndex(label, dvid.Point3d(dvid.MinIndexZYX))
+	endIndex := newLabelIndex(label, dvid.Point3d(dvid.MaxIndexZYX))
+	keys, err := db.KeysInRange(ctx, begIndex, endIndex)
+	if err != nil {
+		return nil, err
+	}
+	elements := []Element{}
+	for _, key := range keys {
+		index, err := ctx.IndexFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		var zyx dvid.IndexZYX
+		if err := zyx.IndexFromBytes(index[9:]); err != nil {
+			return nil, err
+		}
+		elem, err := getElement(ctx, db, dvid.Point3d(zyx))
+		if err != nil {
+			return nil, err
+		}
+		if elem == nil || elem.Label != label {
+			dvid.Errorf("Label index of %q lists label %d at %s with no matching element\n", d.DataName(), label, dvid.Point3d(zyx))
+			continue
+		}
+		elements = append(elements, *elem)
+	}
+	return elements, nil
+}
+
+// --- DataService interface ---
+
+func (d *Data) Help() string {
+	return fmt.Sprintf(HelpMessage, ImportBatchSize, MaxImportErrors)
+}
+
+// Send transfers all key-value pairs pertinent to this data type as well as
+// the storage.DataStoreType for them.
+func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
+	dvid.Criticalf("annotation.Send() is not implemented yet, so push/pull will not work for this data type.\n")
+	return nil
+}
+
+// DoRPC acts as a switchboard for RPC commands.
+func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
+	return fmt.Errorf("Unknown command.  Data '%s' [%s] does not support '%s' command.",
+		d.DataName(), d.TypeName(), request.TypeCommand())
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+// ServeHTTP handles all incoming HTTP requests for this data.
+func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
+	timedLog := datastore.NewTimeLog(requestCtx)
+
+	// Get repo and version ID of this request
+	_, versions, err := datastore.FromContext(requestCtx)
+	if err != nil {
+		server.BadRequest(w, r, "Error: %q ServeHTTP has invalid context: %s\n", d.DataName(), err.Error())
+		return
+	}
+
+	// Construct storage.Context using a particular version of this Data
+	var versionID dvid.VersionID
+	if len(versions) > 0 {
+		versionID = versions[0]
+	}
+	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetClient(datastore.ClientFromContext(requestCtx))
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
+
+	// Break URL request into arguments
+	url := r.URL.Path[len(server.WebAPIPath):]
+	parts := strings.Split(url, "/")
+	if len(parts[len(parts)-1]) == 0 {
+		parts = parts[:len(parts)-1]
+	}
+
+	if len(parts) < 4 {
+		server.BadRequest(w, r, "incomplete API specification")
+		return
+	}
+
+	method := strings.ToLower(r.Method)
+	switch parts[3] {
+	case "help":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, d.Help())
+
+	case "info":
+		jsonBytes, err := d.MarshalJSON()
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+
+	case "elements":
+		switch method {
+		case "get":
+			// GET <api URL>/node/<UUID>/<data name>/elements/<size>/<offset>
+			if len(parts) < 6 {
+				server.BadRequest(w, r, "GET of elements must be followed by size and offset")
+				return
+			}
+			size, err := parsePoint(parts[4], "_")
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			offset, err := parsePoint(parts[5], "_")
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			elements, err := d.GetElements(storeCtx, size, offset)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			writeJSON(w, r, elements)
+			timedLog.Infof("HTTP GET %d elements of %q (%s)", len(elements), d.DataName(), url)
+		case "post":
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			var elements []Element
+			if err := json.Unmarshal(data, &elements); err != nil {
+				server.BadRequest(w, r, "Bad JSON array of elements: %s", err.Error())
+				return
+			}
+			if err := d.PutElements(storeCtx, elements); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			timedLog.Infof("HTTP POST %d elements of %q (%s)", len(elements), d.DataName(), url)
+		default:
+			server.BadRequest(w, r, "Annotations only allow GET or POST of elements")
+		}
+
+	case "label":
+		// GET <api URL>/node/<UUID>/<data name>/label/<label>
+		if method != "get" || len(parts) < 5 {
+			server.BadRequest(w, r, "Annotations only allow GET of label/<label>")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, "Bad label %q", parts[4])
+			return
+		}
+		elements, err := d.GetLabelElements(storeCtx, label)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, elements)
+		timedLog.Infof("HTTP GET %d elements of label %d in %q", len(elements), label, d.DataName())
+
+	case "element":
+		// DELETE <api URL>/node/<UUID>/<data name>/element/<coord>
+		if method != "delete" || len(parts) < 5 {
+			server.BadRequest(w, r, "Annotations only allow DELETE of element/<coord>")
+			return
+		}
+		pos, err := parsePoint(parts[4], "_")
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if err := d.DeleteElement(storeCtx, pos); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP DELETE element %s of %q", pos, d.DataName())
+
+	case "move":
+		if method != "post" {
+			server.BadRequest(w, r, "Annotations only allow POST of move")
+			return
+		}
+		var moves []ElementMove
+		if err := json.NewDecoder(r.Body).Decode(&moves); err != nil {
+			server.BadRequest(w, r, "Bad JSON array of moves: %s", err.Error())
+			return
+		}
+		if err := d.MoveElements(storeCtx, moves); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, struct{ Moved int }{len(moves)})
+		timedLog.Infof("HTTP POST move of %d elements of %q", len(moves), d.DataName())
+
+	case "relabel":
+		if method != "post" {
+			server.BadRequest(w, r, "Annotations only allow POST of relabel")
+			return
+		}
+		var labels []ElementLabel
+		if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+			server.BadRequest(w, r, "Bad JSON array of element labels: %s", err.Error())
+			return
+		}
+		if err := d.RelabelElements(storeCtx, labels); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, struct{ Relabeled int }{len(labels)})
+		timedLog.Infof("HTTP POST relabel of %d elements of %q", len(labels), d.DataName())
+
+	case "import":
+		if method != "post" {
+			server.BadRequest(w, r, "Annotations only allow POST of import")
+			return
+		}
+		queryValues := r.URL.Query()
+		columns, err := parseColumns(queryValues.Get("columns"))
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		header := queryValues.Get("header") == "true"
+		var report *ImportReport
+		switch queryValues.Get("format") {
+		case "csv":
+			report = d.ImportCSV(storeCtx, r.Body, columns, header)
+		case "json":
+			report = d.ImportJSON(storeCtx, r.Body, columns)
+		default:
+			server.BadRequest(w, r, "Import requires a format of \"csv\" or \"json\"")
+			return
+		}
+		jsonBytes, err := json.Marshal(report)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if report.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write(jsonBytes)
+		timedLog.Infof("HTTP POST import into %q: %s", d.DataName(), report)
+
+	default:
+		server.BadRequest(w, r, "Unrecognized API call '%s' for annotation data '%s'.  See API help.",
+			parts[3], d.DataName())
+	}
+}
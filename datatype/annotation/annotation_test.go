@@ -0,0 +1,276 @@
+package annotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+var (
+	annotationtype datastore.TypeService
+	testMu         sync.Mutex
+)
+
+// Sets package-level testRepo and TestVersionID
+func initTestRepo() (datastore.Repo, dvid.VersionID) {
+	testMu.Lock()
+	defer testMu.Unlock()
+	if annotationtype == nil {
+		var err error
+		annotationtype, err = datastore.TypeServiceByName(TypeName)
+		if err != nil {
+			log.Fatalf("Can't get annotation type: %s\n", err)
+		}
+	}
+	return tests.NewRepo()
+}
+
+// newTestData returns an annotation instance with extents from (0,0,0) to (99,99,99).
+func newTestData(t *testing.T, name dvid.DataString) (*Data, *datastore.VersionedContext) {
+	repo, versionID := initTestRepo()
+	config := dvid.NewConfig()
+	config.Set("MinPoint", "0,0,0")
+	config.Set("MaxPoint", "99,99,99")
+	dataservice, err := repo.NewData(annotationtype, name, config)
+	if err != nil {
+		t.Fatalf("Error creating new annotation instance: %s\n", err.Error())
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not annotation.Data\n")
+	}
+	return data, datastore.NewVersionedContext(data, versionID)
+}
+
+func TestElements(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	data, ctx := newTestData(t, "synapses")
+
+	elements := []Element{
+		{Pos: dvid.Point3d{10, 20, 30}, Kind: "PreSyn", Label: 7},
+		{Pos: dvid.Point3d{11, 20, 30}, Kind: "PostSyn", Label: 8},
+		{Pos: dvid.Point3d{50, 50, 50}, Kind: "PostSyn", Label: 7, Tags: []string{"auto"}},
+	}
+	if err := data.PutElements(ctx, elements); err != nil {
+		t.Fatalf("Error storing elements: %s\n", err.Error())
+	}
+	bad := []Element{{Pos: dvid.Point3d{1, 1, 1}}, {Pos: dvid.Point3d{1, 100, 1}}}
+	if err := data.PutElements(ctx, bad); err == nil {
+		t.Errorf("Expected error storing element outside extents\n")
+	}
+	if elem, err := data.GetElement(ctx, dvid.Point3d{1, 1, 1}); err != nil || elem != nil {
+		t.Errorf("Expected no elements stored from rejected batch, got %v (%v)\n", elem, err)
+	}
+
+	got, err := data.GetElements(ctx, dvid.Point3d{2, 1, 1}, dvid.Point3d{10, 20, 30})
+	if err != nil {
+		t.Fatalf("Error getting elements: %s\n", err.Error())
+	}
+	if len(got) != 2 || got[0].Kind != "PreSyn" || got[1].Kind != "PostSyn" {
+		t.Errorf("Bad elements in subvolume: %v\n", got)
+	}
+
+	got, err = data.GetLabelElements(ctx, 7)
+	if err != nil {
+		t.Fatalf("Error getting label elements: %s\n", err.Error())
+	}
+	if len(got) != 2 || !got[0].Pos.Equals(elements[0].Pos) || !got[1].Pos.Equals(elements[2].Pos) {
+		t.Errorf("Bad elements for label 7: %v\n", got)
+	}
+
+	// Relabeling an element moves it within the label index.
+	if err := data.PutElements(ctx, []Element{{Pos: dvid.Point3d{50, 50, 50}, Label: 8}}); err != nil {
+		t.Fatalf("Error replacing element: %s\n", err.Error())
+	}
+	if got, _ = data.GetLabelElements(ctx, 7); len(got) != 1 {
+		t.Errorf("Expected 1 element with label 7 after relabel, got %v\n", got)
+	}
+	if got, _ = data.GetLabelElements(ctx, 8); len(got) != 2 {
+		t.Errorf("Expected 2 elements with label 8 after relabel, got %v\n", got)
+	}
+
+	if err := data.DeleteElement(ctx, dvid.Point3d{11, 20, 30}); err != nil {
+		t.Fatalf("Error deleting element: %s\n", err.Error())
+	}
+	if got, _ = data.GetLabelElements(ctx, 8); len(got) != 1 {
+		t.Errorf("Expected 1 element with label 8 after delete, got %v\n", got)
+	}
+}
+
+func TestMoveRelabel(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	data, ctx := newTestData(t, "synapses")
+	elements := []Element{
+		{Pos: dvid.Point3d{10, 20, 30}, Kind: "PreSyn", Label: 7},
+		{Pos: dvid.Point3d{11, 20, 30}, Kind: "PostSyn", Label: 7},
+		{Pos: dvid.Point3d{12, 20, 30}, Kind: "PostSyn", Label: 7},
+	}
+	if err := data.PutElements(ctx, elements); err != nil {
+		t.Fatalf("Error storing elements: %s\n", err.Error())
+	}
+	uuid, err := datastore.UUIDFromVersion(ctx.VersionID())
+	if err != nil {
+		t.Fatalf("Unable to get UUID of test version: %s\n", err.Error())
+	}
+	post := func(endpoint, body string) *httptest.ResponseRecorder {
+		urlStr := fmt.Sprintf("%snode/%s/synapses/%s", server.WebAPIPath, uuid, endpoint)
+		req, _ := http.NewRequest("POST", urlStr, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeSingleHTTP(w, req)
+		return w
+	}
+	positions := func(label uint64) string {
+		got, err := data.GetLabelElements(ctx, label)
+		if err != nil {
+			t.Fatalf("Error getting label elements: %s\n", err.Error())
+		}
+		var pts []string
+		for _, elem := range got {
+			pts = append(pts, elem.Pos.String())
+		}
+		return strings.Join(pts, " ")
+	}
+
+	// Moves are applied in order, so an element can take a position vacated earlier.
+	w := post("move", `[{"From": [12,20,30], "To": [13,20,30]}, {"From": [11,20,30], "To": [12,20,30]}]`)
+	var moved struct{ Moved int }
+	if err := json.Unmarshal(w.Body.Bytes(), &moved); w.Code != http.StatusOK || err != nil || moved.Moved != 2 {
+		t.Fatalf("Bad move response %d: %s\n", w.Code, w.Body.String())
+	}
+	if elem, _ := data.GetElement(ctx, dvid.Point3d{13, 20, 30}); elem == nil || elem.Kind != "PostSyn" || elem.Label != 7 {
+		t.Errorf("Expected moved element at (13,20,30), got %v\n", elem)
+	}
+	if elem, _ := data.GetElement(ctx, dvid.Point3d{11, 20, 30}); elem != nil {
+		t.Errorf("Expected no element at vacated position, got %v\n", elem)
+	}
+	before := positions(7)
+	if before != "(10,20,30) (12,20,30) (13,20,30)" {
+		t.Errorf("Bad label index after move: %s\n", before)
+	}
+
+	// Nothing is moved if any move fails.
+	for _, body := range []string{
+		`[{"From": [10,20,30], "To": [11,20,30]}, {"From": [50,50,50], "To": [51,50,50]}]`,
+		`[{"From": [10,20,30], "To": [11,20,30]}, {"From": [12,20,30], "To": [13,20,30]}]`,
+		`[{"From": [10,20,30], "To": [11,20,30]}, {"From": [12,20,30], "To": [12,20,300]}]`,
+	} {
+		if w := post("move", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected move %s to fail, got status %d\n", body, w.Code)
+		}
+		if after := positions(7); after != before {
+			t.Errorf("Failed move %s changed elements to %s\n", body, after)
+		}
+	}
+
+	// Relabeling after a split reassigns elements to new bodies.
+	w = post("relabel", `[{"Pos": [12,20,30], "Label": 8}, {"Pos": [13,20,30], "Label": 9}]`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Relabeled":2`) {
+		t.Fatalf("Bad relabel response %d: %s\n", w.Code, w.Body.String())
+	}
+	if got7, got8, got9 := positions(7), positions(8), positions(9); got7 != "(10,20,30)" || got8 != "(12,20,30)" || got9 != "(13,20,30)" {
+		t.Errorf("Bad labels after relabel: 7 %q, 8 %q, 9 %q\n", got7, got8, got9)
+	}
+	if w := post("relabel", `[{"Pos": [10,20,30], "Label": 8}, {"Pos": [50,50,50], "Label": 8}]`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected relabel of missing element to fail, got status %d\n", w.Code)
+	}
+	if got := positions(7); got != "(10,20,30)" {
+		t.Errorf("Failed relabel changed label 7 elements to %s\n", got)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	data, ctx := newTestData(t, "imported")
+
+	columns, err := parseColumns("x=px,y=py,z=pz,prop.conf=4,label=body,tags=tags")
+	if err != nil {
+		t.Fatalf("Error parsing columns: %s\n", err.Error())
+	}
+	csvData := `px,py,pz,conf,tags,body
+1,2,3.0,0.9,a;b,17
+4,5,6,0.5,,
+1,2,bad,0.1,,
+7,8,200,0.7,,
+`
+	report := data.ImportCSV(ctx, strings.NewReader(csvData), columns, true)
+	if report.Error != "" {
+		t.Fatalf("Import stopped: %s\n", report.Error)
+	}
+	if report.Rows != 5 || report.Imported != 2 || report.Rejected != 2 || report.Batches != 1 {
+		t.Errorf("Bad import report: %s\n", report)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Row != 4 || report.Errors[1].Row != 5 {
+		t.Errorf("Bad import errors: %v\n", report.Errors)
+	}
+	elem, err := data.GetElement(ctx, dvid.Point3d{1, 2, 3})
+	if err != nil || elem == nil {
+		t.Fatalf("Expected imported element at (1,2,3), got %v (%v)\n", elem, err)
+	}
+	if elem.Label != 17 || elem.Prop["conf"] != "0.9" || len(elem.Tags) != 2 {
+		t.Errorf("Bad imported element: %v\n", elem)
+	}
+
+	// Unknown or missing fields are rejected before anything is read.
+	for _, mapping := range []string{"x,y", "x,y,z,color", "x,y,z,x=4", "x=0,y,z"} {
+		if _, err := parseColumns(mapping); err == nil {
+			t.Errorf("Expected error for column mapping %q\n", mapping)
+		}
+	}
+	columns, _ = parseColumns("x=px,y,z")
+	if report := data.ImportCSV(ctx, strings.NewReader("1,2,3\n"), columns, false); report.Error == "" {
+		t.Errorf("Expected error for column name without header\n")
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	data, ctx := newTestData(t, "imported")
+
+	columns, err := parseColumns("x,y,z,label=body,tags,prop.conf")
+	if err != nil {
+		t.Fatalf("Error parsing columns: %s\n", err.Error())
+	}
+	jsonData := `[
+		{"x": 1, "y": 2, "z": 3, "body": 17, "tags": ["a", "b"], "conf": 0.9},
+		{"x": 4, "y": 5},
+		{"x": 4, "y": 5, "z": -1},
+		{"x": 6, "y": 7, "z": 8, "body": 17}
+	]`
+	report := data.ImportJSON(ctx, strings.NewReader(jsonData), columns)
+	if report.Error != "" {
+		t.Fatalf("Import stopped: %s\n", report.Error)
+	}
+	if report.Rows != 4 || report.Imported != 2 || report.Rejected != 2 {
+		t.Errorf("Bad import report: %s\n", report)
+	}
+	got, err := data.GetLabelElements(ctx, 17)
+	if err != nil {
+		t.Fatalf("Error getting label elements: %s\n", err.Error())
+	}
+	if len(got) != 2 || len(got[0].Tags) != 2 || got[0].Prop["conf"] != "0.9" {
+		t.Errorf("Bad imported elements: %v\n", got)
+	}
+
+	report = data.ImportJSON(ctx, strings.NewReader(`[{"x": 1, "y": 2, "z": 3}, {"x": `), columns)
+	if report.Error == "" || report.Imported != 0 {
+		t.Errorf("Expected malformed JSON to stop import with nothing stored, got %s\n", report)
+	}
+}
@@ -0,0 +1,389 @@
+/*
+	This file supports bulk import of elements from large CSV files or JSON arrays.  A
+	declared mapping gives the CSV column or JSON key of each element field.  The body is
+	streamed, so imports aren't limited by memory, and elements are written in batches.
+	Rows that can't be parsed or are outside the instance's extents are rejected and
+	reported without stopping the import.
+*/
+
+package annotation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	// ImportBatchSize is the number of elements written in each batch of an import.
+	ImportBatchSize = 1000
+
+	// MaxImportErrors is the maximum number of rejected rows described in an import
+	// report.
+	MaxImportErrors = 100
+)
+
+// DefaultImportColumns is the column mapping used if an import doesn't declare one.
+const DefaultImportColumns = "x,y,z,label,kind"
+
+// ImportError describes a rejected row of an import.  Rows are numbered from 1 and
+// include any CSV header.
+type ImportError struct {
+	Row   int
+	Error string
+}
+
+// ImportReport gives the results of an import.
+type ImportReport struct {
+	Rows     int
+	Imported int
+	Rejected int
+	Batches  int
+	Errors   []ImportError `json:",omitempty"`
+
+	// Error is set if the import stopped before reading all rows.
+	Error string `json:",omitempty"`
+}
+
+func (report *ImportReport) reject(row int, err error) {
+	report.Rejected++
+	if len(report.Errors) < MaxImportErrors {
+		report.Errors = append(report.Errors, ImportError{row, err.Error()})
+	}
+}
+
+// importColumn maps an element field to its source in the imported rows.
+type importColumn struct {
+	field string // "x", "y", "z", "label", "kind", "tags", or "prop.<name>"
+
+	// source is a CSV header name or JSON key.  For CSV, if source is empty, the
+	// field is read from the column given by index.
+	source string
+	index  int
+}
+
+// parseColumns parses a mapping of element fields to CSV columns or JSON keys.
+func parseColumns(mapping string) ([]importColumn, error) {
+	if mapping == "" {
+		mapping = DefaultImportColumns
+	}
+	var columns []importColumn
+	found := make(map[string]bool)
+	for i, entry := range strings.Split(mapping, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "-" {
+			continue
+		}
+		column := importColumn{field: entry, index: i}
+		if eq := strings.Index(entry, "="); eq >= 0 {
+			column.field, column.source = entry[:eq], entry[eq+1:]
+			if column.source == "" {
+				return nil, fmt.Errorf("Column mapping %q has no source", entry)
+			}
+			if n, err := strconv.Atoi(column.source); err == nil {
+				if n < 1 {
+					return nil, fmt.Errorf("Column number in %q must be at least 1", entry)
+				}
+				column.source, column.index = "", n-1
+			}
+		}
+		switch {
+		case column.field == "x", column.field == "y", column.field == "z",
+			column.field == "label", column.field == "kind", column.field == "tags":
+		case strings.HasPrefix(column.field, "prop.") && len(column.field) > len("prop."):
+		default:
+			return nil, fmt.Errorf("Unknown element field %q in column mapping", column.field)
+		}
+		if found[column.field] {
+			return nil, fmt.Errorf("Element field %q mapped more than once", column.field)
+		}
+		found[column.field] = true
+		columns = append(columns, column)
+	}
+	if !found["x"] || !found["y"] || !found["z"] {
+		return nil, fmt.Errorf("Column mapping %q must include x, y, and z", mapping)
+	}
+	return columns, nil
+}
+
+// jsonKey returns the key of a field in imported JSON objects.
+func (c importColumn) jsonKey() string {
+	if c.source != "" {
+		return c.source
+	}
+	if strings.HasPrefix(c.field, "prop.") {
+		return c.field[len("prop."):]
+	}
+	return c.field
+}
+
+// setField sets an element field from an imported value.
+func setField(elem *Element, field, value string) error {
+	switch field {
+	case "x", "y", "z":
+		coord, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			// Allow integral floating-point coordinates, e.g., "1032.0".
+			f, ferr := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if ferr != nil || f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+				return fmt.Errorf("bad %s coordinate %q", field, value)
+			}
+			coord = int64(f)
+		}
+		elem.Pos[field[0]-'x'] = int32(coord)
+	case "label":
+		if value == "" {
+			return nil
+		}
+		label, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad label %q", value)
+		}
+		elem.Label = label
+	case "kind":
+		elem.Kind = value
+	case "tags":
+		for _, tag := range strings.Split(value, ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				elem.Tags = append(elem.Tags, tag)
+			}
+		}
+	default:
+		if elem.Prop == nil {
+			elem.Prop = make(map[string]string)
+		}
+		elem.Prop[field[len("prop."):]] = value
+	}
+	return nil
+}
+
+// importer writes imported elements in batches.
+type importer struct {
+	d      *Data
+	ctx    storage.Context
+	batch  *elementBatch
+	report *ImportReport
+
+	unwritten int // elements imported into the current batch
+}
+
+func (d *Data) newImporter(ctx storage.Context) *importer {
+	return &importer{d: d, ctx: ctx, report: &ImportReport{}}
+}
+
+// add stores an element, writing the current batch once it is full.
+func (imp *importer) add(row int, elem *Element) error {
+	if err := imp.d.checkPosition(elem.Pos); err != nil {
+		imp.report.reject(row, err)
+		return nil
+	}
+	if imp.batch == nil {
+		batch, err := newElementBatch(imp.ctx)
+		if err != nil {
+			return err
+		}
+		imp.batch = batch
+	}
+	if err := imp.batch.put(elem); err != nil {
+		return err
+	}
+	imp.report.Imported++
+	imp.unwritten++
+	if imp.batch.size() >= ImportBatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *importer) flush() error {
+	if imp.batch == nil {
+		return nil
+	}
+	if err := imp.batch.commit(); err != nil {
+		return err
+	}
+	imp.batch = nil
+	imp.unwritten = 0
+	imp.report.Batches++
+	return nil
+}
+
+// finish writes any remaining elements and returns the report, noting the error that
+// stopped the import if any.
+func (imp *importer) finish(err error) *ImportReport {
+	if err == nil {
+		err = imp.flush()
+	}
+	if err != nil {
+		imp.report.Error = err.Error()
+		// Elements of the unwritten batch weren't imported.
+		imp.report.Imported -= imp.unwritten
+	}
+	return imp.report
+}
+
+// ImportCSV imports elements from CSV rows with fields given by the column mapping.
+// If header is true, the first row gives column names that can be used in the mapping.
+func (d *Data) ImportCSV(ctx storage.Context, r io.Reader, columns []importColumn, header bool) *ImportReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	imp := d.newImporter(ctx)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	if header {
+		names, err := reader.Read()
+		if err != nil {
+			return imp.finish(fmt.Errorf("Unable to read CSV header: %s", err.Error()))
+		}
+		imp.report.Rows++
+		for i, column := range columns {
+			if column.source == "" {
+				continue
+			}
+			found := false
+			for n, name := range names {
+				if strings.TrimSpace(name) == column.source {
+					columns[i].index = n
+					found = true
+					break
+				}
+			}
+			if !found {
+				return imp.finish(fmt.Errorf("CSV header has no column %q", column.source))
+			}
+		}
+	} else {
+		for _, column := range columns {
+			if column.source != "" {
+				return imp.finish(fmt.Errorf("Column name %q requires a CSV header", column.source))
+			}
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		imp.report.Rows++
+		row := imp.report.Rows
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				imp.report.reject(row, err)
+				continue
+			}
+			return imp.finish(err)
+		}
+		var elem Element
+		var rowErr error
+		for _, column := range columns {
+			if column.index >= len(record) {
+				rowErr = fmt.Errorf("row has no column %d for %s", column.index+1, column.field)
+				break
+			}
+			if rowErr = setField(&elem, column.field, record[column.index]); rowErr != nil {
+				break
+			}
+		}
+		if rowErr != nil {
+			imp.report.reject(row, rowErr)
+			continue
+		}
+		if err := imp.add(row, &elem); err != nil {
+			return imp.finish(err)
+		}
+	}
+	return imp.finish(nil)
+}
+
+// ImportJSON imports elements from a JSON array of objects with fields given by the
+// column mapping.  The array is decoded one object at a time.
+func (d *Data) ImportJSON(ctx storage.Context, r io.Reader, columns []importColumn) *ImportReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	imp := d.newImporter(ctx)
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		return imp.finish(fmt.Errorf("Import JSON must be an array of objects"))
+	}
+	for dec.More() {
+		imp.report.Rows++
+		row := imp.report.Rows
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			// The decoder can't recover from malformed JSON.
+			return imp.finish(fmt.Errorf("Bad JSON at row %d: %s", row, err.Error()))
+		}
+		var elem Element
+		var rowErr error
+		for _, column := range columns {
+			value, found := obj[column.jsonKey()]
+			if !found {
+				if column.field == "x" || column.field == "y" || column.field == "z" {
+					rowErr = fmt.Errorf("object has no %q for %s", column.jsonKey(), column.field)
+					break
+				}
+				continue
+			}
+			var s string
+			switch v := value.(type) {
+			case string:
+				s = v
+			case json.Number:
+				s = v.String()
+			case []interface{}:
+				if column.field != "tags" {
+					rowErr = fmt.Errorf("%q must not be an array", column.jsonKey())
+					break
+				}
+				tags := make([]string, len(v))
+				for i, tag := range v {
+					tags[i] = fmt.Sprintf("%v", tag)
+				}
+				s = strings.Join(tags, ";")
+			case nil:
+				continue
+			default:
+				s = fmt.Sprintf("%v", v)
+			}
+			if rowErr != nil {
+				break
+			}
+			if rowErr = setField(&elem, column.field, s); rowErr != nil {
+				break
+			}
+		}
+		if rowErr != nil {
+			imp.report.reject(row, rowErr)
+			continue
+		}
+		if err := imp.add(row, &elem); err != nil {
+			return imp.finish(err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return imp.finish(fmt.Errorf("Import JSON array is not terminated: %s", err.Error()))
+	}
+	return imp.finish(nil)
+}
+
+// String returns a summary of the import report.
+func (report *ImportReport) String() string {
+	text := fmt.Sprintf("%d rows, %d elements imported in %d batches, %d rows rejected",
+		report.Rows, report.Imported, report.Batches, report.Rejected)
+	if report.Error != "" {
+		text += ", stopped: " + report.Error
+	}
+	return text
+}
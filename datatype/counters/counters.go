@@ -0,0 +1,647 @@
+/*
+	Package counters implements DVID support for named counters and accumulators, e.g.,
+	for pipeline bookkeeping like the number of chunks processed, without an external
+	coordination service.  Updates of an instance are serialized so concurrent requests
+	never lose increments, and values are persisted in the SmallData store.
+*/
+package counters
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/message"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	Version  = "0.1"
+	RepoURL  = "github.com/janelia-flyem/dvid/datatype/counters"
+	TypeName = "counters"
+)
+
+const HelpMessage = `
+API for 'counters' datatype (github.com/janelia-flyem/dvid/datatype/counters)
+=============================================================================
+
+Command-line:
+
+$ dvid repo <UUID> new counters <data name> <settings...>
+
+	Adds newly named counters data to repo with specified UUID.
+
+	Example:
+
+	$ dvid repo 3f8c new counters pipeline
+
+    Arguments:
+
+    UUID           Hexidecimal string with enough characters to uniquely identify a version node.
+    data name      Name of data to create, e.g., "pipeline"
+    settings       Configuration settings in "key=value" format separated by spaces.
+
+    Configuration Settings (case-insensitive keys)
+
+    Versioned      "true" or "false" (default)
+
+    ------------------
+
+HTTP API (Level 2 REST):
+
+Counters hold 64-bit integers and accumulators hold the sum, count, minimum, and maximum
+of added floating-point samples.  Counters and accumulators are created by their first
+update and read as zero before then.  Updates of an instance are applied one at a time,
+so concurrent clients can update the same counter without losing changes.
+
+GET  <api URL>/node/<UUID>/<data name>/help
+
+	Returns data-specific help message.
+
+GET  <api URL>/node/<UUID>/<data name>/info
+
+    Returns JSON with configuration settings.
+
+GET  <api URL>/node/<UUID>/<data name>/counters[?prefix=<prefix>]
+
+	Returns JSON mapping the names of all counters, or those beginning with the prefix,
+	to their values:
+
+	{ "chunks-done": 1832, "chunks-failed": 3 }
+
+GET  <api URL>/node/<UUID>/<data name>/counter/<name>
+DEL  <api URL>/node/<UUID>/<data name>/counter/<name>
+
+    Returns JSON with the value of a counter or deletes the counter.
+
+    Example:
+
+    GET <api URL>/node/3f8c/pipeline/counter/chunks-done
+
+    Returns:
+
+    { "Name": "chunks-done", "Value": 1832 }
+
+POST <api URL>/node/<UUID>/<data name>/counter/<name>/increment
+POST <api URL>/node/<UUID>/<data name>/counter/<name>/decrement
+POST <api URL>/node/<UUID>/<data name>/counter/<name>/add?delta=<integer>
+POST <api URL>/node/<UUID>/<data name>/counter/<name>/set?value=<integer>
+
+    Atomically changes a counter by 1, -1, or the given delta, or sets it to a value,
+    and returns JSON with the counter's new value as for GET.
+
+GET  <api URL>/node/<UUID>/<data name>/accumulators[?prefix=<prefix>]
+
+	Returns JSON mapping the names of all accumulators, or those beginning with the
+	prefix, to their statistics as for GET of an accumulator.
+
+GET  <api URL>/node/<UUID>/<data name>/accumulator/<name>
+DEL  <api URL>/node/<UUID>/<data name>/accumulator/<name>
+
+    Returns JSON with the statistics of an accumulator or deletes the accumulator.
+
+    Example:
+
+    GET <api URL>/node/3f8c/pipeline/accumulator/chunk-secs
+
+    Returns:
+
+    { "Name": "chunk-secs", "Sum": 2741.5, "Count": 1832, "Min": 0.8, "Max": 12.1, "Mean": 1.4965 }
+
+POST <api URL>/node/<UUID>/<data name>/accumulator/<name>/add?value=<number>
+
+    Atomically adds a sample to an accumulator and returns JSON with its new statistics.
+`
+
+func init() {
+	datastore.Register(NewType())
+
+	// Need to register types that will be used to fulfill interfaces.
+	gob.Register(&Type{})
+	gob.Register(&Data{})
+}
+
+// Type embeds the datastore's Type to create a unique type for counters functions.
+type Type struct {
+	datastore.Type
+}
+
+// NewType returns a pointer to a new counters Type with default values set.
+func NewType() *Type {
+	dtype := new(Type)
+	dtype.Type = datastore.Type{
+		Name:    TypeName,
+		URL:     RepoURL,
+		Version: Version,
+		Requirements: &storage.Requirements{
+			Batcher: true,
+		},
+	}
+	return dtype
+}
+
+// --- TypeService interface ---
+
+// NewDataService returns a pointer to new counters data with default values.
+func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Config) (datastore.DataService, error) {
+	basedata, err := datastore.NewDataService(dtype, uuid, id, name, c)
+	if err != nil {
+		return nil, err
+	}
+	return &Data{Data: basedata}, nil
+}
+
+func (dtype *Type) Help() string {
+	return fmt.Sprintf(HelpMessage)
+}
+
+// The first byte of indices, which separates counters from accumulators.
+const (
+	counterIndex     byte = 1
+	accumulatorIndex byte = 2
+)
+
+func newIndex(kind byte, name string) []byte {
+	return append([]byte{kind}, name...)
+}
+
+// Counter is a named 64-bit integer.
+type Counter struct {
+	Name  string
+	Value int64
+}
+
+// Accumulator holds statistics of samples added under a name.
+type Accumulator struct {
+	Name  string
+	Sum   float64
+	Count uint64
+	Min   float64
+	Max   float64
+}
+
+// Mean returns the mean of the samples or zero if there are none.
+func (a Accumulator) Mean() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// Add adds a sample.
+func (a *Accumulator) Add(value float64) {
+	if a.Count == 0 || value < a.Min {
+		a.Min = value
+	}
+	if a.Count == 0 || value > a.Max {
+		a.Max = value
+	}
+	a.Sum += value
+	a.Count++
+}
+
+func (a Accumulator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name  string
+		Sum   float64
+		Count uint64
+		Min   float64
+		Max   float64
+		Mean  float64
+	}{a.Name, a.Sum, a.Count, a.Min, a.Max, a.Mean()})
+}
+
+func (a Accumulator) encode() []byte {
+	b := make([]byte, 32)
+	binary.LittleEndian.PutUint64(b[0:8], math.Float64bits(a.Sum))
+	binary.LittleEndian.PutUint64(b[8:16], a.Count)
+	binary.LittleEndian.PutUint64(b[16:24], math.Float64bits(a.Min))
+	binary.LittleEndian.PutUint64(b[24:32], math.Float64bits(a.Max))
+	return b
+}
+
+func (a *Accumulator) decode(b []byte) error {
+	if len(b) != 32 {
+		return fmt.Errorf("Accumulator %q has bad stored value of %d bytes", a.Name, len(b))
+	}
+	a.Sum = math.Float64frombits(binary.LittleEndian.Uint64(b[0:8]))
+	a.Count = binary.LittleEndian.Uint64(b[8:16])
+	a.Min = math.Float64frombits(binary.LittleEndian.Uint64(b[16:24]))
+	a.Max = math.Float64frombits(binary.LittleEndian.Uint64(b[24:32]))
+	return nil
+}
+
+func decodeCounter(name string, b []byte) (Counter, error) {
+	if len(b) != 8 {
+		return Counter{}, fmt.Errorf("Counter %q has bad stored value of %d bytes", name, len(b))
+	}
+	return Counter{name, int64(binary.LittleEndian.Uint64(b))}, nil
+}
+
+func encodeCounter(c Counter) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(c.Value))
+	return b
+}
+
+// Data embeds the datastore's Data.  Counters have no extended properties.
+type Data struct {
+	*datastore.Data
+
+	// Serializes read-modify-write updates of counters and accumulators.
+	mu sync.Mutex
+}
+
+func (d *Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Base     *datastore.Data
+		Extended struct{}
+	}{
+		d.Data,
+		struct{}{},
+	})
+}
+
+func (d *Data) GobDecode(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	dec := gob.NewDecoder(buf)
+	return dec.Decode(&(d.Data))
+}
+
+func (d *Data) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(d.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GetCounter returns the value of a counter, which is zero if it hasn't been set.
+func (d *Data) GetCounter(ctx storage.Context, name string) (Counter, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return Counter{}, err
+	}
+	value, err := db.Get(ctx, newIndex(counterIndex, name))
+	if err != nil {
+		return Counter{}, err
+	}
+	if value == nil {
+		return Counter{Name: name}, nil
+	}
+	return decodeCounter(name, value)
+}
+
+// AddCounter atomically adds a delta to a counter and returns its new value.
+func (d *Data) AddCounter(ctx storage.Context, name string, delta int64) (Counter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, err := d.GetCounter(ctx, name)
+	if err != nil {
+		return Counter{}, err
+	}
+	c.Value += delta
+	if err := d.putCounter(ctx, c); err != nil {
+		return Counter{}, err
+	}
+	return c, nil
+}
+
+// SetCounter sets the value of a counter.
+func (d *Data) SetCounter(ctx storage.Context, name string, value int64) (Counter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := Counter{name, value}
+	if err := d.putCounter(ctx, c); err != nil {
+		return Counter{}, err
+	}
+	return c, nil
+}
+
+func (d *Data) putCounter(ctx storage.Context, c Counter) error {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return err
+	}
+	return db.Put(ctx, newIndex(counterIndex, c.Name), encodeCounter(c))
+}
+
+// GetAccumulator returns the statistics of an accumulator, which are zero if no samples
+// have been added.
+func (d *Data) GetAccumulator(ctx storage.Context, name string) (Accumulator, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return Accumulator{}, err
+	}
+	value, err := db.Get(ctx, newIndex(accumulatorIndex, name))
+	if err != nil {
+		return Accumulator{}, err
+	}
+	a := Accumulator{Name: name}
+	if value == nil {
+		return a, nil
+	}
+	if err := a.decode(value); err != nil {
+		return Accumulator{}, err
+	}
+	return a, nil
+}
+
+// AddSample atomically adds a sample to an accumulator and returns its new statistics.
+func (d *Data) AddSample(ctx storage.Context, name string, value float64) (Accumulator, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, err := d.GetAccumulator(ctx, name)
+	if err != nil {
+		return Accumulator{}, err
+	}
+	a.Add(value)
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return Accumulator{}, err
+	}
+	if err := db.Put(ctx, newIndex(accumulatorIndex, name), a.encode()); err != nil {
+		return Accumulator{}, err
+	}
+	return a, nil
+}
+
+// delete deletes a counter or accumulator.
+func (d *Data) delete(ctx storage.Context, kind byte, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return err
+	}
+	return db.Delete(ctx, newIndex(kind, name))
+}
+
+// getRange returns the names and stored values of counters or accumulators with names
+// beginning with the prefix.
+func getRange(ctx storage.Context, kind byte, prefix string) ([]string, [][]byte, error) {
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	begin := newIndex(kind, prefix)
+	end := newIndex(kind, prefix+"\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF")
+	kvs, err := db.GetRange(ctx, begin, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	var values [][]byte
+	for _, kv := range kvs {
+		index, err := ctx.IndexFromKey(kv.K)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(index) == 0 || index[0] != kind || !strings.HasPrefix(string(index[1:]), prefix) {
+			continue
+		}
+		names = append(names, string(index[1:]))
+		values = append(values, kv.V)
+	}
+	return names, values, nil
+}
+
+// GetCounters returns the values of all counters with names beginning with the prefix.
+func (d *Data) GetCounters(ctx storage.Context, prefix string) (map[string]int64, error) {
+	names, values, err := getRange(ctx, counterIndex, prefix)
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]int64, len(names))
+	for i, name := range names {
+		c, err := decodeCounter(name, values[i])
+		if err != nil {
+			return nil, err
+		}
+		counters[name] = c.Value
+	}
+	return counters, nil
+}
+
+// GetAccumulators returns all accumulators with names beginning with the prefix.
+func (d *Data) GetAccumulators(ctx storage.Context, prefix string) (map[string]Accumulator, error) {
+	names, values, err := getRange(ctx, accumulatorIndex, prefix)
+	if err != nil {
+		return nil, err
+	}
+	accumulators := make(map[string]Accumulator, len(names))
+	for i, name := range names {
+		a := Accumulator{Name: name}
+		if err := a.decode(values[i]); err != nil {
+			return nil, err
+		}
+		accumulators[name] = a
+	}
+	return accumulators, nil
+}
+
+// --- DataService interface ---
+
+func (d *Data) Help() string {
+	return fmt.Sprintf(HelpMessage)
+}
+
+// Send transfers all key-value pairs pertinent to this data type as well as
+// the storage.DataStoreType for them.
+func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
+	dvid.Criticalf("counters.Send() is not implemented yet, so push/pull will not work for this data type.\n")
+	return nil
+}
+
+// DoRPC acts as a switchboard for RPC commands.
+func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
+	return fmt.Errorf("Unknown command.  Data '%s' [%s] does not support '%s' command.",
+		d.DataName(), d.TypeName(), request.TypeCommand())
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+// ServeHTTP handles all incoming HTTP requests for this data.
+func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
+	timedLog := datastore.NewTimeLog(requestCtx)
+
+	// Get repo and version ID of this request
+	_, versions, err := datastore.FromContext(requestCtx)
+	if err != nil {
+		server.BadRequest(w, r, "Error: %q ServeHTTP has invalid context: %s\n", d.DataName(), err.Error())
+		return
+	}
+
+	// Construct storage.Context using a particular version of this Data
+	var versionID dvid.VersionID
+	if len(versions) > 0 {
+		versionID = versions[0]
+	}
+	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetClient(datastore.ClientFromContext(requestCtx))
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
+
+	// Break URL request into arguments
+	url := r.URL.Path[len(server.WebAPIPath):]
+	parts := strings.Split(url, "/")
+	if len(parts[len(parts)-1]) == 0 {
+		parts = parts[:len(parts)-1]
+	}
+
+	if len(parts) < 4 {
+		server.BadRequest(w, r, "incomplete API specification")
+		return
+	}
+
+	method := strings.ToLower(r.Method)
+	switch parts[3] {
+	case "help":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, d.Help())
+
+	case "info":
+		jsonBytes, err := d.MarshalJSON()
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+
+	case "counters":
+		counters, err := d.GetCounters(storeCtx, r.URL.Query().Get("prefix"))
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, counters)
+		timedLog.Infof("HTTP GET %d counters of %q (%s)", len(counters), d.DataName(), url)
+
+	case "accumulators":
+		accumulators, err := d.GetAccumulators(storeCtx, r.URL.Query().Get("prefix"))
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, accumulators)
+		timedLog.Infof("HTTP GET %d accumulators of %q (%s)", len(accumulators), d.DataName(), url)
+
+	case "counter":
+		// GET, DELETE, or POST <api URL>/node/<UUID>/<data name>/counter/<name>[/<op>]
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "counter must be followed by a counter name")
+			return
+		}
+		name := parts[4]
+		var c Counter
+		switch {
+		case method == "get" && len(parts) == 5:
+			c, err = d.GetCounter(storeCtx, name)
+		case method == "delete" && len(parts) == 5:
+			if err := d.delete(storeCtx, counterIndex, name); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			timedLog.Infof("HTTP DELETE counter %q of %q", name, d.DataName())
+			return
+		case method == "post" && len(parts) == 6:
+			c, err = d.updateCounter(storeCtx, r, name, parts[5])
+		default:
+			server.BadRequest(w, r, "Counters allow GET or DELETE of counter/<name> or POST of counter/<name>/<op>")
+			return
+		}
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, c)
+		timedLog.Infof("HTTP %s counter %q of %q = %d (%s)", r.Method, name, d.DataName(), c.Value, url)
+
+	case "accumulator":
+		// GET, DELETE, or POST <api URL>/node/<UUID>/<data name>/accumulator/<name>[/add]
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "accumulator must be followed by an accumulator name")
+			return
+		}
+		name := parts[4]
+		var a Accumulator
+		switch {
+		case method == "get" && len(parts) == 5:
+			a, err = d.GetAccumulator(storeCtx, name)
+		case method == "delete" && len(parts) == 5:
+			if err := d.delete(storeCtx, accumulatorIndex, name); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			timedLog.Infof("HTTP DELETE accumulator %q of %q", name, d.DataName())
+			return
+		case method == "post" && len(parts) == 6 && parts[5] == "add":
+			var value float64
+			value, err = strconv.ParseFloat(r.URL.Query().Get("value"), 64)
+			if err != nil {
+				server.BadRequest(w, r, "Accumulator add requires a numeric 'value' query string")
+				return
+			}
+			a, err = d.AddSample(storeCtx, name, value)
+		default:
+			server.BadRequest(w, r, "Counters allow GET or DELETE of accumulator/<name> or POST of accumulator/<name>/add")
+			return
+		}
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeJSON(w, r, a)
+		timedLog.Infof("HTTP %s accumulator %q of %q (%s)", r.Method, name, d.DataName(), url)
+
+	default:
+		server.BadRequest(w, r, "Unrecognized API call '%s' for counters data '%s'.  See API help.",
+			parts[3], d.DataName())
+	}
+}
+
+// updateCounter applies a POSTed counter operation.
+func (d *Data) updateCounter(ctx storage.Context, r *http.Request, name, op string) (Counter, error) {
+	switch op {
+	case "increment":
+		return d.AddCounter(ctx, name, 1)
+	case "decrement":
+		return d.AddCounter(ctx, name, -1)
+	case "add":
+		delta, err := strconv.ParseInt(r.URL.Query().Get("delta"), 10, 64)
+		if err != nil {
+			return Counter{}, fmt.Errorf("Counter add requires an integer 'delta' query string")
+		}
+		return d.AddCounter(ctx, name, delta)
+	case "set":
+		value, err := strconv.ParseInt(r.URL.Query().Get("value"), 10, 64)
+		if err != nil {
+			return Counter{}, fmt.Errorf("Counter set requires an integer 'value' query string")
+		}
+		return d.SetCounter(ctx, name, value)
+	default:
+		return Counter{}, fmt.Errorf("Unknown counter operation %q: expected increment, decrement, add, or set", op)
+	}
+}
@@ -0,0 +1,128 @@
+package counters
+
+import (
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+var (
+	countertype datastore.TypeService
+	testMu      sync.Mutex
+)
+
+// Sets package-level testRepo and TestVersionID
+func initTestRepo() (datastore.Repo, dvid.VersionID) {
+	testMu.Lock()
+	defer testMu.Unlock()
+	if countertype == nil {
+		var err error
+		countertype, err = datastore.TypeServiceByName(TypeName)
+		if err != nil {
+			log.Fatalf("Can't get counters type: %s\n", err)
+		}
+	}
+	return tests.NewRepo()
+}
+
+func newTestData(t *testing.T, name dvid.DataString) (*Data, *datastore.VersionedContext) {
+	repo, versionID := initTestRepo()
+	dataservice, err := repo.NewData(countertype, name, dvid.NewConfig())
+	if err != nil {
+		t.Fatalf("Error creating new counters instance: %s\n", err.Error())
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not counters.Data\n")
+	}
+	return data, datastore.NewVersionedContext(data, versionID)
+}
+
+func TestConcurrentIncrements(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	data, ctx := newTestData(t, "pipeline")
+
+	const numWorkers = 8
+	const numIncrements = 50
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numIncrements; j++ {
+				if _, err := data.AddCounter(ctx, "chunks", 1); err != nil {
+					t.Errorf("Error incrementing counter: %s\n", err.Error())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, err := data.GetCounter(ctx, "chunks")
+	if err != nil {
+		t.Fatalf("Error getting counter: %s\n", err.Error())
+	}
+	if c.Value != numWorkers*numIncrements {
+		t.Errorf("Expected counter value %d, got %d\n", numWorkers*numIncrements, c.Value)
+	}
+
+	if c, err = data.AddCounter(ctx, "chunks", -400); err != nil || c.Value != 0 {
+		t.Errorf("Expected counter value 0 after adding -400, got %d (%v)\n", c.Value, err)
+	}
+	if c, err = data.GetCounter(ctx, "never-set"); err != nil || c.Value != 0 {
+		t.Errorf("Expected unset counter to be 0, got %d (%v)\n", c.Value, err)
+	}
+}
+
+func TestAccumulatorsAndListing(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	data, ctx := newTestData(t, "stats")
+
+	for _, value := range []float64{2.5, -1, 4} {
+		if _, err := data.AddSample(ctx, "secs", value); err != nil {
+			t.Fatalf("Error adding sample: %s\n", err.Error())
+		}
+	}
+	a, err := data.GetAccumulator(ctx, "secs")
+	if err != nil {
+		t.Fatalf("Error getting accumulator: %s\n", err.Error())
+	}
+	if a.Count != 3 || a.Sum != 5.5 || a.Min != -1 || a.Max != 4 {
+		t.Errorf("Bad accumulator statistics: %v\n", a)
+	}
+
+	for _, name := range []string{"a-done", "a-failed", "b-done"} {
+		if _, err := data.SetCounter(ctx, name, 7); err != nil {
+			t.Fatalf("Error setting counter: %s\n", err.Error())
+		}
+	}
+	counters, err := data.GetCounters(ctx, "a-")
+	if err != nil {
+		t.Fatalf("Error listing counters: %s\n", err.Error())
+	}
+	if len(counters) != 2 || counters["a-done"] != 7 || counters["a-failed"] != 7 {
+		t.Errorf("Bad counters with prefix 'a-': %v\n", counters)
+	}
+	accumulators, err := data.GetAccumulators(ctx, "")
+	if err != nil {
+		t.Fatalf("Error listing accumulators: %s\n", err.Error())
+	}
+	if len(accumulators) != 1 || accumulators["secs"].Count != 3 {
+		t.Errorf("Bad accumulators: %v\n", accumulators)
+	}
+
+	if err := data.delete(ctx, counterIndex, "a-done"); err != nil {
+		t.Fatalf("Error deleting counter: %s\n", err.Error())
+	}
+	if counters, err = data.GetCounters(ctx, ""); err != nil || len(counters) != 2 {
+		t.Errorf("Expected 2 counters after delete, got %v (%v)\n", counters, err)
+	}
+}
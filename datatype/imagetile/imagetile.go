@@ -0,0 +1,57 @@
+/*
+	Package imagetile implements DVID support for precomputed XY, XZ, and YZ image tiles at
+	multiple scales for 2d viewers.  It uses the multiscale2d implementation, so tiles are
+	generated from a voxels source via the "generate" command, stored under tile keys for
+	each plane and scale, and served from the same HTTP API.
+*/
+package imagetile
+
+import (
+	"encoding/gob"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/multiscale2d"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	Version  = "0.1"
+	RepoURL  = "github.com/janelia-flyem/dvid/datatype/imagetile"
+	TypeName = "imagetile"
+)
+
+// HelpMessage is the multiscale2d help with this datatype's name.
+var HelpMessage = strings.Replace(multiscale2d.HelpMessage, multiscale2d.TypeName, TypeName, -1)
+
+func init() {
+	datastore.Register(NewType())
+
+	// Need to register types that will be used to fulfill interfaces.
+	gob.Register(&Type{})
+}
+
+// Type embeds the multiscale2d Type, so imagetile instances are multiscale2d.Data.
+type Type struct {
+	multiscale2d.Type
+}
+
+// NewType returns a pointer to a new imagetile Datatype with default values set.
+func NewType() *Type {
+	dtype := &Type{*multiscale2d.NewType()}
+	dtype.Type.Type.Name = TypeName
+	dtype.Type.Type.URL = RepoURL
+	dtype.Type.Type.Version = Version
+	return dtype
+}
+
+// --- TypeService interface ---
+
+// NewDataService returns a pointer to new tile data with default values.
+func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Config) (datastore.DataService, error) {
+	return multiscale2d.NewData(dtype, uuid, id, name, c)
+}
+
+func (dtype *Type) Help() string {
+	return HelpMessage
+}
@@ -0,0 +1,47 @@
+package imagetile
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/multiscale2d"
+	_ "github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestImagetileInstance(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, _ := tests.NewRepo()
+	grayscaleT, err := datastore.TypeServiceByName("grayscale8")
+	if err != nil {
+		t.Fatalf("Can't get grayscale type: %s\n", err.Error())
+	}
+	if _, err := repo.NewData(grayscaleT, "grayscale", dvid.NewConfig()); err != nil {
+		t.Fatalf("Unable to create grayscale instance: %s\n", err.Error())
+	}
+
+	tileT, err := datastore.TypeServiceByName(TypeName)
+	if err != nil {
+		t.Fatalf("Can't get imagetile type: %s\n", err.Error())
+	}
+	config := dvid.NewConfig()
+	config.Set("Format", "png")
+	config.Set("Source", "grayscale")
+	dataservice, err := repo.NewData(tileT, "tiles", config)
+	if err != nil {
+		t.Fatalf("Unable to create imagetile instance: %s\n", err.Error())
+	}
+	tiles, ok := dataservice.(*multiscale2d.Data)
+	if !ok {
+		t.Fatalf("Can't cast imagetile data service into multiscale2d.Data\n")
+	}
+	if tiles.TypeName() != TypeName {
+		t.Errorf("Expected imagetile instance to have type %q, got %q\n", TypeName, tiles.TypeName())
+	}
+	if tiles.Source != "grayscale" || tiles.Encoding != multiscale2d.PNG {
+		t.Errorf("Bad imagetile properties: %v\n", tiles.Properties)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+	This file implements keyvalue mutations within atomic multi-instance commits.
+*/
+
+package keyvalue
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// mutationArgs are the arguments of a keyvalue mutation.  A put stores either a JSON
+// Value, which is stored as a JSON value, or base64-encoded Data.
+type mutationArgs struct {
+	Key    string
+	Value  json.RawMessage
+	Data   []byte
+	Schema string
+}
+
+// PrepareMutation returns the writes for a "put" or "delete" of a key.
+func (d *Data) PrepareMutation(versionID dvid.VersionID, action string, args json.RawMessage) (*datastore.PreparedMutation, error) {
+	var margs mutationArgs
+	if err := json.Unmarshal(args, &margs); err != nil {
+		return nil, fmt.Errorf("Bad arguments for keyvalue mutation: %s", err.Error())
+	}
+	if margs.Key == "" {
+		return nil, fmt.Errorf("Keyvalue mutation requires a Key")
+	}
+	index, err := d.getIndex(margs.Key)
+	if err != nil {
+		return nil, err
+	}
+	key := datastore.NewVersionedContext(d, versionID).ConstructKey(index)
+
+	switch action {
+	case "put":
+		value := []byte(margs.Data)
+		var contentType string
+		if len(margs.Value) != 0 {
+			if margs.Data != nil {
+				return nil, fmt.Errorf("Keyvalue put can have a Value or Data but not both")
+			}
+			value = []byte(margs.Value)
+			contentType = jsonContentType
+		}
+		meta, err := NewValueMeta(contentType, margs.Schema, value)
+		if err != nil {
+			return nil, err
+		}
+		serialization, err := dvid.SerializeData(encodeValue(value, meta), d.Compression(), d.Checksum())
+		if err != nil {
+			return nil, fmt.Errorf("Unable to serialize data: %s\n", err.Error())
+		}
+		return &datastore.PreparedMutation{Writes: []storage.KeyValue{{key, serialization}}}, nil
+	case "delete":
+		return &datastore.PreparedMutation{Writes: []storage.KeyValue{{key, nil}}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown keyvalue mutation %q: expected put or delete", action)
+	}
+}
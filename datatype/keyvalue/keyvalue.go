@@ -52,6 +52,10 @@ $ dvid repo <UUID> new keyvalue <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
+    Encrypted      "true" or "false" (default).  Encrypts stored values if the server
+                     configures encryption at rest.
+    Durability     "sync", "periodic", or "async" durability of writes that don't request
+                     one via the "durability" query string.  Default is the server's.
     MaxKeySize     Maximum size of keys in terms of characters.  Default is 20.
 
 $ dvid node <UUID> <data name> mount <directory>
@@ -94,44 +98,112 @@ POST <api URL>/node/<UUID>/<data name>/info
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of voxels data.
 
-GET  <api URL>/node/<UUID>/<data name>/keys
+GET  <api URL>/node/<UUID>/<data name>/keys[?prefix=<prefix>]
 
 	Returns all keys for this data instance in JSON format:
 
 	[key1, key2, ...]
 
-GET  <api URL>/node/<UUID>/<data name>/<key>[/<key2>]
-POST <api URL>/node/<UUID>/<data name>/<key>
-DEL  <api URL>/node/<UUID>/<data name>/<key> 
+	If a prefix is given, only keys beginning with the prefix are returned.
+
+GET  <api URL>/node/<UUID>/<data name>/keyrange/<key1>/<key2>
+
+	Returns all keys between 'key1' and 'key2', inclusive, in JSON format.
+
+GET  <api URL>/node/<UUID>/<data name>/key/<key>
+POST <api URL>/node/<UUID>/<data name>/key/<key>[?schema=<schema>]
+DEL  <api URL>/node/<UUID>/<data name>/key/<key> 
 
-    Performs operations on a key-value pair depending on the HTTP verb.  Note that "info" and "keys"
-    are reserved key values.  In the future, this API call will be deprecated in favor of explicit
-    "key" and "keyrange" endpoints.
+    Performs operations on a key-value pair depending on the HTTP verb.
 
     Example: 
 
-    GET <api URL>/node/3f8c/stuff/mykey
+    GET <api URL>/node/3f8c/stuff/key/mykey
 
     Returns the data associated with the key "mykey" of the data "stuff" in version
     node 3f8c.
 
-    The "Content-type" of the HTTP response (and usually the request) are
-    "application/octet-stream" for arbitrary binary data.
+    Values are arbitrary binary data unless POSTed with "Content-type" of
+    "application/json", in which case the value must be valid JSON and is returned
+    with that "Content-type".  Other values are returned as "application/octet-stream".
 
     Arguments:
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of data to add/retrieve.
     key           An alphanumeric key.
-    key2          If given, return value is a JSON list of all keys between 'key' and 'key2'
+
+    Query-string Options:
+
+    schema        Optional tag naming the schema of a POSTed value, e.g., "synapse-v2".
+                  The tag is returned in the "X-Dvid-Schema" header of GET responses.
+
+GET  <api URL>/node/<UUID>/<data name>/<key>[/<key2>]
+POST <api URL>/node/<UUID>/<data name>/<key>
+DEL  <api URL>/node/<UUID>/<data name>/<key> 
+
+    Deprecated forms of the "key" and "keyrange" endpoints above.  Note that "info", "keys",
+    "key", and "keyrange" are reserved key values for these forms.
 `
 
 var (
 	minKey, maxKey string
 )
 
+// keyListSchema is the JSON schema of a list of keys.
+var keyListSchema = map[string]interface{}{
+	"type":  "array",
+	"items": map[string]interface{}{"type": "string"},
+}
+
+// Endpoints are the keyvalue HTTP endpoints beyond "help" and "info".  The deprecated
+// forms with the key following the data name aren't declared.
+var Endpoints = []datastore.Endpoint{
+	{
+		Method:  "GET",
+		Path:    "keys",
+		Summary: "Returns all keys, optionally only those beginning with a prefix.",
+		Params: []datastore.EndpointParam{
+			{Name: "prefix", In: "query", Type: "string", Description: "Prefix of returned keys."},
+		},
+		Response: &datastore.EndpointBody{ContentType: "application/json", Schema: keyListSchema},
+	},
+	{
+		Method:   "GET",
+		Path:     "keyrange/{key1}/{key2}",
+		Summary:  "Returns all keys between two keys, inclusive.",
+		Response: &datastore.EndpointBody{ContentType: "application/json", Schema: keyListSchema},
+	},
+	{
+		Method:      "GET",
+		Path:        "key/{key}",
+		Summary:     "Returns the value of a key.",
+		Description: "JSON values are returned with their POSTed content type and any schema tag in the X-Dvid-Schema header.",
+		Response:    &datastore.EndpointBody{ContentType: "application/octet-stream"},
+	},
+	{
+		Method:  "POST",
+		Path:    "key/{key}",
+		Summary: "Stores a value under a key.",
+		Params: []datastore.EndpointParam{
+			{Name: "schema", In: "query", Type: "string", Description: "Tag naming the schema of the value."},
+		},
+		Request: &datastore.EndpointBody{
+			ContentType: "application/octet-stream",
+			Description: "Arbitrary binary value, or valid JSON if POSTed with an application/json content type.",
+		},
+	},
+	{
+		Method:  "DELETE",
+		Path:    "key/{key}",
+		Summary: "Deletes a key and its value.",
+	},
+}
+
 func init() {
-	datastore.Register(NewType())
+	dtype := NewType()
+	datastore.Register(dtype)
+	datastore.RegisterEndpoints(dtype, Endpoints...)
 
 	// Need to register types that will be used to fulfill interfaces.
 	gob.Register(&Type{})
@@ -292,38 +364,77 @@ func (d *Data) GetKeysInRange(ctx storage.Context, keyBeg, keyEnd string) ([]str
 	return keyList, nil
 }
 
+// GetKeysWithPrefix returns all keys that begin with the given prefix.
+func (d *Data) GetKeysWithPrefix(ctx storage.Context, prefix string) ([]string, error) {
+	if prefix == "" {
+		return d.GetKeysInRange(ctx, minKey, maxKey)
+	}
+	if d.Versioned() && len(prefix) > d.MaxKeySize {
+		return nil, fmt.Errorf("Prefix %q is longer than the max key size %d of data instance %q",
+			prefix, d.MaxKeySize, d.DataName())
+	}
+	// The last key of the range is the prefix followed by the largest possible suffix.
+	last := prefix + maxKey
+	if d.Versioned() {
+		last = prefix + strings.Repeat("\xFF", d.MaxKeySize-len(prefix))
+	}
+	keys, err := d.GetKeysInRange(ctx, prefix, last)
+	if err != nil {
+		return nil, err
+	}
+	matched := []string{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
+
 // GetData gets a value using a key
 func (d *Data) GetData(ctx storage.Context, keyStr string) ([]byte, bool, error) {
+	value, _, found, err := d.GetValue(ctx, keyStr)
+	return value, found, err
+}
+
+// GetValue gets a value and its metadata using a key.
+func (d *Data) GetValue(ctx storage.Context, keyStr string) ([]byte, ValueMeta, bool, error) {
 	db, err := storage.BigDataStore()
 	if err != nil {
-		return nil, false, err
+		return nil, ValueMeta{}, false, err
 	}
 	index, err := d.getIndex(keyStr)
 	if err != nil {
-		return nil, false, err
+		return nil, ValueMeta{}, false, err
 	}
 	data, err := db.Get(ctx, []byte(index))
 	if err != nil {
-		return nil, false, fmt.Errorf("Error in retrieving key '%s': %s", keyStr, err.Error())
+		return nil, ValueMeta{}, false, fmt.Errorf("Error in retrieving key '%s': %s", keyStr, err.Error())
 	}
 	if data == nil {
-		return nil, false, nil
+		return nil, ValueMeta{}, false, nil
 	}
 	uncompress := true
-	value, _, err := dvid.DeserializeData(data, uncompress)
+	stored, _, err := dvid.DeserializeData(data, uncompress)
 	if err != nil {
-		return nil, false, fmt.Errorf("Unable to deserialize data for key '%s': %s\n", keyStr, err.Error())
+		return nil, ValueMeta{}, false, fmt.Errorf("Unable to deserialize data for key '%s': %s\n", keyStr, err.Error())
 	}
-	return value, true, nil
+	value, meta := decodeValue(stored)
+	return value, meta, true, nil
 }
 
 // PutData puts a key-value at a given uuid
 func (d *Data) PutData(ctx storage.Context, keyStr string, value []byte) error {
+	return d.PutValue(ctx, keyStr, value, ValueMeta{})
+}
+
+// PutValue puts a key-value with metadata at a given uuid.
+func (d *Data) PutValue(ctx storage.Context, keyStr string, value []byte, meta ValueMeta) error {
 	db, err := storage.BigDataStore()
 	if err != nil {
 		return err
 	}
-	serialization, err := dvid.SerializeData(value, d.Compression(), d.Checksum())
+	serialization, err := dvid.SerializeData(encodeValue(value, meta), d.Compression(), d.Checksum())
 	if err != nil {
 		return fmt.Errorf("Unable to serialize data: %s\n", err.Error())
 	}
@@ -347,6 +458,35 @@ func (d *Data) DeleteData(ctx storage.Context, keyStr string) error {
 	return db.Delete(ctx, []byte(index))
 }
 
+// IngestBatch applies key-value operations sent through an RPC batch session.
+func (d *Data) IngestBatch(ctx *datastore.VersionedContext, ops []datastore.BatchOp) error {
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("Unable to store key-value pairs: big data store can't do batching")
+	}
+	batch := batcher.NewBatch(ctx)
+	for _, op := range ops {
+		index, err := d.getIndex(op.Key)
+		if err != nil {
+			return err
+		}
+		if op.Delete {
+			batch.Delete([]byte(index))
+			continue
+		}
+		serialization, err := dvid.SerializeData(encodeValue(op.Value, ValueMeta{}), d.Compression(), d.Checksum())
+		if err != nil {
+			return fmt.Errorf("Unable to serialize data: %s\n", err.Error())
+		}
+		batch.Put([]byte(index), serialization)
+	}
+	return batch.Commit()
+}
+
 // put handles a PUT command-line request.
 func (d *Data) put(cmd datastore.Request, reply *datastore.Response) error {
 	if len(cmd.Command) < 5 {
@@ -415,7 +555,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(requestCtx)
 
 	// Get repo and version ID of this request
 	_, versions, err := datastore.FromContext(requestCtx)
@@ -430,6 +570,9 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetClient(datastore.ClientFromContext(requestCtx))
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
 
 	// Break URL request into arguments
 	url := r.URL.Path[len(server.WebAPIPath):]
@@ -459,87 +602,115 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		fmt.Fprintf(w, jsonStr)
 		return
 	case "keys":
-		keyList, err := d.GetKeysInRange(storeCtx, minKey, maxKey)
+		keyList, err := d.GetKeysWithPrefix(storeCtx, r.URL.Query().Get("prefix"))
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		jsonBytes, err := json.Marshal(keyList)
+		writeKeys(w, r, keyList)
+		return
+	case "keyrange":
+		if len(parts) < 6 {
+			server.BadRequest(w, r, "keyrange must be followed by two keys")
+			return
+		}
+		keyList, err := d.GetKeysInRange(storeCtx, parts[4], parts[5])
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, string(jsonBytes))
+		writeKeys(w, r, keyList)
+		return
+	case "key":
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "key must be followed by a key name")
+			return
+		}
+		if comment, ok := d.serveKey(storeCtx, w, r, parts[4]); ok {
+			timedLog.Infof("%s (%s)\n", comment, url)
+		}
 		return
 	default:
 	}
 
-	// Get the key and process request
-	var comment string
+	// Legacy endpoint where the key follows the data name.
 	keyStr := parts[3]
+	if strings.ToLower(r.Method) == "get" && len(parts) > 4 {
+		// Return JSON list of keys
+		keyList, err := d.GetKeysInRange(storeCtx, keyStr, parts[4])
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		writeKeys(w, r, keyList)
+		return
+	}
+	if comment, ok := d.serveKey(storeCtx, w, r, keyStr); ok {
+		timedLog.Infof("%s (%s)\n", comment, url)
+	}
+}
+
+func writeKeys(w http.ResponseWriter, r *http.Request, keyList []string) {
+	jsonBytes, err := json.Marshal(keyList)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+// serveKey handles GET, POST and DELETE of a single key, returning a description of
+// the request or false if it failed.
+func (d *Data) serveKey(ctx storage.Context, w http.ResponseWriter, r *http.Request, keyStr string) (string, bool) {
 	switch strings.ToLower(r.Method) {
 	case "get":
-		// TODO: Use one endpoint for now.  Should provide different endpoints:
-		// <name>/value/<key>                  (returns value for a single key)
-		// <name>/keys?min=begKey&max=endKey   (returns keys between endpoints)
-		if len(parts) > 4 {
-			// Return JSON list of keys
-			keyBeg := keyStr
-			keyEnd := parts[4]
-			keyList, err := d.GetKeysInRange(storeCtx, keyBeg, keyEnd)
-			if err != nil {
-				server.BadRequest(w, r, err.Error())
-				return
-			}
-			jsonBytes, err := json.Marshal(keyList)
-			if err != nil {
-				server.BadRequest(w, r, err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, string(jsonBytes))
+		value, meta, found, err := d.GetValue(ctx, keyStr)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return "", false
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("Key '%s' not found", keyStr), http.StatusNotFound)
+			return "", false
+		}
+		if meta.IsJSON() {
+			w.Header().Set("Content-Type", meta.ContentType)
 		} else {
-			// Return value of single key
-			value, found, err := d.GetData(storeCtx, keyStr)
-			if err != nil {
-				server.BadRequest(w, r, err.Error())
-				return
-			}
-			if !found {
-				http.Error(w, fmt.Sprintf("Key '%s' not found", keyStr), http.StatusNotFound)
-				return
-			}
 			w.Header().Set("Content-Type", "application/octet-stream")
-			_, err = w.Write(value)
-			if err != nil {
-				server.BadRequest(w, r, err.Error())
-				return
-			}
-			comment = fmt.Sprintf("HTTP GET key %q of keyvalue %q: %d bytes (%s)\n", keyStr, d.DataName(), len(value), url)
 		}
+		if meta.Schema != "" {
+			w.Header().Set("X-Dvid-Schema", meta.Schema)
+		}
+		if _, err = w.Write(value); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return "", false
+		}
+		return fmt.Sprintf("HTTP GET key %q of keyvalue %q: %d bytes", keyStr, d.DataName(), len(value)), true
 	case "delete":
-		if err := d.DeleteData(storeCtx, keyStr); err != nil {
+		if err := d.DeleteData(ctx, keyStr); err != nil {
 			server.BadRequest(w, r, err.Error())
-			return
+			return "", false
 		}
-		comment = fmt.Sprintf("HTTP DELETE data with key %q of keyvalue %q (%s)\n", keyStr, d.DataName(), url)
+		return fmt.Sprintf("HTTP DELETE data with key %q of keyvalue %q", keyStr, d.DataName()), true
 	case "post":
 		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
-			return
+			return "", false
 		}
-		err = d.PutData(storeCtx, keyStr, data)
+		meta, err := NewValueMeta(r.Header.Get("Content-Type"), r.URL.Query().Get("schema"), data)
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
-			return
+			return "", false
+		}
+		if err = d.PutValue(ctx, keyStr, data, meta); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return "", false
 		}
-		comment = fmt.Sprintf("HTTP POST keyvalue '%s': %d bytes (%s)\n", d.DataName(), len(data), url)
+		return fmt.Sprintf("HTTP POST keyvalue '%s': %d bytes", d.DataName(), len(data)), true
 	default:
-		server.BadRequest(w, r, "Can only handle GET or POST HTTP verbs")
-		return
+		server.BadRequest(w, r, "Can only handle GET, POST, or DELETE HTTP verbs")
+		return "", false
 	}
-
-	timedLog.Infof(comment)
 }
@@ -112,6 +112,41 @@ func TestKeyvalueRoundTrip(t *testing.T) {
 	}
 }
 
+func TestKeyvalueIngestBatch(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	dataservice, err := repo.NewData(kvtype, "ingested", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %s\n", err.Error())
+	}
+	kvdata := dataservice.(*Data)
+	ctx := datastore.NewVersionedContext(dataservice, versionID)
+
+	ops := []datastore.BatchOp{
+		{Key: "a", Value: []byte("apple")},
+		{Key: "b", Value: []byte("banana")},
+		{Key: "c", Value: []byte("cherry")},
+		{Key: "b", Delete: true},
+	}
+	if err := kvdata.IngestBatch(ctx, ops); err != nil {
+		t.Fatalf("Error ingesting batch: %s\n", err.Error())
+	}
+	for key, expected := range map[string]string{"a": "apple", "c": "cherry"} {
+		value, found, err := kvdata.GetData(ctx, key)
+		if err != nil || !found || string(value) != expected {
+			t.Errorf("Bad ingested value for key %q: %q, %t, %v\n", key, value, found, err)
+		}
+	}
+	if _, found, _ := kvdata.GetData(ctx, "b"); found {
+		t.Errorf("Key deleted in batch was found\n")
+	}
+}
+
 func TestKeyvalueRepoPersistence(t *testing.T) {
 	tests.UseStore()
 	defer tests.CloseStore()
@@ -312,3 +347,177 @@ func TestKeyvalueVersioning(t *testing.T) {
 			key1, key2, string(returnValue))
 	}
 }
+
+func TestKeyvalueEndpoints(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	if _, err = repo.NewData(kvtype, "endpoints", config); err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %s\n", err.Error())
+	}
+
+	for _, key := range []string{"cell-a", "cell-b", "cellular", "nucleus"} {
+		keyreq := fmt.Sprintf("%snode/%s/endpoints/key/%s", server.WebAPIPath, uuid, key)
+		server.TestHTTP(t, "POST", keyreq, strings.NewReader("value of "+key))
+	}
+	keyreq := fmt.Sprintf("%snode/%s/endpoints/key/nucleus", server.WebAPIPath, uuid)
+	if returnValue := server.TestHTTP(t, "GET", keyreq, nil); string(returnValue) != "value of nucleus" {
+		t.Errorf("Bad value from key endpoint: %s\n", string(returnValue))
+	}
+
+	var retrievedKeys []string
+	prefixreq := fmt.Sprintf("%snode/%s/endpoints/keys?prefix=cell-", server.WebAPIPath, uuid)
+	if err = json.Unmarshal(server.TestHTTP(t, "GET", prefixreq, nil), &retrievedKeys); err != nil {
+		t.Fatalf("Bad prefix request unmarshal: %s\n", err.Error())
+	}
+	if !reflect.DeepEqual(retrievedKeys, []string{"cell-a", "cell-b"}) {
+		t.Errorf("Bad prefix request return: %v\n", retrievedKeys)
+	}
+
+	rangereq := fmt.Sprintf("%snode/%s/endpoints/keyrange/cell-b/m", server.WebAPIPath, uuid)
+	if err = json.Unmarshal(server.TestHTTP(t, "GET", rangereq, nil), &retrievedKeys); err != nil {
+		t.Fatalf("Bad keyrange request unmarshal: %s\n", err.Error())
+	}
+	if !reflect.DeepEqual(retrievedKeys, []string{"cell-b", "cellular"}) {
+		t.Errorf("Bad keyrange request return: %v\n", retrievedKeys)
+	}
+}
+
+func TestKeyvalueJSONValues(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	config := dvid.NewConfig()
+	dataservice, err := repo.NewData(kvtype, "jsonvalues", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %s\n", err.Error())
+	}
+	kvdata := dataservice.(*Data)
+	ctx := datastore.NewVersionedContext(dataservice, versionID)
+
+	if _, err = NewValueMeta("application/json", "", []byte(`{"bad json"`)); err == nil {
+		t.Errorf("Expected error for invalid JSON value\n")
+	}
+	value := []byte(`{"neuron": 17, "status": "traced"}`)
+	meta, err := NewValueMeta("application/json; charset=utf-8", "annotation-v1", value)
+	if err != nil {
+		t.Fatalf("Error on JSON value metadata: %s\n", err.Error())
+	}
+	if err = kvdata.PutValue(ctx, "annotation", value, meta); err != nil {
+		t.Fatalf("Could not put JSON value: %s\n", err.Error())
+	}
+	retrieved, retrievedMeta, found, err := kvdata.GetValue(ctx, "annotation")
+	if err != nil || !found {
+		t.Fatalf("Could not get JSON value: %v\n", err)
+	}
+	if !bytes.Equal(retrieved, value) || !retrievedMeta.IsJSON() || retrievedMeta.Schema != "annotation-v1" {
+		t.Errorf("Bad JSON value %q with metadata %+v\n", string(retrieved), retrievedMeta)
+	}
+
+	// Values without metadata are stored as is.
+	if stored := encodeValue([]byte("blob"), ValueMeta{}); string(stored) != "blob" {
+		t.Errorf("Value without metadata was changed: %q\n", stored)
+	}
+	if v, m := decodeValue(valueMetaMagic); !bytes.Equal(v, valueMetaMagic) || m.ContentType != "" {
+		t.Errorf("Truncated metadata should return the stored value\n")
+	}
+}
+
+func TestKeyvalueCommit(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		t.Fatalf("Error getting UUID of test repo: %s\n", err.Error())
+	}
+	config := dvid.NewConfig()
+	dataservice1, err := repo.NewData(kvtype, "state1", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %s\n", err.Error())
+	}
+	dataservice2, err := repo.NewData(kvtype, "state2", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %s\n", err.Error())
+	}
+	kv1, kv2 := dataservice1.(*Data), dataservice2.(*Data)
+	ctx1 := datastore.NewVersionedContext(dataservice1, versionID)
+	ctx2 := datastore.NewVersionedContext(dataservice2, versionID)
+
+	if err = kv1.PutData(ctx1, "old", []byte("before")); err != nil {
+		t.Fatalf("Could not put keyvalue data: %s\n", err.Error())
+	}
+
+	// A failed mutation shouldn't write anything.
+	_, err = datastore.Commit(uuid, "bad", []datastore.Mutation{
+		{"state1", "put", json.RawMessage(`{"Key": "a", "Data": "YWJj"}`)},
+		{"state2", "frob", json.RawMessage(`{"Key": "b"}`)},
+	})
+	if err == nil {
+		t.Fatalf("Expected error on commit with unknown action\n")
+	}
+	if _, found, _ := kv1.GetData(ctx1, "a"); found {
+		t.Errorf("Failed commit wrote data\n")
+	}
+
+	record, err := datastore.Commit(uuid, "first", []datastore.Mutation{
+		{"state1", "put", json.RawMessage(`{"Key": "a", "Data": "YWJj"}`)},
+		{"state1", "delete", json.RawMessage(`{"Key": "old"}`)},
+		{"state2", "put", json.RawMessage(`{"Key": "b", "Value": {"done": true}}`)},
+	})
+	if err != nil {
+		t.Fatalf("Error on commit: %s\n", err.Error())
+	}
+	if record.Keys != 3 || len(record.Mutations) != 3 {
+		t.Errorf("Bad commit record: %+v\n", record)
+	}
+	if value, found, err := kv1.GetData(ctx1, "a"); err != nil || !found || string(value) != "abc" {
+		t.Errorf("Bad value after commit: %q\n", value)
+	}
+	if _, found, _ := kv1.GetData(ctx1, "old"); found {
+		t.Errorf("Deleted key found after commit\n")
+	}
+	value, meta, found, err := kv2.GetValue(ctx2, "b")
+	if err != nil || !found || string(value) != `{"done": true}` || !meta.IsJSON() {
+		t.Errorf("Bad JSON value after commit: %q %+v\n", value, meta)
+	}
+
+	// Undo is refused once data written by the commit changes.
+	if err = kv1.PutData(ctx1, "a", []byte("changed")); err != nil {
+		t.Fatalf("Could not put keyvalue data: %s\n", err.Error())
+	}
+	if err = datastore.UndoCommit(record.ID); err == nil {
+		t.Errorf("Expected undo to fail after data changed\n")
+	}
+	if err = kv1.PutData(ctx1, "a", []byte("abc")); err != nil {
+		t.Fatalf("Could not put keyvalue data: %s\n", err.Error())
+	}
+
+	if err = datastore.UndoCommit(record.ID); err != nil {
+		t.Fatalf("Error undoing commit: %s\n", err.Error())
+	}
+	if _, found, _ := kv1.GetData(ctx1, "a"); found {
+		t.Errorf("Key written by commit found after undo\n")
+	}
+	if value, found, _ := kv1.GetData(ctx1, "old"); !found || string(value) != "before" {
+		t.Errorf("Deleted key not restored by undo: %q\n", value)
+	}
+	if _, found, _ := kv2.GetData(ctx2, "b"); found {
+		t.Errorf("Key written by commit found after undo\n")
+	}
+	if record, err = datastore.GetCommit(record.ID); err != nil || !record.Undone {
+		t.Errorf("Commit not marked as undone: %+v\n", record)
+	}
+	if err = datastore.UndoCommit(record.ID); err == nil {
+		t.Errorf("Expected error undoing commit twice\n")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+	This file implements merging of keyvalue data across versions of a repo DAG.
+*/
+
+package keyvalue
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// keyVersions holds the stored values of a key across all versions.
+type keyVersions struct {
+	index  []byte
+	values map[dvid.VersionID][]byte
+}
+
+// getKeyVersions returns the stored values of every key of this data in all versions.
+func (d *Data) getKeyVersions() ([]*keyVersions, error) {
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return nil, err
+	}
+	var keys []*keyVersions
+	indexed := make(map[string]*keyVersions)
+	var processErr error
+	minKey, maxKey := storage.DataContextKeyRange(d.InstanceID())
+	err = db.ProcessRange(nil, minKey, maxKey, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if processErr != nil || chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		_, versionID, err := storage.KeyToLocalIDs(chunk.K)
+		if err != nil {
+			processErr = err
+			return
+		}
+		index := chunk.K[1+dvid.InstanceIDSize : len(chunk.K)-dvid.VersionIDSize]
+		kv, found := indexed[string(index)]
+		if !found {
+			kv = &keyVersions{append([]byte{}, index...), make(map[dvid.VersionID][]byte)}
+			indexed[string(index)] = kv
+			keys = append(keys, kv)
+		}
+		kv.values[versionID] = append([]byte{}, chunk.V...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, processErr
+}
+
+// mergeChanges returns, for each key changed since the common ancestor, the value set
+// by parents other than the first and whether parents made conflicting changes.  Since
+// deletions aren't recorded, a key deleted in a parent is treated as unchanged.
+func (d *Data) mergeChanges(m *datastore.VersionMerge, kv *keyVersions) (value []byte, conflict bool, err error) {
+	stored := func(v dvid.VersionID) bool {
+		_, found := kv.values[v]
+		return found
+	}
+	var changedValue []byte
+	var primaryChanged bool
+	for i, parent := range m.Parents {
+		v, found, changed := m.Seen(parent, stored)
+		if !found || !changed {
+			continue
+		}
+		if changedValue == nil {
+			changedValue = kv.values[v]
+			primaryChanged = (i == 0)
+			continue
+		}
+		same, err := sameValues(changedValue, kv.values[v])
+		if err != nil {
+			return nil, false, err
+		}
+		if !same {
+			return nil, true, nil
+		}
+	}
+	if primaryChanged {
+		return nil, false, nil
+	}
+	return changedValue, false, nil
+}
+
+// sameValues compares serialized values, which may differ only in their compression.
+func sameValues(a, b []byte) (bool, error) {
+	if bytes.Equal(a, b) {
+		return true, nil
+	}
+	uncompress := true
+	valueA, _, err := dvid.DeserializeData(a, uncompress)
+	if err != nil {
+		return false, err
+	}
+	valueB, _, err := dvid.DeserializeData(b, uncompress)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(valueA, valueB), nil
+}
+
+// MergeConflicts returns the keys that were changed to different values in more than
+// one parent since the common ancestor.
+func (d *Data) MergeConflicts(m *datastore.VersionMerge) ([]string, error) {
+	if !d.Versioned() {
+		return nil, nil
+	}
+	keys, err := d.getKeyVersions()
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []string
+	for _, kv := range keys {
+		_, conflict, err := d.mergeChanges(m, kv)
+		if err != nil {
+			return nil, fmt.Errorf("Error checking key %q: %s", indexT(kv.index), err.Error())
+		}
+		if conflict {
+			conflicts = append(conflicts, indexT(kv.index).String())
+		}
+	}
+	return conflicts, nil
+}
+
+// MergeVersions copies keys changed only in parents other than the first into the
+// merged version.  Keys changed in the first parent are already visible through
+// the merged version's ancestor path.
+func (d *Data) MergeVersions(m *datastore.VersionMerge) error {
+	if !d.Versioned() {
+		return nil
+	}
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	keys, err := d.getKeyVersions()
+	if err != nil {
+		return err
+	}
+	ctx := storage.NewDataContext(d, m.Merged)
+	var numMerged int
+	for _, kv := range keys {
+		value, conflict, err := d.mergeChanges(m, kv)
+		if err != nil {
+			return err
+		}
+		if conflict {
+			return fmt.Errorf("Key %q has conflicting changes", indexT(kv.index))
+		}
+		if value == nil {
+			continue
+		}
+		if err := db.Put(ctx, kv.index, value); err != nil {
+			return err
+		}
+		numMerged++
+	}
+	dvid.Infof("Merged %d keys of data %q into version %d\n", numMerged, d.DataName(), m.Merged)
+	return nil
+}
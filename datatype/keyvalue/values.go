@@ -0,0 +1,92 @@
+/*
+	This file handles optional metadata stored with keyvalue values, i.e., the content
+	type of JSON values and a client-supplied schema tag.  Values without metadata are
+	stored as is, so they are unchanged from values stored before metadata support.
+*/
+
+package keyvalue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+)
+
+// Prefix of stored values that have metadata.
+var valueMetaMagic = []byte{0xFF, 'D', 'V', 'I', 'D', 'K', 'V', 0x01}
+
+const jsonContentType = "application/json"
+
+// ValueMeta describes a stored value.
+type ValueMeta struct {
+	// ContentType is "application/json" for JSON values and empty for arbitrary blobs.
+	ContentType string
+
+	// Schema is an optional client-supplied tag naming the schema of the value.
+	Schema string
+}
+
+// IsJSON returns true if the value is JSON.
+func (meta ValueMeta) IsJSON() bool {
+	return meta.ContentType == jsonContentType
+}
+
+// NewValueMeta returns the metadata for a value posted with the given content type and
+// schema tag.  JSON values are validated.
+func NewValueMeta(contentType, schema string, value []byte) (ValueMeta, error) {
+	var meta ValueMeta
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return meta, fmt.Errorf("Bad content type %q: %s", contentType, err.Error())
+		}
+		if mediaType == jsonContentType {
+			var v interface{}
+			if err := json.Unmarshal(value, &v); err != nil {
+				return meta, fmt.Errorf("Value posted as JSON is not valid JSON: %s", err.Error())
+			}
+			meta.ContentType = jsonContentType
+		}
+	}
+	if len(schema) > 255 {
+		return meta, fmt.Errorf("Schema tag %q is longer than 255 characters", schema)
+	}
+	meta.Schema = schema
+	return meta, nil
+}
+
+// encodeValue returns the value prefixed with any metadata.
+func encodeValue(value []byte, meta ValueMeta) []byte {
+	if meta.ContentType == "" && meta.Schema == "" {
+		return value
+	}
+	buf := make([]byte, 0, len(valueMetaMagic)+2+len(meta.ContentType)+len(meta.Schema)+len(value))
+	buf = append(buf, valueMetaMagic...)
+	buf = append(buf, byte(len(meta.ContentType)))
+	buf = append(buf, meta.ContentType...)
+	buf = append(buf, byte(len(meta.Schema)))
+	buf = append(buf, meta.Schema...)
+	return append(buf, value...)
+}
+
+// decodeValue returns a stored value and its metadata.  Values that don't have a valid
+// metadata prefix are returned unchanged with empty metadata.
+func decodeValue(stored []byte) ([]byte, ValueMeta) {
+	var meta ValueMeta
+	if !bytes.HasPrefix(stored, valueMetaMagic) {
+		return stored, meta
+	}
+	b := stored[len(valueMetaMagic):]
+	var fields [2]string
+	for i := range fields {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return stored, ValueMeta{}
+		}
+		n := int(b[0])
+		fields[i] = string(b[1 : 1+n])
+		b = b[1+n:]
+	}
+	meta.ContentType, meta.Schema = fields[0], fields[1]
+	return b, meta
+}
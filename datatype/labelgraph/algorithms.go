@@ -0,0 +1,365 @@
+/*
+	This file implements server-side graph algorithms: connected components, shortest
+	weighted paths, and k-hop neighborhoods.  Results are JSON that can be returned
+	directly, computed in a background job for large graphs, and stored in a keyvalue
+	instance.
+*/
+
+package labelgraph
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// graphAlgorithm computes the result of a graph algorithm, stopping with an error if
+// canceled returns true.
+type graphAlgorithm func(canceled func() bool) (interface{}, error)
+
+func notCanceled() bool { return false }
+
+type vertexIDs []dvid.VertexID
+
+func (s vertexIDs) Len() int           { return len(s) }
+func (s vertexIDs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s vertexIDs) Less(i, j int) bool { return s[i] < s[j] }
+
+// components sorts connected components by decreasing size, then by smallest vertex.
+type components []vertexIDs
+
+func (s components) Len() int      { return len(s) }
+func (s components) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s components) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) > len(s[j])
+	}
+	return s[i][0] < s[j][0]
+}
+
+// connectedComponents returns the connected components of a graph where only edges with
+// at least a minimum weight join vertices.  Vertices within each component are sorted.
+func connectedComponents(vertices []dvid.GraphVertex, edges []dvid.GraphEdge, minWeight float64, canceled func() bool) (components, error) {
+	parent := make(map[dvid.VertexID]dvid.VertexID, len(vertices))
+	var find func(id dvid.VertexID) dvid.VertexID
+	find = func(id dvid.VertexID) dvid.VertexID {
+		p, found := parent[id]
+		if !found {
+			parent[id] = id
+			return id
+		}
+		if p == id {
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	for _, vertex := range vertices {
+		find(vertex.Id)
+	}
+	for i, edge := range edges {
+		if i%100000 == 0 && canceled() {
+			return nil, fmt.Errorf("Connected components canceled")
+		}
+		if edge.Weight < minWeight {
+			continue
+		}
+		root1 := find(edge.Vertexpair.Vertex1)
+		root2 := find(edge.Vertexpair.Vertex2)
+		if root1 != root2 {
+			parent[root2] = root1
+		}
+	}
+
+	members := make(map[dvid.VertexID]vertexIDs)
+	for id := range parent {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+	comps := make(components, 0, len(members))
+	for _, ids := range members {
+		sort.Sort(ids)
+		comps = append(comps, ids)
+	}
+	sort.Sort(comps)
+	return comps, nil
+}
+
+// graphPath is a path between vertices and its total edge weight.
+type graphPath struct {
+	Vertices []dvid.VertexID
+	Weight   float64
+}
+
+// pathItem is a vertex with its distance from the path start.
+type pathItem struct {
+	id   dvid.VertexID
+	dist float64
+}
+
+// pathQueue is a min-heap of vertices by distance.
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q pathQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// shortestPath returns the path between two vertices with the least total edge weight
+// using Dijkstra's algorithm, which requires edge weights be non-negative.  Vertices are
+// read from the graph as the search reaches them.
+func shortestPath(ctx storage.Context, db storage.GraphGetter, from, to dvid.VertexID, canceled func() bool) (*graphPath, error) {
+	if _, err := db.GetVertex(ctx, to); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertex %d: %s", to, err.Error())
+	}
+	dist := map[dvid.VertexID]float64{from: 0}
+	prev := make(map[dvid.VertexID]dvid.VertexID)
+	done := make(map[dvid.VertexID]struct{})
+	queue := &pathQueue{{from, 0}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathItem)
+		if _, found := done[item.id]; found {
+			continue
+		}
+		done[item.id] = struct{}{}
+		if item.id == to {
+			break
+		}
+		if canceled() {
+			return nil, fmt.Errorf("Shortest path canceled after visiting %d vertices", len(done))
+		}
+		vertex, err := db.GetVertex(ctx, item.id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to retrieve vertex %d: %s", item.id, err.Error())
+		}
+		for _, neighbor := range vertex.Vertices {
+			if _, found := done[neighbor]; found {
+				continue
+			}
+			edge, err := db.GetEdge(ctx, item.id, neighbor)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve edge %d-%d: %s", item.id, neighbor, err.Error())
+			}
+			if edge.Weight < 0 {
+				return nil, fmt.Errorf("Edge %d-%d has negative weight %g", item.id, neighbor, edge.Weight)
+			}
+			d := item.dist + edge.Weight
+			if cur, found := dist[neighbor]; !found || d < cur {
+				dist[neighbor] = d
+				prev[neighbor] = item.id
+				heap.Push(queue, pathItem{neighbor, d})
+			}
+		}
+	}
+	if _, found := done[to]; !found {
+		return nil, fmt.Errorf("No path between vertices %d and %d", from, to)
+	}
+
+	path := &graphPath{Weight: dist[to]}
+	for id := to; ; id = prev[id] {
+		path.Vertices = append(path.Vertices, id)
+		if id == from {
+			break
+		}
+	}
+	for i, j := 0, len(path.Vertices)-1; i < j; i, j = i+1, j-1 {
+		path.Vertices[i], path.Vertices[j] = path.Vertices[j], path.Vertices[i]
+	}
+	return path, nil
+}
+
+// kHopNeighborhood returns the subgraph of vertices within k edges of a vertex and all
+// edges between them.  Vertices are listed in breadth-first order.
+func kHopNeighborhood(ctx storage.Context, db storage.GraphGetter, center dvid.VertexID, k int, canceled func() bool) (*LabelGraph, error) {
+	subgraph := new(LabelGraph)
+	visited := map[dvid.VertexID]struct{}{center: {}}
+	var stored []dvid.GraphVertex
+	frontier := []dvid.VertexID{center}
+	for hop := 0; len(frontier) > 0; hop++ {
+		if canceled() {
+			return nil, fmt.Errorf("Neighborhood extraction canceled after %d hops", hop)
+		}
+		var next []dvid.VertexID
+		for _, id := range frontier {
+			vertex, err := db.GetVertex(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve vertex %d: %s", id, err.Error())
+			}
+			stored = append(stored, vertex)
+			subgraph.Vertices = append(subgraph.Vertices, labelVertex{vertex.Id, vertex.Weight})
+			if hop == k {
+				continue
+			}
+			for _, neighbor := range vertex.Vertices {
+				if _, found := visited[neighbor]; !found {
+					visited[neighbor] = struct{}{}
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+	for _, vertex := range stored {
+		for _, neighbor := range vertex.Vertices {
+			if _, found := visited[neighbor]; !found || vertex.Id > neighbor {
+				continue
+			}
+			edge, err := db.GetEdge(ctx, vertex.Id, neighbor)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve edge %d-%d: %s", vertex.Id, neighbor, err.Error())
+			}
+			subgraph.Edges = append(subgraph.Edges, labelEdge{edge.Vertexpair.Vertex1, edge.Vertexpair.Vertex2, edge.Weight})
+		}
+	}
+	return subgraph, nil
+}
+
+// parseVertexID parses a vertex ID from a URL path element.
+func parseVertexID(s string) (dvid.VertexID, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad vertex ID %q", s)
+	}
+	return dvid.VertexID(id), nil
+}
+
+// handleAlgorithm parses the request for a graph algorithm and serves its result.
+func (d *Data) handleAlgorithm(requestCtx context.Context, ctx storage.Context, db storage.GraphDB, w http.ResponseWriter, r *http.Request, name string, path []string) error {
+	var compute graphAlgorithm
+	switch name {
+	case "components":
+		var minWeight float64
+		if s := r.URL.Query().Get("minweight"); s != "" {
+			var err error
+			if minWeight, err = strconv.ParseFloat(s, 64); err != nil {
+				return fmt.Errorf("Bad minweight %q", s)
+			}
+		}
+		compute = func(canceled func() bool) (interface{}, error) {
+			vertices, err := db.GetVertices(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve vertices: %s", err.Error())
+			}
+			edges, err := db.GetEdges(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve edges: %s", err.Error())
+			}
+			comps, err := connectedComponents(vertices, edges, minWeight, canceled)
+			if err != nil {
+				return nil, err
+			}
+			return struct{ Components components }{comps}, nil
+		}
+	case "shortestpath":
+		if len(path) < 2 {
+			return fmt.Errorf("Must specify two vertices for shortest path")
+		}
+		from, err := parseVertexID(path[0])
+		if err != nil {
+			return err
+		}
+		to, err := parseVertexID(path[1])
+		if err != nil {
+			return err
+		}
+		compute = func(canceled func() bool) (interface{}, error) {
+			return shortestPath(ctx, db, from, to, canceled)
+		}
+	case "khop":
+		if len(path) < 2 {
+			return fmt.Errorf("Must specify a vertex and number of hops for k-hop neighborhood")
+		}
+		center, err := parseVertexID(path[0])
+		if err != nil {
+			return err
+		}
+		k, err := strconv.Atoi(path[1])
+		if err != nil || k < 0 {
+			return fmt.Errorf("Bad number of hops %q", path[1])
+		}
+		compute = func(canceled func() bool) (interface{}, error) {
+			return kHopNeighborhood(ctx, db, center, k, canceled)
+		}
+	default:
+		return fmt.Errorf("Unknown graph algorithm %q", name)
+	}
+
+	// Get the keyvalue instance that will store the result, if any.
+	queryValues := r.URL.Query()
+	var dest *keyvalue.Data
+	destKey := queryValues.Get("key")
+	if destName := queryValues.Get("keyvalue"); destName != "" {
+		if destKey == "" {
+			return fmt.Errorf("Must specify a key for storing the result in keyvalue %q", destName)
+		}
+		repo, _, err := datastore.FromContext(requestCtx)
+		if err != nil {
+			return err
+		}
+		dataservice, err := repo.GetDataByName(dvid.DataString(destName))
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if dest, ok = dataservice.(*keyvalue.Data); !ok {
+			return fmt.Errorf("Data instance %q is not keyvalue data", destName)
+		}
+	}
+
+	run := func(canceled func() bool) (string, error) {
+		timedLog := dvid.NewTimeLog()
+		result, err := compute(canceled)
+		if err != nil {
+			return "", err
+		}
+		jsonBytes, err := json.Marshal(result)
+		if err != nil {
+			return "", err
+		}
+		if dest != nil {
+			destCtx := datastore.NewVersionedContext(dest, ctx.VersionID())
+			if err := dest.PutData(destCtx, destKey, jsonBytes); err != nil {
+				return "", fmt.Errorf("Error storing %s result in keyvalue %q: %s", name, dest.DataName(), err.Error())
+			}
+		}
+		timedLog.Infof("Computed %s on %q (%s)", name, d.DataName(), r.URL)
+		return string(jsonBytes), nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if queryValues.Get("background") == "true" {
+		command := fmt.Sprintf("%s %s", r.Method, r.URL)
+		job, err := server.StartJob(command, datastore.ClientFromContext(requestCtx), func(handle *server.JobHandle) (string, error) {
+			return run(handle.Canceled)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "{%q: %q}", "Job", job.ID)
+		return nil
+	}
+	result, err := run(notCanceled)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, result)
+	return nil
+}
@@ -0,0 +1,101 @@
+package labelgraph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestConnectedComponents(t *testing.T) {
+	vertices := []dvid.GraphVertex{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5}, {Id: 9}}
+	edge := func(id1, id2 dvid.VertexID, weight float64) dvid.GraphEdge {
+		return dvid.GraphEdge{&dvid.GraphElement{Weight: weight}, dvid.VertexPairID{id1, id2}}
+	}
+	edges := []dvid.GraphEdge{edge(1, 2, 1), edge(3, 2, 0.5), edge(4, 5, 2)}
+
+	comps, err := connectedComponents(vertices, edges, 0, notCanceled)
+	if err != nil {
+		t.Fatalf("Error computing components: %s\n", err.Error())
+	}
+	expected := components{{1, 2, 3}, {4, 5}, {9}}
+	if !reflect.DeepEqual(comps, expected) {
+		t.Errorf("Expected components %v, got %v\n", expected, comps)
+	}
+
+	comps, err = connectedComponents(vertices, edges, 1, notCanceled)
+	if err != nil {
+		t.Fatalf("Error computing components: %s\n", err.Error())
+	}
+	expected = components{{1, 2}, {4, 5}, {3}, {9}}
+	if !reflect.DeepEqual(comps, expected) {
+		t.Errorf("Expected components with min weight 1 %v, got %v\n", expected, comps)
+	}
+}
+
+func TestShortestPathAndNeighborhood(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	dataservice, err := repo.NewData(dtype, "graph", config)
+	if err != nil {
+		t.Fatalf("Error creating new labelgraph instance: %s\n", err.Error())
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not labelgraph.Data\n")
+	}
+	db, err := storage.GraphStore()
+	if err != nil {
+		t.Fatalf("Can't get graph store: %s\n", err.Error())
+	}
+	ctx := datastore.NewVersionedContext(data, versionID)
+
+	// 1 -1- 2 -1- 3 -1- 4 with a costly shortcut 1 -5- 4, and 6 unconnected.
+	for id := dvid.VertexID(1); id <= 6; id++ {
+		if err := db.AddVertex(ctx, id, 0); err != nil {
+			t.Fatalf("Error adding vertex %d: %s\n", id, err.Error())
+		}
+	}
+	for _, e := range []labelEdge{{1, 2, 1}, {2, 3, 1}, {3, 4, 1}, {1, 4, 5}} {
+		if err := db.AddEdge(ctx, e.Id1, e.Id2, e.Weight); err != nil {
+			t.Fatalf("Error adding edge %d-%d: %s\n", e.Id1, e.Id2, err.Error())
+		}
+	}
+
+	path, err := shortestPath(ctx, db, 1, 4, notCanceled)
+	if err != nil {
+		t.Fatalf("Error computing shortest path: %s\n", err.Error())
+	}
+	expected := &graphPath{Vertices: []dvid.VertexID{1, 2, 3, 4}, Weight: 3}
+	if !reflect.DeepEqual(path, expected) {
+		t.Errorf("Expected shortest path %v, got %v\n", expected, path)
+	}
+	if _, err := shortestPath(ctx, db, 1, 6, notCanceled); err == nil {
+		t.Errorf("Expected error for shortest path between unconnected vertices\n")
+	}
+
+	subgraph, err := kHopNeighborhood(ctx, db, 2, 1, notCanceled)
+	if err != nil {
+		t.Fatalf("Error extracting neighborhood: %s\n", err.Error())
+	}
+	if len(subgraph.Vertices) != 3 || subgraph.Vertices[0].Id != 2 {
+		t.Errorf("Expected vertex 2 and its 2 neighbors, got %v\n", subgraph.Vertices)
+	}
+	if len(subgraph.Edges) != 2 {
+		t.Errorf("Expected 2 edges in 1-hop neighborhood, got %v\n", subgraph.Edges)
+	}
+	subgraph, err = kHopNeighborhood(ctx, db, 2, 2, notCanceled)
+	if err != nil {
+		t.Fatalf("Error extracting neighborhood: %s\n", err.Error())
+	}
+	if len(subgraph.Vertices) != 4 || len(subgraph.Edges) != 4 {
+		t.Errorf("Expected 4 vertices and 4 edges in 2-hop neighborhood, got %v\n", subgraph)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+	This file supports bulk loading of edges from a streamed text file, which is far faster
+	than posting subgraphs for whole-volume adjacency graphs with millions of edges.
+*/
+
+package labelgraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// edgeFileReader parses edges from lines of "<vertex1> <vertex2> [weight]", with fields
+// separated by whitespace or commas.  Blank lines and lines starting with '#' are skipped.
+// Edges without a weight have zero weight.
+type edgeFileReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newEdgeFileReader(r io.Reader) *edgeFileReader {
+	return &edgeFileReader{scanner: bufio.NewScanner(r)}
+}
+
+func (r *edgeFileReader) ReadEdge() (storage.WeightedEdge, error) {
+	var edge storage.WeightedEdge
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\t'
+		})
+		if len(fields) < 2 || len(fields) > 3 {
+			return edge, fmt.Errorf("Line %d: expected 2 vertex IDs and an optional weight, got %q", r.line, line)
+		}
+		id1, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return edge, fmt.Errorf("Line %d: bad vertex ID %q", r.line, fields[0])
+		}
+		id2, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return edge, fmt.Errorf("Line %d: bad vertex ID %q", r.line, fields[1])
+		}
+		edge.Vertex1 = dvid.VertexID(id1)
+		edge.Vertex2 = dvid.VertexID(id2)
+		if len(fields) == 3 {
+			if edge.Weight, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return edge, fmt.Errorf("Line %d: bad edge weight %q", r.line, fields[2])
+			}
+		}
+		return edge, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return edge, err
+	}
+	return edge, io.EOF
+}
+
+// handleEdgesBulk loads edges from a streamed edge file and returns the number loaded.
+func (d *Data) handleEdgesBulk(ctx storage.Context, db storage.GraphDB, r io.Reader) (uint64, error) {
+	loader, ok := db.(storage.GraphBulkLoader)
+	if !ok {
+		return 0, fmt.Errorf("Graph store does not support bulk loading of edges")
+	}
+	if !d.setBusy() {
+		return 0, fmt.Errorf("Server busy with bulk transaction")
+	}
+	defer d.setNotBusy()
+
+	return loader.BulkLoadEdges(ctx, newEdgeFileReader(r))
+}
@@ -0,0 +1,130 @@
+/*
+	This file supports secondary indexes on vertex properties and queries of vertices by
+	indexed property values.
+*/
+
+package labelgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func vertexIndexer(db storage.GraphDB) (storage.VertexIndexer, error) {
+	indexer, ok := db.(storage.VertexIndexer)
+	if !ok {
+		return nil, fmt.Errorf("Graph store does not support vertex property indexes")
+	}
+	return indexer, nil
+}
+
+// handleIndexes lists, creates, or removes vertex property indexes.
+func (d *Data) handleIndexes(ctx storage.Context, db storage.GraphDB, w http.ResponseWriter, r *http.Request, path []string, method string) error {
+	indexer, err := vertexIndexer(db)
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 || path[0] == "" {
+		if method != "get" {
+			return fmt.Errorf("Must specify a vertex property to %s an index", method)
+		}
+		indexes, err := indexer.VertexIndexes(ctx)
+		if err != nil {
+			return err
+		}
+		kinds := make(map[string]string, len(indexes))
+		for property, kind := range indexes {
+			kinds[property] = kind.String()
+		}
+		jsonBytes, err := json.Marshal(kinds)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		return nil
+	}
+
+	property := path[0]
+	switch method {
+	case "post":
+		kindStr := r.URL.Query().Get("kind")
+		if kindStr == "" {
+			kindStr = "string"
+		}
+		kind, err := storage.ParsePropertyIndexKind(kindStr)
+		if err != nil {
+			return err
+		}
+		if !d.setBusy() {
+			return fmt.Errorf("Server busy with bulk transaction")
+		}
+		defer d.setNotBusy()
+
+		timedLog := dvid.NewTimeLog()
+		if err := indexer.CreateVertexIndex(ctx, property, kind); err != nil {
+			return err
+		}
+		timedLog.Infof("Created %s index on vertex property %q of %q", kind, property, d.DataName())
+	case "delete":
+		if err := indexer.DropVertexIndex(ctx, property); err != nil {
+			return err
+		}
+		dvid.Infof("Removed index on vertex property %q of %q\n", property, d.DataName())
+	default:
+		return fmt.Errorf("Indexes on a vertex property only support POSTs and DELETEs")
+	}
+	return nil
+}
+
+// queryCondition is a condition of a vertex query whose value can be a JSON string or
+// number.
+type queryCondition struct {
+	Property string
+	Op       string
+	Value    json.RawMessage
+}
+
+// handleQuery writes the vertices satisfying the JSON list of conditions in the request.
+func (d *Data) handleQuery(ctx storage.Context, db storage.GraphDB, w http.ResponseWriter, r *http.Request) error {
+	indexer, err := vertexIndexer(db)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var queryConditions []queryCondition
+	if err := json.Unmarshal(data, &queryConditions); err != nil {
+		return fmt.Errorf("Error parsing JSON query conditions: %s", err.Error())
+	}
+	conditions := make([]storage.PropertyCondition, len(queryConditions))
+	for i, c := range queryConditions {
+		value := string(bytes.TrimSpace(c.Value))
+		if len(value) != 0 && value[0] == '"' {
+			if err := json.Unmarshal(c.Value, &value); err != nil {
+				return fmt.Errorf("Bad value for condition on %q: %s", c.Property, err.Error())
+			}
+		}
+		conditions[i] = storage.PropertyCondition{Property: c.Property, Op: c.Op, Value: value}
+	}
+
+	vertices, err := indexer.QueryVertices(ctx, conditions)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(struct{ Vertices []dvid.VertexID }{vertices})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+	return nil
+}
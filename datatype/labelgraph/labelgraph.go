@@ -180,6 +180,72 @@ POST  <api URL>/node/<UUID>/<data name>/undomerge
     data name     Name of data to add/retrieve.
 
 
+POST  <api URL>/node/<UUID>/<data name>/edges
+
+    Bulk loads weighted edges from a streamed text file in the request body, e.g.,
+    "curl --data-binary @edges.txt <api URL>/node/3f8c/stuff/edges".  Each line gives an edge
+    as "<vertex1> <vertex2> [weight]" with fields separated by whitespace or commas.  Blank
+    lines and lines starting with "#" are skipped, and edges without a weight have zero weight.
+    Missing vertices are created with zero weight and the weights of existing edges are
+    replaced.  Edges are written in large batches, so millions of edges can be loaded far
+    faster than by posting subgraphs.  Like subgraph requests, this sets a data-wide lock.
+    Returns JSON with the number of edges loaded, e.g., {"Edges": 1250000}.  On error, edges
+    loaded before the error are kept and the error gives their number.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add.
+
+
+GET  <api URL>/node/<UUID>/<data name>/indexes
+
+    Returns JSON giving the kind of index for each indexed vertex property, e.g.,
+    {"size": "number", "status": "string"}.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to retrieve.
+
+
+POST  <api URL>/node/<UUID>/<data name>/indexes/<property>[?kind=number]
+DELETE  <api URL>/node/<UUID>/<data name>/indexes/<property>
+
+    Creates or removes a secondary index on a vertex property so vertices can be queried
+    by property value without traversing the graph.  Creating an index scans all current
+    property values, and indexes are then kept up to date as properties change.  Values
+    that can't be parsed for the index kind aren't indexed.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to modify.
+    property      Name of the vertex property.
+
+    Query-string Options:
+
+    kind          "string" (default) to compare values as byte strings or "number" to compare
+                    values, which must be decimal text like "1e6", as numbers.
+
+
+POST  <api URL>/node/<UUID>/<data name>/query
+
+    Returns the vertices whose properties satisfy all given conditions, using index scans.
+    Each condition must be on an indexed property.  The request body is a JSON list of
+    conditions with a property, a comparison of "=", "<", "<=", ">", or ">=", and a value:
+
+    [{"Property": "status", "Op": "=", "Value": "needs-review"},
+     {"Property": "size", "Op": ">", "Value": 1e6}]
+
+    Returns JSON with the sorted vertex IDs, e.g., {"Vertices": [23, 1024]}.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to query.
+
+
 GET  <api URL>/node/<UUID>/<data name>/neighbors/<vertex>
 
     Retrieves the vertices/edges that are connected to the given vertex.
@@ -195,6 +261,41 @@ GET  <api URL>/node/<UUID>/<data name>/neighbors/<vertex>
     vertex        ID of vertex
 
 
+GET  <api URL>/node/<UUID>/<data name>/components[?minweight=0]
+
+    Returns the connected components of the graph as JSON lists of vertex IDs, largest
+    component first, e.g., {"Components": [[3, 7, 12], [5]]}.
+
+    Query-string Options:
+
+    minweight     Only edges with at least this weight connect vertices.
+
+
+GET  <api URL>/node/<UUID>/<data name>/shortestpath/<vertex1>/<vertex2>
+
+    Returns the path between two vertices with the least total edge weight and that
+    weight, e.g., {"Vertices": [3, 12, 7], "Weight": 2.5}.  Edge weights must not be
+    negative.
+
+
+GET  <api URL>/node/<UUID>/<data name>/khop/<vertex>/<k>
+
+    Returns the vertices within k edges of the given vertex and all edges between them,
+    using the same JSON format as "subgraph".
+
+    Arguments (for components, shortestpath, and khop):
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to query.
+
+    Query-string Options (for components, shortestpath, and khop):
+
+    background    If "true", the algorithm runs as a background job and {"Job": "<job ID>"}
+                    is returned.  The JSON result is the job's result in /api/server/jobs.
+    keyvalue      Name of a keyvalue instance in which the JSON result is also stored.
+    key           Key under which the result is stored in the keyvalue instance.
+
+
 POST  <api URL>/node/<UUID>/<data name>/weight
 
     Updates the weight associated with the provided vertices and edges.  Requests
@@ -1301,11 +1402,23 @@ func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
 	return nil
 }
 
+// OnDelete removes the graph, which is kept outside the data instance's keyspace, when
+// the data instance is deleted.
+func (d *Data) OnDelete(repo datastore.Repo) error {
+	return storage.DeleteGraphInstance(d.InstanceID())
+}
+
 // DoRPC acts as a switchboard for RPC commands -- not supported
 func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
 	return nil
 }
 
+// WriteEndpoint returns true if a request modifies the graph.  A query is POSTed but
+// only reads the graph's indexes.
+func (d *Data) WriteEndpoint(method, endpoint string) bool {
+	return method != "GET" && method != "HEAD" && endpoint != "query"
+}
+
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
 	// --- Don't time labelgraph ops because they are very small and frequent.
@@ -1360,6 +1473,34 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			server.BadRequest(w, r, err.Error())
 			return
 		}
+	case "edges":
+		if method != "post" {
+			server.BadRequest(w, r, "Only supports POSTs")
+			return
+		}
+		timedLog := datastore.NewTimeLog(requestCtx)
+		loaded, err := d.handleEdgesBulk(storeCtx, db, r.Body)
+		if err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("Error after loading %d edges: %s", loaded, err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{%q: %d}", "Edges", loaded)
+		timedLog.Infof("HTTP %s: bulk loaded %d edges into %q", r.Method, loaded, d.DataName())
+	case "indexes":
+		if err := d.handleIndexes(storeCtx, db, w, r, parts[4:], method); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+	case "query":
+		if method != "post" {
+			server.BadRequest(w, r, "Only supports POSTs")
+			return
+		}
+		if err := d.handleQuery(storeCtx, db, w, r); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
 	case "neighbors":
 		if method != "get" {
 			server.BadRequest(w, r, "Only supports GETs")
@@ -1370,6 +1511,15 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			server.BadRequest(w, r, err.Error())
 			return
 		}
+	case "components", "shortestpath", "khop":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		if err := d.handleAlgorithm(requestCtx, storeCtx, db, w, r, parts[3], parts[4:]); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
 	case "merge":
 		if method != "post" {
 			server.BadRequest(w, r, "Only supports POSTs")
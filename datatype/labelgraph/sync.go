@@ -0,0 +1,66 @@
+/*
+	This file supports syncing a labelgraph with labels64 data, so vertices are merged
+	when their labels are merged and new labels from splits are added as vertices.
+*/
+
+package labelgraph
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// CanSync returns true for labels64 data.
+func (d *Data) CanSync(source datastore.DataService) bool {
+	return source.TypeName() == "labels64"
+}
+
+// HandleSync merges vertices for merged labels and adds vertices for split labels.  Labels
+// without vertices are ignored.
+func (d *Data) HandleSync(source datastore.DataService, msg datastore.SyncMessage) error {
+	db, err := storage.GraphStore()
+	if err != nil {
+		return err
+	}
+	ctx := datastore.NewVersionedContext(d, msg.Version)
+
+	switch delta := msg.Delta.(type) {
+	case voxels.LabelMergeDelta:
+		labelgraph := new(LabelGraph)
+		for _, label := range delta.FromLabels {
+			if hasVertex(ctx, db, dvid.VertexID(label)) {
+				labelgraph.Vertices = append(labelgraph.Vertices, labelVertex{Id: dvid.VertexID(label)})
+			}
+		}
+		if len(labelgraph.Vertices) == 0 {
+			return nil
+		}
+		toID := dvid.VertexID(delta.ToLabel)
+		if !hasVertex(ctx, db, toID) {
+			if err := db.AddVertex(ctx, toID, 0); err != nil {
+				return fmt.Errorf("Failed to add vertex %d: %s", toID, err.Error())
+			}
+		}
+		labelgraph.Vertices = append(labelgraph.Vertices, labelVertex{Id: toID})
+		return d.handleMerge(ctx, db, nil, labelgraph)
+
+	case voxels.LabelSplitDelta:
+		// Edges of the new label can't be known without the voxels, so only the vertex is added.
+		if !hasVertex(ctx, db, dvid.VertexID(delta.FromLabel)) {
+			return nil
+		}
+		return db.AddVertex(ctx, dvid.VertexID(delta.ToLabel), 0)
+
+	default:
+		return fmt.Errorf("Unexpected %s delta from %q: %v", msg.Event, source.DataName(), msg.Delta)
+	}
+}
+
+func hasVertex(ctx storage.Context, db storage.GraphDB, id dvid.VertexID) bool {
+	_, err := db.GetVertex(ctx, id)
+	return err == nil
+}
@@ -29,9 +29,25 @@ import (
 // min block (1,2,3) and max block (3,4,5), the subvolume in voxels will be from min voxel
 // point (32, 64, 96) to max voxel point (96, 128, 160).
 func (d *Data) GetLabelsInVolume(ctx storage.Context, minBlock, maxBlock dvid.ChunkPoint3d) (string, error) {
+	jsonStr, _, err := d.GetLabelsInVolumeBudget(ctx, minBlock, maxBlock, nil)
+	return jsonStr, err
+}
+
+// intersectProgress is the state of a label intersection cut short by its budget.
+type intersectProgress struct {
+	labelset map[uint64]bool
+	it       *dvid.IndexZYXIterator
+}
+
+// GetLabelsInVolumeBudget is like GetLabelsInVolume but stops reading spans of blocks
+// once the budget is exceeded.  If the label list is partial, the returned state can be
+// passed to QueryBudget.SetPartial to allow resuming the intersection.
+func (d *Data) GetLabelsInVolumeBudget(ctx storage.Context, minBlock, maxBlock dvid.ChunkPoint3d,
+	budget *server.QueryBudget) (string, interface{}, error) {
+
 	smalldata, err := storage.SmallDataStore()
 	if err != nil {
-		return "{}", err
+		return "{}", nil, err
 	}
 
 	// Get the mappings for this span of keys by using just spatial indices.
@@ -39,46 +55,66 @@ func (d *Data) GetLabelsInVolume(ctx storage.Context, minBlock, maxBlock dvid.Ch
 	maxLabelBytes := make([]byte, 8, 8)
 	binary.BigEndian.PutUint64(maxLabelBytes, 0xFFFFFFFFFFFFFFFF)
 
-	labelset := make(map[uint64]bool, 10)
-	for it := dvid.NewIndexZYXIterator(minBlock, maxBlock); it.Valid(); it.NextSpan() {
+	progress := &intersectProgress{
+		labelset: make(map[uint64]bool, 10),
+		it:       dvid.NewIndexZYXIterator(minBlock, maxBlock),
+	}
+	if state := budget.State(); state != nil {
+		var ok bool
+		if progress, ok = state.(*intersectProgress); !ok {
+			return "{}", nil, fmt.Errorf("Continuation is not for a label intersection")
+		}
+	}
+	var partial bool
+	for numSpans := 0; progress.it.Valid(); progress.it.NextSpan() {
+		// At least one span is read per call so resumed queries always make progress.
+		if numSpans > 0 && budget.Exceeded() {
+			partial = true
+			break
+		}
+		numSpans++
+
 		// Get keys for this span of blocks
-		indexBeg, indexEnd, err := it.IndexSpan()
+		indexBeg, indexEnd, err := progress.it.IndexSpan()
 		if err != nil {
-			return "{}", err
+			return "{}", nil, err
 		}
 		begIndex := voxels.NewSpatialMapIndex(indexBeg, nil, 0)
 		endIndex := voxels.NewSpatialMapIndex(indexEnd, maxLabelBytes, 0xFFFFFFFFFFFFFFFF)
 
 		keys, err := smalldata.KeysInRange(ctx, begIndex, endIndex)
 		if err != nil {
-			return "{}", err
+			return "{}", nil, err
 		}
 
 		// Add mapped labels for these keys into the set
 		for _, key := range keys {
 			_, mappedLabel, err := voxels.DecodeSpatialMapKey(key)
 			if err != nil {
-				return "{}", err
+				return "{}", nil, err
 			}
-			labelset[mappedLabel] = true
+			progress.labelset[mappedLabel] = true
 		}
 	}
 
 	// Convert set to a JSON compatible list.
-	numLabels := len(labelset)
+	numLabels := len(progress.labelset)
 	dvid.Debugf("Found %d labels that intersect subvolume with block coords %s -> %s\n", numLabels,
 		minBlock, maxBlock)
 	labellist := make([]uint64, numLabels, numLabels)
 	i := 0
-	for label, _ := range labelset {
+	for label, _ := range progress.labelset {
 		labellist[i] = label
 		i++
 	}
 	m, err := json.Marshal(labellist)
 	if err != nil {
-		return "{}", nil
+		return "{}", nil, nil
 	}
-	return string(m), nil
+	if partial {
+		return string(m), progress, nil
+	}
+	return string(m), nil, nil
 }
 
 // GetLabelAtPoint returns a mapped label for a given point.
@@ -101,6 +137,9 @@ type denormOp struct {
 	dest      dvid.Data
 	versionID dvid.VersionID
 	mapping   map[string]uint64
+
+	// If identity is true, labels without a mapping are mapped to themselves.
+	identity bool
 }
 
 // GetMappedImage retrieves a 2d image from a version node given a geometry of voxels.
@@ -172,7 +211,7 @@ func (d *Data) GetMappedVoxels(versionID dvid.VersionID, e voxels.ExtData) error
 		}
 
 		// Send the span of label blocks to chunk mapper
-		chunkOp := &storage.ChunkOp{&denormOp{labelData, e, nil, versionID, mapping}, wg}
+		chunkOp := &storage.ChunkOp{&denormOp{labelData, e, nil, versionID, mapping, false}, wg}
 		blockBeg := voxels.NewVoxelBlockIndex(indexBeg)
 		blockEnd := voxels.NewVoxelBlockIndex(indexEnd)
 
@@ -192,35 +231,42 @@ func (d *Data) GetMappedVoxels(versionID dvid.VersionID, e voxels.ExtData) error
 // Iterate through all blocks in the associated label volume, computing the spatial indices
 // for bodies and the mappings for each spatial index.
 func (d *Data) ProcessSpatially(uuid dvid.UUID) {
+	if err := d.processSpatially(uuid, nil, nil); err != nil {
+		dvid.Errorf("Error processing spatial information for %s: %s\n", d.DataName(), err.Error())
+	}
+}
+
+// processSpatially computes the spatial indices and label sizes and surfaces, returning
+// when all are done.  If mapping is nil, the Raveler forward maps for each layer of blocks
+// are used.  Otherwise the given mapping is used for all blocks and labels without a
+// mapping are mapped to themselves.  If not nil, layerDone is called after each layer.
+func (d *Data) processSpatially(uuid dvid.UUID, mapping map[string]uint64, layerDone func(layer, numLayers int32)) error {
 	dvid.Infof("Adding spatial information from label volume %s ...\n", d.DataName())
 
 	versionID, err := datastore.VersionFromUUID(uuid)
 	if err != nil {
-		dvid.Errorf("Illegal UUID %q with no corresponding version ID!  Aborting.", uuid)
-		return
+		return fmt.Errorf("Illegal UUID %q with no corresponding version ID", uuid)
 	}
 
 	labelData, err := d.Labels.GetData()
 	if err != nil {
-		dvid.Errorf("Could not get labels64 data for '%s'", d.Labels)
+		return fmt.Errorf("Could not get labels64 data for '%s': %s", d.Labels, err.Error())
 	}
 
 	bigdata, err := storage.BigDataStore()
 	if err != nil {
-		dvid.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
-		return
+		return fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
 	}
 	smalldata, err := storage.SmallDataStore()
 	if err != nil {
-		dvid.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
-		return
+		return fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
 	}
 
 	// Iterate through all labels chunks incrementally in Z, loading and then using the maps
 	// for all blocks in that layer.
 	timedLog := dvid.NewTimeLog()
 	wg := new(sync.WaitGroup)
-	op := &denormOp{source: labelData, versionID: versionID}
+	op := &denormOp{source: labelData, versionID: versionID, mapping: mapping, identity: mapping != nil}
 
 	extents := labelData.Extents()
 	minIndexZ := extents.MinIndex.Value(2)
@@ -233,10 +279,14 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 
 		// Get the label->label map for this Z
 		var minChunkPt, maxChunkPt dvid.ChunkPoint3d
-		minChunkPt, maxChunkPt, err := d.GetBlockLayerMapping(z, op)
-		if err != nil {
-			dvid.Errorf("Error getting label mapping for block Z %d: %s\n", z, err.Error())
-			return
+		if mapping == nil {
+			minChunkPt, maxChunkPt, err = d.GetBlockLayerMapping(z, op)
+			if err != nil {
+				return fmt.Errorf("Error getting label mapping for block Z %d: %s\n", z, err.Error())
+			}
+		} else {
+			minChunkPt = dvid.ChunkPoint3d{dvid.MinChunkPoint3d[0], dvid.MinChunkPoint3d[1], z}
+			maxChunkPt = dvid.ChunkPoint3d{dvid.MaxChunkPoint3d[0], dvid.MaxChunkPoint3d[1], z}
 		}
 
 		// Process the labels chunks for this Z
@@ -253,6 +303,9 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 		}
 		blockLog.Debugf("Processed all '%s' blocks for layer %d/%d",
 			d.DataName(), z-minIndexZ+1, maxIndexZ-minIndexZ+1)
+		if layerDone != nil {
+			layerDone(z-minIndexZ+1, maxIndexZ-minIndexZ+1)
+		}
 	}
 	timedLog.Infof("Processed spatial information from %s", d.DataName())
 
@@ -279,16 +332,6 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 		go labels64.ComputeSurface(labelmapCtx, labelData, surfaceCh[i], wg)
 	}
 
-	// Wait for results then set Updating.
-	go func() {
-		wg.Wait()
-		timedLog.Infof("Finished processing all RLEs for labels '%s'", d.DataName())
-		d.Ready = true
-		if err := datastore.SaveRepo(uuid); err != nil {
-			dvid.Errorf("Could not save READY state to data '%s', uuid %s: %s", d.DataName(), uuid, err.Error())
-		}
-	}()
-
 	// Iterate through all mapped labels and send to size and surface processing goroutines.
 	begIndex := voxels.NewLabelSpatialMapIndex(0, dvid.MinIndexZYX.Bytes())
 	endIndex := voxels.NewLabelSpatialMapIndex(math.MaxUint64, dvid.MaxIndexZYX.Bytes())
@@ -307,15 +350,24 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 
 		server.BlockOnInteractiveRequests("labelmap [size/surface compute]")
 	})
-	if err != nil {
-		dvid.Errorf("Error indexing sizes for %s: %s\n", d.DataName(), err.Error())
-		return
-	}
 	sizeCh <- nil
 	for i := 0; i < numSurfCalculators; i++ {
 		surfaceCh[i] <- nil
 	}
+	if err != nil {
+		wg.Wait()
+		return fmt.Errorf("Error indexing sizes for %s: %s\n", d.DataName(), err.Error())
+	}
 	timedLog.Infof("Finished reading all RLEs for labels '%s'", d.DataName())
+
+	// Wait for results then set Ready.
+	wg.Wait()
+	timedLog.Infof("Finished processing all RLEs for labels '%s'", d.DataName())
+	d.Ready = true
+	if err := datastore.SaveRepo(uuid); err != nil {
+		return fmt.Errorf("Could not save READY state to data '%s', uuid %s: %s", d.DataName(), uuid, err.Error())
+	}
+	return nil
 }
 
 // MapChunk processes a chunk of label data, storing the mapped labels64.  The data may be
@@ -559,7 +611,9 @@ func (d *Data) denormalizeChunk(chunk *storage.Chunk) {
 					b = 0
 				} else {
 					b, ok = op.mapping[string(a)]
-					if !ok {
+					if !ok && op.identity {
+						b = binary.BigEndian.Uint64(a)
+					} else if !ok {
 						zBeg := zyx.MinPoint(op.source.BlockSize()).Value(2)
 						zEnd := zyx.MaxPoint(op.source.BlockSize()).Value(2)
 						slice := binary.BigEndian.Uint32(a[0:4])
@@ -76,6 +76,33 @@ $ dvid node <UUID> <data name> load raveler <superpixel-to-segment filename> <se
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of data to add.
 
+$ dvid node <UUID> <data name> load remap <remap filename> <settings...>
+
+    Loads a label mapping, e.g., supervoxel->body mappings produced by agglomeration,
+    replacing any existing mapping for the version.  The entire file is read and checked
+    before the existing mapping is changed, so a bad file leaves it untouched.  The forward
+    and inverse maps are then rewritten and the spatial indices, label sizes, and surfaces
+    rebuilt from the associated labels64 data.  Labels in the volume that aren't in the file
+    are treated as mapped to themselves.  The data isn't ready for queries until the remap
+    is complete.  The remap runs as a background job whose progress is available via the
+    /api/server/jobs HTTP endpoints.
+
+    Example: 
+
+    $ dvid node 3f8c sp2body load remap /data/agglo/sp2body.csv
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add.
+    remap filename  File on the server with label and mapped label pairs.
+
+    Configuration Settings (case-insensitive keys)
+
+    format        "csv" (default) for lines of "<label>,<mapped label>", which can also be
+                    separated by whitespace and where lines starting with '#' are ignored,
+                    or "binary" for pairs of little-endian uint64 label and mapped label.
+
 $ dvid node <UUID> <data name> apply <labels64 data name> <new labels64 data name>
 
     Applies a labelmap to current labels64 data and creates a new labels64 data.
@@ -217,6 +244,14 @@ GET <api URL>/node/<UUID>/<data name>/intersect/<min block>/<max block>
     min block     Minimum block coordinate with underscore as separator, e.g., 10_20_30
     max block     Maximum block coordinate with underscore as separator.
 
+    Query-string Options:
+
+    budget_ms     Time budget in milliseconds.  If exceeded, the labels found so far are
+                    returned with a "Dvid-Partial: true" header and a "Dvid-Continuation"
+                    header giving a token.
+    continue      Token from a partial result's "Dvid-Continuation" header.  Resumes the
+                    intersection and returns a more complete list of labels.
+
 GET  <api URL>/node/<UUID>/<data name>/labels/<dims>/<size>/<offset>[/<format>][?throttle=on]
 GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?throttle=on]
 
@@ -236,7 +271,9 @@ GET  <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?th
     Throttling can be enabled by passing a "throttle=on" query string.  Throttling makes sure
     only one compute-intense operation (all API calls that can be throttled) is handled.
     If the server can't initiate the API call right away, a 503 (Service Unavailable) status
-    code is returned.
+    code is returned.  Whether throttled or not, 3d requests count as heavy operations
+    against any quota of the client, and a 429 (Too Many Requests) status code with a
+    Retry-After header is returned if the client is already running its maximum.
 
     Arguments:
 
@@ -454,12 +491,17 @@ func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
 func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
 	switch request.TypeCommand() {
 	case "load":
-		if len(request.Command) < 7 {
+		if len(request.Command) < 6 {
 			return fmt.Errorf("Poorly formatted load command.  See command-line help.")
 		}
 		switch request.Command[4] {
 		case "raveler":
+			if len(request.Command) < 7 {
+				return fmt.Errorf("Poorly formatted load command.  See command-line help.")
+			}
 			return d.LoadRavelerMaps(request, reply)
+		case "remap":
+			return d.Remap(request, reply)
 		default:
 			return fmt.Errorf("Cannot load unknown input file types '%s'", request.Command[3])
 		}
@@ -477,7 +519,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(requestCtx)
 
 	// Get repo and version ID of this request
 	_, versions, err := datastore.FromContext(requestCtx)
@@ -493,6 +535,8 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
 
 	// Get the action (GET, POST)
 	action := strings.ToLower(r.Method)
@@ -584,7 +628,7 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			w.Header().Set("Content-Type", "text/plain")
 			fmt.Fprintf(w, "Put sparse volume with label %d into version %d\n", label, versionID)
 		} else {
-			data, err := labels64.GetSparseVol(storeCtx, label)
+			data, err := labels64.GetSparseVol(storeCtx, label, nil)
 			if err != nil {
 				server.BadRequest(w, r, err.Error())
 				return
@@ -614,7 +658,7 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		data, err := labels64.GetSparseVol(storeCtx, label)
+		data, err := labels64.GetSparseVol(storeCtx, label, nil)
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
@@ -766,21 +810,11 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			}
 			timedLog.Infof("HTTP %s: %s (%s)", r.Method, plane, r.URL)
 		case 3:
-			queryStrings := r.URL.Query()
-			if queryStrings.Get("throttle") == "on" {
-				select {
-				case <-server.Throttle:
-					// Proceed with operation, returning throttle token to server at end.
-					defer func() {
-						server.Throttle <- 1
-					}()
-				default:
-					throttleMsg := fmt.Sprintf("Server already running maximum of %d throttled operations",
-						server.MaxThrottledOps)
-					http.Error(w, throttleMsg, http.StatusServiceUnavailable)
-					return
-				}
+			release, ok := server.ThrottleOp(w, r)
+			if !ok {
+				return
 			}
+			defer release()
 			subvol, err := dvid.NewSubvolumeFromStrings(offsetStr, sizeStr, "_")
 			if err != nil {
 				server.BadRequest(w, r, "Error parsing subvolume: %s", err.Error())
@@ -842,11 +876,22 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			server.BadRequest(w, r, "ERROR: 'intersect' requires block coordinates to be 3d.  Got: %s", maxPoint)
 			return
 		}
-		jsonStr, err := d.GetLabelsInVolume(storeCtx, dvid.ChunkPoint3d(minCoord), dvid.ChunkPoint3d(maxCoord))
+		budget, err := server.BudgetFromQuery(r)
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
+		jsonStr, progress, err := d.GetLabelsInVolumeBudget(storeCtx, dvid.ChunkPoint3d(minCoord), dvid.ChunkPoint3d(maxCoord), budget)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if progress != nil {
+			if err := budget.SetPartial(w, progress); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
 		w.Header().Set("Content-type", "application/json")
 		fmt.Fprintf(w, jsonStr)
 		timedLog.Infof("HTTP %s: labels that intersect volume %s -> %s", r.Method, minCoord, maxCoord)
@@ -961,6 +1006,13 @@ func (d *Data) LoadRavelerMaps(request datastore.Request, reply *datastore.Respo
 	lineReader := bufio.NewReader(file)
 	linenum := 0
 
+	txn, err := storage.NewTransaction(smalldata)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		txn.Rollback()
+	}()
 	for {
 		line, err := lineReader.ReadString('\n')
 		if err != nil {
@@ -989,25 +1041,27 @@ func (d *Data) LoadRavelerMaps(request datastore.Request, reply *datastore.Respo
 			return fmt.Errorf("Segment (%d) in %s not found in %s", segment, spsegStr, segbodyStr)
 		}
 
-		// PUT the forward label pair without compression.
-		forwardIndex := voxels.NewForwardMapIndex(superpixelBytes, body)
-		if err := smalldata.Put(ctx, forwardIndex, dvid.EmptyValue()); err != nil {
-			return fmt.Errorf("ERROR on PUT of forward label mapping (%x -> %d): %s\n",
-				superpixelBytes, body, err.Error())
-		}
-
-		// PUT the inverse label pair without compression.
-		inverseIndex := voxels.NewInverseMapIndex(superpixelBytes, body)
-		if err := smalldata.Put(ctx, inverseIndex, dvid.EmptyValue()); err != nil {
-			return fmt.Errorf("ERROR on PUT of inverse label mapping (%d -> %x): %s\n",
-				body, superpixelBytes, err.Error())
-		}
+		// PUT the forward and inverse label pairs without compression.  Both are in the
+		// same transaction so a mapping is never stored in only one direction.
+		txn.Put(ctx, voxels.NewForwardMapIndex(superpixelBytes, body), dvid.EmptyValue())
+		txn.Put(ctx, voxels.NewInverseMapIndex(superpixelBytes, body), dvid.EmptyValue())
 
 		linenum++
+		if linenum%voxels.KVWriteSize == 0 {
+			if err := txn.Commit(); err != nil {
+				return fmt.Errorf("ERROR on PUT of forward and inverse label mappings: %s\n", err.Error())
+			}
+			if txn, err = storage.NewTransaction(smalldata); err != nil {
+				return err
+			}
+		}
 		if linenum%1000000 == 0 {
 			dvid.Infof("Added %d forward and inverse mappings\n", linenum)
 		}
 	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("ERROR on PUT of forward and inverse label mappings: %s\n", err.Error())
+	}
 	dvid.Infof("Added %d forward and inverse mappings\n", linenum)
 	timedLog.Infof("Processed Raveler superpixel->body files")
 
@@ -1074,7 +1128,7 @@ func (d *Data) ApplyLabelMap(request datastore.Request, reply *datastore.Respons
 	labelCtx := datastore.NewVersionedContext(labelData, versionID)
 
 	wg := new(sync.WaitGroup)
-	op := &denormOp{labelData, nil, dest, versionID, nil}
+	op := &denormOp{labelData, nil, dest, versionID, nil, false}
 
 	extents := labelData.Extents()
 	minIndexZ := extents.MinIndex.Value(2)
@@ -0,0 +1,308 @@
+/*
+	This file supports bulk ingestion of label remappings, e.g., the supervoxel->body maps
+	produced by agglomeration pipelines, which replace any existing mapping for a version.
+*/
+
+package labelmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// remapKeyTypes are the key spaces derived from a label mapping, which are cleared
+// before a remap is written.
+var remapKeyTypes = []voxels.KeyType{
+	voxels.KeyForwardMap,
+	voxels.KeyInverseMap,
+	voxels.KeySpatialMap,
+	voxels.KeyLabelSpatialMap,
+	voxels.KeyLabelSizes,
+	voxels.KeyLabelSurface,
+	voxels.KeyLabelSurfaceBlock,
+}
+
+// Only one remap can be ingested at a time for a labelmap instance.
+var (
+	remapMu      sync.Mutex
+	remapRunning = make(map[dvid.InstanceID]bool)
+)
+
+type remapFormat uint8
+
+const (
+	// Text lines of "<label>,<mapped label>".  Whitespace can also separate the labels
+	// and lines starting with '#' are ignored.
+	remapCSV remapFormat = iota
+
+	// Pairs of little-endian uint64 label and mapped label.
+	remapBinary
+)
+
+func parseRemapFormat(s string) (remapFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "csv":
+		return remapCSV, nil
+	case "binary":
+		return remapBinary, nil
+	default:
+		return remapCSV, fmt.Errorf("Unknown remap format %q, must be \"csv\" or \"binary\"", s)
+	}
+}
+
+// remapReader streams label -> mapped label pairs from a remap file.
+type remapReader struct {
+	format remapFormat
+	r      *bufio.Reader
+	line   int // number of lines or binary pairs read
+	pair   [16]byte
+}
+
+func newRemapReader(r io.Reader, format remapFormat) *remapReader {
+	return &remapReader{format: format, r: bufio.NewReader(r)}
+}
+
+// next returns the next label and its mapped label or io.EOF if there are no more.
+func (rr *remapReader) next() (label, mapped uint64, err error) {
+	if rr.format == remapBinary {
+		if _, err = io.ReadFull(rr.r, rr.pair[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("Binary remap truncated after %d pairs", rr.line)
+			}
+			return
+		}
+		rr.line++
+		label = binary.LittleEndian.Uint64(rr.pair[0:8])
+		mapped = binary.LittleEndian.Uint64(rr.pair[8:16])
+		return
+	}
+	for {
+		var line string
+		line, err = rr.r.ReadString('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return
+		}
+		rr.line++
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ',' || unicode.IsSpace(c)
+		})
+		if len(fields) != 2 {
+			return 0, 0, fmt.Errorf("Line %d of remap has %d fields, expected label and mapped label", rr.line, len(fields))
+		}
+		if label, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Bad label on line %d of remap: %s", rr.line, err.Error())
+		}
+		if mapped, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Bad mapped label on line %d of remap: %s", rr.line, err.Error())
+		}
+		return label, mapped, nil
+	}
+}
+
+// readRemap reads and validates all pairs of a remap, returning a mapping keyed by the
+// big-endian bytes of each label as stored in labels64 blocks.  The zero label is
+// reserved and can't be remapped, and a label can't be mapped to different labels.
+func readRemap(r io.Reader, format remapFormat) (map[string]uint64, error) {
+	mapping := make(map[string]uint64, 1000000)
+	rr := newRemapReader(r, format)
+	labelBytes := make([]byte, 8)
+	for {
+		label, mapped, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if label == 0 {
+			return nil, fmt.Errorf("Remap entry %d maps the reserved zero label", rr.line)
+		}
+		binary.BigEndian.PutUint64(labelBytes, label)
+		prev, found := mapping[string(labelBytes)]
+		if found && prev != mapped {
+			return nil, fmt.Errorf("Label %d is mapped to both %d and %d", label, prev, mapped)
+		}
+		mapping[string(labelBytes)] = mapped
+	}
+	return mapping, nil
+}
+
+// Remap ingests a label remapping file that replaces any existing mapping for the version.
+// Unless the request is already run as a background job, the remap is started as one and
+// its job ID is returned.
+func (d *Data) Remap(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, fileTypeStr, filename string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &fileTypeStr, &filename)
+
+	formatStr, _, err := request.Settings().GetString("format")
+	if err != nil {
+		return err
+	}
+	format, err := parseRemapFormat(formatStr)
+	if err != nil {
+		return err
+	}
+	uuid, _, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	if request.InJob() {
+		reply.Text, err = d.remap(request, uuid, filename, format)
+		return err
+	}
+	job, err := server.StartJob(request.Command.String(), "rpc", func(handle *server.JobHandle) (string, error) {
+		request.SetJob(handle)
+		return d.remap(request, uuid, filename, format)
+	})
+	if err != nil {
+		return err
+	}
+	reply.Text = fmt.Sprintf("Started job %s to remap %q, version %s, using %s.  Check its progress via /api/server/jobs/%s\n",
+		job.ID, d.DataName(), uuid, filename, job.ID)
+	return nil
+}
+
+// remap reads and validates the entire remap file before replacing the current mapping,
+// so a bad file leaves the existing mapping untouched.  While the mapping is replaced the
+// data isn't Ready.
+func (d *Data) remap(request datastore.Request, uuid dvid.UUID, filename string, format remapFormat) (string, error) {
+	remapMu.Lock()
+	if remapRunning[d.InstanceID()] {
+		remapMu.Unlock()
+		return "", fmt.Errorf("A remap of %q is already running", d.DataName())
+	}
+	remapRunning[d.InstanceID()] = true
+	remapMu.Unlock()
+	defer func() {
+		remapMu.Lock()
+		delete(remapRunning, d.InstanceID())
+		remapMu.Unlock()
+	}()
+
+	timedLog := dvid.NewTimeLog()
+	versionID, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		return "", err
+	}
+	labelData, err := d.Labels.GetData()
+	if err != nil {
+		return "", err
+	}
+	if !labelData.Ready {
+		return "", fmt.Errorf("Can't remap if underlying labels64 %q has not been loaded!", labelData.DataName())
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("Could not open remap file: %s", filename)
+	}
+	mapping, err := readRemap(file, format)
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("Error reading remap %s: %s", filename, err.Error())
+	}
+	request.JobLogf("Read %d mappings from %s\n", len(mapping), filename)
+	request.Progress(5)
+
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return "", fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+
+	d.Ready = false
+	if err := datastore.SaveRepo(uuid); err != nil {
+		return "", err
+	}
+
+	// Clear the existing mapping and everything derived from it.
+	maxIndex := bytes.Repeat([]byte{0xFF}, 64)
+	for _, keyType := range remapKeyTypes {
+		begIndex := dvid.IndexBytes{byte(keyType)}
+		endIndex := dvid.IndexBytes(append([]byte{byte(keyType)}, maxIndex...))
+		if err := smalldata.DeleteRange(ctx, begIndex, endIndex); err != nil {
+			return "", fmt.Errorf("Error deleting %s keys: %s", keyType, err.Error())
+		}
+	}
+	request.JobLogf("Cleared previous mapping of %q\n", d.DataName())
+	request.Progress(10)
+
+	// PUT the forward and inverse label pairs in transactions so a mapping is never
+	// stored in only one direction.
+	txn, err := storage.NewTransaction(smalldata)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		txn.Rollback()
+	}()
+	var written int
+	for labelStr, mapped := range mapping {
+		labelBytes := []byte(labelStr)
+		txn.Put(ctx, voxels.NewForwardMapIndex(labelBytes, mapped), dvid.EmptyValue())
+		txn.Put(ctx, voxels.NewInverseMapIndex(labelBytes, mapped), dvid.EmptyValue())
+		written++
+		if written%voxels.KVWriteSize == 0 {
+			if err := txn.Commit(); err != nil {
+				return "", fmt.Errorf("ERROR on PUT of forward and inverse label mappings: %s\n", err.Error())
+			}
+			if txn, err = storage.NewTransaction(smalldata); err != nil {
+				return "", err
+			}
+			if request.Canceled() {
+				return "", fmt.Errorf("Remap canceled after writing %d of %d mappings", written, len(mapping))
+			}
+		}
+		if written%1000000 == 0 {
+			request.JobLogf("Added %d of %d forward and inverse mappings\n", written, len(mapping))
+			request.Progress(10 + 20*float64(written)/float64(len(mapping)))
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return "", fmt.Errorf("ERROR on PUT of forward and inverse label mappings: %s\n", err.Error())
+	}
+	request.JobLogf("Added %d forward and inverse mappings\n", written)
+	request.Progress(30)
+
+	// Rebuild the spatial indices, sizes, and surfaces using the new mapping.
+	err = d.processSpatially(uuid, mapping, func(layer, numLayers int32) {
+		request.Progress(30 + 65*float64(layer)/float64(numLayers))
+		if layer%100 == 0 {
+			request.JobLogf("Processed block layer %d of %d\n", layer, numLayers)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	timedLog.Infof("Remapped %q with %d mappings from %s", d.DataName(), len(mapping), filename)
+	return fmt.Sprintf("Remapped %d labels of %q", len(mapping), d.DataName()), nil
+}
@@ -0,0 +1,53 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestReadRemap(t *testing.T) {
+	expected := map[uint64]uint64{7: 100, 8: 100, 1 << 40: 3}
+	checkMapping := func(mapping map[string]uint64) {
+		if len(mapping) != len(expected) {
+			t.Fatalf("Expected %d mappings, got %d\n", len(expected), len(mapping))
+		}
+		labelBytes := make([]byte, 8)
+		for label, mapped := range expected {
+			binary.BigEndian.PutUint64(labelBytes, label)
+			if got, found := mapping[string(labelBytes)]; !found || got != mapped {
+				t.Errorf("Expected label %d mapped to %d, got %d\n", label, mapped, got)
+			}
+		}
+	}
+
+	csv := "# supervoxel,body\n7,100\n\n8 100\n1099511627776,\t3"
+	mapping, err := readRemap(strings.NewReader(csv), remapCSV)
+	if err != nil {
+		t.Fatalf("Error reading CSV remap: %s\n", err.Error())
+	}
+	checkMapping(mapping)
+
+	var buf bytes.Buffer
+	for _, pair := range [][2]uint64{{7, 100}, {8, 100}, {1 << 40, 3}, {7, 100}} {
+		binary.Write(&buf, binary.LittleEndian, pair)
+	}
+	mapping, err = readRemap(bytes.NewReader(buf.Bytes()), remapBinary)
+	if err != nil {
+		t.Fatalf("Error reading binary remap: %s\n", err.Error())
+	}
+	checkMapping(mapping)
+
+	if _, err := readRemap(bytes.NewReader(buf.Bytes()[:20]), remapBinary); err == nil {
+		t.Errorf("Expected error on truncated binary remap\n")
+	}
+	for _, bad := range []string{"7,100,3\n", "0,5\n", "7,100\n7,101\n", "seven,100\n"} {
+		if _, err := readRemap(strings.NewReader(bad), remapCSV); err == nil {
+			t.Errorf("Expected error reading remap %q\n", bad)
+		}
+	}
+	if _, err := parseRemapFormat("hdf5"); err == nil {
+		t.Errorf("Expected error for unknown remap format\n")
+	}
+}
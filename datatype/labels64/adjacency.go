@@ -0,0 +1,233 @@
+/*
+	This file supports computation of a label adjacency graph, where vertices are labels
+	weighted by voxel count and edges join labels that touch, weighted by contact area
+	in voxel faces.  The graph is stored in a labelgraph instance so it can be queried
+	and modified through the labelgraph HTTP API.
+*/
+
+package labels64
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/labelgraph"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// labelPair is an unordered pair of labels with a < b.
+type labelPair struct {
+	a, b uint64
+}
+
+func newLabelPair(label1, label2 uint64) labelPair {
+	if label1 < label2 {
+		return labelPair{label1, label2}
+	}
+	return labelPair{label2, label1}
+}
+
+// adjacency accumulates label sizes and contact areas.  Label 0 is considered background
+// and is never tallied.
+type adjacency struct {
+	sizes    map[uint64]uint64
+	contacts map[labelPair]uint64
+}
+
+func newAdjacency() *adjacency {
+	return &adjacency{
+		sizes:    make(map[uint64]uint64),
+		contacts: make(map[labelPair]uint64),
+	}
+}
+
+func (adj *adjacency) addContact(label1, label2 uint64) {
+	if label1 == label2 || label1 == 0 || label2 == 0 {
+		return
+	}
+	adj.contacts[newLabelPair(label1, label2)]++
+}
+
+// addBlock tallies the label sizes and contacts within a block as well as contacts across
+// the block faces shared with the next block in x, y, and z.  Neighbor blocks may be nil
+// if they don't exist.
+func (adj *adjacency) addBlock(block, nextX, nextY, nextZ []byte, size dvid.Point3d, byteOrder binary.ByteOrder) error {
+	nx, ny, nz := int(size[0]), int(size[1]), int(size[2])
+	numBytes := nx * ny * nz * 8
+	for _, b := range [][]byte{block, nextX, nextY, nextZ} {
+		if b != nil && len(b) != numBytes {
+			return fmt.Errorf("Label block has %d bytes, expected %d bytes", len(b), numBytes)
+		}
+	}
+	label := func(data []byte, x, y, z int) uint64 {
+		i := ((z*ny+y)*nx + x) * 8
+		return byteOrder.Uint64(data[i : i+8])
+	}
+	for z := 0; z < nz; z++ {
+		for y := 0; y < ny; y++ {
+			for x := 0; x < nx; x++ {
+				cur := label(block, x, y, z)
+				if cur == 0 {
+					continue
+				}
+				adj.sizes[cur]++
+				if x < nx-1 {
+					adj.addContact(cur, label(block, x+1, y, z))
+				} else if nextX != nil {
+					adj.addContact(cur, label(nextX, 0, y, z))
+				}
+				if y < ny-1 {
+					adj.addContact(cur, label(block, x, y+1, z))
+				} else if nextY != nil {
+					adj.addContact(cur, label(nextY, x, 0, z))
+				}
+				if z < nz-1 {
+					adj.addContact(cur, label(block, x, y, z+1))
+				} else if nextZ != nil {
+					adj.addContact(cur, label(nextZ, x, y, 0))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ComputeAdjacency launches a job that builds the label adjacency graph for a version,
+// storing it in the named labelgraph instance, which is created if necessary.
+func (d *Data) ComputeAdjacency(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, destName string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &destName)
+
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	// Get or create the labelgraph instance.
+	dataservice, err := repo.GetDataByName(dvid.DataString(destName))
+	if err != nil {
+		typeservice, err := datastore.TypeServiceByName(labelgraph.TypeName)
+		if err != nil {
+			return fmt.Errorf("Could not get labelgraph type service from DVID")
+		}
+		dataservice, err = repo.NewData(typeservice, dvid.DataString(destName), dvid.NewConfig())
+		if err != nil {
+			return err
+		}
+	}
+	dest, ok := dataservice.(*labelgraph.Data)
+	if !ok {
+		return fmt.Errorf("Data instance %q is not labelgraph data", destName)
+	}
+
+	go d.computeAdjacency(versionID, dest)
+
+	reply.Text = fmt.Sprintf("Started computation of label adjacency graph %q for %q, version %s\n",
+		destName, d.DataName(), uuid)
+	return nil
+}
+
+func (d *Data) computeAdjacency(versionID dvid.VersionID, dest *labelgraph.Data) {
+	timedLog := dvid.NewTimeLog()
+	timedLog.Infof("Starting label adjacency graph %q for %s", dest.DataName(), d.DataName())
+
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		dvid.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+		return
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		dvid.Errorf("Label adjacency requires 3d blocks, %s has block size %s\n", d.DataName(), d.BlockSize())
+		return
+	}
+
+	// Returns the deserialized neighbor block or nil if it doesn't exist.
+	getBlock := func(x, y, z int32) []byte {
+		index := dvid.IndexZYX{x, y, z}
+		value, err := bigdata.Get(ctx, voxels.NewVoxelBlockIndex(&index))
+		if err != nil || value == nil {
+			return nil
+		}
+		data, _, err := dvid.DeserializeData(value, true)
+		if err != nil {
+			dvid.Errorf("Unable to deserialize block %s: %s\n", &index, err.Error())
+			return nil
+		}
+		return data
+	}
+
+	adj := newAdjacency()
+	var numBlocks int
+	minIndex := voxels.NewVoxelBlockIndex(&dvid.MinIndexZYX)
+	maxIndex := voxels.NewVoxelBlockIndex(&dvid.MaxIndexZYX)
+	err = bigdata.ProcessRange(ctx, minIndex, maxIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if chunk == nil || chunk.V == nil {
+			return
+		}
+		indexZYX, err := voxels.DecodeVoxelBlockKey(chunk.K)
+		if err != nil {
+			dvid.Errorf("Error decoding voxel block key: %s\n", err.Error())
+			return
+		}
+		data, _, err := dvid.DeserializeData(chunk.V, true)
+		if err != nil {
+			dvid.Errorf("Error decoding block: %s\n", err.Error())
+			return
+		}
+		x, y, z := indexZYX.Unpack()
+		nextX := getBlock(x+1, y, z)
+		nextY := getBlock(x, y+1, z)
+		nextZ := getBlock(x, y, z+1)
+		if err := adj.addBlock(data, nextX, nextY, nextZ, blockSize, d.Properties.ByteOrder); err != nil {
+			dvid.Errorf("Error computing adjacency for block %s: %s\n", indexZYX, err.Error())
+			return
+		}
+		numBlocks++
+		server.BlockOnInteractiveRequests("labels64 [compute adjacency graph]")
+	})
+	if err != nil {
+		dvid.Errorf("Error computing label adjacency for %s: %s\n", d.DataName(), err.Error())
+		return
+	}
+	timedLog.Infof("Scanned %d blocks of %s: %d labels, %d contacts", numBlocks, d.DataName(),
+		len(adj.sizes), len(adj.contacts))
+
+	// Store the graph, replacing any previous graph in this version.
+	db, err := storage.GraphStore()
+	if err != nil {
+		dvid.Errorf("Cannot get graph store: %s\n", err.Error())
+		return
+	}
+	graphCtx := datastore.NewVersionedContext(dest, versionID)
+	db.RemoveGraph(graphCtx)
+	if err := db.CreateGraph(graphCtx); err != nil {
+		dvid.Errorf("Unable to create graph %q: %s\n", dest.DataName(), err.Error())
+		return
+	}
+	for label, size := range adj.sizes {
+		if err := db.AddVertex(graphCtx, dvid.VertexID(label), float64(size)); err != nil {
+			dvid.Errorf("Failed to add vertex %d to graph %q: %s\n", label, dest.DataName(), err.Error())
+			return
+		}
+	}
+	for pair, area := range adj.contacts {
+		if err := db.AddEdge(graphCtx, dvid.VertexID(pair.a), dvid.VertexID(pair.b), float64(area)); err != nil {
+			dvid.Errorf("Failed to add edge %d-%d to graph %q: %s\n", pair.a, pair.b, dest.DataName(), err.Error())
+			return
+		}
+	}
+	timedLog.Infof("Stored label adjacency graph %q for %s", dest.DataName(), d.DataName())
+}
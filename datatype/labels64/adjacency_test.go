@@ -0,0 +1,67 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func makeLabelBlock(size dvid.Point3d, labelFunc func(x, y, z int) uint64) []byte {
+	nx, ny, nz := int(size[0]), int(size[1]), int(size[2])
+	data := make([]byte, nx*ny*nz*8)
+	i := 0
+	for z := 0; z < nz; z++ {
+		for y := 0; y < ny; y++ {
+			for x := 0; x < nx; x++ {
+				binary.LittleEndian.PutUint64(data[i:i+8], labelFunc(x, y, z))
+				i += 8
+			}
+		}
+	}
+	return data
+}
+
+func TestBlockAdjacency(t *testing.T) {
+	size := dvid.Point3d{4, 4, 4}
+
+	// Left half is label 1, right half is label 2, except z = 3 is background.
+	block := makeLabelBlock(size, func(x, y, z int) uint64 {
+		if z == 3 {
+			return 0
+		}
+		if x < 2 {
+			return 1
+		}
+		return 2
+	})
+	// Next block in x is all label 3.
+	nextX := makeLabelBlock(size, func(x, y, z int) uint64 { return 3 })
+
+	adj := newAdjacency()
+	if err := adj.addBlock(block, nextX, nil, nil, size, binary.LittleEndian); err != nil {
+		t.Fatalf("Error computing adjacency: %s\n", err.Error())
+	}
+	if adj.sizes[1] != 24 || adj.sizes[2] != 24 {
+		t.Errorf("Bad label sizes: %v\n", adj.sizes)
+	}
+	if _, found := adj.sizes[0]; found {
+		t.Errorf("Background label should not be tallied\n")
+	}
+	if area := adj.contacts[labelPair{1, 2}]; area != 12 {
+		t.Errorf("Expected contact area 12 between labels 1 and 2, got %d\n", area)
+	}
+	if area := adj.contacts[labelPair{2, 3}]; area != 12 {
+		t.Errorf("Expected contact area 12 between labels 2 and 3, got %d\n", area)
+	}
+	if len(adj.contacts) != 2 {
+		t.Errorf("Expected 2 contacts, got %v\n", adj.contacts)
+	}
+	if newLabelPair(7, 3) != (labelPair{3, 7}) {
+		t.Errorf("Label pair not ordered\n")
+	}
+
+	if err := adj.addBlock(block[8:], nil, nil, nil, size, binary.LittleEndian); err == nil {
+		t.Errorf("Expected error on bad block size\n")
+	}
+}
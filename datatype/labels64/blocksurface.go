@@ -0,0 +1,300 @@
+/*
+	This file supports parallel computation of label surfaces.  Each block of a label is
+	processed independently using the label's voxels within the block plus a one voxel
+	shell taken from neighboring blocks, which is sufficient to determine the surface voxels
+	and normals along the block faces.  The block surfaces are then stitched together in
+	block order.  For large labels, block surfaces are checkpointed so an interrupted
+	computation resumes without recomputing finished blocks.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Labels with at least this many blocks have their block surfaces checkpointed.
+const surfaceCheckpointBlocks = 256
+
+// rlesByZYX sorts RLEs by their starting voxel in ZYX order.
+type rlesByZYX dvid.RLEs
+
+func (r rlesByZYX) Len() int      { return len(r) }
+func (r rlesByZYX) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r rlesByZYX) Less(i, j int) bool {
+	pi, pj := r[i].StartPt(), r[j].StartPt()
+	if pi[2] != pj[2] {
+		return pi[2] < pj[2]
+	}
+	if pi[1] != pj[1] {
+		return pi[1] < pj[1]
+	}
+	return pi[0] < pj[0]
+}
+
+// clipRLEs returns the portions of RLEs within the given inclusive voxel bounds.
+func clipRLEs(rles dvid.RLEs, minPt, maxPt dvid.Point3d) dvid.RLEs {
+	var clipped dvid.RLEs
+	for _, rle := range rles {
+		start := rle.StartPt()
+		if start[1] < minPt[1] || start[1] > maxPt[1] || start[2] < minPt[2] || start[2] > maxPt[2] {
+			continue
+		}
+		x0 := start[0]
+		x1 := x0 + rle.Length() - 1
+		if x0 < minPt[0] {
+			x0 = minPt[0]
+		}
+		if x1 > maxPt[0] {
+			x1 = maxPt[0]
+		}
+		if x0 > x1 {
+			continue
+		}
+		clipped = append(clipped, dvid.NewRLE(dvid.Point3d{x0, start[1], start[2]}, x1-x0+1))
+	}
+	return clipped
+}
+
+// blockContext returns a label's RLEs within a block and the one voxel shell around it,
+// sorted in ZYX order.
+func blockContext(rles blockRLEs, zyx dvid.IndexZYX, blockSize dvid.Point3d) dvid.RLEs {
+	var minPt, maxPt dvid.Point3d
+	for dim := 0; dim < 3; dim++ {
+		minPt[dim] = zyx[dim]*blockSize[dim] - 1
+		maxPt[dim] = (zyx[dim] + 1) * blockSize[dim]
+	}
+	var context dvid.RLEs
+	for dz := int32(-1); dz <= 1; dz++ {
+		for dy := int32(-1); dy <= 1; dy++ {
+			for dx := int32(-1); dx <= 1; dx++ {
+				neighbor := dvid.IndexZYX{zyx[0] + dx, zyx[1] + dy, zyx[2] + dz}
+				neighborRLEs, found := rles[string(neighbor.Bytes())]
+				if !found {
+					continue
+				}
+				if dx == 0 && dy == 0 && dz == 0 {
+					context = append(context, neighborRLEs...)
+				} else {
+					context = append(context, clipRLEs(neighborRLEs, minPt, maxPt)...)
+				}
+			}
+		}
+	}
+	sort.Sort(rlesByZYX(context))
+	return context
+}
+
+// blockSurface returns the serialized surface of a label within a block given the RLEs
+// returned by blockContext.
+func (d *Data) blockSurface(label uint64, context dvid.RLEs, zyx dvid.IndexZYX) ([]byte, error) {
+	blockSize := d.BlockSize().(dvid.Point3d)
+	var vol dvid.SparseVol
+	vol.SetLabel(label)
+	vol.AddRLE(context)
+	surface, err := vol.SurfaceSerialization(blockSize[2], d.Resolution.VoxelSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shell voxels are only context and always appear as surface, so drop them.
+	var vertices, normals bytes.Buffer
+	n, err := filterSurface(surface, &vertices, &normals, func(x, y, z int32) bool {
+		return blockCoord(x, blockSize[0]) == zyx[0] &&
+			blockCoord(y, blockSize[1]) == zyx[1] &&
+			blockCoord(z, blockSize[2]) == zyx[2]
+	})
+	if err != nil {
+		return nil, err
+	}
+	surface = make([]byte, 4, 4+vertices.Len()+normals.Len())
+	binary.LittleEndian.PutUint32(surface, n)
+	surface = append(surface, vertices.Bytes()...)
+	return append(surface, normals.Bytes()...), nil
+}
+
+// getSurfaceCheckpoint returns the checkpointed block surfaces of a label, keyed by block
+// in string format.  Each value is a 4 byte checksum of the block context followed by the
+// serialized block surface.
+func getSurfaceCheckpoint(ctx storage.Context, bigdata storage.BigDataStorer, label uint64) (map[string][]byte, error) {
+	begIndex := voxels.NewLabelSurfaceBlockIndex(label, dvid.MinIndexZYX.Bytes())
+	endIndex := voxels.NewLabelSurfaceBlockIndex(label, dvid.MaxIndexZYX.Bytes())
+	kvs, err := bigdata.GetRange(ctx, begIndex, endIndex)
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[string][]byte, len(kvs))
+	for _, kv := range kvs {
+		_, blockBytes, err := voxels.DecodeLabelSurfaceBlockKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		// Skip truncated checkpoints, which will be recomputed.
+		if len(kv.V) < 8 || uint64(len(kv.V)) != 8+24*uint64(binary.LittleEndian.Uint32(kv.V[4:8])) {
+			continue
+		}
+		saved[string(blockBytes)] = kv.V
+	}
+	return saved, nil
+}
+
+// deleteSurfaceCheckpoint removes any checkpointed block surfaces of a label.
+func deleteSurfaceCheckpoint(ctx storage.Context, bigdata storage.BigDataStorer, label uint64) error {
+	begIndex := voxels.NewLabelSurfaceBlockIndex(label, dvid.MinIndexZYX.Bytes())
+	endIndex := voxels.NewLabelSurfaceBlockIndex(label, dvid.MaxIndexZYX.Bytes())
+	return bigdata.DeleteRange(ctx, begIndex, endIndex)
+}
+
+// computeSurface returns the serialized surface of a label, computing the surfaces of its
+// blocks in parallel.  The calling goroutine always computes block surfaces and additional
+// workers are started while chunk handler tokens are available, so many labels can be
+// processed concurrently without exhausting the tokens.
+func (d *Data) computeSurface(ctx storage.Context, label uint64, rles blockRLEs) ([]byte, error) {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, err
+	}
+	blockStrs := make([]string, 0, len(rles))
+	for blockStr := range rles {
+		blockStrs = append(blockStrs, blockStr)
+	}
+	sort.Strings(blockStrs)
+
+	checkpoint := len(blockStrs) >= surfaceCheckpointBlocks
+	var saved map[string][]byte
+	if checkpoint {
+		if saved, err = getSurfaceCheckpoint(ctx, bigdata, label); err != nil {
+			return nil, err
+		}
+	}
+
+	surfaces := make([][]byte, len(blockStrs))
+	indices := make(chan int, len(blockStrs))
+	for i := range blockStrs {
+		indices <- i
+	}
+	close(indices)
+
+	var mu sync.Mutex
+	var surfaceErr error
+	var numResumed int
+	failed := func(err error) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && surfaceErr == nil {
+			surfaceErr = err
+		}
+		return surfaceErr != nil
+	}
+	work := func() {
+		for i := range indices {
+			if storage.Canceled(ctx) {
+				failed(storage.ErrCanceled)
+				return
+			}
+			if failed(nil) {
+				return
+			}
+			if len(rles[blockStrs[i]]) == 0 {
+				surfaces[i] = make([]byte, 4)
+				continue
+			}
+			var zyx dvid.IndexZYX
+			if err := zyx.IndexFromBytes([]byte(blockStrs[i])); err != nil {
+				failed(err)
+				return
+			}
+			context := blockContext(rles, zyx, d.BlockSize().(dvid.Point3d))
+			var signature uint32
+			if checkpoint {
+				encoding, err := context.MarshalBinary()
+				if err != nil {
+					failed(err)
+					return
+				}
+				signature = crc32.ChecksumIEEE(encoding)
+				if value, found := saved[blockStrs[i]]; found && binary.LittleEndian.Uint32(value[0:4]) == signature {
+					surfaces[i] = value[4:]
+					mu.Lock()
+					numResumed++
+					mu.Unlock()
+					continue
+				}
+			}
+			surface, err := d.blockSurface(label, context, zyx)
+			if err != nil {
+				failed(fmt.Errorf("Error computing surface of label %d in block %s: %s", label, zyx, err.Error()))
+				return
+			}
+			surfaces[i] = surface
+			if checkpoint {
+				value := make([]byte, 4, 4+len(surface))
+				binary.LittleEndian.PutUint32(value, signature)
+				value = append(value, surface...)
+				key := voxels.NewLabelSurfaceBlockIndex(label, []byte(blockStrs[i]))
+				if err := bigdata.Put(ctx, key, value); err != nil {
+					failed(err)
+					return
+				}
+			}
+		}
+	}
+
+	timedLog := dvid.NewTimeLog()
+	var wg sync.WaitGroup
+	numWorkers := 1
+AddWorkers:
+	for numWorkers < len(blockStrs) && numWorkers < dvid.NumCPU {
+		select {
+		case <-server.HandlerToken:
+			numWorkers++
+			wg.Add(1)
+			go func() {
+				defer func() {
+					server.HandlerToken <- 1
+					wg.Done()
+				}()
+				work()
+			}()
+		default:
+			break AddWorkers
+		}
+	}
+	work()
+	wg.Wait()
+	if surfaceErr != nil {
+		return nil, surfaceErr
+	}
+
+	// Stitch the block surfaces together.
+	var numVoxels uint64
+	for _, surface := range surfaces {
+		numVoxels += uint64(binary.LittleEndian.Uint32(surface[0:4]))
+	}
+	if numVoxels > math.MaxUint32 {
+		return nil, fmt.Errorf("Surface of label %d has too many voxels: %d", label, numVoxels)
+	}
+	surface := make([]byte, 4+24*numVoxels)
+	binary.LittleEndian.PutUint32(surface[0:4], uint32(numVoxels))
+	vertexPos := 4
+	normalPos := 4 + 12*int(numVoxels)
+	for _, blockSurface := range surfaces {
+		n := int(binary.LittleEndian.Uint32(blockSurface[0:4]))
+		vertexPos += copy(surface[vertexPos:], blockSurface[4:4+12*n])
+		normalPos += copy(surface[normalPos:], blockSurface[4+12*n:])
+	}
+	timedLog.Debugf("Computed surface of label %d with %d voxels over %d blocks (%d resumed) using %d workers",
+		label, numVoxels, len(blockStrs), numResumed, numWorkers)
+	return surface, nil
+}
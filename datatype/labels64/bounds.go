@@ -0,0 +1,135 @@
+/*
+	This file supports restricting sparse volumes to a bounding box so clients can
+	retrieve the portion of a label within a region of interest.
+*/
+
+package labels64
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Bounds is an inclusive voxel bounding box along with the blocks it intersects.
+// A nil *Bounds is unbounded.
+type Bounds struct {
+	VoxelMin, VoxelMax dvid.Point3d
+	BlockMin, BlockMax dvid.ChunkPoint3d
+}
+
+// blockCoord returns the block coordinate for a voxel coordinate, rounding down for
+// negative coordinates without overflowing.
+func blockCoord(voxel, blockSize int32) int32 {
+	if voxel < 0 {
+		return (voxel+1)/blockSize - 1
+	}
+	return voxel / blockSize
+}
+
+// NewBounds returns bounds for the given inclusive voxel coordinates.
+func NewBounds(minPt, maxPt, blockSize dvid.Point3d) (*Bounds, error) {
+	b := &Bounds{VoxelMin: minPt, VoxelMax: maxPt}
+	for dim := 0; dim < 3; dim++ {
+		if minPt[dim] > maxPt[dim] {
+			return nil, fmt.Errorf("Bounding box minimum %s exceeds maximum %s", minPt, maxPt)
+		}
+		b.BlockMin[dim] = blockCoord(minPt[dim], blockSize[dim])
+		b.BlockMax[dim] = blockCoord(maxPt[dim], blockSize[dim])
+	}
+	return b, nil
+}
+
+// BoundsFromQuery returns bounds given by any of the "minx", "maxx", "miny", "maxy", "minz",
+// and "maxz" query strings, or nil if none were given.  Missing bounds are unlimited.
+func BoundsFromQuery(r *http.Request, blockSize dvid.Point3d) (*Bounds, error) {
+	queryValues := r.URL.Query()
+	minPt := dvid.Point3d{math.MinInt32, math.MinInt32, math.MinInt32}
+	maxPt := dvid.Point3d{math.MaxInt32, math.MaxInt32, math.MaxInt32}
+	var bounded bool
+	for dim, axis := range []string{"x", "y", "z"} {
+		for _, limit := range []string{"min", "max"} {
+			str := queryValues.Get(limit + axis)
+			if str == "" {
+				continue
+			}
+			value, err := strconv.ParseInt(str, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Bad %s%s query value %q: %s", limit, axis, str, err.Error())
+			}
+			if limit == "min" {
+				minPt[dim] = int32(value)
+			} else {
+				maxPt[dim] = int32(value)
+			}
+			bounded = true
+		}
+	}
+	if !bounded {
+		return nil, nil
+	}
+	return NewBounds(minPt, maxPt, blockSize)
+}
+
+// BlockWithin returns true if the block coordinate intersects the bounds.
+func (b *Bounds) BlockWithin(block dvid.ChunkPoint3d) bool {
+	if b == nil {
+		return true
+	}
+	for dim := 0; dim < 3; dim++ {
+		if block[dim] < b.BlockMin[dim] || block[dim] > b.BlockMax[dim] {
+			return false
+		}
+	}
+	return true
+}
+
+// BlockRange returns the first and last block indices in ZYX order that could
+// intersect the bounds.
+func (b *Bounds) BlockRange() (begIndex, endIndex dvid.IndexZYX) {
+	if b == nil {
+		return dvid.MinIndexZYX, dvid.MaxIndexZYX
+	}
+	return dvid.IndexZYX(b.BlockMin), dvid.IndexZYX(b.BlockMax)
+}
+
+// clipRuns appends the RLE runs, each of form x, y, z, length as little endian int32,
+// that intersect the bounds to the encoding after clipping them.  Returns the new
+// encoding and the number of runs appended.
+func (b *Bounds) clipRuns(encoding, runs []byte) ([]byte, uint32) {
+	if b == nil {
+		return append(encoding, runs...), uint32(len(runs) / 16)
+	}
+	var numRuns uint32
+	var run [16]byte
+	for i := 0; i+16 <= len(runs); i += 16 {
+		x := int32(binary.LittleEndian.Uint32(runs[i : i+4]))
+		y := int32(binary.LittleEndian.Uint32(runs[i+4 : i+8]))
+		z := int32(binary.LittleEndian.Uint32(runs[i+8 : i+12]))
+		length := int32(binary.LittleEndian.Uint32(runs[i+12 : i+16]))
+		if y < b.VoxelMin[1] || y > b.VoxelMax[1] || z < b.VoxelMin[2] || z > b.VoxelMax[2] {
+			continue
+		}
+		// Use int64 so runs near the coordinate limits don't overflow.
+		x0, x1 := int64(x), int64(x)+int64(length)-1
+		if x0 < int64(b.VoxelMin[0]) {
+			x0 = int64(b.VoxelMin[0])
+		}
+		if x1 > int64(b.VoxelMax[0]) {
+			x1 = int64(b.VoxelMax[0])
+		}
+		if x0 > x1 {
+			continue
+		}
+		binary.LittleEndian.PutUint32(run[0:4], uint32(int32(x0)))
+		copy(run[4:12], runs[i+4:i+12])
+		binary.LittleEndian.PutUint32(run[12:16], uint32(int32(x1-x0+1)))
+		encoding = append(encoding, run[:]...)
+		numRuns++
+	}
+	return encoding, numRuns
+}
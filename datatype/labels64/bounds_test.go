@@ -0,0 +1,55 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func encodeRuns(runs ...[4]int32) []byte {
+	buf := make([]byte, 16*len(runs))
+	for i, run := range runs {
+		for j, value := range run {
+			binary.LittleEndian.PutUint32(buf[i*16+j*4:], uint32(value))
+		}
+	}
+	return buf
+}
+
+func TestBounds(t *testing.T) {
+	bounds, err := NewBounds(dvid.Point3d{-33, 0, 10}, dvid.Point3d{40, 63, 10}, dvid.Point3d{32, 32, 32})
+	if err != nil {
+		t.Fatalf("Error creating bounds: %s\n", err.Error())
+	}
+	if bounds.BlockMin != (dvid.ChunkPoint3d{-2, 0, 0}) || bounds.BlockMax != (dvid.ChunkPoint3d{1, 1, 0}) {
+		t.Errorf("Bad block bounds: %v to %v\n", bounds.BlockMin, bounds.BlockMax)
+	}
+	if !bounds.BlockWithin(dvid.ChunkPoint3d{-1, 1, 0}) || bounds.BlockWithin(dvid.ChunkPoint3d{0, 0, 1}) {
+		t.Errorf("Bad block intersection test for bounds %v to %v\n", bounds.BlockMin, bounds.BlockMax)
+	}
+	if _, err := NewBounds(dvid.Point3d{1, 0, 0}, dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}); err == nil {
+		t.Errorf("Expected error for bounds with min > max\n")
+	}
+
+	runs := encodeRuns(
+		[4]int32{-50, 5, 10, 20}, // clipped on left
+		[4]int32{30, 5, 10, 20},  // clipped on right
+		[4]int32{0, 5, 11, 20},   // outside z bounds
+		[4]int32{41, 5, 10, 5},   // outside x bounds
+	)
+	encoding, numRuns := bounds.clipRuns(nil, runs)
+	if numRuns != 2 {
+		t.Fatalf("Expected 2 clipped runs, got %d\n", numRuns)
+	}
+	expected := encodeRuns([4]int32{-33, 5, 10, 3}, [4]int32{30, 5, 10, 11})
+	if string(encoding) != string(expected) {
+		t.Errorf("Bad clipped runs: expected %v, got %v\n", expected, encoding)
+	}
+
+	var unbounded *Bounds
+	encoding, numRuns = unbounded.clipRuns(nil, runs)
+	if numRuns != 4 || len(encoding) != len(runs) {
+		t.Errorf("Expected unbounded runs to be unchanged, got %d runs\n", numRuns)
+	}
+}
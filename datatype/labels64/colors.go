@@ -0,0 +1,269 @@
+/*
+	This file maps labels to the colors used in server-side label rendering.  Labels are
+	colored by a hash of the label unless a color has been assigned to them, so viewers
+	that fetch colors from a labels64 instance render labels consistently.
+*/
+
+package labels64
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// labelColorsMu serializes changes to assigned label colors.
+var labelColorsMu sync.Mutex
+
+// ColorMapper maps labels to the colors used when rendering them.
+type ColorMapper interface {
+	LabelColor(label uint64) color.NRGBA
+}
+
+// HashColors maps each label to an opaque color computed from a hash of the label, so
+// the same label has the same color on any server without stored state.
+type HashColors struct{}
+
+func (HashColors) LabelColor(label uint64) color.NRGBA {
+	labelBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(labelBytes, label)
+	hashBuf := make([]byte, 4)
+	murmurhash3(labelBytes, hashBuf)
+	return color.NRGBA{hashBuf[0], hashBuf[1], hashBuf[2], 255}
+}
+
+// PaletteColors maps labels with assigned colors to those colors and all other labels
+// through a fallback mapper.
+type PaletteColors struct {
+	Assigned map[uint64]color.NRGBA
+	Fallback ColorMapper
+}
+
+func (p PaletteColors) LabelColor(label uint64) color.NRGBA {
+	if c, found := p.Assigned[label]; found {
+		return c
+	}
+	return p.Fallback.LabelColor(label)
+}
+
+// parseColor returns a color from a "#rrggbb" or "#rrggbbaa" hex string.
+func parseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("Bad color %q: must be #rrggbb or #rrggbbaa", s)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("Bad color %q: must be #rrggbb or #rrggbbaa", s)
+	}
+	return color.NRGBA{uint8(value >> 24), uint8(value >> 16), uint8(value >> 8), uint8(value)}, nil
+}
+
+// formatColor returns a color as "#rrggbb", or "#rrggbbaa" if it isn't opaque.
+func formatColor(c color.NRGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// encodeLabelColors serializes assigned colors as a sequence of big-endian labels, each
+// followed by its RGBA bytes, in label order.
+func encodeLabelColors(assigned map[uint64]color.NRGBA) []byte {
+	labels := make([]uint64, 0, len(assigned))
+	for label := range assigned {
+		labels = append(labels, label)
+	}
+	sort.Sort(labelSlice(labels))
+	buf := make([]byte, 12*len(labels))
+	for i, label := range labels {
+		c := assigned[label]
+		binary.BigEndian.PutUint64(buf[i*12:], label)
+		copy(buf[i*12+8:i*12+12], []byte{c.R, c.G, c.B, c.A})
+	}
+	return buf
+}
+
+func decodeLabelColors(value []byte) (map[uint64]color.NRGBA, error) {
+	if len(value)%12 != 0 {
+		return nil, fmt.Errorf("Bad label colors value, expected multiple of 12 bytes got %d", len(value))
+	}
+	assigned := make(map[uint64]color.NRGBA, len(value)/12)
+	for i := 0; i < len(value); i += 12 {
+		label := binary.BigEndian.Uint64(value[i : i+8])
+		assigned[label] = color.NRGBA{value[i+8], value[i+9], value[i+10], value[i+11]}
+	}
+	return assigned, nil
+}
+
+// getLabelColors returns the colors assigned to labels in a version.
+func getLabelColors(ctx *datastore.VersionedContext, smalldata storage.SmallDataStorer) (map[uint64]color.NRGBA, error) {
+	value, err := smalldata.Get(ctx, voxels.NewLabelColorsIndex())
+	if err != nil {
+		return nil, err
+	}
+	return decodeLabelColors(value)
+}
+
+// GetAssignedColors returns the colors assigned to labels in a version.  Assigned colors
+// are inherited by descendant versions.
+func (d *Data) GetAssignedColors(ctx *datastore.VersionedContext) (map[uint64]color.NRGBA, error) {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	return getLabelColors(ctx, smalldata)
+}
+
+// GetColorMapper returns the mapper used to render labels in a version: assigned colors
+// with hash-based colors for all other labels.
+func (d *Data) GetColorMapper(ctx *datastore.VersionedContext) (ColorMapper, error) {
+	assigned, err := d.GetAssignedColors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(assigned) == 0 {
+		return HashColors{}, nil
+	}
+	return PaletteColors{assigned, HashColors{}}, nil
+}
+
+// AssignColors assigns colors to labels in a version.  A nil color removes the label's
+// assigned color so it reverts to its hash-based color.
+func (d *Data) AssignColors(ctx *datastore.VersionedContext, colors map[uint64]*color.NRGBA) error {
+	labelColorsMu.Lock()
+	defer labelColorsMu.Unlock()
+
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	assigned, err := getLabelColors(ctx, smalldata)
+	if err != nil {
+		return err
+	}
+	for label, c := range colors {
+		if c == nil {
+			delete(assigned, label)
+		} else {
+			assigned[label] = *c
+		}
+	}
+	if err := smalldata.Put(ctx, voxels.NewLabelColorsIndex(), encodeLabelColors(assigned)); err != nil {
+		return fmt.Errorf("Unable to store label colors for data %q: %s", d.DataName(), err.Error())
+	}
+	return nil
+}
+
+// colorLabels returns an image of packed 64-bit labels rendered with a color mapper.
+// Label 0 is background and left transparent.
+func colorLabels(colors ColorMapper, data []byte, byteOrder binary.ByteOrder, nx, ny int) (*dvid.Image, error) {
+	if len(data) != nx*ny*8 {
+		return nil, fmt.Errorf("Expected %d bytes of labels for %d x %d image, got %d", nx*ny*8, nx, ny, len(data))
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, nx, ny))
+	var curLabel uint64
+	curColor := color.NRGBA{}
+	for i := 0; i < nx*ny; i++ {
+		label := byteOrder.Uint64(data[i*8 : i*8+8])
+		if label == 0 {
+			continue
+		}
+		if label != curLabel {
+			curLabel = label
+			curColor = colors.LabelColor(label)
+		}
+		copy(img.Pix[i*4:i*4+4], []byte{curColor.R, curColor.G, curColor.B, curColor.A})
+	}
+	return dvid.ImageFromGoImage(img, voxels.RGBA8EncodeFormat(), false)
+}
+
+// ServeColors handles GET requests for label colors and POST requests that assign them.
+func (d *Data) ServeColors(ctx *datastore.VersionedContext, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		var colors map[uint64]color.NRGBA
+		if labelsStr := r.URL.Query().Get("labels"); labelsStr != "" {
+			mapper, err := d.GetColorMapper(ctx)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			colors = make(map[uint64]color.NRGBA)
+			for _, labelStr := range strings.Split(labelsStr, ",") {
+				label, err := strconv.ParseUint(labelStr, 10, 64)
+				if err != nil {
+					server.BadRequest(w, r, fmt.Sprintf("Bad label %q", labelStr))
+					return
+				}
+				colors[label] = mapper.LabelColor(label)
+			}
+		} else {
+			var err error
+			if colors, err = d.GetAssignedColors(ctx); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		colorStrs := make(map[string]string, len(colors))
+		for label, c := range colors {
+			colorStrs[strconv.FormatUint(label, 10)] = formatColor(c)
+		}
+		jsonBytes, err := json.Marshal(colorStrs)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.Write(jsonBytes)
+
+	case "POST":
+		var colorStrs map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&colorStrs); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("Bad JSON of label colors: %s", err.Error()))
+			return
+		}
+		colors := make(map[uint64]*color.NRGBA, len(colorStrs))
+		for labelStr, colorStr := range colorStrs {
+			label, err := strconv.ParseUint(labelStr, 10, 64)
+			if err != nil {
+				server.BadRequest(w, r, fmt.Sprintf("Bad label %q", labelStr))
+				return
+			}
+			if colorStr == "" {
+				colors[label] = nil
+				continue
+			}
+			c, err := parseColor(colorStr)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			colors[label] = &c
+		}
+		if err := d.AssignColors(ctx, colors); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		fmt.Fprintf(w, "Changed colors of %d labels in %q\n", len(colors), d.DataName())
+
+	default:
+		server.BadRequest(w, r, "colors only supports GET and POST requests")
+	}
+}
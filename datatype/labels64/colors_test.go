@@ -0,0 +1,88 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestParseColor(t *testing.T) {
+	c, err := parseColor("#ff8001")
+	if err != nil || c != (color.NRGBA{255, 128, 1, 255}) {
+		t.Errorf("Bad parse of opaque color: %v (%v)\n", c, err)
+	}
+	c, err = parseColor("#00ff0080")
+	if err != nil || c != (color.NRGBA{0, 255, 0, 128}) {
+		t.Errorf("Bad parse of translucent color: %v (%v)\n", c, err)
+	}
+	for _, bad := range []string{"", "#fff", "#gg0000", "#ff00000000"} {
+		if _, err := parseColor(bad); err == nil {
+			t.Errorf("Expected error parsing color %q\n", bad)
+		}
+	}
+	if s := formatColor(color.NRGBA{255, 128, 1, 255}); s != "#ff8001" {
+		t.Errorf("Bad format of opaque color: %s\n", s)
+	}
+	if s := formatColor(color.NRGBA{0, 255, 0, 128}); s != "#00ff0080" {
+		t.Errorf("Bad format of translucent color: %s\n", s)
+	}
+}
+
+func TestLabelColors(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	labels := newDataInstance(repo, t, "bodies")
+	ctx := datastore.NewVersionedContext(labels, versionID)
+
+	// Hash colors are the same as those of label composites.
+	labelBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(labelBytes, 23)
+	hashBuf := make([]byte, 4)
+	murmurhash3(labelBytes, hashBuf)
+	hashed := HashColors{}.LabelColor(23)
+	if hashed != (color.NRGBA{hashBuf[0], hashBuf[1], hashBuf[2], 255}) {
+		t.Errorf("Bad hash color for label 23: %v\n", hashed)
+	}
+
+	red := color.NRGBA{255, 0, 0, 255}
+	if err := labels.AssignColors(ctx, map[uint64]*color.NRGBA{23: &red, 42: &red}); err != nil {
+		t.Fatalf("Unable to assign colors: %s\n", err.Error())
+	}
+	if err := labels.AssignColors(ctx, map[uint64]*color.NRGBA{42: nil}); err != nil {
+		t.Fatalf("Unable to remove color: %s\n", err.Error())
+	}
+	assigned, err := labels.GetAssignedColors(ctx)
+	if err != nil {
+		t.Fatalf("Unable to get assigned colors: %s\n", err.Error())
+	}
+	if len(assigned) != 1 || assigned[23] != red {
+		t.Errorf("Bad assigned colors: %v\n", assigned)
+	}
+	mapper, err := labels.GetColorMapper(ctx)
+	if err != nil {
+		t.Fatalf("Unable to get color mapper: %s\n", err.Error())
+	}
+	if c := mapper.LabelColor(23); c != red {
+		t.Errorf("Expected assigned color for label 23, got %v\n", c)
+	}
+	if c := mapper.LabelColor(42); c != (HashColors{}).LabelColor(42) {
+		t.Errorf("Expected hash color for label 42, got %v\n", c)
+	}
+
+	// Label 0 is transparent in colored images.
+	data := make([]byte, 8*2)
+	binary.LittleEndian.PutUint64(data[8:], 23)
+	img, err := colorLabels(mapper, data, binary.LittleEndian, 2, 1)
+	if err != nil {
+		t.Fatalf("Unable to color labels: %s\n", err.Error())
+	}
+	expected := []byte{0, 0, 0, 0, 255, 0, 0, 255}
+	if string(img.NRGBA.Pix) != string(expected) {
+		t.Errorf("Bad colored labels: expected %v, got %v\n", expected, img.NRGBA.Pix)
+	}
+}
@@ -0,0 +1,299 @@
+/*
+	This file supports extraction of the contact interface between two labels, i.e., the
+	voxels of each label that share a face with a voxel of the other label.
+*/
+
+package labels64
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Contacts describes the interface between two labels.  Area is the number of voxel
+// faces shared by the labels, and Sites1 and Sites2 are the voxel coordinates on each
+// side of the interface, sorted in ZYX order.
+type Contacts struct {
+	Label1 uint64
+	Label2 uint64
+	Area   uint64
+	Sites1 []dvid.Point3d
+	Sites2 []dvid.Point3d
+}
+
+type contactTally struct {
+	label1, label2 uint64
+	area           uint64
+	sites1         map[dvid.Point3d]struct{}
+	sites2         map[dvid.Point3d]struct{}
+}
+
+func newContactTally(label1, label2 uint64) *contactTally {
+	return &contactTally{
+		label1: label1,
+		label2: label2,
+		sites1: make(map[dvid.Point3d]struct{}),
+		sites2: make(map[dvid.Point3d]struct{}),
+	}
+}
+
+func (c *contactTally) check(labelA, labelB uint64, ptA, ptB dvid.Point3d) {
+	switch {
+	case labelA == c.label1 && labelB == c.label2:
+		c.area++
+		c.sites1[ptA] = struct{}{}
+		c.sites2[ptB] = struct{}{}
+	case labelA == c.label2 && labelB == c.label1:
+		c.area++
+		c.sites2[ptA] = struct{}{}
+		c.sites1[ptB] = struct{}{}
+	}
+}
+
+// addBlock tallies contacts within a block whose first voxel is at offset as well as
+// contacts across the faces shared with the next block in x, y, and z.  Neighbor blocks
+// may be nil if they don't contain either label.
+func (c *contactTally) addBlock(block, nextX, nextY, nextZ []byte, offset, size dvid.Point3d, byteOrder binary.ByteOrder) error {
+	nx, ny, nz := int(size[0]), int(size[1]), int(size[2])
+	numBytes := nx * ny * nz * 8
+	for _, b := range [][]byte{block, nextX, nextY, nextZ} {
+		if b != nil && len(b) != numBytes {
+			return fmt.Errorf("Label block has %d bytes, expected %d bytes", len(b), numBytes)
+		}
+	}
+	label := func(data []byte, x, y, z int) uint64 {
+		i := ((z*ny+y)*nx + x) * 8
+		return byteOrder.Uint64(data[i : i+8])
+	}
+	pt := func(x, y, z int) dvid.Point3d {
+		return dvid.Point3d{offset[0] + int32(x), offset[1] + int32(y), offset[2] + int32(z)}
+	}
+	for z := 0; z < nz; z++ {
+		for y := 0; y < ny; y++ {
+			for x := 0; x < nx; x++ {
+				cur := label(block, x, y, z)
+				if cur != c.label1 && cur != c.label2 {
+					continue
+				}
+				curPt := pt(x, y, z)
+				if x < nx-1 {
+					c.check(cur, label(block, x+1, y, z), curPt, pt(x+1, y, z))
+				} else if nextX != nil {
+					c.check(cur, label(nextX, 0, y, z), curPt, pt(x+1, y, z))
+				}
+				if y < ny-1 {
+					c.check(cur, label(block, x, y+1, z), curPt, pt(x, y+1, z))
+				} else if nextY != nil {
+					c.check(cur, label(nextY, x, 0, z), curPt, pt(x, y+1, z))
+				}
+				if z < nz-1 {
+					c.check(cur, label(block, x, y, z+1), curPt, pt(x, y, z+1))
+				} else if nextZ != nil {
+					c.check(cur, label(nextZ, x, y, 0), curPt, pt(x, y, z+1))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sortedSites(sites map[dvid.Point3d]struct{}) []dvid.Point3d {
+	pts := make([]dvid.Point3d, 0, len(sites))
+	for pt := range sites {
+		pts = append(pts, pt)
+	}
+	sort.Sort(zyxPoints(pts))
+	return pts
+}
+
+type zyxPoints []dvid.Point3d
+
+func (p zyxPoints) Len() int      { return len(p) }
+func (p zyxPoints) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p zyxPoints) Less(i, j int) bool {
+	if p[i][2] != p[j][2] {
+		return p[i][2] < p[j][2]
+	}
+	if p[i][1] != p[j][1] {
+		return p[i][1] < p[j][1]
+	}
+	return p[i][0] < p[j][0]
+}
+
+func (c *contactTally) contacts() *Contacts {
+	return &Contacts{
+		Label1: c.label1,
+		Label2: c.label2,
+		Area:   c.area,
+		Sites1: sortedSites(c.sites1),
+		Sites2: sortedSites(c.sites2),
+	}
+}
+
+// getLabelBlocks returns the block indices, keyed by index string, that contain a label.
+func getLabelBlocks(ctx storage.Context, label uint64) (map[string]dvid.IndexZYX, error) {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	begIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MinIndexZYX.Bytes())
+	endIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MaxIndexZYX.Bytes())
+	keys, err := smalldata.KeysInRange(ctx, begIndex, endIndex)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make(map[string]dvid.IndexZYX, len(keys))
+	for _, key := range keys {
+		_, blockBytes, err := voxels.DecodeLabelSpatialMapKey(key)
+		if err != nil {
+			return nil, err
+		}
+		var indexZYX dvid.IndexZYX
+		if err := indexZYX.IndexFromBytes(blockBytes); err != nil {
+			return nil, err
+		}
+		blocks[string(blockBytes)] = indexZYX
+	}
+	return blocks, nil
+}
+
+// GetContacts returns the contact interface between two labels.  Only blocks containing
+// either label, as given by the label spatial indices, are examined.
+func (d *Data) GetContacts(ctx *datastore.VersionedContext, label1, label2 uint64) (*Contacts, error) {
+	contacts, _, err := d.GetContactsBudget(ctx, label1, label2, nil)
+	return contacts, err
+}
+
+// contactsProgress is the state of a contacts computation cut short by its budget.
+type contactsProgress struct {
+	tally  *contactTally
+	blocks []dvid.IndexZYX // sorted blocks containing either label
+	next   int
+}
+
+// GetContactsBudget is like GetContacts but stops examining blocks once the budget is
+// exceeded.  If the contacts are partial, the returned state can be passed to
+// QueryBudget.SetPartial to allow resuming the computation.
+func (d *Data) GetContactsBudget(ctx *datastore.VersionedContext, label1, label2 uint64,
+	budget *server.QueryBudget) (*Contacts, interface{}, error) {
+
+	if label1 == label2 {
+		return nil, nil, fmt.Errorf("Contacts require two different labels, got %d twice", label1)
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, nil, fmt.Errorf("Contacts require 3d blocks, %s has block size %s", d.DataName(), d.BlockSize())
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+
+	var progress *contactsProgress
+	if state := budget.State(); state != nil {
+		if progress, ok = state.(*contactsProgress); !ok {
+			return nil, nil, fmt.Errorf("Continuation is not for contacts")
+		}
+	} else {
+		blocks, err := getLabelBlocks(ctx, label1)
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks2, err := getLabelBlocks(ctx, label2)
+		if err != nil {
+			return nil, nil, err
+		}
+		for blockStr, indexZYX := range blocks2 {
+			blocks[blockStr] = indexZYX
+		}
+		blockStrs := make([]string, 0, len(blocks))
+		for blockStr := range blocks {
+			blockStrs = append(blockStrs, blockStr)
+		}
+		sort.Strings(blockStrs)
+		progress = &contactsProgress{tally: newContactTally(label1, label2)}
+		for _, blockStr := range blockStrs {
+			progress.blocks = append(progress.blocks, blocks[blockStr])
+		}
+	}
+	blocks := make(map[string]struct{}, len(progress.blocks))
+	for _, indexZYX := range progress.blocks {
+		blocks[string(indexZYX.Bytes())] = struct{}{}
+	}
+
+	// Load blocks as needed, keeping them around since each block may be the neighbor
+	// of another block.
+	cache := make(map[string][]byte, len(blocks))
+	getBlock := func(indexZYX dvid.IndexZYX) ([]byte, error) {
+		blockStr := string(indexZYX.Bytes())
+		if _, found := blocks[blockStr]; !found {
+			return nil, nil
+		}
+		if data, found := cache[blockStr]; found {
+			return data, nil
+		}
+		value, err := bigdata.Get(ctx, voxels.NewVoxelBlockIndex(&indexZYX))
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, nil
+		}
+		data, _, err := dvid.DeserializeData(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to deserialize block %s: %s", &indexZYX, err.Error())
+		}
+		cache[blockStr] = data
+		return data, nil
+	}
+
+	// At least one block is examined per call so resumed queries always make progress.
+	for start := progress.next; progress.next < len(progress.blocks); progress.next++ {
+		if progress.next > start && budget.Exceeded() {
+			return progress.tally.contacts(), progress, nil
+		}
+		indexZYX := progress.blocks[progress.next]
+		block, err := getBlock(indexZYX)
+		if err != nil {
+			return nil, nil, err
+		}
+		if block == nil {
+			continue
+		}
+		x, y, z := indexZYX.Unpack()
+		nextX, err := getBlock(dvid.IndexZYX{x + 1, y, z})
+		if err != nil {
+			return nil, nil, err
+		}
+		nextY, err := getBlock(dvid.IndexZYX{x, y + 1, z})
+		if err != nil {
+			return nil, nil, err
+		}
+		nextZ, err := getBlock(dvid.IndexZYX{x, y, z + 1})
+		if err != nil {
+			return nil, nil, err
+		}
+		offset := indexZYX.MinPoint(blockSize).(dvid.Point3d)
+		if err := progress.tally.addBlock(block, nextX, nextY, nextZ, offset, blockSize, d.Properties.ByteOrder); err != nil {
+			return nil, nil, err
+		}
+	}
+	return progress.tally.contacts(), nil, nil
+}
+
+// GetContactsJSON returns the contact interface between two labels in JSON format.
+func (d *Data) GetContactsJSON(ctx *datastore.VersionedContext, label1, label2 uint64) ([]byte, error) {
+	contacts, err := d.GetContacts(ctx, label1, label2)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(contacts)
+}
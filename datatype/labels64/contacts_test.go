@@ -0,0 +1,68 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestContactsWithinBlock(t *testing.T) {
+	// Label 1 fills x = 0, label 2 fills x = 1, and label 3 fills x = 2.
+	size := dvid.Point3d{3, 2, 2}
+	block := makeLabelBlock(size, func(x, y, z int) uint64 { return uint64(x + 1) })
+	offset := dvid.Point3d{10, 20, 30}
+
+	tally := newContactTally(2, 1)
+	if err := tally.addBlock(block, nil, nil, nil, offset, size, binary.LittleEndian); err != nil {
+		t.Fatalf("Error adding block: %s\n", err.Error())
+	}
+	contacts := tally.contacts()
+	if contacts.Area != 4 {
+		t.Errorf("Expected contact area of 4 faces, got %d\n", contacts.Area)
+	}
+	if len(contacts.Sites1) != 4 || len(contacts.Sites2) != 4 {
+		t.Fatalf("Expected 4 sites on each side, got %d and %d\n", len(contacts.Sites1), len(contacts.Sites2))
+	}
+	for i, pt := range contacts.Sites1 {
+		if pt[0] != 11 {
+			t.Errorf("Label 2 site %s should be at x = 11\n", pt)
+		}
+		if i > 0 && !zyxPoints(contacts.Sites1).Less(i-1, i) {
+			t.Errorf("Sites are not in ZYX order: %v\n", contacts.Sites1)
+		}
+	}
+	for _, pt := range contacts.Sites2 {
+		if pt[0] != 10 {
+			t.Errorf("Label 1 site %s should be at x = 10\n", pt)
+		}
+	}
+}
+
+func TestContactsAcrossBlocks(t *testing.T) {
+	size := dvid.Point3d{2, 2, 2}
+	block := makeLabelBlock(size, func(x, y, z int) uint64 { return 1 })
+	nextX := makeLabelBlock(size, func(x, y, z int) uint64 { return 2 })
+	nextZ := makeLabelBlock(size, func(x, y, z int) uint64 {
+		if x == 0 && y == 0 {
+			return 2
+		}
+		return 5
+	})
+
+	tally := newContactTally(1, 2)
+	if err := tally.addBlock(block, nextX, nil, nextZ, dvid.Point3d{0, 0, 0}, size, binary.LittleEndian); err != nil {
+		t.Fatalf("Error adding block: %s\n", err.Error())
+	}
+	contacts := tally.contacts()
+	if contacts.Area != 5 {
+		t.Errorf("Expected 4 faces across x and 1 across z, got area %d\n", contacts.Area)
+	}
+	if len(contacts.Sites2) != 5 {
+		t.Errorf("Expected 5 sites of label 2, got %v\n", contacts.Sites2)
+	}
+
+	if err := tally.addBlock(block[:8], nil, nil, nil, dvid.Point3d{}, size, binary.LittleEndian); err == nil {
+		t.Errorf("Expected error for block of wrong size\n")
+	}
+}
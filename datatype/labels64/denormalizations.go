@@ -194,7 +194,11 @@ func (d *Data) denormFunc(versionID dvid.VersionID, mods voxels.BlockChannel) {
 	// redo denormalization for all of them.
 	// TODO: Limit re-denormalization on actually modified labels, but figure merge/split
 	// is primary calls for these more specific edits.
-	labels := make(map[uint64]bool, 1000)
+	// Key = label, value = blocks in string format containing the label.
+	labels := make(map[uint64]map[string]bool, 1000)
+
+	// All modified blocks, including those cleared of labels, for preview updates.
+	modified := make(map[string]bool)
 
 	// Accept modified label blocks and change LabelSpatialMapIndex key/values.
 	for {
@@ -207,27 +211,36 @@ func (d *Data) denormFunc(versionID dvid.VersionID, mods voxels.BlockChannel) {
 				len(block.Data))
 			return
 		}
+		blockStr := string(block.Index.Bytes())
+		modified[blockStr] = true
 		for i := 0; i < len(block.Data); i += 8 {
 			label := binary.LittleEndian.Uint64(block.Data[i : i+8])
-			if label != 0 {
-				labels[label] = true
+			if label == 0 {
+				continue
+			}
+			blocks, found := labels[label]
+			if !found {
+				blocks = make(map[string]bool)
+				labels[label] = blocks
 			}
+			blocks[blockStr] = true
 		}
 		d.createChunkRLEs(versionID, block.Index, block.Data)
 	}
+	d.queuePreviewUpdate(versionID, modified)
 
-	// Setup goroutines for processing label size and surface.
+	// Setup goroutine for processing label size.
 	ctx := datastore.NewVersionedContext(d, versionID)
 	wg := new(sync.WaitGroup)
 	sizeCh := make(chan *storage.Chunk, 1000)
 	wg.Add(1)
 	go ComputeSizes(ctx, sizeCh, wg)
-	surfaceCh := make(chan *storage.Chunk, 1000)
-	wg.Add(1)
-	go ComputeSurface(ctx, d, surfaceCh, wg)
 
-	// Given all blocks modified, process body RLEs for label sizes and surfaces.
-	for label := range labels {
+	// Given all blocks modified, process body RLEs for label sizes and queue
+	// regeneration of surfaces near the modified blocks.
+	for label, blocks := range labels {
+		d.queueSurfaceUpdate(versionID, label, blocks)
+
 		begIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MinIndexZYX.Bytes())
 		endIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MaxIndexZYX.Bytes())
 		err := smalldata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
@@ -240,11 +253,10 @@ func (d *Data) denormFunc(versionID dvid.VersionID, mods voxels.BlockChannel) {
 			label := binary.BigEndian.Uint64(indexBytes[1:9])
 			chunk.ChunkOp = &storage.ChunkOp{label, nil}
 
-			// Send RLE of label to size indexer and surface calculator.
+			// Send RLE of label to size indexer.
 			sizeCh <- chunk
-			surfaceCh <- chunk
 
-			server.BlockOnInteractiveRequests("labels64 [size/surface compute]")
+			server.BlockOnInteractiveRequests("labels64 [size compute]")
 		})
 		if err != nil {
 			dvid.Errorf("Error denormalizing %s: %s\n", d.DataName(), err.Error())
@@ -253,7 +265,6 @@ func (d *Data) denormFunc(versionID dvid.VersionID, mods voxels.BlockChannel) {
 	}
 
 	sizeCh <- nil
-	surfaceCh <- nil
 
 	// Wait for results then set Updating.
 	go func() {
@@ -304,6 +315,24 @@ func (d *Data) createChunkRLEs(versionID dvid.VersionID, zyx *dvid.IndexZYX, blo
 		dvid.Infof("Retrieved, deserialized block is wrong size: %d bytes\n", blockBytes)
 		return
 	}
+	labelRLEs := d.blockLabelRLEs(zyx, blockData)
+
+	// Store the KeyLabelSpatialMap keys (index = b + s) with slice of runs for value.
+	db, err := storage.SmallDataStore()
+	if err != nil {
+		dvid.Errorf("Error in %s.createChunkRLEs(): %s\n", d.DataName(), err.Error())
+		return
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		dvid.Errorf("Database doesn't support Batch ops in %s.denormalizeChunk()", d.DataName())
+		return
+	}
+	StoreKeyLabelSpatialMap(versionID, d, batcher, zyx.Bytes(), labelRLEs)
+}
+
+// blockLabelRLEs returns the RLEs for each non-zero label within a block of labels.
+func (d *Data) blockLabelRLEs(zyx *dvid.IndexZYX, blockData []byte) map[uint64]dvid.RLEs {
 	labelRLEs := make(map[uint64]dvid.RLEs, 10)
 	firstPt := zyx.MinPoint(d.BlockSize())
 	lastPt := zyx.MaxPoint(d.BlockSize())
@@ -344,17 +373,5 @@ func (d *Data) createChunkRLEs(versionID dvid.VersionID, zyx *dvid.IndexZYX, blo
 			}
 		}
 	}
-
-	// Store the KeyLabelSpatialMap keys (index = b + s) with slice of runs for value.
-	db, err := storage.SmallDataStore()
-	if err != nil {
-		dvid.Errorf("Error in %s.createChunkRLEs(): %s\n", d.DataName(), err.Error())
-		return
-	}
-	batcher, ok := db.(storage.KeyValueBatcher)
-	if !ok {
-		dvid.Errorf("Database doesn't support Batch ops in %s.denormalizeChunk()", d.DataName())
-		return
-	}
-	StoreKeyLabelSpatialMap(versionID, d, batcher, zyx.Bytes(), labelRLEs)
+	return labelRLEs
 }
@@ -0,0 +1,210 @@
+/*
+	This file computes the voxels added to and removed from a label between two versions,
+	e.g., to review a proofreading session, by comparing the label's block runs stored at
+	each version.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// SparseVolDiff holds the voxels of a label added and removed between two versions.
+type SparseVolDiff struct {
+	Added   dvid.RLEs
+	Removed dvid.RLEs
+}
+
+// Stats returns the number of voxels added and removed.
+func (diff *SparseVolDiff) Stats() (added, removed int32) {
+	added, _ = diff.Added.Stats()
+	removed, _ = diff.Removed.Stats()
+	return
+}
+
+// MarshalBinary returns the added then removed voxels, each as a uint32 little-endian
+// number of bytes followed by a sparse volume encoding as returned by "sparsevol".
+func (diff *SparseVolDiff) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, rles := range []dvid.RLEs{diff.Added, diff.Removed} {
+		encoding, err := encodeRLEs(rles)
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(len(encoding)))
+		buf.Write(encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeRLEs returns a sparse volume encoding of runs.
+func encodeRLEs(rles dvid.RLEs) ([]byte, error) {
+	runs, err := rles.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	numVoxels, numRuns := rles.Stats()
+	encoding := make([]byte, 12, 12+len(runs))
+	encoding[0] = dvid.EncodingBinary
+	encoding[1] = 3 // # of dimensions
+	encoding[2] = 0 // dimension of run (X = 0)
+	binary.LittleEndian.PutUint32(encoding[4:8], uint32(numVoxels))
+	binary.LittleEndian.PutUint32(encoding[8:12], uint32(numRuns))
+	return append(encoding, runs...), nil
+}
+
+// GetSparseVolDiff returns the voxels of a label added and removed going from the
+// version of the "from" context to the version of the "to" context.
+func GetSparseVolDiff(from, to *datastore.VersionedContext, label uint64) (*SparseVolDiff, error) {
+	fromRLEs, err := getLabelRLEs(from, label)
+	if err != nil {
+		return nil, err
+	}
+	toRLEs, err := getLabelRLEs(to, label)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare the runs of each block holding the label in either version, in block order.
+	blocks := make(map[string]struct{}, len(toRLEs))
+	for blockStr := range fromRLEs {
+		blocks[blockStr] = struct{}{}
+	}
+	for blockStr := range toRLEs {
+		blocks[blockStr] = struct{}{}
+	}
+	sorted := make([]string, 0, len(blocks))
+	for blockStr := range blocks {
+		sorted = append(sorted, blockStr)
+	}
+	sort.Strings(sorted)
+
+	diff := new(SparseVolDiff)
+	for _, blockStr := range sorted {
+		diff.Added = append(diff.Added, subtractRuns(toRLEs[blockStr], fromRLEs[blockStr])...)
+		diff.Removed = append(diff.Removed, subtractRuns(fromRLEs[blockStr], toRLEs[blockStr])...)
+	}
+	return diff, nil
+}
+
+// interval is an inclusive range of x coordinates.
+type interval struct {
+	x0, x1 int32
+}
+
+type intervals []interval
+
+func (s intervals) Len() int           { return len(s) }
+func (s intervals) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s intervals) Less(i, j int) bool { return s[i].x0 < s[j].x0 }
+
+// rowRuns holds the x intervals of runs for each (y, z) row.
+type rowRuns map[[2]int32]intervals
+
+func newRowRuns(rles dvid.RLEs) rowRuns {
+	rows := make(rowRuns)
+	for _, rle := range rles {
+		start := rle.StartPt()
+		row := [2]int32{start[1], start[2]}
+		rows[row] = append(rows[row], interval{start[0], start[0] + rle.Length() - 1})
+	}
+	return rows
+}
+
+// subtractRuns returns runs of voxels in a that aren't in b, sorted by z, y, then x.
+func subtractRuns(a, b dvid.RLEs) dvid.RLEs {
+	if len(a) == 0 {
+		return nil
+	}
+	aRows := newRowRuns(a)
+	bRows := newRowRuns(b)
+	rows := make([][2]int32, 0, len(aRows))
+	for row := range aRows {
+		rows = append(rows, row)
+	}
+	sort.Sort(rowOrder(rows))
+
+	var diff dvid.RLEs
+	for _, row := range rows {
+		aRuns := aRows[row]
+		bRuns := bRows[row]
+		sort.Sort(aRuns)
+		sort.Sort(bRuns)
+		for _, run := range subtractIntervals(aRuns, bRuns) {
+			diff = append(diff, dvid.NewRLE(dvid.Point3d{run.x0, row[0], row[1]}, run.x1-run.x0+1))
+		}
+	}
+	return diff
+}
+
+// subtractIntervals returns the portions of intervals in a that aren't in b, where both
+// are sorted.
+func subtractIntervals(a, b intervals) intervals {
+	var diff intervals
+	for _, run := range a {
+		x := run.x0
+		for _, sub := range b {
+			if sub.x1 < x {
+				continue
+			}
+			if sub.x0 > run.x1 {
+				break
+			}
+			if sub.x0 > x {
+				diff = append(diff, interval{x, sub.x0 - 1})
+			}
+			x = sub.x1 + 1
+			if x > run.x1 {
+				break
+			}
+		}
+		if x <= run.x1 {
+			diff = append(diff, interval{x, run.x1})
+		}
+	}
+	return diff
+}
+
+// rowOrder sorts (y, z) rows by z then y.
+type rowOrder [][2]int32
+
+func (s rowOrder) Len() int      { return len(s) }
+func (s rowOrder) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s rowOrder) Less(i, j int) bool {
+	if s[i][1] != s[j][1] {
+		return s[i][1] < s[j][1]
+	}
+	return s[i][0] < s[j][0]
+}
+
+// versionContextInRepo returns a context for the data at the version with the given
+// UUID string, which must be in the same repo as the given version.
+func versionContextInRepo(d dvid.Data, versionID dvid.VersionID, uuidStr string) (*datastore.VersionedContext, error) {
+	uuid, otherID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return nil, err
+	}
+	curUUID, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		return nil, err
+	}
+	curRepo, err := datastore.RepoFromUUID(curUUID)
+	if err != nil {
+		return nil, err
+	}
+	otherRepo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if curRepo.RootUUID() != otherRepo.RootUUID() {
+		return nil, fmt.Errorf("Version %s is not in the same repo as data %q", uuid, d.DataName())
+	}
+	return datastore.NewVersionedContext(d, otherID), nil
+}
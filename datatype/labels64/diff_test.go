@@ -0,0 +1,58 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestSubtractRuns(t *testing.T) {
+	a := dvid.RLEs{
+		dvid.NewRLE(dvid.Point3d{0, 1, 2}, 10), // x 0-9
+		dvid.NewRLE(dvid.Point3d{5, 0, 2}, 3),  // x 5-7
+		dvid.NewRLE(dvid.Point3d{0, 0, 1}, 4),  // x 0-3
+	}
+	b := dvid.RLEs{
+		dvid.NewRLE(dvid.Point3d{2, 1, 2}, 2), // x 2-3
+		dvid.NewRLE(dvid.Point3d{6, 1, 2}, 6), // x 6-11
+		dvid.NewRLE(dvid.Point3d{0, 0, 1}, 4), // x 0-3
+	}
+	expected := dvid.RLEs{
+		dvid.NewRLE(dvid.Point3d{5, 0, 2}, 3),
+		dvid.NewRLE(dvid.Point3d{0, 1, 2}, 2),
+		dvid.NewRLE(dvid.Point3d{4, 1, 2}, 2),
+	}
+	if diff := subtractRuns(a, b); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected a - b = %v, got %v\n", expected, diff)
+	}
+	expected = dvid.RLEs{dvid.NewRLE(dvid.Point3d{10, 1, 2}, 2)}
+	if diff := subtractRuns(b, a); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected b - a = %v, got %v\n", expected, diff)
+	}
+	if diff := subtractRuns(a, a); len(diff) != 0 {
+		t.Errorf("Expected no difference of runs with themselves, got %v\n", diff)
+	}
+	if diff := subtractRuns(nil, a); diff != nil {
+		t.Errorf("Expected no difference from empty runs, got %v\n", diff)
+	}
+
+	diff := &SparseVolDiff{Added: expected}
+	data, err := diff.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error encoding diff: %s\n", err.Error())
+	}
+	if len(data) != 4+12+16+4+12 {
+		t.Fatalf("Expected %d bytes for diff, got %d\n", 4+12+16+4+12, len(data))
+	}
+	if n := binary.LittleEndian.Uint32(data[0:4]); n != 28 {
+		t.Errorf("Expected 28 bytes of added voxels, got %d\n", n)
+	}
+	if voxels := binary.LittleEndian.Uint32(data[8:12]); voxels != 2 {
+		t.Errorf("Expected 2 added voxels, got %d\n", voxels)
+	}
+	if n := binary.LittleEndian.Uint32(data[32:36]); n != 12 {
+		t.Errorf("Expected 12 bytes of removed voxels, got %d\n", n)
+	}
+}
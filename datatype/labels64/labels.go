@@ -6,10 +6,13 @@ package labels64
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
+	"net/http"
 	"sync"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -58,7 +61,8 @@ func StoreKeyLabelSpatialMap(versionID dvid.VersionID, data dvid.Data, batcher s
 // ComputeSurface computes and stores a label surface.
 // Runs asynchronously and assumes that sparse volumes per spatial indices are ordered
 // by mapped label, i.e., we will get all data for body N before body N+1.  Exits when
-// receives a nil in channel.
+// receives a nil in channel.  The blocks of each label are processed in parallel as
+// chunk handler tokens allow.
 func ComputeSurface(ctx storage.Context, data *Data, ch chan *storage.Chunk, wg *sync.WaitGroup) {
 	defer func() {
 		wg.Done()
@@ -66,14 +70,14 @@ func ComputeSurface(ctx storage.Context, data *Data, ch chan *storage.Chunk, wg
 	}()
 
 	// Sequentially process all the sparse volume data for each label coming down channel.
-	var curVol dvid.SparseVol
+	var curRLEs blockRLEs
 	var curLabel uint64
 	notFirst := false
 	for {
 		chunk := <-ch
 		if chunk == nil {
 			if notFirst {
-				if err := data.computeAndSaveSurface(ctx, &curVol); err != nil {
+				if err := data.computeAndSaveSurface(ctx, curLabel, curRLEs); err != nil {
 					dvid.Errorf("Error on computing surface and normals: %s\n", err.Error())
 					return
 				}
@@ -83,30 +87,51 @@ func ComputeSurface(ctx storage.Context, data *Data, ch chan *storage.Chunk, wg
 		label := chunk.ChunkOp.Op.(uint64)
 		if label != curLabel || label == 0 {
 			if notFirst {
-				if err := data.computeAndSaveSurface(ctx, &curVol); err != nil {
+				if err := data.computeAndSaveSurface(ctx, curLabel, curRLEs); err != nil {
 					dvid.Errorf("Error on computing surface and normals: %s\n", err.Error())
 					return
 				}
 			}
-			curVol.Clear()
-			curVol.SetLabel(label)
+			curRLEs = blockRLEs{}
 		}
 
-		if err := curVol.AddSerializedRLEs(chunk.V); err != nil {
+		_, blockBytes, err := voxels.DecodeLabelSpatialMapKey(chunk.K)
+		if err != nil {
+			dvid.Errorf("Can't recover block index with chunk key %v: %s\n", chunk.K, err.Error())
+			return
+		}
+		var rles dvid.RLEs
+		if err := rles.UnmarshalBinary(chunk.V); err != nil {
 			dvid.Errorf("Error adding RLE for label %d: %s\n", label, err.Error())
 			return
 		}
+		curRLEs[string(blockBytes)] = rles
 		curLabel = label
 		notFirst = true
 	}
 }
 
-func (d *Data) computeAndSaveSurface(ctx storage.Context, vol *dvid.SparseVol) error {
-	surfaceBytes, err := vol.SurfaceSerialization(d.BlockSize().Value(2), d.Resolution.VoxelSize)
+func (d *Data) computeAndSaveSurface(ctx storage.Context, label uint64, rles blockRLEs) error {
+	surfaceBytes, err := d.computeSurface(ctx, label, rles)
+	if err != nil {
+		return err
+	}
+	if err := storeSurface(ctx, label, surfaceBytes); err != nil {
+		return err
+	}
+	if len(rles) < surfaceCheckpointBlocks {
+		return nil
+	}
+	bigdata, err := storage.BigDataStore()
 	if err != nil {
 		return err
 	}
-	store, err := storage.BigDataStore()
+	return deleteSurfaceCheckpoint(ctx, bigdata, label)
+}
+
+// storeSurface stores the serialized surface of a label.
+func storeSurface(ctx storage.Context, label uint64, surfaceBytes []byte) error {
+	blobs, err := storage.BlobDataStore()
 	if err != nil {
 		return err
 	}
@@ -118,20 +143,22 @@ func (d *Data) computeAndSaveSurface(ctx storage.Context, vol *dvid.SparseVol) e
 	if err != nil {
 		return fmt.Errorf("Unable to serialize data in surface computation: %s\n", err.Error())
 	}
-	key := voxels.NewLabelSurfaceIndex(vol.Label())
-	return store.Put(ctx, key, serialization)
+	// Surfaces are kept by content hash so identical surfaces across versions are
+	// stored once.
+	key := voxels.NewLabelSurfaceIndex(label)
+	return blobs.Put(ctx, key, serialization)
 }
 
 // GetSurface returns a gzipped byte array with # voxels and float32 arrays for vertices and
 // normals.
 func GetSurface(ctx storage.Context, label uint64) ([]byte, bool, error) {
-	bigdata, err := storage.BigDataStore()
+	blobs, err := storage.BlobDataStore()
 	if err != nil {
-		return nil, false, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+		return nil, false, fmt.Errorf("Cannot get datastore that handles blobs: %s\n", err.Error())
 	}
 
 	// Retrieve the precomputed surface or that it's not available.
-	data, err := bigdata.Get(ctx, voxels.NewLabelSurfaceIndex(label))
+	data, err := blobs.Get(ctx, voxels.NewLabelSurfaceIndex(label))
 	if err != nil {
 		return nil, false, fmt.Errorf("Error in retrieving surface for label %d: %s", label, err.Error())
 	}
@@ -222,12 +249,22 @@ func getLabelRLEs(ctx *datastore.VersionedContext, label uint64) (blockRLEs, err
 //        int32   Length of run
 //        bytes   Optional payload dependent on first byte descriptor
 //
-func GetSparseVol(ctx storage.Context, label uint64) ([]byte, error) {
-	smalldata, err := storage.SmallDataStore()
-	if err != nil {
-		return nil, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+// If bounds are given, only runs within the bounds are returned and runs are clipped
+// to the bounds.
+func GetSparseVol(ctx storage.Context, label uint64, bounds *Bounds) ([]byte, error) {
+	op := newSparseOp(ctx)
+	begZYX, _ := bounds.BlockRange()
+	if _, err := encodeSparseVol(ctx, label, bounds, op, begZYX.Bytes(), -1); err != nil {
+		return nil, err
 	}
+	binary.LittleEndian.PutUint32(op.encoding[8:12], op.numRuns)
 
+	dvid.Debugf("[%s] label %d: found %d blocks, %d runs\n", ctx, label, op.numBlocks, op.numRuns)
+	return op.encoding, nil
+}
+
+// newSparseOp returns a sparse volume operation with the header of its encoding.
+func newSparseOp(ctx storage.Context) *sparseOp {
 	// Create the sparse volume header
 	buf := new(bytes.Buffer)
 	buf.WriteByte(dvid.EncodingBinary)
@@ -236,28 +273,175 @@ func GetSparseVol(ctx storage.Context, label uint64) ([]byte, error) {
 	buf.WriteByte(byte(0))                            // reserved for later
 	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # voxels
 	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # spans
+	return &sparseOp{versionID: ctx.VersionID(), encoding: buf.Bytes()}
+}
+
+// encodeSparseVol appends the runs of a label within the bounds, starting at the given
+// block, to the encoding of a sparse volume operation.  Once a block's runs could make
+// the encoding exceed maxBytes, the runs of it and later blocks are only counted, and
+// that first block is returned.  A negative maxBytes has no limit.
+func encodeSparseVol(ctx storage.Context, label uint64, bounds *Bounds, op *sparseOp, begBlock []byte, maxBytes int) ([]byte, error) {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
 
 	// Get the start/end indices for this body's KeyLabelSpatialMap (b + s) keys.
-	begIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MinIndexZYX.Bytes())
-	endIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MaxIndexZYX.Bytes())
+	_, endZYX := bounds.BlockRange()
+	begIndex := voxels.NewLabelSpatialMapIndex(label, begBlock)
+	endIndex := voxels.NewLabelSpatialMapIndex(label, endZYX.Bytes())
 
 	// Process all the b+s keys and their values, which contain RLE runs for that label.
-	op := &sparseOp{versionID: ctx.VersionID(), encoding: buf.Bytes()}
+	var resume []byte
 	chunkOp := &storage.ChunkOp{op, nil}
 	err = smalldata.ProcessRange(ctx, begIndex, endIndex, chunkOp, func(chunk *storage.Chunk) {
 		op := chunk.Op.(*sparseOp)
-		op.numBlocks++
-		op.encoding = append(op.encoding, chunk.V...)
-		op.numRuns += uint32(len(chunk.V) / 16)
+		if bounds != nil {
+			indexZYX, err := decodeLabelBlock(chunk.K)
+			if err != nil {
+				dvid.Errorf("Error retrieving RLE runs for label %d: %s\n", label, err.Error())
+				return
+			}
+			if !bounds.BlockWithin(dvid.ChunkPoint3d(indexZYX)) {
+				return
+			}
+		}
+		if resume == nil && maxBytes >= 0 && len(op.encoding)+len(chunk.V) > maxBytes {
+			_, blockBytes, err := voxels.DecodeLabelSpatialMapKey(chunk.K)
+			if err != nil {
+				dvid.Errorf("Error retrieving RLE runs for label %d: %s\n", label, err.Error())
+				return
+			}
+			resume = append([]byte(nil), blockBytes...)
+		}
+		var numRuns uint32
+		if resume == nil {
+			op.encoding, numRuns = bounds.clipRuns(op.encoding, chunk.V)
+		} else {
+			_, numRuns = bounds.clipRuns(nil, chunk.V)
+		}
+		if numRuns != 0 {
+			op.numBlocks++
+			op.numRuns += numRuns
+		}
 	})
 	if err != nil {
 		return nil, err
 	}
+	return resume, nil
+}
 
+// WriteSparseVol writes the encoded sparse volume of a label, as returned by GetSparseVol,
+// to a response.  Encodings that fit within the server's stream buffer are built in memory.
+// Larger ones are streamed so a slow client pauses storage reads instead of the encoding
+// being buffered: the runs of blocks past the buffer are first counted to complete the
+// header, and then those blocks are read again as the client consumes the response.  Only
+// uncompressed and gzip responses are streamed since other compressions aren't framed.
+// Once streaming has begun, errors are logged rather than returned since the response
+// can no longer be replaced by an error.
+func WriteSparseVol(ctx *datastore.VersionedContext, label uint64, bounds *Bounds, w http.ResponseWriter, r *http.Request) error {
+	compress, found, err := dvid.NegotiateCompression(r, server.StreamBufferSize)
+	if err != nil {
+		return err
+	}
+	if found && compress.Format() != dvid.Gzip {
+		data, err := GetSparseVol(ctx, label, bounds)
+		if err != nil {
+			return err
+		}
+		return dvid.WriteCompressed(data, w, r)
+	}
+
+	op := newSparseOp(ctx)
+	begZYX, _ := bounds.BlockRange()
+	resume, err := encodeSparseVol(ctx, label, bounds, op, begZYX.Bytes(), server.StreamBufferSize)
+	if err != nil {
+		return err
+	}
 	binary.LittleEndian.PutUint32(op.encoding[8:12], op.numRuns)
+	if resume == nil {
+		return dvid.WriteCompressed(op.encoding, w, r)
+	}
 
-	dvid.Debugf("[%s] label %d: found %d blocks, %d runs\n", ctx, label, op.numBlocks, op.numRuns)
-	return op.encoding, nil
+	// Stop reading storage if the client stalls or goes away.
+	stop := make(chan struct{})
+	done := ctx.Done()
+	merged := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-stop:
+		}
+		close(merged)
+	}()
+	ctx.SetDone(merged)
+	defer func() {
+		close(stop)
+		ctx.SetDone(done)
+	}()
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	stream := server.NewStreamWriter(w, r)
+	var out io.Writer = stream
+	var gz *gzip.Writer
+	if found {
+		if gz, err = gzip.NewWriterLevel(stream, int(compress.Level())); err != nil {
+			stream.Close()
+			return err
+		}
+		out = gz
+		w.Header().Set("X-Dvid-Compression", compress.Format().Name())
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	dvid.Debugf("[%s] streaming label %d: %d blocks, %d runs\n", ctx, label, op.numBlocks, op.numRuns)
+
+	_, writeErr := out.Write(op.encoding)
+	if writeErr == nil {
+		writeErr = streamSparseVol(ctx, label, bounds, resume, out)
+	}
+	if writeErr == nil && gz != nil {
+		writeErr = gz.Close()
+	}
+	if err := stream.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		dvid.Errorf("Stopped streaming sparse volume for label %d to %s: %s\n", label, r.RemoteAddr, writeErr.Error())
+	}
+	return nil
+}
+
+// streamSparseVol writes the runs of a label within the bounds, starting at the given
+// block, as each block is read.  The scan stops at the first write error.
+func streamSparseVol(ctx *datastore.VersionedContext, label uint64, bounds *Bounds, begBlock []byte, w io.Writer) error {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	_, endZYX := bounds.BlockRange()
+	begIndex := voxels.NewLabelSpatialMapIndex(label, begBlock)
+	endIndex := voxels.NewLabelSpatialMapIndex(label, endZYX.Bytes())
+
+	var writeErr error
+	var runs []byte
+	err = smalldata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if writeErr != nil {
+			return
+		}
+		if bounds != nil {
+			indexZYX, err := decodeLabelBlock(chunk.K)
+			if err != nil || !bounds.BlockWithin(dvid.ChunkPoint3d(indexZYX)) {
+				return
+			}
+		}
+		if runs, _ = bounds.clipRuns(runs[:0], chunk.V); len(runs) != 0 {
+			_, writeErr = w.Write(runs)
+		}
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
 }
 
 // PutSparseVol stores an encoded sparse volume that stays within a given forward label.
@@ -287,6 +471,19 @@ func PutSparseVol(ctx storage.Context, label uint64, data []byte) error {
 	return nil
 }
 
+// decodeLabelBlock returns the block coordinate of a KeyLabelSpatialMap key.
+func decodeLabelBlock(key []byte) (dvid.IndexZYX, error) {
+	var indexZYX dvid.IndexZYX
+	_, blockBytes, err := voxels.DecodeLabelSpatialMapKey(key)
+	if err != nil {
+		return indexZYX, err
+	}
+	if err := indexZYX.IndexFromBytes(blockBytes); err != nil {
+		return indexZYX, fmt.Errorf("Error decoding block coordinate (%v): %s", blockBytes, err.Error())
+	}
+	return indexZYX, nil
+}
+
 // GetSparseCoarseVol returns an encoded sparse volume given a label.  The encoding has the
 // following format where integers are little endian:
 // 		byte     Set to 0
@@ -301,7 +498,8 @@ func PutSparseVol(ctx storage.Context, label uint64, data []byte) error {
 //     		int32   Block coordinate of run start (dimension 2)
 //     		int32   Length of run
 //
-func GetSparseCoarseVol(ctx storage.Context, label uint64) ([]byte, error) {
+// If bounds are given, only blocks intersecting the bounds are returned.
+func GetSparseCoarseVol(ctx storage.Context, label uint64, bounds *Bounds) ([]byte, error) {
 	smalldata, err := storage.SmallDataStore()
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
@@ -317,26 +515,25 @@ func GetSparseCoarseVol(ctx storage.Context, label uint64) ([]byte, error) {
 	encoding := buf.Bytes()
 
 	// Get the start/end indices for this body's KeyLabelSpatialMap (b + s) keys.
-	begIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MinIndexZYX.Bytes())
-	endIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MaxIndexZYX.Bytes())
+	begZYX, endZYX := bounds.BlockRange()
+	begIndex := voxels.NewLabelSpatialMapIndex(label, begZYX.Bytes())
+	endIndex := voxels.NewLabelSpatialMapIndex(label, endZYX.Bytes())
 
 	// Process all the b+s keys and their values, which contain RLE runs for that label.
 	var numBlocks uint32
 	var span *dvid.Span
 	var spans dvid.Spans
 	err = smalldata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
-		numBlocks++
-		_, blockBytes, err := voxels.DecodeLabelSpatialMapKey(chunk.K)
+		indexZYX, err := decodeLabelBlock(chunk.K)
 		if err != nil {
-			dvid.Errorf("Error retrieving RLE runs for label %d: %s\n", label, err.Error())
+			dvid.Errorf("Error decoding block coordinate for coarse sparse volume of label %d: %s\n",
+				label, err.Error())
 			return
 		}
-		var indexZYX dvid.IndexZYX
-		if err := indexZYX.IndexFromBytes(blockBytes); err != nil {
-			dvid.Errorf("Error decoding block coordinate (%v) for coarse sparse volume: %s\n",
-				blockBytes, err.Error())
+		if !bounds.BlockWithin(dvid.ChunkPoint3d(indexZYX)) {
 			return
 		}
+		numBlocks++
 		x, y, z := indexZYX.Unpack()
 		if span == nil {
 			span = &dvid.Span{z, y, x, x}
@@ -12,6 +12,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
+	"image/color"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -66,10 +68,20 @@ $ dvid repo <UUID> new labels64 <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     LabelType      "standard" (default) or "raveler" 
+    SurfaceRegen   "true" (default) or "false".  If true, label surfaces are regenerated in
+                   background workers after merges, splits, and label ingestion.
     Versioned      "true" or "false" (default)
+    Encrypted      "true" or "false" (default).  Encrypts stored values if the server
+                     configures encryption at rest.
+    Durability     "sync", "periodic", or "async" durability of writes that don't request
+                     one via the "durability" query string.  Default is the server's.
     BlockSize      Size in pixels  (default: %s)
     VoxelSize      Resolution of voxels (default: 8.0, 8.0, 8.0)
     VoxelUnits     Resolution units (default: "nanometers")
+    DeferWrites    "true" or "false" (default).  If true, PUTs return once their blocks are
+                     queued in memory and are written in batches later.  Queued blocks are
+                     lost if the server crashes, and GETs and label indexing don't see them
+                     until written.  See the "flush" endpoint.
 
 $ dvid node <UUID> <data name> load <offset> <image glob> <settings...>
 
@@ -101,8 +113,8 @@ $ dvid node <UUID> <data name> load <offset> <image glob> <settings...>
 
 $ dvid node <UUID> <data name> composite <grayscale8 data name> <new rgba8 data name>
 
-    Creates a RGBA8 image where the RGB is a hash of the labels and the A is the
-    grayscale intensity.
+    Creates a RGBA8 image where the RGB is the color of the labels (see the "colors"
+    endpoint) scaled by the grayscale intensity.
 
     Example: 
 
@@ -112,6 +124,80 @@ $ dvid node <UUID> <data name> composite <grayscale8 data name> <new rgba8 data
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of data to add.
+
+$ dvid node <UUID> <data name> adjacency <labelgraph data name>
+
+    Launches a job that scans all label blocks and builds a label adjacency graph in
+    the given labelgraph data, which is created if necessary.  Each vertex is a label
+    with weight equal to its voxel count, and each edge joins two touching labels with
+    weight equal to their contact area in voxel faces.  Any previous graph in that
+    version of the labelgraph data is replaced.  The graph can then be queried via the
+    labelgraph HTTP API.
+
+    Example: 
+
+    $ dvid node 3f8c bodies adjacency bodygraph
+
+    Arguments:
+
+    UUID                  Hexidecimal string with enough characters to uniquely identify a version node.
+    data name             Name of labels64 data.
+    labelgraph data name  Name of labelgraph data that will hold the adjacency graph.
+
+$ dvid node <UUID> <data name> pyramid <levels>
+
+    Computes and stores downsampled versions of the labels at scale levels 1 through
+    <levels>, where each level halves the resolution of the level below it.  Each
+    downsampled voxel gets the most frequent label of its 2x2x2 voxel neighborhood.
+    Scale levels are not updated when labels are modified so this command should be
+    rerun after changes.
+
+    Example: 
+
+    $ dvid node 3f8c bodies pyramid 4
+
+$ dvid node <UUID> <data name> preview <preview data name> <scale>
+
+    Creates a labels64 instance holding these labels downsampled by 2^scale and
+    launches a job that fills it.  Each preview voxel gets the label at the center of
+    the corresponding voxel neighborhood.  Afterwards, the preview is updated in the
+    background whenever labels are modified, so overview renderings and coarse analyses
+    can read the small preview instead of full resolution blocks.  Labels written
+    directly into the preview are overwritten by later updates.
+
+    Example: 
+
+    $ dvid node 3f8c bodies preview bodies-preview 3
+
+    Arguments:
+
+    UUID               Hexidecimal string with enough characters to uniquely identify a version node.
+    data name          Name of labels64 data.
+    preview data name  Name of the new labels64 data that will hold the preview.
+    scale              Downsampling level from 1 to 10.  The block size must be divisible
+                         by 2^scale.
+
+$ dvid node <UUID> <data name> meshes <levels of detail> <settings...>
+
+    Launches a job that generates and stores triangle meshes of labels at the given
+    comma-separated levels of detail.  At level of detail l, each mesh vertex lies on a
+    grid of 2^l voxels, so higher levels give coarser meshes with fewer triangles.
+    Stored meshes are deleted when their labels are merged or split.
+
+    Example: 
+
+    $ dvid node 3f8c bodies meshes 0,2,4 minsize=10000
+
+    Arguments:
+
+    UUID               Hexidecimal string with enough characters to uniquely identify a version node.
+    data name          Name of labels64 data.
+    levels of detail   Comma-separated levels of detail from 0 to 5.
+
+    Configuration Settings (case-insensitive keys)
+
+    labels             Comma-separated labels to mesh.  By default, all labels are meshed.
+    minsize            Only mesh labels with at least this many voxels if labels aren't given.
 	
 	
     ------------------
@@ -141,6 +227,14 @@ POST <api URL>/node/<UUID>/<data name>/info
     data name     Name of voxels data.
 
 
+GET  <api URL>/node/<UUID>/<data name>/flush
+POST <api URL>/node/<UUID>/<data name>/flush
+
+    Supports data with the "DeferWrites" setting.  A GET returns JSON with the number of
+    blocks queued for writing, and a POST writes all queued blocks before returning JSON
+    with the number of blocks written, e.g., after an ingestion completes.
+
+
 GET  <api URL>/node/<UUID>/<data name>/metadata
 
 	Retrieves a JSON schema (application/vnd.dvid-nd-data+json) that describes the layout
@@ -166,7 +260,13 @@ POST <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?th
     Throttling can be enabled by passing a "throttle=on" query string.  Throttling makes sure
     only one compute-intense operation (all API calls that can be throttled) is handled.
     If the server can't initiate the API call right away, a 503 (Service Unavailable) status
-    code is returned.
+    code is returned.  Whether throttled or not, 3d requests count as heavy operations
+    against any quota of the client, and a 429 (Too Many Requests) status code with a
+    Retry-After header is returned if the client is already running its maximum.
+
+    Large 3d subvolumes that are sent uncompressed or gzip compressed are streamed as
+    layers of blocks along z are read, e.g., via chunked transfer encoding, so responses
+    have no Content-Length and errors after streaming begins truncate the response.
 
     Arguments:
 
@@ -180,10 +280,99 @@ POST <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>][?th
     Query-string Options:
 
     roi       	  Name of roi data instance used to mask the requested data.
+    scale         GET only.  Scale level computed by the "pyramid" command, where the labels
+                    are downsampled by 2^scale.  Size and offset are given in voxels of that
+                    scale.  Default is 0, the original resolution.  Cannot be used with roi.
+    margin        GET of 3d subvolumes only.  Number of context voxels to add around the
+                    requested subvolume, either one value or values for each dimension
+                    like "16_16_4".  The extended subvolume is clamped to the labels extents
+                    but never smaller than requested.  Its offset and size are returned in
+                    the X-Dvid-Offset and X-Dvid-Size headers in "x_y_z" format.
+    compression   GET of nD data only.  Compresses the returned labels independent of how
+                    they are stored: "none" (default), "snappy", "lz4", "gzip", or "gzip-N"
+                    where N is a level from 1 (fastest) to 9 (smallest).  The compression
+                    used is returned in the X-Dvid-Compression header.
+    edit          POST only.  Name of an editing session.  The prior labels of modified
+                    blocks are kept so the POST can be reverted via the "undo" endpoint.
+    color         GET of 2d images only.  If "true", returns an RGBA image with each label
+                    drawn in its color (see the "colors" endpoint) and label 0 transparent.
+
+GET  <api URL>/node/<UUID>/<data name>/colors[?labels=1,2,3]
+POST <api URL>/node/<UUID>/<data name>/colors
+
+    Labels are rendered by the server, e.g., by the "color" option of 2d images and the
+    "composite" command, in colors computed from a hash of each label unless a color has
+    been assigned to the label.  Viewers can get colors here to render labels consistently.
+
+    A GET returns a JSON object mapping labels to colors as "#rrggbb" or, if not opaque,
+    "#rrggbbaa" strings.  If a comma-separated list of labels is given, the color of each
+    is returned; otherwise only the assigned colors are returned.
+
+    A POST assigns colors given a JSON object of the same form.  An empty string removes
+    the label's assigned color.  Assigned colors are inherited by descendant versions.
+
+    Example: 
+
+    POST <api URL>/node/3f8c/bodies/colors
+
+    { "23": "#ff0000", "42": "#00ff0080", "1001": "" }
+
+GET  <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+POST <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+
+    A GET returns JSON listing the mutations of the given editing session that can be
+    undone, most recent first.  A POST reverts the most recent of those mutations,
+    recomputes label indices and sizes for the restored blocks, and returns JSON
+    describing the reverted mutation.  See 'voxels' API for the limits on undo.
+
+    Example: 
+
+    POST <api URL>/node/3f8c/bodies/undo?edit=proofreader-7
+
+    Returns:
+
+    { "Seq": 3, "Time": "2015-03-02T10:14:31.553-05:00", "Blocks": 4 }
+
+GET  <api URL>/node/<UUID>/<data name>/renumbered/<dims>/<size>/<offset>[?bytes=2]
+
+    Retrieves a 3d subvolume of labels renumbered to dense sequential labels, which many
+    analysis tools require, along with the table mapping them back to the original labels.
+    The distinct non-zero labels are sorted and the i-th label, starting from 1, becomes
+    label i.  Label 0 is kept as background.  The encoding has the following format where
+    integers are little endian:
+
+        uint32    Number of labels N, excluding label 0
+        uint8     Bytes per voxel of the renumbered labels: 1, 2, 4, or 8
+        byte x 3  Reserved
+        Repeating N times:
+            uint64    Original label of renumbered label 1, 2, ..., N
+        Renumbered labels packed with the given bytes per voxel, in the same order as
+            voxels returned by "raw" requests.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/superpixels/renumbered/0_1_2/128_128_128/0_0_100
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labels64 data.
+    dims          The axes of data extraction, which must be 3d, e.g., "0_1_2".
+    size          Size in voxels along each dimension specified in <dims>.
+    offset        Gives coordinate of first voxel using dimensionality of data.
+
+    Query-string Options:
+
+    bytes         Bytes per voxel of the renumbered labels: 1, 2, 4, or 8.  By default, the
+                    fewest bytes that can hold all renumbered labels are used.  It is an
+                    error if the given bytes can't hold all labels.
+    roi           Name of roi data instance used to mask the requested data.
+    scale         Scale level computed by the "pyramid" command, as described for "raw".
+    compression   Compression of the returned data, as described for "raw" requests.
 
 (Assumes labels were loaded using without "proc=noindex")
 
-GET <api URL>/node/<UUID>/<data name>/sparsevol/<label>
+GET <api URL>/node/<UUID>/<data name>/sparsevol/<label>[?minx=0&maxx=1023&...]
 
 	Returns a sparse volume with voxels of the given label in encoded RLE format.
 	The encoding has the following format where integers are little endian and the order
@@ -207,11 +396,24 @@ GET <api URL>/node/<UUID>/<data name>/sparsevol/<label>
 	        int32   Length of run
 	        bytes   Optional payload dependent on first byte descriptor
 
+    Query-string Options:
+
+    minx, maxx    Inclusive voxel bounds along x.  Only runs within all given bounds are
+    miny, maxy      returned and runs are clipped to the bounds.  Any bound can be omitted.
+    minz, maxz
+    compression   Compression of the returned data, as described for "raw" requests.
 
-GET <api URL>/node/<UUID>/<data name>/sparsevol-by-point/<coord>
+	Sparse volumes larger than the server's per-connection stream buffer are streamed,
+	and storage reads pause while a slow client catches up.  The buffer size and how long
+	a client may accept no data are set by "buffer_kb" and "stall_secs" in the
+	[server.stream] section of the configuration.  Only uncompressed and gzip responses
+	are streamed.
+
+
+GET <api URL>/node/<UUID>/<data name>/sparsevol-by-point/<coord>[?minx=0&maxx=1023&...]
 
 	Returns a sparse volume with voxels that pass through a given voxel.
-	The encoding is described in the "sparsevol" request above.
+	The encoding and bounding box options are described in the "sparsevol" request above.
 	
     Arguments:
 
@@ -220,7 +422,31 @@ GET <api URL>/node/<UUID>/<data name>/sparsevol-by-point/<coord>
     coord     	  Coordinate of voxel with underscore as separator, e.g., 10_20_30
 
 
-GET <api URL>/node/<UUID>/<data name>/sparsevol-coarse/<label>
+GET <api URL>/node/<UUID>/<data name>/sparsevol-diff/<label>/<from UUID>
+
+	Returns the voxels of a label added and removed going from the version of the "from"
+	UUID to the version of this request's UUID, e.g., to review a proofreading session.
+	Both versions must be in the same repo.  The response has the following format where
+	integers are little endian:
+
+	    uint32   # bytes of added voxels encoding
+	    bytes    Added voxels in the sparse volume encoding described in "sparsevol"
+	    uint32   # bytes of removed voxels encoding
+	    bytes    Removed voxels in the sparse volume encoding
+
+	Unlike "sparsevol", the # voxels field of each encoding is set.  Runs are ordered by
+	block and within a block by z, y, then x.  The "Dvid-Voxels-Added" and
+	"Dvid-Voxels-Removed" response headers give the number of voxels of each.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of mapping data.
+    label         A 64-bit integer label id
+    from UUID     Hexidecimal string identifying the version to compare against.
+
+
+GET <api URL>/node/<UUID>/<data name>/sparsevol-coarse/<label>[?minx=0&maxx=1023&...]
 
 	Returns a sparse volume with blocks of the given label in encoded RLE format.
 	The encoding has the following format where integers are little endian and the order
@@ -242,6 +468,13 @@ GET <api URL>/node/<UUID>/<data name>/sparsevol-coarse/<label>
 	Note that the above format is the RLE encoding of sparsevol, where voxel coordinates
 	have been replaced by block coordinates.
 
+    Query-string Options:
+
+    minx, maxx    Inclusive voxel bounds along x.  Only blocks that intersect all given
+    miny, maxy      bounds are returned.  Any bound can be omitted.
+    minz, maxz
+    compression   Compression of the returned data, as described for "raw" requests.
+
 GET <api URL>/node/<UUID>/<data name>/surface/<label>
 
 	Returns array of vertices and normals of surface voxels of given label.
@@ -252,6 +485,43 @@ GET <api URL>/node/<UUID>/<data name>/surface/<label>
 	    N x float32     Vertices where N = 3 * (# Voxels)
 	    N x float32     Normals where N = 3 * (# Voxels)
 
+	Surfaces are sent gzip compressed if the client accepts gzip encoding.  A "compression"
+	query string, as described for "raw" requests, overrides the returned compression.
+
+
+GET <api URL>/node/<UUID>/<data name>/mesh/<label>[?lod=0]
+
+	Returns a triangle mesh of the given label in the Neuroglancer legacy precomputed mesh
+	format with vertices in physical coordinates:
+
+	    uint32          # Vertices
+	    N x float32     Vertices where N = 3 * (# Vertices)
+	    M x uint32      Vertex indices of triangles where M = 3 * (# Triangles)
+
+	If the mesh hasn't been stored by a "meshes" command, it is generated and stored.
+	Meshes are boundaries of the label with faces on voxel edges, merged into larger
+	rectangles where possible.
+
+    Query-string Options:
+
+    lod           Level of detail from 0 (full resolution) to 5.  At level of detail l the
+                    label is downsampled by 2^l before meshing.
+    format        "ngmesh" (default) for the legacy precomputed format.  Draco encoding is
+                    not supported.
+
+
+GET <api URL>/node/<UUID>/<data name>/surface-regen
+
+	Returns JSON giving the status of background surface regeneration for this data:
+
+	{ "Enabled": true, "PendingLabels": 3, "PendingBlocks": 40, "Active": 2,
+	  "Incremental": 120, "Full": 8, "Failed": 0 }
+
+	After label changes, only surface voxels near changed blocks are recomputed unless
+	a large fraction of a label's blocks have changed.  Surfaces for pending or active
+	labels may be stale.  Regeneration can be turned off by posting the configuration
+	{"SurfaceRegen": "false"} to the data instance.
+
 
 GET <api URL>/node/<UUID>/<data name>/surface-by-point/<coord>
 
@@ -265,6 +535,61 @@ GET <api URL>/node/<UUID>/<data name>/surface-by-point/<coord>
     coord     	  Coordinate of voxel with underscore as separator, e.g., 10_20_30
 
 
+GET <api URL>/node/<UUID>/<data name>/precomputed/info
+GET <api URL>/node/<UUID>/<data name>/precomputed/<scale key>/<chunk name>
+GET <api URL>/node/<UUID>/<data name>/precomputed/mesh/<label>:0
+GET <api URL>/node/<UUID>/<data name>/precomputed/mesh/<label>.surface
+
+    Serves the labels as a Neuroglancer "precomputed" segmentation so Neuroglancer can
+    browse a version directly using the source URL:
+
+    precomputed://http://<server>/api/node/<UUID>/<data name>/precomputed
+
+    The info and chunk endpoints are described in the voxels help.  Label meshes are
+    derived from the stored label surfaces: "<label>:0" returns JSON listing the label's
+    single mesh fragment, and "<label>.surface" returns that fragment in the legacy
+    precomputed mesh format with each surface voxel drawn as a voxel-sized square facing
+    along its normal.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labels64 data.
+    scale key     Scale key from the info JSON, e.g., "s0".
+    chunk name    Chunk bounds in "<xBegin>-<xEnd>_<yBegin>-<yEnd>_<zBegin>-<zEnd>" format.
+    label         Label whose mesh is returned.
+
+
+GET <api URL>/node/<UUID>/<data name>/partition?n=<number of chunks>[&by=bytes][&roi=<roi name>]
+
+    Returns JSON describing at most n non-overlapping, block-aligned chunks that together
+    contain all stored blocks, each chunk having about the same number of blocks or stored
+    bytes.  Chunks are found by recursively bisecting the stored blocks, so external
+    schedulers can plan parallel jobs.  Fewer chunks are returned if there are fewer
+    stored blocks than n.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/segmentation/partition?n=2&by=bytes
+
+    { "By": "bytes", "NumBlocks": 1000, "Weight": 3541822, "NumChunks": 2,
+      "Chunks": [
+        { "MinPoint": [0,0,0], "MaxPoint": [319,639,511],
+          "MinChunk": [0,0,0], "MaxChunk": [9,19,15],
+          "NumBlocks": 500, "Weight": 1772101 }, ...
+      ]
+    }
+
+    The Min/MaxPoint are voxel coordinates and Min/MaxChunk are block coordinates.
+
+    Query-string Options:
+
+    n             Number of chunks.
+    by            "blocks" (default) balances the number of stored blocks per chunk.
+                    "bytes" balances the stored bytes per chunk.
+    roi           Name of roi data instance used to restrict blocks.
+
+
 GET <api URL>/node/<UUID>/<data name>/sizerange/<min size>/<optional max size>
 
     Returns JSON list of labels that have # voxels that fall within the given range
@@ -278,6 +603,147 @@ GET <api URL>/node/<UUID>/<data name>/sizerange/<min size>/<optional max size>
     max size      Optional maximum # of voxels.  If not specified, all labels with volume above minimum
                    are returned.
 
+GET <api URL>/node/<UUID>/<data name>/labelsizes[?queryopts]
+
+    Returns JSON for a page of labels in ascending order of size, i.e., # voxels:
+
+    { "Labels": [{"Label": 23, "Size": 1082}, {"Label": 8, "Size": 1090}], "Next": "1090_8" }
+
+    If "Next" is present, there may be more labels and its value can be passed as the
+    "after" query option to get the following page.
+	
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of mapping data.
+
+    Query-string Options:
+
+    min           Minimum # of voxels.  Default is 0.
+    max           Maximum # of voxels.  If not specified, there is no maximum.
+    limit         Maximum # of labels to return.  If not specified, all labels are returned.
+    after         Cursor from a previous page's "Next".
+
+GET <api URL>/node/<UUID>/<data name>/largest/<n>[?after=<cursor>]
+
+    Returns JSON for the n largest labels in descending order of size, in the same format
+    as the "labelsizes" endpoint.  Pass the returned "Next" as "after" to get the next
+    n largest labels.
+	
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of mapping data.
+    n             Number of labels to return.
+
+GET <api URL>/node/<UUID>/<data name>/labelsize/<label>
+
+    Returns JSON with the exact # of voxels of a label at the given version, computed
+    from the label's sparse volume:
+
+    { "Label": 23, "Size": 1082 }
+	
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of mapping data.
+    label         A 64-bit integer label id
+
+GET <api URL>/node/<UUID>/<data name>/contacts/<label1>/<label2>
+
+    Returns JSON describing the contact interface between two labels, i.e., all voxels
+    of each label that share a face with a voxel of the other label.  "Area" is the
+    number of shared voxel faces.
+
+    Example:
+
+    GET <api URL>/node/3f8c/bodies/contacts/23/1082
+
+    Returns:
+
+    { "Label1": 23, "Label2": 1082, "Area": 3,
+      "Sites1": [[10,20,30], [11,20,30]], "Sites2": [[10,21,30], [11,21,30]] }
+
+    Sites are voxel coordinates sorted in ZYX order.
+	
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labels64 data.
+    label1        First label.
+    label2        Second label.
+
+    Query-string Options:
+
+    budget_ms     Time budget in milliseconds.  If exceeded, the contacts found so far are
+                    returned with a "Dvid-Partial: true" header and a "Dvid-Continuation"
+                    header giving a token.
+    continue      Token from a partial result's "Dvid-Continuation" header.  Resumes the
+                    computation and returns more complete contacts.
+
+GET <api URL>/node/<UUID>/<data name>/mask/<label>/<size>/<offset>[?value=1]
+
+    Returns a binary mask of a label within a 3d subvolume, with 1 byte per voxel that
+    is the mask value where voxels have the label and 0 elsewhere.
+
+    Example:
+
+    GET <api URL>/node/3f8c/bodies/mask/23/128_128_128/1000_2000_3000?value=255
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labels64 data.
+    label         Label to mask.
+    size          Size in voxels in "x_y_z" format.
+    offset        Gives coordinate of first voxel in "x_y_z" format.
+
+    Query-string Options:
+
+    value         Mask value from 1 to 255.  Default 1.
+    compression   Compression of the returned data, as described for "raw" requests.
+
+POST <api URL>/node/<UUID>/<data name>/rasterize/<label>?mask=<mask name>[&value=1]
+
+    Rasterizes a label's sparse volume into a mask data instance at the same version.
+    If the mask is voxels data with 1 byte per voxel, e.g., grayscale8, voxels of the
+    label are set to the mask value and other voxels are unchanged.  If the mask is an
+    ROI, all ROI blocks holding voxels of the label are added to it.  Returns JSON
+    with the number of voxels rasterized:
+
+    { "Label": 23, "Mask": "body23", "Voxels": 1837261, "Blocks": 412 }
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labels64 data.
+    label         Label to rasterize.
+
+    Query-string Options:
+
+    mask          Name of the mask data instance.  Required.
+    value         Mask value from 1 to 255 for voxels masks.  Default 1.
+
+POST <api URL>/node/<UUID>/<data name>/from-mask/<label>/<size>/<offset>[?edit=<session>]
+
+    Sets voxels of a 3d subvolume to the label where a posted binary mask is non-zero,
+    leaving other voxels unchanged.  The request body holds the mask with 1 byte per
+    voxel in the same order as "raw" subvolumes.  Label indices are updated as for
+    posted label volumes.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labels64 data.
+    label         Label written where the mask is non-zero.
+    size          Size in voxels in "x_y_z" format.
+    offset        Gives coordinate of first voxel in "x_y_z" format.
+
+    Query-string Options:
+
+    edit          Name of an editing session so the change can be undone, as described
+                    for "raw" POSTs.
+
 POST <api URL>/node/<UUID>/<data name>/merge
 
 	Merges labels.  Requires JSON in request body using the following format:
@@ -287,15 +753,31 @@ POST <api URL>/node/<UUID>/<data name>/merge
 	  ... ]
 
 	Each element of the JSON array is another array specifying all the labels that
-	should be merged into the label specified by the first element.
+	should be merged into the label specified by the first element.  Relabeled blocks,
+	block-level RLEs, and label sizes are updated together before returning the following
+	JSON:
 
+		{ "labels": [toLabel1, toLabel2, ...] }
 
-POST <api URL>/node/<UUID>/<data name>/split
+	If the big and small data tiers are separate stores, the relabeled blocks are written
+	first.  Should the RLEs and sizes then fail to be written, an error lists the labels
+	whose RLEs and sizes are stale, and the merge can be repeated to update them.
 
-	Splits a portion of a label's voxels into a new label.  Returns the following JSON:
+
+POST <api URL>/node/<UUID>/<data name>/split/<label>
+
+	Splits a portion of a label's voxels into a new label.  Voxels of the given label
+	within the POSTed sparse volume are relabeled, and block-level RLEs and label sizes
+	of both labels are updated together with the blocks before returning the following
+	JSON:
 
 		{ "label": <new label> }
 
+	As with merges, separate big and small data stores can leave the blocks written but
+	the RLEs and sizes stale, which is reported in an error listing both labels.
+
+	The new label is larger than any label previously stored or allocated.
+
 	This request requires a binary sparse volume in the POSTed body with the following 
 	encoded RLE format, which is compatible with the format returned by a GET on the 
 	"sparsevol" endpoint described above:
@@ -410,10 +892,17 @@ func NewData(uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Co
 			return nil, fmt.Errorf("unknown label type specified '%s'", s)
 		}
 	}
+	surfaceRegen := true
+	if regen, found, err := c.GetBool("SurfaceRegen"); err != nil {
+		return nil, err
+	} else if found {
+		surfaceRegen = regen
+	}
 	dvid.Infof("Creating labels64 '%s' with %s", voxelData.DataName(), labelType)
 	data := &Data{
-		Data:     voxelData,
-		Labeling: labelType,
+		Data:         voxelData,
+		Labeling:     labelType,
+		SurfaceRegen: surfaceRegen,
 	}
 	return data, nil
 }
@@ -491,12 +980,24 @@ type Data struct {
 	*voxels.Data
 	Labeling LabelType
 	Ready    bool
+
+	// SurfaceRegen is true if label surfaces are regenerated in the background after
+	// label blocks change.
+	SurfaceRegen bool
+
+	// Preview is the name of a labels64 instance, if any, that mirrors these labels
+	// downsampled by 2^PreviewScale and is updated after label blocks change.
+	Preview      dvid.DataString
+	PreviewScale uint8
 }
 
 type propertiesT struct {
 	voxels.Properties
-	Labeling LabelType
-	Ready    bool
+	Labeling     LabelType
+	Ready        bool
+	SurfaceRegen bool
+	Preview      dvid.DataString
+	PreviewScale uint8
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
@@ -509,6 +1010,9 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 			d.Data.Properties,
 			d.Labeling,
 			d.Ready,
+			d.SurfaceRegen,
+			d.Preview,
+			d.PreviewScale,
 		},
 	})
 }
@@ -525,6 +1029,23 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.Ready)); err != nil {
 		return err
 	}
+	// Data stored before surface regeneration was added always regenerates surfaces.
+	if err := dec.Decode(&(d.SurfaceRegen)); err != nil {
+		if err != io.EOF {
+			return err
+		}
+		d.SurfaceRegen = true
+		return nil
+	}
+	if err := dec.Decode(&(d.Preview)); err != nil {
+		if err != io.EOF {
+			return err
+		}
+		return nil
+	}
+	if err := dec.Decode(&(d.PreviewScale)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -540,6 +1061,15 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Ready); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.SurfaceRegen); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.Preview); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(d.PreviewScale); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -756,6 +1286,31 @@ func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
 	return nil
 }
 
+// ModifyConfig modifies the labels64 properties, including whether surfaces are
+// regenerated after label changes, and the underlying voxels properties.
+func (d *Data) ModifyConfig(config dvid.Config) error {
+	regen, found, err := config.GetBool("SurfaceRegen")
+	if err != nil {
+		return err
+	}
+	if found {
+		d.SurfaceRegen = regen
+	}
+	return d.Data.ModifyConfig(config)
+}
+
+// IngestBatch rejects batch operations since label blocks written directly wouldn't
+// be indexed by label.
+func (d *Data) IngestBatch(ctx *datastore.VersionedContext, ops []datastore.BatchOp) error {
+	return fmt.Errorf("labels64 data %q can't ingest blocks in batches; POST label volumes instead", d.DataName())
+}
+
+// PrepareMutation rejects atomic commits of label blocks since they wouldn't be indexed
+// by label.
+func (d *Data) PrepareMutation(versionID dvid.VersionID, action string, args json.RawMessage) (*datastore.PreparedMutation, error) {
+	return nil, fmt.Errorf("labels64 data %q doesn't support atomic commits; POST label volumes instead", d.DataName())
+}
+
 // DoRPC acts as a switchboard for RPC commands.
 func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
 	switch request.TypeCommand() {
@@ -788,6 +1343,11 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 		}
 		dvid.Debugf(addedFiles + "\n")
 
+		filenames, err = voxels.ExpandImageDirs(filenames)
+		if err != nil {
+			return err
+		}
+
 		uuid, versionID, err := datastore.MatchingUUID(uuidStr)
 		if err != nil {
 			return err
@@ -826,6 +1386,30 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 		}
 		return d.CreateComposite(request, reply)
 
+	case "adjacency":
+		if len(request.Command) < 5 {
+			return fmt.Errorf("Poorly formatted adjacency command.  See command-line help.")
+		}
+		return d.ComputeAdjacency(request, reply)
+
+	case "pyramid":
+		if len(request.Command) < 5 {
+			return fmt.Errorf("Poorly formatted pyramid command.  See command-line help.")
+		}
+		return d.ComputePyramid(request, reply)
+
+	case "preview":
+		if len(request.Command) < 6 {
+			return fmt.Errorf("Poorly formatted preview command.  See command-line help.")
+		}
+		return d.CreatePreview(request, reply)
+
+	case "meshes":
+		if len(request.Command) < 5 {
+			return fmt.Errorf("Poorly formatted meshes command.  See command-line help.")
+		}
+		return d.GenerateMeshes(request, reply)
+
 	default:
 		return fmt.Errorf("Unknown command.  Data type '%s' [%s] does not support '%s' command.",
 			d.DataName(), d.TypeName(), request.TypeCommand())
@@ -835,7 +1419,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(ctx)
 
 	// Get repo and version ID of this request
 	repo, versions, err := datastore.FromContext(ctx)
@@ -851,6 +1435,8 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetDone(ctx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(ctx))
 
 	// Get the action (GET, POST)
 	action := strings.ToLower(r.Method)
@@ -928,12 +1514,24 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, string(jsonBytes))
 
+	case "flush":
+		d.ServeFlush(w, r)
+
 	case "raw", "isotropic":
 		if len(parts) < 7 {
 			server.BadRequest(w, r, "'%s' must be followed by shape/size/offset", parts[3])
 			return
 		}
 		var isotropic bool = (parts[3] == "isotropic")
+		scale, err := voxels.ScaleFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if scale != 0 && op == voxels.PutOp {
+			server.BadRequest(w, r, "scale levels are computed by the pyramid command and cannot be POSTed")
+			return
+		}
 		shapeStr, sizeStr, offsetStr := parts[4], parts[5], parts[6]
 		planeStr := dvid.DataShapeString(shapeStr)
 		plane, err := planeStr.DataShape()
@@ -976,6 +1574,7 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 				var opts voxels.OpOptions
 				opts.SetROI(roiptr)
 				opts.SetModsChannel(modsChan)
+				opts.SetEditSession(queryValues.Get("edit"))
 				err = voxels.PutVoxels(storeCtx, d, e, opts)
 				if err != nil {
 					server.BadRequest(w, r, err.Error())
@@ -995,11 +1594,24 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 						return
 					}
 				}
-				img, err := voxels.GetImage(storeCtx, d, e, roiptr)
+				img, err := voxels.GetScaledImage(storeCtx, d, e, roiptr, scale)
 				if err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
 				}
+				if queryValues.Get("color") == "true" {
+					colors, err := d.GetColorMapper(storeCtx)
+					if err != nil {
+						server.BadRequest(w, r, err.Error())
+						return
+					}
+					nx, ny := int(rawSlice.Size().Value(0)), int(rawSlice.Size().Value(1))
+					img, err = colorLabels(colors, e.Data(), d.ByteOrder, nx, ny)
+					if err != nil {
+						server.BadRequest(w, r, err.Error())
+						return
+					}
+				}
 				if isotropic {
 					dstW := int(slice.Size().Value(0))
 					dstH := int(slice.Size().Value(1))
@@ -1022,51 +1634,35 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 			}
 			timedLog.Infof("HTTP %s: %s (%s)", r.Method, plane, r.URL)
 		case 3:
-			queryStrings := r.URL.Query()
-			if queryStrings.Get("throttle") == "on" {
-				select {
-				case <-server.Throttle:
-					// Proceed with operation, returning throttle token to server at end.
-					defer func() {
-						server.Throttle <- 1
-					}()
-				default:
-					throttleMsg := fmt.Sprintf("Server already running maximum of %d throttled operations",
-						server.MaxThrottledOps)
-					http.Error(w, throttleMsg, http.StatusServiceUnavailable)
-					return
-				}
+			release, ok := server.ThrottleOp(w, r)
+			if !ok {
+				return
 			}
+			defer release()
 			subvol, err := dvid.NewSubvolumeFromStrings(offsetStr, sizeStr, "_")
 			if err != nil {
 				server.BadRequest(w, r, err.Error())
 				return
 			}
+			margin, err := voxels.MarginFromQuery(r)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
 			if op == voxels.GetOp {
-				e, err := d.NewExtHandler(subvol, nil)
-				if err != nil {
+				if subvol, err = d.ExtendSubvolume(subvol, margin, scale); err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
 				}
-				if roiptr != nil {
-					roiptr.Iter, err = roi.NewIterator(roiname, versionID, e)
-					if err != nil {
-						server.BadRequest(w, r, err.Error())
-						return
-					}
-				}
-				data, err := voxels.GetVolume(storeCtx, d, e, roiptr)
-				if err != nil {
+				if err = voxels.WriteSubvolume(storeCtx, d, subvol, roiname, roiptr, scale, w, r); err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
 				}
-				w.Header().Set("Content-type", "application/octet-stream")
-				_, err = w.Write(data)
-				if err != nil {
-					server.BadRequest(w, r, err.Error())
+			} else {
+				if margin != (dvid.Point3d{}) {
+					server.BadRequest(w, r, "margin can only be used when getting subvolumes")
 					return
 				}
-			} else {
 				if isotropic {
 					server.BadRequest(w, r, "can only PUT 'raw' not 'isotropic' images")
 					return
@@ -1093,6 +1689,7 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 				var opts voxels.OpOptions
 				opts.SetROI(roiptr)
 				opts.SetModsChannel(modsChan)
+				opts.SetEditSession(queryValues.Get("edit"))
 				err = voxels.PutVoxels(storeCtx, d, e, opts)
 				if err != nil {
 					server.BadRequest(w, r, err.Error())
@@ -1116,21 +1713,20 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		data, err := GetSparseVol(storeCtx, label)
+		bounds, err := BoundsFromQuery(r, d.BlockSize().(dvid.Point3d))
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
 		w.Header().Set("Content-type", "application/octet-stream")
-		_, err = w.Write(data)
-		if err != nil {
+		if err := WriteSparseVol(storeCtx, label, bounds, w, r); err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
 		timedLog.Infof("HTTP %s: sparsevol on label %d (%s)", r.Method, label, r.URL)
 
 	case "sparsevol-by-point":
-		// GET <api URL>/node/<UUID>/<data name>/sparsevol-by-point/<coord>
+		// GET <api URL>/node/<UUID>/<data name>/sparsevol-by-point/<coord>[?minx=0&maxx=1023&...]
 		if len(parts) < 5 {
 			server.BadRequest(w, r, "ERROR: DVID requires coord to follow 'sparsevol-by-point' command")
 			return
@@ -1145,19 +1741,52 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		data, err := GetSparseVol(storeCtx, label)
+		bounds, err := BoundsFromQuery(r, d.BlockSize().(dvid.Point3d))
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
 		w.Header().Set("Content-type", "application/octet-stream")
-		_, err = w.Write(data)
-		if err != nil {
+		if err := WriteSparseVol(storeCtx, label, bounds, w, r); err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
 		timedLog.Infof("HTTP %s: sparsevol-by-point at %s (%s)", r.Method, coord, r.URL)
 
+	case "sparsevol-diff":
+		// GET <api URL>/node/<UUID>/<data name>/sparsevol-diff/<label>/<from UUID>
+		if len(parts) < 6 {
+			server.BadRequest(w, r, "ERROR: DVID requires label ID and UUID to follow 'sparsevol-diff' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		fromCtx, err := versionContextInRepo(d, versionID, parts[5])
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		diff, err := GetSparseVolDiff(fromCtx, storeCtx, label)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		data, err := diff.MarshalBinary()
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		added, removed := diff.Stats()
+		w.Header().Set("Content-type", "application/octet-stream")
+		w.Header().Set("Dvid-Voxels-Added", strconv.Itoa(int(added)))
+		w.Header().Set("Dvid-Voxels-Removed", strconv.Itoa(int(removed)))
+		w.Write(data)
+		timedLog.Infof("HTTP %s: sparsevol-diff on label %d, %d added and %d removed voxels (%s)",
+			r.Method, label, added, removed, r.URL)
+
 	case "sparsevol-coarse":
 		// GET <api URL>/node/<UUID>/<data name>/sparsevol-coarse/<label>
 		if len(parts) < 5 {
@@ -1169,17 +1798,21 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		data, err := GetSparseCoarseVol(storeCtx, label)
+		bounds, err := BoundsFromQuery(r, d.BlockSize().(dvid.Point3d))
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
-		w.Header().Set("Content-type", "application/octet-stream")
-		_, err = w.Write(data)
+		data, err := GetSparseCoarseVol(storeCtx, label, bounds)
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
 			return
 		}
+		w.Header().Set("Content-type", "application/octet-stream")
+		if err = dvid.WriteCompressed(data, w, r); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
 		timedLog.Infof("HTTP %s: sparsevol-coarse on label %d (%s)", r.Method, label, r.URL)
 
 	case "surface":
@@ -1210,6 +1843,76 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 		timedLog.Infof("HTTP %s: surface on label %d (%s)", r.Method, label, r.URL)
 
+	case "mesh":
+		// GET <api URL>/node/<UUID>/<data name>/mesh/<label>[?lod=0]
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET is supported for label meshes.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'mesh' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		var lod uint8
+		if lodStr := queryValues.Get("lod"); lodStr != "" {
+			lods, err := parseMeshLODs(lodStr)
+			if err != nil || len(lods) != 1 {
+				server.BadRequest(w, r, "Bad lod %q: must be a level of detail from 0 to %d", lodStr, MaxMeshLOD)
+				return
+			}
+			lod = lods[0]
+		}
+		switch queryValues.Get("format") {
+		case "", "ngmesh":
+		case "draco":
+			server.BadRequest(w, r, "Draco mesh encoding is not supported")
+			return
+		default:
+			server.BadRequest(w, r, "Unknown mesh format %q", queryValues.Get("format"))
+			return
+		}
+		release, ok := server.ThrottleOp(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		mesh, found, err := d.GetMesh(storeCtx, label, lod, true)
+		if err != nil {
+			server.BadRequest(w, r, "Error on getting mesh for label %d: %s", label, err.Error())
+			return
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("Label %d not found", label), http.StatusNotFound)
+			return
+		}
+		voxels.ScaleMeshVertices(mesh, d.Properties.Resolution.VoxelSize)
+		w.Header().Set("Content-type", "application/octet-stream")
+		if _, err := w.Write(mesh); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP %s: mesh on label %d at level of detail %d (%s)", r.Method, label, lod, r.URL)
+
+	case "surface-regen":
+		// GET <api URL>/node/<UUID>/<data name>/surface-regen
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET is supported for surface regeneration status.")
+			return
+		}
+		jsonBytes, err := json.Marshal(d.SurfaceStatus())
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: surface regeneration status (%s)", r.Method, r.URL)
+
 	case "surface-by-point":
 		// GET <api URL>/node/<UUID>/<data name>/surface-by-point/<coord>
 		if len(parts) < 5 {
@@ -1243,6 +1946,54 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 		timedLog.Infof("HTTP %s: surface-by-point at %s (%s)", r.Method, coord, r.URL)
 
+	case "undo":
+		// GET  <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+		// POST <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+		var result interface{}
+		if op == voxels.GetOp {
+			result, err = d.UndoRecords(storeCtx, queryValues.Get("edit"))
+		} else {
+			modsChan := make(voxels.BlockChannel)
+			go d.denormFunc(versionID, modsChan)
+			result, err = d.Undo(storeCtx, queryValues.Get("edit"), modsChan)
+			close(modsChan)
+		}
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(result)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: undo (%s)", r.Method, r.URL)
+
+	case "colors":
+		// GET <api URL>/node/<UUID>/<data name>/colors[?labels=1,2,3]
+		// POST <api URL>/node/<UUID>/<data name>/colors
+		d.ServeColors(storeCtx, w, r)
+		timedLog.Infof("HTTP %s: colors (%s)", r.Method, r.URL)
+
+	case "renumbered":
+		// GET <api URL>/node/<UUID>/<data name>/renumbered/<dims>/<size>/<offset>
+		d.ServeRenumbered(storeCtx, w, r, parts)
+		timedLog.Infof("HTTP %s: renumbered (%s)", r.Method, r.URL)
+
+	case "precomputed":
+		// GET <api URL>/node/<UUID>/<data name>/precomputed/info
+		// GET <api URL>/node/<UUID>/<data name>/precomputed/<scale key>/<chunk name>
+		// GET <api URL>/node/<UUID>/<data name>/precomputed/mesh/<label>:0
+		d.Data.ServePrecomputed(storeCtx, d, w, r, parts[4:], d)
+		timedLog.Infof("HTTP %s: precomputed (%s)", r.Method, r.URL)
+
+	case "partition":
+		// GET <api URL>/node/<UUID>/<data name>/partition?n=16[&by=bytes][&roi=name]
+		d.ServePartition(storeCtx, w, r)
+		timedLog.Infof("HTTP %s: partition (%s)", r.Method, r.URL)
+
 	case "sizerange":
 		// GET <api URL>/node/<UUID>/<data name>/sizerange/<min size>/<optional max size>
 		if len(parts) < 5 {
@@ -1271,13 +2022,281 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 		fmt.Fprintf(w, jsonStr)
 		timedLog.Infof("HTTP %s: get labels with volume > %d and < %d (%s)", r.Method, minSize, maxSize, r.URL)
 
+	case "labelsizes":
+		// GET <api URL>/node/<UUID>/<data name>/labelsizes[?min=&max=&limit=&after=]
+		var minSize, maxSize uint64
+		var limit int
+		var after *LabelSize
+		if str := queryValues.Get("min"); str != "" {
+			if minSize, err = strconv.ParseUint(str, 10, 64); err != nil {
+				server.BadRequest(w, r, "Bad min size %q: %s", str, err.Error())
+				return
+			}
+		}
+		if str := queryValues.Get("max"); str != "" {
+			if maxSize, err = strconv.ParseUint(str, 10, 64); err != nil {
+				server.BadRequest(w, r, "Bad max size %q: %s", str, err.Error())
+				return
+			}
+		}
+		if str := queryValues.Get("limit"); str != "" {
+			if limit, err = strconv.Atoi(str); err != nil || limit < 1 {
+				server.BadRequest(w, r, "Bad limit %q: must be a positive integer", str)
+				return
+			}
+		}
+		if str := queryValues.Get("after"); str != "" {
+			cursor, err := ParseLabelSizeCursor(str)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			after = &cursor
+		}
+		page, err := GetLabelSizes(storeCtx, minSize, maxSize, limit, after)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(page)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.Write(jsonBytes)
+		timedLog.Infof("HTTP %s: get %d labels with sizes %d to %d (%s)", r.Method, len(page.Labels), minSize, maxSize, r.URL)
+
+	case "largest":
+		// GET <api URL>/node/<UUID>/<data name>/largest/<n>[?after=]
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires number of labels to follow 'largest' command")
+			return
+		}
+		n, err := strconv.Atoi(parts[4])
+		if err != nil || n < 1 {
+			server.BadRequest(w, r, "Bad number of labels %q: must be a positive integer", parts[4])
+			return
+		}
+		var after *LabelSize
+		if str := queryValues.Get("after"); str != "" {
+			cursor, err := ParseLabelSizeCursor(str)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			after = &cursor
+		}
+		page, err := GetLargestLabels(storeCtx, n, after)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(page)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.Write(jsonBytes)
+		timedLog.Infof("HTTP %s: get %d largest labels (%s)", r.Method, n, r.URL)
+
+	case "labelsize":
+		// GET <api URL>/node/<UUID>/<data name>/labelsize/<label>
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'labelsize' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		size, err := GetLabelSize(storeCtx, label)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, `{"Label": %d, "Size": %d}`, label, size)
+		timedLog.Infof("HTTP %s: get size of label %d (%s)", r.Method, label, r.URL)
+
+	case "contacts":
+		// GET <api URL>/node/<UUID>/<data name>/contacts/<label1>/<label2>
+		if len(parts) < 6 {
+			server.BadRequest(w, r, "ERROR: DVID requires two labels to follow 'contacts' command")
+			return
+		}
+		label1, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		label2, err := strconv.ParseUint(parts[5], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		budget, err := server.BudgetFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		contacts, progress, err := d.GetContactsBudget(storeCtx, label1, label2, budget)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if progress != nil {
+			if err := budget.SetPartial(w, progress); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		jsonBytes, err := json.Marshal(contacts)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: contacts between labels %d and %d (%s)", r.Method, label1, label2, r.URL)
+
+	case "mask":
+		// GET <api URL>/node/<UUID>/<data name>/mask/<label>/<size>/<offset>[?value=1]
+		if action != "get" {
+			server.BadRequest(w, r, "Label masks can only be retrieved via GET")
+			return
+		}
+		if len(parts) < 7 {
+			server.BadRequest(w, r, "ERROR: DVID requires label, size, and offset to follow 'mask' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		subvol, err := dvid.NewSubvolumeFromStrings(parts[6], parts[5], "_")
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		value, err := maskValueFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		data, err := d.GetLabelMask(storeCtx, subvol, label, value)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/octet-stream")
+		if err = dvid.WriteCompressed(data, w, r); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP %s: mask of label %d in %s (%s)", r.Method, label, subvol, r.URL)
+
+	case "rasterize":
+		// POST <api URL>/node/<UUID>/<data name>/rasterize/<label>?mask=<data name>[&value=1]
+		if action != "post" {
+			server.BadRequest(w, r, "Labels can only be rasterized via POST")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'rasterize' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		maskName := queryValues.Get("mask")
+		if maskName == "" {
+			server.BadRequest(w, r, "'rasterize' requires the name of a mask data instance via 'mask' query string")
+			return
+		}
+		value, err := maskValueFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		stats, err := d.RasterizeLabel(storeCtx, label, dvid.DataString(maskName), value)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(stats)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: rasterized label %d into %q (%s)", r.Method, label, maskName, r.URL)
+
+	case "from-mask":
+		// POST <api URL>/node/<UUID>/<data name>/from-mask/<label>/<size>/<offset>
+		if action != "post" {
+			server.BadRequest(w, r, "Labels can only be set from masks via POST")
+			return
+		}
+		if len(parts) < 7 {
+			server.BadRequest(w, r, "ERROR: DVID requires label, size, and offset to follow 'from-mask' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		subvol, err := dvid.NewSubvolumeFromStrings(parts[6], parts[5], "_")
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		mask, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if err := d.PutMaskedLabel(storeCtx, subvol, label, mask, queryValues.Get("edit")); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP %s: label %d from mask in %s (%s)", r.Method, label, subvol, r.URL)
+
 	case "split":
-		// POST <api URL>/node/<UUID>/<data name>/split
+		// POST <api URL>/node/<UUID>/<data name>/split/<label>
 		if action != "post" {
 			server.BadRequest(w, r, "Split requests must be POST actions.")
 			return
 		}
-		timedLog.Infof("HTTP split request (%s)", r.URL)
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'split' command")
+			return
+		}
+		fromLabel, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		splitRLEs, err := ReadSparseVol(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("Bad POSTed sparse volume for split: %s", err.Error()))
+			return
+		}
+		toLabel, err := d.SplitLabel(storeCtx, fromLabel, splitRLEs)
+		if err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("Error on split: %s", err.Error()))
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+		timedLog.Infof("HTTP split of label %d into label %d (%s)", fromLabel, toLabel, r.URL)
 
 	case "merge":
 		// POST <api URL>/node/<UUID>/<data name>/merge
@@ -1299,6 +2318,19 @@ func (d *Data) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Req
 			server.BadRequest(w, r, fmt.Sprintf("Error on merge: %s", err.Error()))
 			return
 		}
+		toLabels := make([]uint64, len(tuples))
+		for i, tuple := range tuples {
+			toLabels[i] = tuple[0]
+		}
+		jsonBytes, err := json.Marshal(struct {
+			Labels []uint64 `json:"labels"`
+		}{toLabels})
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
 		timedLog.Infof("HTTP merge request (%s)", r.URL)
 
 	default:
@@ -1356,9 +2388,10 @@ type blockOp struct {
 	grayscale *voxels.Data
 	composite *voxels.Data
 	versionID dvid.VersionID
+	colors    ColorMapper
 }
 
-// CreateComposite creates a new rgba8 image by combining hash of labels + the grayscale
+// CreateComposite creates a new rgba8 image by combining label colors + the grayscale
 func (d *Data) CreateComposite(request datastore.Request, reply *datastore.Response) error {
 	timedLog := dvid.NewTimeLog()
 
@@ -1412,15 +2445,19 @@ func (d *Data) CreateComposite(request datastore.Request, reply *datastore.Respo
 	}
 
 	// Iterate through all labels and grayscale chunks incrementally in Z, a layer at a time.
+	ctx := datastore.NewVersionedContext(d, versionID)
+	colors, err := d.GetColorMapper(ctx)
+	if err != nil {
+		return err
+	}
 	wg := new(sync.WaitGroup)
-	op := &blockOp{grayscale, composite, versionID}
+	op := &blockOp{grayscale, composite, versionID, colors}
 	chunkOp := &storage.ChunkOp{op, wg}
 
 	store, err := storage.BigDataStore()
 	if err != nil {
 		return err
 	}
-	ctx := datastore.NewVersionedContext(d, versionID)
 	extents := d.Extents()
 	blockBeg := voxels.NewVoxelBlockIndex(extents.MinIndex)
 	blockEnd := voxels.NewVoxelBlockIndex(extents.MaxIndex)
@@ -1510,17 +2547,19 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 	}
 
 	// Compute the composite block.
-	// TODO -- Exploit run lengths, use cache of hash?
 	compositeBytes := blockBytes / 2
 	compositeData := make([]byte, compositeBytes, compositeBytes)
 	compositeI := 0
 	labelI := 0
-	hashBuf := make([]byte, 4, 4)
+	var curLabel uint64
+	curColor := op.colors.LabelColor(curLabel)
 	for _, grayscale := range grayscaleData {
-		//murmurhash3(labelData[labelI:labelI+8], hashBuf)
-		//hashBuf[3] = grayscale
-		writePseudoColor(grayscale, labelData[labelI:labelI+8], hashBuf)
-		copy(compositeData[compositeI:compositeI+4], hashBuf)
+		label := d.ByteOrder.Uint64(labelData[labelI : labelI+8])
+		if label != curLabel {
+			curLabel = label
+			curColor = op.colors.LabelColor(label)
+		}
+		writePseudoColor(grayscale, curColor, compositeData[compositeI:compositeI+4])
 		compositeI += 4
 		labelI += 8
 	}
@@ -1539,16 +2578,16 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 	}
 }
 
-func writePseudoColor(grayscale uint8, in64bits, out32bits []byte) {
-	murmurhash3(in64bits, out32bits)
+// writePseudoColor writes a label color scaled by the grayscale intensity as opaque RGBA.
+func writePseudoColor(grayscale uint8, c color.NRGBA, out32bits []byte) {
 	var t uint64
-	t = uint64(out32bits[0]) * uint64(grayscale)
+	t = uint64(c.R) * uint64(grayscale)
 	t >>= 8
 	out32bits[0] = uint8(t)
-	t = uint64(out32bits[1]) * uint64(grayscale)
+	t = uint64(c.G) * uint64(grayscale)
 	t >>= 8
 	out32bits[1] = uint8(t)
-	t = uint64(out32bits[2]) * uint64(grayscale)
+	t = uint64(c.B) * uint64(grayscale)
 	t >>= 8
 	out32bits[2] = uint8(t)
 	out32bits[3] = 255
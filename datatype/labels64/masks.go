@@ -0,0 +1,182 @@
+/*
+	This file supports conversion between labels and binary masks.  A label can be
+	returned as a mask subvolume or rasterized into a mask data instance, i.e., voxels
+	data with 1 byte per voxel or an ROI, and a posted mask can be written as a label.
+*/
+
+package labels64
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// RasterizeStats describes a label rasterized into a mask instance.  Blocks is the
+// number of label blocks holding voxels of the label.
+type RasterizeStats struct {
+	Label  uint64
+	Mask   dvid.DataString
+	Voxels uint64
+	Blocks int
+}
+
+// maskValueFromQuery returns the mask value given by the "value" query string, which
+// defaults to 1.
+func maskValueFromQuery(r *http.Request) (uint8, error) {
+	valueStr := r.URL.Query().Get("value")
+	if valueStr == "" {
+		return 1, nil
+	}
+	value, err := strconv.ParseUint(valueStr, 10, 8)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("Mask value must be from 1 to 255, not %q", valueStr)
+	}
+	return uint8(value), nil
+}
+
+// GetLabelMask returns a subvolume with 1 byte per voxel that is the given value where
+// voxels have the label and 0 elsewhere.
+func (d *Data) GetLabelMask(ctx *datastore.VersionedContext, subvol *dvid.Subvolume, label uint64, value uint8) ([]byte, error) {
+	e, err := d.NewExtHandler(subvol, nil)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := voxels.GetVolume(ctx, d, e, nil)
+	if err != nil {
+		return nil, err
+	}
+	return labelMask(labels, d.Properties.ByteOrder.Uint64, label, value), nil
+}
+
+// labelMask returns a mask with the value where 64-bit labels equal the label.
+func labelMask(labels []byte, decode func([]byte) uint64, label uint64, value uint8) []byte {
+	mask := make([]byte, len(labels)/8)
+	for i := range mask {
+		if decode(labels[i*8:i*8+8]) == label {
+			mask[i] = value
+		}
+	}
+	return mask
+}
+
+// PutMaskedLabel sets the voxels of a subvolume where the mask is non-zero to the label,
+// leaving other voxels unchanged.  Label indices are updated as for posted label volumes.
+func (d *Data) PutMaskedLabel(ctx *datastore.VersionedContext, subvol *dvid.Subvolume, label uint64, mask []byte, editSession string) error {
+	if int64(len(mask)) != subvol.NumVoxels() {
+		return fmt.Errorf("Mask has %d bytes, expected 1 byte per voxel of %s", len(mask), subvol)
+	}
+	e, err := d.NewExtHandler(subvol, nil)
+	if err != nil {
+		return err
+	}
+	labels, err := voxels.GetVolume(ctx, d, e, nil)
+	if err != nil {
+		return err
+	}
+	for i, m := range mask {
+		if m != 0 {
+			d.Properties.ByteOrder.PutUint64(labels[i*8:i*8+8], label)
+		}
+	}
+	if e, err = d.NewExtHandler(subvol, labels); err != nil {
+		return err
+	}
+	modsChan := make(voxels.BlockChannel)
+	go d.denormFunc(ctx.VersionID(), modsChan)
+	var opts voxels.OpOptions
+	opts.SetModsChannel(modsChan)
+	opts.SetEditSession(editSession)
+	return voxels.PutVoxels(ctx, d, e, opts)
+}
+
+// RasterizeLabel marks the voxels of a label in a mask instance at the same version.
+// Voxels data with 1 byte per voxel gets the value at the label's voxels with other
+// voxels unchanged, while an ROI gets all blocks holding voxels of the label added.
+func (d *Data) RasterizeLabel(ctx *datastore.VersionedContext, label uint64, maskName dvid.DataString, value uint8) (*RasterizeStats, error) {
+	dest, err := datastore.GetData(ctx.VersionID(), maskName)
+	if err != nil {
+		return nil, err
+	}
+	rles, err := getLabelRLEs(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+	stats := &RasterizeStats{Label: label, Mask: maskName, Blocks: len(rles)}
+	switch mask := dest.(type) {
+	case *roi.Data:
+		err = rasterizeROI(ctx.VersionID(), rles, mask, stats)
+	case voxels.IntData:
+		if mask.Values().BytesPerElement() != 1 {
+			return nil, fmt.Errorf("Mask data %q must have 1 byte per voxel", maskName)
+		}
+		err = d.rasterizeVoxels(ctx.VersionID(), rles, mask, value, stats)
+	default:
+		return nil, fmt.Errorf("Data %q of type %q can't be used as a mask", maskName, dest.TypeName())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// rasterizeVoxels sets the voxels of label runs, given by label block, in voxels data
+// with 1 byte per voxel.
+func (d *Data) rasterizeVoxels(versionID dvid.VersionID, rles blockRLEs, mask voxels.IntData, value uint8, stats *RasterizeStats) error {
+	maskCtx := datastore.NewVersionedContext(mask.BaseData(), versionID)
+	blockSize := d.BlockSize().(dvid.Point3d)
+	for blockStr, blockRLEs := range rles {
+		var index dvid.IndexZYX
+		if err := index.IndexFromBytes([]byte(blockStr)); err != nil {
+			return err
+		}
+		minPt := index.MinPoint(blockSize).(dvid.Point3d)
+		subvol := dvid.NewSubvolume(minPt, blockSize)
+		e, err := mask.NewExtHandler(subvol, nil)
+		if err != nil {
+			return err
+		}
+		data, err := voxels.GetVolume(maskCtx, mask, e, nil)
+		if err != nil {
+			return err
+		}
+		for _, rle := range blockRLEs {
+			start := rle.StartPt().Sub(minPt).(dvid.Point3d)
+			i := (start[2]*blockSize[1]+start[1])*blockSize[0] + start[0]
+			for n := int32(0); n < rle.Length(); n++ {
+				data[i+n] = value
+			}
+			stats.Voxels += uint64(rle.Length())
+		}
+		if e, err = mask.NewExtHandler(subvol, data); err != nil {
+			return err
+		}
+		if err := voxels.PutVoxels(maskCtx, mask, e, voxels.OpOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rasterizeROI adds the ROI blocks holding label runs to an ROI.
+func rasterizeROI(versionID dvid.VersionID, rles blockRLEs, mask *roi.Data, stats *RasterizeStats) error {
+	blockSize := mask.BlockSize
+	var spans []dvid.Span
+	for _, blockRLEs := range rles {
+		for _, rle := range blockRLEs {
+			start := rle.StartPt()
+			end := start
+			end[0] += rle.Length() - 1
+			c0 := start.Chunk(blockSize).(dvid.ChunkPoint3d)
+			c1 := end.Chunk(blockSize).(dvid.ChunkPoint3d)
+			spans = append(spans, dvid.Span{c0[2], c0[1], c0[0], c1[0]})
+			stats.Voxels += uint64(rle.Length())
+		}
+	}
+	return mask.UpdateSpans(versionID, spans, roi.AddSpans)
+}
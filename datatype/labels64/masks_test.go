@@ -0,0 +1,52 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"net/http"
+	"testing"
+)
+
+func TestLabelMask(t *testing.T) {
+	labels := make([]byte, 8*5)
+	for i, label := range []uint64{23, 7, 23, 0, 1 << 40} {
+		binary.LittleEndian.PutUint64(labels[i*8:], label)
+	}
+	mask := labelMask(labels, binary.LittleEndian.Uint64, 23, 255)
+	expected := []byte{255, 0, 255, 0, 0}
+	if string(mask) != string(expected) {
+		t.Errorf("Bad mask of label 23: expected %v, got %v\n", expected, mask)
+	}
+	mask = labelMask(labels, binary.LittleEndian.Uint64, 1<<40, 1)
+	expected = []byte{0, 0, 0, 0, 1}
+	if string(mask) != string(expected) {
+		t.Errorf("Bad mask of label 2^40: expected %v, got %v\n", expected, mask)
+	}
+}
+
+func TestMaskValueFromQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		value uint8
+		ok    bool
+	}{
+		{"", 1, true},
+		{"?value=255", 255, true},
+		{"?value=0", 0, false},
+		{"?value=256", 0, false},
+		{"?value=abc", 0, false},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/api/node/3f8c/bodies/mask/23/8_8_8/0_0_0"+test.query, nil)
+		if err != nil {
+			t.Fatalf("Unable to create request: %s\n", err.Error())
+		}
+		value, err := maskValueFromQuery(r)
+		if test.ok != (err == nil) {
+			t.Errorf("Query %q: expected ok %t, got error %v\n", test.query, test.ok, err)
+			continue
+		}
+		if test.ok && value != test.value {
+			t.Errorf("Query %q: expected value %d, got %d\n", test.query, test.value, value)
+		}
+	}
+}
@@ -6,7 +6,11 @@
 package labels64
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"sync"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -16,6 +20,10 @@ import (
 	"github.com/janelia-flyem/dvid/storage"
 )
 
+// labelMutationMu serializes merges, splits, and label allocation so concurrent
+// mutations don't interleave their reads and writes of label keyspaces.
+var labelMutationMu sync.Mutex
+
 type MergeTuple []uint64
 
 type MergeTuples []MergeTuple
@@ -34,24 +42,101 @@ type sizeChange struct {
 	oldSize, newSize uint64
 }
 
+// PartialMutationError is returned by a merge or split whose label blocks were written
+// but whose block-level RLEs and label sizes couldn't be.  This can only happen if the
+// big and small data tiers are separate stores; otherwise all writes of a mutation are
+// committed in one transaction, which is atomic if the store supports transactions.  The sparse volumes and sizes of the listed labels, which
+// include the new label of a split, don't match their voxels until recomputed.  A failed
+// merge can be repeated to update them.
+type PartialMutationError struct {
+	Labels []uint64
+	Err    error
+}
+
+func (e *PartialMutationError) Error() string {
+	return fmt.Sprintf("label blocks were written but the RLEs and sizes of labels %v were not: %s",
+		e.Labels, e.Err.Error())
+}
+
+// labelMutation collects the label block and label index writes of a merge or split.
+// If the big and small data tiers share a store, everything is committed in one
+// transaction.  Otherwise the blocks, which all use the instance's context, are committed
+// before the index, so the big data store only needs to write one batch atomically.
+//
+// Stores that can't apply transactions atomically, e.g., the cluster store, fall back to
+// transactions that commit one batch per context.  A mutation's writes are then only
+// atomic for each context, so a failed commit may leave some of them written.
+type labelMutation struct {
+	blocks storage.Transaction
+	index  storage.Transaction
+}
+
+func newLabelMutation() (*labelMutation, error) {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	return newStoreMutation(smalldata, bigdata)
+}
+
+// newStoreMutation returns a mutation writing the label index to the small data store and
+// blocks to the big data store.
+func newStoreMutation(smalldata, bigdata storage.OrderedKeyValueDB) (*labelMutation, error) {
+	index, err := storage.NewBatchTransaction(smalldata)
+	if err != nil {
+		return nil, err
+	}
+	if interface{}(smalldata) == interface{}(bigdata) {
+		return &labelMutation{blocks: index, index: index}, nil
+	}
+	blocks, err := storage.NewBatchTransaction(bigdata)
+	if err != nil {
+		index.Rollback()
+		return nil, err
+	}
+	return &labelMutation{blocks: blocks, index: index}, nil
+}
+
+// commit writes the mutation, returning a *PartialMutationError if only the blocks
+// could be written.
+func (m *labelMutation) commit(labels []uint64) error {
+	if m.blocks == m.index {
+		return m.index.Commit()
+	}
+	if err := m.blocks.Commit(); err != nil {
+		m.index.Rollback()
+		return err
+	}
+	if err := m.index.Commit(); err != nil {
+		return &PartialMutationError{Labels: labels, Err: err}
+	}
+	return nil
+}
+
+func (m *labelMutation) rollback() {
+	m.blocks.Rollback()
+	m.index.Rollback()
+}
+
 // MergeLabels handles merging of any number of labels throughout the various label data
 // structures.  It assumes that the merges aren't cascading, e.g., there is no attempt
 // to merge label 3 into 4 and also 4 into 5.  The caller should have flattened the merges.
+// The relabeled blocks, block-level RLEs, and label sizes are committed together before
+// returning; see PartialMutationError for the case where they can't be.
 // TODO: Provide some indication that subset of labels are under evolution, returning
 //   an "unavailable" status or 203 for non-authoritative response.  This might not be
 //   feasible for clustered DVID front-ends due to coordination issues.
 func (d *Data) MergeLabels(ctx *datastore.VersionedContext, tuples MergeTuples) error {
-	smalldata, err := storage.SmallDataStore()
-	if err != nil {
-		return fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
-	}
-	smallBatcher, ok := smalldata.(storage.KeyValueBatcher)
-	if !ok {
-		return fmt.Errorf("Database doesn't support Batch ops in MergeLabels()")
-	}
-	bigdata, err := storage.BigDataStore()
+	labelMutationMu.Lock()
+	defer labelMutationMu.Unlock()
+
+	blobs, err := storage.BlobDataStore()
 	if err != nil {
-		return fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+		return fmt.Errorf("Cannot get datastore that handles blobs: %s\n", err.Error())
 	}
 
 	// Global remapping where key = label to be merged; value = new label
@@ -63,8 +148,22 @@ func (d *Data) MergeLabels(ctx *datastore.VersionedContext, tuples MergeTuples)
 	// All blocks that have changed during this merge.  Key = string of block index
 	blocksChanged := make(map[string]bool)
 
+	// All label blocks, spatial map, and size changes are committed together.
+	mutation, err := newLabelMutation()
+	if err != nil {
+		return fmt.Errorf("Can't merge labels in %q: %s", d.DataName(), err.Error())
+	}
+	defer mutation.rollback()
+	txn := mutation.index
+
+	// Blocks changed for each label whose surface needs to be regenerated after the commit.
+	surfaceBlocks := make(map[uint64]map[string]bool, len(tuples))
+
 	// Iterate through all the merge ops to get targeted blocks and the necessary relabeling
 	for _, tuple := range tuples {
+		if len(tuple) < 2 {
+			return fmt.Errorf("Merge list %v must have a target label and at least one label to merge", tuple)
+		}
 
 		fmt.Printf("Processing merge list: %v\n", tuple)
 
@@ -115,58 +214,80 @@ func (d *Data) MergeLabels(ctx *datastore.VersionedContext, tuples MergeTuples)
 			}
 
 			// Delete all fromLabel RLEs since they are all integrated into toLabel RLEs
-			minIndex := voxels.NewLabelSpatialMapIndex(fromLabel, dvid.MinIndexZYX.Bytes())
-			maxIndex := voxels.NewLabelSpatialMapIndex(fromLabel, dvid.MaxIndexZYX.Bytes())
-			if err := smalldata.DeleteRange(ctx, minIndex, maxIndex); err != nil {
-				return fmt.Errorf("Can't delete label %d RLEs: %s", fromLabel, err.Error())
-			}
-
-			// Delete the fromLabel surface.
-			surfaceIndex := voxels.NewLabelSurfaceIndex(fromLabel)
-			if err := bigdata.Delete(ctx, surfaceIndex); err != nil {
-				return fmt.Errorf("Can't delete label %d surface: %s", fromLabel, err.Error())
+			for blockStr := range fromLabelRLEs {
+				txn.Delete(ctx, voxels.NewLabelSpatialMapIndex(fromLabel, []byte(blockStr)))
 			}
 		}
 
 		// Update datastore with all toLabel RLEs that were changed
-		batch := smallBatcher.NewBatch(ctx)
 		for blockStr := range blocksChangedForLabel {
 			toLabelRLEsIndex := voxels.NewLabelSpatialMapIndex(toLabel, []byte(blockStr))
 			serialization, err := toLabelRLEs[blockStr].MarshalBinary()
 			if err != nil {
-				dvid.Errorf("Error serializing RLEs for label %d: %s\n", toLabel, err.Error())
-				continue
+				return fmt.Errorf("Error serializing RLEs for label %d: %s", toLabel, err.Error())
 			}
-			batch.Put(toLabelRLEsIndex, serialization)
-		}
-		if err := batch.Commit(); err != nil {
-			dvid.Errorf("Error on updating RLEs for label %d: %s\n", toLabel, err.Error())
+			txn.Put(ctx, toLabelRLEsIndex, serialization)
 		}
 		sizeMods[toLabel] = sizeChange{toLabelSize, toLabelSize + addedVoxels}
-
-		// Recompute the toLabel surface
-		go d.recomputeSurface(ctx, toLabel, toLabelRLEs)
+		surfaceBlocks[toLabel] = blocksChangedForLabel
 	}
 
-	// Update all label size data (key: sz + b)
-	go updateLabelSizes(ctx, sizeMods)
+	// Relabel the merged blocks, keeping them locked until the merge is committed so
+	// concurrent PUTs aren't lost.
+	blockKeys := make([][]byte, 0, len(blocksChanged))
+	for blockStr := range blocksChanged {
+		blockKeys = append(blockKeys, voxels.NewVoxelBlockIndexByCoord(blockStr))
+	}
+	unlockBlocks := voxels.LockBlocks(d, ctx.VersionID(), blockKeys)
+	defer unlockBlocks()
+	if err := d.relabelBlocks(ctx, mutation.blocks, blockKeys, remapping); err != nil {
+		return err
+	}
 
-	// Iterate through all the label blocks and perform the actual relabeling.
-	go d.relabelBlocks(ctx, blocksChanged, remapping)
+	// Update all label size data (key: sz + b) and commit along with the blocks and RLEs.
+	putLabelSizes(ctx, txn, sizeMods)
+	labels := make([]uint64, 0, len(sizeMods))
+	for label := range sizeMods {
+		labels = append(labels, label)
+	}
+	if err := mutation.commit(labels); err != nil {
+		if _, partial := err.(*PartialMutationError); partial {
+			return err
+		}
+		return fmt.Errorf("Error on updating blocks, RLEs, and sizes for merge: %s", err.Error())
+	}
 
-	return nil
-}
+	// Delete the surfaces of merged labels only after the merge is committed.
+	for fromLabel := range remapping {
+		if err := blobs.Delete(ctx, voxels.NewLabelSurfaceIndex(fromLabel)); err != nil {
+			return fmt.Errorf("Can't delete label %d surface: %s", fromLabel, err.Error())
+		}
+		if err := deleteMeshes(ctx, fromLabel); err != nil {
+			return err
+		}
+	}
 
-// recomputeSurface refreshes the computed surface from a label's RLEs.
-func (d *Data) recomputeSurface(ctx *datastore.VersionedContext, label uint64, rles blockRLEs) {
-	var curVol dvid.SparseVol
-	curVol.SetLabel(label)
-	for _, rle := range rles {
-		curVol.AddRLE(rle)
+	// Regenerate the toLabel surfaces near the merged blocks.
+	for toLabel, blocks := range surfaceBlocks {
+		if err := deleteMeshes(ctx, toLabel); err != nil {
+			return err
+		}
+		d.queueSurfaceUpdate(ctx.VersionID(), toLabel, blocks)
 	}
-	if err := d.computeAndSaveSurface(ctx, &curVol); err != nil {
-		dvid.Errorf("Error on computing surface and normals for label %d: %s\n", label, err.Error())
+
+	d.queuePreviewUpdate(ctx.VersionID(), blocksChanged)
+
+	for _, tuple := range tuples {
+		msg := datastore.SyncMessage{
+			Event:   voxels.LabelMergeEvent,
+			Version: ctx.VersionID(),
+			Delta:   voxels.LabelMergeDelta{ToLabel: tuple[0], FromLabels: tuple[1:]},
+		}
+		if err := datastore.NotifySubscribers(d, msg); err != nil {
+			dvid.Errorf("Unable to notify subscribers of merge in %q: %s\n", d.DataName(), err.Error())
+		}
 	}
+	return nil
 }
 
 // Update all label size data (key: sz + b)
@@ -176,77 +297,98 @@ func updateLabelSizes(ctx *datastore.VersionedContext, sizeMods map[uint64]sizeC
 		dvid.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
 		return
 	}
-	smallBatcher, ok := smalldata.(storage.KeyValueBatcher)
-	if !ok {
-		dvid.Errorf("Database doesn't support Batch ops in updateLabelSizes()")
+	txn, err := storage.NewBatchTransaction(smalldata)
+	if err != nil {
+		dvid.Errorf("Can't update label sizes on %s: %s\n", ctx, err.Error())
 		return
 	}
-	// For every label key, delete the current label size and add the new one.
 	timedLog := dvid.NewTimeLog()
-	batch := smallBatcher.NewBatch(ctx)
+	putLabelSizes(ctx, txn, sizeMods)
+	if err := txn.Commit(); err != nil {
+		dvid.Errorf("Error on updating label sizes on %s: %s\n", ctx, err.Error())
+	}
+	timedLog.Infof("Updated %d label sizes", len(sizeMods))
+}
+
+// putLabelSizes adds label size changes to a transaction.  For every label key, the
+// current label size is deleted and the new one added.
+func putLabelSizes(ctx storage.Context, txn storage.Transaction, sizeMods map[uint64]sizeChange) {
 	for label, change := range sizeMods {
 		oldKey := voxels.NewLabelSizesIndex(change.oldSize, label)
 		newKey := voxels.NewLabelSizesIndex(change.newSize, label)
-		batch.Put(newKey, dvid.EmptyValue())
-		batch.Delete(oldKey)
-	}
-	if err := batch.Commit(); err != nil {
-		dvid.Errorf("Error on updating label sizes on %s: %s\n", ctx, err.Error())
+		txn.Put(ctx, newKey, dvid.EmptyValue())
+		txn.Delete(ctx, oldKey)
 	}
-	timedLog.Infof("Updated %d label sizes", len(sizeMods))
 }
 
-// Iterate through all the label blocks and perform the actual relabeling.
-func (d *Data) relabelBlocks(ctx *datastore.VersionedContext, blocksChanged map[string]bool,
-	remapping map[uint64]uint64) {
+// relabelBlocks relabels the given blocks in parallel, adding the modified blocks to the
+// transaction.  The caller must have locked the blocks.
+func (d *Data) relabelBlocks(ctx *datastore.VersionedContext, txn storage.Transaction, blockKeys [][]byte,
+	remapping map[uint64]uint64) error {
 
 	bigdata, err := storage.BigDataStore()
 	if err != nil {
-		dvid.Errorf("In relabeling, can't get big datastore: %s\n", err.Error())
-		return
+		return fmt.Errorf("In relabeling, can't get big datastore: %s\n", err.Error())
 	}
 
 	// Iterate through all modified blocks
 	timedLog := dvid.NewTimeLog()
+	var mu sync.Mutex
+	var relabelErr error
 	wg := new(sync.WaitGroup)
-	for blockStr, _ := range blocksChanged {
-		blockKey := voxels.NewVoxelBlockIndexByCoord(blockStr)
-		value, err := bigdata.Get(ctx, blockKey)
-		if err != nil {
-			dvid.Errorf("Error in getting block of labels with block %v: %s\n",
-				[]byte(blockStr), err.Error())
-			return
-		}
+	for _, blockKey := range blockKeys {
 		<-server.HandlerToken
 		wg.Add(1)
-		go d.relabelChunk(ctx, blockKey, value, remapping, wg)
+		go func(k []byte) {
+			defer func() {
+				// After processing a chunk, return the token.
+				server.HandlerToken <- 1
+				wg.Done()
+			}()
+			serialization, err := d.relabelChunk(ctx, bigdata, k, remapping)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if relabelErr == nil {
+					relabelErr = err
+				}
+				return
+			}
+			if serialization != nil {
+				txn.Put(ctx, k, serialization)
+			}
+		}(blockKey)
 	}
 	wg.Wait()
-	timedLog.Infof("Completed relabeling of %d blocks", len(blocksChanged))
+	if relabelErr != nil {
+		return relabelErr
+	}
+	timedLog.Infof("Relabeled %d blocks", len(blockKeys))
+	return nil
 }
 
-func (d *Data) relabelChunk(ctx *datastore.VersionedContext, k, v []byte,
-	remapping map[uint64]uint64, wg *sync.WaitGroup) {
+// relabelChunk returns the serialization of a relabeled block, or nil if the block
+// isn't stored.
+func (d *Data) relabelChunk(ctx *datastore.VersionedContext, bigdata storage.BigDataStorer,
+	k []byte, remapping map[uint64]uint64) ([]byte, error) {
 
-	defer func() {
-		// After processing a chunk, return the token.
-		server.HandlerToken <- 1
-
-		// Notify the requestor that this chunk is done.
-		wg.Done()
-	}()
+	v, err := bigdata.Get(ctx, k)
+	if err != nil {
+		return nil, fmt.Errorf("Error in getting block of labels with key %v: %s", k, err.Error())
+	}
+	if v == nil {
+		return nil, nil
+	}
 
 	// Initialize the label buffer.  For voxels, this data needs to be uncompressed and deserialized.
 	blockData, _, err := dvid.DeserializeData(v, true)
 	if err != nil {
-		dvid.Infof("Unable to deserialize block in '%s': %s\n", d.DataName(), err.Error())
-		return
+		return nil, fmt.Errorf("Unable to deserialize block in '%s': %s", d.DataName(), err.Error())
 	}
 	numElements := int32(d.BlockSize().Prod())
 	if int32(len(blockData)) != numElements*8 {
-		dvid.Errorf("Received block with %d bytes instead of bytes for %d labels\n",
+		return nil, fmt.Errorf("Received block with %d bytes instead of bytes for %d labels",
 			len(blockData), numElements)
-		return
 	}
 
 	// Iterate through this block of labels and relabel if label in remapping.
@@ -258,18 +400,274 @@ func (d *Data) relabelChunk(ctx *datastore.VersionedContext, k, v []byte,
 		}
 	}
 
-	// Store this block.
+	serialization, err := dvid.SerializeData(blockData, d.Compression(), d.Checksum())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize block in %q: %s", d.DataName(), err.Error())
+	}
+	return serialization, nil
+}
+
+// NewLabel returns a label larger than any label stored or previously allocated.
+// The largest allocated label is persisted so labels are never reused.
+func (d *Data) NewLabel(ctx *datastore.VersionedContext) (uint64, error) {
+	labelMutationMu.Lock()
+	defer labelMutationMu.Unlock()
+	return d.newLabel(ctx)
+}
+
+func (d *Data) newLabel(ctx *datastore.VersionedContext) (uint64, error) {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return 0, fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	maxLabel, err := getMaxLabel(ctx, smalldata)
+	if err != nil {
+		return 0, err
+	}
+	if maxLabel == math.MaxUint64 {
+		return 0, fmt.Errorf("No more labels can be allocated for data %q", d.DataName())
+	}
+	maxLabel++
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, maxLabel)
+	if err := smalldata.Put(ctx, voxels.NewMaxLabelIndex(), buf); err != nil {
+		return 0, fmt.Errorf("Unable to store max label for data %q: %s", d.DataName(), err.Error())
+	}
+	return maxLabel, nil
+}
+
+// getMaxLabel returns the largest allocated label.  If no label has been allocated, the
+// largest label with a stored size is used.
+func getMaxLabel(ctx *datastore.VersionedContext, smalldata storage.SmallDataStorer) (uint64, error) {
+	value, err := smalldata.Get(ctx, voxels.NewMaxLabelIndex())
+	if err != nil {
+		return 0, err
+	}
+	if value != nil {
+		if len(value) != 8 {
+			return 0, fmt.Errorf("Bad max label value, expected 8 bytes got %d", len(value))
+		}
+		return binary.BigEndian.Uint64(value), nil
+	}
+	begIndex := voxels.NewLabelSizesIndex(0, 0)
+	endIndex := voxels.NewLabelSizesIndex(math.MaxUint64, math.MaxUint64)
+	keys, err := smalldata.KeysInRange(ctx, begIndex, endIndex)
+	if err != nil {
+		return 0, err
+	}
+	var maxLabel uint64
+	for _, key := range keys {
+		label, err := voxels.LabelFromLabelSizesKey(key)
+		if err != nil {
+			return 0, err
+		}
+		if label > maxLabel {
+			maxLabel = label
+		}
+	}
+	return maxLabel, nil
+}
+
+// ReadSparseVol returns the RLEs from a binary sparse volume encoded as described for
+// the "sparsevol" endpoint.
+func ReadSparseVol(r io.Reader) (dvid.RLEs, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, fmt.Errorf("Sparse volume encoding has only %d bytes, needs at least 12", len(data))
+	}
+	if data[0] != dvid.EncodingBinary {
+		return nil, fmt.Errorf("Sparse volume must have binary payload descriptor, got %d", data[0])
+	}
+	if data[1] != 3 || data[2] != 0 {
+		return nil, fmt.Errorf("Only 3d sparse volumes with runs along X are supported")
+	}
+	numSpans := binary.LittleEndian.Uint32(data[8:12])
+	if uint64(len(data)-12) != uint64(numSpans)*16 {
+		return nil, fmt.Errorf("Sparse volume has %d spans but %d bytes of runs", numSpans, len(data)-12)
+	}
+	var rles dvid.RLEs
+	if err := rles.UnmarshalBinary(data[12:]); err != nil {
+		return nil, err
+	}
+	return rles, nil
+}
+
+// splitRLEsByBlock partitions RLEs along X into the blocks they intersect.  The returned
+// map is keyed by block index in string format.
+func splitRLEsByBlock(rles dvid.RLEs, blockSize dvid.Point3d) blockRLEs {
+	byBlock := blockRLEs{}
+	for _, rle := range rles {
+		pt := rle.StartPt()
+		x0 := int64(pt[0])
+		x1 := x0 + int64(rle.Length()) - 1
+		by := blockCoord(pt[1], blockSize[1])
+		bz := blockCoord(pt[2], blockSize[2])
+		for x := x0; x <= x1; {
+			bx := blockCoord(int32(x), blockSize[0])
+			blockEnd := int64(bx+1)*int64(blockSize[0]) - 1
+			if blockEnd > x1 {
+				blockEnd = x1
+			}
+			zyx := dvid.IndexZYX{bx, by, bz}
+			blockStr := string(zyx.Bytes())
+			start := dvid.Point3d{int32(x), pt[1], pt[2]}
+			byBlock[blockStr] = append(byBlock[blockStr], dvid.NewRLE(start, int32(blockEnd-x+1)))
+			x = blockEnd + 1
+		}
+	}
+	return byBlock
+}
+
+// SplitLabel moves the voxels of a label within the given RLEs into a newly allocated
+// label, returning the new label.  Label blocks, block-level RLEs, and label sizes are
+// committed together before returning; see PartialMutationError for the case where they
+// can't be.  Surfaces of both labels are regenerated in the background.
+func (d *Data) SplitLabel(ctx *datastore.VersionedContext, fromLabel uint64, splitRLEs dvid.RLEs) (uint64, error) {
+	if fromLabel == 0 {
+		return 0, fmt.Errorf("Cannot split the background label 0")
+	}
+	labelMutationMu.Lock()
+	defer labelMutationMu.Unlock()
+
 	bigdata, err := storage.BigDataStore()
 	if err != nil {
-		dvid.Errorf("Unable to obtain BigData store in %q: %s\n", d.DataName(), err.Error())
-		return
+		return 0, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
 	}
-	serialization, err := dvid.SerializeData(blockData, d.Compression(), d.Checksum())
+
+	fromLabelRLEs, err := getLabelRLEs(ctx, fromLabel)
 	if err != nil {
-		dvid.Errorf("Unable to serialize block in %q: %s\n", d.DataName(), err.Error())
-		return
+		return 0, fmt.Errorf("Can't get block-level RLEs for label %d: %s", fromLabel, err.Error())
+	}
+	fromLabelSize := fromLabelRLEs.numVoxels()
+
+	toLabel, err := d.newLabel(ctx)
+	if err != nil {
+		return 0, err
+	}
+	changedBlocks := make(map[string]bool)
+
+	// Relabel the split voxels within each block, then recompute block-level RLEs
+	// for both labels.
+	blockSize := d.BlockSize().(dvid.Point3d)
+	numElements := blockSize.Prod()
+	mutation, err := newLabelMutation()
+	if err != nil {
+		return 0, fmt.Errorf("Can't split label in %q: %s", d.DataName(), err.Error())
+	}
+	defer mutation.rollback()
+	txn := mutation.index
+	var splitVoxels uint64
+
+	// Keep the split blocks locked until they are written so concurrent PUTs aren't lost.
+	rlesByBlock := splitRLEsByBlock(splitRLEs, blockSize)
+	blockKeys := make([][]byte, 0, len(rlesByBlock))
+	for blockStr := range rlesByBlock {
+		if _, found := fromLabelRLEs[blockStr]; found {
+			blockKeys = append(blockKeys, voxels.NewVoxelBlockIndexByCoord(blockStr))
+		}
+	}
+	unlockBlocks := voxels.LockBlocks(d, ctx.VersionID(), blockKeys)
+	defer unlockBlocks()
+
+	for blockStr, rles := range rlesByBlock {
+		if _, found := fromLabelRLEs[blockStr]; !found {
+			continue
+		}
+		var zyx dvid.IndexZYX
+		if err := zyx.IndexFromBytes([]byte(blockStr)); err != nil {
+			return 0, err
+		}
+		blockKey := voxels.NewVoxelBlockIndexByCoord(blockStr)
+		value, err := bigdata.Get(ctx, blockKey)
+		if err != nil {
+			return 0, fmt.Errorf("Error in getting block of labels %s: %s", &zyx, err.Error())
+		}
+		if value == nil {
+			continue
+		}
+		blockData, _, err := dvid.DeserializeData(value, true)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to deserialize block %s: %s", &zyx, err.Error())
+		}
+		if int64(len(blockData)) != numElements*8 {
+			return 0, fmt.Errorf("Block %s has %d bytes instead of bytes for %d labels",
+				&zyx, len(blockData), numElements)
+		}
+		offset := zyx.MinPoint(blockSize).(dvid.Point3d)
+		var changed bool
+		for _, rle := range rles {
+			pt := rle.StartPt().Sub(offset).(dvid.Point3d)
+			i := int64(pt[2]*blockSize[0]*blockSize[1]+pt[1]*blockSize[0]+pt[0]) * 8
+			for n := int32(0); n < rle.Length(); n++ {
+				if d.Properties.ByteOrder.Uint64(blockData[i:i+8]) == fromLabel {
+					d.Properties.ByteOrder.PutUint64(blockData[i:i+8], toLabel)
+					splitVoxels++
+					changed = true
+				}
+				i += 8
+			}
+		}
+		if !changed {
+			continue
+		}
+		serialization, err := dvid.SerializeData(blockData, d.Compression(), d.Checksum())
+		if err != nil {
+			return 0, fmt.Errorf("Unable to serialize block %s: %s", &zyx, err.Error())
+		}
+		mutation.blocks.Put(ctx, blockKey, serialization)
+
+		labelRLEs := d.blockLabelRLEs(&zyx, blockData)
+		for _, label := range []uint64{fromLabel, toLabel} {
+			index := voxels.NewLabelSpatialMapIndex(label, []byte(blockStr))
+			blockLabelRLEs, found := labelRLEs[label]
+			if !found {
+				txn.Delete(ctx, index)
+				continue
+			}
+			runsBytes, err := blockLabelRLEs.MarshalBinary()
+			if err != nil {
+				return 0, fmt.Errorf("Error serializing RLEs for label %d: %s", label, err.Error())
+			}
+			txn.Put(ctx, index, runsBytes)
+		}
+		changedBlocks[blockStr] = true
+	}
+	if splitVoxels == 0 {
+		return 0, fmt.Errorf("Split volume doesn't intersect label %d", fromLabel)
+	}
+
+	sizeMods := map[uint64]sizeChange{
+		fromLabel: {fromLabelSize, fromLabelSize - splitVoxels},
+		toLabel:   {0, splitVoxels},
+	}
+	putLabelSizes(ctx, txn, sizeMods)
+
+	if err := mutation.commit([]uint64{fromLabel, toLabel}); err != nil {
+		if _, partial := err.(*PartialMutationError); partial {
+			return 0, err
+		}
+		return 0, fmt.Errorf("Error on updating blocks, RLEs, and sizes for split: %s", err.Error())
+	}
+
+	for _, label := range []uint64{fromLabel, toLabel} {
+		if err := deleteMeshes(ctx, label); err != nil {
+			return 0, err
+		}
+	}
+	d.queueSurfaceUpdate(ctx.VersionID(), fromLabel, changedBlocks)
+	d.queueSurfaceUpdate(ctx.VersionID(), toLabel, changedBlocks)
+	d.queuePreviewUpdate(ctx.VersionID(), changedBlocks)
+
+	msg := datastore.SyncMessage{
+		Event:   voxels.LabelSplitEvent,
+		Version: ctx.VersionID(),
+		Delta:   voxels.LabelSplitDelta{FromLabel: fromLabel, ToLabel: toLabel, SplitVoxels: splitVoxels},
 	}
-	if err := bigdata.Put(ctx, k, serialization); err != nil {
-		dvid.Errorf("Error in putting key %v: %s\n", k, err.Error())
+	if err := datastore.NotifySubscribers(d, msg); err != nil {
+		dvid.Errorf("Unable to notify subscribers of split in %q: %s\n", d.DataName(), err.Error())
 	}
+	return toLabel, nil
 }
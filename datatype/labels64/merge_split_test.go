@@ -3,13 +3,17 @@ package labels64
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
 	"github.com/janelia-flyem/dvid/tests"
 )
 
@@ -303,10 +307,188 @@ func TestMergeLabels(t *testing.T) {
 	}
 }
 
+// Returns a binary sparse volume encoding of the body with runs along X.
+func (b testBody) sparseVol() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))
+	binary.Write(buf, binary.LittleEndian, byte(0))
+	buf.WriteByte(byte(0))
+	binary.Write(buf, binary.LittleEndian, uint32(b.size.Prod()))
+	binary.Write(buf, binary.LittleEndian, uint32(b.size[1]*b.size[2]))
+	for z := b.offset[2]; z < b.offset[2]+b.size[2]; z++ {
+		for y := b.offset[1]; y < b.offset[1]+b.size[1]; y++ {
+			binary.Write(buf, binary.LittleEndian, b.offset[0])
+			binary.Write(buf, binary.LittleEndian, y)
+			binary.Write(buf, binary.LittleEndian, z)
+			binary.Write(buf, binary.LittleEndian, b.size[0])
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestSplitRLEsByBlock(t *testing.T) {
+	rles := dvid.RLEs{
+		dvid.NewRLE(dvid.Point3d{-3, 5, 40}, 40),
+		dvid.NewRLE(dvid.Point3d{1, 2, 3}, 4),
+	}
+	byBlock := splitRLEsByBlock(rles, dvid.Point3d{32, 32, 32})
+	expected := map[dvid.IndexZYX]dvid.RLEs{
+		dvid.IndexZYX{-1, 0, 1}: {dvid.NewRLE(dvid.Point3d{-3, 5, 40}, 3)},
+		dvid.IndexZYX{0, 0, 1}:  {dvid.NewRLE(dvid.Point3d{0, 5, 40}, 32)},
+		dvid.IndexZYX{1, 0, 1}:  {dvid.NewRLE(dvid.Point3d{32, 5, 40}, 5)},
+		dvid.IndexZYX{0, 0, 0}:  {dvid.NewRLE(dvid.Point3d{1, 2, 3}, 4)},
+	}
+	if len(byBlock) != len(expected) {
+		t.Fatalf("Expected RLEs in %d blocks, got %d\n", len(expected), len(byBlock))
+	}
+	for zyx, rles := range expected {
+		if got := byBlock[string(zyx.Bytes())]; !reflect.DeepEqual(got, rles) {
+			t.Errorf("Expected RLEs %v in block %s, got %v\n", rles, &zyx, got)
+		}
+	}
+}
+
 func TestSplitLabel(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
 	// Create testbed labels64 volume
+	repo, _ := initTestRepo()
+	labelsName := "mylabels"
+	uuid := repo.RootUUID()
+	server.CreateTestInstance(t, uuid, "labels64", labelsName)
+	createLabelTestVolume(t, uuid, labelsName)
+
+	// TODO -- Remove this hack in favor of whatever will be the method
+	// for discerning denormalizations are not yet complete.
+	time.Sleep(10 * time.Second)
 
-	// Split part of a label
+	// Split part of a label, including voxels outside the label that should be ignored.
+	splitBody := testBody{
+		offset: dvid.Point3d{70, 40, 60},
+		size:   dvid.Point3d{25, 20, 10},
+	}
+	reqStr := fmt.Sprintf("%snode/%s/%s/split/4", server.WebAPIPath, uuid, labelsName)
+	r := server.TestHTTP(t, "POST", reqStr, bytes.NewBuffer(splitBody.sparseVol()))
+	var jsonVal struct {
+		Label uint64
+	}
+	if err := json.Unmarshal(r, &jsonVal); err != nil {
+		t.Fatalf("Unable to get new label from split.  Instead got: %v\n", string(r))
+	}
+	if jsonVal.Label <= 4 {
+		t.Errorf("Expected new label larger than existing labels, got %d\n", jsonVal.Label)
+	}
 
 	// Make sure changes are correct after completion
+	expected := newTestVolume(100, 100, 100)
+	expected.add(body1, 0)
+	expected.add(body2, 0)
+	expected.add(body3, 0)
+	expected.add(body4, 0)
+	expected.add(testBody{offset: dvid.Point3d{75, 40, 60}, size: dvid.Point3d{20, 20, 10}}, jsonVal.Label)
+
+	retrieved := newTestVolume(100, 100, 100)
+	retrieved.get(t, uuid, labelsName)
+	if !retrieved.equals(expected) {
+		t.Errorf("Split label volume not equal to expected split volume\n")
+	}
+
+	// A second split gets a different label.
+	r = server.TestHTTP(t, "POST", reqStr, bytes.NewBuffer(body4.sparseVol()))
+	var jsonVal2 struct {
+		Label uint64
+	}
+	if err := json.Unmarshal(r, &jsonVal2); err != nil {
+		t.Fatalf("Unable to get new label from split.  Instead got: %v\n", string(r))
+	}
+	if jsonVal2.Label <= jsonVal.Label {
+		t.Errorf("Expected second split label %d to be larger than %d\n", jsonVal2.Label, jsonVal.Label)
+	}
+}
+
+// testTransaction records whether it was committed or rolled back.
+type testTransaction struct {
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (txn *testTransaction) Put(ctx storage.Context, k, v []byte) {}
+func (txn *testTransaction) Delete(ctx storage.Context, k []byte) {}
+func (txn *testTransaction) Rollback()                            { txn.rolledBack = true }
+
+func (txn *testTransaction) Commit() error {
+	txn.committed = true
+	return txn.commitErr
+}
+
+func TestLabelMutationCommit(t *testing.T) {
+	// Blocks are written before the index of separate stores.
+	blocks, index := &testTransaction{}, &testTransaction{commitErr: errors.New("disk full")}
+	mutation := &labelMutation{blocks: blocks, index: index}
+	err := mutation.commit([]uint64{4, 9})
+	partial, ok := err.(*PartialMutationError)
+	if !ok || !blocks.committed || !reflect.DeepEqual(partial.Labels, []uint64{4, 9}) {
+		t.Errorf("Expected partial mutation error for labels 4 and 9, got %v\n", err)
+	}
+
+	// Nothing is written if the blocks fail.
+	blocks, index = &testTransaction{commitErr: errors.New("disk full")}, &testTransaction{}
+	mutation = &labelMutation{blocks: blocks, index: index}
+	if err := mutation.commit([]uint64{4}); err == nil || !index.rolledBack || index.committed {
+		t.Errorf("Expected index to be rolled back after failed block commit, got %v\n", err)
+	}
+
+	// A shared store commits one transaction.
+	txn := &testTransaction{commitErr: errors.New("disk full")}
+	mutation = &labelMutation{blocks: txn, index: txn}
+	if _, partial := mutation.commit([]uint64{4}).(*PartialMutationError); partial || !txn.committed {
+		t.Errorf("Expected single failed commit for shared store\n")
+	}
+}
+
+// noTxnStore hides the transaction support of a store, like the cluster store.
+type noTxnStore struct {
+	storage.OrderedKeyValueDB
+}
+
+func (db noTxnStore) NewBatch(ctx storage.Context) storage.Batch {
+	return db.OrderedKeyValueDB.(storage.KeyValueBatcher).NewBatch(ctx)
+}
+
+func TestLabelMutationWithoutTransactions(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	labels := newDataInstance(repo, t, "mylabels")
+	ctx := datastore.NewVersionedContext(labels, versionID)
+
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		t.Fatalf("Can't get small data store: %s\n", err.Error())
+	}
+	db := noTxnStore{smalldata}
+	if _, err := storage.NewTransaction(db); err != storage.ErrNoTransactions {
+		t.Fatalf("Expected store without transactions, got %v\n", err)
+	}
+
+	// Mutations fall back to batches instead of failing.
+	mutation, err := newStoreMutation(db, db)
+	if err != nil {
+		t.Fatalf("Unable to create label mutation without transactions: %s\n", err.Error())
+	}
+	mutation.blocks.Put(ctx, []byte("block"), []byte("b"))
+	mutation.index.Put(ctx, []byte("index"), []byte("i"))
+	if err := mutation.commit([]uint64{1}); err != nil {
+		t.Fatalf("Error committing label mutation: %s\n", err.Error())
+	}
+	for k, expected := range map[string]string{"block": "b", "index": "i"} {
+		v, err := smalldata.Get(ctx, []byte(k))
+		if err != nil || string(v) != expected {
+			t.Errorf("Expected %q for key %q after commit, got %q (error %v)\n", expected, k, v, err)
+		}
+	}
 }
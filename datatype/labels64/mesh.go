@@ -0,0 +1,337 @@
+/*
+	This file generates triangle meshes of labels at multiple levels of detail.  At level
+	of detail l, a label is downsampled to cells of 2^l voxels on a side, where a cell is
+	set if any of its voxels has the label, and the mesh is the boundary of the set cells.
+	Boundary faces are merged along x runs of cells, which simplifies the mesh without
+	moving its surface.  Meshes are stored in the legacy Neuroglancer precomputed format
+	with vertices in voxel coordinates, and are deleted when their label changes.
+*/
+
+package labels64
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MaxMeshLOD is the coarsest level of detail for label meshes.
+const MaxMeshLOD = 5
+
+// meshBuilder accumulates triangles with shared vertices.
+type meshBuilder struct {
+	index     map[[3]int32]uint32
+	vertices  [][3]int32
+	triangles []uint32
+}
+
+func newMeshBuilder() *meshBuilder {
+	return &meshBuilder{index: make(map[[3]int32]uint32)}
+}
+
+func (m *meshBuilder) vertex(pt [3]int32) uint32 {
+	i, found := m.index[pt]
+	if !found {
+		i = uint32(len(m.vertices))
+		m.index[pt] = i
+		m.vertices = append(m.vertices, pt)
+	}
+	return i
+}
+
+// quad adds a rectangle as two triangles given its corners in counterclockwise order
+// when viewed from the side it faces, or clockwise order if reverse is true.
+func (m *meshBuilder) quad(corners [4][3]int32, reverse bool) {
+	var v [4]uint32
+	for i, corner := range corners {
+		v[i] = m.vertex(corner)
+	}
+	if reverse {
+		v[1], v[3] = v[3], v[1]
+	}
+	m.triangles = append(m.triangles, v[0], v[1], v[2], v[0], v[2], v[3])
+}
+
+// encode returns the mesh in the legacy precomputed format: a uint32 number of vertices,
+// float32 vertex coordinates, then uint32 triangle vertex indices, all little-endian.
+// Cell corners are scaled by the cell size and shifted so voxel centers are at integer
+// coordinates.
+func (m *meshBuilder) encode(cellSize int32) []byte {
+	mesh := make([]byte, 4+12*len(m.vertices)+4*len(m.triangles))
+	binary.LittleEndian.PutUint32(mesh[0:4], uint32(len(m.vertices)))
+	offset := 4
+	for _, v := range m.vertices {
+		for dim := 0; dim < 3; dim++ {
+			coord := float32(v[dim]*cellSize) - 0.5
+			binary.LittleEndian.PutUint32(mesh[offset:], math.Float32bits(coord))
+			offset += 4
+		}
+	}
+	for _, i := range m.triangles {
+		binary.LittleEndian.PutUint32(mesh[offset:], i)
+		offset += 4
+	}
+	return mesh
+}
+
+// labelCells returns the x intervals of cells, 2^lod voxels on a side, that hold any
+// voxels of the runs, for each (y, z) row of cells.  The intervals of each row are sorted
+// and disjoint.
+func labelCells(rles blockRLEs, lod uint8) rowRuns {
+	rows := make(rowRuns)
+	for _, blockRLEs := range rles {
+		for _, rle := range blockRLEs {
+			start := rle.StartPt()
+			row := [2]int32{start[1] >> lod, start[2] >> lod}
+			x1 := start[0] + rle.Length() - 1
+			rows[row] = append(rows[row], interval{start[0] >> lod, x1 >> lod})
+		}
+	}
+	for row, runs := range rows {
+		sort.Sort(runs)
+		merged := runs[:1]
+		for _, run := range runs[1:] {
+			last := &merged[len(merged)-1]
+			if run.x0 <= last.x1+1 {
+				if run.x1 > last.x1 {
+					last.x1 = run.x1
+				}
+			} else {
+				merged = append(merged, run)
+			}
+		}
+		rows[row] = merged
+	}
+	return rows
+}
+
+// buildLabelMesh returns the mesh of the boundary of a label's cells at a level of detail.
+func buildLabelMesh(rles blockRLEs, lod uint8) []byte {
+	rows := labelCells(rles, lod)
+	sorted := make([][2]int32, 0, len(rows))
+	for row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Sort(rowOrder(sorted))
+
+	m := newMeshBuilder()
+	for _, row := range sorted {
+		y, z := row[0], row[1]
+		runs := rows[row]
+		for _, run := range runs {
+			x0, x1 := run.x0, run.x1+1
+			m.quad([4][3]int32{{x1, y, z}, {x1, y + 1, z}, {x1, y + 1, z + 1}, {x1, y, z + 1}}, false)
+			m.quad([4][3]int32{{x0, y, z}, {x0, y + 1, z}, {x0, y + 1, z + 1}, {x0, y, z + 1}}, true)
+		}
+		for _, run := range subtractIntervals(runs, rows[[2]int32{y + 1, z}]) {
+			x0, x1 := run.x0, run.x1+1
+			m.quad([4][3]int32{{x0, y + 1, z}, {x0, y + 1, z + 1}, {x1, y + 1, z + 1}, {x1, y + 1, z}}, false)
+		}
+		for _, run := range subtractIntervals(runs, rows[[2]int32{y - 1, z}]) {
+			x0, x1 := run.x0, run.x1+1
+			m.quad([4][3]int32{{x0, y, z}, {x0, y, z + 1}, {x1, y, z + 1}, {x1, y, z}}, true)
+		}
+		for _, run := range subtractIntervals(runs, rows[[2]int32{y, z + 1}]) {
+			x0, x1 := run.x0, run.x1+1
+			m.quad([4][3]int32{{x0, y, z + 1}, {x1, y, z + 1}, {x1, y + 1, z + 1}, {x0, y + 1, z + 1}}, false)
+		}
+		for _, run := range subtractIntervals(runs, rows[[2]int32{y, z - 1}]) {
+			x0, x1 := run.x0, run.x1+1
+			m.quad([4][3]int32{{x0, y, z}, {x1, y, z}, {x1, y + 1, z}, {x0, y + 1, z}}, true)
+		}
+	}
+	return m.encode(int32(1) << lod)
+}
+
+// GenerateMesh computes and stores the mesh of a label at a level of detail, returning
+// false if the label has no voxels.
+func (d *Data) GenerateMesh(ctx *datastore.VersionedContext, label uint64, lod uint8) ([]byte, bool, error) {
+	if lod > MaxMeshLOD {
+		return nil, false, fmt.Errorf("Mesh level of detail must be from 0 to %d, not %d", MaxMeshLOD, lod)
+	}
+	rles, err := getLabelRLEs(ctx, label)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rles) == 0 {
+		return nil, false, nil
+	}
+	mesh := buildLabelMesh(rles, lod)
+
+	blobs, err := storage.BlobDataStore()
+	if err != nil {
+		return nil, false, err
+	}
+	compression, _ := dvid.NewCompression(dvid.Gzip, dvid.DefaultCompression)
+	serialization, err := dvid.SerializeData(mesh, compression, dvid.NoChecksum)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to serialize mesh of label %d: %s", label, err.Error())
+	}
+	if err := blobs.Put(ctx, voxels.NewLabelMeshIndex(label, lod), serialization); err != nil {
+		return nil, false, err
+	}
+	return mesh, true, nil
+}
+
+// GetMesh returns the stored mesh of a label at a level of detail with vertices in voxel
+// coordinates.  If the mesh isn't stored and generate is true, it's generated and stored.
+// Returns false if there is no mesh.
+func (d *Data) GetMesh(ctx *datastore.VersionedContext, label uint64, lod uint8, generate bool) ([]byte, bool, error) {
+	blobs, err := storage.BlobDataStore()
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot get datastore that handles blobs: %s\n", err.Error())
+	}
+	data, err := blobs.Get(ctx, voxels.NewLabelMeshIndex(label, lod))
+	if err != nil {
+		return nil, false, fmt.Errorf("Error in retrieving mesh for label %d: %s", label, err.Error())
+	}
+	if data == nil {
+		if !generate {
+			return nil, false, nil
+		}
+		return d.GenerateMesh(ctx, label, lod)
+	}
+	mesh, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to deserialize mesh for label %d: %s\n", label, err.Error())
+	}
+	return mesh, true, nil
+}
+
+// deleteMeshes deletes the stored meshes of a label at all levels of detail.
+func deleteMeshes(ctx storage.Context, label uint64) error {
+	blobs, err := storage.BlobDataStore()
+	if err != nil {
+		return err
+	}
+	for lod := uint8(0); lod <= MaxMeshLOD; lod++ {
+		if err := blobs.Delete(ctx, voxels.NewLabelMeshIndex(label, lod)); err != nil {
+			return fmt.Errorf("Can't delete label %d mesh: %s", label, err.Error())
+		}
+	}
+	return nil
+}
+
+// parseMeshLODs parses a comma-separated list of levels of detail.
+func parseMeshLODs(s string) ([]uint8, error) {
+	var lods []uint8
+	for _, lodStr := range strings.Split(s, ",") {
+		lod, err := strconv.ParseUint(lodStr, 10, 8)
+		if err != nil || lod > MaxMeshLOD {
+			return nil, fmt.Errorf("Mesh level of detail must be from 0 to %d, not %q", MaxMeshLOD, lodStr)
+		}
+		lods = append(lods, uint8(lod))
+	}
+	return lods, nil
+}
+
+// GenerateMeshes generates and stores meshes at the given levels of detail for listed
+// labels, or if none are listed, all labels with at least a minimum number of voxels.
+// Unless the request is already run as a background job, it's started as one and its job
+// ID is returned.
+func (d *Data) GenerateMeshes(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, lodsStr string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &lodsStr)
+
+	lods, err := parseMeshLODs(lodsStr)
+	if err != nil {
+		return err
+	}
+	config := request.Settings()
+	var labels []uint64
+	labelsStr, found, err := config.GetString("labels")
+	if err != nil {
+		return err
+	}
+	if found {
+		for _, labelStr := range strings.Split(labelsStr, ",") {
+			label, err := strconv.ParseUint(labelStr, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Bad label %q in labels setting: %s", labelStr, err.Error())
+			}
+			labels = append(labels, label)
+		}
+	}
+	var minSize uint64
+	minSizeStr, found, err := config.GetString("minsize")
+	if err != nil {
+		return err
+	}
+	if found {
+		if minSize, err = strconv.ParseUint(minSizeStr, 10, 64); err != nil {
+			return fmt.Errorf("Bad minsize setting %q: %s", minSizeStr, err.Error())
+		}
+	}
+
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	if request.InJob() {
+		reply.Text, err = d.generateMeshes(request, versionID, lods, labels, minSize)
+		return err
+	}
+	job, err := server.StartJob(request.Command.String(), "rpc", func(handle *server.JobHandle) (string, error) {
+		request.SetJob(handle)
+		return d.generateMeshes(request, versionID, lods, labels, minSize)
+	})
+	if err != nil {
+		return err
+	}
+	reply.Text = fmt.Sprintf("Started job %s to generate meshes for %q, version %s.  Check its progress via /api/server/jobs/%s\n",
+		job.ID, d.DataName(), uuid, job.ID)
+	return nil
+}
+
+func (d *Data) generateMeshes(request datastore.Request, versionID dvid.VersionID, lods []uint8, labels []uint64, minSize uint64) (string, error) {
+	timedLog := dvid.NewTimeLog()
+	ctx := datastore.NewVersionedContext(d, versionID)
+	ctx.SetDone(request.Done())
+
+	if len(labels) == 0 {
+		page, err := GetLabelSizes(ctx, minSize, 0, 0, nil)
+		if err != nil {
+			return "", err
+		}
+		for _, ls := range page.Labels {
+			labels = append(labels, ls.Label)
+		}
+	}
+	request.JobLogf("Generating meshes at levels of detail %v for %d labels of %q\n", lods, len(labels), d.DataName())
+
+	var numMeshes int
+	for i, label := range labels {
+		if request.Canceled() {
+			return "", fmt.Errorf("Mesh generation canceled after %d of %d labels", i, len(labels))
+		}
+		for _, lod := range lods {
+			_, found, err := d.GenerateMesh(ctx, label, lod)
+			if err != nil {
+				return "", fmt.Errorf("Error generating mesh for label %d: %s", label, err.Error())
+			}
+			if found {
+				numMeshes++
+			}
+		}
+		request.Progress(100 * float64(i+1) / float64(len(labels)))
+	}
+	timedLog.Infof("Generated %d meshes for %d labels of %q", numMeshes, len(labels), d.DataName())
+	return fmt.Sprintf("Generated %d meshes for %d labels", numMeshes, len(labels)), nil
+}
@@ -0,0 +1,66 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func checkMeshSize(t *testing.T, mesh []byte, numVertices, numTriangles int) {
+	if len(mesh) < 4 {
+		t.Fatalf("Mesh too short: %d bytes\n", len(mesh))
+	}
+	if n := int(binary.LittleEndian.Uint32(mesh[0:4])); n != numVertices {
+		t.Errorf("Expected %d vertices, got %d\n", numVertices, n)
+	}
+	if n := (len(mesh) - 4 - 12*numVertices) / 12; n != numTriangles {
+		t.Errorf("Expected %d triangles, got %d\n", numTriangles, n)
+	}
+}
+
+func TestBuildLabelMesh(t *testing.T) {
+	voxel := blockRLEs{"a": dvid.RLEs{dvid.NewRLE(dvid.Point3d{3, 4, 5}, 1)}}
+	mesh := buildLabelMesh(voxel, 0)
+	checkMeshSize(t, mesh, 8, 12)
+	x := math.Float32frombits(binary.LittleEndian.Uint32(mesh[4:8]))
+	if x != 3.5 && x != 2.5 {
+		t.Errorf("Expected vertex on voxel boundary, got x = %f\n", x)
+	}
+
+	// Faces along a run are merged into single rectangles.
+	run := blockRLEs{"a": dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 0, 0}, 10)}}
+	checkMeshSize(t, buildLabelMesh(run, 0), 8, 12)
+
+	// Two stacked runs share no faces between them.
+	stacked := blockRLEs{
+		"a": dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 0, 0}, 2)},
+		"b": dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 1, 0}, 2)},
+	}
+	checkMeshSize(t, buildLabelMesh(stacked, 0), 12, 20)
+
+	// Downsampling puts a 2x2x2 cube into one cell.
+	cube := blockRLEs{}
+	for z := int32(0); z < 2; z++ {
+		for y := int32(0); y < 2; y++ {
+			cube["a"] = append(cube["a"], dvid.NewRLE(dvid.Point3d{0, y, z}, 2))
+		}
+	}
+	checkMeshSize(t, buildLabelMesh(cube, 1), 8, 12)
+}
+
+func TestParseMeshLODs(t *testing.T) {
+	lods, err := parseMeshLODs("0,2,5")
+	if err != nil {
+		t.Fatalf("Error parsing levels of detail: %s\n", err.Error())
+	}
+	if len(lods) != 3 || lods[0] != 0 || lods[1] != 2 || lods[2] != 5 {
+		t.Errorf("Expected levels of detail [0 2 5], got %v\n", lods)
+	}
+	for _, bad := range []string{"6", "a", "1,,2", ""} {
+		if _, err := parseMeshLODs(bad); err == nil {
+			t.Errorf("Expected error parsing levels of detail %q\n", bad)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+	This file provides label meshes for the Neuroglancer precomputed format served by the
+	voxels package.  Stored label surfaces are sets of surface voxels with normals rather
+	than triangle meshes, so each surface voxel is rendered as a voxel-sized square facing
+	along its normal.
+*/
+
+package labels64
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// PrecomputedMesh returns a label's mesh in the legacy precomputed format with vertices
+// in voxel coordinates.  A stored full resolution mesh is used if present, else the mesh
+// is converted from the label's surface.
+func (d *Data) PrecomputedMesh(ctx *datastore.VersionedContext, label uint64) ([]byte, bool, error) {
+	if mesh, found, err := d.GetMesh(ctx, label, 0, false); err != nil || found {
+		return mesh, found, err
+	}
+	blobs, err := storage.BlobDataStore()
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot get datastore that handles blobs: %s\n", err.Error())
+	}
+	data, err := blobs.Get(ctx, voxels.NewLabelSurfaceIndex(label))
+	if err != nil {
+		return nil, false, fmt.Errorf("Error in retrieving surface for label %d: %s", label, err.Error())
+	}
+	if data == nil {
+		return nil, false, nil
+	}
+	surface, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to deserialize surface for label %d: %s\n", label, err.Error())
+	}
+	mesh, err := surfaceToMesh(surface)
+	if err != nil {
+		return nil, false, fmt.Errorf("Bad surface for label %d: %s", label, err.Error())
+	}
+	return mesh, true, nil
+}
+
+// surfaceToMesh converts a serialized surface into a legacy precomputed mesh: a uint32
+// number of vertices, float32 vertex coordinates, then uint32 triangle vertex indices,
+// all little-endian.  Each surface voxel becomes a square of two triangles.
+func surfaceToMesh(surface []byte) ([]byte, error) {
+	if len(surface) < 4 {
+		return nil, fmt.Errorf("Surface has only %d bytes", len(surface))
+	}
+	n := binary.LittleEndian.Uint32(surface[0:4])
+	if uint64(len(surface)) != 4+24*uint64(n) {
+		return nil, fmt.Errorf("Surface with %d voxels has %d bytes", n, len(surface))
+	}
+	vertexData := surface[4 : 4+12*n]
+	normalData := surface[4+12*n:]
+
+	numVertices := 4 * n
+	mesh := make([]byte, 4+12*numVertices+24*n)
+	binary.LittleEndian.PutUint32(mesh[0:4], numVertices)
+	vertices := mesh[4 : 4+12*numVertices]
+	triangles := mesh[4+12*numVertices:]
+	for i := uint32(0); i < n; i++ {
+		var v, normal [3]float64
+		for dim := uint32(0); dim < 3; dim++ {
+			v[dim] = float64(float32FromBytes(vertexData[i*12+dim*4:]))
+			normal[dim] = float64(float32FromBytes(normalData[i*12+dim*4:]))
+		}
+		u, w := squareAxes(normal)
+		for corner, sign := range [4][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+			for dim := 0; dim < 3; dim++ {
+				coord := v[dim] + 0.5*(sign[0]*u[dim]+sign[1]*w[dim])
+				offset := (4*i+uint32(corner))*12 + uint32(dim)*4
+				binary.LittleEndian.PutUint32(vertices[offset:], math.Float32bits(float32(coord)))
+			}
+		}
+		for j, corner := range []uint32{0, 1, 2, 0, 2, 3} {
+			binary.LittleEndian.PutUint32(triangles[i*24+uint32(j)*4:], 4*i+corner)
+		}
+	}
+	return mesh, nil
+}
+
+// squareAxes returns two orthogonal unit vectors perpendicular to a normal.  A zero
+// normal is treated as pointing along z.
+func squareAxes(normal [3]float64) (u, w [3]float64) {
+	length := math.Sqrt(normal[0]*normal[0] + normal[1]*normal[1] + normal[2]*normal[2])
+	if length == 0 {
+		return [3]float64{1, 0, 0}, [3]float64{0, 1, 0}
+	}
+	for dim := range normal {
+		normal[dim] /= length
+	}
+
+	// Cross with the axis least aligned with the normal.
+	var axis [3]float64
+	least := 0
+	for dim := 1; dim < 3; dim++ {
+		if math.Abs(normal[dim]) < math.Abs(normal[least]) {
+			least = dim
+		}
+	}
+	axis[least] = 1
+	u = cross(normal, axis)
+	length = math.Sqrt(u[0]*u[0] + u[1]*u[1] + u[2]*u[2])
+	for dim := range u {
+		u[dim] /= length
+	}
+	return u, cross(normal, u)
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
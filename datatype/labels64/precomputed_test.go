@@ -0,0 +1,54 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestSurfaceToMesh(t *testing.T) {
+	surface := encodeSurface([][3]float32{{1, 2, 3}, {10, 20, 30}})
+	mesh, err := surfaceToMesh(surface)
+	if err != nil {
+		t.Fatalf("Unable to convert surface to mesh: %s\n", err.Error())
+	}
+	if n := binary.LittleEndian.Uint32(mesh[0:4]); n != 8 {
+		t.Fatalf("Expected 8 mesh vertices, got %d\n", n)
+	}
+	if len(mesh) != 4+8*12+4*6*2 {
+		t.Fatalf("Bad mesh size: %d bytes\n", len(mesh))
+	}
+
+	// Each square is centered on its voxel and perpendicular to the normal, which is the
+	// negated vertex in the encoded test surface.
+	for i, center := range [][3]float64{{1, 2, 3}, {10, 20, 30}} {
+		var sum [3]float64
+		for corner := 0; corner < 4; corner++ {
+			var d [3]float64
+			for dim := 0; dim < 3; dim++ {
+				offset := 4 + ((4*i+corner)*3+dim)*4
+				coord := float64(math.Float32frombits(binary.LittleEndian.Uint32(mesh[offset:])))
+				sum[dim] += coord
+				d[dim] = coord - center[dim]
+			}
+			if dot := d[0]*center[0] + d[1]*center[1] + d[2]*center[2]; math.Abs(dot) > 1e-3 {
+				t.Errorf("Square corner %d of voxel %d isn't perpendicular to normal\n", corner, i)
+			}
+		}
+		for dim := 0; dim < 3; dim++ {
+			if math.Abs(sum[dim]/4-center[dim]) > 1e-4 {
+				t.Errorf("Square of voxel %d isn't centered on it\n", i)
+			}
+		}
+	}
+	triangles := mesh[4+8*12:]
+	for j, expected := range []uint32{4, 5, 6, 4, 6, 7} {
+		if got := binary.LittleEndian.Uint32(triangles[24+j*4:]); got != expected {
+			t.Errorf("Bad triangle index %d of second square: expected %d, got %d\n", j, expected, got)
+		}
+	}
+
+	if _, err := surfaceToMesh(surface[:len(surface)-4]); err == nil {
+		t.Errorf("Expected error converting truncated surface\n")
+	}
+}
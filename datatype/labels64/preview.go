@@ -0,0 +1,424 @@
+/*
+	This file supports preview instances: labels64 instances that mirror a labels64
+	instance downsampled by 2^scale so whole-dataset overviews and coarse analyses can
+	read a small volume.  Preview blocks have the same size as the full resolution blocks,
+	so each preview block covers 2^scale blocks along each axis.  After label blocks
+	change, the covering preview blocks are queued and recomputed by a background worker.
+	Updates for a preview block that arrive while it is queued are coalesced.
+*/
+
+package labels64
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+type previewKey struct {
+	instanceID dvid.InstanceID
+	versionID  dvid.VersionID
+}
+
+type previewJob struct {
+	d      *Data
+	blocks map[dvid.IndexZYX]bool
+}
+
+type previewUpdater struct {
+	sync.Mutex
+	cond    *sync.Cond
+	started bool
+
+	// queue holds keys that are pending and not active, in order of arrival.
+	queue   []previewKey
+	pending map[previewKey]*previewJob
+	active  map[previewKey]bool
+}
+
+var previews = newPreviewUpdater()
+
+func newPreviewUpdater() *previewUpdater {
+	p := &previewUpdater{
+		pending: make(map[previewKey]*previewJob),
+		active:  make(map[previewKey]bool),
+	}
+	p.cond = sync.NewCond(&p.Mutex)
+	return p
+}
+
+// add queues preview blocks for recomputation.
+func (p *previewUpdater) add(d *Data, versionID dvid.VersionID, blocks map[dvid.IndexZYX]bool) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.started {
+		go p.worker()
+		p.started = true
+	}
+	key := previewKey{d.InstanceID(), versionID}
+	job, found := p.pending[key]
+	if !found {
+		job = &previewJob{d: d, blocks: make(map[dvid.IndexZYX]bool, len(blocks))}
+		p.pending[key] = job
+		if !p.active[key] {
+			p.queue = append(p.queue, key)
+			p.cond.Signal()
+		}
+	}
+	for index := range blocks {
+		job.blocks[index] = true
+	}
+}
+
+func (p *previewUpdater) worker() {
+	for {
+		p.Lock()
+		for len(p.queue) == 0 {
+			p.cond.Wait()
+		}
+		key := p.queue[0]
+		p.queue = p.queue[1:]
+		job := p.pending[key]
+		delete(p.pending, key)
+		p.active[key] = true
+		p.Unlock()
+
+		if err := job.d.updatePreview(key.versionID, job.blocks); err != nil {
+			dvid.Errorf("Error updating preview of %q: %s\n", job.d.DataName(), err.Error())
+		}
+
+		p.Lock()
+		delete(p.active, key)
+		// Updates that arrived while this version was active are now runnable.
+		if _, found := p.pending[key]; found {
+			p.queue = append(p.queue, key)
+			p.cond.Signal()
+		}
+		p.Unlock()
+	}
+}
+
+// forget drops queued preview updates for a data instance.  Updates that are already
+// active are allowed to finish.
+func (p *previewUpdater) forget(instanceID dvid.InstanceID) {
+	p.Lock()
+	defer p.Unlock()
+	queue := p.queue[:0]
+	for _, key := range p.queue {
+		if key.instanceID != instanceID {
+			queue = append(queue, key)
+		}
+	}
+	p.queue = queue
+	for key := range p.pending {
+		if key.instanceID == instanceID {
+			delete(p.pending, key)
+		}
+	}
+}
+
+// previewIndex returns the index of the preview block covering a full resolution block.
+// Arithmetic shift gives floor division for negative block coordinates.
+func previewIndex(index dvid.IndexZYX, scale uint8) dvid.IndexZYX {
+	return dvid.IndexZYX{index[0] >> scale, index[1] >> scale, index[2] >> scale}
+}
+
+// queuePreviewUpdate schedules recomputation of the preview blocks covering the given
+// blocks, in string format.  It does nothing if the data has no preview.
+func (d *Data) queuePreviewUpdate(versionID dvid.VersionID, blocks map[string]bool) {
+	if d.Preview == "" || len(blocks) == 0 {
+		return
+	}
+	indices := make(map[dvid.IndexZYX]bool)
+	for blockStr := range blocks {
+		var index dvid.IndexZYX
+		if err := index.IndexFromBytes([]byte(blockStr)); err != nil {
+			dvid.Errorf("Bad block index queued for preview of %q: %s\n", d.DataName(), err.Error())
+			continue
+		}
+		indices[previewIndex(index, d.PreviewScale)] = true
+	}
+	previews.add(d, versionID, indices)
+}
+
+// getPreview returns the preview instance of the data.
+func (d *Data) getPreview(versionID dvid.VersionID) (*Data, error) {
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	dataservice, err := repo.GetDataByName(d.Preview)
+	if err != nil {
+		return nil, err
+	}
+	preview, ok := dataservice.(*Data)
+	if !ok {
+		return nil, fmt.Errorf("Preview %q of %q is not labels64 data", d.Preview, d.DataName())
+	}
+	return preview, nil
+}
+
+// updatePreview recomputes preview blocks and saves any change to the preview extents.
+func (d *Data) updatePreview(versionID dvid.VersionID, blocks map[dvid.IndexZYX]bool) error {
+	preview, err := d.getPreview(versionID)
+	if err != nil {
+		return err
+	}
+	var extentChanged bool
+	for index := range blocks {
+		changed, err := d.computePreviewBlock(versionID, preview, index)
+		if err != nil {
+			return err
+		}
+		extentChanged = extentChanged || changed
+		server.BlockOnInteractiveRequests("labels64 [preview update]")
+	}
+	if extentChanged {
+		return datastore.SaveRepoByVersionID(versionID)
+	}
+	return nil
+}
+
+// computePreviewBlock recomputes a preview block from the full resolution blocks it
+// covers, deleting it if none of them are stored.  It returns true if the preview
+// extents changed.
+func (d *Data) computePreviewBlock(versionID dvid.VersionID, preview *Data, index dvid.IndexZYX) (bool, error) {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return false, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+	previewCtx := datastore.NewVersionedContext(preview, versionID)
+
+	blockSize := d.BlockSize().(dvid.Point3d)
+	blockBytes := int(blockSize.Prod() * 8)
+	factor := int32(1) << d.PreviewScale
+	block := make([]byte, blockBytes)
+	var found bool
+	for oz := int32(0); oz < factor; oz++ {
+		for oy := int32(0); oy < factor; oy++ {
+			beg := dvid.IndexZYX{index[0] * factor, index[1]*factor + oy, index[2]*factor + oz}
+			end := dvid.IndexZYX{beg[0] + factor - 1, beg[1], beg[2]}
+			kvs, err := bigdata.GetRange(ctx, voxels.NewVoxelBlockIndex(&beg), voxels.NewVoxelBlockIndex(&end))
+			if err != nil {
+				return false, err
+			}
+			for _, kv := range kvs {
+				src, err := voxels.DecodeVoxelBlockKey(kv.K)
+				if err != nil {
+					return false, err
+				}
+				data, _, err := dvid.DeserializeData(kv.V, true)
+				if err != nil {
+					return false, fmt.Errorf("Unable to deserialize block %s: %s", src, err.Error())
+				}
+				if len(data) != blockBytes {
+					return false, fmt.Errorf("Block %s has %d bytes, expected %d bytes", src, len(data), blockBytes)
+				}
+				offset := dvid.Point3d{src[0] - beg[0], oy, oz}
+				sampleBlock(block, data, blockSize, offset, factor)
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return false, bigdata.Delete(previewCtx, voxels.NewVoxelBlockIndex(&index))
+	}
+	serialization, err := dvid.SerializeData(block, preview.Compression(), preview.Checksum())
+	if err != nil {
+		return false, fmt.Errorf("Unable to serialize preview block %s: %s", index, err.Error())
+	}
+	if err := bigdata.Put(previewCtx, voxels.NewVoxelBlockIndex(&index), serialization); err != nil {
+		return false, err
+	}
+	extents := preview.Extents()
+	pointsChanged := extents.AdjustPoints(index.MinPoint(blockSize), index.MaxPoint(blockSize))
+	minIndex, maxIndex := index, index
+	indicesChanged := extents.AdjustIndices(&minIndex, &maxIndex)
+	return pointsChanged || indicesChanged, nil
+}
+
+// sampleBlock reduces a source block by the given factor along each axis and writes the
+// result into the destination block, which has the same size as the source, at the
+// given offset in units of reduced blocks.  Each destination voxel gets the label at the
+// center of the corresponding source neighborhood.
+func sampleBlock(dst, src []byte, blockSize, offset dvid.Point3d, factor int32) {
+	nx, ny, nz := blockSize[0], blockSize[1], blockSize[2]
+	rx, ry, rz := nx/factor, ny/factor, nz/factor
+	half := factor / 2
+	for z := int32(0); z < rz; z++ {
+		srcZ, dstZ := z*factor+half, offset[2]*rz+z
+		for y := int32(0); y < ry; y++ {
+			srcY, dstY := y*factor+half, offset[1]*ry+y
+			for x := int32(0); x < rx; x++ {
+				srcX, dstX := x*factor+half, offset[0]*rx+x
+				i := ((srcZ*ny+srcY)*nx + srcX) * 8
+				j := ((dstZ*ny+dstY)*nx + dstX) * 8
+				copy(dst[j:j+8], src[i:i+8])
+			}
+		}
+	}
+}
+
+// CreatePreview creates a labels64 instance that mirrors the labels downsampled by a
+// scale level and launches a job that fills it.  Afterwards the preview is updated in
+// the background as labels change.
+func (d *Data) CreatePreview(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, previewName, scaleStr string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &previewName, &scaleStr)
+
+	if d.Preview != "" {
+		return fmt.Errorf("Data %q already has preview %q", d.DataName(), d.Preview)
+	}
+	scale, err := strconv.Atoi(scaleStr)
+	if err != nil || scale < 1 || scale > voxels.MaxScaleLevel {
+		return fmt.Errorf("Preview scale must be an integer from 1 to %d, got %q", voxels.MaxScaleLevel, scaleStr)
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return fmt.Errorf("Previews only supported for 3d blocks, not %s", d.BlockSize())
+	}
+	factor := int32(1) << uint8(scale)
+	for dim := uint8(0); dim < 3; dim++ {
+		if blockSize.Value(dim)%factor != 0 {
+			return fmt.Errorf("Block size %s must be divisible by %d for a scale %d preview", blockSize, factor, scale)
+		}
+	}
+
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	// Create the preview with the same block size and coarser resolution.
+	if _, err := repo.GetDataByName(dvid.DataString(previewName)); err == nil {
+		return fmt.Errorf("Data instance with name %q already exists", previewName)
+	}
+	typeService, err := datastore.TypeServiceByName("labels64")
+	if err != nil {
+		return fmt.Errorf("Could not get labels64 type service from DVID")
+	}
+	voxelSize := make([]string, len(d.Properties.Resolution.VoxelSize))
+	for i, res := range d.Properties.Resolution.VoxelSize {
+		voxelSize[i] = strconv.FormatFloat(float64(res*float32(factor)), 'f', -1, 32)
+	}
+	config := dvid.NewConfig()
+	config.Set("BlockSize", fmt.Sprintf("%d,%d,%d", blockSize[0], blockSize[1], blockSize[2]))
+	config.Set("VoxelSize", strings.Join(voxelSize, ","))
+	config.Set("VoxelUnits", strings.Join(d.Properties.Resolution.VoxelUnits, ","))
+	dataservice, err := repo.NewData(typeService, dvid.DataString(previewName), config)
+	if err != nil {
+		return err
+	}
+	preview, ok := dataservice.(*Data)
+	if !ok {
+		return fmt.Errorf("Error: %s was unable to be set to labels64 data", previewName)
+	}
+
+	// Link the preview first so mutations during the initial fill are queued.
+	d.Preview = dvid.DataString(previewName)
+	d.PreviewScale = uint8(scale)
+	if err := repo.Save(); err != nil {
+		return err
+	}
+
+	if request.InJob() {
+		reply.Text, err = d.fillPreview(request, versionID, preview)
+		return err
+	}
+	job, err := server.StartJob(request.Command.String(), "rpc", func(handle *server.JobHandle) (string, error) {
+		request.SetJob(handle)
+		return d.fillPreview(request, versionID, preview)
+	})
+	if err != nil {
+		return err
+	}
+	reply.Text = fmt.Sprintf("Started job %s to fill scale %d preview %q of %q, version %s.  Check its progress via /api/server/jobs/%s\n",
+		job.ID, scale, previewName, d.DataName(), uuid, job.ID)
+	return nil
+}
+
+// fillPreview computes all preview blocks covering the stored labels, a z slab of preview
+// blocks at a time.
+func (d *Data) fillPreview(request datastore.Request, versionID dvid.VersionID, preview *Data) (string, error) {
+	timedLog := dvid.NewTimeLog()
+
+	extents := d.Extents()
+	if extents.MinIndex == nil || extents.MaxIndex == nil {
+		return fmt.Sprintf("No labels stored in %q so preview %q is empty\n", d.DataName(), preview.DataName()), nil
+	}
+	minIndex, ok := extents.MinIndex.(*dvid.IndexZYX)
+	if !ok {
+		return "", fmt.Errorf("Previews only supported for ZYX block indices, not %s", extents.MinIndex)
+	}
+	maxIndex, ok := extents.MaxIndex.(*dvid.IndexZYX)
+	if !ok {
+		return "", fmt.Errorf("Previews only supported for ZYX block indices, not %s", extents.MaxIndex)
+	}
+	begIndex := previewIndex(*minIndex, d.PreviewScale)
+	endIndex := previewIndex(*maxIndex, d.PreviewScale)
+	total := int(endIndex[2] - begIndex[2] + 1)
+	request.JobLogf("Filling preview %q of %q, %d slabs of blocks\n", preview.DataName(), d.DataName(), total)
+
+	var mu sync.Mutex
+	var workErr error
+	var computed int
+	for z := begIndex[2]; z <= endIndex[2]; z++ {
+		if request.Canceled() {
+			return "", fmt.Errorf("Fill of preview %q canceled after %d of %d slabs of blocks",
+				preview.DataName(), z-begIndex[2], total)
+		}
+		wg := new(sync.WaitGroup)
+		for y := begIndex[1]; y <= endIndex[1]; y++ {
+			for x := begIndex[0]; x <= endIndex[0]; x++ {
+				<-server.HandlerToken
+				wg.Add(1)
+				go func(index dvid.IndexZYX) {
+					defer func() {
+						server.HandlerToken <- 1
+						wg.Done()
+					}()
+					_, err := d.computePreviewBlock(versionID, preview, index)
+					mu.Lock()
+					if err != nil && workErr == nil {
+						workErr = fmt.Errorf("Unable to compute preview block %s: %s", index, err.Error())
+					}
+					computed++
+					mu.Unlock()
+				}(dvid.IndexZYX{x, y, z})
+			}
+		}
+		wg.Wait()
+		if workErr != nil {
+			return "", workErr
+		}
+		request.Progress(100 * float64(z-begIndex[2]+1) / float64(total))
+		server.BlockOnInteractiveRequests("labels64 [preview fill]")
+	}
+	if err := datastore.SaveRepoByVersionID(versionID); err != nil {
+		return "", err
+	}
+
+	timedLog.Infof("Filled preview %q of %q with %d computed blocks", preview.DataName(), d.DataName(), computed)
+	return fmt.Sprintf("Computed %d blocks of scale %d preview %q of %q\n",
+		computed, d.PreviewScale, preview.DataName(), d.DataName()), nil
+}
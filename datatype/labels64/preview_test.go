@@ -0,0 +1,51 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestSampleBlock(t *testing.T) {
+	blockSize := dvid.Point3d{4, 4, 4}
+	src := make([]byte, 4*4*4*8)
+	for i := 0; i < 64; i++ {
+		binary.LittleEndian.PutUint64(src[i*8:], uint64(i+1))
+	}
+	dst := make([]byte, len(src))
+	sampleBlock(dst, src, blockSize, dvid.Point3d{1, 0, 1}, 2)
+
+	for z := int32(0); z < 4; z++ {
+		for y := int32(0); y < 4; y++ {
+			for x := int32(0); x < 4; x++ {
+				var expected uint64
+				if x >= 2 && y < 2 && z >= 2 {
+					// Center of each 2x2x2 source neighborhood.
+					sx, sy, sz := (x-2)*2+1, y*2+1, (z-2)*2+1
+					expected = uint64((sz*4+sy)*4+sx) + 1
+				}
+				i := ((z*4+y)*4 + x) * 8
+				if got := binary.LittleEndian.Uint64(dst[i : i+8]); got != expected {
+					t.Fatalf("Bad preview label at (%d,%d,%d): expected %d, got %d\n", x, y, z, expected, got)
+				}
+			}
+		}
+	}
+}
+
+func TestPreviewIndex(t *testing.T) {
+	for _, tc := range []struct {
+		index    dvid.IndexZYX
+		scale    uint8
+		expected dvid.IndexZYX
+	}{
+		{dvid.IndexZYX{0, 1, 7}, 1, dvid.IndexZYX{0, 0, 3}},
+		{dvid.IndexZYX{-1, -8, 9}, 3, dvid.IndexZYX{-1, -1, 1}},
+	} {
+		if got := previewIndex(tc.index, tc.scale); got != tc.expected {
+			t.Errorf("Bad preview index for %s at scale %d: expected %s, got %s\n",
+				tc.index, tc.scale, tc.expected, got)
+		}
+	}
+}
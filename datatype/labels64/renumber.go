@@ -0,0 +1,173 @@
+/*
+	This file supports export of label subvolumes renumbered to dense sequential labels,
+	since many analysis tools require small contiguous label ranges rather than sparse
+	64-bit labels.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+type labelSlice []uint64
+
+func (s labelSlice) Len() int           { return len(s) }
+func (s labelSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s labelSlice) Less(i, j int) bool { return s[i] < s[j] }
+
+// renumberLabels returns the distinct non-zero labels of packed little-endian 64-bit
+// label data in ascending order and the data renumbered so the i-th label (starting
+// from 1) becomes i.  Label 0 is kept as background.  The renumbered labels are packed
+// with the given bytes per voxel or, if zero, the fewest bytes that hold all labels.
+// The bytes per voxel used is returned.
+func renumberLabels(data []byte, width int) (labels []uint64, renumbered []byte, used int, err error) {
+	if len(data)%8 != 0 {
+		return nil, nil, 0, fmt.Errorf("label data has %d bytes, which is not a multiple of 8", len(data))
+	}
+	numVoxels := len(data) / 8
+	present := make(map[uint64]uint64)
+	for i := 0; i < numVoxels; i++ {
+		label := binary.LittleEndian.Uint64(data[i*8 : i*8+8])
+		if label != 0 {
+			present[label] = 0
+		}
+	}
+	labels = make([]uint64, 0, len(present))
+	for label := range present {
+		labels = append(labels, label)
+	}
+	sort.Sort(labelSlice(labels))
+	for i, label := range labels {
+		present[label] = uint64(i + 1)
+	}
+
+	minWidth := 1
+	for n := uint64(len(labels)); minWidth < 8 && n >= 1<<uint(8*minWidth); {
+		minWidth *= 2
+	}
+	switch width {
+	case 0:
+		width = minWidth
+	case 1, 2, 4, 8:
+		if width < minWidth {
+			return nil, nil, 0, fmt.Errorf("%d labels can't be renumbered with %d bytes per voxel", len(labels), width)
+		}
+	default:
+		return nil, nil, 0, fmt.Errorf("bytes per voxel must be 1, 2, 4, or 8, not %d", width)
+	}
+
+	renumbered = make([]byte, numVoxels*width)
+	for i := 0; i < numVoxels; i++ {
+		label := binary.LittleEndian.Uint64(data[i*8 : i*8+8])
+		if label == 0 {
+			continue
+		}
+		newLabel := present[label]
+		switch width {
+		case 1:
+			renumbered[i] = uint8(newLabel)
+		case 2:
+			binary.LittleEndian.PutUint16(renumbered[i*2:], uint16(newLabel))
+		case 4:
+			binary.LittleEndian.PutUint32(renumbered[i*4:], uint32(newLabel))
+		case 8:
+			binary.LittleEndian.PutUint64(renumbered[i*8:], newLabel)
+		}
+	}
+	return labels, renumbered, width, nil
+}
+
+// encodeRenumbered returns the renumbered export encoding described in the help for
+// the "renumbered" endpoint.
+func encodeRenumbered(labels []uint64, renumbered []byte, width int) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(labels)))
+	buf.WriteByte(byte(width))
+	buf.Write([]byte{0, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, labels)
+	buf.Write(renumbered)
+	return buf.Bytes()
+}
+
+// ServeRenumbered handles GET requests for 3d label subvolumes renumbered to dense
+// sequential labels along with the table mapping them back to the original labels.
+func (d *Data) ServeRenumbered(ctx *datastore.VersionedContext, w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Method != "GET" {
+		server.BadRequest(w, r, "renumbered only supports GET requests")
+		return
+	}
+	if len(parts) < 7 {
+		server.BadRequest(w, r, "'renumbered' must be followed by shape/size/offset")
+		return
+	}
+	shapeStr, sizeStr, offsetStr := parts[4], parts[5], parts[6]
+	plane, err := dvid.DataShapeString(shapeStr).DataShape()
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	if plane.ShapeDimensions() != 3 {
+		server.BadRequest(w, r, "renumbered only supports 3d subvolumes")
+		return
+	}
+	subvol, err := dvid.NewSubvolumeFromStrings(offsetStr, sizeStr, "_")
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	scale, err := voxels.ScaleFromQuery(r)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	queryValues := r.URL.Query()
+	var width int
+	if widthStr := queryValues.Get("bytes"); widthStr != "" {
+		if width, err = strconv.Atoi(widthStr); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("bad bytes per voxel %q", widthStr))
+			return
+		}
+	}
+
+	e, err := d.NewExtHandler(subvol, nil)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	var roiptr *voxels.ROI
+	if roiname := dvid.DataString(queryValues.Get("roi")); len(roiname) != 0 {
+		roiptr = new(voxels.ROI)
+		roiptr.Iter, err = roi.NewIterator(roiname, ctx.VersionID(), e)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+	}
+	data, err := voxels.GetScaledVolume(ctx, d, e, roiptr, scale)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	labels, renumbered, width, err := renumberLabels(data, width)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-type", "application/octet-stream")
+	if err = dvid.WriteCompressed(encodeRenumbered(labels, renumbered, width), w, r); err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+}
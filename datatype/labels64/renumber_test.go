@@ -0,0 +1,53 @@
+package labels64
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestRenumberLabels(t *testing.T) {
+	original := []uint64{0, 9000000000, 17, 17, 0, 42, 9000000000, 17}
+	data := make([]byte, len(original)*8)
+	for i, label := range original {
+		binary.LittleEndian.PutUint64(data[i*8:], label)
+	}
+
+	labels, renumbered, width, err := renumberLabels(data, 0)
+	if err != nil {
+		t.Fatalf("Error renumbering labels: %s\n", err.Error())
+	}
+	if !reflect.DeepEqual(labels, []uint64{17, 42, 9000000000}) {
+		t.Errorf("Bad renumbered label table: %v\n", labels)
+	}
+	if width != 1 {
+		t.Errorf("Expected 1 byte per voxel for 3 labels, got %d\n", width)
+	}
+	expected := []byte{0, 3, 1, 1, 0, 2, 3, 1}
+	if !reflect.DeepEqual(renumbered, expected) {
+		t.Errorf("Bad renumbered labels: %v\n", renumbered)
+	}
+
+	_, renumbered, width, err = renumberLabels(data, 4)
+	if err != nil {
+		t.Fatalf("Error renumbering labels: %s\n", err.Error())
+	}
+	if width != 4 || len(renumbered) != 32 || binary.LittleEndian.Uint32(renumbered[4:8]) != 3 {
+		t.Errorf("Bad 32-bit renumbered labels: %v\n", renumbered)
+	}
+
+	// 256 labels don't fit in one byte.
+	data = make([]byte, 256*8)
+	for i := 0; i < 256; i++ {
+		binary.LittleEndian.PutUint64(data[i*8:], uint64(i+1000))
+	}
+	if _, _, _, err = renumberLabels(data, 1); err == nil {
+		t.Errorf("Expected error renumbering 256 labels into 1 byte\n")
+	}
+	if _, _, width, err = renumberLabels(data, 0); err != nil || width != 2 {
+		t.Errorf("Expected 2 bytes per voxel for 256 labels, got %d (%v)\n", width, err)
+	}
+	if _, _, _, err = renumberLabels(data, 3); err == nil {
+		t.Errorf("Expected error for 3 bytes per voxel\n")
+	}
+}
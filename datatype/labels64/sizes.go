@@ -0,0 +1,252 @@
+/*
+	This file supports queries over the KeyLabelSizes keyspace, which orders mapped labels
+	by size then label.  Since stores only iterate forward, listings are read in windows
+	of exponentially growing size ranges so a page only reads keys near the requested labels.
+*/
+
+package labels64
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// LabelSize is a mapped label and its number of voxels.
+type LabelSize struct {
+	Label uint64
+	Size  uint64
+}
+
+// String returns the pagination cursor for the label, "<size>_<label>".
+func (ls LabelSize) String() string {
+	return fmt.Sprintf("%d_%d", ls.Size, ls.Label)
+}
+
+// ParseLabelSizeCursor parses a "<size>_<label>" pagination cursor.
+func ParseLabelSizeCursor(s string) (LabelSize, error) {
+	parts := strings.Split(s, "_")
+	if len(parts) != 2 {
+		return LabelSize{}, fmt.Errorf("Bad label size cursor %q, expected <size>_<label>", s)
+	}
+	size, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return LabelSize{}, fmt.Errorf("Bad size in label size cursor %q: %s", s, err.Error())
+	}
+	label, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return LabelSize{}, fmt.Errorf("Bad label in label size cursor %q: %s", s, err.Error())
+	}
+	return LabelSize{Label: label, Size: size}, nil
+}
+
+func (ls LabelSize) less(ls2 LabelSize) bool {
+	if ls.Size != ls2.Size {
+		return ls.Size < ls2.Size
+	}
+	return ls.Label < ls2.Label
+}
+
+// next returns the following position in the size index or false if there is none.
+func (ls LabelSize) next() (LabelSize, bool) {
+	switch {
+	case ls.Label < math.MaxUint64:
+		return LabelSize{Label: ls.Label + 1, Size: ls.Size}, true
+	case ls.Size < math.MaxUint64:
+		return LabelSize{Label: 0, Size: ls.Size + 1}, true
+	default:
+		return ls, false
+	}
+}
+
+// prev returns the preceding position in the size index or false if there is none.
+func (ls LabelSize) prev() (LabelSize, bool) {
+	switch {
+	case ls.Label > 0:
+		return LabelSize{Label: ls.Label - 1, Size: ls.Size}, true
+	case ls.Size > 0:
+		return LabelSize{Label: math.MaxUint64, Size: ls.Size - 1}, true
+	default:
+		return ls, false
+	}
+}
+
+// LabelSizes is a page of labels with their sizes.  If Next is not empty, it's the
+// cursor to pass as "after" to get the following page.
+type LabelSizes struct {
+	Labels []LabelSize
+	Next   string `json:",omitempty"`
+}
+
+// newLabelSizes returns a page of at most limit labels, setting the cursor if truncated.
+// A non-positive limit returns all labels.
+func newLabelSizes(labels []LabelSize, limit int) *LabelSizes {
+	page := &LabelSizes{Labels: labels}
+	if limit > 0 && len(labels) > limit {
+		page.Labels = labels[:limit]
+		page.Next = page.Labels[limit-1].String()
+	}
+	if page.Labels == nil {
+		page.Labels = []LabelSize{}
+	}
+	return page
+}
+
+// labelSizesInRange returns the labels within the inclusive size index range in ascending order.
+func labelSizesInRange(ctx *datastore.VersionedContext, store storage.OrderedKeyValueGetter,
+	beg, end LabelSize) ([]LabelSize, error) {
+
+	begKey := voxels.NewLabelSizesIndex(beg.Size, beg.Label)
+	endKey := voxels.NewLabelSizesIndex(end.Size, end.Label)
+	keys, err := store.KeysInRange(ctx, begKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]LabelSize, len(keys))
+	for i, key := range keys {
+		size, label, err := voxels.DecodeLabelSizesKey(key)
+		if err != nil {
+			return nil, err
+		}
+		labels[i] = LabelSize{Label: label, Size: size}
+	}
+	return labels, nil
+}
+
+// GetLabelSizes returns labels in ascending order of size with sizes between minSize and
+// maxSize inclusive, starting after the given cursor if not nil.  If maxSize is 0, there is
+// no upper bound.  If limit is positive, at most limit labels are returned.
+func GetLabelSizes(ctx *datastore.VersionedContext, minSize, maxSize uint64, limit int,
+	after *LabelSize) (*LabelSizes, error) {
+
+	store, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	beg := LabelSize{Label: 0, Size: minSize}
+	if after != nil {
+		next, ok := after.next()
+		if !ok {
+			return newLabelSizes(nil, limit), nil
+		}
+		if beg.less(next) {
+			beg = next
+		}
+	}
+	if maxSize == 0 {
+		maxSize = math.MaxUint64
+	}
+	end := LabelSize{Label: math.MaxUint64, Size: maxSize}
+	if end.less(beg) {
+		return newLabelSizes(nil, limit), nil
+	}
+	if limit <= 0 {
+		labels, err := labelSizesInRange(ctx, store, beg, end)
+		if err != nil {
+			return nil, err
+		}
+		return newLabelSizes(labels, limit), nil
+	}
+
+	// Read windows that double in size until we have one more label than the limit,
+	// which tells us whether there's a following page.
+	var labels []LabelSize
+	for {
+		windowEnd := end
+		if beg.Size < maxSize/2 {
+			windowEnd = LabelSize{Label: math.MaxUint64, Size: beg.Size*2 + 1}
+		}
+		window, err := labelSizesInRange(ctx, store, beg, windowEnd)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, window...)
+		if len(labels) > limit || windowEnd == end {
+			break
+		}
+		beg, _ = windowEnd.next()
+	}
+	return newLabelSizes(labels, limit), nil
+}
+
+// GetLargestLabels returns the n largest labels in descending order of size, starting
+// after the given cursor if not nil.
+func GetLargestLabels(ctx *datastore.VersionedContext, n int, after *LabelSize) (*LabelSizes, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("Number of largest labels must be positive, got %d", n)
+	}
+	store, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	end := LabelSize{Label: math.MaxUint64, Size: math.MaxUint64}
+	if after != nil {
+		prev, ok := after.prev()
+		if !ok {
+			return newLabelSizes(nil, n), nil
+		}
+		end = prev
+	}
+
+	// Without reverse iteration, read windows down from the largest power of two not
+	// exceeding the end size, halving the lower bound until we have enough labels.
+	var begSize uint64
+	if end.Size > 0 {
+		begSize = 1 << 63
+		for begSize > end.Size {
+			begSize >>= 1
+		}
+	}
+	var labels []LabelSize
+	for {
+		window, err := labelSizesInRange(ctx, store, LabelSize{Label: 0, Size: begSize}, end)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(window) - 1; i >= 0; i-- {
+			labels = append(labels, window[i])
+		}
+		if len(labels) > n || begSize == 0 {
+			break
+		}
+		end = LabelSize{Label: math.MaxUint64, Size: begSize - 1}
+		begSize >>= 1
+	}
+	return newLabelSizes(labels, n), nil
+}
+
+// GetLabelSize returns the exact number of voxels of a label at the context's version,
+// computed from the label's block runs rather than the size index.
+func GetLabelSize(ctx *datastore.VersionedContext, label uint64) (uint64, error) {
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return 0, err
+	}
+	begIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MinIndexZYX.Bytes())
+	endIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MaxIndexZYX.Bytes())
+
+	var size uint64
+	var rlesErr error
+	err = smalldata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		var rles dvid.RLEs
+		if err := rles.UnmarshalBinary(chunk.V); err != nil {
+			rlesErr = fmt.Errorf("Unable to unmarshal RLE for label %d in block %v: %s", label, chunk.K, err.Error())
+			return
+		}
+		numVoxels, _ := rles.Stats()
+		size += uint64(numVoxels)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rlesErr != nil {
+		return 0, rlesErr
+	}
+	return size, nil
+}
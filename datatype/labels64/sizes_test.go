@@ -0,0 +1,47 @@
+package labels64
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLabelSizeCursor(t *testing.T) {
+	ls := LabelSize{Label: 23, Size: 1082}
+	cursor, err := ParseLabelSizeCursor(ls.String())
+	if err != nil {
+		t.Fatalf("Error parsing cursor %q: %s\n", ls, err.Error())
+	}
+	if cursor != ls {
+		t.Errorf("Expected cursor %v, got %v\n", ls, cursor)
+	}
+	for _, bad := range []string{"", "1082", "a_23", "1082_23_4"} {
+		if _, err := ParseLabelSizeCursor(bad); err == nil {
+			t.Errorf("Expected error parsing cursor %q\n", bad)
+		}
+	}
+
+	last := LabelSize{Label: math.MaxUint64, Size: 10}
+	if next, ok := last.next(); !ok || next != (LabelSize{Label: 0, Size: 11}) {
+		t.Errorf("Bad next position after %v: %v\n", last, next)
+	}
+	first := LabelSize{Label: 0, Size: 11}
+	if prev, ok := first.prev(); !ok || prev != last {
+		t.Errorf("Bad previous position before %v: %v\n", first, prev)
+	}
+	if _, ok := (LabelSize{}).prev(); ok {
+		t.Errorf("Expected no position before first label size\n")
+	}
+
+	labels := []LabelSize{{1, 5}, {2, 6}, {3, 7}}
+	page := newLabelSizes(labels, 2)
+	if len(page.Labels) != 2 || page.Next != "6_2" {
+		t.Errorf("Bad truncated page: %v\n", page)
+	}
+	page = newLabelSizes(labels, 3)
+	if len(page.Labels) != 3 || page.Next != "" {
+		t.Errorf("Bad full page: %v\n", page)
+	}
+	if page = newLabelSizes(nil, 0); page.Labels == nil {
+		t.Errorf("Expected empty rather than nil labels\n")
+	}
+}
@@ -0,0 +1,514 @@
+/*
+	This file supports background regeneration of label surfaces after label blocks
+	change.  Changed blocks are queued per label and a pool of workers recomputes only the
+	portion of each surface near the changed blocks, reusing the stored surface elsewhere.
+	Updates for a label that arrive while it is queued are coalesced.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// If more than this fraction of a label's blocks changed, the whole surface is recomputed.
+const maxIncrementalFraction = 0.5
+
+// SurfaceStatus describes the surface regeneration backlog and history for a data instance.
+type SurfaceStatus struct {
+	Enabled       bool
+	PendingLabels int
+	PendingBlocks int
+	Active        int
+	Incremental   uint64
+	Full          uint64
+	Failed        uint64
+}
+
+type surfaceKey struct {
+	instanceID dvid.InstanceID
+	versionID  dvid.VersionID
+	label      uint64
+}
+
+type surfaceJob struct {
+	d      *Data
+	blocks map[string]bool
+}
+
+type surfaceRegenerator struct {
+	sync.Mutex
+	cond    *sync.Cond
+	started bool
+
+	// queue holds keys that are pending and not active, in order of arrival.
+	queue   []surfaceKey
+	pending map[surfaceKey]*surfaceJob
+	active  map[surfaceKey]bool
+	stats   map[dvid.InstanceID]*SurfaceStatus
+}
+
+var surfaces = newSurfaceRegenerator()
+
+func newSurfaceRegenerator() *surfaceRegenerator {
+	s := &surfaceRegenerator{
+		pending: make(map[surfaceKey]*surfaceJob),
+		active:  make(map[surfaceKey]bool),
+		stats:   make(map[dvid.InstanceID]*SurfaceStatus),
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+	return s
+}
+
+// Must be called with lock held.
+func (s *surfaceRegenerator) instanceStats(instanceID dvid.InstanceID) *SurfaceStatus {
+	stats, found := s.stats[instanceID]
+	if !found {
+		stats = new(SurfaceStatus)
+		s.stats[instanceID] = stats
+	}
+	return stats
+}
+
+// add queues the changed blocks for regeneration of a label's surface.
+func (s *surfaceRegenerator) add(d *Data, versionID dvid.VersionID, label uint64, blocks map[string]bool) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.started {
+		numWorkers := dvid.NumCPU / 2
+		if numWorkers < 1 {
+			numWorkers = 1
+		}
+		for i := 0; i < numWorkers; i++ {
+			go s.worker()
+		}
+		s.started = true
+	}
+	key := surfaceKey{d.InstanceID(), versionID, label}
+	job, found := s.pending[key]
+	if !found {
+		job = &surfaceJob{d: d, blocks: make(map[string]bool, len(blocks))}
+		s.pending[key] = job
+		if !s.active[key] {
+			s.queue = append(s.queue, key)
+			s.cond.Signal()
+		}
+	}
+	for blockStr := range blocks {
+		job.blocks[blockStr] = true
+	}
+}
+
+func (s *surfaceRegenerator) worker() {
+	for {
+		s.Lock()
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		key := s.queue[0]
+		s.queue = s.queue[1:]
+		job := s.pending[key]
+		delete(s.pending, key)
+		s.active[key] = true
+		s.Unlock()
+
+		ctx := datastore.NewVersionedContext(job.d, key.versionID)
+		incremental, err := job.d.regenerateSurface(ctx, key.label, job.blocks)
+		if err != nil {
+			dvid.Errorf("Error regenerating surface for label %d in %q: %s\n",
+				key.label, job.d.DataName(), err.Error())
+		}
+
+		s.Lock()
+		delete(s.active, key)
+		stats := s.instanceStats(key.instanceID)
+		switch {
+		case err != nil:
+			stats.Failed++
+		case incremental:
+			stats.Incremental++
+		default:
+			stats.Full++
+		}
+		// Updates that arrived while this label was active are now runnable.
+		if _, found := s.pending[key]; found {
+			s.queue = append(s.queue, key)
+			s.cond.Signal()
+		}
+		s.Unlock()
+	}
+}
+
+// forget drops queued regenerations and statistics for a data instance.  Regenerations
+// that are already active are allowed to finish.
+func (s *surfaceRegenerator) forget(instanceID dvid.InstanceID) {
+	s.Lock()
+	defer s.Unlock()
+	queue := s.queue[:0]
+	for _, key := range s.queue {
+		if key.instanceID != instanceID {
+			queue = append(queue, key)
+		}
+	}
+	s.queue = queue
+	for key := range s.pending {
+		if key.instanceID == instanceID {
+			delete(s.pending, key)
+		}
+	}
+	delete(s.stats, instanceID)
+}
+
+// status returns the regeneration status for a data instance.
+func (s *surfaceRegenerator) status(d *Data) SurfaceStatus {
+	s.Lock()
+	defer s.Unlock()
+	status := *(s.instanceStats(d.InstanceID()))
+	status.Enabled = d.SurfaceRegen
+	for key, job := range s.pending {
+		if key.instanceID == d.InstanceID() {
+			status.PendingLabels++
+			status.PendingBlocks += len(job.blocks)
+		}
+	}
+	for key := range s.active {
+		if key.instanceID == d.InstanceID() {
+			status.Active++
+		}
+	}
+	return status
+}
+
+// SurfaceStatus returns the surface regeneration backlog for the data instance.
+func (d *Data) SurfaceStatus() SurfaceStatus {
+	return surfaces.status(d)
+}
+
+// OnDelete stops surface regeneration and preview updates for the data instance when it
+// is deleted, along with any deferred writes of its blocks.
+func (d *Data) OnDelete(repo datastore.Repo) error {
+	surfaces.forget(d.InstanceID())
+	previews.forget(d.InstanceID())
+	return d.Data.OnDelete(repo)
+}
+
+// queueSurfaceUpdate schedules regeneration of a label's surface given the blocks, in
+// string format, that changed.  It does nothing if surface regeneration is disabled.
+func (d *Data) queueSurfaceUpdate(versionID dvid.VersionID, label uint64, blocks map[string]bool) {
+	if !d.SurfaceRegen || label == 0 || len(blocks) == 0 {
+		return
+	}
+	surfaces.add(d, versionID, label, blocks)
+}
+
+// regenerateSurface updates the stored surface of a label after the given blocks changed.
+// Returns true if the surface was updated incrementally.
+func (d *Data) regenerateSurface(ctx *datastore.VersionedContext, label uint64, changed map[string]bool) (bool, error) {
+	blobs, err := storage.BlobDataStore()
+	if err != nil {
+		return false, err
+	}
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return false, err
+	}
+	data, err := blobs.Get(ctx, voxels.NewLabelSurfaceIndex(label))
+	if err != nil {
+		return false, err
+	}
+
+	// Without a prior surface, compute the whole surface.
+	if data == nil {
+		return false, d.recomputeFullSurface(ctx, label)
+	}
+	oldSurface, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		return false, fmt.Errorf("Unable to deserialize surface: %s", err.Error())
+	}
+
+	// Get the label's RLEs within the changed blocks and their neighbors, which give
+	// context for surface voxels at the borders of changed blocks.
+	blockSize := d.BlockSize().(dvid.Point3d)
+	contextBlocks := make(map[string]bool, len(changed)*27)
+	for blockStr := range changed {
+		var zyx dvid.IndexZYX
+		if err := zyx.IndexFromBytes([]byte(blockStr)); err != nil {
+			return false, err
+		}
+		for dz := int32(-1); dz <= 1; dz++ {
+			for dy := int32(-1); dy <= 1; dy++ {
+				for dx := int32(-1); dx <= 1; dx++ {
+					neighbor := dvid.IndexZYX{zyx[0] + dx, zyx[1] + dy, zyx[2] + dz}
+					contextBlocks[string(neighbor.Bytes())] = true
+				}
+			}
+		}
+	}
+	blockStrs := make([]string, 0, len(contextBlocks))
+	for blockStr := range contextBlocks {
+		blockStrs = append(blockStrs, blockStr)
+	}
+	sort.Strings(blockStrs) // ZYX order required for surface computation.
+
+	var vol dvid.SparseVol
+	vol.SetLabel(label)
+	var numBlocks int
+	for _, blockStr := range blockStrs {
+		value, err := smalldata.Get(ctx, voxels.NewLabelSpatialMapIndex(label, []byte(blockStr)))
+		if err != nil {
+			return false, err
+		}
+		if value == nil {
+			continue
+		}
+		if err := vol.AddSerializedRLEs(value); err != nil {
+			return false, err
+		}
+		numBlocks++
+	}
+
+	// Recompute fully if the label is largely changed, since the incremental update
+	// would compute most of the surface anyway.
+	labelBlocks, err := numLabelBlocks(ctx, smalldata, label)
+	if err != nil {
+		return false, err
+	}
+	if labelBlocks == 0 {
+		return false, blobs.Delete(ctx, voxels.NewLabelSurfaceIndex(label))
+	}
+	if float64(numBlocks) > maxIncrementalFraction*float64(labelBlocks) {
+		return false, d.recomputeFullSurface(ctx, label)
+	}
+
+	// Voxels within one voxel of a changed block may have a different surface or normal.
+	nearChanged := func(x, y, z int32) bool {
+		pt := dvid.Point3d{x, y, z}
+		var lo, hi dvid.ChunkPoint3d
+		for dim := 0; dim < 3; dim++ {
+			lo[dim] = blockCoord(pt[dim]-1, blockSize[dim])
+			hi[dim] = blockCoord(pt[dim]+1, blockSize[dim])
+		}
+		for bz := lo[2]; bz <= hi[2]; bz++ {
+			for by := lo[1]; by <= hi[1]; by++ {
+				for bx := lo[0]; bx <= hi[0]; bx++ {
+					zyx := dvid.IndexZYX{bx, by, bz}
+					if changed[string(zyx.Bytes())] {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	// Merge the unchanged portion of the old surface with the changed portion of the
+	// recomputed surface.
+	var vertices, normals bytes.Buffer
+	numOld, err := filterSurface(oldSurface, &vertices, &normals, func(x, y, z int32) bool {
+		return !nearChanged(x, y, z)
+	})
+	if err != nil {
+		return false, err
+	}
+	var numNew uint32
+	if vol.NumVoxels() != 0 {
+		newSurface, err := vol.SurfaceSerialization(blockSize[2], d.Resolution.VoxelSize)
+		if err != nil {
+			return false, err
+		}
+		if numNew, err = filterSurface(newSurface, &vertices, &normals, nearChanged); err != nil {
+			return false, err
+		}
+	}
+	surface := make([]byte, 4, 4+vertices.Len()+normals.Len())
+	binary.LittleEndian.PutUint32(surface, numOld+numNew)
+	surface = append(surface, vertices.Bytes()...)
+	surface = append(surface, normals.Bytes()...)
+	return true, storeSurface(ctx, label, surface)
+}
+
+// recomputeFullSurface computes and stores a label's surface from all its RLEs, or
+// deletes the surface if the label has no voxels.
+func (d *Data) recomputeFullSurface(ctx *datastore.VersionedContext, label uint64) error {
+	rles, err := getLabelRLEs(ctx, label)
+	if err != nil {
+		return err
+	}
+	if len(rles) == 0 {
+		blobs, err := storage.BlobDataStore()
+		if err != nil {
+			return err
+		}
+		return blobs.Delete(ctx, voxels.NewLabelSurfaceIndex(label))
+	}
+	return d.computeAndSaveSurface(ctx, label, rles)
+}
+
+// numLabelBlocks returns the number of blocks intersected by a label.
+func numLabelBlocks(ctx storage.Context, smalldata storage.SmallDataStorer, label uint64) (int, error) {
+	begIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MinIndexZYX.Bytes())
+	endIndex := voxels.NewLabelSpatialMapIndex(label, dvid.MaxIndexZYX.Bytes())
+	keys, err := smalldata.KeysInRange(ctx, begIndex, endIndex)
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// filterSurface appends the vertices and normals of a serialized surface that pass the
+// given test of voxel coordinates, returning the number appended.
+func filterSurface(surface []byte, vertices, normals *bytes.Buffer, keep func(x, y, z int32) bool) (uint32, error) {
+	if len(surface) < 4 {
+		return 0, fmt.Errorf("Surface has only %d bytes", len(surface))
+	}
+	n := binary.LittleEndian.Uint32(surface[0:4])
+	if uint64(len(surface)) != 4+24*uint64(n) {
+		return 0, fmt.Errorf("Surface with %d voxels has %d bytes", n, len(surface))
+	}
+	vertexData := surface[4 : 4+12*n]
+	normalData := surface[4+12*n:]
+	var numKept uint32
+	for i := uint32(0); i < n; i++ {
+		v := vertexData[i*12 : i*12+12]
+		x := int32(float32FromBytes(v[0:4]))
+		y := int32(float32FromBytes(v[4:8]))
+		z := int32(float32FromBytes(v[8:12]))
+		if keep(x, y, z) {
+			vertices.Write(v)
+			normals.Write(normalData[i*12 : i*12+12])
+			numKept++
+		}
+	}
+	return numKept, nil
+}
+
+func float32FromBytes(b []byte) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+// PruneStale deletes stored surfaces of labels that no longer have voxels and surfaces
+// with voxels outside the blocks of their label, which were computed before the label's
+// blocks changed.  Surfaces are checked in the version they were stored.  If surface
+// regeneration is enabled, pruned surfaces of existing labels are queued for regeneration.
+func (d *Data) PruneStale(repo datastore.Repo, opts storage.GCOptions, stats *storage.GCStats) error {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	deleter, err := storage.NewStaleDeleter(bigdata, opts, stats)
+	if err != nil {
+		return err
+	}
+	blobs, err := storage.BlobDataStore()
+	if err != nil {
+		return err
+	}
+
+	// Scan the surfaces of all versions.
+	ctx := storage.NewDataContext(d, 0)
+	minKey, err := ctx.MinVersionKey(voxels.NewLabelSurfaceIndex(0))
+	if err != nil {
+		return err
+	}
+	maxKey, err := ctx.MaxVersionKey(voxels.NewLabelSurfaceIndex(math.MaxUint64))
+	if err != nil {
+		return err
+	}
+	blockSize := d.BlockSize().(dvid.Point3d)
+	var regens []staleSurface
+	var pruneErr error
+	err = bigdata.ProcessRange(nil, minKey, maxKey, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if pruneErr != nil || chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		index, err := ctx.IndexFromKey(chunk.K)
+		if err != nil || len(index) != 9 {
+			return
+		}
+		label := binary.BigEndian.Uint64(index[1:9])
+		_, versionID, err := storage.KeyToLocalIDs(chunk.K)
+		if err != nil {
+			return
+		}
+		// Surfaces of versions no longer in the repo are reclaimed as orphans.
+		if _, err := repo.GetIterator(versionID); err != nil {
+			return
+		}
+		vctx := datastore.NewVersionedContext(d, versionID)
+		labelBlocks, err := getLabelBlocks(vctx, label)
+		if err != nil {
+			pruneErr = err
+			return
+		}
+		stale := len(labelBlocks) == 0
+		if !stale {
+			value, err := blobs.Resolve(chunk.V)
+			if err != nil {
+				pruneErr = err
+				return
+			}
+			surface, _, err := dvid.DeserializeData(value, true)
+			if err != nil {
+				dvid.Errorf("Pruning undecodable surface of label %d in %q: %s\n", label, d.DataName(), err.Error())
+				stale = true
+			} else {
+				var vertices, normals bytes.Buffer
+				var outside uint32
+				if outside, err = filterSurface(surface, &vertices, &normals, func(x, y, z int32) bool {
+					zyx := dvid.IndexZYX{blockCoord(x, blockSize[0]), blockCoord(y, blockSize[1]), blockCoord(z, blockSize[2])}
+					_, found := labelBlocks[string(zyx.Bytes())]
+					return !found
+				}); err != nil || outside != 0 {
+					stale = true
+				}
+			}
+		}
+		if !stale {
+			return
+		}
+		if pruneErr = deleter.Delete(d.InstanceID(), chunk.KeyValue); pruneErr != nil {
+			return
+		}
+		if len(labelBlocks) != 0 {
+			blocks := make(map[string]bool, len(labelBlocks))
+			for blockStr := range labelBlocks {
+				blocks[blockStr] = true
+			}
+			regens = append(regens, staleSurface{versionID, label, blocks})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if pruneErr != nil {
+		return pruneErr
+	}
+	if err := deleter.Flush(); err != nil {
+		return err
+	}
+
+	// Regenerate only after the stale surfaces are deleted.
+	if !opts.DryRun {
+		for _, regen := range regens {
+			d.queueSurfaceUpdate(regen.versionID, regen.label, regen.blocks)
+		}
+	}
+	return nil
+}
+
+// staleSurface is a pruned label surface to be regenerated from the given blocks.
+type staleSurface struct {
+	versionID dvid.VersionID
+	label     uint64
+	blocks    map[string]bool
+}
@@ -0,0 +1,238 @@
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func encodeSurface(vertices [][3]float32) []byte {
+	n := len(vertices)
+	buf := make([]byte, 4+24*n)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(n))
+	for i, v := range vertices {
+		for j, value := range v {
+			binary.LittleEndian.PutUint32(buf[4+i*12+j*4:], math.Float32bits(value))
+			// Use the negated vertex as a normal so we can check normals follow vertices.
+			binary.LittleEndian.PutUint32(buf[4+12*n+i*12+j*4:], math.Float32bits(-value))
+		}
+	}
+	return buf
+}
+
+func TestFilterSurface(t *testing.T) {
+	surface := encodeSurface([][3]float32{{1, 2, 3}, {40, 2, 3}, {5, 6, 7}, {100, 0, 0}})
+	var vertices, normals bytes.Buffer
+	numKept, err := filterSurface(surface, &vertices, &normals, func(x, y, z int32) bool {
+		return x < 32
+	})
+	if err != nil {
+		t.Fatalf("Error filtering surface: %s\n", err.Error())
+	}
+	if numKept != 2 {
+		t.Fatalf("Expected 2 surface voxels kept, got %d\n", numKept)
+	}
+	expected := encodeSurface([][3]float32{{1, 2, 3}, {5, 6, 7}})
+	if !bytes.Equal(vertices.Bytes(), expected[4:28]) {
+		t.Errorf("Bad filtered vertices: %v\n", vertices.Bytes())
+	}
+	if !bytes.Equal(normals.Bytes(), expected[28:]) {
+		t.Errorf("Bad filtered normals: %v\n", normals.Bytes())
+	}
+
+	if _, err := filterSurface(surface[:len(surface)-4], &vertices, &normals, nil); err == nil {
+		t.Errorf("Expected error filtering truncated surface\n")
+	}
+}
+
+func TestForgetSurfaces(t *testing.T) {
+	s := newSurfaceRegenerator()
+	for _, key := range []surfaceKey{{1, 1, 10}, {2, 1, 10}, {1, 1, 11}} {
+		s.queue = append(s.queue, key)
+		s.pending[key] = &surfaceJob{blocks: map[string]bool{"a": true}}
+		s.instanceStats(key.instanceID).Full++
+	}
+	s.forget(1)
+	if len(s.queue) != 1 || s.queue[0].instanceID != 2 {
+		t.Errorf("Expected only instance 2 queued after forgetting instance 1: %v\n", s.queue)
+	}
+	if len(s.pending) != 1 {
+		t.Errorf("Expected 1 pending job after forgetting instance 1, got %d\n", len(s.pending))
+	}
+	if _, found := s.stats[1]; found {
+		t.Errorf("Expected statistics of forgotten instance to be removed\n")
+	}
+}
+
+func TestPruneStaleSurfaces(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	labels := newDataInstance(repo, t, "stalesurfaces")
+	labels.SurfaceRegen = false
+	ctx := datastore.NewVersionedContext(labels, versionID)
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		t.Fatalf("Can't get small data store: %s\n", err.Error())
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		t.Fatalf("Can't get big data store: %s\n", err.Error())
+	}
+	block := dvid.IndexZYX{0, 0, 0}
+	for _, label := range []uint64{1, 2} {
+		if err := smalldata.Put(ctx, voxels.NewLabelSpatialMapIndex(label, block.Bytes()), encodeRuns([4]int32{1, 2, 3, 1})); err != nil {
+			t.Fatalf("Error storing label RLEs: %s\n", err.Error())
+		}
+	}
+
+	// Label 1's surface is within its block, label 2's surface has voxels in a block the
+	// label no longer intersects, and label 3 no longer exists.
+	surfaces := map[uint64][][3]float32{
+		1: {{1, 2, 3}},
+		2: {{1, 2, 3}, {40, 2, 3}},
+		3: {{1, 2, 3}},
+	}
+	for label, vertices := range surfaces {
+		if err := storeSurface(ctx, label, encodeSurface(vertices)); err != nil {
+			t.Fatalf("Error storing surface: %s\n", err.Error())
+		}
+	}
+
+	stats := &storage.GCStats{DryRun: true, Stale: make(map[dvid.InstanceID]uint64)}
+	if err := labels.PruneStale(repo, storage.GCOptions{DryRun: true}, stats); err != nil {
+		t.Fatalf("Error on dry run: %s\n", err.Error())
+	}
+	if stats.KeysStale != 2 || stats.KeysPruned != 0 || stats.Stale[labels.InstanceID()] != 2 {
+		t.Errorf("Bad dry run stats: %+v\n", stats)
+	}
+
+	stats = &storage.GCStats{Stale: make(map[dvid.InstanceID]uint64)}
+	if err := labels.PruneStale(repo, storage.GCOptions{BatchSize: 1}, stats); err != nil {
+		t.Fatalf("Error pruning stale surfaces: %s\n", err.Error())
+	}
+	if stats.KeysPruned != 2 {
+		t.Errorf("Expected 2 pruned surfaces, got %d\n", stats.KeysPruned)
+	}
+	for label := range surfaces {
+		value, err := bigdata.Get(ctx, voxels.NewLabelSurfaceIndex(label))
+		if err != nil {
+			t.Fatalf("Error getting surface: %s\n", err.Error())
+		}
+		if (value != nil) != (label == 1) {
+			t.Errorf("Label %d surface was kept %t\n", label, value != nil)
+		}
+	}
+}
+
+// surfaceVoxels returns the normals of a serialized surface keyed by vertex.
+func surfaceVoxels(t *testing.T, surface []byte) map[[12]byte][12]byte {
+	n := binary.LittleEndian.Uint32(surface[0:4])
+	if uint64(len(surface)) != 4+24*uint64(n) {
+		t.Fatalf("Surface with %d voxels has %d bytes\n", n, len(surface))
+	}
+	voxels := make(map[[12]byte][12]byte, n)
+	for i := uint32(0); i < n; i++ {
+		var vertex, normal [12]byte
+		copy(vertex[:], surface[4+i*12:])
+		copy(normal[:], surface[4+12*n+i*12:])
+		voxels[vertex] = normal
+	}
+	return voxels
+}
+
+func TestParallelSurface(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	config.Set("BlockSize", "4,4,4")
+	dataservice, err := repo.NewData(labelsT, "blocksurfaces", config)
+	if err != nil {
+		t.Fatalf("Unable to create labels64 instance: %s\n", err.Error())
+	}
+	labels := dataservice.(*Data)
+	ctx := datastore.NewVersionedContext(labels, versionID)
+
+	// Make a ball spanning enough 4x4x4 blocks to be checkpointed, with runs split by block.
+	const label = 7
+	rles := blockRLEs{}
+	var all dvid.RLEs
+	for z := int32(2); z <= 30; z++ {
+		for y := int32(2); y <= 30; y++ {
+			dy, dz := y-16, z-16
+			r2 := 14*14 - dy*dy - dz*dz
+			if r2 < 0 {
+				continue
+			}
+			dx := int32(math.Sqrt(float64(r2)))
+			for x0 := 16 - dx; x0 <= 16+dx; x0 = (x0/4 + 1) * 4 {
+				x1 := (x0/4+1)*4 - 1
+				if x1 > 16+dx {
+					x1 = 16 + dx
+				}
+				rle := dvid.NewRLE(dvid.Point3d{x0, y, z}, x1-x0+1)
+				block := dvid.IndexZYX{x0 / 4, y / 4, z / 4}
+				rles[string(block.Bytes())] = append(rles[string(block.Bytes())], rle)
+				all = append(all, rle)
+			}
+		}
+	}
+	if len(rles) < surfaceCheckpointBlocks {
+		t.Fatalf("Test label has %d blocks, too few to checkpoint\n", len(rles))
+	}
+
+	var vol dvid.SparseVol
+	vol.SetLabel(label)
+	vol.AddRLE(all)
+	expected, err := vol.SurfaceSerialization(4, labels.Resolution.VoxelSize)
+	if err != nil {
+		t.Fatalf("Error computing surface: %s\n", err.Error())
+	}
+	expectedVoxels := surfaceVoxels(t, expected)
+
+	for pass := 0; pass < 2; pass++ {
+		surface, err := labels.computeSurface(ctx, label, rles)
+		if err != nil {
+			t.Fatalf("Error computing parallel surface: %s\n", err.Error())
+		}
+		voxels := surfaceVoxels(t, surface)
+		if len(voxels) != len(expectedVoxels) {
+			t.Fatalf("Expected %d surface voxels, got %d on pass %d\n", len(expectedVoxels), len(voxels), pass)
+		}
+		for vertex, normal := range expectedVoxels {
+			if voxels[vertex] != normal {
+				t.Fatalf("Bad surface voxel %v on pass %d: expected normal %v, got %v\n",
+					vertex, pass, normal, voxels[vertex])
+			}
+		}
+	}
+
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		t.Fatalf("Can't get big data store: %s\n", err.Error())
+	}
+	saved, err := getSurfaceCheckpoint(ctx, bigdata, label)
+	if err != nil {
+		t.Fatalf("Error getting surface checkpoint: %s\n", err.Error())
+	}
+	if len(saved) != len(rles) {
+		t.Errorf("Expected %d checkpointed blocks, got %d\n", len(rles), len(saved))
+	}
+	if err := labels.computeAndSaveSurface(ctx, label, rles); err != nil {
+		t.Fatalf("Error storing surface: %s\n", err.Error())
+	}
+	if saved, err = getSurfaceCheckpoint(ctx, bigdata, label); err != nil || len(saved) != 0 {
+		t.Errorf("Expected checkpoint to be deleted after storing surface: %d blocks (%v)\n", len(saved), err)
+	}
+}
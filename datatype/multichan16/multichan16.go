@@ -308,7 +308,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(requestCtx)
 
 	// Get repo and version ID of this request
 	_, versions, err := datastore.FromContext(requestCtx)
@@ -324,6 +324,8 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
 
 	// Get the action (GET, POST)
 	action := strings.ToLower(r.Method)
@@ -63,6 +63,10 @@ $ dvid repo <UUID> new multiscale2d <data name> <settings...>
                       tile request time and is a better choice if you primarily ask for arbitrary sized images
                       (via GET .../raw/... or .../isotropic/...) instead of tiles (via GET .../tile/...)
     Versioned      "true" or "false" (default)
+    Encrypted      "true" or "false" (default).  Encrypts stored values if the server
+                     configures encryption at rest.
+    Durability     "sync", "periodic", or "async" durability of writes that don't request
+                     one via the "durability" query string.  Default is the server's.
     Source         Name of data source (required)
     TileSize       Size in pixels
     Placeholder    Bool ("false", "true", "0", or "1").  Return placeholder tile if missing.
@@ -131,14 +135,17 @@ GET  <api URL>/node/<UUID>/<data name>/info
     data name     Name of multiscale2d data.
 
 
-GET  <api URL>/node/<UUID>/<data name>/tile/<dims>/<scaling>/<tile coord>[?noblanks=true]
+GET  <api URL>/node/<UUID>/<data name>/tile/<dims>/<scaling>/<tile coord>[/<format>][?noblanks=true]
 (TODO) POST
-    Retrieves PNG tile of named data within a version node.  This GET call should be the fastest
-    way to retrieve image data since internally it has already been stored as a compressed PNG.
+    Retrieves tile of named data within a version node.  This GET call should be the fastest
+    way to retrieve image data since internally it has already been stored as a compressed image.
+    Tiles are returned as stored (PNG for "lz4" and "png" formats) unless another format is
+    requested, in which case the tile is re-encoded.
 
     Example: 
 
     GET <api URL>/node/3f8c/mymultiscale2d/tile/xy/0/10_10_20
+    GET <api URL>/node/3f8c/mymultiscale2d/tile/xy/0/10_10_20/jpg:80
 
     Arguments:
 
@@ -148,6 +155,8 @@ GET  <api URL>/node/<UUID>/<data name>/tile/<dims>/<scaling>/<tile coord>[?nobla
                     Slice strings ("xy", "xz", or "yz") are also accepted.
     scaling       Value from 0 (original resolution) to N where each step is downres by 2.
     tile coord    The tile coordinate in "x_y_z" format.  See discussion of scaling above.
+    format        "png", "jpg" (default: format of stored tile)
+                    jpg allows lossy quality setting, e.g., "jpg:80"
 
   	Query-string options:
 
@@ -243,6 +252,12 @@ func NewType() *Type {
 
 // NewData returns a pointer to new tile data with default values.
 func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Config) (datastore.DataService, error) {
+	return NewData(dtype, uuid, id, name, c)
+}
+
+// NewData returns a pointer to new tile data of the given type, which can be a datatype
+// embedding this package's Type.
+func NewData(dtype datastore.TypeService, uuid dvid.UUID, id dvid.InstanceID, name dvid.DataString, c dvid.Config) (*Data, error) {
 	// Make sure we have a valid DataService source
 	sourcename, found, err := c.GetString("Source")
 	if err != nil {
@@ -642,7 +657,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(requestCtx)
 
 	// Get repo and version ID of this request
 	repo, versions, err := datastore.FromContext(requestCtx)
@@ -658,6 +673,8 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
 
 	action := strings.ToLower(r.Method)
 	switch action {
@@ -900,6 +917,15 @@ func (d *Data) ServeTile(repo datastore.Repo, ctx storage.Context, w http.Respon
 		return dvid.WriteImageHttp(w, img, formatStr)
 	}
 
+	// Encode the tile if a format other than the stored one is requested.
+	if formatStr != "" && !d.storedAs(formatStr) {
+		img, err := d.decodeTile(data)
+		if err != nil {
+			return err
+		}
+		return dvid.WriteImageHttp(w, img, formatStr)
+	}
+
 	switch d.Encoding {
 	case LZ4:
 		var img dvid.Image
@@ -941,26 +967,38 @@ func (d *Data) GetTile(ctx storage.Context, shape dvid.DataShape, scaling Scalin
 		}
 		return nil, nil // Not found
 	}
+	return d.decodeTile(data)
+}
 
-	var goImg image.Image
+// decodeTile returns the image for stored tile data.
+func (d *Data) decodeTile(data []byte) (image.Image, error) {
 	switch d.Encoding {
 	case LZ4:
 		var img dvid.Image
-		err = img.Deserialize(data)
-		if err != nil {
+		if err := img.Deserialize(data); err != nil {
 			return nil, err
 		}
-		goImg = img.Get()
+		return img.Get(), nil
 	case PNG:
-		pngBuffer := bytes.NewBuffer(data)
-		goImg, err = png.Decode(pngBuffer)
+		return png.Decode(bytes.NewBuffer(data))
 	case JPG:
-		jpgBuffer := bytes.NewBuffer(data)
-		goImg, err = jpeg.Decode(jpgBuffer)
+		return jpeg.Decode(bytes.NewBuffer(data))
 	default:
 		return nil, fmt.Errorf("Unknown tile encoding: %s", d.Encoding)
 	}
-	return goImg, err
+}
+
+// storedAs returns true if tiles are stored in the requested image format so they can be
+// returned without decoding.  Requests with an explicit JPEG quality are always encoded.
+func (d *Data) storedAs(formatStr string) bool {
+	switch formatStr {
+	case "png":
+		return d.Encoding == PNG
+	case "jpg", "jpeg":
+		return d.Encoding == JPG
+	default:
+		return false
+	}
 }
 
 // getTileData returns 2d tile data straight from storage without decoding.
@@ -1194,9 +1232,23 @@ func (d *Data) ConstructTiles(uuidStr string, tileSpec TileSpec, request datasto
 	}
 
 	voxelsCtx := datastore.NewVersionedContext(src, versionID)
+	voxelsCtx.SetDone(request.Done())
 	outF, err := d.putTileFunc(versionID)
 
-	for _, plane := range planes {
+	// Report progress as the fraction of slices read over all planes, and stop if the
+	// request is run as a job that was canceled.
+	nextSlice := func(planeNum int, dim uint8, coord int32) error {
+		if request.Canceled() {
+			return fmt.Errorf("Tile generation for %q canceled", d.DataName())
+		}
+		minCoord, maxCoord := src.MinPoint.Value(dim), src.MaxPoint.Value(dim)
+		done := float64(coord-minCoord) / float64(maxCoord-minCoord+1)
+		request.Progress(100 * (float64(planeNum) + done) / float64(len(planes)))
+		return nil
+	}
+
+	for planeNum, plane := range planes {
+		request.JobLogf("Generating %s tiles for %q\n", plane, d.DataName())
 		timedLog := dvid.NewTimeLog()
 		offset := minTiledPt.Duplicate()
 
@@ -1210,6 +1262,9 @@ func (d *Data) ConstructTiles(uuidStr string, tileSpec TileSpec, request datasto
 			dvid.Debugf("Tiling XY image %d x %d pixels\n", width, height)
 			for z := src.MinPoint.Value(2); z <= src.MaxPoint.Value(2); z++ {
 				server.BlockOnInteractiveRequests("multiscale2d.ConstructTiles [xy]")
+				if err := nextSlice(planeNum, 2, z); err != nil {
+					return err
+				}
 
 				sliceLog := dvid.NewTimeLog()
 				offset = offset.Modify(map[uint8]int32{2: z})
@@ -1261,6 +1316,9 @@ func (d *Data) ConstructTiles(uuidStr string, tileSpec TileSpec, request datasto
 			dvid.Debugf("Tiling XZ image %d x %d pixels\n", width, height)
 			for y := src.MinPoint.Value(1); y <= src.MaxPoint.Value(1); y++ {
 				server.BlockOnInteractiveRequests("multiscale2d.ConstructTiles [xz]")
+				if err := nextSlice(planeNum, 1, y); err != nil {
+					return err
+				}
 
 				sliceLog := dvid.NewTimeLog()
 				offset = offset.Modify(map[uint8]int32{1: y})
@@ -1312,6 +1370,9 @@ func (d *Data) ConstructTiles(uuidStr string, tileSpec TileSpec, request datasto
 			dvid.Debugf("Tiling YZ image %d x %d pixels\n", width, height)
 			for x := src.MinPoint.Value(0); x <= src.MaxPoint.Value(0); x++ {
 				server.BlockOnInteractiveRequests("multiscale2d.ConstructTiles [yz]")
+				if err := nextSlice(planeNum, 0, x); err != nil {
+					return err
+				}
 
 				sliceLog := dvid.NewTimeLog()
 				offset = offset.Modify(map[uint8]int32{0: x})
@@ -1362,3 +1423,47 @@ func (d *Data) ConstructTiles(uuidStr string, tileSpec TileSpec, request datasto
 	}
 	return nil
 }
+
+// PruneStale deletes all stored tiles if the source data no longer exists in the repo,
+// or otherwise tiles of scales not in the current tile specification, which are left
+// over from earlier tilings.
+func (d *Data) PruneStale(repo datastore.Repo, opts storage.GCOptions, stats *storage.GCStats) error {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	deleter, err := storage.NewStaleDeleter(bigdata, opts, stats)
+	if err != nil {
+		return err
+	}
+	_, err = repo.GetDataByName(d.Source)
+	sourceDeleted := err != nil
+
+	var ctx storage.DataContext
+	var pruneErr error
+	minKey, maxKey := storage.DataContextKeyRange(d.InstanceID())
+	err = bigdata.ProcessRange(nil, minKey, maxKey, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if pruneErr != nil || chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		index, err := ctx.IndexFromKey(chunk.K)
+		if err != nil {
+			return
+		}
+		tile := &IndexTile{IndexZYX: new(dvid.IndexZYX)}
+		if err := tile.IndexFromBytes(index); err != nil {
+			return
+		}
+		if _, found := d.Levels[tile.scaling]; found && !sourceDeleted {
+			return
+		}
+		pruneErr = deleter.Delete(d.InstanceID(), chunk.KeyValue)
+	})
+	if err != nil {
+		return err
+	}
+	if pruneErr != nil {
+		return pruneErr
+	}
+	return deleter.Flush()
+}
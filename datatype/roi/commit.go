@@ -0,0 +1,71 @@
+/*
+	This file implements ROI mutations within atomic multi-instance commits.
+*/
+
+package roi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// PrepareMutation returns the writes for a "replace", "add", or "subtract" of spans given
+// as JSON {"Spans": [[z, y, x0, x1], ...]}.  Like a POST of the ROI, all previously
+// stored spans are rewritten.
+func (d *Data) PrepareMutation(versionID dvid.VersionID, action string, args json.RawMessage) (*datastore.PreparedMutation, error) {
+	mode, err := ParseUpdateMode(action)
+	if err != nil || action == "" {
+		return nil, fmt.Errorf("Unknown ROI mutation %q: expected replace, add or subtract", action)
+	}
+	var margs struct {
+		Spans []dvid.Span
+	}
+	if err := json.Unmarshal(args, &margs); err != nil {
+		return nil, fmt.Errorf("Bad arguments for ROI mutation: %s", err.Error())
+	}
+	spans, err := d.updatedSpans(versionID, margs.Spans, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	minKey, maxKey := storage.DataContextKeyRange(d.InstanceID())
+	keys, err := smalldata.KeysInRange(nil, minKey, maxKey)
+	if err != nil {
+		return nil, err
+	}
+	writes := make([]storage.KeyValue, 0, len(keys)+len(spans))
+	for _, key := range keys {
+		writes = append(writes, storage.KeyValue{key, nil})
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+	minZ, maxZ := int32(math.MaxInt32), int32(math.MinInt32)
+	for _, span := range spans {
+		if span[0] < minZ {
+			minZ = span[0]
+		}
+		if span[0] > maxZ {
+			maxZ = span[0]
+		}
+		index := indexRLE{
+			start: dvid.IndexZYX{span[2], span[1], span[0]},
+			span:  uint32(span[3] - span[2] + 1),
+		}
+		writes = append(writes, storage.KeyValue{ctx.ConstructKey(index.Bytes()), dvid.EmptyValue()})
+	}
+
+	applied := func() error {
+		d.MinZ, d.MaxZ = minZ, maxZ
+		d.Ready = true
+		return datastore.SaveRepoByVersionID(versionID)
+	}
+	return &datastore.PreparedMutation{Writes: writes, Applied: applied}, nil
+}
@@ -25,6 +25,14 @@ func NewIterator(roiName dvid.DataString, versionID dvid.VersionID, b dvid.Bound
 		return nil, fmt.Errorf("Data name %q was not of roi data type\n", roiName)
 	}
 
+	// Blocks of data can only be delimited by an ROI with the same block size.
+	if blocked, ok := b.(interface {
+		BlockSize() dvid.Point
+	}); ok && blocked.BlockSize().String() != data.BlockSize.String() {
+		return nil, fmt.Errorf("ROI %q has block size %s, not block size %s of the delimited data",
+			roiName, data.BlockSize, blocked.BlockSize())
+	}
+
 	// Convert voxel extents to block Z extents
 	minPt := b.StartPoint().(dvid.Chunkable)
 	maxPt := b.EndPoint().(dvid.Chunkable)
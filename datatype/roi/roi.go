@@ -56,7 +56,13 @@ $ dvid repo <UUID> new roi <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
-    BlockSize      Size in pixels  (default: %d)
+    Encrypted      "true" or "false" (default).  Encrypts stored values if the server
+                     configures encryption at rest.
+    Durability     "sync", "periodic", or "async" durability of writes that don't request
+                     one via the "durability" query string.  Default is the server's.
+    BlockSize      Size of blocks in voxels, either one size for cubic blocks or sizes in X,
+                     Y, and Z like "128x128x16" (default: %d).  Must match the block size
+                     of data delimited by the ROI.
 	
     ------------------
 
@@ -109,6 +115,16 @@ DEL  <api URL>/node/<UUID>/<data name>/roi
 	(x0, y, z) to (x1, y, z).  Each block is a chunking of voxel space using the BlockSize for 
 	the ROI.
 
+	A POST can also send binary run-length encoded blocks with a "Content-type" of
+	"application/octet-stream".  Each run is 16 bytes giving the x, y, and z block coordinate
+	of its start and its length along X as little-endian int32, like the RLEs of the labels
+	sparsevol format.  Stored spans are sorted and merged where they overlap or abut.
+
+    Query-string Options (POST only):
+
+    mode          "replace" (default) replaces the ROI with the posted blocks, "add" adds the
+                    posted blocks to the ROI, and "subtract" removes them from the ROI.
+
     Arguments:
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
@@ -145,6 +161,7 @@ POST <api URL>/node/<UUID>/<data name>/ptquery
   	Returned: "[false, true]"
 
 GET <api URL>/node/<UUID>/<data name>/partition?batchsize=8
+GET <api URL>/node/<UUID>/<data name>/partition?n=16
 
 	Returns JSON of subvolumes that are batchsize^3 blocks in volume and cover the ROI.
 
@@ -153,6 +170,19 @@ GET <api URL>/node/<UUID>/<data name>/partition?batchsize=8
     batchsize	Number of blocks along each axis to batch to make one subvolume (default = 8)
     optimized   If "true" or "on", partioning returns non-fixed sized subvolumes where the coverage
                   is better in terms of subvolumes having more active blocks.
+    n           If given, returns at most n block-aligned subvolumes with about the same number
+                  of ROI blocks instead of fixed-size subvolumes.  Subvolumes are found by
+                  recursively bisecting the ROI blocks so they never overlap.  Returns:
+
+                  { "By": "blocks", "NumBlocks": 1000, "Weight": 1000, "NumChunks": 2,
+                    "Chunks": [
+                      { "MinPoint": [0,0,0], "MaxPoint": [319,639,511],
+                        "MinChunk": [0,0,0], "MaxChunk": [9,19,15],
+                        "NumBlocks": 500, "Weight": 500 }, ...
+                    ]
+                  }
+
+                  The Min/MaxPoint are voxel coordinates and Min/MaxChunk are block coordinates.
 `
 
 func init() {
@@ -196,14 +226,10 @@ func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.
 	}
 	var blockSize dvid.Point3d
 	if found {
-		pt, err := dvid.StringToPoint(s, ",")
+		blockSize, err = dvid.StringToBlockSize(s)
 		if err != nil {
 			return nil, err
 		}
-		if pt.NumDims() != 3 {
-			return nil, fmt.Errorf("BlockSize must be 3d, not %dd", pt.NumDims())
-		}
-		blockSize, _ = pt.(dvid.Point3d)
 	} else {
 		blockSize = dvid.Point3d{DefaultBlockSize, DefaultBlockSize, DefaultBlockSize}
 	}
@@ -476,16 +502,11 @@ func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool)
 
 // PutJSON saves JSON-encoded data representing an ROI into the datastore.
 func (d *Data) PutJSON(versionID dvid.VersionID, jsonBytes []byte) error {
-	spans := []dvid.Span{}
-	err := json.Unmarshal(jsonBytes, &spans)
+	spans, err := spansFromJSON(jsonBytes)
 	if err != nil {
-		return fmt.Errorf("Error trying to parse POSTed JSON: %s", err.Error())
-	}
-	if err := d.PutSpans(versionID, spans, true); err != nil {
 		return err
 	}
-	d.Ready = true
-	return nil
+	return d.UpdateSpans(versionID, spans, ReplaceSpans)
 }
 
 // Returns the voxel range normalized to begVoxel offset and constrained by block span.
@@ -1088,6 +1109,27 @@ func (d *Data) SimplePartition(ctx storage.Context, batchsize int32) ([]byte, er
 	return jsonBytes, err
 }
 
+// BalancedPartition returns JSON of at most n block-aligned subvolumes that cover the
+// ROI, each having about the same number of ROI blocks.  The subvolumes are found by
+// recursively bisecting the ROI blocks.
+func (d *Data) BalancedPartition(ctx storage.VersionedContext, n int) ([]byte, error) {
+	spans, err := GetSpans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []dvid.WeightedBlock
+	for _, span := range spans {
+		for x := span[2]; x <= span[3]; x++ {
+			blocks = append(blocks, dvid.WeightedBlock{dvid.ChunkPoint3d{x, span[1], span[0]}, 1})
+		}
+	}
+	partition, err := dvid.PartitionBlocks(blocks, n, d.BlockSize, "blocks")
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(partition, "", "    ")
+}
+
 // --- DataService interface ---
 
 func (d *Data) Help() string {
@@ -1107,9 +1149,15 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 		d.DataName(), d.TypeName(), request.TypeCommand())
 }
 
+// WriteEndpoint returns true if a request modifies the ROI.  A point query is POSTed
+// but only reads the ROI.
+func (d *Data) WriteEndpoint(method, endpoint string) bool {
+	return method != "GET" && method != "HEAD" && endpoint != "ptquery"
+}
+
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(requestCtx)
 
 	// Get repo and version ID of this request
 	_, versions, err := datastore.FromContext(requestCtx)
@@ -1124,6 +1172,9 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetClient(datastore.ClientFromContext(requestCtx))
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
 
 	// Break URL request into arguments
 	url := r.URL.Path[len(server.WebAPIPath):]
@@ -1175,16 +1226,30 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			fmt.Fprintf(w, string(jsonBytes))
 			comment = fmt.Sprintf("HTTP GET ROI %q: %d bytes\n", d.DataName(), len(jsonBytes))
 		case "post":
+			mode, err := ParseUpdateMode(r.URL.Query().Get("mode"))
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
 			data, err := ioutil.ReadAll(r.Body)
 			if err != nil {
 				server.BadRequest(w, r, err.Error())
 				return
 			}
-			err = d.PutJSON(versionID, data)
+			var spans []dvid.Span
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "application/octet-stream") {
+				spans, err = SpansFromRLEs(data)
+			} else {
+				spans, err = spansFromJSON(data)
+			}
 			if err != nil {
 				server.BadRequest(w, r, err.Error())
 				return
 			}
+			if err = d.UpdateSpans(versionID, spans, mode); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
 			comment = fmt.Sprintf("HTTP POST ROI %q: %d bytes\n", d.DataName(), len(data))
 		case "delete":
 			if err := d.Delete(storeCtx); err != nil {
@@ -1257,6 +1322,22 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			return
 		}
 		queryValues := r.URL.Query()
+		if nStr := queryValues.Get("n"); nStr != "" {
+			n, err := strconv.Atoi(nStr)
+			if err != nil {
+				server.BadRequest(w, r, fmt.Sprintf("Error reading n query string: %s", err.Error()))
+				return
+			}
+			jsonBytes, err := d.BalancedPartition(storeCtx, n)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, string(jsonBytes))
+			comment = fmt.Sprintf("HTTP partition '%s' into %d balanced subvolumes\n", d.DataName(), n)
+			break
+		}
 		batchsizeStr := queryValues.Get("batchsize")
 		batchsize, err := strconv.Atoi(batchsizeStr)
 		if err != nil {
@@ -338,6 +338,100 @@ func TestROIPostAndDelete(t *testing.T) {
 	}
 }
 
+func TestROICommittedNode(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	if _, err := repo.NewData(roitype, "roi", config); err != nil {
+		t.Fatalf("Error creating new roi instance: %s\n", err.Error())
+	}
+	roiRequest := fmt.Sprintf("%snode/%s/roi/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiRequest, getSpansJSON(testSpans))
+	if err := repo.Lock(uuid); err != nil {
+		t.Fatalf("Unable to lock node %s: %s\n", uuid, err.Error())
+	}
+
+	// Point queries only read the ROI, so they work on committed nodes.
+	ptqueryRequest := fmt.Sprintf("%snode/%s/roi/ptquery", server.WebAPIPath, uuid)
+	inclusions, err := putInclusionJSON(server.TestHTTP(t, "POST", ptqueryRequest, getPointsJSON(testPoints)))
+	if err != nil {
+		t.Fatalf("Error on getting back JSON from ptquery: %s\n", err.Error())
+	}
+	if !reflect.DeepEqual(inclusions, expectedInclusions) {
+		t.Errorf("Bad ptquery results on committed node: %v\n", inclusions)
+	}
+
+	// Modifying the ROI isn't allowed.
+	for _, method := range []string{"POST", "DELETE"} {
+		req, _ := http.NewRequest(method, roiRequest, getSpansJSON(testSpans))
+		w := httptest.NewRecorder()
+		server.ServeSingleHTTP(w, req)
+		if w.Code != http.StatusLocked {
+			t.Errorf("Expected status %d for %s of ROI on committed node, got %d\n", http.StatusLocked, method, w.Code)
+		}
+	}
+}
+
+func TestROIUpdateModes(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	dataservice, err := repo.NewData(roitype, "roi", dvid.NewConfig())
+	if err != nil {
+		t.Fatalf("Error creating new roi instance: %s\n", err.Error())
+	}
+	roiRequest := fmt.Sprintf("%snode/%s/%s/roi", server.WebAPIPath, uuid, dataservice.DataName())
+
+	// Overlapping and abutting spans are merged.
+	posted := []dvid.Span{{10, 5, 7, 12}, {10, 5, 0, 3}, {10, 5, 4, 6}, {9, 5, 0, 1}}
+	server.TestHTTP(t, "POST", roiRequest, getSpansJSON(posted))
+	expected := []dvid.Span{{9, 5, 0, 1}, {10, 5, 0, 12}}
+	spans, err := putSpansJSON(server.TestHTTP(t, "GET", roiRequest, nil))
+	if err != nil || !reflect.DeepEqual(spans, expected) {
+		t.Errorf("Bad normalized ROI: expected %v, got %v (%v)\n", expected, spans, err)
+	}
+
+	server.TestHTTP(t, "POST", roiRequest+"?mode=add", getSpansJSON([]dvid.Span{{10, 5, 13, 20}, {11, 0, 0, 0}}))
+	expected = []dvid.Span{{9, 5, 0, 1}, {10, 5, 0, 20}, {11, 0, 0, 0}}
+	spans, err = putSpansJSON(server.TestHTTP(t, "GET", roiRequest, nil))
+	if err != nil || !reflect.DeepEqual(spans, expected) {
+		t.Errorf("Bad ROI after add: expected %v, got %v (%v)\n", expected, spans, err)
+	}
+
+	server.TestHTTP(t, "POST", roiRequest+"?mode=subtract", getSpansJSON([]dvid.Span{{10, 5, 3, 4}, {10, 5, 18, 30}, {9, 5, 0, 1}}))
+	expected = []dvid.Span{{10, 5, 0, 2}, {10, 5, 5, 17}, {11, 0, 0, 0}}
+	spans, err = putSpansJSON(server.TestHTTP(t, "GET", roiRequest, nil))
+	if err != nil || !reflect.DeepEqual(spans, expected) {
+		t.Errorf("Bad ROI after subtract: expected %v, got %v (%v)\n", expected, spans, err)
+	}
+
+	// Binary RLEs give x, y, z and length.
+	rles := dvid.RLEs{dvid.NewRLE(dvid.Point3d{5, 6, 7}, 3)}
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Can't encode RLEs: %s\n", err.Error())
+	}
+	rleSpans, err := SpansFromRLEs(rleBytes)
+	if err != nil || !reflect.DeepEqual(rleSpans, []dvid.Span{{7, 6, 5, 7}}) {
+		t.Errorf("Bad spans from RLEs: %v (%v)\n", rleSpans, err)
+	}
+	if _, err := ParseUpdateMode("union"); err == nil {
+		t.Errorf("Expected error for bad update mode\n")
+	}
+}
+
 func TestROICreateAndSerialize(t *testing.T) {
 	tests.UseStore()
 	defer tests.CloseStore()
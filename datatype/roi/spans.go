@@ -0,0 +1,185 @@
+/*
+	This file supports updating an ROI with run-length encoded blocks, either replacing the
+	ROI or adding or removing blocks from it.  Stored spans are normalized so no two spans
+	overlap or abut along X.
+*/
+
+package roi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// UpdateMode determines how posted spans change an ROI.
+type UpdateMode uint8
+
+const (
+	// ReplaceSpans replaces all blocks of the ROI with the posted spans.
+	ReplaceSpans UpdateMode = iota
+
+	// AddSpans adds the posted blocks to the ROI.
+	AddSpans
+
+	// SubtractSpans removes the posted blocks from the ROI.
+	SubtractSpans
+)
+
+// ParseUpdateMode returns the update mode for a "mode" query string value.
+func ParseUpdateMode(s string) (UpdateMode, error) {
+	switch s {
+	case "", "replace":
+		return ReplaceSpans, nil
+	case "add":
+		return AddSpans, nil
+	case "subtract":
+		return SubtractSpans, nil
+	default:
+		return ReplaceSpans, fmt.Errorf("Unknown ROI update mode %q: expected replace, add or subtract", s)
+	}
+}
+
+type spanSlice []dvid.Span
+
+func (s spanSlice) Len() int      { return len(s) }
+func (s spanSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s spanSlice) Less(i, j int) bool {
+	for k := 0; k < 3; k++ {
+		if s[i][k] != s[j][k] {
+			return s[i][k] < s[j][k]
+		}
+	}
+	return s[i][3] < s[j][3]
+}
+
+// normalizeSpans returns spans sorted in z, y, then x0 order with overlapping or
+// abutting spans along X merged.
+func normalizeSpans(spans []dvid.Span) []dvid.Span {
+	if len(spans) == 0 {
+		return spans
+	}
+	sorted := make([]dvid.Span, len(spans))
+	copy(sorted, spans)
+	sort.Sort(spanSlice(sorted))
+
+	normalized := []dvid.Span{sorted[0]}
+	for _, span := range sorted[1:] {
+		last := &normalized[len(normalized)-1]
+		if span[0] == last[0] && span[1] == last[1] && int64(span[2]) <= int64(last[3])+1 {
+			if span[3] > last[3] {
+				last[3] = span[3]
+			}
+			continue
+		}
+		normalized = append(normalized, span)
+	}
+	return normalized
+}
+
+// subtractSpans returns the blocks of spans a that aren't in spans b.  Both must be
+// normalized and the result is normalized.
+func subtractSpans(a, b []dvid.Span) []dvid.Span {
+	result := []dvid.Span{}
+	var j int
+	for _, span := range a {
+		// Skip subtracted spans in earlier rows or ending before this span.
+		for j < len(b) && (rowLess(b[j], span) || (sameRow(b[j], span) && b[j][3] < span[2])) {
+			j++
+		}
+		x0 := span[2]
+		for k := j; k < len(b) && sameRow(b[k], span) && b[k][2] <= span[3]; k++ {
+			if b[k][2] > x0 {
+				result = append(result, dvid.Span{span[0], span[1], x0, b[k][2] - 1})
+			}
+			if b[k][3] >= x0 {
+				if b[k][3] >= span[3] {
+					x0 = span[3] + 1
+					break
+				}
+				x0 = b[k][3] + 1
+			}
+		}
+		if x0 <= span[3] {
+			result = append(result, dvid.Span{span[0], span[1], x0, span[3]})
+		}
+	}
+	return result
+}
+
+func sameRow(a, b dvid.Span) bool {
+	return a[0] == b[0] && a[1] == b[1]
+}
+
+func rowLess(a, b dvid.Span) bool {
+	return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+}
+
+// SpansFromRLEs converts binary run-length encoded blocks to spans.  Each run is encoded
+// as in the labels sparsevol format: x, y, z block coordinates and the length along X as
+// little-endian int32.
+func SpansFromRLEs(data []byte) ([]dvid.Span, error) {
+	var rles dvid.RLEs
+	if err := rles.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	spans := make([]dvid.Span, len(rles))
+	for i, rle := range rles {
+		if rle.Length() < 1 {
+			return nil, fmt.Errorf("Bad RLE %d with length %d", i, rle.Length())
+		}
+		pt := rle.StartPt()
+		spans[i] = dvid.Span{pt[2], pt[1], pt[0], pt[0] + rle.Length() - 1}
+	}
+	return spans, nil
+}
+
+// UpdateSpans changes the ROI at the given version with the given spans according to
+// the update mode.
+func (d *Data) UpdateSpans(versionID dvid.VersionID, spans []dvid.Span, mode UpdateMode) error {
+	spans, err := d.updatedSpans(versionID, spans, mode)
+	if err != nil {
+		return err
+	}
+	if err := d.PutSpans(versionID, spans, true); err != nil {
+		return err
+	}
+	d.Ready = true
+	return nil
+}
+
+// updatedSpans returns the normalized spans of the ROI after an update.
+func (d *Data) updatedSpans(versionID dvid.VersionID, spans []dvid.Span, mode UpdateMode) ([]dvid.Span, error) {
+	for _, span := range spans {
+		if span[3] < span[2] {
+			return nil, fmt.Errorf("Got weird span %v.  span[3] (X1) < span[2] (X0)", span)
+		}
+	}
+	spans = normalizeSpans(spans)
+	if mode == ReplaceSpans {
+		return spans, nil
+	}
+	stored, err := GetSpans(datastore.NewVersionedContext(d, versionID))
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case AddSpans:
+		spans = normalizeSpans(append(stored, spans...))
+	case SubtractSpans:
+		spans = subtractSpans(normalizeSpans(stored), spans)
+	}
+	return spans, nil
+}
+
+// spansFromJSON returns the spans in a JSON list of [z, y, x0, x1] tuples.
+func spansFromJSON(jsonBytes []byte) ([]dvid.Span, error) {
+	spans := []dvid.Span{}
+	if err := json.Unmarshal(jsonBytes, &spans); err != nil {
+		return nil, fmt.Errorf("Error trying to parse POSTed JSON: %s", err.Error())
+	}
+	return spans, nil
+}
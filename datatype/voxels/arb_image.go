@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/janelia-flyem/dvid/dvid"
@@ -11,6 +12,49 @@ import (
 	"github.com/janelia-flyem/dvid/storage"
 )
 
+// Interpolation is the method used to compute values at points between voxels.
+type Interpolation uint8
+
+const (
+	// NearestNeighbor uses the value of the nearest voxel and works for any voxel type.
+	NearestNeighbor Interpolation = iota
+
+	// Trilinear interpolates values of the 8 surrounding voxels.
+	Trilinear
+)
+
+func (i Interpolation) String() string {
+	switch i {
+	case NearestNeighbor:
+		return "nearest"
+	case Trilinear:
+		return "trilinear"
+	default:
+		return fmt.Sprintf("unknown interpolation %d", i)
+	}
+}
+
+// ParseInterpolation returns the interpolation for "nearest" or "trilinear".
+func ParseInterpolation(s string) (Interpolation, error) {
+	switch strings.ToLower(s) {
+	case "nearest":
+		return NearestNeighbor, nil
+	case "trilinear":
+		return Trilinear, nil
+	default:
+		return NearestNeighbor, fmt.Errorf("Bad interpolation %q: must be nearest or trilinear", s)
+	}
+}
+
+// defaultInterpolation returns trilinear interpolation for interpolable data and
+// nearest neighbor otherwise.
+func (d *Data) defaultInterpolation() Interpolation {
+	if d.Interpolable {
+		return Trilinear
+	}
+	return NearestNeighbor
+}
+
 // ArbSlice is a 2d rectangle that can be positioned arbitrarily in 3D.
 type ArbSlice struct {
 	topLeft    dvid.Vector3d
@@ -64,19 +108,63 @@ func (d *Data) NewArbSlice(topLeft, topRight, bottomLeft dvid.Vector3d, res floa
 	size := dvid.Point2d{int32(nxFloat) + 1, int32(nyFloat) + 1}
 	bytesPerVoxel := d.Properties.Values.BytesPerElement()
 	arb := &ArbSlice{topLeft, topRight, bottomLeft, res, size, incrX, incrY, bytesPerVoxel, nil}
+	if err := arb.allocate(); err != nil {
+		return nil, err
+	}
+	return arb, nil
+}
 
-	// Allocate the image buffer
-	numVoxels := size[0] * size[1]
-	if numVoxels <= 0 {
-		return nil, fmt.Errorf("Bad arbitrary image size requested: %s", arb)
+// NewObliqueSlice returns an image of the given size in pixels centered on a point in voxel
+// space and lying in the plane with the given normal.  The up vector, once projected onto
+// the plane, points to the top of the image, and the image x axis is the cross product of
+// the normal and up vectors, so an XY slice has normal (0,0,1) and up (0,-1,0).  Pixels are
+// separated by res in real world space defined by the data resolution.
+func (d *Data) NewObliqueSlice(center, normal, up dvid.Vector3d, size dvid.Point2d, res float64) (*ArbSlice, error) {
+	if res <= 0 || math.IsInf(res, 0) || math.IsNaN(res) {
+		return nil, fmt.Errorf("Bad oblique image resolution %f", res)
+	}
+	n, err := normal.Normalize()
+	if err != nil {
+		return nil, fmt.Errorf("Bad normal vector %s: %s", normal, err.Error())
+	}
+	u, err := up.Subtract(n.MultScalar(up.Dot(n))).Normalize()
+	if err != nil {
+		return nil, fmt.Errorf("Up vector %s must not be parallel to normal %s", up, normal)
+	}
+	right := n.Cross(u)
+
+	voxelSize := d.Properties.Resolution.VoxelSize
+	var realCenter dvid.Vector3d
+	for i := 0; i < 3; i++ {
+		realCenter[i] = center[i] * float64(voxelSize[i])
+	}
+	incrX := right.MultScalar(res)
+	incrY := u.MultScalar(-res)
+	topLeft := realCenter.Subtract(incrX.MultScalar(float64(size[0]-1) / 2))
+	topLeft = topLeft.Subtract(incrY.MultScalar(float64(size[1]-1) / 2))
+	topRight := topLeft.Add(incrX.MultScalar(float64(size[0] - 1)))
+	bottomLeft := topLeft.Add(incrY.MultScalar(float64(size[1] - 1)))
+
+	bytesPerVoxel := d.Properties.Values.BytesPerElement()
+	arb := &ArbSlice{topLeft, topRight, bottomLeft, res, size, incrX, incrY, bytesPerVoxel, nil}
+	if err := arb.allocate(); err != nil {
+		return nil, err
 	}
-	requestSize := int64(bytesPerVoxel) * int64(numVoxels)
+	return arb, nil
+}
+
+// allocate allocates the image buffer.
+func (s *ArbSlice) allocate() error {
+	if s.size[0] <= 0 || s.size[1] <= 0 {
+		return fmt.Errorf("Bad arbitrary image size requested: %s", s)
+	}
+	requestSize := int64(s.bytesPerVoxel) * int64(s.size[0]) * int64(s.size[1])
 	if requestSize > MaxDataRequest {
-		return nil, fmt.Errorf("Requested payload (%d bytes) exceeds this DVID server's set limit (%d)",
+		return fmt.Errorf("Requested payload (%d bytes) exceeds this DVID server's set limit (%d)",
 			requestSize, MaxDataRequest)
 	}
-	arb.data = make([]byte, requestSize)
-	return arb, nil
+	s.data = make([]byte, requestSize)
+	return nil
 }
 
 func (s ArbSlice) String() string {
@@ -90,8 +178,28 @@ func (d *Data) GetArbitraryImage(ctx storage.Context, tlStr, trStr, blStr, resSt
 	if err != nil {
 		return nil, err
 	}
+	return d.getArbSliceImage(ctx, arb, d.defaultInterpolation())
+}
 
-	// Iterate across arbitrary image using res increments, retrieving trilinear interpolation
+// GetObliqueImage returns an image of the given size in pixels centered on a point in
+// voxel space with orientation given by normal and up vectors.  See NewObliqueSlice.
+func (d *Data) GetObliqueImage(ctx storage.Context, center, normal, up dvid.Vector3d, size dvid.Point2d,
+	res float64, interp Interpolation) (*dvid.Image, error) {
+
+	if interp == Trilinear && !d.Interpolable {
+		return nil, fmt.Errorf("Data %q is not interpolable so trilinear interpolation can't be used", d.DataName())
+	}
+	arb, err := d.NewObliqueSlice(center, normal, up, size, res)
+	if err != nil {
+		return nil, err
+	}
+	return d.getArbSliceImage(ctx, arb, interp)
+}
+
+// getArbSliceImage fills the image buffer of an arbitrary slice with values resampled
+// from the stored blocks.
+func (d *Data) getArbSliceImage(ctx storage.Context, arb *ArbSlice, interp Interpolation) (*dvid.Image, error) {
+	// Iterate across arbitrary image using res increments, retrieving interpolated value
 	// at each point.
 	cache := NewValueCache(100)
 	keyF := func(pt dvid.Point3d) []byte {
@@ -100,10 +208,11 @@ func (d *Data) GetArbitraryImage(ctx storage.Context, tlStr, trStr, blStr, resSt
 		return NewVoxelBlockIndex(&index)
 	}
 
-	// TODO: Add concurrency.
 	leftPt := arb.topLeft
 	var i int32
 	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var calcErr error
 	for y := int32(0); y < arb.size[1]; y++ {
 		<-server.HandlerToken
 		wg.Add(1)
@@ -113,9 +222,12 @@ func (d *Data) GetArbitraryImage(ctx storage.Context, tlStr, trStr, blStr, resSt
 				wg.Done()
 			}()
 			for x := int32(0); x < arb.size[0]; x++ {
-				value, err := d.computeValue(curPt, ctx, KeyFunc(keyF), cache)
+				value, err := d.computeValue(curPt, ctx, KeyFunc(keyF), cache, interp)
 				if err != nil {
 					dvid.Errorf("Error in concurrent arbitrary image calc: %s", err.Error())
+					errMu.Lock()
+					calcErr = err
+					errMu.Unlock()
 					return
 				}
 				copy(arb.data[dstI:dstI+arb.bytesPerVoxel], value)
@@ -128,6 +240,9 @@ func (d *Data) GetArbitraryImage(ctx storage.Context, tlStr, trStr, blStr, resSt
 		i += arb.size[0] * arb.bytesPerVoxel
 	}
 	wg.Wait()
+	if calcErr != nil {
+		return nil, calcErr
+	}
 
 	return dvid.ImageFromData(arb.size[0], arb.size[1], arb.data, d.Properties.Values, d.Properties.Interpolable)
 }
@@ -216,7 +331,7 @@ func NewValueCache(size int) *ValueCache {
 // Get returns the cached value of a key.  On a miss, it uses the passed PopulateFunc
 // to retrieve the key and stores it in the cache.  If nil is passed for the PopulateFunc,
 // the function just returns a "false" with no value.
-func (vc ValueCache) Get(key []byte, pf PopulateFunc) ([]byte, bool, error) {
+func (vc *ValueCache) Get(key []byte, pf PopulateFunc) ([]byte, bool, error) {
 	vc.Lock()
 	data, found := vc.deserializedBlocks[string(key)]
 	if !found {
@@ -258,7 +373,11 @@ func (vc *ValueCache) Clear() {
 }
 
 // Calculates value of a 3d real world point in space defined by underlying data resolution.
-func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc, cache *ValueCache) ([]byte, error) {
+// Nearest neighbor interpolation works for all voxel types while trilinear interpolation
+// is only supported for 8-bit values with 1 or 4 channels.
+func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc, cache *ValueCache,
+	interp Interpolation) ([]byte, error) {
+
 	db, err := storage.BigDataStore()
 	if err != nil {
 		return nil, err
@@ -297,19 +416,31 @@ func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc,
 		return deserializedData, nil
 	}
 
+	getVoxel := func(voxelCoord dvid.Point3d, dst []byte) error {
+		deserializedData, _, err := cache.Get(keyF(voxelCoord), populateF)
+		if err != nil {
+			return err
+		}
+		blockPt := voxelCoord.PointInChunk(blockSize).(dvid.Point3d)
+		blockI := (blockPt[2]*nxy + blockPt[1]*nx + blockPt[0]) * bytesPerVoxel
+		copy(dst, deserializedData[blockI:blockI+bytesPerVoxel])
+		return nil
+	}
+
 	// For the given point, compute surrounding lattice points and retrieve values.
 	neighbors := d.neighborhood(pt)
+	if interp == NearestNeighbor {
+		value := make([]byte, bytesPerVoxel)
+		if err := getVoxel(neighbors.coords[neighbors.nearest()], value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
 	var valuesI int32
 	for _, voxelCoord := range neighbors.coords {
-		deserializedData, _, err := cache.Get(keyF(voxelCoord), populateF)
-		if err != nil {
+		if err := getVoxel(voxelCoord, neighbors.values[valuesI:valuesI+bytesPerVoxel]); err != nil {
 			return nil, err
 		}
-		blockPt := voxelCoord.PointInChunk(blockSize).(dvid.Point3d)
-		blockI := blockPt[2]*nxy + blockPt[1]*nx + blockPt[0]
-		//fmt.Printf("Block %s (%d) len %d -> Neighbor %s (buffer %d, len %d)\n",
-		//	blockPt, blockI, len(blockData), voxelCoord, valuesI, len(neighbors.values))
-		copy(neighbors.values[valuesI:valuesI+bytesPerVoxel], deserializedData[blockI:blockI+bytesPerVoxel])
 		valuesI += bytesPerVoxel
 	}
 
@@ -323,12 +454,8 @@ func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc,
 	case 1:
 		switch bytesPerValue {
 		case 1:
-			if d.Interpolable {
-				interpValue := trilinearInterpUint8(neighbors.xd, neighbors.yd, neighbors.zd, []uint8(neighbors.values))
-				value = []byte{byte(interpValue)}
-			} else {
-				value = []byte{nearestNeighborUint8(neighbors.xd, neighbors.yd, neighbors.zd, []uint8(neighbors.values))}
-			}
+			interpValue := trilinearInterpUint8(neighbors.xd, neighbors.yd, neighbors.zd, []uint8(neighbors.values))
+			value = []byte{byte(interpValue)}
 		case 2:
 			fallthrough
 		case 4:
@@ -347,12 +474,8 @@ func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc,
 				for i := 0; i < 8; i++ {
 					channelValues[i] = uint8(neighbors.values[i*4+c])
 				}
-				if d.Interpolable {
-					interpValue := trilinearInterpUint8(neighbors.xd, neighbors.yd, neighbors.zd, channelValues)
-					value[c] = byte(interpValue)
-				} else {
-					value[c] = byte(nearestNeighborUint8(neighbors.xd, neighbors.yd, neighbors.zd, channelValues))
-				}
+				interpValue := trilinearInterpUint8(neighbors.xd, neighbors.yd, neighbors.zd, channelValues)
+				value[c] = byte(interpValue)
 			}
 		case 2:
 			fallthrough
@@ -360,24 +483,25 @@ func (d *Data) computeValue(pt dvid.Vector3d, ctx storage.Context, keyF KeyFunc,
 			return nil, unsupported()
 		}
 	default:
+		return nil, unsupported()
 	}
 
 	return value, nil
 }
 
-// Returns value of nearest neighbor to point.
-func nearestNeighborUint8(xd, yd, zd float64, values []uint8) uint8 {
+// nearest returns the index of the lattice point nearest the point.
+func (n neighbors) nearest() int {
 	var x, y, z int
-	if xd > 0.5 {
+	if n.xd > 0.5 {
 		x = 1
 	}
-	if yd > 0.5 {
+	if n.yd > 0.5 {
 		y = 1
 	}
-	if zd > 0.5 {
+	if n.zd > 0.5 {
 		z = 1
 	}
-	return values[z*4+y*2+x]
+	return z*4 + y*2 + x
 }
 
 // Returns the trilinear interpolation of a point 'pt' where 'pt0' is the lattice point below and
@@ -0,0 +1,73 @@
+package voxels
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestObliqueSlice(t *testing.T) {
+	d := new(Data)
+	if err := d.Properties.SetDefault(grayscale8EncodeFormat, true); err != nil {
+		t.Fatalf("Unable to set properties: %s\n", err.Error())
+	}
+	d.Properties.Resolution.VoxelSize = dvid.NdFloat32{4, 4, 40}
+
+	// Normal along z with up along -y gives an xy slice.
+	center := dvid.Vector3d{10, 10, 5}
+	arb, err := d.NewObliqueSlice(center, dvid.Vector3d{0, 0, 2}, dvid.Vector3d{0, -1, 0}, dvid.Point2d{3, 5}, 4)
+	if err != nil {
+		t.Fatalf("Unable to make oblique slice: %s\n", err.Error())
+	}
+	if arb.incrX != (dvid.Vector3d{4, 0, 0}) || arb.incrY != (dvid.Vector3d{0, 4, 0}) {
+		t.Errorf("Bad xy slice increments: %s, %s\n", arb.incrX, arb.incrY)
+	}
+	if arb.topLeft != (dvid.Vector3d{36, 32, 200}) {
+		t.Errorf("Bad xy slice top left: %s\n", arb.topLeft)
+	}
+	if arb.size != (dvid.Point2d{3, 5}) || len(arb.data) != 15 {
+		t.Errorf("Bad xy slice size %s with %d bytes\n", arb.size, len(arb.data))
+	}
+
+	// Up vector is projected onto the plane.
+	arb, err = d.NewObliqueSlice(center, dvid.Vector3d{1, 0, 0}, dvid.Vector3d{1, 0, 1}, dvid.Point2d{1, 1}, 2)
+	if err != nil {
+		t.Fatalf("Unable to make oblique slice: %s\n", err.Error())
+	}
+	if arb.incrY != (dvid.Vector3d{0, 0, -2}) || arb.incrX != (dvid.Vector3d{0, -2, 0}) {
+		t.Errorf("Bad yz slice increments: %s, %s\n", arb.incrX, arb.incrY)
+	}
+
+	if _, err := d.NewObliqueSlice(center, dvid.Vector3d{0, 0, 1}, dvid.Vector3d{0, 0, -3}, dvid.Point2d{8, 8}, 4); err == nil {
+		t.Errorf("Expected error for up vector parallel to normal\n")
+	}
+	if _, err := d.NewObliqueSlice(center, dvid.Vector3d{}, dvid.Vector3d{0, 1, 0}, dvid.Point2d{8, 8}, 4); err == nil {
+		t.Errorf("Expected error for zero normal\n")
+	}
+}
+
+func TestNearestNeighbor(t *testing.T) {
+	tests := []struct {
+		xd, yd, zd float64
+		nearest    int
+	}{
+		{0.2, 0.4, 0.1, 0},
+		{0.7, 0.4, 0.1, 1},
+		{0.2, 0.9, 0.1, 2},
+		{0.6, 0.6, 0.6, 7},
+	}
+	for _, test := range tests {
+		n := neighbors{xd: test.xd, yd: test.yd, zd: test.zd}
+		if i := n.nearest(); i != test.nearest {
+			t.Errorf("Expected nearest %d for (%f,%f,%f), got %d\n", test.nearest, test.xd, test.yd, test.zd, i)
+		}
+	}
+	for _, s := range []string{"nearest", "Trilinear"} {
+		if _, err := ParseInterpolation(s); err != nil {
+			t.Errorf("Error parsing interpolation %q: %s\n", s, err.Error())
+		}
+	}
+	if _, err := ParseInterpolation("cubic"); err == nil {
+		t.Errorf("Expected error parsing unsupported interpolation\n")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+	This file supports locking of voxel blocks so writes that only cover part of a block
+	can read, modify, and write the block without losing concurrent changes to it.  Blocks
+	map onto a fixed number of striped mutexes.  Operations that modify many blocks at once,
+	like bulk loads and undo, instead lock the whole version of a data instance.
+*/
+
+package voxels
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// NumBlockLocks is the number of striped mutexes shared by all voxel blocks.
+const NumBlockLocks = 1024
+
+var (
+	blockLocks [NumBlockLocks]sync.Mutex
+
+	versionLocksMu sync.Mutex
+	versionLocks   = make(map[versionLockID]*sync.RWMutex)
+)
+
+type versionLockID struct {
+	instance dvid.InstanceID
+	version  dvid.VersionID
+}
+
+func versionLock(d dvid.Data, versionID dvid.VersionID) *sync.RWMutex {
+	versionLocksMu.Lock()
+	defer versionLocksMu.Unlock()
+
+	id := versionLockID{d.InstanceID(), versionID}
+	mu, found := versionLocks[id]
+	if !found {
+		mu = new(sync.RWMutex)
+		versionLocks[id] = mu
+	}
+	return mu
+}
+
+// lockVersion gives exclusive access to all blocks of a version of data, returning a
+// function that releases it.  Any blocks queued by deferred writes are written first.
+func lockVersion(d dvid.Data, versionID dvid.VersionID) (unlock func()) {
+	mu := versionLock(d, versionID)
+	mu.Lock()
+	if _, err := FlushDeferred(d); err != nil {
+		dvid.Errorf("Unable to write deferred blocks of %q: %s\n", d.DataName(), err.Error())
+	}
+	return mu.Unlock
+}
+
+// blockLockNum returns the striped mutex for a block index in a version of data.
+func blockLockNum(d dvid.Data, versionID dvid.VersionID, index []byte) int {
+	var ids [8]byte
+	binary.LittleEndian.PutUint32(ids[0:4], uint32(d.InstanceID()))
+	binary.LittleEndian.PutUint32(ids[4:8], uint32(versionID))
+	h := fnv.New32a()
+	h.Write(ids[:])
+	h.Write(index)
+	return int(h.Sum32() % NumBlockLocks)
+}
+
+// LockBlocks locks the blocks with the given indices, e.g., from NewVoxelBlockIndex(),
+// within a version of data, returning a function that unlocks them.  All blocks that
+// will be modified together must be locked in one call, and the caller must not lock
+// other blocks until they are unlocked.  The striped mutexes are always locked in
+// increasing order so writers can't deadlock.  Any of the blocks queued by deferred
+// writes are written first so they can be read from the store.
+func LockBlocks(d dvid.Data, versionID dvid.VersionID, indices [][]byte) (unlock func()) {
+	unlock = lockBlocks(d, versionID, indices)
+	if w := findDeferredWriter(d); w != nil {
+		if err := w.flushBlocks(versionID, indices); err != nil {
+			dvid.Errorf("Unable to write deferred blocks of %q: %s\n", d.DataName(), err.Error())
+		}
+	}
+	return unlock
+}
+
+func lockBlocks(d dvid.Data, versionID dvid.VersionID, indices [][]byte) (unlock func()) {
+	vmu := versionLock(d, versionID)
+	vmu.RLock()
+
+	used := make(map[int]bool, len(indices))
+	nums := make([]int, 0, len(indices))
+	for _, index := range indices {
+		num := blockLockNum(d, versionID, index)
+		if !used[num] {
+			used[num] = true
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		blockLocks[num].Lock()
+	}
+	return func() {
+		for i := len(nums) - 1; i >= 0; i-- {
+			blockLocks[nums[i]].Unlock()
+		}
+		vmu.RUnlock()
+	}
+}
@@ -0,0 +1,153 @@
+package voxels
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+type lockTestData struct {
+	dvid.Data
+	id dvid.InstanceID
+}
+
+func (d lockTestData) InstanceID() dvid.InstanceID {
+	return d.id
+}
+
+func TestLockBlocks(t *testing.T) {
+	d := lockTestData{id: 23}
+	var indices [][]byte
+	for x := int32(0); x < 4; x++ {
+		index := dvid.IndexZYX{x, 1, 2}
+		indices = append(indices, NewVoxelBlockIndex(&index))
+	}
+
+	// Concurrent read-modify-writes of overlapping blocks shouldn't lose any increments.
+	counts := make([]int, len(indices))
+	const numWriters = 50
+	wg := new(sync.WaitGroup)
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			beg := w % 2
+			unlock := LockBlocks(d, 1, indices[beg:beg+3])
+			for i := beg; i < beg+3; i++ {
+				count := counts[i]
+				counts[i] = count + 1
+			}
+			unlock()
+		}(w)
+	}
+	wg.Wait()
+	expected := []int{numWriters / 2, numWriters, numWriters, numWriters / 2}
+	for i, count := range counts {
+		if count != expected[i] {
+			t.Errorf("Expected block %d written %d times, got %d\n", i, expected[i], count)
+		}
+	}
+
+	// Locking the version should wait for blocks to be unlocked.
+	unlock := LockBlocks(d, 1, indices)
+	locked := make(chan struct{})
+	go func() {
+		unlockVersion := lockVersion(d, 1)
+		close(locked)
+		unlockVersion()
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("Version was locked while its blocks were locked\n")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Version wasn't locked after its blocks were unlocked\n")
+	}
+
+	// Blocks of a locked version wait for the version to be unlocked.
+	unlockVersion := lockVersion(d, 1)
+	blocked := make(chan struct{})
+	go func() {
+		unlock := LockBlocks(d, 1, indices[:1])
+		close(blocked)
+		unlock()
+	}()
+	select {
+	case <-blocked:
+		t.Fatalf("Blocks were locked while their version was locked\n")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlockVersion()
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Blocks weren't locked after their version was unlocked\n")
+	}
+}
+
+func TestConcurrentPartialBlockWrites(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	// Two writers each fill half of the same block with their own value.
+	halfSize := dvid.Point3d{DefaultBlockSize / 2, DefaultBlockSize, DefaultBlockSize}
+	offsets := []dvid.Point3d{{0, 0, 0}, {DefaultBlockSize / 2, 0, 0}}
+	putHalf := func(half int, value byte) error {
+		data := bytes.Repeat([]byte{value}, int(halfSize.Prod()))
+		v, err := grayscale.NewExtHandler(dvid.NewSubvolume(offsets[half], halfSize), data)
+		if err != nil {
+			return err
+		}
+		return PutVoxels(ctx, grayscale, v, OpOptions{})
+	}
+
+	blockSize := dvid.Point3d{DefaultBlockSize, DefaultBlockSize, DefaultBlockSize}
+	for round := 0; round < 20; round++ {
+		values := []byte{byte(2*round + 1), byte(2*round + 2)}
+		errs := make([]error, 2)
+		wg := new(sync.WaitGroup)
+		for half := range offsets {
+			wg.Add(1)
+			go func(half int) {
+				defer wg.Done()
+				errs[half] = putHalf(half, values[half])
+			}(half)
+		}
+		wg.Wait()
+		for half, err := range errs {
+			if err != nil {
+				t.Fatalf("Unable to put half %d of block: %s\n", half, err.Error())
+			}
+		}
+
+		// Neither write may be lost.
+		v, err := grayscale.NewExtHandler(dvid.NewSubvolume(dvid.Point3d{0, 0, 0}, blockSize), nil)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		if err := GetVoxels(ctx, grayscale, v, nil); err != nil {
+			t.Fatalf("Unable to get block: %s\n", err.Error())
+		}
+		block := v.Data()
+		for i, value := range block {
+			half := (int32(i) % DefaultBlockSize) / (DefaultBlockSize / 2)
+			if value != values[half] {
+				t.Fatalf("Round %d: expected voxel %d to be %d from writer %d, got %d\n",
+					round, i, values[half], half, value)
+			}
+		}
+	}
+}
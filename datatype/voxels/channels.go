@@ -0,0 +1,115 @@
+/*
+	This file handles conversion between voxel data and go images for voxels with multibyte
+	values or a number of channels that go images don't support.  Voxel values are stored
+	in the data's byte order (little endian by default) while 16-bit go images are big
+	endian, and 2 or 3 channel voxels are expanded into RGBA images with an opaque alpha.
+*/
+
+package voxels
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// rgbaValues returns the format of RGBA images with the given type for each channel.
+func rgbaValues(t dvid.DataType) dvid.DataValues {
+	return dvid.DataValues{
+		{T: t, Label: "red"},
+		{T: t, Label: "green"},
+		{T: t, Label: "blue"},
+		{T: t, Label: "alpha"},
+	}
+}
+
+// swapValueBytes returns a copy of data with the byte order of each value reversed.
+func swapValueBytes(data []uint8, bytesPerValue int32) []uint8 {
+	swapped := make([]uint8, len(data))
+	n := int(bytesPerValue)
+	for beg := 0; beg+n <= len(data); beg += n {
+		for i := 0; i < n; i++ {
+			swapped[beg+i] = data[beg+n-1-i]
+		}
+	}
+	return swapped
+}
+
+// expandToRGBA returns RGBA pixels given data with 2 or 3 channels.  Missing color channels
+// are zero and alpha is set to its maximum.
+func expandToRGBA(data []uint8, channels, bytesPerValue int32) []uint8 {
+	srcBytes := int(channels * bytesPerValue)
+	dstBytes := int(4 * bytesPerValue)
+	numPixels := len(data) / srcBytes
+	rgba := make([]uint8, numPixels*dstBytes)
+	alpha := int(3 * bytesPerValue)
+	for i := 0; i < numPixels; i++ {
+		src := data[i*srcBytes : (i+1)*srcBytes]
+		dst := rgba[i*dstBytes : (i+1)*dstBytes]
+		copy(dst, src)
+		for b := alpha; b < dstBytes; b++ {
+			dst[b] = 0xFF
+		}
+	}
+	return rgba
+}
+
+// compactFromRGBA reverses expandToRGBA, returning data with the given number of channels.
+func compactFromRGBA(rgba []uint8, channels, bytesPerValue int32) []uint8 {
+	srcBytes := int(4 * bytesPerValue)
+	dstBytes := int(channels * bytesPerValue)
+	numPixels := len(rgba) / srcBytes
+	data := make([]uint8, numPixels*dstBytes)
+	for i := 0; i < numPixels; i++ {
+		copy(data[i*dstBytes:(i+1)*dstBytes], rgba[i*srcBytes:i*srcBytes+dstBytes])
+	}
+	return data
+}
+
+// imageVoxelData returns voxel data and its stride for a go image, converting 16-bit
+// values to the given byte order and RGBA pixels to 2 or 3 channels if necessary.
+func imageVoxelData(img image.Image, values dvid.DataValues, byteOrder binary.ByteOrder) (data []uint8, stride int32, err error) {
+	var bytesPerPixel int32
+	data, bytesPerPixel, stride, err = dvid.ImageData(img)
+	if err != nil {
+		return
+	}
+	bytesPerVoxel := values.BytesPerElement()
+	if bytesPerPixel == bytesPerVoxel && len(values) != 2 && len(values) != 3 {
+		// Only 16-bit go images have big endian values.  Other multibyte voxels are
+		// packed into pixels as is.
+		switch img.(type) {
+		case *image.Gray16, *image.NRGBA64:
+			if byteOrder != nil && byteOrder != binary.BigEndian {
+				data = swapValueBytes(data, 2)
+			}
+		}
+		return
+	}
+
+	channels := values.ValuesPerElement()
+	bytesPerValue, err := values.BytesPerValue()
+	if err != nil {
+		return
+	}
+	if (channels != 2 && channels != 3) || bytesPerPixel != 4*bytesPerValue {
+		err = fmt.Errorf("Can't use %d bytes/pixel image for %d values of %d bytes/voxel",
+			bytesPerPixel, channels, bytesPerVoxel)
+		return
+	}
+	rect := img.Bounds()
+	width, height := int32(rect.Dx()), int32(rect.Dy())
+	rowBytes := width * bytesPerPixel
+	rgba := make([]uint8, height*rowBytes)
+	for y := int32(0); y < height; y++ {
+		copy(rgba[y*rowBytes:(y+1)*rowBytes], data[y*stride:y*stride+rowBytes])
+	}
+	if bytesPerValue == 2 && byteOrder != nil && byteOrder != binary.BigEndian {
+		rgba = swapValueBytes(rgba, 2)
+	}
+	data = compactFromRGBA(rgba, channels, bytesPerValue)
+	stride = width * bytesPerVoxel
+	return
+}
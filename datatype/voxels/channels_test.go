@@ -0,0 +1,77 @@
+package voxels
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestExpandToRGBA(t *testing.T) {
+	rgb := []uint8{1, 2, 3, 4, 5, 6}
+	rgba := expandToRGBA(rgb, 3, 1)
+	expected := []uint8{1, 2, 3, 0xFF, 4, 5, 6, 0xFF}
+	if !bytes.Equal(rgba, expected) {
+		t.Errorf("Expected RGBA %v, got %v\n", expected, rgba)
+	}
+	if data := compactFromRGBA(rgba, 3, 1); !bytes.Equal(data, rgb) {
+		t.Errorf("Expected compacted data %v, got %v\n", rgb, data)
+	}
+
+	twochan := []uint8{1, 2, 3, 4}
+	rgba = expandToRGBA(twochan, 2, 2)
+	expected = []uint8{1, 2, 3, 4, 0, 0, 0xFF, 0xFF}
+	if !bytes.Equal(rgba, expected) {
+		t.Errorf("Expected RGBA %v, got %v\n", expected, rgba)
+	}
+	if data := compactFromRGBA(rgba, 2, 2); !bytes.Equal(data, twochan) {
+		t.Errorf("Expected compacted data %v, got %v\n", twochan, data)
+	}
+}
+
+func TestImageVoxelData(t *testing.T) {
+	// 16-bit images are big endian and should be stored in data's byte order.
+	gray16 := image.NewGray16(image.Rect(0, 0, 2, 1))
+	gray16.Pix = []uint8{0x01, 0x02, 0x03, 0x04}
+	data, stride, err := imageVoxelData(gray16, grayscale16EncodeFormat, binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("Error getting voxel data from Gray16 image: %s\n", err.Error())
+	}
+	if stride != 4 || !bytes.Equal(data, []uint8{0x02, 0x01, 0x04, 0x03}) {
+		t.Errorf("Bad voxel data from Gray16 image: stride %d, data %v\n", stride, data)
+	}
+
+	// RGB data is given as RGBA images.
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	rgba.Pix = []uint8{1, 2, 3, 0xFF, 4, 5, 6, 0xFF}
+	data, stride, err = imageVoxelData(rgba, rgb8EncodeFormat, binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("Error getting voxel data from RGBA image: %s\n", err.Error())
+	}
+	if stride != 6 || !bytes.Equal(data, []uint8{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Bad voxel data from RGBA image: stride %d, data %v\n", stride, data)
+	}
+
+	if _, _, err = imageVoxelData(gray16, rgb8EncodeFormat, binary.LittleEndian); err == nil {
+		t.Errorf("Expected error using Gray16 image for RGB data\n")
+	}
+}
+
+func TestGetImage2dChannels(t *testing.T) {
+	slice, err := dvid.NewOrthogSlice(dvid.XY, dvid.Point3d{0, 0, 0}, dvid.Point2d{2, 1})
+	if err != nil {
+		t.Fatalf("Error making slice: %s\n", err.Error())
+	}
+	data := []uint8{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	v := NewVoxels(slice, twochan16EncodeFormat, data, 8, binary.LittleEndian)
+	img, err := v.GetImage2d()
+	if err != nil {
+		t.Fatalf("Error getting image of 2-channel data: %s\n", err.Error())
+	}
+	expected := []uint8{0x02, 0x01, 0x04, 0x03, 0, 0, 0xFF, 0xFF, 0x06, 0x05, 0x08, 0x07, 0, 0, 0xFF, 0xFF}
+	if !bytes.Equal(img.Data(), expected) {
+		t.Errorf("Expected 2-channel image data %v, got %v\n", expected, img.Data())
+	}
+}
@@ -0,0 +1,80 @@
+/*
+	This file implements voxels mutations within atomic multi-instance commits.
+*/
+
+package voxels
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// PrepareMutation returns the writes for a "blocks" mutation given as JSON
+// {"Ops": [{"Key": "10_20_30", "Value": <base64 block>}, {"Key": "11_20_30", "Delete": true}]},
+// which is the same as a batch of block operations: each key is a block coordinate and
+// each put value is the uncompressed block.
+func (d *Data) PrepareMutation(versionID dvid.VersionID, action string, args json.RawMessage) (*datastore.PreparedMutation, error) {
+	if action != "blocks" {
+		return nil, fmt.Errorf("Unknown voxels mutation %q: expected blocks", action)
+	}
+	var margs struct {
+		Ops []datastore.BatchOp
+	}
+	if err := json.Unmarshal(args, &margs); err != nil {
+		return nil, fmt.Errorf("Bad arguments for voxels mutation: %s", err.Error())
+	}
+	if len(margs.Ops) == 0 {
+		return nil, fmt.Errorf("Voxels mutation requires at least one block operation")
+	}
+
+	ctx := datastore.NewVersionedContext(d, versionID)
+	blockSize := d.BlockSize()
+	numBlockBytes := int(blockSize.Prod()) * int(d.Values().BytesPerElement())
+	writes := make([]storage.KeyValue, 0, len(margs.Ops))
+	var written []dvid.IndexZYX
+	for _, op := range margs.Ops {
+		chunkPt, err := dvid.StringToChunkPoint3d(op.Key, "_")
+		if err != nil {
+			return nil, err
+		}
+		index := dvid.IndexZYX(chunkPt)
+		key := ctx.ConstructKey(NewVoxelBlockIndex(&index))
+		if op.Delete {
+			writes = append(writes, storage.KeyValue{key, nil})
+			continue
+		}
+		if len(op.Value) != numBlockBytes {
+			return nil, fmt.Errorf("Expected %d bytes for block %s, got %d", numBlockBytes, op.Key, len(op.Value))
+		}
+		serialization, err := dvid.SerializeData(op.Value, d.Compression(), d.Checksum())
+		if err != nil {
+			return nil, fmt.Errorf("Unable to serialize block %s: %s", op.Key, err.Error())
+		}
+		writes = append(writes, storage.KeyValue{key, serialization})
+		written = append(written, index)
+	}
+
+	// Extents grow to include written blocks once the commit is applied.
+	applied := func() error {
+		extents := d.Extents()
+		var changed bool
+		for i := range written {
+			index := &written[i]
+			if extents.AdjustPoints(index.MinPoint(blockSize), index.MaxPoint(blockSize)) {
+				changed = true
+			}
+			if extents.AdjustIndices(index.Duplicate().(dvid.ChunkIndexer), index.Duplicate().(dvid.ChunkIndexer)) {
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		return datastore.SaveRepoByVersionID(versionID)
+	}
+	return &datastore.PreparedMutation{Writes: writes, Applied: applied}, nil
+}
@@ -168,13 +168,39 @@ func GetVolume(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI) ([
 	return e.Data(), nil
 }
 
+// GetScaledImage retrieves a 2d image from a downsampled scale level of a version node.
+func GetScaledImage(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI, scale uint8) (*dvid.Image, error) {
+	if err := GetScaledVoxels(ctx, i, e, r, scale); err != nil {
+		return nil, err
+	}
+	return e.GetImage2d()
+}
+
+// GetScaledVolume retrieves a n-d volume from a downsampled scale level of a version node.
+func GetScaledVolume(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI, scale uint8) ([]byte, error) {
+	if err := GetScaledVoxels(ctx, i, e, r, scale); err != nil {
+		return nil, err
+	}
+	return e.Data(), nil
+}
+
 // GetVoxels copies voxels from an IntData for a version to an ExtData, e.g.,
 // a requested subvolume or 2d image.
 func GetVoxels(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI) error {
+	return GetScaledVoxels(ctx, i, e, r, 0)
+}
+
+// GetScaledVoxels is like GetVoxels but reads from a downsampled scale level computed
+// by the "pyramid" command.  The ExtData geometry is in voxel coordinates of that scale.
+// Blocks that haven't been computed for the scale are returned as zeros.
+func GetScaledVoxels(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI, scale uint8) error {
 	db, err := storage.BigDataStore()
 	if err != nil {
 		return err
 	}
+	if scale != 0 && r != nil && r.Iter != nil {
+		return fmt.Errorf("ROI masking is only supported at scale 0, not scale %d", scale)
+	}
 
 	// Only do one request at a time, although each request can start many goroutines.
 	server.SpawnGoroutineMutex.Lock()
@@ -182,12 +208,17 @@ func GetVoxels(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI) er
 
 	wg := new(sync.WaitGroup)
 	for it, err := e.IndexIterator(i.BlockSize()); err == nil && it.Valid(); it.NextSpan() {
+		if storage.Canceled(ctx) {
+			wg.Wait()
+			return storage.ErrCanceled
+		}
 		indexBeg, indexEnd, err := it.IndexSpan()
 		if err != nil {
+			wg.Wait()
 			return err
 		}
-		blockBeg := NewVoxelBlockIndex(indexBeg)
-		blockEnd := NewVoxelBlockIndex(indexEnd)
+		blockBeg := NewScaledBlockIndex(scale, indexBeg)
+		blockEnd := NewScaledBlockIndex(scale, indexEnd)
 
 		// Get set of blocks in ROI if ROI provided
 		var chunkOp *storage.ChunkOp
@@ -215,6 +246,11 @@ func GetVoxels(ctx *datastore.VersionedContext, i IntData, e ExtData, r *ROI) er
 		// Send the entire range of key-value pairs to chunk processor
 		err = db.ProcessRange(ctx, blockBeg, blockEnd, chunkOp, i.ProcessChunk)
 		if err != nil {
+			// Wait for chunks already sent so they don't write into the returned data.
+			wg.Wait()
+			if err == storage.ErrCanceled {
+				return err
+			}
 			return fmt.Errorf("Unable to GET data %s: %s", ctx, err.Error())
 		}
 	}
@@ -265,6 +301,89 @@ func GetBlocks(ctx *datastore.VersionedContext, start dvid.ChunkPoint3d, span in
 	return buf.Bytes(), nil
 }
 
+// StreamBlocks writes all stored blocks in the span of block coordinates starting at the
+// given block coordinate, in block key (ZYX) order.  Each block is written as a frame of
+// little-endian int32 block x, y, z and byte length followed by the block data.  The
+// stream ends with a frame having length -1.  The block data is compressed using the given
+// compression, avoiding recompression if the block was stored with the same format and
+// no particular gzip level or JPEG quality was requested.  Blocks that have never been
+// written are skipped.  Returns the number of blocks written.
+func StreamBlocks(ctx *datastore.VersionedContext, w io.Writer, start dvid.ChunkPoint3d, span dvid.Point3d, compress dvid.Compression) (int, error) {
+	if span[0] <= 0 || span[1] <= 0 || span[2] <= 0 {
+		return 0, fmt.Errorf("Block span must be positive in all dimensions, got %s", span)
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return 0, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+
+	var numBlocks int
+	var writeErr error
+	writeFrame := func(x, y, z int32, data []byte) {
+		if writeErr != nil {
+			return
+		}
+		header := []int32{x, y, z, int32(len(data))}
+		if data == nil {
+			header[3] = -1
+		}
+		if writeErr = binary.Write(w, binary.LittleEndian, header); writeErr != nil {
+			return
+		}
+		_, writeErr = w.Write(data)
+	}
+
+	// Each span along x is contiguous in key space.
+	for z := start[2]; z < start[2]+span[2]; z++ {
+		for y := start[1]; y < start[1]+span[1]; y++ {
+			indexBeg := dvid.IndexZYX{start[0], y, z}
+			indexEnd := dvid.IndexZYX{start[0] + span[0] - 1, y, z}
+			begIndex := NewVoxelBlockIndex(&indexBeg)
+			endIndex := NewVoxelBlockIndex(&indexEnd)
+			err = bigdata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+				if writeErr != nil {
+					return
+				}
+				indexZYX, err := DecodeVoxelBlockKey(chunk.K)
+				if err != nil {
+					writeErr = err
+					return
+				}
+				data, format, err := dvid.DeserializeData(chunk.V, false)
+				if err != nil {
+					writeErr = fmt.Errorf("Unable to deserialize block %s: %s", indexZYX, err.Error())
+					return
+				}
+				passthrough := format == compress.Format() &&
+					((format != dvid.Gzip && format != dvid.JPEG) || compress.Level() == dvid.DefaultCompression)
+				if !passthrough {
+					if format != dvid.Uncompressed {
+						if data, _, err = dvid.DeserializeData(chunk.V, true); err != nil {
+							writeErr = fmt.Errorf("Unable to deserialize block %s: %s", indexZYX, err.Error())
+							return
+						}
+					}
+					if data, err = dvid.CompressData(data, compress); err != nil {
+						writeErr = err
+						return
+					}
+				}
+				x, y, z := indexZYX.Unpack()
+				writeFrame(x, y, z, data)
+				numBlocks++
+			})
+			if err != nil {
+				return numBlocks, err
+			}
+			if writeErr != nil {
+				return numBlocks, writeErr
+			}
+		}
+	}
+	writeFrame(0, 0, 0, nil)
+	return numBlocks, writeErr
+}
+
 func PutBlocks(ctx *datastore.VersionedContext, i IntData, start dvid.ChunkPoint3d, span int, data io.Reader) error {
 	bigdata, err := storage.BigDataStore()
 	if err != nil {
@@ -312,9 +431,70 @@ func PutBlocks(ctx *datastore.VersionedContext, i IntData, start dvid.ChunkPoint
 	return nil
 }
 
+// IngestBlocks applies batch operations on voxel blocks, where each key is a block
+// coordinate like "10_20_30" and each put value is the uncompressed block.
+func IngestBlocks(ctx *datastore.VersionedContext, i IntData, ops []datastore.BatchOp) error {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	batcher, ok := bigdata.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("Unable to store voxel blocks: big data store can't do batching!")
+	}
+	batch := batcher.NewBatch(ctx)
+	numBlockBytes := int(i.BlockSize().Prod()) * int(i.Values().BytesPerElement())
+	for _, op := range ops {
+		chunkPt, err := dvid.StringToChunkPoint3d(op.Key, "_")
+		if err != nil {
+			return err
+		}
+		index := dvid.IndexZYX(chunkPt)
+		blockIndex := NewVoxelBlockIndex(&index)
+		if op.Delete {
+			batch.Delete(blockIndex)
+			continue
+		}
+		if len(op.Value) != numBlockBytes {
+			return fmt.Errorf("Expected %d bytes for block %s, got %d", numBlockBytes, op.Key, len(op.Value))
+		}
+		serialization, err := dvid.SerializeData(op.Value, i.Compression(), i.Checksum())
+		if err != nil {
+			return fmt.Errorf("Unable to serialize block %s: %s", op.Key, err.Error())
+		}
+		batch.Put(blockIndex, serialization)
+	}
+	return batch.Commit()
+}
+
+// ReadBlocks returns the uncompressed voxel blocks at the given block coordinates, with
+// a nil block for each block that isn't stored.
+func ReadBlocks(ctx *datastore.VersionedContext, coords []dvid.ChunkPoint3d) ([][]byte, error) {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	blocks := make([][]byte, len(coords))
+	for n, coord := range coords {
+		index := dvid.IndexZYX(coord)
+		serialization, err := bigdata.Get(ctx, NewVoxelBlockIndex(&index))
+		if err != nil {
+			return nil, err
+		}
+		if serialization == nil {
+			continue
+		}
+		if blocks[n], _, err = dvid.DeserializeData(serialization, true); err != nil {
+			return nil, fmt.Errorf("Unable to deserialize block %s: %s", coord, err.Error())
+		}
+	}
+	return blocks, nil
+}
+
 type OpOptions struct {
-	roi      *ROI
-	modsChan BlockChannel
+	roi         *ROI
+	modsChan    BlockChannel
+	editSession string
 }
 
 func (opts *OpOptions) SetROI(roi *ROI) {
@@ -325,25 +505,33 @@ func (opts *OpOptions) SetModsChannel(modsChan BlockChannel) {
 	opts.modsChan = modsChan
 }
 
+// SetEditSession keeps the prior contents of modified blocks so the PUT can be
+// undone within the given editing session.
+func (opts *OpOptions) SetEditSession(session string) {
+	opts.editSession = session
+}
+
 // PutVoxels copies voxels from an ExtData (e.g., subvolume or 2d image) into an IntData
 // for a version.   Since chunk sizes can be larger than the PUT data, this also requires
 // integrating the PUT data into current chunks before writing the result.  There are two passes:
 //   Pass one: Retrieve all available key/values within the PUT space.
 //   Pass two: Merge PUT data into those key/values and store them.
+// Both passes are done for each span of blocks while the blocks are locked via LockBlocks().
 func PutVoxels(ctx storage.Context, i IntData, e ExtData, options OpOptions) error {
+	if len(options.editSession) != 0 {
+		if err := checkEditSession(options.editSession); err != nil {
+			return err
+		}
+	}
 	db, err := storage.BigDataStore()
 	if err != nil {
 		return err
 	}
+	undo := newUndoRecorder(options.editSession)
 	wg := new(sync.WaitGroup)
 	chunkOp := &storage.ChunkOp{&Operation{e, PutOp, nil, 0, options.modsChan}, wg}
 
-	// We only want one PUT on given version for given data to prevent interleaved
-	// chunk PUTs that could potentially overwrite slice modifications.
 	versionID := ctx.VersionID()
-	putMutex := ctx.Mutex()
-	putMutex.Lock()
-	defer putMutex.Unlock()
 
 	// Get UUID
 	uuid, err := datastore.UUIDFromVersion(versionID)
@@ -372,21 +560,26 @@ func PutVoxels(ctx storage.Context, i IntData, e ExtData, options OpOptions) err
 		extentChanged = true
 	}
 
-	// Iterate through index space for this data.
-	for it, err := e.IndexIterator(i.BlockSize()); err == nil && it.Valid(); it.NextSpan() {
-		i0, i1, err := it.IndexSpan()
-		if err != nil {
-			return err
-		}
-		ptBeg := i0.Duplicate().(dvid.ChunkIndexer)
-		ptEnd := i1.Duplicate().(dvid.ChunkIndexer)
-
+	// Each span of blocks is locked while it is read, merged with PUT data, and written,
+	// so concurrent PUTs that partially cover the same blocks don't lose data.
+	putSpan := func(ptBeg, ptEnd dvid.ChunkIndexer) error {
 		begX := ptBeg.Value(0)
 		endX := ptEnd.Value(0)
 
-		if extents.AdjustIndices(ptBeg, ptEnd) {
-			extentChanged = true
+		var blockIndices [][]byte
+		c := dvid.ChunkPoint3d{begX, ptBeg.Value(1), ptBeg.Value(2)}
+		for x := begX; x <= endX; x++ {
+			c[0] = x
+			curIndex := dvid.IndexZYX(c)
+			blockIndices = append(blockIndices, NewVoxelBlockIndex(&curIndex))
 		}
+		// Queued blocks aren't flushed since their queued data is used below.
+		unlock := lockBlocks(i.BaseData(), versionID, blockIndices)
+		defer func() {
+			wg.Wait()
+			unlock()
+		}()
+		writer := findDeferredWriter(i.BaseData())
 
 		indexBeg := NewVoxelBlockIndex(ptBeg)
 		indexEnd := NewVoxelBlockIndex(ptEnd)
@@ -404,12 +597,10 @@ func PutVoxels(ctx storage.Context, i IntData, e ExtData, options OpOptions) err
 		if numOldkv == 0 {
 			oldI = -1
 		}
-		wg.Add(int(endX-begX) + 1)
-		c := dvid.ChunkPoint3d{begX, ptBeg.Value(1), ptBeg.Value(2)}
 		for x := begX; x <= endX; x++ {
 			c[0] = x
 			curIndex := dvid.IndexZYX(c)
-			curIndexBytes := NewVoxelBlockIndex(&curIndex)
+			curIndexBytes := blockIndices[x-begX]
 
 			// Check for this index among old key-value pairs and if so,
 			// send the old value into chunk handler.  Else we are just sending
@@ -431,26 +622,54 @@ func PutVoxels(ctx storage.Context, i IntData, e ExtData, options OpOptions) err
 			} else {
 				kv = &storage.KeyValue{K: ctx.ConstructKey(curIndexBytes)}
 			}
+			if writer != nil {
+				if queued := writer.get(versionID, curIndexBytes); queued != nil {
+					kv = &storage.KeyValue{K: kv.K, V: queued}
+				}
+			}
 
 			// Don't PUT if this index is outside a specified ROI
 			if options.roi != nil && options.roi.Iter != nil && !options.roi.Iter.InsideFast(curIndex) {
-				wg.Done()
 				continue
 			}
+			undo.add(curIndexBytes, kv.V)
 
 			// TODO -- Pass batch write via chunkOp and group all PUTs
 			// together at once.  Should increase write speed, particularly
 			// since the PUTs are using mostly sequential keys.
+			wg.Add(1)
 			i.ProcessChunk(&storage.Chunk{chunkOp, kv})
 		}
+		return nil
+	}
+
+	// Iterate through index space for this data.
+	for it, err := e.IndexIterator(i.BlockSize()); err == nil && it.Valid(); it.NextSpan() {
+		i0, i1, err := it.IndexSpan()
+		if err != nil {
+			return err
+		}
+		ptBeg := i0.Duplicate().(dvid.ChunkIndexer)
+		ptEnd := i1.Duplicate().(dvid.ChunkIndexer)
+
+		if extents.AdjustIndices(ptBeg, ptEnd) {
+			extentChanged = true
+		}
+		if err := putSpan(ptBeg, ptEnd); err != nil {
+			return err
+		}
 	}
 
 	wg.Wait()
+	if err := undo.save(i.BaseData(), versionID); err != nil {
+		return fmt.Errorf("Error saving undo record for edit session %q: %s", options.editSession, err.Error())
+	}
 	return nil
 }
 
 func loadHDF(i IntData, load *bulkLoadInfo) error {
-	return fmt.Errorf("DVID currently does not support HDF5 image import.")
+	return fmt.Errorf("DVID currently does not support HDF5 image import.  Convert %s to an N5 dataset and load its directory.",
+		load.filenames[0])
 	// TODO: Use a DVID-specific HDF5 loader that works off HDF5 C library.
 	/*
 			for _, filename := range load.filenames {
@@ -609,7 +828,10 @@ func loadXYImages(i IntData, load *bulkLoadInfo) error {
 
 		fileNum++
 		load.offset = load.offset.Add(dvid.Point3d{0, 0, 1})
-		timedLog.Infof("Loaded %s slice %s", i, e)
+		if load.progress != nil {
+			load.progress.add(len(e.Data()))
+		}
+		timedLog.Debugf("Loaded %s slice %s", i, e)
 	}
 	waitForWrites.Wait()
 	return nil
@@ -727,16 +949,16 @@ func LoadImages(versionID dvid.VersionID, i IntData, offset dvid.Point, filename
 	}
 	timedLog := dvid.NewTimeLog()
 
-	// We only want one PUT on given version for given data to prevent interleaved
-	// chunk PUTs that could potentially overwrite slice modifications.
-	ctx := storage.NewDataContext(i.BaseData(), versionID)
-	loadMutex := ctx.Mutex()
-	loadMutex.Lock()
+	// Bulk loads modify blocks long after reading them, so lock the whole version to
+	// prevent interleaved PUTs that could potentially overwrite slice modifications.
+	unlockVersion := lockVersion(i.BaseData(), versionID)
 
 	// Handle cleanup given multiple goroutines still writing data.
 	load := &bulkLoadInfo{filenames: filenames, versionID: versionID, offset: offset}
+	load.progress = startLoadProgress(i.BaseData(), len(filenames))
 	defer func() {
-		loadMutex.Unlock()
+		load.progress.finish()
+		unlockVersion()
 
 		if load.extentChanged.Value() {
 			err := datastore.SaveRepoByVersionID(versionID)
@@ -746,15 +968,21 @@ func LoadImages(versionID dvid.VersionID, i IntData, offset dvid.Point, filename
 		}
 	}()
 
-	// Use different loading techniques if we have a potentially multidimensional HDF5 file
-	// or many 2d images.
+	// Use different loading techniques if we have a potentially multidimensional HDF5 file,
+	// an N5 dataset, or many 2d images.
+	var err error
 	if dvid.Filename(filenames[0]).HasExtensionPrefix("hdf", "h5") {
-		loadHDF(i, load)
+		err = loadHDF(i, load)
+	} else if len(filenames) == 1 && isN5Dataset(filenames[0]) {
+		err = loadN5(i, load)
 	} else {
-		loadXYImages(i, load)
+		err = loadXYImages(i, load)
+	}
+	if err != nil {
+		return err
 	}
 
-	timedLog.Infof("RPC load of %d files completed", len(filenames))
+	timedLog.Infof("RPC load of %d files completed: %s", len(filenames), load.progress)
 	return nil
 }
 
@@ -886,10 +1114,11 @@ func ComputeTransform(v ExtData, block *Block, blockSize dvid.Point) (blockBeg,
 	if err != nil {
 		return
 	}
-	if indexBytes[0] != byte(KeyVoxelBlock) {
+	if indexBytes, err = blockSpatialIndex(indexBytes); err != nil {
 		err = fmt.Errorf("Block key (%v) has non-VoxelBlock index", block.K)
+		return
 	}
-	if err = ptIndex.IndexFromBytes(indexBytes[1:]); err != nil {
+	if err = ptIndex.IndexFromBytes(indexBytes); err != nil {
 		return
 	}
 
@@ -1244,45 +1473,54 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 		if op.denormChan != nil {
 			op.denormChan <- Block3d{indexZYX, blockData}
 		}
-		bigdata, err := storage.BigDataStore()
-		if err != nil {
-			dvid.Errorf("Unable to obtain BigData store in %q: %s\n", d.DataName(), err.Error())
-			return
-		}
 		serialization, err := dvid.SerializeData(blockData, d.Compression(), d.Checksum())
 		if err != nil {
 			dvid.Errorf("Unable to serialize block in %q: %s\n", d.DataName(), err.Error())
 			return
 		}
-		if err := bigdata.Put(nil, chunk.K, serialization); err != nil {
-			dvid.Errorf("Unable to PUT voxel data for key %v: %s\n", chunk.K, err.Error())
+		_, versionID, err := storage.KeyToLocalIDs(chunk.K)
+		if err != nil {
+			dvid.Errorf("Unable to get version for block in %q: %s\n", d.DataName(), err.Error())
 			return
 		}
+
+		// Blocks already queued must stay queued so they're written in order.  Blocks are
+		// written with a versioned context so unchanged blocks of child versions can be
+		// deduplicated by storage.
+		index := NewVoxelBlockIndex(indexZYX)
+		ctx := datastore.NewVersionedContext(d, versionID)
+		writer := findDeferredWriter(d)
+		if d.DeferWrites || (writer != nil && writer.get(versionID, index) != nil) {
+			if writer == nil {
+				writer = getDeferredWriter(d)
+			}
+			writer.put(versionID, index, serialization)
+		} else {
+			bigdata, err := storage.BigDataStore()
+			if err != nil {
+				dvid.Errorf("Unable to obtain BigData store in %q: %s\n", d.DataName(), err.Error())
+				return
+			}
+			if err := bigdata.Put(ctx, index, serialization); err != nil {
+				dvid.Errorf("Unable to PUT voxel data for key %v: %s\n", chunk.K, err.Error())
+				return
+			}
+		}
+		if err := d.putBlockHistogram(ctx, indexZYX, blockData); err != nil {
+			dvid.Errorf("Unable to PUT block histogram in %q: %s\n", d.DataName(), err.Error())
+		}
 	}
 }
 
-// Handler conversion of little to big endian for voxels larger than 1 byte.
+// Handler conversion of little to big endian for voxels with values larger than 1 byte.
+// Each value of a multichannel voxel is converted separately.
 func littleToBigEndian(v ExtData, data []uint8) (bigendian []uint8, err error) {
-	bytesPerVoxel := v.Values().BytesPerElement()
-	if v.ByteOrder() == nil || v.ByteOrder() == binary.BigEndian || bytesPerVoxel == 1 {
-		return data, nil
+	bytesPerValue, err := v.Values().BytesPerValue()
+	if err != nil {
+		return nil, err
 	}
-	bigendian = make([]uint8, len(data))
-	switch bytesPerVoxel {
-	case 2:
-		for beg := 0; beg < len(data)-1; beg += 2 {
-			bigendian[beg], bigendian[beg+1] = data[beg+1], data[beg]
-		}
-	case 4:
-		for beg := 0; beg < len(data)-3; beg += 4 {
-			value := binary.LittleEndian.Uint32(data[beg : beg+4])
-			binary.BigEndian.PutUint32(bigendian[beg:beg+4], value)
-		}
-	case 8:
-		for beg := 0; beg < len(data)-7; beg += 8 {
-			value := binary.LittleEndian.Uint64(data[beg : beg+8])
-			binary.BigEndian.PutUint64(bigendian[beg:beg+8], value)
-		}
+	if v.ByteOrder() == nil || v.ByteOrder() == binary.BigEndian || bytesPerValue == 1 {
+		return data, nil
 	}
-	return
+	return swapValueBytes(data, bytesPerValue), nil
 }
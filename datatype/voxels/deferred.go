@@ -0,0 +1,322 @@
+/*
+	This file supports deferred writes of voxel blocks for ingestion workloads.  When data
+	has DeferWrites set, PUTs are acknowledged once their blocks are queued in memory, and
+	the queued blocks are written to the key-value store asynchronously in large batches.
+	Queued blocks are lost if the server crashes before they are written, and GETs don't
+	see them until they are flushed.  PUTs that modify queued blocks use the queued data.
+	Blocks queued for a version are written before a commit of the version completes, and
+	blocks queued for a deleted data instance are discarded.
+*/
+
+package voxels
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+var (
+	// DeferredFlushInterval is the longest time a block is queued before it's written.
+	DeferredFlushInterval = 5 * time.Second
+
+	// MaxDeferredBlocks is the number of queued blocks for a data instance that triggers
+	// a write.  PUTs wait when twice this number of blocks are queued.
+	MaxDeferredBlocks = 10000
+
+	deferredMu      sync.Mutex
+	deferredWriters = make(map[dvid.InstanceID]*deferredWriter)
+)
+
+func init() {
+	server.OnShutdown(FlushAllDeferred)
+}
+
+type deferredKey struct {
+	version dvid.VersionID
+	index   string
+}
+
+// deferredBlock is a serialized block waiting to be written.
+type deferredBlock struct {
+	value []byte
+}
+
+type deferredWriter struct {
+	data    dvid.Data
+	mu      sync.Mutex
+	pending map[deferredKey]*deferredBlock
+	written sync.Cond // signaled after each flush
+
+	// Writes are serialized so a block queued again while being written can't be
+	// overwritten in the store by its older value.
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+}
+
+func getDeferredWriter(d dvid.Data) *deferredWriter {
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+
+	w, found := deferredWriters[d.InstanceID()]
+	if !found {
+		w = &deferredWriter{
+			data:    d,
+			pending: make(map[deferredKey]*deferredBlock),
+			kick:    make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+		}
+		w.written.L = &w.mu
+		deferredWriters[d.InstanceID()] = w
+		go w.run(d)
+	}
+	return w
+}
+
+// findDeferredWriter returns the deferred writer for data or nil if it has none.
+func findDeferredWriter(d dvid.Data) *deferredWriter {
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+	return deferredWriters[d.InstanceID()]
+}
+
+// dropDeferredWriter stops the deferred writer of data, if any, and discards its queued
+// blocks after waiting for any write in progress.
+func dropDeferredWriter(d dvid.Data) {
+	deferredMu.Lock()
+	w, found := deferredWriters[d.InstanceID()]
+	delete(deferredWriters, d.InstanceID())
+	deferredMu.Unlock()
+	if !found {
+		return
+	}
+	close(w.stop)
+
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	w.pending = make(map[deferredKey]*deferredBlock)
+	w.written.Broadcast()
+	w.mu.Unlock()
+}
+
+// run periodically writes queued blocks or when enough blocks are queued.
+func (w *deferredWriter) run(d dvid.Data) {
+	ticker := time.NewTicker(DeferredFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		case <-w.stop:
+			return
+		}
+		if _, err := w.flush(); err != nil {
+			dvid.Errorf("Unable to write deferred blocks of %q: %s\n", d.DataName(), err.Error())
+		}
+	}
+}
+
+// put queues a serialized block, waiting if too many blocks are already queued.
+func (w *deferredWriter) put(versionID dvid.VersionID, index, value []byte) {
+	w.mu.Lock()
+	for len(w.pending) >= 2*MaxDeferredBlocks {
+		w.written.Wait()
+	}
+	w.pending[deferredKey{versionID, string(index)}] = &deferredBlock{value}
+	full := len(w.pending) >= MaxDeferredBlocks
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// get returns a queued block or nil if the block isn't queued.
+func (w *deferredWriter) get(versionID dvid.VersionID, index []byte) []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	block, found := w.pending[deferredKey{versionID, string(index)}]
+	if !found {
+		return nil
+	}
+	return block.value
+}
+
+// flush writes all queued blocks, returning the number of blocks written.
+func (w *deferredWriter) flush() (int, error) {
+	return w.flushWhere(func(deferredKey) bool { return true })
+}
+
+// flushVersion writes all queued blocks of a version, returning the number written.
+func (w *deferredWriter) flushVersion(versionID dvid.VersionID) (int, error) {
+	return w.flushWhere(func(k deferredKey) bool { return k.version == versionID })
+}
+
+// flushBlocks writes any queued blocks with the given indices.
+func (w *deferredWriter) flushBlocks(versionID dvid.VersionID, indices [][]byte) error {
+	queued := make(map[deferredKey]bool, len(indices))
+	for _, index := range indices {
+		queued[deferredKey{versionID, string(index)}] = true
+	}
+	_, err := w.flushWhere(func(k deferredKey) bool { return queued[k] })
+	return err
+}
+
+// flushWhere writes the queued blocks whose keys are selected by the given function,
+// returning the number of blocks written.
+func (w *deferredWriter) flushWhere(selected func(deferredKey) bool) (int, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	blocks := make(map[deferredKey]*deferredBlock)
+	for k, block := range w.pending {
+		if selected(k) {
+			blocks[k] = block
+		}
+	}
+	w.mu.Unlock()
+	if len(blocks) == 0 {
+		return 0, nil
+	}
+
+	err := w.write(blocks)
+
+	// Only dequeue blocks that weren't queued again while being written.
+	w.mu.Lock()
+	if err == nil {
+		for k, block := range blocks {
+			if w.pending[k] == block {
+				delete(w.pending, k)
+			}
+		}
+	}
+	w.written.Broadcast()
+	w.mu.Unlock()
+	return len(blocks), err
+}
+
+// write stores blocks using batches of KVWriteSize blocks per version if possible.
+// Blocks are written with versioned contexts so storage can deduplicate blocks that
+// are unchanged from ancestor versions.
+func (w *deferredWriter) write(blocks map[deferredKey]*deferredBlock) error {
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		for k, block := range blocks {
+			ctx := datastore.NewVersionedContext(w.data, k.version)
+			if err := db.Put(ctx, []byte(k.index), block.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	batches := make(map[dvid.VersionID]storage.Batch)
+	sizes := make(map[dvid.VersionID]int)
+	for k, block := range blocks {
+		batch, found := batches[k.version]
+		if !found {
+			batch = batcher.NewBatch(datastore.NewVersionedContext(w.data, k.version))
+			batches[k.version] = batch
+		}
+		batch.Put([]byte(k.index), block.value)
+		sizes[k.version]++
+		if sizes[k.version]%KVWriteSize == 0 {
+			if err := batch.Commit(); err != nil {
+				return err
+			}
+			delete(batches, k.version)
+		}
+	}
+	for _, batch := range batches {
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushDeferred writes all queued blocks of data, returning the number of blocks written.
+func FlushDeferred(d dvid.Data) (int, error) {
+	w := findDeferredWriter(d)
+	if w == nil {
+		return 0, nil
+	}
+	return w.flush()
+}
+
+// FlushAllDeferred writes all queued blocks of all data.
+func FlushAllDeferred() {
+	deferredMu.Lock()
+	writers := make([]*deferredWriter, 0, len(deferredWriters))
+	for _, w := range deferredWriters {
+		writers = append(writers, w)
+	}
+	deferredMu.Unlock()
+
+	for _, w := range writers {
+		if n, err := w.flush(); err != nil {
+			dvid.Errorf("Unable to write %d deferred blocks: %s\n", n, err.Error())
+		}
+	}
+}
+
+// OnVersionCommit writes any blocks queued for the committed version, so they're stored
+// before the commit completes.
+func (d *Data) OnVersionCommit(repo datastore.Repo, uuid dvid.UUID, versionID dvid.VersionID) error {
+	w := findDeferredWriter(d)
+	if w == nil {
+		return nil
+	}
+	n, err := w.flushVersion(versionID)
+	if err != nil {
+		return fmt.Errorf("unable to write %d deferred blocks: %s", n, err.Error())
+	}
+	return nil
+}
+
+// OnDelete discards any blocks queued for the data instance, so they aren't written after
+// the instance's key-value pairs are deleted.
+func (d *Data) OnDelete(repo datastore.Repo) error {
+	dropDeferredWriter(d)
+	return nil
+}
+
+// ServeFlush handles requests to the "flush" endpoint.  A GET returns the number of
+// blocks queued for deferred writes and a POST writes them before responding.
+func (d *Data) ServeFlush(w http.ResponseWriter, r *http.Request) {
+	var queued, written int
+	switch r.Method {
+	case "GET":
+		if writer := findDeferredWriter(d); writer != nil {
+			writer.mu.Lock()
+			queued = len(writer.pending)
+			writer.mu.Unlock()
+		}
+	case "POST":
+		var err error
+		if written, err = FlushDeferred(d); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+	default:
+		server.BadRequest(w, r, "Only GET or POST are supported on the flush endpoint")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"DeferWrites": %t, "Queued": %d, "Written": %d}`, d.DeferWrites, queued, written)
+}
@@ -0,0 +1,159 @@
+package voxels
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestDeferredWrites(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	grayscale.DeferWrites = true
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	// Two PUTs that partially cover the same blocks.
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	subvol := dvid.NewSubvolume(offset, size)
+	putVoxels := func(subvol *dvid.Subvolume, data []byte) {
+		v, err := grayscale.NewExtHandler(subvol, data)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+			t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+		}
+	}
+	first := makeVolume(offset, size)
+	putVoxels(subvol, first)
+	offset2 := dvid.Point3d{30, 20, 30}
+	second := makeVolume(offset2, size)
+	for i := range second {
+		second[i] ^= 0xFF
+	}
+	putVoxels(dvid.NewSubvolume(offset2, size), second)
+
+	numQueued := func(w *deferredWriter) int {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return len(w.pending)
+	}
+	w := findDeferredWriter(grayscale)
+	if w == nil || numQueued(w) == 0 {
+		t.Fatalf("Expected blocks to be queued for deferred writes\n")
+	}
+	if _, err := FlushDeferred(grayscale); err != nil {
+		t.Fatalf("Error flushing deferred writes: %s\n", err.Error())
+	}
+	if n := numQueued(w); n != 0 {
+		t.Errorf("Expected all queued blocks written, %d still queued\n", n)
+	}
+
+	// The first PUT's voxels not covered by the second PUT must survive.
+	v, err := grayscale.NewExtHandler(subvol, nil)
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = GetVoxels(ctx, grayscale, v, nil); err != nil {
+		t.Fatalf("Unable to get voxels for %s: %s\n", ctx, err.Error())
+	}
+	got := v.Data()
+	for z := int32(0); z < size[2]; z++ {
+		for y := int32(0); y < size[1]; y++ {
+			i := (z*size[1] + y) * size[0]
+			if !bytes.Equal(got[i:i+20], first[i:i+20]) {
+				t.Fatalf("Voxels of first PUT at y %d, z %d were lost\n", y+offset[1], z+offset[2])
+			}
+			j := (z*size[1] + y) * size[0]
+			if !bytes.Equal(got[i+20:i+40], second[j:j+20]) {
+				t.Fatalf("Voxels of second PUT at y %d, z %d were lost\n", y+offset[1], z+offset[2])
+			}
+		}
+	}
+}
+
+func TestDeferredCommitAndDelete(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	grayscale.DeferWrites = true
+
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	subvol := dvid.NewSubvolume(offset, size)
+	data := makeVolume(offset, size)
+	putVoxels := func(versionID dvid.VersionID) {
+		v, err := grayscale.NewExtHandler(subvol, data)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		ctx := datastore.NewVersionedContext(grayscale, versionID)
+		if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+			t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+		}
+	}
+	numQueued := func(w *deferredWriter) int {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return len(w.pending)
+	}
+
+	// Committing a node writes the blocks queued for it.
+	putVoxels(versionID)
+	w := findDeferredWriter(grayscale)
+	if w == nil || numQueued(w) == 0 {
+		t.Fatalf("Expected blocks to be queued for deferred writes\n")
+	}
+	if err := repo.Lock(uuid); err != nil {
+		t.Fatalf("Unable to lock node %s: %s\n", uuid, err.Error())
+	}
+	if n := numQueued(w); n != 0 {
+		t.Errorf("Expected queued blocks to be written on commit, %d still queued\n", n)
+	}
+	v, err := grayscale.NewExtHandler(subvol, nil)
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = GetVoxels(datastore.NewVersionedContext(grayscale, versionID), grayscale, v, nil); err != nil {
+		t.Fatalf("Unable to get voxels of committed node: %s\n", err.Error())
+	}
+	if !bytes.Equal(v.Data(), data) {
+		t.Errorf("Voxels of committed node don't match the PUT voxels\n")
+	}
+
+	// Deleting the instance discards blocks still queued.
+	child, err := repo.NewVersion(uuid)
+	if err != nil {
+		t.Fatalf("Unable to create child of %s: %s\n", uuid, err.Error())
+	}
+	childID, err := datastore.VersionFromUUID(child)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	putVoxels(childID)
+	if numQueued(w) == 0 {
+		t.Fatalf("Expected blocks of child to be queued for deferred writes\n")
+	}
+	if err := repo.DeleteDataByName("grayscale"); err != nil {
+		t.Fatalf("Unable to delete grayscale instance: %s\n", err.Error())
+	}
+	if findDeferredWriter(grayscale) != nil {
+		t.Errorf("Expected deferred writer to be dropped when instance was deleted\n")
+	}
+	if n := numQueued(w); n != 0 {
+		t.Errorf("Expected queued blocks to be discarded when instance was deleted, %d still queued\n", n)
+	}
+}
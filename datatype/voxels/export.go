@@ -0,0 +1,546 @@
+/*
+	This file exports voxels to N5 or Zarr directory hierarchies, chunked volume formats
+	read by tools like BigDataViewer and Python's zarr and z5py packages.  Chunks with
+	only zero voxels, e.g., outside an ROI, aren't written since both formats treat
+	missing chunks as filled with zeros.
+*/
+
+package voxels
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// DefaultExportLevel is the gzip level of exported chunks if none is given.
+const DefaultExportLevel = 6
+
+// exportSpec describes the volume to export and how it is chunked.
+type exportSpec struct {
+	dir     string // container directory
+	dataset string // path of the dataset within the container
+
+	offset    dvid.Point3d
+	size      dvid.Point3d
+	chunkSize dvid.Point3d
+
+	values    dvid.DataValues
+	byteOrder binary.ByteOrder
+
+	compressed bool
+	level      int
+
+	resolution Resolution
+}
+
+func (spec *exportSpec) datasetDir() string {
+	return filepath.Join(spec.dir, filepath.FromSlash(spec.dataset))
+}
+
+// channels returns the number of values per voxel, which are exported along an extra
+// dimension if there is more than one.
+func (spec *exportSpec) channels() int32 {
+	return int32(len(spec.values))
+}
+
+// encode compresses chunk data if requested.
+func (spec *exportSpec) encode(data []byte) ([]byte, error) {
+	if !spec.compressed {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, spec.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// attributes returns the spatial attributes of the dataset in x, y, z order.
+func (spec *exportSpec) attributes() map[string]interface{} {
+	return map[string]interface{}{
+		"resolution": spec.resolution.VoxelSize,
+		"units":      spec.resolution.VoxelUnits,
+		"offset":     spec.offset,
+	}
+}
+
+// exportDataType returns the name of a value type and its kind, 'u', 'i', or 'f'.
+func exportDataType(t dvid.DataType) (string, byte, error) {
+	switch t {
+	case dvid.T_uint8:
+		return "uint8", 'u', nil
+	case dvid.T_int8:
+		return "int8", 'i', nil
+	case dvid.T_uint16:
+		return "uint16", 'u', nil
+	case dvid.T_int16:
+		return "int16", 'i', nil
+	case dvid.T_uint32:
+		return "uint32", 'u', nil
+	case dvid.T_int32:
+		return "int32", 'i', nil
+	case dvid.T_uint64:
+		return "uint64", 'u', nil
+	case dvid.T_int64:
+		return "int64", 'i', nil
+	case dvid.T_float32:
+		return "float32", 'f', nil
+	case dvid.T_float64:
+		return "float64", 'f', nil
+	default:
+		return "", 0, fmt.Errorf("Can't export values of unknown data type %d", t)
+	}
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// writeIfAbsent writes JSON to a path unless a file already exists there, so datasets
+// can be exported into an existing container.
+func writeIfAbsent(path string, v interface{}) error {
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	return writeJSON(path, v)
+}
+
+// exportFormat writes a chunked volume in a directory hierarchy.
+type exportFormat interface {
+	// writeMetadata creates the container and dataset and writes their attributes.
+	writeMetadata(spec *exportSpec) error
+
+	// writeChunk writes the voxels of the chunk at the given chunk coordinate.  The size
+	// of the voxels is smaller than the chunk size for chunks at the upper edges of the
+	// volume.
+	writeChunk(spec *exportSpec, coord, size dvid.Point3d, data []byte) error
+}
+
+func newExportFormat(name string) (exportFormat, error) {
+	switch strings.ToLower(name) {
+	case "n5":
+		return n5Format{}, nil
+	case "zarr":
+		return zarrFormat{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown export format %q, must be \"n5\" or \"zarr\"", name)
+	}
+}
+
+// n5Format writes N5 datasets, where dimensions are listed from fastest to slowest
+// varying and values are big endian.
+type n5Format struct{}
+
+func (n5Format) dims(spec *exportSpec, size dvid.Point3d) []int32 {
+	dims := []int32{size[0], size[1], size[2]}
+	if spec.channels() > 1 {
+		dims = append([]int32{spec.channels()}, dims...)
+	}
+	return dims
+}
+
+func (f n5Format) writeMetadata(spec *exportSpec) error {
+	if err := os.MkdirAll(spec.datasetDir(), 0755); err != nil {
+		return err
+	}
+	if err := writeIfAbsent(filepath.Join(spec.dir, "attributes.json"), map[string]string{"n5": "2.0.0"}); err != nil {
+		return err
+	}
+	dataType, _, err := exportDataType(spec.values[0].T)
+	if err != nil {
+		return err
+	}
+	compression := map[string]interface{}{"type": "raw"}
+	if spec.compressed {
+		compression = map[string]interface{}{"type": "gzip", "level": spec.level}
+	}
+	attrs := spec.attributes()
+	attrs["dimensions"] = f.dims(spec, spec.size)
+	attrs["blockSize"] = f.dims(spec, spec.chunkSize)
+	attrs["dataType"] = dataType
+	attrs["compression"] = compression
+	return writeJSON(filepath.Join(spec.datasetDir(), "attributes.json"), attrs)
+}
+
+func (f n5Format) writeChunk(spec *exportSpec, coord, size dvid.Point3d, data []byte) error {
+	// Header with the default block mode and the size of this block.
+	dims := f.dims(spec, size)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+	binary.Write(&buf, binary.BigEndian, uint16(len(dims)))
+	for _, dim := range dims {
+		binary.Write(&buf, binary.BigEndian, uint32(dim))
+	}
+
+	valueBytes, err := spec.values.BytesPerValue()
+	if err != nil {
+		return err
+	}
+	if valueBytes > 1 && spec.byteOrder != binary.BigEndian {
+		data = swapBytes(data, int(valueBytes))
+	}
+	payload, err := spec.encode(data)
+	if err != nil {
+		return err
+	}
+	buf.Write(payload)
+
+	path := []string{spec.datasetDir()}
+	if spec.channels() > 1 {
+		path = append(path, "0")
+	}
+	for _, c := range coord {
+		path = append(path, fmt.Sprintf("%d", c))
+	}
+	filename := filepath.Join(path...)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// zarrFormat writes Zarr version 2 arrays, where dimensions are listed from slowest to
+// fastest varying, i.e., z, y, x, and edge chunks are padded to the full chunk size.
+type zarrFormat struct{}
+
+func (zarrFormat) dims(spec *exportSpec, size dvid.Point3d) []int32 {
+	dims := []int32{size[2], size[1], size[0]}
+	if spec.channels() > 1 {
+		dims = append(dims, spec.channels())
+	}
+	return dims
+}
+
+func (f zarrFormat) writeMetadata(spec *exportSpec) error {
+	if err := os.MkdirAll(spec.datasetDir(), 0755); err != nil {
+		return err
+	}
+	if err := writeIfAbsent(filepath.Join(spec.dir, ".zgroup"), map[string]int{"zarr_format": 2}); err != nil {
+		return err
+	}
+	_, kind, err := exportDataType(spec.values[0].T)
+	if err != nil {
+		return err
+	}
+	valueBytes, err := spec.values.BytesPerValue()
+	if err != nil {
+		return err
+	}
+	endian := byte('<')
+	if valueBytes == 1 {
+		endian = '|'
+	} else if spec.byteOrder == binary.BigEndian {
+		endian = '>'
+	}
+	var compressor interface{}
+	if spec.compressed {
+		compressor = map[string]interface{}{"id": "gzip", "level": spec.level}
+	}
+	array := map[string]interface{}{
+		"zarr_format": 2,
+		"shape":       f.dims(spec, spec.size),
+		"chunks":      f.dims(spec, spec.chunkSize),
+		"dtype":       fmt.Sprintf("%c%c%d", endian, kind, valueBytes),
+		"compressor":  compressor,
+		"fill_value":  0,
+		"order":       "C",
+		"filters":     nil,
+	}
+	if err := writeJSON(filepath.Join(spec.datasetDir(), ".zarray"), array); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(spec.datasetDir(), ".zattrs"), spec.attributes())
+}
+
+func (f zarrFormat) writeChunk(spec *exportSpec, coord, size dvid.Point3d, data []byte) error {
+	if size != spec.chunkSize {
+		data = padChunk(data, size, spec.chunkSize, int(spec.values.BytesPerElement()))
+	}
+	payload, err := spec.encode(data)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%d.%d.%d", coord[2], coord[1], coord[0])
+	if spec.channels() > 1 {
+		key += ".0"
+	}
+	return ioutil.WriteFile(filepath.Join(spec.datasetDir(), key), payload, 0644)
+}
+
+// newExportSpec returns the export of this data to a directory given settings of an
+// export command.
+func (d *Data) newExportSpec(dir string, config dvid.Config) (*exportSpec, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("Export requires a directory")
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("Export only supported for 3d blocks, not %s", d.BlockSize())
+	}
+	if _, err := d.Values().ValueDataType(); err != nil {
+		return nil, err
+	}
+	spec := &exportSpec{
+		dir:        dir,
+		dataset:    string(d.DataName()),
+		chunkSize:  blockSize,
+		values:     d.Values(),
+		byteOrder:  d.ByteOrder,
+		compressed: true,
+		level:      DefaultExportLevel,
+		resolution: d.Resolution,
+	}
+
+	getPoint := func(key string) (dvid.Point3d, bool, error) {
+		s, found, err := config.GetString(key)
+		if err != nil || !found {
+			return dvid.Point3d{}, found, err
+		}
+		pt, err := dvid.StringToPoint(s, ",")
+		if err != nil {
+			return dvid.Point3d{}, true, err
+		}
+		pt3d, ok := pt.(dvid.Point3d)
+		if !ok {
+			return dvid.Point3d{}, true, fmt.Errorf("Setting %q must be a 3d point, not %q", key, s)
+		}
+		return pt3d, true, nil
+	}
+	positive := func(key string, pt dvid.Point3d) error {
+		if pt[0] <= 0 || pt[1] <= 0 || pt[2] <= 0 {
+			return fmt.Errorf("Setting %q must be positive, not %s", key, pt)
+		}
+		return nil
+	}
+
+	if s, found, err := config.GetString("dataset"); err != nil {
+		return nil, err
+	} else if found {
+		spec.dataset = strings.Trim(s, "/")
+	}
+	if chunkSize, found, err := getPoint("chunksize"); err != nil {
+		return nil, err
+	} else if found {
+		if err := positive("chunksize", chunkSize); err != nil {
+			return nil, err
+		}
+		spec.chunkSize = chunkSize
+	}
+	if s, found, err := config.GetString("compression"); err != nil {
+		return nil, err
+	} else if found {
+		switch strings.ToLower(s) {
+		case "gzip":
+		case "raw", "none":
+			spec.compressed = false
+		default:
+			return nil, fmt.Errorf("Unknown export compression %q, must be \"gzip\" or \"raw\"", s)
+		}
+	}
+	if level, found, err := config.GetInt("level"); err != nil {
+		return nil, err
+	} else if found {
+		if level < gzip.NoCompression || level > gzip.BestCompression {
+			return nil, fmt.Errorf("Export compression level must be from %d to %d, not %d",
+				gzip.NoCompression, gzip.BestCompression, level)
+		}
+		spec.level = level
+	}
+
+	// Export the extents of stored voxels unless a subvolume is given.
+	offset, foundOffset, err := getPoint("offset")
+	if err != nil {
+		return nil, err
+	}
+	size, foundSize, err := getPoint("size")
+	if err != nil {
+		return nil, err
+	}
+	if !foundOffset || !foundSize {
+		extents := d.Extents()
+		minPt, minOK := extents.MinPoint.(dvid.Point3d)
+		maxPt, maxOK := extents.MaxPoint.(dvid.Point3d)
+		if !minOK || !maxOK {
+			return nil, fmt.Errorf("Data %q has no stored voxels, so export requires offset and size", d.DataName())
+		}
+		if !foundOffset {
+			offset = minPt
+		}
+		if !foundSize {
+			size = maxPt.Sub(offset).AddScalar(1).(dvid.Point3d)
+		}
+	}
+	if err := positive("size", size); err != nil {
+		return nil, err
+	}
+	spec.offset = offset
+	spec.size = size
+	return spec, nil
+}
+
+// Export writes the voxels of a version, optionally masked by an ROI, to an N5 or Zarr
+// directory hierarchy.  Unless the request is already run as a background job, the
+// export is started as one and its job ID is returned.
+func (d *Data) Export(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, formatStr, dir string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &formatStr, &dir)
+
+	format, err := newExportFormat(formatStr)
+	if err != nil {
+		return err
+	}
+	config := request.Settings()
+	spec, err := d.newExportSpec(dir, config)
+	if err != nil {
+		return err
+	}
+	roiname, _, err := config.GetString("roi")
+	if err != nil {
+		return err
+	}
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+
+	if request.InJob() {
+		reply.Text, err = d.export(request, versionID, format, spec, dvid.DataString(roiname))
+		return err
+	}
+	job, err := server.StartJob(request.Command.String(), "rpc", func(handle *server.JobHandle) (string, error) {
+		request.SetJob(handle)
+		return d.export(request, versionID, format, spec, dvid.DataString(roiname))
+	})
+	if err != nil {
+		return err
+	}
+	reply.Text = fmt.Sprintf("Started job %s to export %q, version %s, to %s.  Check its progress via /api/server/jobs/%s\n",
+		job.ID, d.DataName(), uuid, dir, job.ID)
+	return nil
+}
+
+func (d *Data) export(request datastore.Request, versionID dvid.VersionID, format exportFormat, spec *exportSpec, roiname dvid.DataString) (string, error) {
+	timedLog := dvid.NewTimeLog()
+
+	var roiptr *ROI
+	if roiname != "" {
+		it, err := roi.NewIterator(roiname, versionID, dvid.NewSubvolume(spec.offset, spec.size))
+		if err != nil {
+			return "", err
+		}
+		roiptr = &ROI{Iter: it}
+	}
+	if err := format.writeMetadata(spec); err != nil {
+		return "", fmt.Errorf("Unable to write export metadata to %s: %s", spec.dir, err.Error())
+	}
+
+	ctx := datastore.NewVersionedContext(d, versionID)
+	ctx.SetDone(request.Done())
+
+	var numChunks dvid.Point3d
+	for i := range numChunks {
+		numChunks[i] = (spec.size[i] + spec.chunkSize[i] - 1) / spec.chunkSize[i]
+	}
+	total := int(numChunks[0]) * int(numChunks[1]) * int(numChunks[2])
+	request.JobLogf("Exporting %d chunks of %q to %s\n", total, d.DataName(), spec.datasetDir())
+
+	var done, written int
+	var coord dvid.Point3d
+	for coord[2] = 0; coord[2] < numChunks[2]; coord[2]++ {
+		for coord[1] = 0; coord[1] < numChunks[1]; coord[1]++ {
+			for coord[0] = 0; coord[0] < numChunks[0]; coord[0]++ {
+				if request.Canceled() {
+					return "", fmt.Errorf("Export of %q canceled after %d of %d chunks", d.DataName(), done, total)
+				}
+				server.BlockOnInteractiveRequests("voxels [export]")
+
+				var offset, size dvid.Point3d
+				for i := range offset {
+					offset[i] = spec.offset[i] + coord[i]*spec.chunkSize[i]
+					size[i] = spec.chunkSize[i]
+					if end := spec.offset[i] + spec.size[i]; offset[i]+size[i] > end {
+						size[i] = end - offset[i]
+					}
+				}
+				e, err := d.NewExtHandler(dvid.NewSubvolume(offset, size), nil)
+				if err != nil {
+					return "", err
+				}
+				if roiptr != nil {
+					roiptr.Iter.Reset()
+				}
+				if err := GetVoxels(ctx, d, e, roiptr); err != nil {
+					return "", err
+				}
+				if !allZero(e.Data()) {
+					if err := format.writeChunk(spec, coord, size, e.Data()); err != nil {
+						return "", fmt.Errorf("Unable to write chunk %s: %s", coord, err.Error())
+					}
+					written++
+				}
+				done++
+				request.Progress(100 * float64(done) / float64(total))
+			}
+		}
+	}
+	result := fmt.Sprintf("Exported %d non-empty chunks out of %d for %q to %s\n", written, total, d.DataName(), spec.datasetDir())
+	timedLog.Infof("Exported %d chunks of %q to %s", written, d.DataName(), spec.datasetDir())
+	return result, nil
+}
+
+// padChunk returns voxels of the given size copied into a zeroed chunk of a larger size.
+func padChunk(data []byte, size, chunkSize dvid.Point3d, bytesPerVoxel int) []byte {
+	padded := make([]byte, int(chunkSize[0]*chunkSize[1]*chunkSize[2])*bytesPerVoxel)
+	rowBytes := int(size[0]) * bytesPerVoxel
+	for z := int32(0); z < size[2]; z++ {
+		for y := int32(0); y < size[1]; y++ {
+			src := int(z*size[1]+y) * rowBytes
+			dst := int((z*chunkSize[1]+y)*chunkSize[0]) * bytesPerVoxel
+			copy(padded[dst:dst+rowBytes], data[src:src+rowBytes])
+		}
+	}
+	return padded
+}
+
+// swapBytes returns a copy of the data with the byte order of each value reversed.
+func swapBytes(data []byte, valueBytes int) []byte {
+	swapped := make([]byte, len(data))
+	for i := 0; i+valueBytes <= len(data); i += valueBytes {
+		for j := 0; j < valueBytes; j++ {
+			swapped[i+j] = data[i+valueBytes-1-j]
+		}
+	}
+	return swapped
+}
+
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package voxels
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// expectedChunk returns the voxels of a chunk within a volume stored at the origin.
+func expectedChunk(volume []byte, volSize, offset, size dvid.Point3d) []byte {
+	chunk := make([]byte, 0, size[0]*size[1]*size[2])
+	for z := offset[2]; z < offset[2]+size[2]; z++ {
+		for y := offset[1]; y < offset[1]+size[1]; y++ {
+			i := (z*volSize[1]+y)*volSize[0] + offset[0]
+			chunk = append(chunk, volume[i:i+size[0]]...)
+		}
+	}
+	return chunk
+}
+
+func TestExportGrayscale8(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	data := makeVolume(offset, size)
+	v, err := grayscale.NewExtHandler(dvid.NewSubvolume(offset, size), data)
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+		t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+	}
+	volume := makeVolume(offset, size)
+
+	dir, err := ioutil.TempDir("", "dvid-export")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// Zarr chunks at the volume edges are padded.
+	config := dvid.NewConfig()
+	config.Set("chunksize", "32,32,32")
+	config.Set("compression", "raw")
+	spec, err := grayscale.newExportSpec(filepath.Join(dir, "vol.zarr"), config)
+	if err != nil {
+		t.Fatalf("Bad export settings: %s\n", err.Error())
+	}
+	if spec.offset != offset || spec.size != size {
+		t.Fatalf("Expected export of stored extents, got offset %s, size %s\n", spec.offset, spec.size)
+	}
+	format, _ := newExportFormat("zarr")
+	if _, err := grayscale.export(datastore.Request{}, versionID, format, spec, ""); err != nil {
+		t.Fatalf("Unable to export zarr: %s\n", err.Error())
+	}
+	var array struct {
+		Shape  []int32
+		Chunks []int32
+		Dtype  string
+	}
+	arrayJSON, err := ioutil.ReadFile(filepath.Join(spec.datasetDir(), ".zarray"))
+	if err != nil {
+		t.Fatalf("Unable to read .zarray: %s\n", err.Error())
+	}
+	if err := json.Unmarshal(arrayJSON, &array); err != nil {
+		t.Fatalf("Bad .zarray: %s\n", err.Error())
+	}
+	if array.Dtype != "|u1" || len(array.Shape) != 3 || array.Shape[0] != 40 || array.Chunks[2] != 32 {
+		t.Errorf("Bad .zarray: %s\n", string(arrayJSON))
+	}
+	chunk, err := ioutil.ReadFile(filepath.Join(spec.datasetDir(), "1.0.1"))
+	if err != nil {
+		t.Fatalf("Unable to read zarr chunk: %s\n", err.Error())
+	}
+	if len(chunk) != 32*32*32 {
+		t.Fatalf("Expected padded zarr chunk of %d bytes, got %d\n", 32*32*32, len(chunk))
+	}
+	expected := expectedChunk(volume, size, dvid.Point3d{32, 0, 32}, dvid.Point3d{8, 32, 8})
+	for z := 0; z < 8; z++ {
+		for y := 0; y < 32; y++ {
+			got := chunk[(z*32+y)*32 : (z*32+y)*32+8]
+			if !bytes.Equal(got, expected[(z*32+y)*8:(z*32+y+1)*8]) {
+				t.Fatalf("Bad zarr chunk row at y %d, z %d\n", y, z)
+			}
+		}
+	}
+
+	// N5 chunks at the volume edges are truncated, and a subvolume can be exported.
+	config = dvid.NewConfig()
+	config.Set("offset", "20,20,30")
+	config.Set("size", "30,40,40")
+	config.Set("dataset", "raw/s0")
+	spec, err = grayscale.newExportSpec(filepath.Join(dir, "vol.n5"), config)
+	if err != nil {
+		t.Fatalf("Bad export settings: %s\n", err.Error())
+	}
+	format, _ = newExportFormat("n5")
+	if _, err := grayscale.export(datastore.Request{}, versionID, format, spec, ""); err != nil {
+		t.Fatalf("Unable to export n5: %s\n", err.Error())
+	}
+	block, err := ioutil.ReadFile(filepath.Join(dir, "vol.n5", "raw", "s0", "0", "1", "0"))
+	if err != nil {
+		t.Fatalf("Unable to read n5 block: %s\n", err.Error())
+	}
+	var header struct {
+		Mode, NumDims uint16
+		Dims          [3]uint32
+	}
+	buf := bytes.NewBuffer(block)
+	if err := binary.Read(buf, binary.BigEndian, &header); err != nil {
+		t.Fatalf("Bad n5 block header: %s\n", err.Error())
+	}
+	if header.Mode != 0 || header.NumDims != 3 || header.Dims != [3]uint32{30, 8, 32} {
+		t.Fatalf("Bad n5 block header: %v\n", header)
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatalf("Bad n5 block compression: %s\n", err.Error())
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Bad n5 block compression: %s\n", err.Error())
+	}
+	expected = expectedChunk(volume, size, dvid.Point3d{10, 32, 0}, dvid.Point3d{30, 8, 32})
+	if !bytes.Equal(got, expected) {
+		t.Errorf("Bad n5 block voxels\n")
+	}
+}
+
+func TestExportN5ByteOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-export")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	spec := &exportSpec{
+		dir:       dir,
+		dataset:   "labels",
+		size:      dvid.Point3d{2, 1, 1},
+		chunkSize: dvid.Point3d{2, 1, 1},
+		values:    dvid.DataValues{{T: dvid.T_uint16, Label: "label"}},
+		byteOrder: binary.LittleEndian,
+	}
+	format := n5Format{}
+	if err := format.writeMetadata(spec); err != nil {
+		t.Fatalf("Unable to write n5 metadata: %s\n", err.Error())
+	}
+	if err := format.writeChunk(spec, dvid.Point3d{0, 0, 0}, spec.size, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("Unable to write n5 block: %s\n", err.Error())
+	}
+	block, err := ioutil.ReadFile(filepath.Join(dir, "labels", "0", "0", "0"))
+	if err != nil {
+		t.Fatalf("Unable to read n5 block: %s\n", err.Error())
+	}
+	if !bytes.Equal(block[16:], []byte{2, 1, 4, 3}) {
+		t.Errorf("Expected big endian n5 values, got %v\n", block[16:])
+	}
+}
@@ -0,0 +1,33 @@
+/*
+	Data type grayscale16 tailors the voxels data type for 16-bit grayscale images.  It simply
+	wraps the voxels package, setting Channels (1) and BytesPerValue(2).
+*/
+
+package voxels
+
+import (
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var grayscale16EncodeFormat dvid.DataValues
+
+func init() {
+	grayscale16EncodeFormat = dvid.DataValues{
+		{
+			T:     dvid.T_uint16,
+			Label: "grayscale",
+		},
+	}
+	interpolable := true
+	grayscale := NewType(grayscale16EncodeFormat, interpolable)
+	grayscale.Type.Name = "grayscale16"
+	grayscale.Type.URL = "github.com/janelia-flyem/dvid/datatype/voxels/grayscale16.go"
+	grayscale.Type.Version = "0.1"
+
+	datastore.Register(grayscale)
+}
+
+func Grayscale16EncodeFormat() dvid.DataValues {
+	return grayscale16EncodeFormat
+}
@@ -0,0 +1,33 @@
+/*
+	Data type grayscale32 tailors the voxels data type for 32-bit grayscale images.  It simply
+	wraps the voxels package, setting Channels (1) and BytesPerValue(4).
+*/
+
+package voxels
+
+import (
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var grayscale32EncodeFormat dvid.DataValues
+
+func init() {
+	grayscale32EncodeFormat = dvid.DataValues{
+		{
+			T:     dvid.T_uint32,
+			Label: "grayscale",
+		},
+	}
+	interpolable := true
+	grayscale := NewType(grayscale32EncodeFormat, interpolable)
+	grayscale.Type.Name = "grayscale32"
+	grayscale.Type.URL = "github.com/janelia-flyem/dvid/datatype/voxels/grayscale32.go"
+	grayscale.Type.Version = "0.1"
+
+	datastore.Register(grayscale)
+}
+
+func Grayscale32EncodeFormat() dvid.DataValues {
+	return grayscale32EncodeFormat
+}
@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"image/png"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 
@@ -18,7 +20,9 @@ import (
 	"github.com/janelia-flyem/dvid/datatype/roi"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
 	"github.com/janelia-flyem/dvid/tests"
+	"github.com/janelia-flyem/go/snappy-go/snappy"
 )
 
 var (
@@ -520,6 +524,289 @@ func TestBlockAPI(t *testing.T) {
 	}
 }
 
+func TestStreamBlocks(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, _ := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	uuid := repo.RootUUID()
+
+	// Store two blocks along x and one block in the next row, leaving a gap.
+	numBlockBytes := int32(grayscale.BlockSize().Prod())
+	stored := map[dvid.ChunkPoint3d][]byte{}
+	put := func(x, y, z int32, n int) {
+		var data []byte
+		for i := int32(0); i < int32(n); i++ {
+			block := tests.RandomBytes(numBlockBytes)
+			stored[dvid.ChunkPoint3d{x + i, y, z}] = block
+			data = append(data, block...)
+		}
+		blockReq := fmt.Sprintf("%snode/%s/grayscale/blocks/%d_%d_%d/%d", server.WebAPIPath, uuid, x, y, z, n)
+		server.TestHTTP(t, "POST", blockReq, bytes.NewBuffer(data))
+	}
+	put(10, 20, 30, 2)
+	put(11, 21, 30, 1)
+	expected := []dvid.ChunkPoint3d{{10, 20, 30}, {11, 20, 30}, {11, 21, 30}}
+
+	for _, compression := range []string{"", "snappy"} {
+		streamReq := fmt.Sprintf("%snode/%s/grayscale/blocks/10_20_30/3/2/1", server.WebAPIPath, uuid)
+		if compression != "" {
+			streamReq += "?compression=" + compression
+		}
+		buf := bytes.NewReader(server.TestHTTP(t, "GET", streamReq, nil))
+
+		var frames []dvid.ChunkPoint3d
+		for {
+			var header [4]int32
+			if err := binary.Read(buf, binary.LittleEndian, &header); err != nil {
+				t.Fatalf("Stream with compression %q ended without terminating frame: %s\n", compression, err.Error())
+			}
+			if header[3] == -1 {
+				break
+			}
+			coord := dvid.ChunkPoint3d{header[0], header[1], header[2]}
+			data := make([]byte, header[3])
+			if _, err := io.ReadFull(buf, data); err != nil {
+				t.Fatalf("Unable to read block %s: %s\n", coord, err.Error())
+			}
+			if compression == "snappy" {
+				var err error
+				if data, err = snappy.Decode(nil, data); err != nil {
+					t.Fatalf("Unable to decode snappy block %s: %s\n", coord, err.Error())
+				}
+			}
+			if !bytes.Equal(data, stored[coord]) {
+				t.Errorf("Streamed block %s with compression %q differs from stored block\n", coord, compression)
+			}
+			frames = append(frames, coord)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Expected stream to end after terminating frame, got %d more bytes\n", buf.Len())
+		}
+		if !reflect.DeepEqual(frames, expected) {
+			t.Errorf("Expected blocks %v in ZYX order with compression %q, got %v\n", expected, compression, frames)
+		}
+	}
+
+	// An empty span has only the terminating frame.
+	streamReq := fmt.Sprintf("%snode/%s/grayscale/blocks/0_0_0/2/2/2", server.WebAPIPath, uuid)
+	returnedData := server.TestHTTP(t, "GET", streamReq, nil)
+	if len(returnedData) != 16 || binary.LittleEndian.Uint32(returnedData[12:]) != 0xFFFFFFFF {
+		t.Errorf("Expected only a terminating frame for empty span, got %v\n", returnedData)
+	}
+}
+
+func TestCommitBlocks(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	uuid := repo.RootUUID()
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	numBlockBytes := int32(grayscale.BlockSize().Prod())
+	block1 := tests.RandomBytes(numBlockBytes)
+	block2 := tests.RandomBytes(numBlockBytes)
+	ops := []datastore.BatchOp{{Key: "1_2_3", Value: block1}, {Key: "2_2_3", Value: block2}}
+	args, err := json.Marshal(struct{ Ops []datastore.BatchOp }{ops})
+	if err != nil {
+		t.Fatalf("Unable to encode mutation: %s\n", err.Error())
+	}
+
+	// Blocks of the wrong size are rejected before anything is written.
+	badArgs, _ := json.Marshal(struct{ Ops []datastore.BatchOp }{[]datastore.BatchOp{{Key: "1_2_3", Value: block1[:10]}}})
+	if _, err := datastore.Commit(uuid, "bad", []datastore.Mutation{{"grayscale", "blocks", badArgs}}); err == nil {
+		t.Errorf("Expected error on commit of short block\n")
+	}
+
+	// Commits recording more than the maximum bytes of values are rejected.
+	maxCommitBytes := datastore.MaxCommitBytes
+	datastore.MaxCommitBytes = int(numBlockBytes)
+	_, err = datastore.Commit(uuid, "large", []datastore.Mutation{{"grayscale", "blocks", args}})
+	datastore.MaxCommitBytes = maxCommitBytes
+	if err == nil {
+		t.Errorf("Expected error on commit larger than the maximum commit bytes\n")
+	}
+	if blocks, err := ReadBlocks(ctx, []dvid.ChunkPoint3d{{1, 2, 3}}); err != nil || blocks[0] != nil {
+		t.Errorf("Expected no blocks written by rejected commit, got error %v\n", err)
+	}
+
+	record, err := datastore.Commit(uuid, "blocks", []datastore.Mutation{{"grayscale", "blocks", args}})
+	if err != nil {
+		t.Fatalf("Error on commit: %s\n", err.Error())
+	}
+	coords := []dvid.ChunkPoint3d{{1, 2, 3}, {2, 2, 3}}
+	blocks, err := ReadBlocks(ctx, coords)
+	if err != nil {
+		t.Fatalf("Error reading blocks: %s\n", err.Error())
+	}
+	if !bytes.Equal(blocks[0], block1) || !bytes.Equal(blocks[1], block2) {
+		t.Errorf("Committed blocks differ from read blocks\n")
+	}
+	if maxIndex := grayscale.Extents().MaxIndex; maxIndex == nil || maxIndex.Value(0) != 2 {
+		t.Errorf("Expected extents to include committed blocks, got max index %v\n", maxIndex)
+	}
+
+	if err := datastore.UndoCommit(record.ID); err != nil {
+		t.Fatalf("Error undoing commit: %s\n", err.Error())
+	}
+	if blocks, err = ReadBlocks(ctx, coords); err != nil || blocks[0] != nil || blocks[1] != nil {
+		t.Errorf("Expected no blocks after undo, got error %v\n", err)
+	}
+}
+
+func TestStorageQuotaWrites(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	uuid := repo.RootUUID()
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	numBlockBytes := int32(grayscale.BlockSize().Prod())
+	if err := grayscale.IngestBatch(ctx, []datastore.BatchOp{{Key: "0_0_0", Value: tests.RandomBytes(numBlockBytes)}}); err != nil {
+		t.Fatalf("Unable to ingest block: %s\n", err.Error())
+	}
+	if _, err := datastore.StorageUsage(true); err != nil {
+		t.Fatalf("Unable to scan storage usage: %s\n", err.Error())
+	}
+	if err := repo.SetStorageQuota(datastore.StorageQuota{MaxBytes: 1}); err != nil {
+		t.Fatalf("Unable to set storage quota: %s\n", err.Error())
+	}
+
+	// Atomic commits are rejected once the quota is reached.
+	args, _ := json.Marshal(struct{ Ops []datastore.BatchOp }{[]datastore.BatchOp{{Key: "1_0_0", Value: tests.RandomBytes(numBlockBytes)}}})
+	_, err := datastore.Commit(uuid, "over quota", []datastore.Mutation{{"grayscale", "blocks", args}})
+	if _, ok := err.(*datastore.QuotaExceededError); !ok {
+		t.Errorf("Expected quota error on commit, got %v\n", err)
+	}
+
+	// So are chunks of RPC batch sessions.
+	var c server.RPCConnection
+	var session string
+	if err := c.BeginBatch(server.BatchStart{UUID: uuid, DataName: "grayscale"}, &session); err != nil {
+		t.Fatalf("Unable to begin batch session: %s\n", err.Error())
+	}
+	var result server.BatchResult
+	chunk := server.BatchChunk{Session: session, Ops: []datastore.BatchOp{{Key: "2_0_0", Value: tests.RandomBytes(numBlockBytes)}}}
+	if err := c.SendBatch(chunk, &result); err != nil {
+		t.Fatalf("Unable to send batch chunk: %s\n", err.Error())
+	}
+	var summary server.BatchSummary
+	if err := c.EndBatch(session, &summary); err != nil {
+		t.Fatalf("Unable to end batch session: %s\n", err.Error())
+	}
+	if len(summary.Failed) != 1 || !strings.Contains(summary.Failed[0].Error, "storage quota") {
+		t.Errorf("Expected batch chunk to fail on storage quota, got %+v\n", summary)
+	}
+
+	blocks, err := ReadBlocks(ctx, []dvid.ChunkPoint3d{{1, 0, 0}, {2, 0, 0}})
+	if err != nil {
+		t.Fatalf("Error reading blocks: %s\n", err.Error())
+	}
+	if blocks[0] != nil || blocks[1] != nil {
+		t.Errorf("Expected no blocks written over quota\n")
+	}
+}
+
+func TestCopyOnWriteChild(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	if err := storage.EnableCopyOnWrite(); err != nil {
+		t.Fatalf("Unable to enable copy-on-write: %s\n", err.Error())
+	}
+	repo, _ := initTestRepo()
+	makeGrayscale(repo, t, "grayscale")
+	uuid := repo.RootUUID()
+
+	offset := dvid.Point3d{0, 0, 0}
+	size := dvid.Point3d{64, 64, 64}
+	data := makeVolume(offset, size)
+	apiStr := fmt.Sprintf("%snode/%s/grayscale/raw/0_1_2/64_64_64/0_0_0", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", apiStr, bytes.NewBuffer(data))
+
+	if err := repo.Lock(uuid); err != nil {
+		t.Fatalf("Unable to lock node %s: %s\n", uuid, err.Error())
+	}
+	child, err := repo.NewVersion(uuid)
+	if err != nil {
+		t.Fatalf("Unable to create child of %s: %s\n", uuid, err.Error())
+	}
+
+	// Rewriting an unchanged subvolume in the child shouldn't store any blocks.
+	before, _ := storage.CopyOnWriteStatistics()
+	apiStr = fmt.Sprintf("%snode/%s/grayscale/raw/0_1_2/64_64_64/0_0_0", server.WebAPIPath, child)
+	server.TestHTTP(t, "POST", apiStr, bytes.NewBuffer(data))
+	after, _ := storage.CopyOnWriteStatistics()
+	writes := after.Writes - before.Writes
+	deduped := after.Deduped - before.Deduped
+	if writes == 0 || deduped != writes {
+		t.Errorf("Expected all %d block writes to child to be deduped, got %d\n", writes, deduped)
+	}
+
+	returned := server.TestHTTP(t, "GET", apiStr, nil)
+	if !bytes.Equal(returned, data) {
+		t.Errorf("Voxels read from child don't match the POSTed voxels\n")
+	}
+}
+
+func TestCanceledGetVoxels(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	offset := dvid.Point3d{5, 35, 61}
+	size := dvid.Point3d{100, 100, 100}
+	subvol := dvid.NewSubvolume(offset, size)
+	v, err := grayscale.NewExtHandler(subvol, makeVolume(offset, size))
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+		t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+	}
+
+	// Reads succeed until the request is canceled.
+	done := make(chan struct{})
+	readCtx := datastore.NewVersionedContext(grayscale, versionID)
+	readCtx.SetDone(done)
+	getVoxels := func() error {
+		v, err := grayscale.NewExtHandler(subvol, nil)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		return GetVoxels(readCtx, grayscale, v, nil)
+	}
+	if err := getVoxels(); err != nil {
+		t.Fatalf("Unable to get voxels before cancel: %s\n", err.Error())
+	}
+	close(done)
+	if err := getVoxels(); err != storage.ErrCanceled {
+		t.Errorf("Expected canceled GET to return %v, got %v\n", storage.ErrCanceled, err)
+	}
+
+	// Range scans of the store stop too.
+	db, err := storage.BigDataStore()
+	if err != nil {
+		t.Fatalf("Unable to get store: %s\n", err.Error())
+	}
+	begIndex, endIndex := dvid.IndexZYX{0, 0, 0}, dvid.IndexZYX{10, 10, 10}
+	keyBeg, keyEnd := NewVoxelBlockIndex(&begIndex), NewVoxelBlockIndex(&endIndex)
+	if keys, err := db.KeysInRange(ctx, keyBeg, keyEnd); err != nil || len(keys) == 0 {
+		t.Fatalf("Expected stored blocks in range, got %d keys (%v)\n", len(keys), err)
+	}
+	if _, err := db.KeysInRange(readCtx, keyBeg, keyEnd); err != storage.ErrCanceled {
+		t.Errorf("Expected canceled range scan to return %v, got %v\n", storage.ErrCanceled, err)
+	}
+}
+
 // Should intersect 100x100 image at Z = 67 and Y = 108
 const testROIJson = "[[2,3,10,10],[2,4,12,13]]"
 
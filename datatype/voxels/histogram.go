@@ -0,0 +1,318 @@
+/*
+	This file supports precomputed intensity histograms for voxel blocks.  Histograms are
+	stored in their own keyspace so viewers can estimate contrast over large regions
+	without reading any voxel blocks.
+*/
+
+package voxels
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// HistogramBins is the number of bins in a block histogram, one per 8-bit intensity.
+const HistogramBins = 256
+
+// Histogram holds the number of voxels for each 8-bit intensity.
+type Histogram [HistogramBins]uint64
+
+// NewBlockHistogram returns the histogram of a block of 1 byte/voxel data.
+func NewBlockHistogram(data []byte) *Histogram {
+	h := new(Histogram)
+	for _, value := range data {
+		h[value]++
+	}
+	return h
+}
+
+// Add accumulates the counts of another histogram into the receiver.
+func (h *Histogram) Add(h2 *Histogram) {
+	for i := 0; i < HistogramBins; i++ {
+		h[i] += h2[i]
+	}
+}
+
+// NumVoxels returns the total number of voxels tallied in the histogram.
+func (h *Histogram) NumVoxels() uint64 {
+	var total uint64
+	for _, count := range h {
+		total += count
+	}
+	return total
+}
+
+// Percentile returns the lowest intensity at which the cumulative voxel count reaches
+// the given percentage (0 to 100) of all voxels.  An empty histogram returns 0.
+func (h *Histogram) Percentile(percent float64) uint8 {
+	total := h.NumVoxels()
+	if total == 0 {
+		return 0
+	}
+	threshold := uint64(percent / 100.0 * float64(total))
+	var cumulative uint64
+	for i, count := range h {
+		cumulative += count
+		if cumulative > 0 && cumulative >= threshold {
+			return uint8(i)
+		}
+	}
+	return HistogramBins - 1
+}
+
+// Range returns the minimum and maximum intensities with non-zero counts.
+func (h *Histogram) Range() (min, max uint8) {
+	min, max = HistogramBins-1, 0
+	for i, count := range h {
+		if count == 0 {
+			continue
+		}
+		if uint8(i) < min {
+			min = uint8(i)
+		}
+		max = uint8(i)
+	}
+	if min > max {
+		min = 0
+	}
+	return
+}
+
+// MarshalBinary fulfills the encoding.BinaryMarshaler interface and stores the
+// bins as little endian uint64.
+func (h *Histogram) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, h[:]); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary fulfills the encoding.BinaryUnmarshaler interface.
+func (h *Histogram) UnmarshalBinary(b []byte) error {
+	if len(b) != HistogramBins*8 {
+		return fmt.Errorf("Bad histogram serialization.  Has length %d bytes != %d", len(b), HistogramBins*8)
+	}
+	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, h[:])
+}
+
+// putBlockHistogram computes and stores the histogram for a block.  It is a no-op for
+// data that isn't 1 byte/voxel.
+func (d *Data) putBlockHistogram(ctx storage.Context, index *dvid.IndexZYX, blockData []byte) error {
+	if d.Values().BytesPerElement() != 1 {
+		return nil
+	}
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return err
+	}
+	histBytes, err := NewBlockHistogram(blockData).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	serialization, err := dvid.SerializeData(histBytes, d.Compression().Lossless(), d.Checksum())
+	if err != nil {
+		return err
+	}
+	return smalldata.Put(ctx, NewBlockHistogramIndex(index), serialization)
+}
+
+// ComputeHistograms launches a job that computes histograms for all stored blocks of a
+// version.  This is necessary after bulk loads, which bypass histogram computation.
+func (d *Data) ComputeHistograms(request datastore.Request, reply *datastore.Response) error {
+	if d.Values().BytesPerElement() != 1 {
+		return fmt.Errorf("Block histograms only implemented for 1 byte/voxel data!")
+	}
+
+	var uuidStr, dataName, cmdStr string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr)
+
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	go d.computeHistograms(versionID)
+
+	reply.Text = fmt.Sprintf("Started computation of block histograms for %q, version %s\n", d.DataName(), uuid)
+	return nil
+}
+
+func (d *Data) computeHistograms(versionID dvid.VersionID) {
+	timedLog := dvid.NewTimeLog()
+	timedLog.Infof("Starting block histogram computation for %s", d.DataName())
+
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		dvid.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+		return
+	}
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		dvid.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+		return
+	}
+	batcher, ok := smalldata.(storage.KeyValueBatcher)
+	if !ok {
+		dvid.Errorf("Unable to compute block histograms: small data store can't do batching!")
+		return
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+
+	var numBlocks int
+	batch := batcher.NewBatch(ctx)
+	minIndex := NewVoxelBlockIndex(&dvid.MinIndexZYX)
+	maxIndex := NewVoxelBlockIndex(&dvid.MaxIndexZYX)
+	err = bigdata.ProcessRange(ctx, minIndex, maxIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if chunk == nil || chunk.V == nil {
+			return
+		}
+		indexZYX, err := DecodeVoxelBlockKey(chunk.K)
+		if err != nil {
+			dvid.Errorf("Error decoding voxel block key: %s\n", err.Error())
+			return
+		}
+		data, _, err := dvid.DeserializeData(chunk.V, true)
+		if err != nil {
+			dvid.Errorf("Error decoding block: %s\n", err.Error())
+			return
+		}
+		histBytes, err := NewBlockHistogram(data).MarshalBinary()
+		if err != nil {
+			dvid.Errorf("Error serializing block histogram: %s\n", err.Error())
+			return
+		}
+		serialization, err := dvid.SerializeData(histBytes, d.Compression().Lossless(), d.Checksum())
+		if err != nil {
+			dvid.Errorf("Unable to serialize block histogram: %s\n", err.Error())
+			return
+		}
+		batch.Put(NewBlockHistogramIndex(indexZYX), serialization)
+		numBlocks++
+		if numBlocks%KVWriteSize == 0 {
+			if err := batch.Commit(); err != nil {
+				dvid.Errorf("Error on trying to write batch: %s\n", err.Error())
+			}
+			batch = batcher.NewBatch(ctx)
+		}
+		server.BlockOnInteractiveRequests("voxels [compute block histograms]")
+	})
+	if err != nil {
+		dvid.Errorf("Error computing block histograms for %s: %s\n", d.DataName(), err.Error())
+		return
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Errorf("Error on trying to write batch: %s\n", err.Error())
+		return
+	}
+	timedLog.Infof("Computed histograms for %d blocks of %s", numBlocks, d.DataName())
+}
+
+// GetHistogram aggregates the stored histograms of all blocks intersecting the given
+// subvolume.  Since whole blocks are tallied, voxels just outside the subvolume may be
+// counted.  Returns the histogram and the number of blocks with stored histograms.
+func (d *Data) GetHistogram(ctx *datastore.VersionedContext, subvol *dvid.Subvolume) (*Histogram, int, error) {
+	hist, numBlocks, _, err := d.GetHistogramBudget(ctx, subvol, nil)
+	return hist, numBlocks, err
+}
+
+// histogramProgress is the state of a histogram aggregation cut short by its budget.
+type histogramProgress struct {
+	hist      Histogram
+	numBlocks int
+	row       int32 // next row of blocks along x to aggregate
+}
+
+// GetHistogramBudget is like GetHistogram but stops aggregating rows of blocks once the
+// budget is exceeded.  If the histogram is partial, the returned state can be passed to
+// QueryBudget.SetPartial to allow resuming the aggregation.
+func (d *Data) GetHistogramBudget(ctx *datastore.VersionedContext, subvol *dvid.Subvolume,
+	budget *server.QueryBudget) (*Histogram, int, interface{}, error) {
+
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	begVoxel, ok := subvol.StartPoint().(dvid.Chunkable)
+	if !ok {
+		return nil, 0, nil, fmt.Errorf("Subvolume %s start point cannot be chunked", subvol)
+	}
+	endVoxel, ok := subvol.EndPoint().(dvid.Chunkable)
+	if !ok {
+		return nil, 0, nil, fmt.Errorf("Subvolume %s end point cannot be chunked", subvol)
+	}
+	begBlock := begVoxel.Chunk(d.BlockSize()).(dvid.ChunkPoint3d)
+	endBlock := endVoxel.Chunk(d.BlockSize()).(dvid.ChunkPoint3d)
+
+	progress := new(histogramProgress)
+	if state := budget.State(); state != nil {
+		if progress, ok = state.(*histogramProgress); !ok {
+			return nil, 0, nil, fmt.Errorf("Continuation is not for a histogram")
+		}
+	}
+	// At least one row is aggregated per call so resumed queries always make progress.
+	numRows := (endBlock[1] - begBlock[1] + 1) * (endBlock[2] - begBlock[2] + 1)
+	for startRow := progress.row; progress.row < numRows; progress.row++ {
+		if progress.row > startRow && budget.Exceeded() {
+			return &progress.hist, progress.numBlocks, progress, nil
+		}
+		y := begBlock[1] + progress.row%(endBlock[1]-begBlock[1]+1)
+		z := begBlock[2] + progress.row/(endBlock[1]-begBlock[1]+1)
+		indexBeg := dvid.IndexZYX{begBlock[0], y, z}
+		indexEnd := dvid.IndexZYX{endBlock[0], y, z}
+		keyvalues, err := smalldata.GetRange(ctx, NewBlockHistogramIndex(&indexBeg), NewBlockHistogramIndex(&indexEnd))
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		for _, kv := range keyvalues {
+			histBytes, _, err := dvid.DeserializeData(kv.V, true)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			var blockHist Histogram
+			if err := blockHist.UnmarshalBinary(histBytes); err != nil {
+				return nil, 0, nil, err
+			}
+			progress.hist.Add(&blockHist)
+			progress.numBlocks++
+		}
+	}
+	return &progress.hist, progress.numBlocks, nil, nil
+}
+
+// HistogramJSON returns a JSON description of a histogram including the intensities at
+// the given low and high percentiles, which are suitable for auto-contrast.
+func HistogramJSON(hist *Histogram, numBlocks int, lowPercent, highPercent float64) ([]byte, error) {
+	min, max := hist.Range()
+	return json.Marshal(struct {
+		Blocks int
+		Voxels uint64
+		Min    uint8
+		Max    uint8
+		Low    uint8
+		High   uint8
+		Bins   []uint64
+	}{
+		Blocks: numBlocks,
+		Voxels: hist.NumVoxels(),
+		Min:    min,
+		Max:    max,
+		Low:    hist.Percentile(lowPercent),
+		High:   hist.Percentile(highPercent),
+		Bins:   hist[:],
+	})
+}
@@ -0,0 +1,129 @@
+package voxels
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestHistogram(t *testing.T) {
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i + 10)
+	}
+	hist := NewBlockHistogram(data)
+	if hist.NumVoxels() != 100 {
+		t.Errorf("Expected 100 voxels in histogram, got %d\n", hist.NumVoxels())
+	}
+	min, max := hist.Range()
+	if min != 10 || max != 109 {
+		t.Errorf("Expected histogram range 10 to 109, got %d to %d\n", min, max)
+	}
+	if low := hist.Percentile(5); low != 14 {
+		t.Errorf("Expected 5th percentile to be 14, got %d\n", low)
+	}
+	if high := hist.Percentile(95); high != 104 {
+		t.Errorf("Expected 95th percentile to be 104, got %d\n", high)
+	}
+
+	hist.Add(NewBlockHistogram(data))
+	if hist.NumVoxels() != 200 || hist[50] != 2 {
+		t.Errorf("Bad histogram after addition: %d voxels, %d at intensity 50\n", hist.NumVoxels(), hist[50])
+	}
+
+	serialization, err := hist.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unable to serialize histogram: %s\n", err.Error())
+	}
+	var hist2 Histogram
+	if err := hist2.UnmarshalBinary(serialization); err != nil {
+		t.Fatalf("Unable to deserialize histogram: %s\n", err.Error())
+	}
+	if hist2 != *hist {
+		t.Errorf("Deserialized histogram doesn't match original\n")
+	}
+	if err := hist2.UnmarshalBinary(serialization[1:]); err == nil {
+		t.Errorf("Expected error on deserializing truncated histogram\n")
+	}
+
+	var empty Histogram
+	if empty.Percentile(50) != 0 {
+		t.Errorf("Expected 0 for percentile of empty histogram\n")
+	}
+}
+
+func TestHistogramBudget(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "histbudget")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	// Store histograms for a 2x2x2 region of blocks, each with voxels of intensity 7.
+	blockData := make([]byte, grayscale.BlockSize().Prod())
+	for i := range blockData {
+		blockData[i] = 7
+	}
+	for z := int32(0); z < 2; z++ {
+		for y := int32(0); y < 2; y++ {
+			for x := int32(0); x < 2; x++ {
+				if err := grayscale.putBlockHistogram(ctx, &dvid.IndexZYX{x, y, z}, blockData); err != nil {
+					t.Fatalf("Error storing block histogram: %s\n", err.Error())
+				}
+			}
+		}
+	}
+	size := grayscale.BlockSize().(dvid.Point3d)
+	subvol := dvid.NewSubvolume(dvid.Point3d{0, 0, 0}, dvid.Point3d{2 * size[0], 2 * size[1], 2 * size[2]})
+
+	// An expired budget aggregates one row of blocks per request.
+	path := "/api/node/abc/histbudget/histogram"
+	r, _ := http.NewRequest("GET", path+"?budget_ms=1", nil)
+	budget, err := server.BudgetFromQuery(r)
+	if err != nil {
+		t.Fatalf("Error parsing budget: %s\n", err.Error())
+	}
+	time.Sleep(5 * time.Millisecond)
+	hist, numBlocks, progress, err := grayscale.GetHistogramBudget(ctx, subvol, budget)
+	if err != nil {
+		t.Fatalf("Error getting histogram: %s\n", err.Error())
+	}
+	if progress == nil || numBlocks != 2 || hist[7] != 2*uint64(len(blockData)) {
+		t.Fatalf("Expected partial histogram of 2 blocks, got %d blocks (partial %t)\n", numBlocks, progress != nil)
+	}
+	for rows := 1; progress != nil; rows++ {
+		if rows > 4 {
+			t.Fatalf("Histogram aggregation did not finish after %d rows\n", rows)
+		}
+		w := httptest.NewRecorder()
+		if err := budget.SetPartial(w, progress); err != nil {
+			t.Fatalf("Error setting partial result: %s\n", err.Error())
+		}
+		if w.Header().Get(server.PartialHeader) != "true" {
+			t.Errorf("Expected partial header on response\n")
+		}
+		r, _ = http.NewRequest("GET", path+"?budget_ms=1&continue="+w.Header().Get(server.ContinuationHeader), nil)
+		if budget, err = server.BudgetFromQuery(r); err != nil {
+			t.Fatalf("Error resuming histogram: %s\n", err.Error())
+		}
+		time.Sleep(5 * time.Millisecond)
+		if hist, numBlocks, progress, err = grayscale.GetHistogramBudget(ctx, subvol, budget); err != nil {
+			t.Fatalf("Error getting histogram: %s\n", err.Error())
+		}
+	}
+	if numBlocks != 8 || hist.NumVoxels() != 8*uint64(len(blockData)) {
+		t.Errorf("Expected complete histogram of 8 blocks, got %d blocks with %d voxels\n", numBlocks, hist.NumVoxels())
+	}
+
+	// Continuation tokens can only be used once.
+	if _, err := server.BudgetFromQuery(r); err == nil {
+		t.Errorf("Expected error on reusing continuation token\n")
+	}
+}
@@ -0,0 +1,768 @@
+/*
+	This file imports N5 or Zarr datasets, e.g., written by the "export" command or by
+	Python's zarr and z5py packages, into voxels data.  Containers can be read from a
+	directory visible to the server or over HTTP.  Source chunks needn't match DVID
+	blocks, so the chunks overlapping each row of blocks are read and the blocks of the
+	row assembled from them, with both steps run in parallel using the server's pool of
+	chunk handlers.
+*/
+
+package voxels
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// importSource reads the files of a container.
+type importSource interface {
+	// read returns the contents of a file given its slash-separated path within the
+	// container, or nil if the file doesn't exist.
+	read(name string) ([]byte, error)
+
+	String() string
+}
+
+// dirSource reads a container from a directory visible to the server.
+type dirSource string
+
+func (src dirSource) read(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(string(src), filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (src dirSource) String() string {
+	return string(src)
+}
+
+// importClient reads containers served over HTTP.
+var importClient = &http.Client{Timeout: 5 * time.Minute}
+
+// httpSource reads a container from its base URL.
+type httpSource string
+
+func (src httpSource) read(name string) ([]byte, error) {
+	url := strings.TrimRight(string(src), "/") + "/" + name
+	resp, err := importClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("GET %s returned status %s", url, resp.Status)
+	}
+}
+
+func (src httpSource) String() string {
+	return string(src)
+}
+
+func newImportSource(location string) importSource {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return httpSource(location)
+	}
+	return dirSource(location)
+}
+
+// importSpec describes a dataset to import and where its voxels are placed.
+type importSpec struct {
+	source  importSource
+	dataset string // path of the dataset within the container
+
+	size      dvid.Point3d
+	chunkSize dvid.Point3d
+	channels  int32
+
+	dataType    string // named like the values of exportDataType
+	valueBytes  int
+	byteOrder   binary.ByteOrder
+	compression string // "raw", "gzip", or "zlib"
+
+	// separator joins chunk coordinates in Zarr chunk keys.
+	separator string
+
+	// offset is the DVID coordinate of the dataset's first voxel.
+	offset dvid.Point3d
+
+	resolution Resolution
+}
+
+func (spec *importSpec) path(elems ...string) string {
+	return path.Join(append([]string{spec.dataset}, elems...)...)
+}
+
+func (spec *importSpec) bytesPerVoxel() int {
+	return int(spec.channels) * spec.valueBytes
+}
+
+func (spec *importSpec) chunkBytes() int {
+	return int(spec.chunkSize[0]*spec.chunkSize[1]*spec.chunkSize[2]) * spec.bytesPerVoxel()
+}
+
+// decode decompresses the payload of a chunk.
+func (spec *importSpec) decode(payload []byte) ([]byte, error) {
+	switch spec.compression {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	case "zlib":
+		zr, err := zlib.NewReader(bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	default:
+		return payload, nil
+	}
+}
+
+// setAttributes sets the offset and resolution of a dataset from attributes like those
+// written by export.  Attributes written differently by other tools are ignored.
+func (spec *importSpec) setAttributes(attrJSON []byte) {
+	var attrs struct {
+		Offset     []int32
+		Resolution []float32
+		Units      []string
+	}
+	if len(attrJSON) == 0 || json.Unmarshal(attrJSON, &attrs) != nil {
+		return
+	}
+	if len(attrs.Offset) == 3 {
+		spec.offset = dvid.Point3d{attrs.Offset[0], attrs.Offset[1], attrs.Offset[2]}
+	}
+	if len(attrs.Resolution) == 3 {
+		spec.resolution.VoxelSize = dvid.NdFloat32(attrs.Resolution)
+	}
+	if len(attrs.Units) == 3 {
+		spec.resolution.VoxelUnits = dvid.NdString(attrs.Units)
+	}
+}
+
+// setShape sets the size and chunk size of a dataset from dimensions in x, y, z order
+// optionally preceded by a dimension of channels, which must be within a single chunk.
+func (spec *importSpec) setShape(dims, chunkDims []int32) error {
+	if len(dims) != len(chunkDims) {
+		return fmt.Errorf("Dataset %s has %d dimensions but %d chunk dimensions", spec.dataset, len(dims), len(chunkDims))
+	}
+	spec.channels = 1
+	switch len(dims) {
+	case 3:
+	case 4:
+		spec.channels = dims[0]
+		if chunkDims[0] != dims[0] {
+			return fmt.Errorf("Dataset %s must have all %d channels in each chunk", spec.dataset, dims[0])
+		}
+		dims, chunkDims = dims[1:], chunkDims[1:]
+	default:
+		return fmt.Errorf("Only 3d datasets can be imported, not %s with %d dimensions", spec.dataset, len(dims))
+	}
+	for i := 0; i < 3; i++ {
+		if dims[i] <= 0 || chunkDims[i] <= 0 {
+			return fmt.Errorf("Dataset %s has bad dimensions %v and chunk dimensions %v", spec.dataset, dims, chunkDims)
+		}
+		spec.size[i] = dims[i]
+		spec.chunkSize[i] = chunkDims[i]
+	}
+	return nil
+}
+
+// importDataType returns the name of a value type of the given kind, 'u', 'i', or 'f',
+// and number of bytes.
+func importDataType(kind byte, valueBytes int) (string, error) {
+	switch {
+	case kind == 'u' && (valueBytes == 1 || valueBytes == 2 || valueBytes == 4 || valueBytes == 8):
+		return fmt.Sprintf("uint%d", 8*valueBytes), nil
+	case kind == 'i' && (valueBytes == 1 || valueBytes == 2 || valueBytes == 4 || valueBytes == 8):
+		return fmt.Sprintf("int%d", 8*valueBytes), nil
+	case kind == 'f' && (valueBytes == 4 || valueBytes == 8):
+		return fmt.Sprintf("float%d", 8*valueBytes), nil
+	default:
+		return "", fmt.Errorf("Can't import values of kind %q with %d bytes", kind, valueBytes)
+	}
+}
+
+// importFormat reads a chunked volume from a container.
+type importFormat interface {
+	// readMetadata returns the shape, chunking, and value type of a dataset.
+	readMetadata(src importSource, dataset string) (*importSpec, error)
+
+	// readChunk returns the decompressed voxels of the chunk at the given chunk
+	// coordinate padded to the full chunk size, or nil if the chunk isn't stored.
+	readChunk(spec *importSpec, coord dvid.Point3d) ([]byte, error)
+}
+
+func newImportFormat(name string) (importFormat, error) {
+	switch strings.ToLower(name) {
+	case "n5":
+		return n5Format{}, nil
+	case "zarr":
+		return zarrFormat{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown import format %q, must be \"n5\" or \"zarr\"", name)
+	}
+}
+
+func (n5Format) readMetadata(src importSource, dataset string) (*importSpec, error) {
+	spec := &importSpec{source: src, dataset: dataset, byteOrder: binary.BigEndian}
+	attrJSON, err := src.read(spec.path("attributes.json"))
+	if err != nil {
+		return nil, err
+	}
+	if attrJSON == nil {
+		return nil, fmt.Errorf("No N5 dataset %s in %s", dataset, src)
+	}
+	var attrs struct {
+		Dimensions      []int32
+		BlockSize       []int32
+		DataType        string
+		Compression     struct{ Type string }
+		CompressionType string // used before N5 version 2
+	}
+	if err := json.Unmarshal(attrJSON, &attrs); err != nil {
+		return nil, fmt.Errorf("Bad attributes of N5 dataset %s: %s", dataset, err.Error())
+	}
+	if err := spec.setShape(attrs.Dimensions, attrs.BlockSize); err != nil {
+		return nil, err
+	}
+	spec.dataType = attrs.DataType
+	switch attrs.DataType {
+	case "uint8", "int8":
+		spec.valueBytes = 1
+	case "uint16", "int16":
+		spec.valueBytes = 2
+	case "uint32", "int32", "float32":
+		spec.valueBytes = 4
+	case "uint64", "int64", "float64":
+		spec.valueBytes = 8
+	default:
+		return nil, fmt.Errorf("Can't import N5 dataset %s with data type %q", dataset, attrs.DataType)
+	}
+	compression := attrs.Compression.Type
+	if compression == "" {
+		compression = attrs.CompressionType
+	}
+	switch compression {
+	case "raw", "gzip":
+		spec.compression = compression
+	default:
+		return nil, fmt.Errorf("Can't import N5 dataset %s with compression %q", dataset, compression)
+	}
+	spec.setAttributes(attrJSON)
+	return spec, nil
+}
+
+func (n5Format) readChunk(spec *importSpec, coord dvid.Point3d) ([]byte, error) {
+	var elems []string
+	if spec.channels > 1 {
+		elems = append(elems, "0")
+	}
+	for _, c := range coord {
+		elems = append(elems, fmt.Sprintf("%d", c))
+	}
+	block, err := spec.source.read(spec.path(elems...))
+	if err != nil || block == nil {
+		return nil, err
+	}
+
+	// Header with the block mode and the size of this block.
+	buf := bytes.NewBuffer(block)
+	var mode, numDims uint16
+	if err := binary.Read(buf, binary.BigEndian, &mode); err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		return nil, fmt.Errorf("Unsupported N5 block mode %d", mode)
+	}
+	if err := binary.Read(buf, binary.BigEndian, &numDims); err != nil {
+		return nil, err
+	}
+	dims := make([]uint32, numDims)
+	if err := binary.Read(buf, binary.BigEndian, dims); err != nil {
+		return nil, err
+	}
+	if spec.channels > 1 {
+		dims = dims[1:]
+	}
+	if len(dims) != 3 {
+		return nil, fmt.Errorf("N5 block has %d dimensions, expected 3", len(dims))
+	}
+	size := dvid.Point3d{int32(dims[0]), int32(dims[1]), int32(dims[2])}
+	for i := range size {
+		if size[i] > spec.chunkSize[i] {
+			return nil, fmt.Errorf("N5 block size %s exceeds block size %s", size, spec.chunkSize)
+		}
+	}
+
+	data, err := spec.decode(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if expected := int(size[0]*size[1]*size[2]) * spec.bytesPerVoxel(); len(data) != expected {
+		return nil, fmt.Errorf("N5 block has %d bytes, expected %d for size %s", len(data), expected, size)
+	}
+	if size != spec.chunkSize {
+		data = padChunk(data, size, spec.chunkSize, spec.bytesPerVoxel())
+	}
+	return data, nil
+}
+
+func (zarrFormat) readMetadata(src importSource, dataset string) (*importSpec, error) {
+	spec := &importSpec{source: src, dataset: dataset, separator: "."}
+	arrayJSON, err := src.read(spec.path(".zarray"))
+	if err != nil {
+		return nil, err
+	}
+	if arrayJSON == nil {
+		return nil, fmt.Errorf("No Zarr array %s in %s", dataset, src)
+	}
+	var array struct {
+		ZarrFormat         int `json:"zarr_format"`
+		Shape              []int32
+		Chunks             []int32
+		Dtype              string
+		Compressor         *struct{ ID string }
+		FillValue          interface{} `json:"fill_value"`
+		Order              string
+		Filters            []interface{}
+		DimensionSeparator string `json:"dimension_separator"`
+	}
+	if err := json.Unmarshal(arrayJSON, &array); err != nil {
+		return nil, fmt.Errorf("Bad .zarray of Zarr array %s: %s", dataset, err.Error())
+	}
+	if array.ZarrFormat != 2 {
+		return nil, fmt.Errorf("Can't import Zarr array %s with format %d", dataset, array.ZarrFormat)
+	}
+	if array.Order != "C" || len(array.Filters) != 0 {
+		return nil, fmt.Errorf("Can't import Zarr array %s with order %q or filters", dataset, array.Order)
+	}
+	if fill, ok := array.FillValue.(float64); array.FillValue != nil && (!ok || fill != 0) {
+		return nil, fmt.Errorf("Can't import Zarr array %s with fill value %v", dataset, array.FillValue)
+	}
+	if array.DimensionSeparator != "" {
+		spec.separator = array.DimensionSeparator
+	}
+
+	// Zarr dimensions are listed from slowest to fastest varying.
+	reverse := func(dims []int32) []int32 {
+		reversed := make([]int32, len(dims))
+		for i, dim := range dims {
+			reversed[len(dims)-1-i] = dim
+		}
+		return reversed
+	}
+	if err := spec.setShape(reverse(array.Shape), reverse(array.Chunks)); err != nil {
+		return nil, err
+	}
+
+	if len(array.Dtype) < 3 {
+		return nil, fmt.Errorf("Bad dtype %q of Zarr array %s", array.Dtype, dataset)
+	}
+	switch array.Dtype[0] {
+	case '<':
+		spec.byteOrder = binary.LittleEndian
+	case '>':
+		spec.byteOrder = binary.BigEndian
+	case '|':
+		spec.byteOrder = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("Bad dtype %q of Zarr array %s", array.Dtype, dataset)
+	}
+	if _, err := fmt.Sscanf(array.Dtype[2:], "%d", &spec.valueBytes); err != nil {
+		return nil, fmt.Errorf("Bad dtype %q of Zarr array %s", array.Dtype, dataset)
+	}
+	if spec.dataType, err = importDataType(array.Dtype[1], spec.valueBytes); err != nil {
+		return nil, err
+	}
+
+	spec.compression = "raw"
+	if array.Compressor != nil {
+		switch array.Compressor.ID {
+		case "gzip", "zlib":
+			spec.compression = array.Compressor.ID
+		default:
+			return nil, fmt.Errorf("Can't import Zarr array %s with compressor %q", dataset, array.Compressor.ID)
+		}
+	}
+
+	attrJSON, err := src.read(spec.path(".zattrs"))
+	if err != nil {
+		return nil, err
+	}
+	spec.setAttributes(attrJSON)
+	return spec, nil
+}
+
+func (zarrFormat) readChunk(spec *importSpec, coord dvid.Point3d) ([]byte, error) {
+	key := []string{fmt.Sprintf("%d", coord[2]), fmt.Sprintf("%d", coord[1]), fmt.Sprintf("%d", coord[0])}
+	if spec.channels > 1 {
+		key = append(key, "0")
+	}
+	payload, err := spec.source.read(spec.path(strings.Join(key, spec.separator)))
+	if err != nil || payload == nil {
+		return nil, err
+	}
+	data, err := spec.decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != spec.chunkBytes() {
+		return nil, fmt.Errorf("Zarr chunk has %d bytes, expected %d", len(data), spec.chunkBytes())
+	}
+	return data, nil
+}
+
+// checkValues returns an error if the dataset's voxels don't match the given values of
+// the named data.
+func (spec *importSpec) checkValues(name dvid.DataString, values dvid.DataValues) error {
+	dataType, _, err := exportDataType(values[0].T)
+	if err != nil {
+		return err
+	}
+	if int(spec.channels) != len(values) || spec.dataType != dataType {
+		return fmt.Errorf("Dataset %s has %d channels of %s values, but data %q has %d channels of %s values",
+			spec.dataset, spec.channels, spec.dataType, name, len(values), dataType)
+	}
+	return nil
+}
+
+// newImportSpec returns the import of a dataset into this data given settings of an
+// import command.
+func (d *Data) newImportSpec(format importFormat, location string, config dvid.Config) (*importSpec, error) {
+	if location == "" {
+		return nil, fmt.Errorf("Import requires a directory or URL")
+	}
+	if _, ok := d.BlockSize().(dvid.Point3d); !ok {
+		return nil, fmt.Errorf("Import only supported for 3d blocks, not %s", d.BlockSize())
+	}
+	if _, ok := d.Extents().MinPoint.(dvid.Point3d); ok {
+		return nil, fmt.Errorf("Data %q already has stored voxels; import requires new data", d.DataName())
+	}
+	dataset := string(d.DataName())
+	if s, found, err := config.GetString("dataset"); err != nil {
+		return nil, err
+	} else if found {
+		dataset = strings.Trim(s, "/")
+	}
+	spec, err := format.readMetadata(newImportSource(location), dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := spec.checkValues(d.DataName(), d.Values()); err != nil {
+		return nil, err
+	}
+
+	if s, found, err := config.GetString("offset"); err != nil {
+		return nil, err
+	} else if found {
+		pt, err := dvid.StringToPoint(s, ",")
+		if err != nil {
+			return nil, err
+		}
+		offset, ok := pt.(dvid.Point3d)
+		if !ok {
+			return nil, fmt.Errorf("Setting \"offset\" must be a 3d point, not %q", s)
+		}
+		spec.offset = offset
+	}
+	return spec, nil
+}
+
+// Import reads an N5 or Zarr dataset into this data, which must not have any stored
+// voxels.  Unless the request is already run as a background job, the import is
+// started as one and its job ID is returned.
+func (d *Data) Import(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, formatStr, location string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &formatStr, &location)
+
+	format, err := newImportFormat(formatStr)
+	if err != nil {
+		return err
+	}
+	spec, err := d.newImportSpec(format, location, request.Settings())
+	if err != nil {
+		return err
+	}
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	if request.InJob() {
+		reply.Text, err = d.importVolume(request, versionID, format, spec)
+		return err
+	}
+	job, err := server.StartJob(request.Command.String(), "rpc", func(handle *server.JobHandle) (string, error) {
+		request.SetJob(handle)
+		return d.importVolume(request, versionID, format, spec)
+	})
+	if err != nil {
+		return err
+	}
+	reply.Text = fmt.Sprintf("Started job %s to import %s into %q, version %s.  Check its progress via /api/server/jobs/%s\n",
+		job.ID, location, d.DataName(), uuid, job.ID)
+	return nil
+}
+
+func (d *Data) importVolume(request datastore.Request, versionID dvid.VersionID, format importFormat, spec *importSpec) (string, error) {
+	timedLog := dvid.NewTimeLog()
+
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return "", err
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+	ctx.SetDone(request.Done())
+
+	blockSize := d.BlockSize().(dvid.Point3d)
+	swap := spec.valueBytes > 1 && spec.byteOrder != d.ByteOrder
+
+	var last dvid.Point3d
+	for i := range last {
+		last[i] = spec.offset[i] + spec.size[i] - 1
+	}
+	minBlock := spec.offset.Chunk(blockSize).(dvid.ChunkPoint3d)
+	maxBlock := last.Chunk(blockSize).(dvid.ChunkPoint3d)
+	total := int(maxBlock[2]-minBlock[2]+1) * int(maxBlock[1]-minBlock[1]+1)
+	request.JobLogf("Importing %s from %s into %q, %d rows of blocks\n", spec.dataset, spec.source, d.DataName(), total)
+
+	var mu sync.Mutex
+	var workErr error
+	setErr := func(err error) {
+		mu.Lock()
+		if workErr == nil {
+			workErr = err
+		}
+		mu.Unlock()
+	}
+
+	// Chunks are kept while they overlap the current row of blocks.
+	chunks := make(map[dvid.Point3d][]byte)
+	var done, written int
+	for bz := minBlock[2]; bz <= maxBlock[2]; bz++ {
+		for by := minBlock[1]; by <= maxBlock[1]; by++ {
+			if request.Canceled() {
+				return "", fmt.Errorf("Import into %q canceled after %d of %d rows of blocks", d.DataName(), done, total)
+			}
+			server.BlockOnInteractiveRequests("voxels [import]")
+
+			beg, end := spec.rowBounds(blockSize, by, bz)
+			if err := readRowChunks(format, spec, chunks, beg, end, swap); err != nil {
+				return "", err
+			}
+
+			// Assemble and store the blocks of the row.
+			wg := new(sync.WaitGroup)
+			for bx := minBlock[0]; bx <= maxBlock[0]; bx++ {
+				<-server.HandlerToken
+				wg.Add(1)
+				go func(index dvid.IndexZYX) {
+					defer func() {
+						server.HandlerToken <- 1
+						wg.Done()
+					}()
+					block := importBlock(spec, chunks, index, blockSize, beg, end, nil)
+					if allZero(block) {
+						return
+					}
+					serialization, err := dvid.SerializeData(block, d.Compression(), d.Checksum())
+					if err != nil {
+						setErr(fmt.Errorf("Unable to serialize block %s: %s", index, err.Error()))
+						return
+					}
+					blockIndex := NewVoxelBlockIndex(&index)
+					unlock := LockBlocks(d, versionID, [][]byte{blockIndex})
+					err = bigdata.Put(ctx, blockIndex, serialization)
+					unlock()
+					if err != nil {
+						setErr(fmt.Errorf("Unable to store block %s: %s", index, err.Error()))
+						return
+					}
+					if err := d.putBlockHistogram(ctx, &index, block); err != nil {
+						dvid.Errorf("Unable to PUT block histogram in %q: %s\n", d.DataName(), err.Error())
+					}
+					mu.Lock()
+					written++
+					mu.Unlock()
+				}(dvid.IndexZYX{bx, by, bz})
+			}
+			wg.Wait()
+			if workErr != nil {
+				return "", workErr
+			}
+			done++
+			request.Progress(100 * float64(done) / float64(total))
+		}
+	}
+
+	// Record the extents of the imported voxels and the dataset resolution.
+	extents := d.Extents()
+	extents.AdjustPoints(spec.offset, last)
+	minIndex, maxIndex := dvid.IndexZYX(minBlock), dvid.IndexZYX(maxBlock)
+	extents.AdjustIndices(&minIndex, &maxIndex)
+	if len(spec.resolution.VoxelSize) == 3 {
+		d.Properties.Resolution.VoxelSize = spec.resolution.VoxelSize
+	}
+	if len(spec.resolution.VoxelUnits) == 3 {
+		d.Properties.Resolution.VoxelUnits = spec.resolution.VoxelUnits
+	}
+	uuid, err := datastore.UUIDFromVersion(versionID)
+	if err != nil {
+		return "", err
+	}
+	if err := datastore.SaveRepo(uuid); err != nil {
+		return "", err
+	}
+
+	result := fmt.Sprintf("Imported %s from %s into %d non-empty blocks of %q at offset %s\n",
+		spec.dataset, spec.source, written, d.DataName(), spec.offset)
+	timedLog.Infof("Imported %s from %s into %d blocks of %q", spec.dataset, spec.source, written, d.DataName())
+	return result, nil
+}
+
+// rowBounds returns the first and last dataset voxels of a row of blocks.
+func (spec *importSpec) rowBounds(blockSize dvid.Point3d, by, bz int32) (beg, end dvid.Point3d) {
+	beg = dvid.Point3d{0, by*blockSize[1] - spec.offset[1], bz*blockSize[2] - spec.offset[2]}
+	end = dvid.Point3d{spec.size[0] - 1, beg[1] + blockSize[1] - 1, beg[2] + blockSize[2] - 1}
+	for i := 1; i < 3; i++ {
+		if beg[i] < 0 {
+			beg[i] = 0
+		}
+		if end[i] >= spec.size[i] {
+			end[i] = spec.size[i] - 1
+		}
+	}
+	return
+}
+
+// readRowChunks reads in parallel the chunks overlapping the dataset voxels from beg
+// to end that aren't already in chunks, and forgets chunks that don't overlap them.
+func readRowChunks(format importFormat, spec *importSpec, chunks map[dvid.Point3d][]byte, beg, end dvid.Point3d, swap bool) error {
+	needed := make(map[dvid.Point3d]bool)
+	var c dvid.Point3d
+	for c[2] = beg[2] / spec.chunkSize[2]; c[2] <= end[2]/spec.chunkSize[2]; c[2]++ {
+		for c[1] = beg[1] / spec.chunkSize[1]; c[1] <= end[1]/spec.chunkSize[1]; c[1]++ {
+			for c[0] = beg[0] / spec.chunkSize[0]; c[0] <= end[0]/spec.chunkSize[0]; c[0]++ {
+				needed[c] = true
+			}
+		}
+	}
+	for coord := range chunks {
+		if !needed[coord] {
+			delete(chunks, coord)
+		}
+	}
+	var missing []dvid.Point3d
+	for coord := range needed {
+		if _, found := chunks[coord]; !found {
+			missing = append(missing, coord)
+		}
+	}
+
+	var mu sync.Mutex
+	var readErr error
+	wg := new(sync.WaitGroup)
+	for _, coord := range missing {
+		<-server.HandlerToken
+		wg.Add(1)
+		go func(coord dvid.Point3d) {
+			defer func() {
+				server.HandlerToken <- 1
+				wg.Done()
+			}()
+			data, err := format.readChunk(spec, coord)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if readErr == nil {
+					readErr = fmt.Errorf("Unable to read chunk %s of %s: %s", coord, spec.dataset, err.Error())
+				}
+				return
+			}
+			if data != nil && swap {
+				data = swapBytes(data, spec.valueBytes)
+			}
+			chunks[coord] = data
+		}(coord)
+	}
+	wg.Wait()
+	return readErr
+}
+
+// importBlock returns the voxels of a block copied from the chunks of a dataset, where
+// beg and end bound the dataset voxels of the block's row.  The voxels are copied into
+// the given block, e.g., with previously stored voxels, or a new block if it is nil.
+func importBlock(spec *importSpec, chunks map[dvid.Point3d][]byte, index dvid.IndexZYX, blockSize, beg, end dvid.Point3d, block []byte) []byte {
+	bytesPerVoxel := int32(spec.bytesPerVoxel())
+	if block == nil {
+		block = make([]byte, blockSize.Prod()*int64(bytesPerVoxel))
+	}
+
+	// Block origin in dataset coordinates.
+	var origin dvid.Point3d
+	for i := range origin {
+		origin[i] = index[i]*blockSize[i] - spec.offset[i]
+	}
+	xBeg, xEnd := origin[0], origin[0]+blockSize[0]-1
+	if xBeg < beg[0] {
+		xBeg = beg[0]
+	}
+	if xEnd > end[0] {
+		xEnd = end[0]
+	}
+	cs := spec.chunkSize
+	for z := beg[2]; z <= end[2]; z++ {
+		for y := beg[1]; y <= end[1]; y++ {
+			for x := xBeg; x <= xEnd; {
+				coord := dvid.Point3d{x / cs[0], y / cs[1], z / cs[2]}
+				spanEnd := (coord[0]+1)*cs[0] - 1
+				if spanEnd > xEnd {
+					spanEnd = xEnd
+				}
+				if chunk := chunks[coord]; chunk != nil {
+					n := (spanEnd - x + 1) * bytesPerVoxel
+					dst := (((z-origin[2])*blockSize[1]+y-origin[1])*blockSize[0] + x - origin[0]) * bytesPerVoxel
+					src := (((z%cs[2])*cs[1]+y%cs[1])*cs[0] + x%cs[0]) * bytesPerVoxel
+					copy(block[dst:dst+n], chunk[src:src+n])
+				}
+				x = spanEnd + 1
+			}
+		}
+	}
+	return block
+}
@@ -0,0 +1,155 @@
+package voxels
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestImportGrayscale8(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	v, err := grayscale.NewExtHandler(dvid.NewSubvolume(offset, size), makeVolume(offset, size))
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+		t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+	}
+	volume := makeVolume(offset, size)
+
+	dir, err := ioutil.TempDir("", "dvid-import")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// Chunks that don't match blocks are split across them on import.
+	for _, formatName := range []string{"n5", "zarr"} {
+		container := filepath.Join(dir, "vol."+formatName)
+		config := dvid.NewConfig()
+		config.Set("chunksize", "20,24,28")
+		spec, err := grayscale.newExportSpec(container, config)
+		if err != nil {
+			t.Fatalf("Bad export settings: %s\n", err.Error())
+		}
+		format, _ := newExportFormat(formatName)
+		if _, err := grayscale.export(datastore.Request{}, versionID, format, spec, ""); err != nil {
+			t.Fatalf("Unable to export %s: %s\n", formatName, err.Error())
+		}
+
+		imported := makeGrayscale(repo, t, "imported-"+formatName)
+		config = dvid.NewConfig()
+		config.Set("dataset", "grayscale")
+		importer, _ := newImportFormat(formatName)
+		importSpec, err := imported.newImportSpec(importer, container, config)
+		if err != nil {
+			t.Fatalf("Bad import settings: %s\n", err.Error())
+		}
+		if importSpec.offset != offset || importSpec.size != size {
+			t.Fatalf("Expected %s import at offset %s, size %s, got %s, %s\n", formatName, offset, size,
+				importSpec.offset, importSpec.size)
+		}
+		if _, err := imported.importVolume(datastore.Request{}, versionID, importer, importSpec); err != nil {
+			t.Fatalf("Unable to import %s: %s\n", formatName, err.Error())
+		}
+
+		importedCtx := datastore.NewVersionedContext(imported, versionID)
+		e, err := imported.NewExtHandler(dvid.NewSubvolume(offset, size), nil)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		got, err := GetVolume(importedCtx, imported, e, nil)
+		if err != nil {
+			t.Fatalf("Unable to get imported voxels: %s\n", err.Error())
+		}
+		if !bytes.Equal(got, volume) {
+			t.Errorf("Voxels imported from %s don't match exported voxels\n", formatName)
+		}
+		if _, err := imported.newImportSpec(importer, container, config); err == nil {
+			t.Errorf("Expected error importing into data with stored voxels\n")
+		}
+	}
+}
+
+func TestLoadN5(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	v, err := grayscale.NewExtHandler(dvid.NewSubvolume(offset, size), makeVolume(offset, size))
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+		t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+	}
+	volume := makeVolume(offset, size)
+
+	dir, err := ioutil.TempDir("", "dvid-load")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	container := filepath.Join(dir, "vol.n5")
+	config := dvid.NewConfig()
+	config.Set("chunksize", "20,24,28")
+	spec, err := grayscale.newExportSpec(container, config)
+	if err != nil {
+		t.Fatalf("Bad export settings: %s\n", err.Error())
+	}
+	format, _ := newExportFormat("n5")
+	if _, err := grayscale.export(datastore.Request{}, versionID, format, spec, ""); err != nil {
+		t.Fatalf("Unable to export n5: %s\n", err.Error())
+	}
+
+	// The dataset directory is passed through image expansion and loaded at a new offset.
+	dataset := filepath.Join(container, "grayscale")
+	filenames, err := ExpandImageDirs([]string{dataset})
+	if err != nil || len(filenames) != 1 || filenames[0] != dataset {
+		t.Fatalf("Expected N5 dataset to be passed through, got %v, %v\n", filenames, err)
+	}
+	loaded := makeGrayscale(repo, t, "loaded")
+	loadOffset := dvid.Point3d{50, 5, 100}
+	if err := LoadImages(versionID, loaded, loadOffset, filenames); err != nil {
+		t.Fatalf("Unable to load N5 dataset: %s\n", err.Error())
+	}
+	if _, found := GetLoadProgress(loaded); found {
+		t.Errorf("Expected load progress to be removed once load finished\n")
+	}
+
+	loadedCtx := datastore.NewVersionedContext(loaded, versionID)
+	e, err := loaded.NewExtHandler(dvid.NewSubvolume(loadOffset, size), nil)
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	got, err := GetVolume(loadedCtx, loaded, e, nil)
+	if err != nil {
+		t.Fatalf("Unable to get loaded voxels: %s\n", err.Error())
+	}
+	if !bytes.Equal(got, volume) {
+		t.Errorf("Voxels loaded from N5 don't match exported voxels\n")
+	}
+	if err := LoadImages(versionID, loaded, loadOffset, []string{filepath.Join(dir, "vol.h5")}); err == nil {
+		t.Errorf("Expected error loading HDF5 file\n")
+	}
+}
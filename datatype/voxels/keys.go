@@ -59,6 +59,35 @@ const (
 	// KeyLabelSurface have keys of form 'b' and have the label's sparse volume
 	// for its value.
 	KeyLabelSurface
+
+	// KeyBlockHistogram have keys of form 's' and have an intensity histogram
+	// of the voxel block with the same spatial index for its value.
+	KeyBlockHistogram
+
+	// KeyScaledBlock have keys of form 'r+s' where r is a one byte scale level and
+	// s is the spatial index of a block in a volume downsampled by 2^r.
+	KeyScaledBlock
+
+	// KeyMaxLabel has a single key with no other components and holds the largest
+	// label allocated so far as a big-endian uint64.
+	KeyMaxLabel
+
+	// KeyUndoRecord have keys of form 'e+n' where e is an editing session and n is a
+	// mutation sequence number.  They hold the prior contents of blocks modified by
+	// a mutation and are only stored for the modified version.
+	KeyUndoRecord
+
+	// KeyLabelSurfaceBlock have keys of form 'b+s' and checkpoint the surface of a label
+	// within one block while the label's full surface is being computed.
+	KeyLabelSurfaceBlock
+
+	// KeyLabelColors has a single key with no other components and holds the colors
+	// assigned to labels for rendering.
+	KeyLabelColors
+
+	// KeyLabelMesh have keys of form 'b+l' where l is a one byte level of detail and
+	// have the label's triangle mesh at that level of detail for its value.
+	KeyLabelMesh
 )
 
 func (t KeyType) String() string {
@@ -79,6 +108,20 @@ func (t KeyType) String() string {
 		return "Forward Label sorted by volume"
 	case KeyLabelSurface:
 		return "Forward Label Surface"
+	case KeyBlockHistogram:
+		return "Voxel block histogram"
+	case KeyScaledBlock:
+		return "Downsampled voxel block"
+	case KeyMaxLabel:
+		return "Maximum allocated label"
+	case KeyUndoRecord:
+		return "Undo record for editing session"
+	case KeyLabelSurfaceBlock:
+		return "Forward Label Surface within block"
+	case KeyLabelColors:
+		return "Assigned label colors"
+	case KeyLabelMesh:
+		return "Forward Label mesh at level of detail"
 	default:
 		return "Unknown Key Type"
 	}
@@ -118,6 +161,67 @@ func DecodeVoxelBlockKey(key []byte) (*dvid.IndexZYX, error) {
 	return &zyx, nil
 }
 
+// NewScaledBlockIndex returns an index for a voxel block at the given scale level, where
+// each level halves the resolution of the level below it.  Scale 0 is the original
+// resolution and uses the voxel block keyspace.
+// Index = r+s
+func NewScaledBlockIndex(scale uint8, blockIndex dvid.Index) []byte {
+	if scale == 0 {
+		return NewVoxelBlockIndex(blockIndex)
+	}
+	indexBytes := blockIndex.Bytes()
+	index := make([]byte, 2+len(indexBytes))
+	index[0] = byte(KeyScaledBlock)
+	index[1] = scale
+	copy(index[2:], indexBytes)
+	return dvid.IndexBytes(index)
+}
+
+// DecodeScaledBlockKey returns the scale level and spatial index from a voxel block
+// key at any scale.
+func DecodeScaledBlockKey(key []byte) (uint8, *dvid.IndexZYX, error) {
+	var ctx storage.DataContext
+	index, err := ctx.IndexFromKey(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	var scale uint8
+	if len(index) > 1 && index[0] == byte(KeyScaledBlock) {
+		scale = index[1]
+	}
+	spatial, err := blockSpatialIndex(index)
+	if err != nil {
+		return 0, nil, err
+	}
+	var zyx dvid.IndexZYX
+	if err = zyx.IndexFromBytes(spatial); err != nil {
+		return 0, nil, fmt.Errorf("Cannot recover ZYX index from key %v: %s\n", key, err.Error())
+	}
+	return scale, &zyx, nil
+}
+
+// blockSpatialIndex returns the spatial portion of a voxel block index at any scale.
+func blockSpatialIndex(index []byte) ([]byte, error) {
+	switch {
+	case len(index) > 0 && index[0] == byte(KeyVoxelBlock):
+		return index[1:], nil
+	case len(index) > 1 && index[0] == byte(KeyScaledBlock):
+		return index[2:], nil
+	default:
+		return nil, fmt.Errorf("Expected KeyVoxelBlock or KeyScaledBlock index, got %v instead", index)
+	}
+}
+
+// NewMaxLabelIndex returns the index for the largest allocated label.
+func NewMaxLabelIndex() dvid.IndexBytes {
+	return dvid.IndexBytes{byte(KeyMaxLabel)}
+}
+
+// NewLabelColorsIndex returns the index for the colors assigned to labels.
+func NewLabelColorsIndex() dvid.IndexBytes {
+	return dvid.IndexBytes{byte(KeyLabelColors)}
+}
+
 // NewForwardMapIndex returns an index for mapping a label into another label.
 // Index = a+b
 // For dcumentation purposes, consider the following key components:
@@ -236,6 +340,27 @@ func LabelFromLabelSizesKey(key []byte) (uint64, error) {
 	return binary.BigEndian.Uint64(indexBytes[9:17]), nil
 }
 
+// DecodeLabelSizesKey returns the size and mapped label of a KeyLabelSizes key.
+func DecodeLabelSizesKey(key []byte) (size, label uint64, err error) {
+	ctx := &storage.DataContext{}
+	var index []byte
+	index, err = ctx.IndexFromKey(key)
+	if err != nil {
+		return
+	}
+	if len(index) != 17 {
+		err = fmt.Errorf("Expected 17 byte KeyLabelSizes index, got %d bytes", len(index))
+		return
+	}
+	if index[0] != byte(KeyLabelSizes) {
+		err = fmt.Errorf("Expected KeyLabelSizes index, got %d byte instead", index[0])
+		return
+	}
+	size = binary.BigEndian.Uint64(index[1:9])
+	label = binary.BigEndian.Uint64(index[9:17])
+	return
+}
+
 // NewLabelSurfaceIndex returns an identifier for a given label's surface.
 func NewLabelSurfaceIndex(label uint64) dvid.IndexBytes {
 	index := make([]byte, 1+8)
@@ -243,3 +368,68 @@ func NewLabelSurfaceIndex(label uint64) dvid.IndexBytes {
 	binary.BigEndian.PutUint64(index[1:9], label)
 	return dvid.IndexBytes(index)
 }
+
+// NewLabelMeshIndex returns an identifier for a label's mesh at a level of detail.
+// Index = b+l
+func NewLabelMeshIndex(label uint64, lod uint8) dvid.IndexBytes {
+	index := make([]byte, 1+8+1)
+	index[0] = byte(KeyLabelMesh)
+	binary.BigEndian.PutUint64(index[1:9], label)
+	index[9] = lod
+	return dvid.IndexBytes(index)
+}
+
+// NewLabelSurfaceBlockIndex returns an identifier for the checkpointed surface of a label
+// within a block.
+// Index = b+s
+func NewLabelSurfaceBlockIndex(label uint64, blockBytes []byte) dvid.IndexBytes {
+	index := make([]byte, 1+8+len(blockBytes))
+	index[0] = byte(KeyLabelSurfaceBlock)
+	binary.BigEndian.PutUint64(index[1:9], label)
+	copy(index[9:], blockBytes)
+	return dvid.IndexBytes(index)
+}
+
+// DecodeLabelSurfaceBlockKey returns a label and block index bytes from a LabelSurfaceBlock key.
+func DecodeLabelSurfaceBlockKey(key []byte) (label uint64, blockBytes []byte, err error) {
+	var ctx storage.DataContext
+	var index []byte
+	index, err = ctx.IndexFromKey(key)
+	if err != nil {
+		return
+	}
+	if index[0] != byte(KeyLabelSurfaceBlock) {
+		err = fmt.Errorf("Expected KeyLabelSurfaceBlock index, got %d byte instead", index[0])
+		return
+	}
+	label = binary.BigEndian.Uint64(index[1:9])
+	blockBytes = index[9:]
+	return
+}
+
+// NewBlockHistogramIndex returns an identifier for the intensity histogram of a voxel block.
+// Index = s
+func NewBlockHistogramIndex(blockIndex dvid.Index) dvid.IndexBytes {
+	indexBytes := blockIndex.Bytes()
+	index := make([]byte, 1+len(indexBytes))
+	index[0] = byte(KeyBlockHistogram)
+	copy(index[1:], indexBytes)
+	return dvid.IndexBytes(index)
+}
+
+// DecodeBlockHistogramKey returns a spatial index from a block histogram key.
+func DecodeBlockHistogramKey(key []byte) (*dvid.IndexZYX, error) {
+	var ctx storage.DataContext
+	index, err := ctx.IndexFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if index[0] != byte(KeyBlockHistogram) {
+		return nil, fmt.Errorf("Expected KeyBlockHistogram index, got %d byte instead", index[0])
+	}
+	var zyx dvid.IndexZYX
+	if err = zyx.IndexFromBytes(index[1:]); err != nil {
+		return nil, fmt.Errorf("Cannot recover ZYX index from key %v: %s\n", key, err.Error())
+	}
+	return &zyx, nil
+}
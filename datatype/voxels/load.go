@@ -0,0 +1,313 @@
+/*
+	This file supports bulk loading of large volumes: expansion of image directories,
+	loading of N5 datasets, and progress reporting for long-running loads.
+
+	HDF5 sources aren't supported since DVID isn't built against the HDF5 C library.
+	HDF5 volumes can be converted to N5, e.g., with Python's z5py package, and loaded.
+*/
+
+package voxels
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// How often progress of a bulk load is logged.
+const loadReportInterval = 10 * time.Second
+
+// ExpandImageDirs replaces any directories in the list of filenames with the image files
+// they contain, sorted by name so slices are loaded in Z order.  Directories holding an
+// N5 dataset are passed through.
+func ExpandImageDirs(filenames []string) ([]string, error) {
+	expanded := []string{}
+	for _, filename := range filenames {
+		if isN5Dataset(filename) {
+			expanded = append(expanded, filename)
+			continue
+		}
+		infos, err := ioutil.ReadDir(filename)
+		if err != nil {
+			// Not a readable directory so pass it through.
+			expanded = append(expanded, filename)
+			continue
+		}
+		var images []string
+		for _, info := range infos {
+			if info.IsDir() {
+				continue
+			}
+			if dvid.Filename(info.Name()).HasExtensionPrefix("png", "jpg", "jpeg", "tif", "gif") {
+				images = append(images, filepath.Join(filename, info.Name()))
+			}
+		}
+		if len(images) == 0 {
+			return nil, fmt.Errorf("No image files found in directory %q", filename)
+		}
+		sort.Strings(images)
+		expanded = append(expanded, images...)
+	}
+	return expanded, nil
+}
+
+// isN5Dataset returns true if the given path is a directory holding an N5 dataset.
+func isN5Dataset(filename string) bool {
+	info, err := os.Stat(filepath.Join(filename, "attributes.json"))
+	return err == nil && !info.IsDir()
+}
+
+// loadN5 loads an N5 dataset with its first voxel at the load offset.  Each row of
+// blocks is assembled in parallel from the dataset chunks overlapping it, merged with
+// any stored blocks, and written in batches.
+func loadN5(i IntData, load *bulkLoadInfo) error {
+	blockSize, ok := i.BlockSize().(dvid.Point3d)
+	if !ok {
+		return fmt.Errorf("N5 load only supported for 3d blocks, not %s", i.BlockSize())
+	}
+	offset, ok := load.offset.(dvid.Point3d)
+	if !ok {
+		return fmt.Errorf("N5 load requires a 3d offset, not %s", load.offset)
+	}
+	format := n5Format{}
+	spec, err := format.readMetadata(dirSource(load.filenames[0]), "")
+	if err != nil {
+		return err
+	}
+	if err := spec.checkValues(i.BaseData().DataName(), i.Values()); err != nil {
+		return err
+	}
+	spec.offset = offset
+
+	byteOrder := binary.ByteOrder(binary.LittleEndian)
+	if d, ok := i.(*Data); ok {
+		byteOrder = d.ByteOrder
+	}
+	swap := spec.valueBytes > 1 && spec.byteOrder != byteOrder
+
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	ctx := datastore.NewVersionedContext(i.BaseData(), load.versionID)
+
+	var last dvid.Point3d
+	for n := range last {
+		last[n] = offset[n] + spec.size[n] - 1
+	}
+	minBlock := offset.Chunk(blockSize).(dvid.ChunkPoint3d)
+	maxBlock := last.Chunk(blockSize).(dvid.ChunkPoint3d)
+	load.progress.setTotal(int(maxBlock[2]-minBlock[2]+1)*int(maxBlock[1]-minBlock[1]+1), "block rows")
+
+	chunks := make(map[dvid.Point3d][]byte)
+	var waitForWrites sync.WaitGroup
+	for bz := minBlock[2]; bz <= maxBlock[2]; bz++ {
+		for by := minBlock[1]; by <= maxBlock[1]; by++ {
+			server.BlockOnInteractiveRequests("voxels.loadN5")
+
+			beg, end := spec.rowBounds(blockSize, by, bz)
+			if err := readRowChunks(format, spec, chunks, beg, end, swap); err != nil {
+				waitForWrites.Wait()
+				return err
+			}
+
+			// Get stored blocks of the row so partially covered blocks keep their voxels.
+			rowBeg, rowEnd := dvid.IndexZYX{minBlock[0], by, bz}, dvid.IndexZYX{maxBlock[0], by, bz}
+			keyvalues, err := db.GetRange(ctx, NewVoxelBlockIndex(&rowBeg), NewVoxelBlockIndex(&rowEnd))
+			if err != nil {
+				waitForWrites.Wait()
+				return err
+			}
+			oldBlocks := make(map[string][]byte, len(keyvalues))
+			for _, kv := range keyvalues {
+				indexBytes, err := ctx.IndexFromKey(kv.K)
+				if err != nil {
+					waitForWrites.Wait()
+					return err
+				}
+				block, _, err := dvid.DeserializeData(kv.V, true)
+				if err != nil {
+					waitForWrites.Wait()
+					return fmt.Errorf("Unable to deserialize block, %s: %s", ctx, err.Error())
+				}
+				oldBlocks[string(indexBytes)] = block
+			}
+
+			// Assemble the blocks of the row in parallel.
+			numBlocks := int(maxBlock[0] - minBlock[0] + 1)
+			blocks := make(Blocks, numBlocks)
+			var wg sync.WaitGroup
+			for n := 0; n < numBlocks; n++ {
+				<-server.HandlerToken
+				wg.Add(1)
+				go func(n int) {
+					defer func() {
+						server.HandlerToken <- 1
+						wg.Done()
+					}()
+					index := dvid.IndexZYX{minBlock[0] + int32(n), by, bz}
+					indexBytes := NewVoxelBlockIndex(&index)
+					blocks[n].K = ctx.ConstructKey(indexBytes)
+					blocks[n].V = importBlock(spec, chunks, index, blockSize, beg, end, oldBlocks[string(indexBytes)])
+				}(n)
+			}
+			wg.Wait()
+
+			// Write the row asynchronously in batches.
+			var rowWritten sync.WaitGroup
+			rowWritten.Add(1)
+			waitForWrites.Add(1)
+			if err := writeBlocks(ctx, i.Compression(), i.Checksum(), blocks, &rowWritten, &waitForWrites); err != nil {
+				rowWritten.Done()
+				waitForWrites.Done()
+				waitForWrites.Wait()
+				return err
+			}
+			rowVoxels := int(end[0]-beg[0]+1) * int(end[1]-beg[1]+1) * int(end[2]-beg[2]+1)
+			load.progress.add(rowVoxels * spec.bytesPerVoxel())
+		}
+	}
+	waitForWrites.Wait()
+
+	if i.Extents().AdjustPoints(offset, last) {
+		load.extentChanged.SetTrue()
+	}
+	minIndex, maxIndex := dvid.IndexZYX(minBlock), dvid.IndexZYX(maxBlock)
+	if i.Extents().AdjustIndices(&minIndex, &maxIndex) {
+		load.extentChanged.SetTrue()
+	}
+	return nil
+}
+
+// LoadProgress describes the progress of a bulk load.
+type LoadProgress struct {
+	id        dvid.InstanceID
+	Data      dvid.DataString
+	Unit      string // what is counted, e.g., "slices"
+	Total     int
+	Done      int
+	BytesRead int64
+	Started   time.Time
+
+	lastReport time.Time
+}
+
+// Percent returns the percentage of the load completed.
+func (p *LoadProgress) Percent() float64 {
+	if p.Total == 0 {
+		return 100.0
+	}
+	return 100.0 * float64(p.Done) / float64(p.Total)
+}
+
+// Remaining returns the estimated time remaining based on the rate so far.
+func (p *LoadProgress) Remaining() time.Duration {
+	if p.Done == 0 {
+		return 0
+	}
+	elapsed := time.Since(p.Started)
+	perItem := elapsed / time.Duration(p.Done)
+	return perItem * time.Duration(p.Total-p.Done)
+}
+
+func (p *LoadProgress) String() string {
+	elapsed := time.Since(p.Started).Seconds()
+	var mbPerSec float64
+	if elapsed > 0 {
+		mbPerSec = float64(p.BytesRead) / elapsed / 1000000.0
+	}
+	return fmt.Sprintf("%d of %d %s (%.1f%%), %.1f MB/s, ~%s remaining",
+		p.Done, p.Total, p.Unit, p.Percent(), mbPerSec, p.Remaining())
+}
+
+// MarshalJSON returns the progress with derived stats.
+func (p *LoadProgress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Data             dvid.DataString
+		Unit             string
+		Total            int
+		Done             int
+		Percent          float64
+		BytesRead        int64
+		Started          time.Time
+		SecondsRemaining float64
+	}{
+		p.Data,
+		p.Unit,
+		p.Total,
+		p.Done,
+		p.Percent(),
+		p.BytesRead,
+		p.Started,
+		p.Remaining().Seconds(),
+	})
+}
+
+// Active bulk loads keyed by data instance.
+var (
+	activeLoads   = make(map[dvid.InstanceID]*LoadProgress)
+	activeLoadsMu sync.RWMutex
+)
+
+func startLoadProgress(data dvid.Data, total int) *LoadProgress {
+	now := time.Now()
+	p := &LoadProgress{id: data.InstanceID(), Data: data.DataName(), Unit: "slices", Total: total, Started: now, lastReport: now}
+	activeLoadsMu.Lock()
+	activeLoads[p.id] = p
+	activeLoadsMu.Unlock()
+	return p
+}
+
+// setTotal sets the number and kind of items to load once known, e.g., rows of blocks
+// after reading the metadata of a dataset.
+func (p *LoadProgress) setTotal(total int, unit string) {
+	activeLoadsMu.Lock()
+	p.Total = total
+	p.Unit = unit
+	activeLoadsMu.Unlock()
+}
+
+// add records completion of an item with the given number of bytes, periodically
+// logging progress.
+func (p *LoadProgress) add(numBytes int) {
+	activeLoadsMu.Lock()
+	p.Done++
+	p.BytesRead += int64(numBytes)
+	var report string
+	if time.Since(p.lastReport) > loadReportInterval {
+		p.lastReport = time.Now()
+		report = p.String()
+	}
+	activeLoadsMu.Unlock()
+	if report != "" {
+		dvid.Infof("Loading %s: %s\n", p.Data, report)
+	}
+}
+
+func (p *LoadProgress) finish() {
+	activeLoadsMu.Lock()
+	delete(activeLoads, p.id)
+	activeLoadsMu.Unlock()
+}
+
+// GetLoadProgress returns a copy of the progress of any bulk load into the given data.
+func GetLoadProgress(data dvid.Data) (LoadProgress, bool) {
+	activeLoadsMu.RLock()
+	defer activeLoadsMu.RUnlock()
+	p, found := activeLoads[data.InstanceID()]
+	if !found {
+		return LoadProgress{}, false
+	}
+	return *p, true
+}
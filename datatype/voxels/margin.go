@@ -0,0 +1,108 @@
+/*
+	This file supports extending requested subvolumes by a margin of context voxels so
+	clients like tiled inference can get halo voxels around a box in a single request.
+*/
+
+package voxels
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// MarginFromQuery returns the margin given by the "margin" query string, which can be a
+// single value for all dimensions or values for each dimension separated by underscores,
+// e.g., "margin=16" or "margin=16_16_4".  Returns a zero margin if no margin is given.
+func MarginFromQuery(r *http.Request) (dvid.Point3d, error) {
+	var margin dvid.Point3d
+	str := r.URL.Query().Get("margin")
+	if str == "" {
+		return margin, nil
+	}
+	values := strings.Split(str, "_")
+	if len(values) != 1 && len(values) != 3 {
+		return margin, fmt.Errorf("margin %q must be a single value or 3 values separated by '_'", str)
+	}
+	for dim := 0; dim < 3; dim++ {
+		valueStr := values[0]
+		if len(values) == 3 {
+			valueStr = values[dim]
+		}
+		value, err := strconv.ParseUint(valueStr, 10, 31)
+		if err != nil {
+			return margin, fmt.Errorf("Bad margin %q: %s", str, err.Error())
+		}
+		margin[dim] = int32(value)
+	}
+	return margin, nil
+}
+
+// extendBox returns the inclusive box that extends the requested box by the margin but
+// not beyond the given bounds.  The requested box is never shrunk.
+func extendBox(minPt, maxPt, margin, boundMin, boundMax dvid.Point3d) (dvid.Point3d, dvid.Point3d) {
+	var newMin, newMax dvid.Point3d
+	for dim := 0; dim < 3; dim++ {
+		// Use int64 so margins near the coordinate limits don't overflow.
+		lo := int64(minPt[dim]) - int64(margin[dim])
+		if limit := int64(boundMin[dim]); lo < limit {
+			lo = limit
+		}
+		if lo > int64(minPt[dim]) {
+			lo = int64(minPt[dim])
+		}
+		hi := int64(maxPt[dim]) + int64(margin[dim])
+		if limit := int64(boundMax[dim]); hi > limit {
+			hi = limit
+		}
+		if hi < int64(maxPt[dim]) {
+			hi = int64(maxPt[dim])
+		}
+		newMin[dim], newMax[dim] = int32(lo), int32(hi)
+	}
+	return newMin, newMax
+}
+
+// ExtendSubvolume returns the subvolume extended by the margin and clamped to the
+// extents of the data at the given scale level.  If the data has no extents yet, the
+// subvolume is extended without clamping.
+func (d *Data) ExtendSubvolume(subvol *dvid.Subvolume, margin dvid.Point3d, scale uint8) (*dvid.Subvolume, error) {
+	if margin == (dvid.Point3d{}) {
+		return subvol, nil
+	}
+	minPt, ok := subvol.StartPoint().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("Can only extend 3d subvolumes, not %s", subvol)
+	}
+	maxPt := subvol.EndPoint().(dvid.Point3d)
+
+	boundMin := dvid.Point3d{math.MinInt32, math.MinInt32, math.MinInt32}
+	boundMax := dvid.Point3d{math.MaxInt32, math.MaxInt32, math.MaxInt32}
+	extents := d.Extents()
+	if extents.MinPoint != nil && extents.MaxPoint != nil {
+		extMin, ok1 := extents.MinPoint.(dvid.Point3d)
+		extMax, ok2 := extents.MaxPoint.(dvid.Point3d)
+		if ok1 && ok2 {
+			for dim := 0; dim < 3; dim++ {
+				boundMin[dim] = extMin[dim] >> scale
+				boundMax[dim] = extMax[dim] >> scale
+			}
+		}
+	}
+	newMin, newMax := extendBox(minPt, maxPt, margin, boundMin, boundMax)
+	size := newMax.Sub(newMin).Add(dvid.Point3d{1, 1, 1})
+	return dvid.NewSubvolume(newMin, size), nil
+}
+
+// SetSubvolumeHeaders sets response headers giving the offset and size of the returned
+// subvolume, which may differ from the requested one when a margin is used.
+func SetSubvolumeHeaders(w http.ResponseWriter, subvol *dvid.Subvolume) {
+	offset := subvol.StartPoint().(dvid.Point3d)
+	size := subvol.Size().(dvid.Point3d)
+	w.Header().Set("X-Dvid-Offset", fmt.Sprintf("%d_%d_%d", offset[0], offset[1], offset[2]))
+	w.Header().Set("X-Dvid-Size", fmt.Sprintf("%d_%d_%d", size[0], size[1], size[2]))
+}
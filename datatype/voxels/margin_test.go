@@ -0,0 +1,52 @@
+package voxels
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestMarginFromQuery(t *testing.T) {
+	tests := map[string]dvid.Point3d{
+		"":                {0, 0, 0},
+		"?margin=16":      {16, 16, 16},
+		"?margin=16_8_4":  {16, 8, 4},
+		"?margin=-1":      {},
+		"?margin=1_2":     {},
+		"?margin=1_2_bad": {},
+	}
+	for query, expected := range tests {
+		r, err := http.NewRequest("GET", "/api/node/1/grayscale/raw/0_1_2/10_10_10/0_0_0"+query, nil)
+		if err != nil {
+			t.Fatalf("Error creating request: %s\n", err.Error())
+		}
+		margin, err := MarginFromQuery(r)
+		valid := expected != (dvid.Point3d{}) || query == ""
+		if valid && (err != nil || margin != expected) {
+			t.Errorf("Query %q: expected margin %s, got %s (%v)\n", query, expected, margin, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Query %q: expected error, got margin %s\n", query, margin)
+		}
+	}
+}
+
+func TestExtendBox(t *testing.T) {
+	boundMin := dvid.Point3d{0, 0, 0}
+	boundMax := dvid.Point3d{99, 99, 99}
+
+	// Clamped on the low side of x and high side of z.
+	newMin, newMax := extendBox(dvid.Point3d{5, 20, 80}, dvid.Point3d{14, 29, 95},
+		dvid.Point3d{8, 8, 8}, boundMin, boundMax)
+	if newMin != (dvid.Point3d{0, 12, 72}) || newMax != (dvid.Point3d{22, 37, 99}) {
+		t.Errorf("Bad extended box: %s to %s\n", newMin, newMax)
+	}
+
+	// A requested box outside the bounds is never shrunk.
+	newMin, newMax = extendBox(dvid.Point3d{-10, 0, 0}, dvid.Point3d{120, 9, 9},
+		dvid.Point3d{4, 0, 0}, boundMin, boundMax)
+	if newMin != (dvid.Point3d{-10, 0, 0}) || newMax != (dvid.Point3d{120, 9, 9}) {
+		t.Errorf("Expected requested box to be unchanged, got %s to %s\n", newMin, newMax)
+	}
+}
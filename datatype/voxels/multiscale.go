@@ -0,0 +1,279 @@
+/*
+	This file supports multiscale pyramids of voxel data.  Each scale level halves the
+	resolution of the level below it and is stored in the KeyScaledBlock keyspace using
+	the same block size as the original data, so level r blocks cover 2^r times the
+	volume of original blocks.  Interpolable data like grayscale is averaged while label
+	data uses the most frequent value among each 2x2x2 voxel neighborhood.
+*/
+
+package voxels
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MaxScaleLevel is the maximum scale level that can be computed, i.e., downsampling
+// by 2^MaxScaleLevel.
+const MaxScaleLevel = 10
+
+// ScaleFromQuery returns the scale level given by a "scale" query string or 0 if none
+// was given.
+func ScaleFromQuery(r *http.Request) (uint8, error) {
+	scaleStr := r.URL.Query().Get("scale")
+	if scaleStr == "" {
+		return 0, nil
+	}
+	scale, err := strconv.Atoi(scaleStr)
+	if err != nil || scale < 0 || scale > MaxScaleLevel {
+		return 0, fmt.Errorf("scale must be an integer from 0 to %d, got %q", MaxScaleLevel, scaleStr)
+	}
+	return uint8(scale), nil
+}
+
+// ComputePyramid launches a job that computes and stores downsampled versions of a
+// version's voxel data at scale levels 1 through the requested number of levels.
+// Scales must be recomputed after voxels are modified.
+func (d *Data) ComputePyramid(request datastore.Request, reply *datastore.Response) error {
+	var uuidStr, dataName, cmdStr, levelsStr string
+	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &levelsStr)
+
+	levels, err := strconv.Atoi(levelsStr)
+	if err != nil || levels < 1 || levels > MaxScaleLevel {
+		return fmt.Errorf("Number of scale levels must be an integer from 1 to %d, got %q", MaxScaleLevel, levelsStr)
+	}
+	if err := d.checkDownsampling(); err != nil {
+		return err
+	}
+
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if err = repo.AddToLog(request.Command.String()); err != nil {
+		return err
+	}
+
+	go d.computePyramid(versionID, uint8(levels))
+
+	reply.Text = fmt.Sprintf("Started computation of %d scale levels for %q, version %s\n", levels, d.DataName(), uuid)
+	return nil
+}
+
+// checkDownsampling returns an error if the data can't be downsampled by averaging or
+// picking the most frequent value.
+func (d *Data) checkDownsampling() error {
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return fmt.Errorf("Multiscale data only supported for 3d blocks, not %s", d.BlockSize())
+	}
+	for dim := uint8(0); dim < 3; dim++ {
+		if blockSize.Value(dim)%2 != 0 {
+			return fmt.Errorf("Multiscale data requires even block sizes, not %s", blockSize)
+		}
+	}
+	if d.Interpolable {
+		for i := range d.Values() {
+			if d.Values().ValueBytes(i) != 1 {
+				return fmt.Errorf("Multiscale averaging only supported for 8-bit values")
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Data) computePyramid(versionID dvid.VersionID, levels uint8) {
+	timedLog := dvid.NewTimeLog()
+	timedLog.Infof("Starting computation of %d scale levels for %s", levels, d.DataName())
+
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		dvid.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+		return
+	}
+	batcher, ok := bigdata.(storage.KeyValueBatcher)
+	if !ok {
+		dvid.Errorf("Unable to compute scale levels: big data store can't do batching!")
+		return
+	}
+	ctx := datastore.NewVersionedContext(d, versionID)
+
+	var computed uint8
+	for scale := uint8(1); scale <= levels; scale++ {
+		numBlocks, err := d.computeScale(ctx, bigdata, batcher, scale)
+		if err != nil {
+			dvid.Errorf("Error computing scale %d for %s: %s\n", scale, d.DataName(), err.Error())
+			return
+		}
+		dvid.Infof("Computed %d blocks at scale %d for %s\n", numBlocks, scale, d.DataName())
+		if numBlocks == 0 {
+			break
+		}
+		computed = scale
+	}
+	d.Properties.ScaleLevels = computed
+	if err := datastore.SaveRepoByVersionID(versionID); err != nil {
+		dvid.Errorf("Error saving scale levels for %s: %s\n", d.DataName(), err.Error())
+	}
+	timedLog.Infof("Computed scale levels for %s", d.DataName())
+}
+
+// computeScale replaces all blocks at the given scale with blocks downsampled from the
+// scale below.  Since source blocks are processed in ZYX order, only one z slab of
+// downsampled blocks needs to be held in memory.
+func (d *Data) computeScale(ctx storage.Context, bigdata storage.OrderedKeyValueDB, batcher storage.KeyValueBatcher, scale uint8) (int, error) {
+	minIndex := NewScaledBlockIndex(scale, &dvid.MinIndexZYX)
+	maxIndex := NewScaledBlockIndex(scale, &dvid.MaxIndexZYX)
+	if err := bigdata.DeleteRange(ctx, minIndex, maxIndex); err != nil {
+		return 0, err
+	}
+
+	blockSize := d.BlockSize().(dvid.Point3d)
+	bytesPerVoxel := d.Values().BytesPerElement()
+	blockBytes := int(blockSize.Prod() * int64(bytesPerVoxel))
+
+	var numBlocks int
+	var parentZ int32
+	parents := make(map[dvid.IndexZYX][]byte)
+	batch := batcher.NewBatch(ctx)
+	flush := func() error {
+		for index, data := range parents {
+			serialization, err := dvid.SerializeData(data, d.Compression(), d.Checksum())
+			if err != nil {
+				return err
+			}
+			parentIndex := index
+			batch.Put(NewScaledBlockIndex(scale, &parentIndex), serialization)
+			numBlocks++
+			if numBlocks%KVWriteSize == 0 {
+				if err := batch.Commit(); err != nil {
+					return err
+				}
+				batch = batcher.NewBatch(ctx)
+			}
+		}
+		parents = make(map[dvid.IndexZYX][]byte)
+		return nil
+	}
+
+	var processErr error
+	srcBeg := NewScaledBlockIndex(scale-1, &dvid.MinIndexZYX)
+	srcEnd := NewScaledBlockIndex(scale-1, &dvid.MaxIndexZYX)
+	err := bigdata.ProcessRange(ctx, srcBeg, srcEnd, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if processErr != nil || chunk == nil || chunk.V == nil {
+			return
+		}
+		_, indexZYX, err := DecodeScaledBlockKey(chunk.K)
+		if err != nil {
+			processErr = err
+			return
+		}
+		data, _, err := dvid.DeserializeData(chunk.V, true)
+		if err != nil {
+			processErr = fmt.Errorf("Unable to deserialize block %s: %s", indexZYX, err.Error())
+			return
+		}
+		if len(data) != blockBytes {
+			processErr = fmt.Errorf("Block %s has %d bytes, expected %d bytes", indexZYX, len(data), blockBytes)
+			return
+		}
+
+		// Arithmetic shift gives floor division for negative block coordinates.
+		parent := dvid.IndexZYX{indexZYX[0] >> 1, indexZYX[1] >> 1, indexZYX[2] >> 1}
+		if len(parents) != 0 && parent[2] != parentZ {
+			if processErr = flush(); processErr != nil {
+				return
+			}
+		}
+		parentZ = parent[2]
+		dst, found := parents[parent]
+		if !found {
+			dst = make([]byte, blockBytes)
+			parents[parent] = dst
+		}
+		octant := dvid.Point3d{indexZYX[0] - 2*parent[0], indexZYX[1] - 2*parent[1], indexZYX[2] - 2*parent[2]}
+		downsampleBlock(dst, data, blockSize, octant, bytesPerVoxel, d.Interpolable)
+
+		server.BlockOnInteractiveRequests("voxels [compute scale levels]")
+	})
+	if err != nil {
+		return numBlocks, err
+	}
+	if processErr != nil {
+		return numBlocks, processErr
+	}
+	if err := flush(); err != nil {
+		return numBlocks, err
+	}
+	return numBlocks, batch.Commit()
+}
+
+// downsampleBlock reduces a source block by 2x along each axis and writes the result
+// into one octant of the destination block, which has the same size as the source.
+// Each octant coordinate is 0 or 1.  If interpolable, each byte is averaged over the
+// 2x2x2 source neighborhood.  Otherwise, the most frequent voxel value is used with ties
+// going to the first value in XYZ order.
+func downsampleBlock(dst, src []byte, blockSize, octant dvid.Point3d, bytesPerVoxel int32, interpolable bool) {
+	nx, ny, nz := blockSize[0], blockSize[1], blockSize[2]
+	hx, hy, hz := nx/2, ny/2, nz/2
+	var neighbors [8][]byte
+	for z := int32(0); z < hz; z++ {
+		for y := int32(0); y < hy; y++ {
+			for x := int32(0); x < hx; x++ {
+				n := 0
+				for dz := int32(0); dz < 2; dz++ {
+					for dy := int32(0); dy < 2; dy++ {
+						for dx := int32(0); dx < 2; dx++ {
+							i := (((2*z+dz)*ny+2*y+dy)*nx + 2*x + dx) * bytesPerVoxel
+							neighbors[n] = src[i : i+bytesPerVoxel]
+							n++
+						}
+					}
+				}
+				dstX, dstY, dstZ := octant[0]*hx+x, octant[1]*hy+y, octant[2]*hz+z
+				i := ((dstZ*ny+dstY)*nx + dstX) * bytesPerVoxel
+				voxel := dst[i : i+bytesPerVoxel]
+				if interpolable {
+					for b := int32(0); b < bytesPerVoxel; b++ {
+						var sum int
+						for _, neighbor := range neighbors {
+							sum += int(neighbor[b])
+						}
+						voxel[b] = uint8((sum + 4) / 8)
+					}
+				} else {
+					copy(voxel, mostFrequent(neighbors))
+				}
+			}
+		}
+	}
+}
+
+// mostFrequent returns the most frequent value with ties going to the earliest value.
+func mostFrequent(values [8][]byte) []byte {
+	var best []byte
+	var bestCount int
+	for i, value := range values {
+		count := 1
+		for j := i + 1; j < len(values); j++ {
+			if bytes.Equal(value, values[j]) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = value, count
+		}
+	}
+	return best
+}
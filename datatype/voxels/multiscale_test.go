@@ -0,0 +1,76 @@
+package voxels
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestScaledBlockIndex(t *testing.T) {
+	blockIndex := dvid.IndexZYX{-3, 4, 5}
+	if !bytes.Equal(NewScaledBlockIndex(0, &blockIndex), NewVoxelBlockIndex(&blockIndex)) {
+		t.Errorf("Expected scale 0 index to match voxel block index\n")
+	}
+	index := NewScaledBlockIndex(2, &blockIndex)
+	if index[0] != byte(KeyScaledBlock) || index[1] != 2 {
+		t.Errorf("Bad scaled block index prefix: %v\n", index[:2])
+	}
+	spatial, err := blockSpatialIndex(index)
+	if err != nil {
+		t.Fatalf("Error getting spatial index: %s\n", err.Error())
+	}
+	if !bytes.Equal(spatial, blockIndex.Bytes()) {
+		t.Errorf("Expected spatial index %v, got %v\n", blockIndex.Bytes(), spatial)
+	}
+	if _, err := blockSpatialIndex(NewBlockHistogramIndex(&blockIndex)); err == nil {
+		t.Errorf("Expected error getting spatial index of histogram key\n")
+	}
+}
+
+func TestDownsampleBlock(t *testing.T) {
+	blockSize := dvid.Point3d{4, 4, 4}
+
+	// Grayscale with a gradient along x so each 2x2x2 average is known.
+	src := make([]byte, 64)
+	for i := range src {
+		src[i] = byte(10 * (i % 4))
+	}
+	dst := make([]byte, 64)
+	downsampleBlock(dst, src, blockSize, dvid.Point3d{1, 0, 1}, 1, true)
+	for z := 0; z < 4; z++ {
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				var expected byte
+				if z >= 2 && y < 2 && x >= 2 {
+					expected = []byte{5, 25}[x-2]
+				}
+				if got := dst[z*16+y*4+x]; got != expected {
+					t.Fatalf("Bad averaged voxel (%d,%d,%d): expected %d, got %d\n", x, y, z, expected, got)
+				}
+			}
+		}
+	}
+
+	// Labels with 2 bytes/voxel use the most frequent value.
+	labels := make([]byte, 64*2)
+	for i := 0; i < 64; i++ {
+		x, y, z := i%4, (i/4)%4, i/16
+		if x == 1 && y == 1 && z == 1 {
+			labels[i*2] = 7 // minority label in first neighborhood
+		} else if x >= 2 {
+			labels[i*2+1] = 1 // label 256 for second half
+		}
+	}
+	dst = make([]byte, 64*2)
+	downsampleBlock(dst, labels, blockSize, dvid.Point3d{0, 0, 0}, 2, false)
+	if dst[0] != 0 || dst[1] != 0 {
+		t.Errorf("Expected majority label 0 in first voxel, got %v\n", dst[0:2])
+	}
+	if dst[2] != 0 || dst[3] != 1 {
+		t.Errorf("Expected label 256 in second voxel, got %v\n", dst[2:4])
+	}
+	if dst[8*2+1] != 0 || dst[8*2] != 0 {
+		t.Errorf("Expected untouched voxel outside octant, got %v\n", dst[16:18])
+	}
+}
@@ -0,0 +1,109 @@
+/*
+	This file supports partitioning the stored blocks of voxels data into balanced,
+	block-aligned chunks so external schedulers can plan parallel jobs.
+*/
+
+package voxels
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Partition returns the stored blocks of this data, optionally restricted to an ROI,
+// partitioned into at most n chunks with balanced block count or, if byBytes is true,
+// balanced stored bytes.
+func (d *Data) Partition(ctx *datastore.VersionedContext, n int, byBytes bool, roiname dvid.DataString) (*dvid.BlockPartition, error) {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	var roiIterator *roi.Iterator
+	if len(roiname) != 0 {
+		roiIterator, err = roi.NewIterator(roiname, ctx.VersionID(), d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blocks []dvid.WeightedBlock
+	var processErr error
+	begIndex := NewVoxelBlockIndex(&dvid.MinIndexZYX)
+	endIndex := NewVoxelBlockIndex(&dvid.MaxIndexZYX)
+	err = bigdata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if processErr != nil || chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		indexZYX, err := DecodeVoxelBlockKey(chunk.K)
+		if err != nil {
+			processErr = err
+			return
+		}
+		if roiIterator != nil && !roiIterator.InsideFast(*indexZYX) {
+			return
+		}
+		weight := uint64(1)
+		if byBytes {
+			weight = uint64(len(chunk.V))
+		}
+		blocks = append(blocks, dvid.WeightedBlock{dvid.ChunkPoint3d(*indexZYX), weight})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if processErr != nil {
+		return nil, processErr
+	}
+
+	by := "blocks"
+	if byBytes {
+		by = "bytes"
+	}
+	return dvid.PartitionBlocks(blocks, n, d.BlockSize().(dvid.Point3d), by)
+}
+
+// ServePartition handles GET requests for partitions of this data's stored blocks.
+// Query strings give the number of chunks "n", the balancing "by" ("blocks" or "bytes"),
+// and an optional "roi" restricting blocks.
+func (d *Data) ServePartition(ctx *datastore.VersionedContext, w http.ResponseWriter, r *http.Request) {
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "partition only supports GET request")
+		return
+	}
+	queryValues := r.URL.Query()
+	n, err := strconv.Atoi(queryValues.Get("n"))
+	if err != nil || n < 1 {
+		server.BadRequest(w, r, fmt.Sprintf("partition requires positive number of chunks 'n', got %q", queryValues.Get("n")))
+		return
+	}
+	var byBytes bool
+	switch queryValues.Get("by") {
+	case "", "blocks":
+	case "bytes":
+		byBytes = true
+	default:
+		server.BadRequest(w, r, fmt.Sprintf("partition 'by' must be 'blocks' or 'bytes', got %q", queryValues.Get("by")))
+		return
+	}
+	partition, err := d.Partition(ctx, n, byBytes, dvid.DataString(queryValues.Get("roi")))
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(partition)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+}
@@ -0,0 +1,291 @@
+/*
+	This file serves voxel data in the Neuroglancer "precomputed" format so Neuroglancer
+	can browse a version of a data instance directly via a source URL like
+
+		precomputed://http://<server>/api/node/<UUID>/<data name>/precomputed
+
+	The info JSON lists the full resolution data as scale "s0" plus any scale levels
+	computed by the "pyramid" command.  Chunks use the "raw" encoding, which holds
+	little-endian values with each channel stored as a separate volume.
+*/
+
+package voxels
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// PrecomputedMeshes provides label meshes for the precomputed format.  Each label has
+// a single mesh fragment in the legacy precomputed mesh format.
+type PrecomputedMeshes interface {
+	// PrecomputedMesh returns the mesh of a label with vertices in voxel coordinates,
+	// or false if the label has no mesh.
+	PrecomputedMesh(ctx *datastore.VersionedContext, label uint64) ([]byte, bool, error)
+}
+
+type precomputedScale struct {
+	Key         string     `json:"key"`
+	Size        [3]int32   `json:"size"`
+	VoxelOffset [3]int32   `json:"voxel_offset"`
+	Resolution  [3]float32 `json:"resolution"`
+	ChunkSizes  [][3]int32 `json:"chunk_sizes"`
+	Encoding    string     `json:"encoding"`
+}
+
+type precomputedInfo struct {
+	Type        string             `json:"@type"`
+	VolumeType  string             `json:"type"`
+	DataType    string             `json:"data_type"`
+	NumChannels int                `json:"num_channels"`
+	Scales      []precomputedScale `json:"scales"`
+	Mesh        string             `json:"mesh,omitempty"`
+}
+
+// precomputedDataType returns the precomputed data type of the voxel values, which must
+// all have the same type.
+func precomputedDataType(values dvid.DataValues) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("No values defined for voxels")
+	}
+	dataType := values[0].T
+	for _, value := range values[1:] {
+		if value.T != dataType {
+			return "", fmt.Errorf("Precomputed format requires all channels have the same type")
+		}
+	}
+	switch dataType {
+	case dvid.T_uint8:
+		return "uint8", nil
+	case dvid.T_uint16:
+		return "uint16", nil
+	case dvid.T_uint32:
+		return "uint32", nil
+	case dvid.T_uint64:
+		return "uint64", nil
+	case dvid.T_float32:
+		return "float32", nil
+	default:
+		return "", fmt.Errorf("Precomputed format doesn't support %s values", dataType)
+	}
+}
+
+// getPrecomputedInfo returns the info JSON describing the stored voxels.
+func (d *Data) getPrecomputedInfo(meshes PrecomputedMeshes) (*precomputedInfo, error) {
+	dataType, err := precomputedDataType(d.Values())
+	if err != nil {
+		return nil, err
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("Precomputed format only supported for 3d blocks, not %s", d.BlockSize())
+	}
+	minPt, minOk := d.Extents().MinPoint.(dvid.Point3d)
+	maxPt, maxOk := d.Extents().MaxPoint.(dvid.Point3d)
+	if !minOk || !maxOk {
+		return nil, fmt.Errorf("No voxels stored in %q", d.DataName())
+	}
+	if len(d.Properties.Resolution.VoxelSize) != 3 {
+		return nil, fmt.Errorf("Precomputed format requires 3d voxel size, not %s", d.Properties.Resolution.VoxelSize)
+	}
+
+	info := &precomputedInfo{
+		Type:        "neuroglancer_multiscale_volume",
+		VolumeType:  "image",
+		DataType:    dataType,
+		NumChannels: len(d.Values()),
+	}
+	if meshes != nil {
+		info.VolumeType = "segmentation"
+		info.Mesh = "mesh"
+	}
+	for scale := uint8(0); scale <= d.Properties.ScaleLevels; scale++ {
+		s := precomputedScale{
+			Key:        fmt.Sprintf("s%d", scale),
+			ChunkSizes: [][3]int32{{blockSize[0], blockSize[1], blockSize[2]}},
+			Encoding:   "raw",
+		}
+		// Arithmetic shift gives floor division for negative coordinates.
+		for dim := 0; dim < 3; dim++ {
+			s.VoxelOffset[dim] = minPt[dim] >> scale
+			s.Size[dim] = maxPt[dim]>>scale - s.VoxelOffset[dim] + 1
+			s.Resolution[dim] = d.Properties.Resolution.VoxelSize[dim] * float32(int32(1)<<scale)
+		}
+		info.Scales = append(info.Scales, s)
+	}
+	return info, nil
+}
+
+// parsePrecomputedChunk returns the scale level, offset and size of a chunk given its
+// scale key and "<xBegin>-<xEnd>_<yBegin>-<yEnd>_<zBegin>-<zEnd>" name.
+func parsePrecomputedChunk(key, name string, maxScale uint8) (uint8, dvid.Point3d, dvid.Point3d, error) {
+	var offset, size dvid.Point3d
+	scale, err := strconv.Atoi(strings.TrimPrefix(key, "s"))
+	if err != nil || !strings.HasPrefix(key, "s") || scale < 0 || scale > int(maxScale) {
+		return 0, offset, size, fmt.Errorf("Bad precomputed scale key %q", key)
+	}
+	ranges := strings.Split(name, "_")
+	if len(ranges) != 3 {
+		return 0, offset, size, fmt.Errorf("Bad precomputed chunk name %q", name)
+	}
+	for dim, r := range ranges {
+		bounds := strings.Split(r, "-")
+		if len(bounds) != 2 {
+			return 0, offset, size, fmt.Errorf("Bad precomputed chunk name %q", name)
+		}
+		beg, err1 := strconv.ParseInt(bounds[0], 10, 32)
+		end, err2 := strconv.ParseInt(bounds[1], 10, 32)
+		if err1 != nil || err2 != nil || end <= beg {
+			return 0, offset, size, fmt.Errorf("Bad precomputed chunk name %q", name)
+		}
+		offset[dim] = int32(beg)
+		size[dim] = int32(end - beg)
+	}
+	return uint8(scale), offset, size, nil
+}
+
+// encodePrecomputedChunk converts voxels to the raw chunk encoding: little-endian values
+// with each channel stored as a separate volume.
+func encodePrecomputedChunk(data []byte, values dvid.DataValues, byteOrder binary.ByteOrder) []byte {
+	valueBytes := int(values.ValueBytes(0))
+	if valueBytes > 1 && byteOrder != binary.LittleEndian {
+		data = swapBytes(data, valueBytes)
+	}
+	channels := len(values)
+	if channels == 1 {
+		return data
+	}
+	numVoxels := len(data) / (channels * valueBytes)
+	chunk := make([]byte, len(data))
+	for c := 0; c < channels; c++ {
+		for i := 0; i < numVoxels; i++ {
+			src := (i*channels + c) * valueBytes
+			dst := (c*numVoxels + i) * valueBytes
+			copy(chunk[dst:dst+valueBytes], data[src:src+valueBytes])
+		}
+	}
+	return chunk
+}
+
+// ServePrecomputed handles GET requests for the precomputed format, where parts are the
+// URL path elements following "precomputed".  The IntData is used to read voxels so
+// types built on voxels can supply their own ExtData handlers.  If meshes is non-nil,
+// the data is served as a segmentation with label meshes.
+func (d *Data) ServePrecomputed(ctx *datastore.VersionedContext, i IntData, w http.ResponseWriter, r *http.Request, parts []string, meshes PrecomputedMeshes) {
+	if r.Method != "GET" {
+		server.BadRequest(w, r, "precomputed only supports GET requests")
+		return
+	}
+	if len(parts) == 0 {
+		server.BadRequest(w, r, "precomputed must be followed by info, a scale key, or mesh")
+		return
+	}
+	switch {
+	case parts[0] == "info":
+		info, err := d.getPrecomputedInfo(meshes)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(info)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBytes)
+
+	case parts[0] == "mesh" && meshes != nil:
+		if len(parts) < 2 {
+			server.BadRequest(w, r, "precomputed mesh must be followed by <label>:0 or a fragment name")
+			return
+		}
+		d.servePrecomputedMesh(ctx, w, r, parts[1], meshes)
+
+	default:
+		if len(parts) < 2 {
+			server.BadRequest(w, r, "precomputed scale key must be followed by a chunk name")
+			return
+		}
+		scale, offset, size, err := parsePrecomputedChunk(parts[0], parts[1], d.Properties.ScaleLevels)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		e, err := i.NewExtHandler(dvid.NewSubvolume(offset, size), nil)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		data, err := GetScaledVolume(ctx, i, e, nil, scale)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(encodePrecomputedChunk(data, d.Values(), d.ByteOrder))
+	}
+}
+
+// servePrecomputedMesh returns either the fragment list of a label, given "<label>:0",
+// or a mesh fragment, given "<label>.surface".
+func (d *Data) servePrecomputedMesh(ctx *datastore.VersionedContext, w http.ResponseWriter, r *http.Request, name string, meshes PrecomputedMeshes) {
+	if strings.HasSuffix(name, ":0") {
+		labelStr := strings.TrimSuffix(name, ":0")
+		if _, err := strconv.ParseUint(labelStr, 10, 64); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("Bad label in mesh manifest %q", name))
+			return
+		}
+		jsonBytes, err := json.Marshal(struct {
+			Fragments []string `json:"fragments"`
+		}{[]string{labelStr + ".surface"}})
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBytes)
+		return
+	}
+
+	label, err := strconv.ParseUint(strings.TrimSuffix(name, ".surface"), 10, 64)
+	if err != nil || !strings.HasSuffix(name, ".surface") {
+		server.BadRequest(w, r, fmt.Sprintf("Bad mesh fragment %q", name))
+		return
+	}
+	mesh, found, err := meshes.PrecomputedMesh(ctx, label)
+	if err != nil {
+		server.BadRequest(w, r, err.Error())
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("Mesh for label %d not found", label), http.StatusNotFound)
+		return
+	}
+	ScaleMeshVertices(mesh, d.Properties.Resolution.VoxelSize)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(mesh)
+}
+
+// ScaleMeshVertices converts the vertices of a legacy precomputed mesh from voxel
+// coordinates to physical coordinates in place.
+func ScaleMeshVertices(mesh []byte, voxelSize dvid.NdFloat32) {
+	if len(mesh) < 4 || len(voxelSize) != 3 {
+		return
+	}
+	n := int(binary.LittleEndian.Uint32(mesh[0:4]))
+	for i := 0; i < 3*n && 4+4*i+4 <= len(mesh); i++ {
+		b := mesh[4+4*i : 8+4*i]
+		value := math.Float32frombits(binary.LittleEndian.Uint32(b)) * voxelSize[i%3]
+		binary.LittleEndian.PutUint32(b, math.Float32bits(value))
+	}
+}
@@ -0,0 +1,90 @@
+package voxels
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestParsePrecomputedChunk(t *testing.T) {
+	scale, offset, size, err := parsePrecomputedChunk("s1", "-64-0_32-96_0-10", 2)
+	if err != nil {
+		t.Fatalf("Unable to parse chunk: %s\n", err.Error())
+	}
+	if scale != 1 || offset != (dvid.Point3d{-64, 32, 0}) || size != (dvid.Point3d{64, 64, 10}) {
+		t.Errorf("Bad parsed chunk: scale %d, offset %s, size %s\n", scale, offset, size)
+	}
+	bad := [][2]string{
+		{"s3", "0-1_0-1_0-1"},
+		{"x0", "0-1_0-1_0-1"},
+		{"s0", "0-1_0-1"},
+		{"s0", "0-1_1-1_0-1"},
+	}
+	for _, tc := range bad {
+		if _, _, _, err := parsePrecomputedChunk(tc[0], tc[1], 2); err == nil {
+			t.Errorf("Expected error parsing chunk %s/%s\n", tc[0], tc[1])
+		}
+	}
+}
+
+func TestEncodePrecomputedChunk(t *testing.T) {
+	values := dvid.DataValues{
+		{T: dvid.T_uint16, Label: "a"},
+		{T: dvid.T_uint16, Label: "b"},
+	}
+	data := []byte{0, 1, 0, 2, 0, 3, 0, 4}
+	got := encodePrecomputedChunk(data, values, binary.BigEndian)
+	expected := []byte{1, 0, 3, 0, 2, 0, 4, 0}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("Expected precomputed chunk %v, got %v\n", expected, got)
+	}
+}
+
+func TestPrecomputedGrayscale8(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	v, err := grayscale.NewExtHandler(dvid.NewSubvolume(offset, size), makeVolume(offset, size))
+	if err != nil {
+		t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+	}
+	if err = PutVoxels(ctx, grayscale, v, OpOptions{}); err != nil {
+		t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+	}
+
+	r, _ := http.NewRequest("GET", "/precomputed/info", nil)
+	w := httptest.NewRecorder()
+	grayscale.ServePrecomputed(ctx, grayscale, w, r, []string{"info"}, nil)
+	var info precomputedInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Bad precomputed info %q: %s\n", w.Body.String(), err.Error())
+	}
+	if info.VolumeType != "image" || info.DataType != "uint8" || len(info.Scales) != 1 {
+		t.Fatalf("Bad precomputed info: %s\n", w.Body.String())
+	}
+	scale := info.Scales[0]
+	if scale.VoxelOffset != [3]int32{10, 20, 30} || scale.Size != [3]int32{40, 40, 40} {
+		t.Errorf("Bad precomputed extents: %s\n", w.Body.String())
+	}
+
+	r, _ = http.NewRequest("GET", "/precomputed/s0/20-30_20-30_30-31", nil)
+	w = httptest.NewRecorder()
+	grayscale.ServePrecomputed(ctx, grayscale, w, r, []string{"s0", "20-30_20-30_30-31"}, nil)
+	expected := expectedChunk(makeVolume(offset, size), size, dvid.Point3d{10, 0, 0}, dvid.Point3d{10, 10, 1})
+	if !bytes.Equal(w.Body.Bytes(), expected) {
+		t.Errorf("Bad precomputed chunk: %v\n", w.Body.Bytes())
+	}
+}
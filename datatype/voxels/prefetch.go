@@ -0,0 +1,248 @@
+/*
+	This file prefetches blocks for orthogonal views.  Slice viewers often switch between
+	XY, XZ and YZ views around the same point, and the first slice after switching is slow
+	since none of its blocks have been read recently.  Recent slice requests of each client,
+	identified by session token or else API token or address, are kept per data instance.
+	Once a client has used more than one orientation, the blocks for its other orientations
+	through the center of its latest slice are read in the background so they're in the
+	block cache when the client switches.  Prefetching is only done if a block cache is
+	configured.
+*/
+
+package voxels
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	// PrefetchHistory is the number of recent slice requests kept for each client.
+	PrefetchHistory = 16
+
+	// PrefetchIdle is how long a client's slice requests are kept after its last one.
+	PrefetchIdle = 10 * time.Minute
+
+	// MaxPrefetches is the maximum number of clients with prefetches running at once.
+	MaxPrefetches = 2
+)
+
+// sliceView is an orthogonal slice request described by its center so views of other
+// orientations can be placed at the same point.
+type sliceView struct {
+	shape     dvid.DataShape
+	size      dvid.Point2d
+	center    dvid.Point3d
+	isotropic bool
+}
+
+// newSliceView returns the view of a 3d orthogonal slice or false if the geometry isn't one.
+func newSliceView(slice dvid.Geometry, isotropic bool) (sliceView, bool) {
+	shape := slice.DataShape()
+	if shape.TotalDimensions() != 3 || shape.ShapeDimensions() != 2 {
+		return sliceView{}, false
+	}
+	offset, ok := slice.StartPoint().(dvid.Point3d)
+	if !ok {
+		return sliceView{}, false
+	}
+	size, ok := slice.Size().(dvid.Point2d)
+	if !ok {
+		return sliceView{}, false
+	}
+	xDim, _ := shape.ShapeDimension(0)
+	yDim, _ := shape.ShapeDimension(1)
+	center := offset
+	center[xDim] += size[0] / 2
+	center[yDim] += size[1] / 2
+	return sliceView{shape, size, center, isotropic}, true
+}
+
+// geometry returns the slice of the view.
+func (v sliceView) geometry() (dvid.Geometry, error) {
+	xDim, _ := v.shape.ShapeDimension(0)
+	yDim, _ := v.shape.ShapeDimension(1)
+	offset := v.center
+	offset[xDim] -= v.size[0] / 2
+	offset[yDim] -= v.size[1] / 2
+	return dvid.NewOrthogSlice(v.shape, offset, v.size)
+}
+
+// predictViews returns views of the orientations in a client's history other than the
+// current one, centered on the current view and sized like each orientation's most
+// recent request.
+func predictViews(history []sliceView, current sliceView) []sliceView {
+	var predicted []sliceView
+	for i := len(history) - 1; i >= 0; i-- {
+		view := history[i]
+		if view.shape.Equals(current.shape) {
+			continue
+		}
+		var found bool
+		for _, p := range predicted {
+			if p.shape.Equals(view.shape) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			view.center = current.center
+			predicted = append(predicted, view)
+		}
+	}
+	return predicted
+}
+
+// viewClient identifies the client of slice requests on a version of a data instance.
+type viewClient struct {
+	instanceID dvid.InstanceID
+	versionID  dvid.VersionID
+	client     string
+}
+
+type viewHistory struct {
+	views    []sliceView
+	fetched  map[string]dvid.ChunkPoint3d // block holding the center of the last prefetch by shape
+	busy     bool
+	lastUsed time.Time
+}
+
+type viewPrefetcher struct {
+	sync.Mutex
+	clients map[viewClient]*viewHistory
+	running int
+	swept   time.Time
+}
+
+var prefetches = viewPrefetcher{clients: make(map[viewClient]*viewHistory)}
+
+// record adds a slice request to a client's history and returns the views to prefetch,
+// if any.  Views whose center is in the same block as their last prefetch are skipped.
+// If views are returned, done() must be called once they've been prefetched.
+func (p *viewPrefetcher) record(client viewClient, view sliceView, blockSize dvid.Point3d) []sliceView {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.swept) > PrefetchIdle {
+		for c, h := range p.clients {
+			if !h.busy && now.Sub(h.lastUsed) > PrefetchIdle {
+				delete(p.clients, c)
+			}
+		}
+		p.swept = now
+	}
+
+	h, found := p.clients[client]
+	if !found {
+		h = &viewHistory{fetched: make(map[string]dvid.ChunkPoint3d)}
+		p.clients[client] = h
+	}
+	h.lastUsed = now
+	h.views = append(h.views, view)
+	if len(h.views) > PrefetchHistory {
+		h.views = h.views[len(h.views)-PrefetchHistory:]
+	}
+	if h.busy || p.running >= MaxPrefetches {
+		return nil
+	}
+
+	var views []sliceView
+	block := view.center.Chunk(blockSize).(dvid.ChunkPoint3d)
+	for _, v := range predictViews(h.views, view) {
+		if last, found := h.fetched[v.shape.String()]; found && last == block {
+			continue
+		}
+		h.fetched[v.shape.String()] = block
+		views = append(views, v)
+	}
+	if len(views) != 0 {
+		h.busy = true
+		p.running++
+	}
+	return views
+}
+
+// done marks the end of a client's prefetch.
+func (p *viewPrefetcher) done(client viewClient) {
+	p.Lock()
+	defer p.Unlock()
+	if h, found := p.clients[client]; found {
+		h.busy = false
+	}
+	p.running--
+}
+
+// prefetchViews records a GET of a slice at full resolution and starts prefetching
+// blocks for the client's other orientations.
+func (d *Data) prefetchViews(requestCtx context.Context, r *http.Request, versionID dvid.VersionID, slice dvid.Geometry, isotropic bool) {
+	if _, cached := storage.BlockCacheStatistics(); !cached {
+		return
+	}
+	view, ok := newSliceView(slice, isotropic)
+	if !ok {
+		return
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get(server.SessionQuery)
+	if name == "" {
+		name = datastore.ClientFromContext(requestCtx)
+	}
+	client := viewClient{d.InstanceID(), versionID, name}
+	views := prefetches.record(client, view, blockSize)
+	if len(views) == 0 {
+		return
+	}
+	go func() {
+		defer prefetches.done(client)
+		ctx := datastore.NewVersionedContext(d, versionID)
+		for _, v := range views {
+			if err := d.prefetchView(ctx, v); err != nil {
+				dvid.Debugf("Unable to prefetch %s view for %q: %s\n", v.shape, d.DataName(), err.Error())
+			}
+		}
+	}()
+}
+
+// prefetchView reads the blocks of a view using the same block ranges as a GET of the
+// view, so the block cache holds them for the GET.
+func (d *Data) prefetchView(ctx *datastore.VersionedContext, view sliceView) error {
+	slice, err := view.geometry()
+	if err != nil {
+		return err
+	}
+	if slice, err = d.HandleIsotropy2D(slice, view.isotropic); err != nil {
+		return err
+	}
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	it, err := (&Voxels{Geometry: slice}).IndexIterator(d.BlockSize())
+	if err != nil {
+		return err
+	}
+	for ; it.Valid(); it.NextSpan() {
+		indexBeg, indexEnd, err := it.IndexSpan()
+		if err != nil {
+			return err
+		}
+		blockBeg := NewScaledBlockIndex(0, indexBeg)
+		blockEnd := NewScaledBlockIndex(0, indexEnd)
+		if err := db.ProcessRange(ctx, blockBeg, blockEnd, &storage.ChunkOp{}, func(*storage.Chunk) {}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package voxels
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func makeSliceView(t *testing.T, shape dvid.DataShape, offset dvid.Point3d, size dvid.Point2d) sliceView {
+	slice, err := dvid.NewOrthogSlice(shape, offset, size)
+	if err != nil {
+		t.Fatalf("Unable to make slice: %s\n", err.Error())
+	}
+	view, ok := newSliceView(slice, false)
+	if !ok {
+		t.Fatalf("Unable to get view of slice %s\n", slice)
+	}
+	return view
+}
+
+func TestSliceView(t *testing.T) {
+	view := makeSliceView(t, dvid.XZ, dvid.Point3d{100, 200, 300}, dvid.Point2d{64, 32})
+	if view.center != (dvid.Point3d{132, 200, 316}) {
+		t.Errorf("Bad view center: %s\n", view.center)
+	}
+	slice, err := view.geometry()
+	if err != nil {
+		t.Fatalf("Unable to get view geometry: %s\n", err.Error())
+	}
+	if !slice.StartPoint().(dvid.Point3d).Equals(dvid.Point3d{100, 200, 300}) {
+		t.Errorf("Bad view geometry: %s\n", slice)
+	}
+}
+
+func TestPredictViews(t *testing.T) {
+	xy := makeSliceView(t, dvid.XY, dvid.Point3d{0, 0, 10}, dvid.Point2d{512, 512})
+	yz := makeSliceView(t, dvid.YZ, dvid.Point3d{5, 0, 0}, dvid.Point2d{256, 128})
+	if views := predictViews([]sliceView{xy, xy}, xy); len(views) != 0 {
+		t.Errorf("Expected no predicted views for single orientation, got %v\n", views)
+	}
+	views := predictViews([]sliceView{yz, xy, xy}, xy)
+	if len(views) != 1 || !views[0].shape.Equals(dvid.YZ) {
+		t.Fatalf("Expected YZ view predicted, got %v\n", views)
+	}
+	if views[0].center != xy.center || views[0].size != yz.size {
+		t.Errorf("Expected YZ view sized %s at %s, got %v\n", yz.size, xy.center, views[0])
+	}
+}
+
+func TestPrefetchRecord(t *testing.T) {
+	p := viewPrefetcher{clients: make(map[viewClient]*viewHistory)}
+	client := viewClient{1, 1, "tester"}
+	blockSize := dvid.Point3d{32, 32, 32}
+	xy := makeSliceView(t, dvid.XY, dvid.Point3d{0, 0, 10}, dvid.Point2d{64, 64})
+	xz := makeSliceView(t, dvid.XZ, dvid.Point3d{0, 32, 0}, dvid.Point2d{64, 64})
+
+	if views := p.record(client, xy, blockSize); len(views) != 0 {
+		t.Errorf("Expected no prefetch after first slice, got %v\n", views)
+	}
+	if views := p.record(client, xz, blockSize); len(views) != 1 || !views[0].shape.Equals(dvid.XY) {
+		t.Fatalf("Expected XY prefetch after switching to XZ, got %v\n", views)
+	}
+	if views := p.record(client, xz, blockSize); len(views) != 0 {
+		t.Errorf("Expected no prefetch while one is running, got %v\n", views)
+	}
+	p.done(client)
+	if views := p.record(client, xz, blockSize); len(views) != 0 {
+		t.Errorf("Expected no repeated prefetch in same block, got %v\n", views)
+	}
+	xz.center[1] += 32
+	if views := p.record(client, xz, blockSize); len(views) != 1 {
+		t.Errorf("Expected prefetch after moving to new block, got %v\n", views)
+	}
+	if p.running != 1 {
+		t.Errorf("Expected 1 running prefetch, got %d\n", p.running)
+	}
+}
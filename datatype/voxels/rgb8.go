@@ -0,0 +1,41 @@
+/*
+	Data type rgb8 tailors the voxels data type for 8-bit RGB images.  It simply
+	wraps the voxels package, setting Channels (3) and BytesPerValue(1).
+*/
+
+package voxels
+
+import (
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var rgb8EncodeFormat dvid.DataValues
+
+func init() {
+	rgb8EncodeFormat = dvid.DataValues{
+		{
+			T:     dvid.T_uint8,
+			Label: "red",
+		},
+		{
+			T:     dvid.T_uint8,
+			Label: "green",
+		},
+		{
+			T:     dvid.T_uint8,
+			Label: "blue",
+		},
+	}
+	interpolable := true
+	rgb := NewType(rgb8EncodeFormat, interpolable)
+	rgb.Type.Name = "rgb8"
+	rgb.Type.URL = "github.com/janelia-flyem/dvid/datatype/voxels/rgb8.go"
+	rgb.Type.Version = "0.1"
+
+	datastore.Register(rgb)
+}
+
+func RGB8EncodeFormat() dvid.DataValues {
+	return rgb8EncodeFormat
+}
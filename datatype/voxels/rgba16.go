@@ -0,0 +1,45 @@
+/*
+	Data type rgba16 tailors the voxels data type for 16-bit RGBA images.  It simply
+	wraps the voxels package, setting Channels (4) and BytesPerValue(2).
+*/
+
+package voxels
+
+import (
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var rgba16EncodeFormat dvid.DataValues
+
+func init() {
+	rgba16EncodeFormat = dvid.DataValues{
+		{
+			T:     dvid.T_uint16,
+			Label: "red",
+		},
+		{
+			T:     dvid.T_uint16,
+			Label: "green",
+		},
+		{
+			T:     dvid.T_uint16,
+			Label: "blue",
+		},
+		{
+			T:     dvid.T_uint16,
+			Label: "alpha",
+		},
+	}
+	interpolable := true
+	rgba := NewType(rgba16EncodeFormat, interpolable)
+	rgba.Type.Name = "rgba16"
+	rgba.Type.URL = "github.com/janelia-flyem/dvid/datatype/voxels/rgba16.go"
+	rgba.Type.Version = "0.1"
+
+	datastore.Register(rgba)
+}
+
+func RGBA16EncodeFormat() dvid.DataValues {
+	return rgba16EncodeFormat
+}
@@ -0,0 +1,215 @@
+/*
+	This file supports reading strided and multi-box geometries, e.g., downsampled
+	previews that take every Nth voxel or sparse samples of many small boxes.  Only the
+	blocks holding sampled voxels are read, and a block shared by several boxes is read
+	once and copied into each of them.
+*/
+
+package voxels
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// GetSampledVolume returns the sampled voxels of each box, concatenated in box order
+// with each box's voxels in x-fastest order.  Box coordinates are in voxels of the given
+// scale level.  Voxels in blocks that haven't been stored are zero.
+func GetSampledVolume(ctx *datastore.VersionedContext, i IntData, boxes dvid.MultiBox, scale uint8) ([]byte, error) {
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return nil, err
+	}
+	blockSize, ok := i.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("Sampled reads require 3d blocks, not %s", i.BlockSize())
+	}
+	bytesPerVoxel := int64(i.Values().BytesPerElement())
+
+	// Allocate the returned buffer and find the boxes sampled by each block.
+	outputs := make([][]byte, len(boxes))
+	data := make([]byte, boxes.NumVoxels()*bytesPerVoxel)
+	blockBoxes := make(map[dvid.ChunkPoint3d][]int)
+	var pos int64
+	for b, box := range boxes {
+		n := box.NumVoxels() * bytesPerVoxel
+		outputs[b] = data[pos : pos+n]
+		pos += n
+
+		var coords [3][]int32
+		for dim := uint8(0); dim < 3; dim++ {
+			coords[dim] = sampledBlockCoords(box, blockSize, dim)
+		}
+		for _, z := range coords[2] {
+			for _, y := range coords[1] {
+				for _, x := range coords[0] {
+					c := dvid.ChunkPoint3d{x, y, z}
+					blockBoxes[c] = append(blockBoxes[c], b)
+				}
+			}
+		}
+	}
+
+	// Only do one request at a time, although each request can start many goroutines.
+	server.SpawnGoroutineMutex.Lock()
+	defer server.SpawnGoroutineMutex.Unlock()
+
+	var mu sync.Mutex
+	var copyErr error
+	wg := new(sync.WaitGroup)
+	for _, run := range blockRuns(blockBoxes) {
+		indexBeg := dvid.IndexZYX(run[0])
+		indexEnd := dvid.IndexZYX(run[1])
+		kvs, err := db.GetRange(ctx, NewScaledBlockIndex(scale, &indexBeg), NewScaledBlockIndex(scale, &indexEnd))
+		if err != nil {
+			wg.Wait()
+			return nil, fmt.Errorf("Unable to GET data %s: %s", ctx, err.Error())
+		}
+		if len(kvs) == 0 {
+			continue
+		}
+		<-server.HandlerToken
+		wg.Add(1)
+		go func(kvs []*storage.KeyValue) {
+			defer func() {
+				server.HandlerToken <- 1
+				wg.Done()
+			}()
+			for _, kv := range kvs {
+				err := copySampledBlock(kv, blockSize, bytesPerVoxel, boxes, blockBoxes, outputs)
+				if err != nil {
+					mu.Lock()
+					copyErr = err
+					mu.Unlock()
+					return
+				}
+			}
+		}(kvs)
+	}
+	wg.Wait()
+	if copyErr != nil {
+		return nil, copyErr
+	}
+	return data, nil
+}
+
+// sampledBlockCoords returns the block coordinates along an axis of the blocks holding
+// sampled voxels of a box.
+func sampledBlockCoords(box *dvid.StridedSubvolume, blockSize dvid.Point3d, dim uint8) []int32 {
+	blockLength := blockSize[dim]
+	first := box.StartPoint().(dvid.Point3d).Chunk(blockSize).(dvid.ChunkPoint3d)[dim]
+	last := box.EndPoint().(dvid.Point3d).Chunk(blockSize).(dvid.ChunkPoint3d)[dim]
+	var coords []int32
+	for c := first; c <= last; c++ {
+		if begin, end := box.SampleRange(dim, c*blockLength, (c+1)*blockLength-1); begin < end {
+			coords = append(coords, c)
+		}
+	}
+	return coords
+}
+
+type chunksByZYX []dvid.ChunkPoint3d
+
+func (s chunksByZYX) Len() int      { return len(s) }
+func (s chunksByZYX) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Block coordinates are ordered by Z, then Y, then X.
+func (s chunksByZYX) Less(i, j int) bool {
+	if s[i][2] != s[j][2] {
+		return s[i][2] < s[j][2]
+	}
+	if s[i][1] != s[j][1] {
+		return s[i][1] < s[j][1]
+	}
+	return s[i][0] < s[j][0]
+}
+
+// blockRuns returns the first and last block coordinates of the runs of blocks with
+// consecutive x coordinates, ordered like block keys.
+func blockRuns(blocks map[dvid.ChunkPoint3d][]int) [][2]dvid.ChunkPoint3d {
+	coords := make([]dvid.ChunkPoint3d, 0, len(blocks))
+	for c := range blocks {
+		coords = append(coords, c)
+	}
+	sort.Sort(chunksByZYX(coords))
+	var runs [][2]dvid.ChunkPoint3d
+	for _, c := range coords {
+		n := len(runs)
+		if n != 0 {
+			last := runs[n-1][1]
+			if last[2] == c[2] && last[1] == c[1] && last[0]+1 == c[0] {
+				runs[n-1][1] = c
+				continue
+			}
+		}
+		runs = append(runs, [2]dvid.ChunkPoint3d{c, c})
+	}
+	return runs
+}
+
+// copySampledBlock copies the sampled voxels of a stored block into each box it holds.
+func copySampledBlock(kv *storage.KeyValue, blockSize dvid.Point3d, bytesPerVoxel int64,
+	boxes dvid.MultiBox, blockBoxes map[dvid.ChunkPoint3d][]int, outputs [][]byte) error {
+
+	_, indexZYX, err := DecodeScaledBlockKey(kv.K)
+	if err != nil {
+		return err
+	}
+	block, _, err := dvid.DeserializeData(kv.V, true)
+	if err != nil {
+		return fmt.Errorf("Unable to deserialize block %s: %s", indexZYX, err.Error())
+	}
+	if int64(len(block)) != blockSize.Prod()*bytesPerVoxel {
+		return fmt.Errorf("Block %s has %d bytes, expected %d", indexZYX, len(block), blockSize.Prod()*bytesPerVoxel)
+	}
+	blockMin := indexZYX.MinPoint(blockSize).(dvid.Point3d)
+	for _, b := range blockBoxes[dvid.ChunkPoint3d(*indexZYX)] {
+		copySamples(block, blockMin, blockSize, bytesPerVoxel, boxes[b], outputs[b])
+	}
+	return nil
+}
+
+// copySamples copies the voxels of a box sampled within a block to the box's output,
+// copying whole rows when voxels along x aren't skipped.
+func copySamples(block []byte, blockMin, blockSize dvid.Point3d, bytesPerVoxel int64,
+	box *dvid.StridedSubvolume, out []byte) {
+
+	var begin, end [3]int32
+	for dim := uint8(0); dim < 3; dim++ {
+		begin[dim], end[dim] = box.SampleRange(dim, blockMin[dim], blockMin[dim]+blockSize[dim]-1)
+		if begin[dim] >= end[dim] {
+			return
+		}
+	}
+	offset := box.StartPoint().(dvid.Point3d)
+	size := box.Size().(dvid.Point3d)
+	stride := box.Stride()
+
+	x0 := int64(offset[0] + begin[0]*stride[0] - blockMin[0])
+	numX := int64(end[0] - begin[0])
+	for kz := begin[2]; kz < end[2]; kz++ {
+		z := int64(offset[2] + kz*stride[2] - blockMin[2])
+		for ky := begin[1]; ky < end[1]; ky++ {
+			y := int64(offset[1] + ky*stride[1] - blockMin[1])
+			src := ((z*int64(blockSize[1])+y)*int64(blockSize[0]) + x0) * bytesPerVoxel
+			dst := ((int64(kz)*int64(size[1])+int64(ky))*int64(size[0]) + int64(begin[0])) * bytesPerVoxel
+			if stride[0] == 1 {
+				n := numX * bytesPerVoxel
+				copy(out[dst:dst+n], block[src:src+n])
+				continue
+			}
+			srcStep := int64(stride[0]) * bytesPerVoxel
+			for kx := int64(0); kx < numX; kx++ {
+				copy(out[dst:dst+bytesPerVoxel], block[src:src+bytesPerVoxel])
+				src += srcStep
+				dst += bytesPerVoxel
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package voxels
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestSampledBlockCoords(t *testing.T) {
+	blockSize := dvid.Point3d{32, 32, 32}
+
+	// With a stride larger than the block size, blocks between samples are skipped.
+	box, err := dvid.NewStridedSubvolume(dvid.Point3d{0, -40, 0}, dvid.Point3d{200, 8, 32}, dvid.Point3d{100, 1, 1})
+	if err != nil {
+		t.Fatalf("Error creating strided subvolume: %s\n", err.Error())
+	}
+	if coords := sampledBlockCoords(box, blockSize, 0); len(coords) != 2 || coords[0] != 0 || coords[1] != 3 {
+		t.Errorf("Bad sampled x block coords: %v\n", coords)
+	}
+	if coords := sampledBlockCoords(box, blockSize, 1); len(coords) != 1 || coords[0] != -2 {
+		t.Errorf("Bad sampled y block coords: %v\n", coords)
+	}
+
+	blocks := map[dvid.ChunkPoint3d][]int{
+		{3, 0, 0}: nil, {1, 0, 0}: nil, {2, 0, 0}: nil, {5, 0, 0}: nil, {0, 1, 0}: nil,
+	}
+	runs := blockRuns(blocks)
+	expected := [][2]dvid.ChunkPoint3d{
+		{{1, 0, 0}, {3, 0, 0}},
+		{{5, 0, 0}, {5, 0, 0}},
+		{{0, 1, 0}, {0, 1, 0}},
+	}
+	if len(runs) != len(expected) {
+		t.Fatalf("Expected %d block runs, got %v\n", len(expected), runs)
+	}
+	for i, run := range runs {
+		if run != expected[i] {
+			t.Errorf("Expected block run %v, got %v\n", expected[i], run)
+		}
+	}
+}
+
+func TestCopySamples(t *testing.T) {
+	blockSize := dvid.Point3d{8, 8, 8}
+	block := make([]byte, blockSize.Prod())
+	for i := range block {
+		block[i] = byte(i)
+	}
+	value := func(x, y, z int32) byte {
+		return byte(z*64 + y*8 + x)
+	}
+
+	// A strided box spanning two blocks along x gets its samples from the first block.
+	box, err := dvid.NewStridedSubvolume(dvid.Point3d{1, 2, 3}, dvid.Point3d{12, 5, 2}, dvid.Point3d{3, 2, 1})
+	if err != nil {
+		t.Fatalf("Error creating strided subvolume: %s\n", err.Error())
+	}
+	out := make([]byte, box.NumVoxels())
+	copySamples(block, dvid.Point3d{0, 0, 0}, blockSize, 1, box, out)
+	size := box.Size().(dvid.Point3d)
+	if size != (dvid.Point3d{4, 3, 2}) {
+		t.Fatalf("Bad strided box size: %s\n", size)
+	}
+	for kz := int32(0); kz < size[2]; kz++ {
+		for ky := int32(0); ky < size[1]; ky++ {
+			for kx := int32(0); kx < size[0]; kx++ {
+				got := out[(kz*size[1]+ky)*size[0]+kx]
+				x, y, z := 1+kx*3, 2+ky*2, 3+kz
+				var want byte
+				if x < 8 {
+					want = value(x, y, z)
+				}
+				if got != want {
+					t.Errorf("Sample (%d,%d,%d) is %d, expected %d\n", kx, ky, kz, got, want)
+				}
+			}
+		}
+	}
+
+	// Unstrided boxes copy whole rows.
+	box, err = dvid.NewStridedSubvolume(dvid.Point3d{6, 7, 7}, dvid.Point3d{4, 1, 1}, dvid.Point3d{1, 1, 1})
+	if err != nil {
+		t.Fatalf("Error creating subvolume: %s\n", err.Error())
+	}
+	out = make([]byte, box.NumVoxels())
+	copySamples(block, dvid.Point3d{0, 0, 0}, blockSize, 1, box, out)
+	if out[0] != value(6, 7, 7) || out[1] != value(7, 7, 7) || out[2] != 0 || out[3] != 0 {
+		t.Errorf("Bad unstrided samples: %v\n", out)
+	}
+}
@@ -0,0 +1,333 @@
+/*
+	This file computes exact statistics and histograms of voxel values within a subvolume
+	and/or ROI by reading the stored voxel blocks, so clients can set contrast without
+	downloading the data.  Unlike the precomputed block histograms, only voxels within
+	the subvolume are tallied and any grayscale data type is supported.
+*/
+
+package voxels
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// DefaultStatsBins is the default number of histogram bins for voxel statistics.
+const DefaultStatsBins = 256
+
+// VoxelStats holds statistics and a histogram of voxel values.  The histogram has bins
+// of equal width spanning all values of the data type.
+type VoxelStats struct {
+	Blocks int
+	Voxels uint64
+	Min    float64
+	Max    float64
+
+	sum      float64
+	sumSq    float64
+	binWidth float64
+	bins     []uint64
+}
+
+// NewVoxelStats returns empty statistics for data with the given values, which must be
+// a single unsigned 8 or 16-bit channel.  The number of bins can't exceed the number of
+// possible values.
+func NewVoxelStats(values dvid.DataValues, numBins int) (*VoxelStats, error) {
+	if len(values) != 1 {
+		return nil, fmt.Errorf("Voxel statistics require a single channel, not %d channels", len(values))
+	}
+	var numValues int
+	switch values[0].T {
+	case dvid.T_uint8:
+		numValues = 1 << 8
+	case dvid.T_uint16:
+		numValues = 1 << 16
+	default:
+		return nil, fmt.Errorf("Voxel statistics only supported for uint8 and uint16 data")
+	}
+	if numBins < 1 || numBins > numValues {
+		return nil, fmt.Errorf("Number of histogram bins must be between 1 and %d, got %d", numValues, numBins)
+	}
+	return &VoxelStats{
+		Min:      math.Inf(1),
+		Max:      math.Inf(-1),
+		binWidth: float64(numValues) / float64(numBins),
+		bins:     make([]uint64, numBins),
+	}, nil
+}
+
+// newEmpty returns empty statistics with the same histogram bins.
+func (s *VoxelStats) newEmpty() *VoxelStats {
+	return &VoxelStats{
+		Min:      math.Inf(1),
+		Max:      math.Inf(-1),
+		binWidth: s.binWidth,
+		bins:     make([]uint64, len(s.bins)),
+	}
+}
+
+func (s *VoxelStats) add(value float64) {
+	s.Voxels++
+	if value < s.Min {
+		s.Min = value
+	}
+	if value > s.Max {
+		s.Max = value
+	}
+	s.sum += value
+	s.sumSq += value * value
+	bin := int(value / s.binWidth)
+	if bin >= len(s.bins) {
+		bin = len(s.bins) - 1
+	}
+	s.bins[bin]++
+}
+
+// Add accumulates the statistics of another set of voxels with the same bins.
+func (s *VoxelStats) Add(s2 *VoxelStats) {
+	s.Blocks += s2.Blocks
+	s.Voxels += s2.Voxels
+	s.Min = math.Min(s.Min, s2.Min)
+	s.Max = math.Max(s.Max, s2.Max)
+	s.sum += s2.sum
+	s.sumSq += s2.sumSq
+	for i, count := range s2.bins {
+		s.bins[i] += count
+	}
+}
+
+// Mean returns the mean voxel value or 0 if there are no voxels.
+func (s *VoxelStats) Mean() float64 {
+	if s.Voxels == 0 {
+		return 0
+	}
+	return s.sum / float64(s.Voxels)
+}
+
+// StdDev returns the population standard deviation of voxel values.
+func (s *VoxelStats) StdDev() float64 {
+	if s.Voxels == 0 {
+		return 0
+	}
+	mean := s.Mean()
+	variance := s.sumSq/float64(s.Voxels) - mean*mean
+	if variance < 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s *VoxelStats) MarshalJSON() ([]byte, error) {
+	min, max := s.Min, s.Max
+	if s.Voxels == 0 {
+		min, max = 0, 0
+	}
+	return json.Marshal(struct {
+		Blocks   int
+		Voxels   uint64
+		Min      float64
+		Max      float64
+		Mean     float64
+		StdDev   float64
+		BinWidth float64
+		Bins     []uint64
+	}{s.Blocks, s.Voxels, min, max, s.Mean(), s.StdDev(), s.binWidth, s.bins})
+}
+
+// addBlock tallies the voxels of a deserialized block that lie within the subvolume, or
+// all voxels if the subvolume is nil.
+func (s *VoxelStats) addBlock(block []byte, blockCoord dvid.ChunkPoint3d, blockSize dvid.Point3d,
+	bytesPerVoxel int32, byteOrder binary.ByteOrder, subvol *dvid.Subvolume) error {
+
+	if int64(len(block)) != blockSize.Prod()*int64(bytesPerVoxel) {
+		return fmt.Errorf("Block %s has %d bytes, expected %d", blockCoord, len(block),
+			blockSize.Prod()*int64(bytesPerVoxel))
+	}
+	var beg, end dvid.Point3d
+	for i := 0; i < 3; i++ {
+		beg[i] = blockCoord[i] * blockSize[i]
+		end[i] = beg[i] + blockSize[i] - 1
+	}
+	if subvol != nil {
+		subvolBeg, _ := beg.Max(subvol.StartPoint())
+		subvolEnd, _ := end.Min(subvol.EndPoint())
+		beg, end = subvolBeg.(dvid.Point3d), subvolEnd.(dvid.Point3d)
+		if beg[0] > end[0] || beg[1] > end[1] || beg[2] > end[2] {
+			return nil
+		}
+	}
+	s.Blocks++
+	for z := beg[2]; z <= end[2]; z++ {
+		for y := beg[1]; y <= end[1]; y++ {
+			x0 := beg[0] - blockCoord[0]*blockSize[0]
+			yOff := y - blockCoord[1]*blockSize[1]
+			zOff := z - blockCoord[2]*blockSize[2]
+			i := ((zOff*blockSize[1]+yOff)*blockSize[0] + x0) * bytesPerVoxel
+			for x := beg[0]; x <= end[0]; x++ {
+				if bytesPerVoxel == 1 {
+					s.add(float64(block[i]))
+				} else {
+					s.add(float64(byteOrder.Uint16(block[i : i+2])))
+				}
+				i += bytesPerVoxel
+			}
+		}
+	}
+	return nil
+}
+
+// statsSpans returns the (z, y, x0, x1) runs of blocks to tally for the given subvolume
+// and ROI, either of which may be absent but not both.
+func (d *Data) statsSpans(versionID dvid.VersionID, subvol *dvid.Subvolume, roiname dvid.DataString) ([]dvid.Span, error) {
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("Data %q does not have a 3d block size", d.DataName())
+	}
+	var begBlock, endBlock dvid.ChunkPoint3d
+	if subvol != nil {
+		begBlock = subvol.StartPoint().(dvid.Chunkable).Chunk(blockSize).(dvid.ChunkPoint3d)
+		endBlock = subvol.EndPoint().(dvid.Chunkable).Chunk(blockSize).(dvid.ChunkPoint3d)
+	}
+	if len(roiname) == 0 {
+		if subvol == nil {
+			return nil, fmt.Errorf("Voxel statistics require a subvolume or ROI")
+		}
+		var spans []dvid.Span
+		for z := begBlock[2]; z <= endBlock[2]; z++ {
+			for y := begBlock[1]; y <= endBlock[1]; y++ {
+				spans = append(spans, dvid.Span{z, y, begBlock[0], endBlock[0]})
+			}
+		}
+		return spans, nil
+	}
+
+	dataservice, err := datastore.GetData(versionID, roiname)
+	if err != nil {
+		return nil, fmt.Errorf("Can't get ROI with name %q: %s", roiname, err.Error())
+	}
+	roiData, ok := dataservice.(*roi.Data)
+	if !ok {
+		return nil, fmt.Errorf("Data name %q was not of roi data type", roiname)
+	}
+	if !roiData.BlockSize.Equals(blockSize) {
+		return nil, fmt.Errorf("ROI %q block size %s differs from data %q block size %s",
+			roiname, roiData.BlockSize, d.DataName(), blockSize)
+	}
+	roiSpans, err := roi.GetSpans(datastore.NewVersionedContext(roiData, versionID))
+	if err != nil {
+		return nil, err
+	}
+	if subvol == nil {
+		return roiSpans, nil
+	}
+	var spans []dvid.Span
+	for _, span := range roiSpans {
+		if span[0] < begBlock[2] || span[0] > endBlock[2] || span[1] < begBlock[1] || span[1] > endBlock[1] {
+			continue
+		}
+		if span[3] < begBlock[0] || span[2] > endBlock[0] {
+			continue
+		}
+		if span[2] < begBlock[0] {
+			span[2] = begBlock[0]
+		}
+		if span[3] > endBlock[0] {
+			span[3] = endBlock[0]
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
+
+// GetStats returns statistics and a histogram of the stored voxels within a subvolume
+// and/or ROI, either of which may be nil or empty but not both.  ROIs are delimited by
+// blocks, and voxels of blocks that were never stored aren't tallied.
+func (d *Data) GetStats(ctx *datastore.VersionedContext, subvol *dvid.Subvolume, roiname dvid.DataString,
+	numBins int) (*VoxelStats, error) {
+
+	stats, err := NewVoxelStats(d.Properties.Values, numBins)
+	if err != nil {
+		return nil, err
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("Data %q does not have a 3d block size", d.DataName())
+	}
+	byteOrder := d.ByteOrder
+	if byteOrder == nil {
+		byteOrder = binary.LittleEndian
+	}
+	bytesPerVoxel := d.Properties.Values.BytesPerElement()
+	spans, err := d.statsSpans(ctx.VersionID(), subvol, roiname)
+	if err != nil {
+		return nil, err
+	}
+	db, err := storage.BigDataStore()
+	if err != nil {
+		return nil, err
+	}
+
+	// Block runs are read in order while their voxels are tallied concurrently.
+	var mu sync.Mutex
+	var statsErr error
+	wg := new(sync.WaitGroup)
+	for _, span := range spans {
+		indexBeg := dvid.IndexZYX{span[2], span[1], span[0]}
+		indexEnd := dvid.IndexZYX{span[3], span[1], span[0]}
+		kvs, err := db.GetRange(ctx, NewVoxelBlockIndex(&indexBeg), NewVoxelBlockIndex(&indexEnd))
+		if err != nil {
+			wg.Wait()
+			return nil, err
+		}
+		if len(kvs) == 0 {
+			continue
+		}
+		<-server.HandlerToken
+		wg.Add(1)
+		go func(kvs []*storage.KeyValue) {
+			defer func() {
+				server.HandlerToken <- 1
+				wg.Done()
+			}()
+			runStats := stats.newEmpty()
+			for _, kv := range kvs {
+				err := func() error {
+					indexZYX, err := DecodeVoxelBlockKey(kv.K)
+					if err != nil {
+						return err
+					}
+					block, _, err := dvid.DeserializeData(kv.V, true)
+					if err != nil {
+						return fmt.Errorf("Unable to deserialize block %s: %s", indexZYX, err.Error())
+					}
+					blockCoord := dvid.ChunkPoint3d(*indexZYX)
+					return runStats.addBlock(block, blockCoord, blockSize, bytesPerVoxel, byteOrder, subvol)
+				}()
+				if err != nil {
+					mu.Lock()
+					statsErr = err
+					mu.Unlock()
+					return
+				}
+			}
+			mu.Lock()
+			stats.Add(runStats)
+			mu.Unlock()
+		}(kvs)
+	}
+	wg.Wait()
+	if statsErr != nil {
+		return nil, statsErr
+	}
+	return stats, nil
+}
@@ -0,0 +1,73 @@
+package voxels
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestVoxelStats(t *testing.T) {
+	if _, err := NewVoxelStats(dvid.DataValues{{T: dvid.T_float32}}, 16); err == nil {
+		t.Errorf("Expected error for float32 statistics\n")
+	}
+	if _, err := NewVoxelStats(grayscale8EncodeFormat, 257); err == nil {
+		t.Errorf("Expected error for more bins than uint8 values\n")
+	}
+	stats, err := NewVoxelStats(grayscale8EncodeFormat, 4)
+	if err != nil {
+		t.Fatalf("Unable to create voxel stats: %s\n", err.Error())
+	}
+
+	// Block (1,0,0) holds value x+y+z at its voxel offset.
+	blockSize := dvid.Point3d{4, 4, 4}
+	block := make([]byte, blockSize.Prod())
+	for z := int32(0); z < 4; z++ {
+		for y := int32(0); y < 4; y++ {
+			for x := int32(0); x < 4; x++ {
+				block[z*16+y*4+x] = byte(100 * (x + y + z))
+			}
+		}
+	}
+	subvol := dvid.NewSubvolume(dvid.Point3d{6, 0, 0}, dvid.Point3d{8, 2, 1})
+	if err := stats.addBlock(block, dvid.ChunkPoint3d{1, 0, 0}, blockSize, 1, binary.LittleEndian, subvol); err != nil {
+		t.Fatalf("Error adding block: %s\n", err.Error())
+	}
+	if err := stats.addBlock(block, dvid.ChunkPoint3d{0, 0, 0}, blockSize, 1, binary.LittleEndian, subvol); err != nil {
+		t.Fatalf("Error adding block outside subvolume: %s\n", err.Error())
+	}
+
+	// Subvolume covers x offsets 2-3 and y offsets 0-1 at z 0 of the block.
+	if stats.Blocks != 1 || stats.Voxels != 4 {
+		t.Fatalf("Expected 4 voxels in 1 block, got %d voxels in %d blocks\n", stats.Voxels, stats.Blocks)
+	}
+	values := []float64{200, 44, 44, 144}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if stats.Mean() != sum/4 {
+		t.Errorf("Expected mean %f, got %f\n", sum/4, stats.Mean())
+	}
+	if stats.Min != 44 || stats.Max != 200 {
+		t.Errorf("Expected range 44 to 200, got %f to %f\n", stats.Min, stats.Max)
+	}
+	var sumSq float64
+	for _, v := range values {
+		sumSq += (v - sum/4) * (v - sum/4)
+	}
+	if math.Abs(stats.StdDev()-math.Sqrt(sumSq/4)) > 1e-9 {
+		t.Errorf("Expected std dev %f, got %f\n", math.Sqrt(sumSq/4), stats.StdDev())
+	}
+	if stats.bins[0] != 2 || stats.bins[2] != 1 || stats.bins[3] != 1 {
+		t.Errorf("Bad histogram bins: %v\n", stats.bins)
+	}
+
+	total := stats.newEmpty()
+	total.Add(stats)
+	total.Add(stats)
+	if total.Voxels != 8 || total.Mean() != stats.Mean() || total.bins[0] != 4 {
+		t.Errorf("Bad combined stats: %d voxels, mean %f, bins %v\n", total.Voxels, total.Mean(), total.bins)
+	}
+}
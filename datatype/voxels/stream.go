@@ -0,0 +1,136 @@
+/*
+	This file supports streaming large subvolume GETs.  Rather than assembling the whole
+	subvolume before responding, a subvolume is read in slabs of block layers along z,
+	and each slab is sent as soon as its blocks are assembled.  Since voxels are ordered
+	with z slowest, the concatenated slabs are the requested subvolume.
+*/
+
+package voxels
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// subvolumeSlabs divides a subvolume into slabs along z whose boundaries fall on block
+// boundaries and that have at most maxBytes of voxels, although each slab has at least
+// one layer of blocks.
+func subvolumeSlabs(subvol *dvid.Subvolume, blockSize dvid.Point, bytesPerVoxel int32, maxBytes int) []*dvid.Subvolume {
+	offset := subvol.StartPoint().(dvid.Point3d)
+	size := subvol.Size().(dvid.Point3d)
+	blockZ := blockSize.Value(2)
+	layerBytes := int64(size[0]) * int64(size[1]) * int64(bytesPerVoxel) * int64(blockZ)
+	layers := int32(1)
+	if layerBytes > 0 && int64(maxBytes)/layerBytes > 1 {
+		layers = int32(int64(maxBytes) / layerBytes)
+	}
+
+	var slabs []*dvid.Subvolume
+	z, endZ := offset[2], offset[2]+size[2]
+	for z < endZ {
+		// Start of the block layer holding z, allowing for negative coordinates.
+		layerZ := z - z%blockZ
+		if z%blockZ < 0 {
+			layerZ -= blockZ
+		}
+		nextZ := layerZ + layers*blockZ
+		if nextZ > endZ {
+			nextZ = endZ
+		}
+		slabs = append(slabs, dvid.NewSubvolume(dvid.Point3d{offset[0], offset[1], z}, dvid.Point3d{size[0], size[1], nextZ - z}))
+		z = nextZ
+	}
+	return slabs
+}
+
+// getSlab reads the voxels of a slab, masked by the given ROI if not nil.
+func getSlab(ctx *datastore.VersionedContext, i IntData, slab *dvid.Subvolume, roiname dvid.DataString, r *ROI, scale uint8) ([]byte, error) {
+	e, err := i.NewExtHandler(slab, nil)
+	if err != nil {
+		return nil, err
+	}
+	var slabROI *ROI
+	if r != nil {
+		slabROI = &ROI{attenuation: r.attenuation}
+		if slabROI.Iter, err = roi.NewIterator(roiname, ctx.VersionID(), e); err != nil {
+			return nil, err
+		}
+	}
+	return GetScaledVolume(ctx, i, e, slabROI, scale)
+}
+
+// WriteSubvolume writes the voxels of a subvolume, masked by the named ROI if r is not
+// nil, along with headers giving the subvolume.  Subvolumes larger than the stream
+// buffer are sent uncompressed or gzip-compressed as slabs are read, using chunked
+// transfer encoding for HTTP/1.1, so the server never holds the whole subvolume.  Errors
+// after streaming has begun are logged since the response status has been sent.
+func WriteSubvolume(ctx *datastore.VersionedContext, i IntData, subvol *dvid.Subvolume, roiname dvid.DataString, r *ROI, scale uint8, w http.ResponseWriter, req *http.Request) error {
+	size := subvol.Size().(dvid.Point3d)
+	numBytes := int64(size[0]) * int64(size[1]) * int64(size[2]) * int64(i.Values().BytesPerElement())
+	compress, found, err := dvid.NegotiateCompression(req, server.StreamBufferSize)
+	if err != nil {
+		return err
+	}
+	if numBytes <= int64(server.StreamBufferSize) || (found && compress.Format() != dvid.Gzip) {
+		data, err := getSlab(ctx, i, subvol, roiname, r, scale)
+		if err != nil {
+			return err
+		}
+		SetSubvolumeHeaders(w, subvol)
+		w.Header().Set("Content-type", "application/octet-stream")
+		if isGrayscale8(i.Values()) {
+			return dvid.WriteGrayscaleCompressed(data, w, req)
+		}
+		return dvid.WriteCompressed(data, w, req)
+	}
+
+	// Read the first slab before sending headers so early errors get an error response.
+	slabs := subvolumeSlabs(subvol, i.BlockSize(), i.Values().BytesPerElement(), server.StreamBufferSize)
+	data, err := getSlab(ctx, i, slabs[0], roiname, r, scale)
+	if err != nil {
+		return err
+	}
+
+	SetSubvolumeHeaders(w, subvol)
+	w.Header().Set("Content-type", "application/octet-stream")
+	w.Header().Add("Vary", "Accept-Encoding")
+	stream := server.NewStreamWriter(w, req)
+	var out io.Writer = stream
+	var gz *gzip.Writer
+	if found {
+		if gz, err = gzip.NewWriterLevel(stream, int(compress.Level())); err != nil {
+			stream.Close()
+			return err
+		}
+		out = gz
+		w.Header().Set("X-Dvid-Compression", compress.Format().Name())
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	dvid.Debugf("[%s] streaming subvolume %s in %d slabs\n", ctx, subvol, len(slabs))
+
+	_, writeErr := out.Write(data)
+	for _, slab := range slabs[1:] {
+		if writeErr != nil {
+			break
+		}
+		if data, writeErr = getSlab(ctx, i, slab, roiname, r, scale); writeErr == nil {
+			_, writeErr = out.Write(data)
+		}
+	}
+	if writeErr == nil && gz != nil {
+		writeErr = gz.Close()
+	}
+	if err := stream.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		dvid.Errorf("Stopped streaming subvolume %s to %s: %s\n", subvol, req.RemoteAddr, writeErr.Error())
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package voxels
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestSubvolumeSlabs(t *testing.T) {
+	blockSize := dvid.Point3d{32, 32, 32}
+	subvol := dvid.NewSubvolume(dvid.Point3d{0, 0, -40}, dvid.Point3d{100, 100, 150})
+
+	// Each layer of blocks is 320,000 bytes so two layers fit in a slab.
+	slabs := subvolumeSlabs(subvol, blockSize, 1, 700000)
+	expected := [][2]int32{{-40, 0}, {0, 64}, {64, 110}}
+	if len(slabs) != len(expected) {
+		t.Fatalf("Expected %d slabs, got %d\n", len(expected), len(slabs))
+	}
+	for n, slab := range slabs {
+		offset := slab.StartPoint().(dvid.Point3d)
+		size := slab.Size().(dvid.Point3d)
+		if offset[0] != 0 || offset[1] != 0 || size[0] != 100 || size[1] != 100 {
+			t.Errorf("Slab %d should cover subvolume in x and y: %s\n", n, slab)
+		}
+		if offset[2] != expected[n][0] || offset[2]+size[2] != expected[n][1] {
+			t.Errorf("Expected slab %d to span z %d to %d, got %s\n", n, expected[n][0], expected[n][1], slab)
+		}
+	}
+
+	// Slabs have at least one layer of blocks.
+	slabs = subvolumeSlabs(subvol, blockSize, 8, 1000)
+	if len(slabs) != 6 {
+		t.Errorf("Expected a slab for each of 6 block layers, got %d\n", len(slabs))
+	}
+}
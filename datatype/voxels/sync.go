@@ -0,0 +1,27 @@
+/*
+	This file defines the label mutations sent to data instances synced with label data.
+	They are defined here so label datatypes and the datatypes syncing with them don't
+	have to import each other.
+*/
+
+package voxels
+
+// Events sent to data synced with label data.
+const (
+	LabelMergeEvent = "label merge"
+	LabelSplitEvent = "label split"
+)
+
+// LabelMergeDelta is the Delta of a LabelMergeEvent.
+type LabelMergeDelta struct {
+	ToLabel    uint64
+	FromLabels []uint64
+}
+
+// LabelSplitDelta is the Delta of a LabelSplitEvent, where the split voxels of
+// FromLabel are given the new label ToLabel.
+type LabelSplitDelta struct {
+	FromLabel   uint64
+	ToLabel     uint64
+	SplitVoxels uint64
+}
@@ -0,0 +1,38 @@
+/*
+	Data type twochan16 tailors the voxels data type for 2-channel 16-bit images, e.g., two
+	fluorescence channels.  It simply wraps the voxels package, setting Channels (2) and
+	BytesPerValue(2).
+*/
+
+package voxels
+
+import (
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var twochan16EncodeFormat dvid.DataValues
+
+func init() {
+	twochan16EncodeFormat = dvid.DataValues{
+		{
+			T:     dvid.T_uint16,
+			Label: "channel1",
+		},
+		{
+			T:     dvid.T_uint16,
+			Label: "channel2",
+		},
+	}
+	interpolable := true
+	twochan := NewType(twochan16EncodeFormat, interpolable)
+	twochan.Type.Name = "twochan16"
+	twochan.Type.URL = "github.com/janelia-flyem/dvid/datatype/voxels/twochan16.go"
+	twochan.Type.Version = "0.1"
+
+	datastore.Register(twochan)
+}
+
+func TwoChan16EncodeFormat() dvid.DataValues {
+	return twochan16EncodeFormat
+}
@@ -0,0 +1,282 @@
+/*
+	This file supports undo of recent voxel POSTs within an editing session.  The prior
+	contents of blocks modified by each POST are kept in a bounded, per-session buffer
+	so interactive painting and correction tools can step back through their latest
+	edits without creating version branches.
+*/
+
+package voxels
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+var (
+	// UndoDepth is the number of most recent mutations that can be undone for each
+	// editing session.
+	UndoDepth = 10
+
+	// UndoMaxBlocks is the maximum number of blocks a single mutation can modify and
+	// still be undone.  Larger mutations clear the editing session's undo buffer since
+	// earlier mutations can no longer be undone consistently.
+	UndoMaxBlocks = 1024
+)
+
+// MaxEditSessionLength is the maximum number of bytes in an editing session name.
+const MaxEditSessionLength = 255
+
+// UndoBlock holds the contents of a voxel block before a mutation.  A nil value
+// means the block did not exist before the mutation.
+type UndoBlock struct {
+	Index []byte // voxel block index
+	V     []byte // serialized block
+}
+
+// UndoRecord holds all blocks modified by one mutation in an editing session.
+type UndoRecord struct {
+	Time   time.Time
+	Blocks []UndoBlock
+}
+
+// UndoInfo describes a buffered mutation that can be undone.
+type UndoInfo struct {
+	Seq    uint64
+	Time   time.Time
+	Blocks int
+}
+
+// NewUndoIndex returns an index for the mutation with the given sequence number in
+// an editing session.
+// Index = e+n where e is a length-prefixed session name and n is a big-endian uint64.
+func NewUndoIndex(session string, seq uint64) dvid.IndexBytes {
+	index := make([]byte, 2+len(session)+8)
+	index[0] = byte(KeyUndoRecord)
+	index[1] = byte(len(session))
+	copy(index[2:], session)
+	binary.BigEndian.PutUint64(index[2+len(session):], seq)
+	return dvid.IndexBytes(index)
+}
+
+// DecodeUndoKey returns the mutation sequence number from an undo record key.
+func DecodeUndoKey(key []byte) (uint64, error) {
+	var ctx storage.DataContext
+	index, err := ctx.IndexFromKey(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(index) < 10 || index[0] != byte(KeyUndoRecord) {
+		return 0, fmt.Errorf("Expected KeyUndoRecord index, got %v instead", index)
+	}
+	return binary.BigEndian.Uint64(index[len(index)-8:]), nil
+}
+
+// undoContext returns the storage context for undo records.  Records are held only
+// for the version that was modified, so they aren't inherited by child versions.
+func undoContext(data dvid.Data, versionID dvid.VersionID) *storage.DataContext {
+	return storage.NewDataContext(data, versionID)
+}
+
+func checkEditSession(session string) error {
+	if len(session) == 0 {
+		return fmt.Errorf("An editing session must be specified via the 'edit' query string")
+	}
+	if len(session) > MaxEditSessionLength {
+		return fmt.Errorf("Editing session name %q is longer than %d bytes", session, MaxEditSessionLength)
+	}
+	return nil
+}
+
+// undoRecorder accumulates the prior contents of blocks modified by a mutation.
+type undoRecorder struct {
+	session  string
+	blocks   []UndoBlock
+	overflow bool
+}
+
+func newUndoRecorder(session string) *undoRecorder {
+	if len(session) == 0 {
+		return nil
+	}
+	return &undoRecorder{session: session}
+}
+
+// add records the prior value of a block.  The value may be nil if the block did
+// not exist.
+func (rec *undoRecorder) add(index, v []byte) {
+	if rec == nil || rec.overflow {
+		return
+	}
+	if len(rec.blocks) >= UndoMaxBlocks {
+		rec.overflow = true
+		rec.blocks = nil
+		return
+	}
+	rec.blocks = append(rec.blocks, UndoBlock{Index: index, V: v})
+}
+
+// save stores the recorded mutation, dropping the oldest mutations beyond UndoDepth.
+func (rec *undoRecorder) save(data dvid.Data, versionID dvid.VersionID) error {
+	if rec == nil {
+		return nil
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return err
+	}
+	ctx := undoContext(data, versionID)
+	begIndex := NewUndoIndex(rec.session, 0)
+	endIndex := NewUndoIndex(rec.session, math.MaxUint64)
+	if rec.overflow {
+		dvid.Infof("Mutation in session %q of %q modified more than %d blocks.  Clearing undo buffer.\n",
+			rec.session, data.DataName(), UndoMaxBlocks)
+		return bigdata.DeleteRange(ctx, begIndex, endIndex)
+	}
+	keys, err := bigdata.KeysInRange(ctx, begIndex, endIndex)
+	if err != nil {
+		return err
+	}
+	var seq uint64 = 1
+	if len(keys) != 0 {
+		last, err := DecodeUndoKey(keys[len(keys)-1])
+		if err != nil {
+			return err
+		}
+		seq = last + 1
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(UndoRecord{time.Now(), rec.blocks}); err != nil {
+		return err
+	}
+	if err := bigdata.Put(ctx, NewUndoIndex(rec.session, seq), buf.Bytes()); err != nil {
+		return err
+	}
+	for i := 0; i < len(keys)+1-UndoDepth; i++ {
+		old, err := DecodeUndoKey(keys[i])
+		if err != nil {
+			return err
+		}
+		if err := bigdata.Delete(ctx, NewUndoIndex(rec.session, old)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UndoRecords returns the buffered mutations for an editing session, most recent first.
+func (d *Data) UndoRecords(ctx *datastore.VersionedContext, session string) ([]UndoInfo, error) {
+	if err := checkEditSession(session); err != nil {
+		return nil, err
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := bigdata.GetRange(undoContext(d, ctx.VersionID()), NewUndoIndex(session, 0), NewUndoIndex(session, math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]UndoInfo, 0, len(kvs))
+	for i := len(kvs) - 1; i >= 0; i-- {
+		seq, err := DecodeUndoKey(kvs[i].K)
+		if err != nil {
+			return nil, err
+		}
+		var record UndoRecord
+		if err := gob.NewDecoder(bytes.NewBuffer(kvs[i].V)).Decode(&record); err != nil {
+			return nil, err
+		}
+		infos = append(infos, UndoInfo{seq, record.Time, len(record.Blocks)})
+	}
+	return infos, nil
+}
+
+// Undo restores the blocks modified by the most recent mutation in an editing session
+// and removes that mutation from the session's undo buffer.  If a channel is given,
+// each restored block is sent down it so dependent data can be recomputed.
+func (d *Data) Undo(ctx *datastore.VersionedContext, session string, mods BlockChannel) (*UndoInfo, error) {
+	if err := checkEditSession(session); err != nil {
+		return nil, err
+	}
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't interleave with PUTs on this version.
+	unlockVersion := lockVersion(d, ctx.VersionID())
+	defer unlockVersion()
+
+	undoCtx := undoContext(d, ctx.VersionID())
+	keys, err := bigdata.KeysInRange(undoCtx, NewUndoIndex(session, 0), NewUndoIndex(session, math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No mutations to undo in session %q for %q", session, d.DataName())
+	}
+	seq, err := DecodeUndoKey(keys[len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	undoIndex := NewUndoIndex(session, seq)
+	value, err := bigdata.Get(undoCtx, undoIndex)
+	if err != nil {
+		return nil, err
+	}
+	var record UndoRecord
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&record); err != nil {
+		return nil, err
+	}
+
+	smalldata, err := storage.SmallDataStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range record.Blocks {
+		spatial, err := blockSpatialIndex(block.Index)
+		if err != nil {
+			return nil, err
+		}
+		indexZYX := new(dvid.IndexZYX)
+		if err := indexZYX.IndexFromBytes(spatial); err != nil {
+			return nil, err
+		}
+		var blockData []byte
+		if block.V == nil {
+			if err := bigdata.Delete(ctx, block.Index); err != nil {
+				return nil, err
+			}
+			if err := smalldata.Delete(ctx, NewBlockHistogramIndex(indexZYX)); err != nil {
+				return nil, err
+			}
+			blockData = d.BackgroundBlock()
+		} else {
+			if err := bigdata.Put(ctx, block.Index, block.V); err != nil {
+				return nil, err
+			}
+			if blockData, _, err = dvid.DeserializeData(block.V, true); err != nil {
+				return nil, fmt.Errorf("Unable to deserialize block %s in %q: %s", indexZYX, d.DataName(), err.Error())
+			}
+			if err := d.putBlockHistogram(ctx, indexZYX, blockData); err != nil {
+				return nil, err
+			}
+		}
+		if mods != nil {
+			mods <- Block3d{indexZYX, blockData}
+		}
+	}
+	if err := bigdata.Delete(undoCtx, undoIndex); err != nil {
+		return nil, err
+	}
+	return &UndoInfo{seq, record.Time, len(record.Blocks)}, nil
+}
@@ -0,0 +1,95 @@
+package voxels
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestUndo(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, versionID := initTestRepo()
+	grayscale := makeGrayscale(repo, t, "grayscale")
+	ctx := datastore.NewVersionedContext(grayscale, versionID)
+
+	offset := dvid.Point3d{10, 20, 30}
+	size := dvid.Point3d{40, 40, 40}
+	subvol := dvid.NewSubvolume(offset, size)
+	getVoxels := func() []byte {
+		v, err := grayscale.NewExtHandler(subvol, nil)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		if err = GetVoxels(ctx, grayscale, v, nil); err != nil {
+			t.Fatalf("Unable to get voxels for %s: %s\n", ctx, err.Error())
+		}
+		return v.Data()
+	}
+	putVoxels := func(data []byte, session string) {
+		v, err := grayscale.NewExtHandler(subvol, data)
+		if err != nil {
+			t.Fatalf("Unable to make new grayscale ExtHandler: %s\n", err.Error())
+		}
+		var opts OpOptions
+		opts.SetEditSession(session)
+		if err = PutVoxels(ctx, grayscale, v, opts); err != nil {
+			t.Fatalf("Unable to put voxels for %s: %s\n", ctx, err.Error())
+		}
+	}
+
+	first := makeVolume(offset, size)
+	second := make([]byte, len(first))
+	for i := range second {
+		second[i] = first[i] ^ 0xFF
+	}
+	putVoxels(first, "painter")
+	putVoxels(second, "painter")
+	putVoxels(first, "")
+
+	infos, err := grayscale.UndoRecords(ctx, "painter")
+	if err != nil {
+		t.Fatalf("Error getting undo records: %s\n", err.Error())
+	}
+	if len(infos) != 2 || infos[0].Seq != 2 || infos[1].Seq != 1 || infos[0].Blocks != 12 {
+		t.Fatalf("Bad undo records: %v\n", infos)
+	}
+
+	// Undo restores the voxels prior to the second edit, then the empty volume.
+	if _, err := grayscale.Undo(ctx, "painter", nil); err != nil {
+		t.Fatalf("Error on undo: %s\n", err.Error())
+	}
+	if !bytes.Equal(getVoxels(), first) {
+		t.Errorf("Undo did not restore voxels prior to the last edit\n")
+	}
+	if _, err := grayscale.Undo(ctx, "painter", nil); err != nil {
+		t.Fatalf("Error on undo: %s\n", err.Error())
+	}
+	if !bytes.Equal(getVoxels(), make([]byte, len(first))) {
+		t.Errorf("Undo did not remove blocks that didn't exist before the first edit\n")
+	}
+	if _, err := grayscale.Undo(ctx, "painter", nil); err == nil {
+		t.Errorf("Expected error when nothing is left to undo\n")
+	}
+
+	// Only the most recent mutations are kept.
+	oldDepth := UndoDepth
+	UndoDepth = 2
+	defer func() { UndoDepth = oldDepth }()
+	for i := 0; i < 4; i++ {
+		putVoxels(first, "painter")
+	}
+	if infos, err = grayscale.UndoRecords(ctx, "painter"); err != nil {
+		t.Fatalf("Error getting undo records: %s\n", err.Error())
+	}
+	if len(infos) != 2 || infos[0].Seq != 4 {
+		t.Errorf("Expected 2 most recent undo records, got %v\n", infos)
+	}
+	if _, err = grayscale.UndoRecords(ctx, ""); err == nil {
+		t.Errorf("Expected error on missing editing session\n")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"image"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -52,33 +53,56 @@ $ dvid repo <UUID> new <type name> <data name> <settings...>
     Arguments:
 
     UUID           Hexidecimal string with enough characters to uniquely identify a version node.
-    type name      Data type name, e.g., "grayscale8"
+    type name      Data type name, e.g., "grayscale8".  Voxel types are "grayscale8",
+                     "grayscale16", "grayscale32", "rgb8", "rgba8", "rgba16", and "twochan16".
+                     The type of each voxel is reported as "VoxelType" in the instance info.
     data name      Name of data to create, e.g., "mygrayscale"
     settings       Configuration settings in "key=value" format separated by spaces.
 
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
-    BlockSize      Size in pixels  (default: %s)
+    Encrypted      "true" or "false" (default).  Encrypts stored values if the server
+                     configures encryption at rest.
+    Durability     "sync", "periodic", or "async" durability of writes that don't request
+                     one via the "durability" query string.  Default is the server's.
+    Compression    Compression of stored blocks: "none", "snappy", "lz4" (default), "gzip",
+                     "gzip:N" where N is a level from 1 to 9, or for 8-bit grayscale only,
+                     lossy "jpeg" or "jpeg:N" where N is a quality from 1 to 100.
+    BlockSize      Size of blocks in voxels, either one size for cubic blocks, e.g., "64", or
+                     sizes in X, Y, and Z like "128x128x16" or "128,128,16" (default: %d).
+                     The block size can't be changed after creation.
     VoxelSize      Resolution of voxels (default: 8.0, 8.0, 8.0)
     VoxelUnits     Resolution units (default: "nanometers")
     Background     Integer value that signifies background in any element (default: 0)
+    DeferWrites    "true" or "false" (default).  If true, PUTs return once their blocks are
+                     queued in memory, and queued blocks are written in large batches every
+                     few seconds or when many are queued.  This speeds up ingestion at the cost
+                     of durability: queued blocks are lost if the server crashes, and GETs
+                     don't see them until written.  Use the "flush" endpoint to write them.
 
 $ dvid node <UUID> <data name> load <offset> <image glob>
 
     Initializes version node to a set of XY images described by glob of filenames.  The
-    DVID server must have access to the named files.  Currently, XY images are required.
+    DVID server must have access to the named files.  If a directory is given, all PNG,
+    JPG, TIF, and GIF images within it are loaded in filename order.  If the directory
+    holds an N5 dataset (an attributes.json file and chunks), the dataset is loaded with
+    its first voxel at the offset.  HDF5 files aren't supported; convert them to N5.
+    Slices or dataset chunks are converted to blocks in parallel and written in batches.
+    Progress is periodically logged and available via the "load-progress" HTTP endpoint.
 
     Example: 
 
     $ dvid node 3f8c mygrayscale load 0,0,100 data/*.png
+    $ dvid node 3f8c mygrayscale load 0,0,0 /data/em-slices
+    $ dvid node 3f8c mygrayscale load 0,0,0 /data/em.n5/grayscale/s0
 
     Arguments:
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of data to add.
     offset        3d coordinate in the format "x,y,z".  Gives coordinate of top upper left voxel.
-    image glob    Filenames of images, e.g., foo-xy-*.png
+    image glob    Filenames of images, e.g., foo-xy-*.png, an image directory, or an N5 dataset
 
 $ dvid node <UUID> <data name> put local  <plane> <offset> <image glob>
 $ dvid node <UUID> <data name> put remote <plane> <offset> <image glob>
@@ -109,6 +133,92 @@ $ dvid node <UUID> <data name> roi <new roi data name> <background values separa
 
     $ dvid node 3f8c mygrayscale roi grayscale_roi 0,255
 
+
+$ dvid node <UUID> <data name> histograms
+
+    Computes and stores intensity histograms for all voxel blocks in the given version.
+    Histograms are maintained automatically for blocks written via the HTTP API, so
+    this is only necessary after bulk loads or for data stored before histograms were
+    supported.  Only 8-bit voxels are supported.
+
+    Example:
+
+    $ dvid node 3f8c mygrayscale histograms
+
+
+$ dvid node <UUID> <data name> pyramid <levels>
+
+    Computes and stores downsampled versions of the voxel data at scale levels 1 through
+    <levels>, where each level halves the resolution of the level below it.  Grayscale
+    data is averaged and label data uses the most frequent label in each 2x2x2 voxel
+    neighborhood.  Scale levels are not updated when voxels are modified so this command
+    should be rerun after changes.  Scale levels can be retrieved via the "scale" query
+    string of the "raw" and "isotropic" endpoints.
+
+    Example:
+
+    $ dvid node 3f8c mygrayscale pyramid 4
+
+
+$ dvid node <UUID> <data name> export <format> <directory> <settings...>
+
+    Exports voxels of the given version to an N5 or Zarr directory hierarchy, e.g., for
+    BigDataViewer or Python tools.  The directory must be visible to the DVID server.
+    The export runs as a background job whose progress is available via the
+    /api/server/jobs HTTP endpoints.  Chunks without any nonzero voxels aren't written.
+
+    Example:
+
+    $ dvid node 3f8c mygrayscale export zarr /data/export roi=medulla chunksize=64,64,64
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to export.
+    format        "n5" or "zarr".
+    directory     Container directory, which is created if necessary.
+
+    Configuration Settings (case-insensitive keys)
+
+    dataset       Path of the dataset within the container.  Defaults to the data name.
+    offset        Voxel coordinate "x,y,z" of the first voxel exported.  Defaults to the
+                    smallest coordinate of stored voxels.
+    size          Size "x,y,z" of the exported volume.  Defaults to the extent of stored voxels.
+    roi           Name of an ROI.  Voxels outside the ROI are exported as zeros.
+    chunksize     Chunk size "x,y,z".  Defaults to the block size.
+    compression   "gzip" (default) or "raw".
+    level         Gzip compression level from 0 to 9.  Defaults to %d.
+
+
+$ dvid node <UUID> <data name> import <format> <location> <settings...>
+
+    Imports an N5 or Zarr dataset into data without stored voxels, e.g., just created via
+    "dvid repo <UUID> new".  The container can be a directory visible to the DVID server
+    or an http(s) URL.  The dataset must have the same value type and number of channels
+    as the data, but its chunks needn't match the block size.  Missing chunks are treated
+    as zeros and blocks without nonzero voxels aren't stored.  The dataset's resolution
+    and offset attributes, as written by "export", are used if present.  The import runs
+    as a background job whose progress is available via the /api/server/jobs HTTP
+    endpoints.  Chunks are read and blocks written in parallel by the server's chunk
+    handlers.
+
+    Example:
+
+    $ dvid node 3f8c mygrayscale import n5 https://example.org/em.n5 dataset=raw/s0
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to import into.
+    format        "n5" or "zarr".
+    location      Container directory or URL.
+
+    Configuration Settings (case-insensitive keys)
+
+    dataset       Path of the dataset within the container.  Defaults to the data name.
+    offset        Voxel coordinate "x,y,z" where the dataset's first voxel is placed.
+                    Defaults to the dataset's offset attribute or 0,0,0.
+
     
     ------------------
 
@@ -137,6 +247,21 @@ POST <api URL>/node/<UUID>/<data name>/info
     data name     Name of voxels data.
 
 
+GET  <api URL>/node/<UUID>/<data name>/load-progress
+
+    Returns JSON describing the progress of any bulk load initiated via the "load"
+    command, including the # of slices or rows of blocks loaded and estimated seconds
+    remaining.  Returns 404 if no load is in progress.
+
+
+GET  <api URL>/node/<UUID>/<data name>/flush
+POST <api URL>/node/<UUID>/<data name>/flush
+
+    Supports data with the "DeferWrites" setting.  A GET returns JSON with the number of
+    blocks queued for writing, and a POST writes all queued blocks before returning JSON
+    with the number of blocks written, e.g., after an ingestion completes.
+
+
 GET  <api URL>/node/<UUID>/<data name>/metadata
 
 	Retrieves a JSON schema (application/vnd.dvid-nd-data+json) that describes the layout
@@ -164,7 +289,19 @@ POST <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>]
     Throttling can be enabled by passing a "throttle=on" query string.  Throttling makes sure
     only one compute-intense operation (all API calls that can be throttled) is handled.
     If the server can't initiate the API call right away, a 503 (Service Unavailable) status
-    code is returned.
+    code is returned.  Whether throttled or not, 3d requests count as heavy operations
+    against any quota of the client, and a 429 (Too Many Requests) status code with a
+    Retry-After header is returned if the client is already running its maximum.
+
+    Large 3d subvolumes that are sent uncompressed or gzip compressed are streamed as
+    layers of blocks along z are read, e.g., via chunked transfer encoding, so responses
+    have no Content-Length and errors after streaming begins truncate the response.
+
+    If the server has a block cache, full resolution GETs of XY, XZ and YZ slices without
+    an roi are tracked per client, identified by the "session" query string or else its
+    API token or address.  Once a client has requested more than one orientation, blocks
+    for its other orientations through the center of its latest slice are prefetched into
+    the cache, so the first slice after switching views is fast.
 
     Arguments:
 
@@ -176,8 +313,10 @@ POST <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>]
     offset        Gives coordinate of first voxel using dimensionality of data.
     format        Valid formats depend on the dimensionality of the request and formats
                     available in server implementation.
-                  2D: "png", "jpg" (default: "png")
+                  2D: "png", "jpg", "tiff" (default: "png")
                     jpg allows lossy quality setting, e.g., "jpg:80"
+                    16-bit voxels give 16-bit png and tiff images.  Voxels with 2 or 3
+                    channels give RGBA images with unused channels zero and opaque alpha.
                   nD: uses default "octet-stream".
 
     Query-string Options:
@@ -186,8 +325,60 @@ POST <api URL>/node/<UUID>/<data name>/raw/<dims>/<size>/<offset>[/<format>]
     attenuation   (TODO) For attenuation n, this reduces the intensity of voxels outside ROI by 2^n.
     			  Valid range is n = 1 to n = 7.  Currently only implemented for 8-bit voxels.
     			  Default is to zero out voxels outside ROI.
+    scale         GET only.  Scale level computed by the "pyramid" command, where the data
+                    is downsampled by 2^scale.  Size and offset are given in voxels of that
+                    scale.  Default is 0, the original resolution.  Cannot be used with roi.
+    margin        GET of 3d subvolumes only.  Number of context voxels to add around the
+                    requested subvolume, either one value or values for each dimension
+                    like "16_16_4".  The extended subvolume is clamped to the data extents
+                    but never smaller than requested.  Its offset and size are returned in
+                    the X-Dvid-Offset and X-Dvid-Size headers in "x_y_z" format.
+    compression   GET of nD data only.  Compresses the returned data independent of how
+                    it is stored: "none", "snappy", "lz4", "gzip", or "gzip-N" where N is
+                    a level from 1 (fastest) to 9 (smallest).  8-bit grayscale can also use
+                    lossy "jpeg" or "jpeg-N" where N is a quality from 1 to 100.  Without
+                    this option, data of at least 1 KB is gzip compressed if the request's
+                    Accept-Encoding header allows gzip.  The compression used is returned
+                    in the X-Dvid-Compression header.  Lz4 and jpeg data are prefixed by
+                    the uncompressed size as a little-endian uint32, and jpeg data is a
+                    grayscale image whose rows hold consecutive voxels.
+    edit          POST only.  Name of an editing session.  The prior contents of modified
+                    blocks are kept so the POST can be reverted via the "undo" endpoint.
+    stride        GET of 3d subvolumes only.  Returns every Nth voxel along each axis,
+                    either one value or values for each dimension like "4_4_1", starting
+                    with the voxel at offset.  The returned subvolume has ceil(size/stride)
+                    voxels along each axis, and only blocks holding sampled voxels are read.
+                    The stride is returned in the X-Dvid-Stride header.  Cannot be used
+                    with roi.
+
+GET  <api URL>/node/<UUID>/<data name>/boxes/<size>/<offset>[/<size>/<offset>...][?queryopts]
+
+    Retrieves the voxels of several, possibly non-contiguous, 3d boxes in one request,
+    e.g., for sparse sampling.  Blocks shared by boxes are only read once.  The returned
+    octet-stream holds the voxels of each box in the requested order, each box in
+    x-fastest order like "raw" subvolumes.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/boxes/64_64_64/0_0_100/64_64_64/1024_512_300?stride=2
+
+    Returns two 32 x 32 x 32 boxes sampling every other voxel of the 64^3 boxes at offsets
+    (0,0,100) and (1024,512,300).
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of voxels data.
+    size          Size in voxels of a box in "x_y_z" format.
+    offset        Gives coordinate of first voxel of a box in "x_y_z" format.
+
+    Query-string Options:
 
-GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format>][?throttle=on]
+    stride        Returns every Nth voxel of each box.  See "raw" endpoint.
+    scale         Scale level computed by the "pyramid" command.  See "raw" endpoint.
+    compression   Compresses the returned data.  See "raw" endpoint.
+
+GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format>][?throttle=on][?scale=0]
 
     Retrieves or puts voxel data.
 
@@ -209,7 +400,9 @@ GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format
     Throttling can be enabled by passing a "throttle=on" query string.  Throttling makes sure
     only one compute-intense operation (all API calls that can be throttled) is handled.
     If the server can't initiate the API call right away, a 503 (Service Unavailable) status
-    code is returned.
+    code is returned.  Whether throttled or not, 3d requests count as heavy operations
+    against any quota of the client, and a 429 (Too Many Requests) status code with a
+    Retry-After header is returned if the client is already running its maximum.
 
     Arguments:
 
@@ -225,6 +418,101 @@ GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format
                     jpg allows lossy quality setting, e.g., "jpg:80"
                   nD: uses default "octet-stream".
 
+    Query-string Options:
+
+    scale         Scale level computed by the "pyramid" command.  See "raw" endpoint.
+
+GET  <api URL>/node/<UUID>/<data name>/histogram/<size>/<offset>[?low=0.5&high=99.5]
+
+    Returns JSON with the intensity histogram of all voxel blocks intersecting the
+    given 3d subvolume, aggregated from precomputed block histograms so no voxel
+    data is read.  Only 8-bit voxels are supported.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/histogram/512_512_256/0_0_100?low=1&high=99
+
+    Returns:
+
+    { "Blocks": 1024, "Voxels": 33554432, "Min": 3, "Max": 251, "Low": 40, "High": 212, "Bins": [...] }
+
+    "Low" and "High" are the intensities at the given percentiles and are suitable
+    for auto-contrast.  Since whole blocks are tallied, voxels slightly outside the
+    subvolume may be counted.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of voxels data.
+    size          Size in voxels along each dimension in "x_y_z" format.
+    offset        Gives coordinate of first voxel in "x_y_z" format.
+
+    Query-string Options:
+
+    low           Percentile for the "Low" intensity.  Default 0.5.
+    high          Percentile for the "High" intensity.  Default 99.5.
+    budget_ms     Time budget in milliseconds.  If exceeded, the histogram of the blocks
+                    aggregated so far is returned with a "Dvid-Partial: true" header and a
+                    "Dvid-Continuation" header giving a token.
+    continue      Token from a partial result's "Dvid-Continuation" header.  Resumes the
+                    aggregation and returns a more complete histogram.
+
+GET  <api URL>/node/<UUID>/<data name>/stats[/<size>/<offset>][?queryopts]
+
+    Returns JSON with statistics and a histogram of the voxel values within the given 3d
+    subvolume, an ROI, or the part of an ROI within the subvolume.  Unlike the "histogram"
+    endpoint, the voxel blocks are read so only voxels within the subvolume are tallied.
+    Voxels in blocks that have never been stored aren't tallied.  Only single channel
+    uint8 and uint16 data are supported.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/stats/512_512_256/0_0_100?roi=medulla&bins=64
+
+    Returns:
+
+    { "Blocks": 1024, "Voxels": 30828160, "Min": 3, "Max": 251, "Mean": 121.6, "StdDev": 38.2,
+      "BinWidth": 4, "Bins": [...] }
+
+    The histogram bins are of equal width and span all values of the data type, so for
+    uint8 data, bin i counts values from i*BinWidth to (i+1)*BinWidth - 1.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of voxels data.
+    size          Size in voxels along each dimension in "x_y_z" format.
+    offset        Gives coordinate of first voxel in "x_y_z" format.
+
+    Query-string Options:
+
+    roi           Name of an ROI with the same block size as the data.  Only voxels within
+                    blocks of the ROI are tallied.  Required if no subvolume is given.
+    bins          Number of histogram bins.  Default 256.
+
+GET  <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+POST <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+
+    A GET returns JSON listing the mutations of the given editing session that can be
+    undone, most recent first.  A POST reverts the most recent of those mutations and
+    returns JSON describing it.  Only "raw" POSTs with an "edit" query string are
+    recorded, and only the last 10 mutations of each session are kept.  A mutation
+    modifying more than 1024 blocks can't be undone and clears the session's buffer.
+    Undo records are kept for the modified version only.
+
+    Example: 
+
+    POST <api URL>/node/3f8c/grayscale/undo?edit=painter-42
+
+    Returns:
+
+    { "Seq": 7, "Time": "2015-03-02T10:14:31.553-05:00", "Blocks": 12 }
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of voxels data.
+
 GET  <api URL>/node/<UUID>/<data name>/arb/<top left>/<top right>/<bottom left>/<res>[/<format>]
 
     Retrieves non-orthogonal (arbitrarily oriented planar) image data of named 3d data 
@@ -248,6 +536,64 @@ GET  <api URL>/node/<UUID>/<data name>/arb/<top left>/<top right>/<bottom left>/
     format        "png", "jpg" (default: "png")  
                     jpg allows lossy quality setting, e.g., "jpg:80"
 
+GET  <api URL>/node/<UUID>/<data name>/oblique/<size>/<center>/<normal>/<up>[/<format>][?queryopts]
+
+    Retrieves an arbitrarily oriented 2d slice of named 3d data within a version node,
+    resampled from the stored voxels.  The slice is centered on a point in voxel space and
+    lies in the plane with the given normal.  The up vector, projected onto the plane, points
+    to the top of the image, and the image x axis is the cross product of the normal and up
+    vectors.  A normal of "0_0_1" with up "0_-1_0" gives the same orientation as an xy slice.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/oblique/512_512/1024.5_880_300/1_1_0/0_0_1/jpg:80?interpolation=nearest
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add.
+    size          Size in pixels in the format "width_height".
+    center        Voxel coordinate of the image center in "x_y_z" format, e.g., "20.3_11.8_109.4".
+    normal        Vector normal to the image plane in "x_y_z" format.
+    up            Vector giving the top of the image in "x_y_z" format.  Must not be parallel
+                    to the normal.
+    format        "png", "jpg" (default: "png")  
+                    jpg allows lossy quality setting, e.g., "jpg:80"
+
+    Query-string Options:
+
+    interpolation "nearest" or "trilinear".  Trilinear interpolation is only allowed for
+                    interpolable data and is the default for it.  Nearest neighbor
+                    interpolation works for all voxel types and is the default otherwise.
+    res           The real world distance between pixels, e.g., nanometers.  Defaults to the
+                    smallest voxel dimension.
+
+GET  <api URL>/node/<UUID>/<data name>/precomputed/info
+GET  <api URL>/node/<UUID>/<data name>/precomputed/<scale key>/<chunk name>
+
+    Serves the data in the Neuroglancer "precomputed" format so Neuroglancer can browse
+    a version directly using the source URL:
+
+    precomputed://http://<server>/api/node/<UUID>/<data name>/precomputed
+
+    The info endpoint returns JSON describing the stored extents with scale key "s0" at
+    full resolution and keys "s1", "s2", ... for each scale level computed by the last
+    "pyramid" command.  Chunks are returned with "raw" encoding as little-endian values,
+    with each channel stored as a separate volume.  Voxel sizes are assumed to be in
+    nanometers.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/precomputed/s1/0-64_64-128_0-64
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of voxels data.
+    scale key     Scale key from the info JSON, e.g., "s0".
+    chunk name    Chunk bounds in "<xBegin>-<xEnd>_<yBegin>-<yEnd>_<zBegin>-<zEnd>" format,
+                    where each end is exclusive and coordinates are voxels of the scale.
+
  GET <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
 POST <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
 
@@ -272,12 +618,79 @@ POST <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of data to add.
     block coord   Gives coordinate of first voxel using dimensionality of data.
+
+ GET <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>/<spanY>/<spanZ>[?compression=lz4]
+
+    Streams all stored blocks within a span of block coordinates in block key order, i.e.,
+    X varies fastest then Y then Z.  Blocks that have never been written are skipped.
+    Each block is sent as a frame with all integers in little-endian format:
+
+    <int32: block x> <int32: block y> <int32: block z>
+    <int32: # bytes N in block data>
+    <N bytes of block data>
+
+    The stream ends with a frame having -1 bytes.  If the stream ends without this frame,
+    an error occurred on the server.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/blocks/10_20_30/8/4/4?compression=snappy
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add.
+    block coord   Block coordinate of first block in "x_y_z" format.
+    spanX         Number of blocks along X.
+    spanY         Number of blocks along Y.
+    spanZ         Number of blocks along Z.
+
+    Query-string Options:
+
+    compression   Compression of each block's data: "none" (default), "snappy", "lz4",
+                    "gzip", or "gzip-N" where N is a level from 1 (fastest) to 9 (smallest).
+                    8-bit grayscale can also use "jpeg" or "jpeg-N" for quality N.
+                    Blocks stored with the requested format are sent without recompression
+                    unless a gzip level is given.
+
+GET <api URL>/node/<UUID>/<data name>/partition?n=<number of chunks>[&by=bytes][&roi=<roi name>]
+
+    Returns JSON describing at most n non-overlapping, block-aligned chunks that together
+    contain all stored blocks, each chunk having about the same number of blocks or stored
+    bytes.  Chunks are found by recursively bisecting the stored blocks, so external
+    schedulers can plan parallel jobs.  Fewer chunks are returned if there are fewer
+    stored blocks than n.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/grayscale/partition?n=2&by=bytes
+
+    { "By": "bytes", "NumBlocks": 1000, "Weight": 3541822, "NumChunks": 2,
+      "Chunks": [
+        { "MinPoint": [0,0,0], "MaxPoint": [319,639,511],
+          "MinChunk": [0,0,0], "MaxChunk": [9,19,15],
+          "NumBlocks": 500, "Weight": 1772101 }, ...
+      ]
+    }
+
+    The Min/MaxPoint are voxel coordinates and Min/MaxChunk are block coordinates.
+
+    Query-string Options:
+
+    n             Number of chunks.
+    by            "blocks" (default) balances the number of stored blocks per chunk.
+                    "bytes" balances the stored bytes per chunk.
+    roi           Name of roi data instance used to restrict blocks.
+
 `
 
 var (
 	// DefaultBlockSize specifies the default size for each block of this data type.
 	DefaultBlockSize int32 = 32
 
+	// MaxBlockVoxels is the maximum number of voxels in a block.
+	MaxBlockVoxels int64 = 256 * 256 * 256
+
 	DefaultRes float32 = 8
 
 	DefaultUnits = "nanometers"
@@ -348,7 +761,17 @@ func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.
 }
 
 func (dtype *Type) Help() string {
-	return fmt.Sprintf(HelpMessage, DefaultBlockSize)
+	return fmt.Sprintf(HelpMessage, DefaultBlockSize, DefaultExportLevel)
+}
+
+// SupportsLossyCompression returns true for 8-bit grayscale data, which can be stored
+// with JPEG compression.
+func (dtype *Type) SupportsLossyCompression() bool {
+	return isGrayscale8(dtype.values)
+}
+
+func isGrayscale8(values dvid.DataValues) bool {
+	return len(values) == 1 && values[0].T == dvid.T_uint8
 }
 
 type bulkLoadInfo struct {
@@ -356,6 +779,7 @@ type bulkLoadInfo struct {
 	versionID     dvid.VersionID
 	offset        dvid.Point
 	extentChanged dvid.Bool
+	progress      *LoadProgress
 }
 
 // Voxels represents subvolumes or slices and implements the ExtData interface.
@@ -469,8 +893,21 @@ func (v *Voxels) DownRes(magnification dvid.Point) error {
 		return err
 	}
 
-	// Set data and dimensions to downres data.
-	v.data = []byte(img.Data())
+	// Set data and dimensions to downres data, undoing any conversion done for the image.
+	data := []byte(img.Data())
+	bytesPerValue, err := v.values.BytesPerValue()
+	if err != nil {
+		return err
+	}
+	if bytesPerValue == 2 {
+		if data, err = littleToBigEndian(v, data); err != nil {
+			return err
+		}
+	}
+	if channels := v.values.ValuesPerElement(); channels == 2 || channels == 3 {
+		data = compactFromRGBA(data, channels, bytesPerValue)
+	}
+	v.data = data
 	geom, err := dvid.NewOrthogSlice(v.DataShape(), v.StartPoint(), dvid.Point2d{dstW, dstH})
 	if err != nil {
 		return err
@@ -498,9 +935,10 @@ func (v *Voxels) IndexIterator(chunkSize dvid.Point) (dvid.IndexIterator, error)
 	return dvid.NewIndexZYXIterator(begBlock, endBlock), nil
 }
 
-// GetImage2d returns a 2d image suitable for use external to DVID.
-// TODO -- Create more comprehensive handling of endianness and encoding of
-// multibytes/voxel data into appropriate images.
+// GetImage2d returns a 2d image suitable for use external to DVID.  Voxels with 2 or 3
+// values are expanded into RGBA images with opaque alpha, and 16-bit values are converted
+// to the big endian order of go images.  Voxels with a single 32 or 64-bit value are packed
+// as is into 8 or 16-bit RGBA images.
 func (v *Voxels) GetImage2d() (*dvid.Image, error) {
 	// Make sure each value has same # of bytes or else we can't generate a go image.
 	// If so, we need to make another ExtData that knows how to convert the varying
@@ -532,31 +970,42 @@ func (v *Voxels) GetImage2d() (*dvid.Image, error) {
 	if int(end) > len(data) {
 		return nil, fmt.Errorf("Voxels %s has insufficient amount of data to return an image.", v)
 	}
+	pixels := data[beg:end]
+	format := v.Values()
+	if valuesPerVoxel == 2 || valuesPerVoxel == 3 {
+		pixels = expandToRGBA(pixels, valuesPerVoxel, bytesPerValue)
+		format = rgbaValues(v.values[0].T)
+		valuesPerVoxel = 4
+	}
 	r := image.Rect(0, 0, int(width), int(height))
 	switch valuesPerVoxel {
 	case 1:
 		switch bytesPerValue {
 		case 1:
-			img = &image.Gray{data[beg:end], 1 * r.Dx(), r}
+			img = &image.Gray{pixels, 1 * r.Dx(), r}
 		case 2:
-			bigendian, err := littleToBigEndian(v, data[beg:end])
+			bigendian, err := littleToBigEndian(v, pixels)
 			if err != nil {
 				return nil, err
 			}
 			img = &image.Gray16{bigendian, 2 * r.Dx(), r}
 		case 4:
-			img = &image.NRGBA{data[beg:end], 4 * r.Dx(), r}
+			img = &image.NRGBA{pixels, 4 * r.Dx(), r}
 		case 8:
-			img = &image.NRGBA64{data[beg:end], 8 * r.Dx(), r}
+			img = &image.NRGBA64{pixels, 8 * r.Dx(), r}
 		default:
 			return nil, unsupported()
 		}
 	case 4:
 		switch bytesPerValue {
 		case 1:
-			img = &image.NRGBA{data[beg:end], 4 * r.Dx(), r}
+			img = &image.NRGBA{pixels, 4 * r.Dx(), r}
 		case 2:
-			img = &image.NRGBA64{data[beg:end], 8 * r.Dx(), r}
+			bigendian, err := littleToBigEndian(v, pixels)
+			if err != nil {
+				return nil, err
+			}
+			img = &image.NRGBA64{bigendian, 8 * r.Dx(), r}
 		default:
 			return nil, unsupported()
 		}
@@ -564,7 +1013,7 @@ func (v *Voxels) GetImage2d() (*dvid.Image, error) {
 		return nil, unsupported()
 	}
 
-	return dvid.ImageFromGoImage(img, v.Values(), v.Interpolable())
+	return dvid.ImageFromGoImage(img, format, v.Interpolable())
 }
 
 // Extents holds the extents of a volume in both absolute voxel coordinates
@@ -677,6 +1126,37 @@ type Properties struct {
 
 	// Background value for data
 	Background uint8
+
+	// ScaleLevels is the number of downsampled scale levels last computed by the
+	// "pyramid" command.
+	ScaleLevels uint8
+
+	// DeferWrites is true if PUT blocks are queued and written asynchronously in batches.
+	DeferWrites bool
+}
+
+// VoxelType summarizes the values of each voxel.
+type VoxelType struct {
+	// DataType is the type of each value, e.g., "uint16", or "mixed" if values differ.
+	DataType string
+
+	// Channels is the number of values per voxel.
+	Channels int32
+
+	BytesPerVoxel int32
+}
+
+// VoxelType returns a summary of the values of each voxel.
+func (props *Properties) VoxelType() VoxelType {
+	dataType := "mixed"
+	if t, err := props.Values.ValueDataType(); err == nil {
+		dataType = t.String()
+	}
+	return VoxelType{
+		DataType:      dataType,
+		Channels:      props.Values.ValuesPerElement(),
+		BytesPerVoxel: props.Values.BytesPerElement(),
+	}
 }
 
 // SetDefault sets Voxels properties to default values.
@@ -717,10 +1197,14 @@ func (props *Properties) SetByConfig(config dvid.Config) error {
 		return err
 	}
 	if found {
-		props.BlockSize, err = dvid.StringToPoint(s, ",")
+		blockSize, err := dvid.StringToBlockSize(s)
 		if err != nil {
 			return err
 		}
+		if blockSize.Prod() > MaxBlockVoxels {
+			return fmt.Errorf("Block size %s has more than the maximum %d voxels", blockSize, MaxBlockVoxels)
+		}
+		props.BlockSize = blockSize
 	}
 	s, found, err = config.GetString("VoxelSize")
 	if err != nil {
@@ -754,6 +1238,13 @@ func (props *Properties) SetByConfig(config dvid.Config) error {
 		}
 		props.Background = uint8(background)
 	}
+	deferWrites, found, err := config.GetBool("DeferWrites")
+	if err != nil {
+		return err
+	}
+	if found {
+		props.DeferWrites = deferWrites
+	}
 	return nil
 }
 
@@ -834,6 +1325,13 @@ func (d *Data) BlankImage(dstW, dstH int32) (*dvid.Image, error) {
 			valuesPerVoxel, bytesPerValue)
 	}
 
+	// Images of 2 or 3 channel data are RGBA, as returned by GetImage2d().
+	format := d.Properties.Values
+	if valuesPerVoxel == 2 || valuesPerVoxel == 3 {
+		format = rgbaValues(format[0].T)
+		valuesPerVoxel = 4
+	}
+
 	var img image.Image
 	stride := int(dstW * valuesPerVoxel * bytesPerValue)
 	r := image.Rect(0, 0, int(dstW), int(dstH))
@@ -890,7 +1388,7 @@ func (d *Data) BlankImage(dstW, dstH int32) (*dvid.Image, error) {
 		return nil, unsupported()
 	}
 
-	return dvid.ImageFromGoImage(img, d.Properties.Values, d.Properties.Interpolable)
+	return dvid.ImageFromGoImage(img, format, d.Properties.Interpolable)
 }
 
 // Returns the image size necessary to compute an isotropic slice of the given dimensions.
@@ -1045,7 +1543,7 @@ func (d *Data) NewExtHandler(geom dvid.Geometry, img interface{}) (ExtData, erro
 		case image.Image:
 			var actualStride int32
 			var err error
-			voxels.data, _, actualStride, err = dvid.ImageData(t)
+			voxels.data, actualStride, err = imageVoxelData(t, d.Properties.Values, d.ByteOrder)
 			if err != nil {
 				return nil, err
 			}
@@ -1068,6 +1566,16 @@ func (d *Data) NewExtHandler(geom dvid.Geometry, img interface{}) (ExtData, erro
 	return voxels, nil
 }
 
+// IngestBatch applies operations on voxel blocks sent through an RPC batch session.
+func (d *Data) IngestBatch(ctx *datastore.VersionedContext, ops []datastore.BatchOp) error {
+	return IngestBlocks(ctx, d, ops)
+}
+
+// ReadBlocks returns uncompressed voxel blocks, e.g., for gRPC clients.
+func (d *Data) ReadBlocks(ctx *datastore.VersionedContext, coords []dvid.ChunkPoint3d) ([][]byte, error) {
+	return ReadBlocks(ctx, coords)
+}
+
 func (d *Data) BaseData() dvid.Data {
 	return d
 }
@@ -1076,6 +1584,15 @@ func (d *Data) Values() dvid.DataValues {
 	return d.Properties.Values
 }
 
+// writeCompressed writes data using the compression negotiated for the request, which
+// can be JPEG for 8-bit grayscale data.
+func (d *Data) writeCompressed(data []byte, w http.ResponseWriter, r *http.Request) error {
+	if isGrayscale8(d.Values()) {
+		return dvid.WriteGrayscaleCompressed(data, w, r)
+	}
+	return dvid.WriteCompressed(data, w, r)
+}
+
 func (d *Data) BlockSize() dvid.Point {
 	return d.Properties.BlockSize
 }
@@ -1090,11 +1607,13 @@ func (d *Data) String() string {
 
 func (d *Data) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Base     *datastore.Data
-		Extended Properties
+		Base      *datastore.Data
+		Extended  Properties
+		VoxelType VoxelType
 	}{
 		&(d.Data),
 		d.Properties,
+		d.Properties.VoxelType(),
 	})
 }
 
@@ -1125,13 +1644,32 @@ func (d *Data) GobEncode() ([]byte, error) {
 // --- DataService interface ---
 
 func (d *Data) Help() string {
-	return fmt.Sprintf(HelpMessage, DefaultBlockSize)
+	return fmt.Sprintf(HelpMessage, DefaultBlockSize, DefaultExportLevel)
 }
 func (d *Data) ModifyConfig(config dvid.Config) error {
+	// Stored blocks can't be read with another block size.
+	s, found, err := config.GetString("BlockSize")
+	if err != nil {
+		return err
+	}
+	if found {
+		blockSize, err := dvid.StringToBlockSize(s)
+		if err != nil {
+			return err
+		}
+		if blockSize.String() != d.BlockSize().String() {
+			return fmt.Errorf("Can't change block size of data %q from %s to %s", d.DataName(), d.BlockSize(), blockSize)
+		}
+	}
 	props := &(d.Properties)
 	if err := props.SetByConfig(config); err != nil {
 		return err
 	}
+	if !props.DeferWrites {
+		if _, err := FlushDeferred(d); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1212,6 +1750,29 @@ func (d *Data) Send(s message.Socket, roiname string, uuid dvid.UUID) error {
 	return nil
 }
 
+// TransferFilter returns a function accepting the keys of voxel blocks that Send would
+// transfer, i.e., blocks inside the named ROI if any.
+func (d *Data) TransferFilter(roiname string, uuid dvid.UUID) (func(key []byte) bool, error) {
+	var roiIterator *roi.Iterator
+	if len(roiname) != 0 {
+		versionID, err := datastore.VersionFromUUID(uuid)
+		if err != nil {
+			return nil, err
+		}
+		roiIterator, err = roi.NewIterator(dvid.DataString(roiname), versionID, d)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(key []byte) bool {
+		indexZYX, err := DecodeVoxelBlockKey(key)
+		if err != nil {
+			return false
+		}
+		return roiIterator == nil || roiIterator.InsideFast(*indexZYX)
+	}, nil
+}
+
 // ForegroundROI creates a new ROI by determining all non-background blocks.
 func (d *Data) ForegroundROI(request datastore.Request, reply *datastore.Response) error {
 	if d.Values().BytesPerElement() != 1 {
@@ -1392,6 +1953,11 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 		}
 		dvid.Debugf(addedFiles + "\n")
 
+		filenames, err = ExpandImageDirs(filenames)
+		if err != nil {
+			return err
+		}
+
 		uuid, versionID, err := datastore.MatchingUUID(uuidStr)
 		if err != nil {
 			return err
@@ -1426,6 +1992,30 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 		}
 		return d.ForegroundROI(request, reply)
 
+	case "histograms":
+		if len(request.Command) < 4 {
+			return fmt.Errorf("Poorly formatted histograms command. See command-line help.")
+		}
+		return d.ComputeHistograms(request, reply)
+
+	case "pyramid":
+		if len(request.Command) < 5 {
+			return fmt.Errorf("Poorly formatted pyramid command. See command-line help.")
+		}
+		return d.ComputePyramid(request, reply)
+
+	case "export":
+		if len(request.Command) < 6 {
+			return fmt.Errorf("Poorly formatted export command. See command-line help.")
+		}
+		return d.Export(request, reply)
+
+	case "import":
+		if len(request.Command) < 6 {
+			return fmt.Errorf("Poorly formatted import command. See command-line help.")
+		}
+		return d.Import(request, reply)
+
 	default:
 		return fmt.Errorf("Unknown command.  Data instance '%s' [%s] does not support '%s' command.",
 			d.DataName(), d.TypeName(), request.TypeCommand())
@@ -1447,9 +2037,15 @@ func debugData(img image.Image, message string) {
 	}
 }
 
+// WriteEndpoint returns true if a request modifies the data.  A POST to "flush" only
+// writes blocks already queued by earlier requests, so it's allowed on committed nodes.
+func (d *Data) WriteEndpoint(method, endpoint string) bool {
+	return method != "GET" && method != "HEAD" && endpoint != "flush"
+}
+
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	timedLog := dvid.NewTimeLog()
+	timedLog := datastore.NewTimeLog(requestCtx)
 
 	// Get repo and version ID of this request
 	repo, versions, err := datastore.FromContext(requestCtx)
@@ -1465,6 +2061,8 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		versionID = versions[0]
 	}
 	storeCtx := datastore.NewVersionedContext(d, versionID)
+	storeCtx.SetDone(requestCtx.Done())
+	storeCtx.SetDurability(datastore.DurabilityFromContext(requestCtx))
 
 	// Get the action (GET, POST)
 	action := strings.ToLower(r.Method)
@@ -1539,6 +2137,21 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		fmt.Fprintln(w, d.Help())
 		return
 
+	case "load-progress":
+		progress, found := GetLoadProgress(d)
+		if !found {
+			http.Error(w, fmt.Sprintf("No load in progress for %q", d.DataName()), http.StatusNotFound)
+			return
+		}
+		jsonBytes, err := progress.MarshalJSON()
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		return
+
 	case "metadata":
 		jsonStr, err := d.NdDataMetadata()
 		if err != nil {
@@ -1559,6 +2172,23 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		fmt.Fprintf(w, string(jsonBytes))
 		return
 
+	case "flush":
+		d.ServeFlush(w, r)
+		return
+
+	case "precomputed":
+		// GET <api URL>/node/<UUID>/<data name>/precomputed/info
+		// GET <api URL>/node/<UUID>/<data name>/precomputed/<scale key>/<chunk name>
+		d.ServePrecomputed(storeCtx, d, w, r, parts[4:], nil)
+		timedLog.Infof("HTTP %s: precomputed (%s)", r.Method, r.URL)
+		return
+
+	case "partition":
+		// GET <api URL>/node/<UUID>/<data name>/partition?n=16[&by=bytes][&roi=name]
+		d.ServePartition(storeCtx, w, r)
+		timedLog.Infof("HTTP %s: partition (%s)", r.Method, r.URL)
+		return
+
 	case "blocks":
 		// GET  <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
 		// POST <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
@@ -1576,6 +2206,39 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			server.BadRequest(w, r, err.Error())
 			return
 		}
+		if op == GetOp && len(parts) >= 8 {
+			// GET <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>/<spanY>/<spanZ>
+			spanY, err := strconv.Atoi(parts[6])
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			spanZ, err := strconv.Atoi(parts[7])
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			compress, _, err := dvid.WireCompression(r)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			if compress.Format().Lossy() && !isGrayscale8(d.Values()) {
+				server.BadRequest(w, r, "%s is only available for 8-bit grayscale data", compress.Format())
+				return
+			}
+			span3d := dvid.Point3d{int32(span), int32(spanY), int32(spanZ)}
+			w.Header().Set("Content-type", "application/octet-stream")
+			numBlocks, err := StreamBlocks(storeCtx, w, blockCoord, span3d, compress)
+			if err != nil {
+				// Can't send error status once the stream has started, so missing
+				// terminating frame signals error to client.
+				dvid.Errorf("Error streaming blocks for %s after %d blocks: %s\n", r.URL, numBlocks, err.Error())
+				return
+			}
+			timedLog.Infof("HTTP %s: %d blocks streamed (%s)", r.Method, numBlocks, r.URL)
+			return
+		}
 		if op == GetOp {
 			data, err := GetBlocks(storeCtx, blockCoord, span)
 			if err != nil {
@@ -1583,8 +2246,7 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 				return
 			}
 			w.Header().Set("Content-type", "application/octet-stream")
-			_, err = w.Write(data)
-			if err != nil {
+			if err = d.writeCompressed(data, w, r); err != nil {
 				server.BadRequest(w, r, err.Error())
 				return
 			}
@@ -1596,27 +2258,187 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		}
 		timedLog.Infof("HTTP %s: Blocks (%s)", r.Method, r.URL)
 
+	case "undo":
+		// GET  <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+		// POST <api URL>/node/<UUID>/<data name>/undo?edit=<session>
+		var result interface{}
+		if op == GetOp {
+			result, err = d.UndoRecords(storeCtx, queryValues.Get("edit"))
+		} else {
+			result, err = d.Undo(storeCtx, queryValues.Get("edit"), nil)
+		}
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(result)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: undo (%s)", r.Method, r.URL)
+
+	case "histogram":
+		// GET  <api URL>/node/<UUID>/<data name>/histogram/<size>/<offset>
+		if len(parts) < 6 {
+			server.BadRequest(w, r, "%q must be followed by size/offset", parts[3])
+			return
+		}
+		if op != GetOp {
+			server.BadRequest(w, r, "Histograms can only be retrieved via GET")
+			return
+		}
+		subvol, err := dvid.NewSubvolumeFromStrings(parts[5], parts[4], "_")
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		lowPercent, highPercent := 0.5, 99.5
+		if lowStr := queryValues.Get("low"); len(lowStr) != 0 {
+			if lowPercent, err = strconv.ParseFloat(lowStr, 64); err != nil {
+				server.BadRequest(w, r, "Bad low percentile %q: %s", lowStr, err.Error())
+				return
+			}
+		}
+		if highStr := queryValues.Get("high"); len(highStr) != 0 {
+			if highPercent, err = strconv.ParseFloat(highStr, 64); err != nil {
+				server.BadRequest(w, r, "Bad high percentile %q: %s", highStr, err.Error())
+				return
+			}
+		}
+		budget, err := server.BudgetFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		hist, numBlocks, progress, err := d.GetHistogramBudget(storeCtx, subvol, budget)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if progress != nil {
+			if err := budget.SetPartial(w, progress); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		jsonBytes, err := HistogramJSON(hist, numBlocks, lowPercent, highPercent)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: Histogram (%s)", r.Method, r.URL)
+
+	case "stats":
+		// GET  <api URL>/node/<UUID>/<data name>/stats[/<size>/<offset>][?roi=<roi name>&bins=<n>]
+		if op != GetOp {
+			server.BadRequest(w, r, "Voxel statistics can only be retrieved via GET")
+			return
+		}
+		var subvol *dvid.Subvolume
+		if len(parts) >= 6 {
+			if subvol, err = dvid.NewSubvolumeFromStrings(parts[5], parts[4], "_"); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		numBins := DefaultStatsBins
+		if binsStr := queryValues.Get("bins"); len(binsStr) != 0 {
+			if numBins, err = strconv.Atoi(binsStr); err != nil {
+				server.BadRequest(w, r, "Bad number of bins %q: %s", binsStr, err.Error())
+				return
+			}
+		}
+		release, ok := server.ThrottleOp(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		stats, err := d.GetStats(storeCtx, subvol, dvid.DataString(queryValues.Get("roi")), numBins)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		jsonBytes, err := json.Marshal(stats)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(jsonBytes))
+		timedLog.Infof("HTTP %s: Voxel statistics of %d voxels (%s)", r.Method, stats.Voxels, r.URL)
+
+	case "boxes":
+		// GET  <api URL>/node/<UUID>/<data name>/boxes/<size>/<offset>[/<size>/<offset>...]
+		if op != GetOp {
+			server.BadRequest(w, r, "Boxes can only be retrieved via GET")
+			return
+		}
+		boxStrs := parts[4:]
+		if len(boxStrs) > 0 && boxStrs[len(boxStrs)-1] == "" {
+			boxStrs = boxStrs[:len(boxStrs)-1]
+		}
+		if len(boxStrs) == 0 || len(boxStrs)%2 != 0 {
+			server.BadRequest(w, r, "%q must be followed by one or more size/offset pairs", parts[3])
+			return
+		}
+		if roiptr != nil {
+			server.BadRequest(w, r, "roi can't be used when getting boxes")
+			return
+		}
+		scale, err := ScaleFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		stride := dvid.Point3d{1, 1, 1}
+		if strideStr := queryValues.Get("stride"); strideStr != "" {
+			if stride, err = dvid.StringToStride(strideStr, "_"); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		boxes := make(dvid.MultiBox, 0, len(boxStrs)/2)
+		for i := 0; i < len(boxStrs); i += 2 {
+			subvol, err := dvid.NewSubvolumeFromStrings(boxStrs[i+1], boxStrs[i], "_")
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			box, err := dvid.NewStridedSubvolume(subvol.StartPoint().(dvid.Point3d), subvol.Size().(dvid.Point3d), stride)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+			boxes = append(boxes, box)
+		}
+		data, err := GetSampledVolume(storeCtx, d, boxes, scale)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-type", "application/octet-stream")
+		if err = d.writeCompressed(data, w, r); err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP %s: %s (%s)", r.Method, boxes, r.URL)
+
 	case "arb":
 		// GET  <api URL>/node/<UUID>/<data name>/arb/<top left>/<top right>/<bottom left>/<res>[/<format>]
 		if len(parts) < 8 {
 			server.BadRequest(w, r, "%q must be followed by top-left/top-right/bottom-left/res", parts[3])
 			return
 		}
-		queryStrings := r.URL.Query()
-		if queryStrings.Get("throttle") == "on" {
-			select {
-			case <-server.Throttle:
-				// Proceed with operation, returning throttle token to server at end.
-				defer func() {
-					server.Throttle <- 1
-				}()
-			default:
-				throttleMsg := fmt.Sprintf("Server already running maximum of %d throttled operations",
-					server.MaxThrottledOps)
-				http.Error(w, throttleMsg, http.StatusServiceUnavailable)
-				return
-			}
+		release, ok := server.ThrottleOp(w, r)
+		if !ok {
+			return
 		}
+		defer release()
 		img, err := d.GetArbitraryImage(storeCtx, parts[4], parts[5], parts[6], parts[7])
 		if err != nil {
 			server.BadRequest(w, r, err.Error())
@@ -1633,6 +2455,69 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 		}
 		timedLog.Infof("HTTP %s: Arbitrary image (%s)", r.Method, r.URL)
 
+	case "oblique":
+		// GET  <api URL>/node/<UUID>/<data name>/oblique/<size>/<center>/<normal>/<up>[/<format>]
+		if len(parts) < 8 {
+			server.BadRequest(w, r, "%q must be followed by size/center/normal/up", parts[3])
+			return
+		}
+		sizeStr, centerStr, normalStr, upStr := parts[4], parts[5], parts[6], parts[7]
+		pt, err := dvid.StringToPoint(sizeStr, "_")
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		size, ok := pt.(dvid.Point2d)
+		if !ok {
+			server.BadRequest(w, r, "oblique image size must be 2d, got %q", sizeStr)
+			return
+		}
+		var vectors [3]dvid.Vector3d
+		for i, str := range []string{centerStr, normalStr, upStr} {
+			if vectors[i], err = dvid.StringToVector3d(str, "_"); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		queryValues := r.URL.Query()
+		interp := d.defaultInterpolation()
+		if interpStr := queryValues.Get("interpolation"); interpStr != "" {
+			if interp, err = ParseInterpolation(interpStr); err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+		res := float64(d.Properties.Resolution.VoxelSize[0])
+		for _, voxelSize := range d.Properties.Resolution.VoxelSize[1:] {
+			res = math.Min(res, float64(voxelSize))
+		}
+		if resStr := queryValues.Get("res"); resStr != "" {
+			if res, err = strconv.ParseFloat(resStr, 64); err != nil {
+				server.BadRequest(w, r, "bad res %q: %s", resStr, err.Error())
+				return
+			}
+		}
+		release, ok := server.ThrottleOp(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		img, err := d.GetObliqueImage(storeCtx, vectors[0], vectors[1], vectors[2], size, res, interp)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		var formatStr string
+		if len(parts) >= 9 {
+			formatStr = parts[8]
+		}
+		err = dvid.WriteImageHttp(w, img.Get(), formatStr)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		timedLog.Infof("HTTP %s: Oblique %s image (%s)", r.Method, interp, r.URL)
+
 	case "raw", "isotropic":
 		// GET  <api URL>/node/<UUID>/<data name>/isotropic/<dims>/<size>/<offset>[/<format>]
 		if len(parts) < 7 {
@@ -1640,6 +2525,15 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 			return
 		}
 		var isotropic bool = (parts[3] == "isotropic")
+		scale, err := ScaleFromQuery(r)
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return
+		}
+		if scale != 0 && op == PutOp {
+			server.BadRequest(w, r, "scale levels are computed by the pyramid command and cannot be POSTed")
+			return
+		}
 		shapeStr, sizeStr, offsetStr := parts[4], parts[5], parts[6]
 		planeStr := dvid.DataShapeString(shapeStr)
 		plane, err := planeStr.DataShape()
@@ -1678,7 +2572,7 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 						return
 					}
 				}
-				err = PutVoxels(storeCtx, d, e, OpOptions{roi: roiptr})
+				err = PutVoxels(storeCtx, d, e, OpOptions{roi: roiptr, editSession: queryValues.Get("edit")})
 				if err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
@@ -1702,7 +2596,7 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 						return
 					}
 				}
-				img, err := GetImage(storeCtx, d, e, roiptr)
+				img, err := GetScaledImage(storeCtx, d, e, roiptr, scale)
 				if err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
@@ -1725,54 +2619,75 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 					server.BadRequest(w, r, err.Error())
 					return
 				}
+				if scale == 0 && roiptr == nil {
+					d.prefetchViews(requestCtx, r, versionID, slice, isotropic)
+				}
 			}
 			timedLog.Infof("HTTP %s: %s (%s)", r.Method, plane, r.URL)
 		case 3:
-			queryStrings := r.URL.Query()
-			if queryStrings.Get("throttle") == "on" {
-				select {
-				case <-server.Throttle:
-					// Proceed with operation, returning throttle token to server at end.
-					defer func() {
-						server.Throttle <- 1
-					}()
-				default:
-					throttleMsg := fmt.Sprintf("Server already running maximum of %d throttled operations",
-						server.MaxThrottledOps)
-					http.Error(w, throttleMsg, http.StatusServiceUnavailable)
-					return
-				}
+			release, ok := server.ThrottleOp(w, r)
+			if !ok {
+				return
 			}
+			defer release()
 			subvol, err := dvid.NewSubvolumeFromStrings(offsetStr, sizeStr, "_")
 			if err != nil {
 				server.BadRequest(w, r, err.Error())
 				return
 			}
+			margin, err := MarginFromQuery(r)
+			if err != nil {
+				server.BadRequest(w, r, err.Error())
+				return
+			}
 			if op == GetOp {
-				e, err := d.NewExtHandler(subvol, nil)
-				if err != nil {
+				if subvol, err = d.ExtendSubvolume(subvol, margin, scale); err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
 				}
-				if roiptr != nil {
-					roiptr.Iter, err = roi.NewIterator(roiname, versionID, e)
+				if strideStr := queryValues.Get("stride"); strideStr != "" {
+					if roiptr != nil {
+						server.BadRequest(w, r, "stride can't be used with roi")
+						return
+					}
+					stride, err := dvid.StringToStride(strideStr, "_")
 					if err != nil {
 						server.BadRequest(w, r, err.Error())
 						return
 					}
-				}
-				data, err := GetVolume(storeCtx, d, e, roiptr)
-				if err != nil {
-					server.BadRequest(w, r, err.Error())
+					strided, err := dvid.NewStridedSubvolume(subvol.StartPoint().(dvid.Point3d), subvol.Size().(dvid.Point3d), stride)
+					if err != nil {
+						server.BadRequest(w, r, err.Error())
+						return
+					}
+					data, err := GetSampledVolume(storeCtx, d, dvid.MultiBox{strided}, scale)
+					if err != nil {
+						server.BadRequest(w, r, err.Error())
+						return
+					}
+					SetSubvolumeHeaders(w, subvol)
+					w.Header().Set("X-Dvid-Stride", fmt.Sprintf("%d_%d_%d", stride[0], stride[1], stride[2]))
+					w.Header().Set("Content-type", "application/octet-stream")
+					if err = d.writeCompressed(data, w, r); err != nil {
+						server.BadRequest(w, r, err.Error())
+						return
+					}
+					timedLog.Infof("HTTP %s: %s (%s)", r.Method, strided, r.URL)
 					return
 				}
-				w.Header().Set("Content-type", "application/octet-stream")
-				_, err = w.Write(data)
-				if err != nil {
+				if err = WriteSubvolume(storeCtx, d, subvol, roiname, roiptr, scale, w, r); err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
 				}
 			} else {
+				if margin != (dvid.Point3d{}) {
+					server.BadRequest(w, r, "margin can only be used when getting subvolumes")
+					return
+				}
+				if queryValues.Get("stride") != "" {
+					server.BadRequest(w, r, "stride can only be used when getting subvolumes")
+					return
+				}
 				if isotropic {
 					err := fmt.Errorf("can only PUT 'raw' not 'isotropic' images")
 					server.BadRequest(w, r, err.Error())
@@ -1795,7 +2710,7 @@ func (d *Data) ServeHTTP(requestCtx context.Context, w http.ResponseWriter, r *h
 						return
 					}
 				}
-				err = PutVoxels(storeCtx, d, e, OpOptions{roi: roiptr})
+				err = PutVoxels(storeCtx, d, e, OpOptions{roi: roiptr, editSession: queryValues.Get("edit")})
 				if err != nil {
 					server.BadRequest(w, r, err.Error())
 					return
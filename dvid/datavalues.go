@@ -70,32 +70,36 @@ func (dv DataValue) ValueBytes() int32 {
 	return typeBytes[dv.T]
 }
 
-// MarshalJSON implements the json.Marshaler interface.
-func (dv DataValue) MarshalJSON() ([]byte, error) {
-	var dataType string
-	switch dv.T {
+// String returns the name of the data type, e.g., "uint16".
+func (t DataType) String() string {
+	switch t {
 	case T_uint8:
-		dataType = "uint8"
+		return "uint8"
 	case T_int8:
-		dataType = "int8"
+		return "int8"
 	case T_uint16:
-		dataType = "uint16"
+		return "uint16"
 	case T_int16:
-		dataType = "int16"
+		return "int16"
 	case T_uint32:
-		dataType = "uint32"
+		return "uint32"
 	case T_int32:
-		dataType = "int32"
+		return "int32"
 	case T_uint64:
-		dataType = "uint64"
+		return "uint64"
 	case T_int64:
-		dataType = "int64"
+		return "int64"
 	case T_float32:
-		dataType = "float32"
+		return "float32"
 	case T_float64:
-		dataType = "float64"
+		return "float64"
 	}
-	return []byte(fmt.Sprintf(`{"DataType":%q,"Label":%q}`, dataType, dv.Label)), nil
+	return ""
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (dv DataValue) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"DataType":%q,"Label":%q}`, dv.T, dv.Label)), nil
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
@@ -2,6 +2,7 @@ package dvid
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -507,3 +508,189 @@ func (s OrthogSlice) EndPoint() Point {
 func (s OrthogSlice) String() string {
 	return fmt.Sprintf("%s @ offset %s, size %s", s.shape, s.offset, s.size)
 }
+
+// StridedSubvolume is a 3d box Geometry sampled every Nth voxel along each axis, e.g.,
+// for downsampled previews or sparse sampling without transferring full resolution
+// data.  StartPoint and EndPoint give the first and last sampled voxels, while Size
+// gives the number of sampled voxels along each axis.  A stride of 1 along every axis
+// samples all voxels like a Subvolume.
+type StridedSubvolume struct {
+	offset Point3d
+	size   Point3d // number of sampled voxels along each axis
+	stride Point3d
+}
+
+// NewStridedSubvolume returns a StridedSubvolume sampling every stride voxels of the box
+// with the given offset and full resolution size.
+func NewStridedSubvolume(offset, size, stride Point3d) (*StridedSubvolume, error) {
+	var sampled Point3d
+	for dim := 0; dim < 3; dim++ {
+		if size[dim] < 1 {
+			return nil, fmt.Errorf("Size %s must be positive along each axis", size)
+		}
+		if stride[dim] < 1 {
+			return nil, fmt.Errorf("Stride %s must be positive along each axis", stride)
+		}
+		sampled[dim] = (size[dim]-1)/stride[dim] + 1
+	}
+	return &StridedSubvolume{offset, sampled, stride}, nil
+}
+
+// NewStridedSubvolumeFromStrings returns a StridedSubvolume given string representations
+// of offset ("0,10,20"), full resolution size ("250,250,250"), and stride, which can
+// be a single value for all axes ("4") or a value for each axis ("4,4,1").
+func NewStridedSubvolumeFromStrings(offsetStr, sizeStr, strideStr, sep string) (*StridedSubvolume, error) {
+	subvol, err := NewSubvolumeFromStrings(offsetStr, sizeStr, sep)
+	if err != nil {
+		return nil, err
+	}
+	stride, err := StringToStride(strideStr, sep)
+	if err != nil {
+		return nil, err
+	}
+	return NewStridedSubvolume(subvol.offset.(Point3d), subvol.size.(Point3d), stride)
+}
+
+// StringToStride parses a stride given as a single value for all axes or as a value for
+// each axis separated by sep.
+func StringToStride(str, sep string) (Point3d, error) {
+	var stride Point3d
+	elems := strings.Split(str, sep)
+	if len(elems) != 1 && len(elems) != 3 {
+		return stride, fmt.Errorf("Stride %q must be a single value or 3 values separated by %q", str, sep)
+	}
+	for dim := 0; dim < 3; dim++ {
+		elem := elems[0]
+		if len(elems) == 3 {
+			elem = elems[dim]
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(elem), 10, 32)
+		if err != nil {
+			return stride, fmt.Errorf("Bad stride %q: %s", str, err.Error())
+		}
+		if value < 1 {
+			return stride, fmt.Errorf("Stride %q must be positive along each axis", str)
+		}
+		stride[dim] = int32(value)
+	}
+	return stride, nil
+}
+
+// Stride returns the distance in voxels between samples along each axis.
+func (s *StridedSubvolume) Stride() Point3d {
+	return s.stride
+}
+
+// Strided returns true if any voxels of the box are skipped.
+func (s *StridedSubvolume) Strided() bool {
+	return s.stride != Point3d{1, 1, 1}
+}
+
+// SampleRange returns the indices [begin, end) of the samples along the given axis that
+// lie within the inclusive coordinate range [min, max], e.g., the extent of a block.
+// The range is empty if begin >= end.
+func (s *StridedSubvolume) SampleRange(dim uint8, min, max int32) (begin, end int32) {
+	offset, stride := int64(s.offset[dim]), int64(s.stride[dim])
+	lo := int64(min) - offset
+	if lo < 0 {
+		lo = 0
+	}
+	hi := int64(max) - offset
+	if hi < 0 {
+		return 0, 0
+	}
+	begin64 := (lo + stride - 1) / stride
+	end64 := hi/stride + 1
+	if size := int64(s.size[dim]); end64 > size {
+		end64 = size
+	}
+	if begin64 >= end64 {
+		return 0, 0
+	}
+	return int32(begin64), int32(end64)
+}
+
+func (s *StridedSubvolume) DataShape() DataShape {
+	return Vol3d
+}
+
+func (s *StridedSubvolume) Size() Point {
+	return s.size
+}
+
+func (s *StridedSubvolume) NumVoxels() int64 {
+	return int64(s.size[0]) * int64(s.size[1]) * int64(s.size[2])
+}
+
+func (s *StridedSubvolume) StartPoint() Point {
+	return s.offset
+}
+
+func (s *StridedSubvolume) EndPoint() Point {
+	var end Point3d
+	for dim := 0; dim < 3; dim++ {
+		end[dim] = s.offset[dim] + (s.size[dim]-1)*s.stride[dim]
+	}
+	return end
+}
+
+func (s *StridedSubvolume) String() string {
+	return fmt.Sprintf("%s %s at offset %s with stride %s", Vol3d, s.size, s.offset, s.stride)
+}
+
+// MultiBox is a Geometry made of possibly non-contiguous and strided 3d boxes that are
+// requested together, so blocks shared by boxes need only be read once.  StartPoint,
+// EndPoint, and Size describe the bounding box of all boxes, while NumVoxels is the
+// total number of sampled voxels in the boxes.
+type MultiBox []*StridedSubvolume
+
+func (m MultiBox) DataShape() DataShape {
+	return Vol3d
+}
+
+func (m MultiBox) StartPoint() Point {
+	if len(m) == 0 {
+		return Point3d{}
+	}
+	start := m[0].offset
+	for _, box := range m[1:] {
+		for dim := 0; dim < 3; dim++ {
+			if box.offset[dim] < start[dim] {
+				start[dim] = box.offset[dim]
+			}
+		}
+	}
+	return start
+}
+
+func (m MultiBox) EndPoint() Point {
+	if len(m) == 0 {
+		return Point3d{-1, -1, -1}
+	}
+	end := m[0].EndPoint().(Point3d)
+	for _, box := range m[1:] {
+		boxEnd := box.EndPoint().(Point3d)
+		for dim := 0; dim < 3; dim++ {
+			if boxEnd[dim] > end[dim] {
+				end[dim] = boxEnd[dim]
+			}
+		}
+	}
+	return end
+}
+
+func (m MultiBox) Size() Point {
+	return m.EndPoint().(Point3d).Sub(m.StartPoint()).Add(Point3d{1, 1, 1})
+}
+
+func (m MultiBox) NumVoxels() int64 {
+	var voxels int64
+	for _, box := range m {
+		voxels += box.NumVoxels()
+	}
+	return voxels
+}
+
+func (m MultiBox) String() string {
+	return fmt.Sprintf("%d boxes within %s %s at offset %s", len(m), Vol3d, m.Size(), m.StartPoint())
+}
@@ -0,0 +1,47 @@
+package dvid
+
+import . "github.com/janelia-flyem/go/gocheck"
+
+func (s *DataSuite) TestStridedSubvolume(c *C) {
+	box, err := NewStridedSubvolume(Point3d{10, 20, 30}, Point3d{100, 50, 8}, Point3d{4, 4, 1})
+	c.Assert(err, IsNil)
+	c.Assert(box.Size(), Equals, Point3d{25, 13, 8})
+	c.Assert(box.NumVoxels(), Equals, int64(25*13*8))
+	c.Assert(box.EndPoint(), Equals, Point3d{106, 68, 37})
+	c.Assert(box.Strided(), Equals, true)
+
+	// Samples along x are at 10, 14, ..., 106.
+	begin, end := box.SampleRange(0, 0, 31)
+	c.Assert(begin, Equals, int32(0))
+	c.Assert(end, Equals, int32(6))
+	begin, end = box.SampleRange(0, 32, 63)
+	c.Assert(begin, Equals, int32(6))
+	c.Assert(end, Equals, int32(14))
+	begin, end = box.SampleRange(0, 107, 200)
+	c.Assert(begin >= end, Equals, true)
+	begin, end = box.SampleRange(0, 11, 13)
+	c.Assert(begin >= end, Equals, true)
+
+	_, err = NewStridedSubvolume(Point3d{0, 0, 0}, Point3d{10, 10, 10}, Point3d{0, 1, 1})
+	c.Assert(err, NotNil)
+
+	box, err = NewStridedSubvolumeFromStrings("0_0_0", "64_64_64", "3", "_")
+	c.Assert(err, IsNil)
+	c.Assert(box.Stride(), Equals, Point3d{3, 3, 3})
+	c.Assert(box.Size(), Equals, Point3d{22, 22, 22})
+
+	_, err = StringToStride("2_2", "_")
+	c.Assert(err, NotNil)
+}
+
+func (s *DataSuite) TestMultiBox(c *C) {
+	box1, err := NewStridedSubvolume(Point3d{0, 0, 100}, Point3d{64, 64, 64}, Point3d{1, 1, 1})
+	c.Assert(err, IsNil)
+	box2, err := NewStridedSubvolume(Point3d{1024, -512, 300}, Point3d{10, 10, 10}, Point3d{2, 2, 2})
+	c.Assert(err, IsNil)
+	boxes := MultiBox{box1, box2}
+	c.Assert(boxes.StartPoint(), Equals, Point3d{0, -512, 100})
+	c.Assert(boxes.EndPoint(), Equals, Point3d{1032, 63, 308})
+	c.Assert(boxes.Size(), Equals, Point3d{1033, 576, 209})
+	c.Assert(boxes.NumVoxels(), Equals, int64(64*64*64+5*5*5))
+}
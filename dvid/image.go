@@ -1277,6 +1277,10 @@ func ImageData(img image.Image) (data []uint8, bytesPerPixel, stride int32, err
 		data = typedImg.Pix
 		stride = int32(typedImg.Stride)
 		bytesPerPixel = 8
+	case *image.RGBA64:
+		data = typedImg.Pix
+		stride = int32(typedImg.Stride)
+		bytesPerPixel = 8
 	default:
 		err = fmt.Errorf("Illegal image type called ImageData(): %T", typedImg)
 	}
@@ -1,6 +1,15 @@
 package dvid
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 type ModeFlag uint
 
@@ -13,6 +22,85 @@ const (
 	SilentMode
 )
 
+var modeNames = []string{"debug", "info", "warning", "error", "critical", "silent"}
+
+func (m ModeFlag) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("unknown level %d", m)
+}
+
+// MarshalText fulfills the encoding.TextMarshaler interface so levels are readable in JSON.
+func (m ModeFlag) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText fulfills the encoding.TextUnmarshaler interface.
+func (m *ModeFlag) UnmarshalText(text []byte) error {
+	level, err := ParseLogLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*m = level
+	return nil
+}
+
+// ParseLogLevel returns the level with the given name, e.g., "debug" or "warning".
+func ParseLogLevel(s string) (ModeFlag, error) {
+	for i, name := range modeNames {
+		if strings.ToLower(s) == name {
+			return ModeFlag(i), nil
+		}
+	}
+	return SilentMode, fmt.Errorf("Unknown log level %q: must be one of %s", s, strings.Join(modeNames, ", "))
+}
+
+// LogFormat is how log messages are written.
+type LogFormat uint8
+
+const (
+	// TextLog writes a severity prefix and the message, optionally followed by fields.
+	TextLog LogFormat = iota
+
+	// JSONLog writes one JSON object per message.
+	JSONLog
+
+	// LogfmtLog writes one line of key=value pairs per message.
+	LogfmtLog
+)
+
+func (f LogFormat) String() string {
+	switch f {
+	case JSONLog:
+		return "json"
+	case LogfmtLog:
+		return "logfmt"
+	default:
+		return "text"
+	}
+}
+
+// MarshalText fulfills the encoding.TextMarshaler interface.
+func (f LogFormat) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// ParseLogFormat returns the format with the given name: "text" (or empty), "json", or
+// "logfmt".
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "text":
+		return TextLog, nil
+	case "json":
+		return JSONLog, nil
+	case "logfmt":
+		return LogfmtLog, nil
+	default:
+		return TextLog, fmt.Errorf("Unknown log format %q: must be text, json, or logfmt", s)
+	}
+}
+
 var (
 	// Verbose is set when we want to be exceptionally verbose.
 	Verbose bool
@@ -21,6 +109,12 @@ var (
 	mode ModeFlag
 )
 
+// LogField is a key and value added to a structured log message.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
 // Logger provides a way for the application to log messages at different severities.
 // Implementations will vary if the app is in the cloud or on a local server.
 type Logger interface {
@@ -45,44 +139,250 @@ type Logger interface {
 	Shutdown()
 }
 
+// StructuredLogger is implemented by loggers that record the module, i.e., the DVID
+// package, and fields of a message, e.g., as JSON.
+type StructuredLogger interface {
+	Log(level ModeFlag, module string, fields []LogField, msg string)
+}
+
 // package print functions use the default package-level logger initialized
 // with newLogger() or is simply nil and uses unmodified standard log package.
 
 // SetLogMode sets the severity required for a log message to be printed.
 // For example, SetMode(dvid.WarningMode) will log any calls using
 // Warningf, Errorf, or Criticalf.  To turn off all logging, use SilentMode.
+// Modules with their own levels are unaffected.
 func SetLogMode(newMode ModeFlag) {
 	mode = newMode
 }
 
-func Debugf(format string, args ...interface{}) {
-	if mode <= DebugMode {
-		logger.Debugf(format, args...)
+// LogMode returns the severity required for messages of modules without their own level.
+func LogMode() ModeFlag {
+	return mode
+}
+
+// moduleLevels holds the levels of modules, e.g., "datatype/labels64" or "storage", that
+// override the global mode.  A module's level also applies to modules below it.
+var moduleLevels = struct {
+	sync.RWMutex
+	m map[string]ModeFlag
+}{m: make(map[string]ModeFlag)}
+
+// SetModuleLogLevel sets the severity required for messages logged by the given module,
+// which is the path of a DVID package, e.g., "datatype/labels64", or a parent path like
+// "datatype".
+func SetModuleLogLevel(module string, level ModeFlag) {
+	moduleLevels.Lock()
+	moduleLevels.m[strings.Trim(module, "/")] = level
+	moduleLevels.Unlock()
+}
+
+// ClearModuleLogLevel makes a module use the level of its parent or the global mode.
+func ClearModuleLogLevel(module string) {
+	moduleLevels.Lock()
+	delete(moduleLevels.m, strings.Trim(module, "/"))
+	moduleLevels.Unlock()
+}
+
+// ModuleLogLevels returns the modules with their own levels.
+func ModuleLogLevels() map[string]ModeFlag {
+	moduleLevels.RLock()
+	defer moduleLevels.RUnlock()
+	levels := make(map[string]ModeFlag, len(moduleLevels.m))
+	for module, level := range moduleLevels.m {
+		levels[module] = level
 	}
+	return levels
 }
 
-func Infof(format string, args ...interface{}) {
-	if mode <= InfoMode {
-		logger.Infof(format, args...)
+// moduleLevel returns the level of a module, which is the level set for the module or
+// its closest parent, or the global mode.  Must be called with moduleLevels locked.
+func moduleLevel(module string) ModeFlag {
+	for {
+		if level, found := moduleLevels.m[module]; found {
+			return level
+		}
+		i := strings.LastIndex(module, "/")
+		if i < 0 {
+			return mode
+		}
+		module = module[:i]
 	}
 }
 
-func Warningf(format string, args ...interface{}) {
-	if mode <= WarningMode {
-		logger.Warningf(format, args...)
+// The import path prefix trimmed from DVID packages to get their module.
+const modulePrefix = "github.com/janelia-flyem/dvid/"
+
+var callerModules = struct {
+	sync.RWMutex
+	m map[uintptr]string
+}{m: make(map[uintptr]string)}
+
+// callerModule returns the module of the function the given number of frames up the
+// stack, e.g., "datatype/labels64".
+func callerModule(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	callerModules.RLock()
+	module, found := callerModules.m[pc]
+	callerModules.RUnlock()
+	if found {
+		return module
 	}
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		module = packageOfFunc(fn.Name())
+	}
+	callerModules.Lock()
+	callerModules.m[pc] = module
+	callerModules.Unlock()
+	return module
 }
 
-func Errorf(format string, args ...interface{}) {
-	if mode <= ErrorMode {
+// packageOfFunc returns the module of a fully qualified function name like
+// "github.com/janelia-flyem/dvid/datatype/labels64.(*Data).ServeHTTP".
+func packageOfFunc(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		name = name[:slash+1+dot]
+	}
+	return strings.TrimPrefix(name, modulePrefix)
+}
+
+var (
+	// logFormat is the format used by loggers that fulfill StructuredLogger.
+	logFormat LogFormat
+
+	// logStructured is true if the logger records modules, so they must always be found.
+	logStructured bool
+)
+
+// CurrentLogFormat returns the format of log messages.
+func CurrentLogFormat() LogFormat {
+	return logFormat
+}
+
+// logAt logs a message if the level is enabled for the module of the function the given
+// number of frames up the stack.
+func logAt(level ModeFlag, skip int, fields []LogField, format string, args ...interface{}) {
+	moduleLevels.RLock()
+	overrides := len(moduleLevels.m) != 0
+	moduleLevels.RUnlock()
+	if !overrides && level < mode {
+		return
+	}
+	var module string
+	if overrides || logStructured {
+		module = callerModule(skip + 1)
+	}
+	if overrides {
+		moduleLevels.RLock()
+		required := moduleLevel(module)
+		moduleLevels.RUnlock()
+		if level < required {
+			return
+		}
+	}
+	var l interface{} = logger
+	if sl, ok := l.(StructuredLogger); ok {
+		sl.Log(level, module, fields, fmt.Sprintf(format, args...))
+		return
+	}
+	switch level {
+	case DebugMode:
+		logger.Debugf(format, args...)
+	case InfoMode:
+		logger.Infof(format, args...)
+	case WarningMode:
+		logger.Warningf(format, args...)
+	case ErrorMode:
 		logger.Errorf(format, args...)
+	default:
+		logger.Criticalf(format, args...)
 	}
 }
 
+func Debugf(format string, args ...interface{}) {
+	logAt(DebugMode, 1, nil, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	logAt(InfoMode, 1, nil, format, args...)
+}
+
+func Warningf(format string, args ...interface{}) {
+	logAt(WarningMode, 1, nil, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	logAt(ErrorMode, 1, nil, format, args...)
+}
+
 func Criticalf(format string, args ...interface{}) {
-	if mode <= CriticalMode {
-		logger.Criticalf(format, args...)
+	logAt(CriticalMode, 1, nil, format, args...)
+}
+
+// formatLogLine returns a log message as a line in the given format.  Text lines don't
+// include the time or module since the standard log package adds the time.
+func formatLogLine(format LogFormat, t time.Time, level ModeFlag, module string, fields []LogField, msg string) []byte {
+	msg = strings.TrimRight(msg, "\n")
+	var buf bytes.Buffer
+	switch format {
+	case JSONLog:
+		// Fields are written in order after the standard keys.
+		buf.WriteString(`{"time":`)
+		writeJSONValue(&buf, t.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSONValue(&buf, level.String())
+		if module != "" {
+			buf.WriteString(`,"module":`)
+			writeJSONValue(&buf, module)
+		}
+		buf.WriteString(`,"msg":`)
+		writeJSONValue(&buf, msg)
+		for _, field := range fields {
+			buf.WriteByte(',')
+			writeJSONValue(&buf, field.Key)
+			buf.WriteByte(':')
+			writeJSONValue(&buf, field.Value)
+		}
+		buf.WriteByte('}')
+	case LogfmtLog:
+		fmt.Fprintf(&buf, "time=%s level=%s", t.Format(time.RFC3339Nano), level)
+		if module != "" {
+			buf.WriteString(" module=")
+			buf.WriteString(logfmtValue(module))
+		}
+		buf.WriteString(" msg=")
+		buf.WriteString(logfmtValue(msg))
+		for _, field := range fields {
+			fmt.Fprintf(&buf, " %s=%s", field.Key, logfmtValue(fmt.Sprint(field.Value)))
+		}
+	default:
+		fmt.Fprintf(&buf, "%8s %s", strings.ToUpper(level.String()), msg)
+		for _, field := range fields {
+			fmt.Fprintf(&buf, " %s=%s", field.Key, logfmtValue(fmt.Sprint(field.Value)))
+		}
 	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// logfmtValue quotes values with spaces, quotes, or equal signs.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
 }
 
 // TimeLog adds elapsed time to logging.
@@ -93,40 +393,39 @@ func Criticalf(format string, args ...interface{}) {
 type TimeLog struct {
 	logger Logger
 	start  time.Time
+	fields []LogField
 }
 
 func NewTimeLog() TimeLog {
-	return TimeLog{logger, time.Now()}
+	return TimeLog{logger, time.Now(), nil}
+}
+
+// With returns a TimeLog that adds a field, e.g., a request ID, to each message.
+func (t TimeLog) With(key string, value interface{}) TimeLog {
+	fields := make([]LogField, len(t.fields), len(t.fields)+1)
+	copy(fields, t.fields)
+	t.fields = append(fields, LogField{key, value})
+	return t
 }
 
 func (t TimeLog) Debugf(format string, args ...interface{}) {
-	if mode <= DebugMode {
-		t.logger.Debugf(format+": %s\n", append(args, time.Since(t.start))...)
-	}
+	logAt(DebugMode, 1, t.fields, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Infof(format string, args ...interface{}) {
-	if mode <= InfoMode {
-		t.logger.Infof(format+": %s\n", append(args, time.Since(t.start))...)
-	}
+	logAt(InfoMode, 1, t.fields, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Warningf(format string, args ...interface{}) {
-	if mode <= WarningMode {
-		t.logger.Warningf(format+": %s\n", append(args, time.Since(t.start))...)
-	}
+	logAt(WarningMode, 1, t.fields, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Errorf(format string, args ...interface{}) {
-	if mode <= ErrorMode {
-		t.logger.Errorf(format+": %s\n", append(args, time.Since(t.start))...)
-	}
+	logAt(ErrorMode, 1, t.fields, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Criticalf(format string, args ...interface{}) {
-	if mode <= CriticalMode {
-		t.logger.Criticalf(format+": %s\n", append(args, time.Since(t.start))...)
-	}
+	logAt(CriticalMode, 1, t.fields, format+": %s\n", append(args, time.Since(t.start))...)
 }
 
 func (t TimeLog) Shutdown() {
@@ -53,5 +53,5 @@ func (glog gcloudLogger) Criticalf(format string, args ...interface{}) {
 //     ...
 //     mylog.Debugf("stuff happened")  // Appends elapsed time from NewTimeLog() to message.
 func (glog gcloudLogger) NewTimeLog() TimeLog {
-	return TimeLog{glog, time.Now()}
+	return TimeLog{glog, time.Now(), nil}
 }
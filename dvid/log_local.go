@@ -4,67 +4,157 @@ package dvid
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"sync"
+	"time"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type stdLogger struct {
 	*lumberjack.Logger
+
+	// If non-nil, Error and Critical messages are also written to this rotating file.
+	errorLog *lumberjack.Logger
 }
 
-var logger stdLogger
+var (
+	logger stdLogger
+
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr
+)
 
 type LogConfig struct {
-	Logfile string
-	MaxSize int `toml:"max_log_size"`
-	MaxAge  int `toml:"max_log_age"`
+	Logfile    string
+	MaxSize    int `toml:"max_log_size"`
+	MaxAge     int `toml:"max_log_age"`
+	MaxBackups int `toml:"max_log_backups"`
+
+	// ErrorLogfile, if given, receives a copy of Error and Critical messages and is
+	// rotated like the main log file.
+	ErrorLogfile string `toml:"error_logfile"`
+
+	// Format is "text" (default), "json", or "logfmt".
+	Format string
+
+	// Level is the severity required for messages to be logged, e.g., "info".
+	Level string
+
+	// Levels gives the levels of modules, e.g., {"datatype/labels64" = "debug"}.
+	Levels map[string]string
 }
 
-// SetLogger creates a logger that saves to a rotating log file.
-func (c *LogConfig) SetLogger() {
+// SetLogger creates a logger that saves to a rotating log file.  A nil configuration
+// sends text messages to stderr.
+func (c *LogConfig) SetLogger() error {
+	if c == nil {
+		return nil
+	}
+	format, err := ParseLogFormat(c.Format)
+	if err != nil {
+		return err
+	}
+	if c.Level != "" {
+		level, err := ParseLogLevel(c.Level)
+		if err != nil {
+			return err
+		}
+		SetLogMode(level)
+	}
+	for module, levelStr := range c.Levels {
+		level, err := ParseLogLevel(levelStr)
+		if err != nil {
+			return fmt.Errorf("Bad level for log module %q: %s", module, err.Error())
+		}
+		SetModuleLogLevel(module, level)
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	logFormat = format
+	logStructured = format != TextLog
+	if c.ErrorLogfile != "" {
+		fmt.Printf("Sending error log messages to: %s\n", c.ErrorLogfile)
+		logger.errorLog = &lumberjack.Logger{
+			Filename:   c.ErrorLogfile,
+			MaxSize:    c.MaxSize,
+			MaxAge:     c.MaxAge,
+			MaxBackups: c.MaxBackups,
+		}
+	}
 	if c.Logfile == "" {
 		fmt.Println("Sending log messages to stdout since no log file specified.")
-		return
+		return nil
 	}
 	fmt.Printf("Sending log messages to: %s\n", c.Logfile)
-	l := &lumberjack.Logger{
-		Filename: c.Logfile,
-		MaxSize:  c.MaxSize, // megabytes
-		MaxAge:   c.MaxAge,  //days
+	logger.Logger = &lumberjack.Logger{
+		Filename:   c.Logfile,
+		MaxSize:    c.MaxSize, // megabytes
+		MaxAge:     c.MaxAge,  //days
+		MaxBackups: c.MaxBackups,
 	}
-	log.SetOutput(l)
+	logOutput = logger.Logger
+	log.SetOutput(logger.Logger)
+	return nil
 }
 
 // --- Logger implementation ----
 
+// Log writes a message in the configured format, fulfilling the StructuredLogger
+// interface.
+func (slog stdLogger) Log(level ModeFlag, module string, fields []LogField, msg string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	now := time.Now()
+	line := formatLogLine(logFormat, now, level, module, fields, msg)
+	if logFormat == TextLog {
+		log.Print(string(line))
+	} else {
+		logOutput.Write(line)
+	}
+	if slog.errorLog != nil && level >= ErrorMode {
+		if logFormat == TextLog {
+			line = append([]byte(now.Format("2006/01/02 15:04:05 ")), line...)
+		}
+		slog.errorLog.Write(line)
+	}
+}
+
 // Debugf formats its arguments analogous to fmt.Printf and records the text as a log
 // message at Debug level.  If dvid.Verbose is not true, these logs aren't written.
 func (slog stdLogger) Debugf(format string, args ...interface{}) {
-	log.Printf("   DEBUG "+format, args...)
+	slog.Log(DebugMode, "", nil, fmt.Sprintf(format, args...))
 }
 
 // Infof is like Debugf, but at Info level and will be written regardless if not in
 // verbose mode.
 func (slog stdLogger) Infof(format string, args ...interface{}) {
-	log.Printf("    INFO "+format, args...)
+	slog.Log(InfoMode, "", nil, fmt.Sprintf(format, args...))
 }
 
 // Warningf is like Debugf, but at Warning level.
 func (slog stdLogger) Warningf(format string, args ...interface{}) {
-	log.Printf(" WARNING "+format, args...)
+	slog.Log(WarningMode, "", nil, fmt.Sprintf(format, args...))
 }
 
 // Errorf is like Debugf, but at Error level.
 func (slog stdLogger) Errorf(format string, args ...interface{}) {
-	log.Printf("  ERROR "+format, args...)
+	slog.Log(ErrorMode, "", nil, fmt.Sprintf(format, args...))
 }
 
 // Criticalf is like Debugf, but at Critical level.
 func (slog stdLogger) Criticalf(format string, args ...interface{}) {
-	log.Printf("CRITICAL "+format, args...)
+	slog.Log(CriticalMode, "", nil, fmt.Sprintf(format, args...))
 }
 
 func (slog stdLogger) Shutdown() {
-	slog.Rotate()
+	if slog.Logger != nil {
+		slog.Rotate()
+	}
+	if slog.errorLog != nil {
+		slog.errorLog.Rotate()
+	}
 }
@@ -0,0 +1,60 @@
+package dvid
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/janelia-flyem/go/gocheck"
+)
+
+func (s *DataSuite) TestLogFormats(c *C) {
+	t := time.Date(2015, 3, 4, 5, 6, 7, 0, time.UTC)
+	fields := []LogField{{"request", "host/abc-000001"}, {"bytes", 42}}
+
+	line := formatLogLine(TextLog, t, InfoMode, "datatype/labels64", fields, "got block\n")
+	c.Assert(string(line), Equals, "    INFO got block request=host/abc-000001 bytes=42\n")
+
+	line = formatLogLine(LogfmtLog, t, WarningMode, "storage", fields, "slow get")
+	c.Assert(string(line), Equals,
+		"time=2015-03-04T05:06:07Z level=warning module=storage msg=\"slow get\" request=host/abc-000001 bytes=42\n")
+
+	line = formatLogLine(JSONLog, t, ErrorMode, "server", fields, `bad "uuid"`)
+	var parsed map[string]interface{}
+	c.Assert(json.Unmarshal(line, &parsed), IsNil)
+	c.Assert(parsed["level"], Equals, "error")
+	c.Assert(parsed["module"], Equals, "server")
+	c.Assert(parsed["msg"], Equals, `bad "uuid"`)
+	c.Assert(parsed["bytes"], Equals, float64(42))
+}
+
+func (s *DataSuite) TestModuleLogLevels(c *C) {
+	oldMode := mode
+	defer SetLogMode(oldMode)
+	SetLogMode(WarningMode)
+
+	SetModuleLogLevel("datatype", InfoMode)
+	SetModuleLogLevel("/datatype/labels64/", DebugMode)
+	defer ClearModuleLogLevel("datatype")
+	defer ClearModuleLogLevel("datatype/labels64")
+
+	moduleLevels.RLock()
+	c.Assert(moduleLevel("datatype/labels64"), Equals, DebugMode)
+	c.Assert(moduleLevel("datatype/roi"), Equals, InfoMode)
+	c.Assert(moduleLevel("storage/basholeveldb"), Equals, WarningMode)
+	moduleLevels.RUnlock()
+
+	ClearModuleLogLevel("datatype/labels64")
+	moduleLevels.RLock()
+	c.Assert(moduleLevel("datatype/labels64"), Equals, InfoMode)
+	moduleLevels.RUnlock()
+
+	c.Assert(packageOfFunc("github.com/janelia-flyem/dvid/datatype/labels64.(*Data).ServeHTTP"),
+		Equals, "datatype/labels64")
+	c.Assert(callerModule(0), Equals, "dvid")
+
+	level, err := ParseLogLevel("WARNING")
+	c.Assert(err, IsNil)
+	c.Assert(level, Equals, WarningMode)
+	_, err = ParseLogLevel("verbose")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,164 @@
+/*
+	This file supports partitioning sets of blocks into balanced, block-aligned chunks
+	so external schedulers can plan parallel jobs.
+*/
+
+package dvid
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// WeightedBlock is a block coordinate with a weight used for balancing partitions,
+// e.g., 1 to balance by block count or the stored size to balance by bytes.
+type WeightedBlock struct {
+	Coord  ChunkPoint3d
+	Weight uint64
+}
+
+// BlockChunk is one block-aligned chunk of a partition.  The extents are the bounding
+// box of the chunk's blocks, so chunks of a partition never overlap.
+type BlockChunk struct {
+	Extents3d
+	ChunkExtents3d
+	NumBlocks int
+	Weight    uint64
+}
+
+// BlockPartition describes the chunks that partition a set of blocks.
+type BlockPartition struct {
+	// By describes the weights used for balancing, e.g., "blocks" or "bytes".
+	By        string
+	NumBlocks int
+	Weight    uint64
+	NumChunks int
+	Chunks    []BlockChunk
+}
+
+// PartitionBlocks partitions blocks into at most n chunks of roughly equal total
+// weight by recursively bisecting the blocks with block-aligned planes.  Each cut is
+// made along the axis giving the best balance, preferring the longest axis of the
+// blocks' bounding box.  Fewer than n chunks are returned if there are fewer blocks
+// than requested chunks.  The blocks slice is reordered.
+func PartitionBlocks(blocks []WeightedBlock, n int, blockSize Point3d, by string) (*BlockPartition, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("number of partitions must be positive, got %d", n)
+	}
+	chunks := []BlockChunk{}
+	if len(blocks) != 0 {
+		chunks = bisectBlocks(blocks, n, chunks)
+	}
+	p := &BlockPartition{
+		By:        by,
+		NumBlocks: len(blocks),
+		NumChunks: len(chunks),
+		Chunks:    chunks,
+	}
+	for i, chunk := range chunks {
+		p.Weight += chunk.Weight
+		p.Chunks[i].MinPoint = chunk.MinChunk.MinPoint(blockSize).(Point3d)
+		p.Chunks[i].MaxPoint = chunk.MaxChunk.MaxPoint(blockSize).(Point3d)
+	}
+	return p, nil
+}
+
+func newBlockChunk(blocks []WeightedBlock) BlockChunk {
+	chunk := BlockChunk{
+		ChunkExtents3d: ChunkExtents3d{
+			MinChunk: ChunkPoint3d{math.MaxInt32, math.MaxInt32, math.MaxInt32},
+			MaxChunk: ChunkPoint3d{math.MinInt32, math.MinInt32, math.MinInt32},
+		},
+		NumBlocks: len(blocks),
+	}
+	for _, block := range blocks {
+		chunk.ChunkExtents3d.Extend(block.Coord)
+		chunk.Weight += block.Weight
+	}
+	return chunk
+}
+
+// blocksByDim sorts blocks by their coordinate along one dimension.
+type blocksByDim struct {
+	blocks []WeightedBlock
+	dim    int
+}
+
+func (b blocksByDim) Len() int      { return len(b.blocks) }
+func (b blocksByDim) Swap(i, j int) { b.blocks[i], b.blocks[j] = b.blocks[j], b.blocks[i] }
+func (b blocksByDim) Less(i, j int) bool {
+	return b.blocks[i].Coord[b.dim] < b.blocks[j].Coord[b.dim]
+}
+
+func bisectBlocks(blocks []WeightedBlock, n int, chunks []BlockChunk) []BlockChunk {
+	chunk := newBlockChunk(blocks)
+	if n > len(blocks) {
+		n = len(blocks)
+	}
+	if n == 1 {
+		return append(chunks, chunk)
+	}
+
+	// Cut along the axis that best balances weight, preferring longer axes on ties so
+	// chunks stay compact.
+	var size [3]int64
+	for dim := 0; dim < 3; dim++ {
+		size[dim] = int64(chunk.MaxChunk[dim]) - int64(chunk.MinChunk[dim])
+	}
+	dims := []int{0, 1, 2}
+	for i := 1; i < 3; i++ {
+		for j := i; j > 0 && size[dims[j]] > size[dims[j-1]]; j-- {
+			dims[j], dims[j-1] = dims[j-1], dims[j]
+		}
+	}
+	nLeft := n / 2
+	target := float64(chunk.Weight) * float64(nLeft) / float64(n)
+	bestDim := -1
+	var bestCut int
+	bestDiff := math.Inf(1)
+	for _, dim := range dims {
+		if size[dim] == 0 {
+			break
+		}
+		cut, diff := findCut(blocks, dim, target)
+		if diff < bestDiff {
+			bestDim, bestCut, bestDiff = dim, cut, diff
+		}
+	}
+	if bestDim < 0 {
+		return append(chunks, chunk)
+	}
+	sort.Sort(blocksByDim{blocks, bestDim})
+
+	// Each side can't have more partitions than blocks.
+	if nLeft > bestCut {
+		nLeft = bestCut
+	}
+	if n-nLeft > len(blocks)-bestCut {
+		nLeft = n - (len(blocks) - bestCut)
+	}
+	chunks = bisectBlocks(blocks[:bestCut], nLeft, chunks)
+	return bisectBlocks(blocks[bestCut:], n-nLeft, chunks)
+}
+
+// findCut sorts blocks along a dimension and returns the index of the first block
+// past the block-aligned plane where the weight below best matches the target.
+func findCut(blocks []WeightedBlock, dim int, target float64) (cut int, bestDiff float64) {
+	sort.Sort(blocksByDim{blocks, dim})
+	bestDiff = math.Inf(1)
+	var weight uint64
+	for i := 0; i < len(blocks)-1; i++ {
+		weight += blocks[i].Weight
+		if blocks[i].Coord[dim] == blocks[i+1].Coord[dim] {
+			continue
+		}
+		diff := math.Abs(float64(weight) - target)
+		if diff >= bestDiff {
+			break
+		}
+		bestDiff = diff
+		cut = i + 1
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package dvid
+
+import . "github.com/janelia-flyem/go/gocheck"
+
+func (s *DataSuite) TestPartitionBlocks(c *C) {
+	var blocks []WeightedBlock
+	for z := int32(0); z < 10; z++ {
+		for y := int32(0); y < 4; y++ {
+			for x := int32(0); x < 20; x++ {
+				blocks = append(blocks, WeightedBlock{ChunkPoint3d{x, y, z}, 1})
+			}
+		}
+	}
+	partition, err := PartitionBlocks(blocks, 16, Point3d{32, 32, 32}, "blocks")
+	c.Assert(err, IsNil)
+	c.Assert(partition.NumChunks, Equals, 16)
+	c.Assert(partition.NumBlocks, Equals, 800)
+	c.Assert(partition.Weight, Equals, uint64(800))
+
+	// Chunks should be balanced and never overlap.
+	for i, chunk := range partition.Chunks {
+		c.Assert(chunk.NumBlocks, Equals, 50)
+		c.Assert(chunk.MinPoint, Equals, chunk.MinChunk.MinPoint(Point3d{32, 32, 32}))
+		c.Assert(chunk.MaxPoint, Equals, chunk.MaxChunk.MaxPoint(Point3d{32, 32, 32}))
+		for _, other := range partition.Chunks[i+1:] {
+			overlaps := true
+			for dim := 0; dim < 3; dim++ {
+				if chunk.MaxChunk[dim] < other.MinChunk[dim] || other.MaxChunk[dim] < chunk.MinChunk[dim] {
+					overlaps = false
+				}
+			}
+			c.Assert(overlaps, Equals, false)
+		}
+	}
+
+	// Weights are balanced and we can't have more chunks than blocks.
+	blocks = []WeightedBlock{
+		{ChunkPoint3d{0, 0, 0}, 6},
+		{ChunkPoint3d{1, 0, 0}, 1},
+		{ChunkPoint3d{2, 0, 0}, 2},
+		{ChunkPoint3d{3, 0, 0}, 3},
+	}
+	partition, err = PartitionBlocks(blocks, 2, Point3d{32, 32, 32}, "bytes")
+	c.Assert(err, IsNil)
+	c.Assert(partition.NumChunks, Equals, 2)
+	c.Assert(partition.Chunks[0].Weight, Equals, uint64(6))
+	c.Assert(partition.Chunks[1].MinChunk, Equals, ChunkPoint3d{1, 0, 0})
+
+	partition, err = PartitionBlocks(blocks, 10, Point3d{32, 32, 32}, "bytes")
+	c.Assert(err, IsNil)
+	c.Assert(partition.NumChunks, Equals, 4)
+
+	_, err = PartitionBlocks(blocks, 0, Point3d{32, 32, 32}, "blocks")
+	c.Assert(err, NotNil)
+}
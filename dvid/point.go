@@ -1043,6 +1043,27 @@ func StringToPoint(str, separator string) (p Point, err error) {
 	return
 }
 
+// StringToBlockSize parses a 3d block size, which can be a single size for cubic blocks,
+// e.g., "64", or sizes in X, Y, and Z separated by commas or "x", e.g., "128x128x16".
+func StringToBlockSize(str string) (Point3d, error) {
+	elems := strings.FieldsFunc(str, func(r rune) bool { return r == ',' || r == 'x' || r == 'X' })
+	if len(elems) == 1 {
+		elems = []string{elems[0], elems[0], elems[0]}
+	}
+	if len(elems) != 3 {
+		return Point3d{}, fmt.Errorf("Block size %q must be a single size or sizes in X, Y, and Z", str)
+	}
+	var size Point3d
+	for i, elem := range elems {
+		n, err := strconv.ParseInt(strings.TrimSpace(elem), 10, 32)
+		if err != nil || n < 1 {
+			return Point3d{}, fmt.Errorf("Bad block size %q: sizes must be positive integers", str)
+		}
+		size[i] = int32(n)
+	}
+	return size, nil
+}
+
 // -- Handle N-dimensional floating points and strings --------
 
 // Vector3d is a 3D vector of 64-bit floats, a recommended type for math operations.
@@ -1084,6 +1105,38 @@ func (v Vector3d) DivideScalar(x float64) Vector3d {
 	return Vector3d{v[0] / x, v[1] / x, v[2] / x}
 }
 
+func (v Vector3d) MultScalar(x float64) Vector3d {
+	return Vector3d{v[0] * x, v[1] * x, v[2] * x}
+}
+
+// Dot returns the dot product of two vectors.
+func (v Vector3d) Dot(x Vector3d) float64 {
+	return v[0]*x[0] + v[1]*x[1] + v[2]*x[2]
+}
+
+// Cross returns the cross product v x x.
+func (v Vector3d) Cross(x Vector3d) Vector3d {
+	return Vector3d{
+		v[1]*x[2] - v[2]*x[1],
+		v[2]*x[0] - v[0]*x[2],
+		v[0]*x[1] - v[1]*x[0],
+	}
+}
+
+// Length returns the Euclidean length of the vector.
+func (v Vector3d) Length() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
+// Normalize returns the unit vector in the direction of v or an error if v has no length.
+func (v Vector3d) Normalize() (Vector3d, error) {
+	length := v.Length()
+	if length == 0 {
+		return Vector3d{}, fmt.Errorf("Can't normalize zero-length vector")
+	}
+	return v.DivideScalar(length), nil
+}
+
 func (v *Vector3d) Increment(x Vector3d) {
 	(*v)[0] += x[0]
 	(*v)[1] += x[1]
@@ -160,3 +160,22 @@ func (s *DataSuite) TestChunk(c *C) {
 	result := d.PointInChunk(blockSize)
 	c.Assert(result, Equals, Point3d{11, 3, 0})
 }
+
+func (s *DataSuite) TestStringToBlockSize(c *C) {
+	size, err := StringToBlockSize("64")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, Point3d{64, 64, 64})
+
+	size, err = StringToBlockSize("128x128x16")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, Point3d{128, 128, 16})
+
+	size, err = StringToBlockSize("32, 64, 8")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, Point3d{32, 64, 8})
+
+	for _, bad := range []string{"", "32,32", "0", "32x-1x32", "ax32x32"} {
+		_, err = StringToBlockSize(bad)
+		c.Assert(err, NotNil)
+	}
+}
@@ -12,8 +12,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/crc32"
+	"image"
+	"image/jpeg"
 	"io"
 	_ "log"
+	"strconv"
+	"strings"
 
 	lz4 "github.com/janelia-flyem/go/golz4"
 	"github.com/janelia-flyem/go/snappy-go/snappy"
@@ -90,11 +94,59 @@ func NewCompression(format CompressionFormat, level CompressionLevel) (Compressi
 			return Compression{}, fmt.Errorf("Gzip compression level must be between 1 and 9")
 		}
 		return Compression{format, level}, nil
+	case JPEG:
+		if level != DefaultCompression && (level < 1 || level > 100) {
+			return Compression{}, fmt.Errorf("JPEG quality must be between 1 and 100")
+		}
+		return Compression{format, level}, nil
 	default:
 		return Compression{}, fmt.Errorf("Unrecognized compression format requested: %d", format)
 	}
 }
 
+// ParseCompression returns the compression given by a name, which can be "none",
+// "snappy", "lz4", "gzip", "gzip-N" where N is a gzip level from 1 (fastest) to
+// 9 (smallest), "jpeg", or "jpeg-N" where N is a JPEG quality from 1 to 100.
+func ParseCompression(name string) (Compression, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "none":
+		return NewCompression(Uncompressed, DefaultCompression)
+	case "snappy":
+		return NewCompression(Snappy, DefaultCompression)
+	case "lz4":
+		return NewCompression(LZ4, DefaultCompression)
+	case "gzip":
+		return NewCompression(Gzip, DefaultCompression)
+	case "jpeg":
+		return NewCompression(JPEG, DefaultCompression)
+	}
+	if strings.HasPrefix(name, "gzip-") {
+		level, err := strconv.Atoi(name[5:])
+		if err != nil || level < 1 || level > 9 {
+			return Compression{}, fmt.Errorf("Bad gzip compression %q: level must be between 1 and 9", name)
+		}
+		return NewCompression(Gzip, CompressionLevel(level))
+	}
+	if strings.HasPrefix(name, "jpeg-") {
+		quality, err := strconv.Atoi(name[5:])
+		if err != nil || quality < 1 || quality > 100 {
+			return Compression{}, fmt.Errorf("Bad jpeg compression %q: quality must be between 1 and 100", name)
+		}
+		return NewCompression(JPEG, CompressionLevel(quality))
+	}
+	return Compression{}, fmt.Errorf("Unknown compression %q: must be 'none', 'snappy', 'lz4', 'gzip', 'gzip-N', 'jpeg', or 'jpeg-N'", name)
+}
+
+// Lossless returns the compression if it is lossless or LZ4 otherwise.  It's used for
+// data like histograms of an instance whose voxels use lossy compression.
+func (c Compression) Lossless() Compression {
+	if c.format.Lossy() {
+		return Compression{LZ4, DefaultCompression}
+	}
+	return c
+}
+
 // CompressionLevel goes from 1 (fastest) to 9 (highest compression)
 // as in deflate.  Default compression is -1 so need signed int8.
 type CompressionLevel int8
@@ -116,6 +168,15 @@ const (
 	LZ4
 )
 
+// JPEG is lossy compression of 8-bit grayscale data.  Since only 3 bits of a serialization
+// hold the compression format, it uses the value left unused by the formats above.
+const JPEG CompressionFormat = 3
+
+// Lossy returns true if data compressed with the format may differ when uncompressed.
+func (format CompressionFormat) Lossy() bool {
+	return format == JPEG
+}
+
 func (format CompressionFormat) String() string {
 	switch format {
 	case Uncompressed:
@@ -126,11 +187,31 @@ func (format CompressionFormat) String() string {
 		return "LZ4 compression"
 	case Gzip:
 		return "gzip compression"
+	case JPEG:
+		return "JPEG compression"
 	default:
 		return "Unknown compression"
 	}
 }
 
+// Name returns the short name of the compression format used in requests.
+func (format CompressionFormat) Name() string {
+	switch format {
+	case Uncompressed:
+		return "none"
+	case Snappy:
+		return "snappy"
+	case LZ4:
+		return "lz4"
+	case Gzip:
+		return "gzip"
+	case JPEG:
+		return "jpeg"
+	default:
+		return "unknown"
+	}
+}
+
 // Checksum is the type of checksum employed for error checking stored data.
 // NOTE: Should be no more than 4 (2 bits) of checksum types.
 type Checksum uint8
@@ -138,6 +219,7 @@ type Checksum uint8
 const (
 	NoChecksum Checksum = 0
 	CRC32               = 1 << (iota - 1)
+	XXHash              // 64-bit xxHash
 )
 
 // DefaultChecksum is the type of checksum employed for all data operations.
@@ -151,11 +233,155 @@ func (checksum Checksum) String() string {
 		return "No checksum"
 	case CRC32:
 		return "CRC32 checksum"
+	case XXHash:
+		return "xxHash checksum"
 	default:
 		return "Unknown checksum"
 	}
 }
 
+// ParseChecksum returns the checksum given by a name: "none", "crc32", or "xxhash".
+func ParseChecksum(name string) (Checksum, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return NoChecksum, nil
+	case "crc32":
+		return CRC32, nil
+	case "xxhash":
+		return XXHash, nil
+	default:
+		return NoChecksum, fmt.Errorf("Unknown checksum %q: must be 'none', 'crc32', or 'xxhash'", name)
+	}
+}
+
+// ChecksumPolicy is how a checksum mismatch on reading stored data is handled.
+type ChecksumPolicy uint8
+
+const (
+	// ChecksumFail returns an error for data with a bad checksum.
+	ChecksumFail ChecksumPolicy = iota
+
+	// ChecksumLog logs data with a bad checksum and uses it anyway.
+	ChecksumLog
+
+	// ChecksumRepair replaces data with a bad checksum by a good copy from a replica
+	// store if possible, failing otherwise.  Repair is done by the storage layer.
+	ChecksumRepair
+)
+
+func (p ChecksumPolicy) String() string {
+	switch p {
+	case ChecksumFail:
+		return "fail"
+	case ChecksumLog:
+		return "log"
+	case ChecksumRepair:
+		return "repair"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseChecksumPolicy returns the policy given by a name: "fail", "log", or "repair".
+func ParseChecksumPolicy(name string) (ChecksumPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "fail":
+		return ChecksumFail, nil
+	case "log":
+		return ChecksumLog, nil
+	case "repair":
+		return ChecksumRepair, nil
+	default:
+		return ChecksumFail, fmt.Errorf("Unknown checksum policy %q: must be 'fail', 'log', or 'repair'", name)
+	}
+}
+
+var checksumPolicy = ChecksumFail
+
+// SetChecksumPolicy sets how checksum mismatches are handled when deserializing data.
+func SetChecksumPolicy(p ChecksumPolicy) {
+	checksumPolicy = p
+	Infof("Checksum mismatches on reads will %s\n", p)
+}
+
+// GetChecksumPolicy returns how checksum mismatches are handled.
+func GetChecksumPolicy() ChecksumPolicy {
+	return checksumPolicy
+}
+
+// checksumSize returns the number of bytes stored for a checksum.
+func checksumSize(checksum Checksum) (int, error) {
+	switch checksum {
+	case NoChecksum:
+		return 0, nil
+	case CRC32:
+		return 4, nil
+	case XXHash:
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("Illegal checksum (%d)", checksum)
+	}
+}
+
+// computeChecksum returns the stored form of a checksum of data.
+func computeChecksum(checksum Checksum, data []byte) []byte {
+	switch checksum {
+	case CRC32:
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(data))
+		return b
+	case XXHash:
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, XXHash64(data))
+		return b
+	default:
+		return nil
+	}
+}
+
+// ChecksumError is returned for serialized data whose stored checksum doesn't match.
+type ChecksumError struct {
+	Checksum Checksum
+	Stored   []byte
+	Computed []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("Bad %s.  Stored %x got %x", e.Checksum, e.Stored, e.Computed)
+}
+
+// splitSerialization returns the parts of serialized data.
+func splitSerialization(s []byte) (CompressionFormat, Checksum, []byte, []byte, error) {
+	if len(s) < 1 {
+		return 0, 0, nil, nil, fmt.Errorf("Could not read serialization format info: no data")
+	}
+	compression, checksum := DecodeSerializationFormat(SerializationFormat(s[0]))
+	size, err := checksumSize(checksum)
+	if err != nil {
+		return 0, 0, nil, nil, fmt.Errorf("Illegal checksum in deserializing data")
+	}
+	if len(s) < 1+size {
+		return 0, 0, nil, nil, fmt.Errorf("Error reading checksum: only %d bytes", len(s))
+	}
+	return compression, checksum, s[1 : 1+size], s[1+size:], nil
+}
+
+// VerifyChecksum returns the checksum of serialized data and a *ChecksumError if the
+// stored checksum doesn't match the data.  Data without a checksum always verify.
+func VerifyChecksum(s []byte) (Checksum, error) {
+	_, checksum, stored, cdata, err := splitSerialization(s)
+	if err != nil {
+		return NoChecksum, err
+	}
+	if checksum == NoChecksum {
+		return checksum, nil
+	}
+	if computed := computeChecksum(checksum, cdata); !bytes.Equal(stored, computed) {
+		return checksum, &ChecksumError{checksum, stored, computed}
+	}
+	return checksum, nil
+}
+
 // SerializationFormat combines both compression and checksum methods.
 type SerializationFormat uint8
 
@@ -188,7 +414,29 @@ func SerializeData(data []byte, compress Compression, checksum Checksum) ([]byte
 		return nil, err
 	}
 
-	// Handle compression if requested
+	byteData, err := CompressData(data, compress)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle checksum if requested
+	if _, err := checksumSize(checksum); err != nil {
+		return nil, fmt.Errorf("Illegal checksum (%s) in serialize.SerializeData()", checksum)
+	}
+	buffer.Write(computeChecksum(checksum, byteData))
+
+	// Note the actual data is written last, after any checksum so we don't have to
+	// worry about length when deserializing.
+	if _, err := buffer.Write(byteData); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// CompressData returns data compressed using the given compression without any
+// serialization header.  LZ4-compressed data is prefixed by the uncompressed size as
+// a little-endian uint32.
+func CompressData(data []byte, compress Compression) ([]byte, error) {
 	var err error
 	var byteData []byte
 	switch compress.format {
@@ -222,28 +470,85 @@ func SerializeData(data []byte, compress Compression, checksum Checksum) ([]byte
 			return nil, err
 		}
 		byteData = b.Bytes()
+	case JPEG:
+		return compressJPEG(data, compress.level)
 	default:
-		return nil, fmt.Errorf("Illegal compression (%s) during serialization", compress)
+		return nil, fmt.Errorf("Illegal compression (%s) requested", compress)
 	}
+	return byteData, nil
+}
 
-	// Handle checksum if requested
-	switch checksum {
-	case NoChecksum:
-	case CRC32:
-		crcChecksum := crc32.ChecksumIEEE(byteData)
-		if err := binary.Write(&buffer, binary.LittleEndian, crcChecksum); err != nil {
-			return nil, err
+// Largest width or height of a JPEG image.
+const maxJPEGDim = 65535
+
+// jpegLayout returns the dimensions of the grayscale image holding n bytes of JPEG-compressed
+// data.  The width is the smallest power of 2 at least the cube root of n, so each row of
+// a cubic block is a line of voxels along x and vertically adjacent pixels are usually
+// adjacent voxels.
+func jpegLayout(n int) (width, height int) {
+	width = 8
+	for width*width*width < n {
+		width *= 2
+	}
+	for {
+		height = (n + width - 1) / width
+		if height <= maxJPEGDim {
+			return
 		}
-	default:
-		return nil, fmt.Errorf("Illegal checksum (%s) in serialize.SerializeData()", checksum)
+		width *= 2
 	}
+}
 
-	// Note the actual data is written last, after any checksum so we don't have to
-	// worry about length when deserializing.
-	if _, err := buffer.Write(byteData); err != nil {
+// compressJPEG returns 8-bit data compressed as a grayscale JPEG image of given quality,
+// prefixed by the uncompressed size as a little-endian uint32.
+func compressJPEG(data []byte, quality CompressionLevel) ([]byte, error) {
+	if len(data) == 0 {
+		return make([]byte, 4), nil
+	}
+	width, height := jpegLayout(len(data))
+	if width > maxJPEGDim {
+		return nil, fmt.Errorf("Cannot JPEG compress %d bytes", len(data))
+	}
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	copy(img.Pix, data)
+	options := &jpeg.Options{Quality: jpeg.DefaultQuality}
+	if quality != DefaultCompression {
+		options.Quality = int(quality)
+	}
+	var b bytes.Buffer
+	origSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(origSize, uint32(len(data)))
+	b.Write(origSize)
+	if err := jpeg.Encode(&b, img, options); err != nil {
 		return nil, err
 	}
-	return buffer.Bytes(), nil
+	return b.Bytes(), nil
+}
+
+// uncompressJPEG returns the 8-bit data of a compressed JPEG image from compressJPEG.
+func uncompressJPEG(cdata []byte) ([]byte, error) {
+	if len(cdata) < 4 {
+		return nil, fmt.Errorf("JPEG compressed data is only %d bytes", len(cdata))
+	}
+	origSize := int(binary.LittleEndian.Uint32(cdata[0:4]))
+	data := make([]byte, origSize)
+	if origSize == 0 {
+		return data, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(cdata[4:]))
+	if err != nil {
+		return nil, err
+	}
+	gray, ok := img.(*image.Gray)
+	width, height := jpegLayout(origSize)
+	if !ok || gray.Rect.Dx() != width || gray.Rect.Dy() != height {
+		return nil, fmt.Errorf("JPEG compressed data is not a %d x %d grayscale image", width, height)
+	}
+	for y := 0; y < height; y++ {
+		row := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+		copy(data[y*width:], row)
+	}
+	return data, nil
 }
 
 // Serializes an arbitrary Go object using Gob encoding and optional compression, checksum.
@@ -261,38 +566,23 @@ func Serialize(object interface{}, compress Compression, checksum Checksum) ([]b
 }
 
 // DeserializeData deserializes a slice of bytes using stored compression, checksum.
-// If uncompress parameter is false, the data is not uncompressed.
+// If uncompress parameter is false, the data is not uncompressed.  A bad checksum is
+// handled according to the checksum policy.
 func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, error) {
-	buffer := bytes.NewBuffer(s)
-
-	// Get the stored compression and checksum
-	var format SerializationFormat
-	if err := binary.Read(buffer, binary.LittleEndian, &format); err != nil {
-		return nil, 0, fmt.Errorf("Could not read serialization format info: %s", err.Error())
-	}
-	compression, checksum := DecodeSerializationFormat(format)
-
-	// Get any checksum.
-	var storedCrc32 uint32
-	switch checksum {
-	case NoChecksum:
-	case CRC32:
-		if err := binary.Read(buffer, binary.LittleEndian, &storedCrc32); err != nil {
-			return nil, 0, fmt.Errorf("Error reading checksum: %s", err.Error())
-		}
-	default:
-		return nil, 0, fmt.Errorf("Illegal checksum in deserializing data")
+	// Get the stored compression, checksum, and possibly compressed data.
+	compression, checksum, stored, cdata, err := splitSerialization(s)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Get the possibly compressed data.
-	cdata := buffer.Bytes()
-
 	// Perform any requested checksum
-	switch checksum {
-	case CRC32:
-		crcChecksum := crc32.ChecksumIEEE(cdata)
-		if crcChecksum != storedCrc32 {
-			return nil, 0, fmt.Errorf("Bad checksum.  Stored %x got %x", storedCrc32, crcChecksum)
+	if checksum != NoChecksum {
+		if computed := computeChecksum(checksum, cdata); !bytes.Equal(stored, computed) {
+			checksumErr := &ChecksumError{checksum, stored, computed}
+			if checksumPolicy != ChecksumLog {
+				return nil, 0, checksumErr
+			}
+			Errorf("Using data with bad checksum: %s\n", checksumErr.Error())
 		}
 	}
 
@@ -332,6 +622,12 @@ func DeserializeData(s []byte, uncompress bool) ([]byte, CompressionFormat, erro
 				return nil, 0, err
 			}
 			return buffer.Bytes(), compression, nil
+		case JPEG:
+			data, err := uncompressJPEG(cdata)
+			if err != nil {
+				return nil, 0, err
+			}
+			return data, compression, nil
 		default:
 			return nil, 0, fmt.Errorf("Illegal compression format (%d) in deserialization", compression)
 		}
@@ -1,7 +1,9 @@
 package dvid
 
 import (
+	"strings"
 	"testing"
+
 	. "github.com/janelia-flyem/go/gocheck"
 )
 
@@ -32,7 +34,7 @@ func (suite *DataSuite) TestSerialization(c *C) {
 	}
 
 	for _, format := range []CompressionFormat{Uncompressed, Snappy, LZ4, Gzip} {
-		for _, checksum := range []Checksum{NoChecksum, CRC32} {
+		for _, checksum := range []Checksum{NoChecksum, CRC32, XXHash} {
 			compression, err := NewCompression(format, DefaultCompression)
 			c.Assert(err, IsNil)
 
@@ -75,6 +77,98 @@ func (suite *DataSuite) TestSerialization(c *C) {
 	}
 }
 
+func (suite *DataSuite) TestXXHash64(c *C) {
+	c.Assert(XXHash64(nil), Equals, uint64(0xef46db3751d8e999))
+	c.Assert(XXHash64([]byte("abc")), Equals, uint64(0x44bc2cf5ad770999))
+}
+
+func (suite *DataSuite) TestChecksumPolicy(c *C) {
+	s, err := SerializeData([]byte("some block of voxels"), Compression{}, XXHash)
+	c.Assert(err, IsNil)
+	checksum, err := VerifyChecksum(s)
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, XXHash)
+
+	s[len(s)-1] ^= 0x01
+	_, err = VerifyChecksum(s)
+	_, bad := err.(*ChecksumError)
+	c.Assert(bad, Equals, true)
+
+	_, _, err = DeserializeData(s, true)
+	c.Assert(err, NotNil)
+
+	SetChecksumPolicy(ChecksumLog)
+	defer SetChecksumPolicy(ChecksumFail)
+	data, _, err := DeserializeData(s, true)
+	c.Assert(err, IsNil)
+	c.Assert(len(data), Equals, len("some block of voxels"))
+}
+
+func (suite *DataSuite) TestParseCompression(c *C) {
+	data := []byte("some data that is some data that is some data")
+	for _, name := range []string{"none", "snappy", "LZ4", "gzip", "gzip-9"} {
+		compression, err := ParseCompression(name)
+		c.Assert(err, IsNil)
+		c.Assert(compression.Format().Name(), Equals, strings.Split(strings.ToLower(name), "-")[0])
+
+		// Compressed data should match the payload of serialized data.
+		compressed, err := CompressData(data, compression)
+		c.Assert(err, IsNil)
+		s, err := SerializeData(data, compression, NoChecksum)
+		c.Assert(err, IsNil)
+		payload, format, err := DeserializeData(s, false)
+		c.Assert(err, IsNil)
+		c.Assert(format, Equals, compression.Format())
+		c.Assert(payload, DeepEquals, compressed)
+	}
+	compression, err := ParseCompression("gzip-1")
+	c.Assert(err, IsNil)
+	c.Assert(compression.Level(), Equals, CompressionLevel(1))
+
+	compression, err = ParseCompression("jpeg-90")
+	c.Assert(err, IsNil)
+	c.Assert(compression.Format(), Equals, JPEG)
+	c.Assert(compression.Level(), Equals, CompressionLevel(90))
+	c.Assert(compression.Lossless().Format(), Equals, LZ4)
+
+	for _, name := range []string{"", "zip", "gzip-0", "gzip-10", "gzip-x", "jpeg-0", "jpeg-101"} {
+		_, err := ParseCompression(name)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (suite *DataSuite) TestJPEGCompression(c *C) {
+	// A smooth 32^3 block should be close after lossy compression.
+	data := make([]byte, 32*32*32)
+	for i := range data {
+		x, y, z := i%32, (i/32)%32, i/1024
+		data[i] = uint8(64 + x + 2*y + z)
+	}
+	compression, err := ParseCompression("jpeg-95")
+	c.Assert(err, IsNil)
+	s, err := SerializeData(data, compression, CRC32)
+	c.Assert(err, IsNil)
+	c.Assert(len(s) < len(data), Equals, true)
+	uncompressed, format, err := DeserializeData(s, true)
+	c.Assert(err, IsNil)
+	c.Assert(format, Equals, JPEG)
+	c.Assert(uncompressed, HasLen, len(data))
+	for i := range data {
+		diff := int(uncompressed[i]) - int(data[i])
+		if diff < -8 || diff > 8 {
+			c.Fatalf("Voxel %d is %d after JPEG compression, expected %d", i, uncompressed[i], data[i])
+		}
+	}
+
+	// Sizes that don't fill the image are preserved.
+	data = []byte("odd sized data")
+	s, err = SerializeData(data, compression, NoChecksum)
+	c.Assert(err, IsNil)
+	uncompressed, _, err = DeserializeData(s, true)
+	c.Assert(err, IsNil)
+	c.Assert(uncompressed, HasLen, len(data))
+}
+
 func (suite *DataSuite) testUncompressed(b *testing.B, checksum Checksum) {
 	stringObj := "Hi there!"
 	var returnObj string
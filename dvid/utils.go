@@ -195,8 +195,31 @@ func SupportsGzipEncoding(r *http.Request) bool {
 // WriteGzip will write already gzip-encoded data to the ResponseWriter unless
 // the requestor cannot support it.  In that case, the gzip data is uncompressed
 // and sent uncompressed.
+// If the request overrides compression via the "compression" query string, the data
+// is recompressed as requested.
 func WriteGzip(gzipData []byte, w http.ResponseWriter, r *http.Request) error {
-	if SupportsGzipEncoding(r) {
+	compress, found, err := WireCompression(r)
+	if err != nil {
+		return err
+	}
+	if found && (compress.Format() != Gzip || compress.Level() != DefaultCompression) {
+		gzipReader, err := gzip.NewReader(bytes.NewBuffer(gzipData))
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(gzipReader)
+		if err != nil {
+			return err
+		}
+		if err = gzipReader.Close(); err != nil {
+			return err
+		}
+		return WriteCompressed(data, w, r)
+	}
+	if found || SupportsGzipEncoding(r) {
+		if found {
+			w.Header().Set("X-Dvid-Compression", "gzip")
+		}
 		w.Header().Set("Content-Encoding", "gzip")
 		if _, err := w.Write(gzipData); err != nil {
 			return err
@@ -219,6 +242,81 @@ func WriteGzip(gzipData []byte, w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// WireCompression returns the compression requested for a response via the "compression"
+// query string, e.g., "?compression=lz4" for fast reads within a cluster or
+// "?compression=gzip-9" for smaller transfers over slower networks.  This is
+// independent of how the data is compressed in storage.  Returns false if no
+// compression was requested.
+func WireCompression(r *http.Request) (Compression, bool, error) {
+	name := r.URL.Query().Get("compression")
+	if name == "" {
+		return Compression{}, false, nil
+	}
+	compress, err := ParseCompression(name)
+	if err != nil {
+		return Compression{}, false, err
+	}
+	return compress, true, nil
+}
+
+// Responses smaller than this aren't gzip compressed unless requested via query string.
+const minGzipResponse = 1024
+
+// NegotiateCompression returns the compression for a response of the given size.  The
+// "compression" query string takes precedence.  Otherwise, responses of at least 1 KB are
+// gzip compressed if the requestor accepts gzip encoding.  Returns false if the response
+// should not be compressed.
+func NegotiateCompression(r *http.Request, size int) (Compression, bool, error) {
+	compress, found, err := WireCompression(r)
+	if err != nil || found {
+		return compress, found, err
+	}
+	if size >= minGzipResponse && SupportsGzipEncoding(r) {
+		compress, err = NewCompression(Gzip, DefaultCompression)
+		return compress, err == nil, err
+	}
+	return Compression{}, false, nil
+}
+
+// WriteCompressed writes uncompressed data to the ResponseWriter using the compression
+// negotiated via the "compression" query string or Accept-Encoding header.  The
+// "X-Dvid-Compression" header gives the compression used, and gzip-compressed data also
+// sets the standard Content-Encoding.  Snappy and lz4 data are not framed, with lz4 data
+// prefixed by the uncompressed size as a little-endian uint32.  Lossy compression like
+// JPEG is refused since the data may not be images; see WriteGrayscaleCompressed.
+func WriteCompressed(data []byte, w http.ResponseWriter, r *http.Request) error {
+	return writeCompressed(data, w, r, false)
+}
+
+// WriteGrayscaleCompressed is like WriteCompressed but for 8-bit grayscale data, which
+// can also be sent as JPEG.  JPEG data is prefixed by the uncompressed size as a
+// little-endian uint32 and holds a grayscale image whose rows are consecutive data.
+func WriteGrayscaleCompressed(data []byte, w http.ResponseWriter, r *http.Request) error {
+	return writeCompressed(data, w, r, true)
+}
+
+func writeCompressed(data []byte, w http.ResponseWriter, r *http.Request, allowLossy bool) error {
+	compress, found, err := NegotiateCompression(r, len(data))
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if found {
+		if compress.Format().Lossy() && !allowLossy {
+			return fmt.Errorf("%s is only available for 8-bit grayscale data", compress.Format())
+		}
+		if data, err = CompressData(data, compress); err != nil {
+			return err
+		}
+		w.Header().Set("X-Dvid-Compression", compress.Format().Name())
+		if compress.Format() == Gzip {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 // Nod to Andrew Gerrand for simple gzip solution:
 // See https://groups.google.com/forum/m/?fromgroups#!topic/golang-nuts/eVnTcMwNVjM
 type gzipResponseWriter struct {
@@ -80,6 +80,16 @@ func NewRLE(start Point3d, length int32) RLE {
 	return RLE{start, length}
 }
 
+// StartPt returns the starting coordinate of the run.
+func (rle RLE) StartPt() Point3d {
+	return rle.start
+}
+
+// Length returns the number of voxels in the run.
+func (rle RLE) Length() int32 {
+	return rle.length
+}
+
 // RLEs are simply a slice of RLE.
 type RLEs []RLE
 
@@ -0,0 +1,395 @@
+/*
+	This file supports an audit log of mutating HTTP requests, e.g., to settle disputes
+	over who changed a segmentation during proofreading.  Each POST, PUT, or DELETE that
+	passes authentication is recorded with its client, endpoint, data instance, version,
+	response status, and a digest of its payload.  Records are appended to the metadata
+	tier under keys ordered by time and are never modified, so the log can be queried by
+	time range and filtered by client or data instance.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/middleware"
+)
+
+const (
+	// DefaultAuditLimit is the maximum number of audit records returned by a query
+	// unless another limit is given.
+	DefaultAuditLimit = 1000
+
+	// MaxAuditLimit is the maximum number of audit records returned by a query.
+	MaxAuditLimit = 100000
+)
+
+// The first byte of metadata indices for audit records.
+const auditKey byte = 0xAC
+
+// AuditRecord describes one mutating request.
+type AuditRecord struct {
+	Time time.Time
+
+	// Client is the name of the API token or the client address if there's no token.
+	// ClientID identifies the API token, if any, since names can be reused.
+	Client   string
+	ClientID string `json:",omitempty"`
+
+	Method    string
+	Endpoint  string
+	RequestID string `json:",omitempty"`
+
+	// UUID and Instance are the version and data instance of the request, if any.
+	UUID     dvid.UUID       `json:",omitempty"`
+	Instance dvid.DataString `json:",omitempty"`
+
+	Status int
+
+	// PayloadBytes and PayloadDigest give the size and SHA-256 digest of the request body.
+	PayloadBytes  int64
+	PayloadDigest string `json:",omitempty"`
+}
+
+// AuditFilter selects audit records.  Empty fields match any record.
+type AuditFilter struct {
+	Client   string
+	ClientID string
+	Instance dvid.DataString
+	UUID     dvid.UUID
+
+	// Records from Since up to but not including Until.  Until defaults to now.
+	Since time.Time
+	Until time.Time
+
+	// Limit is the maximum number of records returned, oldest first.
+	Limit int
+}
+
+func (f AuditFilter) matches(record *AuditRecord) bool {
+	if f.Client != "" && f.Client != record.Client {
+		return false
+	}
+	if f.ClientID != "" && f.ClientID != record.ClientID {
+		return false
+	}
+	if f.Instance != "" && f.Instance != record.Instance {
+		return false
+	}
+	return f.UUID == "" || f.UUID == record.UUID
+}
+
+var (
+	auditMu      sync.Mutex
+	auditEnabled bool
+	auditSeq     uint32 // distinguishes records with the same timestamp
+)
+
+// EnableAudit starts recording mutating requests in the audit log.
+func EnableAudit() {
+	auditMu.Lock()
+	auditEnabled = true
+	auditMu.Unlock()
+	dvid.Infof("Audit log of mutating requests enabled.\n")
+}
+
+// AuditEnabled returns true if mutating requests are recorded in the audit log.
+func AuditEnabled() bool {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	return auditEnabled
+}
+
+// auditIndex orders audit records by time, with a sequence number so records with
+// the same timestamp don't collide.
+func auditIndex(t time.Time, seq uint32) []byte {
+	index := make([]byte, 13)
+	index[0] = auditKey
+	binary.BigEndian.PutUint64(index[1:9], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(index[9:13], seq)
+	return index
+}
+
+func putAuditRecord(record *AuditRecord) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(record); err != nil {
+		return err
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	auditMu.Lock()
+	auditSeq++
+	index := auditIndex(record.Time, auditSeq)
+	auditMu.Unlock()
+	return store.Put(storage.NewMetadataContext(), index, buf.Bytes())
+}
+
+// auditContext is a metadata context whose range scans can be stopped once enough
+// audit records are found.
+type auditContext struct {
+	storage.MetadataContext
+	done chan struct{}
+}
+
+func (ctx auditContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+// AuditRecords returns audit records selected by the filter, oldest first.  The filter's
+// UUID can be a partial UUID that uniquely identifies a version.  Records are read in
+// order until the limit is reached, so only the needed records are decoded.
+func AuditRecords(filter AuditFilter) ([]AuditRecord, error) {
+	if filter.Until.IsZero() {
+		filter.Until = time.Now()
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditLimit
+	}
+	if filter.Since.Before(time.Unix(0, 0)) {
+		filter.Since = time.Unix(0, 0)
+	}
+	if filter.UUID != "" {
+		uuid, _, err := datastore.MatchingUUID(string(filter.UUID))
+		if err != nil {
+			return nil, err
+		}
+		filter.UUID = uuid
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := auditContext{storage.NewMetadataContext(), make(chan struct{})}
+	records := []AuditRecord{}
+	var decodeErr error
+	err = store.ProcessRange(ctx, auditIndex(filter.Since, 0), auditIndex(filter.Until, 0), &storage.ChunkOp{}, func(chunk *storage.Chunk) {
+		if decodeErr != nil || len(records) == filter.Limit || chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		var record AuditRecord
+		dec := gob.NewDecoder(bytes.NewBuffer(chunk.V))
+		if err := dec.Decode(&record); err != nil {
+			decodeErr = fmt.Errorf("Could not decode audit record: %s", err.Error())
+			close(ctx.done)
+			return
+		}
+		if !record.Time.Before(filter.Until) || !filter.matches(&record) {
+			return
+		}
+		records = append(records, record)
+		if len(records) == filter.Limit {
+			close(ctx.done)
+		}
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if err != nil && !(err == storage.ErrCanceled && len(records) == filter.Limit) {
+		return nil, err
+	}
+	return records, nil
+}
+
+// auditBody computes the size and digest of a request body as it is read.
+type auditBody struct {
+	io.ReadCloser
+	digest hash.Hash
+	n      int64
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.digest.Write(p[:n])
+	b.n += int64(n)
+	return n, err
+}
+
+// auditWriter records the status of a response.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// CloseNotify passes through the underlying writer's notification so audited requests
+// are still canceled when clients disconnect.
+func (w *auditWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *auditWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// setAuditInstance gives the data instance of an audited request.
+func setAuditInstance(c *web.C, data datastore.DataService) {
+	if record, ok := c.Env["audit"].(*AuditRecord); ok {
+		record.Instance = data.DataName()
+	}
+}
+
+// auditHandler is middleware that records mutating requests in the audit log once
+// they are handled.  The payload digest covers the whole body even if the handler
+// didn't read all of it.
+func auditHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || !AuditEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		record := &AuditRecord{
+			Time:      time.Now(),
+			Client:    requestClient(r),
+			Method:    r.Method,
+			Endpoint:  r.URL.Path,
+			RequestID: middleware.GetReqID(*c),
+		}
+		if token := getAuthToken(r); token != nil {
+			record.ClientID = token.ID()
+		}
+		if c.Env == nil {
+			c.Env = make(map[interface{}]interface{})
+		}
+		c.Env["audit"] = record
+
+		var body *auditBody
+		if r.Body != nil {
+			body = &auditBody{ReadCloser: r.Body, digest: sha256.New()}
+			r.Body = body
+		}
+		aw := &auditWriter{ResponseWriter: w}
+		h.ServeHTTP(aw, r)
+
+		record.Status = aw.status
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		if uuid, ok := c.Env["uuid"].(dvid.UUID); ok {
+			record.UUID = uuid
+		}
+		if body != nil {
+			if _, err := io.Copy(ioutil.Discard, body); err != nil {
+				dvid.Errorf("Unable to read remaining body of %s %s for audit: %s\n", r.Method, r.URL.Path, err.Error())
+			}
+			record.PayloadBytes = body.n
+			record.PayloadDigest = hex.EncodeToString(body.digest.Sum(nil))
+		}
+		if err := putAuditRecord(record); err != nil {
+			dvid.Errorf("Unable to record %s %s by %s in audit log: %s\n", r.Method, r.URL.Path, record.Client, err.Error())
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// parseAuditTime parses a time given in RFC3339 format or as Unix seconds.
+func parseAuditTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("Bad time %q: must be RFC3339 or Unix seconds", s)
+	}
+	return t, nil
+}
+
+func auditGetHandler(w http.ResponseWriter, r *http.Request) {
+	if !AuditEnabled() {
+		BadRequest(w, r, "Audit log is not enabled on this server")
+		return
+	}
+	queryValues := r.URL.Query()
+	filter := AuditFilter{
+		Client:   queryValues.Get("user"),
+		ClientID: queryValues.Get("clientid"),
+		Instance: dvid.DataString(queryValues.Get("instance")),
+		UUID:     dvid.UUID(queryValues.Get("uuid")),
+	}
+	var err error
+	if s := queryValues.Get("since"); s != "" {
+		if filter.Since, err = parseAuditTime(s); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+	}
+	if s := queryValues.Get("until"); s != "" {
+		if filter.Until, err = parseAuditTime(s); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+	}
+	if s := queryValues.Get("limit"); s != "" {
+		if filter.Limit, err = strconv.Atoi(s); err != nil || filter.Limit < 1 || filter.Limit > MaxAuditLimit {
+			BadRequest(w, r, "Bad limit %q: must be from 1 to %d", s, MaxAuditLimit)
+			return
+		}
+	}
+
+	// Only admins can see the mutations of other clients.
+	if AuthEnabled() {
+		token := getAuthToken(r)
+		if token == nil {
+			Unauthorized(w, r, http.StatusUnauthorized, "Valid API token required")
+			return
+		}
+		if !token.Admin {
+			if (filter.Client != "" && filter.Client != token.Name) || (filter.ClientID != "" && filter.ClientID != token.ID()) {
+				Unauthorized(w, r, http.StatusForbidden, "Admin API token required for audit records of other users")
+				return
+			}
+			filter.ClientID = token.ID()
+		}
+	}
+
+	records, err := AuditRecords(filter)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(records)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
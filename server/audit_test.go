@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestAuditRecords(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+
+	// Records alternate between clients, and those of alice are for the repo's root.
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 6; i++ {
+		record := &AuditRecord{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Client: "bob",
+			Method: "POST",
+			Status: 200 + i,
+		}
+		if i%2 == 0 {
+			record.Client = "alice"
+			record.UUID = root
+		}
+		if err := putAuditRecord(record); err != nil {
+			t.Fatalf("Unable to store audit record: %s\n", err.Error())
+		}
+	}
+	statuses := func(filter AuditFilter) []int {
+		records, err := AuditRecords(filter)
+		if err != nil {
+			t.Fatalf("Unable to get audit records: %s\n", err.Error())
+		}
+		var statuses []int
+		for _, record := range records {
+			statuses = append(statuses, record.Status)
+		}
+		return statuses
+	}
+	equal := func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for name, test := range map[string]struct {
+		filter   AuditFilter
+		expected []int
+	}{
+		"all":            {AuditFilter{Since: start}, []int{200, 201, 202, 203, 204, 205}},
+		"limit":          {AuditFilter{Since: start, Limit: 2}, []int{200, 201}},
+		"client limit":   {AuditFilter{Since: start, Client: "bob", Limit: 2}, []int{201, 203}},
+		"time range":     {AuditFilter{Since: start.Add(2 * time.Second), Until: start.Add(4 * time.Second)}, []int{202, 203}},
+		"full UUID":      {AuditFilter{Since: start, UUID: root}, []int{200, 202, 204}},
+		"partial UUID":   {AuditFilter{Since: start, UUID: root[:8], Limit: 2}, []int{200, 202}},
+		"no match limit": {AuditFilter{Since: start, Client: "carol", Limit: 1}, nil},
+	} {
+		if got := statuses(test.filter); !equal(got, test.expected) {
+			t.Errorf("Bad audit records for %s: expected statuses %v, got %v\n", name, test.expected, got)
+		}
+	}
+
+	if _, err := AuditRecords(AuditFilter{UUID: dvid.UUID("ffffffff")}); err == nil {
+		t.Errorf("Expected error for UUID not matching any node\n")
+	}
+}
+
+func TestAuditAccess(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+	defer func() {
+		auditMu.Lock()
+		auditEnabled = false
+		auditMu.Unlock()
+	}()
+
+	enableTestAuth(t, "test-admin")
+	EnableAudit()
+
+	// Tokens need unique, non-empty names.
+	if _, err := NewAuthToken("", false, nil); err == nil {
+		t.Errorf("Expected error creating API token without a name\n")
+	}
+	alice, err := NewAuthToken("alice", false, nil)
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+	if _, err := NewAuthToken("alice", false, nil); err == nil {
+		t.Errorf("Expected error creating API token with a used name\n")
+	}
+	if w := testRequest("POST", WebAPIPath+"server/tokens", "test-admin", strings.NewReader(`{"Name": ""}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected token POST without a name to fail, got status %d\n", w.Code)
+	}
+
+	// A token with a reused name doesn't see the records of the revoked token.
+	start := time.Now().Add(-time.Minute)
+	for _, record := range []*AuditRecord{
+		{Time: start, Client: "alice", ClientID: alice.ID(), Method: "POST", Status: 200},
+		{Time: start.Add(time.Second), Client: "bob", ClientID: "0123456789abcdef", Method: "POST", Status: 201},
+		{Time: start.Add(2 * time.Second), Client: "127.0.0.1:5000", Method: "POST", Status: 202},
+	} {
+		if err := putAuditRecord(record); err != nil {
+			t.Fatalf("Unable to store audit record: %s\n", err.Error())
+		}
+	}
+	visible := func(token, query string) []AuditRecord {
+		w := testRequest("GET", WebAPIPath+"server/audit?since=0"+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Bad status %d getting audit records: %s\n", w.Code, w.Body.String())
+		}
+		var records []AuditRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+			t.Fatalf("Unable to decode audit records %q: %s\n", w.Body.String(), err.Error())
+		}
+		return records
+	}
+	if records := visible(alice.Token, ""); len(records) != 1 || records[0].Status != 200 {
+		t.Errorf("Expected only alice's record, got %v\n", records)
+	}
+	if err := RevokeAuthToken(alice.Token); err != nil {
+		t.Fatalf("Unable to revoke API token: %s\n", err.Error())
+	}
+	newAlice, err := NewAuthToken("alice", false, nil)
+	if err != nil {
+		t.Fatalf("Unable to reuse name of revoked API token: %s\n", err.Error())
+	}
+	if records := visible(newAlice.Token, ""); len(records) != 0 {
+		t.Errorf("Expected new token to see no records of the revoked token, got %v\n", records)
+	}
+	if w := testRequest("GET", WebAPIPath+"server/audit?clientid="+alice.ID(), newAlice.Token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for records of another token, got %d\n", http.StatusForbidden, w.Code)
+	}
+	if records := visible("test-admin", "&clientid="+alice.ID()); len(records) != 1 || records[0].Status != 200 {
+		t.Errorf("Expected admin to select records by client ID, got %v\n", records)
+	}
+	if records := visible("test-admin", ""); len(records) != 3 {
+		t.Errorf("Expected admin to see all records, got %v\n", records)
+	}
+}
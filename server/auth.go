@@ -0,0 +1,475 @@
+/*
+	This file supports token-based authentication and per-repo authorization for the
+	HTTP API.  Each API token may be a server administrator and/or be granted a role for
+	particular repos.  Tokens are persisted in the metadata store.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+// Role is the level of access a token has for a repo.  Each role includes the
+// access of lower roles.
+type Role uint8
+
+const (
+	NoRole Role = iota
+	ReadRole
+	WriteRole
+	AdminRole
+)
+
+func (r Role) String() string {
+	switch r {
+	case NoRole:
+		return "none"
+	case ReadRole:
+		return "read"
+	case WriteRole:
+		return "write"
+	case AdminRole:
+		return "admin"
+	default:
+		return fmt.Sprintf("unknown role %d", r)
+	}
+}
+
+// MarshalText fulfills the encoding.TextMarshaler interface so roles are readable in JSON.
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText fulfills the encoding.TextUnmarshaler interface.
+func (r *Role) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "none":
+		*r = NoRole
+	case "read":
+		*r = ReadRole
+	case "write":
+		*r = WriteRole
+	case "admin":
+		*r = AdminRole
+	default:
+		return fmt.Errorf("Unknown role %q: must be 'read', 'write', or 'admin'", string(text))
+	}
+	return nil
+}
+
+// AuthToken describes an API token and the access it grants.
+type AuthToken struct {
+	Token   string
+	Name    string
+	Created time.Time
+
+	// Admin tokens have full access to all repos and can manage tokens.
+	Admin bool
+
+	// Roles maps the root UUID of a repo to the access granted for that repo.
+	Roles map[dvid.UUID]Role
+}
+
+// ID returns a stable identifier of the token that doesn't reveal it, e.g., to attribute
+// audit records to the token even if another token later reuses its name.
+func (t *AuthToken) ID() string {
+	digest := sha256.Sum256([]byte(t.Token))
+	return hex.EncodeToString(digest[:8])
+}
+
+// RoleFor returns the role of the token for the repo with the given root UUID.
+func (t *AuthToken) RoleFor(root dvid.UUID) Role {
+	if t.Admin {
+		return AdminRole
+	}
+	return t.Roles[root]
+}
+
+// The first byte of metadata indices for tokens.  Values below this are used by the
+// datastore package for repo management.
+const authTokenKey byte = 0xA0
+
+func authTokenIndex(token string) []byte {
+	return append([]byte{authTokenKey}, token...)
+}
+
+type authManager struct {
+	sync.RWMutex
+	enabled bool
+	tokens  map[string]*AuthToken
+}
+
+var auth = authManager{tokens: make(map[string]*AuthToken)}
+
+// EnableAuth loads any stored tokens and requires valid tokens for subsequent HTTP
+// requests.  If a non-empty admin token is given, it is accepted as an admin token
+// but not stored, which allows bootstrapping of token management.
+func EnableAuth(adminToken string) error {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	ctx := storage.NewMetadataContext()
+	keyvalues, err := store.GetRange(ctx, []byte{authTokenKey}, []byte{authTokenKey, 0xFF})
+	if err != nil {
+		return fmt.Errorf("Unable to load API tokens: %s", err.Error())
+	}
+
+	auth.Lock()
+	defer auth.Unlock()
+	for _, kv := range keyvalues {
+		token := new(AuthToken)
+		dec := gob.NewDecoder(bytes.NewBuffer(kv.V))
+		if err := dec.Decode(token); err != nil {
+			return fmt.Errorf("Could not decode stored API token: %s", err.Error())
+		}
+		auth.tokens[token.Token] = token
+	}
+	if adminToken != "" {
+		auth.tokens[adminToken] = &AuthToken{
+			Token:   adminToken,
+			Name:    "configured admin",
+			Created: time.Now(),
+			Admin:   true,
+		}
+	}
+	auth.enabled = true
+	dvid.Infof("Authentication enabled with %d stored API tokens.\n", len(keyvalues))
+	return nil
+}
+
+// AuthEnabled returns true if HTTP requests require API tokens.
+func AuthEnabled() bool {
+	auth.RLock()
+	defer auth.RUnlock()
+	return auth.enabled
+}
+
+// NewAuthToken creates and stores a new random API token.  The name must be non-empty
+// and not used by another token.
+func NewAuthToken(name string, admin bool, roles map[dvid.UUID]Role) (*AuthToken, error) {
+	if name == "" {
+		return nil, fmt.Errorf("API token requires a name")
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("Unable to generate API token: %s", err.Error())
+	}
+	if roles == nil {
+		roles = make(map[dvid.UUID]Role)
+	}
+	token := &AuthToken{
+		Token:   hex.EncodeToString(b),
+		Name:    name,
+		Created: time.Now(),
+		Admin:   admin,
+		Roles:   roles,
+	}
+	if err := putAuthToken(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// SetAuthRoles replaces the repo roles of an existing token.
+func SetAuthRoles(tokenStr string, roles map[dvid.UUID]Role) (*AuthToken, error) {
+	auth.RLock()
+	old, found := auth.tokens[tokenStr]
+	auth.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("No API token %q", tokenStr)
+	}
+	token := *old
+	token.Roles = roles
+	if err := putAuthToken(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeAuthToken deletes an API token.
+func RevokeAuthToken(tokenStr string) error {
+	auth.Lock()
+	defer auth.Unlock()
+	if _, found := auth.tokens[tokenStr]; !found {
+		return fmt.Errorf("No API token %q", tokenStr)
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(storage.NewMetadataContext(), authTokenIndex(tokenStr)); err != nil {
+		return err
+	}
+	delete(auth.tokens, tokenStr)
+	return nil
+}
+
+// AuthTokens returns all tokens sorted by creation time.
+func AuthTokens() []*AuthToken {
+	auth.RLock()
+	defer auth.RUnlock()
+	tokens := make([]*AuthToken, 0, len(auth.tokens))
+	for _, token := range auth.tokens {
+		tokens = append(tokens, token)
+	}
+	for i := 1; i < len(tokens); i++ {
+		for j := i; j > 0 && tokens[j].Created.Before(tokens[j-1].Created); j-- {
+			tokens[j], tokens[j-1] = tokens[j-1], tokens[j]
+		}
+	}
+	return tokens
+}
+
+func putAuthToken(token *AuthToken) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(token); err != nil {
+		return err
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	auth.Lock()
+	defer auth.Unlock()
+	for _, other := range auth.tokens {
+		if other.Name == token.Name && other.Token != token.Token {
+			return fmt.Errorf("API token name %q is already used", token.Name)
+		}
+	}
+	if err := store.Put(storage.NewMetadataContext(), authTokenIndex(token.Token), buf.Bytes()); err != nil {
+		return err
+	}
+	auth.tokens[token.Token] = token
+	return nil
+}
+
+// requestToken returns the token given via an "Authorization: Bearer" header, a
+// "token" query string, or a console session cookie.
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	return consoleToken(r)
+}
+
+// getAuthToken returns the token for a request or nil if there's no valid token.
+func getAuthToken(r *http.Request) *AuthToken {
+	tokenStr := requestToken(r)
+	if tokenStr == "" {
+		return nil
+	}
+	auth.RLock()
+	defer auth.RUnlock()
+	return auth.tokens[tokenStr]
+}
+
+// requestClient returns the name of the request's API token or, if none, its remote
+// address, to attribute mutations.
+func requestClient(r *http.Request) string {
+	if token := getAuthToken(r); token != nil {
+		return token.Name
+	}
+	return r.RemoteAddr
+}
+
+// Unauthorized writes a 401 (no valid token) or 403 (insufficient role) response.
+func Unauthorized(w http.ResponseWriter, r *http.Request, status int, message string) {
+	errorMsg := fmt.Sprintf("ERROR: %s (%s).", message, r.URL.Path)
+	dvid.Infof(errorMsg)
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dvid"`)
+	}
+	http.Error(w, errorMsg, status)
+}
+
+// authPublic returns true if the request can be served without a token.
+func authPublic(r *http.Request) bool {
+	if r.Method == "OPTIONS" || isConsoleLogin(r) {
+		return true
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	path := r.URL.Path
+	return strings.HasPrefix(path, "/api/help") || strings.HasPrefix(path, "/interface") ||
+		!strings.HasPrefix(path, WebAPIPath)
+}
+
+// authHandler is middleware that rejects requests without a valid token when
+// authentication is enabled.  Server-level requests other than GET require an admin token.
+func authHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !AuthEnabled() || authPublic(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// Share tokens are fully checked by repoAuthorizer once the repo is selected.
+		if requestShare(r) != "" && shareAllowed(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		token := getAuthToken(r)
+		if token == nil {
+			Unauthorized(w, r, http.StatusUnauthorized, "Valid API token required")
+			return
+		}
+		repoLevel := strings.HasPrefix(r.URL.Path, WebAPIPath+"repo/") ||
+			strings.HasPrefix(r.URL.Path, WebAPIPath+"node/")
+		if !repoLevel && r.Method != "GET" && r.Method != "HEAD" && !token.Admin {
+			Unauthorized(w, r, http.StatusForbidden, "Admin API token required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// repoAuthorizer is middleware, used after repoSelector, that checks the request token
+// has sufficient role for the selected repo.  Reads require the read role, modifying
+// data requires the write role, and modifying the repo itself requires the admin role.
+// Requests with a share token instead must be within the scope of the share.
+func repoAuthorizer(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !AuthEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if requestShare(r) != "" && getAuthToken(r) == nil {
+			if checkShare(c, w, r) {
+				h.ServeHTTP(w, r)
+			}
+			return
+		}
+		token := getAuthToken(r)
+		if token == nil {
+			Unauthorized(w, r, http.StatusUnauthorized, "Valid API token required")
+			return
+		}
+		repo, ok := c.Env["repo"].(datastore.Repo)
+		if !ok {
+			BadRequest(w, r, "Unable to get repo for authorization")
+			return
+		}
+		required := ReadRole
+		if r.Method != "GET" && r.Method != "HEAD" && !isSessionRequest(r) {
+			if strings.HasPrefix(r.URL.Path, WebAPIPath+"repo/") {
+				required = AdminRole
+			} else {
+				required = WriteRole
+			}
+		}
+		if token.RoleFor(repo.RootUUID()) < required {
+			Unauthorized(w, r, http.StatusForbidden,
+				fmt.Sprintf("API token requires %s role for repo %s", required, repo.RootUUID()))
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ---- Token management handlers, which require admin tokens.
+
+type authTokenRequest struct {
+	Name  string
+	Admin bool
+	Roles map[dvid.UUID]Role
+}
+
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !AuthEnabled() {
+		BadRequest(w, r, "Authentication is not enabled on this server")
+		return false
+	}
+	token := getAuthToken(r)
+	if token == nil || !token.Admin {
+		Unauthorized(w, r, http.StatusForbidden, "Admin API token required")
+		return false
+	}
+	return true
+}
+
+func writeAuthJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+}
+
+func tokensGetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeAuthJSON(w, r, AuthTokens())
+}
+
+func tokensPostHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req authTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, r, "Malformed JSON request for new API token: %s", err.Error())
+		return
+	}
+	token, err := NewAuthToken(req.Name, req.Admin, req.Roles)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Created API token %q (admin %t)\n", token.Name, token.Admin)
+	writeAuthJSON(w, r, token)
+}
+
+func tokenRolesHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var roles map[dvid.UUID]Role
+	if err := json.NewDecoder(r.Body).Decode(&roles); err != nil {
+		BadRequest(w, r, "Malformed JSON roles: %s", err.Error())
+		return
+	}
+	token, err := SetAuthRoles(c.URLParams["token"], roles)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	writeAuthJSON(w, r, token)
+}
+
+func tokenDeleteHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := RevokeAuthToken(c.URLParams["token"]); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Revoked API token %s\n", c.URLParams["token"])
+}
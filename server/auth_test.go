@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// enableTestAuth turns on authentication with an admin token for a test.
+func enableTestAuth(t *testing.T, adminToken string) {
+	if err := EnableAuth(adminToken); err != nil {
+		t.Fatalf("Unable to enable authentication: %s\n", err.Error())
+	}
+}
+
+// resetAuth turns off authentication and forgets all tokens.
+func resetAuth() {
+	auth.Lock()
+	auth.enabled = false
+	auth.tokens = make(map[string]*AuthToken)
+	auth.Unlock()
+}
+
+// testRequest sends a request through the HTTP API with an optional API token and
+// returns the response.
+func testRequest(method, urlStr, token string, body io.Reader) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, urlStr, body)
+	if err != nil {
+		panic(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	return w
+}
+
+func TestAuthRoles(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	otherRepo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+
+	enableTestAuth(t, "test-admin")
+	newToken := func(name string, roles map[dvid.UUID]Role) string {
+		token, err := NewAuthToken(name, false, roles)
+		if err != nil {
+			t.Fatalf("Unable to create API token %q: %s\n", name, err.Error())
+		}
+		return token.Token
+	}
+	tokens := map[string]string{
+		"none":  "",
+		"bad":   "not-a-token",
+		"other": newToken("other", map[dvid.UUID]Role{otherRepo.RootUUID(): AdminRole}),
+		"read":  newToken("reader", map[dvid.UUID]Role{root: ReadRole}),
+		"write": newToken("writer", map[dvid.UUID]Role{root: WriteRole}),
+		"admin": newToken("repo admin", map[dvid.UUID]Role{root: AdminRole}),
+		"super": "test-admin",
+	}
+
+	repoInfo := fmt.Sprintf("%srepo/%s/info", WebAPIPath, root)
+	repoNote := fmt.Sprintf("%srepo/%s/note", WebAPIPath, root)
+	nodeMeta := fmt.Sprintf("%snode/%s/metadata", WebAPIPath, root)
+	nodeNote := fmt.Sprintf("%snode/%s/note", WebAPIPath, root)
+	serverTokens := WebAPIPath + "server/tokens"
+
+	type check struct {
+		method, url string
+		body        string
+
+		// expected status by token
+		status map[string]int
+	}
+	const ok, unauth, forbidden = http.StatusOK, http.StatusUnauthorized, http.StatusForbidden
+	checks := []check{
+		{"GET", repoInfo, "", map[string]int{
+			"none": unauth, "bad": unauth, "other": forbidden,
+			"read": ok, "write": ok, "admin": ok, "super": ok,
+		}},
+		{"POST", repoNote, `{"note": "repo note"}`, map[string]int{
+			"none": unauth, "bad": unauth, "other": forbidden,
+			"read": forbidden, "write": forbidden, "admin": ok, "super": ok,
+		}},
+		{"GET", nodeMeta, "", map[string]int{
+			"none": unauth, "bad": unauth, "other": forbidden,
+			"read": ok, "write": ok, "admin": ok, "super": ok,
+		}},
+		{"POST", nodeNote, `{"note": "node note"}`, map[string]int{
+			"none": unauth, "bad": unauth, "other": forbidden,
+			"read": forbidden, "write": ok, "admin": ok, "super": ok,
+		}},
+		{"GET", serverTokens, "", map[string]int{
+			"none": unauth, "bad": unauth, "other": forbidden,
+			"read": forbidden, "write": forbidden, "admin": forbidden, "super": ok,
+		}},
+		{"POST", serverTokens, `{"Name": "new token"}`, map[string]int{
+			"none": unauth, "bad": unauth, "other": forbidden,
+			"read": forbidden, "write": forbidden, "admin": forbidden, "super": ok,
+		}},
+	}
+	for _, c := range checks {
+		for name, expected := range c.status {
+			var body io.Reader
+			if c.body != "" {
+				body = strings.NewReader(c.body)
+			}
+			w := testRequest(c.method, c.url, tokens[name], body)
+			if w.Code != expected {
+				t.Errorf("%s %s with %q token: expected status %d, got %d: %s\n",
+					c.method, c.url, name, expected, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	// Help is public, and revoked tokens are rejected.
+	if w := testRequest("GET", WebAPIPath+"help", "", nil); w.Code != ok {
+		t.Errorf("Expected public help, got status %d\n", w.Code)
+	}
+	if err := RevokeAuthToken(tokens["read"]); err != nil {
+		t.Fatalf("Unable to revoke token: %s\n", err.Error())
+	}
+	if w := testRequest("GET", repoInfo, tokens["read"], nil); w.Code != unauth {
+		t.Errorf("Expected revoked token to be rejected, got status %d\n", w.Code)
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	resetAuth()
+
+	repo, _ := tests.NewRepo()
+	repoInfo := fmt.Sprintf("%srepo/%s/info", WebAPIPath, repo.RootUUID())
+	if w := testRequest("GET", repoInfo, "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected access without authentication, got status %d\n", w.Code)
+	}
+	if w := testRequest("GET", WebAPIPath+"server/tokens", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected token management to fail without authentication, got status %d\n", w.Code)
+	}
+}
+
+func TestRoleText(t *testing.T) {
+	for _, role := range []Role{NoRole, ReadRole, WriteRole, AdminRole} {
+		text, err := role.MarshalText()
+		if err != nil {
+			t.Fatalf("Error marshaling role %s: %s\n", role, err.Error())
+		}
+		var parsed Role
+		if err := parsed.UnmarshalText(text); err != nil || parsed != role {
+			t.Errorf("Role %s didn't round-trip through text %q\n", role, text)
+		}
+	}
+	var role Role
+	if err := role.UnmarshalText([]byte("owner")); err == nil {
+		t.Errorf("Expected error for unknown role\n")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+	This file supports time budgets for expensive analytic queries.  A request with a
+	"budget_ms" query string is answered with the best result computed within the budget.
+	If the computation was cut short, the response has a Dvid-Partial header and a
+	Dvid-Continuation token that can be passed back as the "continue" query string to
+	resume the computation and get a more complete result.  Continuation state is kept in
+	memory for a limited time.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// PartialHeader is set to "true" on responses with partial results.
+	PartialHeader = "Dvid-Partial"
+
+	// ContinuationHeader gives the token for resuming a partial result.
+	ContinuationHeader = "Dvid-Continuation"
+
+	// ContinuationTTL is how long the state of a partial result is kept for resumption.
+	ContinuationTTL = 10 * time.Minute
+
+	// Maximum number of partial results kept for resumption.
+	maxContinuations = 1000
+)
+
+type continuation struct {
+	path    string
+	client  string
+	state   interface{}
+	expires time.Time
+}
+
+var (
+	continuations   = make(map[string]continuation)
+	continuationsMu sync.Mutex
+)
+
+// QueryBudget limits the time spent computing a query result and holds any state of a
+// prior partial result being resumed.  A nil budget is unlimited.
+type QueryBudget struct {
+	deadline time.Time
+	path     string
+	client   string
+	state    interface{}
+}
+
+// BudgetFromQuery returns the budget given by the "budget_ms" query string and the state
+// given by a "continue" token from a prior partial result of the same query.  Returns a
+// nil budget if neither is given.
+func BudgetFromQuery(r *http.Request) (*QueryBudget, error) {
+	queryValues := r.URL.Query()
+	budgetStr := queryValues.Get("budget_ms")
+	token := queryValues.Get("continue")
+	if budgetStr == "" && token == "" {
+		return nil, nil
+	}
+	b := &QueryBudget{path: r.URL.Path, client: requestToken(r)}
+	if budgetStr != "" {
+		ms, err := strconv.Atoi(budgetStr)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("Bad budget_ms %q: must be a positive number of milliseconds", budgetStr)
+		}
+		b.deadline = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	}
+	if token != "" {
+		continuationsMu.Lock()
+		c, found := continuations[token]
+		delete(continuations, token)
+		continuationsMu.Unlock()
+		if !found || time.Now().After(c.expires) {
+			return nil, fmt.Errorf("Continuation token %q is unknown or has expired", token)
+		}
+		if c.path != b.path || c.client != b.client {
+			return nil, fmt.Errorf("Continuation token %q is for a different query", token)
+		}
+		b.state = c.state
+	}
+	return b, nil
+}
+
+// Exceeded returns true if the time budget has been used up.
+func (b *QueryBudget) Exceeded() bool {
+	if b == nil || b.deadline.IsZero() {
+		return false
+	}
+	return time.Now().After(b.deadline)
+}
+
+// State returns the state of the partial result being resumed or nil if there is none.
+func (b *QueryBudget) State() interface{} {
+	if b == nil {
+		return nil
+	}
+	return b.state
+}
+
+// SetPartial marks the response as a partial result by setting the partial and
+// continuation headers.  The given state is returned by State() when the query is
+// resumed using the continuation token.  Must be called before the response body
+// is written.
+func (b *QueryBudget) SetPartial(w http.ResponseWriter, state interface{}) error {
+	token, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	continuationsMu.Lock()
+	for t, c := range continuations {
+		if now.After(c.expires) {
+			delete(continuations, t)
+		}
+	}
+	if len(continuations) >= maxContinuations {
+		continuationsMu.Unlock()
+		return fmt.Errorf("Too many partial results awaiting continuation, try a larger budget")
+	}
+	continuations[token] = continuation{b.path, b.client, state, now.Add(ContinuationTTL)}
+	continuationsMu.Unlock()
+
+	w.Header().Set(PartialHeader, "true")
+	w.Header().Set(ContinuationHeader, token)
+	return nil
+}
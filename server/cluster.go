@@ -0,0 +1,585 @@
+/*
+	This file supports a cluster mode where DVID servers partition data across their local
+	storage engines by consistent hashing of block keys.  Each member forwards storage
+	operations on keys it doesn't own to the owning member through internal endpoints.
+	Repo metadata is held by a coordinator member, so requests that modify repos are
+	forwarded or redirected to the coordinator, and other members reload repo metadata
+	when gossip shows the coordinator's metadata has changed.
+
+	Members learn of each other by gossip: each member periodically sends its member table,
+	with heartbeat counters, to a random member and merges the reply.  New members join by
+	gossiping with any configured member.  A member whose heartbeat stops advancing is
+	reported as down but keeps its place in the hash ring, since data isn't replicated and
+	moving its keys would hide them.  After members join, data is moved to its new owners
+	with the "rebalance" command.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// DefaultGossipInterval is the default time between gossip exchanges.
+	DefaultGossipInterval = 2 * time.Second
+
+	// DefaultMemberDownTime is the default time without a heartbeat after which a
+	// member is reported as down.
+	DefaultMemberDownTime = 30 * time.Second
+
+	clusterSecretHeader    = "X-Dvid-Cluster-Secret"
+	clusterForwardedHeader = "X-Dvid-Cluster-Forwarded"
+)
+
+// ClusterConfig gives the settings of a cluster member.
+type ClusterConfig struct {
+	// Self is the URL of this server, e.g., "http://emdata1:8000", as reached by members.
+	Self string
+
+	// Coordinator is the URL of the member holding repo metadata.
+	Coordinator string
+
+	// Members are the URLs of other known members.  Data is partitioned across these
+	// members and any that join later.
+	Members []string
+
+	// Secret is sent with requests between members and must match on all members.
+	Secret string
+
+	GossipInterval time.Duration
+	DownTime       time.Duration
+
+	// If true, requests modifying repos are redirected rather than forwarded to the
+	// coordinator.
+	Redirect bool
+}
+
+type clusterMember struct {
+	URL       string
+	Heartbeat uint64
+
+	// Epoch is the time of the coordinator's last change to repo metadata.
+	Epoch int64
+
+	seen time.Time
+}
+
+type clusterState struct {
+	sync.RWMutex
+	enabled  bool
+	config   ClusterConfig
+	members  map[string]*clusterMember
+	peers    map[string]*clusterPeer
+	loaded   int64 // metadata epoch of the coordinator last loaded
+	forward  *httputil.ReverseProxy
+	coordURL *url.URL
+}
+
+var cluster clusterState
+
+var clusterClient = &http.Client{Timeout: 60 * time.Second}
+
+// EnableCluster makes this server a member of a cluster partitioning data across its
+// members.
+func EnableCluster(config ClusterConfig) error {
+	if config.Self == "" || config.Coordinator == "" {
+		return fmt.Errorf("Cluster mode requires this server's and the coordinator's URLs")
+	}
+	if config.Secret == "" {
+		return fmt.Errorf("Cluster mode requires a secret shared by all members")
+	}
+	coordURL, err := url.Parse(config.Coordinator)
+	if err != nil || coordURL.Scheme == "" || coordURL.Host == "" {
+		return fmt.Errorf("Bad cluster coordinator URL %q", config.Coordinator)
+	}
+	if config.GossipInterval <= 0 {
+		config.GossipInterval = DefaultGossipInterval
+	}
+	if config.DownTime <= 0 {
+		config.DownTime = DefaultMemberDownTime
+	}
+
+	cluster.Lock()
+	cluster.config = config
+	cluster.members = map[string]*clusterMember{
+		config.Self: {URL: config.Self, Epoch: time.Now().UnixNano(), seen: time.Now()},
+	}
+	cluster.peers = make(map[string]*clusterPeer)
+	for _, member := range append(config.Members, config.Coordinator) {
+		if _, found := cluster.members[member]; !found {
+			cluster.members[member] = &clusterMember{URL: member}
+		}
+	}
+	cluster.coordURL = coordURL
+	cluster.forward = httputil.NewSingleHostReverseProxy(coordURL)
+	others := cluster.otherMembers()
+	cluster.enabled = true
+	cluster.Unlock()
+
+	if err := storage.EnableCluster(config.Self, config.Coordinator, others, clusterPeerFor); err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(config.GossipInterval) {
+			cluster.gossip()
+		}
+	}()
+	dvid.Infof("Cluster member %s with coordinator %s and %d other members.\n",
+		config.Self, config.Coordinator, len(others))
+	return nil
+}
+
+func (s *clusterState) isEnabled() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.enabled
+}
+
+func (s *clusterState) isCoordinator() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.config.Self == s.config.Coordinator
+}
+
+// otherMembers returns the sorted URLs of all members but this one.  Must be called with
+// the lock held.
+func (s *clusterState) otherMembers() []string {
+	var others []string
+	for member := range s.members {
+		if member != s.config.Self {
+			others = append(others, member)
+		}
+	}
+	sort.Strings(others)
+	return others
+}
+
+// metadataChanged marks a change of repo metadata by the coordinator.
+func (s *clusterState) metadataChanged() {
+	s.Lock()
+	s.members[s.config.Self].Epoch = time.Now().UnixNano()
+	s.Unlock()
+}
+
+// table returns a copy of the member table after advancing this member's heartbeat.
+func (s *clusterState) table() []clusterMember {
+	s.Lock()
+	defer s.Unlock()
+	self := s.members[s.config.Self]
+	self.Heartbeat++
+	self.seen = time.Now()
+	table := make([]clusterMember, 0, len(s.members))
+	for _, m := range s.members {
+		table = append(table, *m)
+	}
+	return table
+}
+
+// merge updates the member table with a received one.  New members are added to the
+// hash ring, and repo metadata is reloaded if the coordinator changed it.
+func (s *clusterState) merge(table []clusterMember) {
+	s.Lock()
+	var joined bool
+	for _, received := range table {
+		if received.URL == s.config.Self {
+			continue
+		}
+		m, found := s.members[received.URL]
+		if !found {
+			dvid.Infof("Cluster member %s joined.\n", received.URL)
+			m = &clusterMember{URL: received.URL}
+			s.members[received.URL] = m
+			joined = true
+		}
+		if received.Heartbeat > m.Heartbeat {
+			m.Heartbeat = received.Heartbeat
+			m.seen = time.Now()
+		}
+		if received.Epoch > m.Epoch {
+			m.Epoch = received.Epoch
+		}
+	}
+	var reload bool
+	if coord := s.members[s.config.Coordinator]; s.config.Self != s.config.Coordinator && coord.Epoch > s.loaded {
+		s.loaded = coord.Epoch
+		reload = true
+	}
+	others := s.otherMembers()
+	s.Unlock()
+
+	if joined {
+		storage.SetClusterMembers(others)
+	}
+	if reload {
+		if err := datastore.ReloadMetadata(); err != nil {
+			dvid.Errorf("Unable to reload repo metadata from cluster coordinator: %s\n", err.Error())
+		}
+	}
+}
+
+// gossip exchanges member tables with a random member.
+func (s *clusterState) gossip() {
+	table := s.table()
+	s.RLock()
+	others := s.otherMembers()
+	s.RUnlock()
+	if len(others) == 0 {
+		return
+	}
+	member := others[rand.Intn(len(others))]
+	body, err := json.Marshal(table)
+	if err != nil {
+		dvid.Errorf("Unable to encode cluster gossip: %s\n", err.Error())
+		return
+	}
+	resp, err := clusterPeerFor(member).(*clusterPeer).do("POST", "gossip", nil, body)
+	if err != nil {
+		dvid.Debugf("Unable to gossip with cluster member %s: %s\n", member, err.Error())
+		return
+	}
+	var received []clusterMember
+	if err := json.Unmarshal(resp, &received); err != nil {
+		dvid.Errorf("Bad gossip from cluster member %s: %s\n", member, err.Error())
+		return
+	}
+	s.merge(received)
+}
+
+// MarshalJSON returns the state of each member.
+func (s *clusterState) MarshalJSON() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	type memberJSON struct {
+		URL       string
+		Heartbeat uint64
+		LastSeen  time.Time `json:",omitempty"`
+		Down      bool
+	}
+	names := make([]string, 0, len(s.members))
+	for name := range s.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	members := make([]memberJSON, 0, len(names))
+	for _, name := range names {
+		m := s.members[name]
+		members = append(members, memberJSON{
+			URL:       m.URL,
+			Heartbeat: m.Heartbeat,
+			LastSeen:  m.seen,
+			Down:      time.Since(m.seen) > s.config.DownTime,
+		})
+	}
+	return json.Marshal(struct {
+		Self        string
+		Coordinator string
+		Members     []memberJSON
+	}{s.config.Self, s.config.Coordinator, members})
+}
+
+// ---- Storage access to other members
+
+// clusterPeer forwards storage operations to a member's internal endpoints.
+type clusterPeer struct {
+	url    string
+	secret string
+}
+
+func clusterPeerFor(member string) storage.ClusterPeer {
+	cluster.Lock()
+	defer cluster.Unlock()
+	peer, found := cluster.peers[member]
+	if !found {
+		peer = &clusterPeer{url: member, secret: cluster.config.Secret}
+		cluster.peers[member] = peer
+	}
+	return peer
+}
+
+// do sends a request to an internal endpoint of the member and returns the response
+// body, or nil if the endpoint returns not found.
+func (p *clusterPeer) do(method, endpoint string, query url.Values, body []byte) ([]byte, error) {
+	u := p.url + WebAPIPath + "cluster/" + endpoint
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(clusterSecretHeader, p.secret)
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+}
+
+func keyRangeQuery(kStart, kEnd []byte) url.Values {
+	return url.Values{"beg": {hex.EncodeToString(kStart)}, "end": {hex.EncodeToString(kEnd)}}
+}
+
+func (p *clusterPeer) Get(k []byte) ([]byte, error) {
+	return p.do("GET", "kv", url.Values{"key": {hex.EncodeToString(k)}}, nil)
+}
+
+func (p *clusterPeer) GetRange(kStart, kEnd []byte, keysOnly bool) ([]*storage.KeyValue, error) {
+	query := keyRangeQuery(kStart, kEnd)
+	if keysOnly {
+		query.Set("keysonly", "true")
+	}
+	data, err := p.do("GET", "range", query, nil)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var kvs []*storage.KeyValue
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&kvs); err != nil {
+		return nil, err
+	}
+	return kvs, nil
+}
+
+func (p *clusterPeer) Write(writes []storage.ClusterWrite) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(writes); err != nil {
+		return err
+	}
+	_, err := p.do("POST", "write", nil, buf.Bytes())
+	return err
+}
+
+func (p *clusterPeer) DeleteRange(kStart, kEnd []byte) error {
+	_, err := p.do("DELETE", "range", keyRangeQuery(kStart, kEnd), nil)
+	return err
+}
+
+// ---- Routing of requests
+
+// clusterRepoRequest returns true if the request modifies repo metadata, which is only
+// done by the coordinator.  Node requests may modify data but not repos.
+func clusterRepoRequest(r *http.Request) bool {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return false
+	}
+	return !strings.HasPrefix(r.URL.Path, WebAPIPath+"node/")
+}
+
+// validClusterSecret returns true if the request has the secret shared by members.
+func validClusterSecret(r *http.Request) bool {
+	cluster.RLock()
+	secret := cluster.config.Secret
+	cluster.RUnlock()
+	given := r.Header.Get(clusterSecretHeader)
+	return secret != "" && subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// clusterHandler is middleware that sends requests modifying repos, and node requests
+// for UUIDs not yet known to this member, to the coordinator.  Requests forwarded by
+// another member are handled here, but only if they have the cluster secret, so clients
+// can't bypass forwarding.
+func clusterHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !cluster.isEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		forwarded := r.Header.Get(clusterForwardedHeader) != "" && validClusterSecret(r)
+		r.Header.Del(clusterForwardedHeader)
+		r.Header.Del(clusterSecretHeader)
+		if cluster.isCoordinator() || forwarded {
+			h.ServeHTTP(w, r)
+			if cluster.isCoordinator() && clusterRepoRequest(r) {
+				cluster.metadataChanged()
+			}
+			return
+		}
+		toCoordinator := clusterRepoRequest(r)
+		if uuidStr, ok := proxiedUUID(r.URL.Path); ok && !toCoordinator {
+			uuidStr = strings.SplitN(uuidStr, ":", 2)[0]
+			if _, _, err := datastore.MatchingUUID(uuidStr); err != nil {
+				toCoordinator = true
+			}
+		}
+		if !toCoordinator {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cluster.RLock()
+		redirect := cluster.config.Redirect
+		coordURL := cluster.coordURL
+		forward := cluster.forward
+		secret := cluster.config.Secret
+		cluster.RUnlock()
+		if redirect {
+			http.Redirect(w, r, coordURL.String()+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		dvid.Debugf("Forwarding %s %s to cluster coordinator %s\n", r.Method, r.URL, coordURL)
+		r.Header.Set(clusterForwardedHeader, "true")
+		r.Header.Set(clusterSecretHeader, secret)
+		forward.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// clusterAuth is middleware for internal cluster endpoints that requires the cluster
+// secret.
+func clusterAuth(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !cluster.isEnabled() {
+			BadRequest(w, r, "Cluster mode is not enabled on this server")
+			return
+		}
+		if !validClusterSecret(r) {
+			Unauthorized(w, r, http.StatusForbidden, "Cluster secret required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ---- Cluster handlers
+
+func clusterInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if !cluster.isEnabled() {
+		BadRequest(w, r, "Cluster mode is not enabled on this server")
+		return
+	}
+	jsonBytes, err := cluster.MarshalJSON()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+}
+
+func clusterGossipHandler(w http.ResponseWriter, r *http.Request) {
+	var received []clusterMember
+	if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+		BadRequest(w, r, "Bad gossip: %s", err.Error())
+		return
+	}
+	cluster.merge(received)
+	jsonBytes, err := json.Marshal(cluster.table())
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+// clusterKeys returns the hex-encoded keys of the given query strings.
+func clusterKeys(r *http.Request, names ...string) ([][]byte, error) {
+	keys := make([][]byte, len(names))
+	for i, name := range names {
+		k, err := hex.DecodeString(r.URL.Query().Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("Bad %s key: %s", name, err.Error())
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
+func clusterGetHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := clusterKeys(r, "key")
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	peer, err := storage.LocalClusterPeer()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	v, err := peer.Get(keys[0])
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	if v == nil {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(v)
+}
+
+func clusterRangeHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := clusterKeys(r, "beg", "end")
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	peer, err := storage.LocalClusterPeer()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	if r.Method == "DELETE" {
+		if err := peer.DeleteRange(keys[0], keys[1]); err != nil {
+			BadRequest(w, r, err.Error())
+		}
+		return
+	}
+	kvs, err := peer.GetRange(keys[0], keys[1], r.URL.Query().Get("keysonly") == "true")
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := gob.NewEncoder(w).Encode(kvs); err != nil {
+		dvid.Errorf("Unable to send key-value pairs to cluster member: %s\n", err.Error())
+	}
+}
+
+func clusterWriteHandler(w http.ResponseWriter, r *http.Request) {
+	var writes []storage.ClusterWrite
+	if err := gob.NewDecoder(r.Body).Decode(&writes); err != nil {
+		BadRequest(w, r, "Bad cluster writes: %s", err.Error())
+		return
+	}
+	peer, err := storage.LocalClusterPeer()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	if err := peer.Write(writes); err != nil {
+		BadRequest(w, r, err.Error())
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+)
+
+// setTestCluster makes this server a cluster member without starting gossip or
+// partitioning storage.
+func setTestCluster(t *testing.T, self, coordinator, secret string) {
+	coordURL, err := url.Parse(coordinator)
+	if err != nil {
+		t.Fatalf("Bad coordinator URL %q: %s\n", coordinator, err.Error())
+	}
+	cluster.Lock()
+	cluster.enabled = true
+	cluster.config = ClusterConfig{Self: self, Coordinator: coordinator, Secret: secret}
+	cluster.members = map[string]*clusterMember{self: {URL: self}}
+	cluster.coordURL = coordURL
+	cluster.forward = httputil.NewSingleHostReverseProxy(coordURL)
+	cluster.Unlock()
+}
+
+// resetCluster turns off cluster mode.
+func resetCluster() {
+	cluster.Lock()
+	cluster.enabled = false
+	cluster.config = ClusterConfig{}
+	cluster.members = nil
+	cluster.coordURL = nil
+	cluster.forward = nil
+	cluster.Unlock()
+}
+
+func TestClusterForwarding(t *testing.T) {
+	defer resetCluster()
+
+	coordinator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "coordinator|%s|%s", r.Header.Get(clusterSecretHeader), r.Header.Get(clusterForwardedHeader))
+	}))
+	defer coordinator.Close()
+	setTestCluster(t, "http://member:8000", coordinator.URL, "cluster-secret")
+
+	handler := clusterHandler(&web.C{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "local|%s|%s", r.Header.Get(clusterSecretHeader), r.Header.Get(clusterForwardedHeader))
+	}))
+	send := func(forwarded, secret string) string {
+		r, _ := http.NewRequest("POST", WebAPIPath+"repo/abc/note", nil)
+		if forwarded != "" {
+			r.Header.Set(clusterForwardedHeader, forwarded)
+		}
+		if secret != "" {
+			r.Header.Set(clusterSecretHeader, secret)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	// Repo changes are forwarded to the coordinator with the cluster secret.
+	const toCoordinator = "coordinator|cluster-secret|true"
+	if got := send("", ""); got != toCoordinator {
+		t.Errorf("Expected repo change to be forwarded, got %q\n", got)
+	}
+
+	// A forwarded header is only honored with the cluster secret.
+	if got := send("true", ""); got != toCoordinator {
+		t.Errorf("Expected forwarded header without secret to be ignored, got %q\n", got)
+	}
+	if got := send("true", "wrong-secret"); got != toCoordinator {
+		t.Errorf("Expected forwarded header with wrong secret to be ignored, got %q\n", got)
+	}
+	if got := send("true", "cluster-secret"); got != "local||" {
+		t.Errorf("Expected forwarded request with secret to be handled locally without cluster headers, got %q\n", got)
+	}
+
+	// The coordinator handles everything locally.
+	setTestCluster(t, coordinator.URL, coordinator.URL, "cluster-secret")
+	if got := send("true", "wrong-secret"); got != "local||" {
+		t.Errorf("Expected coordinator to handle request locally, got %q\n", got)
+	}
+}
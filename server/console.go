@@ -0,0 +1,213 @@
+/*
+	This file supports login sessions for the embedded web console when authentication
+	is enabled.  Logging in with an API token sets an HTTP-only session cookie that stands
+	in for the token on later requests, so the console never keeps the token in page
+	scripts.  Because browsers send cookies with any request to the server, requests other
+	than GET, HEAD, and OPTIONS made with the cookie must also echo the session's CSRF token
+	in a header, which pages on other sites can't read.  Console sessions are kept in memory
+	and end on logout, after being idle, or when their API token is revoked.
+*/
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// ConsoleCookie is the name of the cookie holding a console session ID.
+	ConsoleCookie = "dvid-console"
+
+	// CSRFHeader is the header that must give the CSRF token of a console session for
+	// requests that may modify data.
+	CSRFHeader = "X-Dvid-Csrf-Token"
+
+	// Console sessions unused for this long are ended.
+	consoleSessionIdle = 8 * time.Hour
+)
+
+// consoleSession is a logged-in console.  It holds the API token it was created with
+// rather than a copy of its roles, so role changes and revocations apply immediately.
+type consoleSession struct {
+	token    string
+	csrf     string
+	created  time.Time
+	lastUsed time.Time
+}
+
+var consoleSessions = struct {
+	sync.Mutex
+	m map[string]*consoleSession
+}{m: make(map[string]*consoleSession)}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newConsoleSession creates a session for a valid API token.
+func newConsoleSession(tokenStr string) (id string, session *consoleSession, err error) {
+	auth.RLock()
+	_, found := auth.tokens[tokenStr]
+	auth.RUnlock()
+	if !found {
+		return "", nil, fmt.Errorf("Invalid API token")
+	}
+	if id, err = randomHex(16); err != nil {
+		return "", nil, fmt.Errorf("Unable to generate console session ID: %s", err.Error())
+	}
+	csrf, err := randomHex(16)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to generate CSRF token: %s", err.Error())
+	}
+	now := time.Now()
+	session = &consoleSession{token: tokenStr, csrf: csrf, created: now, lastUsed: now}
+
+	consoleSessions.Lock()
+	for sid, s := range consoleSessions.m {
+		if now.Sub(s.lastUsed) > consoleSessionIdle {
+			delete(consoleSessions.m, sid)
+		}
+	}
+	consoleSessions.m[id] = session
+	consoleSessions.Unlock()
+	return id, session, nil
+}
+
+// requestConsoleSession returns the unexpired console session given by a request's
+// cookie, marking it as used, or nil if there is none.
+func requestConsoleSession(r *http.Request) *consoleSession {
+	cookie, err := r.Cookie(ConsoleCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	consoleSessions.Lock()
+	defer consoleSessions.Unlock()
+	session, found := consoleSessions.m[cookie.Value]
+	if !found {
+		return nil
+	}
+	if time.Since(session.lastUsed) > consoleSessionIdle {
+		delete(consoleSessions.m, cookie.Value)
+		return nil
+	}
+	session.lastUsed = time.Now()
+	return session
+}
+
+// consoleToken returns the API token of a request's console session.  Requests that may
+// modify data must give the session's CSRF token, or no token is returned.
+func consoleToken(r *http.Request) string {
+	session := requestConsoleSession(r)
+	if session == nil {
+		return ""
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		csrf := r.Header.Get(CSRFHeader)
+		if subtle.ConstantTimeCompare([]byte(csrf), []byte(session.csrf)) != 1 {
+			dvid.Infof("Rejected console request without valid CSRF token: %s %s\n", r.Method, r.URL.Path)
+			return ""
+		}
+	}
+	return session.token
+}
+
+// isConsoleLogin returns true for console login and logout requests, which are checked
+// by their handlers rather than requiring an API token.
+func isConsoleLogin(r *http.Request) bool {
+	return r.Method == "POST" &&
+		(r.URL.Path == WebAPIPath+"console/login" || r.URL.Path == WebAPIPath+"console/logout")
+}
+
+// consoleSessionInfo is returned on login and for the current session.
+type consoleSessionInfo struct {
+	Name      string
+	Admin     bool
+	CSRFToken string
+	Created   time.Time
+}
+
+func writeConsoleSession(w http.ResponseWriter, r *http.Request, session *consoleSession) {
+	auth.RLock()
+	token, found := auth.tokens[session.token]
+	auth.RUnlock()
+	if !found {
+		Unauthorized(w, r, http.StatusUnauthorized, "API token of console session was revoked")
+		return
+	}
+	writeAuthJSON(w, r, consoleSessionInfo{token.Name, token.Admin, session.csrf, session.created})
+}
+
+// ---- Console session handlers
+
+func consoleLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !AuthEnabled() {
+		BadRequest(w, r, "Authentication is not enabled on this server")
+		return
+	}
+	var login struct {
+		Token string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+		return
+	}
+	id, session, err := newConsoleSession(login.Token)
+	if err != nil {
+		Unauthorized(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ConsoleCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	dvid.Infof("Console login from %s\n", r.RemoteAddr)
+	writeConsoleSession(w, r, session)
+}
+
+func consoleLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if consoleToken(r) == "" {
+		Unauthorized(w, r, http.StatusUnauthorized, "Valid console session and CSRF token required")
+		return
+	}
+	cookie, _ := r.Cookie(ConsoleCookie)
+	consoleSessions.Lock()
+	delete(consoleSessions.m, cookie.Value)
+	consoleSessions.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     ConsoleCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "Logged out of console")
+}
+
+func consoleSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session := requestConsoleSession(r)
+	if session == nil {
+		Unauthorized(w, r, http.StatusUnauthorized, "No console session")
+		return
+	}
+	writeConsoleSession(w, r, session)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// consoleRequest sends a request with a console session cookie and optional CSRF token.
+func consoleRequest(method, urlStr string, cookie *http.Cookie, csrf string, body io.Reader) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, urlStr, body)
+	if err != nil {
+		panic(err)
+	}
+	req.AddCookie(cookie)
+	if csrf != "" {
+		req.Header.Set(CSRFHeader, csrf)
+	}
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	return w
+}
+
+// consoleLogin logs into the console with an API token, returning the session cookie
+// and CSRF token.
+func consoleLogin(t *testing.T, token string) (*http.Cookie, string) {
+	w := testRequest("POST", WebAPIPath+"console/login", "", strings.NewReader(`{"Token": "`+token+`"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Console login failed with status %d: %s\n", w.Code, w.Body.String())
+	}
+	var info consoleSessionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Bad console session JSON: %s\n", err.Error())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != ConsoleCookie {
+		t.Fatalf("Expected console session cookie on login, got %v\n", cookies)
+	}
+	return cookies[0], info.CSRFToken
+}
+
+func TestConsoleSessions(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	noteURL := WebAPIPath + "repo/" + string(repo.RootUUID()) + "/note"
+	enableTestAuth(t, "test-admin")
+	token, err := NewAuthToken("console", false, map[dvid.UUID]Role{repo.RootUUID(): WriteRole})
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+
+	if w := testRequest("POST", WebAPIPath+"console/login", "", strings.NewReader(`{"Token": "bad"}`)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected login with bad token to be rejected, got status %d\n", w.Code)
+	}
+
+	// Login sets an HTTP-only, same-site session cookie.
+	cookie, csrf := consoleLogin(t, token.Token)
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Value == "" {
+		t.Errorf("Expected HTTP-only strict same-site session cookie, got %+v\n", cookie)
+	}
+	if csrf == "" {
+		t.Fatalf("Expected CSRF token on login\n")
+	}
+	if w := consoleRequest("GET", WebAPIPath+"console/session", cookie, "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected session to be readable without CSRF token, got status %d\n", w.Code)
+	}
+
+	// Writes with the cookie need the session's CSRF token.
+	if w := consoleRequest("POST", noteURL, cookie, "", strings.NewReader(`{"note": "a"}`)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected POST without CSRF token to be rejected, got status %d\n", w.Code)
+	}
+	if w := consoleRequest("POST", noteURL, cookie, "wrong", strings.NewReader(`{"note": "a"}`)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected POST with wrong CSRF token to be rejected, got status %d\n", w.Code)
+	}
+	if w := consoleRequest("POST", noteURL, cookie, csrf, strings.NewReader(`{"note": "a"}`)); w.Code != http.StatusOK {
+		t.Errorf("Expected POST with CSRF token to succeed, got status %d: %s\n", w.Code, w.Body.String())
+	}
+
+	// Logout ends the session.
+	if w := consoleRequest("POST", WebAPIPath+"console/logout", cookie, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected logout without CSRF token to be rejected, got status %d\n", w.Code)
+	}
+	if w := consoleRequest("POST", WebAPIPath+"console/logout", cookie, csrf, nil); w.Code != http.StatusOK {
+		t.Fatalf("Logout failed with status %d: %s\n", w.Code, w.Body.String())
+	}
+	if w := consoleRequest("GET", WebAPIPath+"console/session", cookie, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected no session after logout, got status %d\n", w.Code)
+	}
+	if w := consoleRequest("POST", noteURL, cookie, csrf, strings.NewReader(`{"note": "b"}`)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected POST after logout to be rejected, got status %d\n", w.Code)
+	}
+
+	// Revoking the API token ends its sessions.
+	cookie, csrf = consoleLogin(t, token.Token)
+	if err := RevokeAuthToken(token.Token); err != nil {
+		t.Fatalf("Unable to revoke API token: %s\n", err.Error())
+	}
+	if w := consoleRequest("GET", WebAPIPath+"console/session", cookie, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected session of revoked token to be ended, got status %d\n", w.Code)
+	}
+	if w := consoleRequest("POST", noteURL, cookie, csrf, strings.NewReader(`{"note": "c"}`)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected POST with session of revoked token to be rejected, got status %d\n", w.Code)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+	This file supports cross-origin resource sharing (CORS) so web clients served from
+	other origins, e.g., browser-based viewers, can call the HTTP API.  By default any
+	origin can make requests without credentials.  A CORS policy can restrict the allowed
+	origins, methods, and headers and allow credentials like cookies or Authorization
+	headers.  Preflight OPTIONS requests are answered before authentication, since
+	browsers don't send credentials with them.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+// CORSPolicy gives which cross-origin requests are allowed.
+type CORSPolicy struct {
+	// AllowedOrigins are origins, e.g., "https://viewer.example.org", that can make
+	// requests.  An origin can have one "*" wildcard, e.g., "https://*.example.org", and
+	// "*" alone allows any origin.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders are returned in preflight responses.  Any
+	// requested headers are allowed if AllowedHeaders has "*".
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers that scripts are allowed to read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies or Authorization headers.  The
+	// origin of each request is returned instead of "*" so browsers accept responses.
+	// It can't be used when "*" alone is an allowed origin, since any site could then
+	// make requests with a user's credentials.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers can cache preflight responses.  Not sent if zero.
+	MaxAge time.Duration
+}
+
+// DefaultCORSPolicy allows requests from any origin without credentials.
+var DefaultCORSPolicy = CORSPolicy{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "DELETE"},
+	AllowedHeaders: []string{"*"},
+}
+
+var (
+	corsMu     sync.RWMutex
+	corsPolicy = DefaultCORSPolicy
+)
+
+// EnableCORS replaces the default CORS policy.  Methods and headers default to those of
+// DefaultCORSPolicy if none are given.
+func EnableCORS(policy CORSPolicy) error {
+	if len(policy.AllowedOrigins) == 0 {
+		return fmt.Errorf("CORS policy requires at least one allowed origin")
+	}
+	for _, origin := range policy.AllowedOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("Bad CORS origin %q: only one '*' wildcard is allowed", origin)
+		}
+		if origin == "*" && policy.AllowCredentials {
+			return fmt.Errorf("CORS policy can't allow credentials from any origin '*'")
+		}
+	}
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSPolicy.AllowedMethods
+	}
+	policy.AllowedMethods = make([]string, len(methods))
+	for i, method := range methods {
+		policy.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	if len(policy.AllowedHeaders) == 0 {
+		policy.AllowedHeaders = DefaultCORSPolicy.AllowedHeaders
+	}
+	corsMu.Lock()
+	corsPolicy = policy
+	corsMu.Unlock()
+	return nil
+}
+
+// originAllowed returns true if the origin matches an allowed origin.
+func (p *CORSPolicy) originAllowed(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyOrigin returns true if responses can allow any origin with "*".
+func (p *CORSPolicy) anyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedHeaders returns the headers allowed for a preflight request.
+func (p *CORSPolicy) allowedHeaders(requested string) string {
+	for _, header := range p.AllowedHeaders {
+		if header == "*" {
+			return requested
+		}
+	}
+	return strings.Join(p.AllowedHeaders, ", ")
+}
+
+// corsHandler adds CORS headers allowed by the CORS policy and responds to preflight
+// requests.
+func corsHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		corsMu.RLock()
+		policy := corsPolicy
+		corsMu.RUnlock()
+
+		origin := r.Header.Get("Origin")
+		allowed := policy.anyOrigin() || (origin != "" && policy.originAllowed(origin))
+		header := w.Header()
+
+		// Unless any origin is allowed, responses depend on the origin, so caches must
+		// not reuse them for other origins, including requests without an origin.
+		if !policy.anyOrigin() {
+			header.Add("Vary", "Origin")
+		}
+		if allowed {
+			if policy.anyOrigin() {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		// Preflight requests give the method of the actual request and are answered here.
+		if r.Method == "OPTIONS" && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				http.Error(w, fmt.Sprintf("Origin %q is not allowed", origin), http.StatusForbidden)
+				return
+			}
+			header.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			if headers := policy.allowedHeaders(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			}
+			if policy.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if allowed && len(policy.ExposedHeaders) != 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+)
+
+// resetCORS restores the default CORS policy.
+func resetCORS() {
+	corsMu.Lock()
+	corsPolicy = DefaultCORSPolicy
+	corsMu.Unlock()
+}
+
+func TestCORSPolicy(t *testing.T) {
+	defer resetCORS()
+
+	// Credentials can't be allowed from any origin.
+	if err := EnableCORS(CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Errorf("Expected error for CORS policy allowing credentials from any origin\n")
+	}
+
+	// send returns the response headers for a request with an optional origin.
+	send := func(origin string) http.Header {
+		r, _ := http.NewRequest("GET", "/api/server/info", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		corsHandler(&web.C{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		return w.Header()
+	}
+
+	// Responses allowing any origin don't depend on it.
+	header := send("https://viewer.example.org")
+	if header.Get("Access-Control-Allow-Origin") != "*" || header.Get("Vary") != "" {
+		t.Errorf("Expected '*' without Vary for default policy, got %v\n", header)
+	}
+
+	// Otherwise every response varies by origin, even if the origin isn't allowed.
+	policy := CORSPolicy{AllowedOrigins: []string{"https://*.example.org"}, AllowCredentials: true}
+	if err := EnableCORS(policy); err != nil {
+		t.Fatalf("Unable to enable CORS policy: %s\n", err.Error())
+	}
+	header = send("https://viewer.example.org")
+	if header.Get("Access-Control-Allow-Origin") != "https://viewer.example.org" || header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Expected allowed origin with credentials, got %v\n", header)
+	}
+	for name, header := range map[string]http.Header{
+		"allowed origin":    header,
+		"disallowed origin": send("https://evil.example.com"),
+		"no origin":         send(""),
+	} {
+		if header.Get("Vary") != "Origin" {
+			t.Errorf("Expected 'Vary: Origin' for %s, got %v\n", name, header)
+		}
+		if name != "allowed origin" && header.Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Expected no allowed origin for %s, got %v\n", name, header)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+/*
+	Package dvidpb holds the protocol buffer definitions of the DVID gRPC API.  The Go
+	code is generated from dvid.proto when building DVID with gRPC support, which
+	requires protoc and the protoc-gen-go plugin.
+*/
+package dvidpb
+
+//go:generate protoc --go_out=plugins=grpc:. dvid.proto
@@ -0,0 +1,58 @@
+/*
+	This file supports an optional gRPC API served alongside the HTTP and net/rpc APIs so
+	clients in languages other than Go, e.g., Python pipelines, can manage repos and
+	read and write voxel blocks.  The gRPC server is only compiled in with the "grpc"
+	build tag since it requires Go code generated from server/dvidpb/dvid.proto.
+*/
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// grpcListenAndServe serves gRPC requests on an address and shouldn't return if
+// successful.  It is set by the gRPC server's init() when compiled in.
+var grpcListenAndServe func(address string) error
+
+// GRPCAvailable returns true if DVID was built with gRPC support.
+func GRPCAvailable() bool {
+	return grpcListenAndServe != nil
+}
+
+// serveGRPC listens and serves gRPC requests using address.  Should not return if
+// successful.
+func serveGRPC(address string) error {
+	if grpcListenAndServe == nil {
+		return fmt.Errorf("DVID was not built with gRPC support (build tag \"grpc\")")
+	}
+	dvid.Infof("Serving gRPC on %s\n", address)
+	return grpcListenAndServe(address)
+}
+
+// authorizeToken returns an error if authentication is enabled and the token doesn't have
+// at least the given role for the repo with the given root UUID.  Requests not on a repo,
+// given by an empty root UUID, require an admin token.
+func authorizeToken(tokenStr string, root dvid.UUID, role Role) error {
+	if !AuthEnabled() {
+		return nil
+	}
+	auth.RLock()
+	token := auth.tokens[tokenStr]
+	auth.RUnlock()
+	if token == nil {
+		return fmt.Errorf("Valid API token required")
+	}
+	if root == "" {
+		if !token.Admin {
+			return fmt.Errorf("Admin API token required")
+		}
+		return nil
+	}
+	if token.RoleFor(root) < role {
+		return fmt.Errorf("API token %q doesn't have %s role for repo %s", token.Name, role, root)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+// +build grpc
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server/dvidpb"
+)
+
+// Maximum number of blocks read at a time while streaming blocks to a client.
+const grpcBlocksPerRead = 64
+
+func init() {
+	grpcListenAndServe = func(address string) error {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		s := grpc.NewServer()
+		dvidpb.RegisterDVIDServer(s, grpcService{})
+		return s.Serve(listener)
+	}
+}
+
+// grpcService implements the gRPC API using the same datastore service as the HTTP and
+// net/rpc APIs.  Block reads and writes each take a handler token, like the chunk
+// handlers of HTTP requests, so gRPC clients can't swamp the server.
+type grpcService struct{}
+
+// grpcToken returns the API token sent as "authorization: Bearer <token>" metadata.
+func grpcToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, header := range md["authorization"] {
+		if strings.HasPrefix(header, "Bearer ") {
+			return strings.TrimSpace(header[len("Bearer "):])
+		}
+	}
+	return ""
+}
+
+// grpcNode returns the repo and version of a node after checking that the request's
+// token has the given role for the repo.
+func grpcNode(ctx context.Context, uuidStr string, role Role) (datastore.Repo, dvid.UUID, dvid.VersionID, error) {
+	uuid, versionID, err := datastore.MatchingUUID(uuidStr)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if err := authorizeToken(grpcToken(ctx), repo.RootUUID(), role); err != nil {
+		return nil, "", 0, err
+	}
+	return repo, uuid, versionID, nil
+}
+
+func grpcReadOnly() error {
+	if readonly {
+		return fmt.Errorf("Server is in read-only mode")
+	}
+	return nil
+}
+
+func (grpcService) NewRepo(ctx context.Context, req *dvidpb.NewRepoRequest) (*dvidpb.NodeReply, error) {
+	if err := grpcReadOnly(); err != nil {
+		return nil, err
+	}
+	if err := authorizeToken(grpcToken(ctx), "", AdminRole); err != nil {
+		return nil, err
+	}
+	repo, err := datastore.NewRepo(req.Alias, req.Description)
+	if err != nil {
+		return nil, err
+	}
+	return &dvidpb.NodeReply{Uuid: string(repo.RootUUID())}, nil
+}
+
+func (grpcService) RepoInfo(ctx context.Context, req *dvidpb.NodeRequest) (*dvidpb.JSONReply, error) {
+	repo, _, _, err := grpcNode(ctx, req.Uuid, ReadRole)
+	if err != nil {
+		return nil, err
+	}
+	jsonBytes, err := repo.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return &dvidpb.JSONReply{Json: jsonBytes}, nil
+}
+
+func (grpcService) LockNode(ctx context.Context, req *dvidpb.NodeRequest) (*dvidpb.NodeReply, error) {
+	if err := grpcReadOnly(); err != nil {
+		return nil, err
+	}
+	repo, uuid, _, err := grpcNode(ctx, req.Uuid, AdminRole)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Lock(uuid); err != nil {
+		return nil, err
+	}
+	return &dvidpb.NodeReply{Uuid: string(uuid)}, nil
+}
+
+func (grpcService) NewVersion(ctx context.Context, req *dvidpb.NewVersionRequest) (*dvidpb.NodeReply, error) {
+	if err := grpcReadOnly(); err != nil {
+		return nil, err
+	}
+	repo, uuid, _, err := grpcNode(ctx, req.Uuid, AdminRole)
+	if err != nil {
+		return nil, err
+	}
+	var child dvid.UUID
+	if req.Branch != "" {
+		child, err = repo.NewBranchVersion(uuid, req.Branch)
+	} else {
+		child, err = repo.NewVersion(uuid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dvidpb.NodeReply{Uuid: string(child)}, nil
+}
+
+// grpcBlockData returns data holding voxel blocks.
+func grpcBlockData(repo datastore.Repo, name string) (datastore.DataService, error) {
+	dataservice, err := repo.GetDataByName(dvid.DataString(name))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dataservice.(datastore.BlockReader); !ok {
+		return nil, fmt.Errorf("Data %q of type %q doesn't hold voxel blocks", name, dataservice.TypeName())
+	}
+	return dataservice, nil
+}
+
+func (grpcService) GetBlocks(req *dvidpb.GetBlocksRequest, stream dvidpb.DVID_GetBlocksServer) error {
+	repo, _, versionID, err := grpcNode(stream.Context(), req.Uuid, ReadRole)
+	if err != nil {
+		return err
+	}
+	dataservice, err := grpcBlockData(repo, req.DataName)
+	if err != nil {
+		return err
+	}
+	reader := dataservice.(datastore.BlockReader)
+	ctx := datastore.NewVersionedContext(dataservice, versionID)
+
+	for start := 0; start < len(req.Coords); start += grpcBlocksPerRead {
+		end := start + grpcBlocksPerRead
+		if end > len(req.Coords) {
+			end = len(req.Coords)
+		}
+		coords := make([]dvid.ChunkPoint3d, end-start)
+		for n, c := range req.Coords[start:end] {
+			if c == nil {
+				return fmt.Errorf("Block %d has no coordinate", start+n)
+			}
+			coords[n] = dvid.ChunkPoint3d{c.X, c.Y, c.Z}
+		}
+		<-HandlerToken
+		blocks, err := reader.ReadBlocks(ctx, coords)
+		HandlerToken <- 1
+		if err != nil {
+			return err
+		}
+		for n, block := range blocks {
+			if err := stream.Send(&dvidpb.Block{Coord: req.Coords[start+n], Data: block}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkStreamTarget returns an error if a later message of a PutBlocks stream gives a
+// node or data instance other than the one authorized for the stream's first message.
+func checkStreamTarget(req *dvidpb.PutBlocksRequest, uuid dvid.UUID, name dvid.DataString) error {
+	if req.Uuid != "" {
+		reqUUID, _, err := datastore.MatchingUUID(req.Uuid)
+		if err != nil {
+			return err
+		}
+		if reqUUID != uuid {
+			return fmt.Errorf("PutBlocks stream for node %s can't write to node %s", uuid, reqUUID)
+		}
+	}
+	if req.DataName != "" && dvid.DataString(req.DataName) != name {
+		return fmt.Errorf("PutBlocks stream for data %q can't write to data %q", name, req.DataName)
+	}
+	return nil
+}
+
+func (grpcService) PutBlocks(stream dvidpb.DVID_PutBlocksServer) error {
+	if err := grpcReadOnly(); err != nil {
+		return err
+	}
+	var repo datastore.Repo
+	var uuid dvid.UUID
+	var name dvid.DataString
+	var ingester datastore.BatchIngester
+	var ctx *datastore.VersionedContext
+	reply := new(dvidpb.PutBlocksReply)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(reply)
+		}
+		if err != nil {
+			return err
+		}
+		if ingester == nil {
+			var versionID dvid.VersionID
+			repo, uuid, versionID, err = grpcNode(stream.Context(), req.Uuid, WriteRole)
+			if err != nil {
+				return err
+			}
+			locked, err := repo.Locked(uuid)
+			if err != nil {
+				return err
+			}
+			if locked {
+				return fmt.Errorf("Can't write to locked node %s", uuid)
+			}
+			dataservice, err := grpcBlockData(repo, req.DataName)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if ingester, ok = dataservice.(datastore.BatchIngester); !ok {
+				return fmt.Errorf("Data %q of type %q doesn't accept blocks", req.DataName, dataservice.TypeName())
+			}
+			name = dataservice.DataName()
+			ctx = datastore.NewVersionedContext(dataservice, versionID)
+		} else if err := checkStreamTarget(req, uuid, name); err != nil {
+			return err
+		}
+
+		ops := make([]datastore.BatchOp, len(req.Blocks))
+		var numBytes int64
+		for n, block := range req.Blocks {
+			if block.Coord == nil {
+				return fmt.Errorf("Block %d has no coordinate", reply.Blocks+int32(n))
+			}
+			ops[n] = datastore.BatchOp{
+				Key:   fmt.Sprintf("%d_%d_%d", block.Coord.X, block.Coord.Y, block.Coord.Z),
+				Value: block.Data,
+			}
+			numBytes += int64(len(block.Data))
+		}
+		<-HandlerToken
+		err = ingestBatch(repo, ingester, ctx, ops)
+		HandlerToken <- 1
+		if err != nil {
+			return err
+		}
+		reply.Blocks += int32(len(ops))
+		reply.Bytes += numBytes
+	}
+}
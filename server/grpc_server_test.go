@@ -0,0 +1,140 @@
+// +build grpc
+
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server/dvidpb"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// grpcContext returns a request context sending an API token.
+func grpcContext(token string) context.Context {
+	ctx := context.Background()
+	if token == "" {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// testPutBlocksStream sends the given messages to PutBlocks.
+type testPutBlocksStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	reqs  []*dvidpb.PutBlocksRequest
+	reply *dvidpb.PutBlocksReply
+}
+
+func (s *testPutBlocksStream) Context() context.Context { return s.ctx }
+
+func (s *testPutBlocksStream) Recv() (*dvidpb.PutBlocksRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *testPutBlocksStream) SendAndClose(reply *dvidpb.PutBlocksReply) error {
+	s.reply = reply
+	return nil
+}
+
+func TestGRPCRoles(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+	enableTestAuth(t, "test-admin")
+	reader, err := NewAuthToken("reader", false, map[dvid.UUID]Role{root: ReadRole})
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+
+	if _, _, _, err := grpcNode(grpcContext(""), string(root), ReadRole); err == nil {
+		t.Errorf("Expected request without token to be rejected\n")
+	}
+	if _, uuid, _, err := grpcNode(grpcContext(reader.Token), string(root), ReadRole); err != nil || uuid != root {
+		t.Errorf("Expected reader to read node %s, got %s (error %v)\n", root, uuid, err)
+	}
+	if _, _, _, err := grpcNode(grpcContext(reader.Token), string(root), WriteRole); err == nil {
+		t.Errorf("Expected reader to be refused write role\n")
+	}
+	if _, _, _, err := grpcNode(grpcContext("test-admin"), string(root), AdminRole); err != nil {
+		t.Errorf("Expected admin to have admin role: %s\n", err.Error())
+	}
+
+	var s grpcService
+	if _, err := s.RepoInfo(grpcContext(reader.Token), &dvidpb.NodeRequest{Uuid: string(root)}); err != nil {
+		t.Errorf("Expected reader to get repo info: %s\n", err.Error())
+	}
+	if _, err := s.LockNode(grpcContext(reader.Token), &dvidpb.NodeRequest{Uuid: string(root)}); err == nil {
+		t.Errorf("Expected reader to be refused node lock\n")
+	}
+	if _, err := s.NewRepo(grpcContext(reader.Token), &dvidpb.NewRepoRequest{Alias: "other"}); err == nil {
+		t.Errorf("Expected non-admin to be refused new repo\n")
+	}
+}
+
+func TestGRPCPutBlocks(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+	var s grpcService
+
+	// Writes are refused in read-only mode.
+	SetReadOnly(true)
+	stream := &testPutBlocksStream{ctx: grpcContext(""), reqs: []*dvidpb.PutBlocksRequest{{Uuid: string(root), DataName: "grayscale"}}}
+	err := s.PutBlocks(stream)
+	SetReadOnly(false)
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("Expected PutBlocks to be refused in read-only mode, got %v\n", err)
+	}
+	if _, err := s.NewVersion(grpcContext(""), &dvidpb.NewVersionRequest{Uuid: string(root)}); err == nil {
+		t.Errorf("Expected new version of unlocked node to fail\n")
+	}
+
+	// Locked nodes can't be written.
+	if _, err := s.LockNode(grpcContext(""), &dvidpb.NodeRequest{Uuid: string(root)}); err != nil {
+		t.Fatalf("Unable to lock node: %s\n", err.Error())
+	}
+	stream = &testPutBlocksStream{ctx: grpcContext(""), reqs: []*dvidpb.PutBlocksRequest{{Uuid: string(root), DataName: "grayscale"}}}
+	if err := s.PutBlocks(stream); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Expected PutBlocks to locked node to be refused, got %v\n", err)
+	}
+	if stream.reply != nil {
+		t.Errorf("Expected no reply after refused PutBlocks\n")
+	}
+
+	// Later messages of a stream can't switch node or data instance.
+	child, err := s.NewVersion(grpcContext(""), &dvidpb.NewVersionRequest{Uuid: string(root)})
+	if err != nil {
+		t.Fatalf("Unable to create child node: %s\n", err.Error())
+	}
+	childUUID := dvid.UUID(child.Uuid)
+	if err := checkStreamTarget(&dvidpb.PutBlocksRequest{}, childUUID, "grayscale"); err != nil {
+		t.Errorf("Expected message without node or data to be accepted: %s\n", err.Error())
+	}
+	if err := checkStreamTarget(&dvidpb.PutBlocksRequest{Uuid: string(childUUID), DataName: "grayscale"}, childUUID, "grayscale"); err != nil {
+		t.Errorf("Expected message for same node and data to be accepted: %s\n", err.Error())
+	}
+	if err := checkStreamTarget(&dvidpb.PutBlocksRequest{Uuid: string(root)}, childUUID, "grayscale"); err == nil {
+		t.Errorf("Expected message for another node to be rejected\n")
+	}
+	if err := checkStreamTarget(&dvidpb.PutBlocksRequest{DataName: "labels"}, childUUID, "grayscale"); err == nil {
+		t.Errorf("Expected message for another data instance to be rejected\n")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+	This file sets HTTP caching headers on reads of data instances based on the lock state
+	of the addressed version.  Data of a locked node can't change, so successful responses
+	addressed to a locked node by UUID can be cached indefinitely by browsers and proxies.
+	Responses for open nodes, branch references whose head can move, unversioned data, and
+	partial results must be revalidated.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/zenazn/goji/web"
+)
+
+// LockedMaxAge is how long responses for locked versions may be cached.
+const LockedMaxAge = 365 * 24 * time.Hour
+
+// Cache-Control values for responses that can't change and those that may.
+var (
+	lockedCacheControl = fmt.Sprintf("max-age=%d, immutable", int64(LockedMaxAge/time.Second))
+	openCacheControl   = "no-cache"
+)
+
+// cachingWriter sets caching headers just before the response header is written so
+// only successful, complete responses are cached.
+type cachingWriter struct {
+	http.ResponseWriter
+	immutable   bool
+	private     bool
+	wroteHeader bool
+}
+
+func (w *cachingWriter) setHeaders(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	if header.Get("Cache-Control") != "" {
+		return
+	}
+	switch {
+	case status != http.StatusOK && status != http.StatusPartialContent:
+		header.Set("Cache-Control", "no-store")
+	case w.immutable && header.Get(PartialHeader) == "":
+		visibility := "public"
+		if w.private {
+			visibility = "private"
+		}
+		header.Set("Cache-Control", visibility+", "+lockedCacheControl)
+		header.Set("Expires", time.Now().Add(LockedMaxAge).UTC().Format(http.TimeFormat))
+	default:
+		header.Set("Cache-Control", openCacheControl)
+		header.Set("Expires", "0")
+	}
+}
+
+func (w *cachingWriter) WriteHeader(status int) {
+	w.setHeaders(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cachingWriter) Write(b []byte) (int, error) {
+	w.setHeaders(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// CloseNotify passes through the underlying writer's notification so requests are
+// still canceled when clients disconnect.
+func (w *cachingWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *cachingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// lockedVersion returns true if a request addresses versioned data of a locked node by
+// UUID rather than by a branch reference.
+func lockedVersion(c *web.C) bool {
+	if strings.Contains(c.URLParams["uuid"], ":") {
+		return false
+	}
+	repo, ok := c.Env["repo"].(datastore.Repo)
+	if !ok {
+		return false
+	}
+	uuid, ok := c.Env["uuid"].(dvid.UUID)
+	if !ok {
+		return false
+	}
+	if dataname := c.URLParams["dataname"]; dataname != "" {
+		data, err := repo.GetDataByName(dvid.DataString(dataname))
+		if err != nil || !data.Versioned() {
+			return false
+		}
+	}
+	locked, err := repo.Locked(uuid)
+	return err == nil && locked
+}
+
+// cacheHeaderHandler is middleware that sets caching headers on GET and HEAD requests
+// according to the lock state of the addressed version.  Cacheable responses are marked
+// private when authentication is enabled so shared proxies don't serve them to others.
+func cacheHeaderHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &cachingWriter{
+			ResponseWriter: w,
+			immutable:      lockedVersion(c),
+			private:        AuthEnabled(),
+		}
+		h.ServeHTTP(cw, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+	"github.com/zenazn/goji/web"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	uuid := repo.RootUUID()
+	if err := repo.Lock(uuid); err != nil {
+		t.Fatalf("Unable to lock node %s: %s\n", uuid, err.Error())
+	}
+	child, err := repo.NewVersion(uuid)
+	if err != nil {
+		t.Fatalf("Unable to create child of %s: %s\n", uuid, err.Error())
+	}
+
+	// send passes a request through the caching middleware to a handler.
+	send := func(method, uuidParam, dataname string, h http.HandlerFunc) http.Header {
+		var nodeUUID dvid.UUID
+		if strings.HasPrefix(uuidParam, string(child)) {
+			nodeUUID = child
+		} else {
+			nodeUUID = uuid
+		}
+		c := &web.C{
+			Env:       map[interface{}]interface{}{"repo": repo, "uuid": nodeUUID},
+			URLParams: map[string]string{"uuid": uuidParam, "dataname": dataname},
+		}
+		r, _ := http.NewRequest(method, "/api/node/"+uuidParam+"/"+dataname+"/info", nil)
+		w := httptest.NewRecorder()
+		cacheHeaderHandler(c, h).ServeHTTP(w, r)
+		return w.Header()
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}
+
+	// Complete reads of a locked node addressed by UUID are immutable.
+	header := send("GET", string(uuid), "", ok)
+	if cc := header.Get("Cache-Control"); !strings.HasPrefix(cc, "public, ") || !strings.Contains(cc, "immutable") {
+		t.Errorf("Expected public immutable response for locked node, got %q\n", cc)
+	}
+	if header.Get("Expires") == "" {
+		t.Errorf("Expected Expires header for locked node\n")
+	}
+
+	// Open nodes, branch references, unknown instances, and partial results must be
+	// revalidated.
+	for name, header := range map[string]http.Header{
+		"open node":        send("GET", string(child), "", ok),
+		"branch reference": send("GET", string(uuid)+":master", "", ok),
+		"unknown instance": send("HEAD", string(uuid), "nonexistent", ok),
+		"partial result": send("GET", string(uuid), "", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(PartialHeader, "true")
+			w.Write([]byte("some data"))
+		}),
+	} {
+		if cc := header.Get("Cache-Control"); cc != openCacheControl {
+			t.Errorf("Expected %q for %s, got %q\n", openCacheControl, name, cc)
+		}
+	}
+
+	// Errors aren't stored, writes get no caching headers, and handlers can set their own.
+	header = send("GET", string(uuid), "", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	if cc := header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Expected no-store for error, got %q\n", cc)
+	}
+	if cc := send("POST", string(uuid), "", ok).Get("Cache-Control"); cc != "" {
+		t.Errorf("Expected no caching headers for POST, got %q\n", cc)
+	}
+	header = send("GET", string(uuid), "", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("data"))
+	})
+	if cc := header.Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("Expected handler's caching header to be kept, got %q\n", cc)
+	}
+
+	// With authentication, cacheable responses are private.
+	enableTestAuth(t, "test-admin")
+	if cc := send("GET", string(uuid), "", ok).Get("Cache-Control"); !strings.HasPrefix(cc, "private, ") {
+		t.Errorf("Expected private response with authentication, got %q\n", cc)
+	}
+}
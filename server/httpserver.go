@@ -0,0 +1,108 @@
+/*
+	This file configures the HTTP server: timeouts, maximum header size, keep-alive
+	connections, and HTTP/2.  HTTP/2 is negotiated with clients over TLS and lets
+	many requests, e.g., tile or block GETs of a viewer, share one connection.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var (
+	// HTTPReadTimeout is the maximum time to read a request, including its body.
+	// No timeout if zero.
+	HTTPReadTimeout time.Duration
+
+	// HTTPWriteTimeout is the maximum time from the end of reading a request header
+	// until its response is written.  Streamed responses, e.g., large subvolumes,
+	// must be sent within it.  No timeout if zero.
+	HTTPWriteTimeout time.Duration
+
+	// HTTPIdleTimeout is how long an idle keep-alive connection is kept open.  This
+	// keeps idle connections from holding goroutines indefinitely.
+	HTTPIdleTimeout = time.Hour
+
+	// HTTPMaxHeaderBytes is the maximum size of request headers.
+	HTTPMaxHeaderBytes = http.DefaultMaxHeaderBytes
+
+	// HTTPKeepAlives allows connections to be reused across requests.
+	HTTPKeepAlives = true
+
+	// HTTP2 allows clients to negotiate HTTP/2 over TLS.
+	HTTP2 = true
+)
+
+// HTTPShutdownTimeout is how long Shutdown() waits for active requests to finish.
+const HTTPShutdownTimeout = 10 * time.Second
+
+var (
+	httpServerMu sync.Mutex
+	httpServer   *http.Server
+)
+
+// newHTTPServer returns an HTTP server with the configured timeouts, header limit,
+// keep-alive, and HTTP/2 settings.  If tlsConfig is non-nil, it is returned with the
+// protocols the server negotiates.
+func newHTTPServer(handler http.Handler, tlsConfig *tls.Config) (*http.Server, *tls.Config) {
+	srv := &http.Server{
+		Handler:        handler,
+		ReadTimeout:    HTTPReadTimeout,
+		WriteTimeout:   HTTPWriteTimeout,
+		IdleTimeout:    HTTPIdleTimeout,
+		MaxHeaderBytes: HTTPMaxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(HTTPKeepAlives)
+	if tlsConfig == nil {
+		return srv, nil
+	}
+	tlsConfig = tlsConfig.Clone()
+	if HTTP2 {
+		tlsConfig.NextProtos = appendProto(tlsConfig.NextProtos, "h2")
+	} else {
+		// A non-nil, empty map keeps the server from configuring HTTP/2.
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		var protos []string
+		for _, proto := range tlsConfig.NextProtos {
+			if proto != "h2" {
+				protos = append(protos, proto)
+			}
+		}
+		tlsConfig.NextProtos = protos
+	}
+	tlsConfig.NextProtos = appendProto(tlsConfig.NextProtos, "http/1.1")
+	return srv, tlsConfig
+}
+
+// appendProto appends a protocol to a list of protocols if not already present.
+func appendProto(protos []string, proto string) []string {
+	for _, p := range protos {
+		if p == proto {
+			return protos
+		}
+	}
+	return append(protos, proto)
+}
+
+// shutdownHTTP stops the server from accepting connections and waits for active
+// requests to finish.
+func shutdownHTTP() {
+	httpServerMu.Lock()
+	srv := httpServer
+	httpServerMu.Unlock()
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HTTPShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		dvid.Errorf("HTTP server did not shut down cleanly: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,328 @@
+/*
+	This file supports Idempotency-Key headers on mutating HTTP requests.  A client that
+	times out can retry a POST or DELETE with the same key and receive the stored response
+	instead of applying the mutation twice.  Recent keys and their response digests are
+	stored in the metadata tier.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// IdempotencyHeader is the request header giving a client-chosen key for a mutation.
+	IdempotencyHeader = "Idempotency-Key"
+
+	// IdempotencyTTL is how long idempotency keys are remembered.
+	IdempotencyTTL = 24 * time.Hour
+
+	// Responses larger than this are not stored; retries get the status and digest only.
+	maxIdempotentBody = 1 * dvid.Mega
+
+	// Maximum length of a client idempotency key.
+	maxIdempotencyKeyLen = 255
+)
+
+// The first byte of metadata indices for idempotency records.
+const idempotencyKey byte = 0xA1
+
+// idempotencyRecord is the stored result of a mutating request.
+type idempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	Digest      string
+	Truncated   bool
+	Created     time.Time
+}
+
+// keys of requests currently being handled
+var (
+	idempotentInflight   = make(map[string]struct{})
+	idempotentInflightMu sync.Mutex
+	idempotentCleanup    sync.Once
+)
+
+// idempotencyIndex scopes a client key by API token so different clients can't collide.
+func idempotencyIndex(r *http.Request, key string) []byte {
+	return append([]byte{idempotencyKey}, requestToken(r)+"\x00"+key...)
+}
+
+// newFingerprint returns a hash identifying a request once its body is written to it.
+func newFingerprint(r *http.Request) hash.Hash {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	return h
+}
+
+// requestFingerprint identifies a request by reading its entire body.
+func requestFingerprint(r *http.Request) (string, error) {
+	h := newFingerprint(r)
+	if r.Body != nil {
+		if _, err := io.Copy(h, r.Body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fingerprintBody adds a request body to its fingerprint as the handler reads it, so
+// large bodies are streamed rather than buffered.
+type fingerprintBody struct {
+	io.ReadCloser
+	fingerprint hash.Hash
+
+	finished bool
+	sum      string
+	err      error
+}
+
+func (b *fingerprintBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.fingerprint.Write(p[:n])
+	return n, err
+}
+
+// Close reads any part of the body the handler skipped so the fingerprint is complete.
+func (b *fingerprintBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+// finish reads the rest of the body and returns the fingerprint.
+func (b *fingerprintBody) finish() (string, error) {
+	if !b.finished {
+		b.finished = true
+		if _, b.err = io.Copy(ioutil.Discard, b); b.err == nil {
+			b.sum = hex.EncodeToString(b.fingerprint.Sum(nil))
+		}
+	}
+	return b.sum, b.err
+}
+
+func getIdempotencyRecord(index []byte) (*idempotencyRecord, error) {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return nil, err
+	}
+	value, err := store.Get(storage.NewMetadataContext(), index)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	record := new(idempotencyRecord)
+	dec := gob.NewDecoder(bytes.NewBuffer(value))
+	if err := dec.Decode(record); err != nil {
+		return nil, fmt.Errorf("Could not decode idempotency record: %s", err.Error())
+	}
+	if time.Since(record.Created) > IdempotencyTTL {
+		return nil, nil
+	}
+	return record, nil
+}
+
+func putIdempotencyRecord(index []byte, record *idempotencyRecord) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(record); err != nil {
+		return err
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	return store.Put(storage.NewMetadataContext(), index, buf.Bytes())
+}
+
+// deleteExpiredIdempotencyKeys periodically removes records older than IdempotencyTTL.
+func deleteExpiredIdempotencyKeys() {
+	for {
+		store, err := storage.MetaDataStore()
+		if err != nil {
+			dvid.Errorf("Unable to clean idempotency keys: %s\n", err.Error())
+			return
+		}
+		ctx := storage.NewMetadataContext()
+		keyvalues, err := store.GetRange(ctx, []byte{idempotencyKey}, []byte{idempotencyKey, 0xFF})
+		if err != nil {
+			dvid.Errorf("Unable to read idempotency keys: %s\n", err.Error())
+		}
+		numDeleted := 0
+		for _, kv := range keyvalues {
+			var record idempotencyRecord
+			dec := gob.NewDecoder(bytes.NewBuffer(kv.V))
+			if err := dec.Decode(&record); err != nil || time.Since(record.Created) > IdempotencyTTL {
+				index, err := ctx.IndexFromKey(kv.K)
+				if err != nil {
+					continue
+				}
+				if err := store.Delete(ctx, index); err != nil {
+					dvid.Errorf("Unable to delete idempotency key: %s\n", err.Error())
+					continue
+				}
+				numDeleted++
+			}
+		}
+		if numDeleted != 0 {
+			dvid.Debugf("Deleted %d expired idempotency keys.\n", numDeleted)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// idempotentWriter records the status and a digest of the response, keeping the body
+// if it is small enough to store.
+type idempotentWriter struct {
+	http.ResponseWriter
+	status    int
+	digest    hash.Hash
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *idempotentWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotentWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.digest.Write(b)
+	if !w.truncated {
+		if w.body.Len()+len(b) > maxIdempotentBody {
+			w.truncated = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// replayIdempotent writes a previously stored response.
+func replayIdempotent(w http.ResponseWriter, record *idempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.Header().Set("Idempotent-Digest", record.Digest)
+	if record.Truncated {
+		w.Header().Set("Idempotent-Truncated", "true")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(record.Body)))
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// idempotencyHandler is middleware that makes mutating requests with an Idempotency-Key
+// header safe to retry.  Successful responses are stored and replayed for retries with
+// the same key.  A key reused for a different request is rejected, as is a retry while
+// the original request is still being handled.  Failed requests are not stored so they
+// can be retried.
+func idempotencyHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			BadRequest(w, r, "%s header must be at most %d characters", IdempotencyHeader, maxIdempotencyKeyLen)
+			return
+		}
+		idempotentCleanup.Do(func() { go deleteExpiredIdempotencyKeys() })
+
+		index := idempotencyIndex(r, key)
+
+		idempotentInflightMu.Lock()
+		if _, found := idempotentInflight[string(index)]; found {
+			idempotentInflightMu.Unlock()
+			http.Error(w, fmt.Sprintf("Request with %s %q is still in progress", IdempotencyHeader, key), http.StatusConflict)
+			return
+		}
+		record, err := getIdempotencyRecord(index)
+		if err != nil {
+			idempotentInflightMu.Unlock()
+			BadRequest(w, r, err.Error())
+			return
+		}
+		if record == nil {
+			idempotentInflight[string(index)] = struct{}{}
+		}
+		idempotentInflightMu.Unlock()
+
+		if record != nil {
+			fingerprint, err := requestFingerprint(r)
+			if err != nil {
+				BadRequest(w, r, "Unable to read request body: %s", err.Error())
+				return
+			}
+			if record.Fingerprint != fingerprint {
+				http.Error(w, fmt.Sprintf("%s %q was already used for a different request", IdempotencyHeader, key),
+					http.StatusUnprocessableEntity)
+				return
+			}
+			dvid.Infof("Replaying response for %s %s with %s %q\n", r.Method, r.URL.Path, IdempotencyHeader, key)
+			replayIdempotent(w, record)
+			return
+		}
+
+		defer func() {
+			idempotentInflightMu.Lock()
+			delete(idempotentInflight, string(index))
+			idempotentInflightMu.Unlock()
+		}()
+		body := &fingerprintBody{ReadCloser: ioutil.NopCloser(bytes.NewReader(nil)), fingerprint: newFingerprint(r)}
+		if r.Body != nil {
+			body.ReadCloser = r.Body
+		}
+		r.Body = body
+		iw := &idempotentWriter{ResponseWriter: w, digest: sha256.New()}
+		h.ServeHTTP(iw, r)
+		if iw.status == 0 {
+			iw.status = http.StatusOK
+		}
+		if iw.status < 200 || iw.status >= 300 {
+			return
+		}
+		fingerprint, err := body.finish()
+		if err != nil {
+			dvid.Errorf("Unable to store %s %q: error reading request body: %s\n", IdempotencyHeader, key, err.Error())
+			return
+		}
+		record = &idempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      iw.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        iw.body.Bytes(),
+			Digest:      hex.EncodeToString(iw.digest.Sum(nil)),
+			Truncated:   iw.truncated,
+			Created:     time.Now(),
+		}
+		if err := putIdempotencyRecord(index, record); err != nil {
+			dvid.Errorf("Unable to store %s %q: %s\n", IdempotencyHeader, key, err.Error())
+		}
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// idempotentRequest sends a POST with an Idempotency-Key header.
+func idempotentRequest(urlStr, key, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", urlStr, strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set(IdempotencyHeader, key)
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, _ := tests.NewRepo()
+	nodeNote := fmt.Sprintf("%snode/%s/note", WebAPIPath, repo.RootUUID())
+
+	w := idempotentRequest(nodeNote, "key-1", `{"note": "first"}`)
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Bad response to first request: %d %v\n", w.Code, w.Header())
+	}
+	first := w.Body.String()
+
+	// A retry gets the stored response.
+	w = idempotentRequest(nodeNote, "key-1", `{"note": "first"}`)
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" || w.Body.String() != first {
+		t.Errorf("Expected replay of first response, got %d %v: %s\n", w.Code, w.Header(), w.Body.String())
+	}
+
+	// A body of the same length but different content is a different request.
+	w = idempotentRequest(nodeNote, "key-1", `{"note": "other"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected key reused with different body to be rejected, got status %d\n", w.Code)
+	}
+	w = idempotentRequest(nodeNote+"?x=1", "key-1", `{"note": "first"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected key reused with different URL to be rejected, got status %d\n", w.Code)
+	}
+
+	// Failed requests aren't stored, so they can be retried with the same key.
+	if w = idempotentRequest(nodeNote, "key-2", `{"bad"`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected bad JSON to fail, got status %d\n", w.Code)
+	}
+	if w = idempotentRequest(nodeNote, "key-2", `{"note": "second"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected retry of failed request to succeed, got status %d\n", w.Code)
+	}
+
+	if w = idempotentRequest(nodeNote, strings.Repeat("k", maxIdempotencyKeyLen+1), `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected overly long key to be rejected, got status %d\n", w.Code)
+	}
+}
+
+func TestFingerprintBody(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/api/node/abc/data/blocks", strings.NewReader("0123456789"))
+	expected, err := requestFingerprint(req)
+	if err != nil {
+		t.Fatalf("Error fingerprinting request: %s\n", err.Error())
+	}
+
+	// Parts of the body skipped by a handler are still fingerprinted.
+	req, _ = http.NewRequest("POST", "/api/node/abc/data/blocks", strings.NewReader("0123456789"))
+	body := &fingerprintBody{ReadCloser: req.Body, fingerprint: newFingerprint(req)}
+	buf := make([]byte, 4)
+	if _, err := body.Read(buf); err != nil {
+		t.Fatalf("Error reading body: %s\n", err.Error())
+	}
+	body.Close()
+	if got, err := body.finish(); err != nil || got != expected {
+		t.Errorf("Expected fingerprint %s after partial read, got %s (%v)\n", expected, got, err)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/node/abc/data/blocks", ioutil.NopCloser(strings.NewReader("0123456788")))
+	if got, _ := requestFingerprint(req); got == expected {
+		t.Errorf("Expected different bodies to have different fingerprints\n")
+	}
+}
@@ -0,0 +1,539 @@
+/*
+	This file supports background jobs for long-running operations like bulk loads, tile
+	generation, and surface computation, so they don't block the RPC connection or an HTTP
+	request.  Each job gets an ID and reports its progress and log messages, which can be
+	retrieved via the /api/server/jobs endpoints.  Job state is persisted in the metadata
+	store, so the outcome of jobs survives restarts, and jobs that were running when the
+	server stopped are marked as interrupted.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// Finished jobs older than this are deleted.
+	JobRetention = 7 * 24 * time.Hour
+
+	// Number of log lines kept for each job.
+	maxJobLogLines = 500
+
+	// Running jobs are saved at most this often when their progress or log changes.
+	jobSaveInterval = 5 * time.Second
+)
+
+// The first byte of metadata indices for jobs.
+const jobKey byte = 0xAA
+
+func jobIndex(id string) []byte {
+	return append([]byte{jobKey}, id...)
+}
+
+// JobState is the state of a background job.
+type JobState string
+
+const (
+	JobRunning     JobState = "running"
+	JobSucceeded   JobState = "succeeded"
+	JobFailed      JobState = "failed"
+	JobCanceled    JobState = "canceled"
+	JobInterrupted JobState = "interrupted" // server stopped while job was running
+)
+
+// Job describes a background job.
+type Job struct {
+	ID      string
+	Command string
+
+	// Owner is the name of the API token or "rpc" for jobs started by RPC commands.
+	Owner string
+
+	State    JobState
+	Progress float64 // percent done, if reported by the job
+	Created  time.Time
+	Finished time.Time `json:",omitempty"`
+
+	// CancelRequested is true if the job was asked to stop but hasn't yet.
+	CancelRequested bool
+	Error           string `json:",omitempty"`
+	Result          string `json:",omitempty"`
+
+	// Log holds the most recent log messages of the job.
+	Log []string `json:"-"`
+}
+
+// Done returns true if the job is no longer running.
+func (job *Job) Done() bool {
+	return job.State != JobRunning
+}
+
+// JobFunc does the work of a job and returns text describing its result.
+type JobFunc func(handle *JobHandle) (result string, err error)
+
+// JobHandle is used by a running job to report progress and check for cancellation.
+// It fulfills the datastore.JobReporter interface.
+type JobHandle struct {
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ID returns the ID of the job.
+func (h *JobHandle) ID() string {
+	return h.id
+}
+
+// Context returns a context that is done when the job is canceled.
+func (h *JobHandle) Context() context.Context {
+	return h.ctx
+}
+
+// Done returns a channel that is closed when the job is canceled or finishes.
+func (h *JobHandle) Done() <-chan struct{} {
+	return h.ctx.Done()
+}
+
+// Canceled returns true if the job has been canceled.
+func (h *JobHandle) Canceled() bool {
+	select {
+	case <-h.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// SetProgress records the percent of the job that is done.
+func (h *JobHandle) SetProgress(percent float64) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	jobs.update(h.id, func(job *Job) {
+		job.Progress = percent
+	})
+}
+
+// Logf adds a message to the job's log and the server log.
+func (h *JobHandle) Logf(format string, args ...interface{}) {
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	dvid.Infof("Job %s: %s\n", h.id, msg)
+	line := time.Now().Format(time.RFC3339) + " " + msg
+	jobs.update(h.id, func(job *Job) {
+		job.Log = append(job.Log, line)
+		if len(job.Log) > maxJobLogLines {
+			job.Log = job.Log[len(job.Log)-maxJobLogLines:]
+		}
+	})
+}
+
+type jobManager struct {
+	sync.Mutex
+	loaded  bool
+	jobs    map[string]*Job
+	handles map[string]*JobHandle // running jobs
+	saved   map[string]time.Time
+}
+
+var jobs = jobManager{
+	jobs:    make(map[string]*Job),
+	handles: make(map[string]*JobHandle),
+	saved:   make(map[string]time.Time),
+}
+
+// loadJobs reads stored jobs from the metadata store if not already loaded.  Jobs that
+// were running are marked as interrupted, and old finished jobs are deleted.  Must be
+// called with the job lock held.
+func (m *jobManager) loadJobs() error {
+	if m.loaded {
+		return nil
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	ctx := storage.NewMetadataContext()
+	keyvalues, err := store.GetRange(ctx, []byte{jobKey}, []byte{jobKey, 0xFF})
+	if err != nil {
+		return fmt.Errorf("Unable to load jobs: %s", err.Error())
+	}
+	for _, kv := range keyvalues {
+		job := new(Job)
+		dec := gob.NewDecoder(bytes.NewBuffer(kv.V))
+		if err := dec.Decode(job); err != nil {
+			return fmt.Errorf("Could not decode stored job: %s", err.Error())
+		}
+		if job.State == JobRunning {
+			job.State = JobInterrupted
+			job.Error = "Server stopped before job finished"
+			job.Finished = time.Now()
+			if err := putJob(job); err != nil {
+				dvid.Errorf("Unable to save interrupted job %s: %s\n", job.ID, err.Error())
+			}
+		}
+		m.jobs[job.ID] = job
+	}
+	m.loaded = true
+	m.prune()
+	return nil
+}
+
+// prune deletes finished jobs older than the retention time.  Must be called with the
+// job lock held.
+func (m *jobManager) prune() {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return
+	}
+	for id, job := range m.jobs {
+		if job.Done() && time.Since(job.Finished) > JobRetention {
+			if err := store.Delete(storage.NewMetadataContext(), jobIndex(id)); err != nil {
+				dvid.Errorf("Unable to delete old job %s: %s\n", id, err.Error())
+				continue
+			}
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// update modifies a running job and saves it if it hasn't been saved recently.
+func (m *jobManager) update(id string, f func(job *Job)) {
+	m.Lock()
+	defer m.Unlock()
+	job, found := m.jobs[id]
+	if !found {
+		return
+	}
+	f(job)
+	if time.Since(m.saved[id]) > jobSaveInterval {
+		if err := putJob(job); err != nil {
+			dvid.Errorf("Unable to save job %s: %s\n", id, err.Error())
+		}
+		m.saved[id] = time.Now()
+	}
+}
+
+func putJob(job *Job) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(job); err != nil {
+		return err
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	return store.Put(storage.NewMetadataContext(), jobIndex(job.ID), buf.Bytes())
+}
+
+// StartJob runs a function in the background as a new job and returns a copy of the
+// job's initial state.
+func StartJob(command, owner string, f JobFunc) (*Job, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate job ID: %s", err.Error())
+	}
+	job := &Job{
+		ID:      id,
+		Command: command,
+		Owner:   owner,
+		State:   JobRunning,
+		Created: time.Now(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	handle := &JobHandle{id, ctx, cancel}
+
+	jobs.Lock()
+	if err := jobs.loadJobs(); err != nil {
+		jobs.Unlock()
+		return nil, err
+	}
+	jobs.prune()
+	if err := putJob(job); err != nil {
+		jobs.Unlock()
+		return nil, err
+	}
+	jobs.jobs[id] = job
+	jobs.handles[id] = handle
+	jobs.saved[id] = time.Now()
+	copied := *job
+	jobs.Unlock()
+
+	dvid.Infof("Started job %s: %s\n", id, command)
+	go runJob(handle, f)
+	return &copied, nil
+}
+
+func runJob(handle *JobHandle, f JobFunc) {
+	result, err := func() (result string, err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = fmt.Errorf("panic: %v", e)
+			}
+		}()
+		return f(handle)
+	}()
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	job := jobs.jobs[handle.id]
+	delete(jobs.handles, handle.id)
+	delete(jobs.saved, handle.id)
+	handle.cancel()
+
+	job.Finished = time.Now()
+	job.Result = result
+	switch {
+	case job.CancelRequested:
+		job.State = JobCanceled
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+	default:
+		job.State = JobSucceeded
+		job.Progress = 100
+	}
+	if err != nil {
+		dvid.Errorf("Job %s (%s) %s: %s\n", job.ID, job.Command, job.State, err.Error())
+	} else {
+		dvid.Infof("Job %s (%s) %s after %s\n", job.ID, job.Command, job.State, job.Finished.Sub(job.Created))
+	}
+	if err := putJob(job); err != nil {
+		dvid.Errorf("Unable to save job %s: %s\n", job.ID, err.Error())
+	}
+}
+
+// GetJob returns a copy of a job.
+func GetJob(id string) (*Job, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if err := jobs.loadJobs(); err != nil {
+		return nil, err
+	}
+	job, found := jobs.jobs[id]
+	if !found {
+		return nil, fmt.Errorf("No job %q", id)
+	}
+	copied := *job
+	copied.Log = append([]string{}, job.Log...)
+	return &copied, nil
+}
+
+// Jobs returns all jobs sorted by creation time.
+func Jobs() ([]Job, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if err := jobs.loadJobs(); err != nil {
+		return nil, err
+	}
+	list := make([]Job, 0, len(jobs.jobs))
+	for _, job := range jobs.jobs {
+		list = append(list, *job)
+	}
+	sort.Sort(jobsByCreation(list))
+	return list, nil
+}
+
+type jobsByCreation []Job
+
+func (s jobsByCreation) Len() int           { return len(s) }
+func (s jobsByCreation) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s jobsByCreation) Less(i, j int) bool { return s[i].Created.Before(s[j].Created) }
+
+// CancelJob asks a running job to stop.  The job is canceled once its function returns.
+func CancelJob(id string) error {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if err := jobs.loadJobs(); err != nil {
+		return err
+	}
+	job, found := jobs.jobs[id]
+	if !found {
+		return fmt.Errorf("No job %q", id)
+	}
+	handle, running := jobs.handles[id]
+	if !running {
+		return fmt.Errorf("Job %s is not running: %s", id, job.State)
+	}
+	job.CancelRequested = true
+	handle.cancel()
+	return putJob(job)
+}
+
+// DeleteJob deletes the record of a finished job.
+func DeleteJob(id string) error {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if err := jobs.loadJobs(); err != nil {
+		return err
+	}
+	job, found := jobs.jobs[id]
+	if !found {
+		return fmt.Errorf("No job %q", id)
+	}
+	if !job.Done() {
+		return fmt.Errorf("Job %s is still running and must be canceled first", id)
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(storage.NewMetadataContext(), jobIndex(id)); err != nil {
+		return err
+	}
+	delete(jobs.jobs, id)
+	return nil
+}
+
+// startRPCJob runs an RPC command as a job.  The job's result is the text the command
+// would have returned.
+func startRPCJob(c *RPCConnection, cmd datastore.Request) (*Job, error) {
+	return StartJob(cmd.String(), "rpc", func(handle *JobHandle) (string, error) {
+		cmd.SetJob(handle)
+		var reply datastore.Response
+		if err := c.do(cmd, &reply); err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := reply.Write(&buf); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	})
+}
+
+// ---- Job handlers
+
+// canAccessJob returns true if the client of a request can view or cancel a job, which
+// requires being the owner or an administrator when authentication is enabled.
+func canAccessJob(w http.ResponseWriter, r *http.Request, job *Job) bool {
+	if !AuthEnabled() {
+		return true
+	}
+	if token := getAuthToken(r); token != nil && (token.Admin || token.Name == job.Owner) {
+		return true
+	}
+	Unauthorized(w, r, http.StatusForbidden, fmt.Sprintf("Not allowed to access job %s", job.ID))
+	return false
+}
+
+func jobsGetHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := Jobs()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	if AuthEnabled() {
+		token := getAuthToken(r)
+		visible := []Job{}
+		for _, job := range list {
+			if token != nil && (token.Admin || token.Name == job.Owner) {
+				visible = append(visible, job)
+			}
+		}
+		list = visible
+	}
+	state := JobState(r.URL.Query().Get("state"))
+	if state != "" {
+		matching := []Job{}
+		for _, job := range list {
+			if job.State == state {
+				matching = append(matching, job)
+			}
+		}
+		list = matching
+	}
+	writeAuthJSON(w, r, list)
+}
+
+func jobGetHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	job, err := GetJob(c.URLParams["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !canAccessJob(w, r, job) {
+		return
+	}
+	writeAuthJSON(w, r, job)
+}
+
+func jobLogHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	job, err := GetJob(c.URLParams["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !canAccessJob(w, r, job) {
+		return
+	}
+	lines := job.Log
+	if s := r.URL.Query().Get("lines"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			BadRequest(w, r, "Bad number of log lines: %q", s)
+			return
+		}
+		if n < len(lines) {
+			lines = lines[len(lines)-n:]
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func jobCancelHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	id := c.URLParams["id"]
+	job, err := GetJob(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !canAccessJob(w, r, job) {
+		return
+	}
+	if err := CancelJob(id); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Canceling job %s\n", id)
+}
+
+func jobDeleteHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	id := c.URLParams["id"]
+	job, err := GetJob(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !canAccessJob(w, r, job) {
+		return
+	}
+	if err := DeleteJob(id); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Deleted job %s\n", id)
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// resetJobs forgets all jobs so they are reloaded from the current metadata store.
+func resetJobs() {
+	jobs.Lock()
+	jobs.loaded = false
+	jobs.jobs = make(map[string]*Job)
+	jobs.handles = make(map[string]*JobHandle)
+	jobs.saved = make(map[string]time.Time)
+	jobs.Unlock()
+}
+
+// waitForJob waits until a job is no longer running and returns it.
+func waitForJob(t *testing.T, id string) *Job {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		job, err := GetJob(id)
+		if err != nil {
+			t.Fatalf("Unable to get job %s: %s\n", id, err.Error())
+		}
+		if job.Done() {
+			return job
+		}
+	}
+	t.Fatalf("Job %s didn't finish\n", id)
+	return nil
+}
+
+func TestJobProgress(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetJobs()
+	resetJobs()
+
+	proceed := make(chan struct{})
+	job, err := StartJob("test progress", "rpc", func(handle *JobHandle) (string, error) {
+		handle.SetProgress(50)
+		handle.Logf("first line")
+		handle.Logf("second line\n")
+		<-proceed
+		return "all done", nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to start job: %s\n", err.Error())
+	}
+	if job.State != JobRunning {
+		t.Errorf("Expected new job to be running, got %s\n", job.State)
+	}
+
+	// Progress and log are available while the job runs.
+	jobURL := WebAPIPath + "server/jobs/" + job.ID
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		w := testRequest("GET", jobURL+"/log", "", nil)
+		if strings.Count(w.Body.String(), "\n") == 2 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected 2 log lines, got %q\n", w.Body.String())
+		}
+	}
+	w := testRequest("GET", jobURL, "", nil)
+	var status Job
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Unable to decode job status %q: %s\n", w.Body.String(), err.Error())
+	}
+	if status.State != JobRunning || status.Progress != 50 || status.Command != "test progress" {
+		t.Errorf("Bad status of running job: %+v\n", status)
+	}
+	w = testRequest("GET", jobURL+"/log?lines=1", "", nil)
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], " second line") {
+		t.Errorf("Expected last log line, got %q\n", w.Body.String())
+	}
+	if w = testRequest("DELETE", jobURL, "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected delete of running job to fail, got status %d\n", w.Code)
+	}
+	w = testRequest("GET", WebAPIPath+"server/jobs?state=running", "", nil)
+	var running []Job
+	if err := json.Unmarshal(w.Body.Bytes(), &running); err != nil || len(running) != 1 || running[0].ID != job.ID {
+		t.Errorf("Expected only the running job, got %s\n", w.Body.String())
+	}
+
+	close(proceed)
+	done := waitForJob(t, job.ID)
+	if done.State != JobSucceeded || done.Progress != 100 || done.Result != "all done" {
+		t.Errorf("Bad status of finished job: %+v\n", done)
+	}
+
+	// Failed and panicking jobs record their error.
+	failed, _ := StartJob("test failure", "rpc", func(handle *JobHandle) (string, error) {
+		return "", fmt.Errorf("bad data")
+	})
+	panicked, _ := StartJob("test panic", "rpc", func(handle *JobHandle) (string, error) {
+		panic("oops")
+	})
+	if done := waitForJob(t, failed.ID); done.State != JobFailed || done.Error != "bad data" {
+		t.Errorf("Bad status of failed job: %+v\n", done)
+	}
+	if done := waitForJob(t, panicked.ID); done.State != JobFailed || done.Error != "panic: oops" {
+		t.Errorf("Bad status of panicked job: %+v\n", done)
+	}
+
+	if w = testRequest("DELETE", jobURL, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("Unable to delete finished job, status %d: %s\n", w.Code, w.Body.String())
+	}
+	if w = testRequest("GET", jobURL, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected deleted job to be gone, got status %d\n", w.Code)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetJobs()
+	resetJobs()
+
+	job, err := StartJob("test cancel", "rpc", func(handle *JobHandle) (string, error) {
+		select {
+		case <-handle.Done():
+			return "partial", handle.Context().Err()
+		case <-time.After(5 * time.Second):
+			return "", fmt.Errorf("job was not canceled")
+		}
+	})
+	if err != nil {
+		t.Fatalf("Unable to start job: %s\n", err.Error())
+	}
+	cancelURL := WebAPIPath + "server/jobs/" + job.ID + "/cancel"
+	if w := testRequest("POST", cancelURL, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("Unable to cancel job, status %d: %s\n", w.Code, w.Body.String())
+	}
+	done := waitForJob(t, job.ID)
+	if done.State != JobCanceled || !done.CancelRequested || done.Result != "partial" {
+		t.Errorf("Bad status of canceled job: %+v\n", done)
+	}
+	if w := testRequest("POST", cancelURL, "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected cancel of finished job to fail, got status %d\n", w.Code)
+	}
+	if w := testRequest("POST", WebAPIPath+"server/jobs/unknown/cancel", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected cancel of unknown job to fail with 404, got status %d\n", w.Code)
+	}
+}
+
+func TestJobReload(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetJobs()
+	resetJobs()
+
+	// Stored jobs that were running are interrupted, and old finished jobs are pruned.
+	stored := []*Job{
+		{ID: "running", Command: "load", State: JobRunning, Created: time.Now()},
+		{ID: "recent", Command: "load", State: JobSucceeded, Created: time.Now(), Finished: time.Now()},
+		{ID: "old", Command: "load", State: JobFailed, Created: time.Now().Add(-2 * JobRetention),
+			Finished: time.Now().Add(-JobRetention - time.Hour)},
+	}
+	for _, job := range stored {
+		if err := putJob(job); err != nil {
+			t.Fatalf("Unable to store job %s: %s\n", job.ID, err.Error())
+		}
+	}
+	list, err := Jobs()
+	if err != nil {
+		t.Fatalf("Unable to get jobs: %s\n", err.Error())
+	}
+	states := make(map[string]JobState)
+	for _, job := range list {
+		states[job.ID] = job.State
+	}
+	if len(states) != 2 || states["running"] != JobInterrupted || states["recent"] != JobSucceeded {
+		t.Errorf("Bad reloaded jobs: %v\n", states)
+	}
+
+	// The interrupted state was saved.
+	resetJobs()
+	if job, err := GetJob("running"); err != nil || job.State != JobInterrupted {
+		t.Errorf("Expected interrupted job to be saved, got %+v (%v)\n", job, err)
+	}
+	if _, err := GetJob("old"); err == nil {
+		t.Errorf("Expected old job to be pruned\n")
+	}
+}
+
+func TestJobAccess(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetJobs()
+	defer resetAuth()
+	resetJobs()
+
+	enableTestAuth(t, "test-admin")
+	alice, err := NewAuthToken("alice", false, nil)
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+	bob, err := NewAuthToken("bob", false, nil)
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+	job, err := StartJob("test access", "alice", func(handle *JobHandle) (string, error) {
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to start job: %s\n", err.Error())
+	}
+	waitForJob(t, job.ID)
+
+	// Only the owner and admins can see a job.
+	jobURL := WebAPIPath + "server/jobs/" + job.ID
+	for token, expected := range map[string]int{
+		alice.Token:  http.StatusOK,
+		"test-admin": http.StatusOK,
+		bob.Token:    http.StatusForbidden,
+		"":           http.StatusUnauthorized,
+	} {
+		if w := testRequest("GET", jobURL, token, nil); w.Code != expected {
+			t.Errorf("GET job with token %q: expected status %d, got %d\n", token, expected, w.Code)
+		}
+	}
+	for token, expected := range map[string]int{alice.Token: 1, bob.Token: 0} {
+		w := testRequest("GET", WebAPIPath+"server/jobs", token, nil)
+		var list []Job
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != expected {
+			t.Errorf("Expected %d visible jobs, got %s\n", expected, w.Body.String())
+		}
+	}
+}
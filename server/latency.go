@@ -0,0 +1,378 @@
+/*
+	This file records latency histograms of HTTP requests per endpoint class, e.g.,
+	"GET labelblk/raw", and per data instance.  Histograms are kept in memory, periodically
+	persisted to the metadata store so they survive restarts, and reported as percentiles
+	via the admin API and a Prometheus-style metrics endpoint.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+// The metadata index of the persisted latency histograms.
+var latencyIndex = []byte{0xA5, 'l', 'a', 't'}
+
+const (
+	// Histogram buckets grow by a factor of sqrt(2) from 0.1 ms, so the last bounded
+	// bucket ends at about 40 minutes.  Slower requests go into an overflow bucket.
+	numLatencyBuckets = 50
+	minLatencyMs      = 0.1
+
+	// How often latency histograms are persisted while the server runs.
+	latencySaveInterval = 5 * time.Minute
+)
+
+// latencyBound returns the upper bound in milliseconds of the i-th histogram bucket.
+func latencyBound(i int) float64 {
+	return minLatencyMs * math.Pow(2, float64(i)/2)
+}
+
+// latencyHistogram counts request durations in exponentially sized buckets.  The last
+// count is the overflow bucket.
+type latencyHistogram struct {
+	Counts [numLatencyBuckets + 1]uint64
+	Count  uint64
+	SumMs  float64
+	MaxMs  float64
+}
+
+func (h *latencyHistogram) record(ms float64) {
+	i := sort.Search(numLatencyBuckets, func(i int) bool { return ms <= latencyBound(i) })
+	h.Counts[i]++
+	h.Count++
+	h.SumMs += ms
+	if ms > h.MaxMs {
+		h.MaxMs = ms
+	}
+}
+
+// percentile returns the estimated duration in milliseconds below which the given
+// fraction of requests completed, interpolating within the bucket that holds it.
+func (h *latencyHistogram) percentile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	target := q * float64(h.Count)
+	var cumulative float64
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		if cumulative+float64(n) >= target {
+			var lower, upper float64
+			if i > 0 {
+				lower = latencyBound(i - 1)
+			}
+			if i < numLatencyBuckets {
+				upper = latencyBound(i)
+			}
+			if upper == 0 || upper > h.MaxMs {
+				upper = h.MaxMs
+			}
+			if lower > upper {
+				lower = upper
+			}
+			return lower + (upper-lower)*(target-cumulative)/float64(n)
+		}
+		cumulative += float64(n)
+	}
+	return h.MaxMs
+}
+
+// LatencySummary gives percentiles in milliseconds of a histogram.
+type LatencySummary struct {
+	Count  uint64
+	MeanMs float64
+	P50Ms  float64
+	P90Ms  float64
+	P99Ms  float64
+	MaxMs  float64
+}
+
+func (h *latencyHistogram) summary() LatencySummary {
+	s := LatencySummary{
+		Count: h.Count,
+		P50Ms: h.percentile(0.5),
+		P90Ms: h.percentile(0.9),
+		P99Ms: h.percentile(0.99),
+		MaxMs: h.MaxMs,
+	}
+	if h.Count != 0 {
+		s.MeanMs = h.SumMs / float64(h.Count)
+	}
+	return s
+}
+
+// latencyStats holds histograms for each endpoint class and data instance.  Instances
+// are keyed by the root UUID of their repo and their name, e.g., "3f8c.../grayscale".
+type latencyStats struct {
+	mu        sync.Mutex
+	Since     time.Time
+	Endpoints map[string]*latencyHistogram
+	Instances map[string]*latencyHistogram
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{
+		Since:     time.Now(),
+		Endpoints: make(map[string]*latencyHistogram),
+		Instances: make(map[string]*latencyHistogram),
+	}
+}
+
+var (
+	latencies = newLatencyStats()
+
+	// True if latency histograms are persisted to the metadata store.
+	latencyPersisted bool
+)
+
+func (s *latencyStats) record(endpoint, instance string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, found := s.Endpoints[endpoint]
+	if !found {
+		h = new(latencyHistogram)
+		s.Endpoints[endpoint] = h
+	}
+	h.record(ms)
+	if instance == "" {
+		return
+	}
+	if h, found = s.Instances[instance]; !found {
+		h = new(latencyHistogram)
+		s.Instances[instance] = h
+	}
+	h.record(ms)
+}
+
+func (s *latencyStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Since = time.Now()
+	s.Endpoints = make(map[string]*latencyHistogram)
+	s.Instances = make(map[string]*latencyHistogram)
+}
+
+// LatencyReport gives latency percentiles since the given time.
+type LatencyReport struct {
+	Since     time.Time
+	Endpoints map[string]LatencySummary
+	Instances map[string]LatencySummary
+}
+
+func (s *latencyStats) report() LatencyReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := LatencyReport{
+		Since:     s.Since,
+		Endpoints: make(map[string]LatencySummary, len(s.Endpoints)),
+		Instances: make(map[string]LatencySummary, len(s.Instances)),
+	}
+	for endpoint, h := range s.Endpoints {
+		report.Endpoints[endpoint] = h.summary()
+	}
+	for instance, h := range s.Instances {
+		report.Instances[instance] = h.summary()
+	}
+	return report
+}
+
+// save persists the histograms to the metadata store.
+func (s *latencyStats) save() error {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	s.mu.Lock()
+	err = gob.NewEncoder(&buf).Encode(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return store.Put(storage.NewMetadataContext(), latencyIndex, buf.Bytes())
+}
+
+// load merges any persisted histograms into the current ones.
+func (s *latencyStats) load() error {
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	value, err := store.Get(storage.NewMetadataContext(), latencyIndex)
+	if err != nil || value == nil {
+		return err
+	}
+	stored := newLatencyStats()
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(stored); err != nil {
+		return fmt.Errorf("Could not decode stored latency histograms: %s", err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored.Since.Before(s.Since) {
+		s.Since = stored.Since
+	}
+	mergeHistograms(s.Endpoints, stored.Endpoints)
+	mergeHistograms(s.Instances, stored.Instances)
+	return nil
+}
+
+func mergeHistograms(dst, src map[string]*latencyHistogram) {
+	for key, h := range src {
+		existing, found := dst[key]
+		if !found {
+			dst[key] = h
+			continue
+		}
+		for i, n := range h.Counts {
+			existing.Counts[i] += n
+		}
+		existing.Count += h.Count
+		existing.SumMs += h.SumMs
+		if h.MaxMs > existing.MaxMs {
+			existing.MaxMs = h.MaxMs
+		}
+	}
+}
+
+// EnableLatencyPersistence loads latency histograms persisted before the last shutdown
+// and periodically persists them while the server runs.
+func EnableLatencyPersistence() error {
+	if err := latencies.load(); err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(latencySaveInterval) {
+			if err := latencies.save(); err != nil {
+				dvid.Errorf("Unable to save latency histograms: %s\n", err.Error())
+			}
+		}
+	}()
+	latencyPersisted = true
+	return nil
+}
+
+// latencyLabels are set by handlers that know the endpoint class and data instance of
+// a request in more detail than its path.
+type latencyLabels struct {
+	endpoint string
+	instance string
+}
+
+// endpointClass returns the endpoint class of a request that doesn't go to a data
+// instance, which is the method and the API path without UUIDs or other parameters.
+func endpointClass(r *http.Request) string {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return r.Method + " webclient"
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	class := parts[0]
+	switch parts[0] {
+	case "server", "repos":
+		if len(parts) > 1 {
+			class += "/" + parts[1]
+		}
+	case "repo":
+		if len(parts) > 2 {
+			class += "/" + parts[2]
+		}
+	}
+	return r.Method + " " + class
+}
+
+// Middleware that records the latency of each request.
+func latencyHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		labels := new(latencyLabels)
+		if c.Env == nil {
+			c.Env = make(map[interface{}]interface{})
+		}
+		c.Env["latency"] = labels
+		t0 := time.Now()
+		h.ServeHTTP(w, r)
+		if labels.endpoint == "" {
+			labels.endpoint = endpointClass(r)
+		}
+		latencies.record(labels.endpoint, labels.instance, time.Since(t0))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// setLatencyLabels labels a request to a data instance with its datatype and keyword.
+func setLatencyLabels(c *web.C, r *http.Request, repo datastore.Repo, data datastore.DataService) {
+	labels, ok := c.Env["latency"].(*latencyLabels)
+	if !ok {
+		return
+	}
+	labels.endpoint = fmt.Sprintf("%s %s/%s", r.Method, data.TypeName(), c.URLParams["keyword"])
+	labels.instance = fmt.Sprintf("%s/%s", repo.RootUUID(), data.DataName())
+}
+
+func latencyGetHandler(w http.ResponseWriter, r *http.Request) {
+	m, err := json.Marshal(latencies.report())
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
+}
+
+func latencyDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	latencies.reset()
+	if err := latencies.save(); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Unable to save reset latency histograms: %s", err.Error()))
+		return
+	}
+	dvid.Infof("Reset latency histograms\n")
+}
+
+// metricsHandler writes latency percentiles in the Prometheus text format as summaries.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	report := latencies.report()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeLatencyMetric(w, "dvid_endpoint_latency_seconds", "endpoint", report.Endpoints,
+		"Latency of HTTP requests by endpoint class.")
+	writeLatencyMetric(w, "dvid_instance_latency_seconds", "instance", report.Instances,
+		"Latency of HTTP requests by data instance.")
+}
+
+func writeLatencyMetric(w http.ResponseWriter, name, label string, summaries map[string]LatencySummary, help string) {
+	keys := make([]string, 0, len(summaries))
+	for key := range summaries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+	for _, key := range keys {
+		s := summaries[key]
+		value := strings.Replace(strings.Replace(key, `\`, `\\`, -1), `"`, `\"`, -1)
+		quantiles := []struct {
+			q  string
+			ms float64
+		}{{"0.5", s.P50Ms}, {"0.9", s.P90Ms}, {"0.99", s.P99Ms}}
+		for _, quantile := range quantiles {
+			fmt.Fprintf(w, "%s{%s=\"%s\",quantile=\"%s\"} %g\n", name, label, value, quantile.q, quantile.ms/1000)
+		}
+		fmt.Fprintf(w, "%s_sum{%s=\"%s\"} %g\n", name, label, value, s.MeanMs*float64(s.Count)/1000)
+		fmt.Fprintf(w, "%s_count{%s=\"%s\"} %d\n", name, label, value, s.Count)
+	}
+}
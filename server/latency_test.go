@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+	"github.com/zenazn/goji/web"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	h.record(0.05)
+	h.record(0.1)
+	h.record(0.15)
+	h.record(1e9)
+	if h.Counts[0] != 2 {
+		t.Errorf("Expected durations up to %g ms in first bucket, got %d\n", latencyBound(0), h.Counts[0])
+	}
+	if h.Counts[1] != 0 || h.Counts[2] != 1 {
+		t.Errorf("Expected 0.15 ms in bucket ending at %g ms, got counts %v\n", latencyBound(2), h.Counts[:3])
+	}
+	if h.Counts[numLatencyBuckets] != 1 {
+		t.Errorf("Expected slow request in overflow bucket\n")
+	}
+	if h.Count != 4 || h.MaxMs != 1e9 {
+		t.Errorf("Bad histogram totals: count %d, max %g\n", h.Count, h.MaxMs)
+	}
+
+	h = latencyHistogram{}
+	for i := 0; i < 100; i++ {
+		h.record(10)
+	}
+	s := h.summary()
+	if s.Count != 100 || s.MeanMs != 10 || s.MaxMs != 10 {
+		t.Errorf("Bad latency summary: %+v\n", s)
+	}
+	if s.P50Ms > 10 || s.P50Ms < latencyBound(13) || s.P99Ms > 10 {
+		t.Errorf("Expected percentiles within the bucket holding 10 ms: %+v\n", s)
+	}
+}
+
+func TestEndpointClass(t *testing.T) {
+	cases := []struct {
+		method, path, class string
+	}{
+		{"GET", "/api/server/info", "GET server/info"},
+		{"GET", "/api/repos/info", "GET repos/info"},
+		{"POST", "/api/repo/3f8c/lock", "POST repo/lock"},
+		{"GET", "/api/help", "GET help"},
+		{"GET", "/console/index.html", "GET webclient"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if class := endpointClass(r); class != tc.class {
+			t.Errorf("Expected class %q for %s %s, got %q\n", tc.class, tc.method, tc.path, class)
+		}
+	}
+}
+
+// latencyTestData is a data instance with just a name and type.
+type latencyTestData struct {
+	datastore.DataService
+}
+
+func (d latencyTestData) DataName() dvid.DataString { return "mydata" }
+func (d latencyTestData) TypeName() dvid.TypeString { return "grayscale8" }
+
+func TestSetLatencyLabels(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	repo, _ := tests.NewRepo()
+	labels := new(latencyLabels)
+	c := &web.C{
+		Env:       map[interface{}]interface{}{"latency": labels},
+		URLParams: map[string]string{"keyword": "raw"},
+	}
+	r := httptest.NewRequest("GET", "/api/node/3f8c/mydata/raw/0_1/64_64/0_0_0", nil)
+	setLatencyLabels(c, r, repo, latencyTestData{})
+	if labels.endpoint != "GET grayscale8/raw" {
+		t.Errorf("Bad endpoint label %q\n", labels.endpoint)
+	}
+	if labels.instance != string(repo.RootUUID())+"/mydata" {
+		t.Errorf("Bad instance label %q\n", labels.instance)
+	}
+
+	// Requests without latency tracking are ignored.
+	setLatencyLabels(&web.C{Env: map[interface{}]interface{}{}}, r, repo, latencyTestData{})
+}
+
+func TestLatencyEndpoints(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	latencies.reset()
+	defer latencies.reset()
+
+	for i := 0; i < 3; i++ {
+		testRequest("GET", WebAPIPath+"help", "", nil)
+	}
+
+	w := testRequest("GET", WebAPIPath+"server/latency", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Bad status %d getting latencies: %s\n", w.Code, w.Body.String())
+	}
+	var report LatencyReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Bad latency JSON: %s\n", err.Error())
+	}
+	if s, found := report.Endpoints["GET help"]; !found || s.Count != 3 {
+		t.Errorf("Expected 3 help requests in latency report, got %+v\n", report.Endpoints)
+	}
+
+	w = httptest.NewRecorder()
+	ServeSingleHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Bad status %d getting metrics\n", w.Code)
+	}
+	metrics := w.Body.String()
+	for _, line := range []string{
+		"# TYPE dvid_endpoint_latency_seconds summary",
+		`dvid_endpoint_latency_seconds_count{endpoint="GET help"} 3`,
+		`dvid_endpoint_latency_seconds{endpoint="GET help",quantile="0.5"}`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Expected metrics to include %q, got:\n%s\n", line, metrics)
+		}
+	}
+
+	if w := testRequest("DELETE", WebAPIPath+"server/latency", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("Bad status %d resetting latencies: %s\n", w.Code, w.Body.String())
+	}
+	if _, found := latencies.report().Endpoints["GET help"]; found {
+		t.Errorf("Expected latencies to be reset\n")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+	This file supports runtime control of logging.  The global log level and the levels of
+	modules, i.e., DVID packages like "datatype/labels64" or parents like "storage", can be
+	changed without restarting the server, e.g., to debug one datatype on a busy server.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// LoggingStatus describes the current logging settings.
+type LoggingStatus struct {
+	Level   dvid.ModeFlag
+	Format  dvid.LogFormat
+	Modules map[string]dvid.ModeFlag
+}
+
+// loggingRequest changes logging settings.  A module with an empty level reverts to the
+// level of its parent or the global level.
+type loggingRequest struct {
+	Level   string
+	Modules map[string]string
+}
+
+func writeLoggingStatus(w http.ResponseWriter, r *http.Request) {
+	status := LoggingStatus{dvid.LogMode(), dvid.CurrentLogFormat(), dvid.ModuleLogLevels()}
+	jsonBytes, err := json.Marshal(status)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func loggingGetHandler(w http.ResponseWriter, r *http.Request) {
+	writeLoggingStatus(w, r)
+}
+
+func loggingPostHandler(w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	var req loggingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+		return
+	}
+
+	// Parse everything before changing any levels.
+	var level dvid.ModeFlag
+	var err error
+	if req.Level != "" {
+		if level, err = dvid.ParseLogLevel(req.Level); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+	}
+	modules := make(map[string]dvid.ModeFlag, len(req.Modules))
+	for module, levelStr := range req.Modules {
+		if levelStr == "" {
+			continue
+		}
+		if modules[module], err = dvid.ParseLogLevel(levelStr); err != nil {
+			BadRequest(w, r, "Bad level for module %q: %s", module, err.Error())
+			return
+		}
+	}
+
+	if req.Level != "" {
+		dvid.SetLogMode(level)
+	}
+	for module, levelStr := range req.Modules {
+		if levelStr == "" {
+			dvid.ClearModuleLogLevel(module)
+		} else {
+			dvid.SetModuleLogLevel(module, modules[module])
+		}
+	}
+	dvid.Infof("Logging changed by %s: level %s, modules %v\n", requestClient(r), dvid.LogMode(), req.Modules)
+	writeLoggingStatus(w, r)
+}
@@ -0,0 +1,137 @@
+/*
+	This file supports HTTP requests for the descriptive metadata of repos and nodes: a
+	note, key/value tags, and an append-only provenance log.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/zenazn/goji/web"
+)
+
+// metadataTarget gets and changes the metadata of a repo or node.
+type metadataTarget struct {
+	get           func() (datastore.Metadata, error)
+	setNote       func(string) error
+	setTags       func(map[string]*string) error
+	addProvenance func(datastore.Provenance) error
+}
+
+func repoMetadata(c web.C) metadataTarget {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	return metadataTarget{
+		get: func() (datastore.Metadata, error) {
+			return repo.GetMetadata(), nil
+		},
+		setNote:       repo.SetNote,
+		setTags:       repo.SetTags,
+		addProvenance: repo.AddProvenance,
+	}
+}
+
+func nodeMetadata(c web.C) metadataTarget {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+	return metadataTarget{
+		get: func() (datastore.Metadata, error) {
+			return repo.GetNodeMetadata(uuid)
+		},
+		setNote: func(note string) error {
+			return repo.SetNodeNote(uuid, note)
+		},
+		setTags: func(tags map[string]*string) error {
+			return repo.SetNodeTags(uuid, tags)
+		},
+		addProvenance: func(entry datastore.Provenance) error {
+			return repo.AddNodeProvenance(uuid, entry)
+		},
+	}
+}
+
+func writeMetadata(w http.ResponseWriter, r *http.Request, target metadataTarget) {
+	md, err := target.get()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(md)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func metadataGetHandler(getTarget func(web.C) metadataTarget) func(web.C, http.ResponseWriter, *http.Request) {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		writeMetadata(w, r, getTarget(c))
+	}
+}
+
+func metadataNoteHandler(getTarget func(web.C) metadataTarget) func(web.C, http.ResponseWriter, *http.Request) {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var config struct {
+			Note *string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+		if config.Note == nil {
+			BadRequest(w, r, "POST on note endpoint requires a 'note' property")
+			return
+		}
+		target := getTarget(c)
+		if err := target.setNote(*config.Note); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		writeMetadata(w, r, target)
+	}
+}
+
+func metadataTagsHandler(getTarget func(web.C) metadataTarget) func(web.C, http.ResponseWriter, *http.Request) {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var tags map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+			BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+		target := getTarget(c)
+		if err := target.setTags(tags); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		writeMetadata(w, r, target)
+	}
+}
+
+func metadataProvenanceHandler(getTarget func(web.C) metadataTarget) func(web.C, http.ResponseWriter, *http.Request) {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var config struct {
+			Operation string `json:"operation"`
+			Detail    string `json:"detail"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+		entry := datastore.Provenance{
+			User:      requestClient(r),
+			Operation: config.Operation,
+			Detail:    config.Detail,
+		}
+		target := getTarget(c)
+		if err := target.addProvenance(entry); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		writeMetadata(w, r, target)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+	This file generates an OpenAPI 3 document describing the HTTP endpoints declared by
+	compiled datatypes, and the per-datatype help JSON listing those declarations.
+
+	Endpoints of data instances all have the form /api/node/{uuid}/{data name}/..., so
+	the data name parameter of each datatype's paths is named after the datatype, e.g.,
+	/api/node/{uuid}/{keyvalue}/key/{key}.  This keeps each datatype's operations in a
+	separate path so client generators produce separate calls per datatype.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/zenazn/goji/web"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification used by /api/spec.
+const OpenAPIVersion = "3.0.3"
+
+// TypeEndpoints is the help JSON for a datatype, listing its declared endpoints.
+type TypeEndpoints struct {
+	Name      dvid.TypeString
+	URL       dvid.URLString
+	Version   string
+	Endpoints []datastore.Endpoint
+}
+
+type openAPIParam struct {
+	Name        string                 `json:"name"`
+	In          string                 `json:"in"`
+	Description string                 `json:"description,omitempty"`
+	Required    bool                   `json:"required"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+type openAPIMedia struct {
+	Schema map[string]interface{} `json:"schema,omitempty"`
+}
+
+type openAPIBody struct {
+	Description string                  `json:"description,omitempty"`
+	Content     map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []openAPIParam             `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIDoc struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Tags  []map[string]string                    `json:"tags"`
+	Paths map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type typeServicesByName []datastore.TypeService
+
+func (s typeServicesByName) Len() int      { return len(s) }
+func (s typeServicesByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s typeServicesByName) Less(i, j int) bool {
+	return s[i].GetType().Name < s[j].GetType().Name
+}
+
+// sortedTypes returns the compiled datatypes in order of name.
+func sortedTypes() []datastore.TypeService {
+	var types typeServicesByName
+	for _, typeservice := range datastore.Compiled {
+		types = append(types, typeservice)
+	}
+	sort.Sort(types)
+	return types
+}
+
+// operationID returns a unique identifier of a datatype endpoint, e.g., "keyvalue_get_key".
+func operationID(typename dvid.TypeString, e datastore.Endpoint) string {
+	id := string(typename) + "_" + strings.ToLower(e.Method)
+	for _, segment := range strings.Split(e.Path, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment != "" {
+			id += "_" + segment
+		}
+	}
+	return id
+}
+
+func openAPIContent(body *datastore.EndpointBody) map[string]openAPIMedia {
+	return map[string]openAPIMedia{body.ContentType: {Schema: body.Schema}}
+}
+
+// openAPISpec returns an OpenAPI document for the endpoints of all compiled datatypes.
+func openAPISpec() *openAPIDoc {
+	doc := &openAPIDoc{
+		OpenAPI: OpenAPIVersion,
+		Paths:   make(map[string]map[string]openAPIOperation),
+	}
+	doc.Info.Title = "DVID datatype API"
+	doc.Info.Version = BuildVersion
+
+	for _, typeservice := range sortedTypes() {
+		t := typeservice.GetType()
+		doc.Tags = append(doc.Tags, map[string]string{
+			"name":        string(t.Name),
+			"description": fmt.Sprintf("%s datatype, version %s", t.URL, t.Version),
+		})
+		for _, e := range datastore.TypeEndpoints(typeservice) {
+			op := openAPIOperation{
+				OperationID: operationID(t.Name, e),
+				Summary:     e.Summary,
+				Description: e.Description,
+				Tags:        []string{string(t.Name)},
+				Parameters: []openAPIParam{
+					{
+						Name:        "uuid",
+						In:          "path",
+						Description: "UUID prefix that uniquely identifies a version node.",
+						Required:    true,
+						Schema:      map[string]interface{}{"type": "string"},
+					},
+					{
+						Name:        string(t.Name),
+						In:          "path",
+						Description: fmt.Sprintf("Name of a %s data instance.", t.Name),
+						Required:    true,
+						Schema:      map[string]interface{}{"type": "string"},
+					},
+				},
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "Success"},
+					"400": {Description: "Bad request"},
+				},
+			}
+			for _, param := range e.Params {
+				op.Parameters = append(op.Parameters, openAPIParam{
+					Name:        param.Name,
+					In:          param.In,
+					Description: param.Description,
+					Required:    param.Required || param.In == "path",
+					Schema:      map[string]interface{}{"type": param.Type},
+				})
+			}
+			if e.Request != nil {
+				op.RequestBody = &openAPIBody{
+					Description: e.Request.Description,
+					Content:     openAPIContent(e.Request),
+				}
+			}
+			if e.Response != nil {
+				description := e.Response.Description
+				if description == "" {
+					description = "Success"
+				}
+				op.Responses["200"] = openAPIResponse{
+					Description: description,
+					Content:     openAPIContent(e.Response),
+				}
+			}
+
+			path := fmt.Sprintf("/api/node/{uuid}/{%s}/%s", t.Name, e.Path)
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = make(map[string]openAPIOperation)
+			}
+			doc.Paths[path][strings.ToLower(e.Method)] = op
+		}
+	}
+	return doc
+}
+
+func specHandler(w http.ResponseWriter, r *http.Request) {
+	jsonBytes, err := json.Marshal(openAPISpec())
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func typeSpecHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	typeservice, err := datastore.TypeServiceByName(dvid.TypeString(c.URLParams["typename"]))
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	t := typeservice.GetType()
+	jsonBytes, err := json.Marshal(TypeEndpoints{
+		Name:      t.Name,
+		URL:       t.URL,
+		Version:   t.Version,
+		Endpoints: datastore.TypeEndpoints(typeservice),
+	})
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
@@ -0,0 +1,322 @@
+/*
+	This file supports a federated proxy mode where this server forwards read requests for
+	repos it doesn't hold to backend DVID servers.  A registry mapping version UUIDs to
+	backends is built from each backend's /api/repos/info and refreshed periodically.
+
+	If authentication is enabled, proxied requests need an API token with a read role for
+	the backend repo, keyed like local roles by the repo's root UUID.  Share tokens only
+	grant access to local repos.  The client's credentials are never forwarded; backends
+	see the proxy's own token, if configured, instead.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/zenazn/goji/web"
+)
+
+// DefaultProxyRefresh is the default time between refreshes of the backend UUID registry.
+const DefaultProxyRefresh = 60 * time.Second
+
+type proxyBackend struct {
+	url     *url.URL
+	proxy   *httputil.ReverseProxy
+	roots   map[dvid.UUID]dvid.UUID // root UUID of each version UUID
+	err     error
+	checked time.Time
+}
+
+// proxyTarget is the backend and repo root of a version UUID.
+type proxyTarget struct {
+	backend *proxyBackend
+	root    dvid.UUID
+}
+
+type proxyRegistry struct {
+	sync.RWMutex
+	enabled  bool
+	token    string // API token sent to backends
+	backends []*proxyBackend
+	uuids    map[dvid.UUID]proxyTarget
+}
+
+var proxies proxyRegistry
+
+// EnableProxy sets the backend DVID servers, e.g., "http://emdata1:8000", to which read
+// requests for non-local UUIDs are forwarded.  If token is non-empty, it is sent as the
+// API token of all requests to backends.  The registry of backend UUIDs is refreshed at
+// the given interval.
+func EnableProxy(backendURLs []string, token string, refresh time.Duration) error {
+	if len(backendURLs) == 0 {
+		return fmt.Errorf("Proxy mode requires at least one backend server")
+	}
+	backends := make([]*proxyBackend, len(backendURLs))
+	for i, backendURL := range backendURLs {
+		u, err := url.Parse(backendURL)
+		if err != nil {
+			return fmt.Errorf("Bad proxy backend URL %q: %s", backendURL, err.Error())
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Proxy backend URL %q must include scheme and host", backendURL)
+		}
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		director := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			director(r)
+			setProxyCredentials(r, token)
+		}
+		backends[i] = &proxyBackend{url: u, proxy: proxy}
+	}
+	if refresh <= 0 {
+		refresh = DefaultProxyRefresh
+	}
+
+	proxies.Lock()
+	proxies.enabled = true
+	proxies.token = token
+	proxies.backends = backends
+	proxies.uuids = make(map[dvid.UUID]proxyTarget)
+	proxies.Unlock()
+
+	proxies.refresh()
+	go func() {
+		for range time.Tick(refresh) {
+			proxies.refresh()
+		}
+	}()
+	dvid.Infof("Proxying reads for non-local repos to %d backend servers.\n", len(backends))
+	return nil
+}
+
+// repoInfo holds the parts of a backend's /api/repos/info response we need.
+type repoInfo struct {
+	DAG struct {
+		Nodes map[dvid.UUID]json.RawMessage
+	}
+}
+
+var proxyClient = &http.Client{Timeout: 30 * time.Second}
+
+// setProxyCredentials removes the client's credentials from a request to a backend and
+// sets the proxy's API token, if any.
+func setProxyCredentials(r *http.Request, token string) {
+	r.Header.Del("Authorization")
+	r.Header.Del("Cookie")
+	query := r.URL.Query()
+	if query.Get("token") != "" || query.Get(ShareQuery) != "" {
+		query.Del("token")
+		query.Del(ShareQuery)
+		r.URL.RawQuery = query.Encode()
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// fetchUUIDs returns the root UUID of each version UUID held by a backend.
+func (b *proxyBackend) fetchUUIDs(token string) (map[dvid.UUID]dvid.UUID, error) {
+	req, err := http.NewRequest("GET", b.url.String()+WebAPIPath+"repos/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	setProxyCredentials(req, token)
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend returned status %d", resp.StatusCode)
+	}
+	// Repos are keyed by root UUID.
+	var repos map[dvid.UUID]repoInfo
+	if err := json.NewDecoder(resp.Body).Decode(&repos); err != nil {
+		return nil, fmt.Errorf("could not decode repos info: %s", err.Error())
+	}
+	roots := make(map[dvid.UUID]dvid.UUID)
+	for root, repo := range repos {
+		for uuid := range repo.DAG.Nodes {
+			roots[uuid] = root
+		}
+	}
+	return roots, nil
+}
+
+// refresh rebuilds the UUID registry from all backends.  Backends that can't be reached
+// keep their previously known UUIDs.
+func (p *proxyRegistry) refresh() {
+	p.RLock()
+	backends := p.backends
+	token := p.token
+	p.RUnlock()
+
+	results := make([]map[dvid.UUID]dvid.UUID, len(backends))
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *proxyBackend) {
+			results[i], errs[i] = b.fetchUUIDs(token)
+			wg.Done()
+		}(i, b)
+	}
+	wg.Wait()
+
+	p.Lock()
+	defer p.Unlock()
+	uuids := make(map[dvid.UUID]proxyTarget)
+	for i, b := range backends {
+		b.checked = time.Now()
+		b.err = errs[i]
+		if errs[i] != nil {
+			dvid.Errorf("Unable to refresh UUIDs from proxy backend %s: %s\n", b.url, errs[i].Error())
+		} else {
+			b.roots = results[i]
+		}
+		for uuid, root := range b.roots {
+			if other, found := uuids[uuid]; found && other.backend != b {
+				dvid.Errorf("UUID %s found on backends %s and %s; using %s\n", uuid, other.backend.url, b.url, other.backend.url)
+				continue
+			}
+			uuids[uuid] = proxyTarget{backend: b, root: root}
+		}
+	}
+	p.uuids = uuids
+}
+
+// lookup returns the backend and repo root of a node given a potentially shortened UUID
+// string.
+func (p *proxyRegistry) lookup(uuidStr string) (proxyTarget, error) {
+	p.RLock()
+	defer p.RUnlock()
+	var match proxyTarget
+	numMatches := 0
+	for uuid, target := range p.uuids {
+		if strings.HasPrefix(string(uuid), uuidStr) {
+			numMatches++
+			match = target
+		}
+	}
+	switch numMatches {
+	case 0:
+		return match, fmt.Errorf("Could not find UUID with partial match to %s on any server", uuidStr)
+	case 1:
+		return match, nil
+	default:
+		return match, fmt.Errorf("More than one UUID matches %s across proxied servers", uuidStr)
+	}
+}
+
+func (p *proxyRegistry) isEnabled() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.enabled
+}
+
+// MarshalJSON returns the state of each backend.
+func (p *proxyRegistry) MarshalJSON() ([]byte, error) {
+	p.RLock()
+	defer p.RUnlock()
+	type backendJSON struct {
+		URL     string
+		UUIDs   []dvid.UUID
+		Checked time.Time
+		Error   string `json:",omitempty"`
+	}
+	backends := make([]backendJSON, len(p.backends))
+	for i, b := range p.backends {
+		uuids := make([]dvid.UUID, 0, len(b.roots))
+		for uuid := range b.roots {
+			uuids = append(uuids, uuid)
+		}
+		backends[i] = backendJSON{URL: b.url.String(), UUIDs: uuids, Checked: b.checked}
+		if b.err != nil {
+			backends[i].Error = b.err.Error()
+		}
+	}
+	return json.Marshal(backends)
+}
+
+// proxiedUUID returns the UUID string for node or repo requests.
+func proxiedUUID(path string) (string, bool) {
+	for _, prefix := range []string{WebAPIPath + "node/", WebAPIPath + "repo/"} {
+		if strings.HasPrefix(path, prefix) {
+			parts := strings.SplitN(path[len(prefix):], "/", 2)
+			return parts[0], parts[0] != ""
+		}
+	}
+	return "", false
+}
+
+// proxyHandler is middleware that forwards node and repo requests to a backend server if
+// the UUID isn't held locally.  Only GET and HEAD requests are forwarded, and if
+// authentication is enabled, only for API tokens with a read role for the repo.
+func proxyHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !proxies.isEnabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		uuidStr, ok := proxiedUUID(r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// Branch references are resolved by whichever server holds the repo.
+		uuidStr = strings.SplitN(uuidStr, ":", 2)[0]
+		if _, _, err := datastore.MatchingUUID(uuidStr); err == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		target, err := proxies.lookup(uuidStr)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			BadRequest(w, r, "Proxy only forwards GET and HEAD requests; send %s directly to %s",
+				r.Method, target.backend.url)
+			return
+		}
+		if AuthEnabled() {
+			token := getAuthToken(r)
+			if token == nil {
+				Unauthorized(w, r, http.StatusUnauthorized, "Valid API token required for proxied repos")
+				return
+			}
+			if token.RoleFor(target.root) < ReadRole {
+				Unauthorized(w, r, http.StatusForbidden,
+					fmt.Sprintf("API token requires %s role for repo %s", ReadRole, target.root))
+				return
+			}
+		}
+		dvid.Debugf("Proxying %s to %s\n", r.URL.Path, target.backend.url)
+		target.backend.proxy.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func proxyInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if !proxies.isEnabled() {
+		BadRequest(w, r, "Proxy mode is not enabled on this server")
+		return
+	}
+	jsonBytes, err := proxies.MarshalJSON()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+}
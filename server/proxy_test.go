@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// resetProxy turns off proxy mode.
+func resetProxy() {
+	proxies.Lock()
+	proxies.enabled = false
+	proxies.token = ""
+	proxies.backends = nil
+	proxies.uuids = nil
+	proxies.Unlock()
+}
+
+func TestProxyAuth(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+	defer resetProxy()
+
+	const root, child = "aaaa0000000000000000000000000000", "aaaa1111111111111111111111111111"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == WebAPIPath+"repos/info" {
+			fmt.Fprintf(w, `{"%s": {"DAG": {"Nodes": {"%s": {}, "%s": {}}}}}`, root, root, child)
+			return
+		}
+		fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("Authorization"), r.Header.Get("Cookie"), r.URL.RawQuery)
+	}))
+	defer backend.Close()
+
+	if err := EnableProxy([]string{backend.URL}, "backend-token", time.Hour); err != nil {
+		t.Fatalf("Unable to enable proxy: %s\n", err.Error())
+	}
+	enableTestAuth(t, "test-admin")
+	reader, err := NewAuthToken("reader", false, map[dvid.UUID]Role{root: ReadRole})
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+	other, err := NewAuthToken("other", false, map[dvid.UUID]Role{"bbbb0000000000000000000000000000": AdminRole})
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+
+	// Roles are checked against the root of the backend repo.
+	nodeInfo := fmt.Sprintf("%snode/%s/grayscale/info?share=abc&x=1", WebAPIPath, child[:8])
+	checks := map[string]int{
+		"":           http.StatusUnauthorized,
+		"bad-token":  http.StatusUnauthorized,
+		other.Token:  http.StatusForbidden,
+		reader.Token: http.StatusOK,
+		"test-admin": http.StatusOK,
+	}
+	for token, expected := range checks {
+		if w := testRequest("GET", nodeInfo, token, nil); w.Code != expected {
+			t.Errorf("Proxied GET with token %q: expected status %d, got %d: %s\n", token, expected, w.Code, w.Body.String())
+		}
+	}
+
+	// Client credentials are replaced by the proxy's token.
+	req, _ := http.NewRequest("GET", nodeInfo+"&token="+reader.Token, nil)
+	req.Header.Set("Cookie", "session=abc")
+	w := httptest.NewRecorder()
+	ServeSingleHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Proxied GET with token query failed with status %d: %s\n", w.Code, w.Body.String())
+	}
+	if got := w.Body.String(); got != "Bearer backend-token||x=1" {
+		t.Errorf("Expected backend to only get proxy token, got %q\n", got)
+	}
+
+	if w := testRequest("POST", nodeInfo, "test-admin", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected proxied POST to be rejected, got status %d\n", w.Code)
+	}
+}
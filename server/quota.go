@@ -0,0 +1,371 @@
+/*
+	This file supports per-client quotas on HTTP requests.  Clients, identified by API
+	token or, without a token, by IP address, are assigned to named quota groups that
+	limit each client's requests per second and concurrent heavy operations, i.e., the
+	compute-intense operations that can also be limited server-wide via Throttle.  Clients
+	exceeding their quota get a 429 response with a Retry-After header.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/zenazn/goji/web"
+)
+
+// Clients without requests for this long are forgotten, resetting their quotas.
+const quotaClientIdle = 10 * time.Minute
+
+// QuotaGroup gives the limits for each client assigned to the group.  Zero limits are
+// unlimited.
+type QuotaGroup struct {
+	Name string
+
+	// RequestsPerSec is the sustained rate of requests, with up to Burst requests
+	// allowed at once.  Burst defaults to RequestsPerSec.
+	RequestsPerSec float64
+	Burst          int
+
+	// MaxHeavyOps is the maximum number of concurrent heavy operations.
+	MaxHeavyOps int
+
+	// Tokens are the names of API tokens assigned to the group.
+	Tokens []string
+
+	// IPs are IP addresses or CIDR blocks, e.g., "10.1.0.0/16", of clients without
+	// API tokens assigned to the group.
+	IPs []string
+
+	nets []*net.IPNet
+}
+
+// quotaClient tracks the quota usage of one client.
+type quotaClient struct {
+	group    *QuotaGroup
+	tokens   float64   // requests currently allowed
+	last     time.Time // when tokens were last refilled
+	seen     time.Time // last activity, which keeps the client from being forgotten
+	heavyOps int
+	requests uint64
+	rejected uint64
+}
+
+type quotaManager struct {
+	sync.Mutex
+	groups       []*QuotaGroup
+	defaultGroup *QuotaGroup
+	clients      map[string]*quotaClient
+	lastReap     time.Time
+}
+
+var quotas = quotaManager{clients: make(map[string]*quotaClient)}
+
+// EnableQuotas limits requests of clients in the given groups.  Clients not assigned to
+// any group are limited by the default group, if named, and otherwise unlimited.
+func EnableQuotas(groups []QuotaGroup, defaultName string) error {
+	byName := make(map[string]*QuotaGroup, len(groups))
+	ptrs := make([]*QuotaGroup, len(groups))
+	for i := range groups {
+		g := groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("Quota groups must be named")
+		}
+		if _, found := byName[g.Name]; found {
+			return fmt.Errorf("Quota group %q given more than once", g.Name)
+		}
+		if g.RequestsPerSec < 0 || g.Burst < 0 || g.MaxHeavyOps < 0 {
+			return fmt.Errorf("Quota group %q has negative limits", g.Name)
+		}
+		if g.Burst == 0 && g.RequestsPerSec > 0 {
+			g.Burst = int(math.Ceil(g.RequestsPerSec))
+		}
+		for _, ipStr := range g.IPs {
+			_, ipNet, err := net.ParseCIDR(ipStr)
+			if err != nil {
+				ip := net.ParseIP(ipStr)
+				if ip == nil {
+					return fmt.Errorf("Bad IP address %q in quota group %q", ipStr, g.Name)
+				}
+				bits := 8 * len(ip)
+				ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			}
+			g.nets = append(g.nets, ipNet)
+		}
+		byName[g.Name] = &g
+		ptrs[i] = &g
+	}
+	var defaultGroup *QuotaGroup
+	if defaultName != "" {
+		var found bool
+		if defaultGroup, found = byName[defaultName]; !found {
+			return fmt.Errorf("Default quota group %q isn't configured", defaultName)
+		}
+	}
+
+	quotas.Lock()
+	quotas.groups = ptrs
+	quotas.defaultGroup = defaultGroup
+	quotas.clients = make(map[string]*quotaClient)
+	quotas.Unlock()
+	dvid.Infof("Enabled %d quota groups.\n", len(groups))
+	return nil
+}
+
+// quotaClientID returns the client of a request, which is the name of its API token or
+// its IP address, and the IP address.
+func quotaClientID(r *http.Request) (id string, ip net.IP) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip = net.ParseIP(host)
+	if token := getAuthToken(r); token != nil {
+		return "token:" + token.Name, ip
+	}
+	return "ip:" + host, ip
+}
+
+// groupFor returns the quota group of a client or nil if it's unlimited.  Token
+// assignments take precedence over IP assignments.
+func (m *quotaManager) groupFor(r *http.Request, ip net.IP) *QuotaGroup {
+	if token := getAuthToken(r); token != nil {
+		for _, g := range m.groups {
+			for _, name := range g.Tokens {
+				if name == token.Name {
+					return g
+				}
+			}
+		}
+	}
+	if ip != nil {
+		for _, g := range m.groups {
+			for _, ipNet := range g.nets {
+				if ipNet.Contains(ip) {
+					return g
+				}
+			}
+		}
+	}
+	return m.defaultGroup
+}
+
+// client returns the usage of a request's client, or nil if the client is unlimited.
+// The manager must be locked.
+func (m *quotaManager) client(r *http.Request) *quotaClient {
+	if len(m.groups) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(m.lastReap) > quotaClientIdle {
+		for id, c := range m.clients {
+			if c.heavyOps == 0 && now.Sub(c.seen) > quotaClientIdle {
+				delete(m.clients, id)
+			}
+		}
+		m.lastReap = now
+	}
+	id, ip := quotaClientID(r)
+	c, found := m.clients[id]
+	if !found {
+		group := m.groupFor(r, ip)
+		if group == nil {
+			return nil
+		}
+		c = &quotaClient{group: group, tokens: float64(group.Burst), last: now, seen: now}
+		m.clients[id] = c
+	}
+	return c
+}
+
+// allowRequest returns zero if the request is within its client's rate or otherwise
+// the time until it would be.
+func (m *quotaManager) allowRequest(r *http.Request) time.Duration {
+	m.Lock()
+	defer m.Unlock()
+	c := m.client(r)
+	if c == nil {
+		return 0
+	}
+	now := time.Now()
+	rate := c.group.RequestsPerSec
+	if rate > 0 {
+		c.tokens += rate * now.Sub(c.last).Seconds()
+		if burst := float64(c.group.Burst); c.tokens > burst {
+			c.tokens = burst
+		}
+	}
+	c.last = now
+	c.seen = now
+	if rate > 0 && c.tokens < 1 {
+		c.rejected++
+		return time.Duration((1 - c.tokens) / rate * float64(time.Second))
+	}
+	if rate > 0 {
+		c.tokens--
+	}
+	c.requests++
+	return 0
+}
+
+// tooManyRequests writes a 429 response asking the client to retry after the wait.
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, message string) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	errorMsg := fmt.Sprintf("ERROR: %s (%s).", message, r.URL.Path)
+	dvid.Infof(errorMsg)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, errorMsg, http.StatusTooManyRequests)
+}
+
+// quotaHandler is middleware, used after authHandler, that rejects requests exceeding
+// their client's rate.
+func quotaHandler(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if wait := quotas.allowRequest(r); wait > 0 {
+			tooManyRequests(w, r, wait, "Request rate quota exceeded")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ThrottleOp reserves a heavy operation for a request.  The operation counts against
+// the request client's quota and, if the request has a "throttle=on" query string, the
+// server-wide Throttle.  If the operation can't be started, an error response is
+// written and ok is false.  Otherwise, release must be called when the operation is done.
+func ThrottleOp(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	quotas.Lock()
+	c := quotas.client(r)
+	if c != nil && c.group.MaxHeavyOps > 0 && c.heavyOps >= c.group.MaxHeavyOps {
+		c.rejected++
+		quotas.Unlock()
+		tooManyRequests(w, r, time.Second, fmt.Sprintf("Client already running maximum of %d heavy operations", c.group.MaxHeavyOps))
+		return nil, false
+	}
+	if c != nil {
+		c.heavyOps++
+	}
+	quotas.Unlock()
+
+	releaseQuota := func() {
+		if c != nil {
+			quotas.Lock()
+			c.heavyOps--
+			c.seen = time.Now()
+			quotas.Unlock()
+		}
+	}
+	if r.URL.Query().Get("throttle") != "on" {
+		return releaseQuota, true
+	}
+	select {
+	case <-Throttle:
+		return func() {
+			Throttle <- 1
+			releaseQuota()
+		}, true
+	default:
+		releaseQuota()
+		throttleMsg := fmt.Sprintf("Server already running maximum of %d throttled operations", MaxThrottledOps)
+		http.Error(w, throttleMsg, http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
+
+// QuotaUsage gives the current quota usage of a client.
+type QuotaUsage struct {
+	Client   string
+	Group    string
+	Requests uint64 // requests allowed since the client was last idle
+	Rejected uint64 // requests rejected since the client was last idle
+
+	// Available is the number of requests currently allowed before being rate limited,
+	// or -1 if unlimited.
+	Available int
+
+	HeavyOps int
+}
+
+type quotaUsageByClient []QuotaUsage
+
+func (s quotaUsageByClient) Len() int           { return len(s) }
+func (s quotaUsageByClient) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s quotaUsageByClient) Less(i, j int) bool { return s[i].Client < s[j].Client }
+
+// usage returns the usage of all tracked clients, or only the given client if not empty.
+func (m *quotaManager) usage(only string) []QuotaUsage {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	usages := []QuotaUsage{}
+	for id, c := range m.clients {
+		if only != "" && id != only {
+			continue
+		}
+		available := -1
+		if rate := c.group.RequestsPerSec; rate > 0 {
+			tokens := math.Min(c.tokens+rate*now.Sub(c.last).Seconds(), float64(c.group.Burst))
+			available = int(tokens)
+		}
+		usages = append(usages, QuotaUsage{
+			Client:    id,
+			Group:     c.group.Name,
+			Requests:  c.requests,
+			Rejected:  c.rejected,
+			Available: available,
+			HeavyOps:  c.heavyOps,
+		})
+	}
+	sort.Sort(quotaUsageByClient(usages))
+	return usages
+}
+
+// quotaGetHandler returns the configured quota groups and the usage of clients.  Without
+// authentication or with an admin token, all clients are returned; otherwise only the
+// requesting client is returned.
+func quotaGetHandler(w http.ResponseWriter, r *http.Request) {
+	var only string
+	if AuthEnabled() {
+		if token := getAuthToken(r); token == nil || !token.Admin {
+			only, _ = quotaClientID(r)
+		}
+	}
+	quotas.Lock()
+	groups := make([]QuotaGroup, len(quotas.groups))
+	for i, g := range quotas.groups {
+		groups[i] = *g
+		if only != "" {
+			groups[i].Tokens, groups[i].IPs = nil, nil
+		}
+	}
+	var defaultName string
+	if quotas.defaultGroup != nil {
+		defaultName = quotas.defaultGroup.Name
+	}
+	quotas.Unlock()
+
+	jsonBytes, err := json.Marshal(struct {
+		Groups       []QuotaGroup
+		DefaultGroup string
+		Clients      []QuotaUsage
+	}{groups, defaultName, quotas.usage(only)})
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// resetQuotas turns off quotas and forgets all clients.
+func resetQuotas() {
+	quotas.Lock()
+	quotas.groups = nil
+	quotas.defaultGroup = nil
+	quotas.clients = make(map[string]*quotaClient)
+	quotas.Unlock()
+}
+
+// quotaRequest returns a request from the given address without an API token.
+func quotaRequest(remoteAddr string) *http.Request {
+	r, err := http.NewRequest("GET", WebAPIPath+"server/info", nil)
+	if err != nil {
+		panic(err)
+	}
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestQuotaRequestRate(t *testing.T) {
+	defer resetQuotas()
+	groups := []QuotaGroup{
+		{Name: "slow", RequestsPerSec: 1, Burst: 2, IPs: []string{"192.0.2.0/24"}},
+		{Name: "fast", RequestsPerSec: 1000, IPs: []string{"198.51.100.7"}},
+	}
+	if err := EnableQuotas(groups, ""); err != nil {
+		t.Fatalf("Unable to enable quotas: %s\n", err.Error())
+	}
+
+	// A burst is allowed, then requests are limited to the rate.
+	slow := quotaRequest("192.0.2.10:5000")
+	for i := 0; i < 2; i++ {
+		if wait := quotas.allowRequest(slow); wait != 0 {
+			t.Fatalf("Expected request %d of burst to be allowed, got wait %s\n", i, wait)
+		}
+	}
+	wait := quotas.allowRequest(slow)
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("Expected request after burst to wait up to 1 sec, got %s\n", wait)
+	}
+
+	// Clients are limited separately, and unassigned clients are unlimited.
+	if wait := quotas.allowRequest(quotaRequest("192.0.2.11:5000")); wait != 0 {
+		t.Errorf("Expected other client of group to be allowed, got wait %s\n", wait)
+	}
+	for i := 0; i < 100; i++ {
+		if wait := quotas.allowRequest(quotaRequest("203.0.113.5:5000")); wait != 0 {
+			t.Fatalf("Expected unassigned client to be unlimited, got wait %s\n", wait)
+		}
+	}
+
+	// Rejected requests get a 429 with Retry-After.
+	handler := quotaHandler(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, slow)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After of 1 sec, got %d %v\n", w.Code, w.Header())
+	}
+
+	usage := quotas.usage("ip:192.0.2.10")
+	if len(usage) != 1 || usage[0].Requests != 2 || usage[0].Rejected != 2 || usage[0].Group != "slow" {
+		t.Errorf("Bad quota usage: %+v\n", usage)
+	}
+
+	if err := EnableQuotas([]QuotaGroup{{Name: "x"}}, "missing"); err == nil {
+		t.Errorf("Expected error for unknown default group\n")
+	}
+}
+
+func TestQuotaHeavyOps(t *testing.T) {
+	defer resetQuotas()
+	groups := []QuotaGroup{{Name: "default", RequestsPerSec: 1, Burst: 1, MaxHeavyOps: 1}}
+	if err := EnableQuotas(groups, "default"); err != nil {
+		t.Fatalf("Unable to enable quotas: %s\n", err.Error())
+	}
+	r := quotaRequest("192.0.2.10:5000")
+
+	release, ok := ThrottleOp(httptest.NewRecorder(), r)
+	if !ok {
+		t.Fatalf("Expected first heavy operation to start\n")
+	}
+	w := httptest.NewRecorder()
+	if _, ok := ThrottleOp(w, r); ok || w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected second concurrent heavy operation to be rejected, got status %d\n", w.Code)
+	}
+
+	// Finishing a heavy operation doesn't reset the refill of the request rate.
+	if wait := quotas.allowRequest(r); wait != 0 {
+		t.Fatalf("Expected first request to be allowed, got wait %s\n", wait)
+	}
+	quotas.Lock()
+	quotas.clients["ip:192.0.2.10"].last = time.Now().Add(-time.Second)
+	quotas.Unlock()
+	release()
+	if wait := quotas.allowRequest(r); wait != 0 {
+		t.Errorf("Expected request to be allowed after refill despite heavy op release, got wait %s\n", wait)
+	}
+
+	release, ok = ThrottleOp(httptest.NewRecorder(), r)
+	if !ok {
+		t.Fatalf("Expected heavy operation to start after release\n")
+	}
+	release()
+}
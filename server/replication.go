@@ -0,0 +1,235 @@
+/*
+	This file supports a read-only replica mode where this server serves reads from a
+	datastore that follows a primary server.  The primary, which must have a mutation log,
+	streams its mutations via /api/server/replication/stream, and the replica applies them
+	to its datastore and reloads repo metadata when it changes.  The replica rejects all
+	requests that would modify data, so heavy read traffic like tile serving can be balanced
+	across replicas while the primary handles writes.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	// Minimum and maximum waits before a replica reconnects to its primary.
+	replicationMinRetry = 1 * time.Second
+	replicationMaxRetry = 60 * time.Second
+)
+
+type replicaState struct {
+	sync.RWMutex
+	primary   *url.URL
+	token     string
+	connected bool
+	err       error
+}
+
+var replica replicaState
+
+// replicationClient has no timeout since replication streams are long-lived.
+var replicationClient = &http.Client{}
+
+// EnableReplica makes this server a read-only replica of the primary DVID server at the
+// given URL, e.g., "http://emdata1:8000".  The token, if not empty, is sent to the primary
+// and must be an admin token if the primary requires authentication.
+func EnableReplica(primaryURL, token string) error {
+	u, err := url.Parse(primaryURL)
+	if err != nil {
+		return fmt.Errorf("Bad primary URL %q: %s", primaryURL, err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("Primary URL %q must include scheme and host", primaryURL)
+	}
+	if err := storage.EnableReadOnlyReplica(); err != nil {
+		return err
+	}
+	SetReadOnly(true)
+
+	replica.Lock()
+	replica.primary = u
+	replica.token = token
+	replica.Unlock()
+
+	go replica.follow()
+	dvid.Infof("Replicating mutations of primary %s.\n", u)
+	return nil
+}
+
+func (s *replicaState) isEnabled() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.primary != nil
+}
+
+// follow applies the primary's replication stream, reconnecting with backoff whenever
+// the stream fails.
+func (s *replicaState) follow() {
+	wait := replicationMinRetry
+	for {
+		start := time.Now()
+		err := s.replicate()
+		s.Lock()
+		s.connected = false
+		s.err = err
+		s.Unlock()
+		dvid.Errorf("Replication from primary %s stopped: %s\n", s.primary, err.Error())
+
+		if time.Since(start) > replicationMaxRetry {
+			wait = replicationMinRetry
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > replicationMaxRetry {
+			wait = replicationMaxRetry
+		}
+	}
+}
+
+// replicate requests the primary's mutations after the last one applied and applies
+// them until the stream fails.
+func (s *replicaState) replicate() error {
+	seq, err := storage.ReplicatedSeq()
+	if err != nil {
+		return err
+	}
+	s.RLock()
+	primary := strings.TrimSuffix(s.primary.String(), "/")
+	streamURL := fmt.Sprintf("%s/api/server/replication/stream?after=%d", primary, seq)
+	token := s.token
+	s.RUnlock()
+
+	req, err := http.NewRequest("GET", streamURL, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := replicationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned status %d", resp.StatusCode)
+	}
+
+	s.Lock()
+	s.connected = true
+	s.err = nil
+	s.Unlock()
+	dvid.Infof("Connected to primary %s, applying mutations after %d\n", s.primary, seq)
+	return storage.ApplyReplicationStream(resp.Body, reloadReplicaMetadata)
+}
+
+// reloadReplicaMetadata makes repo changes replicated from the primary visible.
+func reloadReplicaMetadata() {
+	if err := datastore.ReloadMetadata(); err != nil {
+		dvid.Errorf("Unable to reload replicated metadata: %s\n", err.Error())
+	}
+}
+
+// MarshalJSON returns the primary, connection status, and progress of a replica.
+func (s *replicaState) MarshalJSON() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	status := storage.ReplicationStatus()
+	var errMsg string
+	if s.err != nil {
+		errMsg = s.err.Error()
+	}
+	return json.Marshal(struct {
+		Primary   string
+		Connected bool
+		Error     string `json:",omitempty"`
+		Seq       uint64
+		Applied   uint64
+		Last      time.Time
+	}{s.primary.String(), s.connected, errMsg, status.Seq, status.Applied, status.Last})
+}
+
+// flushWriter sends each write to the client immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+func replicationInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var jsonBytes []byte
+	var err error
+	if replica.isEnabled() {
+		jsonBytes, err = replica.MarshalJSON()
+	} else {
+		seq, seqErr := storage.MutationLogSeq()
+		if seqErr != nil {
+			BadRequest(w, r, "Server is neither a replica nor a primary with a mutation log")
+			return
+		}
+		jsonBytes, err = json.Marshal(struct{ Seq uint64 }{seq})
+	}
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func replicationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() {
+		if token := getAuthToken(r); token == nil || !token.Admin {
+			Unauthorized(w, r, http.StatusForbidden, "Admin API token required")
+			return
+		}
+	}
+	if _, err := storage.MutationLogSeq(); err != nil {
+		BadRequest(w, r, "Replication requires a mutation log: %s", err.Error())
+		return
+	}
+	var after uint64
+	if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+		var err error
+		if after, err = strconv.ParseUint(afterStr, 10, 64); err != nil {
+			BadRequest(w, r, "Bad sequence number %q: %s", afterStr, err.Error())
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		BadRequest(w, r, "Replication stream requires a flushable connection")
+		return
+	}
+	done := make(chan struct{})
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed := notifier.CloseNotify()
+		go func() {
+			<-closed
+			close(done)
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	dvid.Infof("Streaming mutations after %d to replica %s\n", after, r.RemoteAddr)
+	if err := storage.StreamMutations(flushWriter{w, flusher}, after, done); err != nil {
+		dvid.Errorf("Replication stream to %s stopped: %s\n", r.RemoteAddr, err.Error())
+	}
+}
@@ -7,15 +7,20 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/rpc"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/message"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 const RPCHelpMessage = `Commands executed on the server (rpc address = %s):
@@ -23,11 +28,98 @@ const RPCHelpMessage = `Commands executed on the server (rpc address = %s):
 	help
 	shutdown
 
+	gc [dryrun] [batch=<keys>] [delay=<ms>]
+
+		Deletes stored data of deleted data instances and of versions no longer in any
+		repo.  Orphaned keys are deleted in batches of the given number of keys (default
+		%d) with a pause of the given milliseconds (default %d) between batches.  Stale
+		derived data, e.g., surfaces of labels that no longer exist or tiles whose source
+		was deleted, is also deleted.  With "dryrun", only reports the orphaned and stale
+		data that could be reclaimed.
+
+	usage [scan]
+
+		Reports the keys and bytes stored by each data instance and version as of the
+		last scan, plus writes since then if "usage_tracking" is set in the server storage
+		configuration.  With "scan", all stored data is scanned first and the report is
+		cached for the /api/server/usage HTTP endpoint.
+
+	compact [<UUID> <data name>]
+
+		Compacts the storage engines to reclaim the space of deleted or overwritten data,
+		e.g., after deleting data instances and running gc.  If a data instance is given,
+		only its keys are compacted.  Compacting a large datastore can take a long time, so
+		consider adding "async=true".
+
+	compact stats
+
+		Reports the storage engine statistics on the sorted tables in each level.
+
+	compact schedule [<HH:MM> [every=<interval>] | off]
+
+		Compacts all storage each day starting at the given local time and then at the
+		given interval, e.g., "6h", until the next day's start (default one compaction a
+		day).  With "off", scheduled compactions are stopped.  Without arguments, the
+		current schedule is returned.
+
+	verify <UUID> <data name> [repair]
+
+		Scans all stored values of a data instance created with checksums and reports
+		values whose checksums don't match.  With "repair", corrupted values are replaced
+		by good values from the replica datastore, which requires the "repair" checksum
+		policy in the server configuration.
+
+	dictionary <UUID> <data name> <key type> [samples=<n>] [size=<bytes>]
+
+		Trains a zstd dictionary on up to the given number of sampled values (default %d)
+		of a data instance's keyspace, i.e., keys whose type-specific index begins with the
+		given key type byte, and compresses values written afterwards with it.  Useful for
+		keyspaces of small, similar values like label RLEs.  The dictionary size defaults
+		to %d bytes.  Requires "dictionaries" in the server storage configuration.
+
+	replay <log file> [<RFC3339 time>]
+
+		Applies mutations from a mutation log file to the datastore, up to and including
+		the given time if any, for point-in-time recovery.  The datastore should be a
+		restored snapshot taken after the log was started.  Restart the server after
+		replay so repo metadata is reloaded.
+
+	rebalance
+
+		In cluster mode, moves data held by this server but owned by other members, e.g.,
+		after members join, to its owners.  Run on each member after membership changes.
+
+	settings [handlers=<n>] [throttle=<n>] [blockcache=<MB>]
+
+		Changes the maximum number of chunk handlers, the maximum number of throttled
+		operations, or the size of the block cache without restarting the server, and
+		returns the resulting settings.  Operations already running are not interrupted,
+		so lowered limits take effect as they finish.
+
 	repos new  <alias> <description>
 
 	repo <UUID> new <datatype name> <data name> <datatype-specific config>...
 
-	repo <UUID> push <remote DVID address> <settings...>
+	repo <UUID> alias [<alias>]
+
+		Sets the alias of the repo, which can be used in place of its root UUID in
+		commands and HTTP requests, or removes it if no alias is given.  Aliases are
+		unique and can only have letters, digits, '-', '_', and '.'.
+
+	repo <UUID> commit <message>
+
+		Commits (locks) the node with a message so its versioned data can't be modified.
+
+	repo <UUID> branch <branch name>
+
+		Creates a child of the locked node that starts a new named branch.
+
+	repo <UUID> merge <UUID2> [<UUID3>...] [note=<note>]
+
+		Creates a node whose parents are the given locked nodes, with the first
+		node's data taking precedence.  Fails if data have conflicting changes.
+
+	repo <UUID> push <remote DVID address> [--estimate] <settings...>
 
 		where <settings> are optional "key=value" strings that provide:
 
@@ -35,14 +127,32 @@ const RPCHelpMessage = `Commands executed on the server (rpc address = %s):
 
 		data=<data1>[,<data2>[,<data3>...]]
 
+		With "--estimate", nothing is transferred and the number of key-value pairs and
+		bytes that would be pushed are reported for each data instance.  The remote
+		address can then be omitted.
+
+	repo <UUID> manifest [verify=<manifest file>]
+
+		Returns a JSON manifest of a locked node giving each data instance's datatype
+		version and the number, size, and checksum of its key-value pairs.  With
+		"verify", the node is instead checked against a manifest file on the server,
+		e.g., one published with the node, to verify a mirrored copy.
+
 	node <UUID> <data name> <type-specific commands>
 
+	Any command other than help and shutdown can be run as a background job by adding
+	the setting "async=true".  The job ID is returned immediately, and the job's progress,
+	log, and result are available via the /api/server/jobs HTTP endpoints.
+
 For further information, use a web browser to visit the server for this
 datastore:  
 
 	http://%s
 `
 
+// Maximum number of corrupted keys listed by the verify command.
+const maxReportedFaults = 100
+
 // Client provides RPC access to a DVID server.
 type Client struct {
 	rpcAddress string
@@ -86,11 +196,25 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 	if cmd.Name() == "" {
 		return fmt.Errorf("Server error: got empty command!")
 	}
+	if async, _ := cmd.Setting("async"); async == "true" && cmd.Name() != "help" && cmd.Name() != "shutdown" {
+		job, err := startRPCJob(c, cmd)
+		if err != nil {
+			return err
+		}
+		reply.Text = fmt.Sprintf("Started job %s.  Check its progress at http://%s/api/server/jobs/%s\n",
+			job.ID, config.HTTPAddress(), job.ID)
+		return nil
+	}
+	return c.do(cmd, reply)
+}
 
+func (c *RPCConnection) do(cmd datastore.Request, reply *datastore.Response) error {
 	switch cmd.Name() {
 
 	case "help":
-		reply.Text = fmt.Sprintf(RPCHelpMessage, config.RPCAddress(), config.HTTPAddress())
+		reply.Text = fmt.Sprintf(RPCHelpMessage, config.RPCAddress(), storage.DefaultGCBatchSize,
+			storage.DefaultGCBatchDelay/time.Millisecond, storage.DefaultDictSamples,
+			storage.DefaultDictSize, config.HTTPAddress())
 
 	case "shutdown":
 		Shutdown()
@@ -103,6 +227,255 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 			os.Exit(0)
 		}()
 
+	case "gc":
+		opts := storage.GCOptions{
+			BatchSize:  storage.DefaultGCBatchSize,
+			BatchDelay: storage.DefaultGCBatchDelay,
+		}
+		var mode string
+		cmd.CommandArgs(1, &mode)
+		switch mode {
+		case "":
+		case "dryrun":
+			opts.DryRun = true
+		default:
+			return fmt.Errorf("Unknown gc option: %q", mode)
+		}
+		if s, found := cmd.Setting("batch"); found {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return fmt.Errorf("Bad gc batch size: %q", s)
+			}
+			opts.BatchSize = n
+		}
+		if s, found := cmd.Setting("delay"); found {
+			ms, err := strconv.Atoi(s)
+			if err != nil || ms < 0 {
+				return fmt.Errorf("Bad gc delay: %q", s)
+			}
+			opts.BatchDelay = time.Duration(ms) * time.Millisecond
+		}
+		stats, err := datastore.CollectGarbage(opts)
+		if err != nil {
+			return err
+		}
+		reply.Text = stats.String() + "\n"
+		for instanceID, n := range stats.Instances {
+			reply.Text += fmt.Sprintf("  instance %d: %d orphaned keys\n", instanceID, n)
+		}
+		for versionID, n := range stats.Versions {
+			reply.Text += fmt.Sprintf("  version %d: %d orphaned keys\n", versionID, n)
+		}
+		for instanceID, n := range stats.Stale {
+			reply.Text += fmt.Sprintf("  instance %d: %d stale keys\n", instanceID, n)
+		}
+
+	case "usage":
+		var mode string
+		cmd.CommandArgs(1, &mode)
+		var scan bool
+		switch mode {
+		case "":
+		case "scan":
+			scan = true
+		default:
+			return fmt.Errorf("Unknown usage option: %q", mode)
+		}
+		report, err := datastore.StorageUsage(scan)
+		if err != nil {
+			return err
+		}
+		if report.Scanned.IsZero() {
+			reply.Text = "Storage has not been scanned.  Use \"usage scan\" to scan it.\n"
+		} else {
+			reply.Text = fmt.Sprintf("Storage usage as of scan at %s:\n", report.Scanned.Format(time.RFC3339))
+		}
+		for _, data := range report.Data {
+			name := "(deleted)"
+			if data.Name != "" {
+				name = fmt.Sprintf("%s/%s", data.Repo, data.Name)
+			}
+			reply.Text += fmt.Sprintf("  instance %d %s: %s\n", data.InstanceID, name, data.Stored)
+			if report.Tracking && data.Writes != (storage.WriteCount{}) {
+				reply.Text += fmt.Sprintf("    since scan: %s\n", data.Writes)
+			}
+		}
+
+	case "compact":
+		var arg1, arg2 string
+		cmd.CommandArgs(1, &arg1, &arg2)
+		switch arg1 {
+		case "":
+			if err := storage.CompactStore(); err != nil {
+				return err
+			}
+			reply.Text = "Compacted all storage.\n"
+		case "stats":
+			stats, err := storage.CompactionStatistics()
+			if err != nil {
+				return err
+			}
+			for _, engine := range stats {
+				reply.Text += fmt.Sprintf("%s:\n%s\n", engine.Engine, engine.Levels)
+			}
+		case "schedule":
+			switch arg2 {
+			case "":
+				if schedule := storage.ScheduledCompaction(); schedule != nil {
+					reply.Text = fmt.Sprintf("Compactions scheduled %s.\n", schedule)
+				} else {
+					reply.Text = "No compactions scheduled.\n"
+				}
+			case "off":
+				if err := storage.ScheduleCompaction(nil); err != nil {
+					return err
+				}
+				reply.Text = "Stopped scheduled compactions.\n"
+			default:
+				interval, _ := cmd.Setting("every")
+				schedule, err := storage.ParseCompactionSchedule(arg2, interval)
+				if err != nil {
+					return err
+				}
+				if err := storage.ScheduleCompaction(&schedule); err != nil {
+					return err
+				}
+				reply.Text = fmt.Sprintf("Compactions scheduled %s, next at %s.\n", schedule,
+					schedule.Next(time.Now()).Format(time.RFC3339))
+			}
+		default:
+			uuid, _, err := datastore.MatchingUUID(arg1)
+			if err != nil {
+				return err
+			}
+			repo, err := datastore.RepoFromUUID(uuid)
+			if err != nil {
+				return err
+			}
+			dataservice, err := repo.GetDataByName(dvid.DataString(arg2))
+			if err != nil {
+				return err
+			}
+			if err := storage.CompactInstance(dataservice.InstanceID()); err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Compacted data %q.\n", arg2)
+		}
+
+	case "verify":
+		var uuidStr, dataname, mode string
+		cmd.CommandArgs(1, &uuidStr, &dataname, &mode)
+		var repair bool
+		switch mode {
+		case "":
+		case "repair":
+			repair = true
+		default:
+			return fmt.Errorf("Unknown verify option: %q", mode)
+		}
+		uuid, _, err := datastore.MatchingUUID(uuidStr)
+		if err != nil {
+			return err
+		}
+		repo, err := datastore.RepoFromUUID(uuid)
+		if err != nil {
+			return err
+		}
+		dataservice, err := repo.GetDataByName(dvid.DataString(dataname))
+		if err != nil {
+			return err
+		}
+		report, err := storage.VerifyInstance(dataservice.InstanceID(), repair)
+		if err != nil {
+			return err
+		}
+		reply.Text = fmt.Sprintf("Data %q: %s\n", dataname, report)
+		for i, fault := range report.Faults {
+			if i == maxReportedFaults {
+				reply.Text += fmt.Sprintf("  ... %d more\n", len(report.Faults)-i)
+				break
+			}
+			reply.Text += fmt.Sprintf("  key %x: %s\n", fault.Key, fault.Error)
+		}
+
+	case "dictionary":
+		var uuidStr, dataname, keyTypeStr string
+		cmd.CommandArgs(1, &uuidStr, &dataname, &keyTypeStr)
+		keyType, err := strconv.ParseUint(keyTypeStr, 10, 8)
+		if err != nil {
+			return fmt.Errorf("Bad key type %q: must be a byte value", keyTypeStr)
+		}
+		var samples, size int
+		if s, found := cmd.Setting("samples"); found {
+			if samples, err = strconv.Atoi(s); err != nil || samples < 1 {
+				return fmt.Errorf("Bad number of dictionary samples: %q", s)
+			}
+		}
+		if s, found := cmd.Setting("size"); found {
+			if size, err = strconv.Atoi(s); err != nil || size < 1 {
+				return fmt.Errorf("Bad dictionary size: %q", s)
+			}
+		}
+		uuid, _, err := datastore.MatchingUUID(uuidStr)
+		if err != nil {
+			return err
+		}
+		repo, err := datastore.RepoFromUUID(uuid)
+		if err != nil {
+			return err
+		}
+		dataservice, err := repo.GetDataByName(dvid.DataString(dataname))
+		if err != nil {
+			return err
+		}
+		ks := storage.Keyspace{Instance: dataservice.InstanceID(), KeyType: byte(keyType)}
+		report, err := storage.TrainDictionary(ks, samples, size)
+		if err != nil {
+			return err
+		}
+		reply.Text = fmt.Sprintf("Data %q: %s\n", dataname, report)
+
+	case "replay":
+		var path, timeStr string
+		cmd.CommandArgs(1, &path, &timeStr)
+		if path == "" {
+			return fmt.Errorf("replay requires a mutation log file")
+		}
+		var until time.Time
+		if timeStr != "" {
+			var err error
+			if until, err = time.Parse(time.RFC3339Nano, timeStr); err != nil {
+				return fmt.Errorf("Bad recovery time %q: %s", timeStr, err.Error())
+			}
+		}
+		stats, err := storage.ReplayMutationLog(path, until)
+		if err != nil {
+			return err
+		}
+		dvid.Infof("Replayed mutation log %s: %s\n", path, stats)
+		reply.Text = fmt.Sprintf("Replayed %s: %s\nRestart the server to reload repo metadata.\n", path, stats)
+
+	case "rebalance":
+		moved, err := storage.RebalanceCluster()
+		if err != nil {
+			return err
+		}
+		dvid.Infof("Moved %d key-value pairs to other cluster members.\n", moved)
+		reply.Text = fmt.Sprintf("Moved %d key-value pairs to other cluster members.\n", moved)
+
+	case "settings":
+		req, err := settingsFromRequest(cmd)
+		if err != nil {
+			return err
+		}
+		settings, err := ChangeSettings(req)
+		if err != nil {
+			return err
+		}
+		dvid.Infof("Settings changed by RPC: %+v\n", settings)
+		reply.Text = fmt.Sprintf("Chunk handlers: %d\nThrottled operations: %d\nBlock cache: %d MB\n",
+			settings.ChunkHandlers, settings.ThrottledOps, settings.BlockCacheMB)
+
 	case "types":
 		if len(cmd.Command) == 1 {
 			text := "\nData Types within this DVID Server\n"
@@ -180,14 +553,110 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 			}
 			reply.Text = fmt.Sprintf("Data %q [%s] added to node %s\n", dataname, typename, uuid)
 			repo.AddToLog(cmd.String())
+		case "alias":
+			var alias string
+			cmd.CommandArgs(3, &alias)
+			prev := repo.GetAlias()
+			if err := repo.SetAlias(alias); err != nil {
+				return err
+			}
+			if alias == "" {
+				reply.Text = fmt.Sprintf("Removed alias %q of repo with root %s\n", prev, repo.RootUUID())
+			} else {
+				reply.Text = fmt.Sprintf("Repo with root %s now has alias %q\n", repo.RootUUID(), alias)
+			}
+		case "commit":
+			message := strings.Join(cmd.CommandArgs(3), " ")
+			if message == "" {
+				return fmt.Errorf("Commit command requires a message")
+			}
+			if err := repo.CommitNode(uuid, message, "rpc"); err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Committed node %s: %s\n", uuid, message)
+		case "branch":
+			var branch string
+			cmd.CommandArgs(3, &branch)
+			if branch == "" {
+				return fmt.Errorf("Branch command requires a branch name")
+			}
+			child, err := repo.NewBranchVersion(uuid, branch)
+			if err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Branch %q started with node %s off parent %s\n", branch, child, uuid)
+		case "merge":
+			parents := []dvid.UUID{uuid}
+			for _, arg := range cmd.CommandArgs(3) {
+				parent, _, err := datastore.MatchingUUID(arg)
+				if err != nil {
+					return err
+				}
+				parents = append(parents, parent)
+			}
+			note, _ := cmd.Setting("note")
+			child, err := repo.Merge(parents, note)
+			if conflictErr, ok := err.(*datastore.MergeConflictError); ok {
+				msg := conflictErr.Error()
+				for name, conflicts := range conflictErr.Conflicts {
+					msg += fmt.Sprintf("\n  %s: %s", name, strings.Join(conflicts, ", "))
+				}
+				return fmt.Errorf(msg)
+			}
+			if err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Merged nodes %v into node %s\n", parents, child)
 		case "push":
 			var target string
-			cmd.CommandArgs(3, &target)
+			var estimate bool
+			for _, arg := range cmd.Command[3:] {
+				switch {
+				case arg == "--estimate":
+					estimate = true
+				case target == "" && !strings.Contains(arg, "="):
+					target = arg
+				}
+			}
 			config := cmd.Settings()
+			if estimate {
+				est, err := datastore.EstimatePush(repo, config)
+				if err != nil {
+					return err
+				}
+				reply.Text = fmt.Sprintf("Push of repo %q would transfer:\n%s", repo.RootUUID(), est)
+				return nil
+			}
 			if err = datastore.Push(repo, target, config); err != nil {
 				return err
 			}
 			reply.Text = fmt.Sprintf("Repo %q pushed to %q\n", repo.RootUUID(), target)
+		case "manifest":
+			manifest, err := datastore.GenerateManifest(repo, uuid, cmd)
+			if err != nil {
+				return err
+			}
+			filename, found := cmd.Setting("verify")
+			if !found {
+				jsonBytes, err := json.MarshalIndent(manifest, "", "  ")
+				if err != nil {
+					return err
+				}
+				reply.Text = string(jsonBytes) + "\n"
+				return nil
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			var expected datastore.Manifest
+			if err := json.Unmarshal(data, &expected); err != nil {
+				return fmt.Errorf("Unable to read manifest %q: %s", filename, err.Error())
+			}
+			if diffs := manifest.Verify(&expected); len(diffs) != 0 {
+				return fmt.Errorf("Node %s doesn't match manifest %q:\n  %s", uuid, filename, strings.Join(diffs, "\n  "))
+			}
+			reply.Text = fmt.Sprintf("Node %s matches manifest %q\n", uuid, filename)
 		default:
 			return fmt.Errorf("Unknown command: %q", cmd)
 		}
@@ -218,7 +687,16 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 			reply.Text = dataservice.Help()
 			return nil
 		}
-		return dataservice.DoRPC(cmd, reply)
+		if err := dataservice.DoRPC(cmd, reply); err != nil {
+			return err
+		}
+		if subcommand == "load" {
+			entry := datastore.Provenance{User: "rpc", Operation: "load", Detail: cmd.String()}
+			if err := repo.AddNodeProvenance(uuid, entry); err != nil {
+				dvid.Errorf("Unable to add load to provenance of node %s: %s\n", uuid, err.Error())
+			}
+		}
+		return nil
 
 	default:
 		return fmt.Errorf("Unknown command: '%s'", cmd)
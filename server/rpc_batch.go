@@ -0,0 +1,322 @@
+/*
+	This file implements batch sessions over the RPC connection for programmatic
+	ingestion.  A client begins a session for a data instance at an unlocked node, sends
+	chunks of key-value or block operations, and ends the session to get a summary of
+	the chunks applied and any that failed.  Chunks are queued and applied in order by a
+	worker for each session, so a client can send the next chunk while earlier ones are
+	being committed to storage.
+*/
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// Number of chunks of a session queued before sending a chunk blocks.
+	batchQueueSize = 4
+
+	// Sessions without requests for this long are ended.
+	batchSessionIdle = 10 * time.Minute
+)
+
+// BatchStart begins a batch session for a data instance.
+type BatchStart struct {
+	UUID     dvid.UUID
+	DataName dvid.DataString
+}
+
+// BatchChunk is a chunk of operations sent in a batch session.  Chunks are numbered
+// from 0 in the order they should be applied.
+type BatchChunk struct {
+	Session string
+	Seq     int
+	Ops     []datastore.BatchOp
+}
+
+// BatchResult gives the outcome of applying a chunk.
+type BatchResult struct {
+	Seq   int
+	Ops   int
+	Bytes int
+	Error string
+}
+
+// BatchSummary gives the outcome of a batch session.
+type BatchSummary struct {
+	Chunks int
+	Ops    int
+	Bytes  int64
+	Failed []BatchResult
+}
+
+func (s BatchSummary) String() string {
+	return fmt.Sprintf("%d chunks with %d operations (%d bytes) applied, %d chunks failed",
+		s.Chunks-len(s.Failed), s.Ops, s.Bytes, len(s.Failed))
+}
+
+// batchSession is locked while a chunk is queued, so chunks are queued in order and the
+// queue isn't closed during a send.
+type batchSession struct {
+	sync.Mutex
+	cond     *sync.Cond
+	name     dvid.DataString
+	repo     datastore.Repo
+	ingester datastore.BatchIngester
+	ctx      *datastore.VersionedContext
+	next     int
+	queue    chan BatchChunk
+	done     chan struct{}
+	lastUsed time.Time
+	ended    bool
+
+	summaryMu sync.Mutex
+	summary   BatchSummary
+}
+
+var batchSessions = struct {
+	sync.Mutex
+	m map[string]*batchSession
+}{m: make(map[string]*batchSession)}
+
+// apply applies queued chunks in order until the queue is closed.
+func (s *batchSession) apply() {
+	for chunk := range s.queue {
+		result := BatchResult{Seq: chunk.Seq, Ops: len(chunk.Ops)}
+		for _, op := range chunk.Ops {
+			result.Bytes += len(op.Value)
+		}
+		if err := ingestBatch(s.repo, s.ingester, s.ctx, chunk.Ops); err != nil {
+			result.Error = err.Error()
+			dvid.Errorf("Batch chunk %d for data %q failed: %s\n", chunk.Seq, s.name, err.Error())
+		}
+		s.summaryMu.Lock()
+		s.summary.Chunks++
+		if result.Error == "" {
+			s.summary.Ops += result.Ops
+			s.summary.Bytes += int64(result.Bytes)
+		} else {
+			s.summary.Failed = append(s.summary.Failed, result)
+		}
+		s.summaryMu.Unlock()
+	}
+	close(s.done)
+}
+
+// end stops accepting chunks and waits for queued chunks to be applied.
+func (s *batchSession) end() BatchSummary {
+	s.Lock()
+	if !s.ended {
+		s.ended = true
+		close(s.queue)
+	}
+	s.cond.Broadcast()
+	s.Unlock()
+	<-s.done
+	s.summaryMu.Lock()
+	defer s.summaryMu.Unlock()
+	return s.summary
+}
+
+// reapBatchSessions ends sessions that have been idle too long.
+func reapBatchSessions() {
+	batchSessions.Lock()
+	var idle []*batchSession
+	for id, s := range batchSessions.m {
+		s.Lock()
+		if time.Since(s.lastUsed) > batchSessionIdle {
+			idle = append(idle, s)
+			delete(batchSessions.m, id)
+		}
+		s.Unlock()
+	}
+	batchSessions.Unlock()
+	for _, s := range idle {
+		summary := s.end()
+		dvid.Errorf("Ended idle batch session for data %q: %s\n", s.name, summary)
+	}
+}
+
+func getBatchSession(id string) (*batchSession, error) {
+	batchSessions.Lock()
+	defer batchSessions.Unlock()
+	s, found := batchSessions.m[id]
+	if !found {
+		return nil, fmt.Errorf("No batch session %q", id)
+	}
+	return s, nil
+}
+
+// BeginBatch starts a batch session for a data instance at an unlocked node and returns
+// its ID.
+func (c *RPCConnection) BeginBatch(start BatchStart, session *string) error {
+	if readonly {
+		return fmt.Errorf("Batch sessions aren't allowed on a read-only server")
+	}
+	reapBatchSessions()
+
+	uuid, versionID, err := datastore.MatchingUUID(string(start.UUID))
+	if err != nil {
+		return err
+	}
+	repo, err := datastore.RepoFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	locked, err := repo.Locked(uuid)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return fmt.Errorf("Can't write to locked node %s", uuid)
+	}
+	dataservice, err := repo.GetDataByName(start.DataName)
+	if err != nil {
+		return err
+	}
+	ingester, ok := dataservice.(datastore.BatchIngester)
+	if !ok {
+		return fmt.Errorf("Data %q of type %q doesn't accept batch operations", start.DataName, dataservice.TypeName())
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("Unable to generate batch session ID: %s", err.Error())
+	}
+	id := hex.EncodeToString(b)
+	s := &batchSession{
+		name:     start.DataName,
+		repo:     repo,
+		ingester: ingester,
+		ctx:      datastore.NewVersionedContext(dataservice, versionID),
+		queue:    make(chan BatchChunk, batchQueueSize),
+		done:     make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	s.cond = sync.NewCond(s)
+	go s.apply()
+
+	batchSessions.Lock()
+	batchSessions.m[id] = s
+	batchSessions.Unlock()
+	*session = id
+	dvid.Infof("Began batch session for data %q at node %s\n", start.DataName, uuid)
+	return nil
+}
+
+// SendBatch queues a chunk of operations, waiting for any earlier chunks of the session
+// to be queued and for room in the queue.  The reply gives the chunk's number and size;
+// its outcome is reported when the session ends.
+func (c *RPCConnection) SendBatch(chunk BatchChunk, result *BatchResult) error {
+	s, err := getBatchSession(chunk.Session)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	for s.next != chunk.Seq && !s.ended {
+		if chunk.Seq < s.next {
+			s.Unlock()
+			return fmt.Errorf("Chunk %d of batch session already received", chunk.Seq)
+		}
+		s.cond.Wait()
+	}
+	if s.ended {
+		s.Unlock()
+		return fmt.Errorf("Batch session %q has ended", chunk.Session)
+	}
+	s.lastUsed = time.Now()
+	s.queue <- chunk
+	s.next++
+	s.cond.Broadcast()
+	s.Unlock()
+
+	result.Seq = chunk.Seq
+	result.Ops = len(chunk.Ops)
+	for _, op := range chunk.Ops {
+		result.Bytes += len(op.Value)
+	}
+	return nil
+}
+
+// EndBatch waits for all chunks of a session to be applied and returns a summary.
+func (c *RPCConnection) EndBatch(session string, summary *BatchSummary) error {
+	s, err := getBatchSession(session)
+	if err != nil {
+		return err
+	}
+	batchSessions.Lock()
+	delete(batchSessions.m, session)
+	batchSessions.Unlock()
+	*summary = s.end()
+	dvid.Infof("Ended batch session for data %q: %s\n", s.name, summary)
+	return nil
+}
+
+// ---- Client ------
+
+// Maximum number of chunks a client sends before waiting for replies.
+const batchMaxInFlight = 4
+
+// BatchSession sends chunks of operations to a data instance over RPC.
+type BatchSession struct {
+	client  *rpc.Client
+	id      string
+	seq     int
+	pending []*rpc.Call
+}
+
+// NewBatchSession begins a batch session for a data instance at an unlocked node.
+func (c *Client) NewBatchSession(uuid dvid.UUID, name dvid.DataString) (*BatchSession, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("No DVID server is available at %s", c.rpcAddress)
+	}
+	var id string
+	if err := c.client.Call("RPCConnection.BeginBatch", BatchStart{uuid, name}, &id); err != nil {
+		return nil, fmt.Errorf("Unable to begin batch session: %s", err.Error())
+	}
+	return &BatchSession{client: c.client, id: id}, nil
+}
+
+// Send sends a chunk of operations without waiting for it to be applied.
+func (s *BatchSession) Send(ops []datastore.BatchOp) error {
+	if len(s.pending) == batchMaxInFlight {
+		call := <-s.pending[0].Done
+		s.pending = s.pending[1:]
+		if call.Error != nil {
+			return fmt.Errorf("Unable to send batch chunk: %s", call.Error.Error())
+		}
+	}
+	chunk := BatchChunk{Session: s.id, Seq: s.seq, Ops: ops}
+	s.pending = append(s.pending, s.client.Go("RPCConnection.SendBatch", chunk, new(BatchResult), nil))
+	s.seq++
+	return nil
+}
+
+// Close waits for all chunks to be applied and returns a summary of the session.
+func (s *BatchSession) Close() (*BatchSummary, error) {
+	var sendErr error
+	for _, call := range s.pending {
+		<-call.Done
+		if call.Error != nil && sendErr == nil {
+			sendErr = call.Error
+		}
+	}
+	s.pending = nil
+	summary := new(BatchSummary)
+	if err := s.client.Call("RPCConnection.EndBatch", s.id, summary); err != nil {
+		return nil, fmt.Errorf("Unable to end batch session: %s", err.Error())
+	}
+	if sendErr != nil {
+		return summary, fmt.Errorf("Unable to send batch chunk: %s", sendErr.Error())
+	}
+	return summary, nil
+}
@@ -53,13 +53,17 @@ var (
 	MaxChunkHandlers = runtime.NumCPU()
 
 	// HandlerToken is buffered channel to limit spawning of goroutines.
-	// See ProcessChunk() in datatype/voxels for example.
-	HandlerToken = make(chan int, MaxChunkHandlers)
+	// See ProcessChunk() in datatype/voxels for example.  Its capacity allows the number
+	// of handlers to be raised at runtime up to MaxPoolTokens.
+	HandlerToken = make(chan int, MaxPoolTokens)
+
+	// MaxThrottledOps is the maximum number of throttled ops we can handle through API.
+	MaxThrottledOps = 1
 
 	// Throttle allows server-wide throttling of operations.  This is used for voxels-based
 	// compute-intensive operations on constrained servers.
 	// TODO: This should be replaced with message queue mechanism for prioritized requests.
-	Throttle = make(chan int, MaxThrottledOps)
+	Throttle = make(chan int, MaxPoolTokens)
 
 	// SpawnGoroutineMutex is a global lock for compute-intense processes that want to
 	// spawn goroutines that consume handler tokens.  This lets processes capture most
@@ -87,6 +91,9 @@ func init() {
 	}
 
 	// Initialize the number of handler tokens available.
+	if MaxChunkHandlers > MaxPoolTokens {
+		MaxChunkHandlers = MaxPoolTokens
+	}
 	for i := 0; i < MaxChunkHandlers; i++ {
 		HandlerToken <- 1
 	}
@@ -102,7 +109,7 @@ func init() {
 				ActiveHandlers = curActiveHandlers
 				curActiveHandlers = 0
 			}
-			numHandlers := MaxChunkHandlers - len(HandlerToken)
+			numHandlers := ActiveChunkHandlers()
 			if numHandlers > curActiveHandlers {
 				curActiveHandlers = numHandlers
 			}
@@ -161,6 +168,7 @@ func AboutJSON() (jsonStr string, err error) {
 		"Cores":           fmt.Sprintf("%d", dvid.NumCPU),
 		"Maximum Cores":   fmt.Sprintf("%d", runtime.NumCPU()),
 		"DVID datastore":  datastore.Version,
+		"DVID build":      BuildVersion,
 		"Storage backend": storage.EnginesAvailable(),
 		"Server uptime":   time.Since(startupTime).String(),
 	}
@@ -172,13 +180,27 @@ func AboutJSON() (jsonStr string, err error) {
 	return
 }
 
+var (
+	shutdownMu    sync.Mutex
+	shutdownFuncs []func()
+)
+
+// OnShutdown registers a function called during Shutdown() before storage is closed,
+// e.g., to write data still held in memory.
+func OnShutdown(f func()) {
+	shutdownMu.Lock()
+	shutdownFuncs = append(shutdownFuncs, f)
+	shutdownMu.Unlock()
+}
+
 // Shutdown handles graceful cleanup of server functions before exiting DVID.
 // This may not be so graceful if the chunk handler uses cgo since the interrupt
 // may be caught during cgo execution.
 func Shutdown() {
+	shutdownHTTP()
 	waits := 0
 	for {
-		active := MaxChunkHandlers - len(HandlerToken)
+		active := ActiveChunkHandlers()
 		if waits >= 20 {
 			log.Printf("Already waited for 20 seconds.  Continuing with shutdown...")
 			break
@@ -191,6 +213,16 @@ func Shutdown() {
 		}
 		time.Sleep(1 * time.Second)
 	}
+	if latencyPersisted {
+		if err := latencies.save(); err != nil {
+			dvid.Errorf("Unable to save latency histograms: %s\n", err.Error())
+		}
+	}
+	shutdownMu.Lock()
+	for _, f := range shutdownFuncs {
+		f()
+	}
+	shutdownMu.Unlock()
 	storage.Shutdown()
 	dvid.BlockOnActiveCgo()
 }
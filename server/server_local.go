@@ -18,8 +18,13 @@ import (
 	"os"
 	"runtime"
 	"text/template"
+	"time"
 
+	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/dvid/storage/local"
+	"github.com/janelia-flyem/dvid/storage/objectstore"
 	"github.com/janelia-flyem/go/toml"
 )
 
@@ -32,9 +37,6 @@ const (
 
 	// The name of the server error log, stored in the datastore directory.
 	ErrorLogFilename = "dvid-errors.log"
-
-	// Maximum number of throttled ops we can handle through API
-	MaxThrottledOps = 1
 )
 
 var localConfig configT
@@ -70,6 +72,257 @@ type serverConfig struct {
 	Notify  []string
 	Logging dvid.LogConfig
 	Email   smtpServer
+	TLS     TLSConfig
+	HTTP    httpConfig
+	Auth    authConfig
+	Audit   auditConfig
+	Quotas  quotasConfig
+	CORS    corsConfig
+	Proxy   proxyConfig
+	Cluster clusterConfig
+	Replica replicaConfig
+	GRPC    grpcConfig
+	Cache   cacheConfig
+	Stream  streamConfig
+	Storage storageConfig
+	Plugins pluginsConfig
+}
+
+type pluginsConfig struct {
+	// Paths of Go plugins providing additional datatypes.
+	Paths []string
+}
+
+type storageConfig struct {
+	// If true, versioned writes identical to values inherited from ancestor versions
+	// are not stored.
+	CopyOnWrite bool `toml:"copy_on_write"`
+
+	// If true, values of keyspaces with dictionaries trained by the "dictionary" command
+	// are compressed with them.
+	Dictionaries bool
+
+	// If true, writes are tallied so the storage usage report stays current between
+	// scans by the "usage" command.
+	UsageTracking bool `toml:"usage_tracking"`
+
+	// Durability of writes that request none and whose data instance has none: "sync"
+	// (default), "periodic", or "async".  Periodic writes are synced every SyncSecs.
+	Durability string
+	SyncSecs   int `toml:"sync_secs"`
+
+	Encryption  encryptionConfig
+	Checksums   checksumConfig
+	MutationLog mutationLogConfig `toml:"mutation_log"`
+	BigData     bigDataConfig
+}
+
+type bigDataConfig struct {
+	// Kind of object store holding big data: "s3" or "gcs".  The local datastore is
+	// used if empty.
+	Engine string
+
+	// Bucket and optional prefix of object names.
+	Bucket string
+	Prefix string
+
+	// Region and endpoint of S3 or S3-compatible stores.
+	Region   string
+	Endpoint string
+
+	// MB of writes buffered in memory before being flushed.  Writes aren't buffered
+	// if zero.
+	BufferMB int `toml:"buffer_mb"`
+
+	// Maximum seconds a write is buffered.
+	FlushSecs int `toml:"flush_secs"`
+
+	// Number of concurrent object store requests.
+	Parallelism int
+}
+
+type mutationLogConfig struct {
+	// Path of the log file to which all mutations are appended.  Logging is disabled
+	// if empty.
+	Path string
+
+	// If true, the log file is synced after each mutation.
+	Sync bool
+}
+
+type checksumConfig struct {
+	// How bad checksums of values read for data instances with checksums are handled:
+	// "fail" (default), "log", or "repair".
+	Policy string
+
+	// Path of a local datastore, e.g., a backup, from which values with bad checksums
+	// are repaired.  Required for the "repair" policy.
+	Replica string
+}
+
+type encryptionConfig struct {
+	// File holding a hex-encoded 16, 24, or 32 byte AES key.
+	KeyFile string `toml:"key_file"`
+
+	// Command, e.g., a KMS client, that prints the hex-encoded key with the ID given as
+	// its last argument.  Used instead of a key file.
+	KeyCommand string `toml:"key_command"`
+
+	// ID of the key used to encrypt new values, which is stored with each value so keys
+	// can be rotated.  Values are decrypted using the key they were encrypted with.
+	KeyID uint8 `toml:"key_id"`
+}
+
+// KeyProvider returns the storage key provider given by the configuration or nil if
+// encryption isn't configured.
+func (c encryptionConfig) KeyProvider() (storage.KeyProvider, error) {
+	switch {
+	case c.KeyFile != "" && c.KeyCommand != "":
+		return nil, fmt.Errorf("Only one of key_file or key_command can be given for encryption")
+	case c.KeyFile != "":
+		return storage.NewKeyFileProvider(c.KeyFile, c.KeyID)
+	case c.KeyCommand != "":
+		return storage.NewCommandKeyProvider(c.KeyCommand, c.KeyID)
+	default:
+		return nil, nil
+	}
+}
+
+type cacheConfig struct {
+	// Size in MB of the in-memory LRU cache for voxel blocks and other big data.
+	// No cache is used if zero.
+	BlockMB int `toml:"block_mb"`
+
+	// Number of the most frequent reads before the last shutdown to replay into the
+	// cache on startup.  No warmup is done if zero.
+	WarmupReads int `toml:"warmup_reads"`
+
+	// Seconds after which any remaining warmup reads are skipped.  No limit if zero.
+	WarmupSecs int `toml:"warmup_secs"`
+}
+
+type httpConfig struct {
+	// Seconds allowed to read a request or write its response, including streamed
+	// responses like large subvolumes.  No timeouts if zero.
+	ReadTimeoutSecs  int `toml:"read_timeout_secs"`
+	WriteTimeoutSecs int `toml:"write_timeout_secs"`
+
+	// Seconds an idle keep-alive connection is kept open.  Defaults to an hour.
+	IdleTimeoutSecs int `toml:"idle_timeout_secs"`
+
+	// KB allowed for request headers.  Defaults to 1 MB.
+	MaxHeaderKB int `toml:"max_header_kb"`
+
+	DisableKeepAlives bool `toml:"disable_keep_alives"`
+
+	// If true, HTTP/2 is not negotiated over TLS.
+	DisableHTTP2 bool `toml:"disable_http2"`
+}
+
+type streamConfig struct {
+	// KB of a streamed response, e.g., a large sparse volume, buffered per connection
+	// before storage reads are paused until the client catches up.  Defaults to 4 MB.
+	BufferKB int `toml:"buffer_kb"`
+
+	// Seconds a client may accept no data before a streamed response is abandoned.
+	// Defaults to 60.
+	StallSecs int `toml:"stall_secs"`
+}
+
+type proxyConfig struct {
+	// URLs of backend DVID servers, e.g., "http://emdata1:8000".  If any are given,
+	// reads of UUIDs not held locally are forwarded to the backend holding them.
+	Backends []string
+
+	// API token sent to backends in place of the client's credentials, which are
+	// never forwarded.  Only needed if backends require authentication.
+	Token string
+
+	// Seconds between refreshes of the backend UUID registry.
+	RefreshSecs int `toml:"refresh_secs"`
+}
+
+type clusterConfig struct {
+	// URL of this server as reached by other members, e.g., "http://emdata1:8000".  Cluster
+	// mode is disabled if empty.
+	Self string
+
+	// URL of the member holding repo metadata.
+	Coordinator string
+
+	// URLs of other members.
+	Members []string
+
+	// Secret shared by all members to authenticate requests between them.
+	Secret string
+
+	GossipSecs int `toml:"gossip_secs"`
+	DownSecs   int `toml:"down_secs"`
+
+	// If true, requests modifying repos are redirected to the coordinator instead of
+	// being forwarded.
+	Redirect bool
+}
+
+type replicaConfig struct {
+	// URL of the primary server, e.g., "http://emdata1:8000", whose mutations are
+	// applied to this read-only replica.  Replica mode is disabled if empty.
+	Primary string
+
+	// API token sent to the primary if it requires authentication.
+	Token string
+}
+
+type grpcConfig struct {
+	// Address on which the gRPC API is served, e.g., ":8002".  Requires DVID built with
+	// the "grpc" build tag.  The gRPC API is disabled if empty.
+	Address string
+}
+
+type authConfig struct {
+	// If true, HTTP requests other than help require a valid API token.
+	Enabled bool
+
+	// Admin token accepted in addition to stored tokens, e.g., for creating the first tokens.
+	AdminToken string `toml:"admin_token"`
+}
+
+type auditConfig struct {
+	// If true, mutating HTTP requests are recorded in the audit log.
+	Enabled bool
+}
+
+type corsConfig struct {
+	// Origins allowed to make cross-origin requests, e.g., "https://*.example.org".  Any
+	// origin is allowed without credentials if none are given.
+	AllowedOrigins []string `toml:"allowed_origins"`
+
+	AllowedMethods   []string `toml:"allowed_methods"`
+	AllowedHeaders   []string `toml:"allowed_headers"`
+	ExposedHeaders   []string `toml:"exposed_headers"`
+	AllowCredentials bool     `toml:"allow_credentials"`
+
+	// Seconds browsers can cache preflight responses.
+	MaxAgeSecs int `toml:"max_age_secs"`
+}
+
+type quotasConfig struct {
+	// Name of the group limiting clients not assigned to any group.  Such clients are
+	// unlimited if empty.
+	Default string
+
+	Groups []quotaGroupConfig
+}
+
+type quotaGroupConfig struct {
+	Name           string
+	RequestsPerSec float64 `toml:"requests_per_sec"`
+	Burst          int
+	MaxHeavyOps    int `toml:"max_heavy_ops"`
+
+	// Names of API tokens and IP addresses or CIDR blocks of clients in the group.
+	Tokens []string
+	IPs    []string `toml:"ips"`
 }
 
 type smtpServer struct {
@@ -93,6 +346,17 @@ func LoadConfig(filename string) (*dvid.LogConfig, error) {
 	return &(localConfig.settings.Server.Logging), nil
 }
 
+// LoadPlugins registers the datatypes of any plugins given in the configuration.  It
+// must be called after LoadConfig and before the datastore is initialized.
+func LoadPlugins() error {
+	for _, path := range localConfig.settings.Server.Plugins.Paths {
+		if _, err := datastore.LoadTypePlugin(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type emailData struct {
 	From    string
 	To      string
@@ -155,7 +419,7 @@ func SendNotification(message string, recipients []string) error {
 	return nil
 }
 
-// Serve starts HTTP and RPC servers.
+// Serve starts HTTP and RPC servers and, if configured, a gRPC server.
 func Serve(httpAddress, webClientDir, rpcAddress string) error {
 	// Set the package-level config variable
 	dvid.Infof("Serving HTTP on %s\n", httpAddress)
@@ -167,8 +431,244 @@ func Serve(httpAddress, webClientDir, rpcAddress string) error {
 	c.rpcAddress = rpcAddress
 	c.webClientDir = webClientDir
 
-	// Launch the web server
-	go serveHttp(httpAddress, webClientDir)
+	// Require API tokens if configured.
+	if authCfg := localConfig.settings.Server.Auth; authCfg.Enabled {
+		if err := EnableAuth(authCfg.AdminToken); err != nil {
+			return fmt.Errorf("Could not enable authentication: %s\n", err.Error())
+		}
+	}
+
+	// Record mutating requests if configured.
+	if localConfig.settings.Server.Audit.Enabled {
+		EnableAudit()
+	}
+
+	// Limit requests per client if quota groups are configured.
+	if quotasCfg := localConfig.settings.Server.Quotas; len(quotasCfg.Groups) != 0 {
+		groups := make([]QuotaGroup, len(quotasCfg.Groups))
+		for i, g := range quotasCfg.Groups {
+			groups[i] = QuotaGroup{
+				Name:           g.Name,
+				RequestsPerSec: g.RequestsPerSec,
+				Burst:          g.Burst,
+				MaxHeavyOps:    g.MaxHeavyOps,
+				Tokens:         g.Tokens,
+				IPs:            g.IPs,
+			}
+		}
+		if err := EnableQuotas(groups, quotasCfg.Default); err != nil {
+			return fmt.Errorf("Could not enable quotas: %s\n", err.Error())
+		}
+	}
+
+	// Restrict cross-origin requests if a CORS policy is configured.
+	if corsCfg := localConfig.settings.Server.CORS; len(corsCfg.AllowedOrigins) != 0 {
+		policy := CORSPolicy{
+			AllowedOrigins:   corsCfg.AllowedOrigins,
+			AllowedMethods:   corsCfg.AllowedMethods,
+			AllowedHeaders:   corsCfg.AllowedHeaders,
+			ExposedHeaders:   corsCfg.ExposedHeaders,
+			AllowCredentials: corsCfg.AllowCredentials,
+			MaxAge:           time.Duration(corsCfg.MaxAgeSecs) * time.Second,
+		}
+		if err := EnableCORS(policy); err != nil {
+			return fmt.Errorf("Could not enable CORS policy: %s\n", err.Error())
+		}
+	}
+
+	// Bound the memory buffered for streamed responses to slow clients.
+	httpCfg := localConfig.settings.Server.HTTP
+	HTTPReadTimeout = time.Duration(httpCfg.ReadTimeoutSecs) * time.Second
+	HTTPWriteTimeout = time.Duration(httpCfg.WriteTimeoutSecs) * time.Second
+	if httpCfg.IdleTimeoutSecs > 0 {
+		HTTPIdleTimeout = time.Duration(httpCfg.IdleTimeoutSecs) * time.Second
+	}
+	if httpCfg.MaxHeaderKB > 0 {
+		HTTPMaxHeaderBytes = httpCfg.MaxHeaderKB * dvid.Kilo
+	}
+	HTTPKeepAlives = !httpCfg.DisableKeepAlives
+	HTTP2 = !httpCfg.DisableHTTP2
+
+	if streamCfg := localConfig.settings.Server.Stream; streamCfg.BufferKB > 0 {
+		StreamBufferSize = streamCfg.BufferKB * dvid.Kilo
+	}
+	if streamCfg := localConfig.settings.Server.Stream; streamCfg.StallSecs > 0 {
+		StreamStallTimeout = time.Duration(streamCfg.StallSecs) * time.Second
+	}
+
+	// Proxy reads for non-local repos if backends are configured.
+	if proxyCfg := localConfig.settings.Server.Proxy; len(proxyCfg.Backends) != 0 {
+		refresh := time.Duration(proxyCfg.RefreshSecs) * time.Second
+		if err := EnableProxy(proxyCfg.Backends, proxyCfg.Token, refresh); err != nil {
+			return fmt.Errorf("Could not enable proxy mode: %s\n", err.Error())
+		}
+	}
+
+	// Keep big data in an object store if configured.  This must precede all storage
+	// wrappers.
+	if bigCfg := localConfig.settings.Server.Storage.BigData; bigCfg.Engine != "" {
+		store, err := objectstore.Open(objectstore.Config{
+			Kind:          bigCfg.Engine,
+			Bucket:        bigCfg.Bucket,
+			Prefix:        bigCfg.Prefix,
+			Region:        bigCfg.Region,
+			Endpoint:      bigCfg.Endpoint,
+			BufferBytes:   bigCfg.BufferMB * dvid.Mega,
+			FlushInterval: time.Duration(bigCfg.FlushSecs) * time.Second,
+			Parallelism:   bigCfg.Parallelism,
+		})
+		if err != nil {
+			return fmt.Errorf("Could not open big data store: %s\n", err.Error())
+		}
+		if err := storage.SetBigDataStore(store); err != nil {
+			return fmt.Errorf("Could not set big data store: %s\n", err.Error())
+		}
+	}
+
+	// Follow a primary server as a read-only replica if configured.  This must precede
+	// other storage wrappers so replicated values are stored as on the primary.
+	if replicaCfg := localConfig.settings.Server.Replica; replicaCfg.Primary != "" {
+		if err := EnableReplica(replicaCfg.Primary, replicaCfg.Token); err != nil {
+			return fmt.Errorf("Could not enable replica mode: %s\n", err.Error())
+		}
+	}
+
+	// Log mutations for point-in-time recovery if configured.  This must precede other
+	// storage wrappers so encrypted values are logged.
+	if logCfg := localConfig.settings.Server.Storage.MutationLog; logCfg.Path != "" {
+		if err := storage.EnableMutationLog(logCfg.Path, logCfg.Sync); err != nil {
+			return fmt.Errorf("Could not enable mutation log: %s\n", err.Error())
+		}
+	}
+
+	// Partition data across a cluster if configured.  This must follow the mutation log
+	// so each member logs its own keys, and precede other storage wrappers.
+	if clusterCfg := localConfig.settings.Server.Cluster; clusterCfg.Self != "" {
+		err := EnableCluster(ClusterConfig{
+			Self:           clusterCfg.Self,
+			Coordinator:    clusterCfg.Coordinator,
+			Members:        clusterCfg.Members,
+			Secret:         clusterCfg.Secret,
+			GossipInterval: time.Duration(clusterCfg.GossipSecs) * time.Second,
+			DownTime:       time.Duration(clusterCfg.DownSecs) * time.Second,
+			Redirect:       clusterCfg.Redirect,
+		})
+		if err != nil {
+			return fmt.Errorf("Could not enable cluster mode: %s\n", err.Error())
+		}
+	}
+
+	// Encrypt values at rest if configured.  This must precede other storage wrappers
+	// except the mutation log and cluster.
+	provider, err := localConfig.settings.Server.Storage.Encryption.KeyProvider()
+	if err != nil {
+		return fmt.Errorf("Could not configure encryption: %s\n", err.Error())
+	}
+	if provider != nil {
+		if err := storage.EnableEncryption(provider); err != nil {
+			return fmt.Errorf("Could not enable encryption: %s\n", err.Error())
+		}
+	}
+
+	// Compress values with trained dictionaries if configured.  This must follow
+	// encryption and precede checksum repair.
+	if localConfig.settings.Server.Storage.Dictionaries {
+		if err := storage.EnableValueDictionaries(); err != nil {
+			return fmt.Errorf("Could not enable value dictionaries: %s\n", err.Error())
+		}
+	}
+
+	// Handle bad checksums as configured.  Repair must precede caching.
+	checksumCfg := localConfig.settings.Server.Storage.Checksums
+	policy, err := dvid.ParseChecksumPolicy(checksumCfg.Policy)
+	if err != nil {
+		return fmt.Errorf("Could not configure checksums: %s\n", err.Error())
+	}
+	dvid.SetChecksumPolicy(policy)
+	if policy == dvid.ChecksumRepair {
+		if checksumCfg.Replica == "" {
+			return fmt.Errorf("Checksum repair requires a replica datastore\n")
+		}
+		replica, err := local.OpenReplica(checksumCfg.Replica)
+		if err != nil {
+			return fmt.Errorf("Could not enable checksum repair: %s\n", err.Error())
+		}
+		if err := storage.EnableChecksumRepair(replica); err != nil {
+			return fmt.Errorf("Could not enable checksum repair: %s\n", err.Error())
+		}
+	}
+
+	// Sync writes as required by their durability.  Replicas only make replicated writes.
+	if storageCfg := localConfig.settings.Server.Storage; !replica.isEnabled() {
+		durability := storage.DurabilitySync
+		if storageCfg.Durability != "" {
+			if durability, err = storage.ParseDurability(storageCfg.Durability); err != nil {
+				return fmt.Errorf("Could not configure write durability: %s\n", err.Error())
+			}
+		}
+		interval := time.Duration(storageCfg.SyncSecs) * time.Second
+		if err := storage.EnableWriteDurability(durability, interval); err != nil {
+			return fmt.Errorf("Could not enable write durability: %s\n", err.Error())
+		}
+	}
+
+	// Cache block reads if configured.
+	if cacheCfg := localConfig.settings.Server.Cache; cacheCfg.BlockMB > 0 {
+		if err := storage.EnableBlockCache(cacheCfg.BlockMB * dvid.Mega); err != nil {
+			return fmt.Errorf("Could not enable block cache: %s\n", err.Error())
+		}
+	}
+
+	// Dedupe versioned writes against ancestors if configured.
+	if localConfig.settings.Server.Storage.CopyOnWrite {
+		if err := storage.EnableCopyOnWrite(); err != nil {
+			return fmt.Errorf("Could not enable copy-on-write: %s\n", err.Error())
+		}
+	}
+
+	// Tally writes for the storage usage report if configured.  This must follow all
+	// other storage wrappers.
+	if localConfig.settings.Server.Storage.UsageTracking && !replica.isEnabled() {
+		if err := storage.EnableUsageTracking(); err != nil {
+			return fmt.Errorf("Could not enable usage tracking: %s\n", err.Error())
+		}
+	}
+
+	// Keep request latency histograms across restarts.  Replicas can't save them.
+	if !replica.isEnabled() {
+		if err := EnableLatencyPersistence(); err != nil {
+			dvid.Errorf("Could not load latency histograms: %s\n", err.Error())
+		}
+	}
+
+	// Warm the block cache in the background if configured.
+	if cacheCfg := localConfig.settings.Server.Cache; cacheCfg.BlockMB > 0 && cacheCfg.WarmupReads > 0 {
+		go func() {
+			timeout := time.Duration(cacheCfg.WarmupSecs) * time.Second
+			if err := datastore.Warmup(cacheCfg.WarmupReads, timeout); err != nil {
+				dvid.Errorf("Cache warmup failed: %s\n", err.Error())
+			}
+		}()
+	}
+
+	// Launch the web server, using TLS if configured.
+	tlsConfig, err := localConfig.settings.Server.TLS.NewTLS()
+	if err != nil {
+		return fmt.Errorf("Could not configure TLS: %s\n", err.Error())
+	}
+	go serveHttp(httpAddress, webClientDir, tlsConfig)
+
+	// Launch the gRPC server if configured.
+	if grpcCfg := localConfig.settings.Server.GRPC; grpcCfg.Address != "" {
+		if !GRPCAvailable() {
+			return fmt.Errorf("Could not start gRPC server: DVID was not built with the \"grpc\" build tag\n")
+		}
+		go func() {
+			if err := serveGRPC(grpcCfg.Address); err != nil {
+				dvid.Errorf("gRPC server stopped: %s\n", err.Error())
+			}
+		}()
+	}
 
 	// Launch the rpc server
 	if err := serveRpc(rpcAddress); err != nil {
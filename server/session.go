@@ -0,0 +1,331 @@
+/*
+	This file supports version-pinned sessions for long-lived downloads.  A session pins
+	a locked node when it is created, so requests made with the session token see the same
+	version for the life of the session even if a branch reference used to create the
+	session advances.  Sessions are persisted in the metadata store and can be listed and
+	force-closed by administrators.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// SessionQuery is the query string giving a session token for a request.
+	SessionQuery = "session"
+
+	// DefaultSessionIdle is how long a session can go unused before it expires if no
+	// idle time is given on creation.
+	DefaultSessionIdle = 24 * time.Hour
+)
+
+// The first byte of metadata indices for sessions.
+const sessionKey byte = 0xA3
+
+func sessionIndex(token string) []byte {
+	return append([]byte{sessionKey}, token...)
+}
+
+// Session is a version-pinned view of a repo.
+type Session struct {
+	Token string
+
+	// Ref is the UUID or branch reference used to create the session.
+	Ref string
+
+	// UUID is the pinned node.
+	UUID dvid.UUID
+	Root dvid.UUID
+
+	// Owner is the name of the API token that created the session, if any.
+	Owner string
+
+	Created  time.Time
+	LastUsed time.Time
+	Idle     time.Duration
+	Requests uint64
+}
+
+// Expired returns true if the session has been unused longer than its idle time.
+func (s *Session) Expired() bool {
+	return time.Since(s.LastUsed) > s.Idle
+}
+
+type sessionManager struct {
+	sync.RWMutex
+	loaded   bool
+	sessions map[string]*Session
+}
+
+var sessions = sessionManager{sessions: make(map[string]*Session)}
+
+// loadSessions reads stored sessions from the metadata store if not already loaded.
+// Expired sessions are deleted.  Must be called with the session lock held.
+func (m *sessionManager) loadSessions() error {
+	if m.loaded {
+		return nil
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	ctx := storage.NewMetadataContext()
+	keyvalues, err := store.GetRange(ctx, []byte{sessionKey}, []byte{sessionKey, 0xFF})
+	if err != nil {
+		return fmt.Errorf("Unable to load sessions: %s", err.Error())
+	}
+	for _, kv := range keyvalues {
+		session := new(Session)
+		dec := gob.NewDecoder(bytes.NewBuffer(kv.V))
+		if err := dec.Decode(session); err != nil {
+			return fmt.Errorf("Could not decode stored session: %s", err.Error())
+		}
+		if session.Expired() {
+			if err := store.Delete(ctx, sessionIndex(session.Token)); err != nil {
+				dvid.Errorf("Unable to delete expired session %s: %s\n", session.Token, err.Error())
+			}
+			continue
+		}
+		m.sessions[session.Token] = session
+	}
+	m.loaded = true
+	return nil
+}
+
+func putSession(session *Session) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(session); err != nil {
+		return err
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	return store.Put(storage.NewMetadataContext(), sessionIndex(session.Token), buf.Bytes())
+}
+
+// NewSession pins the given node, which must be locked, and returns a new session.
+func NewSession(repo datastore.Repo, ref string, uuid dvid.UUID, owner string, idle time.Duration) (*Session, error) {
+	locked, err := repo.Locked(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("Sessions can only pin locked nodes and node %s is unlocked", uuid)
+	}
+	if idle <= 0 {
+		idle = DefaultSessionIdle
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("Unable to generate session token: %s", err.Error())
+	}
+	now := time.Now()
+	session := &Session{
+		Token:    hex.EncodeToString(b),
+		Ref:      ref,
+		UUID:     uuid,
+		Root:     repo.RootUUID(),
+		Owner:    owner,
+		Created:  now,
+		LastUsed: now,
+		Idle:     idle,
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+	if err := sessions.loadSessions(); err != nil {
+		return nil, err
+	}
+	if err := putSession(session); err != nil {
+		return nil, err
+	}
+	sessions.sessions[session.Token] = session
+	return session, nil
+}
+
+// UseSession returns a copy of the session for a token and marks it as used.
+func UseSession(token string) (*Session, error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if err := sessions.loadSessions(); err != nil {
+		return nil, err
+	}
+	session, found := sessions.sessions[token]
+	if !found {
+		return nil, fmt.Errorf("No session %q", token)
+	}
+	if session.Expired() {
+		delete(sessions.sessions, token)
+		if store, err := storage.MetaDataStore(); err == nil {
+			store.Delete(storage.NewMetadataContext(), sessionIndex(token))
+		}
+		return nil, fmt.Errorf("Session %q has expired", token)
+	}
+	session.LastUsed = time.Now()
+	session.Requests++
+
+	// Only persist use occasionally so expiration survives restarts without writing
+	// metadata on every request.
+	if session.Requests%1000 == 1 {
+		if err := putSession(session); err != nil {
+			dvid.Errorf("Unable to save session %s: %s\n", token, err.Error())
+		}
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// CloseSession deletes a session.
+func CloseSession(token string) error {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if err := sessions.loadSessions(); err != nil {
+		return err
+	}
+	if _, found := sessions.sessions[token]; !found {
+		return fmt.Errorf("No session %q", token)
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(storage.NewMetadataContext(), sessionIndex(token)); err != nil {
+		return err
+	}
+	delete(sessions.sessions, token)
+	return nil
+}
+
+// Sessions returns all unexpired sessions sorted by creation time.
+func Sessions() ([]Session, error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if err := sessions.loadSessions(); err != nil {
+		return nil, err
+	}
+	list := make([]Session, 0, len(sessions.sessions))
+	for _, session := range sessions.sessions {
+		if !session.Expired() {
+			list = append(list, *session)
+		}
+	}
+	for i := 1; i < len(list); i++ {
+		for j := i; j > 0 && list[j].Created.Before(list[j-1].Created); j-- {
+			list[j], list[j-1] = list[j-1], list[j]
+		}
+	}
+	return list, nil
+}
+
+// pinSession sets the version of a request that gives a session token.  Sessions only
+// allow reads within the repo of the pinned node.  Returns false if the request was
+// rejected.
+func pinSession(c *web.C, w http.ResponseWriter, r *http.Request) bool {
+	token := r.URL.Query().Get(SessionQuery)
+	if token == "" {
+		return true
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		BadRequest(w, r, "Sessions only allow GET and HEAD requests")
+		return false
+	}
+	session, err := UseSession(token)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s (%s).", err.Error(), r.URL.Path), http.StatusGone)
+		return false
+	}
+	repo, ok := c.Env["repo"].(datastore.Repo)
+	if !ok || repo.RootUUID() != session.Root {
+		BadRequest(w, r, fmt.Sprintf("Session %s is for a different repo (root %s)", token, session.Root))
+		return false
+	}
+	versionID, err := datastore.VersionFromUUID(session.UUID)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return false
+	}
+	c.Env["uuid"] = session.UUID
+	c.Env["versionID"] = versionID
+	w.Header().Set("X-Dvid-Session-Uuid", string(session.UUID))
+	return true
+}
+
+// isSessionRequest returns true if the request creates a session, which is allowed
+// on read-only servers and only requires read access to the repo.
+func isSessionRequest(r *http.Request) bool {
+	return r.Method == "POST" && strings.HasPrefix(r.URL.Path, WebAPIPath+"repo/") &&
+		strings.HasSuffix(r.URL.Path, "/session")
+}
+
+// ---- Session handlers
+
+func repoSessionHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+
+	// An optional JSON body can give the idle time in seconds before expiration.
+	var config struct {
+		IdleSecs int64 `json:"idle"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil && err != io.EOF {
+			BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+	}
+	var owner string
+	if token := getAuthToken(r); token != nil {
+		owner = token.Name
+	}
+	idle := time.Duration(config.IdleSecs) * time.Second
+	session, err := NewSession(repo, c.URLParams["uuid"], uuid, owner, idle)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Created session %s pinned to node %s (%s)\n", session.Token, uuid, session.Ref)
+	writeAuthJSON(w, r, session)
+}
+
+func sessionsGetHandler(w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	list, err := Sessions()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	writeAuthJSON(w, r, list)
+}
+
+func sessionDeleteHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	if err := CloseSession(c.URLParams["token"]); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Closed session %s\n", c.URLParams["token"])
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+// resetSessions forgets all sessions loaded from a previous test store.
+func resetSessions() {
+	sessions.Lock()
+	sessions.loaded = false
+	sessions.sessions = make(map[string]*Session)
+	sessions.Unlock()
+}
+
+func TestSessions(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	resetSessions()
+	defer resetSessions()
+
+	repo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+	otherRepo, _ := tests.NewRepo()
+
+	// Only locked nodes can be pinned.
+	if w := testRequest("POST", WebAPIPath+"repo/"+string(root)+"/session", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected session on unlocked node to be rejected, got status %d\n", w.Code)
+	}
+	if err := repo.Lock(root); err != nil {
+		t.Fatalf("Unable to lock root: %s\n", err.Error())
+	}
+	w := testRequest("POST", WebAPIPath+"repo/"+string(root)+"/session", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unable to create session, status %d: %s\n", w.Code, w.Body.String())
+	}
+	var session Session
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatalf("Bad session JSON: %s\n", err.Error())
+	}
+	if session.UUID != root || session.Token == "" {
+		t.Fatalf("Expected session pinned to root %s, got %+v\n", root, session)
+	}
+
+	// Reads with the session stay on the pinned node after a newer commit.
+	child, err := repo.NewVersion(root)
+	if err != nil {
+		t.Fatalf("Unable to create child of root: %s\n", err.Error())
+	}
+	if err := repo.Lock(child); err != nil {
+		t.Fatalf("Unable to lock child: %s\n", err.Error())
+	}
+	infoURL := WebAPIPath + "repo/" + string(child) + "/info?" + SessionQuery + "=" + session.Token
+	w = testRequest("GET", infoURL, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Session read failed with status %d: %s\n", w.Code, w.Body.String())
+	}
+	if pinned := w.Header().Get("X-Dvid-Session-Uuid"); pinned != string(root) {
+		t.Errorf("Expected session read pinned to %s, got %q\n", root, pinned)
+	}
+	if used, err := UseSession(session.Token); err != nil || used.Requests != 2 {
+		t.Errorf("Expected session use to be counted, got %+v (error %v)\n", used, err)
+	}
+
+	// Sessions only allow reads within their repo.
+	if w := testRequest("POST", WebAPIPath+"repo/"+string(child)+"/note?"+SessionQuery+"="+session.Token, "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected POST with session to be rejected, got status %d\n", w.Code)
+	}
+	otherURL := WebAPIPath + "repo/" + string(otherRepo.RootUUID()) + "/info?" + SessionQuery + "=" + session.Token
+	if w := testRequest("GET", otherURL, "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected session read of another repo to be rejected, got status %d\n", w.Code)
+	}
+
+	// Unknown and expired sessions are gone.
+	unknownURL := WebAPIPath + "repo/" + string(child) + "/info?" + SessionQuery + "=nosuchsession"
+	if w := testRequest("GET", unknownURL, "", nil); w.Code != http.StatusGone {
+		t.Errorf("Expected unknown session to be rejected, got status %d\n", w.Code)
+	}
+	expiring, err := NewSession(repo, string(root), root, "", time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unable to create session: %s\n", err.Error())
+	}
+	time.Sleep(10 * time.Millisecond)
+	expiredURL := WebAPIPath + "repo/" + string(child) + "/info?" + SessionQuery + "=" + expiring.Token
+	if w := testRequest("GET", expiredURL, "", nil); w.Code != http.StatusGone {
+		t.Errorf("Expected expired session to be rejected, got status %d\n", w.Code)
+	}
+	if _, err := UseSession(expiring.Token); err == nil {
+		t.Errorf("Expected expired session to be deleted\n")
+	}
+
+	// Closed sessions are gone.
+	if err := CloseSession(session.Token); err != nil {
+		t.Fatalf("Unable to close session: %s\n", err.Error())
+	}
+	if w := testRequest("GET", infoURL, "", nil); w.Code != http.StatusGone {
+		t.Errorf("Expected closed session to be rejected, got status %d\n", w.Code)
+	}
+}
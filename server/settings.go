@@ -0,0 +1,215 @@
+/*
+	This file supports runtime changes to the server's concurrency limits and cache sizes,
+	so a busy server can be tuned without a restart.  The handler token and throttle pools
+	are resized by adding tokens immediately or by withdrawing tokens as running operations
+	return them, so operations already holding tokens are never interrupted.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MaxPoolTokens is the largest number of chunk handlers or throttled operations that can
+// be set.
+const MaxPoolTokens = 1024
+
+var (
+	// poolMu guards resizing of the token pools and the number of tokens still to be
+	// withdrawn from each pool.
+	poolMu            sync.Mutex
+	retiringHandlers  int
+	retiringThrottled int
+)
+
+// Settings are the server limits that can be changed at runtime.
+type Settings struct {
+	ChunkHandlers int
+	ThrottledOps  int
+
+	// BlockCacheMB is the size of the block cache or 0 if there is no block cache.
+	BlockCacheMB int
+}
+
+// settingsRequest changes settings.  Omitted settings aren't changed.
+type settingsRequest struct {
+	ChunkHandlers *int
+	ThrottledOps  *int
+	BlockCacheMB  *int
+}
+
+// CurrentSettings returns the current adjustable server limits.
+func CurrentSettings() Settings {
+	poolMu.Lock()
+	settings := Settings{ChunkHandlers: MaxChunkHandlers, ThrottledOps: MaxThrottledOps}
+	poolMu.Unlock()
+	if stats, found := storage.BlockCacheStatistics(); found {
+		settings.BlockCacheMB = stats.MaxBytes / dvid.Mega
+	}
+	return settings
+}
+
+// ActiveChunkHandlers returns the number of handler tokens in use.
+func ActiveChunkHandlers() int {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	return activeTokens(HandlerToken, MaxChunkHandlers, retiringHandlers)
+}
+
+// ActiveThrottledOps returns the number of throttled operations in flight.
+func ActiveThrottledOps() int {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	return activeTokens(Throttle, MaxThrottledOps, retiringThrottled)
+}
+
+// activeTokens returns the number of tokens of a pool in use, where tokens still to be
+// withdrawn are counted as circulating.  Caller must hold poolMu.
+func activeTokens(pool chan int, size, retiring int) int {
+	active := size + retiring - len(pool)
+	if active < 0 {
+		return 0
+	}
+	return active
+}
+
+// resizePool changes the number of tokens circulating in a pool to n.  Tokens still to
+// be withdrawn are reclaimed first when growing, and tokens removed when shrinking are
+// withdrawn in the background as they become free.  Caller must hold poolMu.
+func resizePool(pool chan int, size, retiring *int, n int) {
+	for ; *size < n; *size++ {
+		if *retiring > 0 {
+			*retiring--
+		} else {
+			pool <- 1
+		}
+	}
+	if *size > n {
+		*retiring += *size - n
+		*size = n
+		go retireTokens(pool, retiring)
+	}
+}
+
+// retireTokens withdraws free tokens from a pool until no more need to be withdrawn.  A
+// token taken after a pool has grown again is put back.
+func retireTokens(pool chan int, retiring *int) {
+	for {
+		poolMu.Lock()
+		if *retiring == 0 {
+			poolMu.Unlock()
+			return
+		}
+		poolMu.Unlock()
+
+		<-pool
+
+		poolMu.Lock()
+		if *retiring > 0 {
+			*retiring--
+		} else {
+			pool <- 1
+		}
+		poolMu.Unlock()
+	}
+}
+
+// ChangeSettings validates and then applies changes to server limits, returning the new
+// settings.  Nothing is changed if any setting is invalid.
+func ChangeSettings(req settingsRequest) (Settings, error) {
+	if req.ChunkHandlers != nil && (*req.ChunkHandlers < 1 || *req.ChunkHandlers > MaxPoolTokens) {
+		return Settings{}, fmt.Errorf("Number of chunk handlers must be from 1 to %d, not %d", MaxPoolTokens, *req.ChunkHandlers)
+	}
+	if req.ThrottledOps != nil && (*req.ThrottledOps < 1 || *req.ThrottledOps > MaxPoolTokens) {
+		return Settings{}, fmt.Errorf("Number of throttled operations must be from 1 to %d, not %d", MaxPoolTokens, *req.ThrottledOps)
+	}
+	if req.BlockCacheMB != nil {
+		if *req.BlockCacheMB < 1 {
+			return Settings{}, fmt.Errorf("Block cache size must be at least 1 MB, not %d", *req.BlockCacheMB)
+		}
+		if _, found := storage.BlockCacheStatistics(); !found {
+			return Settings{}, fmt.Errorf("Can't set block cache size since no block cache is configured")
+		}
+	}
+
+	poolMu.Lock()
+	if req.ChunkHandlers != nil {
+		resizePool(HandlerToken, &MaxChunkHandlers, &retiringHandlers, *req.ChunkHandlers)
+	}
+	if req.ThrottledOps != nil {
+		resizePool(Throttle, &MaxThrottledOps, &retiringThrottled, *req.ThrottledOps)
+	}
+	poolMu.Unlock()
+	if req.BlockCacheMB != nil {
+		if err := storage.ResizeBlockCache(*req.BlockCacheMB * dvid.Mega); err != nil {
+			return Settings{}, err
+		}
+	}
+	return CurrentSettings(), nil
+}
+
+func writeSettings(w http.ResponseWriter, r *http.Request, settings Settings) {
+	jsonBytes, err := json.Marshal(settings)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func settingsGetHandler(w http.ResponseWriter, r *http.Request) {
+	writeSettings(w, r, CurrentSettings())
+}
+
+func settingsPostHandler(w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	var req settingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+		return
+	}
+	settings, err := ChangeSettings(req)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Settings changed by %s: %+v\n", requestClient(r), settings)
+	writeSettings(w, r, settings)
+}
+
+// settingsFromRequest parses the "handlers", "throttle", and "blockcache" settings of an
+// RPC command.
+func settingsFromRequest(cmd datastore.Request) (settingsRequest, error) {
+	var req settingsRequest
+	for _, setting := range []struct {
+		key   string
+		value **int
+	}{
+		{"handlers", &req.ChunkHandlers},
+		{"throttle", &req.ThrottledOps},
+		{"blockcache", &req.BlockCacheMB},
+	} {
+		str, found := cmd.Setting(setting.key)
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return req, fmt.Errorf("Bad %s setting %q", setting.key, str)
+		}
+		*setting.value = &n
+	}
+	return req, nil
+}
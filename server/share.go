@@ -0,0 +1,308 @@
+/*
+	This file supports read-only sharing links.  A share token is a signed capability
+	embedding a locked node, optionally a set of data instances, and an expiration time.
+	Any GET or HEAD request within that scope that includes the token is allowed without
+	an API token, so data like a released segmentation can be shared with collaborators
+	who have no account.  Tokens are signed with a server secret persisted in the
+	metadata store, and all tokens can be revoked by replacing the secret.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// ShareQuery is the query string giving a share token for a request.
+	ShareQuery = "share"
+
+	// DefaultShareExpiration is how long a share token is valid if no expiration is
+	// given on creation.
+	DefaultShareExpiration = 7 * 24 * time.Hour
+)
+
+// Metadata index of the secret used to sign share tokens.
+var shareSecretIndex = []byte{0xA7, 's', 'h', 'a', 'r', 'e'}
+
+// ShareLink is the read-only access granted by a share token.
+type ShareLink struct {
+	// UUID is the shared node.
+	UUID dvid.UUID
+	Root dvid.UUID
+
+	// Instances are the names of shared data instances.  If empty, all data of the
+	// node are shared.
+	Instances []dvid.DataString `json:",omitempty"`
+
+	Expires time.Time
+	Note    string `json:",omitempty"`
+
+	// Owner is the name of the API token that created the link, if any.
+	Owner string `json:",omitempty"`
+}
+
+// Expired returns true if the link is no longer valid.
+func (s *ShareLink) Expired() bool {
+	return time.Now().After(s.Expires)
+}
+
+// Allows returns true if the link grants access to the given data instance.
+func (s *ShareLink) Allows(name dvid.DataString) bool {
+	if len(s.Instances) == 0 {
+		return true
+	}
+	for _, shared := range s.Instances {
+		if shared == name {
+			return true
+		}
+	}
+	return false
+}
+
+type shareManager struct {
+	sync.Mutex
+	secret []byte
+}
+
+var shares shareManager
+
+// getSecret returns the signing secret, loading or creating it if necessary.  Must be
+// called with the share lock held.
+func (m *shareManager) getSecret() ([]byte, error) {
+	if m.secret != nil {
+		return m.secret, nil
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := store.Get(storage.NewMetadataContext(), shareSecretIndex)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load share secret: %s", err.Error())
+	}
+	if secret == nil {
+		return m.newSecret()
+	}
+	m.secret = secret
+	return secret, nil
+}
+
+// newSecret replaces the signing secret, which invalidates all prior share tokens.
+// Must be called with the share lock held.
+func (m *shareManager) newSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("Unable to generate share secret: %s", err.Error())
+	}
+	store, err := storage.MetaDataStore()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(storage.NewMetadataContext(), shareSecretIndex, secret); err != nil {
+		return nil, fmt.Errorf("Unable to store share secret: %s", err.Error())
+	}
+	m.secret = secret
+	return secret, nil
+}
+
+func signShare(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// NewShareToken returns a token granting read-only access to the given node, which must
+// be locked, and data instances of the repo.  All data are shared if no instances are
+// given.
+func NewShareToken(repo datastore.Repo, uuid dvid.UUID, instances []dvid.DataString, expiration time.Duration, note, owner string) (string, *ShareLink, error) {
+	locked, err := repo.Locked(uuid)
+	if err != nil {
+		return "", nil, err
+	}
+	if !locked {
+		return "", nil, fmt.Errorf("Only locked nodes can be shared and node %s is unlocked", uuid)
+	}
+	for _, name := range instances {
+		if _, err := repo.GetDataByName(name); err != nil {
+			return "", nil, err
+		}
+	}
+	if expiration <= 0 {
+		expiration = DefaultShareExpiration
+	}
+	link := &ShareLink{
+		UUID:      uuid,
+		Root:      repo.RootUUID(),
+		Instances: instances,
+		Expires:   time.Now().Add(expiration),
+		Note:      note,
+		Owner:     owner,
+	}
+	payload, err := json.Marshal(link)
+	if err != nil {
+		return "", nil, err
+	}
+
+	shares.Lock()
+	defer shares.Unlock()
+	secret, err := shares.getSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString(payload) + "." + enc.EncodeToString(signShare(secret, payload))
+	return token, link, nil
+}
+
+// ParseShareToken verifies a share token and returns the access it grants.
+func ParseShareToken(token string) (*ShareLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed share token")
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed share token: %s", err.Error())
+	}
+	signature, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed share token: %s", err.Error())
+	}
+
+	shares.Lock()
+	secret, err := shares.getSecret()
+	shares.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature, signShare(secret, payload)) {
+		return nil, fmt.Errorf("Invalid share token")
+	}
+	link := new(ShareLink)
+	if err := json.NewDecoder(bytes.NewBuffer(payload)).Decode(link); err != nil {
+		return nil, fmt.Errorf("Malformed share token: %s", err.Error())
+	}
+	if link.Expired() {
+		return nil, fmt.Errorf("Share token expired at %s", link.Expires.Format(time.RFC3339))
+	}
+	return link, nil
+}
+
+// RevokeShareTokens invalidates all share tokens by replacing the signing secret.
+func RevokeShareTokens() error {
+	shares.Lock()
+	defer shares.Unlock()
+	_, err := shares.newSecret()
+	return err
+}
+
+// requestShare returns the share token given via a "share" query string.
+func requestShare(r *http.Request) string {
+	return r.URL.Query().Get(ShareQuery)
+}
+
+// shareAllowed returns true if the request is a read within the repo or node API, which
+// is all a share token can allow.
+func shareAllowed(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, WebAPIPath+"repo/") ||
+		strings.HasPrefix(r.URL.Path, WebAPIPath+"node/")
+}
+
+// checkShare verifies that a request, after repo selection, is within the scope of its
+// share token.  Sharing only some data instances doesn't allow repo-level requests,
+// which would reveal other instances.  Returns false if the request was rejected.
+func checkShare(c *web.C, w http.ResponseWriter, r *http.Request) bool {
+	if !shareAllowed(r) {
+		Unauthorized(w, r, http.StatusForbidden, "Share tokens only allow reads of shared data")
+		return false
+	}
+	link, err := ParseShareToken(requestShare(r))
+	if err != nil {
+		Unauthorized(w, r, http.StatusUnauthorized, err.Error())
+		return false
+	}
+	if uuid, ok := c.Env["uuid"].(dvid.UUID); !ok || uuid != link.UUID {
+		Unauthorized(w, r, http.StatusForbidden, fmt.Sprintf("Share token is for node %s", link.UUID))
+		return false
+	}
+	dataname, found := c.URLParams["dataname"]
+	if !found && len(link.Instances) != 0 {
+		Unauthorized(w, r, http.StatusForbidden, "Share token only allows reads of shared data instances")
+		return false
+	}
+	if found && !link.Allows(dvid.DataString(dataname)) {
+		Unauthorized(w, r, http.StatusForbidden, fmt.Sprintf("Data %q is not shared by token", dataname))
+		return false
+	}
+	return true
+}
+
+// ---- Share handlers
+
+func repoShareHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+
+	// An optional JSON body can restrict the shared instances and give the seconds
+	// until expiration.
+	var config struct {
+		Instances   []dvid.DataString `json:"instances"`
+		ExpiresSecs int64             `json:"expires"`
+		Note        string            `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil && err != io.EOF {
+			BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+	}
+	var owner string
+	if token := getAuthToken(r); token != nil {
+		owner = token.Name
+	}
+	expiration := time.Duration(config.ExpiresSecs) * time.Second
+	token, link, err := NewShareToken(repo, uuid, config.Instances, expiration, config.Note, owner)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Created share token for node %s expiring %s\n", uuid, link.Expires.Format(time.RFC3339))
+	writeAuthJSON(w, r, struct {
+		Token string
+		*ShareLink
+	}{token, link})
+}
+
+func sharesDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	if err := RevokeShareTokens(); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Revoked all share tokens\n")
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Revoked all share tokens\n")
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+	"github.com/zenazn/goji/web"
+)
+
+// signTestShare returns a share token for a link without the checks of NewShareToken.
+func signTestShare(t *testing.T, link *ShareLink) string {
+	payload, err := json.Marshal(link)
+	if err != nil {
+		t.Fatalf("Unable to encode share link: %s\n", err.Error())
+	}
+	shares.Lock()
+	secret, err := shares.getSecret()
+	shares.Unlock()
+	if err != nil {
+		t.Fatalf("Unable to get share secret: %s\n", err.Error())
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(signShare(secret, payload))
+}
+
+func TestShareTokens(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	otherRepo, _ := tests.NewRepo()
+	root, otherRoot := repo.RootUUID(), otherRepo.RootUUID()
+	enableTestAuth(t, "test-admin")
+
+	shareReq := fmt.Sprintf("%srepo/%s/share", WebAPIPath, root)
+	if w := testRequest("POST", shareReq, "test-admin", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected share of unlocked node to fail, got status %d\n", w.Code)
+	}
+	for _, r := range []struct {
+		uuid dvid.UUID
+		lock func(dvid.UUID) error
+	}{{root, repo.Lock}, {otherRoot, otherRepo.Lock}} {
+		if err := r.lock(r.uuid); err != nil {
+			t.Fatalf("Unable to lock node %s: %s\n", r.uuid, err.Error())
+		}
+	}
+	w := testRequest("POST", shareReq, "test-admin", strings.NewReader(`{"expires": 3600, "note": "for reviewers"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unable to create share token, status %d: %s\n", w.Code, w.Body.String())
+	}
+	var created struct {
+		Token string
+		Owner string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Unable to decode share token response: %s\n", err.Error())
+	}
+
+	repoInfo := fmt.Sprintf("%srepo/%s/info", WebAPIPath, root)
+	nodeMeta := fmt.Sprintf("%snode/%s/metadata", WebAPIPath, root)
+	nodeNote := fmt.Sprintf("%snode/%s/note", WebAPIPath, root)
+	withShare := func(urlStr, token string) string {
+		return urlStr + "?" + ShareQuery + "=" + token
+	}
+
+	// Reads of the shared node are allowed without an API token, but writes aren't.
+	for _, urlStr := range []string{repoInfo, nodeMeta} {
+		if w := testRequest("GET", withShare(urlStr, created.Token), "", nil); w.Code != http.StatusOK {
+			t.Errorf("GET %s with share token: expected status 200, got %d: %s\n", urlStr, w.Code, w.Body.String())
+		}
+	}
+	if w := testRequest("POST", withShare(nodeNote, created.Token), "", strings.NewReader(`{"note": "x"}`)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected write with share token to be rejected, got status %d\n", w.Code)
+	}
+
+	// Tampered, expired, and wrong-node tokens are rejected.
+	parts := strings.Split(created.Token, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	payload = []byte(strings.Replace(string(payload), "for reviewers", "for everyone!", 1))
+	tampered := base64.RawURLEncoding.EncodeToString(payload) + "." + parts[1]
+	expired := signTestShare(t, &ShareLink{UUID: root, Root: root, Expires: time.Now().Add(-time.Minute)})
+	wrongNode := signTestShare(t, &ShareLink{UUID: otherRoot, Root: otherRoot, Expires: time.Now().Add(time.Hour)})
+	checks := map[string]int{
+		"tampered":   http.StatusUnauthorized,
+		"expired":    http.StatusUnauthorized,
+		"wrong node": http.StatusForbidden,
+		"malformed":  http.StatusUnauthorized,
+	}
+	tokens := map[string]string{
+		"tampered":   tampered,
+		"expired":    expired,
+		"wrong node": wrongNode,
+		"malformed":  "not-a-share-token",
+	}
+	for name, expected := range checks {
+		if w := testRequest("GET", withShare(repoInfo, tokens[name]), "", nil); w.Code != expected {
+			t.Errorf("GET with %s share token: expected status %d, got %d\n", name, expected, w.Code)
+		}
+	}
+
+	// Revoking shares requires an admin and invalidates all prior tokens.
+	reader, err := NewAuthToken("reader", false, map[dvid.UUID]Role{root: ReadRole})
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+	sharesURL := WebAPIPath + "shares"
+	if w := testRequest("DELETE", sharesURL, reader.Token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected revoke by non-admin to be rejected, got status %d\n", w.Code)
+	}
+	if w := testRequest("GET", withShare(repoInfo, created.Token), "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected share token to work before revoke, got status %d\n", w.Code)
+	}
+	if w := testRequest("DELETE", sharesURL, "test-admin", nil); w.Code != http.StatusOK {
+		t.Fatalf("Unable to revoke share tokens, status %d: %s\n", w.Code, w.Body.String())
+	}
+	if w := testRequest("GET", withShare(repoInfo, created.Token), "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked share token to be rejected, got status %d\n", w.Code)
+	}
+}
+
+func TestShareInstances(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+	if err := repo.Lock(root); err != nil {
+		t.Fatalf("Unable to lock node %s: %s\n", root, err.Error())
+	}
+	enableTestAuth(t, "test-admin")
+
+	// Instance-scoped tokens don't allow repo or node-level reads that would reveal other
+	// instances.
+	token := signTestShare(t, &ShareLink{
+		UUID:      root,
+		Root:      root,
+		Instances: []dvid.DataString{"grayscale"},
+		Expires:   time.Now().Add(time.Hour),
+	})
+	for _, urlStr := range []string{
+		fmt.Sprintf("%srepo/%s/info", WebAPIPath, root),
+		fmt.Sprintf("%snode/%s/metadata", WebAPIPath, root),
+	} {
+		if w := testRequest("GET", urlStr+"?share="+token, "", nil); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s with instance share token: expected status 403, got %d\n", urlStr, w.Code)
+		}
+	}
+
+	// Only the shared instance can be read.
+	for dataname, expected := range map[string]bool{"grayscale": true, "segmentation": false} {
+		urlStr := fmt.Sprintf("%snode/%s/%s/info?share=%s", WebAPIPath, root, dataname, token)
+		r, _ := http.NewRequest("GET", urlStr, nil)
+		c := &web.C{
+			Env:       map[interface{}]interface{}{"uuid": root},
+			URLParams: map[string]string{"uuid": string(root), "dataname": dataname},
+		}
+		w := httptest.NewRecorder()
+		if allowed := checkShare(c, w, r); allowed != expected {
+			t.Errorf("Share of %q: expected allowed %t, got %t with status %d\n", dataname, expected, allowed, w.Code)
+		}
+		if !expected && w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for unshared instance %q, got %d\n", dataname, w.Code)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+	This file supports the server status endpoint, which reports the build, uptime, load,
+	storage statistics, memory use, and loaded datatypes of a running server so clients
+	can monitor it and verify compatibility programmatically.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// BuildVersion identifies the source the server was built from, e.g., the output of
+// "git describe".  It is set at link time via -ldflags "-X".
+var BuildVersion = "unknown"
+
+// TypeStatus describes a datatype loaded by the server.
+type TypeStatus struct {
+	Name    dvid.TypeString
+	URL     dvid.URLString
+	Version string
+}
+
+type typesByName []TypeStatus
+
+func (s typesByName) Len() int           { return len(s) }
+func (s typesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s typesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// StorageStatus gives storage throughput over the last second and statistics of
+// optional storage layers.
+type StorageStatus struct {
+	Engines string
+
+	GetsPerSec              int
+	PutsPerSec              int
+	KeyBytesReadPerSec      int
+	KeyBytesWrittenPerSec   int
+	ValueBytesReadPerSec    int
+	ValueBytesWrittenPerSec int
+	FileBytesReadPerSec     int
+	FileBytesWrittenPerSec  int
+
+	BlockCache  *storage.BlockCacheStats  `json:",omitempty"`
+	CopyOnWrite *storage.CopyOnWriteStats `json:",omitempty"`
+}
+
+// MemoryStatus gives the memory use of the server process.
+type MemoryStatus struct {
+	AllocBytes     uint64 // bytes of allocated heap objects
+	HeapInUseBytes uint64
+	SysBytes       uint64 // bytes obtained from the OS
+	NumGC          uint32
+	Goroutines     int
+}
+
+// Status describes a running server.
+type Status struct {
+	BuildVersion     string
+	DatastoreVersion string
+	GoVersion        string
+	StartTime        time.Time
+	UptimeSecs       float64
+	ReadOnly         bool
+
+	Cores            int
+	MaxCores         int
+	ActiveHandlers   int // maximum handlers active over the last second
+	MaxChunkHandlers int
+	ThrottledOps     int // throttled operations in flight
+	MaxThrottledOps  int
+
+	Storage   StorageStatus
+	Memory    MemoryStatus
+	Datatypes []TypeStatus
+}
+
+// GetStatus returns the current status of the server.
+func GetStatus() (*Status, error) {
+	typemap, err := datastore.Types()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]TypeStatus, 0, len(typemap))
+	for _, typeservice := range typemap {
+		t := typeservice.GetType()
+		types = append(types, TypeStatus{t.Name, t.URL, t.Version})
+	}
+	sort.Sort(typesByName(types))
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	status := &Status{
+		BuildVersion:     BuildVersion,
+		DatastoreVersion: datastore.Version,
+		GoVersion:        runtime.Version(),
+		StartTime:        startupTime,
+		UptimeSecs:       time.Since(startupTime).Seconds(),
+		ReadOnly:         readonly,
+		Cores:            dvid.NumCPU,
+		MaxCores:         runtime.NumCPU(),
+		ActiveHandlers:   ActiveHandlers,
+		MaxChunkHandlers: MaxChunkHandlers,
+		ThrottledOps:     ActiveThrottledOps(),
+		MaxThrottledOps:  MaxThrottledOps,
+		Storage: StorageStatus{
+			Engines:                 storage.EnginesAvailable(),
+			GetsPerSec:              storage.GetsPerSec,
+			PutsPerSec:              storage.PutsPerSec,
+			KeyBytesReadPerSec:      storage.StoreKeyBytesReadPerSec,
+			KeyBytesWrittenPerSec:   storage.StoreKeyBytesWrittenPerSec,
+			ValueBytesReadPerSec:    storage.StoreValueBytesReadPerSec,
+			ValueBytesWrittenPerSec: storage.StoreValueBytesWrittenPerSec,
+			FileBytesReadPerSec:     storage.FileBytesReadPerSec,
+			FileBytesWrittenPerSec:  storage.FileBytesWrittenPerSec,
+		},
+		Memory: MemoryStatus{
+			AllocBytes:     mem.Alloc,
+			HeapInUseBytes: mem.HeapInuse,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+			Goroutines:     runtime.NumGoroutine(),
+		},
+		Datatypes: types,
+	}
+	if stats, found := storage.BlockCacheStatistics(); found {
+		status.Storage.BlockCache = &stats
+	}
+	if stats, found := storage.CopyOnWriteStatistics(); found {
+		status.Storage.CopyOnWrite = &stats
+	}
+	return status, nil
+}
+
+func serverStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := GetStatus()
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(status)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
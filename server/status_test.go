@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestServerStatus(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+
+	w := testRequest("GET", WebAPIPath+"server/status", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Bad status %d getting server status: %s\n", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %q\n", ct)
+	}
+
+	var status map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Bad server status JSON: %s\n", err.Error())
+	}
+	for _, key := range []string{
+		"BuildVersion", "DatastoreVersion", "GoVersion", "StartTime", "UptimeSecs",
+		"Cores", "ActiveHandlers", "MaxChunkHandlers", "ThrottledOps",
+		"Storage", "Memory", "Datatypes",
+	} {
+		if _, found := status[key]; !found {
+			t.Errorf("Expected %q in server status, got %s\n", key, w.Body.String())
+		}
+	}
+
+	var build string
+	if err := json.Unmarshal(status["BuildVersion"], &build); err != nil || build != BuildVersion {
+		t.Errorf("Expected build version %q, got %s\n", BuildVersion, status["BuildVersion"])
+	}
+	var dsVersion string
+	if err := json.Unmarshal(status["DatastoreVersion"], &dsVersion); err != nil || dsVersion != datastore.Version {
+		t.Errorf("Expected datastore version %q, got %s\n", datastore.Version, status["DatastoreVersion"])
+	}
+
+	var storageStatus map[string]json.RawMessage
+	if err := json.Unmarshal(status["Storage"], &storageStatus); err != nil {
+		t.Fatalf("Bad storage status JSON: %s\n", err.Error())
+	}
+	for _, key := range []string{"Engines", "GetsPerSec", "PutsPerSec", "ValueBytesReadPerSec", "ValueBytesWrittenPerSec"} {
+		if _, found := storageStatus[key]; !found {
+			t.Errorf("Expected %q in storage status, got %s\n", key, status["Storage"])
+		}
+	}
+
+	// Datatypes is a list even if no datatypes are loaded.
+	var types []TypeStatus
+	if err := json.Unmarshal(status["Datatypes"], &types); err != nil || types == nil {
+		t.Errorf("Expected list of datatypes, got %s\n", status["Datatypes"])
+	}
+	for i := 1; i < len(types); i++ {
+		if types[i-1].Name > types[i].Name {
+			t.Errorf("Expected datatypes sorted by name, got %v\n", types)
+		}
+	}
+}
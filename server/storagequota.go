@@ -0,0 +1,117 @@
+/*
+	This file supports per-repo storage quotas.  A repo's quota is kept with the repo
+	metadata, and requests that may write data instances of a repo whose stored bytes,
+	as tallied by the storage usage report, reach its quota get a 507 response.  Admins
+	can override the quota so writes are accepted until the override is removed.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/zenazn/goji/web"
+)
+
+// InsufficientStorage returns a 507 response for writes rejected by a storage quota.
+func InsufficientStorage(w http.ResponseWriter, r *http.Request, message string) {
+	errorMsg := fmt.Sprintf("ERROR: %s (%s).", message, r.URL.Path)
+	dvid.Infof(errorMsg)
+	http.Error(w, errorMsg, http.StatusInsufficientStorage)
+}
+
+// checkStorageQuota returns false after writing a 507 response if the repo's storage
+// quota is exceeded.
+func checkStorageQuota(w http.ResponseWriter, r *http.Request, repo datastore.Repo) bool {
+	if err := datastore.CheckStorageQuota(repo); err != nil {
+		InsufficientStorage(w, r, err.Error())
+		return false
+	}
+	return true
+}
+
+// ingestBatch applies batch operations unless the repo's storage quota is exceeded.  It
+// is the write path for blocks sent over gRPC and for RPC batch sessions.
+func ingestBatch(repo datastore.Repo, ingester datastore.BatchIngester, ctx *datastore.VersionedContext, ops []datastore.BatchOp) error {
+	if err := datastore.CheckStorageQuota(repo); err != nil {
+		return err
+	}
+	return ingester.IngestBatch(ctx, ops)
+}
+
+// writeStorageQuota returns JSON with a repo's quota and the bytes it has used.
+func writeStorageQuota(w http.ResponseWriter, r *http.Request, repo datastore.Repo) {
+	used, err := datastore.RepoStorageUsed(repo)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	quota := repo.GetStorageQuota()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"MaxBytes": %d, "Override": %t, "UsedBytes": %d, "Exceeded": %t}`,
+		quota.MaxBytes, quota.Override, used, quota.MaxBytes != 0 && used >= quota.MaxBytes)
+}
+
+func repoQuotaGetHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	writeStorageQuota(w, r, repo)
+}
+
+func repoQuotaPostHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Repo admins can't change their own quota.
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	repo := (c.Env["repo"]).(datastore.Repo)
+
+	var config struct {
+		MaxBytes *uint64 `json:"maxbytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err.Error()))
+		return
+	}
+	if config.MaxBytes == nil {
+		BadRequest(w, r, "POST on repo quota endpoint requires a 'maxbytes' property")
+		return
+	}
+	quota := repo.GetStorageQuota()
+	quota.MaxBytes = *config.MaxBytes
+	if err := repo.SetStorageQuota(quota); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Set storage quota of repo %s to %d bytes\n", repo.RootUUID(), quota.MaxBytes)
+	writeStorageQuota(w, r, repo)
+}
+
+func repoQuotaOverrideHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	repo := (c.Env["repo"]).(datastore.Repo)
+
+	var config struct {
+		Override *bool `json:"override"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err.Error()))
+		return
+	}
+	if config.Override == nil {
+		BadRequest(w, r, "POST on repo quota override endpoint requires an 'override' property")
+		return
+	}
+	quota := repo.GetStorageQuota()
+	quota.Override = *config.Override
+	if err := repo.SetStorageQuota(quota); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	dvid.Infof("Storage quota override of repo %s set to %t by %s\n", repo.RootUUID(), quota.Override, requestClient(r))
+	writeStorageQuota(w, r, repo)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/tests"
+)
+
+func TestRepoQuotaAdmin(t *testing.T) {
+	tests.UseStore()
+	defer tests.CloseStore()
+	defer resetAuth()
+
+	repo, _ := tests.NewRepo()
+	root := repo.RootUUID()
+	enableTestAuth(t, "test-admin")
+	repoAdmin, err := NewAuthToken("owner", false, map[dvid.UUID]Role{root: AdminRole})
+	if err != nil {
+		t.Fatalf("Unable to create API token: %s\n", err.Error())
+	}
+
+	// Only server admins can set a repo's quota or override it.
+	quotaURL := WebAPIPath + "repo/" + string(root) + "/quota"
+	for _, token := range []string{repoAdmin.Token, ""} {
+		if w := testRequest("POST", quotaURL, token, strings.NewReader(`{"maxbytes": 1000}`)); w.Code != http.StatusForbidden && w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected quota POST with token %q to be rejected, got status %d\n", token, w.Code)
+		}
+		if w := testRequest("POST", quotaURL+"/override", token, strings.NewReader(`{"override": true}`)); w.Code != http.StatusForbidden && w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected quota override with token %q to be rejected, got status %d\n", token, w.Code)
+		}
+	}
+	if quota := repo.GetStorageQuota(); quota.MaxBytes != 0 || quota.Override {
+		t.Fatalf("Quota changed by rejected requests: %+v\n", quota)
+	}
+	if w := testRequest("GET", quotaURL, repoAdmin.Token, nil); w.Code == http.StatusForbidden {
+		t.Errorf("Expected repo admin to be able to read the quota\n")
+	}
+
+	testRequest("POST", quotaURL, "test-admin", strings.NewReader(`{"maxbytes": 1000}`))
+	testRequest("POST", quotaURL+"/override", "test-admin", strings.NewReader(`{"override": true}`))
+	if quota := repo.GetStorageQuota(); quota.MaxBytes != 1000 || !quota.Override {
+		t.Errorf("Expected server admin to set quota and override, got %+v\n", quota)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+	This file supports streaming large responses, e.g., sparse volumes, to clients that
+	may consume them slowly.  A StreamWriter holds at most a fixed number of bytes per
+	connection, and writes block once that much is waiting to be sent.  The producer of
+	the response, e.g., a storage range scan, is thereby paused rather than buffering the
+	whole response in memory.  If the client accepts no data for too long, writes fail so
+	the producer can stop reading storage, and the connection is aborted so a write
+	blocked on the stalled client doesn't hold the request open.
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// DefaultStreamBufferSize is the default number of bytes buffered per connection.
+	DefaultStreamBufferSize = 4 * dvid.Mega
+
+	// DefaultStreamStallTimeout is the default time a client may accept no data before
+	// a streamed response is abandoned.
+	DefaultStreamStallTimeout = time.Minute
+)
+
+var (
+	// StreamBufferSize is the maximum number of bytes buffered for a streamed response.
+	StreamBufferSize = DefaultStreamBufferSize
+
+	// StreamStallTimeout is how long a client may accept no data before writes to a
+	// streamed response fail.
+	StreamStallTimeout = DefaultStreamStallTimeout
+
+	// ErrSlowClient is returned by writes to a streamed response when the client has
+	// accepted no data within the stall timeout.
+	ErrSlowClient = errors.New("client stopped accepting streamed response")
+)
+
+// StreamWriter sends data to a client from a bounded buffer, blocking writes while the
+// buffer is full.  Writes must come from a single goroutine, and Close must be called
+// before the request handler returns.
+type StreamWriter struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	limit   int
+	timeout time.Duration
+
+	mu       sync.Mutex
+	queue    [][]byte
+	buffered int
+	err      error
+	closed   bool
+	aborted  bool // a write deadline was set to unblock the sender after a stall
+
+	ready   chan struct{} // signaled when data is queued or the stream is closed
+	drained chan struct{} // signaled when queued data is sent
+	done    chan struct{} // closed when the sender exits
+
+	// Total bytes sent and time writes were blocked by a slow client.
+	sent    uint64
+	blocked time.Duration
+}
+
+// NewStreamWriter returns a StreamWriter for a response using the configured buffer size
+// and stall timeout.  Headers must be set before the first write.
+func NewStreamWriter(w http.ResponseWriter, r *http.Request) *StreamWriter {
+	s := &StreamWriter{
+		w:       w,
+		r:       r,
+		limit:   StreamBufferSize,
+		timeout: StreamStallTimeout,
+		ready:   make(chan struct{}, 1),
+		drained: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go s.send()
+	return s
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// send writes queued data to the client until the stream is closed or a write fails.
+func (s *StreamWriter) send() {
+	defer close(s.done)
+	flusher, _ := s.w.(http.Flusher)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 {
+			if s.closed || s.err != nil {
+				s.mu.Unlock()
+				return
+			}
+			s.mu.Unlock()
+			<-s.ready
+			s.mu.Lock()
+		}
+		if s.err != nil {
+			s.mu.Unlock()
+			return
+		}
+		data := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		_, err := s.w.Write(data)
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+
+		s.mu.Lock()
+		s.buffered -= len(data)
+		s.sent += uint64(len(data))
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		notify(s.drained)
+	}
+}
+
+// Write queues a copy of the data to be sent, blocking while the buffer is full.  Data
+// larger than the buffer is queued once the buffer is empty.  Returns ErrSlowClient if
+// the client accepts no data within the stall timeout.
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	data := append([]byte(nil), p...)
+	for {
+		s.mu.Lock()
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		if s.buffered == 0 || s.buffered+len(data) <= s.limit {
+			s.queue = append(s.queue, data)
+			s.buffered += len(data)
+			s.mu.Unlock()
+			notify(s.ready)
+			return len(p), nil
+		}
+		s.mu.Unlock()
+
+		// Wait for the client to consume some of the buffer.
+		t0 := time.Now()
+		timer := time.NewTimer(s.timeout)
+		select {
+		case <-s.drained:
+			timer.Stop()
+			s.blocked += time.Since(t0)
+		case <-timer.C:
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = ErrSlowClient
+			}
+			s.mu.Unlock()
+			s.abort()
+			notify(s.ready)
+			return 0, ErrSlowClient
+		}
+	}
+}
+
+// abort sets an expired write deadline on the connection so a write blocked on a stalled
+// client fails.
+func (s *StreamWriter) abort() {
+	if err := http.NewResponseController(s.w).SetWriteDeadline(time.Now()); err != nil {
+		dvid.Debugf("Unable to set write deadline for stalled stream to %s: %s\n", s.r.RemoteAddr, err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.aborted = true
+	s.mu.Unlock()
+}
+
+// Close waits until all queued data is sent or the stream fails and returns the first
+// error.  If the client stalled and the connection's write deadline couldn't be set,
+// Close panics with http.ErrAbortHandler so the server closes the connection instead
+// of waiting on the client.
+func (s *StreamWriter) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	stalled := s.err == ErrSlowClient && !s.aborted
+	s.mu.Unlock()
+	notify(s.ready)
+	if stalled {
+		select {
+		case <-s.done:
+		default:
+			dvid.Errorf("Aborting stalled stream of %d bytes to %s for %s\n", s.sent, s.r.RemoteAddr, s.r.URL.Path)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blocked >= s.timeout/10 {
+		dvid.Infof("Streamed %d bytes to slow client %s for %s, paused %s waiting for client\n",
+			s.sent, s.r.RemoteAddr, s.r.URL.Path, s.blocked)
+	}
+	return s.err
+}
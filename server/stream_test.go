@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallWriter is a response writer whose writes block until released, simulating a
+// client that stops reading.  If deadlines are supported, an expired write deadline
+// makes blocked writes fail.
+type stallWriter struct {
+	http.ResponseWriter
+	release  chan struct{}
+	deadline chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	written int
+}
+
+func newStallWriter() *stallWriter {
+	return &stallWriter{
+		ResponseWriter: httptest.NewRecorder(),
+		release:        make(chan struct{}),
+		deadline:       make(chan struct{}),
+	}
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.release:
+	case <-w.deadline:
+		return 0, errors.New("write deadline exceeded")
+	}
+	w.mu.Lock()
+	w.written += len(p)
+	w.mu.Unlock()
+	return len(p), nil
+}
+
+func (w *stallWriter) numWritten() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// deadlineWriter is a stallWriter that supports write deadlines.
+type deadlineWriter struct {
+	*stallWriter
+}
+
+func (w deadlineWriter) SetWriteDeadline(t time.Time) error {
+	w.once.Do(func() { close(w.deadline) })
+	return nil
+}
+
+func setStreamLimits(size int, timeout time.Duration) func() {
+	oldSize, oldTimeout := StreamBufferSize, StreamStallTimeout
+	StreamBufferSize, StreamStallTimeout = size, timeout
+	return func() {
+		StreamBufferSize, StreamStallTimeout = oldSize, oldTimeout
+	}
+}
+
+func TestStreamBackPressure(t *testing.T) {
+	defer setStreamLimits(10, time.Minute)()
+
+	w := newStallWriter()
+	r := httptest.NewRequest("GET", "/api/stream", nil)
+	stream := NewStreamWriter(w, r)
+
+	// The sender takes the first write and blocks on the client, so the buffer is full.
+	if _, err := stream.Write(make([]byte, 10)); err != nil {
+		t.Fatalf("Error on first write: %s\n", err.Error())
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(make([]byte, 10))
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatalf("Expected write to block while buffer is full\n")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(w.release)
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("Error on write after client resumed: %s\n", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write still blocked after client resumed\n")
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Error closing stream: %s\n", err.Error())
+	}
+	if n := w.numWritten(); n != 20 {
+		t.Errorf("Expected 20 bytes sent to client, got %d\n", n)
+	}
+}
+
+func TestStreamStallTimeout(t *testing.T) {
+	defer setStreamLimits(10, 20*time.Millisecond)()
+
+	w := deadlineWriter{newStallWriter()}
+	r := httptest.NewRequest("GET", "/api/stream", nil)
+	stream := NewStreamWriter(w, r)
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = stream.Write(make([]byte, 10))
+	}
+	if err != ErrSlowClient {
+		t.Fatalf("Expected ErrSlowClient on write to stalled client, got %v\n", err)
+	}
+
+	// The expired deadline unblocks the sender so Close returns.
+	closed := make(chan error, 1)
+	go func() {
+		closed <- stream.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != ErrSlowClient {
+			t.Errorf("Expected ErrSlowClient from Close, got %v\n", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close didn't return after client stalled\n")
+	}
+}
+
+func TestStreamStallAbort(t *testing.T) {
+	defer setStreamLimits(10, 20*time.Millisecond)()
+
+	w := newStallWriter()
+	defer close(w.release)
+	r := httptest.NewRequest("GET", "/api/stream", nil)
+	stream := NewStreamWriter(w, r)
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = stream.Write(make([]byte, 10))
+	}
+	if err != ErrSlowClient {
+		t.Fatalf("Expected ErrSlowClient on write to stalled client, got %v\n", err)
+	}
+
+	// Without write deadlines, Close aborts the handler rather than waiting on the client.
+	closed := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			closed <- recover()
+		}()
+		stream.Close()
+	}()
+	select {
+	case e := <-closed:
+		if e != http.ErrAbortHandler {
+			t.Errorf("Expected Close to panic with http.ErrAbortHandler, got %v\n", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close didn't return after client stalled\n")
+	}
+}
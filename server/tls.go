@@ -0,0 +1,127 @@
+/*
+	This file supports serving the HTTP API over TLS.  Certificates are either read from
+	files, optionally reloaded on SIGHUP, or obtained automatically from Let's Encrypt.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig gives the TLS settings for the web server.  Either a certificate and key
+// file or a list of hosts for automatic Let's Encrypt certificates should be given.
+type TLSConfig struct {
+	// Paths to PEM-encoded certificate and private key.
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+
+	// If true, the certificate and key files are reloaded when the server gets SIGHUP.
+	ReloadOnHUP bool `toml:"reload_on_hup"`
+
+	// Host names for which certificates are automatically obtained from Let's Encrypt.
+	AutocertHosts []string `toml:"autocert_hosts"`
+
+	// Directory for caching Let's Encrypt certificates across restarts.
+	AutocertCache string `toml:"autocert_cache"`
+
+	// Contact e-mail given to Let's Encrypt.
+	AutocertEmail string `toml:"autocert_email"`
+}
+
+// Enabled returns true if TLS has been configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.AutocertHosts) != 0
+}
+
+// NewTLS returns a tls.Config for the web server or nil if TLS isn't configured.
+func (c TLSConfig) NewTLS() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	if len(c.AutocertHosts) != 0 {
+		if c.CertFile != "" {
+			return nil, fmt.Errorf("TLS configuration can't specify both certificate files and autocert hosts")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertHosts...),
+			Email:      c.AutocertEmail,
+		}
+		if c.AutocertCache != "" {
+			m.Cache = autocert.DirCache(c.AutocertCache)
+		} else {
+			dvid.Infof("No autocert cache directory set: certificates will be requested on each restart.\n")
+		}
+		dvid.Infof("Using Let's Encrypt certificates for hosts %v\n", c.AutocertHosts)
+		return m.TLSConfig(), nil
+	}
+	if c.KeyFile == "" {
+		return nil, fmt.Errorf("TLS configuration has certificate file %q but no key file", c.CertFile)
+	}
+	reloader, err := newCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.ReloadOnHUP {
+		go reloader.reloadOnSignal()
+	}
+	dvid.Infof("Using TLS certificate %s\n", c.CertFile)
+	return &tls.Config{GetCertificate: reloader.getCertificate}, nil
+}
+
+// certReloader holds a certificate that can be reloaded from disk without restarting
+// the server.
+type certReloader struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load TLS certificate %q and key %q: %s", cr.certFile, cr.keyFile, err.Error())
+	}
+	cr.Lock()
+	cr.cert = &cert
+	cr.Unlock()
+	return nil
+}
+
+// reloadOnSignal reloads the certificate each time SIGHUP is received.  On error, the
+// previous certificate remains in use.
+func (cr *certReloader) reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := cr.reload(); err != nil {
+			dvid.Errorf("Keeping previous TLS certificate: %s\n", err.Error())
+		} else {
+			dvid.Infof("Reloaded TLS certificate %s\n", cr.certFile)
+		}
+	}
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.RLock()
+	defer cr.RUnlock()
+	return cr.cert, nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key as PEM files and returns
+// the DER-encoded certificate.
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s\n", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "dvid-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %s\n", err.Error())
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to encode key: %s\n", err.Error())
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Unable to write certificate: %s\n", err.Error())
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Unable to write key: %s\n", err.Error())
+	}
+	return der
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-tls")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Errorf("Expected error loading missing certificate\n")
+	}
+	if _, err := (TLSConfig{CertFile: certFile}).NewTLS(); err == nil {
+		t.Errorf("Expected error for certificate without key file\n")
+	}
+
+	served := func(cr *certReloader) []byte {
+		cert, err := cr.getCertificate(nil)
+		if err != nil || cert == nil || len(cert.Certificate) == 0 {
+			t.Fatalf("No certificate served: %v\n", err)
+		}
+		return cert.Certificate[0]
+	}
+
+	first := writeTestCert(t, certFile, keyFile, 1)
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Unable to load certificate: %s\n", err.Error())
+	}
+	if !bytes.Equal(served(cr), first) {
+		t.Errorf("Expected initial certificate to be served\n")
+	}
+
+	// A reload serves the new certificate.
+	second := writeTestCert(t, certFile, keyFile, 2)
+	if err := cr.reload(); err != nil {
+		t.Fatalf("Unable to reload certificate: %s\n", err.Error())
+	}
+	if !bytes.Equal(served(cr), second) {
+		t.Errorf("Expected new certificate after reload\n")
+	}
+
+	// A bad key pair is rejected and the previous certificate is kept.
+	writeTestCert(t, filepath.Join(dir, "other.pem"), keyFile, 3)
+	if err := cr.reload(); err == nil {
+		t.Errorf("Expected error reloading mismatched certificate and key\n")
+	}
+	if err := ioutil.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Unable to write certificate: %s\n", err.Error())
+	}
+	if err := cr.reload(); err == nil {
+		t.Errorf("Expected error reloading malformed certificate\n")
+	}
+	if !bytes.Equal(served(cr), second) {
+		t.Errorf("Expected previous certificate to be kept after failed reloads\n")
+	}
+}
@@ -6,14 +6,19 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -24,7 +29,6 @@ import (
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
-	"github.com/zenazn/goji/graceful"
 	"github.com/zenazn/goji/web"
 	"github.com/zenazn/goji/web/middleware"
 )
@@ -70,7 +74,40 @@ const WebHelp = `
 /serverhost:someport/api/...
 		</pre>
 		The online documentation doesn't show the server host prefixed to the "/api/..." URL,
-		but it is required.
+		but it is required.</p>
+
+		<p>Wherever a {uuid} is expected, a branch reference of the form {uuid}:{branch} can
+		be used instead, where {uuid} identifies any node in the repo.  It resolves to the
+		most recently created locked node on the branch, or with the query string
+		<i>head=open</i>, the most recently created unlocked node.  For example,
+		<code>/api/node/3f8c:master/grayscale/info?head=open</code>.</p>
+
+		<p>A repo's alias can also be used in place of a {uuid} and identifies the repo's
+		root node, e.g., <code>/api/repo/hemibrain/info</code>, so
+		<code>/api/node/hemibrain:master/grayscale/info</code> gives the latest locked node
+		on the master branch.  A UUID matching the string takes precedence.</p>
+
+		<p>Any POST or DELETE request can include an <i>Idempotency-Key</i> header with a
+		client-chosen key.  If the request succeeds, retries with the same key within 24 hours
+		return the stored response, marked by an <i>Idempotent-Replayed</i> header, instead of
+		applying the mutation again.  Reusing a key for a request with a different method, URL,
+		or body returns status 422,
+		and retrying while the original request is in progress returns status 409.
+
+		<p>Writes of /api/node endpoints can be made with the query string
+		<i>durability=sync</i> to sync storage before the request returns,
+		<i>durability=periodic</i> to sync storage within a configured interval, or
+		<i>durability=async</i> to leave syncing to the storage engine, e.g., for bulk
+		ingest.  Without it, the durability of the data instance or else the server default
+		is used.  The durability used is returned in the <i>X-Dvid-Durability</i> header of
+		non-GET responses.</p>
+
+		<p>Successful GET and HEAD responses from /api/node endpoints have caching headers set
+		by the lock state of the node.  Responses for versioned data of a locked node addressed
+		by UUID can't change and are cacheable for a year with <i>Cache-Control: immutable</i>,
+		and are marked private if authentication is enabled.  Responses for open nodes, branch
+		references, unversioned data, and partial results get <i>Cache-Control: no-cache</i>.
+		Error responses get <i>Cache-Control: no-store</i>.</p>
 
 		<h4>General commands</h4>
 
@@ -83,28 +120,202 @@ const WebHelp = `
 
 	Returns help for the given datatype.
 
+ GET  /api/spec
+ GET  /api/spec/{typename}
+
+	Returns an OpenAPI 3 document describing the HTTP endpoints of all datatypes, e.g.,
+	for client code generation, or JSON listing the endpoints declared by the given
+	datatype with their parameters and request and response schemas.  The data name in
+	each datatype's paths is a parameter named after the datatype, e.g.,
+	/api/node/{uuid}/{keyvalue}/key/{key}.
+
  GET  /api/load
 
-	Returns a JSON of server load statistics.
+	Returns a JSON of server load statistics.  If a block cache is configured, its size,
+	number of entries, and cumulative hits, misses, and evictions are included.  If
+	copy-on-write versions are configured, the number of versioned writes, the number
+	deduped against ancestor values, and the bytes saved are included.
+
+ GET  /metrics
+
+	Returns latency percentiles of HTTP requests by endpoint class and data instance in the
+	Prometheus text format, as reported by /api/server/latency.
 
  GET  /api/server/info
 
 	Returns JSON for server properties.
 
- GET  /api/server/types
+ GET  /api/server/status
+
+	Returns JSON describing the running server: build and datastore versions, start time
+	and uptime, active chunk handlers and throttled operations in flight, storage
+	throughput and cache statistics, memory use, and the name, URL, and version of each
+	loaded datatype.  Clients can use it to monitor the server and check compatibility.
+
+ GET  /api/server/types[?loaded=true]
+
+	Returns JSON with the names and URLs of datatypes used by repos.  With "loaded=true",
+	returns a JSON list of all datatypes compiled into the server or loaded from plugins
+	given in the server configuration, with each datatype's name, URL, version, and the
+	path of its plugin, if any.
 
-	Returns JSON with datatype names and their URLs.
+ GET  /api/server/logging
+ POST /api/server/logging
+
+	Returns or changes logging settings without restarting the server.  The GET returns
+	JSON with the global log level, the output format, and the levels of modules, which are
+	DVID package paths like "datatype/labels64" or parents like "datatype".  A module's
+	level applies to modules below it and overrides the global level.  The POST requires
+	an admin token when authentication is enabled and expects JSON like:
+
+	{"Level": "info", "Modules": {"datatype/labels64": "debug", "storage": ""}}
+
+	Levels are "debug", "info", "warning", "error", "critical", or "silent".  An empty
+	module level clears it.  Either property may be omitted.
+
+ GET  /api/server/settings
+ POST /api/server/settings
+
+	Returns or changes server limits without restarting the server.  The GET returns JSON
+	with the maximum number of chunk handlers, the maximum number of throttled operations,
+	and the block cache size in MB (0 if there is no block cache).  The POST requires an
+	admin token when authentication is enabled and expects JSON like:
+
+	{"ChunkHandlers": 16, "ThrottledOps": 2, "BlockCacheMB": 4096}
+
+	Any property may be omitted.  Limits can be from 1 to 1024.  Operations already
+	running are not interrupted, so lowered limits take effect as they finish.  The block
+	cache can only be resized if it was enabled in the server configuration.
+
+ GET  /api/server/jobs[?state=running]
+ GET  /api/server/jobs/{id}
+ GET  /api/server/jobs/{id}/log[?lines=100]
+ POST /api/server/jobs/{id}/cancel
+ DELETE /api/server/jobs/{id}
+
+	Lists background jobs, optionally only those in the given state, returns the status
+	of a job, returns the most recent lines of a job's log as text, cancels a running job,
+	or deletes the record of a finished job.  Job status is JSON giving the command, owner,
+	state ("running", "succeeded", "failed", "canceled", or "interrupted" if the server
+	stopped while it was running), percent progress, times, and any error or result.
+	Long-running RPC commands are run as jobs by adding "async=true", e.g.,
+	"dvid node {uuid} tiles generate async=true".  Jobs are kept for 7 days after they
+	finish.  When authentication is enabled, only a job's owner or an admin can access it.
+
+ GET  /api/server/tokens
+ POST /api/server/tokens
+ POST /api/server/tokens/{token}/roles
+ DELETE /api/server/tokens/{token}
+
+	Lists, creates, sets repo roles for, or revokes API tokens.  Only available when
+	authentication is enabled in the server configuration, and requires an admin token.
+	Tokens are passed to any request via an "Authorization: Bearer {token}" header or a
+	"token" query string.  A POST to create a token expects a JSON object like
+	{"Name": "tracer", "Admin": false, "Roles": {"{root uuid}": "write"}} and returns the new
+	token.  Each token needs a name that no other token uses.  Roles are "read" (GET/HEAD), "write" (modify data), or "admin" (modify the repo).
+	A POST of roles expects a JSON object mapping root UUIDs to roles.
+
+ POST /api/console/login
+ POST /api/console/logout
+ GET  /api/console/session
+
+	Logs the embedded web console in or out when authentication is enabled.  A login POST
+	expects a JSON object with an API token, e.g., {"Token": "..."}, and sets an HTTP-only
+	session cookie used in place of the token until logout or 8 hours without use.  It
+	returns JSON with the token's "Name", "Admin" status, and a "CSRFToken" that must be
+	sent in an X-Dvid-Csrf-Token header with every cookie-authenticated request other than
+	GET, HEAD, and OPTIONS, including logout.  A GET returns the same JSON for the current
+	session, e.g., after the console page is reloaded.  Revoking the API token ends its
+	console sessions.
+
+ GET  /api/server/proxy
+
+	Returns JSON listing the backend servers and their UUIDs if this server is running in
+	proxy mode.  In proxy mode, GET and HEAD requests for repos and nodes not held by this
+	server are forwarded to the backend holding the UUID.  If authentication is enabled,
+	forwarded requests need an API token with a read role for the backend repo, and
+	share tokens aren't accepted.  Client credentials are replaced by the proxy's token.
+
+ GET  /api/server/cluster
+
+	Returns JSON giving this server's URL, the coordinator, and the heartbeat and status of
+	each member if this server is running in cluster mode.  In cluster mode, data is
+	partitioned across the members by block, and requests that modify repos are sent to
+	the coordinator, which holds repo metadata.
+
+ GET  /api/server/replication
+
+	Returns JSON giving the primary, connection status, last applied mutation sequence
+	number, and number of mutations applied if this server is a read-only replica, or the
+	sequence number of the last settled mutation if this server has a mutation log.
+
+ GET  /api/server/replication/stream?after={seq}
+
+	Streams the encoded records of mutations after the given sequence number from this
+	server's mutation log, waiting for new mutations until the connection is closed.
+	Used by read-only replicas and requires an admin token if authentication is enabled.
+
+ GET  /api/server/quota
+
+	Returns JSON with the configured quota groups and the current usage of each client,
+	i.e., each API token or, for requests without a token, IP address.  When a client
+	exceeds the requests per second or concurrent heavy operations of its group, requests
+	get a 429 (Too Many Requests) status with a Retry-After header.  If authentication is
+	enabled, only admin tokens see all clients and others see only their own usage.
+
+ GET  /api/server/latency
+ DELETE /api/server/latency
+
+	Returns JSON with the count, mean, p50, p90, p99, and maximum latency in milliseconds
+	of HTTP requests since the given time, both by endpoint class and by data instance.
+	Requests to data instances are classed by method, datatype, and keyword, e.g.,
+	"GET labelblk/raw", and instances are named by the root UUID of their repo and their
+	data name.  Other requests are classed by method and API path without UUIDs.
+	Histograms persist across restarts.  A DELETE resets them and requires an admin token
+	if authentication is enabled.
+
+ GET  /api/server/usage
+
+	Returns JSON with the keys and bytes stored by each data instance, in order of
+	decreasing bytes, and by each of its versions, along with the extent of the instance's
+	keys in hexadecimal.  The report is cached from the last scan by the "usage scan"
+	command.  If "usage_tracking" is set in the server storage configuration, the keys and
+	bytes put and the keys and ranges deleted since the scan are included.  Instances no
+	longer in any repo have no name.  Requires an admin token if authentication is enabled.
+
+ GET  /api/server/audit[?user=<name>][&clientid=<token ID>][&instance=<data name>][&uuid=<UUID>][&since=<time>][&until=<time>][&limit=1000]
+
+	Returns JSON with the audit records of mutating requests, oldest first, if the audit
+	log is enabled in the server configuration.  Each POST, PUT, or DELETE that passes
+	authentication is recorded with its time, client (API token name or address without a
+	token), client ID (a hash identifying the API token, if any), method, endpoint, request
+	ID, UUID and data instance if any, response status, and the byte count and SHA-256
+	digest of its body.  Records can be selected by client name, client ID, data instance
+	name, UUID, and time range, with times in RFC3339 format or Unix seconds.  The UUID can
+	be partial if it uniquely identifies a node.  At most "limit" records are returned, up
+	to 100000.  If authentication is enabled, tokens other than admin tokens only see the
+	records made with that token.
 
  POST /api/repos
 
 	Creates a new repository.  Expects configuration data in JSON as the body of the POST.
 	Configuration is a JSON object with optional "alias" and "description" properties.
+	The alias must be valid and unused as described for the repo alias endpoint.
 	Returns the root UUID of the newly created repo in JSON object: {"Root": uuid}
 
  GET  /api/repos/info
 
 	Returns JSON for the repositories under management by this server.
 
+ POST /api/repos/import
+
+	Creates a repository from the JSON document returned by a repo's "export" endpoint,
+	possibly on another server.  The DAG keeps its UUIDs, and data instances are created
+	empty with local IDs of this server.  Fails if any of the UUIDs already exist here, the
+	repo's alias is used here, or a data instance's datatype version isn't compiled into
+	this server.  Returns the root
+	UUID of the imported repo in JSON object: {"Root": uuid}
+
  HEAD /api/repo/{uuid}
 
 	Returns 200 if a repo with given UUID is available.
@@ -114,14 +325,176 @@ const WebHelp = `
 	Returns JSON for just the repository with given root UUID.  The UUID string can be
 	shortened as long as it is uniquely identifiable across the managed repositories.
 
+ GET  /api/repo/{uuid}/push/estimate?data={name1},{name2}&roi={roi name}
+
+	Returns JSON with the number of key-value pairs and key and value bytes that a push of
+	the repository would transfer, per data instance and in total, by scanning stored data
+	without transferring it.  The optional "data" and "roi" query strings select data
+	instances and delimit blocks as in the "push" command.
+
+ GET  /api/repo/{uuid}/export
+
+	Returns a portable JSON document describing the repository with given root UUID: its
+	version DAG, the definitions of its data instances, and the datatype versions they
+	require.  No data is included.  The document can be POSTed to "/api/repos/import" on
+	another server as the first step of migrating the repository.
+
  POST /api/repo/{uuid}/lock
 
 	Locks the node (version) with given UUID.  This is required before a version can 
-	be branched or pushed to a remote server.
+	be branched or pushed to a remote server.  Locking is a commit without a message.
+
+ POST /api/node/{uuid}/commit
+ GET  /api/node/{uuid}/commit
+
+	Commits (locks) the node with given UUID with a message given in the JSON body, e.g.,
+	{"message": "finished proofreading of region 3"}.  Once committed, every request that
+	would modify versioned data of the node returns status 423 (Locked), as does committing
+	it again.  Requests other than GET or HEAD modify data unless the datatype declares
+	otherwise, e.g., the roi "ptquery" and voxels "flush" endpoints.  Children can only be
+	created from committed nodes.  The message, committer, and commit time are recorded in
+	the node's log and repo info.  Returns or, for GET, retrieves JSON describing the commit:
+
+	{
+		"UUID": "3f8c...",
+		"Locked": true,
+		"Message": "finished proofreading of region 3",
+		"Committer": "alice",
+		"Committed": "2015-04-01T15:04:05Z",
+		"Log": ["Committed by alice: finished proofreading of region 3"]
+	}
+
+ POST /api/repo/{uuid}/alias
+
+	Sets the alias of the repository, given in the JSON body, e.g., {"alias": "hemibrain"}.
+	An empty alias removes it.  Aliases are unique across repos, can only have letters,
+	digits, '-', '_', and '.', and can't be all hexadecimal digits.  Returns the root
+	UUID and alias in JSON object: {"Root": uuid, "Alias": alias}
+
+ GET  /api/repo/{uuid}/quota
+ POST /api/repo/{uuid}/quota
+ POST /api/repo/{uuid}/quota/override
+
+	Gets or sets the storage quota of the repository, which limits the bytes stored by all
+	its data instances.  The quota is set in bytes by a JSON body like
+	{"maxbytes": 1099511627776}, where zero removes the limit.  Once the repo's stored
+	bytes reach the quota, requests that may modify its data instances return status 507
+	(Insufficient Storage).  Stored bytes are taken from the storage usage
+	report, i.e., the last "usage scan" plus bytes written since then if usage tracking is
+	enabled, so rewrites count again until the next scan.  An admin can accept writes over
+	the quota with {"override": true} on the override endpoint and restore enforcement
+	with {"override": false}.  POSTs require an admin API token if authentication is
+	enabled, since the admin role for the repo isn't enough.  Each request returns JSON like:
+
+	{"MaxBytes": 1099511627776, "Override": false, "UsedBytes": 734003200, "Exceeded": false}
+
+ GET  /api/repo/{uuid}/metadata
+ POST /api/repo/{uuid}/note
+ POST /api/repo/{uuid}/tags
+ POST /api/repo/{uuid}/provenance
+
+ GET  /api/node/{uuid}/metadata
+ POST /api/node/{uuid}/note
+ POST /api/node/{uuid}/tags
+ POST /api/node/{uuid}/provenance
+
+	Gets or changes the descriptive metadata of the repo or node with given UUID, which is
+	also given in the repo info JSON.  Each POST returns the changed metadata as a GET does:
+
+	{
+		"Note": "Initial load of the optic lobe",
+		"Tags": {"species": "fly", "region": "optic lobe"},
+		"Provenance": [
+			{"Time": "2015-04-01T15:04:05Z", "User": "alice", "Operation": "load",
+			 "Detail": "grayscale from /data/optic/*.png"}
+		]
+	}
+
+	The note is set by a JSON body like {"note": "Initial load of the optic lobe"}.  Tags
+	are set by a JSON object of tags, where a null value deletes a tag, e.g.,
+	{"region": "optic lobe", "draft": null}.  The provenance log is append-only, and an
+	entry with the requestor as user and the current time is added by a JSON body like
+	{"operation": "load", "detail": "grayscale from /data/optic/*.png"}.  Loads through
+	the command line are added to the provenance log of their node.  Node metadata can be
+	changed even if the node is locked.
 
  POST /api/repo/{uuid}/branch
 
-	Creates a new child node (version) of the node with given UUID.
+	Creates a new child node (version) of the node with given UUID.  The child stays on
+	its parent's branch unless the optional JSON body names a new branch, e.g.,
+	{"branch": "proofreading"}.  The root node is on the "master" branch.
+
+ POST /api/repo/{uuid}/merge
+
+	Creates a new node (version) whose parents are the locked node with given UUID and
+	the locked nodes given in the JSON body, e.g., {"parents": ["3f8c", "a7b2"], "note": "..."}.
+	The merged node is on the branch of the node with given UUID, whose data takes
+	precedence for data that can't be merged.  Keyvalue data changed in only one parent
+	since the common ancestor is merged.  If more than one parent changed the same data,
+	no node is created and status 409 is returned with JSON giving the conflicts for each
+	data instance.  Returns the UUID of the merged node as with branch.
+
+ POST /api/repo/{uuid}/session
+
+	Creates a session pinned to the node with given UUID, which must be locked, and returns
+	JSON describing the session including its "Token".  A branch reference resolves to the
+	latest locked node on the branch when the session is created.  Any GET or HEAD request
+	on nodes of the same repo with the query string "session={token}" reads the pinned node
+	instead of the node in the URL, so long-running downloads see a consistent version
+	even if the branch advances.  The pinned UUID is returned in the X-Dvid-Session-Uuid
+	response header.  Sessions expire after being unused for 24 hours or the number of
+	seconds given by an optional JSON body, e.g., {"idle": 3600}.  Requests with an expired
+	or closed session return status 410.  Only requires read access to the repo.
+
+ GET  /api/sessions
+ DELETE /api/sessions/{token}
+
+	Lists all open sessions or force-closes a session.  Requires an admin token if
+	authentication is enabled.
+
+ POST /api/repo/{uuid}/share
+
+	Creates a read-only sharing link for the node with given UUID, which must be locked.
+	Returns JSON describing the share including its "Token", which is a signed capability
+	that can be given to collaborators without an API token.  Any GET or HEAD request on
+	that node with the query string "share={token}" is allowed, e.g.,
+	/api/node/{uuid}/segmentation/raw/0_1/512_512/256_256_100?share={token}.  An optional
+	JSON body can restrict the share to some data instances and give the seconds until the
+	token expires (default 7 days), e.g., {"instances": ["segmentation"], "expires": 86400,
+	"note": "for reviewers"}.  Shares restricted to instances don't allow repo-level
+	requests.  Requires the admin role for the repo if authentication is enabled.
+
+ DELETE /api/shares
+
+	Revokes all sharing links by replacing the secret used to sign them.  Requires an admin
+	token if authentication is enabled.
+
+ POST /api/repo/{uuid}/commit
+ GET  /api/repo/{uuid}/commit/{id}
+ POST /api/repo/{uuid}/commit/{id}/undo
+
+	Applies mutations of several data instances in the unlocked node with given UUID as
+	one atomic commit, e.g., an ROI update together with keyvalue state, and returns JSON
+	describing the commit including its "ID".  Expects a JSON body:
+
+	{
+		"Note": "split of body 17",
+		"Mutations": [
+			{"Instance": "roi1", "Action": "add", "Args": {"Spans": [[10, 20, 30, 40]]}},
+			{"Instance": "state", "Action": "put", "Args": {"Key": "status", "Value": {"done": true}}}
+		]
+	}
+
+	Either all mutations are applied or none are.  Keyvalue instances support "put" with a
+	JSON "Value" or base64-encoded "Data", and "delete" of a "Key".  ROI instances support
+	"replace", "add", and "subtract" of "Spans".  Voxels instances, e.g., grayscale8,
+	support "blocks" with "Ops" like [{"Key": "10_20_30", "Value": <base64 block>},
+	{"Key": "11_20_30", "Delete": true}], where each value is an uncompressed block.
+	Labels64 instances can't be part of commits since their label indices wouldn't be
+	updated, and neither can other datatypes.  A GET returns a commit, and POSTing to
+	undo restores all data written by the commit as a unit.  An undo is refused if any of
+	that data has changed since the commit.  Since the prior and new values written by
+	every commit are kept for undo, commits writing more than 64 MB of values are rejected.
 
  POST /api/repo/{uuid}/instance
 
@@ -131,6 +504,16 @@ const WebHelp = `
 	are required: "typename" should be set to the type name of the new instance, and
 	"dataname" should be set to the desired name of the new instance.
 
+ GET  /api/repo/{uuid}/{dataname}/sync
+ POST /api/repo/{uuid}/{dataname}/sync
+
+	Gets or sets the data instances in the repo that the named data instance is synced
+	with, e.g., {"Syncs": ["segmentation"]}.  A synced data instance receives the mutations
+	of the data it's synced with and updates itself, e.g., a labelgraph merges vertices when
+	the labels64 data it's synced with merges labels.  A POST replaces all syncs, so an
+	empty list removes them.  Syncs can also be set with the "Sync" property of a new
+	instance's configuration, e.g., "Sync": "segmentation".
+
 	
  DELETE /api/repo/{uuid}/{dataname}?imsure=true
 
@@ -175,6 +558,9 @@ const (
 
 	// The relative URL path to our Level 2 REST API
 	WebAPIPath = "/api/" + WebAPIVersion
+
+	// DurabilityHeader gives the durability of writes made by a data instance request.
+	DurabilityHeader = "X-Dvid-Durability"
 )
 
 type WebMux struct {
@@ -198,21 +584,26 @@ func ServeSingleHTTP(w http.ResponseWriter, r *http.Request) {
 		initRoutes()
 	}
 
-	// Allow cross-origin resource sharing.
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-
 	webMux.ServeHTTP(w, r)
 }
 
 // Listen and serve HTTP requests using address and don't let stay-alive
-// connections hog goroutines for more than an hour.
+// connections hog goroutines for more than HTTPIdleTimeout.
 // See for discussion:
 // http://stackoverflow.com/questions/10971800/golang-http-server-leaving-open-goroutines
-func serveHttp(address, clientDir string) {
+// If tlsConfig is non-nil, the server only accepts HTTPS connections and HTTP/2
+// can be negotiated.
+func serveHttp(address, clientDir string, tlsConfig *tls.Config) {
 	var mode string
 	if readonly {
 		mode = " (read-only mode)"
 	}
+	if tlsConfig != nil {
+		mode += " (TLS)"
+		if HTTP2 {
+			mode += " (HTTP/2)"
+		}
+	}
 	dvid.Infof("Web server listening at %s%s ...\n", address, mode)
 	if !webMux.routesSetup {
 		initRoutes()
@@ -222,11 +613,20 @@ func serveHttp(address, clientDir string) {
 	// This allows packages like expvar to continue working as expected.  (From goji.go)
 	http.Handle("/", webMux)
 
-	graceful.HandleSignals()
-	if err := graceful.ListenAndServe(address, http.DefaultServeMux); err != nil {
+	srv, tlsConfig := newHTTPServer(http.DefaultServeMux, tlsConfig)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	httpServerMu.Lock()
+	httpServer = srv
+	httpServerMu.Unlock()
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
-	graceful.Wait()
 }
 
 // High-level switchboard for DVID HTTP API.
@@ -242,13 +642,32 @@ func initRoutes() {
 	webMux.Handle("/api/load", silentMux)
 	silentMux.Use(corsHandler)
 	silentMux.Get("/api/load", loadHandler)
+	webMux.Handle("/metrics", silentMux)
+	silentMux.Get("/metrics", metricsHandler)
+
+	// Internal endpoints for cluster members.
+	clusterMux := web.New()
+	webMux.Handle("/api/cluster/*", clusterMux)
+	clusterMux.Use(clusterAuth)
+	clusterMux.Post("/api/cluster/gossip", clusterGossipHandler)
+	clusterMux.Get("/api/cluster/kv", clusterGetHandler)
+	clusterMux.Get("/api/cluster/range", clusterRangeHandler)
+	clusterMux.Delete("/api/cluster/range", clusterRangeHandler)
+	clusterMux.Post("/api/cluster/write", clusterWriteHandler)
 
 	mainMux := web.New()
 	webMux.Handle("/*", mainMux)
 	mainMux.Use(middleware.Logger)
+	mainMux.Use(latencyHandler)
 	mainMux.Use(middleware.AutomaticOptions)
 	mainMux.Use(recoverHandler)
 	mainMux.Use(corsHandler)
+	mainMux.Use(authHandler)
+	mainMux.Use(quotaHandler)
+	mainMux.Use(clusterHandler)
+	mainMux.Use(proxyHandler)
+	mainMux.Use(idempotencyHandler)
+	mainMux.Use(auditHandler)
 
 	// Handle RAML interface
 	mainMux.Get("/interface", interfaceHandler)
@@ -257,14 +676,55 @@ func initRoutes() {
 	mainMux.Get("/api/help", helpHandler)
 	mainMux.Get("/api/help/", helpHandler)
 	mainMux.Get("/api/help/:typename", typehelpHandler)
+	mainMux.Get("/api/spec", specHandler)
+	mainMux.Get("/api/spec/:typename", typeSpecHandler)
 
 	mainMux.Get("/api/server/info", serverInfoHandler)
 	mainMux.Get("/api/server/info/", serverInfoHandler)
+	mainMux.Get("/api/server/status", serverStatusHandler)
+	mainMux.Get("/api/server/logging", loggingGetHandler)
+	mainMux.Post("/api/server/logging", loggingPostHandler)
+	mainMux.Get("/api/server/settings", settingsGetHandler)
+	mainMux.Post("/api/server/settings", settingsPostHandler)
+	mainMux.Get("/api/server/jobs", jobsGetHandler)
+	mainMux.Get("/api/server/jobs/:id", jobGetHandler)
+	mainMux.Get("/api/server/jobs/:id/log", jobLogHandler)
+	mainMux.Post("/api/server/jobs/:id/cancel", jobCancelHandler)
+	mainMux.Delete("/api/server/jobs/:id", jobDeleteHandler)
 	mainMux.Get("/api/server/types", serverTypesHandler)
 	mainMux.Get("/api/server/types/", serverTypesHandler)
 
+	mainMux.Get("/api/server/proxy", proxyInfoHandler)
+	mainMux.Get("/api/server/cluster", clusterInfoHandler)
+
+	mainMux.Get("/api/server/replication", replicationInfoHandler)
+	mainMux.Get("/api/server/replication/stream", replicationStreamHandler)
+
+	mainMux.Get("/api/server/quota", quotaGetHandler)
+
+	mainMux.Get("/api/server/latency", latencyGetHandler)
+
+	mainMux.Get("/api/server/usage", storageUsageHandler)
+	mainMux.Get("/api/server/audit", auditGetHandler)
+	mainMux.Delete("/api/server/latency", latencyDeleteHandler)
+
+	mainMux.Get("/api/server/tokens", tokensGetHandler)
+	mainMux.Post("/api/server/tokens", tokensPostHandler)
+	mainMux.Post("/api/server/tokens/:token/roles", tokenRolesHandler)
+	mainMux.Delete("/api/server/tokens/:token", tokenDeleteHandler)
+
+	mainMux.Post("/api/console/login", consoleLoginHandler)
+	mainMux.Post("/api/console/logout", consoleLogoutHandler)
+	mainMux.Get("/api/console/session", consoleSessionHandler)
+
+	mainMux.Get("/api/sessions", sessionsGetHandler)
+	mainMux.Delete("/api/sessions/:token", sessionDeleteHandler)
+
+	mainMux.Delete("/api/shares", sharesDeleteHandler)
+
 	if !readonly {
 		mainMux.Post("/api/repos", reposPostHandler)
+		mainMux.Post("/api/repos/import", reposImportHandler)
 	}
 	mainMux.Get("/api/repos/info", reposInfoHandler)
 
@@ -272,17 +732,51 @@ func initRoutes() {
 	mainMux.Handle("/api/repo/:uuid", repoMux)
 	mainMux.Handle("/api/repo/:uuid/*", repoMux)
 	repoMux.Use(repoSelector)
+	repoMux.Use(repoAuthorizer)
 	repoMux.Head("/api/repo/:uuid", repoHeadHandler)
 	repoMux.Get("/api/repo/:uuid/info", repoInfoHandler)
+	repoMux.Get("/api/repo/:uuid/push/estimate", repoPushEstimateHandler)
+	repoMux.Get("/api/repo/:uuid/export", repoExportHandler)
 	repoMux.Post("/api/repo/:uuid/instance", repoNewDataHandler)
 	repoMux.Post("/api/repo/:uuid/lock", repoLockHandler)
+	repoMux.Post("/api/repo/:uuid/alias", repoAliasHandler)
+	repoMux.Get("/api/repo/:uuid/quota", repoQuotaGetHandler)
+	repoMux.Post("/api/repo/:uuid/quota", repoQuotaPostHandler)
+	repoMux.Post("/api/repo/:uuid/quota/override", repoQuotaOverrideHandler)
+	repoMux.Get("/api/repo/:uuid/metadata", metadataGetHandler(repoMetadata))
+	repoMux.Post("/api/repo/:uuid/note", metadataNoteHandler(repoMetadata))
+	repoMux.Post("/api/repo/:uuid/tags", metadataTagsHandler(repoMetadata))
+	repoMux.Post("/api/repo/:uuid/provenance", metadataProvenanceHandler(repoMetadata))
 	repoMux.Post("/api/repo/:uuid/branch", repoBranchHandler)
+	repoMux.Post("/api/repo/:uuid/merge", repoMergeHandler)
+	repoMux.Post("/api/repo/:uuid/session", repoSessionHandler)
+	repoMux.Post("/api/repo/:uuid/share", repoShareHandler)
+	repoMux.Post("/api/repo/:uuid/commit", repoCommitHandler)
+	repoMux.Get("/api/repo/:uuid/commit/:id", repoGetCommitHandler)
+	repoMux.Post("/api/repo/:uuid/commit/:id/undo", repoUndoCommitHandler)
+	repoMux.Get("/api/repo/:uuid/:dataname/sync", repoGetSyncHandler)
+	repoMux.Post("/api/repo/:uuid/:dataname/sync", repoSyncHandler)
 	repoMux.Delete("/api/repo/:uuid/:dataname", repoDeleteHandler)
 
+	nodeMux := web.New()
+	for _, endpoint := range []string{"commit", "metadata", "note", "tags", "provenance"} {
+		mainMux.Handle("/api/node/:uuid/"+endpoint, nodeMux)
+	}
+	nodeMux.Use(repoSelector)
+	nodeMux.Use(repoAuthorizer)
+	nodeMux.Get("/api/node/:uuid/commit", nodeGetCommitHandler)
+	nodeMux.Post("/api/node/:uuid/commit", nodeCommitHandler)
+	nodeMux.Get("/api/node/:uuid/metadata", metadataGetHandler(nodeMetadata))
+	nodeMux.Post("/api/node/:uuid/note", metadataNoteHandler(nodeMetadata))
+	nodeMux.Post("/api/node/:uuid/tags", metadataTagsHandler(nodeMetadata))
+	nodeMux.Post("/api/node/:uuid/provenance", metadataProvenanceHandler(nodeMetadata))
+
 	instanceMux := web.New()
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword", instanceMux)
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword/*", instanceMux)
 	instanceMux.Use(repoSelector)
+	instanceMux.Use(repoAuthorizer)
+	instanceMux.Use(cacheHeaderHandler)
 	instanceMux.Use(instanceSelector)
 	instanceMux.NotFound(NotFound)
 
@@ -299,6 +793,10 @@ func recoverHandler(c *web.C, h http.Handler) http.Handler {
 
 		defer func() {
 			if err := recover(); err != nil {
+				// Let the server abort the connection without reporting a crash.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				buf := make([]byte, 1<<16)
 				size := runtime.Stack(buf, false)
 				stackTrace := string(buf[0:size])
@@ -325,9 +823,17 @@ func NotFound(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, errorMsg, http.StatusNotFound)
 }
 
+// LockedNode responds with status 423 to a request that would modify a committed node.
+func LockedNode(w http.ResponseWriter, r *http.Request, uuid dvid.UUID) {
+	errorMsg := fmt.Sprintf("ERROR: Node %s is committed and can't be modified (%s).", uuid, r.URL.Path)
+	dvid.Infof(errorMsg)
+	http.Error(w, errorMsg, http.StatusLocked)
+}
+
+// BadRequest responds with status 400 and an error message formatted with any args.
 func BadRequest(w http.ResponseWriter, r *http.Request, message string, args ...interface{}) {
 	if len(args) > 0 {
-		message = fmt.Sprintf(message, args)
+		message = fmt.Sprintf(message, args...)
 	}
 	errorMsg := fmt.Sprintf("ERROR: %s (%s).", message, r.URL.Path)
 	dvid.Errorf(errorMsg)
@@ -345,30 +851,30 @@ func DecodeJSON(r *http.Request) (dvid.Config, error) {
 
 // ---- Middleware -------------
 
-// corsHandler adds CORS support via header
-func corsHandler(c *web.C, h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		// Allow cross-origin resource sharing.
-		w.Header().Add("Access-Control-Allow-Origin", "*")
-
-		h.ServeHTTP(w, r)
-	}
-	return http.HandlerFunc(fn)
-}
-
 // repoSelector retrieves the particular repo from a potentially partial string that uniquely
 // identifies the repo.
 func repoSelector(c *web.C, h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		action := strings.ToLower(r.Method)
-		if readonly && action != "get" && action != "head" {
+		if readonly && action != "get" && action != "head" && !isSessionRequest(r) {
 			BadRequest(w, r, "Server in read-only mode and will only accept GET and HEAD requests")
 			return
 		}
 
+		// A branch reference resolves to the latest locked node unless "head=open" is given.
+		var openHead bool
+		switch head := r.URL.Query().Get("head"); head {
+		case "", "committed", "locked":
+		case "open":
+			openHead = true
+		default:
+			BadRequest(w, r, "Bad head option %q: must be 'committed' or 'open'", head)
+			return
+		}
+
 		var err error
 		var uuid dvid.UUID
-		if uuid, c.Env["versionID"], err = datastore.MatchingUUID(c.URLParams["uuid"]); err != nil {
+		if uuid, c.Env["versionID"], err = datastore.ResolveVersion(c.URLParams["uuid"], openHead); err != nil {
 			BadRequest(w, r, err.Error())
 			return
 		}
@@ -376,7 +882,7 @@ func repoSelector(c *web.C, h http.Handler) http.Handler {
 		c.Env["repo"], err = datastore.RepoFromUUID(uuid)
 		if err != nil {
 			BadRequest(w, r, err.Error())
-		} else {
+		} else if pinSession(c, w, r) {
 			h.ServeHTTP(w, r)
 		}
 	}
@@ -411,6 +917,26 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 			return
 		}
 
+		// Versioned data of a committed node is immutable, so requests to endpoints the
+		// data declares as writes are rejected.
+		writes := datastore.IsWriteRequest(dataservice, r.Method, c.URLParams["keyword"])
+		if writes && dataservice.Versioned() {
+			locked, err := repo.Locked(uuid)
+			if err != nil {
+				BadRequest(w, r, err.Error())
+				return
+			}
+			if locked {
+				LockedNode(w, r, uuid)
+				return
+			}
+		}
+
+		// Writes are rejected once the repo's storage quota is reached.
+		if writes && !checkStorageQuota(w, r, repo) {
+			return
+		}
+
 		// Handle DVID-wide query string commands like non-interactive call designations
 		queryValues := r.URL.Query()
 
@@ -420,13 +946,53 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 			GotInteractiveRequest()
 		}
 
-		// Construct the Context
-		ctx := datastore.NewServerContext(context.Background(), repo, versionID)
+		// Writes can request a durability, which is reported for requests that may write.
+		durability, err := storage.ParseDurability(queryValues.Get("durability"))
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		if writes {
+			effective := storage.ResolveDurability(dataservice.InstanceID(), durability)
+			w.Header().Set(DurabilityHeader, effective.String())
+		}
+
+		setLatencyLabels(c, r, repo, dataservice)
+		setAuditInstance(c, dataservice)
+
+		// Construct the Context, which is canceled if the client goes away so storage
+		// range scans and chunk processing for the request stop early.
+		ctx, cancel := requestContext(w)
+		defer cancel()
+		ctx = datastore.NewServerContext(ctx, repo, versionID)
+		ctx = datastore.WithClient(ctx, requestClient(r))
+		ctx = datastore.WithRequestID(ctx, middleware.GetReqID(*c))
+		ctx = datastore.WithDurability(ctx, durability)
 		dataservice.ServeHTTP(ctx, w, r)
 	}
 	return http.HandlerFunc(fn)
 }
 
+// requestContext returns a context that is canceled when the client of a request
+// disconnects or when the returned cancel function is called after the request is done.
+func requestContext(w http.ResponseWriter) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	notifier, ok := w.(http.CloseNotifier)
+	if !ok {
+		return ctx, cancel
+	}
+	closed := notifier.CloseNotify()
+	go func() {
+		select {
+		case <-closed:
+			dvid.Debugf("Client disconnected, canceling request\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 // ---- Function types that fulfill http.Handler.  How can a bare function satisfy an interface?
 //      See http://www.onebigfluke.com/2014/04/gos-power-is-in-emergent-behavior.html
 
@@ -500,7 +1066,7 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func loadHandler(w http.ResponseWriter, r *http.Request) {
-	m, err := json.Marshal(map[string]int{
+	load := map[string]int{
 		"file bytes read":     storage.FileBytesReadPerSec,
 		"file bytes written":  storage.FileBytesWrittenPerSec,
 		"key bytes read":      storage.StoreKeyBytesReadPerSec,
@@ -511,7 +1077,20 @@ func loadHandler(w http.ResponseWriter, r *http.Request) {
 		"PUT requests":        storage.PutsPerSec,
 		"handlers active":     int(100 * ActiveHandlers / MaxChunkHandlers),
 		"goroutines":          runtime.NumGoroutine(),
-	})
+	}
+	if stats, found := storage.BlockCacheStatistics(); found {
+		load["block cache bytes"] = stats.Bytes
+		load["block cache entries"] = stats.Entries
+		load["block cache hits"] = int(stats.Hits)
+		load["block cache misses"] = int(stats.Misses)
+		load["block cache evictions"] = int(stats.Evictions)
+	}
+	if stats, found := storage.CopyOnWriteStatistics(); found {
+		load["copy-on-write writes"] = int(stats.Writes)
+		load["copy-on-write deduped"] = int(stats.Deduped)
+		load["copy-on-write bytes saved"] = int(stats.BytesSaved)
+	}
+	m, err := json.Marshal(load)
 	if err != nil {
 		BadRequest(w, r, err.Error())
 		return
@@ -520,6 +1099,24 @@ func loadHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, string(m))
 }
 
+func storageUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if AuthEnabled() && !requireAdmin(w, r) {
+		return
+	}
+	report, err := datastore.StorageUsage(false)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	m, err := json.Marshal(report)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(m)
+}
+
 func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
 	jsonStr, err := AboutJSON()
 	if err != nil {
@@ -530,7 +1127,25 @@ func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonStr)
 }
 
+type typeSourcesByName []datastore.TypeSource
+
+func (s typeSourcesByName) Len() int           { return len(s) }
+func (s typeSourcesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s typeSourcesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
 func serverTypesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("loaded") == "true" {
+		types := datastore.AvailableTypes()
+		sort.Sort(typeSourcesByName(types))
+		jsonBytes, err := json.Marshal(types)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonBytes)
+		return
+	}
 	jsonMap := make(map[dvid.TypeString]string)
 	typemap, err := datastore.Types()
 	if err != nil {
@@ -583,6 +1198,22 @@ func reposPostHandler(w http.ResponseWriter, r *http.Request) {
 	repo, err := datastore.NewRepo(alias, description)
 	if err != nil {
 		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "Root", repo.RootUUID())
+}
+
+func reposImportHandler(w http.ResponseWriter, r *http.Request) {
+	var doc datastore.RepoExport
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Error decoding POSTed repo export: %s", err.Error()))
+		return
+	}
+	repo, err := datastore.ImportRepo(&doc)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, "{%q: %q}", "Root", repo.RootUUID())
@@ -605,6 +1236,44 @@ func repoInfoHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, string(jsonBytes))
 }
 
+func repoPushEstimateHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	config := dvid.NewConfig()
+	for _, setting := range []string{"data", "roi"} {
+		if value := r.URL.Query().Get(setting); value != "" {
+			config.Set(setting, value)
+		}
+	}
+	estimate, err := datastore.EstimatePush(repo, config)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(estimate)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func repoExportHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	doc, err := datastore.ExportRepo(repo)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(doc)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
 func repoDeleteHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	imsure := queryValues.Get("imsure")
@@ -666,15 +1335,50 @@ func repoNewDataHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "{%q: 'Added %s [%s] to node %s'}", "result", dataname, typename, repo.RootUUID())
 }
 
-func repoLockHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+func repoGetSyncHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	repo := (c.Env["repo"]).(datastore.Repo)
-	uuid, _, err := datastore.MatchingUUID(c.URLParams["uuid"])
+	dataservice, err := repo.GetDataByName(dvid.DataString(c.URLParams["dataname"]))
 	if err != nil {
 		BadRequest(w, r, err.Error())
 		return
 	}
+	syncs := []dvid.DataString{}
+	if syncer, ok := dataservice.(datastore.Syncer); ok && syncer.SyncedData() != nil {
+		syncs = syncer.SyncedData()
+	}
+	jsonBytes, err := json.Marshal(struct{ Syncs []dvid.DataString }{syncs})
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func repoSyncHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	dataname := dvid.DataString(c.URLParams["dataname"])
+
+	var config struct {
+		Syncs []dvid.DataString
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err.Error()))
+		return
+	}
+	if err := datastore.SetSyncs(repo, dataname, config.Syncs); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Set syncs of data %q to %v\n", dataname, config.Syncs)
+}
+
+func repoLockHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
 
-	err = repo.Lock(uuid)
+	err := repo.Lock(uuid)
 	if err != nil {
 		BadRequest(w, r, err.Error())
 	} else {
@@ -683,15 +1387,228 @@ func repoLockHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func repoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+func nodeGetCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	repo := (c.Env["repo"]).(datastore.Repo)
-	uuid, _, err := datastore.MatchingUUID(c.URLParams["uuid"])
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+	writeNodeCommit(w, r, repo, uuid)
+}
+
+func nodeCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+
+	var config struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+		return
+	}
+	if config.Message == "" {
+		BadRequest(w, r, "POST on node commit endpoint requires a 'message' property")
+		return
+	}
+	err := repo.CommitNode(uuid, config.Message, requestClient(r))
+	if err == datastore.ErrModifyLockedNode {
+		LockedNode(w, r, uuid)
+		return
+	}
 	if err != nil {
 		BadRequest(w, r, err.Error())
 		return
 	}
+	writeNodeCommit(w, r, repo, uuid)
+}
 
-	newuuid, err := repo.NewVersion(uuid)
+func writeNodeCommit(w http.ResponseWriter, r *http.Request, repo datastore.Repo, uuid dvid.UUID) {
+	commit, err := repo.GetNodeCommit(uuid)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(commit)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func repoAliasHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+
+	var config struct {
+		Alias *string `json:"alias"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err.Error()))
+		return
+	}
+	if config.Alias == nil {
+		BadRequest(w, r, "POST on repo alias endpoint requires an 'alias' property")
+		return
+	}
+	if err := repo.SetAlias(*config.Alias); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q, %q: %q}", "Root", repo.RootUUID(), "Alias", repo.GetAlias())
+}
+
+func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+
+	var config struct {
+		Parents []string `json:"parents"`
+		Note    string   `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Malformed JSON request in body: %s", err.Error()))
+		return
+	}
+	parents := []dvid.UUID{uuid}
+	for _, uuidStr := range config.Parents {
+		parent, _, err := datastore.MatchingUUID(uuidStr)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		parents = append(parents, parent)
+	}
+
+	newuuid, err := repo.Merge(parents, config.Note)
+	if conflictErr, ok := err.(*datastore.MergeConflictError); ok {
+		jsonBytes, err := json.Marshal(struct {
+			Error     string
+			Conflicts map[dvid.DataString][]string
+		}{
+			conflictErr.Error(),
+			conflictErr.Conflicts,
+		})
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write(jsonBytes)
+		return
+	}
+	if err != nil {
+		BadRequest(w, r, err.Error())
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{%q: %q}", "Child", newuuid)
+	}
+}
+
+func repoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+
+	var config struct {
+		Note      string
+		Mutations []datastore.Mutation
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+		return
+	}
+	record, err := datastore.Commit(uuid, config.Note, config.Mutations)
+	if err == datastore.ErrModifyLockedNode {
+		LockedNode(w, r, uuid)
+		return
+	}
+	if _, ok := err.(*datastore.QuotaExceededError); ok {
+		InsufficientStorage(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	writeCommitRecord(w, r, record)
+}
+
+// repoCommit returns the commit given in the URL, which must have been made on a node of
+// the repo.
+func repoCommit(c web.C) (*datastore.CommitRecord, error) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	id, err := strconv.ParseUint(c.URLParams["id"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Bad commit ID %q", c.URLParams["id"])
+	}
+	record, err := datastore.GetCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	commitRepo, err := datastore.RepoFromUUID(record.UUID)
+	if err != nil || commitRepo.RootUUID() != repo.RootUUID() {
+		return nil, fmt.Errorf("Commit %d was not made in this repo", id)
+	}
+	return record, nil
+}
+
+func repoGetCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	record, err := repoCommit(c)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	writeCommitRecord(w, r, record)
+}
+
+func repoUndoCommitHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	record, err := repoCommit(c)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	if err := datastore.UndoCommit(record.ID); err == datastore.ErrModifyLockedNode {
+		LockedNode(w, r, record.UUID)
+		return
+	} else if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Undid commit %d on node %s.\n", record.ID, record.UUID)
+}
+
+func writeCommitRecord(w http.ResponseWriter, r *http.Request, record *datastore.CommitRecord) {
+	jsonBytes, err := json.Marshal(record)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func repoBranchHandler(c web.C, w http.ResponseWriter, r *http.Request) {
+	repo := (c.Env["repo"]).(datastore.Repo)
+	uuid := (c.Env["uuid"]).(dvid.UUID)
+
+	// An optional JSON body can name a new branch.
+	var config struct {
+		Branch string `json:"branch"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil && err != io.EOF {
+			BadRequest(w, r, "Malformed JSON request in body: %s", err.Error())
+			return
+		}
+	}
+
+	var newuuid dvid.UUID
+	var err error
+	if config.Branch != "" {
+		newuuid, err = repo.NewBranchVersion(uuid, config.Branch)
+	} else {
+		newuuid, err = repo.NewVersion(uuid)
+	}
 	if err != nil {
 		BadRequest(w, r, err.Error())
 	} else {
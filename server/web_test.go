@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeNotifyRecorder is a response recorder whose client can disconnect.
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (w *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return w.closed
+}
+
+func TestRequestContext(t *testing.T) {
+	// Contexts are canceled when the client disconnects.
+	w := &closeNotifyRecorder{httptest.NewRecorder(), make(chan bool, 1)}
+	ctx, cancel := requestContext(w)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatalf("Request context canceled before client disconnected\n")
+	default:
+	}
+	w.closed <- true
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Request context not canceled after client disconnected\n")
+	}
+
+	// ... and when the request is done, even if the client can't be watched.
+	ctx, cancel = requestContext(httptest.NewRecorder())
+	cancel()
+	select {
+	case <-ctx.Done():
+	default:
+		t.Errorf("Request context not canceled after request finished\n")
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/api/server/audit", nil)
+	w := httptest.NewRecorder()
+	BadRequest(w, r, "Bad limit %q: must be from 1 to %d", "x", 100)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d\n", http.StatusBadRequest, w.Code)
+	}
+	if msg := w.Body.String(); !strings.Contains(msg, `Bad limit "x": must be from 1 to 100`) {
+		t.Errorf("Bad formatting of error message: %q\n", msg)
+	}
+}
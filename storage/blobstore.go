@@ -0,0 +1,323 @@
+/*
+	This file implements a content-addressable tier for large immutable values, e.g., label
+	surfaces and meshes, that are often identical across versions.  A value stored through
+	a BlobStore is kept once under its SHA-256 digest and the data key only holds a short
+	reference to the digest, so the same value written to many keys or versions of the DAG
+	is stored once.
+
+	Blobs, their reference counts, and the digest referenced by each data key are kept in
+	the blob partition of the key space:
+
+		blob:       blobKeyPrefix + 'b' + digest -> value
+		count:      blobKeyPrefix + 'c' + digest -> number of references (uint64)
+		reference:  blobKeyPrefix + 'r' + full data key -> digest
+
+	A blob is deleted with its last reference.  References are tracked by the full data key
+	given to Put, so a reference is released by Delete, by deletion of its data instance,
+	or by garbage collection of its version even if the data key itself was never stored,
+	e.g., because copy-on-write found the ancestor value identical.
+
+	Values smaller than BlobMinSize and values of encrypted data instances are stored
+	directly under the data key.  Reads of data keys holding values stored before the blob
+	tier was used return the value as is.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// BlobMinSize is the minimum size of a value stored by content hash.  Smaller values are
+// stored directly since the savings don't warrant the extra reads.
+const BlobMinSize = 4 * dvid.Kilo
+
+// blobRefMagic begins the value of a data key referencing a blob.
+var blobRefMagic = []byte{0xFF, 'D', 'V', 'B'}
+
+const (
+	blobValueTag = 'b'
+	blobCountTag = 'c'
+	blobRefTag   = 'r'
+)
+
+// blobMu serializes reference count changes of all blob stores.
+var blobMu sync.Mutex
+
+func blobKey(tag byte, id []byte) []byte {
+	key := make([]byte, 2, 2+len(id))
+	key[0] = blobKeyPrefix
+	key[1] = tag
+	return append(key, id...)
+}
+
+// blobDigest returns the digest referenced by a stored value or false if the value isn't
+// a blob reference.
+func blobDigest(v []byte) ([]byte, bool) {
+	if len(v) != len(blobRefMagic)+sha256.Size || !bytes.HasPrefix(v, blobRefMagic) {
+		return nil, false
+	}
+	return v[len(blobRefMagic):], true
+}
+
+// IsBlobRef returns true if a stored value is a reference to a blob.
+func IsBlobRef(v []byte) bool {
+	_, ok := blobDigest(v)
+	return ok
+}
+
+// BlobStore stores large immutable values of data keys by content hash in an ordered
+// key-value store.
+type BlobStore struct {
+	db OrderedKeyValueDB
+}
+
+// NewBlobStore returns a BlobStore that keeps blobs in the given store.
+func NewBlobStore(db OrderedKeyValueDB) (*BlobStore, error) {
+	if _, ok := db.(KeyValueBatcher); !ok {
+		return nil, fmt.Errorf("Blob store requires a database that supports batches, %q does not", db)
+	}
+	return &BlobStore{db}, nil
+}
+
+func (b *BlobStore) String() string {
+	return fmt.Sprintf("blobs in %s", b.db)
+}
+
+// blobTxn tracks reference counts changed by an uncommitted transaction.
+type blobTxn struct {
+	db     OrderedKeyValueDB
+	txn    Transaction
+	counts map[string]uint64
+}
+
+// newTxn returns a transaction for blob writes.  Since blobs may be kept in object stores
+// without transactions, writes might only be atomic per context.
+func (b *BlobStore) newTxn() (*blobTxn, error) {
+	txn, err := NewBatchTransaction(b.db)
+	if err != nil {
+		return nil, err
+	}
+	return &blobTxn{b.db, txn, make(map[string]uint64)}, nil
+}
+
+func (t *blobTxn) count(digest []byte) (uint64, error) {
+	if count, found := t.counts[string(digest)]; found {
+		return count, nil
+	}
+	v, err := t.db.Get(nil, blobKey(blobCountTag, digest))
+	if err != nil || v == nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("Bad reference count for blob %x", digest)
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func (t *blobTxn) setCount(digest []byte, count uint64) {
+	t.counts[string(digest)] = count
+	if count == 0 {
+		t.txn.Delete(nil, blobKey(blobCountTag, digest))
+		return
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, count)
+	t.txn.Put(nil, blobKey(blobCountTag, digest), buf)
+}
+
+// reference adds a reference from a full data key to a blob, storing the blob if it's new.
+func (t *blobTxn) reference(key, digest, v []byte) error {
+	count, err := t.count(digest)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		t.txn.Put(nil, blobKey(blobValueTag, digest), v)
+	}
+	t.setCount(digest, count+1)
+	t.txn.Put(nil, blobKey(blobRefTag, key), digest)
+	return nil
+}
+
+// release removes the reference of a full data key, if any, deleting the blob if it was
+// the last reference.
+func (t *blobTxn) release(key, digest []byte) error {
+	count, err := t.count(digest)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		t.txn.Delete(nil, blobKey(blobValueTag, digest))
+		count = 1
+	}
+	t.setCount(digest, count-1)
+	t.txn.Delete(nil, blobKey(blobRefTag, key))
+	return nil
+}
+
+// referenced returns the digest referenced by a full data key or nil if there's none.
+func (b *BlobStore) referenced(key []byte) ([]byte, error) {
+	return b.db.Get(nil, blobKey(blobRefTag, key))
+}
+
+// Put stores a value for a key, keeping it by content hash unless it's small or its
+// data instance is encrypted.
+func (b *BlobStore) Put(ctx Context, k, v []byte) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	key := logKey(ctx, k)
+	prev, err := b.referenced(key)
+	if err != nil {
+		return err
+	}
+	txn, err := b.newTxn()
+	if err != nil {
+		return err
+	}
+	defer txn.txn.Rollback()
+
+	instanceID, isData := instanceOf(nil, key)
+	if !isData || len(v) < BlobMinSize || instanceEncrypted(instanceID) {
+		if prev != nil {
+			if err := txn.release(key, prev); err != nil {
+				return err
+			}
+		}
+		txn.txn.Put(ctx, k, v)
+		return txn.txn.Commit()
+	}
+
+	sum := sha256.Sum256(v)
+	digest := sum[:]
+	if !bytes.Equal(prev, digest) {
+		if prev != nil {
+			if err := txn.release(key, prev); err != nil {
+				return err
+			}
+		}
+		if err := txn.reference(key, digest, v); err != nil {
+			return err
+		}
+	}
+	ref := make([]byte, 0, len(blobRefMagic)+len(digest))
+	ref = append(ref, blobRefMagic...)
+	txn.txn.Put(ctx, k, append(ref, digest...))
+	return txn.txn.Commit()
+}
+
+// Get returns the value of a key, or nil if the key isn't found.
+func (b *BlobStore) Get(ctx Context, k []byte) ([]byte, error) {
+	v, err := b.db.Get(ctx, k)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return b.Resolve(v)
+}
+
+// Resolve returns the value given the value stored for a data key, which is either a
+// blob reference or the value itself.
+func (b *BlobStore) Resolve(v []byte) ([]byte, error) {
+	digest, ok := blobDigest(v)
+	if !ok {
+		return v, nil
+	}
+	blob, err := b.db.Get(nil, blobKey(blobValueTag, digest))
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, fmt.Errorf("Missing blob %x", digest)
+	}
+	return blob, nil
+}
+
+// Delete removes the value of a key and releases any blob it references.
+func (b *BlobStore) Delete(ctx Context, k []byte) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	key := logKey(ctx, k)
+	prev, err := b.referenced(key)
+	if err != nil {
+		return err
+	}
+	txn, err := b.newTxn()
+	if err != nil {
+		return err
+	}
+	defer txn.txn.Rollback()
+	if prev != nil {
+		if err := txn.release(key, prev); err != nil {
+			return err
+		}
+	}
+	txn.txn.Delete(ctx, k)
+	return txn.txn.Commit()
+}
+
+// Release releases any blob referenced by a full data key without deleting the key,
+// e.g., for keys deleted directly from the underlying store.
+func (b *BlobStore) Release(key []byte) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	prev, err := b.referenced(key)
+	if err != nil || prev == nil {
+		return err
+	}
+	txn, err := b.newTxn()
+	if err != nil {
+		return err
+	}
+	defer txn.txn.Rollback()
+	if err := txn.release(key, prev); err != nil {
+		return err
+	}
+	return txn.txn.Commit()
+}
+
+// ReleaseRange releases blobs referenced by full data keys in the given range.
+func (b *BlobStore) ReleaseRange(minKey, maxKey []byte) error {
+	_, err := b.releaseRange(minKey, maxKey, nil)
+	return err
+}
+
+// releaseRange releases blobs referenced by full data keys in the given range that pass
+// an optional filter and returns the number of references released.
+func (b *BlobStore) releaseRange(minKey, maxKey []byte, filter func(key []byte) bool) (uint64, error) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	refs, err := b.db.GetRange(nil, blobKey(blobRefTag, minKey), blobKey(blobRefTag, maxKey))
+	if err != nil || len(refs) == 0 {
+		return 0, err
+	}
+	txn, err := b.newTxn()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.txn.Rollback()
+	var released uint64
+	for _, ref := range refs {
+		key := ref.K[2:]
+		if filter != nil && !filter(key) {
+			continue
+		}
+		if err := txn.release(key, ref.V); err != nil {
+			return 0, err
+		}
+		released++
+	}
+	if released == 0 {
+		return 0, nil
+	}
+	return released, txn.txn.Commit()
+}
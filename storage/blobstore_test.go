@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+// numBlobs returns the number of blobs stored in a memory db.
+func numBlobs(db *memoryDB) int {
+	var n int
+	for k := range db.kv {
+		if k[0] == blobKeyPrefix && k[1] == blobValueTag {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBlobStore(t *testing.T) {
+	db := newMemoryDB()
+	blobs, err := NewBlobStore(db)
+	if err != nil {
+		t.Fatalf("Can't create blob store: %s\n", err.Error())
+	}
+	ctx1 := GetTestDataContext(TestUUID1, "labels", 1)
+	ctx2 := GetTestDataContext(TestUUID2, "labels", 1)
+	big := bytes.Repeat([]byte{1, 2, 3, 4}, BlobMinSize)
+	other := bytes.Repeat([]byte{5, 6, 7, 8}, BlobMinSize)
+
+	// Identical values in different versions and keys are stored once.
+	for _, k := range [][]byte{{1}, {2}} {
+		if err := blobs.Put(ctx1, k, big); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+	if err := blobs.Put(ctx2, []byte{1}, big); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if n := numBlobs(db); n != 1 {
+		t.Fatalf("Expected 1 blob, got %d\n", n)
+	}
+	stored, _ := db.Get(ctx2, []byte{1})
+	if !IsBlobRef(stored) {
+		t.Errorf("Expected blob reference stored for key, got %d bytes\n", len(stored))
+	}
+	value, err := blobs.Get(ctx2, []byte{1})
+	if err != nil {
+		t.Fatalf("Error on get: %s\n", err.Error())
+	}
+	if !bytes.Equal(value, big) {
+		t.Errorf("Bad value from blob store: %d bytes\n", len(value))
+	}
+
+	// Small values are stored directly.
+	if err := blobs.Put(ctx1, []byte{3}, []byte("small")); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if stored, _ := db.Get(ctx1, []byte{3}); string(stored) != "small" {
+		t.Errorf("Expected small value stored directly, got %q\n", stored)
+	}
+
+	// Overwriting and deleting release references, and the last release deletes the blob.
+	if err := blobs.Put(ctx1, []byte{1}, other); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if err := blobs.Delete(ctx1, []byte{2}); err != nil {
+		t.Fatalf("Error on delete: %s\n", err.Error())
+	}
+	if n := numBlobs(db); n != 2 {
+		t.Fatalf("Expected 2 blobs, got %d\n", n)
+	}
+	if err := blobs.Put(ctx2, []byte{1}, []byte("small")); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if n := numBlobs(db); n != 1 {
+		t.Fatalf("Expected 1 blob after releasing shared blob, got %d\n", n)
+	}
+	if value, err := blobs.Get(ctx1, []byte{1}); err != nil || !bytes.Equal(value, other) {
+		t.Errorf("Bad value after overwrite: %d bytes, %v\n", len(value), err)
+	}
+
+	// Releasing an instance's references deletes its blobs.
+	minKey, maxKey := DataContextKeyRange(1)
+	if err := blobs.ReleaseRange(minKey, maxKey); err != nil {
+		t.Fatalf("Error releasing instance blobs: %s\n", err.Error())
+	}
+	for k := range db.kv {
+		if k[0] == blobKeyPrefix {
+			t.Errorf("Expected no blob keys after release, found %x\n", k)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+/*
+	This file implements an in-memory LRU cache layered over an ordered key-value store.
+	Results of Get, GetRange and ProcessRange on data contexts are cached by instance,
+	version and index range.  Any write to an instance invalidates cached results whose
+	index range includes the written index, across all versions.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// BlockCacheStats gives statistics for a block cache.
+type BlockCacheStats struct {
+	MaxBytes  int
+	Bytes     int
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type cacheOp uint8
+
+const (
+	cacheGet cacheOp = iota
+	cacheRange
+)
+
+type cacheEntry struct {
+	key      string
+	instance dvid.InstanceID
+	beg, end []byte // index range covered by this entry
+	values   []*KeyValue
+	size     int
+}
+
+// blockCache wraps an ordered key-value store and caches reads on data contexts.
+type blockCache struct {
+	OrderedKeyValueDB
+
+	sync.Mutex
+	maxBytes   int
+	curBytes   int
+	lru        *list.List // front is most recently used
+	entries    map[string]*list.Element
+	byInstance map[dvid.InstanceID]map[string]*list.Element
+
+	// Incremented on every invalidation so reads started before a write don't
+	// cache stale values.
+	epoch uint64
+
+	hits, misses, evictions uint64
+
+	// Read counts used to warm the cache after a restart.
+	hot map[string]*HotRead
+}
+
+func newBlockCache(db OrderedKeyValueDB, maxBytes int) (*blockCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("Block cache size must be positive, got %d bytes", maxBytes)
+	}
+	if _, ok := db.(KeyValueBatcher); !ok {
+		return nil, fmt.Errorf("Block cache requires a database that supports batches, %q does not", db)
+	}
+	return &blockCache{
+		OrderedKeyValueDB: db,
+		maxBytes:          maxBytes,
+		lru:               list.New(),
+		entries:           make(map[string]*list.Element),
+		byInstance:        make(map[dvid.InstanceID]map[string]*list.Element),
+		hot:               make(map[string]*HotRead),
+	}, nil
+}
+
+func (c *blockCache) String() string {
+	return fmt.Sprintf("%s with %d MB LRU block cache", c.OrderedKeyValueDB, c.maxBytes/dvid.Mega)
+}
+
+// Stats returns the current cache statistics.
+func (c *blockCache) Stats() BlockCacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return BlockCacheStats{
+		MaxBytes:  c.maxBytes,
+		Bytes:     c.curBytes,
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// cacheKey returns the cache key and instance for a read on the given context, or
+// false if the context isn't a data context.
+func cacheKey(ctx Context, op cacheOp, beg, end []byte) (string, dvid.InstanceID, bool) {
+	if ctx == nil {
+		return "", 0, false
+	}
+	key := ctx.ConstructKey(beg)
+	instanceID, versionID, err := KeyToLocalIDs(key)
+	if err != nil {
+		return "", 0, false
+	}
+	return makeCacheKey(op, instanceID, versionID, beg, end), instanceID, true
+}
+
+func makeCacheKey(op cacheOp, instanceID dvid.InstanceID, versionID dvid.VersionID, beg, end []byte) string {
+	buf := make([]byte, 0, 1+dvid.InstanceIDSize+dvid.VersionIDSize+len(beg)+len(end)+1)
+	buf = append(buf, byte(op))
+	buf = append(buf, instanceID.Bytes()...)
+	buf = append(buf, versionID.Bytes()...)
+	buf = append(buf, beg...)
+	buf = append(buf, 0)
+	buf = append(buf, end...)
+	return string(buf)
+}
+
+// lookup returns cached key-value pairs and the current epoch.
+func (c *blockCache) lookup(key string, beg, end []byte) ([]*KeyValue, bool, uint64) {
+	c.Lock()
+	defer c.Unlock()
+	c.recordRead(key, beg, end)
+	elem, found := c.entries[key]
+	if !found {
+		c.misses++
+		return nil, false, c.epoch
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return copyValues(elem.Value.(*cacheEntry).values), true, c.epoch
+}
+
+// copyValues returns a deep copy so callers can't modify cached data.
+func copyValues(values []*KeyValue) []*KeyValue {
+	dup := make([]*KeyValue, len(values))
+	for i, kv := range values {
+		dup[i] = &KeyValue{K: append([]byte(nil), kv.K...), V: append([]byte(nil), kv.V...)}
+	}
+	return dup
+}
+
+// store caches key-value pairs if there have been no invalidations since epoch.
+func (c *blockCache) store(key string, instance dvid.InstanceID, beg, end []byte, values []*KeyValue, epoch uint64) {
+	size := len(key)
+	for _, kv := range values {
+		size += len(kv.K) + len(kv.V)
+	}
+	c.Lock()
+	defer c.Unlock()
+	if size > c.maxBytes || epoch != c.epoch {
+		return
+	}
+	if _, found := c.entries[key]; found {
+		return
+	}
+	entry := &cacheEntry{
+		key:      key,
+		instance: instance,
+		beg:      beg,
+		end:      end,
+		values:   copyValues(values),
+		size:     size,
+	}
+	elem := c.lru.PushFront(entry)
+	c.entries[key] = elem
+	instEntries, found := c.byInstance[instance]
+	if !found {
+		instEntries = make(map[string]*list.Element)
+		c.byInstance[instance] = instEntries
+	}
+	instEntries[key] = elem
+	c.curBytes += size
+	for c.curBytes > c.maxBytes {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// setMaxBytes changes the size of the cache, evicting the least recently used entries
+// if the cache is now too large.
+func (c *blockCache) setMaxBytes(maxBytes int) error {
+	if maxBytes <= 0 {
+		return fmt.Errorf("Block cache size must be positive, got %d bytes", maxBytes)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.maxBytes = maxBytes
+	for c.curBytes > c.maxBytes {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+	return nil
+}
+
+// removeElement removes an entry.  Caller must hold the lock.
+func (c *blockCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	if instEntries, found := c.byInstance[entry.instance]; found {
+		delete(instEntries, entry.key)
+		if len(instEntries) == 0 {
+			delete(c.byInstance, entry.instance)
+		}
+	}
+	c.curBytes -= entry.size
+}
+
+// invalidate removes all entries for the context's instance whose index range
+// overlaps [beg, end].  If there is no context, beg and end are full keys and all
+// entries for the instances they span are removed.
+func (c *blockCache) invalidate(ctx Context, beg, end []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.epoch++
+	if ctx == nil {
+		for _, k := range [][]byte{beg, end} {
+			if len(k) >= 1+dvid.InstanceIDSize && k[0] == dataKeyPrefix {
+				instanceID := dvid.InstanceIDFromBytes(k[1 : 1+dvid.InstanceIDSize])
+				for _, elem := range c.byInstance[instanceID] {
+					c.removeElement(elem)
+				}
+			}
+		}
+		return
+	}
+	instanceID, _, err := KeyToLocalIDs(ctx.ConstructKey(beg))
+	if err != nil {
+		return
+	}
+	for _, elem := range c.byInstance[instanceID] {
+		entry := elem.Value.(*cacheEntry)
+		if bytes.Compare(entry.beg, end) <= 0 && bytes.Compare(beg, entry.end) <= 0 {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (c *blockCache) Get(ctx Context, k []byte) ([]byte, error) {
+	key, instance, cacheable := cacheKey(ctx, cacheGet, k, k)
+	if !cacheable {
+		return c.OrderedKeyValueDB.Get(ctx, k)
+	}
+	values, found, epoch := c.lookup(key, k, k)
+	if found {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[0].V, nil
+	}
+	v, err := c.OrderedKeyValueDB.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	// Cache misses as well since absent blocks are common for sparse volumes.
+	values = []*KeyValue{}
+	if v != nil {
+		values = append(values, &KeyValue{V: v})
+	}
+	c.store(key, instance, k, k, values, epoch)
+	return v, nil
+}
+
+func (c *blockCache) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	key, instance, cacheable := cacheKey(ctx, cacheRange, kStart, kEnd)
+	if !cacheable {
+		return c.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	}
+	values, found, epoch := c.lookup(key, kStart, kEnd)
+	if found {
+		return values, nil
+	}
+	values, err := c.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, instance, kStart, kEnd, values, epoch)
+	return values, nil
+}
+
+// ProcessRange sends cached key-value pairs to the chunk handler if available.  Otherwise
+// the underlying store's range is processed and cached.
+func (c *blockCache) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	key, instance, cacheable := cacheKey(ctx, cacheRange, kStart, kEnd)
+	if !cacheable {
+		return c.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, f)
+	}
+	values, found, epoch := c.lookup(key, kStart, kEnd)
+	if found {
+		for _, kv := range values {
+			if op != nil && op.Wg != nil {
+				op.Wg.Add(1)
+			}
+			f(&Chunk{op, kv})
+		}
+		return nil
+	}
+
+	// Key-value pairs are only kept while the range could fit in the cache, so large
+	// scans aren't buffered in memory.
+	c.Lock()
+	maxBytes := c.maxBytes
+	c.Unlock()
+	values = []*KeyValue{}
+	size := len(key)
+	err := c.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(chunk *Chunk) {
+		if values != nil {
+			size += len(chunk.K) + len(chunk.V)
+			if size > maxBytes {
+				values = nil
+			} else {
+				values = append(values, &KeyValue{K: chunk.K, V: append([]byte(nil), chunk.V...)})
+			}
+		}
+		f(chunk)
+	})
+	if err != nil || values == nil {
+		return err
+	}
+	c.store(key, instance, kStart, kEnd, values, epoch)
+	return nil
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (c *blockCache) Put(ctx Context, k, v []byte) error {
+	err := c.OrderedKeyValueDB.Put(ctx, k, v)
+	c.invalidate(ctx, k, k)
+	return err
+}
+
+func (c *blockCache) Delete(ctx Context, k []byte) error {
+	err := c.OrderedKeyValueDB.Delete(ctx, k)
+	c.invalidate(ctx, k, k)
+	return err
+}
+
+func (c *blockCache) PutRange(ctx Context, values []KeyValue) error {
+	err := c.OrderedKeyValueDB.PutRange(ctx, values)
+	for _, kv := range values {
+		c.invalidate(ctx, kv.K, kv.K)
+	}
+	return err
+}
+
+func (c *blockCache) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	err := c.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+	c.invalidate(ctx, kStart, kEnd)
+	return err
+}
+
+// ---- KeyValueBatcher interface ------
+
+type cacheBatch struct {
+	Batch
+	cache *blockCache
+	ctx   Context
+	keys  [][]byte
+}
+
+func (c *blockCache) NewBatch(ctx Context) Batch {
+	return c.wrapBatch(ctx, c.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (c *blockCache) wrappedStore() OrderedKeyValueSetter { return c.OrderedKeyValueDB }
+
+func (c *blockCache) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &cacheBatch{Batch: newBatch(ctx), cache: c, ctx: ctx}
+}
+
+func (b *cacheBatch) Delete(k []byte) {
+	b.keys = append(b.keys, k)
+	b.Batch.Delete(k)
+}
+
+func (b *cacheBatch) Put(k, v []byte) {
+	b.keys = append(b.keys, k)
+	b.Batch.Put(k, v)
+}
+
+func (b *cacheBatch) Commit() error {
+	err := b.Batch.Commit()
+	for _, k := range b.keys {
+		b.cache.invalidate(b.ctx, k, k)
+	}
+	return err
+}
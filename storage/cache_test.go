@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+// memoryDB is a simple ordered key-value store that counts reads.
+type memoryDB struct {
+	kv    map[string][]byte
+	reads int
+}
+
+// fullKey returns the key for an index or the given full key if there's no context.
+func fullKey(ctx Context, k []byte) []byte {
+	if ctx == nil {
+		return k
+	}
+	return ctx.ConstructKey(k)
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{kv: make(map[string][]byte)}
+}
+
+func (db *memoryDB) String() string { return "memory db" }
+
+func (db *memoryDB) Get(ctx Context, k []byte) ([]byte, error) {
+	db.reads++
+	return db.kv[string(fullKey(ctx, k))], nil
+}
+
+func (db *memoryDB) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	db.reads++
+	beg, end := fullKey(ctx, kStart), fullKey(ctx, kEnd)
+	var keys []string
+	for k := range db.kv {
+		if bytes.Compare([]byte(k), beg) >= 0 && bytes.Compare([]byte(k), end) <= 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	values := []*KeyValue{}
+	for _, k := range keys {
+		values = append(values, &KeyValue{[]byte(k), db.kv[k]})
+	}
+	return values, nil
+}
+
+func (db *memoryDB) KeysInRange(ctx Context, kStart, kEnd []byte) ([][]byte, error) {
+	values, err := db.GetRange(ctx, kStart, kEnd)
+	keys := make([][]byte, len(values))
+	for i, kv := range values {
+		keys[i] = kv.K
+	}
+	return keys, err
+}
+
+func (db *memoryDB) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	values, err := db.GetRange(ctx, kStart, kEnd)
+	for _, kv := range values {
+		f(&Chunk{op, kv})
+	}
+	return err
+}
+
+func (db *memoryDB) Put(ctx Context, k, v []byte) error {
+	db.kv[string(fullKey(ctx, k))] = v
+	return nil
+}
+
+func (db *memoryDB) Delete(ctx Context, k []byte) error {
+	delete(db.kv, string(fullKey(ctx, k)))
+	return nil
+}
+
+func (db *memoryDB) PutRange(ctx Context, values []KeyValue) error {
+	for _, kv := range values {
+		db.Put(ctx, kv.K, kv.V)
+	}
+	return nil
+}
+
+func (db *memoryDB) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	keys, _ := db.KeysInRange(ctx, kStart, kEnd)
+	for _, k := range keys {
+		delete(db.kv, string(k))
+	}
+	return nil
+}
+
+type memoryBatch struct {
+	db  *memoryDB
+	ctx Context
+	ops []KeyValue // nil value is a delete
+}
+
+func (db *memoryDB) NewBatch(ctx Context) Batch {
+	return &memoryBatch{db: db, ctx: ctx}
+}
+
+func (b *memoryBatch) Put(k, v []byte) { b.ops = append(b.ops, KeyValue{k, v}) }
+func (b *memoryBatch) Delete(k []byte) { b.ops = append(b.ops, KeyValue{k, nil}) }
+func (b *memoryBatch) Commit() error {
+	for _, op := range b.ops {
+		if op.V == nil {
+			b.db.Delete(b.ctx, op.K)
+		} else {
+			b.db.Put(b.ctx, op.K, op.V)
+		}
+	}
+	return nil
+}
+
+func TestBlockCache(t *testing.T) {
+	db := newMemoryDB()
+	cache, err := newBlockCache(db, 1000)
+	if err != nil {
+		t.Fatalf("Can't create block cache: %s\n", err.Error())
+	}
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 1)
+	otherCtx := GetTestDataContext(TestUUID1, "labels", 2)
+
+	for i := byte(0); i < 10; i++ {
+		if err := cache.Put(ctx, []byte{i}, []byte{i, i}); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+
+	// Repeated range reads should only hit the db once.
+	for n := 0; n < 3; n++ {
+		values, err := cache.GetRange(ctx, []byte{2}, []byte{5})
+		if err != nil {
+			t.Fatalf("Error on GetRange: %s\n", err.Error())
+		}
+		if len(values) != 4 || values[0].V[0] != 2 {
+			t.Fatalf("Bad cached range: %v\n", values)
+		}
+		values[0].V[0] = 99 // should not modify cached value
+	}
+	if db.reads != 1 {
+		t.Errorf("Expected 1 db read for repeated range, got %d\n", db.reads)
+	}
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Bad cache stats: %+v\n", stats)
+	}
+
+	// Missing keys are cached too.
+	for n := 0; n < 2; n++ {
+		v, err := cache.Get(ctx, []byte{20})
+		if err != nil || v != nil {
+			t.Fatalf("Expected nil value for missing key, got %v, %v\n", v, err)
+		}
+	}
+	if db.reads != 2 {
+		t.Errorf("Expected missing key to be cached, got %d db reads\n", db.reads)
+	}
+
+	// Writes to another instance or outside range don't invalidate.
+	cache.Put(otherCtx, []byte{3}, []byte{0})
+	cache.Put(ctx, []byte{8}, []byte{0})
+	cache.GetRange(ctx, []byte{2}, []byte{5})
+	if db.reads != 2 {
+		t.Errorf("Expected range to stay cached after unrelated writes, got %d db reads\n", db.reads)
+	}
+
+	// Write within range through a batch invalidates.
+	batch := cache.NewBatch(ctx)
+	batch.Put([]byte{3}, []byte{33})
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	chunks := 0
+	err = cache.ProcessRange(ctx, []byte{2}, []byte{5}, &ChunkOp{}, func(chunk *Chunk) {
+		index, _ := ctx.IndexFromKey(chunk.K)
+		if index[0] == 3 && chunk.V[0] != 33 {
+			t.Errorf("Expected updated value after batch write, got %v\n", chunk.V)
+		}
+		chunks++
+	})
+	if err != nil {
+		t.Fatalf("Error on ProcessRange: %s\n", err.Error())
+	}
+	if chunks != 4 || db.reads != 3 {
+		t.Errorf("Expected 4 chunks from 1 new db read, got %d chunks, %d reads\n", chunks, db.reads)
+	}
+
+	// Eviction when over size.
+	for i := byte(0); i < 100; i++ {
+		cache.Get(ctx, []byte{i})
+	}
+	stats = cache.Stats()
+	if stats.Bytes > stats.MaxBytes || stats.Evictions == 0 {
+		t.Errorf("Expected evictions to keep cache under max size: %+v\n", stats)
+	}
+
+	// Shrinking the cache evicts entries to fit.
+	if err := cache.setMaxBytes(stats.Bytes / 2); err != nil {
+		t.Fatalf("Error resizing cache: %s\n", err.Error())
+	}
+	resized := cache.Stats()
+	if resized.MaxBytes != stats.Bytes/2 || resized.Bytes > resized.MaxBytes || resized.Evictions <= stats.Evictions {
+		t.Errorf("Expected resized cache to evict down to new size: %+v\n", resized)
+	}
+	if err := cache.setMaxBytes(0); err == nil {
+		t.Errorf("Expected error resizing cache to zero bytes\n")
+	}
+}
+
+func TestBlockCacheLargeRange(t *testing.T) {
+	db := newMemoryDB()
+	cache, err := newBlockCache(db, 500)
+	if err != nil {
+		t.Fatalf("Can't create block cache: %s\n", err.Error())
+	}
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 1)
+	for i := byte(0); i < 10; i++ {
+		if err := cache.Put(ctx, []byte{i}, make([]byte, 100)); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+
+	// Ranges larger than the cache are processed fully but not cached.
+	for n := 0; n < 2; n++ {
+		chunks := 0
+		err := cache.ProcessRange(ctx, []byte{0}, []byte{9}, &ChunkOp{}, func(chunk *Chunk) {
+			chunks++
+		})
+		if err != nil {
+			t.Fatalf("Error on ProcessRange: %s\n", err.Error())
+		}
+		if chunks != 10 {
+			t.Errorf("Expected 10 chunks, got %d\n", chunks)
+		}
+	}
+	if db.reads != 2 {
+		t.Errorf("Expected large range to be read from db each time, got %d reads\n", db.reads)
+	}
+	if stats := cache.Stats(); stats.Bytes != 0 {
+		t.Errorf("Expected large range to not be cached: %+v\n", stats)
+	}
+
+	// Ranges that fit are still cached.
+	for n := 0; n < 2; n++ {
+		if err := cache.ProcessRange(ctx, []byte{0}, []byte{1}, &ChunkOp{}, func(chunk *Chunk) {}); err != nil {
+			t.Fatalf("Error on ProcessRange: %s\n", err.Error())
+		}
+	}
+	if db.reads != 3 {
+		t.Errorf("Expected small range to be cached, got %d db reads\n", db.reads)
+	}
+}
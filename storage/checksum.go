@@ -0,0 +1,248 @@
+/*
+	This file supports verification of checksums stored with serialized values (see
+	dvid.SerializeData) and repair of corrupted values from a replica store.  Only values
+	of data instances configured with checksums are verified since other values may not
+	have a serialization header.
+
+	With the "repair" checksum policy, an engine wrapper verifies values as they are read
+	and replaces a corrupted value by the replica's value for the same key if the replica's
+	checksum is good.  Instances can also be scanned for corruption on demand.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// instances whose values have checksums.
+var (
+	checksummedInstances   = make(map[dvid.InstanceID]bool)
+	checksummedInstancesMu sync.RWMutex
+)
+
+// SetInstanceChecksum sets whether values of a data instance are written with checksums
+// and can be verified.
+func SetInstanceChecksum(instanceID dvid.InstanceID, checksummed bool) {
+	checksummedInstancesMu.Lock()
+	defer checksummedInstancesMu.Unlock()
+	if checksummed {
+		checksummedInstances[instanceID] = true
+	} else {
+		delete(checksummedInstances, instanceID)
+	}
+}
+
+func instanceChecksummed(instanceID dvid.InstanceID) bool {
+	checksummedInstancesMu.RLock()
+	defer checksummedInstancesMu.RUnlock()
+	return checksummedInstances[instanceID]
+}
+
+// checkValue returns a *dvid.ChecksumError if the value of a full key belongs to a
+// checksummed instance and has a bad checksum.
+func checkValue(k, v []byte) error {
+	instanceID, isData := instanceOf(nil, k)
+	if !isData || len(v) == 0 || !instanceChecksummed(instanceID) {
+		return nil
+	}
+	if _, err := dvid.VerifyChecksum(v); err != nil {
+		if _, bad := err.(*dvid.ChecksumError); bad {
+			return err
+		}
+	}
+	return nil
+}
+
+// repairValue returns a good value for a full key with a corrupted value, reading it from
+// the replica and storing it in the database.
+func repairValue(db OrderedKeyValueDB, replica OrderedKeyValueGetter, k []byte) ([]byte, error) {
+	if replica == nil {
+		return nil, fmt.Errorf("no replica store is available")
+	}
+	v, err := replica.Get(nil, k)
+	if err != nil {
+		return nil, fmt.Errorf("can't read replica: %s", err.Error())
+	}
+	if v == nil {
+		return nil, fmt.Errorf("key is not in replica")
+	}
+	if err := checkValue(k, v); err != nil {
+		return nil, fmt.Errorf("replica value is also corrupted: %s", err.Error())
+	}
+	if err := db.Put(nil, k, v); err != nil {
+		return nil, fmt.Errorf("can't store repaired value: %s", err.Error())
+	}
+	return v, nil
+}
+
+// checksumRepairer wraps an ordered key-value store and repairs values with bad
+// checksums as they are read.
+type checksumRepairer struct {
+	OrderedKeyValueDB
+
+	replica OrderedKeyValueGetter
+}
+
+func newChecksumRepairer(db OrderedKeyValueDB, replica OrderedKeyValueGetter) *checksumRepairer {
+	return &checksumRepairer{db, replica}
+}
+
+func (c *checksumRepairer) String() string {
+	return fmt.Sprintf("%s with checksum repair", c.OrderedKeyValueDB)
+}
+
+// verified returns the value for a full key, repairing it if its checksum is bad.
+func (c *checksumRepairer) verified(k, v []byte) ([]byte, error) {
+	err := checkValue(k, v)
+	if err == nil {
+		return v, nil
+	}
+	repaired, repairErr := repairValue(c.OrderedKeyValueDB, c.replica, k)
+	if repairErr != nil {
+		return nil, fmt.Errorf("Unable to repair key %x (%s): %s", k, err.Error(), repairErr.Error())
+	}
+	dvid.Infof("Repaired key %x with bad checksum from replica\n", k)
+	return repaired, nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (c *checksumRepairer) Get(ctx Context, k []byte) ([]byte, error) {
+	v, err := c.OrderedKeyValueDB.Get(ctx, k)
+	if err != nil || v == nil {
+		return v, err
+	}
+	key := k
+	if ctx != nil {
+		key = ctx.ConstructKey(k)
+	}
+	return c.verified(key, v)
+}
+
+func (c *checksumRepairer) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	values, err := c.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range values {
+		// Returned keys are full keys.
+		if kv.V, err = c.verified(kv.K, kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (c *checksumRepairer) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	var repairErr error
+	err := c.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(chunk *Chunk) {
+		if chunk != nil && chunk.KeyValue != nil {
+			value, err := c.verified(chunk.K, chunk.V)
+			if err != nil {
+				if repairErr == nil {
+					repairErr = err
+				}
+				dvid.Errorf("Skipping value in range: %s\n", err.Error())
+				if op != nil && op.Wg != nil {
+					op.Wg.Done()
+				}
+				return
+			}
+			chunk.V = value
+		}
+		f(chunk)
+	})
+	if err != nil {
+		return err
+	}
+	return repairErr
+}
+
+// ---- KeyValueBatcher interface ------
+
+func (c *checksumRepairer) NewBatch(ctx Context) Batch {
+	return c.OrderedKeyValueDB.(KeyValueBatcher).NewBatch(ctx)
+}
+
+func (c *checksumRepairer) wrappedStore() OrderedKeyValueSetter { return c.OrderedKeyValueDB }
+
+func (c *checksumRepairer) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return newBatch(ctx)
+}
+
+// ChecksumFault describes a stored value with a bad checksum.
+type ChecksumFault struct {
+	Key      []byte
+	Error    string
+	Repaired bool
+}
+
+// ChecksumReport gives the results of scanning a data instance for corrupted values.
+type ChecksumReport struct {
+	Instance dvid.InstanceID
+
+	// Keys is the number of keys scanned.
+	Keys uint64
+
+	// Checksummed is the number of values with checksums.
+	Checksummed uint64
+
+	Faults []ChecksumFault
+}
+
+func (r *ChecksumReport) String() string {
+	var repaired int
+	for _, fault := range r.Faults {
+		if fault.Repaired {
+			repaired++
+		}
+	}
+	return fmt.Sprintf("Instance %d: %d keys, %d with checksums, %d corrupted, %d repaired",
+		r.Instance, r.Keys, r.Checksummed, len(r.Faults), repaired)
+}
+
+// verifyInstance scans all values of a data instance in a database, adding any with
+// bad checksums to the report.  Corrupted values are repaired from the replica if
+// requested.
+func verifyInstance(db OrderedKeyValueDB, replica OrderedKeyValueGetter, instanceID dvid.InstanceID, repair bool, report *ChecksumReport) error {
+	var faults []ChecksumFault
+	minKey, maxKey := DataContextKeyRange(instanceID)
+	err := db.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+		if chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		report.Keys++
+		if len(chunk.V) == 0 {
+			return
+		}
+		checksum, err := dvid.VerifyChecksum(chunk.V)
+		if checksum != dvid.NoChecksum {
+			report.Checksummed++
+		}
+		if _, bad := err.(*dvid.ChecksumError); bad {
+			key := append([]byte{}, chunk.K...)
+			faults = append(faults, ChecksumFault{Key: key, Error: err.Error()})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Repair after the scan so writes don't interfere with iteration.
+	for i, fault := range faults {
+		if repair {
+			if _, err := repairValue(db, replica, fault.Key); err != nil {
+				faults[i].Error += "; repair failed: " + err.Error()
+			} else {
+				faults[i].Repaired = true
+			}
+		}
+		dvid.Errorf("Instance %d key %x: %s\n", instanceID, fault.Key, faults[i].Error)
+	}
+	report.Faults = append(report.Faults, faults...)
+	return nil
+}
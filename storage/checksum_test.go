@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestChecksumRepair(t *testing.T) {
+	db := newMemoryDB()
+	replica := newMemoryDB()
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 21)
+	SetInstanceChecksum(21, true)
+	defer SetInstanceChecksum(21, false)
+
+	good, err := dvid.SerializeData([]byte("block of voxels"), dvid.Compression{}, dvid.XXHash)
+	if err != nil {
+		t.Fatalf("Can't serialize data: %s\n", err.Error())
+	}
+	bad := append([]byte{}, good...)
+	bad[len(bad)-1] ^= 0x01
+	for _, k := range [][]byte{{1}, {2}} {
+		db.Put(ctx, k, bad)
+		replica.Put(ctx, k, good)
+	}
+	db.Put(ctx, []byte{3}, good)
+
+	// A scan finds corrupted values and repairs them if asked.
+	report := &ChecksumReport{Instance: 21}
+	if err := verifyInstance(db, replica, 21, false, report); err != nil {
+		t.Fatalf("Error verifying instance: %s\n", err.Error())
+	}
+	if report.Keys != 3 || report.Checksummed != 3 || len(report.Faults) != 2 || report.Faults[0].Repaired {
+		t.Errorf("Bad verification report: %s\n", report)
+	}
+	report = &ChecksumReport{Instance: 21}
+	if err := verifyInstance(db, replica, 21, true, report); err != nil {
+		t.Fatalf("Error verifying instance: %s\n", err.Error())
+	}
+	if len(report.Faults) != 2 || !report.Faults[1].Repaired {
+		t.Errorf("Bad repair report: %s\n", report)
+	}
+	if v, _ := db.Get(ctx, []byte{1}); !bytes.Equal(v, good) {
+		t.Errorf("Corrupted value not repaired: %v\n", v)
+	}
+
+	// Reads through the repairer fix corrupted values.
+	db.Put(ctx, []byte{2}, bad)
+	repairer := newChecksumRepairer(db, replica)
+	if v, err := repairer.Get(ctx, []byte{2}); err != nil || !bytes.Equal(v, good) {
+		t.Errorf("Bad repaired value %v: %v\n", v, err)
+	}
+	if v, _ := db.Get(ctx, []byte{2}); !bytes.Equal(v, good) {
+		t.Errorf("Repaired value not stored: %v\n", v)
+	}
+
+	// Values that can't be repaired are errors.
+	replica.Put(ctx, []byte{3}, bad)
+	db.Put(ctx, []byte{3}, bad)
+	if _, err := repairer.GetRange(ctx, []byte{1}, []byte{3}); err == nil {
+		t.Errorf("Expected error on unrepairable value\n")
+	}
+
+	// Values of instances without checksums aren't checked.
+	plainCtx := GetTestDataContext(TestUUID1, "labels", 22)
+	db.Put(plainCtx, []byte{1}, []byte("not serialized"))
+	if v, err := repairer.Get(plainCtx, []byte{1}); err != nil || string(v) != "not serialized" {
+		t.Errorf("Bad value of instance without checksums %q: %v\n", v, err)
+	}
+}
@@ -0,0 +1,523 @@
+/*
+	This file supports a cluster of DVID servers that partition data across their local
+	storage engines.  Data keys are assigned to members by consistent hashing of the key
+	without its version, so all versions of a block are held by one member and versioned
+	reads are resolved as they would be by a single engine.  All other keys, e.g., repo
+	metadata and graphs, are held by a coordinator member.
+
+	A cluster wrapper routes each operation to the owning members, which are reached
+	through the ClusterPeer interface.  The server package supplies peers that forward
+	operations over HTTP and keeps the ring current as members join or fail.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// DefaultRingReplicas is the default number of virtual nodes per member of a hash ring.
+const DefaultRingReplicas = 128
+
+// HashRing assigns keys to members by consistent hashing, so adding or removing a
+// member only moves the keys it gains or loses.
+type HashRing struct {
+	replicas int
+	hashes   []uint64
+	owners   map[uint64]string
+	members  []string
+}
+
+// NewHashRing returns a ring with the given virtual nodes per member and members.
+func NewHashRing(replicas int, members ...string) *HashRing {
+	if replicas <= 0 {
+		replicas = DefaultRingReplicas
+	}
+	ring := &HashRing{replicas: replicas, owners: make(map[uint64]string)}
+	for _, member := range members {
+		ring.add(member)
+	}
+	sort.Strings(ring.members)
+	sort.Sort(uint64Slice(ring.hashes))
+	return ring
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (ring *HashRing) add(member string) {
+	for _, m := range ring.members {
+		if m == member {
+			return
+		}
+	}
+	ring.members = append(ring.members, member)
+	for i := 0; i < ring.replicas; i++ {
+		h := dvid.XXHash64([]byte(member + "#" + strconv.Itoa(i)))
+		if _, found := ring.owners[h]; found {
+			continue
+		}
+		ring.owners[h] = member
+		ring.hashes = append(ring.hashes, h)
+	}
+}
+
+// Members returns the sorted members of the ring.
+func (ring *HashRing) Members() []string {
+	return ring.members
+}
+
+// Owner returns the member owning a key, or an empty string if the ring is empty.
+func (ring *HashRing) Owner(key []byte) string {
+	if len(ring.hashes) == 0 {
+		return ""
+	}
+	h := dvid.XXHash64(key)
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= h })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.owners[ring.hashes[i]]
+}
+
+// ClusterWrite is a put or, if Delete is true, a delete of a full key.
+type ClusterWrite struct {
+	K      []byte
+	V      []byte
+	Delete bool
+}
+
+// ClusterPeer gives a cluster member access to the storage of a member.  All keys are
+// full keys, i.e., suitable for use with a nil Context.
+type ClusterPeer interface {
+	Get(k []byte) ([]byte, error)
+
+	// GetRange returns the key-value pairs with keys in the inclusive range.  Values
+	// are not returned if keysOnly is true.
+	GetRange(kStart, kEnd []byte, keysOnly bool) ([]*KeyValue, error)
+
+	// Write applies the puts and deletes atomically if the member's engine allows.
+	Write(writes []ClusterWrite) error
+
+	DeleteRange(kStart, kEnd []byte) error
+}
+
+// localPeer gives cluster members access to a local engine.
+type localPeer struct {
+	db OrderedKeyValueDB
+}
+
+func (p localPeer) Get(k []byte) ([]byte, error) {
+	return p.db.Get(nil, k)
+}
+
+func (p localPeer) GetRange(kStart, kEnd []byte, keysOnly bool) ([]*KeyValue, error) {
+	if !keysOnly {
+		return p.db.GetRange(nil, kStart, kEnd)
+	}
+	keys, err := p.db.KeysInRange(nil, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]*KeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i] = &KeyValue{K: k}
+	}
+	return kvs, nil
+}
+
+func (p localPeer) Write(writes []ClusterWrite) error {
+	if batcher, ok := p.db.(KeyValueBatcher); ok {
+		batch := batcher.NewBatch(nil)
+		for _, w := range writes {
+			if w.Delete {
+				batch.Delete(w.K)
+			} else {
+				batch.Put(w.K, w.V)
+			}
+		}
+		return batch.Commit()
+	}
+	for _, w := range writes {
+		var err error
+		if w.Delete {
+			err = p.db.Delete(nil, w.K)
+		} else {
+			err = p.db.Put(nil, w.K, w.V)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p localPeer) DeleteRange(kStart, kEnd []byte) error {
+	return p.db.DeleteRange(nil, kStart, kEnd)
+}
+
+// partitionKey returns the part of a data key that determines its owner, i.e., the key
+// without its version, or nil if the key isn't partitioned.
+func partitionKey(key []byte) []byte {
+	if len(key) < 1+dvid.InstanceIDSize+dvid.VersionIDSize || key[0] != dataKeyPrefix {
+		return nil
+	}
+	return key[:len(key)-dvid.VersionIDSize]
+}
+
+// clusterDB wraps the local engine of a cluster member and routes operations to the
+// members owning the keys.
+type clusterDB struct {
+	OrderedKeyValueDB
+
+	self        string
+	coordinator string
+	peer        func(member string) ClusterPeer
+
+	mu   sync.RWMutex
+	ring *HashRing
+}
+
+func newClusterDB(local OrderedKeyValueDB, self, coordinator string, members []string, peer func(string) ClusterPeer) *clusterDB {
+	c := &clusterDB{OrderedKeyValueDB: local, self: self, coordinator: coordinator, peer: peer}
+	c.setMembers(members)
+	return c
+}
+
+func (c *clusterDB) String() string {
+	return fmt.Sprintf("%s partitioned across cluster as %s", c.OrderedKeyValueDB, c.self)
+}
+
+// setMembers rebuilds the ring from the live members, which always include this member.
+func (c *clusterDB) setMembers(members []string) {
+	ring := NewHashRing(DefaultRingReplicas, append([]string{c.self}, members...)...)
+	c.mu.Lock()
+	c.ring = ring
+	c.mu.Unlock()
+}
+
+func (c *clusterDB) members() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Members()
+}
+
+// owner returns the member holding a full key.
+func (c *clusterDB) owner(key []byte) string {
+	pkey := partitionKey(key)
+	if pkey == nil {
+		return c.coordinator
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Owner(pkey)
+}
+
+func (c *clusterDB) member(name string) ClusterPeer {
+	if name == c.self {
+		return localPeer{c.OrderedKeyValueDB}
+	}
+	return c.peer(name)
+}
+
+// rangeMembers returns the members that may hold keys in a full key range.
+func (c *clusterDB) rangeMembers(minKey, maxKey []byte) []string {
+	if (len(minKey) != 0 && minKey[0] > dataKeyPrefix) || (len(maxKey) != 0 && maxKey[0] < dataKeyPrefix) {
+		return []string{c.coordinator}
+	}
+	members := append([]string{}, c.members()...)
+	for _, m := range members {
+		if m == c.coordinator {
+			return members
+		}
+	}
+	return append(members, c.coordinator)
+}
+
+// fullRange returns the full key range for a range of keys in a context.
+func fullRange(ctx Context, kStart, kEnd []byte) (minKey, maxKey []byte, err error) {
+	if ctx == nil {
+		return kStart, kEnd, nil
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(VersionedContext)
+		if !ok {
+			return nil, nil, fmt.Errorf("Context is versioned but doesn't fulfill storage.VersionedContext")
+		}
+		if minKey, err = vctx.MinVersionKey(kStart); err != nil {
+			return nil, nil, err
+		}
+		maxKey, err = vctx.MaxVersionKey(kEnd)
+		return minKey, maxKey, err
+	}
+	return ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), nil
+}
+
+// getRange returns the sorted key-value pairs in a range from all members holding them,
+// resolving versions for versioned contexts.
+func (c *clusterDB) getRange(ctx Context, kStart, kEnd []byte, keysOnly bool) ([]*KeyValue, error) {
+	minKey, maxKey, err := fullRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	versioned := ctx != nil && ctx.Versioned()
+	members := c.rangeMembers(minKey, maxKey)
+	results := make([][]*KeyValue, len(members))
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m string) {
+			// Versions are resolved using all versioned keys.
+			results[i], errs[i] = c.member(m).GetRange(minKey, maxKey, keysOnly && !versioned)
+			wg.Done()
+		}(i, m)
+	}
+	wg.Wait()
+	var kvs []*KeyValue
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Range query on cluster member %s failed: %s", members[i], err.Error())
+		}
+		kvs = append(kvs, results[i]...)
+	}
+	sort.Sort(kvsByKey(kvs))
+	if !versioned {
+		return kvs, nil
+	}
+	return resolveVersions(ctx.(VersionedContext), kvs)
+}
+
+type kvsByKey []*KeyValue
+
+func (s kvsByKey) Len() int           { return len(s) }
+func (s kvsByKey) Less(i, j int) bool { return bytes.Compare(s[i].K, s[j].K) < 0 }
+func (s kvsByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// resolveVersions returns the value visible to a version for each index of the sorted
+// key-value pairs.
+func resolveVersions(vctx VersionedContext, kvs []*KeyValue) ([]*KeyValue, error) {
+	var resolved []*KeyValue
+	var group []*KeyValue
+	flush := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(group)
+		if err != nil {
+			return err
+		}
+		if kv != nil {
+			resolved = append(resolved, kv)
+		}
+		group = nil
+		return nil
+	}
+	for _, kv := range kvs {
+		if len(group) != 0 && !bytes.Equal(partitionKey(kv.K), partitionKey(group[0].K)) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		group = append(group, kv)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// writeAll applies writes grouped by owning member.
+func (c *clusterDB) writeAll(writes []ClusterWrite) error {
+	byOwner := make(map[string][]ClusterWrite)
+	for _, w := range writes {
+		owner := c.owner(w.K)
+		byOwner[owner] = append(byOwner[owner], w)
+	}
+	for owner, ws := range byOwner {
+		if err := c.member(owner).Write(ws); err != nil {
+			return fmt.Errorf("Write to cluster member %s failed: %s", owner, err.Error())
+		}
+	}
+	return nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (c *clusterDB) Get(ctx Context, k []byte) ([]byte, error) {
+	if ctx != nil && ctx.Versioned() {
+		kvs, err := c.getRange(ctx, k, k, false)
+		if err != nil {
+			return nil, err
+		}
+		key := ctx.ConstructKey(k)
+		for _, kv := range kvs {
+			if bytes.Equal(partitionKey(kv.K), partitionKey(key)) {
+				return kv.V, nil
+			}
+		}
+		return nil, nil
+	}
+	key := logKey(ctx, k)
+	return c.member(c.owner(key)).Get(key)
+}
+
+func (c *clusterDB) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	return c.getRange(ctx, kStart, kEnd, false)
+}
+
+func (c *clusterDB) KeysInRange(ctx Context, kStart, kEnd []byte) ([][]byte, error) {
+	kvs, err := c.getRange(ctx, kStart, kEnd, true)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.K
+	}
+	return keys, nil
+}
+
+func (c *clusterDB) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	kvs, err := c.getRange(ctx, kStart, kEnd, false)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		f(&Chunk{op, kv})
+	}
+	return nil
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (c *clusterDB) Put(ctx Context, k, v []byte) error {
+	key := logKey(ctx, k)
+	return c.member(c.owner(key)).Write([]ClusterWrite{{K: key, V: v}})
+}
+
+func (c *clusterDB) Delete(ctx Context, k []byte) error {
+	key := logKey(ctx, k)
+	return c.member(c.owner(key)).Write([]ClusterWrite{{K: key, Delete: true}})
+}
+
+func (c *clusterDB) PutRange(ctx Context, values []KeyValue) error {
+	writes := make([]ClusterWrite, len(values))
+	for i, kv := range values {
+		writes[i] = ClusterWrite{K: logKey(ctx, kv.K), V: kv.V}
+	}
+	return c.writeAll(writes)
+}
+
+// DeleteRange deletes the keys in a range on all members holding them.  As with local
+// engines, only the keys visible to a versioned context's version are deleted.
+func (c *clusterDB) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	if ctx != nil && ctx.Versioned() {
+		keys, err := c.KeysInRange(ctx, kStart, kEnd)
+		if err != nil {
+			return err
+		}
+		writes := make([]ClusterWrite, len(keys))
+		for i, k := range keys {
+			writes[i] = ClusterWrite{K: k, Delete: true}
+		}
+		return c.writeAll(writes)
+	}
+	minKey, maxKey, err := fullRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	for _, m := range c.rangeMembers(minKey, maxKey) {
+		if err := c.member(m).DeleteRange(minKey, maxKey); err != nil {
+			return fmt.Errorf("Range delete on cluster member %s failed: %s", m, err.Error())
+		}
+	}
+	return nil
+}
+
+// ---- KeyValueBatcher interface ------
+
+type clusterBatch struct {
+	c      *clusterDB
+	ctx    Context
+	writes []ClusterWrite
+}
+
+func (c *clusterDB) NewBatch(ctx Context) Batch {
+	return &clusterBatch{c: c, ctx: ctx}
+}
+
+func (b *clusterBatch) Put(k, v []byte) {
+	b.writes = append(b.writes, ClusterWrite{K: logKey(b.ctx, k), V: v})
+}
+
+func (b *clusterBatch) Delete(k []byte) {
+	b.writes = append(b.writes, ClusterWrite{K: logKey(b.ctx, k), Delete: true})
+}
+
+// Commit applies the batch on each owning member.  Batches spanning members are only
+// atomic per member, so the cluster doesn't support transactions and NewTransaction
+// returns ErrNoTransactions for it.
+func (b *clusterBatch) Commit() error {
+	return b.c.writeAll(b.writes)
+}
+
+// Number of key-value pairs moved per write during rebalancing.
+const rebalanceBatch = 1000
+
+// rebalance moves locally held data keys owned by other members to their owners and
+// returns the number of moved keys.
+func (c *clusterDB) rebalance() (moved int, err error) {
+	keys, err := c.OrderedKeyValueDB.KeysInRange(nil, []byte{dataKeyPrefix}, []byte{dataKeyPrefix + 1})
+	if err != nil {
+		return 0, err
+	}
+	byOwner := make(map[string][][]byte)
+	for _, k := range keys {
+		if partitionKey(k) == nil {
+			continue
+		}
+		if owner := c.owner(k); owner != c.self {
+			byOwner[owner] = append(byOwner[owner], k)
+		}
+	}
+	local := localPeer{c.OrderedKeyValueDB}
+	for owner, ownerKeys := range byOwner {
+		for beg := 0; beg < len(ownerKeys); beg += rebalanceBatch {
+			end := beg + rebalanceBatch
+			if end > len(ownerKeys) {
+				end = len(ownerKeys)
+			}
+			puts := make([]ClusterWrite, 0, end-beg)
+			deletes := make([]ClusterWrite, 0, end-beg)
+			for _, k := range ownerKeys[beg:end] {
+				v, err := local.Get(k)
+				if err != nil {
+					return moved, err
+				}
+				puts = append(puts, ClusterWrite{K: k, V: v})
+				deletes = append(deletes, ClusterWrite{K: k, Delete: true})
+			}
+			if err := c.peer(owner).Write(puts); err != nil {
+				return moved, fmt.Errorf("Unable to move keys to cluster member %s: %s", owner, err.Error())
+			}
+			if err := local.Write(deletes); err != nil {
+				return moved, err
+			}
+			moved += len(puts)
+		}
+	}
+	return moved, nil
+}
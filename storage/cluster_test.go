@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := NewHashRing(0, "http://a", "http://b", "http://c")
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("block %d", i)
+		owner := ring.Owner([]byte(key))
+		counts[owner]++
+		owners[key] = owner
+	}
+	for _, member := range ring.Members() {
+		if counts[member] < 500 {
+			t.Errorf("Member %s owns only %d of 3000 keys\n", member, counts[member])
+		}
+	}
+
+	// Adding a member only moves keys to the new member.
+	bigger := NewHashRing(0, "http://a", "http://b", "http://c", "http://d")
+	for key, owner := range owners {
+		if newOwner := bigger.Owner([]byte(key)); newOwner != owner && newOwner != "http://d" {
+			t.Fatalf("Key %q moved from %s to %s\n", key, owner, newOwner)
+		}
+	}
+	if NewHashRing(0).Owner([]byte("key")) != "" {
+		t.Errorf("Expected no owner on empty ring\n")
+	}
+}
+
+func TestClusterRouting(t *testing.T) {
+	names := []string{"http://a", "http://b", "http://c"}
+	dbs := map[string]*memoryDB{}
+	for _, name := range names {
+		dbs[name] = newMemoryDB()
+	}
+	peer := func(member string) ClusterPeer { return localPeer{dbs[member]} }
+	a := newClusterDB(dbs["http://a"], "http://a", "http://a", names[1:], peer)
+	b := newClusterDB(dbs["http://b"], "http://b", "http://a", []string{"http://a", "http://c"}, peer)
+
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 24)
+	for i := 0; i < 100; i++ {
+		if err := a.Put(ctx, []byte{byte(i)}, []byte{byte(i)}); err != nil {
+			t.Fatalf("Error on cluster put: %s\n", err.Error())
+		}
+	}
+	for _, name := range names {
+		if n := len(dbs[name].kv); n == 0 || n == 100 {
+			t.Errorf("Member %s holds %d of 100 keys\n", name, n)
+		}
+	}
+
+	// Other members read the same values, and ranges are merged in key order.
+	if v, err := b.Get(ctx, []byte{42}); err != nil || len(v) != 1 || v[0] != 42 {
+		t.Errorf("Bad value read through another member: %v, %v\n", v, err)
+	}
+	kvs, err := b.GetRange(ctx, []byte{10}, []byte{59})
+	if err != nil {
+		t.Fatalf("Error on cluster range: %s\n", err.Error())
+	}
+	if len(kvs) != 50 {
+		t.Fatalf("Expected 50 key-value pairs in range, got %d\n", len(kvs))
+	}
+	for i, kv := range kvs {
+		if kv.V[0] != byte(10+i) {
+			t.Fatalf("Range out of order at %d: %v\n", i, kv.V)
+		}
+	}
+
+	// Non-data keys are held by the coordinator.
+	if err := b.Put(MetadataContext{}, []byte("repos"), []byte("metadata")); err != nil {
+		t.Fatalf("Error on metadata put: %s\n", err.Error())
+	}
+	if v, _ := dbs["http://a"].Get(MetadataContext{}, []byte("repos")); string(v) != "metadata" {
+		t.Errorf("Metadata not held by coordinator: %q\n", v)
+	}
+
+	// Batches and range deletes reach all owners.
+	batch := b.NewBatch(ctx)
+	for i := 100; i < 110; i++ {
+		batch.Put([]byte{byte(i)}, []byte{byte(i)})
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on cluster batch: %s\n", err.Error())
+	}
+	if err := a.DeleteRange(ctx, []byte{0}, []byte{99}); err != nil {
+		t.Fatalf("Error on cluster range delete: %s\n", err.Error())
+	}
+	if keys, _ := b.KeysInRange(ctx, []byte{0}, []byte{255}); len(keys) != 10 {
+		t.Errorf("Expected 10 keys after range delete, got %d\n", len(keys))
+	}
+
+	// After a member joins, rebalancing moves keys to their new owners.
+	names = append(names, "http://d")
+	dbs["http://d"] = newMemoryDB()
+	a.setMembers(names[1:])
+	b.setMembers([]string{"http://a", "http://c", "http://d"})
+	var moved int
+	for _, c := range []*clusterDB{a, b, newClusterDB(dbs["http://c"], "http://c", "http://a", []string{"http://a", "http://b", "http://d"}, peer)} {
+		n, err := c.rebalance()
+		if err != nil {
+			t.Fatalf("Error rebalancing: %s\n", err.Error())
+		}
+		moved += n
+	}
+	if moved == 0 || moved != len(dbs["http://d"].kv) {
+		t.Errorf("Moved %d keys but new member holds %d\n", moved, len(dbs["http://d"].kv))
+	}
+	if keys, _ := a.KeysInRange(ctx, []byte{0}, []byte{255}); len(keys) != 10 {
+		t.Errorf("Expected 10 keys after rebalance, got %d\n", len(keys))
+	}
+}
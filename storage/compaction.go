@@ -0,0 +1,113 @@
+/*
+	This file supports manual and scheduled compaction of the storage engines so space
+	held by deleted or overwritten key-value pairs, e.g., after deleting a large data
+	instance, can be reclaimed without restarting the server.  Compaction of an engine
+	that supports it rewrites the sorted tables overlapping a key range, and engines can
+	report statistics on their tables by level.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// DefaultCompactionInterval is the default time between scheduled compactions.
+const DefaultCompactionInterval = 24 * time.Hour
+
+// Compacter is implemented by storage engines that can compact key ranges.
+type Compacter interface {
+	// CompactRange compacts the stored keys from kStart to kEnd.  A nil kStart or kEnd
+	// denotes the beginning or end of the database.
+	CompactRange(kStart, kEnd []byte) error
+
+	// LevelStats returns the engine's statistics of its sorted tables by level.
+	LevelStats() (string, error)
+}
+
+// CompactionStats reports the table statistics of one storage engine.
+type CompactionStats struct {
+	Engine string
+	Levels string
+}
+
+// CompactionSchedule gives the start of a daily window and the interval at which
+// compactions of all storage are started.
+type CompactionSchedule struct {
+	// Start is the time since local midnight of the first compaction each day.
+	Start time.Duration
+
+	// Interval is the time between compactions.
+	Interval time.Duration
+}
+
+// ParseCompactionSchedule parses a start time in "HH:MM" format and an interval, which
+// is DefaultCompactionInterval if empty.
+func ParseCompactionSchedule(start, interval string) (CompactionSchedule, error) {
+	var schedule CompactionSchedule
+	parts := strings.Split(start, ":")
+	if len(parts) != 2 {
+		return schedule, fmt.Errorf("Bad compaction start %q: must be HH:MM", start)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return schedule, fmt.Errorf("Bad compaction start hour in %q", start)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return schedule, fmt.Errorf("Bad compaction start minute in %q", start)
+	}
+	schedule.Start = time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+	schedule.Interval = DefaultCompactionInterval
+	if interval != "" {
+		if schedule.Interval, err = time.ParseDuration(interval); err != nil {
+			return schedule, fmt.Errorf("Bad compaction interval %q: %s", interval, err.Error())
+		}
+		if schedule.Interval < time.Minute {
+			return schedule, fmt.Errorf("Compaction interval must be at least a minute, not %s", schedule.Interval)
+		}
+	}
+	return schedule, nil
+}
+
+// Next returns the first compaction time of the schedule after the given time.
+func (s CompactionSchedule) Next(now time.Time) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(s.Start)
+	if next.After(now) {
+		return next
+	}
+	next = next.Add(now.Sub(next).Truncate(s.Interval) + s.Interval)
+
+	// Compactions restart at the start time each day.
+	if tomorrow := midnight.AddDate(0, 0, 1).Add(s.Start); next.After(tomorrow) {
+		return tomorrow
+	}
+	return next
+}
+
+func (s CompactionSchedule) String() string {
+	start := fmt.Sprintf("%02d:%02d", int(s.Start/time.Hour), int(s.Start%time.Hour/time.Minute))
+	return fmt.Sprintf("starting %s daily every %s", start, s.Interval)
+}
+
+// compacters returns the databases that support compaction, with an error if none do.
+func compacters(dbs []OrderedKeyValueDB) ([]Compacter, error) {
+	var cs []Compacter
+	for _, db := range dbs {
+		if c, ok := db.(Compacter); ok {
+			cs = append(cs, c)
+		} else {
+			dvid.Infof("Skipping compaction of %q, which doesn't support it\n", db)
+		}
+	}
+	if len(cs) == 0 {
+		return nil, fmt.Errorf("No storage engine supports compaction")
+	}
+	return cs, nil
+}
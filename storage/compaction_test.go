@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+type compactedDB struct {
+	*memoryDB
+	ranges int
+}
+
+func (db *compactedDB) CompactRange(kStart, kEnd []byte) error {
+	db.ranges++
+	return nil
+}
+
+func (db *compactedDB) LevelStats() (string, error) {
+	return "level 0: 1 file", nil
+}
+
+func TestParseCompactionSchedule(t *testing.T) {
+	schedule, err := ParseCompactionSchedule("02:30", "6h")
+	if err != nil {
+		t.Fatalf("Error parsing compaction schedule: %s\n", err.Error())
+	}
+	if schedule.Start != 2*time.Hour+30*time.Minute || schedule.Interval != 6*time.Hour {
+		t.Errorf("Bad compaction schedule: %s\n", schedule)
+	}
+	for _, bad := range []string{"2", "24:00", "02:60", "ab:00"} {
+		if _, err := ParseCompactionSchedule(bad, ""); err == nil {
+			t.Errorf("Expected error parsing compaction start %q\n", bad)
+		}
+	}
+	if _, err := ParseCompactionSchedule("02:30", "1s"); err == nil {
+		t.Errorf("Expected error for too short compaction interval\n")
+	}
+}
+
+func TestCompactionScheduleNext(t *testing.T) {
+	schedule := CompactionSchedule{Start: 2*time.Hour + 30*time.Minute, Interval: 6 * time.Hour}
+	day := func(d, h, m int) time.Time {
+		return time.Date(2016, time.March, d, h, m, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		now, next time.Time
+	}{
+		{day(10, 1, 0), day(10, 2, 30)},
+		{day(10, 2, 30), day(10, 8, 30)},
+		{day(10, 9, 0), day(10, 14, 30)},
+		{day(10, 21, 0), day(11, 2, 30)},
+	}
+	for _, test := range tests {
+		if next := schedule.Next(test.now); !next.Equal(test.next) {
+			t.Errorf("Expected compaction after %s at %s, got %s\n", test.now, test.next, next)
+		}
+	}
+
+	// Intervals that don't divide a day restart at the start time.
+	schedule.Interval = 5 * time.Hour
+	if next := schedule.Next(day(10, 23, 0)); !next.Equal(day(11, 2, 30)) {
+		t.Errorf("Expected compaction at next day's start, got %s\n", next)
+	}
+}
+
+func TestCompacters(t *testing.T) {
+	compacted := &compactedDB{memoryDB: newMemoryDB()}
+	cs, err := compacters([]OrderedKeyValueDB{newMemoryDB(), compacted})
+	if err != nil {
+		t.Fatalf("Error getting compacters: %s\n", err.Error())
+	}
+	if len(cs) != 1 {
+		t.Fatalf("Expected 1 compacter, got %d\n", len(cs))
+	}
+	if err := cs[0].CompactRange(nil, nil); err != nil || compacted.ranges != 1 {
+		t.Errorf("Expected database to be compacted\n")
+	}
+	if _, err := compacters([]OrderedKeyValueDB{newMemoryDB()}); err == nil {
+		t.Errorf("Expected error when no database supports compaction\n")
+	}
+}
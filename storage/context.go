@@ -6,6 +6,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
@@ -75,6 +76,35 @@ type VersionIterator interface {
 	Next()
 }
 
+// ErrCanceled is returned by storage operations that stopped because the request using
+// them was canceled, e.g., when an HTTP client disconnected.
+var ErrCanceled = errors.New("request canceled")
+
+// canceler is implemented by contexts whose requests can be canceled.
+type canceler interface {
+	Done() <-chan struct{}
+}
+
+// Canceled returns true if the request using the given context has been canceled.
+// Storage engines check it while iterating through ranges so abandoned requests stop
+// reading.
+func Canceled(ctx Context) bool {
+	c, ok := ctx.(canceler)
+	if !ok {
+		return false
+	}
+	done := c.Done()
+	if done == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
 var contextMutexes map[mutexID]*sync.Mutex
 
 func init() {
@@ -86,6 +116,8 @@ func init() {
 const (
 	metadataKeyPrefix byte = iota
 	dataKeyPrefix
+	graphKeyPrefix
+	blobKeyPrefix
 )
 
 // MetadataContext is an implementation of Context for MetadataContext persistence.
@@ -130,6 +162,15 @@ func (ctx MetadataContext) Versioned() bool {
 type DataContext struct {
 	data    dvid.Data
 	version dvid.VersionID
+
+	// client making requests through this context, if known.
+	client string
+
+	// done is closed when the request using this context is canceled.
+	done <-chan struct{}
+
+	// durability requested for writes through this context.
+	durability Durability
 }
 
 // MinDataContextKeyRange returns the minimum and maximum key for data with a given local
@@ -150,12 +191,13 @@ func DataContextKeyRange(instanceID dvid.InstanceID) (minKey, maxKey []byte) {
 // only be implemented within package storage, we force compatible implementations to embed
 // DataContext and initialize it via this function.
 func NewDataContext(data dvid.Data, versionID dvid.VersionID) *DataContext {
-	return &DataContext{data, versionID}
+	return &DataContext{data: data, version: versionID}
 }
 
-// KeyToLocalIDs parses a key under a DataContext and returns instance and version ids.
+// KeyToLocalIDs parses a key under a DataContext or its graph partition and returns
+// instance and version ids.
 func KeyToLocalIDs(k []byte) (dvid.InstanceID, dvid.VersionID, error) {
-	if k[0] != dataKeyPrefix {
+	if k[0] != dataKeyPrefix && k[0] != graphKeyPrefix {
 		return 0, 0, fmt.Errorf("Cannot extract local IDs from a non-DataContext key")
 	}
 	instanceID := dvid.InstanceIDFromBytes(k[1 : 1+dvid.InstanceIDSize])
@@ -229,6 +271,40 @@ func (ctx *DataContext) Versioned() bool {
 	return ctx.data.Versioned()
 }
 
+// SetClient sets the client, e.g., an API token name or remote address, making requests
+// through this context so mutations can be attributed.
+func (ctx *DataContext) SetClient(client string) {
+	ctx.client = client
+}
+
+// Client returns the client making requests through this context, if known.
+func (ctx *DataContext) Client() string {
+	return ctx.client
+}
+
+// SetDone sets a channel that is closed when the request using this context is
+// canceled, e.g., the Done() channel of a request's context.Context.
+func (ctx *DataContext) SetDone(done <-chan struct{}) {
+	ctx.done = done
+}
+
+// Done returns a channel closed when the request using this context is canceled, or
+// nil if the request can't be canceled.
+func (ctx *DataContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+// SetDurability sets the durability requested for writes through this context.
+func (ctx *DataContext) SetDurability(d Durability) {
+	ctx.durability = d
+}
+
+// Durability returns the durability requested for writes through this context, which
+// is DurabilityDefault if the instance or server default should be used.
+func (ctx *DataContext) Durability() Durability {
+	return ctx.durability
+}
+
 // ----- partial storage.VersionedContext implementation
 
 // Returns lower bound key for versions of given byte slice key representation.
@@ -244,3 +320,98 @@ func (ctx *DataContext) MaxVersionKey(index []byte) ([]byte, error) {
 	key = append(key, index...)
 	return append(key, dvid.VersionID(dvid.MaxVersionID).Bytes()...), nil
 }
+
+// ---- Graph partition of the key space
+
+// GraphContextKeyRange returns the minimum and maximum key for graph data with a given
+// local instance id.  Like DataContextKeyRange, the returned keys are full DVID keys to be
+// used with nil storage.Context.
+func GraphContextKeyRange(instanceID dvid.InstanceID) (minKey, maxKey []byte) {
+	minKey, maxKey = DataContextKeyRange(instanceID)
+	minKey[0] = graphKeyPrefix
+	maxKey[0] = graphKeyPrefix
+	return minKey, maxKey
+}
+
+// NewGraphContext returns a Context that keeps the key-value pairs of a data context in
+// the graph partition of the key space, so graph stores layered on a key-value database
+// can't collide with other data of the instance.  Keys are otherwise identical to those
+// of the given context, including versioning.
+func NewGraphContext(ctx Context) Context {
+	switch c := ctx.(type) {
+	case nil:
+		return nil
+	case *graphContext, *versionedGraphContext:
+		return ctx
+	case VersionedContext:
+		return &versionedGraphContext{c}
+	default:
+		return &graphContext{c}
+	}
+}
+
+// toGraphKey moves a data key into the graph partition.
+func toGraphKey(key []byte) []byte {
+	if len(key) != 0 && key[0] == dataKeyPrefix {
+		key[0] = graphKeyPrefix
+	}
+	return key
+}
+
+// fromGraphKey returns the data key corresponding to a graph key.
+func fromGraphKey(key []byte) ([]byte, error) {
+	if len(key) == 0 || key[0] != graphKeyPrefix {
+		return nil, fmt.Errorf("Cannot extract graph index from non-graph key")
+	}
+	return append([]byte{dataKeyPrefix}, key[1:]...), nil
+}
+
+type graphContext struct {
+	Context
+}
+
+func (ctx *graphContext) ConstructKey(index []byte) []byte {
+	return toGraphKey(ctx.Context.ConstructKey(index))
+}
+
+func (ctx *graphContext) IndexFromKey(key []byte) ([]byte, error) {
+	dataKey, err := fromGraphKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Context.IndexFromKey(dataKey)
+}
+
+func (ctx *graphContext) String() string {
+	return "Graph partition of " + ctx.Context.String()
+}
+
+type versionedGraphContext struct {
+	VersionedContext
+}
+
+func (ctx *versionedGraphContext) ConstructKey(index []byte) []byte {
+	return toGraphKey(ctx.VersionedContext.ConstructKey(index))
+}
+
+func (ctx *versionedGraphContext) IndexFromKey(key []byte) ([]byte, error) {
+	dataKey, err := fromGraphKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.VersionedContext.IndexFromKey(dataKey)
+}
+
+func (ctx *versionedGraphContext) MinVersionKey(index []byte) ([]byte, error) {
+	key, err := ctx.VersionedContext.MinVersionKey(index)
+	return toGraphKey(key), err
+}
+
+func (ctx *versionedGraphContext) MaxVersionKey(index []byte) ([]byte, error) {
+	key, err := ctx.VersionedContext.MaxVersionKey(index)
+	return toGraphKey(key), err
+}
+
+func (ctx *versionedGraphContext) String() string {
+	return "Graph partition of " + ctx.VersionedContext.String()
+}
@@ -1,6 +1,10 @@
 package storage
 
-import "github.com/janelia-flyem/dvid/dvid"
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
 
 var (
 	TestUUID1         dvid.UUID = "01"
@@ -54,5 +58,24 @@ func GetTestDataContext(uuid dvid.UUID, name string, instanceID dvid.InstanceID)
 		return nil
 	}
 	data := &testData{uuid, dvid.DataString(name), instanceID}
-	return &DataContext{data, versionID}
+	return &DataContext{data: data, version: versionID}
+}
+
+func TestCanceled(t *testing.T) {
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 13)
+	if Canceled(ctx) {
+		t.Errorf("Context without done channel should not be canceled\n")
+	}
+	done := make(chan struct{})
+	ctx.SetDone(done)
+	if Canceled(ctx) {
+		t.Errorf("Context should not be canceled before done channel is closed\n")
+	}
+	close(done)
+	if !Canceled(ctx) {
+		t.Errorf("Context should be canceled after done channel is closed\n")
+	}
+	if Canceled(NewMetadataContext()) {
+		t.Errorf("Metadata context should never be canceled\n")
+	}
 }
@@ -0,0 +1,223 @@
+/*
+	This file implements an engine wrapper that makes versioned writes copy-on-write.
+	Versioned data keys end with the version ID, so a read on a child version already
+	resolves to the closest ancestor holding the key.  Without the wrapper, any write to
+	a child version stores a full value even if it's identical to the ancestor's, e.g.,
+	when a subvolume write rewrites whole voxel blocks whose contents didn't change.
+
+	Before each versioned write, the wrapper resolves the value the version inherits from
+	its ancestors.  If the new value is identical, the write is skipped and, if the
+	version held its own copy, that copy is deleted so the ancestor's value shows
+	through.  Only modified values are stored per version.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// CopyOnWriteStats gives statistics for copy-on-write versioned storage.
+type CopyOnWriteStats struct {
+	// Versioned writes checked against ancestor values.
+	Writes uint64
+
+	// Writes skipped or reverted to ancestor values because they were identical.
+	Deduped uint64
+
+	// Value bytes not stored due to deduplication.
+	BytesSaved uint64
+}
+
+type cowAction uint8
+
+const (
+	cowPut    cowAction = iota // store the value
+	cowSkip                    // value is inherited by the version, which has no copy
+	cowKeep                    // value is already stored as the version's own copy
+	cowRevert                  // delete the version's copy to expose the identical ancestor value
+)
+
+// copyOnWrite wraps an ordered key-value store and dedupes versioned writes.
+type copyOnWrite struct {
+	OrderedKeyValueDB
+
+	writes, deduped, bytesSaved uint64
+}
+
+func newCopyOnWrite(db OrderedKeyValueDB) (*copyOnWrite, error) {
+	if _, ok := db.(KeyValueBatcher); !ok {
+		return nil, fmt.Errorf("Copy-on-write requires a database that supports batches, %q does not", db)
+	}
+	return &copyOnWrite{OrderedKeyValueDB: db}, nil
+}
+
+func (c *copyOnWrite) String() string {
+	return fmt.Sprintf("%s with copy-on-write versions", c.OrderedKeyValueDB)
+}
+
+// Stats returns the current copy-on-write statistics.
+func (c *copyOnWrite) Stats() CopyOnWriteStats {
+	return CopyOnWriteStats{
+		Writes:     atomic.LoadUint64(&c.writes),
+		Deduped:    atomic.LoadUint64(&c.deduped),
+		BytesSaved: atomic.LoadUint64(&c.bytesSaved),
+	}
+}
+
+// resolve returns the value a version inherits from its ancestors for an index,
+// ignoring any value stored by the version itself, as well as the version's own value.
+func (c *copyOnWrite) resolve(vctx VersionedContext, index []byte) (inherited, own []byte, err error) {
+	minKey, err := vctx.MinVersionKey(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxKey, err := vctx.MaxVersionKey(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := c.OrderedKeyValueDB.GetRange(nil, minKey, maxKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ancestors := make([]*KeyValue, 0, len(values))
+	for _, kv := range values {
+		// Skip longer indices that share this index as a prefix.
+		if len(kv.K) != len(minKey) {
+			continue
+		}
+		_, versionID, err := KeyToLocalIDs(kv.K)
+		if err != nil {
+			return nil, nil, err
+		}
+		if versionID == vctx.VersionID() {
+			own = kv.V
+			continue
+		}
+		ancestors = append(ancestors, kv)
+	}
+	if len(ancestors) == 0 {
+		return nil, own, nil
+	}
+	kv, err := vctx.VersionedKeyValue(ancestors)
+	if err != nil || kv == nil {
+		return nil, own, err
+	}
+	return kv.V, own, nil
+}
+
+// action determines how a write should be stored.  Unversioned writes are always
+// stored, as are writes where the ancestor value can't be resolved.
+func (c *copyOnWrite) action(ctx Context, index, v []byte) cowAction {
+	if ctx == nil || !ctx.Versioned() {
+		return cowPut
+	}
+	vctx, ok := ctx.(VersionedContext)
+	if !ok {
+		return cowPut
+	}
+	atomic.AddUint64(&c.writes, 1)
+	inherited, own, err := c.resolve(vctx, index)
+	if err != nil {
+		dvid.Errorf("Storing full value after copy-on-write resolution error: %s\n", err.Error())
+		return cowPut
+	}
+	switch {
+	case inherited != nil && bytes.Equal(inherited, v):
+		atomic.AddUint64(&c.deduped, 1)
+		atomic.AddUint64(&c.bytesSaved, uint64(len(v)))
+		if own != nil {
+			return cowRevert
+		}
+		return cowSkip
+	case own != nil && bytes.Equal(own, v):
+		return cowKeep
+	default:
+		return cowPut
+	}
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (c *copyOnWrite) Put(ctx Context, k, v []byte) error {
+	switch c.action(ctx, k, v) {
+	case cowSkip, cowKeep:
+		return nil
+	case cowRevert:
+		return c.OrderedKeyValueDB.Delete(ctx, k)
+	default:
+		return c.OrderedKeyValueDB.Put(ctx, k, v)
+	}
+}
+
+func (c *copyOnWrite) PutRange(ctx Context, values []KeyValue) error {
+	puts := make([]KeyValue, 0, len(values))
+	for _, kv := range values {
+		switch c.action(ctx, kv.K, kv.V) {
+		case cowSkip, cowKeep:
+		case cowRevert:
+			if err := c.OrderedKeyValueDB.Delete(ctx, kv.K); err != nil {
+				return err
+			}
+		default:
+			puts = append(puts, kv)
+		}
+	}
+	if len(puts) == 0 {
+		return nil
+	}
+	return c.OrderedKeyValueDB.PutRange(ctx, puts)
+}
+
+// ---- KeyValueBatcher interface ------
+
+type cowBatch struct {
+	Batch
+	cow *copyOnWrite
+	ctx Context
+
+	// keys with puts or deletes already added to the batch
+	written map[string]struct{}
+}
+
+func (c *copyOnWrite) NewBatch(ctx Context) Batch {
+	return c.wrapBatch(ctx, c.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (c *copyOnWrite) wrappedStore() OrderedKeyValueSetter { return c.OrderedKeyValueDB }
+
+func (c *copyOnWrite) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &cowBatch{Batch: newBatch(ctx), cow: c, ctx: ctx, written: make(map[string]struct{})}
+}
+
+// Put adds a put to the batch unless the value is inherited from an ancestor.  Values
+// are resolved against committed data, so if the key was already written in this batch,
+// the earlier operation is overridden by a put or delete that leaves the committed value
+// visible.
+func (b *cowBatch) Put(k, v []byte) {
+	_, rewrite := b.written[string(k)]
+	b.written[string(k)] = struct{}{}
+	switch b.cow.action(b.ctx, k, v) {
+	case cowSkip:
+		if rewrite {
+			b.Batch.Delete(k)
+		}
+	case cowKeep:
+		if rewrite {
+			b.Batch.Put(k, v)
+		}
+	case cowRevert:
+		b.Batch.Delete(k)
+	default:
+		b.Batch.Put(k, v)
+	}
+}
+
+func (b *cowBatch) Delete(k []byte) {
+	b.written[string(k)] = struct{}{}
+	b.Batch.Delete(k)
+}
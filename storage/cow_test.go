@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// testVersionedContext is a versioned data context with a fixed ancestor path.
+type testVersionedContext struct {
+	*DataContext
+	ancestry []dvid.VersionID
+}
+
+func (ctx testVersionedContext) Versioned() bool { return true }
+
+func (ctx testVersionedContext) GetIterator() (VersionIterator, error) { return nil, nil }
+
+func (ctx testVersionedContext) VersionedKeyValue(values []*KeyValue) (*KeyValue, error) {
+	for _, versionID := range ctx.ancestry {
+		for _, kv := range values {
+			_, v, err := KeyToLocalIDs(kv.K)
+			if err != nil {
+				return nil, err
+			}
+			if v == versionID {
+				return kv, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	db := newMemoryDB()
+	cow, err := newCopyOnWrite(db)
+	if err != nil {
+		t.Fatalf("Can't create copy-on-write store: %s\n", err.Error())
+	}
+	parent := testVersionedContext{GetTestDataContext(TestUUID1, "grayscale", 1), []dvid.VersionID{1}}
+	child := testVersionedContext{GetTestDataContext(TestUUID2, "grayscale", 1), []dvid.VersionID{2, 1}}
+
+	for i := byte(0); i < 4; i++ {
+		if err := cow.Put(parent, []byte{i}, []byte{i, i}); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+	if len(db.kv) != 4 {
+		t.Fatalf("Expected 4 stored parent values, got %d\n", len(db.kv))
+	}
+
+	// Rewriting unchanged values in the child shouldn't store anything.
+	batch := cow.NewBatch(child)
+	for i := byte(0); i < 4; i++ {
+		v := []byte{i, i}
+		if i == 3 {
+			v = []byte{9, 9}
+		}
+		batch.Put([]byte{i}, v)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	if len(db.kv) != 5 {
+		t.Fatalf("Expected only modified child value stored, got %d values\n", len(db.kv))
+	}
+	if v := db.kv[string(child.ConstructKey([]byte{3}))]; !bytes.Equal(v, []byte{9, 9}) {
+		t.Errorf("Bad modified child value: %v\n", v)
+	}
+
+	// Restoring the ancestor's value removes the child's copy.
+	if err := cow.PutRange(child, []KeyValue{{[]byte{3}, []byte{3, 3}}, {[]byte{4}, []byte{4}}}); err != nil {
+		t.Fatalf("Error on put range: %s\n", err.Error())
+	}
+	if _, found := db.kv[string(child.ConstructKey([]byte{3}))]; found {
+		t.Errorf("Expected child copy to be removed after restoring ancestor value\n")
+	}
+	if len(db.kv) != 5 {
+		t.Errorf("Expected 5 stored values, got %d\n", len(db.kv))
+	}
+
+	// Unversioned writes are always stored.
+	unversioned := GetTestDataContext(TestUUID2, "labels", 2)
+	if err := cow.Put(unversioned, []byte{0}, []byte{0, 0}); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if len(db.kv) != 6 {
+		t.Errorf("Expected unversioned write to be stored\n")
+	}
+
+	stats := cow.Stats()
+	if stats.Writes != 10 || stats.Deduped != 4 || stats.BytesSaved != 8 {
+		t.Errorf("Bad copy-on-write stats: %+v\n", stats)
+	}
+}
+
+func TestCopyOnWriteBatchRewrite(t *testing.T) {
+	db := newMemoryDB()
+	cow, err := newCopyOnWrite(db)
+	if err != nil {
+		t.Fatalf("Can't create copy-on-write store: %s\n", err.Error())
+	}
+	parent := testVersionedContext{GetTestDataContext(TestUUID1, "grayscale", 1), []dvid.VersionID{1}}
+	child := testVersionedContext{GetTestDataContext(TestUUID2, "grayscale", 1), []dvid.VersionID{2, 1}}
+
+	if err := cow.Put(parent, []byte{0}, []byte{1}); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if err := cow.Put(child, []byte{1}, []byte{2}); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+
+	// Later puts in a batch that restore the committed value override earlier puts.
+	batch := cow.NewBatch(child)
+	batch.Put([]byte{0}, []byte{9})
+	batch.Put([]byte{0}, []byte{1})
+	batch.Put([]byte{1}, []byte{9})
+	batch.Put([]byte{1}, []byte{2})
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	if v, found := db.kv[string(child.ConstructKey([]byte{0}))]; found {
+		t.Errorf("Expected no child copy of inherited value, got %v\n", v)
+	}
+	if v := db.kv[string(child.ConstructKey([]byte{1}))]; !bytes.Equal(v, []byte{2}) {
+		t.Errorf("Expected child's own value to be kept, got %v\n", v)
+	}
+	if len(db.kv) != 2 {
+		t.Errorf("Expected 2 stored values, got %d\n", len(db.kv))
+	}
+}
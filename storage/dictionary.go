@@ -0,0 +1,433 @@
+/*
+	This file implements an engine wrapper that compresses values using zstd dictionaries
+	trained on sampled values of a keyspace.  Keyspaces dominated by small, similar values,
+	e.g., annotations, RLE spans, or label map entries, compress poorly on their own but
+	well against a dictionary of their common substrings.
+
+	A keyspace is the set of keys of a data instance whose type-specific indices share a
+	first byte, which datatypes use to partition their key space.  Dictionaries are stored
+	in the metadata store and never deleted, so a keyspace can be retrained while values
+	compressed with older dictionaries remain readable.  Compressed values have the form
+
+		dictMagic (4 bytes) + dictionary ID (4 bytes) + zstd frame
+
+	where a dictionary ID of 0 marks an uncompressed value that happens to begin with the
+	magic bytes.  Values without the header, e.g., those stored before a keyspace was
+	trained, are returned unchanged.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/valyala/gozstd"
+)
+
+// The metadata index prefix for value dictionaries.  Keys append the data instance ID,
+// key type, and big-endian dictionary ID.
+var dictionaryIndexPrefix = []byte{0xA8, 'd', 'i', 'c', 't'}
+
+var dictMagic = []byte{0xFF, 'D', 'V', 'Z'}
+
+const dictHeaderSize = 8 // magic + dictionary ID
+
+const (
+	// DefaultDictSamples is the default maximum number of values sampled for training.
+	DefaultDictSamples = 10000
+
+	// DefaultDictSize is the default size in bytes of a trained dictionary.
+	DefaultDictSize = 64 * 1024
+
+	// MaxDictSampleBytes is the size of the largest value sampled for training.  Larger
+	// values compress well without a dictionary.
+	MaxDictSampleBytes = 16 * 1024
+
+	// minDictSamples is the fewest sampled values from which a dictionary is trained.
+	minDictSamples = 16
+)
+
+// Keyspace identifies the keys of a data instance whose type-specific indices begin
+// with the same key type byte.
+type Keyspace struct {
+	Instance dvid.InstanceID
+	KeyType  byte
+}
+
+func (ks Keyspace) String() string {
+	return fmt.Sprintf("instance %d, key type %d", ks.Instance, ks.KeyType)
+}
+
+// keyspaceOf returns the keyspace of a key or false if it isn't a data key.
+func keyspaceOf(ctx Context, k []byte) (Keyspace, bool) {
+	key := k
+	if ctx != nil {
+		key = ctx.ConstructKey(k)
+	}
+	if len(key) < 2+dvid.InstanceIDSize || key[0] != dataKeyPrefix {
+		return Keyspace{}, false
+	}
+	instanceID := dvid.InstanceIDFromBytes(key[1 : 1+dvid.InstanceIDSize])
+	return Keyspace{instanceID, key[1+dvid.InstanceIDSize]}, true
+}
+
+func dictionaryIndex(ks Keyspace, id uint32) []byte {
+	index := make([]byte, len(dictionaryIndexPrefix)+dvid.InstanceIDSize+5)
+	n := copy(index, dictionaryIndexPrefix)
+	n += copy(index[n:], ks.Instance.Bytes())
+	index[n] = ks.KeyType
+	binary.BigEndian.PutUint32(index[n+1:], id)
+	return index
+}
+
+// valueDictionary is a trained dictionary prepared for compression and decompression.
+type valueDictionary struct {
+	id    uint32
+	cdict *gozstd.CDict
+	ddict *gozstd.DDict
+}
+
+func newValueDictionary(id uint32, dict []byte) (*valueDictionary, error) {
+	cdict, err := gozstd.NewCDict(dict)
+	if err != nil {
+		return nil, err
+	}
+	ddict, err := gozstd.NewDDict(dict)
+	if err != nil {
+		return nil, err
+	}
+	return &valueDictionary{id, cdict, ddict}, nil
+}
+
+// valueDictionaries holds all dictionaries, loaded from the metadata store.
+type valueDictionaries struct {
+	metadata OrderedKeyValueDB
+
+	mu      sync.RWMutex
+	all     map[Keyspace]map[uint32]*valueDictionary
+	current map[Keyspace]*valueDictionary
+}
+
+func loadValueDictionaries(metadata OrderedKeyValueDB) (*valueDictionaries, error) {
+	dicts := &valueDictionaries{
+		metadata: metadata,
+		all:      make(map[Keyspace]map[uint32]*valueDictionary),
+		current:  make(map[Keyspace]*valueDictionary),
+	}
+	minIndex := dictionaryIndexPrefix
+	maxIndex := append(append([]byte{}, dictionaryIndexPrefix...), 0xFF)
+	kvs, err := metadata.GetRange(NewMetadataContext(), minIndex, maxIndex)
+	if err != nil {
+		return nil, err
+	}
+	var ctx MetadataContext
+	for _, kv := range kvs {
+		index, err := ctx.IndexFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		n := len(dictionaryIndexPrefix)
+		if len(index) != n+dvid.InstanceIDSize+5 {
+			return nil, fmt.Errorf("Bad value dictionary key %v", kv.K)
+		}
+		ks := Keyspace{dvid.InstanceIDFromBytes(index[n : n+dvid.InstanceIDSize]), index[n+dvid.InstanceIDSize]}
+		id := binary.BigEndian.Uint32(index[n+dvid.InstanceIDSize+1:])
+		if err := dicts.add(ks, id, kv.V); err != nil {
+			return nil, fmt.Errorf("Bad dictionary %d for %s: %s", id, ks, err.Error())
+		}
+	}
+	return dicts, nil
+}
+
+// add prepares a dictionary for use.  The dictionary with the highest ID in a keyspace
+// is used to compress new values.
+func (dicts *valueDictionaries) add(ks Keyspace, id uint32, dict []byte) error {
+	vd, err := newValueDictionary(id, dict)
+	if err != nil {
+		return err
+	}
+	dicts.mu.Lock()
+	defer dicts.mu.Unlock()
+	if dicts.all[ks] == nil {
+		dicts.all[ks] = make(map[uint32]*valueDictionary)
+	}
+	dicts.all[ks][id] = vd
+	if cur, found := dicts.current[ks]; !found || cur.id < id {
+		dicts.current[ks] = vd
+	}
+	return nil
+}
+
+func (dicts *valueDictionaries) get(ks Keyspace, id uint32) *valueDictionary {
+	dicts.mu.RLock()
+	defer dicts.mu.RUnlock()
+	return dicts.all[ks][id]
+}
+
+func (dicts *valueDictionaries) currentFor(ks Keyspace) *valueDictionary {
+	dicts.mu.RLock()
+	defer dicts.mu.RUnlock()
+	return dicts.current[ks]
+}
+
+// DictionaryReport describes a trained dictionary and its effect on sampled values.
+type DictionaryReport struct {
+	Keyspace Keyspace
+	ID       uint32
+	Size     int
+
+	// Values is the number of values scanned and Sampled the number used for training.
+	Values  uint64
+	Sampled int
+
+	// SampleBytes is the size of the sampled values, which compress to CompressedBytes
+	// using the dictionary.
+	SampleBytes     uint64
+	CompressedBytes uint64
+}
+
+func (r *DictionaryReport) String() string {
+	var ratio float64
+	if r.CompressedBytes != 0 {
+		ratio = float64(r.SampleBytes) / float64(r.CompressedBytes)
+	}
+	return fmt.Sprintf("Dictionary %d for %s: %d bytes trained on %d of %d values, %.2fx compression of samples",
+		r.ID, r.Keyspace, r.Size, r.Sampled, r.Values, ratio)
+}
+
+// train builds a new dictionary from a random sample of small values of a keyspace
+// read from the given stores, and stores it in the metadata store.
+func (dicts *valueDictionaries) train(dbs []OrderedKeyValueDB, ks Keyspace, maxSamples, dictSize int) (*DictionaryReport, error) {
+	if maxSamples <= 0 {
+		maxSamples = DefaultDictSamples
+	}
+	if dictSize <= 0 {
+		dictSize = DefaultDictSize
+	}
+	report := &DictionaryReport{Keyspace: ks}
+
+	// Reservoir sample the values so every small value is equally likely to be used.
+	var samples [][]byte
+	var numSmall int
+	minKey, maxKey := keyspaceKeyRange(ks)
+	for _, db := range dbs {
+		err := db.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+			if chunk == nil || chunk.KeyValue == nil {
+				return
+			}
+			report.Values++
+			if len(chunk.V) == 0 || len(chunk.V) > MaxDictSampleBytes {
+				return
+			}
+			numSmall++
+			if len(samples) < maxSamples {
+				samples = append(samples, append([]byte{}, chunk.V...))
+			} else if i := rand.Intn(numSmall); i < maxSamples {
+				samples[i] = append([]byte{}, chunk.V...)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(samples) < minDictSamples {
+		return nil, fmt.Errorf("Only %d values of at most %d bytes in %s, need %d to train dictionary",
+			len(samples), MaxDictSampleBytes, ks, minDictSamples)
+	}
+	report.Sampled = len(samples)
+
+	dict := gozstd.BuildDict(samples, dictSize)
+	if len(dict) == 0 {
+		return nil, fmt.Errorf("Unable to train dictionary for %s from %d values", ks, len(samples))
+	}
+	report.Size = len(dict)
+	vd, err := newValueDictionary(0, dict)
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range samples {
+		report.SampleBytes += uint64(len(sample))
+		report.CompressedBytes += uint64(len(gozstd.CompressDict(nil, sample, vd.cdict)))
+	}
+
+	// Store the dictionary before using it so compressed values are always readable.
+	var id uint32 = 1
+	if cur := dicts.currentFor(ks); cur != nil {
+		id = cur.id + 1
+	}
+	if err := dicts.metadata.Put(NewMetadataContext(), dictionaryIndex(ks, id), dict); err != nil {
+		return nil, err
+	}
+	if err := dicts.add(ks, id, dict); err != nil {
+		return nil, err
+	}
+	report.ID = id
+	return report, nil
+}
+
+// keyspaceKeyRange returns the minimum and maximum full keys of a keyspace.
+func keyspaceKeyRange(ks Keyspace) (minKey, maxKey []byte) {
+	minKey, maxKey = DataContextKeyRange(ks.Instance)
+	minKey = append(minKey, ks.KeyType)
+	if ks.KeyType != 0xFF {
+		maxKey = append(minKey[:len(minKey)-1:len(minKey)-1], ks.KeyType+1)
+	}
+	return
+}
+
+// dictCompressor wraps an ordered key-value store and compresses values of keyspaces
+// with trained dictionaries.
+type dictCompressor struct {
+	OrderedKeyValueDB
+	dicts *valueDictionaries
+}
+
+func newDictCompressor(db OrderedKeyValueDB, dicts *valueDictionaries) (*dictCompressor, error) {
+	if _, ok := db.(KeyValueBatcher); !ok {
+		return nil, fmt.Errorf("Value dictionaries require a database that supports batches, %q does not", db)
+	}
+	return &dictCompressor{db, dicts}, nil
+}
+
+func (c *dictCompressor) String() string {
+	return fmt.Sprintf("%s with value dictionaries", c.OrderedKeyValueDB)
+}
+
+// compress returns the value to store for a key.
+func (c *dictCompressor) compress(ctx Context, k, v []byte) []byte {
+	ks, isData := keyspaceOf(ctx, k)
+	if !isData || len(v) == 0 {
+		return v
+	}
+	vd := c.dicts.currentFor(ks)
+	if vd == nil {
+		return v
+	}
+	header := make([]byte, dictHeaderSize)
+	copy(header, dictMagic)
+	binary.LittleEndian.PutUint32(header[len(dictMagic):], vd.id)
+	compressed := gozstd.CompressDict(header, v, vd.cdict)
+	if len(compressed) < len(v) {
+		return compressed
+	}
+	if !bytes.HasPrefix(v, dictMagic) {
+		return v
+	}
+	binary.LittleEndian.PutUint32(header[len(dictMagic):], 0)
+	return append(header, v...)
+}
+
+// decompress returns the original value of a stored value for a key.
+func (c *dictCompressor) decompress(ctx Context, k, v []byte) ([]byte, error) {
+	if len(v) < dictHeaderSize || !bytes.HasPrefix(v, dictMagic) {
+		return v, nil
+	}
+	ks, isData := keyspaceOf(ctx, k)
+	if !isData {
+		return v, nil
+	}
+	id := binary.LittleEndian.Uint32(v[len(dictMagic):dictHeaderSize])
+	if id == 0 {
+		return v[dictHeaderSize:], nil
+	}
+	vd := c.dicts.get(ks, id)
+	if vd == nil {
+		// Values stored before the keyspace was trained could begin with the magic bytes.
+		return v, nil
+	}
+	value, err := gozstd.DecompressDict(nil, v[dictHeaderSize:], vd.ddict)
+	if err != nil {
+		return nil, fmt.Errorf("Can't decompress value with dictionary %d for %s: %s", id, ks, err.Error())
+	}
+	return value, nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (c *dictCompressor) Get(ctx Context, k []byte) ([]byte, error) {
+	v, err := c.OrderedKeyValueDB.Get(ctx, k)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return c.decompress(ctx, k, v)
+}
+
+func (c *dictCompressor) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	values, err := c.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range values {
+		// Returned keys are full keys.
+		if kv.V, err = c.decompress(nil, kv.K, kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (c *dictCompressor) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	var decompressErr error
+	err := c.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(chunk *Chunk) {
+		if chunk != nil && chunk.KeyValue != nil {
+			value, err := c.decompress(nil, chunk.K, chunk.V)
+			if err != nil {
+				if decompressErr == nil {
+					decompressErr = err
+				}
+				dvid.Errorf("Skipping value in range: %s\n", err.Error())
+				if op != nil && op.Wg != nil {
+					op.Wg.Done()
+				}
+				return
+			}
+			chunk.V = value
+		}
+		f(chunk)
+	})
+	if err != nil {
+		return err
+	}
+	return decompressErr
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (c *dictCompressor) Put(ctx Context, k, v []byte) error {
+	return c.OrderedKeyValueDB.Put(ctx, k, c.compress(ctx, k, v))
+}
+
+func (c *dictCompressor) PutRange(ctx Context, values []KeyValue) error {
+	compressed := make([]KeyValue, len(values))
+	for i, kv := range values {
+		compressed[i] = KeyValue{kv.K, c.compress(ctx, kv.K, kv.V)}
+	}
+	return c.OrderedKeyValueDB.PutRange(ctx, compressed)
+}
+
+// ---- KeyValueBatcher interface ------
+
+type dictBatch struct {
+	Batch
+	c   *dictCompressor
+	ctx Context
+}
+
+func (c *dictCompressor) NewBatch(ctx Context) Batch {
+	return c.wrapBatch(ctx, c.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (c *dictCompressor) wrappedStore() OrderedKeyValueSetter { return c.OrderedKeyValueDB }
+
+func (c *dictCompressor) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &dictBatch{Batch: newBatch(ctx), c: c, ctx: ctx}
+}
+
+func (b *dictBatch) Put(k, v []byte) {
+	b.Batch.Put(k, b.c.compress(b.ctx, k, v))
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestValueDictionaries(t *testing.T) {
+	db := newMemoryDB()
+	dicts, err := loadValueDictionaries(db)
+	if err != nil {
+		t.Fatalf("Error loading dictionaries: %s\n", err.Error())
+	}
+	c, err := newDictCompressor(db, dicts)
+	if err != nil {
+		t.Fatalf("Error creating dictionary compressor: %s\n", err.Error())
+	}
+	ctx := GetTestDataContext(TestUUID1, "annotations", 7)
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"Kind":"PostSyn","Pos":[%d,%d,%d],"Tags":["reviewed"],"Prop":{"conf":"0.9%d"}}`, i, i*3, i*7, i%10))
+	}
+	for i := 0; i < 500; i++ {
+		if err := c.Put(ctx, []byte{5, byte(i >> 8), byte(i)}, value(i)); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+	legacy := ctx.ConstructKey([]byte{5, 0, 0})
+	if !bytes.Equal(db.kv[string(legacy)], value(0)) {
+		t.Fatalf("Value stored before training was modified\n")
+	}
+
+	ks := Keyspace{Instance: 7, KeyType: 5}
+	report, err := dicts.train([]OrderedKeyValueDB{c}, ks, 100, 4096)
+	if err != nil {
+		t.Fatalf("Error training dictionary: %s\n", err.Error())
+	}
+	if report.ID != 1 || report.Values != 500 || report.Sampled != 100 || report.CompressedBytes >= report.SampleBytes {
+		t.Errorf("Bad dictionary report: %s\n", report)
+	}
+
+	// New values are stored compressed and read back unchanged, as are older values.
+	if err := c.Put(ctx, []byte{5, 9, 0}, value(9000)); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	stored := db.kv[string(ctx.ConstructKey([]byte{5, 9, 0}))]
+	if !bytes.HasPrefix(stored, dictMagic) || len(stored) >= len(value(9000)) {
+		t.Errorf("Expected value to be stored compressed, got %q\n", stored)
+	}
+	if v, err := c.Get(ctx, []byte{5, 9, 0}); err != nil || !bytes.Equal(v, value(9000)) {
+		t.Errorf("Bad compressed value read: %q, %v\n", v, err)
+	}
+	if v, err := c.Get(ctx, []byte{5, 0, 0}); err != nil || !bytes.Equal(v, value(0)) {
+		t.Errorf("Bad value read from before training: %q, %v\n", v, err)
+	}
+	magic := append(append([]byte{}, dictMagic...), 1, 0, 0, 0)
+	c.Put(ctx, []byte{5, 9, 1}, magic)
+	if v, err := c.Get(ctx, []byte{5, 9, 1}); err != nil || !bytes.Equal(v, magic) {
+		t.Errorf("Bad value beginning with magic bytes: %v, %v\n", v, err)
+	}
+
+	// Other keyspaces aren't compressed.
+	c.Put(ctx, []byte{6, 0}, value(1))
+	if !bytes.Equal(db.kv[string(ctx.ConstructKey([]byte{6, 0}))], value(1)) {
+		t.Errorf("Value of untrained keyspace was modified\n")
+	}
+
+	// Retraining uses a new dictionary while values of the old one stay readable, and
+	// dictionaries are reloaded from metadata.
+	if report, err = dicts.train([]OrderedKeyValueDB{c}, ks, 0, 4096); err != nil || report.ID != 2 {
+		t.Fatalf("Bad retraining: %v, %v\n", report, err)
+	}
+	reloaded, err := loadValueDictionaries(db)
+	if err != nil {
+		t.Fatalf("Error reloading dictionaries: %s\n", err.Error())
+	}
+	c = &dictCompressor{db, reloaded}
+	if reloaded.currentFor(ks).id != 2 {
+		t.Errorf("Expected dictionary 2 to be current after reload\n")
+	}
+	kvs, err := c.GetRange(ctx, []byte{5, 9, 0}, []byte{5, 9, 1})
+	if err != nil || len(kvs) != 2 || !bytes.Equal(kvs[0].V, value(9000)) {
+		t.Errorf("Bad values read after reload: %v, %v\n", kvs, err)
+	}
+}
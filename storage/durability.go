@@ -0,0 +1,290 @@
+/*
+	This file lets writes trade durability for throughput.  Storage engines normally don't
+	sync writes to disk, so a crash can lose recent writes even though they were
+	acknowledged.  Once write durability is enabled, each write to the small and big data
+	stores is made at one of these levels:
+
+		sync      The engine is synced before the write returns.
+		periodic  The engine is synced at a fixed interval if there were writes.
+		async     The engine syncs on its own schedule.
+
+	The level is given by the request making the write, else by the data instance, else by
+	the server default.  Bulk ingest can therefore use asynchronous writes while
+	interactive edits get safe synchronous writes.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Durability is the level of durability of a write.
+type Durability uint8
+
+const (
+	// DurabilityDefault uses the level of the data instance or server.
+	DurabilityDefault Durability = iota
+
+	// DurabilitySync syncs the storage engine before a write returns.
+	DurabilitySync
+
+	// DurabilityPeriodic syncs the storage engine at a fixed interval after writes.
+	DurabilityPeriodic
+
+	// DurabilityAsync leaves syncing to the storage engine.
+	DurabilityAsync
+)
+
+// DefaultSyncInterval is the interval between syncs of periodic writes.
+const DefaultSyncInterval = time.Second
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityDefault:
+		return "default"
+	case DurabilitySync:
+		return "sync"
+	case DurabilityPeriodic:
+		return "periodic"
+	case DurabilityAsync:
+		return "async"
+	default:
+		return fmt.Sprintf("unknown durability %d", d)
+	}
+}
+
+// ParseDurability returns the durability for "sync", "periodic", "async", or "default".
+// An empty string is the default durability.
+func ParseDurability(s string) (Durability, error) {
+	switch strings.ToLower(s) {
+	case "", "default":
+		return DurabilityDefault, nil
+	case "sync":
+		return DurabilitySync, nil
+	case "periodic":
+		return DurabilityPeriodic, nil
+	case "async":
+		return DurabilityAsync, nil
+	default:
+		return DurabilityDefault, fmt.Errorf("Bad durability %q: must be sync, periodic, or async", s)
+	}
+}
+
+// Syncer is implemented by storage engines that can sync prior writes to disk.
+type Syncer interface {
+	Sync() error
+}
+
+// DurabilityContext is implemented by contexts that know the durability requested for
+// their writes.
+type DurabilityContext interface {
+	Durability() Durability
+}
+
+// contextDurability returns the durability requested for writes through a context.
+func contextDurability(ctx Context) Durability {
+	switch c := ctx.(type) {
+	case *graphContext:
+		return contextDurability(c.Context)
+	case *versionedGraphContext:
+		return contextDurability(c.VersionedContext)
+	case DurabilityContext:
+		return c.Durability()
+	default:
+		return DurabilityDefault
+	}
+}
+
+// durability levels of the server and data instances.
+var (
+	durabilityMu       sync.RWMutex
+	durabilityEnabled  bool
+	serverDurability   = DurabilityAsync
+	instanceDurability = make(map[dvid.InstanceID]Durability)
+)
+
+// SetInstanceDurability sets the durability of writes for a data instance that don't
+// request one.  DurabilityDefault uses the server default.
+func SetInstanceDurability(instanceID dvid.InstanceID, d Durability) {
+	durabilityMu.Lock()
+	defer durabilityMu.Unlock()
+	if d == DurabilityDefault {
+		delete(instanceDurability, instanceID)
+	} else {
+		instanceDurability[instanceID] = d
+	}
+}
+
+// ResolveDurability returns the durability used for writes to a data instance given the
+// requested durability.  Until write durability is enabled, writes are asynchronous.
+func ResolveDurability(instanceID dvid.InstanceID, requested Durability) Durability {
+	durabilityMu.RLock()
+	defer durabilityMu.RUnlock()
+	if !durabilityEnabled {
+		return DurabilityAsync
+	}
+	if requested != DurabilityDefault {
+		return requested
+	}
+	if d, found := instanceDurability[instanceID]; found {
+		return d
+	}
+	return serverDurability
+}
+
+// writeDurability returns the durability of a write of a key through a context.
+func writeDurability(ctx Context, k []byte) Durability {
+	if d := contextDurability(ctx); d != DurabilityDefault {
+		return d
+	}
+	durabilityMu.RLock()
+	defer durabilityMu.RUnlock()
+	if instanceID, isData := instanceOf(ctx, k); isData {
+		if d, found := instanceDurability[instanceID]; found {
+			return d
+		}
+	}
+	return serverDurability
+}
+
+// dbSyncer returns the storage engine beneath any wrappers if it can be synced.
+func dbSyncer(db OrderedKeyValueDB) (Syncer, bool) {
+	for {
+		switch wrapper := baseDB(db).(type) {
+		case *mutationLogger:
+			db = wrapper.OrderedKeyValueDB
+		case *clusterDB:
+			db = wrapper.OrderedKeyValueDB
+		case Syncer:
+			return wrapper, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// durableDB wraps an ordered key-value store and syncs its engine after writes as
+// required by their durability.
+type durableDB struct {
+	OrderedKeyValueDB
+
+	syncer Syncer
+
+	// dirty is nonzero if there were periodic writes since the last sync.
+	dirty int32
+}
+
+func newDurableDB(db OrderedKeyValueDB) (*durableDB, error) {
+	syncer, ok := dbSyncer(db)
+	if !ok {
+		return nil, fmt.Errorf("Write durability requires a storage engine that can sync, %q can't", db)
+	}
+	return &durableDB{OrderedKeyValueDB: db, syncer: syncer}, nil
+}
+
+func (d *durableDB) String() string {
+	return fmt.Sprintf("%s with write durability", d.OrderedKeyValueDB)
+}
+
+// written makes a successful write durable at the given level.
+func (d *durableDB) written(durability Durability) error {
+	switch durability {
+	case DurabilitySync:
+		return d.syncer.Sync()
+	case DurabilityPeriodic:
+		atomic.StoreInt32(&d.dirty, 1)
+	}
+	return nil
+}
+
+// syncPeriodic syncs the engine if there were periodic writes since the last sync.
+func (d *durableDB) syncPeriodic() error {
+	if atomic.SwapInt32(&d.dirty, 0) == 0 {
+		return nil
+	}
+	return d.syncer.Sync()
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (d *durableDB) Put(ctx Context, k, v []byte) error {
+	if err := d.OrderedKeyValueDB.Put(ctx, k, v); err != nil {
+		return err
+	}
+	return d.written(writeDurability(ctx, k))
+}
+
+func (d *durableDB) Delete(ctx Context, k []byte) error {
+	if err := d.OrderedKeyValueDB.Delete(ctx, k); err != nil {
+		return err
+	}
+	return d.written(writeDurability(ctx, k))
+}
+
+func (d *durableDB) PutRange(ctx Context, values []KeyValue) error {
+	if err := d.OrderedKeyValueDB.PutRange(ctx, values); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return d.written(writeDurability(ctx, values[0].K))
+}
+
+func (d *durableDB) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	if err := d.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd); err != nil {
+		return err
+	}
+	return d.written(writeDurability(ctx, kStart))
+}
+
+// ---- KeyValueBatcher interface ------
+
+type durableBatch struct {
+	Batch
+	db  *durableDB
+	ctx Context
+	key []byte // first key written, which gives the instance of the batch
+}
+
+func (d *durableDB) NewBatch(ctx Context) Batch {
+	return d.wrapBatch(ctx, d.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (d *durableDB) wrappedStore() OrderedKeyValueSetter { return d.OrderedKeyValueDB }
+
+func (d *durableDB) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &durableBatch{Batch: newBatch(ctx), db: d, ctx: ctx}
+}
+
+func (b *durableBatch) Put(k, v []byte) {
+	if b.key == nil {
+		b.key = k
+	}
+	b.Batch.Put(k, v)
+}
+
+func (b *durableBatch) Delete(k []byte) {
+	if b.key == nil {
+		b.key = k
+	}
+	b.Batch.Delete(k)
+}
+
+// Commit makes the batch durable once it is committed.
+func (b *durableBatch) Commit() error {
+	if err := b.Batch.Commit(); err != nil {
+		return err
+	}
+	if b.key == nil {
+		return nil
+	}
+	return b.db.written(writeDurability(b.ctx, b.key))
+}
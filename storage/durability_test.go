@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"testing"
+)
+
+type syncedDB struct {
+	*memoryDB
+	syncs int
+}
+
+func (db *syncedDB) Sync() error {
+	db.syncs++
+	return nil
+}
+
+func TestParseDurability(t *testing.T) {
+	for _, d := range []Durability{DurabilityDefault, DurabilitySync, DurabilityPeriodic, DurabilityAsync} {
+		parsed, err := ParseDurability(d.String())
+		if err != nil {
+			t.Fatalf("Error parsing %q: %s\n", d, err.Error())
+		}
+		if parsed != d {
+			t.Errorf("Expected %s, got %s\n", d, parsed)
+		}
+	}
+	if d, err := ParseDurability(""); err != nil || d != DurabilityDefault {
+		t.Errorf("Expected empty string to give default durability, got %s, %v\n", d, err)
+	}
+	if _, err := ParseDurability("fsync"); err == nil {
+		t.Errorf("Expected error parsing bad durability\n")
+	}
+}
+
+func TestDurableDB(t *testing.T) {
+	engine := &syncedDB{memoryDB: newMemoryDB()}
+	db, err := newDurableDB(&usageTracker{engine})
+	if err != nil {
+		t.Fatalf("Error wrapping syncable store: %s\n", err.Error())
+	}
+	if _, err := newDurableDB(newMemoryDB()); err == nil {
+		t.Errorf("Expected error wrapping store that can't sync\n")
+	}
+
+	durabilityMu.Lock()
+	serverDurability = DurabilitySync
+	durabilityMu.Unlock()
+	defer func() {
+		durabilityMu.Lock()
+		serverDurability = DurabilityAsync
+		durabilityMu.Unlock()
+	}()
+
+	// Writes without a requested durability use the server default.
+	ctx1 := GetTestDataContext(TestUUID1, "grayscale", 1)
+	if err := db.Put(ctx1, []byte{1}, []byte{1}); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if engine.syncs != 1 {
+		t.Errorf("Expected sync after default write, got %d syncs\n", engine.syncs)
+	}
+
+	// Requested durability overrides the instance durability.
+	SetInstanceDurability(1, DurabilityAsync)
+	defer SetInstanceDurability(1, DurabilityDefault)
+	if err := db.Delete(ctx1, []byte{1}); err != nil {
+		t.Fatalf("Error on delete: %s\n", err.Error())
+	}
+	if engine.syncs != 1 {
+		t.Errorf("Expected no sync after async instance write, got %d syncs\n", engine.syncs)
+	}
+	ctx1.SetDurability(DurabilitySync)
+	batch := db.NewBatch(ctx1)
+	batch.Put([]byte{2}, []byte{2})
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	if engine.syncs != 2 {
+		t.Errorf("Expected sync after requested sync batch, got %d syncs\n", engine.syncs)
+	}
+
+	// Periodic writes are synced once.
+	ctx1.SetDurability(DurabilityPeriodic)
+	for i := byte(0); i < 4; i++ {
+		if err := db.Put(ctx1, []byte{i}, []byte{i}); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+	if engine.syncs != 2 {
+		t.Errorf("Expected no sync after periodic writes, got %d syncs\n", engine.syncs)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.syncPeriodic(); err != nil {
+			t.Fatalf("Error on periodic sync: %s\n", err.Error())
+		}
+	}
+	if engine.syncs != 3 {
+		t.Errorf("Expected one sync of periodic writes, got %d syncs\n", engine.syncs)
+	}
+}
@@ -0,0 +1,360 @@
+/*
+	This file implements an engine wrapper that encrypts values with AES-GCM before they
+	are stored, for deployments handling sensitive data.  Only values of data instances
+	marked as encrypted are encrypted.  Keys are stored in the clear since engines must
+	order them.
+
+	Encrypted values have the form
+
+		encryptedMagic (4 bytes) + key ID (1 byte) + nonce (12 bytes) + sealed value
+
+	where the sealed value is authenticated with the data instance ID, so an encrypted
+	value can't be moved to another instance without detection.  Values without the
+	header, e.g., those stored before encryption was enabled for an instance, are
+	returned unchanged.  The key ID allows keys to be rotated: new values are encrypted
+	with the current key while older values are decrypted with the key they name.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+var encryptedMagic = []byte{0xFF, 'D', 'V', 'E'}
+
+const encryptedHeaderSize = 5 // magic + key ID
+
+// KeyProvider supplies AES keys for encryption at rest, e.g., from a file or a KMS.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key used to encrypt new values.
+	CurrentKeyID() uint8
+
+	// Key returns the 16, 24, or 32 byte AES key with the given ID.
+	Key(id uint8) ([]byte, error)
+}
+
+// instances whose values should be encrypted when written.
+var (
+	encryptedInstances   = make(map[dvid.InstanceID]bool)
+	encryptedInstancesMu sync.RWMutex
+)
+
+// SetInstanceEncryption sets whether values written for a data instance are encrypted.
+// Values are only encrypted if encryption has been enabled for the storage engines.
+func SetInstanceEncryption(instanceID dvid.InstanceID, encrypted bool) {
+	encryptedInstancesMu.Lock()
+	defer encryptedInstancesMu.Unlock()
+	if encrypted {
+		encryptedInstances[instanceID] = true
+	} else {
+		delete(encryptedInstances, instanceID)
+	}
+}
+
+func instanceEncrypted(instanceID dvid.InstanceID) bool {
+	encryptedInstancesMu.RLock()
+	defer encryptedInstancesMu.RUnlock()
+	return encryptedInstances[instanceID]
+}
+
+// encryptor wraps an ordered key-value store and encrypts values of encrypted instances.
+type encryptor struct {
+	OrderedKeyValueDB
+
+	provider KeyProvider
+
+	mu      sync.Mutex
+	ciphers map[uint8]cipher.AEAD
+}
+
+func newEncryptor(db OrderedKeyValueDB, provider KeyProvider) (*encryptor, error) {
+	if _, ok := db.(KeyValueBatcher); !ok {
+		return nil, fmt.Errorf("Encryption requires a database that supports batches, %q does not", db)
+	}
+	e := &encryptor{
+		OrderedKeyValueDB: db,
+		provider:          provider,
+		ciphers:           make(map[uint8]cipher.AEAD),
+	}
+	// Make sure the current key is usable before any writes.
+	if _, err := e.cipher(provider.CurrentKeyID()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptor) String() string {
+	return fmt.Sprintf("%s with encryption at rest", e.OrderedKeyValueDB)
+}
+
+// cipher returns the AES-GCM cipher for a key ID, fetching the key if necessary.
+func (e *encryptor) cipher(keyID uint8) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if aead, found := e.ciphers[keyID]; found {
+		return aead, nil
+	}
+	key, err := e.provider.Key(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("Can't get encryption key %d: %s", keyID, err.Error())
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Bad encryption key %d: %s", keyID, err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.ciphers[keyID] = aead
+	return aead, nil
+}
+
+// instanceOf returns the data instance of a key or false if it isn't a data key.
+func instanceOf(ctx Context, k []byte) (dvid.InstanceID, bool) {
+	key := k
+	if ctx != nil {
+		key = ctx.ConstructKey(k)
+	}
+	if len(key) < 1+dvid.InstanceIDSize || key[0] != dataKeyPrefix {
+		return 0, false
+	}
+	return dvid.InstanceIDFromBytes(key[1 : 1+dvid.InstanceIDSize]), true
+}
+
+// encrypt returns the value to store for a key.
+func (e *encryptor) encrypt(ctx Context, k, v []byte) ([]byte, error) {
+	instanceID, isData := instanceOf(ctx, k)
+	if !isData || !instanceEncrypted(instanceID) {
+		return v, nil
+	}
+	keyID := e.provider.CurrentKeyID()
+	aead, err := e.cipher(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aead.NonceSize()
+	sealed := make([]byte, encryptedHeaderSize+nonceSize, encryptedHeaderSize+nonceSize+len(v)+aead.Overhead())
+	copy(sealed, encryptedMagic)
+	sealed[len(encryptedMagic)] = keyID
+	nonce := sealed[encryptedHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, v, instanceID.Bytes()), nil
+}
+
+// decrypt returns the original value of a stored value for a key.
+func (e *encryptor) decrypt(ctx Context, k, v []byte) ([]byte, error) {
+	if len(v) < encryptedHeaderSize || !bytes.Equal(v[:len(encryptedMagic)], encryptedMagic) {
+		return v, nil
+	}
+	instanceID, isData := instanceOf(ctx, k)
+	if !isData {
+		return v, nil
+	}
+	aead, err := e.cipher(v[len(encryptedMagic)])
+	if err != nil {
+		return nil, err
+	}
+	nonceEnd := encryptedHeaderSize + aead.NonceSize()
+	if len(v) >= nonceEnd {
+		value, err := aead.Open(nil, v[encryptedHeaderSize:nonceEnd], v[nonceEnd:], instanceID.Bytes())
+		if err == nil {
+			return value, nil
+		}
+	}
+	// Unencrypted values could begin with the magic bytes, but not for encrypted instances.
+	if !instanceEncrypted(instanceID) {
+		return v, nil
+	}
+	return nil, fmt.Errorf("Can't decrypt value for data instance %d", instanceID)
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (e *encryptor) Get(ctx Context, k []byte) ([]byte, error) {
+	v, err := e.OrderedKeyValueDB.Get(ctx, k)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return e.decrypt(ctx, k, v)
+}
+
+func (e *encryptor) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	values, err := e.OrderedKeyValueDB.GetRange(ctx, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range values {
+		// Returned keys are full keys.
+		if kv.V, err = e.decrypt(nil, kv.K, kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (e *encryptor) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	var decryptErr error
+	err := e.OrderedKeyValueDB.ProcessRange(ctx, kStart, kEnd, op, func(chunk *Chunk) {
+		if chunk != nil && chunk.KeyValue != nil {
+			value, err := e.decrypt(nil, chunk.K, chunk.V)
+			if err != nil {
+				if decryptErr == nil {
+					decryptErr = err
+				}
+				dvid.Errorf("Skipping value in range: %s\n", err.Error())
+				if op != nil && op.Wg != nil {
+					op.Wg.Done()
+				}
+				return
+			}
+			chunk.V = value
+		}
+		f(chunk)
+	})
+	if err != nil {
+		return err
+	}
+	return decryptErr
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (e *encryptor) Put(ctx Context, k, v []byte) error {
+	sealed, err := e.encrypt(ctx, k, v)
+	if err != nil {
+		return err
+	}
+	return e.OrderedKeyValueDB.Put(ctx, k, sealed)
+}
+
+func (e *encryptor) PutRange(ctx Context, values []KeyValue) error {
+	sealed := make([]KeyValue, len(values))
+	for i, kv := range values {
+		v, err := e.encrypt(ctx, kv.K, kv.V)
+		if err != nil {
+			return err
+		}
+		sealed[i] = KeyValue{kv.K, v}
+	}
+	return e.OrderedKeyValueDB.PutRange(ctx, sealed)
+}
+
+// ---- KeyValueBatcher interface ------
+
+type encryptBatch struct {
+	Batch
+	enc *encryptor
+	ctx Context
+}
+
+func (e *encryptor) NewBatch(ctx Context) Batch {
+	return e.wrapBatch(ctx, e.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (e *encryptor) wrappedStore() OrderedKeyValueSetter { return e.OrderedKeyValueDB }
+
+func (e *encryptor) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &encryptBatch{Batch: newBatch(ctx), enc: e, ctx: ctx}
+}
+
+// Put adds an encrypted put to the batch.  Since batches can't return errors until
+// committed, a value that can't be encrypted is logged and not stored.
+func (b *encryptBatch) Put(k, v []byte) {
+	sealed, err := b.enc.encrypt(b.ctx, k, v)
+	if err != nil {
+		dvid.Errorf("Not storing value that couldn't be encrypted: %s\n", err.Error())
+		return
+	}
+	b.Batch.Put(k, sealed)
+}
+
+// ---- Key providers ------
+
+// staticKeyProvider holds a single key.
+type staticKeyProvider struct {
+	id  uint8
+	key []byte
+}
+
+func (p staticKeyProvider) CurrentKeyID() uint8 { return p.id }
+
+func (p staticKeyProvider) Key(id uint8) ([]byte, error) {
+	if id != p.id {
+		return nil, fmt.Errorf("only key %d is available", p.id)
+	}
+	return p.key, nil
+}
+
+// parseHexKey decodes a hex-encoded AES key, ignoring surrounding whitespace.
+func parseHexKey(encoded []byte) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("key must be hex-encoded: %s", err.Error())
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("key must be 16, 24, or 32 bytes, not %d", len(key))
+	}
+}
+
+// NewKeyFileProvider returns a KeyProvider with a single hex-encoded key, with the
+// given ID, read from a file.
+func NewKeyFileProvider(filename string, id uint8) (KeyProvider, error) {
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseHexKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Bad key file %q: %s", filename, err.Error())
+	}
+	return staticKeyProvider{id, key}, nil
+}
+
+// commandKeyProvider gets keys from an external command, e.g., a KMS client, that is
+// given the key ID as its last argument and prints the hex-encoded key.
+type commandKeyProvider struct {
+	command []string
+	current uint8
+}
+
+// NewCommandKeyProvider returns a KeyProvider that gets keys by running a command with
+// the key ID appended as an argument.  The command must print the hex-encoded key.
+// New values are encrypted with the key of the given current ID.
+func NewCommandKeyProvider(command string, current uint8) (KeyProvider, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("No key command given")
+	}
+	return commandKeyProvider{fields, current}, nil
+}
+
+func (p commandKeyProvider) CurrentKeyID() uint8 { return p.current }
+
+func (p commandKeyProvider) Key(id uint8) ([]byte, error) {
+	args := append(p.command[1:len(p.command):len(p.command)], strconv.Itoa(int(id)))
+	output, err := exec.Command(p.command[0], args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("key command %q failed: %s", p.command[0], err.Error())
+	}
+	return parseHexKey(output)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptor(t *testing.T) {
+	db := newMemoryDB()
+	key1 := staticKeyProvider{1, bytes.Repeat([]byte{7}, 32)}
+	enc, err := newEncryptor(db, key1)
+	if err != nil {
+		t.Fatalf("Can't create encryptor: %s\n", err.Error())
+	}
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 11)
+	plainCtx := GetTestDataContext(TestUUID1, "labels", 12)
+	SetInstanceEncryption(11, true)
+	defer SetInstanceEncryption(11, false)
+
+	value := []byte("sensitive voxels")
+	if err := enc.Put(ctx, []byte{1}, value); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if err := enc.Put(plainCtx, []byte{1}, value); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	stored := db.kv[string(ctx.ConstructKey([]byte{1}))]
+	if bytes.Contains(stored, value) || !bytes.HasPrefix(stored, encryptedMagic) {
+		t.Errorf("Value of encrypted instance stored in the clear: %v\n", stored)
+	}
+	if stored := db.kv[string(plainCtx.ConstructKey([]byte{1}))]; !bytes.Equal(stored, value) {
+		t.Errorf("Value of unencrypted instance was modified: %v\n", stored)
+	}
+	if v, err := enc.Get(ctx, []byte{1}); err != nil || !bytes.Equal(v, value) {
+		t.Errorf("Bad decrypted value %q: %v\n", v, err)
+	}
+
+	// Batches and ranges are handled.
+	batch := enc.NewBatch(ctx)
+	batch.Put([]byte{2}, []byte("more voxels"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	values, err := enc.GetRange(ctx, []byte{1}, []byte{2})
+	if err != nil || len(values) != 2 || string(values[1].V) != "more voxels" {
+		t.Errorf("Bad decrypted range: %v, %v\n", values, err)
+	}
+	var numChunks int
+	err = enc.ProcessRange(ctx, []byte{1}, []byte{2}, &ChunkOp{}, func(chunk *Chunk) {
+		numChunks++
+		if bytes.HasPrefix(chunk.V, encryptedMagic) {
+			t.Errorf("Chunk value not decrypted: %v\n", chunk.V)
+		}
+	})
+	if err != nil || numChunks != 2 {
+		t.Errorf("Bad processed range: %d chunks, %v\n", numChunks, err)
+	}
+
+	// Encrypted values can't be moved to another instance.
+	otherCtx := GetTestDataContext(TestUUID1, "grayscale2", 13)
+	SetInstanceEncryption(13, true)
+	defer SetInstanceEncryption(13, false)
+	db.kv[string(otherCtx.ConstructKey([]byte{1}))] = stored
+	if _, err := enc.Get(otherCtx, []byte{1}); err == nil {
+		t.Errorf("Expected error decrypting value moved to another instance\n")
+	}
+
+	// Values encrypted with a prior key can still be read after rotation.
+	rotated := &multiKeyProvider{current: 2, keys: map[uint8][]byte{1: key1.key, 2: bytes.Repeat([]byte{9}, 16)}}
+	enc2, err := newEncryptor(db, rotated)
+	if err != nil {
+		t.Fatalf("Can't create encryptor: %s\n", err.Error())
+	}
+	if err := enc2.Put(ctx, []byte{3}, value); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	for _, k := range []byte{1, 3} {
+		if v, err := enc2.Get(ctx, []byte{k}); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("Bad decrypted value for key %d after rotation: %q, %v\n", k, v, err)
+		}
+	}
+	if _, err := enc.Get(ctx, []byte{3}); err == nil {
+		t.Errorf("Expected error reading value encrypted with unknown key\n")
+	}
+
+	if _, err := parseHexKey([]byte("0123")); err == nil {
+		t.Errorf("Expected error for short key\n")
+	}
+}
+
+type multiKeyProvider struct {
+	current uint8
+	keys    map[uint8][]byte
+}
+
+func (p *multiKeyProvider) CurrentKeyID() uint8 { return p.current }
+
+func (p *multiKeyProvider) Key(id uint8) ([]byte, error) {
+	return p.keys[id], nil
+}
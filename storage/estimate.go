@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// TransferEstimate gives the number and size of a data instance's key-value pairs that
+// would be transferred, e.g., by a push.
+type TransferEstimate struct {
+	Instance   dvid.InstanceID
+	Keys       uint64
+	KeyBytes   uint64
+	ValueBytes uint64
+}
+
+func (e *TransferEstimate) String() string {
+	return fmt.Sprintf("%d keys, %s (keys %s, values %s)", e.Keys, humanBytes(e.KeyBytes+e.ValueBytes),
+		humanBytes(e.KeyBytes), humanBytes(e.ValueBytes))
+}
+
+// Add adds the counts of another estimate.
+func (e *TransferEstimate) Add(other *TransferEstimate) {
+	e.Keys += other.Keys
+	e.KeyBytes += other.KeyBytes
+	e.ValueBytes += other.ValueBytes
+}
+
+// estimateInstance scans all key-value pairs of a data instance in a database, counting
+// those whose full keys are accepted by the filter, if any.
+func estimateInstance(db OrderedKeyValueDB, instanceID dvid.InstanceID, filter func(key []byte) bool, est *TransferEstimate) error {
+	minKey, maxKey := DataContextKeyRange(instanceID)
+	return db.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+		if chunk == nil || chunk.KeyValue == nil {
+			return
+		}
+		if filter != nil && !filter(chunk.K) {
+			return
+		}
+		est.Keys++
+		est.KeyBytes += uint64(len(chunk.K))
+		est.ValueBytes += uint64(len(chunk.V))
+	})
+}
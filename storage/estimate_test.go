@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEstimateInstance(t *testing.T) {
+	db := newMemoryDB()
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 31)
+	other := GetTestDataContext(TestUUID1, "labels", 32)
+	db.Put(ctx, []byte{1}, []byte("block"))
+	db.Put(ctx, []byte{2, 0}, []byte("bigger block"))
+	db.Put(other, []byte{1}, []byte("not counted"))
+
+	est := &TransferEstimate{Instance: 31}
+	if err := estimateInstance(db, 31, nil, est); err != nil {
+		t.Fatalf("Error estimating instance: %s\n", err.Error())
+	}
+	keyBytes := uint64(len(ctx.ConstructKey([]byte{1})) + len(ctx.ConstructKey([]byte{2, 0})))
+	if est.Keys != 2 || est.KeyBytes != keyBytes || est.ValueBytes != 17 {
+		t.Errorf("Bad estimate: %s\n", est)
+	}
+
+	// Filters restrict the counted keys.
+	filtered := &TransferEstimate{Instance: 31}
+	onlyFirst := func(key []byte) bool { return bytes.Equal(key, ctx.ConstructKey([]byte{1})) }
+	if err := estimateInstance(db, 31, onlyFirst, filtered); err != nil {
+		t.Fatalf("Error estimating instance: %s\n", err.Error())
+	}
+	if filtered.Keys != 1 || filtered.ValueBytes != 5 {
+		t.Errorf("Bad filtered estimate: %s\n", filtered)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+	This file supports garbage collection of data key-value pairs that are no longer
+	reachable, e.g., those of deleted data instances or of versions no longer in any
+	repo's DAG.  The caller decides reachability while the storage tiers are scanned
+	and orphaned keys are deleted in rate-limited batches.  Data types can also prune
+	stale derived values, e.g., surfaces or tiles, through a StaleDeleter.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// DefaultGCBatchSize is the default number of keys deleted per batch.
+	DefaultGCBatchSize = 1000
+
+	// DefaultGCBatchDelay is the default pause between deletion batches.
+	DefaultGCBatchDelay = 100 * time.Millisecond
+)
+
+// GCOptions control a garbage collection pass.
+type GCOptions struct {
+	// DryRun only reports what would be reclaimed without deleting anything.
+	DryRun bool
+
+	// BatchSize is the number of keys deleted per batch.
+	BatchSize int
+
+	// BatchDelay is the pause after each deletion batch to limit the load on the store.
+	BatchDelay time.Duration
+}
+
+// LiveFunc returns true if data for the given instance and version is reachable.
+type LiveFunc func(dvid.InstanceID, dvid.VersionID) bool
+
+// GCStats reports the results of a garbage collection pass.
+type GCStats struct {
+	DryRun bool
+
+	// KeysScanned is the number of data keys examined.
+	KeysScanned uint64
+
+	// KeysOrphaned and BytesOrphaned give the unreachable key-value pairs and their
+	// total key and value bytes, which are reclaimable on a dry run.
+	KeysOrphaned  uint64
+	BytesOrphaned uint64
+
+	// KeysDeleted is the number of orphaned keys deleted.
+	KeysDeleted uint64
+
+	// Orphaned keys per instance and version ID.
+	Instances map[dvid.InstanceID]uint64
+	Versions  map[dvid.VersionID]uint64
+
+	// KeysStale and BytesStale give derived key-value pairs, e.g., surfaces or tiles,
+	// that no longer match their source data, and KeysPruned is the number deleted.
+	KeysStale  uint64
+	BytesStale uint64
+	KeysPruned uint64
+
+	// Stale keys per instance ID.
+	Stale map[dvid.InstanceID]uint64
+
+	// BlobRefsReleased is the number of blob references released for orphaned or stale
+	// keys.
+	BlobRefsReleased uint64
+}
+
+func newGCStats(dryRun bool) *GCStats {
+	return &GCStats{
+		DryRun:    dryRun,
+		Instances: make(map[dvid.InstanceID]uint64),
+		Versions:  make(map[dvid.VersionID]uint64),
+		Stale:     make(map[dvid.InstanceID]uint64),
+	}
+}
+
+func (stats *GCStats) String() string {
+	if stats.DryRun {
+		return fmt.Sprintf("Scanned %d keys: %d orphaned keys (%s) and %d stale keys (%s) could be reclaimed",
+			stats.KeysScanned, stats.KeysOrphaned, humanBytes(stats.BytesOrphaned),
+			stats.KeysStale, humanBytes(stats.BytesStale))
+	}
+	return fmt.Sprintf("Scanned %d keys: deleted %d of %d orphaned keys (%s) and %d of %d stale keys (%s)",
+		stats.KeysScanned, stats.KeysDeleted, stats.KeysOrphaned, humanBytes(stats.BytesOrphaned),
+		stats.KeysPruned, stats.KeysStale, humanBytes(stats.BytesStale))
+}
+
+func humanBytes(n uint64) string {
+	switch {
+	case n >= dvid.Giga:
+		return fmt.Sprintf("%.1f GB", float64(n)/dvid.Giga)
+	case n >= dvid.Mega:
+		return fmt.Sprintf("%.1f MB", float64(n)/dvid.Mega)
+	case n >= dvid.Kilo:
+		return fmt.Sprintf("%.1f KB", float64(n)/dvid.Kilo)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+// batchDeleter deletes full keys in rate-limited batches.
+type batchDeleter struct {
+	batcher KeyValueBatcher
+	opts    GCOptions
+	keys    [][]byte
+	deleted uint64
+	err     error
+}
+
+func newBatchDeleter(db OrderedKeyValueDB, opts GCOptions) (*batchDeleter, error) {
+	batcher, ok := db.(KeyValueBatcher)
+	if !ok {
+		return nil, fmt.Errorf("Garbage collection requires a database that supports batches, %q does not", db)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultGCBatchSize
+	}
+	return &batchDeleter{batcher: batcher, opts: opts}, nil
+}
+
+// add queues a key for deletion and deletes a batch once enough keys are queued.
+func (d *batchDeleter) add(k []byte) {
+	if d.err != nil {
+		return
+	}
+	d.keys = append(d.keys, append([]byte(nil), k...))
+	if len(d.keys) >= d.opts.BatchSize {
+		d.flush()
+	}
+}
+
+// flush deletes any queued keys and returns the first deletion error.
+func (d *batchDeleter) flush() error {
+	if len(d.keys) == 0 || d.err != nil {
+		return d.err
+	}
+	batch := d.batcher.NewBatch(nil)
+	for _, k := range d.keys {
+		batch.Delete(k)
+	}
+	if d.err = batch.Commit(); d.err != nil {
+		return d.err
+	}
+	d.deleted += uint64(len(d.keys))
+	d.keys = nil
+	if d.opts.BatchDelay > 0 {
+		time.Sleep(d.opts.BatchDelay)
+	}
+	return nil
+}
+
+// collectGarbage scans all data and graph keys of a store and deletes those that aren't live.
+func collectGarbage(db OrderedKeyValueDB, live LiveFunc, opts GCOptions, stats *GCStats) error {
+	deleter, err := newBatchDeleter(db, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		stats.KeysDeleted += deleter.deleted
+	}()
+
+	// Scan the data partition and the graph partition used by graph stores.
+	for _, prefix := range []byte{dataKeyPrefix, graphKeyPrefix} {
+		minKey := []byte{prefix}
+		maxKey := []byte{prefix, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		err := db.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+			if chunk == nil || chunk.KeyValue == nil || len(chunk.K) < 1+dvid.InstanceIDSize+dvid.VersionIDSize {
+				return
+			}
+			stats.KeysScanned++
+			instanceID, versionID, err := KeyToLocalIDs(chunk.K)
+			if err != nil || live(instanceID, versionID) {
+				return
+			}
+			stats.KeysOrphaned++
+			stats.BytesOrphaned += uint64(len(chunk.K) + len(chunk.V))
+			stats.Instances[instanceID]++
+			stats.Versions[versionID]++
+			if !opts.DryRun {
+				deleter.add(chunk.K)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return deleter.flush()
+}
+
+// StaleDeleter deletes stale derived key-value pairs of data instances, e.g., surfaces
+// of labels that no longer exist, in rate-limited batches and records them in the
+// garbage collection stats.  Nothing is deleted on a dry run.
+type StaleDeleter struct {
+	deleter *batchDeleter
+	stats   *GCStats
+
+	// Blobs referenced by deleted keys are released once the keys are deleted.
+	blobs *BlobStore
+	refs  [][]byte
+}
+
+// NewStaleDeleter returns a StaleDeleter for full keys in the given store.
+func NewStaleDeleter(db OrderedKeyValueDB, opts GCOptions, stats *GCStats) (*StaleDeleter, error) {
+	deleter, err := newBatchDeleter(db, opts)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := NewBlobStore(db)
+	if err != nil {
+		return nil, err
+	}
+	return &StaleDeleter{deleter: deleter, stats: stats, blobs: blobs}, nil
+}
+
+// Delete records a stale key-value pair of a data instance and queues its full key
+// for deletion.  It returns the first deletion error.
+func (d *StaleDeleter) Delete(instanceID dvid.InstanceID, kv *KeyValue) error {
+	d.stats.KeysStale++
+	d.stats.BytesStale += uint64(len(kv.K) + len(kv.V))
+	d.stats.Stale[instanceID]++
+	if d.stats.DryRun {
+		return nil
+	}
+	if IsBlobRef(kv.V) {
+		d.refs = append(d.refs, append([]byte(nil), kv.K...))
+	}
+	d.deleter.add(kv.K)
+	return d.deleter.err
+}
+
+// Flush deletes any queued stale keys.  It must be called after the last Delete.
+func (d *StaleDeleter) Flush() error {
+	err := d.deleter.flush()
+	d.stats.KeysPruned += d.deleter.deleted
+	d.deleter.deleted = 0
+	if err != nil {
+		return err
+	}
+	for _, key := range d.refs {
+		if err := d.blobs.Release(key); err != nil {
+			return err
+		}
+		d.stats.BlobRefsReleased++
+	}
+	d.refs = nil
+	return nil
+}
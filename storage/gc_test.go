@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	db := newMemoryDB()
+	live := GetTestDataContext(TestUUID1, "grayscale", 1)
+	deleted := GetTestDataContext(TestUUID1, "labels", 2)
+	orphanedVersion := GetTestDataContext(TestUUID2, "grayscale", 1)
+	for i := byte(0); i < 5; i++ {
+		for _, ctx := range []*DataContext{live, deleted, orphanedVersion} {
+			if err := db.Put(ctx, []byte{i}, []byte{i, i, i}); err != nil {
+				t.Fatalf("Error on put: %s\n", err.Error())
+			}
+		}
+	}
+	db.kv[string(NewMetadataContext().ConstructKey([]byte{1}))] = []byte{1}
+
+	isLive := func(instanceID dvid.InstanceID, versionID dvid.VersionID) bool {
+		return instanceID == 1 && versionID == live.VersionID()
+	}
+
+	stats := newGCStats(true)
+	if err := collectGarbage(db, isLive, GCOptions{DryRun: true}, stats); err != nil {
+		t.Fatalf("Error on dry run: %s\n", err.Error())
+	}
+	keyBytes := uint64(len(live.ConstructKey([]byte{0})))
+	if stats.KeysScanned != 15 || stats.KeysOrphaned != 10 || stats.BytesOrphaned != 10*(keyBytes+3) {
+		t.Errorf("Bad dry run stats: %+v\n", stats)
+	}
+	if stats.Instances[2] != 5 || stats.Versions[orphanedVersion.VersionID()] != 5 {
+		t.Errorf("Bad orphan counts: %v, %v\n", stats.Instances, stats.Versions)
+	}
+	if len(db.kv) != 16 {
+		t.Fatalf("Dry run deleted keys: %d left\n", len(db.kv))
+	}
+
+	stats = newGCStats(false)
+	if err := collectGarbage(db, isLive, GCOptions{BatchSize: 3}, stats); err != nil {
+		t.Fatalf("Error collecting garbage: %s\n", err.Error())
+	}
+	if stats.KeysDeleted != 10 {
+		t.Errorf("Expected 10 deleted keys, got %d\n", stats.KeysDeleted)
+	}
+	if len(db.kv) != 6 {
+		t.Errorf("Expected live data and metadata kept, got %d keys\n", len(db.kv))
+	}
+	for i := byte(0); i < 5; i++ {
+		if v, _ := db.Get(live, []byte{i}); len(v) != 3 {
+			t.Errorf("Live value %d was removed\n", i)
+		}
+	}
+}
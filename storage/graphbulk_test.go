@@ -0,0 +1,193 @@
+// +build graphkeyvalue
+
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type edgeSlice []WeightedEdge
+
+func (s *edgeSlice) ReadEdge() (WeightedEdge, error) {
+	if len(*s) == 0 {
+		return WeightedEdge{}, io.EOF
+	}
+	edge := (*s)[0]
+	*s = (*s)[1:]
+	return edge, nil
+}
+
+func TestGraphBulkLoad(t *testing.T) {
+	db := newMemoryDB()
+	ctx := GetTestDataContext(TestUUID1, "graph", 13)
+
+	// Store a vertex the way graphs were stored before the graph partition.
+	engine, _ := NewGraphStore(db)
+	graphDB := engine.(*GraphKeyValueDB)
+	properties := make(dvid.ElementProperties)
+	legacy := dvid.GraphVertex{&dvid.GraphElement{properties, 5}, 1, nil}
+	vertexIndex := &graphIndex{keyVertex, 1, 0, ""}
+	db.Put(ctx, vertexIndex.Bytes(), graphDB.serializeVertex(legacy))
+
+	edges := edgeSlice{{1, 2, 0.5}, {2, 3, 1.5}, {2, 1, 0.7}}
+	loaded, err := graphDB.BulkLoadEdges(ctx, &edges)
+	if err != nil {
+		t.Fatalf("Error bulk loading edges: %s\n", err.Error())
+	}
+	if loaded != 3 {
+		t.Errorf("Expected 3 edges loaded, got %d\n", loaded)
+	}
+	for k := range db.kv {
+		if k[0] != graphKeyPrefix {
+			t.Errorf("Found key outside graph partition: %v\n", []byte(k))
+		}
+	}
+
+	vertex1, err := graphDB.GetVertex(ctx, 1)
+	if err != nil {
+		t.Fatalf("Can't get vertex: %s\n", err.Error())
+	}
+	if vertex1.Weight != 5 || len(vertex1.Vertices) != 1 || vertex1.Vertices[0] != 2 {
+		t.Errorf("Bad migrated vertex after bulk load: %v\n", vertex1)
+	}
+	vertex2, err := graphDB.GetVertex(ctx, 2)
+	if err != nil {
+		t.Fatalf("Can't get vertex: %s\n", err.Error())
+	}
+	if vertex2.Weight != 0 || len(vertex2.Vertices) != 2 {
+		t.Errorf("Bad created vertex after bulk load: %v\n", vertex2)
+	}
+	edge, err := graphDB.GetEdge(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("Can't get edge: %s\n", err.Error())
+	}
+	if edge.Weight != 0.7 {
+		t.Errorf("Expected replaced edge weight 0.7, got %f\n", edge.Weight)
+	}
+
+	// Loading again keeps edge properties and doesn't duplicate neighbors.
+	if err := graphDB.SetEdgeProperty(ctx, 1, 2, "color", []byte("red")); err != nil {
+		t.Fatalf("Can't set edge property: %s\n", err.Error())
+	}
+	edges = edgeSlice{{1, 2, 0.9}}
+	if _, err := graphDB.BulkLoadEdges(ctx, &edges); err != nil {
+		t.Fatalf("Error bulk loading edges: %s\n", err.Error())
+	}
+	if edge, err = graphDB.GetEdge(ctx, 1, 2); err != nil || edge.Weight != 0.9 {
+		t.Errorf("Bad edge after reload: %v, %v\n", edge, err)
+	} else if _, found := edge.Properties["color"]; !found {
+		t.Errorf("Edge property lost after reload: %v\n", edge)
+	}
+	if vertex1, _ = graphDB.GetVertex(ctx, 1); len(vertex1.Vertices) != 1 {
+		t.Errorf("Duplicated neighbors after reload: %v\n", vertex1)
+	}
+
+	edges = edgeSlice{{4, 4, 1}}
+	if _, err := graphDB.BulkLoadEdges(ctx, &edges); err == nil {
+		t.Errorf("Expected error loading self-edge\n")
+	}
+}
+
+func TestGraphVertexIndex(t *testing.T) {
+	db := newMemoryDB()
+	ctx := GetTestDataContext(TestUUID1, "graph", 14)
+	engine, _ := NewGraphStore(db)
+	graphDB := engine.(*GraphKeyValueDB)
+
+	compression, _ := dvid.NewCompression(dvid.Uncompressed, dvid.DefaultCompression)
+	setProperty := func(id dvid.VertexID, key, value string) {
+		serialization, err := dvid.SerializeData([]byte(value), compression, dvid.NoChecksum)
+		if err != nil {
+			t.Fatalf("Can't serialize property: %s\n", err.Error())
+		}
+		if err := graphDB.SetVertexProperty(ctx, id, key, serialization); err != nil {
+			t.Fatalf("Can't set vertex property: %s\n", err.Error())
+		}
+	}
+	for id := dvid.VertexID(1); id <= 5; id++ {
+		if err := graphDB.AddVertex(ctx, id, 0); err != nil {
+			t.Fatalf("Can't add vertex: %s\n", err.Error())
+		}
+	}
+	setProperty(1, "size", "2e6")
+	setProperty(2, "size", "500")
+	setProperty(3, "size", "-7")
+	setProperty(4, "size", "3000000")
+	setProperty(1, "status", "needs-review")
+
+	// Index creation picks up existing values and later writes update the index.
+	if err := graphDB.CreateVertexIndex(ctx, "size", NumberIndex); err != nil {
+		t.Fatalf("Can't create index: %s\n", err.Error())
+	}
+	if err := graphDB.CreateVertexIndex(ctx, "status", StringIndex); err != nil {
+		t.Fatalf("Can't create index: %s\n", err.Error())
+	}
+	if err := graphDB.CreateVertexIndex(ctx, "size", StringIndex); err == nil {
+		t.Errorf("Expected error changing kind of index\n")
+	}
+	setProperty(4, "status", "needs-review")
+	setProperty(2, "status", "done")
+	setProperty(4, "size", "1500000")
+
+	indexes, err := graphDB.VertexIndexes(ctx)
+	if err != nil {
+		t.Fatalf("Can't get indexes: %s\n", err.Error())
+	}
+	if len(indexes) != 2 || indexes["size"] != NumberIndex || indexes["status"] != StringIndex {
+		t.Errorf("Bad indexes: %v\n", indexes)
+	}
+
+	tests := []struct {
+		conditions []PropertyCondition
+		expected   []dvid.VertexID
+	}{
+		{[]PropertyCondition{{"size", ">", "1e6"}}, []dvid.VertexID{1, 4}},
+		{[]PropertyCondition{{"size", "<", "0"}}, []dvid.VertexID{3}},
+		{[]PropertyCondition{{"size", "<=", "500"}}, []dvid.VertexID{2, 3}},
+		{[]PropertyCondition{{"size", "=", "1500000"}}, []dvid.VertexID{4}},
+		{[]PropertyCondition{{"status", "=", "needs-review"}, {"size", ">", "1.6e6"}}, []dvid.VertexID{1}},
+		{[]PropertyCondition{{"status", ">=", "e"}}, []dvid.VertexID{1, 4}},
+		{[]PropertyCondition{{"status", "<", "needs"}}, []dvid.VertexID{2}},
+	}
+	for _, test := range tests {
+		vertices, err := graphDB.QueryVertices(ctx, test.conditions)
+		if err != nil {
+			t.Fatalf("Error querying %v: %s\n", test.conditions, err.Error())
+		}
+		if len(vertices) != len(test.expected) {
+			t.Errorf("Query %v: expected %v, got %v\n", test.conditions, test.expected, vertices)
+			continue
+		}
+		for i, id := range vertices {
+			if id != test.expected[i] {
+				t.Errorf("Query %v: expected %v, got %v\n", test.conditions, test.expected, vertices)
+				break
+			}
+		}
+	}
+
+	// Removed properties and vertices are removed from the index.
+	if err := graphDB.RemoveVertexProperty(ctx, 4, "status"); err != nil {
+		t.Fatalf("Can't remove vertex property: %s\n", err.Error())
+	}
+	if err := graphDB.RemoveVertex(ctx, 1); err != nil {
+		t.Fatalf("Can't remove vertex: %s\n", err.Error())
+	}
+	vertices, err := graphDB.QueryVertices(ctx, []PropertyCondition{{"status", "=", "needs-review"}})
+	if err != nil || len(vertices) != 0 {
+		t.Errorf("Expected no vertices needing review, got %v, %v\n", vertices, err)
+	}
+	if vertices, _ = graphDB.QueryVertices(ctx, []PropertyCondition{{"size", ">", "1e6"}}); len(vertices) != 1 || vertices[0] != 4 {
+		t.Errorf("Expected only vertex 4 with large size, got %v\n", vertices)
+	}
+
+	if err := graphDB.DropVertexIndex(ctx, "size"); err != nil {
+		t.Fatalf("Can't drop index: %s\n", err.Error())
+	}
+	if _, err := graphDB.QueryVertices(ctx, []PropertyCondition{{"size", ">", "0"}}); err == nil {
+		t.Errorf("Expected error querying dropped index\n")
+	}
+}
@@ -0,0 +1,343 @@
+// +build graphkeyvalue
+
+/*
+   This file supports secondary indexes on vertex properties so vertices can be found by
+   property values with range scans instead of traversing the whole graph.  Index keys are
+   kept with the other keys of the graph:
+
+     keyIndexDef + property name -> index kind
+     keyIndexEntry + property name + 0 + encoded value + vertex ID -> empty
+
+   Encoded values sort in the order of the index kind, so conditions map to key ranges.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Number of index entries written per batch when creating an index.
+const indexBatchSize = 1000
+
+func indexDefKey(property string) []byte {
+	return append([]byte{byte(keyIndexDef)}, property...)
+}
+
+// indexEntryPrefix returns the prefix of all index entries for a property.
+func indexEntryPrefix(property string) []byte {
+	key := append([]byte{byte(keyIndexEntry)}, property...)
+	return append(key, 0)
+}
+
+func indexEntryKey(property string, encoded []byte, id dvid.VertexID) []byte {
+	key := append(indexEntryPrefix(property), encoded...)
+	idBytes := make([]byte, vertexIDSize)
+	binary.BigEndian.PutUint64(idBytes, uint64(id))
+	return append(key, idBytes...)
+}
+
+// encodeIndexValue returns an encoding of a property value whose byte order matches the
+// order of values for the index kind.  Strings are terminated with a 0 byte so shorter
+// strings sort first, and numbers are float64 with the sign bit flipped for positive
+// numbers and all bits flipped for negative ones.
+func encodeIndexValue(kind PropertyIndexKind, value []byte) ([]byte, error) {
+	switch kind {
+	case StringIndex:
+		if bytes.IndexByte(value, 0) >= 0 {
+			return nil, fmt.Errorf("String index values cannot contain 0 bytes")
+		}
+		encoded := make([]byte, len(value)+1)
+		copy(encoded, value)
+		return encoded, nil
+	case NumberIndex:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(value)), 64)
+		if err != nil || math.IsNaN(f) {
+			return nil, fmt.Errorf("Value %q is not a number", value)
+		}
+		if f == 0 {
+			f = 0 // don't distinguish negative zero
+		}
+		bits := math.Float64bits(f)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		encoded := make([]byte, 8)
+		binary.BigEndian.PutUint64(encoded, bits)
+		return encoded, nil
+	default:
+		return nil, fmt.Errorf("Unknown property index kind %d", kind)
+	}
+}
+
+// indexEntry returns the index entry key for a serialized property value or false if the
+// value can't be indexed.
+func indexEntry(kind PropertyIndexKind, property string, id dvid.VertexID, serialized []byte) ([]byte, bool) {
+	value, _, err := dvid.DeserializeData(serialized, true)
+	if err != nil {
+		return nil, false
+	}
+	encoded, err := encodeIndexValue(kind, value)
+	if err != nil {
+		return nil, false
+	}
+	return indexEntryKey(property, encoded, id), true
+}
+
+// indexKind returns the kind of index on a vertex property or false if not indexed.
+func (db *GraphKeyValueDB) indexKind(ctx Context, property string) (PropertyIndexKind, bool, error) {
+	data, err := db.Get(ctx, indexDefKey(property))
+	if err != nil || len(data) == 0 {
+		return 0, false, err
+	}
+	return PropertyIndexKind(data[0]), true, nil
+}
+
+// updateIndex adds to a batch the index changes for a vertex property going from the old
+// to the new serialized value.  A nil value means the property isn't set.
+func (db *GraphKeyValueDB) updateIndex(ctx Context, batcher Batch, id dvid.VertexID, property string, oldValue, newValue []byte) error {
+	kind, indexed, err := db.indexKind(ctx, property)
+	if err != nil || !indexed {
+		return err
+	}
+	if oldValue != nil {
+		if key, ok := indexEntry(kind, property, id, oldValue); ok {
+			batcher.Delete(key)
+		}
+	}
+	if newValue != nil {
+		if key, ok := indexEntry(kind, property, id, newValue); ok {
+			batcher.Put(key, []byte{})
+		}
+	}
+	return nil
+}
+
+// CreateVertexIndex indexes the given vertex property, scanning all vertex properties to
+// index current values.  Creating an existing index of the same kind does nothing.
+func (db *GraphKeyValueDB) CreateVertexIndex(ctx Context, property string, kind PropertyIndexKind) error {
+	if kind != StringIndex && kind != NumberIndex {
+		return fmt.Errorf("Unknown property index kind %d", kind)
+	}
+	if strings.IndexByte(property, 0) >= 0 {
+		return fmt.Errorf("Indexed property names cannot contain 0 bytes")
+	}
+	existing, indexed, err := db.indexKind(ctx, property)
+	if err != nil {
+		return err
+	}
+	if indexed {
+		if existing != kind {
+			return fmt.Errorf("Vertex property %q already has a %s index", property, existing)
+		}
+		return nil
+	}
+
+	// Find the values of the property for all vertices.
+	gctx := NewGraphContext(ctx)
+	var entries [][]byte
+	var scanErr error
+	keylb := &graphIndex{keyVertexProperty, 0, 0, ""}
+	keyub := &graphIndex{keyVertexProperty, ^dvid.VertexID(0), 0, "\xFF"}
+	err = db.ProcessRange(ctx, keylb.Bytes(), keyub.Bytes(), &ChunkOp{}, func(chunk *Chunk) {
+		if chunk == nil || chunk.KeyValue == nil || scanErr != nil {
+			return
+		}
+		index, err := gctx.IndexFromKey(chunk.K)
+		if err != nil {
+			scanErr = err
+			return
+		}
+		var propIndex graphIndex
+		if len(index) < 1+vertexIDSize || propIndex.IndexFromBytes(index) != nil || propIndex.property != property {
+			return
+		}
+		if key, ok := indexEntry(kind, property, propIndex.vertex1, chunk.V); ok {
+			entries = append(entries, key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+
+	for len(entries) > indexBatchSize {
+		batcher := db.dbbatch.NewBatch(ctx)
+		for _, key := range entries[:indexBatchSize] {
+			batcher.Put(key, []byte{})
+		}
+		if err := batcher.Commit(); err != nil {
+			return err
+		}
+		entries = entries[indexBatchSize:]
+	}
+
+	// The index is only used once its definition is written with the last entries.
+	batcher := db.dbbatch.NewBatch(ctx)
+	for _, key := range entries {
+		batcher.Put(key, []byte{})
+	}
+	batcher.Put(indexDefKey(property), []byte{byte(kind)})
+	return batcher.Commit()
+}
+
+// DropVertexIndex removes the index of the given vertex property.
+func (db *GraphKeyValueDB) DropVertexIndex(ctx Context, property string) error {
+	_, indexed, err := db.indexKind(ctx, property)
+	if err != nil {
+		return err
+	}
+	if !indexed {
+		return fmt.Errorf("No index for vertex property %q", property)
+	}
+	if err := db.Delete(ctx, indexDefKey(property)); err != nil {
+		return err
+	}
+	prefix := indexEntryPrefix(property)
+	keylb := append(prefix, make([]byte, vertexIDSize+1)...)
+	keyub := append(append([]byte{}, prefix[:len(prefix)-1]...), 1)
+	return db.DeleteRange(ctx, keylb, keyub)
+}
+
+// VertexIndexes returns the kinds of all indexed vertex properties.
+func (db *GraphKeyValueDB) VertexIndexes(ctx Context) (map[string]PropertyIndexKind, error) {
+	keylb := []byte{byte(keyIndexDef), 0}
+	keyub := []byte{byte(keyIndexEntry)}
+	keyvalues, err := db.GetRange(ctx, keylb, keyub)
+	if err != nil {
+		return nil, err
+	}
+	gctx := NewGraphContext(ctx)
+	indexes := make(map[string]PropertyIndexKind, len(keyvalues))
+	for _, kv := range keyvalues {
+		index, err := gctx.IndexFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		if len(index) < 2 || graphType(index[0]) != keyIndexDef || len(kv.V) == 0 {
+			continue
+		}
+		indexes[string(index[1:])] = PropertyIndexKind(kv.V[0])
+	}
+	return indexes, nil
+}
+
+type vertexIDSlice []dvid.VertexID
+
+func (s vertexIDSlice) Len() int           { return len(s) }
+func (s vertexIDSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s vertexIDSlice) Less(i, j int) bool { return s[i] < s[j] }
+
+// QueryVertices returns the sorted IDs of vertices that satisfy all conditions using a
+// range scan of the index for each condition.
+func (db *GraphKeyValueDB) QueryVertices(ctx Context, conditions []PropertyCondition) ([]dvid.VertexID, error) {
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("Vertex query requires at least one condition")
+	}
+	var matches map[dvid.VertexID]bool
+	for _, condition := range conditions {
+		ids, err := db.queryIndex(ctx, condition)
+		if err != nil {
+			return nil, err
+		}
+		if matches == nil {
+			matches = ids
+		} else {
+			for id := range matches {
+				if !ids[id] {
+					delete(matches, id)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			break
+		}
+	}
+	vertices := make([]dvid.VertexID, 0, len(matches))
+	for id := range matches {
+		vertices = append(vertices, id)
+	}
+	sort.Sort(vertexIDSlice(vertices))
+	return vertices, nil
+}
+
+// queryIndex returns the vertices that satisfy one condition.
+func (db *GraphKeyValueDB) queryIndex(ctx Context, condition PropertyCondition) (map[dvid.VertexID]bool, error) {
+	kind, indexed, err := db.indexKind(ctx, condition.Property)
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		return nil, fmt.Errorf("No index for vertex property %q", condition.Property)
+	}
+	encoded, err := encodeIndexValue(kind, []byte(condition.Value))
+	if err != nil {
+		return nil, fmt.Errorf("Bad value for %s index on %q: %s", kind, condition.Property, err.Error())
+	}
+
+	var match func(cmp int) bool
+	switch condition.Op {
+	case "=":
+		match = func(cmp int) bool { return cmp == 0 }
+	case "<":
+		match = func(cmp int) bool { return cmp < 0 }
+	case "<=":
+		match = func(cmp int) bool { return cmp <= 0 }
+	case ">":
+		match = func(cmp int) bool { return cmp > 0 }
+	case ">=":
+		match = func(cmp int) bool { return cmp >= 0 }
+	default:
+		return nil, fmt.Errorf("Unknown comparison %q for vertex property %q", condition.Op, condition.Property)
+	}
+
+	// Scan the smallest key range holding all entries that can match.  Since versioned
+	// keys have a version suffix, the lower bound is padded with zeros to not exclude
+	// the smallest entries.
+	prefix := indexEntryPrefix(condition.Property)
+	keylb := indexEntryKey(condition.Property, []byte{0}, 0)
+	keyub := append(append([]byte{}, prefix[:len(prefix)-1]...), 1)
+	switch condition.Op {
+	case "=":
+		keylb = indexEntryKey(condition.Property, encoded, 0)
+		keyub = indexEntryKey(condition.Property, encoded, ^dvid.VertexID(0))
+	case ">", ">=":
+		keylb = indexEntryKey(condition.Property, encoded, 0)
+	case "<", "<=":
+		keyub = indexEntryKey(condition.Property, encoded, ^dvid.VertexID(0))
+	}
+	keys, err := db.KeysInRange(ctx, keylb, keyub)
+	if err != nil {
+		return nil, err
+	}
+
+	gctx := NewGraphContext(ctx)
+	ids := make(map[dvid.VertexID]bool)
+	for _, key := range keys {
+		index, err := gctx.IndexFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(index) < len(prefix)+vertexIDSize || !bytes.HasPrefix(index, prefix) {
+			continue
+		}
+		entry := index[len(prefix):]
+		value := entry[:len(entry)-vertexIDSize]
+		if match(bytes.Compare(value, encoded)) {
+			ids[dvid.VertexID(binary.BigEndian.Uint64(entry[len(value):]))] = true
+		}
+	}
+	return ids, nil
+}
@@ -10,6 +10,9 @@
    Most actions that involve multiple writes are done as a batched transaction to maintain
    atomicity.  However, transactions should probably be supported so that read-write actions
    are performed as one atomic step.
+
+   Graph key-value pairs are kept in their own partition of the key space (see
+   NewGraphContext) so they can't collide with other data of the same instance.
 */
 
 package storage
@@ -18,6 +21,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/janelia-flyem/dvid/dvid"
 )
@@ -30,6 +35,8 @@ const (
 	keyEdge
 	keyVertexProperty
 	keyEdgeProperty
+	keyIndexDef   // see graphindex.go
+	keyIndexEntry // see graphindex.go
 	keyMax
 )
 
@@ -130,10 +137,186 @@ func NewGraphStore(kvdb OrderedKeyValueDB) (Engine, error) {
 	if !ok {
 		err = fmt.Errorf("DVID key-value needed by graph does not support batch write")
 	}
-	graphdb := &GraphKeyValueDB{kvdb, dbbatch}
+	keyspace := &graphKeyspace{
+		OrderedKeyValueDB: kvdb,
+		batcher:           dbbatch,
+		migrated:          make(map[dvid.InstanceID]bool),
+	}
+	graphdb := &GraphKeyValueDB{keyspace, keyspace}
 	return graphdb, err
 }
 
+// graphKeyspace wraps a key-value database so all access is through the graph partition
+// of the key space.  Graphs stored before the graph partition existed were kept with the
+// data keys of their instance, so those keys are moved into the graph partition the first
+// time an instance's graph is accessed.
+type graphKeyspace struct {
+	OrderedKeyValueDB
+	batcher KeyValueBatcher
+
+	mu       sync.Mutex
+	migrated map[dvid.InstanceID]bool
+}
+
+// context returns the graph partition context for a data context, moving any legacy
+// graph keys of the instance into the graph partition if not already done.
+func (db *graphKeyspace) context(ctx Context) (Context, error) {
+	if ctx == nil {
+		return nil, nil
+	}
+	instanceID, _, err := KeyToLocalIDs(ctx.ConstructKey(nil))
+	if err != nil {
+		return nil, fmt.Errorf("Graph requires a data context, not %s", ctx)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.migrated[instanceID] {
+		if err := db.migrate(instanceID); err != nil {
+			return nil, err
+		}
+		db.migrated[instanceID] = true
+	}
+	return NewGraphContext(ctx), nil
+}
+
+// migrate moves legacy graph keys of an instance into the graph partition in batches.
+func (db *graphKeyspace) migrate(instanceID dvid.InstanceID) error {
+	if db.batcher == nil {
+		return nil
+	}
+	const batchSize = 1000
+	var moved int
+	var kvs []KeyValue
+	moveBatch := func() error {
+		if len(kvs) == 0 {
+			return nil
+		}
+		batch := db.batcher.NewBatch(nil)
+		for _, kv := range kvs {
+			graphKey := append([]byte{graphKeyPrefix}, kv.K[1:]...)
+			batch.Put(graphKey, kv.V)
+			batch.Delete(kv.K)
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+		moved += len(kvs)
+		kvs = nil
+		return nil
+	}
+
+	var moveErr error
+	minKey, maxKey := DataContextKeyRange(instanceID)
+	err := db.OrderedKeyValueDB.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+		if chunk == nil || chunk.KeyValue == nil || moveErr != nil {
+			return
+		}
+		// Only move keys with graph indices, which start with a graph key type.
+		if len(chunk.K) <= 1+dvid.InstanceIDSize || graphType(chunk.K[1+dvid.InstanceIDSize]) >= keyMax {
+			return
+		}
+		kvs = append(kvs, KeyValue{append([]byte(nil), chunk.K...), append([]byte(nil), chunk.V...)})
+		if len(kvs) >= batchSize {
+			moveErr = moveBatch()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if moveErr != nil {
+		return moveErr
+	}
+	if err := moveBatch(); err != nil {
+		return err
+	}
+	if moved != 0 {
+		dvid.Infof("Moved %d graph key-value pairs of instance %d into graph partition\n", moved, instanceID)
+	}
+	return nil
+}
+
+func (db *graphKeyspace) Get(ctx Context, k []byte) ([]byte, error) {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.OrderedKeyValueDB.Get(gctx, k)
+}
+
+func (db *graphKeyspace) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.OrderedKeyValueDB.GetRange(gctx, kStart, kEnd)
+}
+
+func (db *graphKeyspace) KeysInRange(ctx Context, kStart, kEnd []byte) ([][]byte, error) {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.OrderedKeyValueDB.KeysInRange(gctx, kStart, kEnd)
+}
+
+func (db *graphKeyspace) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return err
+	}
+	return db.OrderedKeyValueDB.ProcessRange(gctx, kStart, kEnd, op, f)
+}
+
+func (db *graphKeyspace) Put(ctx Context, k, v []byte) error {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return err
+	}
+	return db.OrderedKeyValueDB.Put(gctx, k, v)
+}
+
+func (db *graphKeyspace) Delete(ctx Context, k []byte) error {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return err
+	}
+	return db.OrderedKeyValueDB.Delete(gctx, k)
+}
+
+func (db *graphKeyspace) PutRange(ctx Context, values []KeyValue) error {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return err
+	}
+	return db.OrderedKeyValueDB.PutRange(gctx, values)
+}
+
+func (db *graphKeyspace) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return err
+	}
+	return db.OrderedKeyValueDB.DeleteRange(gctx, kStart, kEnd)
+}
+
+// NewBatch returns a batch in the graph partition.  Since batches can't return errors
+// until committed, a failed migration is returned on Commit.
+func (db *graphKeyspace) NewBatch(ctx Context) Batch {
+	gctx, err := db.context(ctx)
+	if err != nil {
+		return failedBatch{err}
+	}
+	return db.batcher.NewBatch(gctx)
+}
+
+type failedBatch struct {
+	err error
+}
+
+func (b failedBatch) Delete(k []byte) {}
+func (b failedBatch) Put(k, v []byte) {}
+func (b failedBatch) Commit() error   { return b.err }
+
 // GetName returns the name of theengien
 func (db *GraphKeyValueDB) String() string {
 	return "graph db using key value datastore"
@@ -314,7 +497,8 @@ func (db *GraphKeyValueDB) SetEdgeWeight(ctx Context, id1 dvid.VertexID, id2 dvi
 	return err
 }
 
-// SetVertexProperty modifies the vertex and adds a property vertex key (1 read, 2 writes)
+// SetVertexProperty modifies the vertex and adds a property vertex key, updating any index
+// on the property (3 reads, 2 writes plus any index update)
 func (db *GraphKeyValueDB) SetVertexProperty(ctx Context, id dvid.VertexID, key string, value []byte) error {
 	// load data
 	vertexIndex := &graphIndex{keyVertex, id, 0, ""}
@@ -327,6 +511,13 @@ func (db *GraphKeyValueDB) SetVertexProperty(ctx Context, id dvid.VertexID, key
 	if err != nil {
 		return nil
 	}
+	oldValue, err := db.GetVertexProperty(ctx, id, key)
+	if err != nil {
+		return err
+	}
+	if err := db.updateIndex(ctx, batcher, id, key, oldValue, value); err != nil {
+		return err
+	}
 	batcher.Put(propIndex.Bytes(), value)
 
 	vertex.Properties[key] = struct{}{}
@@ -378,28 +569,26 @@ func (db *GraphKeyValueDB) RemoveGraph(ctx Context) error {
 }
 
 // RemoveVertex removes the vertex and all of its edges and properties
-// (1 read, 1 + num edges + num properties writes)
+// (1 + num properties reads, 1 + num edges + num properties writes)
 func (db *GraphKeyValueDB) RemoveVertex(ctx Context, id dvid.VertexID) error {
 	batcher := db.dbbatch.NewBatch(ctx)
 
-	keylb := &graphIndex{keyVertexProperty, id, 0, ""}
-	keyub := &graphIndex{keyVertexProperty, id, 1, ""}
-	keys, err := db.KeysInRange(ctx, keylb.Bytes(), keyub.Bytes())
+	vertex, err := db.GetVertex(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	// batch deletion for vertex and edge
-	for _, key := range keys {
-		batcher.Delete(key)
+	// batch deletion for vertex properties and their index entries
+	for key := range vertex.Properties {
+		value, err := db.GetVertexProperty(ctx, id, key)
 		if err != nil {
 			return err
 		}
-	}
-
-	vertex, err := db.GetVertex(ctx, id)
-	if err != nil {
-		return err
+		if err := db.updateIndex(ctx, batcher, id, key, value, nil); err != nil {
+			return err
+		}
+		propIndex := &graphIndex{keyVertexProperty, id, 0, key}
+		batcher.Delete(propIndex.Bytes())
 	}
 
 	// edges are removed in separate transactions from vertex and properties!
@@ -477,7 +666,7 @@ func (db *GraphKeyValueDB) RemoveEdge(ctx Context, id1 dvid.VertexID, id2 dvid.V
 }
 
 // RemoveVertexProperty retrieves vertex and batch removes property and modifies vertex
-// (1 read, 2 writes)
+// (2 reads, 2 writes plus any index update)
 func (db *GraphKeyValueDB) RemoveVertexProperty(ctx Context, id dvid.VertexID, key string) error {
 	vertex, err := db.GetVertex(ctx, id)
 	if err != nil {
@@ -495,6 +684,13 @@ func (db *GraphKeyValueDB) RemoveVertexProperty(ctx Context, id dvid.VertexID, k
 		return nil
 	}
 
+	value, err := db.GetVertexProperty(ctx, id, key)
+	if err != nil {
+		return err
+	}
+	if err := db.updateIndex(ctx, batcher, id, key, value, nil); err != nil {
+		return err
+	}
 	propIndex := &graphIndex{keyVertexProperty, id, 0, key}
 	batcher.Delete(propIndex.Bytes())
 	err = batcher.Commit()
@@ -614,3 +810,119 @@ func (db *GraphKeyValueDB) GetEdgeProperty(ctx Context, id1 dvid.VertexID, id2 d
 	data, err := db.Get(ctx, propIndex.Bytes())
 	return data, err
 }
+
+// Number of edges written per batch by BulkLoadEdges.
+const bulkEdgeBatchSize = 10000
+
+// BulkLoadEdges adds edges in batches, reading each affected vertex once per batch
+// instead of twice per edge as with AddEdge.
+func (db *GraphKeyValueDB) BulkLoadEdges(ctx Context, edges EdgeReader) (uint64, error) {
+	var loaded uint64
+	batch := make([]WeightedEdge, 0, bulkEdgeBatchSize)
+	for {
+		edge, err := edges.ReadEdge()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if edge.Vertex1 == edge.Vertex2 {
+			return loaded, fmt.Errorf("Edge %d after %d loaded edges connects vertex %d to itself",
+				len(batch)+1, loaded, edge.Vertex1)
+		}
+		batch = append(batch, edge)
+		if len(batch) == bulkEdgeBatchSize {
+			if err := db.loadEdgeBatch(ctx, batch); err != nil {
+				return loaded, err
+			}
+			loaded += uint64(len(batch))
+			batch = batch[:0]
+		}
+	}
+	if err := db.loadEdgeBatch(ctx, batch); err != nil {
+		return loaded, err
+	}
+	return loaded + uint64(len(batch)), nil
+}
+
+// bulkVertex is a vertex being modified by a bulk load.
+type bulkVertex struct {
+	vertex    dvid.GraphVertex
+	neighbors map[dvid.VertexID]bool // true if the edge was stored before this batch
+}
+
+// loadEdgeBatch adds edges and the affected vertices in one batch.
+func (db *GraphKeyValueDB) loadEdgeBatch(ctx Context, edges []WeightedEdge) error {
+	if len(edges) == 0 {
+		return nil
+	}
+	vertices := make(map[dvid.VertexID]*bulkVertex)
+	getVertex := func(id dvid.VertexID) (*bulkVertex, error) {
+		if v, found := vertices[id]; found {
+			return v, nil
+		}
+		v := &bulkVertex{neighbors: make(map[dvid.VertexID]bool)}
+		vertexIndex := &graphIndex{keyVertex, id, 0, ""}
+		data, err := db.Get(ctx, vertexIndex.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			properties := make(dvid.ElementProperties)
+			v.vertex = dvid.GraphVertex{&dvid.GraphElement{properties, 0}, id, nil}
+		} else {
+			if v.vertex, err = db.deserializeVertex(data); err != nil {
+				return nil, err
+			}
+			for _, neighbor := range v.vertex.Vertices {
+				v.neighbors[neighbor] = true
+			}
+		}
+		vertices[id] = v
+		return v, nil
+	}
+
+	batcher := db.dbbatch.NewBatch(ctx)
+	for _, e := range edges {
+		v1, err := getVertex(e.Vertex1)
+		if err != nil {
+			return err
+		}
+		v2, err := getVertex(e.Vertex2)
+		if err != nil {
+			return err
+		}
+
+		// Keep the properties of edges that were already stored.
+		properties := make(dvid.ElementProperties)
+		if v1.neighbors[e.Vertex2] {
+			stored, err := db.GetEdge(ctx, e.Vertex1, e.Vertex2)
+			if err == nil && stored.GraphElement != nil && stored.Properties != nil {
+				properties = stored.Properties
+			}
+		}
+		if _, found := v1.neighbors[e.Vertex2]; !found {
+			v1.neighbors[e.Vertex2] = false
+			v1.vertex.Vertices = append(v1.vertex.Vertices, e.Vertex2)
+		}
+		if _, found := v2.neighbors[e.Vertex1]; !found {
+			v2.neighbors[e.Vertex1] = false
+			v2.vertex.Vertices = append(v2.vertex.Vertices, e.Vertex1)
+		}
+
+		// GraphEdge should have smaller id as id1
+		id1, id2 := e.Vertex1, e.Vertex2
+		if id1 > id2 {
+			id1, id2 = id2, id1
+		}
+		edge := dvid.GraphEdge{&dvid.GraphElement{properties, e.Weight}, dvid.VertexPairID{id1, id2}}
+		edgeIndex := &graphIndex{keyEdge, id1, id2, ""}
+		batcher.Put(edgeIndex.Bytes(), db.serializeEdge(edge))
+	}
+	for id, v := range vertices {
+		vertexIndex := &graphIndex{keyVertex, id, 0, ""}
+		batcher.Put(vertexIndex.Bytes(), db.serializeVertex(v.vertex))
+	}
+	return batcher.Commit()
+}
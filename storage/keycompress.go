@@ -0,0 +1,346 @@
+/*
+	This file implements an engine wrapper that compresses the data instance prefix of
+	DVID keys.  Data keys have the form
+
+		dataKeyPrefix (1 byte) + instance ID (4 bytes) + index + version ID (4 bytes)
+
+	and since instance IDs are assigned sequentially from 1, nearly every key starts
+	with the same 3 zero bytes.  The wrapper replaces the fixed-width instance ID with an
+	order-preserving variable-length encoding so most keys shrink by 3 bytes, reducing
+	index size and memory footprint for stores with billions of keys.  The version suffix
+	is left alone since versions of an index must sort after it regardless of the length
+	of other indices.
+
+	Because stored keys change, compression is chosen when a store is created and
+	recorded in its metadata.  Keys passed to and returned from the wrapper are always
+	in the uncompressed form, so callers are unaware of compression.
+*/
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// The metadata index that marks a store as using compressed keys.
+var keyCompressionIndex = []byte{0xA2, 'k', 'e', 'y', 'c', 'o', 'm', 'p'}
+
+const keyCompressionVersion = "1"
+
+// appendInstanceID appends an order-preserving variable-length encoding of an
+// instance ID.  The high bits of the first byte give the number of following bytes.
+func appendInstanceID(buf []byte, id uint32) []byte {
+	switch {
+	case id < 1<<7:
+		return append(buf, byte(id))
+	case id < 1<<14:
+		return append(buf, 0x80|byte(id>>8), byte(id))
+	case id < 1<<21:
+		return append(buf, 0xC0|byte(id>>16), byte(id>>8), byte(id))
+	case id < 1<<28:
+		return append(buf, 0xE0|byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	default:
+		return append(buf, 0xF0, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	}
+}
+
+// readInstanceID decodes an instance ID encoded by appendInstanceID and returns
+// the number of bytes read.
+func readInstanceID(b []byte) (uint32, int, error) {
+	if len(b) == 0 {
+		return 0, 0, fmt.Errorf("no bytes for compressed instance id")
+	}
+	var n int
+	switch {
+	case b[0] < 0x80:
+		return uint32(b[0]), 1, nil
+	case b[0] < 0xC0:
+		n = 2
+	case b[0] < 0xE0:
+		n = 3
+	case b[0] < 0xF0:
+		n = 4
+	default:
+		n = 5
+	}
+	if len(b) < n {
+		return 0, 0, fmt.Errorf("compressed instance id needs %d bytes, has %d", n, len(b))
+	}
+	var id uint32
+	if n < 5 {
+		id = uint32(b[0] & (0xFF >> uint(n)))
+	}
+	for i := 1; i < n; i++ {
+		id = id<<8 | uint32(b[i])
+	}
+	return id, n, nil
+}
+
+// compressKey returns the compressed form of a full key.  Non-data keys and data keys
+// too short to hold an instance ID are unchanged.
+func compressKey(key []byte) []byte {
+	if len(key) < 1+dvid.InstanceIDSize || key[0] != dataKeyPrefix {
+		return key
+	}
+	instanceID := dvid.InstanceIDFromBytes(key[1 : 1+dvid.InstanceIDSize])
+	compressed := make([]byte, 1, len(key))
+	compressed[0] = dataKeyPrefix
+	compressed = appendInstanceID(compressed, uint32(instanceID))
+	return append(compressed, key[1+dvid.InstanceIDSize:]...)
+}
+
+// expandKey returns the uncompressed form of a key compressed by compressKey.
+func expandKey(key []byte) ([]byte, error) {
+	if len(key) < 2 || key[0] != dataKeyPrefix {
+		return key, nil
+	}
+	id, n, err := readInstanceID(key[1:])
+	if err != nil {
+		return nil, fmt.Errorf("Bad compressed key %v: %s", key, err.Error())
+	}
+	expanded := make([]byte, 0, len(key)-n+dvid.InstanceIDSize)
+	expanded = append(expanded, dataKeyPrefix)
+	expanded = append(expanded, dvid.InstanceID(id).Bytes()...)
+	return append(expanded, key[1+n:]...), nil
+}
+
+// compressedContext wraps a Context so keys constructed by storage engines are compressed.
+type compressedContext struct {
+	Context
+}
+
+func (ctx compressedContext) ConstructKey(index []byte) []byte {
+	return compressKey(ctx.Context.ConstructKey(index))
+}
+
+func (ctx compressedContext) IndexFromKey(key []byte) ([]byte, error) {
+	expanded, err := expandKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Context.IndexFromKey(expanded)
+}
+
+// compressedVersionedContext wraps a VersionedContext.
+type compressedVersionedContext struct {
+	compressedContext
+	vctx VersionedContext
+}
+
+func (ctx compressedVersionedContext) GetIterator() (VersionIterator, error) {
+	return ctx.vctx.GetIterator()
+}
+
+func (ctx compressedVersionedContext) MinVersionKey(index []byte) ([]byte, error) {
+	key, err := ctx.vctx.MinVersionKey(index)
+	if err != nil {
+		return nil, err
+	}
+	return compressKey(key), nil
+}
+
+func (ctx compressedVersionedContext) MaxVersionKey(index []byte) ([]byte, error) {
+	key, err := ctx.vctx.MaxVersionKey(index)
+	if err != nil {
+		return nil, err
+	}
+	return compressKey(key), nil
+}
+
+// VersionedKeyValue chooses among key-value pairs with compressed keys by passing
+// uncompressed keys to the wrapped context.
+func (ctx compressedVersionedContext) VersionedKeyValue(values []*KeyValue) (*KeyValue, error) {
+	expanded := make([]*KeyValue, len(values))
+	original := make(map[*KeyValue]*KeyValue, len(values))
+	for i, kv := range values {
+		k, err := expandKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		expanded[i] = &KeyValue{K: k, V: kv.V}
+		original[expanded[i]] = kv
+	}
+	kv, err := ctx.vctx.VersionedKeyValue(expanded)
+	if err != nil || kv == nil {
+		return nil, err
+	}
+	return original[kv], nil
+}
+
+func wrapContext(ctx Context) Context {
+	if ctx == nil {
+		return nil
+	}
+	if vctx, ok := ctx.(VersionedContext); ok {
+		return compressedVersionedContext{compressedContext{ctx}, vctx}
+	}
+	return compressedContext{ctx}
+}
+
+// keyCompressor wraps an ordered key-value store so keys are stored compressed.
+type keyCompressor struct {
+	Engine
+	db OrderedKeyValueDB
+}
+
+// UsesKeyCompression returns true if the store was created with compressed keys.
+func UsesKeyCompression(engine Engine) (bool, error) {
+	db, ok := engine.(OrderedKeyValueDB)
+	if !ok {
+		return false, fmt.Errorf("Database %q is not a valid ordered key-value database", engine)
+	}
+	value, err := db.Get(NewMetadataContext(), keyCompressionIndex)
+	if err != nil {
+		return false, err
+	}
+	if value == nil {
+		return false, nil
+	}
+	if string(value) != keyCompressionVersion {
+		return false, fmt.Errorf("Database %q uses unknown key compression version %q", engine, value)
+	}
+	return true, nil
+}
+
+// SetKeyCompression marks a newly created store as using compressed keys.  It is an
+// error to set compression on a store that already holds data.
+func SetKeyCompression(engine Engine) error {
+	db, ok := engine.(OrderedKeyValueDB)
+	if !ok {
+		return fmt.Errorf("Database %q is not a valid ordered key-value database", engine)
+	}
+	minKey := []byte{dataKeyPrefix}
+	maxKey := []byte{dataKeyPrefix, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	keys, err := db.KeysInRange(nil, minKey, maxKey)
+	if err != nil {
+		return err
+	}
+	if len(keys) != 0 {
+		return fmt.Errorf("Cannot set key compression on database %q that already holds data", engine)
+	}
+	return db.Put(NewMetadataContext(), keyCompressionIndex, []byte(keyCompressionVersion))
+}
+
+// NewKeyCompressor returns an engine that compresses keys stored in the given engine.
+// The engine must also be an ordered key-value store that supports batching.
+func NewKeyCompressor(engine Engine) (Engine, error) {
+	db, ok := engine.(OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("Database %q is not a valid ordered key-value database", engine)
+	}
+	if _, ok := engine.(KeyValueBatcher); !ok {
+		return nil, fmt.Errorf("Key compression requires a database that supports batches, %q does not", engine)
+	}
+	return &keyCompressor{engine, db}, nil
+}
+
+func (c *keyCompressor) String() string {
+	return fmt.Sprintf("%s with compressed keys", c.Engine)
+}
+
+// wrapKeys returns the context and keys to pass to the wrapped store.  Without a
+// context, the keys are full keys and must be compressed.
+func wrapKeys(ctx Context, keys ...[]byte) (Context, [][]byte) {
+	if ctx == nil {
+		compressed := make([][]byte, len(keys))
+		for i, k := range keys {
+			compressed[i] = compressKey(k)
+		}
+		return nil, compressed
+	}
+	return wrapContext(ctx), keys
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+func (c *keyCompressor) Get(ctx Context, k []byte) ([]byte, error) {
+	wctx, keys := wrapKeys(ctx, k)
+	return c.db.Get(wctx, keys[0])
+}
+
+func (c *keyCompressor) GetRange(ctx Context, kStart, kEnd []byte) ([]*KeyValue, error) {
+	wctx, keys := wrapKeys(ctx, kStart, kEnd)
+	values, err := c.db.GetRange(wctx, keys[0], keys[1])
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range values {
+		if kv.K, err = expandKey(kv.K); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (c *keyCompressor) KeysInRange(ctx Context, kStart, kEnd []byte) ([][]byte, error) {
+	wctx, keys := wrapKeys(ctx, kStart, kEnd)
+	fullKeys, err := c.db.KeysInRange(wctx, keys[0], keys[1])
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range fullKeys {
+		if fullKeys[i], err = expandKey(k); err != nil {
+			return nil, err
+		}
+	}
+	return fullKeys, nil
+}
+
+func (c *keyCompressor) ProcessRange(ctx Context, kStart, kEnd []byte, op *ChunkOp, f func(*Chunk)) error {
+	wctx, keys := wrapKeys(ctx, kStart, kEnd)
+	return c.db.ProcessRange(wctx, keys[0], keys[1], op, func(chunk *Chunk) {
+		if chunk != nil && chunk.KeyValue != nil {
+			expanded, err := expandKey(chunk.K)
+			if err != nil {
+				dvid.Errorf("Skipping key in range: %s\n", err.Error())
+				return
+			}
+			chunk.K = expanded
+		}
+		f(chunk)
+	})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (c *keyCompressor) Put(ctx Context, k, v []byte) error {
+	wctx, keys := wrapKeys(ctx, k)
+	return c.db.Put(wctx, keys[0], v)
+}
+
+func (c *keyCompressor) Delete(ctx Context, k []byte) error {
+	wctx, keys := wrapKeys(ctx, k)
+	return c.db.Delete(wctx, keys[0])
+}
+
+func (c *keyCompressor) PutRange(ctx Context, values []KeyValue) error {
+	if ctx == nil {
+		compressed := make([]KeyValue, len(values))
+		for i, kv := range values {
+			compressed[i] = KeyValue{compressKey(kv.K), kv.V}
+		}
+		return c.db.PutRange(nil, compressed)
+	}
+	return c.db.PutRange(wrapContext(ctx), values)
+}
+
+func (c *keyCompressor) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	wctx, keys := wrapKeys(ctx, kStart, kEnd)
+	return c.db.DeleteRange(wctx, keys[0], keys[1])
+}
+
+// ---- KeyValueBatcher interface ------
+
+// NewBatch returns a batch of the wrapped store.  Batch keys are indices that are
+// converted to compressed keys by the wrapped context.
+func (c *keyCompressor) NewBatch(ctx Context) Batch {
+	return c.wrapBatch(ctx, c.db.(KeyValueBatcher).NewBatch)
+}
+
+func (c *keyCompressor) wrappedStore() OrderedKeyValueSetter { return c.db }
+
+func (c *keyCompressor) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return newBatch(wrapContext(ctx))
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestInstanceIDEncoding(t *testing.T) {
+	ids := []uint32{0, 1, 127, 128, 300, 1<<14 - 1, 1 << 14, 1<<21 + 5, 1<<28 - 1, 1 << 28, 0xFFFFFFFF}
+	var prev []byte
+	for _, id := range ids {
+		encoding := appendInstanceID(nil, id)
+		decoded, n, err := readInstanceID(append(encoding, 0xFF))
+		if err != nil {
+			t.Fatalf("Error decoding instance id %d: %s\n", id, err.Error())
+		}
+		if decoded != id || n != len(encoding) {
+			t.Errorf("Instance id %d decoded as %d using %d of %d bytes\n", id, decoded, n, len(encoding))
+		}
+		if prev != nil && bytes.Compare(prev, encoding) >= 0 {
+			t.Errorf("Encoding of instance id %d (%v) doesn't sort after previous %v\n", id, encoding, prev)
+		}
+		prev = encoding
+	}
+}
+
+func TestKeyCompressor(t *testing.T) {
+	db := newMemoryDB()
+	c := &keyCompressor{db: db}
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 3)
+	otherCtx := GetTestDataContext(TestUUID1, "labels", 200)
+
+	for i := byte(0); i < 10; i++ {
+		if err := c.Put(ctx, []byte{1, i}, []byte{i}); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+	c.Put(otherCtx, []byte{1, 5}, []byte("other"))
+	if err := c.Put(NewMetadataContext(), []byte("meta"), []byte("data")); err != nil {
+		t.Fatalf("Error on metadata put: %s\n", err.Error())
+	}
+
+	// Stored data keys should be shorter while metadata keys are unchanged.
+	for k := range db.kv {
+		switch {
+		case k[0] == metadataKeyPrefix:
+			if k != string(NewMetadataContext().ConstructKey([]byte("meta"))) {
+				t.Errorf("Metadata key was modified: %v\n", []byte(k))
+			}
+		case k[1] == 3:
+			if len(k) != len(ctx.ConstructKey([]byte{1, 0}))-3 {
+				t.Errorf("Expected stored key %v to be compressed by 3 bytes\n", []byte(k))
+			}
+		case len(k) != len(otherCtx.ConstructKey([]byte{1, 5}))-2:
+			t.Errorf("Expected stored key %v to be compressed by 2 bytes\n", []byte(k))
+		}
+	}
+
+	// Reads return uncompressed keys.
+	values, err := c.GetRange(ctx, []byte{1, 2}, []byte{1, 5})
+	if err != nil {
+		t.Fatalf("Error on GetRange: %s\n", err.Error())
+	}
+	if len(values) != 4 {
+		t.Fatalf("Expected 4 key-values in range, got %d\n", len(values))
+	}
+	for i, kv := range values {
+		if !bytes.Equal(kv.K, ctx.ConstructKey([]byte{1, byte(i + 2)})) || kv.V[0] != byte(i+2) {
+			t.Errorf("Bad key-value returned: %v -> %v\n", kv.K, kv.V)
+		}
+	}
+	value, err := c.Get(otherCtx, []byte{1, 5})
+	if err != nil || string(value) != "other" {
+		t.Errorf("Bad value for other instance: %q, %v\n", value, err)
+	}
+
+	// Batches and deletes of full key ranges work on compressed keys.
+	batch := c.NewBatch(ctx)
+	batch.Delete([]byte{1, 0})
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	minKey, maxKey := DataContextKeyRange(dvid.InstanceID(3))
+	keys, err := c.KeysInRange(nil, minKey, maxKey)
+	if err != nil {
+		t.Fatalf("Error on KeysInRange: %s\n", err.Error())
+	}
+	if len(keys) != 9 || !bytes.Equal(keys[0], ctx.ConstructKey([]byte{1, 1})) {
+		t.Errorf("Expected 9 keys after batch delete starting with index 1, got %d: %v\n", len(keys), keys)
+	}
+	if err := c.DeleteRange(nil, minKey, maxKey); err != nil {
+		t.Fatalf("Error on DeleteRange: %s\n", err.Error())
+	}
+	if len(db.kv) != 2 {
+		t.Errorf("Expected only other instance and metadata to remain, got %d keys\n", len(db.kv))
+	}
+}
@@ -0,0 +1,606 @@
+// +build badger
+
+package local
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	humanize "github.com/janelia-flyem/go/go-humanize"
+)
+
+const (
+	Version = "Badger"
+
+	Driver = "github.com/dgraph-io/badger"
+
+	// Default maximum size of each value log file.  Large voxel blocks are stored in the
+	// value log so larger files mean fewer files to manage.
+	DefaultValueLogFileSize = 1024 * dvid.Mega
+
+	// Default number of key-value pairs to delete in one write batch for DeleteRange().
+	DefaultDeleteBatchSize = 10000
+
+	// If SyncWrites is true, every write is synced to disk before returning.  If false,
+	// a crash of the machine (but not just the process) can lose recent writes.
+	DefaultSyncWrites = false
+)
+
+// --- The main interface object ----
+
+// BadgerDB is a pure Go, LSM-tree key-value store that keeps keys separate from values
+// in a value log.  Keys are iterated in ascending byte order like leveldb.
+type BadgerDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.Config
+
+	db *badger.DB
+}
+
+// GetOptions returns badger options from the DVID configuration.  Recognized settings
+// are "ValueLogFileSize" (in MB), "SyncWrites", and "LoadToRAM" which keeps the
+// LSM tree in memory.
+func GetOptions(path string, config dvid.Config) (badger.Options, error) {
+	opt := badger.DefaultOptions(path)
+	opt.Logger = nil
+
+	valueLogFileSize, found, err := config.GetInt("ValueLogFileSize")
+	if err != nil {
+		return opt, err
+	}
+	if !found {
+		valueLogFileSize = DefaultValueLogFileSize
+	} else {
+		valueLogFileSize *= dvid.Mega
+	}
+	dvid.Infof("badger value log file size: %s\n", humanize.Bytes(uint64(valueLogFileSize)))
+	opt.ValueLogFileSize = int64(valueLogFileSize)
+
+	syncWrites, found, err := config.GetBool("SyncWrites")
+	if err != nil {
+		return opt, err
+	}
+	if !found {
+		syncWrites = DefaultSyncWrites
+	}
+	opt.SyncWrites = syncWrites
+
+	loadToRAM, found, err := config.GetBool("LoadToRAM")
+	if err != nil {
+		return opt, err
+	}
+	if found && loadToRAM {
+		opt.TableLoadingMode = options.LoadToRAM
+	}
+	return opt, nil
+}
+
+// NewKeyValueStore returns a badger backend.  Badger creates the store at the path if it
+// doesn't already exist, so create is only used for logging.
+func NewKeyValueStore(path string, create bool, config dvid.Config) (storage.Engine, error) {
+	opt, err := GetOptions(path, config)
+	if err != nil {
+		return nil, err
+	}
+	if create {
+		dvid.Infof("Creating badger datastore at %s\n", path)
+	}
+	db, err := badger.Open(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerDB{
+		directory: path,
+		config:    config,
+		db:        db,
+	}, nil
+}
+
+// RepairStore tries to repair a damaged badger store.  Badger truncates corrupted
+// value log entries when opened, so we just open and close the store.
+func RepairStore(path string, config dvid.Config) error {
+	opt, err := GetOptions(path, config)
+	if err != nil {
+		return err
+	}
+	opt.Truncate = true
+	db, err := badger.Open(opt)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// ---- Engine interface ----
+
+func (db *BadgerDB) String() string {
+	return "badger key-value store"
+}
+
+func (db *BadgerDB) GetConfig() dvid.Config {
+	return db.config
+}
+
+// Sync syncs prior writes to disk.
+func (db *BadgerDB) Sync() error {
+	return db.db.Sync()
+}
+
+// Close closes the badger store.
+func (db *BadgerDB) Close() {
+	if db != nil && db.db != nil {
+		if err := db.db.Close(); err != nil {
+			dvid.Errorf("Error closing badger store at %s: %s\n", db.directory, err.Error())
+		}
+	}
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *BadgerDB) Get(ctx storage.Context, k []byte) ([]byte, error) {
+	if ctx != nil && ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedContext)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill storage.VersionedContext")
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, k)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	}
+	key := constructKey(ctx, k)
+	var v []byte
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	storage.StoreValueBytesRead <- len(v)
+	return v, err
+}
+
+// getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
+// in ascending key order.
+func (db *BadgerDB) getSingleKeyVersions(vctx storage.VersionedContext, k []byte) ([]*storage.KeyValue, error) {
+	kStart, err := vctx.MinVersionKey(k)
+	if err != nil {
+		return nil, err
+	}
+	kEnd, err := vctx.MaxVersionKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []*storage.KeyValue{}
+	err = db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(kStart); it.Valid(); it.Next() {
+			item := it.Item()
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			if bytes.Compare(itKey, kEnd) > 0 {
+				return nil
+			}
+			itValue, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			storage.StoreValueBytesRead <- len(itValue)
+			values = append(values, &storage.KeyValue{itKey, itValue})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func constructKey(ctx storage.Context, index []byte) []byte {
+	if ctx != nil {
+		return ctx.ConstructKey(index)
+	}
+	return index
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedContext, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// iterOptions returns iterator options that only prefetch values if they are needed.
+func iterOptions(keysOnly bool) badger.IteratorOptions {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = !keysOnly
+	return opts
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+// Like leveldb, all versions of a given index are adjacent in badger's key ordering so we
+// collect them and send the one appropriate for the context's version.
+func (db *BadgerDB) versionedRange(vctx storage.VersionedContext, kStart, kEnd []byte, ch chan errorableKV, keysOnly bool) {
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(kStart)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	values := []*storage.KeyValue{}
+	err = db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(iterOptions(keysOnly))
+		defer it.Close()
+		var itValue []byte
+		for it.Seek(minKey); it.Valid(); it.Next() {
+			if storage.Canceled(vctx) {
+				return storage.ErrCanceled
+			}
+			item := it.Item()
+			if !keysOnly {
+				if itValue, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				storage.StoreValueBytesRead <- len(itValue)
+			}
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+
+			// Did we pass all versions for last key read?
+			if bytes.Compare(itKey, maxVersionKey) > 0 {
+				indexBytes, err := vctx.IndexFromKey(itKey)
+				if err != nil {
+					return err
+				}
+				maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+				if err != nil {
+					return err
+				}
+				sendKV(vctx, values, ch)
+				values = []*storage.KeyValue{}
+			}
+			// Did we pass the final key?
+			if bytes.Compare(itKey, maxKey) > 0 {
+				return nil
+			}
+			values = append(values, &storage.KeyValue{itKey, itValue})
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	sendKV(vctx, values, ch)
+	ch <- errorableKV{nil, nil}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *BadgerDB) unversionedRange(ctx storage.Context, kStart, kEnd []byte, ch chan errorableKV, keysOnly bool) {
+	// Apply context if applicable
+	keyBeg := constructKey(ctx, kStart)
+	keyEnd := constructKey(ctx, kEnd)
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(iterOptions(keysOnly))
+		defer it.Close()
+		var itValue []byte
+		var err error
+		for it.Seek(keyBeg); it.Valid(); it.Next() {
+			if storage.Canceled(ctx) {
+				return storage.ErrCanceled
+			}
+			item := it.Item()
+			if !keysOnly {
+				if itValue, err = item.ValueCopy(nil); err != nil {
+					return err
+				}
+				storage.StoreValueBytesRead <- len(itValue)
+			}
+			itKey := item.KeyCopy(nil)
+			storage.StoreKeyBytesRead <- len(itKey)
+			// Did we pass the final key?
+			if bytes.Compare(itKey, keyEnd) > 0 {
+				return nil
+			}
+			ch <- errorableKV{&storage.KeyValue{itKey, itValue}, nil}
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// startRange runs a potentially versioned range query in a goroutine.
+func (db *BadgerDB) startRange(ctx storage.Context, kStart, kEnd []byte, keysOnly bool) chan errorableKV {
+	ch := make(chan errorableKV)
+	go func() {
+		if ctx != nil && ctx.Versioned() {
+			db.versionedRange(ctx.(storage.VersionedContext), kStart, kEnd, ch, keysOnly)
+		} else {
+			db.unversionedRange(ctx, kStart, kEnd, ch, keysOnly)
+		}
+	}()
+	return ch
+}
+
+// drainRange consumes the rest of a range query in the background so its goroutine,
+// and the read transaction it holds, finish after the consumer stops early.
+func drainRange(ch chan errorableKV) {
+	go func() {
+		for {
+			if result := <-ch; result.KeyValue == nil {
+				return
+			}
+		}
+	}()
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *BadgerDB) KeysInRange(ctx storage.Context, kStart, kEnd []byte) ([][]byte, error) {
+	ch := db.startRange(ctx, kStart, kEnd, true)
+
+	// Consume the keys.
+	values := [][]byte{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return values, result.error
+		}
+		values = append(values, result.KeyValue.K)
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *BadgerDB) GetRange(ctx storage.Context, kStart, kEnd []byte) ([]*storage.KeyValue, error) {
+	ch := db.startRange(ctx, kStart, kEnd, false)
+
+	// Consume the key-value pairs.
+	values := []*storage.KeyValue{}
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return values, result.error
+		}
+		values = append(values, result.KeyValue)
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.
+func (db *BadgerDB) ProcessRange(ctx storage.Context, kStart, kEnd []byte, op *storage.ChunkOp, f func(*storage.Chunk)) error {
+	ch := db.startRange(ctx, kStart, kEnd, false)
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			return result.error
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		chunk := &storage.Chunk{op, result.KeyValue}
+		f(chunk)
+	}
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *BadgerDB) Put(ctx storage.Context, k, v []byte) error {
+	key := constructKey(ctx, k)
+	err := db.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, v)
+	})
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	return err
+}
+
+// Delete removes a value with given key.
+func (db *BadgerDB) Delete(ctx storage.Context, k []byte) error {
+	key := constructKey(ctx, k)
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts key-value pairs that have been sorted in sequential key order.
+func (db *BadgerDB) PutRange(ctx storage.Context, values []storage.KeyValue) error {
+	batch := db.NewBatch(ctx)
+	for _, kv := range values {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *BadgerDB) DeleteRange(ctx storage.Context, kStart, kEnd []byte) error {
+	ch := db.startRange(ctx, kStart, kEnd, true)
+
+	// Consume the keys, deleting them in batches.
+	wb := db.db.NewWriteBatch()
+	numKV := 0
+	for {
+		result := <-ch
+		if result.KeyValue == nil {
+			if result.error != nil {
+				wb.Cancel()
+				return result.error
+			}
+			break
+		}
+
+		// The key coming down channel is not index but full key, so no need to construct key using context.
+		if err := wb.Delete(result.KeyValue.K); err != nil {
+			wb.Cancel()
+			drainRange(ch)
+			return fmt.Errorf("Error on batch DELETE at key-value pair %d: %s\n", numKV, err.Error())
+		}
+		numKV++
+		if numKV%DefaultDeleteBatchSize == 0 {
+			if err := wb.Flush(); err != nil {
+				drainRange(ch)
+				return fmt.Errorf("Error on batch DELETE at key-value pair %d: %s\n", numKV, err.Error())
+			}
+			wb = db.db.NewWriteBatch()
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("Error on last batch DELETE: %s\n", err.Error())
+	}
+	return nil
+}
+
+// --- Batcher interface ----
+
+type goBatch struct {
+	ctx storage.Context
+	wb  *badger.WriteBatch
+	err error
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *BadgerDB) NewBatch(ctx storage.Context) storage.Batch {
+	return &goBatch{ctx: ctx, wb: db.db.NewWriteBatch()}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(k []byte) {
+	if batch.err != nil {
+		return
+	}
+	key := constructKey(batch.ctx, k)
+	batch.err = batch.wb.Delete(key)
+}
+
+func (batch *goBatch) Put(k, v []byte) {
+	if batch.err != nil {
+		return
+	}
+	key := constructKey(batch.ctx, k)
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.err = batch.wb.Set(key, v)
+}
+
+// Commit writes the batch.  Since the Batch interface doesn't return errors on Put or
+// Delete, the first such error is returned here and the batch is discarded.
+func (batch *goBatch) Commit() error {
+	if batch.err != nil {
+		batch.wb.Cancel()
+		return batch.err
+	}
+	return batch.wb.Flush()
+}
+
+// --- Transactioner interface ---
+
+type goTransaction struct {
+	txn  *badger.Txn
+	err  error
+	done bool
+}
+
+// NewTransaction returns a transaction whose writes, which may use different contexts,
+// are applied atomically in one badger transaction.  Transactions too large for badger
+// fail on commit without applying any writes.
+func (db *BadgerDB) NewTransaction() storage.Transaction {
+	return &goTransaction{txn: db.db.NewTransaction(true)}
+}
+
+// --- Transaction interface ---
+
+func (txn *goTransaction) Delete(ctx storage.Context, k []byte) {
+	if txn.done || txn.err != nil {
+		return
+	}
+	txn.err = txn.txn.Delete(constructKey(ctx, k))
+}
+
+func (txn *goTransaction) Put(ctx storage.Context, k, v []byte) {
+	if txn.done || txn.err != nil {
+		return
+	}
+	key := constructKey(ctx, k)
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	txn.err = txn.txn.Set(key, v)
+}
+
+// Commit applies the transaction.  Since the Transaction interface doesn't return errors
+// on Put or Delete, the first such error is returned here and the transaction discarded.
+func (txn *goTransaction) Commit() error {
+	if txn.done {
+		return storage.ErrTransactionDone
+	}
+	txn.done = true
+	if txn.err != nil {
+		txn.txn.Discard()
+		return fmt.Errorf("Unable to build badger transaction: %s", txn.err.Error())
+	}
+	return txn.txn.Commit()
+}
+
+func (txn *goTransaction) Rollback() {
+	if txn.done {
+		return
+	}
+	txn.done = true
+	txn.txn.Discard()
+}
@@ -0,0 +1,186 @@
+// +build badger
+
+package local
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Satisfies dvid.Data interface
+type testData struct {
+	name       dvid.DataString
+	instanceID dvid.InstanceID
+}
+
+func (d *testData) DataName() dvid.DataString        { return d.name }
+func (d *testData) InstanceID() dvid.InstanceID      { return d.instanceID }
+func (d *testData) SetInstanceID(id dvid.InstanceID) { d.instanceID = id }
+func (d *testData) TypeName() dvid.TypeString        { return "testType" }
+func (d *testData) TypeURL() dvid.URLString          { return "foo.baz.com/go/testData" }
+func (d *testData) TypeVersion() string              { return "1.0" }
+func (d *testData) Versioned() bool                  { return false }
+
+// voxelBlockIndex mimics the voxels keyspace: a key type byte followed by a ZYX block index.
+func voxelBlockIndex(x, y, z int32) []byte {
+	index := dvid.IndexZYX{x, y, z}
+	return append([]byte{0}, index.Bytes()...)
+}
+
+func openTestStore(t *testing.T) (*BadgerDB, func()) {
+	dir, err := ioutil.TempDir("", "dvid-badger-test")
+	if err != nil {
+		t.Fatalf("Can't create temp directory: %s\n", err.Error())
+	}
+	engine, err := CreateBlankStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Can't create badger store: %s\n", err.Error())
+	}
+	db := engine.(*BadgerDB)
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBadgerVoxelBlockOrdering(t *testing.T) {
+	db, closer := openTestStore(t)
+	defer closer()
+
+	ctx := storage.NewDataContext(&testData{"grayscale", 13}, 1)
+	otherCtx := storage.NewDataContext(&testData{"labels", 14}, 1)
+
+	// Write blocks out of order, including negative coordinates.
+	coords := [][3]int32{{2, 0, 1}, {-1, 3, 0}, {0, 0, 0}, {5, -2, 0}, {1, 1, 1}, {0, 0, -1}}
+	for i, c := range coords {
+		if err := db.Put(ctx, voxelBlockIndex(c[0], c[1], c[2]), []byte{byte(i)}); err != nil {
+			t.Fatalf("Error on Put: %s\n", err.Error())
+		}
+	}
+	if err := db.Put(otherCtx, voxelBlockIndex(0, 0, 0), []byte("other")); err != nil {
+		t.Fatalf("Error on Put: %s\n", err.Error())
+	}
+
+	// Expected ZYX order
+	expected := [][3]int32{{0, 0, -1}, {-1, 3, 0}, {5, -2, 0}, {0, 0, 0}, {1, 1, 1}, {2, 0, 1}}
+	begIndex := voxelBlockIndex(dvid.MinIndexZYX.Unpack())
+	endIndex := voxelBlockIndex(dvid.MaxIndexZYX.Unpack())
+	kvs, err := db.GetRange(ctx, begIndex, endIndex)
+	if err != nil {
+		t.Fatalf("Error on GetRange: %s\n", err.Error())
+	}
+	if len(kvs) != len(expected) {
+		t.Fatalf("Expected %d blocks in range, got %d\n", len(expected), len(kvs))
+	}
+	for i, kv := range kvs {
+		index, err := ctx.IndexFromKey(kv.K)
+		if err != nil {
+			t.Fatalf("Bad key returned: %s\n", err.Error())
+		}
+		c := expected[i]
+		if !bytes.Equal(index, voxelBlockIndex(c[0], c[1], c[2])) {
+			t.Errorf("Block %d out of order: expected %v, got index %v\n", i, c, index)
+		}
+	}
+
+	// Partial range over z = 0 only.
+	keys, err := db.KeysInRange(ctx, voxelBlockIndex(dvid.MinIndexZYX[0], dvid.MinIndexZYX[1], 0),
+		voxelBlockIndex(dvid.MaxIndexZYX[0], dvid.MaxIndexZYX[1], 0))
+	if err != nil {
+		t.Fatalf("Error on KeysInRange: %s\n", err.Error())
+	}
+	if len(keys) != 3 {
+		t.Errorf("Expected 3 blocks with z = 0, got %d\n", len(keys))
+	}
+
+	// Delete a range and make sure other instance is untouched.
+	if err := db.DeleteRange(ctx, begIndex, endIndex); err != nil {
+		t.Fatalf("Error on DeleteRange: %s\n", err.Error())
+	}
+	keys, err = db.KeysInRange(ctx, begIndex, endIndex)
+	if err != nil {
+		t.Fatalf("Error on KeysInRange: %s\n", err.Error())
+	}
+	if len(keys) != 0 {
+		t.Errorf("Expected no keys after DeleteRange, got %d\n", len(keys))
+	}
+	value, err := db.Get(otherCtx, voxelBlockIndex(0, 0, 0))
+	if err != nil {
+		t.Fatalf("Error on Get: %s\n", err.Error())
+	}
+	if string(value) != "other" {
+		t.Errorf("Expected other instance's block to survive DeleteRange, got %q\n", value)
+	}
+}
+
+func TestBadgerBatch(t *testing.T) {
+	db, closer := openTestStore(t)
+	defer closer()
+
+	ctx := storage.NewDataContext(&testData{"grayscale", 3}, 2)
+	batch := db.NewBatch(ctx)
+	for x := int32(0); x < 100; x++ {
+		batch.Put(voxelBlockIndex(x, 0, 0), []byte{byte(x)})
+	}
+	batch.Delete(voxelBlockIndex(50, 0, 0))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+
+	value, err := db.Get(ctx, voxelBlockIndex(50, 0, 0))
+	if err != nil {
+		t.Fatalf("Error on Get: %s\n", err.Error())
+	}
+	if value != nil {
+		t.Errorf("Expected deleted block to be nil, got %v\n", value)
+	}
+	value, err = db.Get(ctx, voxelBlockIndex(99, 0, 0))
+	if err != nil {
+		t.Fatalf("Error on Get: %s\n", err.Error())
+	}
+	if len(value) != 1 || value[0] != 99 {
+		t.Errorf("Bad value for batch put block: %v\n", value)
+	}
+}
+
+func TestBadgerTransaction(t *testing.T) {
+	db, closer := openTestStore(t)
+	defer closer()
+
+	ctx := storage.NewDataContext(&testData{"labels", 4}, 1)
+	otherCtx := storage.NewDataContext(&testData{"bodies", 5}, 1)
+	txn, err := storage.NewTransaction(db)
+	if err != nil {
+		t.Fatalf("Can't create transaction: %s\n", err.Error())
+	}
+	txn.Put(ctx, voxelBlockIndex(1, 2, 3), []byte("block"))
+	txn.Put(otherCtx, []byte{1}, []byte("index"))
+	if value, _ := db.Get(ctx, voxelBlockIndex(1, 2, 3)); value != nil {
+		t.Fatalf("Transaction wrote before commit\n")
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Error on transaction commit: %s\n", err.Error())
+	}
+	if value, _ := db.Get(ctx, voxelBlockIndex(1, 2, 3)); string(value) != "block" {
+		t.Errorf("Bad value for transaction put: %q\n", value)
+	}
+	if value, _ := db.Get(otherCtx, []byte{1}); string(value) != "index" {
+		t.Errorf("Bad value for transaction put: %q\n", value)
+	}
+
+	txn, _ = storage.NewTransaction(db)
+	txn.Delete(otherCtx, []byte{1})
+	txn.Rollback()
+	if err := txn.Commit(); err != storage.ErrTransactionDone {
+		t.Errorf("Expected ErrTransactionDone on commit after rollback, got %v\n", err)
+	}
+	if value, _ := db.Get(otherCtx, []byte{1}); value == nil {
+		t.Errorf("Rolled back delete was applied\n")
+	}
+}
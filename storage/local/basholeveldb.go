@@ -239,6 +239,39 @@ func (db *LevelDB) GetConfig() dvid.Config {
 	return db.config
 }
 
+// Sync syncs prior writes to disk by writing an empty batch with sync set.
+func (db *LevelDB) Sync() error {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	wo := levigo.NewWriteOptions()
+	defer wo.Close()
+	wo.SetSync(true)
+	wb := levigo.NewWriteBatch()
+	defer wb.Close()
+	return db.ldb.Write(wo, wb)
+}
+
+// CompactRange compacts the keys from kStart to kEnd, where nil keys denote the
+// beginning or end of the database, so space of deleted or overwritten values is freed.
+func (db *LevelDB) CompactRange(kStart, kEnd []byte) error {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	db.ldb.CompactRange(levigo.Range{Start: kStart, Limit: kEnd})
+	return nil
+}
+
+// LevelStats returns leveldb statistics on the number, size, and compaction of the
+// sorted tables in each level.
+func (db *LevelDB) LevelStats() (string, error) {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	stats := db.ldb.PropertyValue("leveldb.stats")
+	if stats == "" {
+		return "", fmt.Errorf("Leveldb at %s did not return statistics", db.directory)
+	}
+	return stats, nil
+}
+
 // Close closes the leveldb and then the I/O abstraction for leveldb.
 func (db *LevelDB) Close() {
 	if db != nil {
@@ -404,6 +437,10 @@ func (db *LevelDB) versionedRange(vctx storage.VersionedContext, kStart, kEnd []
 	it.Seek(minKey)
 	var itValue []byte
 	for {
+		if storage.Canceled(vctx) {
+			ch <- errorableKV{nil, storage.ErrCanceled}
+			return
+		}
 		if it.Valid() {
 			if !keysOnly {
 				itValue = it.Value()
@@ -474,6 +511,10 @@ func (db *LevelDB) unversionedRange(ctx storage.Context, kStart, kEnd []byte, ch
 	var itValue []byte
 	it.Seek(keyBeg)
 	for {
+		if storage.Canceled(ctx) {
+			ch <- errorableKV{nil, storage.ErrCanceled}
+			return
+		}
 		if it.Valid() {
 			if !keysOnly {
 				itValue = it.Value()
@@ -520,7 +561,7 @@ func (db *LevelDB) KeysInRange(ctx storage.Context, kStart, kEnd []byte) ([][]by
 	for {
 		result := <-ch
 		if result.KeyValue == nil {
-			return values, nil
+			return values, result.error
 		}
 		if result.error != nil {
 			return nil, result.error
@@ -549,7 +590,7 @@ func (db *LevelDB) GetRange(ctx storage.Context, kStart, kEnd []byte) ([]*storag
 	for {
 		result := <-ch
 		if result.KeyValue == nil {
-			return values, nil
+			return values, result.error
 		}
 		if result.error != nil {
 			return nil, result.error
@@ -577,7 +618,7 @@ func (db *LevelDB) ProcessRange(ctx storage.Context, kStart, kEnd []byte, op *st
 	for {
 		result := <-ch
 		if result.KeyValue == nil {
-			return nil
+			return result.error
 		}
 		if result.error != nil {
 			return result.error
@@ -739,6 +780,68 @@ func (batch *goBatch) Commit() error {
 	return err
 }
 
+// --- Transactioner interface ---
+
+type goTransaction struct {
+	*levigo.WriteBatch
+	wo   *levigo.WriteOptions
+	ldb  *levigo.DB
+	done bool
+}
+
+// NewTransaction returns a transaction whose writes, which may use different contexts,
+// are applied atomically in one write batch.
+func (db *LevelDB) NewTransaction() storage.Transaction {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	return &goTransaction{WriteBatch: levigo.NewWriteBatch(), wo: db.options.WriteOptions, ldb: db.ldb}
+}
+
+// --- Transaction interface ---
+
+func (txn *goTransaction) Delete(ctx storage.Context, k []byte) {
+	if txn.done {
+		return
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	txn.WriteBatch.Delete(constructKey(ctx, k))
+}
+
+func (txn *goTransaction) Put(ctx storage.Context, k, v []byte) {
+	if txn.done {
+		return
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	key := constructKey(ctx, k)
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	txn.WriteBatch.Put(key, v)
+}
+
+func (txn *goTransaction) Commit() error {
+	if txn.done {
+		return storage.ErrTransactionDone
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	txn.done = true
+	err := txn.ldb.Write(txn.wo, txn.WriteBatch)
+	txn.WriteBatch.Close()
+	return err
+}
+
+func (txn *goTransaction) Rollback() {
+	if txn.done {
+		return
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	txn.done = true
+	txn.WriteBatch.Close()
+}
+
 /** Clear and Close were removed due to how other key-value stores implement batches.
     It's easier to implement cross-database handling of a simple write/delete batch
     that commits then closes rather than something that clears.
@@ -15,14 +15,46 @@ import (
 // local storage system waits until it receives a path and configuration data from a
 // "serve" command.
 func Initialize(path string, config dvid.Config) error {
-	create := false
-	kvEngine, err := NewKeyValueStore(path, create, config)
+	kvEngine, err := openStore(path, config)
 	if err != nil {
 		return err
 	}
 	return storage.Initialize(kvEngine, Version)
 }
 
+// openStore opens an existing local key-value database, handling compressed keys.
+func openStore(path string, config dvid.Config) (storage.Engine, error) {
+	create := false
+	kvEngine, err := NewKeyValueStore(path, create, config)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := storage.UsesKeyCompression(kvEngine)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		if kvEngine, err = storage.NewKeyCompressor(kvEngine); err != nil {
+			return nil, err
+		}
+	}
+	return kvEngine, nil
+}
+
+// OpenReplica opens an existing local datastore, e.g., a backup, as a replica used to
+// repair values with bad checksums.
+func OpenReplica(path string) (storage.OrderedKeyValueDB, error) {
+	kvEngine, err := openStore(path, dvid.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("Can't open replica %q: %s", path, err.Error())
+	}
+	kvDB, ok := kvEngine.(storage.OrderedKeyValueDB)
+	if !ok {
+		return nil, fmt.Errorf("Replica %q is not a valid ordered key-value database", path)
+	}
+	return kvDB, nil
+}
+
 // CreateBlankStore creates a new local key-value database at the given path,
 // deleting any data that was present in the given path.
 func CreateBlankStore(path string) (storage.Engine, error) {
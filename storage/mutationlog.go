@@ -0,0 +1,592 @@
+/*
+	This file supports a write-ahead mutation log for point-in-time recovery.  When enabled,
+	every key-value mutation of the datastore is appended to a log file before it is applied:
+	puts, deletes, and range deletes of metadata, data, and graph keys.  Each record gives
+	the data instance and version of the key, the client if known, a timestamp, and the value
+	with its xxHash digest.  Mutations that fail after being logged are marked as aborted.
+
+	Records are framed by their length and a CRC32 so a record torn by a crash is detected.
+	Since all mutations are blind writes, replaying the log in order onto a snapshot of the
+	datastore taken after logging started recovers the datastore as of any logged time.
+*/
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// MutationOp is the kind of a logged mutation.
+type MutationOp uint8
+
+const (
+	MutationPut MutationOp = iota + 1
+	MutationDelete
+	MutationDeleteRange
+
+	// MutationAbort marks the logged mutation with the given sequence number as not
+	// applied.
+	MutationAbort
+)
+
+func (op MutationOp) String() string {
+	switch op {
+	case MutationPut:
+		return "put"
+	case MutationDelete:
+		return "delete"
+	case MutationDeleteRange:
+		return "delete range"
+	case MutationAbort:
+		return "abort"
+	default:
+		return fmt.Sprintf("unknown mutation %d", op)
+	}
+}
+
+// ClientContext is implemented by contexts that know the client making a mutation.
+type ClientContext interface {
+	Client() string
+}
+
+// contextClient returns the client of a context if known.
+func contextClient(ctx Context) string {
+	switch c := ctx.(type) {
+	case *graphContext:
+		return contextClient(c.Context)
+	case *versionedGraphContext:
+		return contextClient(c.VersionedContext)
+	case ClientContext:
+		return c.Client()
+	default:
+		return ""
+	}
+}
+
+// MutationRecord is one logged mutation.  Keys are full keys, i.e., suitable for use
+// with a nil Context.
+type MutationRecord struct {
+	Seq      uint64
+	Op       MutationOp
+	Time     time.Time
+	Instance dvid.InstanceID
+	Version  dvid.VersionID
+	Client   string
+
+	Key []byte
+
+	// KeyEnd is the inclusive end of a deleted range.
+	KeyEnd []byte
+
+	// Value is the value of a put, and Digest is its xxHash.
+	Value  []byte
+	Digest uint64
+
+	// Aborted is the sequence number of the mutation marked by an abort record.
+	Aborted uint64
+}
+
+func newMutationRecord(op MutationOp, client string, key, keyEnd, value []byte) *MutationRecord {
+	r := &MutationRecord{
+		Op:     op,
+		Time:   time.Now(),
+		Client: client,
+		Key:    key,
+		KeyEnd: keyEnd,
+		Value:  value,
+	}
+	if len(key) != 0 && (key[0] == dataKeyPrefix || key[0] == graphKeyPrefix) &&
+		len(key) >= 1+dvid.InstanceIDSize+dvid.VersionIDSize {
+		r.Instance, r.Version, _ = KeyToLocalIDs(key)
+	}
+	if op == MutationPut {
+		r.Digest = dvid.XXHash64(value)
+	}
+	return r
+}
+
+func putBytes(buf *bytes.Buffer, b []byte) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(b)))
+	buf.Write(n[:])
+	buf.Write(b)
+}
+
+func getBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if int(n) > r.Len() {
+		return nil, fmt.Errorf("field of %d bytes exceeds record", n)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// mutationHeader is the fixed-size portion of an encoded record.
+type mutationHeader struct {
+	Seq      uint64
+	Op       MutationOp
+	Time     int64
+	Instance uint32
+	Version  uint32
+	Digest   uint64
+	Aborted  uint64
+}
+
+// encode returns the framed record: length, payload, and CRC32 of the payload.
+func (r *MutationRecord) encode() []byte {
+	var payload bytes.Buffer
+	header := mutationHeader{r.Seq, r.Op, r.Time.UnixNano(), uint32(r.Instance), uint32(r.Version), r.Digest, r.Aborted}
+	binary.Write(&payload, binary.LittleEndian, header)
+	putBytes(&payload, []byte(r.Client))
+	putBytes(&payload, r.Key)
+	putBytes(&payload, r.KeyEnd)
+	putBytes(&payload, r.Value)
+
+	framed := make([]byte, 4, 8+payload.Len())
+	binary.LittleEndian.PutUint32(framed, uint32(payload.Len()))
+	framed = append(framed, payload.Bytes()...)
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(framed, crc[:]...)
+}
+
+// ErrTornRecord is returned when the log ends in an incomplete or corrupted record, e.g.,
+// because of a crash while appending.
+var ErrTornRecord = fmt.Errorf("Mutation log ends in a torn record")
+
+// readMutationRecord reads the next record, returning io.EOF at the end of the log.
+func readMutationRecord(r io.Reader) (*MutationRecord, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, ErrTornRecord
+	}
+	framed := make([]byte, n+4)
+	if _, err := io.ReadFull(r, framed); err != nil {
+		return nil, ErrTornRecord
+	}
+	payload := framed[:n]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(framed[n:]) {
+		return nil, ErrTornRecord
+	}
+
+	buf := bytes.NewReader(payload)
+	var header mutationHeader
+	if err := binary.Read(buf, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("Bad mutation record: %s", err.Error())
+	}
+	rec := &MutationRecord{
+		Seq:      header.Seq,
+		Op:       header.Op,
+		Time:     time.Unix(0, header.Time),
+		Instance: dvid.InstanceID(header.Instance),
+		Version:  dvid.VersionID(header.Version),
+		Digest:   header.Digest,
+		Aborted:  header.Aborted,
+	}
+	fields := make([][]byte, 4)
+	for i := range fields {
+		var err error
+		if fields[i], err = getBytes(buf); err != nil {
+			return nil, fmt.Errorf("Bad mutation record %d: %s", rec.Seq, err.Error())
+		}
+	}
+	rec.Client = string(fields[0])
+	rec.Key, rec.KeyEnd, rec.Value = fields[1], fields[2], fields[3]
+	return rec, nil
+}
+
+// mutationLog is an append-only log file.
+type mutationLog struct {
+	sync.Mutex
+	path string
+	file *os.File
+	w    *bufio.Writer
+	seq  uint64
+
+	// If true, the file is synced to disk after each append.
+	sync bool
+
+	// Sequence numbers of logged mutations not yet applied or aborted, and of aborted
+	// mutations, so only settled mutations are streamed to replicas.
+	pending map[uint64]bool
+	aborted map[uint64]bool
+
+	// changed is closed and replaced whenever records are appended or settled.
+	changed chan struct{}
+}
+
+// openMutationLog opens a log for appending, continuing the sequence numbers of any
+// records already in the file.  A torn record at the end of the file is truncated.
+func openMutationLog(path string, sync bool) (*mutationLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var seq uint64
+	var offset int64
+	aborted := make(map[uint64]bool)
+	r := bufio.NewReader(file)
+	for {
+		rec, err := readMutationRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err == ErrTornRecord {
+			dvid.Errorf("Truncating torn record at offset %d of mutation log %s\n", offset, path)
+			if err := file.Truncate(offset); err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if rec.Op == MutationAbort {
+			aborted[rec.Aborted] = true
+		}
+		seq = rec.Seq
+		offset += int64(len(rec.encode()))
+	}
+	if _, err := file.Seek(offset, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &mutationLog{
+		path:    path,
+		file:    file,
+		w:       bufio.NewWriter(file),
+		seq:     seq,
+		sync:    sync,
+		pending: make(map[uint64]bool),
+		aborted: aborted,
+		changed: make(chan struct{}),
+	}
+	return l, nil
+}
+
+// notify wakes any waiters on changes to the log.  The log must be locked.
+func (l *mutationLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// settle marks logged mutations as applied or aborted.
+func (l *mutationLog) settle(records []*MutationRecord) {
+	l.Lock()
+	defer l.Unlock()
+	for _, rec := range records {
+		delete(l.pending, rec.Seq)
+	}
+	l.notify()
+}
+
+// settled returns the sequence number through which all logged mutations are settled
+// and a channel closed on the next change to the log.
+func (l *mutationLog) settled() (uint64, <-chan struct{}) {
+	l.Lock()
+	defer l.Unlock()
+	settled := l.seq
+	for seq := range l.pending {
+		if seq <= settled {
+			settled = seq - 1
+		}
+	}
+	return settled, l.changed
+}
+
+// isAborted returns true if the logged mutation was aborted.
+func (l *mutationLog) isAborted(seq uint64) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.aborted[seq]
+}
+
+// append assigns sequence numbers to records and writes them to the log.
+func (l *mutationLog) append(records ...*MutationRecord) error {
+	l.Lock()
+	defer l.Unlock()
+	defer l.notify()
+	for _, rec := range records {
+		l.seq++
+		rec.Seq = l.seq
+		if rec.Op == MutationAbort {
+			l.aborted[rec.Aborted] = true
+		} else {
+			l.pending[rec.Seq] = true
+		}
+		if _, err := l.w.Write(rec.encode()); err != nil {
+			return fmt.Errorf("Unable to write mutation log: %s", err.Error())
+		}
+	}
+	if err := l.w.Flush(); err != nil {
+		return fmt.Errorf("Unable to write mutation log: %s", err.Error())
+	}
+	if l.sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// abort marks logged mutations as not applied.
+func (l *mutationLog) abort(records []*MutationRecord, cause error) {
+	aborts := make([]*MutationRecord, len(records))
+	for i, rec := range records {
+		aborts[i] = &MutationRecord{Op: MutationAbort, Time: time.Now(), Aborted: rec.Seq}
+	}
+	if err := l.append(aborts...); err != nil {
+		dvid.Criticalf("Unable to log abort of mutations after error (%s): %s\n", cause.Error(), err.Error())
+	}
+}
+
+func (l *mutationLog) close() error {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+// mutationLogger wraps an ordered key-value store and logs all mutations before they
+// are applied.
+type mutationLogger struct {
+	OrderedKeyValueDB
+
+	log *mutationLog
+}
+
+func (m *mutationLogger) String() string {
+	return fmt.Sprintf("%s with mutation log %s", m.OrderedKeyValueDB, m.log.path)
+}
+
+// logged appends the records, calls the mutation, and marks the records aborted if it
+// fails.
+func (m *mutationLogger) logged(records []*MutationRecord, mutate func() error) error {
+	if len(records) == 0 {
+		return mutate()
+	}
+	if err := m.log.append(records...); err != nil {
+		m.log.settle(records)
+		return err
+	}
+	defer m.log.settle(records)
+	if err := mutate(); err != nil {
+		m.log.abort(records, err)
+		return err
+	}
+	return nil
+}
+
+// logKey returns the full key for a key given in a context.
+func logKey(ctx Context, k []byte) []byte {
+	if ctx == nil {
+		return k
+	}
+	return ctx.ConstructKey(k)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (m *mutationLogger) Put(ctx Context, k, v []byte) error {
+	rec := newMutationRecord(MutationPut, contextClient(ctx), logKey(ctx, k), nil, v)
+	return m.logged([]*MutationRecord{rec}, func() error {
+		return m.OrderedKeyValueDB.Put(ctx, k, v)
+	})
+}
+
+func (m *mutationLogger) Delete(ctx Context, k []byte) error {
+	rec := newMutationRecord(MutationDelete, contextClient(ctx), logKey(ctx, k), nil, nil)
+	return m.logged([]*MutationRecord{rec}, func() error {
+		return m.OrderedKeyValueDB.Delete(ctx, k)
+	})
+}
+
+func (m *mutationLogger) PutRange(ctx Context, values []KeyValue) error {
+	client := contextClient(ctx)
+	records := make([]*MutationRecord, len(values))
+	for i, kv := range values {
+		records[i] = newMutationRecord(MutationPut, client, logKey(ctx, kv.K), nil, kv.V)
+	}
+	return m.logged(records, func() error {
+		return m.OrderedKeyValueDB.PutRange(ctx, values)
+	})
+}
+
+// DeleteRange logs the range of full keys for unversioned deletes.  Versioned deletes
+// only remove keys visible in the version, so the deleted keys are logged instead.
+func (m *mutationLogger) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	client := contextClient(ctx)
+	var records []*MutationRecord
+	if ctx == nil || !ctx.Versioned() {
+		rec := newMutationRecord(MutationDeleteRange, client, logKey(ctx, kStart), logKey(ctx, kEnd), nil)
+		records = []*MutationRecord{rec}
+	} else {
+		keys, err := m.OrderedKeyValueDB.KeysInRange(ctx, kStart, kEnd)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			records = append(records, newMutationRecord(MutationDelete, client, key, nil, nil))
+		}
+	}
+	return m.logged(records, func() error {
+		return m.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd)
+	})
+}
+
+// ---- KeyValueBatcher interface ------
+
+type mutationBatch struct {
+	Batch
+	m       *mutationLogger
+	ctx     Context
+	client  string
+	records []*MutationRecord
+}
+
+func (m *mutationLogger) NewBatch(ctx Context) Batch {
+	return m.wrapBatch(ctx, m.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (m *mutationLogger) wrappedStore() OrderedKeyValueSetter { return m.OrderedKeyValueDB }
+
+func (m *mutationLogger) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &mutationBatch{Batch: newBatch(ctx), m: m, ctx: ctx, client: contextClient(ctx)}
+}
+
+func (b *mutationBatch) Put(k, v []byte) {
+	b.records = append(b.records, newMutationRecord(MutationPut, b.client, logKey(b.ctx, k), nil, v))
+	b.Batch.Put(k, v)
+}
+
+func (b *mutationBatch) Delete(k []byte) {
+	b.records = append(b.records, newMutationRecord(MutationDelete, b.client, logKey(b.ctx, k), nil, nil))
+	b.Batch.Delete(k)
+}
+
+// Commit logs all mutations of the batch before committing it.
+func (b *mutationBatch) Commit() error {
+	return b.m.logged(b.records, b.Batch.Commit)
+}
+
+// ---- Replay ------
+
+// ReplayStats gives the results of replaying a mutation log.
+type ReplayStats struct {
+	Applied uint64
+	Skipped uint64
+	Aborted uint64
+	Last    time.Time
+	Torn    bool
+}
+
+func (s *ReplayStats) String() string {
+	text := fmt.Sprintf("applied %d mutations, skipped %d after the recovery time, ignored %d aborted",
+		s.Applied, s.Skipped, s.Aborted)
+	if !s.Last.IsZero() {
+		text += fmt.Sprintf(", last applied at %s", s.Last.Format(time.RFC3339Nano))
+	}
+	if s.Torn {
+		text += " (log ends in a torn record)"
+	}
+	return text
+}
+
+// forEachMutation calls f for each record of a log file in order.
+func forEachMutation(path string, f func(*MutationRecord) error) (torn bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	for {
+		rec, err := readMutationRecord(r)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err == ErrTornRecord {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := f(rec); err != nil {
+			return false, err
+		}
+	}
+}
+
+// replayMutations applies the logged mutations up to and including the given time, if
+// not zero, to a database.  Aborted mutations are ignored, and values whose digests
+// don't match are errors.
+func replayMutations(db OrderedKeyValueDB, path string, until time.Time) (*ReplayStats, error) {
+	aborted := make(map[uint64]bool)
+	_, err := forEachMutation(path, func(rec *MutationRecord) error {
+		if rec.Op == MutationAbort {
+			aborted[rec.Aborted] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := new(ReplayStats)
+	stats.Torn, err = forEachMutation(path, func(rec *MutationRecord) error {
+		switch {
+		case rec.Op == MutationAbort:
+			return nil
+		case aborted[rec.Seq]:
+			stats.Aborted++
+			return nil
+		case !until.IsZero() && rec.Time.After(until):
+			stats.Skipped++
+			return nil
+		}
+		if err := applyMutation(db, rec); err != nil {
+			return fmt.Errorf("Unable to replay mutation %d: %s", rec.Seq, err.Error())
+		}
+		stats.Applied++
+		stats.Last = rec.Time
+		return nil
+	})
+	return stats, err
+}
+
+// applyMutation applies a logged mutation to a database.  Values whose digests don't
+// match are errors.
+func applyMutation(db OrderedKeyValueDB, rec *MutationRecord) error {
+	switch rec.Op {
+	case MutationPut:
+		if dvid.XXHash64(rec.Value) != rec.Digest {
+			return fmt.Errorf("Value of mutation %d has bad digest", rec.Seq)
+		}
+		return db.Put(nil, rec.Key, rec.Value)
+	case MutationDelete:
+		return db.Delete(nil, rec.Key)
+	case MutationDeleteRange:
+		return db.DeleteRange(nil, rec.Key, rec.KeyEnd)
+	default:
+		return fmt.Errorf("Unknown mutation %d in record %d", rec.Op, rec.Seq)
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMutationLogReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-mutationlog")
+	if err != nil {
+		t.Fatalf("Can't create temp directory: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mutations.log")
+
+	mlog, err := openMutationLog(path, false)
+	if err != nil {
+		t.Fatalf("Can't open mutation log: %s\n", err.Error())
+	}
+	db := newMemoryDB()
+	logger := &mutationLogger{db, mlog}
+	ctx := GetTestDataContext(TestUUID1, "keyvalue", 23)
+	ctx.SetClient("tester")
+
+	logger.Put(ctx, []byte("a"), []byte("apple"))
+	logger.Put(ctx, []byte("b"), []byte("banana"))
+	batch := logger.NewBatch(ctx)
+	batch.Put([]byte("c"), []byte("cherry"))
+	batch.Delete([]byte("a"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error committing batch: %s\n", err.Error())
+	}
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	logger.Delete(ctx, []byte("b"))
+	if err := mlog.close(); err != nil {
+		t.Fatalf("Error closing mutation log: %s\n", err.Error())
+	}
+
+	var records []*MutationRecord
+	if _, err := forEachMutation(path, func(rec *MutationRecord) error {
+		records = append(records, rec)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error reading mutation log: %s\n", err.Error())
+	}
+	if len(records) != 5 {
+		t.Fatalf("Expected 5 logged mutations, got %d\n", len(records))
+	}
+	if rec := records[0]; rec.Seq != 1 || rec.Op != MutationPut || rec.Instance != 23 || rec.Client != "tester" {
+		t.Errorf("Bad logged mutation: %v\n", rec)
+	}
+
+	// Replaying onto an empty store recovers all mutations or those before a time.
+	restored := newMemoryDB()
+	stats, err := replayMutations(restored, path, time.Time{})
+	if err != nil {
+		t.Fatalf("Error replaying mutation log: %s\n", err.Error())
+	}
+	if stats.Applied != 5 {
+		t.Errorf("Bad replay stats: %s\n", stats)
+	}
+	for _, k := range []string{"a", "b"} {
+		if v, _ := restored.Get(ctx, []byte(k)); v != nil {
+			t.Errorf("Deleted key %q restored with value %q\n", k, v)
+		}
+	}
+	if v, _ := restored.Get(ctx, []byte("c")); string(v) != "cherry" {
+		t.Errorf("Bad restored value: %q\n", v)
+	}
+	restored = newMemoryDB()
+	if stats, err = replayMutations(restored, path, cutoff); err != nil {
+		t.Fatalf("Error replaying mutation log: %s\n", err.Error())
+	}
+	if stats.Applied != 4 || stats.Skipped != 1 {
+		t.Errorf("Bad replay stats: %s\n", stats)
+	}
+	if v, _ := restored.Get(ctx, []byte("b")); string(v) != "banana" {
+		t.Errorf("Bad value restored to point in time: %q\n", v)
+	}
+
+	// Aborted mutations aren't replayed.
+	if mlog, err = openMutationLog(path, false); err != nil {
+		t.Fatalf("Can't reopen mutation log: %s\n", err.Error())
+	}
+	rec := newMutationRecord(MutationPut, "", ctx.ConstructKey([]byte("d")), nil, []byte("date"))
+	if err := mlog.append(rec); err != nil {
+		t.Fatalf("Error appending mutation: %s\n", err.Error())
+	}
+	if rec.Seq != 6 {
+		t.Errorf("Expected reopened log to continue at sequence 6, got %d\n", rec.Seq)
+	}
+	mlog.abort([]*MutationRecord{rec}, os.ErrInvalid)
+	mlog.close()
+	restored = newMemoryDB()
+	if stats, err = replayMutations(restored, path, time.Time{}); err != nil {
+		t.Fatalf("Error replaying mutation log: %s\n", err.Error())
+	}
+	if stats.Applied != 5 || stats.Aborted != 1 {
+		t.Errorf("Bad replay stats: %s\n", stats)
+	}
+	if v, _ := restored.Get(ctx, []byte("d")); v != nil {
+		t.Errorf("Aborted mutation replayed: %q\n", v)
+	}
+
+	// A torn record at the end of the log is ignored on replay and truncated on open.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Can't read mutation log: %s\n", err.Error())
+	}
+	torn := append(data, newMutationRecord(MutationDelete, "", []byte("e"), nil, nil).encode()[:5]...)
+	if err := ioutil.WriteFile(path, torn, 0644); err != nil {
+		t.Fatalf("Can't write mutation log: %s\n", err.Error())
+	}
+	if stats, err = replayMutations(newMemoryDB(), path, time.Time{}); err != nil || !stats.Torn {
+		t.Errorf("Expected torn log to replay: %v, %v\n", stats, err)
+	}
+	if mlog, err = openMutationLog(path, false); err != nil {
+		t.Fatalf("Can't reopen torn mutation log: %s\n", err.Error())
+	}
+	mlog.close()
+	if truncated, _ := ioutil.ReadFile(path); !bytes.Equal(truncated, data) {
+		t.Errorf("Torn record not truncated: %d bytes, expected %d\n", len(truncated), len(data))
+	}
+}
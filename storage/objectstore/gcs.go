@@ -0,0 +1,78 @@
+// +build gcs
+
+package objectstore
+
+import (
+	"context"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	registerBucket("gcs", openGCS)
+}
+
+// gcsBucket is a bucket in Google Cloud Storage.  Credentials are found through the
+// application default credentials.
+type gcsBucket struct {
+	handle *storage.BucketHandle
+}
+
+func openGCS(config Config) (Bucket, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &gcsBucket{handle: client.Bucket(config.Bucket)}, nil
+}
+
+func (b *gcsBucket) Get(name string) ([]byte, error) {
+	r, err := b.handle.Object(name).NewReader(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (b *gcsBucket) Put(name string, data []byte) error {
+	w := b.handle.Object(name).NewWriter(context.Background())
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (b *gcsBucket) Delete(name string) error {
+	err := b.handle.Object(name).Delete(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+func (b *gcsBucket) List(prefix, after string, f func(name string) bool) error {
+	// The start offset is inclusive, so skip any object with exactly that name.
+	it := b.handle.Objects(context.Background(), &storage.Query{Prefix: prefix, StartOffset: after})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if attrs.Name == after {
+			continue
+		}
+		if !f(attrs.Name) {
+			return nil
+		}
+	}
+}
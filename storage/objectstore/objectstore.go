@@ -0,0 +1,683 @@
+/*
+	Package objectstore implements an ordered key-value store on top of a cloud object
+	store like Amazon S3 or Google Cloud Storage, so the big data tier of a DVID server
+	can grow beyond its local disks.
+
+	Each key-value pair is stored as one object named by the lowercase hex encoding of
+	the key followed by a period, under an optional prefix.  Hex encoding preserves the
+	byte ordering of keys in the lexicographic ordering of object names, and the period,
+	which sorts before all hex digits, orders a key before any longer key it prefixes.
+	Range scans are therefore prefix listings that start after the first key of the range.
+
+	Writes can be buffered in memory and flushed to the object store when the buffer
+	fills, periodically, and on close.  Buffered writes are visible to all reads.  Object
+	requests for flushes and range reads are issued by a configurable number of workers.
+
+	Object store clients are only compiled in with the "s3" or "gcs" build tags.
+*/
+package objectstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	// DefaultParallelism is the default number of concurrent object store requests.
+	DefaultParallelism = 16
+
+	// DefaultFlushInterval is the default maximum time writes are buffered.
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// Bucket is the minimal interface to an object store needed by Store.
+type Bucket interface {
+	// Get returns the contents of the named object or nil if it doesn't exist.
+	Get(name string) ([]byte, error)
+
+	// Put writes the named object.
+	Put(name string, data []byte) error
+
+	// Delete removes the named object.  It is not an error if the object doesn't exist.
+	Delete(name string) error
+
+	// List calls f in ascending order with the names of objects that begin with the
+	// prefix and sort after the given name, until f returns false.
+	List(prefix, after string, f func(name string) bool) error
+}
+
+// Config describes an object store and how it's accessed.
+type Config struct {
+	// Kind of object store: "s3" or "gcs".
+	Kind string
+
+	// Bucket holding the objects.
+	Bucket string
+
+	// Prefix of all object names, allowing a bucket to be shared.
+	Prefix string
+
+	// Region and Endpoint of S3 or S3-compatible stores.  The endpoint can be empty
+	// for Amazon S3.
+	Region   string
+	Endpoint string
+
+	// BufferBytes is the number of bytes of writes buffered in memory before they are
+	// flushed.  If zero, writes go directly to the object store.
+	BufferBytes int
+
+	// FlushInterval is the maximum time a write is buffered.
+	FlushInterval time.Duration
+
+	// Parallelism is the number of concurrent object store requests.
+	Parallelism int
+}
+
+var openers = map[string]func(Config) (Bucket, error){}
+
+// registerBucket makes a kind of object store available.  It's called from the init()
+// of each compiled-in object store client.
+func registerBucket(kind string, open func(Config) (Bucket, error)) {
+	openers[kind] = open
+}
+
+// Kinds returns the kinds of object stores compiled into this server.
+func Kinds() []string {
+	var kinds []string
+	for kind := range openers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Open returns a Store for the configured object store.
+func Open(config Config) (*Store, error) {
+	open, found := openers[config.Kind]
+	if !found {
+		return nil, fmt.Errorf("Object store %q not supported by this server, which supports %v", config.Kind, Kinds())
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("No bucket given for %s object store", config.Kind)
+	}
+	bucket, err := open(config)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open %s bucket %q: %s", config.Kind, config.Bucket, err.Error())
+	}
+	return NewStore(bucket, config), nil
+}
+
+// write is a buffered put or delete.
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// bufferedKV is a buffered write of a key.
+type bufferedKV struct {
+	key string
+	*write
+}
+
+// Store is an ordered key-value store that keeps each key-value pair in an object.
+type Store struct {
+	bucket Bucket
+	config Config
+
+	mu           sync.Mutex
+	pending      map[string]*write // buffered writes by key
+	flushing     map[string]*write // writes being flushed
+	pendingBytes int
+
+	flushMu sync.Mutex // serializes flushes
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewStore returns a Store using the given bucket.
+func NewStore(bucket Bucket, config Config) *Store {
+	if config.Parallelism <= 0 {
+		config.Parallelism = DefaultParallelism
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	s := &Store{
+		bucket:  bucket,
+		config:  config,
+		pending: make(map[string]*write),
+		done:    make(chan struct{}),
+	}
+	if config.BufferBytes > 0 {
+		s.wg.Add(1)
+		go s.flushPeriodically()
+	}
+	return s
+}
+
+// ---- Engine interface ----
+
+func (s *Store) String() string {
+	return fmt.Sprintf("%s object store %s/%s", s.config.Kind, s.config.Bucket, s.config.Prefix)
+}
+
+func (s *Store) GetConfig() dvid.Config {
+	config := dvid.NewConfig()
+	config.Set("kind", s.config.Kind)
+	config.Set("bucket", s.config.Bucket)
+	config.Set("prefix", s.config.Prefix)
+	return config
+}
+
+// Sync writes any buffered writes to the object store.
+func (s *Store) Sync() error {
+	return s.Flush()
+}
+
+// Close flushes any buffered writes.
+func (s *Store) Close() {
+	close(s.done)
+	s.wg.Wait()
+	if err := s.Flush(); err != nil {
+		dvid.Errorf("Unable to flush writes to %s: %s\n", s, err.Error())
+	}
+}
+
+// ---- Object naming ----
+
+func (s *Store) objectName(key []byte) string {
+	return s.config.Prefix + hex.EncodeToString(key) + "."
+}
+
+// objectKey returns the key of an object or false if the object name isn't an
+// encoded key.
+func (s *Store) objectKey(name string) ([]byte, bool) {
+	if !strings.HasPrefix(name, s.config.Prefix) || !strings.HasSuffix(name, ".") {
+		return nil, false
+	}
+	key, err := hex.DecodeString(name[len(s.config.Prefix) : len(name)-1])
+	if err != nil {
+		return nil, false
+	}
+	return key, true
+}
+
+func constructKey(ctx storage.Context, index []byte) []byte {
+	if ctx != nil {
+		return ctx.ConstructKey(index)
+	}
+	return index
+}
+
+// ---- Buffered writes ----
+
+func (s *Store) buffered(key []byte) (*write, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, found := s.pending[string(key)]; found {
+		return w, true
+	}
+	w, found := s.flushing[string(key)]
+	return w, found
+}
+
+// bufferedRange returns the buffered writes of keys in the range, sorted by key.
+func (s *Store) bufferedRange(begKey, endKey []byte) []bufferedKV {
+	s.mu.Lock()
+	inRange := make(map[string]*write)
+	for _, writes := range []map[string]*write{s.flushing, s.pending} {
+		for key, w := range writes {
+			if key >= string(begKey) && key <= string(endKey) {
+				inRange[key] = w
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	keys := make([]string, 0, len(inRange))
+	for key := range inRange {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kvs := make([]bufferedKV, len(keys))
+	for i, key := range keys {
+		kvs[i] = bufferedKV{key, inRange[key]}
+	}
+	return kvs
+}
+
+func (s *Store) write(key []byte, w *write) error {
+	if s.config.BufferBytes <= 0 {
+		return s.writeObject(string(key), w)
+	}
+	s.mu.Lock()
+	s.pending[string(key)] = w
+	s.pendingBytes += len(key) + len(w.value)
+	full := s.pendingBytes >= s.config.BufferBytes
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *Store) writeObject(key string, w *write) error {
+	if w.deleted {
+		return s.bucket.Delete(s.objectName([]byte(key)))
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(w.value)
+	return s.bucket.Put(s.objectName([]byte(key)), w.value)
+}
+
+// Flush writes all buffered writes to the object store.  Writes that fail are kept
+// in the buffer to be retried on the next flush.
+func (s *Store) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	flushing := s.pending
+	s.flushing = flushing
+	s.pending = make(map[string]*write)
+	s.pendingBytes = 0
+	s.mu.Unlock()
+
+	keys := make([]string, 0, len(flushing))
+	for key := range flushing {
+		keys = append(keys, key)
+	}
+	errs := make([]error, len(keys))
+	err := s.parallel(len(keys), func(i int) error {
+		errs[i] = s.writeObject(keys[i], flushing[keys[i]])
+		return errs[i]
+	})
+
+	s.mu.Lock()
+	for i, key := range keys {
+		if errs[i] == nil {
+			continue
+		}
+		if _, found := s.pending[key]; !found {
+			w := flushing[key]
+			s.pending[key] = w
+			s.pendingBytes += len(key) + len(w.value)
+		}
+	}
+	s.flushing = nil
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Store) flushPeriodically() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				dvid.Errorf("Unable to flush writes to %s: %s\n", s, err.Error())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// parallel calls f for each index in [0, n) using the configured number of concurrent
+// workers and returns the first error.
+func (s *Store) parallel(n int, f func(i int) error) error {
+	workers := s.config.Parallelism
+	if workers > n {
+		workers = n
+	}
+	indices := make(chan int)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := f(i); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// ---- Reads ----
+
+// getKey returns the value of a full key, or nil if it doesn't exist.
+func (s *Store) getKey(key []byte) ([]byte, error) {
+	if w, found := s.buffered(key); found {
+		if w.deleted {
+			return nil, nil
+		}
+		return w.value, nil
+	}
+	v, err := s.bucket.Get(s.objectName(key))
+	storage.StoreValueBytesRead <- len(v)
+	return v, err
+}
+
+// scan calls f in ascending order with each key in [begKey, endKey] that is in the
+// object store or write buffer.
+func (s *Store) scan(begKey, endKey []byte, f func(key []byte) error) error {
+	buffered := s.bufferedRange(begKey, endKey)
+	emitBuffered := func(before []byte) error {
+		for len(buffered) != 0 && (before == nil || buffered[0].key < string(before)) {
+			if !buffered[0].deleted {
+				if err := f([]byte(buffered[0].key)); err != nil {
+					return err
+				}
+			}
+			buffered = buffered[1:]
+		}
+		return nil
+	}
+
+	begHex := hex.EncodeToString(begKey)
+	endHex := hex.EncodeToString(endKey)
+	common := 0
+	for common < len(begHex) && common < len(endHex) && begHex[common] == endHex[common] {
+		common++
+	}
+	endName := s.config.Prefix + endHex + "."
+
+	var err error
+	listErr := s.bucket.List(s.config.Prefix+begHex[:common], s.config.Prefix+begHex, func(name string) bool {
+		if name > endName {
+			return false
+		}
+		key, ok := s.objectKey(name)
+		if !ok {
+			return true
+		}
+		storage.StoreKeyBytesRead <- len(key)
+		if err = emitBuffered(key); err != nil {
+			return false
+		}
+		if len(buffered) != 0 && buffered[0].key == string(key) {
+			if buffered[0].deleted {
+				buffered = buffered[1:]
+				return true
+			}
+			buffered = buffered[1:]
+		}
+		err = f(key)
+		return err == nil
+	})
+	if listErr != nil {
+		return listErr
+	}
+	if err != nil {
+		return err
+	}
+	return emitBuffered(nil)
+}
+
+// rangeKeys returns the full keys in the range visible to the context.
+func (s *Store) rangeKeys(ctx storage.Context, kStart, kEnd []byte) ([][]byte, error) {
+	var keys [][]byte
+	if ctx == nil || !ctx.Versioned() {
+		err := s.scan(constructKey(ctx, kStart), constructKey(ctx, kEnd), func(key []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+		return keys, err
+	}
+
+	// All versions of an index are adjacent in key ordering, so we collect them and
+	// keep the one appropriate for the context's version.
+	vctx, ok := ctx.(storage.VersionedContext)
+	if !ok {
+		return nil, fmt.Errorf("Context is versioned but doesn't fulfill storage.VersionedContext")
+	}
+	minKey, err := vctx.MinVersionKey(kStart)
+	if err != nil {
+		return nil, err
+	}
+	maxKey, err := vctx.MaxVersionKey(kEnd)
+	if err != nil {
+		return nil, err
+	}
+	maxVersionKey, err := vctx.MaxVersionKey(kStart)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*storage.KeyValue
+	resolve := func() error {
+		if len(versions) == 0 {
+			return nil
+		}
+		kv, err := vctx.VersionedKeyValue(versions)
+		if err != nil {
+			return err
+		}
+		if kv != nil {
+			keys = append(keys, kv.K)
+		}
+		versions = nil
+		return nil
+	}
+	err = s.scan(minKey, maxKey, func(key []byte) error {
+		if bytes.Compare(key, maxVersionKey) > 0 {
+			index, err := vctx.IndexFromKey(key)
+			if err != nil {
+				return err
+			}
+			if maxVersionKey, err = vctx.MaxVersionKey(index); err != nil {
+				return err
+			}
+			if err := resolve(); err != nil {
+				return err
+			}
+		}
+		versions = append(versions, &storage.KeyValue{K: key})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := resolve(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// rangeValues calls f in key order with each key-value pair in the range visible to
+// the context.  Values are read concurrently in groups.
+func (s *Store) rangeValues(ctx storage.Context, kStart, kEnd []byte, f func(*storage.KeyValue) error) error {
+	keys, err := s.rangeKeys(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	groupSize := 4 * s.config.Parallelism
+	for beg := 0; beg < len(keys); beg += groupSize {
+		if storage.Canceled(ctx) {
+			return storage.ErrCanceled
+		}
+		group := keys[beg:]
+		if len(group) > groupSize {
+			group = group[:groupSize]
+		}
+		values := make([][]byte, len(group))
+		err := s.parallel(len(group), func(i int) error {
+			var err error
+			values[i], err = s.getKey(group[i])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for i, key := range group {
+			if values[i] == nil {
+				continue // deleted since listed
+			}
+			if err := f(&storage.KeyValue{K: key, V: values[i]}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (s *Store) Get(ctx storage.Context, k []byte) ([]byte, error) {
+	if ctx != nil && ctx.Versioned() {
+		keys, err := s.rangeKeys(ctx, k, k)
+		if err != nil || len(keys) == 0 {
+			return nil, err
+		}
+		return s.getKey(keys[0])
+	}
+	return s.getKey(constructKey(ctx, k))
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (s *Store) KeysInRange(ctx storage.Context, kStart, kEnd []byte) ([][]byte, error) {
+	return s.rangeKeys(ctx, kStart, kEnd)
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (s *Store) GetRange(ctx storage.Context, kStart, kEnd []byte) ([]*storage.KeyValue, error) {
+	values := []*storage.KeyValue{}
+	err := s.rangeValues(ctx, kStart, kEnd, func(kv *storage.KeyValue) error {
+		values = append(values, kv)
+		return nil
+	})
+	return values, err
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.
+func (s *Store) ProcessRange(ctx storage.Context, kStart, kEnd []byte, op *storage.ChunkOp, f func(*storage.Chunk)) error {
+	return s.rangeValues(ctx, kStart, kEnd, func(kv *storage.KeyValue) error {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		f(&storage.Chunk{op, kv})
+		return nil
+	})
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (s *Store) Put(ctx storage.Context, k, v []byte) error {
+	return s.write(constructKey(ctx, k), &write{value: v})
+}
+
+// Delete removes a value with given key.
+func (s *Store) Delete(ctx storage.Context, k []byte) error {
+	return s.write(constructKey(ctx, k), &write{deleted: true})
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts key-value pairs that have been sorted in sequential key order.
+func (s *Store) PutRange(ctx storage.Context, values []storage.KeyValue) error {
+	batch := s.NewBatch(ctx)
+	for _, kv := range values {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (s *Store) DeleteRange(ctx storage.Context, kStart, kEnd []byte) error {
+	keys, err := s.rangeKeys(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	batch := &objectBatch{store: s}
+	for _, key := range keys {
+		batch.Delete(key)
+	}
+	return batch.Commit()
+}
+
+// --- Batcher interface ----
+
+type objectBatch struct {
+	store  *Store
+	ctx    storage.Context
+	keys   []string
+	writes []*write
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (s *Store) NewBatch(ctx storage.Context) storage.Batch {
+	return &objectBatch{store: s, ctx: ctx}
+}
+
+// --- Batch interface ---
+
+func (batch *objectBatch) Delete(k []byte) {
+	batch.keys = append(batch.keys, string(constructKey(batch.ctx, k)))
+	batch.writes = append(batch.writes, &write{deleted: true})
+}
+
+func (batch *objectBatch) Put(k, v []byte) {
+	batch.keys = append(batch.keys, string(constructKey(batch.ctx, k)))
+	batch.writes = append(batch.writes, &write{value: v})
+}
+
+// Commit buffers the batch's writes or, without a write buffer, writes them
+// concurrently.  Object stores have no transactions, so a failed commit may have
+// applied some of the writes.
+func (batch *objectBatch) Commit() error {
+	s := batch.store
+	if s.config.BufferBytes > 0 {
+		for i, key := range batch.keys {
+			if err := s.write([]byte(key), batch.writes[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Only the last write of each key is applied since concurrent writes are unordered.
+	last := make(map[string]*write, len(batch.keys))
+	for i, key := range batch.keys {
+		last[key] = batch.writes[i]
+	}
+	keys := make([]string, 0, len(last))
+	for key := range last {
+		keys = append(keys, key)
+	}
+	return s.parallel(len(keys), func(i int) error {
+		return s.writeObject(keys[i], last[keys[i]])
+	})
+}
@@ -0,0 +1,141 @@
+package objectstore
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// memBucket is an in-memory object store.
+type memBucket struct {
+	sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{objects: make(map[string][]byte)}
+}
+
+func (b *memBucket) Get(name string) ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.objects[name], nil
+}
+
+func (b *memBucket) Put(name string, data []byte) error {
+	b.Lock()
+	defer b.Unlock()
+	b.objects[name] = data
+	b.puts++
+	return nil
+}
+
+func (b *memBucket) Delete(name string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.objects, name)
+	return nil
+}
+
+func (b *memBucket) List(prefix, after string, f func(name string) bool) error {
+	b.Lock()
+	var names []string
+	for name := range b.objects {
+		if strings.HasPrefix(name, prefix) && name > after {
+			names = append(names, name)
+		}
+	}
+	b.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if !f(name) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestObjectNameOrdering(t *testing.T) {
+	s := NewStore(newMemBucket(), Config{Prefix: "dvid/"})
+	keys := [][]byte{{}, {0}, {0, 0}, {0, 1}, {1}, {1, 0xFF}, {0x7F}, {0xFF}, {0xFF, 0}}
+	for i := 1; i < len(keys); i++ {
+		if s.objectName(keys[i-1]) >= s.objectName(keys[i]) {
+			t.Errorf("Object name of key %v doesn't sort before key %v\n", keys[i-1], keys[i])
+		}
+	}
+	if key, ok := s.objectKey(s.objectName([]byte{3, 0xAB})); !ok || !bytes.Equal(key, []byte{3, 0xAB}) {
+		t.Errorf("Bad key decoded from object name: %v\n", key)
+	}
+	if _, ok := s.objectKey("dvid/foreign-object"); ok {
+		t.Errorf("Expected foreign object name to be ignored\n")
+	}
+}
+
+func TestObjectStore(t *testing.T) {
+	for _, bufferBytes := range []int{0, 1 << 20} {
+		bucket := newMemBucket()
+		s := NewStore(bucket, Config{Prefix: "test/", BufferBytes: bufferBytes, Parallelism: 4})
+		ctx := storage.NewMetadataContext()
+
+		batch := s.NewBatch(ctx)
+		for i := 0; i < 300; i++ {
+			batch.Put([]byte{byte(i >> 8), byte(i)}, []byte{byte(i)})
+		}
+		if err := batch.Commit(); err != nil {
+			t.Fatalf("Error on batch commit: %s\n", err.Error())
+		}
+		if err := s.Put(ctx, []byte{1}, []byte("prefix key")); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+		if bufferBytes != 0 && bucket.puts != 0 {
+			t.Errorf("Expected writes to be buffered, got %d puts\n", bucket.puts)
+		}
+
+		// Buffered writes are visible to reads.
+		if v, err := s.Get(ctx, []byte{0, 42}); err != nil || !bytes.Equal(v, []byte{42}) {
+			t.Errorf("Bad value read: %v, %v\n", v, err)
+		}
+		if err := s.Delete(ctx, []byte{0, 50}); err != nil {
+			t.Fatalf("Error on delete: %s\n", err.Error())
+		}
+		kvs, err := s.GetRange(ctx, []byte{0, 40}, []byte{1, 2})
+		if err != nil {
+			t.Fatalf("Error on range read: %s\n", err.Error())
+		}
+		if len(kvs) != 219 || !bytes.Equal(kvs[0].V, []byte{40}) || string(kvs[215].V) != "prefix key" {
+			t.Fatalf("Bad range read of %d key-value pairs\n", len(kvs))
+		}
+		for i := 1; i < len(kvs); i++ {
+			if bytes.Compare(kvs[i-1].K, kvs[i].K) >= 0 {
+				t.Fatalf("Range out of order at %d\n", i)
+			}
+		}
+
+		// Flushed writes are in the object store and read back the same.
+		if err := s.Flush(); err != nil {
+			t.Fatalf("Error on flush: %s\n", err.Error())
+		}
+		if len(bucket.objects) != 300 {
+			t.Errorf("Expected 300 objects after flush, got %d\n", len(bucket.objects))
+		}
+		keys, err := s.KeysInRange(ctx, []byte{0, 40}, []byte{1, 2})
+		if err != nil || len(keys) != 219 {
+			t.Errorf("Bad keys in range after flush: %d, %v\n", len(keys), err)
+		}
+		if err := s.DeleteRange(ctx, []byte{0}, []byte{0, 0xFF}); err != nil {
+			t.Fatalf("Error on range delete: %s\n", err.Error())
+		}
+		if keys, _ := s.KeysInRange(ctx, []byte{}, []byte{0xFF}); len(keys) != 45 {
+			t.Errorf("Expected 45 keys after range delete, got %d\n", len(keys))
+		}
+		s.Close()
+		if bufferBytes != 0 && len(bucket.objects) != 45 {
+			t.Errorf("Expected 45 objects after close, got %d\n", len(bucket.objects))
+		}
+	}
+}
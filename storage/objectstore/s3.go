@@ -0,0 +1,87 @@
+// +build s3
+
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func init() {
+	registerBucket("s3", openS3)
+}
+
+// s3Bucket is a bucket in Amazon S3 or an S3-compatible store.  Credentials are
+// found in the standard AWS environment variables or configuration files.
+type s3Bucket struct {
+	client *s3.S3
+	name   string
+}
+
+func openS3(config Config) (Bucket, error) {
+	awsConfig := aws.NewConfig()
+	if config.Region != "" {
+		awsConfig = awsConfig.WithRegion(config.Region)
+	}
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Bucket{client: s3.New(sess), name: config.Bucket}, nil
+}
+
+func (b *s3Bucket) Get(name string) ([]byte, error) {
+	out, err := b.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (b *s3Bucket) Put(name string, data []byte) error {
+	_, err := b.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(name),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (b *s3Bucket) Delete(name string) error {
+	_, err := b.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(name),
+	})
+	return err
+}
+
+func (b *s3Bucket) List(prefix, after string, f func(name string) bool) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:     aws.String(b.name),
+		Prefix:     aws.String(prefix),
+		StartAfter: aws.String(after),
+	}
+	return b.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			if !f(aws.StringValue(object.Key)) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,308 @@
+/*
+	This file supports read-only replicas that follow the mutation log of a primary server.
+	A primary with a mutation log streams its settled mutations, i.e., those applied or
+	aborted, in log order, and a replica applies the mutations that weren't aborted to its
+	own datastore, which otherwise rejects all writes.  The replica datastore should start
+	as a snapshot of the primary's taken after the mutation log was started.  Since all
+	mutations are blind writes, reapplying mutations already in the snapshot is harmless.
+*/
+
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// replicationIndex is the metadata key of the sequence number of the last mutation
+// applied by a replica.
+var replicationIndex = []byte{0xA9, 'r', 'e', 'p', 'l'}
+
+// ErrReadOnly is returned when writing to a read-only replica datastore.
+var ErrReadOnly = fmt.Errorf("Datastore is a read-only replica")
+
+// readOnlyDB wraps an ordered key-value store and rejects all mutations.
+type readOnlyDB struct {
+	OrderedKeyValueDB
+}
+
+func (db *readOnlyDB) String() string {
+	return fmt.Sprintf("read-only %s", db.OrderedKeyValueDB)
+}
+
+func (db *readOnlyDB) Put(ctx Context, k, v []byte) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) Delete(ctx Context, k []byte) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) PutRange(ctx Context, values []KeyValue) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	return ErrReadOnly
+}
+
+type readOnlyBatch struct{}
+
+func (db *readOnlyDB) NewBatch(ctx Context) Batch {
+	return readOnlyBatch{}
+}
+
+func (b readOnlyBatch) Put(k, v []byte) {}
+func (b readOnlyBatch) Delete(k []byte) {}
+func (b readOnlyBatch) Commit() error   { return ErrReadOnly }
+
+type readOnlyTransaction struct{}
+
+// NewTransaction returns a transaction that fails on commit, like batches of a replica.
+func (db *readOnlyDB) NewTransaction() Transaction {
+	return readOnlyTransaction{}
+}
+
+func (txn readOnlyTransaction) Put(ctx Context, k, v []byte) {}
+func (txn readOnlyTransaction) Delete(ctx Context, k []byte) {}
+func (txn readOnlyTransaction) Commit() error                { return ErrReadOnly }
+func (txn readOnlyTransaction) Rollback()                    {}
+
+// ---- Primary ------
+
+// MutationLogSeq returns the sequence number of the last logged mutation.
+func MutationLogSeq() (uint64, error) {
+	if manager.mutationLog == nil {
+		return 0, fmt.Errorf("Mutation log is not enabled")
+	}
+	seq, _ := manager.mutationLog.settled()
+	return seq, nil
+}
+
+// StreamMutations writes the encoded records of settled mutations after the given
+// sequence number to w, waiting for new mutations until the stop channel is closed.
+// Aborted mutations are not written.
+func StreamMutations(w io.Writer, after uint64, stop <-chan struct{}) error {
+	if manager.mutationLog == nil {
+		return fmt.Errorf("Mutation log is not enabled")
+	}
+	return streamMutations(manager.mutationLog, w, after, stop)
+}
+
+func streamMutations(l *mutationLog, w io.Writer, after uint64, stop <-chan struct{}) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	bw := bufio.NewWriter(w)
+	var offset int64
+	for {
+		// Read records up to the last settled one, stopping at a partially written record.
+		settled, changed := l.settled()
+		for {
+			rec, err := readMutationRecord(r)
+			if err == io.EOF || err == ErrTornRecord {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if rec.Seq > settled {
+				break
+			}
+			encoded := rec.encode()
+			offset += int64(len(encoded))
+			if rec.Op == MutationAbort || rec.Seq <= after || l.isAborted(rec.Seq) {
+				continue
+			}
+			if _, err := bw.Write(encoded); err != nil {
+				return err
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if _, err := file.Seek(offset, 0); err != nil {
+			return err
+		}
+		r.Reset(file)
+
+		select {
+		case <-changed:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// ---- Replica ------
+
+// ReplicationStats gives the progress of a replica applying its primary's mutations.
+type ReplicationStats struct {
+	Seq     uint64
+	Applied uint64
+	Last    time.Time
+}
+
+var replicationStats struct {
+	sync.RWMutex
+	ReplicationStats
+}
+
+// ReplicationStatus returns the progress of a replica since the server started.
+func ReplicationStatus() ReplicationStats {
+	replicationStats.RLock()
+	defer replicationStats.RUnlock()
+	return replicationStats.ReplicationStats
+}
+
+// EnableReadOnlyReplica makes the datastore reject all writes except mutations applied
+// from a primary by ApplyReplicationStream.  It must be enabled before any other storage
+// wrappers and can't be used with a mutation log or cluster mode.
+func EnableReadOnlyReplica() error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable read-only replica before storage manager is initialized")
+	}
+	if manager.replicated != nil {
+		return fmt.Errorf("Read-only replica already enabled for %s", manager.replicated)
+	}
+	if manager.mutationLog != nil || manager.cluster != nil {
+		return fmt.Errorf("Read-only replica can't be used with a mutation log or cluster mode")
+	}
+	if manager.encryptor != nil || manager.dictionaries != nil || manager.checksumRepairer != nil || manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Read-only replica must be enabled before other storage wrappers")
+	}
+	if manager.metadata != manager.smalldata || manager.smalldata != manager.bigdata {
+		return fmt.Errorf("Read-only replica requires all storage tiers to use one database")
+	}
+	db := &readOnlyDB{manager.metadata}
+
+	graphEngine, err := NewGraphStore(db)
+	if err != nil {
+		return err
+	}
+	manager.graphEngine = graphEngine
+	manager.graphDB = graphEngine.(GraphDB)
+	manager.graphSetter = graphEngine.(GraphSetter)
+	manager.graphGetter = graphEngine.(GraphGetter)
+
+	manager.replicated = manager.metadata
+	manager.metadata = db
+	manager.smalldata = db
+	manager.bigdata = db
+
+	seq, err := ReplicatedSeq()
+	if err != nil {
+		return err
+	}
+	replicationStats.Lock()
+	replicationStats.Seq = seq
+	replicationStats.Unlock()
+	dvid.Infof("Enabled read-only replica: %s (continuing after mutation %d)\n", db, seq)
+	return nil
+}
+
+// ReplicatedSeq returns the sequence number of the last mutation applied by a replica.
+func ReplicatedSeq() (uint64, error) {
+	if manager.replicated == nil {
+		return 0, fmt.Errorf("Datastore is not a read-only replica")
+	}
+	return getReplicatedSeq(manager.replicated)
+}
+
+func getReplicatedSeq(db OrderedKeyValueDB) (uint64, error) {
+	data, err := db.Get(NewMetadataContext(), replicationIndex)
+	if err != nil || data == nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("Bad replicated sequence number of %d bytes", len(data))
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+func putReplicatedSeq(db OrderedKeyValueDB, seq uint64) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, seq)
+	return db.Put(NewMetadataContext(), replicationIndex, data)
+}
+
+// ApplyReplicationStream applies mutations streamed by a primary until the stream ends
+// or fails, which is returned as an error.  The sequence number of the last applied
+// mutation is saved whenever the stream pauses, and metadataChanged, if not nil, is
+// then called if any metadata was modified.
+func ApplyReplicationStream(r io.Reader, metadataChanged func()) error {
+	if manager.replicated == nil {
+		return fmt.Errorf("Datastore is not a read-only replica")
+	}
+	return applyReplicationStream(manager.replicated, manager.blockCache, r, metadataChanged)
+}
+
+// replicationSaveInterval is the maximum number of mutations applied before the last
+// sequence number is saved.
+const replicationSaveInterval = 1000
+
+func applyReplicationStream(db OrderedKeyValueDB, cache *blockCache, r io.Reader, metadataChanged func()) error {
+	seq, err := getReplicatedSeq(db)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	var unsaved uint64
+	var metadata bool
+	for {
+		rec, err := readMutationRecord(br)
+		if err == io.EOF {
+			return fmt.Errorf("Replication stream ended after mutation %d", seq)
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Seq <= seq {
+			continue
+		}
+		if err := applyMutation(db, rec); err != nil {
+			return fmt.Errorf("Unable to apply replicated mutation %d: %s", rec.Seq, err.Error())
+		}
+		if cache != nil {
+			keyEnd := rec.KeyEnd
+			if keyEnd == nil {
+				keyEnd = rec.Key
+			}
+			cache.invalidate(nil, rec.Key, keyEnd)
+		}
+		if len(rec.Key) != 0 && rec.Key[0] == metadataKeyPrefix {
+			metadata = true
+		}
+		seq = rec.Seq
+		unsaved++
+
+		replicationStats.Lock()
+		replicationStats.Seq = seq
+		replicationStats.Applied++
+		replicationStats.Last = rec.Time
+		replicationStats.Unlock()
+
+		if br.Buffered() == 0 || unsaved >= replicationSaveInterval {
+			if err := putReplicatedSeq(db, seq); err != nil {
+				return fmt.Errorf("Unable to save replicated sequence number: %s", err.Error())
+			}
+			unsaved = 0
+			if metadata && br.Buffered() == 0 {
+				metadata = false
+				if metadataChanged != nil {
+					metadataChanged()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplicationStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dvid-replication")
+	if err != nil {
+		t.Fatalf("Can't create temp directory: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	mlog, err := openMutationLog(filepath.Join(dir, "mutations.log"), false)
+	if err != nil {
+		t.Fatalf("Can't open mutation log: %s\n", err.Error())
+	}
+	defer mlog.close()
+	primary := &mutationLogger{newMemoryDB(), mlog}
+	ctx := GetTestDataContext(TestUUID1, "keyvalue", 23)
+
+	primary.Put(ctx, []byte("a"), []byte("apple"))
+	primary.Put(ctx, []byte("b"), []byte("banana"))
+	rec := newMutationRecord(MutationPut, "", ctx.ConstructKey([]byte("x")), nil, []byte("failed"))
+	primary.logged([]*MutationRecord{rec}, func() error { return fmt.Errorf("failed put") })
+
+	// Mutations logged but not yet applied aren't settled.
+	unsettled := newMutationRecord(MutationDelete, "", ctx.ConstructKey([]byte("a")), nil, nil)
+	if err := mlog.append(unsettled); err != nil {
+		t.Fatalf("Error appending mutation: %s\n", err.Error())
+	}
+	if settled, _ := mlog.settled(); settled != unsettled.Seq-1 {
+		t.Errorf("Expected mutations through %d settled, got %d\n", unsettled.Seq-1, settled)
+	}
+
+	replica := newMemoryDB()
+	pr, pw := io.Pipe()
+	stop := make(chan struct{})
+	streamed := make(chan error, 1)
+	go func() {
+		streamed <- streamMutations(mlog, pw, 0, stop)
+		pw.Close()
+	}()
+	applied := make(chan error, 1)
+	go func() {
+		applied <- applyReplicationStream(replica, nil, pr, nil)
+	}()
+
+	waitFor := func(seq uint64) {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if got, _ := getReplicatedSeq(replica); got >= seq {
+				return
+			}
+		}
+		t.Fatalf("Replica didn't apply mutation %d\n", seq)
+	}
+	waitFor(2)
+	if v, _ := replica.Get(ctx, []byte("a")); string(v) != "apple" {
+		t.Errorf("Bad replicated value: %q\n", v)
+	}
+	if v, _ := replica.Get(ctx, []byte("x")); v != nil {
+		t.Errorf("Aborted mutation was replicated with value %q\n", v)
+	}
+
+	// Settling the pending mutation and logging more streams them to the replica.
+	mlog.settle([]*MutationRecord{unsettled})
+	primary.Put(ctx, []byte("c"), []byte("cherry"))
+	waitFor(unsettled.Seq + 1)
+	if v, _ := replica.Get(ctx, []byte("a")); v != nil {
+		t.Errorf("Deleted key replicated with value %q\n", v)
+	}
+	if v, _ := replica.Get(ctx, []byte("c")); string(v) != "cherry" {
+		t.Errorf("Bad replicated value: %q\n", v)
+	}
+
+	close(stop)
+	if err := <-streamed; err != nil {
+		t.Errorf("Error streaming mutations: %s\n", err.Error())
+	}
+	if err := <-applied; err == nil {
+		t.Errorf("Expected error when replication stream ends\n")
+	}
+
+	// Read-only stores reject all writes.
+	readOnly := &readOnlyDB{replica}
+	if err := readOnly.Put(ctx, []byte("d"), []byte("date")); err != ErrReadOnly {
+		t.Errorf("Expected write to read-only store to fail, got %v\n", err)
+	}
+	if v, _ := readOnly.Get(ctx, []byte("c")); string(v) != "cherry" {
+		t.Errorf("Bad value read from read-only store: %q\n", v)
+	}
+}
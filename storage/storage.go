@@ -258,6 +258,31 @@ type Batch interface {
 	Commit() error
 }
 
+// Transaction groups puts and deletes, possibly using different contexts, so they are
+// applied together on Commit or discarded on Rollback.  This allows, for example, label
+// maps, label spatial indices, and label sizes to be updated atomically.  Rollback after
+// Commit does nothing, so callers can defer Rollback to discard uncommitted writes on
+// any early return.  Use NewTransaction to get a transaction for a store.
+type Transaction interface {
+	// Put adds to the transaction a put using the given context and key-value.
+	Put(ctx Context, k, v []byte)
+
+	// Delete adds to the transaction a delete using the given context and key.
+	Delete(ctx Context, k []byte)
+
+	// Commit applies all operations of the transaction.
+	Commit() error
+
+	// Rollback discards all operations of the transaction.
+	Rollback()
+}
+
+// Transactioner is implemented by stores that can apply a transaction atomically even
+// when its operations use different contexts.
+type Transactioner interface {
+	NewTransaction() Transaction
+}
+
 // GraphSetter defines operations that modify a graph
 type GraphSetter interface {
 	// CreateGraph creates a graph with the given context.
@@ -323,3 +348,86 @@ type GraphDB interface {
 	GraphSetter
 	GraphGetter
 }
+
+// WeightedEdge is an edge between two vertices with a weight.
+type WeightedEdge struct {
+	Vertex1 dvid.VertexID
+	Vertex2 dvid.VertexID
+	Weight  float64
+}
+
+// EdgeReader supplies a stream of edges, returning io.EOF after the last edge.
+type EdgeReader interface {
+	ReadEdge() (WeightedEdge, error)
+}
+
+// GraphBulkLoader is implemented by graph databases that can add large numbers of edges
+// far more efficiently than individual AddEdge calls.
+type GraphBulkLoader interface {
+	// BulkLoadEdges adds all edges from the reader using batched writes, creating any
+	// missing vertices with zero weight.  The weights of existing edges are replaced.
+	// Returns the number of edges loaded, which are persisted even if an error occurs.
+	BulkLoadEdges(ctx Context, edges EdgeReader) (uint64, error)
+}
+
+// PropertyIndexKind determines how the values of an indexed vertex property are ordered.
+type PropertyIndexKind uint8
+
+const (
+	// StringIndex orders property values as byte strings.
+	StringIndex PropertyIndexKind = iota + 1
+
+	// NumberIndex orders property values, which are decimal text, as numbers.
+	NumberIndex
+)
+
+// ParsePropertyIndexKind returns the index kind for "string" or "number".
+func ParsePropertyIndexKind(s string) (PropertyIndexKind, error) {
+	switch s {
+	case "string":
+		return StringIndex, nil
+	case "number":
+		return NumberIndex, nil
+	default:
+		return 0, fmt.Errorf("Unknown property index kind %q: expected string or number", s)
+	}
+}
+
+func (kind PropertyIndexKind) String() string {
+	switch kind {
+	case StringIndex:
+		return "string"
+	case NumberIndex:
+		return "number"
+	default:
+		return fmt.Sprintf("unknown index kind %d", kind)
+	}
+}
+
+// PropertyCondition is a comparison of an indexed vertex property with a value.  The
+// operator is one of "=", "<", "<=", ">", or ">=".
+type PropertyCondition struct {
+	Property string
+	Op       string
+	Value    string
+}
+
+// VertexIndexer is implemented by graph databases that support secondary indexes on
+// vertex properties.  Indexed property values must be serialized with dvid.SerializeData
+// and are indexed on their deserialized bytes.  Values that can't be deserialized or
+// parsed for the index kind aren't indexed.
+type VertexIndexer interface {
+	// CreateVertexIndex indexes the given vertex property, including all of its
+	// current values.
+	CreateVertexIndex(ctx Context, property string, kind PropertyIndexKind) error
+
+	// DropVertexIndex removes the index of the given vertex property.
+	DropVertexIndex(ctx Context, property string) error
+
+	// VertexIndexes returns the kinds of all indexed vertex properties.
+	VertexIndexes(ctx Context) (map[string]PropertyIndexKind, error)
+
+	// QueryVertices returns the sorted IDs of vertices that satisfy all conditions,
+	// each of which must be on an indexed property.
+	QueryVertices(ctx Context, conditions []PropertyCondition) ([]dvid.VertexID, error)
+}
@@ -1,6 +1,7 @@
 // +build clustered
 
-// TODO: Implement clustered storage support
+// TODO: Implement clustered storage support for shared clustered engines.  Servers with
+// local engines can partition data across a cluster in the local build; see cluster.go.
 
 package storage
 
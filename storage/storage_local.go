@@ -5,6 +5,8 @@ package storage
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
 )
@@ -28,6 +30,41 @@ type managerT struct {
 	graphSetter GraphSetter
 	graphGetter GraphGetter
 
+	// Optional separate engine, e.g., an object store, for the big data tier.
+	bigdataEngine Engine
+
+	// Optional LRU cache layered over the big data store.
+	blockCache *blockCache
+
+	// Optional copy-on-write deduplication of versioned writes to the big data store.
+	copyOnWrite *copyOnWrite
+
+	// Optional encryption of values in the small and big data stores.
+	encryptor *encryptor
+
+	// Optional dictionary compression of values in the small and big data stores.
+	dictionaries *valueDictionaries
+
+	// Optional write-ahead log of all mutations for point-in-time recovery.
+	mutationLog *mutationLog
+
+	// Optional partitioning of data across a cluster of servers.
+	cluster *clusterDB
+
+	// Writable database beneath the tiers of a read-only replica, modified only by
+	// mutations replicated from a primary.
+	replicated OrderedKeyValueDB
+
+	// Optional repair of values with bad checksums from a replica store.
+	checksumRepairer *checksumRepairer
+	replica          OrderedKeyValueGetter
+
+	// Optional tallying of writes for the storage usage report.
+	usageTracker *usageTracker
+
+	// Optional syncing of the small and big data stores after writes.
+	durability []*durableDB
+
 	enginesAvail []string
 }
 
@@ -52,6 +89,15 @@ func BigDataStore() (BigDataStorer, error) {
 	return manager.bigdata, nil
 }
 
+// BlobDataStore returns a store of large immutable values by content hash, which keeps
+// blobs in the big data store.
+func BlobDataStore() (*BlobStore, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Key-value store not initialized before requesting BlobDataStore")
+	}
+	return NewBlobStore(manager.bigdata)
+}
+
 func GraphStore() (GraphDB, error) {
 	if !manager.setup {
 		return nil, fmt.Errorf("Graph DB not initialized before requesting it")
@@ -59,6 +105,790 @@ func GraphStore() (GraphDB, error) {
 	return manager.graphDB, nil
 }
 
+// EnableBlockCache layers an in-memory LRU cache of the given size in bytes over the
+// big data store.  Reads of voxel blocks and other data keyed by data instance are cached
+// until evicted or invalidated by a write.
+func EnableBlockCache(maxBytes int) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable block cache before storage manager is initialized")
+	}
+	if manager.blockCache != nil {
+		return fmt.Errorf("Block cache already enabled for %s", manager.blockCache)
+	}
+	if manager.cluster != nil {
+		return fmt.Errorf("Block cache can't be used in cluster mode since other members modify data")
+	}
+	cache, err := newBlockCache(manager.bigdata, maxBytes)
+	if err != nil {
+		return err
+	}
+	manager.blockCache = cache
+	manager.bigdata = cache
+	dvid.Infof("Enabled block cache: %s\n", cache)
+
+	// Periodically persist the most frequent reads so the cache can be warmed on restart.
+	go func() {
+		for range time.Tick(hotReadsSaveInterval) {
+			if err := saveHotReads(manager.metadata, cache); err != nil {
+				dvid.Errorf("Unable to save hot reads for cache warmup: %s\n", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// ResizeBlockCache changes the size in bytes of the block cache, which must be enabled.
+func ResizeBlockCache(maxBytes int) error {
+	if manager.blockCache == nil {
+		return fmt.Errorf("Can't resize block cache since it isn't enabled")
+	}
+	if err := manager.blockCache.setMaxBytes(maxBytes); err != nil {
+		return err
+	}
+	dvid.Infof("Resized block cache: %s\n", manager.blockCache)
+	return nil
+}
+
+// LoadHotReads returns the most frequent reads persisted before the last shutdown, in
+// order of decreasing frequency, for warming the block cache.  The block cache must be
+// enabled.
+func LoadHotReads() ([]HotRead, error) {
+	if manager.blockCache == nil {
+		return nil, fmt.Errorf("Can't load hot reads without a block cache")
+	}
+	reads, err := loadHotReads(manager.metadata)
+	if err != nil {
+		return nil, err
+	}
+	manager.blockCache.seedHotReads(reads)
+	return reads, nil
+}
+
+// WarmMetadata reads all metadata key-value pairs so the storage engine has them in
+// its own caches, returning the number of pairs read.
+func WarmMetadata() (int, error) {
+	if !manager.setup {
+		return 0, fmt.Errorf("Can't warm metadata before storage manager is initialized")
+	}
+	var n int
+	minKey := []byte{metadataKeyPrefix}
+	maxKey := []byte{metadataKeyPrefix, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	err := manager.metadata.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+		n++
+	})
+	return n, err
+}
+
+// BlockCacheStatistics returns statistics for the block cache or false if there is no cache.
+func BlockCacheStatistics() (BlockCacheStats, bool) {
+	if manager.blockCache == nil {
+		return BlockCacheStats{}, false
+	}
+	return manager.blockCache.Stats(), true
+}
+
+// SetBigDataStore uses a separate engine, e.g., a cloud object store, for the big data
+// tier instead of the local key-value store.  It must be called before any storage
+// wrappers are enabled, and the mutation log and cluster mode, which require all tiers
+// to share a database, can't be used with it.
+func SetBigDataStore(engine Engine) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't set big data store before storage manager is initialized")
+	}
+	if manager.bigdataEngine != nil {
+		return fmt.Errorf("Big data store already set to %s", manager.bigdataEngine)
+	}
+	if manager.mutationLog != nil || manager.cluster != nil || manager.replicated != nil ||
+		manager.encryptor != nil || manager.dictionaries != nil || manager.checksumRepairer != nil ||
+		manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Big data store must be set before any storage wrappers are enabled")
+	}
+	kvDB, ok := engine.(OrderedKeyValueDB)
+	if !ok {
+		return fmt.Errorf("Database %q is not a valid ordered key-value database", engine)
+	}
+	if _, ok := engine.(KeyValueBatcher); !ok {
+		return fmt.Errorf("Database %q doesn't support batch writes", engine)
+	}
+	manager.bigdata = kvDB
+	manager.bigdataEngine = engine
+	manager.enginesAvail = append(manager.enginesAvail, engine.String()+" (big data)")
+	dvid.Infof("Using %s for big data\n", engine)
+	return nil
+}
+
+// EnableEncryption encrypts values of data instances marked as encrypted before they
+// are written to the small and big data stores, using keys from the given provider.
+// It must be enabled before any block cache or copy-on-write so they see decrypted
+// values.  Graph data is not encrypted.
+func EnableEncryption(provider KeyProvider) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable encryption before storage manager is initialized")
+	}
+	if manager.encryptor != nil {
+		return fmt.Errorf("Encryption already enabled for %s", manager.encryptor)
+	}
+	if manager.dictionaries != nil || manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Encryption must be enabled before value dictionaries, block cache, or copy-on-write")
+	}
+	enc, err := newEncryptor(manager.bigdata, provider)
+	if err != nil {
+		return err
+	}
+	manager.encryptor = enc
+	manager.bigdata = enc
+	if manager.smalldata == enc.OrderedKeyValueDB {
+		manager.smalldata = enc
+	} else {
+		if manager.smalldata, err = newEncryptor(manager.smalldata, provider); err != nil {
+			return err
+		}
+	}
+	dvid.Infof("Enabled encryption at rest: %s\n", enc)
+	return nil
+}
+
+// EnableValueDictionaries compresses values of keyspaces with trained dictionaries
+// before they are written to the small and big data stores.  It must be enabled after
+// encryption so compressed values are encrypted, and before checksum repair, block cache,
+// or copy-on-write so they see uncompressed values.
+func EnableValueDictionaries() error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable value dictionaries before storage manager is initialized")
+	}
+	if manager.dictionaries != nil {
+		return fmt.Errorf("Value dictionaries already enabled")
+	}
+	if manager.checksumRepairer != nil || manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Value dictionaries must be enabled before checksum repair, block cache, or copy-on-write")
+	}
+	dicts, err := loadValueDictionaries(manager.metadata)
+	if err != nil {
+		return err
+	}
+	bigdata, err := newDictCompressor(manager.bigdata, dicts)
+	if err != nil {
+		return err
+	}
+	if manager.smalldata == manager.bigdata {
+		manager.smalldata = bigdata
+	} else if manager.smalldata, err = newDictCompressor(manager.smalldata, dicts); err != nil {
+		return err
+	}
+	manager.bigdata = bigdata
+	manager.dictionaries = dicts
+	dvid.Infof("Enabled value dictionaries with %d trained keyspaces: %s\n", len(dicts.current), bigdata)
+	return nil
+}
+
+// TrainDictionary trains a new dictionary on sampled values of a keyspace, which is
+// used to compress values written afterwards.  At most maxSamples values are sampled
+// to build a dictionary of up to dictSize bytes, using defaults if either is zero.
+func TrainDictionary(ks Keyspace, maxSamples, dictSize int) (*DictionaryReport, error) {
+	if manager.dictionaries == nil {
+		return nil, fmt.Errorf("Value dictionaries are not enabled")
+	}
+	dbs := []OrderedKeyValueDB{manager.bigdata}
+	if baseDB(manager.smalldata) != baseDB(manager.bigdata) {
+		dbs = append(dbs, manager.smalldata)
+	}
+	return manager.dictionaries.train(dbs, ks, maxSamples, dictSize)
+}
+
+// EnableMutationLog appends all mutations of the datastore to a log file before they are
+// applied, so a restored snapshot can be recovered to a point in time by replaying the
+// log.  If sync is true, the log is synced to disk after each mutation.  It must be
+// enabled before any other storage wrappers so the logged values are those stored.
+func EnableMutationLog(path string, sync bool) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable mutation log before storage manager is initialized")
+	}
+	if manager.mutationLog != nil {
+		return fmt.Errorf("Mutation log already enabled to %s", manager.mutationLog.path)
+	}
+	if manager.replicated != nil {
+		return fmt.Errorf("Mutation log can't be enabled for a read-only replica")
+	}
+	if manager.encryptor != nil || manager.dictionaries != nil || manager.checksumRepairer != nil || manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Mutation log must be enabled before other storage wrappers")
+	}
+	if manager.metadata != manager.smalldata || manager.smalldata != manager.bigdata {
+		return fmt.Errorf("Mutation log requires all storage tiers to use one database")
+	}
+	mlog, err := openMutationLog(path, sync)
+	if err != nil {
+		return fmt.Errorf("Unable to open mutation log: %s", err.Error())
+	}
+	logger := &mutationLogger{manager.metadata, mlog}
+
+	// Graph writes go through the log as well.
+	graphEngine, err := NewGraphStore(logger)
+	if err != nil {
+		mlog.close()
+		return err
+	}
+	manager.graphEngine = graphEngine
+	manager.graphDB = graphEngine.(GraphDB)
+	manager.graphSetter = graphEngine.(GraphSetter)
+	manager.graphGetter = graphEngine.(GraphGetter)
+
+	manager.mutationLog = mlog
+	manager.metadata = logger
+	manager.smalldata = logger
+	manager.bigdata = logger
+	dvid.Infof("Enabled mutation log: %s (sync %t, continuing after mutation %d)\n", logger, sync, mlog.seq)
+	return nil
+}
+
+// ReplayMutationLog applies mutations from a log file to the datastore, which should be
+// a restored snapshot taken after the log was started, recovering the datastore as of the
+// given time.  All mutations are applied if the time is zero.  The server should be
+// restarted after replay so repo metadata is reloaded.
+func ReplayMutationLog(path string, until time.Time) (*ReplayStats, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't replay mutations before storage manager is initialized")
+	}
+	if manager.mutationLog != nil && manager.mutationLog.path == path {
+		return nil, fmt.Errorf("Can't replay the mutation log currently being written")
+	}
+	// Logged values are those stored locally beneath any cache, copy-on-write, or
+	// encryption, and replayed values aren't logged again.
+	db := baseDB(manager.metadata)
+	if cluster, ok := db.(*clusterDB); ok {
+		db = cluster.OrderedKeyValueDB
+	}
+	if logger, ok := db.(*mutationLogger); ok {
+		db = logger.OrderedKeyValueDB
+	}
+	return replayMutations(db, path, until)
+}
+
+// EnableCluster partitions data across a cluster of servers, each identified by its
+// URL.  Data keys are assigned to the given live members and this server by consistent
+// hashing, while all other keys are held by the coordinator.  Other members are reached
+// through peers returned by the given function.  It must be enabled before any storage
+// wrappers except the mutation log, which then logs only mutations of local keys.
+func EnableCluster(self, coordinator string, members []string, peer func(member string) ClusterPeer) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable cluster before storage manager is initialized")
+	}
+	if manager.cluster != nil {
+		return fmt.Errorf("Cluster already enabled for %s", manager.cluster)
+	}
+	if manager.replicated != nil {
+		return fmt.Errorf("Cluster can't be enabled for a read-only replica")
+	}
+	if manager.encryptor != nil || manager.dictionaries != nil || manager.checksumRepairer != nil || manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Cluster must be enabled before storage wrappers other than the mutation log")
+	}
+	if manager.metadata != manager.smalldata || manager.smalldata != manager.bigdata {
+		return fmt.Errorf("Cluster requires all storage tiers to use one database")
+	}
+	if self == "" || coordinator == "" {
+		return fmt.Errorf("Cluster requires this server's and the coordinator's URLs")
+	}
+	cluster := newClusterDB(manager.metadata, self, coordinator, members, peer)
+
+	// Graph keys are held by the coordinator.
+	graphEngine, err := NewGraphStore(cluster)
+	if err != nil {
+		return err
+	}
+	manager.graphEngine = graphEngine
+	manager.graphDB = graphEngine.(GraphDB)
+	manager.graphSetter = graphEngine.(GraphSetter)
+	manager.graphGetter = graphEngine.(GraphGetter)
+
+	manager.cluster = cluster
+	manager.metadata = cluster
+	manager.smalldata = cluster
+	manager.bigdata = cluster
+	dvid.Infof("Enabled cluster: %s with coordinator %s\n", cluster, coordinator)
+	return nil
+}
+
+// SetClusterMembers sets the live members of the cluster other than this server, e.g.,
+// as members join or fail.
+func SetClusterMembers(members []string) {
+	if manager.cluster != nil {
+		manager.cluster.setMembers(members)
+	}
+}
+
+// ClusterOwner returns the cluster member holding a full key.
+func ClusterOwner(key []byte) (string, error) {
+	if manager.cluster == nil {
+		return "", fmt.Errorf("Cluster mode is not enabled")
+	}
+	return manager.cluster.owner(key), nil
+}
+
+// LocalClusterPeer returns access to the keys held by this cluster member, for serving
+// requests from other members.
+func LocalClusterPeer() (ClusterPeer, error) {
+	if manager.cluster == nil {
+		return nil, fmt.Errorf("Cluster mode is not enabled")
+	}
+	return localPeer{manager.cluster.OrderedKeyValueDB}, nil
+}
+
+// RebalanceCluster moves data held by this server but owned by other members, e.g.,
+// after members join, to its owners and returns the number of moved key-value pairs.
+func RebalanceCluster() (int, error) {
+	if manager.cluster == nil {
+		return 0, fmt.Errorf("Cluster mode is not enabled")
+	}
+	return manager.cluster.rebalance()
+}
+
+// EnableChecksumRepair verifies checksums of values read from the small and big data
+// stores, replacing corrupted values with good values from the replica.  The replica
+// should be a copy of the datastore, e.g., a backup, and is encrypted and compressed the
+// same way if encryption or value dictionaries are enabled.  It must be enabled after
+// encryption and value dictionaries but before any block cache or copy-on-write.
+func EnableChecksumRepair(replica OrderedKeyValueDB) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable checksum repair before storage manager is initialized")
+	}
+	if manager.checksumRepairer != nil {
+		return fmt.Errorf("Checksum repair already enabled for %s", manager.checksumRepairer)
+	}
+	if manager.blockCache != nil || manager.copyOnWrite != nil {
+		return fmt.Errorf("Checksum repair must be enabled before block cache or copy-on-write")
+	}
+	if manager.encryptor != nil {
+		var err error
+		if replica, err = newEncryptor(replica, manager.encryptor.provider); err != nil {
+			return err
+		}
+	}
+	if manager.dictionaries != nil {
+		var err error
+		if replica, err = newDictCompressor(replica, manager.dictionaries); err != nil {
+			return err
+		}
+	}
+	manager.replica = replica
+	repairer := newChecksumRepairer(manager.bigdata, replica)
+	manager.checksumRepairer = repairer
+	if manager.smalldata == manager.bigdata {
+		manager.smalldata = repairer
+	} else {
+		manager.smalldata = newChecksumRepairer(manager.smalldata, replica)
+	}
+	manager.bigdata = repairer
+	dvid.Infof("Enabled checksum repair: %s\n", repairer)
+	return nil
+}
+
+// VerifyInstance scans all values of a data instance for bad checksums, repairing them
+// from the replica if requested and checksum repair is enabled.
+func VerifyInstance(instanceID dvid.InstanceID, repair bool) (*ChecksumReport, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't verify data before storage manager is initialized")
+	}
+	if !instanceChecksummed(instanceID) {
+		return nil, fmt.Errorf("Data instance %d doesn't store checksums", instanceID)
+	}
+	if repair && manager.replica == nil {
+		return nil, fmt.Errorf("Can't repair data without a replica store")
+	}
+
+	// Scan stored values beneath any cache or copy-on-write, but after decryption.
+	dbs := []OrderedKeyValueDB{storedDB(manager.bigdata)}
+	if baseDB(manager.smalldata) != baseDB(manager.bigdata) {
+		dbs = append(dbs, storedDB(manager.smalldata))
+	}
+	report := &ChecksumReport{Instance: instanceID}
+	for _, db := range dbs {
+		if err := verifyInstance(db, manager.replica, instanceID, repair, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// EstimateInstance scans the stored key-value pairs of a data instance in all tiers,
+// counting those whose full keys are accepted by the filter, if any, without copying
+// any data.
+func EstimateInstance(instanceID dvid.InstanceID, filter func(key []byte) bool) (*TransferEstimate, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't estimate data before storage manager is initialized")
+	}
+	dbs := []OrderedKeyValueDB{storedDB(manager.bigdata)}
+	if baseDB(manager.smalldata) != baseDB(manager.bigdata) {
+		dbs = append(dbs, storedDB(manager.smalldata))
+	}
+	est := &TransferEstimate{Instance: instanceID}
+	for _, db := range dbs {
+		if err := estimateInstance(db, instanceID, filter, est); err != nil {
+			return est, err
+		}
+	}
+	return est, nil
+}
+
+// SummarizeInstance counts and checksums the key-value pairs of a data instance visible
+// through a context, which selects the version if the instance is versioned, in all
+// tiers.  Values are read beneath any cache or copy-on-write but after any decryption.
+// Graph data of the instance isn't included.
+func SummarizeInstance(ctx Context) (*ContentSummary, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't summarize data before storage manager is initialized")
+	}
+	dbs := []OrderedKeyValueDB{storedDB(manager.bigdata)}
+	if baseDB(manager.smalldata) != baseDB(manager.bigdata) {
+		dbs = append(dbs, storedDB(manager.smalldata))
+	}
+	summary := new(ContentSummary)
+	for _, db := range dbs {
+		if err := summarizeInstance(db, ctx, summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// storedDB returns the database beneath any cache, copy-on-write, or checksum repair
+// wrappers, which gives stored values after any decryption.
+func storedDB(db OrderedKeyValueDB) OrderedKeyValueDB {
+	for {
+		switch wrapper := db.(type) {
+		case *blockCache:
+			db = wrapper.OrderedKeyValueDB
+		case *copyOnWrite:
+			db = wrapper.OrderedKeyValueDB
+		case *checksumRepairer:
+			db = wrapper.OrderedKeyValueDB
+		default:
+			return db
+		}
+	}
+}
+
+// EnableCopyOnWrite makes versioned writes to the big data store copy-on-write, so
+// values identical to those inherited from ancestor versions aren't duplicated.
+func EnableCopyOnWrite() error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable copy-on-write before storage manager is initialized")
+	}
+	if manager.copyOnWrite != nil {
+		return fmt.Errorf("Copy-on-write already enabled for %s", manager.copyOnWrite)
+	}
+	cow, err := newCopyOnWrite(manager.bigdata)
+	if err != nil {
+		return err
+	}
+	manager.copyOnWrite = cow
+	manager.bigdata = cow
+	dvid.Infof("Enabled copy-on-write versions: %s\n", cow)
+	return nil
+}
+
+// CopyOnWriteStatistics returns copy-on-write statistics or false if it isn't enabled.
+func CopyOnWriteStatistics() (CopyOnWriteStats, bool) {
+	if manager.copyOnWrite == nil {
+		return CopyOnWriteStats{}, false
+	}
+	return manager.copyOnWrite.Stats(), true
+}
+
+// EnableWriteDurability syncs the storage engines of the small and big data stores after
+// writes as required by their durability.  Writes that don't request a durability and
+// whose data instance has none use the given default, which can't be DurabilityDefault.
+// Periodic writes are synced at the given interval.
+func EnableWriteDurability(defaultLevel Durability, interval time.Duration) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable write durability before storage manager is initialized")
+	}
+	if manager.durability != nil {
+		return fmt.Errorf("Write durability already enabled for %s", manager.durability[0])
+	}
+	if defaultLevel == DurabilityDefault {
+		return fmt.Errorf("Default write durability must be sync, periodic, or async")
+	}
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	big, err := newDurableDB(manager.bigdata)
+	if err != nil {
+		return err
+	}
+	durables := []*durableDB{big}
+	if manager.smalldata == manager.bigdata {
+		manager.smalldata = big
+	} else {
+		small, err := newDurableDB(manager.smalldata)
+		if err != nil {
+			return err
+		}
+		manager.smalldata = small
+		durables = append(durables, small)
+	}
+	manager.bigdata = big
+	manager.durability = durables
+
+	durabilityMu.Lock()
+	durabilityEnabled = true
+	serverDurability = defaultLevel
+	durabilityMu.Unlock()
+	dvid.Infof("Enabled write durability with default %s, syncing periodic writes every %s: %s\n",
+		defaultLevel, interval, big)
+
+	go func() {
+		for range time.Tick(interval) {
+			for _, db := range durables {
+				if err := db.syncPeriodic(); err != nil {
+					dvid.Errorf("Unable to sync %s: %s\n", db, err.Error())
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// writableMetadata returns the metadata store that can be modified, which is the
+// replicated database for a read-only replica.
+func writableMetadata() MetaDataStorer {
+	if manager.replicated != nil {
+		return manager.replicated
+	}
+	return manager.metadata
+}
+
+// EnableUsageTracking tallies writes to the small and big data stores so the cached
+// storage usage report stays current between scans.  It should follow all other storage
+// wrappers so each write is tallied once with the size given by the caller.
+func EnableUsageTracking() error {
+	if !manager.setup {
+		return fmt.Errorf("Can't enable usage tracking before storage manager is initialized")
+	}
+	if manager.usageTracker != nil {
+		return fmt.Errorf("Usage tracking already enabled for %s", manager.usageTracker)
+	}
+	if manager.replicated != nil {
+		return fmt.Errorf("Usage tracking can't be enabled for a read-only replica")
+	}
+	for _, db := range []OrderedKeyValueDB{manager.smalldata, manager.bigdata} {
+		if _, ok := db.(KeyValueBatcher); !ok {
+			return fmt.Errorf("Usage tracking requires a database that supports batches, %q does not", db)
+		}
+	}
+	usage.Lock()
+	err := usage.load(manager.metadata)
+	usage.Unlock()
+	if err != nil {
+		return fmt.Errorf("Unable to load storage usage report: %s", err.Error())
+	}
+	tracker := &usageTracker{manager.bigdata}
+	if manager.smalldata == manager.bigdata {
+		manager.smalldata = tracker
+	} else {
+		manager.smalldata = &usageTracker{manager.smalldata}
+	}
+	manager.bigdata = tracker
+	manager.usageTracker = tracker
+	dvid.Infof("Enabled usage tracking: %s\n", tracker)
+
+	// Periodically persist the report as writes are tallied.
+	go func() {
+		for range time.Tick(usageSaveInterval) {
+			if err := usage.save(manager.metadata); err != nil {
+				dvid.Errorf("Unable to save storage usage report: %s\n", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// StorageUsage returns the storage used by each data instance and version as of the last
+// scan, plus any writes since then if usage tracking is enabled.  A scan is made first if
+// requested, which reads all data keys of the small and big data stores.
+func StorageUsage(scan bool) (*UsageReport, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't report storage usage before storage manager is initialized")
+	}
+	var report *UsageReport
+	var err error
+	if scan {
+		// Scan beneath any wrappers so the bytes are those stored.
+		dbs := []OrderedKeyValueDB{baseDB(manager.bigdata)}
+		if baseDB(manager.smalldata) != dbs[0] {
+			dbs = append(dbs, baseDB(manager.smalldata))
+		}
+		report, err = computeUsage(writableMetadata(), dbs)
+	} else {
+		report, err = cachedUsage(writableMetadata())
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Tracking = manager.usageTracker != nil
+	return report, nil
+}
+
+// UsageBytes returns the total bytes stored by the given data instances as of the last
+// usage scan, plus bytes written since then if usage tracking is enabled.  Rewritten
+// values are counted again and deletions aren't subtracted, so it can overestimate the
+// storage used until the next scan.
+func UsageBytes(instances []dvid.InstanceID) (uint64, error) {
+	if !manager.setup {
+		return 0, fmt.Errorf("Can't report storage usage before storage manager is initialized")
+	}
+	usage.Lock()
+	defer usage.Unlock()
+	if err := usage.load(writableMetadata()); err != nil {
+		return 0, err
+	}
+	return usage.bytes(instances), nil
+}
+
+// engineDBs returns the distinct storage engines beneath all wrappers of the metadata,
+// small and big data stores.
+func engineDBs() []OrderedKeyValueDB {
+	var dbs []OrderedKeyValueDB
+	for _, db := range []OrderedKeyValueDB{manager.metadata, manager.smalldata, manager.bigdata} {
+		db = engineDB(db)
+		found := false
+		for _, prev := range dbs {
+			if prev == db {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+// engineDB returns the storage engine beneath any wrappers, including the mutation
+// log, replica, and cluster wrappers that baseDB keeps.
+func engineDB(db OrderedKeyValueDB) OrderedKeyValueDB {
+	for {
+		db = baseDB(db)
+		switch wrapper := db.(type) {
+		case *mutationLogger:
+			db = wrapper.OrderedKeyValueDB
+		case *readOnlyDB:
+			db = wrapper.OrderedKeyValueDB
+		case *clusterDB:
+			db = wrapper.OrderedKeyValueDB
+		default:
+			return db
+		}
+	}
+}
+
+var (
+	// compactionMu serializes compactions.
+	compactionMu sync.Mutex
+
+	scheduleMu         sync.Mutex
+	compactionSchedule *CompactionSchedule
+	compactionStop     chan struct{}
+)
+
+// CompactStore compacts all keys of the storage engines that support compaction, which
+// can take a long time for a large datastore.
+func CompactStore() error {
+	return compactRange(nil, nil)
+}
+
+// CompactInstance compacts the keys of a data instance, e.g., to reclaim the space of a
+// deleted instance.
+func CompactInstance(instanceID dvid.InstanceID) error {
+	minKey, maxKey := DataContextKeyRange(instanceID)
+	return compactRange(minKey, maxKey)
+}
+
+func compactRange(kStart, kEnd []byte) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't compact storage before storage manager is initialized")
+	}
+	cs, err := compacters(engineDBs())
+	if err != nil {
+		return err
+	}
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+	for _, c := range cs {
+		timedLog := dvid.NewTimeLog()
+		if err := c.CompactRange(kStart, kEnd); err != nil {
+			return fmt.Errorf("Unable to compact %s: %s", c, err.Error())
+		}
+		timedLog.Infof("Compacted %s from key %x to %x", c, kStart, kEnd)
+	}
+	return nil
+}
+
+// CompactionStatistics returns the table statistics by level of each storage engine
+// that supports compaction.
+func CompactionStatistics() ([]CompactionStats, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't get compaction statistics before storage manager is initialized")
+	}
+	cs, err := compacters(engineDBs())
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]CompactionStats, len(cs))
+	for i, c := range cs {
+		levels, err := c.LevelStats()
+		if err != nil {
+			return nil, err
+		}
+		stats[i] = CompactionStats{Engine: fmt.Sprintf("%s", c), Levels: levels}
+	}
+	return stats, nil
+}
+
+// ScheduleCompaction periodically compacts all storage according to a schedule, which
+// replaces any prior schedule.  A nil schedule stops scheduled compactions.
+func ScheduleCompaction(schedule *CompactionSchedule) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't schedule compaction before storage manager is initialized")
+	}
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	if compactionStop != nil {
+		close(compactionStop)
+		compactionStop = nil
+	}
+	compactionSchedule = schedule
+	if schedule == nil {
+		dvid.Infof("Stopped scheduled compactions\n")
+		return nil
+	}
+	stop := make(chan struct{})
+	compactionStop = stop
+	dvid.Infof("Scheduled compactions %s\n", schedule)
+
+	go func() {
+		for {
+			timer := time.NewTimer(schedule.Next(time.Now()).Sub(time.Now()))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if err := CompactStore(); err != nil {
+				dvid.Errorf("Scheduled compaction failed: %s\n", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// ScheduledCompaction returns the compaction schedule or nil if none is scheduled.
+func ScheduledCompaction() *CompactionSchedule {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	return compactionSchedule
+}
+
 // EnginesAvailable returns a description of the available storage engines.
 func EnginesAvailable() string {
 	return strings.Join(manager.enginesAvail, "; ")
@@ -67,6 +897,29 @@ func EnginesAvailable() string {
 // Shutdown handles any storage-specific shutdown procedures.
 func Shutdown() {
 	// Place to be put any storage engine shutdown code.
+	if manager.blockCache != nil {
+		if err := saveHotReads(writableMetadata(), manager.blockCache); err != nil {
+			dvid.Errorf("Unable to save hot reads for cache warmup: %s\n", err.Error())
+		}
+	}
+	if manager.usageTracker != nil {
+		if err := usage.save(manager.metadata); err != nil {
+			dvid.Errorf("Unable to save storage usage report: %s\n", err.Error())
+		}
+	}
+	for _, db := range manager.durability {
+		if err := db.syncPeriodic(); err != nil {
+			dvid.Errorf("Unable to sync %s: %s\n", db, err.Error())
+		}
+	}
+	if manager.mutationLog != nil {
+		if err := manager.mutationLog.close(); err != nil {
+			dvid.Errorf("Unable to close mutation log: %s\n", err.Error())
+		}
+	}
+	if manager.bigdataEngine != nil {
+		manager.bigdataEngine.Close()
+	}
 }
 
 // Initialize the storage systems given a configuration, path to datastore.  Unlike cluster
@@ -103,6 +956,7 @@ func Initialize(kvEngine Engine, description string) error {
 	manager.metadata = kvDB
 	manager.smalldata = kvDB
 	manager.bigdata = kvDB
+	manager.copyOnWrite = nil
 
 	manager.enginesAvail = append(manager.enginesAvail, description)
 
@@ -110,6 +964,69 @@ func Initialize(kvEngine Engine, description string) error {
 	return nil
 }
 
+// CollectGarbage scans the data key-value pairs of all storage tiers and deletes those
+// that aren't live, e.g., data of deleted instances or versions.  Use a dry run to only
+// report what could be reclaimed.
+func CollectGarbage(live LiveFunc, opts GCOptions) (*GCStats, error) {
+	if !manager.setup {
+		return nil, fmt.Errorf("Can't collect garbage before storage manager is initialized")
+	}
+
+	// Scan the big data store through any wrappers so cached values are invalidated,
+	// and the small data store only if it doesn't share the underlying database.
+	dbs := []OrderedKeyValueDB{manager.bigdata}
+	if baseDB(manager.smalldata) != baseDB(manager.bigdata) {
+		dbs = append(dbs, manager.smalldata)
+	}
+	stats := newGCStats(opts.DryRun)
+	for _, db := range dbs {
+		if err := collectGarbage(db, live, opts, stats); err != nil {
+			return stats, err
+		}
+	}
+	if opts.DryRun {
+		return stats, nil
+	}
+
+	// Release blobs referenced by keys of orphaned versions.
+	blobs, err := NewBlobStore(manager.bigdata)
+	if err != nil {
+		return stats, err
+	}
+	minKey := []byte{dataKeyPrefix}
+	maxKey := []byte{dataKeyPrefix, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	stats.BlobRefsReleased, err = blobs.releaseRange(minKey, maxKey, func(key []byte) bool {
+		instanceID, versionID, err := KeyToLocalIDs(key)
+		return err == nil && !live(instanceID, versionID)
+	})
+	return stats, err
+}
+
+// baseDB returns the database beneath any cache, copy-on-write, checksum repair,
+// dictionary compression, encryption, usage tracking, or write durability wrappers.
+func baseDB(db OrderedKeyValueDB) OrderedKeyValueDB {
+	for {
+		switch wrapper := db.(type) {
+		case *blockCache:
+			db = wrapper.OrderedKeyValueDB
+		case *copyOnWrite:
+			db = wrapper.OrderedKeyValueDB
+		case *checksumRepairer:
+			db = wrapper.OrderedKeyValueDB
+		case *encryptor:
+			db = wrapper.OrderedKeyValueDB
+		case *dictCompressor:
+			db = wrapper.OrderedKeyValueDB
+		case *usageTracker:
+			db = wrapper.OrderedKeyValueDB
+		case *durableDB:
+			db = wrapper.OrderedKeyValueDB
+		default:
+			return db
+		}
+	}
+}
+
 // DeleteDataInstance removes all data context key-value pairs from all tiers of storage.
 func DeleteDataInstance(instanceID dvid.InstanceID) error {
 	if !manager.setup {
@@ -118,16 +1035,42 @@ func DeleteDataInstance(instanceID dvid.InstanceID) error {
 
 	// Determine all database tiers that are distinct.
 	dbs := []OrderedKeyValueDB{manager.smalldata}
-	if manager.smalldata != manager.bigdata {
+	if manager.blockCache != nil {
+		if baseDB(manager.smalldata) != baseDB(manager.blockCache.OrderedKeyValueDB) {
+			dbs = append(dbs, manager.blockCache.OrderedKeyValueDB)
+		}
+		minKey, maxKey := DataContextKeyRange(instanceID)
+		defer manager.blockCache.invalidate(nil, minKey, maxKey)
+	} else if manager.smalldata != manager.bigdata {
 		dbs = append(dbs, manager.bigdata)
 	}
 
+	// Release blobs referenced by the instance, which are kept in the big data store.
+	blobs, err := NewBlobStore(manager.bigdata)
+	if err != nil {
+		return err
+	}
+	minKey, maxKey := DataContextKeyRange(instanceID)
+	if err := blobs.ReleaseRange(minKey, maxKey); err != nil {
+		return err
+	}
+
 	// For each storage tier, remove all key-values with the given instance id.
 	for _, db := range dbs {
-		minKey, maxKey := DataContextKeyRange(instanceID)
 		if err := db.DeleteRange(nil, minKey, maxKey); err != nil {
 			return err
 		}
 	}
+	usage.forget(instanceID)
 	return nil
 }
+
+// DeleteGraphInstance removes all graph key-value pairs of a data instance, which are
+// kept in their own partition of the underlying database.
+func DeleteGraphInstance(instanceID dvid.InstanceID) error {
+	if !manager.setup {
+		return fmt.Errorf("Can't delete graph of data instance %d before storage manager is initialized", instanceID)
+	}
+	minKey, maxKey := GraphContextKeyRange(instanceID)
+	return baseDB(manager.smalldata).DeleteRange(nil, minKey, maxKey)
+}
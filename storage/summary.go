@@ -0,0 +1,87 @@
+/*
+	This file summarizes the content of a data instance as seen from a version, e.g., for
+	a release manifest that lets third parties verify a mirrored copy.  The checksum
+	covers type-specific indices and values but not local instance or version IDs, so
+	copies on servers with different local IDs have the same checksum.
+*/
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// ContentSummary gives the number and size of a data instance's key-value pairs and a
+// checksum of their content.  The checksum is the sum modulo 2^256 of the SHA-256
+// digests of each pair, so it doesn't depend on scan order or on how pairs are split
+// across storage tiers.
+type ContentSummary struct {
+	Keys       uint64
+	IndexBytes uint64
+	ValueBytes uint64
+	Checksum   string
+
+	sum contentSum
+}
+
+func (s *ContentSummary) String() string {
+	return fmt.Sprintf("%d keys, %s (indices %s, values %s), checksum %s", s.Keys,
+		humanBytes(s.IndexBytes+s.ValueBytes), humanBytes(s.IndexBytes), humanBytes(s.ValueBytes), s.Checksum)
+}
+
+// add includes a key-value pair given its type-specific index.
+func (s *ContentSummary) add(index, value []byte) {
+	s.Keys++
+	s.IndexBytes += uint64(len(index))
+	s.ValueBytes += uint64(len(value))
+	s.sum.add(index, value)
+}
+
+// contentSum is a 256-bit big-endian sum of key-value pair digests.
+type contentSum [sha256.Size]byte
+
+func (s *contentSum) add(index, value []byte) {
+	h := sha256.New()
+	var indexLen [4]byte
+	binary.BigEndian.PutUint32(indexLen[:], uint32(len(index)))
+	h.Write(indexLen[:])
+	h.Write(index)
+	h.Write(value)
+	digest := h.Sum(nil)
+	var carry uint16
+	for i := len(s) - 1; i >= 0; i-- {
+		total := uint16(s[i]) + uint16(digest[i]) + carry
+		s[i] = byte(total)
+		carry = total >> 8
+	}
+}
+
+func (s contentSum) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// summarizeInstance adds all key-value pairs of a data instance visible through a
+// context in a database to the summary.  Type-specific indices begin with a key type
+// byte, so the scanned index range covers all of them.
+func summarizeInstance(db OrderedKeyValueDB, ctx Context, summary *ContentSummary) error {
+	var indexErr error
+	err := db.ProcessRange(ctx, []byte{0}, []byte{0xff}, &ChunkOp{}, func(chunk *Chunk) {
+		if chunk == nil || chunk.KeyValue == nil || indexErr != nil {
+			return
+		}
+		index, err := ctx.IndexFromKey(chunk.K)
+		if err != nil {
+			indexErr = err
+			return
+		}
+		summary.add(index, chunk.V)
+	})
+	if err != nil {
+		return err
+	}
+	summary.Checksum = summary.sum.String()
+	return indexErr
+}
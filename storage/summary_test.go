@@ -0,0 +1,47 @@
+package storage
+
+import "testing"
+
+func TestSummarizeInstance(t *testing.T) {
+	db := newMemoryDB()
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 31)
+	other := GetTestDataContext(TestUUID1, "labels", 32)
+	db.Put(ctx, []byte{1}, []byte("block"))
+	db.Put(ctx, []byte{2, 0}, []byte("bigger block"))
+	db.Put(other, []byte{1}, []byte("not counted"))
+
+	summary := new(ContentSummary)
+	if err := summarizeInstance(db, ctx, summary); err != nil {
+		t.Fatalf("Error summarizing instance: %s\n", err.Error())
+	}
+	if summary.Keys != 2 || summary.IndexBytes != 3 || summary.ValueBytes != 17 {
+		t.Errorf("Bad summary: %s\n", summary)
+	}
+
+	// Pairs split across tiers and instances with other local IDs give the same checksum.
+	small, big := newMemoryDB(), newMemoryDB()
+	copied := GetTestDataContext(TestUUID1, "grayscale", 45)
+	small.Put(copied, []byte{2, 0}, []byte("bigger block"))
+	big.Put(copied, []byte{1}, []byte("block"))
+	split := new(ContentSummary)
+	for _, tier := range []*memoryDB{small, big} {
+		if err := summarizeInstance(tier, copied, split); err != nil {
+			t.Fatalf("Error summarizing instance: %s\n", err.Error())
+		}
+	}
+	if split.Checksum != summary.Checksum || split.Keys != summary.Keys {
+		t.Errorf("Expected checksum %s for split copy, got %s\n", summary.Checksum, split.Checksum)
+	}
+
+	// Changing a value changes the checksum.
+	big.Put(copied, []byte{1}, []byte("blocc"))
+	changed := new(ContentSummary)
+	for _, tier := range []*memoryDB{small, big} {
+		if err := summarizeInstance(tier, copied, changed); err != nil {
+			t.Fatalf("Error summarizing instance: %s\n", err.Error())
+		}
+	}
+	if changed.Checksum == summary.Checksum {
+		t.Errorf("Expected checksum to change with modified value\n")
+	}
+}
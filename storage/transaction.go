@@ -0,0 +1,244 @@
+/*
+	This file supports transactions, which group writes that may use different contexts
+	so they are committed or rolled back together.  Stores that implement Transactioner,
+	e.g., the leveldb and badger engines, apply a transaction in one atomic write.  Stores
+	that wrap another store, e.g., to cache, encrypt, or log writes, pass transactions
+	through to the wrapped store.  Other stores can't apply transactions atomically, so
+	NewTransaction returns ErrNoTransactions for them.
+*/
+
+package storage
+
+import "errors"
+
+// ErrTransactionDone is returned when committing a transaction that was already
+// committed or rolled back.
+var ErrTransactionDone = errors.New("transaction has already been committed or rolled back")
+
+// ErrNoTransactions is returned by NewTransaction for stores that can't apply writes
+// using different contexts atomically.
+var ErrNoTransactions = errors.New("store can't apply transactions atomically")
+
+// batchWrapper is implemented by stores that wrap another store and build their batches
+// on top of batches of the wrapped store, so transactions can pass through them.
+type batchWrapper interface {
+	// wrappedStore returns the store being wrapped.
+	wrappedStore() OrderedKeyValueSetter
+
+	// wrapBatch returns a batch for the given context that writes through a batch of
+	// the wrapped store made by newBatch.
+	wrapBatch(ctx Context, newBatch func(Context) Batch) Batch
+}
+
+// NewTransaction returns a transaction on the given store, or ErrNoTransactions if the
+// store can't apply it atomically.
+func NewTransaction(db OrderedKeyValueSetter) (Transaction, error) {
+	switch s := db.(type) {
+	case Transactioner:
+		return s.NewTransaction(), nil
+	case batchWrapper:
+		txn, err := NewTransaction(s.wrappedStore())
+		if err != nil {
+			return nil, err
+		}
+		return &wrappedTransaction{wrapper: s, txn: txn, byContext: make(map[string]Batch)}, nil
+	}
+	return nil, ErrNoTransactions
+}
+
+// NewBatchTransaction returns a transaction like NewTransaction if the store supports
+// them, and otherwise a transaction that commits one batch per context, which is only
+// atomic for each context.  It should only be used for writes that tolerate that, e.g.,
+// writes that all use one context.
+func NewBatchTransaction(db OrderedKeyValueSetter) (Transaction, error) {
+	txn, err := NewTransaction(db)
+	if err != ErrNoTransactions {
+		return txn, err
+	}
+	batcher, ok := db.(KeyValueBatcher)
+	if !ok {
+		return nil, errors.New("store doesn't support batch operations required by transactions")
+	}
+	return &batchTransaction{batcher: batcher}, nil
+}
+
+// contextName identifies a context when grouping operations by context, since contexts
+// aren't necessarily comparable.
+func contextName(ctx Context) string {
+	if ctx == nil {
+		return ""
+	}
+	return ctx.String()
+}
+
+// wrappedTransaction writes through the batches of a wrapping store into a transaction
+// of the wrapped store.  On commit, each wrapper batch is committed in turn, with the
+// commit of its underlying batch continuing to the next wrapper batch and the last one
+// committing the wrapped transaction.  So the work each wrapper does before and after
+// committing, e.g., logging or cache invalidation, surrounds one atomic commit.
+type wrappedTransaction struct {
+	wrapper   batchWrapper
+	txn       Transaction
+	batches   []wrappedBatch
+	byContext map[string]Batch
+	committed bool
+	done      bool
+}
+
+// wrappedBatch is a batch of the wrapping store and its underlying batch, if any.
+type wrappedBatch struct {
+	batch  Batch
+	staged *txnBatch
+}
+
+// txnBatch is the underlying batch of a wrapper batch, which adds its operations to the
+// wrapped transaction.
+type txnBatch struct {
+	ctx  Context
+	txn  Transaction
+	next func() error
+}
+
+func (b *txnBatch) Put(k, v []byte) {
+	b.txn.Put(b.ctx, k, v)
+}
+
+func (b *txnBatch) Delete(k []byte) {
+	b.txn.Delete(b.ctx, k)
+}
+
+func (b *txnBatch) Commit() error {
+	if b.next == nil {
+		return ErrTransactionDone
+	}
+	next := b.next
+	b.next = nil
+	return next()
+}
+
+func (t *wrappedTransaction) batch(ctx Context) Batch {
+	name := contextName(ctx)
+	batch, found := t.byContext[name]
+	if !found {
+		var staged *txnBatch
+		batch = t.wrapper.wrapBatch(ctx, func(ctx Context) Batch {
+			staged = &txnBatch{ctx: ctx, txn: t.txn}
+			return staged
+		})
+		t.byContext[name] = batch
+		t.batches = append(t.batches, wrappedBatch{batch, staged})
+	}
+	return batch
+}
+
+func (t *wrappedTransaction) Put(ctx Context, k, v []byte) {
+	if !t.done {
+		t.batch(ctx).Put(k, v)
+	}
+}
+
+func (t *wrappedTransaction) Delete(ctx Context, k []byte) {
+	if !t.done {
+		t.batch(ctx).Delete(k)
+	}
+}
+
+func (t *wrappedTransaction) Commit() error {
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.done = true
+	commit := func() error {
+		t.committed = true
+		return t.txn.Commit()
+	}
+	for i := len(t.batches) - 1; i >= 0; i-- {
+		wb, next := t.batches[i], commit
+		if wb.staged == nil {
+			commit = func() error {
+				if err := wb.batch.Commit(); err != nil {
+					return err
+				}
+				return next()
+			}
+			continue
+		}
+		wb.staged.next = next
+		commit = wb.batch.Commit
+	}
+	err := commit()
+	if !t.committed {
+		// A wrapper batch failed before the wrapped transaction was reached.
+		t.txn.Rollback()
+	}
+	return err
+}
+
+func (t *wrappedTransaction) Rollback() {
+	if !t.done {
+		t.done = true
+		t.txn.Rollback()
+	}
+}
+
+type transactionOp struct {
+	ctx    Context
+	k, v   []byte
+	delete bool
+}
+
+// batchTransaction buffers operations until commit, when they are written using one
+// batch per context in the order each context was first used.
+type batchTransaction struct {
+	batcher KeyValueBatcher
+	ops     []transactionOp
+	done    bool
+}
+
+func (txn *batchTransaction) Put(ctx Context, k, v []byte) {
+	if !txn.done {
+		txn.ops = append(txn.ops, transactionOp{ctx: ctx, k: k, v: v})
+	}
+}
+
+func (txn *batchTransaction) Delete(ctx Context, k []byte) {
+	if !txn.done {
+		txn.ops = append(txn.ops, transactionOp{ctx: ctx, k: k, delete: true})
+	}
+}
+
+func (txn *batchTransaction) Commit() error {
+	if txn.done {
+		return ErrTransactionDone
+	}
+	txn.done = true
+
+	var batches []Batch
+	byContext := make(map[string]Batch)
+	for _, op := range txn.ops {
+		name := contextName(op.ctx)
+		batch, found := byContext[name]
+		if !found {
+			batch = txn.batcher.NewBatch(op.ctx)
+			byContext[name] = batch
+			batches = append(batches, batch)
+		}
+		if op.delete {
+			batch.Delete(op.k)
+		} else {
+			batch.Put(op.k, op.v)
+		}
+	}
+	txn.ops = nil
+	for _, batch := range batches {
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (txn *batchTransaction) Rollback() {
+	txn.done = true
+	txn.ops = nil
+}
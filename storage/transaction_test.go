@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// atomicMemoryDB is a memoryDB that applies transactions atomically.
+type atomicMemoryDB struct {
+	*memoryDB
+	fail bool // if true, transactions fail on commit without writing anything
+}
+
+type memoryTxn struct {
+	db   *atomicMemoryDB
+	ops  []transactionOp
+	done bool
+}
+
+func (db *atomicMemoryDB) NewTransaction() Transaction {
+	return &memoryTxn{db: db}
+}
+
+func (txn *memoryTxn) Put(ctx Context, k, v []byte) {
+	txn.ops = append(txn.ops, transactionOp{ctx: ctx, k: k, v: v})
+}
+
+func (txn *memoryTxn) Delete(ctx Context, k []byte) {
+	txn.ops = append(txn.ops, transactionOp{ctx: ctx, k: k, delete: true})
+}
+
+func (txn *memoryTxn) Commit() error {
+	if txn.done {
+		return ErrTransactionDone
+	}
+	txn.done = true
+	if txn.db.fail {
+		return errors.New("commit failed")
+	}
+	for _, op := range txn.ops {
+		if op.delete {
+			txn.db.Delete(op.ctx, op.k)
+		} else {
+			txn.db.Put(op.ctx, op.k, op.v)
+		}
+	}
+	return nil
+}
+
+func (txn *memoryTxn) Rollback() {
+	txn.done = true
+}
+
+func TestBatchTransaction(t *testing.T) {
+	db := newMemoryDB()
+	ctx := GetTestDataContext(TestUUID1, "labels", 1)
+	otherCtx := GetTestDataContext(TestUUID1, "bodies", 2)
+
+	if _, err := NewTransaction(db); err != ErrNoTransactions {
+		t.Fatalf("Expected ErrNoTransactions for store without transactions, got %v\n", err)
+	}
+	txn, err := NewBatchTransaction(db)
+	if err != nil {
+		t.Fatalf("Can't create transaction: %s\n", err.Error())
+	}
+	txn.Put(ctx, []byte{1}, []byte("forward"))
+	txn.Put(otherCtx, []byte{1}, []byte("inverse"))
+	txn.Delete(ctx, []byte{2})
+	if len(db.kv) != 0 {
+		t.Fatalf("Transaction wrote %d key-values before commit\n", len(db.kv))
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %s\n", err.Error())
+	}
+	for _, c := range []Context{ctx, otherCtx} {
+		if value, _ := db.Get(c, []byte{1}); value == nil {
+			t.Errorf("Expected committed value for %s\n", c)
+		}
+	}
+	txn.Rollback()
+	if err := txn.Commit(); err != ErrTransactionDone {
+		t.Errorf("Expected ErrTransactionDone on second commit, got %v\n", err)
+	}
+
+	txn, _ = NewBatchTransaction(db)
+	txn.Put(ctx, []byte{3}, []byte("discarded"))
+	txn.Delete(otherCtx, []byte{1})
+	txn.Rollback()
+	if err := txn.Commit(); err != ErrTransactionDone {
+		t.Errorf("Expected ErrTransactionDone on commit after rollback, got %v\n", err)
+	}
+	if value, _ := db.Get(ctx, []byte{3}); value != nil {
+		t.Errorf("Rolled back put was written\n")
+	}
+	if value, _ := db.Get(otherCtx, []byte{1}); value == nil {
+		t.Errorf("Rolled back delete was applied\n")
+	}
+}
+
+func TestWrappedTransaction(t *testing.T) {
+	// Wrappers of stores without transactions can't provide them.
+	cache, err := newBlockCache(newMemoryDB(), 1000)
+	if err != nil {
+		t.Fatalf("Can't create block cache: %s\n", err.Error())
+	}
+	if _, err := NewTransaction(cache); err != ErrNoTransactions {
+		t.Errorf("Expected ErrNoTransactions for wrapper of store without transactions, got %v\n", err)
+	}
+
+	db := &atomicMemoryDB{memoryDB: newMemoryDB()}
+	enc, err := newEncryptor(db, staticKeyProvider{1, bytes.Repeat([]byte{7}, 32)})
+	if err != nil {
+		t.Fatalf("Can't create encryptor: %s\n", err.Error())
+	}
+	if cache, err = newBlockCache(enc, 1000); err != nil {
+		t.Fatalf("Can't create block cache: %s\n", err.Error())
+	}
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 21)
+	otherCtx := GetTestDataContext(TestUUID1, "labels", 22)
+	SetInstanceEncryption(21, true)
+	defer SetInstanceEncryption(21, false)
+
+	if err := cache.Put(ctx, []byte{1}, []byte("old")); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if v, err := cache.Get(ctx, []byte{1}); err != nil || string(v) != "old" {
+		t.Fatalf("Bad value %q: %v\n", v, err)
+	}
+
+	// A transaction passes through both wrappers and is applied on commit.
+	txn, err := NewTransaction(cache)
+	if err != nil {
+		t.Fatalf("Can't create transaction: %s\n", err.Error())
+	}
+	txn.Put(ctx, []byte{1}, []byte("new"))
+	txn.Put(otherCtx, []byte{1}, []byte("other"))
+	txn.Delete(otherCtx, []byte{2})
+	if len(db.kv) != 1 {
+		t.Fatalf("Transaction wrote to store before commit\n")
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %s\n", err.Error())
+	}
+	if v, err := cache.Get(ctx, []byte{1}); err != nil || string(v) != "new" {
+		t.Errorf("Expected cached value to be replaced after commit, got %q: %v\n", v, err)
+	}
+	if v, err := cache.Get(otherCtx, []byte{1}); err != nil || string(v) != "other" {
+		t.Errorf("Bad committed value %q: %v\n", v, err)
+	}
+	if stored := db.kv[string(ctx.ConstructKey([]byte{1}))]; !bytes.HasPrefix(stored, encryptedMagic) {
+		t.Errorf("Value written by transaction wasn't encrypted: %v\n", stored)
+	}
+	if err := txn.Commit(); err != ErrTransactionDone {
+		t.Errorf("Expected ErrTransactionDone on second commit, got %v\n", err)
+	}
+
+	// Nothing is written if the wrapped transaction fails or is rolled back.
+	db.fail = true
+	txn, _ = NewTransaction(cache)
+	txn.Put(ctx, []byte{1}, []byte("failed"))
+	txn.Put(otherCtx, []byte{3}, []byte("failed"))
+	if err := txn.Commit(); err == nil {
+		t.Errorf("Expected error from failed commit\n")
+	}
+	db.fail = false
+	txn2, _ := NewTransaction(cache)
+	txn2.Put(otherCtx, []byte{4}, []byte("discarded"))
+	txn2.Rollback()
+	if err := txn2.Commit(); err != ErrTransactionDone {
+		t.Errorf("Expected ErrTransactionDone on commit after rollback, got %v\n", err)
+	}
+	if len(db.kv) != 2 {
+		t.Errorf("Expected failed and rolled back transactions to write nothing, have %d keys\n", len(db.kv))
+	}
+	if v, _ := cache.Get(ctx, []byte{1}); string(v) != "new" {
+		t.Errorf("Expected value from before failed commit, got %q\n", v)
+	}
+}
@@ -0,0 +1,445 @@
+/*
+	This file supports reporting the storage used by each data instance and version so
+	admins can see which instances consume disk space.  A scan of the storage tiers tallies
+	the keys, bytes, and key extents of each instance and version, and the report is cached
+	in the metadata store.  If usage tracking is enabled, writes since the last scan are
+	tallied as well so the cached report stays current without rescanning the datastore.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// The metadata index of the cached usage report.
+var usageIndex = []byte{0xAB, 'u', 's', 'e'}
+
+// How often a usage report modified by tracked writes is saved.
+const usageSaveInterval = 5 * time.Minute
+
+// UsageCount gives a number of keys and the total bytes of the keys and their values.
+type UsageCount struct {
+	Keys  uint64
+	Bytes uint64
+}
+
+func (c UsageCount) String() string {
+	return fmt.Sprintf("%d keys, %s", c.Keys, humanBytes(c.Bytes))
+}
+
+// WriteCount tallies writes since the last usage scan.  Rewritten keys are counted again
+// and the bytes of deleted values aren't known without reading them, so only deleted
+// keys and ranges are counted.
+type WriteCount struct {
+	KeysPut       uint64
+	BytesPut      uint64
+	KeysDeleted   uint64
+	RangesDeleted uint64
+}
+
+func (c WriteCount) String() string {
+	return fmt.Sprintf("%d keys put (%s), %d keys and %d ranges deleted",
+		c.KeysPut, humanBytes(c.BytesPut), c.KeysDeleted, c.RangesDeleted)
+}
+
+// VersionUsage is the storage used by a data instance in one version.
+type VersionUsage struct {
+	Stored UsageCount
+	Writes WriteCount
+}
+
+// InstanceUsage is the storage used by a data instance across all versions.
+type InstanceUsage struct {
+	Stored UsageCount
+	Writes WriteCount
+
+	// MinKey and MaxKey give the extent of the instance's full keys, which is widened
+	// by tracked writes.
+	MinKey, MaxKey []byte
+
+	Versions map[dvid.VersionID]*VersionUsage
+}
+
+// UsageReport gives the storage used by each data instance as of the last scan, plus
+// any tracked writes since then.
+type UsageReport struct {
+	// Scanned is the time of the last scan, or zero if there has been none.
+	Scanned time.Time
+
+	// Updated is the time of the last scan or tracked write.
+	Updated time.Time
+
+	// Tracking is true if writes are currently being tallied.
+	Tracking bool
+
+	Instances map[dvid.InstanceID]*InstanceUsage
+}
+
+func newUsageReport() *UsageReport {
+	return &UsageReport{Instances: make(map[dvid.InstanceID]*InstanceUsage)}
+}
+
+// instance returns the usage of an instance and version, adding them if necessary.
+func (report *UsageReport) instance(instanceID dvid.InstanceID, versionID dvid.VersionID) (*InstanceUsage, *VersionUsage) {
+	inst, found := report.Instances[instanceID]
+	if !found {
+		inst = &InstanceUsage{Versions: make(map[dvid.VersionID]*VersionUsage)}
+		report.Instances[instanceID] = inst
+	}
+	version, found := inst.Versions[versionID]
+	if !found {
+		version = &VersionUsage{}
+		inst.Versions[versionID] = version
+	}
+	return inst, version
+}
+
+// extend widens the key extent of an instance to include the given full key.
+func (inst *InstanceUsage) extend(k []byte) {
+	if inst.MinKey == nil || bytes.Compare(k, inst.MinKey) < 0 {
+		inst.MinKey = append([]byte(nil), k...)
+	}
+	if inst.MaxKey == nil || bytes.Compare(k, inst.MaxKey) > 0 {
+		inst.MaxKey = append([]byte(nil), k...)
+	}
+}
+
+// addWrites adds the tracked writes and key extents of another report.
+func (report *UsageReport) addWrites(other *UsageReport) {
+	for instanceID, otherInst := range other.Instances {
+		var inst *InstanceUsage
+		for versionID, otherVersion := range otherInst.Versions {
+			var version *VersionUsage
+			inst, version = report.instance(instanceID, versionID)
+			version.Writes.add(otherVersion.Writes)
+		}
+		if inst == nil {
+			continue
+		}
+		inst.Writes.add(otherInst.Writes)
+		if otherInst.MinKey != nil {
+			inst.extend(otherInst.MinKey)
+			inst.extend(otherInst.MaxKey)
+		}
+	}
+	if other.Updated.After(report.Updated) {
+		report.Updated = other.Updated
+	}
+}
+
+func (c *WriteCount) add(other WriteCount) {
+	c.KeysPut += other.KeysPut
+	c.BytesPut += other.BytesPut
+	c.KeysDeleted += other.KeysDeleted
+	c.RangesDeleted += other.RangesDeleted
+}
+
+// copy returns a deep copy of the report.
+func (report *UsageReport) copy() *UsageReport {
+	dup := *report
+	dup.Instances = make(map[dvid.InstanceID]*InstanceUsage, len(report.Instances))
+	for instanceID, inst := range report.Instances {
+		instCopy := *inst
+		instCopy.Versions = make(map[dvid.VersionID]*VersionUsage, len(inst.Versions))
+		for versionID, version := range inst.Versions {
+			versionCopy := *version
+			instCopy.Versions[versionID] = &versionCopy
+		}
+		dup.Instances[instanceID] = &instCopy
+	}
+	return &dup
+}
+
+// usageTally holds the usage report cached in the metadata store.
+type usageTally struct {
+	sync.Mutex
+	report *UsageReport
+	dirty  bool
+}
+
+var usage usageTally
+
+// load reads any cached report from the metadata store if it hasn't been read.
+// Caller must hold the lock.
+func (t *usageTally) load(db MetaDataStorer) error {
+	if t.report != nil {
+		return nil
+	}
+	value, err := db.Get(NewMetadataContext(), usageIndex)
+	if err != nil {
+		return err
+	}
+	report := newUsageReport()
+	if value != nil {
+		if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(report); err != nil {
+			return err
+		}
+		if report.Instances == nil {
+			report.Instances = make(map[dvid.InstanceID]*InstanceUsage)
+		}
+	}
+	t.report = report
+	return nil
+}
+
+// save writes the report to the metadata store if it was modified.
+func (t *usageTally) save(db MetaDataStorer) error {
+	t.Lock()
+	if !t.dirty || t.report == nil {
+		t.Unlock()
+		return nil
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(t.report)
+	t.dirty = false
+	t.Unlock()
+	if err != nil {
+		return err
+	}
+	return db.Put(NewMetadataContext(), usageIndex, buf.Bytes())
+}
+
+// record applies a tracked write of a full key to the report.
+func (t *usageTally) record(k []byte, f func(*InstanceUsage, *VersionUsage)) {
+	if len(k) < 1+dvid.InstanceIDSize+dvid.VersionIDSize {
+		return
+	}
+	instanceID, versionID, err := KeyToLocalIDs(k)
+	if err != nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.report == nil {
+		return
+	}
+	inst, version := t.report.instance(instanceID, versionID)
+	f(inst, version)
+	t.report.Updated = time.Now()
+	t.dirty = true
+}
+
+func (t *usageTally) recordPut(k []byte, size int) {
+	t.record(k, func(inst *InstanceUsage, version *VersionUsage) {
+		inst.Writes.KeysPut++
+		inst.Writes.BytesPut += uint64(size)
+		version.Writes.KeysPut++
+		version.Writes.BytesPut += uint64(size)
+		inst.extend(k)
+	})
+}
+
+func (t *usageTally) recordDelete(k []byte) {
+	t.record(k, func(inst *InstanceUsage, version *VersionUsage) {
+		inst.Writes.KeysDeleted++
+		version.Writes.KeysDeleted++
+	})
+}
+
+func (t *usageTally) recordDeleteRange(k []byte) {
+	t.record(k, func(inst *InstanceUsage, version *VersionUsage) {
+		inst.Writes.RangesDeleted++
+		version.Writes.RangesDeleted++
+	})
+}
+
+// bytes returns the bytes stored by data instances as of the last scan plus the bytes
+// put since then.  Caller must hold the lock.
+func (t *usageTally) bytes(instances []dvid.InstanceID) uint64 {
+	var n uint64
+	for _, instanceID := range instances {
+		if inst, found := t.report.Instances[instanceID]; found {
+			n += inst.Stored.Bytes + inst.Writes.BytesPut
+		}
+	}
+	return n
+}
+
+// forget removes the usage of a deleted data instance.
+func (t *usageTally) forget(instanceID dvid.InstanceID) {
+	t.Lock()
+	defer t.Unlock()
+	if t.report == nil {
+		return
+	}
+	if _, found := t.report.Instances[instanceID]; found {
+		delete(t.report.Instances, instanceID)
+		t.dirty = true
+	}
+}
+
+// scanUsage tallies the data and graph keys of a store into the report.
+func scanUsage(db OrderedKeyValueDB, report *UsageReport) error {
+	for _, prefix := range []byte{dataKeyPrefix, graphKeyPrefix} {
+		minKey := []byte{prefix}
+		maxKey := []byte{prefix, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		err := db.ProcessRange(nil, minKey, maxKey, &ChunkOp{}, func(chunk *Chunk) {
+			if chunk == nil || chunk.KeyValue == nil || len(chunk.K) < 1+dvid.InstanceIDSize+dvid.VersionIDSize {
+				return
+			}
+			instanceID, versionID, err := KeyToLocalIDs(chunk.K)
+			if err != nil {
+				return
+			}
+			size := uint64(len(chunk.K) + len(chunk.V))
+			inst, version := report.instance(instanceID, versionID)
+			inst.Stored.Keys++
+			inst.Stored.Bytes += size
+			version.Stored.Keys++
+			version.Stored.Bytes += size
+			inst.extend(chunk.K)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedUsage returns a copy of the cached usage report.
+func cachedUsage(metadata MetaDataStorer) (*UsageReport, error) {
+	usage.Lock()
+	defer usage.Unlock()
+	if err := usage.load(metadata); err != nil {
+		return nil, err
+	}
+	return usage.report.copy(), nil
+}
+
+// computeUsage scans the given stores, replaces the cached usage report, and saves it.
+// Writes tallied during the scan are kept as writes since the scan, so they may also
+// be counted as stored.
+func computeUsage(metadata MetaDataStorer, dbs []OrderedKeyValueDB) (*UsageReport, error) {
+	usage.Lock()
+	if err := usage.load(metadata); err != nil {
+		usage.Unlock()
+		return nil, err
+	}
+	previous := usage.report
+	usage.report = newUsageReport()
+	usage.Unlock()
+
+	scanned := newUsageReport()
+	scanned.Scanned = time.Now()
+	scanned.Updated = scanned.Scanned
+	var err error
+	for _, db := range dbs {
+		if err = scanUsage(db, scanned); err != nil {
+			break
+		}
+	}
+
+	// Keep the previous report if the scan failed.
+	usage.Lock()
+	if err != nil {
+		previous.addWrites(usage.report)
+		usage.report = previous
+	} else {
+		scanned.addWrites(usage.report)
+		usage.report = scanned
+	}
+	usage.dirty = true
+	report := usage.report.copy()
+	usage.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return report, usage.save(metadata)
+}
+
+// usageTracker wraps an ordered key-value store and tallies writes of data keys.
+type usageTracker struct {
+	OrderedKeyValueDB
+}
+
+func (t *usageTracker) String() string {
+	return fmt.Sprintf("%s with usage tracking", t.OrderedKeyValueDB)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+func (t *usageTracker) Put(ctx Context, k, v []byte) error {
+	if err := t.OrderedKeyValueDB.Put(ctx, k, v); err != nil {
+		return err
+	}
+	key := logKey(ctx, k)
+	usage.recordPut(key, len(key)+len(v))
+	return nil
+}
+
+func (t *usageTracker) Delete(ctx Context, k []byte) error {
+	if err := t.OrderedKeyValueDB.Delete(ctx, k); err != nil {
+		return err
+	}
+	usage.recordDelete(logKey(ctx, k))
+	return nil
+}
+
+func (t *usageTracker) PutRange(ctx Context, values []KeyValue) error {
+	if err := t.OrderedKeyValueDB.PutRange(ctx, values); err != nil {
+		return err
+	}
+	for _, kv := range values {
+		key := logKey(ctx, kv.K)
+		usage.recordPut(key, len(key)+len(kv.V))
+	}
+	return nil
+}
+
+func (t *usageTracker) DeleteRange(ctx Context, kStart, kEnd []byte) error {
+	if err := t.OrderedKeyValueDB.DeleteRange(ctx, kStart, kEnd); err != nil {
+		return err
+	}
+	usage.recordDeleteRange(logKey(ctx, kStart))
+	return nil
+}
+
+// ---- KeyValueBatcher interface ------
+
+type usageBatch struct {
+	Batch
+	ctx Context
+	ops []KeyValue // nil value is a delete
+}
+
+func (t *usageTracker) NewBatch(ctx Context) Batch {
+	return t.wrapBatch(ctx, t.OrderedKeyValueDB.(KeyValueBatcher).NewBatch)
+}
+
+func (t *usageTracker) wrappedStore() OrderedKeyValueSetter { return t.OrderedKeyValueDB }
+
+func (t *usageTracker) wrapBatch(ctx Context, newBatch func(Context) Batch) Batch {
+	return &usageBatch{Batch: newBatch(ctx), ctx: ctx}
+}
+
+func (b *usageBatch) Put(k, v []byte) {
+	b.ops = append(b.ops, KeyValue{logKey(b.ctx, k), v})
+	b.Batch.Put(k, v)
+}
+
+func (b *usageBatch) Delete(k []byte) {
+	b.ops = append(b.ops, KeyValue{logKey(b.ctx, k), nil})
+	b.Batch.Delete(k)
+}
+
+// Commit tallies the writes of the batch once it is committed.
+func (b *usageBatch) Commit() error {
+	if err := b.Batch.Commit(); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		if op.V == nil {
+			usage.recordDelete(op.K)
+		} else {
+			usage.recordPut(op.K, len(op.K)+len(op.V))
+		}
+	}
+	return nil
+}
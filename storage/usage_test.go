@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestUsage(t *testing.T) {
+	db := newMemoryDB()
+	usage = usageTally{}
+	ctx1 := GetTestDataContext(TestUUID1, "grayscale", 1)
+	ctx2 := GetTestDataContext(TestUUID2, "labels", 2)
+	for i := byte(0); i < 4; i++ {
+		if err := db.Put(ctx1, []byte{i}, []byte{i, i}); err != nil {
+			t.Fatalf("Error on put: %s\n", err.Error())
+		}
+	}
+	if err := db.Put(ctx2, []byte{7}, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+	if err := db.Put(NewMetadataContext(), []byte{1}, []byte{1}); err != nil {
+		t.Fatalf("Error on put: %s\n", err.Error())
+	}
+
+	report, err := computeUsage(db, []OrderedKeyValueDB{db})
+	if err != nil {
+		t.Fatalf("Error computing usage: %s\n", err.Error())
+	}
+	if len(report.Instances) != 2 {
+		t.Fatalf("Expected usage of 2 instances, got %d\n", len(report.Instances))
+	}
+	keySize := uint64(len(ctx1.ConstructKey([]byte{0})))
+	inst := report.Instances[1]
+	if inst.Stored.Keys != 4 || inst.Stored.Bytes != 4*(keySize+2) {
+		t.Errorf("Bad usage of instance 1: %s\n", inst.Stored)
+	}
+	if string(inst.MinKey) != string(ctx1.ConstructKey([]byte{0})) || string(inst.MaxKey) != string(ctx1.ConstructKey([]byte{3})) {
+		t.Errorf("Bad key extent of instance 1: %x to %x\n", inst.MinKey, inst.MaxKey)
+	}
+	version := report.Instances[2].Versions[ctx2.VersionID()]
+	if version == nil || version.Stored.Keys != 1 || version.Stored.Bytes != keySize+3 {
+		t.Errorf("Bad usage of instance 2 version: %v\n", version)
+	}
+
+	// Tracked writes are added to the cached report.
+	tracker := &usageTracker{db}
+	batch := tracker.NewBatch(ctx1)
+	batch.Put([]byte{9}, []byte{1, 2, 3, 4})
+	batch.Delete([]byte{0})
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Error on batch commit: %s\n", err.Error())
+	}
+	if err := tracker.DeleteRange(ctx2, []byte{0}, []byte{0xFF}); err != nil {
+		t.Fatalf("Error on delete range: %s\n", err.Error())
+	}
+	report, err = cachedUsage(db)
+	if err != nil {
+		t.Fatalf("Error getting cached usage: %s\n", err.Error())
+	}
+	expected := WriteCount{KeysPut: 1, BytesPut: keySize + 4, KeysDeleted: 1}
+	if inst := report.Instances[1]; inst.Stored.Keys != 4 || inst.Writes != expected {
+		t.Errorf("Bad tracked writes of instance 1: %s\n", inst.Writes)
+	}
+	if inst := report.Instances[1]; string(inst.MaxKey) != string(ctx1.ConstructKey([]byte{9})) {
+		t.Errorf("Expected key extent widened by put, got max %x\n", inst.MaxKey)
+	}
+	if n := report.Instances[2].Writes.RangesDeleted; n != 1 {
+		t.Errorf("Expected 1 range deleted for instance 2, got %d\n", n)
+	}
+	usage.Lock()
+	n := usage.bytes([]dvid.InstanceID{1, 2, 99})
+	usage.Unlock()
+	if want := 4*(keySize+2) + keySize + 4 + keySize + 3; n != want {
+		t.Errorf("Expected %d bytes used by instances, got %d\n", want, n)
+	}
+
+	// The report is saved and reloaded, and a rescan resets the tracked writes.
+	if err := usage.save(db); err != nil {
+		t.Fatalf("Error saving usage: %s\n", err.Error())
+	}
+	usage = usageTally{}
+	report, err = cachedUsage(db)
+	if err != nil {
+		t.Fatalf("Error loading usage: %s\n", err.Error())
+	}
+	if report.Instances[1].Writes != expected {
+		t.Errorf("Bad reloaded writes of instance 1: %s\n", report.Instances[1].Writes)
+	}
+	report, err = computeUsage(db, []OrderedKeyValueDB{db})
+	if err != nil {
+		t.Fatalf("Error computing usage: %s\n", err.Error())
+	}
+	if _, found := report.Instances[2]; found {
+		t.Errorf("Expected no usage of instance 2 after its data was deleted\n")
+	}
+	inst = report.Instances[dvid.InstanceID(1)]
+	if inst.Stored.Keys != 4 || inst.Writes != (WriteCount{}) {
+		t.Errorf("Bad rescanned usage of instance 1: %s, %s\n", inst.Stored, inst.Writes)
+	}
+}
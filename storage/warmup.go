@@ -0,0 +1,173 @@
+/*
+	This file supports warming the block cache after a restart.  The cache counts reads
+	of each instance, version and index range, and the most frequent reads are persisted
+	to the metadata store so they can be replayed when the server starts again.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// The metadata index of the persisted hot reads.
+var hotReadsIndex = []byte{0xA4, 'h', 'o', 't'}
+
+const (
+	// Number of distinct reads tracked and persisted.  Up to twice this number are
+	// tracked before the least frequent are dropped.
+	maxHotReads = 10000
+
+	// How often hot reads are persisted while the server runs.
+	hotReadsSaveInterval = 5 * time.Minute
+)
+
+// HotRead is a frequent read of a data instance, used to warm the cache on restart.
+type HotRead struct {
+	// Range is true for range reads of [Beg, End] and false for reads of a single
+	// key, where Beg and End are the same index.
+	Range bool
+
+	InstanceID dvid.InstanceID
+	VersionID  dvid.VersionID
+	Beg, End   []byte
+
+	Hits uint64
+}
+
+type hotReadSlice []HotRead
+
+func (s hotReadSlice) Len() int           { return len(s) }
+func (s hotReadSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s hotReadSlice) Less(i, j int) bool { return s[i].Hits > s[j].Hits }
+
+// recordRead counts a read with the given cache key.  Caller must hold the lock.
+func (c *blockCache) recordRead(key string, beg, end []byte) {
+	read, found := c.hot[key]
+	if !found {
+		if len(c.hot) >= 2*maxHotReads {
+			c.pruneHotReads(maxHotReads)
+		}
+		// Cache keys start with the op, instance ID and version ID.
+		k := []byte(key)
+		read = &HotRead{
+			Range:      cacheOp(k[0]) == cacheRange,
+			InstanceID: dvid.InstanceIDFromBytes(k[1 : 1+dvid.InstanceIDSize]),
+			VersionID:  dvid.VersionIDFromBytes(k[1+dvid.InstanceIDSize : 1+dvid.InstanceIDSize+dvid.VersionIDSize]),
+			Beg:        append([]byte(nil), beg...),
+			End:        append([]byte(nil), end...),
+		}
+		c.hot[key] = read
+	}
+	read.Hits++
+}
+
+// pruneHotReads keeps only the n most frequent reads.  Caller must hold the lock.
+func (c *blockCache) pruneHotReads(n int) {
+	reads := c.topHotReads(n)
+	if len(reads) < n {
+		return
+	}
+	threshold := reads[n-1].Hits
+	var ties int
+	for _, read := range reads {
+		if read.Hits == threshold {
+			ties++
+		}
+	}
+	for key, read := range c.hot {
+		switch {
+		case read.Hits > threshold:
+		case read.Hits == threshold && ties > 0:
+			ties--
+		default:
+			delete(c.hot, key)
+		}
+	}
+}
+
+// topHotReads returns up to n reads in order of decreasing frequency.  Caller must hold
+// the lock.
+func (c *blockCache) topHotReads(n int) []HotRead {
+	reads := make([]HotRead, 0, len(c.hot))
+	for _, read := range c.hot {
+		reads = append(reads, *read)
+	}
+	sort.Sort(hotReadSlice(reads))
+	if len(reads) > n {
+		reads = reads[:n]
+	}
+	return reads
+}
+
+// HotReads returns up to n of the most frequent reads in order of decreasing frequency.
+func (c *blockCache) HotReads(n int) []HotRead {
+	c.Lock()
+	defer c.Unlock()
+	return c.topHotReads(n)
+}
+
+// seedHotReads adds persisted reads with halved counts so reads that are no longer
+// frequent fade over restarts.
+func (c *blockCache) seedHotReads(reads []HotRead) {
+	c.Lock()
+	defer c.Unlock()
+	for i := range reads {
+		read := reads[i]
+		read.Hits /= 2
+		if read.Hits == 0 {
+			continue
+		}
+		op := cacheGet
+		if read.Range {
+			op = cacheRange
+		}
+		key := makeCacheKey(op, read.InstanceID, read.VersionID, read.Beg, read.End)
+		if existing, found := c.hot[key]; found {
+			existing.Hits += read.Hits
+		} else {
+			c.hot[key] = &read
+		}
+	}
+}
+
+// saveHotReads persists the most frequent reads of a cache to the metadata store.
+func saveHotReads(db MetaDataStorer, c *blockCache) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c.HotReads(maxHotReads)); err != nil {
+		return err
+	}
+	return db.Put(NewMetadataContext(), hotReadsIndex, buf.Bytes())
+}
+
+// loadHotReads returns the persisted reads, which are empty if none were saved.
+func loadHotReads(db MetaDataStorer) ([]HotRead, error) {
+	value, err := db.Get(NewMetadataContext(), hotReadsIndex)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var reads []HotRead
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&reads); err != nil {
+		return nil, err
+	}
+	return reads, nil
+}
+
+// WarmRead repeats a read on the big data store so its result is cached.
+func WarmRead(ctx Context, read HotRead) error {
+	db, err := BigDataStore()
+	if err != nil {
+		return err
+	}
+	if read.Range {
+		_, err = db.GetRange(ctx, read.Beg, read.End)
+	} else {
+		_, err = db.Get(ctx, read.Beg)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHotReads(t *testing.T) {
+	db := newMemoryDB()
+	cache, err := newBlockCache(db, 1000000)
+	if err != nil {
+		t.Fatalf("Can't create block cache: %s\n", err.Error())
+	}
+	ctx := GetTestDataContext(TestUUID1, "grayscale", 1)
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(ctx, []byte{1}); err != nil {
+			t.Fatalf("Error on get: %s\n", err.Error())
+		}
+	}
+	if _, err := cache.GetRange(ctx, []byte{2}, []byte{5}); err != nil {
+		t.Fatalf("Error on get range: %s\n", err.Error())
+	}
+	reads := cache.HotReads(10)
+	if len(reads) != 2 || reads[0].Hits != 3 || reads[0].Range || !reads[1].Range {
+		t.Fatalf("Bad hot reads: %v\n", reads)
+	}
+	if reads[1].InstanceID != 1 || reads[1].VersionID != ctx.VersionID() ||
+		!bytes.Equal(reads[1].Beg, []byte{2}) || !bytes.Equal(reads[1].End, []byte{5}) {
+		t.Errorf("Bad range read: %+v\n", reads[1])
+	}
+
+	// Pruning keeps the most frequent reads even with ties.
+	cache.Lock()
+	for i := 0; i < 10; i++ {
+		cache.recordRead(makeCacheKey(cacheGet, 2, 1, []byte{byte(i)}, []byte{byte(i)}), []byte{byte(i)}, []byte{byte(i)})
+	}
+	cache.pruneHotReads(4)
+	if len(cache.hot) != 4 {
+		t.Errorf("Expected 4 reads after pruning, got %d\n", len(cache.hot))
+	}
+	cache.Unlock()
+	if reads = cache.HotReads(10); reads[0].Hits != 3 || reads[1].Hits != 1 {
+		t.Errorf("Bad hot reads after pruning: %v\n", reads)
+	}
+
+	// Reads persist and seed a new cache with halved counts.
+	if err := saveHotReads(db, cache); err != nil {
+		t.Fatalf("Error saving hot reads: %s\n", err.Error())
+	}
+	loaded, err := loadHotReads(db)
+	if err != nil || len(loaded) != 4 {
+		t.Fatalf("Bad loaded hot reads: %v, %v\n", loaded, err)
+	}
+	cache2, _ := newBlockCache(db, 1000000)
+	cache2.seedHotReads(loaded)
+	if reads = cache2.HotReads(10); len(reads) != 1 || reads[0].Hits != 1 {
+		t.Errorf("Bad seeded hot reads: %v\n", reads)
+	}
+	if _, err := cache2.Get(ctx, []byte{1}); err != nil {
+		t.Fatalf("Error on get: %s\n", err.Error())
+	}
+	if reads = cache2.HotReads(10); reads[0].Hits != 2 {
+		t.Errorf("Seeded read wasn't matched by new read: %v\n", reads)
+	}
+}
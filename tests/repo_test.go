@@ -46,3 +46,57 @@ func TestDataAndChildCreation(t *testing.T) {
 		t.Errorf("Error trying to create child off root %s: %s\n", repo.RootUUID(), err.Error())
 	}
 }
+
+func TestBranchHeads(t *testing.T) {
+	UseStore()
+	defer CloseStore()
+
+	repo, _ := NewRepo()
+	root := repo.RootUUID()
+
+	// Root is open on the default branch.
+	head, err := repo.BranchHead(datastore.DefaultBranch, true)
+	if err != nil || head != root {
+		t.Fatalf("Expected root %s as open head of master, got %s (%v)\n", root, head, err)
+	}
+	if _, err = repo.BranchHead(datastore.DefaultBranch, false); err == nil {
+		t.Errorf("Expected no committed head before locking root\n")
+	}
+
+	if err = repo.Lock(root); err != nil {
+		t.Fatalf("Error locking root: %s\n", err.Error())
+	}
+	child, err := repo.NewVersion(root)
+	if err != nil {
+		t.Fatalf("Error creating child: %s\n", err.Error())
+	}
+	proofread, err := repo.NewBranchVersion(root, "proofread")
+	if err != nil {
+		t.Fatalf("Error creating branch: %s\n", err.Error())
+	}
+	if _, err = repo.NewBranchVersion(root, "proofread"); err == nil {
+		t.Errorf("Expected error on duplicate branch name\n")
+	}
+
+	head, err = repo.BranchHead(datastore.DefaultBranch, false)
+	if err != nil || head != root {
+		t.Errorf("Expected root %s as committed head of master, got %s (%v)\n", root, head, err)
+	}
+	head, err = repo.BranchHead(datastore.DefaultBranch, true)
+	if err != nil || head != child {
+		t.Errorf("Expected child %s as open head of master, got %s (%v)\n", child, head, err)
+	}
+	head, err = repo.BranchHead("proofread", true)
+	if err != nil || head != proofread {
+		t.Errorf("Expected %s as open head of proofread, got %s (%v)\n", proofread, head, err)
+	}
+
+	// Branch references resolve through the datastore.
+	head, _, err = datastore.ResolveVersion(string(child)+":proofread", true)
+	if err != nil || head != proofread {
+		t.Errorf("Expected branch reference to resolve to %s, got %s (%v)\n", proofread, head, err)
+	}
+	if _, _, err = datastore.ResolveVersion(string(root)+":nonexistent", true); err == nil {
+		t.Errorf("Expected error resolving nonexistent branch\n")
+	}
+}